@@ -34,6 +34,8 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/cache"
 	"github.com/argoproj-labs/argocd-agent/internal/config"
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
 	"github.com/argoproj-labs/argocd-agent/internal/informer"
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
@@ -43,18 +45,25 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/manager/gpgkey"
 	"github.com/argoproj-labs/argocd-agent/internal/manager/repository"
 	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/argoproj-labs/argocd-agent/internal/netpol"
+	"github.com/argoproj-labs/argocd-agent/internal/nsmap"
 	"github.com/argoproj-labs/argocd-agent/internal/queue"
 	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
 	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
 	"github.com/argoproj-labs/argocd-agent/internal/version"
 	"github.com/argoproj-labs/argocd-agent/pkg/client"
+	"github.com/argoproj-labs/argocd-agent/pkg/plugin"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
 
 	"github.com/argoproj/argo-cd/v3/common"
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
@@ -76,7 +85,7 @@ type Agent struct {
 	allowedNamespaces []string
 	// infStopCh is not currently used
 	infStopCh chan struct{}
-	connected atomic.Bool
+	connState *connectionState
 	// syncCh is not currently used
 	syncCh           chan bool
 	remote           *client.Remote
@@ -95,12 +104,42 @@ type Agent struct {
 	eventWriter *event.EventWriter
 	version     *version.Version
 	kubeClient  *kube.KubernetesClient
+	// proxyClient is used for requests proxied on behalf of the principal.
+	// It impersonates proxyImpersonation, if configured.
+	proxyClient        *kube.KubernetesClient
+	proxyImpersonation *rest.ImpersonationConfig
+	// appServiceAccountClient is used for resource proxy requests that
+	// modify resources, if the agent applies changes with the service
+	// accounts of Applications. It impersonates the service account carried
+	// in the request's context.
+	appServiceAccountClient *kube.KubernetesClient
+	// appServiceAccounts holds the service accounts of Applications to be
+	// created or deleted. It is nil unless the agent maintains them.
+	appServiceAccounts *appServiceAccountQueue
 
 	// metrics holds agent side metrics
 	metrics *metrics.AgentMetrics
 
+	// eventPool processes incoming events concurrently. It is nil if events
+	// are processed one after the other.
+	eventPool *eventPool
+	// memoryBudget limits the memory used by log data and queued events. It
+	// is nil if there is no limit.
+	memoryBudget *memoryBudget
+	// leader runs the leader election between replicas of the agent. It is
+	// nil if leader election is disabled.
+	leader *leaderElector
+
 	// determines if a resync check is done with the principal when the agent restarts.
 	resyncedOnStart bool
+	// warmCacheLoaded is true if bookmarks were loaded from the warm cache and
+	// have not been used for the resync on start yet
+	warmCacheLoaded bool
+	// resyncPager sends the events of initial syncs in batches
+	resyncPager *resync.Pager
+	// resyncBookmarks records the state of Applications synced with the
+	// principal, for incremental resyncs. It is nil if those are disabled.
+	resyncBookmarks *resync.Bookmarks
 	// resources is a list of all the resources that are currently being managed by the agent
 	resources *resources.Resources
 
@@ -113,11 +152,32 @@ type Agent struct {
 	cacheRefreshInterval time.Duration
 	clusterCache         *appstatecache.Cache
 
-	inflightMu sync.Mutex
-	// inflightLogs blocks starting a duplicate stream for the same request UUID (esp. follow=true).
-	inflightLogs map[string]struct{}
-	// inflightTerminal blocks starting a duplicate web terminal session for the same request UUID.
-	inflightTerminal map[string]struct{}
+	// inflight keeps track of long-running operations such as log streams
+	// and terminal sessions, and blocks starting duplicates of them.
+	inflight *inflight.Registry
+	// logCancels records the log streams the principal asked to cancel
+	logCancels logCancelRequests
+	// logMux holds the log streams multiplexed over the event stream
+	logMux logMux
+	// logStreamLimit limits the log streams open on the connection to the
+	// principal, nil if there is no limit
+	logStreamLimit *logStreamLimiter
+
+	// config is set when the agent reloads parts of its configuration from
+	// a ConfigMap at runtime
+	config *configReloader
+	// remoteConfig holds the configurations pushed by the principal
+	remoteConfig *remoteConfig
+	// logSource provides the container logs requested by the principal
+	logSource LogSource
+	// historicalLogSource provides the logs of pods which are gone
+	historicalLogSource LogSource
+	// egressEndpoints are endpoints the agent connects to, which it does not
+	// know about itself
+	egressEndpoints []netpol.Endpoint
+	// logChunkSize is the size of the chunks log streams are sent in. It
+	// may be changed at runtime; 0 means the default.
+	logChunkSize atomic.Int64
 	// sourceCache is a cache of resources from the source. We use it to revert any changes made to the local resources.
 	sourceCache *cache.SourceCache
 
@@ -128,6 +188,14 @@ type Agent struct {
 	// trackingReader reads and caches the Argo CD resource tracking configuration
 	trackingReader *ResourceTrackingReader
 
+	// plugins are the hooks run for events received from and sent to the
+	// principal
+	plugins plugin.Chain
+	// pluginConns are the connections to plugin processes
+	pluginConns []*grpc.ClientConn
+	// recorder records the events exchanged with the principal, if not nil
+	recorder *eventrecord.Recorder
+
 	// below are loggers to control log levels of different subsystems
 	resourceProxyLogger *logging.CentralizedLogger
 	redisProxyLogger    *logging.CentralizedLogger
@@ -166,12 +234,95 @@ type AgentOptions struct {
 	metricsPort int
 
 	healthzPort int
+	// readinessGracePeriod is how long the agent stays ready after losing
+	// the connection to the principal
+	readinessGracePeriod time.Duration
 
 	// heartbeatInterval is the interval at which the agent sends heartbeat (ping)
 	// events to the principal over the Subscribe stream. This is used to keep
 	// the connection alive through service meshes like Istio that have idle timeouts.
 	// A value of 0 disables heartbeats.
 	heartbeatInterval time.Duration
+
+	// logStreamBackoff configures retries of interrupted log streams
+	logStreamBackoff LogStreamBackoff
+	// inflightOptions configures limits for long-running operations
+	inflightOptions []inflight.Option
+
+	// metricsRegistry is the registry to register metrics with, instead of
+	// the default registry
+	metricsRegistry prometheus.Registerer
+
+	// permissionCheck enables checking the agent's Kubernetes permissions,
+	// on startup and every permissionCheckInterval if it is not 0
+	permissionCheck         bool
+	permissionCheckInterval time.Duration
+	// resourceFilter filters the resource statuses sent to the principal,
+	// if not nil
+	resourceFilter *resourceFilter
+	// statusReporter suppresses status updates that only change timestamps,
+	// if not nil. The status of all Applications is still sent every
+	// statusResyncInterval, if it is not 0.
+	statusReporter       *statusReporter
+	statusResyncInterval time.Duration
+	// stateChecksumInterval is the interval in which the checksums of the
+	// state of all Applications are sent to the principal. 0 disables it.
+	stateChecksumInterval time.Duration
+	// slowEventHandlerThreshold is the time after which the handler of an
+	// incoming event is logged as slow. 0 disables it.
+	slowEventHandlerThreshold time.Duration
+	// eventWorkers is the number of workers processing incoming events
+	eventWorkers int
+	// memoryBudget is the number of bytes log data and queued events may
+	// use. 0 means no limit.
+	memoryBudget int64
+	// leaderElection configures leader election between replicas, if not
+	// nil
+	leaderElection *LeaderElection
+	// selfUpdate lets the principal update the agent's Deployment, if not
+	// nil
+	selfUpdate *SelfUpdate
+	// imagePolicy verifies the images of manifests before they are applied,
+	// if not nil
+	imagePolicy *ImagePolicy
+	// specPolicy evaluates the specs of incoming Applications before they
+	// are created or updated, if not nil
+	specPolicy *SpecPolicy
+	// appServiceAccounts makes the agent maintain a service account for
+	// every Application and apply changes with it, if not nil
+	appServiceAccounts *AppServiceAccounts
+	// conflictStrategy decides whose spec prevails when an Application of
+	// the principal was also modified on the agent
+	conflictStrategy manager.ConflictStrategy
+	// serverSideApply makes the agent update Applications with server-side
+	// apply, so that it only owns the fields it synchronizes
+	serverSideApply bool
+	// logStreamMultiplexing makes the agent send log streams over its event
+	// stream instead of opening a LogStream RPC for each
+	logStreamMultiplexing bool
+	// logStreamConcurrency is the number of log streams that may be open on
+	// the connection to the principal at the same time, 0 for no limit.
+	// Log streams wait up to logStreamQueueTimeout for a free stream.
+	logStreamConcurrency  int
+	logStreamQueueTimeout time.Duration
+	// resyncBatchSize is the number of resources sent per batch during an
+	// initial sync with the principal
+	resyncBatchSize int
+	// resyncBookmarkMaxAge is how long bookmarks are used for incremental
+	// resyncs requested by the principal. Zero disables incremental resyncs.
+	resyncBookmarkMaxAge time.Duration
+	// warmCachePath is the file the agent persists bookmarks of synced
+	// Applications to. Empty disables the warm cache.
+	warmCachePath string
+	// logAccessRules restrict the pods whose logs the principal can read,
+	// if not nil
+	logAccessRules *logAccessRules
+	// namespaceMapper translates namespaces between the principal and the
+	// agent's cluster, if not nil
+	namespaceMapper *nsmap.Mapper
+	// clusterResourceRules are the cluster-scoped resources the principal
+	// may read through the resource proxy
+	clusterResourceRules clusterResourceRules
 }
 
 // AgentOption is a functional option type used to configure an Agent instance during initialization.
@@ -182,11 +333,10 @@ type AgentOption func(*Agent) error
 // options.
 func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace string, opts ...AgentOption) (*Agent, error) {
 	a := &Agent{
-		version:          version.New("argocd-agent"),
-		deletions:        manager.NewDeletionTracker(),
-		sourceCache:      cache.NewSourceCache(),
-		inflightLogs:     make(map[string]struct{}),
-		inflightTerminal: make(map[string]struct{}),
+		version:     version.New("argocd-agent"),
+		deletions:   manager.NewDeletionTracker(),
+		sourceCache: cache.NewSourceCache(),
+		connState:   newConnectionState(),
 	}
 	a.infStopCh = make(chan struct{})
 	a.namespace = namespace
@@ -194,6 +344,12 @@ func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace stri
 	a.redisProxyMsgHandler = &redisProxyMsgHandler{}
 	// Resource proxy is enabled by default.
 	a.enableResourceProxy = true
+	a.options.slowEventHandlerThreshold = defaultSlowEventHandlerThreshold
+	a.options.eventWorkers = defaultEventWorkers
+	a.options.readinessGracePeriod = defaultReadinessGracePeriod
+	a.options.conflictStrategy = manager.ConflictHubWins
+	a.options.resyncBatchSize = resync.DefaultBatchSize
+	a.options.resyncBookmarkMaxAge = resync.DefaultBookmarkMaxAge
 
 	for _, o := range opts {
 		err := o(a)
@@ -202,6 +358,13 @@ func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace stri
 		}
 	}
 
+	// Plugins compiled into the binary run before the configured ones
+	a.plugins = append(plugin.Chain(plugin.Registered()), a.plugins...)
+
+	a.inflight = inflight.NewRegistry(a.options.inflightOptions...)
+	a.remoteConfig = newRemoteConfig(a.inflight)
+	a.logStreamLimit = newLogStreamLimiter(a.options.logStreamConcurrency, a.options.logStreamQueueTimeout)
+
 	if a.resourceProxyLogger == nil {
 		a.resourceProxyLogger = logging.GetDefaultLogger()
 	}
@@ -226,10 +389,39 @@ func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace stri
 		return nil, fmt.Errorf("cache refresh interval not set")
 	}
 
+	// A client configured with WithKubeClient takes precedence
+	if a.kubeClient != nil {
+		client = a.kubeClient
+		client.Context = ctx
+	}
+	if client == nil {
+		return nil, fmt.Errorf("no Kubernetes client configured")
+	}
 	a.kubeClient = client
+	a.proxyClient = client
+	if a.proxyImpersonation != nil {
+		pc, err := newProxyClient(client, *a.proxyImpersonation)
+		if err != nil {
+			return nil, fmt.Errorf("could not create client for proxied requests: %w", err)
+		}
+		a.proxyClient = pc
+	}
+	if a.options.appServiceAccounts != nil {
+		sc, err := newAppServiceAccountClient(client)
+		if err != nil {
+			return nil, fmt.Errorf("could not create client for application service accounts: %w", err)
+		}
+		a.appServiceAccountClient = sc
+	}
+	if a.logSource == nil {
+		a.logSource = NewKubernetesLogSource(a.proxyClient.Clientset)
+	}
+	if a.historicalLogSource != nil {
+		a.logSource = &fallbackLogSource{primary: a.logSource, historical: a.historicalLogSource}
+	}
 
 	// Initial state of the agent is disconnected
-	a.connected.Store(false)
+	a.connState.set(false)
 
 	// We have one queue in the agent, named default
 	a.queues = queue.NewSendRecvQueues()
@@ -290,9 +482,31 @@ func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace stri
 		application.WithDestinationBasedMapping(a.destinationBasedMapping),
 	}
 
-	if a.options.metricsPort > 0 {
-		a.metrics = metrics.NewAgentMetrics()
-		metrics.RegisterK8sClientMetrics()
+	if a.options.metricsPort > 0 || a.options.metricsRegistry != nil {
+		reg := a.options.metricsRegistry
+		if reg == nil {
+			reg = prometheus.DefaultRegisterer
+			metrics.RegisterK8sClientMetrics()
+		}
+		a.metrics = metrics.NewAgentMetricsWith(reg)
+		if err := reg.Register(queue.NewCollector(a.queues, "agent")); err != nil {
+			log().WithError(err).Warn("Could not register queue metrics")
+		}
+	}
+
+	if a.options.memoryBudget > 0 {
+		a.memoryBudget = newMemoryBudget(a.options.memoryBudget,
+			func() int64 { return a.queues.SendBytes(defaultQueueName) },
+			func() int { return a.queues.CoalesceSend(defaultQueueName) },
+			a.metrics)
+	}
+
+	if a.options.leaderElection != nil {
+		leader, err := newLeaderElector(a, *a.options.leaderElection)
+		if err != nil {
+			return nil, err
+		}
+		a.leader = leader
 	}
 
 	appInformer, err := informer.NewInformer(ctx, appInformerOptions...)
@@ -304,6 +518,9 @@ func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace stri
 	allowUpsert := a.mode == types.AgentModeManaged
 
 	appManagerOpts = append(appManagerOpts, application.WithAllowUpsert(allowUpsert))
+	if a.options.serverSideApply {
+		appManagerOpts = append(appManagerOpts, application.WithFieldManager(application.FieldManagerAgent))
+	}
 
 	projListFunc := func(ctx context.Context, opts v1.ListOptions) (runtime.Object, error) {
 		return client.ApplicationsClientset.ArgoprojV1alpha1().AppProjects(a.namespace).List(ctx, config.LabelSelector(a.labelSelector))
@@ -467,10 +684,47 @@ func NewAgent(ctx context.Context, client *kube.KubernetesClient, namespace stri
 }
 
 func (a *Agent) Start(ctx context.Context) error {
+	if a.leader == nil {
+		if a.metrics != nil {
+			a.metrics.Leader.Set(1)
+		}
+		return a.start(ctx)
+	}
+	// Standby replicas serve metrics and health checks, too
+	a.startMetricsServer()
+	a.startHealthzServer()
+	log().Infof("Waiting for leadership of lease %s as %s", a.leader.config.LeaseName, a.leader.config.Identity)
+	a.leader.run(ctx)
+	return nil
+}
+
+// start starts the agent's informers and its connection to the principal
+func (a *Agent) start(ctx context.Context) error {
 	infCtx, cancelFn := context.WithCancel(ctx)
 	log().Infof("Starting %s (agent) v%s (ns=%s, allowed_namespaces=%v, mode=%s, auth=%s)", a.version.Name(), a.version.Version(), a.namespace, a.options.namespaces, a.mode, a.remote.AuthMethod())
 	a.context = infCtx
 	a.cancelFn = cancelFn
+	a.resyncPager = resync.NewPager(a.context, a.options.resyncBatchSize, a.reportResyncProgress)
+	if a.options.resyncBookmarkMaxAge > 0 {
+		a.resyncBookmarks = resync.NewBookmarks(a.options.resyncBookmarkMaxAge)
+		a.loadWarmCache()
+		if a.options.warmCachePath != "" {
+			go a.runWarmCachePersistence(a.context)
+		}
+	}
+
+	if a.options.eventWorkers > 1 {
+		a.eventPool = newEventPool(a.options.eventWorkers)
+		a.eventPool.start(a.context, a.options.eventWorkers)
+	}
+
+	if a.memoryBudget != nil {
+		go a.memoryBudget.run(a.context)
+	}
+
+	if a.appServiceAccounts != nil {
+		go a.runAppServiceAccounts(a.context)
+	}
 
 	if a.destinationBasedMapping {
 		log().Info("Destination-based mapping is enabled")
@@ -485,8 +739,8 @@ func (a *Agent) Start(ctx context.Context) error {
 		}
 	}
 
-	if a.options.metricsPort > 0 {
-		metrics.StartMetricsServer(metrics.WithListener("", a.options.metricsPort))
+	if a.leader == nil {
+		a.startMetricsServer()
 	}
 
 	a.emitter = event.NewEventSource(fmt.Sprintf("agent://%s", "agent-managed"))
@@ -567,14 +821,27 @@ func (a *Agent) Start(ctx context.Context) error {
 	}
 	log().Infof("GPG key informer synced and ready")
 
-	if a.options.healthzPort > 0 {
-		// Endpoint to check if the agent is up and running
-		http.HandleFunc("/healthz", a.healthzHandler)
-		healthzAddr := fmt.Sprintf(":%d", a.options.healthzPort)
+	if a.config != nil {
+		if err = a.startConfigReload(a.context); err != nil {
+			return fmt.Errorf("unable to watch agent configuration: %w", err)
+		}
+		log().Infof("Reloading configuration from ConfigMap %s", a.config.configMap)
+	}
 
-		log().Infof("Starting healthz server on %s", healthzAddr)
-		//nolint:errcheck
-		go http.ListenAndServe(healthzAddr, nil)
+	if a.leader == nil {
+		a.startHealthzServer()
+	}
+
+	if a.options.permissionCheck {
+		go a.runPermissionCheck(a.context)
+	}
+
+	if a.options.statusReporter != nil && a.options.statusResyncInterval > 0 {
+		go a.runStatusResync(a.context)
+	}
+
+	if a.options.stateChecksumInterval > 0 {
+		go a.runStateChecksum(a.context)
 	}
 
 	// Start the background process of periodic sync of cluster cache info.
@@ -594,6 +861,8 @@ func (a *Agent) Start(ctx context.Context) error {
 		}()
 	}
 
+	a.logEgressEndpoints()
+
 	if a.remote != nil {
 		a.remote.SetClientMode(a.mode)
 		// TODO: Right now, maintainConnection always returns nil. Revisit
@@ -604,13 +873,43 @@ func (a *Agent) Start(ctx context.Context) error {
 	return nil
 }
 
+// startMetricsServer starts the metrics server, if a port is configured
+func (a *Agent) startMetricsServer() {
+	if a.options.metricsPort > 0 {
+		metrics.StartMetricsServer(metrics.WithListener("", a.options.metricsPort))
+	}
+}
+
+// startHealthzServer starts the server for health checks and debug
+// endpoints, if a port is configured
+func (a *Agent) startHealthzServer() {
+	if a.options.healthzPort > 0 {
+		// Endpoint to check if the agent is up and running
+		http.HandleFunc("/healthz", a.healthzHandler)
+		// Endpoint to check if the agent's dependencies are available
+		http.HandleFunc("/readyz", a.readyzHandler)
+		http.HandleFunc("GET /debug/inflight", a.inflightListHandler)
+		http.HandleFunc("DELETE /debug/inflight/{kind}/{id}", a.inflightCancelHandler)
+		http.HandleFunc("GET /debug/egress", a.egressHandler)
+		http.HandleFunc("GET /debug/leader", a.leaderHandler)
+		healthzAddr := fmt.Sprintf(":%d", a.options.healthzPort)
+
+		log().Infof("Starting healthz server on %s", healthzAddr)
+		//nolint:errcheck
+		go http.ListenAndServe(healthzAddr, nil)
+	}
+}
+
 func (a *Agent) Stop() error {
 	log().Infof("Stopping agent")
+	a.ReleaseLeadership()
 	tckr := time.NewTicker(2 * time.Second)
 	if a.context == nil || a.cancelFn == nil {
 		return fmt.Errorf("could not stop agent: agent has not started")
 	}
 	a.cancelFn()
+	a.closePlugins()
+	a.saveWarmCache()
 	stopping := true
 	for stopping {
 		select {
@@ -629,12 +928,13 @@ func (a *Agent) Stop() error {
 
 // IsConnected returns whether the agent is connected to the principal
 func (a *Agent) IsConnected() bool {
-	return a.remote != nil && a.connected.Load()
+	return a.remote != nil && a.connState.get()
 }
 
-// SetConnected sets the connection state of the agent
+// SetConnected sets the connection state of the agent and notifies anyone
+// watching it.
 func (a *Agent) SetConnected(connected bool) {
-	a.connected.Store(connected)
+	a.connState.set(connected)
 }
 
 func log() *logrus.Entry {
@@ -655,7 +955,8 @@ func (a *Agent) logGrpcEvent() *logrus.Entry {
 
 func (a *Agent) healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
-	if a.IsConnected() {
+	// Standby replicas are healthy without a connection to the principal
+	if a.IsConnected() || a.standby() {
 		w.WriteHeader(http.StatusOK)
 	} else {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -21,12 +21,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
 
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/pkg/client"
 	fakekube "github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,6 +50,30 @@ func Test_NewAgent(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func Test_NewAgentEmbedded(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	agent, err := NewAgent(context.TODO(), nil, "agent",
+		WithRemote(&client.Remote{}),
+		WithCacheRefreshInterval(10*time.Second),
+		WithKubeClient(&rest.Config{Host: "https://127.0.0.1:6443"}),
+		WithMetricsRegistry(reg),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:6443", agent.kubeClient.RestConfig.Host)
+	require.NotNil(t, agent.metrics)
+	agent.metrics.EventSent.Inc()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+	assert.Contains(t, names, "agent_events_sent")
+
+	_, err = NewAgent(context.TODO(), nil, "agent", WithRemote(&client.Remote{}), WithCacheRefreshInterval(10*time.Second))
+	assert.ErrorContains(t, err, "no Kubernetes client")
+}
+
 // func Test_AgentNewAppFromInformer(t *testing.T) {
 // 	agent := newAgent(t)
 // 	require.NotNil(t, agent)
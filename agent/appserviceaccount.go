@@ -0,0 +1,410 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// AppServiceAccounts configures the service accounts the agent maintains for
+// Applications. The agent creates a service account for every Application
+// in the Application's destination namespace, and binds the ClusterRole to
+// it in that namespace only. Changes to resources made through the resource
+// proxy are applied with the service account of the Application the
+// resource belongs to, so that they cannot affect other namespaces. Syncs
+// of Argo CD are not affected, they are applied with the identity of the
+// application controller.
+type AppServiceAccounts struct {
+	// ClusterRole is bound to the service accounts in the destination
+	// namespaces of their Applications, e.g. edit
+	ClusterRole string
+	// Prefix is prepended to the Application's name to form the name of its
+	// service account
+	Prefix string
+}
+
+// DefaultAppServiceAccountPrefix is the prefix of the names of Application
+// service accounts, unless configured otherwise.
+const DefaultAppServiceAccountPrefix = "argocd-agent-app-"
+
+const (
+	// appServiceAccountLabel marks service accounts and role bindings
+	// maintained by the agent, and holds the name of their Application
+	appServiceAccountLabel = "argocd-agent.argoproj.io/application"
+	// appInstanceLabel is the label Argo CD tracks resources with by default
+	appInstanceLabel = "app.kubernetes.io/instance"
+	// appServiceAccountTimeout is the time maintaining the service account
+	// of an Application may take
+	appServiceAccountTimeout = 10 * time.Second
+)
+
+// WithAppServiceAccounts makes the agent maintain a namespace-scoped service
+// account for every Application, and apply changes to the Application's
+// resources made through the resource proxy with it. The agent's service
+// account needs permission to manage service accounts and role bindings, to
+// bind the ClusterRole and to impersonate service accounts.
+func WithAppServiceAccounts(cfg AppServiceAccounts) AgentOption {
+	return func(a *Agent) error {
+		if cfg.ClusterRole == "" {
+			return errors.New("application service accounts need a cluster role")
+		}
+		if cfg.Prefix == "" {
+			cfg.Prefix = DefaultAppServiceAccountPrefix
+		}
+		a.options.appServiceAccounts = &cfg
+		a.appServiceAccounts = newAppServiceAccountQueue()
+		return nil
+	}
+}
+
+// appServiceAccountItem is the service account of an Application in a
+// destination namespace, which is created or deleted depending on the
+// Application's current destination
+type appServiceAccountItem struct {
+	appNamespace string
+	appName      string
+	namespace    string
+}
+
+func (i appServiceAccountItem) app() string {
+	return i.appNamespace + "/" + i.appName
+}
+
+// appServiceAccountQueue holds the service accounts of Applications to be
+// created or deleted. Failed items are retried with backoff, e.g. while the
+// destination namespace doesn't exist yet.
+type appServiceAccountQueue struct {
+	queue workqueue.TypedRateLimitingInterface[appServiceAccountItem]
+
+	mu sync.Mutex
+	// destinations holds the destination namespace of each Application
+	destinations map[string]string
+	// ready holds the service accounts that were put in place
+	ready map[appServiceAccountItem]bool
+}
+
+func newAppServiceAccountQueue() *appServiceAccountQueue {
+	return &appServiceAccountQueue{
+		queue:        workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[appServiceAccountItem]()),
+		destinations: make(map[string]string),
+		ready:        make(map[appServiceAccountItem]bool),
+	}
+}
+
+// set records namespace as the destination namespace of app, and queues the
+// service accounts that need to be created or deleted. If recheck is true,
+// the service account is queued even if it was put in place before, so that
+// it is recreated if it was deleted meanwhile.
+func (q *appServiceAccountQueue) set(app *v1alpha1.Application, namespace string, recheck bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := appServiceAccountItem{appNamespace: app.Namespace, appName: app.Name, namespace: namespace}
+	old, ok := q.destinations[item.app()]
+	if ok && old != namespace {
+		q.queue.Add(appServiceAccountItem{appNamespace: app.Namespace, appName: app.Name, namespace: old})
+	}
+	if namespace == "" {
+		delete(q.destinations, item.app())
+		return
+	}
+	q.destinations[item.app()] = namespace
+	if recheck || old != namespace || !q.ready[item] {
+		q.queue.Add(item)
+	}
+}
+
+// wanted returns whether the service account of item should exist
+func (q *appServiceAccountQueue) wanted(item appServiceAccountItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.destinations[item.app()] == item.namespace
+}
+
+func (q *appServiceAccountQueue) setReady(item appServiceAccountItem, ready bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if ready {
+		q.ready[item] = true
+	} else {
+		delete(q.ready, item)
+	}
+}
+
+// runAppServiceAccounts creates and deletes the service accounts of
+// Applications queued by the informer's handlers until ctx is done.
+func (a *Agent) runAppServiceAccounts(ctx context.Context) {
+	q := a.appServiceAccounts.queue
+	go func() {
+		<-ctx.Done()
+		q.ShutDown()
+	}()
+	for a.processAppServiceAccount() {
+	}
+}
+
+// processAppServiceAccount reconciles the next queued service account and
+// returns false once the queue was shut down.
+func (a *Agent) processAppServiceAccount() bool {
+	q := a.appServiceAccounts.queue
+	item, shutdown := q.Get()
+	if shutdown {
+		return false
+	}
+	defer q.Done(item)
+	logCtx := log().WithField("app", item.app())
+	wanted := a.appServiceAccounts.wanted(item)
+	var err error
+	if wanted {
+		err = a.createAppServiceAccount(item)
+	} else {
+		err = a.deleteAppServiceAccount(item)
+	}
+	if err != nil {
+		logCtx.WithError(err).Errorf("Could not reconcile service account of application in namespace %s, retrying", item.namespace)
+		q.AddRateLimited(item)
+		return true
+	}
+	q.Forget(item)
+	a.appServiceAccounts.setReady(item, wanted)
+	return true
+}
+
+// appServiceAccountName returns the name of the service account of the
+// Application named appName
+func (c *AppServiceAccounts) appServiceAccountName(appName string) (string, error) {
+	name := c.Prefix + appName
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid service account name %s: %s", name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// ensureAppServiceAccount queues the creation of the service account of app
+// and its role binding in app's destination namespace.
+func (a *Agent) ensureAppServiceAccount(app *v1alpha1.Application) {
+	if a.appServiceAccounts == nil {
+		return
+	}
+	a.appServiceAccounts.set(app, app.Spec.Destination.Namespace, false)
+}
+
+// removeAppServiceAccount queues the deletion of the service account of app
+// and its role binding.
+func (a *Agent) removeAppServiceAccount(app *v1alpha1.Application) {
+	if a.appServiceAccounts == nil {
+		return
+	}
+	a.appServiceAccounts.set(app, "", false)
+}
+
+// updateAppServiceAccount queues the service account of an updated
+// Application, which moves if the destination namespace changed. On resyncs
+// of the informer, the service account is checked again.
+func (a *Agent) updateAppServiceAccount(old, new *v1alpha1.Application) {
+	if a.appServiceAccounts == nil {
+		return
+	}
+	a.appServiceAccounts.set(new, new.Spec.Destination.Namespace, old.ResourceVersion == new.ResourceVersion)
+}
+
+// createAppServiceAccount creates the service account of item and its role
+// binding, if they don't exist yet.
+func (a *Agent) createAppServiceAccount(item appServiceAccountItem) error {
+	cfg := a.options.appServiceAccounts
+	namespace := item.namespace
+	name, err := cfg.appServiceAccountName(item.appName)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(a.pluginContext(), appServiceAccountTimeout)
+	defer cancel()
+	labels := map[string]string{appServiceAccountLabel: item.appName}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+	_, err = a.kubeClient.Clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("could not create service account %s/%s: %w", namespace, name, err)
+	}
+
+	bindings := a.kubeClient.Clientset.RbacV1().RoleBindings(namespace)
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: cfg.ClusterRole},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}},
+	}
+	existing, err := bindings.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = bindings.Create(ctx, rb, metav1.CreateOptions{})
+	case err == nil && existing.RoleRef != rb.RoleRef:
+		// The role of a binding cannot be changed, so the binding is
+		// replaced if the configured cluster role changed
+		if err = bindings.Delete(ctx, name, metav1.DeleteOptions{}); err == nil {
+			_, err = bindings.Create(ctx, rb, metav1.CreateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not bind cluster role %s to service account %s/%s: %w", cfg.ClusterRole, namespace, name, err)
+	}
+	log().WithField("app", item.app()).Debugf("Service account %s/%s of application is in place", namespace, name)
+	return nil
+}
+
+// deleteAppServiceAccount deletes the service account of item and its role
+// binding.
+func (a *Agent) deleteAppServiceAccount(item appServiceAccountItem) error {
+	namespace := item.namespace
+	name, err := a.options.appServiceAccounts.appServiceAccountName(item.appName)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(a.pluginContext(), appServiceAccountTimeout)
+	defer cancel()
+	err = a.kubeClient.Clientset.RbacV1().RoleBindings(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete role binding %s/%s: %w", namespace, name, err)
+	}
+	err = a.kubeClient.Clientset.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete service account %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// trackedAppName returns the name of the Application obj is tracked by, as
+// recorded by Argo CD in the tracking annotation or the instance label.
+func trackedAppName(obj metav1.Object) string {
+	app := ""
+	if id, ok := obj.GetAnnotations()[trackingAnnotationKey]; ok {
+		app, _, _ = strings.Cut(id, ":")
+	} else {
+		app = obj.GetLabels()[appInstanceLabel]
+	}
+	// Applications outside of Argo CD's namespace are tracked as
+	// <namespace>_<name>
+	if _, name, ok := strings.Cut(app, "_"); ok {
+		return name
+	}
+	return app
+}
+
+// appServiceAccountContext returns the context for a resource proxy request
+// modifying a resource, which carries the service account to impersonate.
+// The service account is that of the Application the resource belongs to,
+// in the resource's namespace. Requests for resources that belong to no
+// Application, or that are cluster scoped, are refused.
+func (a *Agent) appServiceAccountContext(ctx context.Context, rreq *event.ResourceRequest, gvr schema.GroupVersionResource) (context.Context, error) {
+	cfg := a.options.appServiceAccounts
+	if cfg == nil {
+		return ctx, nil
+	}
+	forbidden := func(reason string) error {
+		return apierrors.NewForbidden(gvr.GroupResource(), rreq.Name, errors.New(reason))
+	}
+	if rreq.Namespace == "" {
+		return nil, forbidden("cluster scoped resources cannot be modified with the service account of an application")
+	}
+
+	var obj metav1.Object
+	if rreq.Method == http.MethodPost && rreq.Subresource == "" {
+		u := &unstructured.Unstructured{}
+		if err := json.Unmarshal(rreq.Body, u); err != nil {
+			return nil, err
+		}
+		obj = u
+	} else {
+		existing, err := a.kubeClient.DynamicClient.Resource(gvr).Namespace(rreq.Namespace).Get(ctx, rreq.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		obj = existing
+	}
+	appName := trackedAppName(obj)
+	if appName == "" {
+		return nil, forbidden("resource does not belong to an application")
+	}
+	name, err := cfg.appServiceAccountName(appName)
+	if err != nil {
+		return nil, forbidden(err.Error())
+	}
+	return context.WithValue(ctx, appServiceAccountKey{}, "system:serviceaccount:"+rreq.Namespace+":"+name), nil
+}
+
+// applyKubeClient returns the client to use for resource proxy requests
+// that modify resources
+func (a *Agent) applyKubeClient() *kube.KubernetesClient {
+	if a.appServiceAccountClient != nil {
+		return a.appServiceAccountClient
+	}
+	return a.proxyKubeClient()
+}
+
+type appServiceAccountKey struct{}
+
+// newAppServiceAccountClient returns a copy of client which impersonates the
+// service account carried in the context of each request
+func newAppServiceAccountClient(client *kube.KubernetesClient) (*kube.KubernetesClient, error) {
+	if client.RestConfig == nil {
+		return nil, fmt.Errorf("impersonation requires a REST config for the Kubernetes client")
+	}
+	config := rest.CopyConfig(client.RestConfig)
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &appServiceAccountRoundTripper{delegate: &onBehalfOfRoundTripper{delegate: rt}}
+	})
+	return kube.NewKubernetesClientForConfig(client.Context, config, client.Namespace)
+}
+
+// appServiceAccountRoundTripper impersonates the service account carried in
+// the request's context. Requests without service account are refused, so
+// that they are never made with the agent's own identity.
+type appServiceAccountRoundTripper struct {
+	delegate http.RoundTripper
+}
+
+func (rt *appServiceAccountRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	user, ok := req.Context().Value(appServiceAccountKey{}).(string)
+	if !ok {
+		return nil, errors.New("no application service account to impersonate")
+	}
+	req = utilnet.CloneRequest(req)
+	req.Header.Set(transport.ImpersonateUserHeader, user)
+	return rt.delegate.RoundTrip(req)
+}
+
+func (rt *appServiceAccountRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.delegate
+}
@@ -0,0 +1,234 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	fakekube "github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+func Test_AppServiceAccountClient(t *testing.T) {
+	var mu sync.Mutex
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = r.Header.Clone()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"guestbook-ui","namespace":"guestbook"}}`))
+	}))
+	defer srv.Close()
+
+	client, err := kube.NewKubernetesClientForConfig(context.Background(), &rest.Config{Host: srv.URL}, "argocd")
+	require.NoError(t, err)
+	sc, err := newAppServiceAccountClient(client)
+	require.NoError(t, err)
+
+	t.Run("Requests impersonate the service account in the context", func(t *testing.T) {
+		ctx := context.WithValue(withOnBehalfOf(context.Background(), "alice"), appServiceAccountKey{}, "system:serviceaccount:guestbook:argocd-agent-app-guestbook")
+		_, err := sc.Clientset.CoreV1().Pods("guestbook").Get(ctx, "guestbook-ui", v1.GetOptions{})
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "system:serviceaccount:guestbook:argocd-agent-app-guestbook", headers.Get("Impersonate-User"))
+		assert.Equal(t, "alice", headers.Get("Impersonate-Extra-argocd-agent.argoproj.io%2Fon-behalf-of"))
+	})
+
+	t.Run("Requests without service account are refused", func(t *testing.T) {
+		_, err := sc.Clientset.CoreV1().Pods("guestbook").Get(context.Background(), "guestbook-ui", v1.GetOptions{})
+		assert.ErrorContains(t, err, "no application service account")
+	})
+
+	t.Run("Cluster role must not be empty", func(t *testing.T) {
+		assert.Error(t, WithAppServiceAccounts(AppServiceAccounts{})(&Agent{}))
+	})
+}
+
+// processAppServiceAccounts reconciles all service accounts queued so far
+func processAppServiceAccounts(a *Agent) {
+	for a.appServiceAccounts.queue.Len() > 0 {
+		a.processAppServiceAccount()
+	}
+}
+
+func Test_AppServiceAccountLifecycle(t *testing.T) {
+	app := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{Name: "guestbook", Namespace: "argocd", ResourceVersion: "1"},
+		Spec:       v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "guestbook"}},
+	}
+	a := &Agent{kubeClient: fakekube.NewKubernetesFakeClientWithResources()}
+	require.NoError(t, WithAppServiceAccounts(AppServiceAccounts{ClusterRole: "edit"})(a))
+	cs := a.kubeClient.Clientset
+	ctx := context.Background()
+
+	t.Run("Service account and role binding are created", func(t *testing.T) {
+		a.ensureAppServiceAccount(app)
+		processAppServiceAccounts(a)
+		_, err := cs.CoreV1().ServiceAccounts("guestbook").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		require.NoError(t, err)
+		rb, err := cs.RbacV1().RoleBindings("guestbook").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "edit", rb.RoleRef.Name)
+		require.Len(t, rb.Subjects, 1)
+		assert.Equal(t, "argocd-agent-app-guestbook", rb.Subjects[0].Name)
+		assert.Equal(t, "guestbook", rb.Subjects[0].Namespace)
+	})
+
+	t.Run("Updates don't recheck service accounts in place", func(t *testing.T) {
+		updated := app.DeepCopy()
+		updated.ResourceVersion = "2"
+		a.updateAppServiceAccount(app, updated)
+		assert.Equal(t, 0, a.appServiceAccounts.queue.Len())
+	})
+
+	t.Run("Resync recreates a deleted service account and replaces a changed role binding", func(t *testing.T) {
+		require.NoError(t, cs.CoreV1().ServiceAccounts("guestbook").Delete(ctx, "argocd-agent-app-guestbook", v1.DeleteOptions{}))
+		a.options.appServiceAccounts.ClusterRole = "view"
+		a.updateAppServiceAccount(app, app)
+		processAppServiceAccounts(a)
+		_, err := cs.CoreV1().ServiceAccounts("guestbook").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		require.NoError(t, err)
+		rb, err := cs.RbacV1().RoleBindings("guestbook").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "view", rb.RoleRef.Name)
+	})
+
+	t.Run("Service account moves with the destination namespace", func(t *testing.T) {
+		moved := app.DeepCopy()
+		moved.ResourceVersion = "3"
+		moved.Spec.Destination.Namespace = "guestbook-prod"
+		a.updateAppServiceAccount(app, moved)
+		processAppServiceAccounts(a)
+		_, err := cs.CoreV1().ServiceAccounts("guestbook").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+		_, err = cs.CoreV1().ServiceAccounts("guestbook-prod").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		assert.NoError(t, err)
+		app = moved
+	})
+
+	t.Run("Service account and role binding are removed", func(t *testing.T) {
+		a.removeAppServiceAccount(app)
+		processAppServiceAccounts(a)
+		_, err := cs.CoreV1().ServiceAccounts("guestbook-prod").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+		_, err = cs.RbacV1().RoleBindings("guestbook-prod").Get(ctx, "argocd-agent-app-guestbook", v1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("Creation is retried until the namespace exists", func(t *testing.T) {
+		fakeCS, ok := cs.(*kubefake.Clientset)
+		require.True(t, ok)
+		failures := 2
+		fakeCS.PrependReactor("create", "serviceaccounts", func(action kubetesting.Action) (bool, runtime.Object, error) {
+			if failures == 0 {
+				return false, nil, nil
+			}
+			failures--
+			return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "guestbook-new")
+		})
+		created := app.DeepCopy()
+		created.Name = "new"
+		created.Spec.Destination.Namespace = "guestbook-new"
+		a.ensureAppServiceAccount(created)
+		for range 3 {
+			// Blocks until the failed item is retried
+			require.True(t, a.processAppServiceAccount())
+		}
+		_, err := cs.CoreV1().ServiceAccounts("guestbook-new").Get(ctx, "argocd-agent-app-new", v1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, failures)
+	})
+}
+
+func Test_AppServiceAccountContext(t *testing.T) {
+	existing := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "guestbook-config",
+			Namespace:   "guestbook",
+			Annotations: map[string]string{trackingAnnotationKey: "apps_guestbook:/ConfigMap:guestbook/guestbook-config"},
+		},
+	}
+	untracked := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "untracked", Namespace: "guestbook"}}
+	a := &Agent{kubeClient: fakekube.NewKubernetesFakeClientWithResources(existing, untracked)}
+	require.NoError(t, WithAppServiceAccounts(AppServiceAccounts{ClusterRole: "edit"})(a))
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	user := func(t *testing.T, rreq *event.ResourceRequest) (string, error) {
+		t.Helper()
+		ctx, err := a.appServiceAccountContext(context.Background(), rreq, gvr)
+		if err != nil {
+			return "", err
+		}
+		u, _ := ctx.Value(appServiceAccountKey{}).(string)
+		return u, nil
+	}
+
+	t.Run("Application is taken from the existing resource", func(t *testing.T) {
+		u, err := user(t, &event.ResourceRequest{Method: http.MethodDelete, Name: "guestbook-config", Namespace: "guestbook"})
+		require.NoError(t, err)
+		assert.Equal(t, "system:serviceaccount:guestbook:argocd-agent-app-guestbook", u)
+	})
+
+	t.Run("Application is taken from the body of new resources", func(t *testing.T) {
+		body := []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"new","labels":{"app.kubernetes.io/instance":"guestbook"}}}`)
+		u, err := user(t, &event.ResourceRequest{Method: http.MethodPost, Namespace: "guestbook", Body: body})
+		require.NoError(t, err)
+		assert.Equal(t, "system:serviceaccount:guestbook:argocd-agent-app-guestbook", u)
+	})
+
+	t.Run("Resources of no application are refused", func(t *testing.T) {
+		_, err := user(t, &event.ResourceRequest{Method: http.MethodPatch, Name: "untracked", Namespace: "guestbook"})
+		assert.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("Cluster scoped resources are refused", func(t *testing.T) {
+		_, err := user(t, &event.ResourceRequest{Method: http.MethodDelete, Name: "guestbook"})
+		assert.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("Requests are unchanged if disabled", func(t *testing.T) {
+		ctx, err := (&Agent{}).appServiceAccountContext(context.Background(), &event.ResourceRequest{}, gvr)
+		require.NoError(t, err)
+		assert.Nil(t, ctx.Value(appServiceAccountKey{}))
+	})
+}
+
+func Test_TrackedAppName(t *testing.T) {
+	obj := func(annotations, labels map[string]string) *v1.ObjectMeta {
+		return &v1.ObjectMeta{Annotations: annotations, Labels: labels}
+	}
+	assert.Equal(t, "guestbook", trackedAppName(obj(map[string]string{trackingAnnotationKey: "guestbook:apps/Deployment:guestbook/ui"}, nil)))
+	assert.Equal(t, "guestbook", trackedAppName(obj(map[string]string{trackingAnnotationKey: "apps_guestbook:apps/Deployment:guestbook/ui"}, nil)))
+	assert.Equal(t, "guestbook", trackedAppName(obj(nil, map[string]string{appInstanceLabel: "guestbook"})))
+	assert.Empty(t, trackedAppName(obj(nil, nil)))
+}
@@ -0,0 +1,103 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// clusterResourceRules are the cluster-scoped resources the principal may
+// read through the resource proxy, whether or not they are managed by Argo CD.
+type clusterResourceRules []schema.GroupResource
+
+// WithClusterResourceAllowRules allows the principal to get and list the
+// given cluster-scoped resources, e.g. "nodes" or
+// "apiextensions.k8s.io/customresourcedefinitions". Resources of the core
+// API group are given without a group.
+func WithClusterResourceAllowRules(rules []string) AgentOption {
+	return func(a *Agent) error {
+		allowed := make(clusterResourceRules, 0, len(rules))
+		for _, rule := range rules {
+			gr, err := parseClusterResourceRule(rule)
+			if err != nil {
+				return err
+			}
+			allowed = append(allowed, gr)
+		}
+		if len(allowed) > 0 {
+			a.options.clusterResourceRules = allowed
+		}
+		return nil
+	}
+}
+
+func parseClusterResourceRule(rule string) (schema.GroupResource, error) {
+	group, resource, found := strings.Cut(rule, "/")
+	if !found {
+		group, resource = "", group
+	}
+	if resource == "" || (found && group == "") || strings.Contains(resource, "/") {
+		return schema.GroupResource{}, fmt.Errorf("invalid cluster resource rule %q: must be <resource> or <group>/<resource>", rule)
+	}
+	return schema.GroupResource{Group: group, Resource: resource}, nil
+}
+
+// allows returns whether any rule matches the given resource
+func (r clusterResourceRules) allows(gvr schema.GroupVersionResource) bool {
+	for _, gr := range r {
+		if gr == gvr.GroupResource() {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedClusterResource returns whether a request for the given resource
+// reads an allowed cluster-scoped resource. Subresources are never allowed,
+// and the resource must be cluster-scoped according to the API server.
+func (a *Agent) isAllowedClusterResource(gvr schema.GroupVersionResource, namespace, subresource string) bool {
+	if namespace != "" || subresource != "" || !a.options.clusterResourceRules.allows(gvr) {
+		return false
+	}
+	resources, err := a.kubeClient.Clientset.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		a.logResourceProxy().Warnf("Could not discover resources of %s: %v", gvr.GroupVersion().String(), err)
+		return false
+	}
+	for _, res := range resources.APIResources {
+		if res.Name == gvr.Resource {
+			return !res.Namespaced
+		}
+	}
+	return false
+}
+
+// getClusterResource returns the named cluster-scoped resource, or a list of
+// all such resources if name is empty.
+func (a *Agent) getClusterResource(ctx context.Context, gvr schema.GroupVersionResource, name string, params map[string]string) (*unstructured.Unstructured, *unstructured.UnstructuredList, error) {
+	rif := a.proxyKubeClient().DynamicClient.Resource(gvr)
+	if name != "" {
+		res, err := rif.Get(ctx, name, v1.GetOptions{})
+		return res, nil, err
+	}
+	list, err := rif.List(ctx, listOptionsFromParams(params))
+	return nil, list, err
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/queue"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_WithClusterResourceAllowRules(t *testing.T) {
+	t.Run("Valid rules", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithClusterResourceAllowRules([]string{"nodes", "apiextensions.k8s.io/customresourcedefinitions"})(a))
+		assert.Equal(t, clusterResourceRules{
+			{Resource: "nodes"},
+			{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+		}, a.options.clusterResourceRules)
+	})
+	t.Run("No rules", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithClusterResourceAllowRules(nil)(a))
+		assert.Nil(t, a.options.clusterResourceRules)
+	})
+	t.Run("Invalid rules", func(t *testing.T) {
+		for _, rule := range []string{"", "/nodes", "apps/", "a/b/c"} {
+			err := WithClusterResourceAllowRules([]string{rule})(&Agent{})
+			assert.ErrorContains(t, err, "invalid cluster resource rule", rule)
+		}
+	})
+}
+
+func Test_isAllowedClusterResource(t *testing.T) {
+	a := &Agent{kubeClient: kube.NewDynamicFakeClient()}
+	require.NoError(t, WithClusterResourceAllowRules([]string{"namespaces", "pods"})(a))
+	namespaces := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+	t.Run("Allowed cluster-scoped resource", func(t *testing.T) {
+		assert.True(t, a.isAllowedClusterResource(namespaces, "", ""))
+	})
+	t.Run("Subresources are not allowed", func(t *testing.T) {
+		assert.False(t, a.isAllowedClusterResource(namespaces, "", "status"))
+	})
+	t.Run("Namespaced resources are not allowed", func(t *testing.T) {
+		pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+		assert.False(t, a.isAllowedClusterResource(pods, "", ""))
+		assert.False(t, a.isAllowedClusterResource(pods, "default", ""))
+	})
+	t.Run("Resources without a rule are not allowed", func(t *testing.T) {
+		deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		assert.False(t, a.isAllowedClusterResource(deployments, "", ""))
+	})
+	t.Run("Nothing is allowed without rules", func(t *testing.T) {
+		assert.False(t, (&Agent{kubeClient: a.kubeClient}).isAllowedClusterResource(namespaces, "", ""))
+	})
+}
+
+func Test_processIncomingClusterResourceRequest(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "kube-system"}}
+	request := func(t *testing.T, rules []string, name string) *event.ResourceResponse {
+		t.Helper()
+		a := &Agent{
+			context:             context.Background(),
+			kubeClient:          kube.NewDynamicFakeClient(ns),
+			queues:              queue.NewSendRecvQueues(),
+			emitter:             event.NewEventSource("test-agent"),
+			enableResourceProxy: true,
+			trackingReader:      newTestTrackingReader(""),
+		}
+		require.NoError(t, WithClusterResourceAllowRules(rules)(a))
+		require.NoError(t, a.queues.Create(defaultQueueName))
+
+		ev := cloudevents.NewEvent()
+		ev.SetType(event.GetRequest.String())
+		require.NoError(t, ev.SetData(cloudevents.ApplicationJSON, &event.ResourceRequest{
+			UUID:                 "test-uuid",
+			Name:                 name,
+			GroupVersionResource: v1.GroupVersionResource{Version: "v1", Resource: "namespaces"},
+			Method:               http.MethodGet,
+		}))
+		require.NoError(t, a.processIncomingResourceRequest(event.New(&ev, event.TargetResource)))
+
+		respEv, _ := a.queues.SendQ(defaultQueueName).Get()
+		resp := &event.ResourceResponse{}
+		require.NoError(t, respEv.DataAs(resp))
+		return resp
+	}
+
+	t.Run("Get an allowed unmanaged resource", func(t *testing.T) {
+		resp := request(t, []string{"namespaces"}, "kube-system")
+		require.Equal(t, http.StatusOK, resp.Status)
+		res := &unstructured.Unstructured{}
+		require.NoError(t, json.Unmarshal([]byte(resp.Resource), res))
+		assert.Equal(t, "kube-system", res.GetName())
+	})
+	t.Run("List an allowed resource", func(t *testing.T) {
+		resp := request(t, []string{"namespaces"}, "")
+		require.Equal(t, http.StatusOK, resp.Status)
+		list := &unstructured.UnstructuredList{}
+		require.NoError(t, json.Unmarshal([]byte(resp.Resource), list))
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, "kube-system", list.Items[0].GetName())
+	})
+	t.Run("Unmanaged resources without a rule are forbidden", func(t *testing.T) {
+		resp := request(t, nil, "kube-system")
+		assert.Equal(t, http.StatusForbidden, resp.Status)
+	})
+}
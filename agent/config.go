@@ -0,0 +1,286 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj-labs/argocd-agent/internal/informer"
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Keys of the agent's ConfigMap that are applied at runtime when config
+// reload is enabled. All other keys require a restart of the agent.
+const (
+	ConfigKeyLogLevel            = "agent.log.level"
+	ConfigKeyServerAddress       = "agent.server.address"
+	ConfigKeyServerPort          = "agent.server.port"
+	ConfigKeyLogStreamChunkSize  = "agent.log-stream.chunk-size"
+	ConfigKeyMaxLogStreams       = "agent.max-log-streams"
+	ConfigKeyMaxTerminalSessions = "agent.max-terminal-sessions"
+)
+
+var reloadableConfigKeys = []string{
+	ConfigKeyLogLevel,
+	ConfigKeyServerAddress,
+	ConfigKeyServerPort,
+	ConfigKeyLogStreamChunkSize,
+	ConfigKeyMaxLogStreams,
+	ConfigKeyMaxTerminalSessions,
+}
+
+const (
+	// defaultLogChunkSize is the size of the chunks log streams are sent in
+	defaultLogChunkSize = 64 * 1024
+	minLogChunkSize     = 1024
+	maxLogChunkSize     = 1024 * 1024
+)
+
+// configReloader keeps track of the configuration generations applied from
+// the agent's ConfigMap.
+type configReloader struct {
+	configMap string
+
+	mu         sync.Mutex
+	generation int64
+	// observed holds the values of the reloadable keys as last seen in the
+	// ConfigMap. It is nil until the ConfigMap has been seen once.
+	observed map[string]string
+}
+
+// logChunkMax returns the size of the chunks log streams are sent in
+func (a *Agent) logChunkMax() int {
+	if n := a.logChunkSize.Load(); n > 0 {
+		return int(n)
+	}
+	return defaultLogChunkSize
+}
+
+// ConfigGeneration returns the generation of the configuration applied from
+// the agent's ConfigMap. It is 0 until a change has been applied.
+func (a *Agent) ConfigGeneration() int64 {
+	if a.config == nil {
+		return 0
+	}
+	a.config.mu.Lock()
+	defer a.config.mu.Unlock()
+	return a.config.generation
+}
+
+// startConfigReload watches the agent's ConfigMap and applies changes to the
+// reloadable keys until ctx is done.
+func (a *Agent) startConfigReload(ctx context.Context) error {
+	selector := fields.OneTermEqualSelector("metadata.name", a.config.configMap).String()
+	cms := a.kubeClient.Clientset.CoreV1().ConfigMaps(a.namespace)
+	inf, err := informer.NewInformer(ctx,
+		informer.WithListHandler[*corev1.ConfigMap](func(ctx context.Context, opts v1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector
+			return cms.List(ctx, opts)
+		}),
+		informer.WithWatchHandler[*corev1.ConfigMap](func(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = selector
+			return cms.Watch(ctx, opts)
+		}),
+		informer.WithAddHandler[*corev1.ConfigMap](func(cm *corev1.ConfigMap) {
+			a.reloadConfig(cm)
+		}),
+		informer.WithUpdateHandler[*corev1.ConfigMap](func(_, cm *corev1.ConfigMap) {
+			a.reloadConfig(cm)
+		}),
+		informer.WithGroupResource[*corev1.ConfigMap]("", "configmaps"),
+	)
+	if err != nil {
+		return fmt.Errorf("could not instantiate config informer: %w", err)
+	}
+	go func() {
+		if err := inf.Start(ctx); err != nil {
+			log().WithError(err).Error("Config informer has exited non-successfully")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = inf.Stop()
+	}()
+	syncCtx, cancel := context.WithTimeout(ctx, waitForSyncedDuration)
+	defer cancel()
+	return inf.WaitForSync(syncCtx)
+}
+
+// reloadConfig applies cm and reports the applied configuration generation
+// to the principal.
+func (a *Agent) reloadConfig(cm *corev1.ConfigMap) {
+	if cm.Name != a.config.configMap {
+		return
+	}
+	report := a.applyConfig(cm)
+	if report == nil {
+		return
+	}
+	logCtx := log().WithField("configmap", cm.Name).WithField("generation", report.Generation)
+	for key, reason := range report.Rejected {
+		logCtx.Warnf("Could not apply %s: %s", key, reason)
+	}
+	logCtx.Infof("Applied configuration: %v", report.Applied)
+	if q := a.queues.SendQ(defaultQueueName); q != nil {
+		q.Add(a.emitter.AgentConfigReportEvent(report))
+	}
+}
+
+// applyConfig applies the reloadable keys of cm that have changed since the
+// ConfigMap was last seen. The first time the ConfigMap is seen, its values
+// are only recorded, because the agent has been configured from them on
+// startup. Returns nil if nothing has changed.
+func (a *Agent) applyConfig(cm *corev1.ConfigMap) *event.AgentConfigReport {
+	a.config.mu.Lock()
+	defer a.config.mu.Unlock()
+
+	current := make(map[string]string)
+	for _, key := range reloadableConfigKeys {
+		if v, ok := cm.Data[key]; ok {
+			current[key] = v
+		}
+	}
+	previous := a.config.observed
+	a.config.observed = current
+	if previous == nil {
+		return nil
+	}
+
+	changed := []string{}
+	for _, key := range reloadableConfigKeys {
+		v, ok := current[key]
+		// Removing a key leaves the current setting in place
+		if ok && v != previous[key] {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	report := &event.AgentConfigReport{
+		Source:          cm.Name,
+		ResourceVersion: cm.ResourceVersion,
+		Applied:         make(map[string]string),
+		Rejected:        make(map[string]string),
+	}
+	addressChanged := false
+	for _, key := range changed {
+		var err error
+		switch key {
+		case ConfigKeyLogLevel:
+			err = a.setLogLevel(current[key])
+		case ConfigKeyServerAddress, ConfigKeyServerPort:
+			// Address and port are applied together below
+			addressChanged = true
+			continue
+		case ConfigKeyLogStreamChunkSize:
+			err = a.setLogChunkSize(current[key])
+		case ConfigKeyMaxLogStreams:
+			err = a.setInflightLimit(InflightLogs, current[key])
+		case ConfigKeyMaxTerminalSessions:
+			err = a.setInflightLimit(InflightTerminal, current[key])
+		}
+		if err != nil {
+			report.Rejected[key] = err.Error()
+		} else {
+			report.Applied[key] = current[key]
+		}
+	}
+	if addressChanged {
+		if err := a.setServerAddress(current[ConfigKeyServerAddress], current[ConfigKeyServerPort]); err != nil {
+			report.Rejected[ConfigKeyServerAddress] = err.Error()
+		} else {
+			report.Applied[ConfigKeyServerAddress] = a.remote.Addr()
+		}
+	}
+
+	a.config.generation++
+	report.Generation = a.config.generation
+	return report
+}
+
+func (a *Agent) setLogLevel(value string) error {
+	level := logging.LogLevel(value)
+	if err := logging.GetDefaultLogger().SetLogLevel(level); err != nil {
+		return err
+	}
+	for _, l := range []*logging.CentralizedLogger{a.resourceProxyLogger, a.redisProxyLogger, a.grpcEventLogger} {
+		if l != nil {
+			_ = l.SetLogLevel(level)
+		}
+	}
+	return nil
+}
+
+func (a *Agent) setLogChunkSize(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid chunk size: %w", err)
+	}
+	if n < minLogChunkSize || n > maxLogChunkSize {
+		return fmt.Errorf("chunk size must be between %d and %d bytes", minLogChunkSize, maxLogChunkSize)
+	}
+	a.logChunkSize.Store(int64(n))
+	return nil
+}
+
+func (a *Agent) setInflightLimit(kind inflight.Kind, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid limit: %w", err)
+	}
+	if n < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	a.setLocalInflightLimit(kind, n)
+	return nil
+}
+
+// setServerAddress changes the address of the principal and, if the agent
+// is connected, forces a reconnect to the new address. Empty values leave the
+// current hostname or port in place.
+func (a *Agent) setServerAddress(hostname, port string) error {
+	if hostname == "" {
+		hostname = a.remote.Hostname()
+	}
+	p := a.remote.Port()
+	if port != "" {
+		var err error
+		p, err = strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid port: %s", port)
+		}
+	}
+	if hostname == a.remote.Hostname() && p == a.remote.Port() {
+		return nil
+	}
+	a.remote.SetAddress(hostname, p)
+	if a.IsConnected() {
+		log().Infof("Reconnecting to principal at %s", a.remote.Addr())
+		a.SetConnected(false)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func configMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "argocd-agent-params", Namespace: "argocd"},
+		Data:       data,
+	}
+}
+
+func Test_ApplyConfig(t *testing.T) {
+	originalLevel := logrus.GetLevel()
+	defer logrus.SetLevel(originalLevel)
+
+	a, _ := newAgent(t)
+	a.config = &configReloader{configMap: "argocd-agent-params"}
+	a.emitter = event.NewEventSource("test")
+
+	base := map[string]string{
+		ConfigKeyLogLevel:      "info",
+		ConfigKeyServerAddress: "127.0.0.1",
+		ConfigKeyServerPort:    "8080",
+		"agent.mode":           "managed",
+	}
+
+	t.Run("Initial configuration is only recorded", func(t *testing.T) {
+		assert.Nil(t, a.applyConfig(configMap(base)))
+		assert.Equal(t, int64(0), a.ConfigGeneration())
+	})
+
+	t.Run("Unchanged or non-reloadable keys are ignored", func(t *testing.T) {
+		data := map[string]string{}
+		for k, v := range base {
+			data[k] = v
+		}
+		data["agent.mode"] = "autonomous"
+		assert.Nil(t, a.applyConfig(configMap(data)))
+	})
+
+	t.Run("Changes are applied", func(t *testing.T) {
+		data := map[string]string{
+			ConfigKeyLogLevel:            "debug",
+			ConfigKeyServerAddress:       "principal.example.com",
+			ConfigKeyServerPort:          "443",
+			ConfigKeyLogStreamChunkSize:  "4096",
+			ConfigKeyMaxLogStreams:       "3",
+			ConfigKeyMaxTerminalSessions: "2",
+		}
+		report := a.applyConfig(configMap(data))
+		require.NotNil(t, report)
+		assert.Equal(t, int64(1), report.Generation)
+		assert.Empty(t, report.Rejected)
+		assert.Equal(t, "principal.example.com:443", report.Applied[ConfigKeyServerAddress])
+		assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+		assert.Equal(t, "principal.example.com:443", a.remote.Addr())
+		assert.Equal(t, 4096, a.logChunkMax())
+		assert.Equal(t, 3, a.inflight.Limit(InflightLogs))
+		assert.Equal(t, 2, a.inflight.Limit(InflightTerminal))
+		assert.Equal(t, int64(1), a.ConfigGeneration())
+	})
+
+	t.Run("Invalid values are rejected", func(t *testing.T) {
+		data := map[string]string{
+			ConfigKeyLogLevel:            "verbose",
+			ConfigKeyServerAddress:       "principal.example.com",
+			ConfigKeyServerPort:          "0",
+			ConfigKeyLogStreamChunkSize:  "10",
+			ConfigKeyMaxLogStreams:       "5",
+			ConfigKeyMaxTerminalSessions: "-1",
+		}
+		report := a.applyConfig(configMap(data))
+		require.NotNil(t, report)
+		assert.Equal(t, int64(2), report.Generation)
+		assert.Contains(t, report.Rejected, ConfigKeyLogLevel)
+		assert.Contains(t, report.Rejected, ConfigKeyServerAddress)
+		assert.Contains(t, report.Rejected, ConfigKeyLogStreamChunkSize)
+		assert.Contains(t, report.Rejected, ConfigKeyMaxTerminalSessions)
+		assert.Equal(t, map[string]string{ConfigKeyMaxLogStreams: "5"}, report.Applied)
+		// Previous settings stay in place
+		assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+		assert.Equal(t, "principal.example.com:443", a.remote.Addr())
+		assert.Equal(t, 4096, a.logChunkMax())
+		assert.Equal(t, 2, a.inflight.Limit(InflightTerminal))
+	})
+
+	t.Run("Reloading reports to the principal", func(t *testing.T) {
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 0, q.Len())
+		a.reloadConfig(configMap(map[string]string{ConfigKeyMaxLogStreams: "7"}))
+		require.Equal(t, 1, q.Len())
+		ev, _ := q.Get()
+		q.Done(ev)
+		require.Equal(t, event.TargetAgentConfig, event.Target(ev))
+		report, err := event.New(ev, event.TargetAgentConfig).AgentConfigReport()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), report.Generation)
+		assert.Equal(t, "argocd-agent-params", report.Source)
+		assert.Equal(t, map[string]string{ConfigKeyMaxLogStreams: "7"}, report.Applied)
+	})
+}
+
+func Test_ConfigReloadWatch(t *testing.T) {
+	a, kubec := newAgent(t)
+	a.config = &configReloader{configMap: "argocd-agent-params"}
+	a.emitter = event.NewEventSource("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cms := kubec.Clientset.CoreV1().ConfigMaps("argocd")
+	_, err := cms.Create(ctx, configMap(map[string]string{ConfigKeyMaxLogStreams: "1"}), v1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, a.startConfigReload(ctx))
+
+	_, err = cms.Update(ctx, configMap(map[string]string{ConfigKeyMaxLogStreams: "4"}), v1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return a.ConfigGeneration() == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 4, a.inflight.Limit(InflightLogs))
+}
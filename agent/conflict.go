@@ -0,0 +1,96 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"reflect"
+
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+// WithConflictStrategy sets whose spec prevails when an Application the
+// principal owns was modified on the agent. It only applies to managed
+// agents, as the Applications of autonomous agents are owned by the agent.
+// The default is hub-wins, which reverts modifications made on the agent.
+func WithConflictStrategy(strategy string) AgentOption {
+	return func(a *Agent) error {
+		s, err := manager.ParseConflictStrategy(strategy)
+		if err != nil {
+			return err
+		}
+		a.options.conflictStrategy = s
+		return nil
+	}
+}
+
+// resolveLocalAppChange handles a modification of app, an Application of the
+// principal, on the agent. It returns true if the modification was reverted
+// to the principal's spec.
+func (a *Agent) resolveLocalAppChange(app *v1alpha1.Application) bool {
+	if !a.mode.IsManaged() {
+		return false
+	}
+	hub, ok := a.sourceCache.Application.Get(ktypes.UID(app.Annotations[manager.SourceUIDAnnotation]))
+	if !ok || a.options.conflictStrategy.OwnerPrevails(manager.SideHub, false) {
+		return a.appManager.RevertManagedAppChanges(a.context, app, a.sourceCache.Application)
+	}
+	if reflect.DeepEqual(hub, app.Spec) {
+		a.setConflictCondition(app, false)
+		return false
+	}
+	log().WithField("app", app.QualifiedName()).Infof("Keeping modification of application according to conflict strategy %s", a.options.conflictStrategy)
+	a.setConflictCondition(app, a.options.conflictStrategy == manager.ConflictManual)
+	return false
+}
+
+// resolveIncomingAppChange returns the Application to write for incoming, an
+// update of the principal's Application, whose copy on the agent is
+// existing. If the copy was modified on the agent, and the modification
+// prevails according to the conflict strategy, the copy's spec is kept.
+func (a *Agent) resolveIncomingAppChange(existing, incoming *v1alpha1.Application) *v1alpha1.Application {
+	hub, ok := a.sourceCache.Application.Get(sourceUIDForApp(incoming))
+	if existing == nil || !ok || reflect.DeepEqual(existing.Spec, hub) || reflect.DeepEqual(existing.Spec, incoming.Spec) {
+		// The copy was not modified, or matches the principal's spec again
+		a.setConflictCondition(existing, false)
+		return incoming
+	}
+	if a.options.conflictStrategy.OwnerPrevails(manager.SideHub, true) {
+		a.setConflictCondition(existing, false)
+		return incoming
+	}
+	log().WithField("app", existing.QualifiedName()).Infof("Keeping modified spec of application instead of the principal's according to conflict strategy %s", a.options.conflictStrategy)
+	a.setConflictCondition(existing, a.options.conflictStrategy == manager.ConflictManual)
+	out := incoming.DeepCopy()
+	out.Spec = *existing.Spec.DeepCopy()
+	return out
+}
+
+// setConflictCondition adds or removes the conflict condition of app, if it
+// doesn't match conflicted.
+func (a *Agent) setConflictCondition(app *v1alpha1.Application, conflicted bool) {
+	if app == nil || manager.HasConflictCondition(app) == conflicted {
+		return
+	}
+	conditions := []v1alpha1.ApplicationCondition{}
+	if conflicted {
+		conditions = append(conditions, manager.ConflictCondition(manager.SideSpoke))
+	}
+	evaluated := map[v1alpha1.ApplicationConditionType]bool{manager.ConflictConditionType: true}
+	if _, err := a.appManager.SetConditions(a.context, app.Name, app.Namespace, conditions, evaluated); err != nil {
+		log().WithError(err).WithField("app", app.QualifiedName()).Warn("Could not update conflict condition of application")
+	}
+}
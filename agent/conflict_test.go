@@ -0,0 +1,124 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/pkg/client"
+	fakekube "github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ConflictStrategy(t *testing.T) {
+	hubSpec := v1alpha1.ApplicationSpec{Project: "default", Source: &v1alpha1.ApplicationSource{RepoURL: "https://hub", TargetRevision: "v1"}}
+	localSpec := v1alpha1.ApplicationSpec{Project: "default", Source: &v1alpha1.ApplicationSource{RepoURL: "https://hub", TargetRevision: "local"}}
+	newHubSpec := v1alpha1.ApplicationSpec{Project: "default", Source: &v1alpha1.ApplicationSource{RepoURL: "https://hub", TargetRevision: "v2"}}
+	modified := &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app", Namespace: "argocd",
+			Annotations: map[string]string{manager.SourceUIDAnnotation: "principal-uid"},
+		},
+		Spec: localSpec,
+	}
+
+	newConflictAgent := func(t *testing.T, strategy string) *Agent {
+		t.Helper()
+		kubec := fakekube.NewKubernetesFakeClientWithApps("argocd", modified.DeepCopy())
+		remote, err := client.NewRemote("127.0.0.1", 8080)
+		require.NoError(t, err)
+		a, err := NewAgent(context.TODO(), kubec, "argocd", WithRemote(remote), WithCacheRefreshInterval(10*time.Second),
+			WithMode("managed"), WithConflictStrategy(strategy))
+		require.NoError(t, err)
+		a.sourceCache.Application.Set("principal-uid", hubSpec)
+		return a
+	}
+	getApp := func(t *testing.T, a *Agent) *v1alpha1.Application {
+		t.Helper()
+		app, err := a.appManager.Get(context.TODO(), "app", "argocd")
+		require.NoError(t, err)
+		return app
+	}
+	incoming := func() *v1alpha1.Application {
+		app := modified.DeepCopy()
+		app.Spec = newHubSpec
+		return app
+	}
+
+	t.Run("Invalid strategies are refused", func(t *testing.T) {
+		_, err := NewAgent(context.TODO(), fakekube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+			WithRemote(&client.Remote{}), WithConflictStrategy("local-wins"))
+		assert.ErrorContains(t, err, "invalid conflict strategy")
+	})
+
+	t.Run("hub-wins reverts local modifications", func(t *testing.T) {
+		a := newConflictAgent(t, "hub-wins")
+		assert.True(t, a.resolveLocalAppChange(modified.DeepCopy()))
+		assert.Equal(t, hubSpec, getApp(t, a).Spec)
+	})
+
+	for _, strategy := range []string{"spoke-wins", "newest-wins"} {
+		t.Run(strategy+" keeps local modifications", func(t *testing.T) {
+			a := newConflictAgent(t, strategy)
+			assert.False(t, a.resolveLocalAppChange(modified.DeepCopy()))
+			app := getApp(t, a)
+			assert.Equal(t, localSpec, app.Spec)
+			assert.False(t, manager.HasConflictCondition(app))
+		})
+	}
+
+	t.Run("spoke-wins keeps the local spec on updates from the principal", func(t *testing.T) {
+		a := newConflictAgent(t, "spoke-wins")
+		toApply := a.resolveIncomingAppChange(getApp(t, a), incoming())
+		assert.Equal(t, localSpec, toApply.Spec)
+	})
+
+	t.Run("newest-wins applies updates from the principal", func(t *testing.T) {
+		a := newConflictAgent(t, "newest-wins")
+		toApply := a.resolveIncomingAppChange(getApp(t, a), incoming())
+		assert.Equal(t, newHubSpec, toApply.Spec)
+	})
+
+	t.Run("Unmodified applications receive updates from the principal", func(t *testing.T) {
+		a := newConflictAgent(t, "spoke-wins")
+		a.sourceCache.Application.Set("principal-uid", localSpec)
+		toApply := a.resolveIncomingAppChange(getApp(t, a), incoming())
+		assert.Equal(t, newHubSpec, toApply.Spec)
+	})
+
+	t.Run("manual marks conflicts until both specs match", func(t *testing.T) {
+		a := newConflictAgent(t, "manual")
+		assert.False(t, a.resolveLocalAppChange(modified.DeepCopy()))
+		app := getApp(t, a)
+		assert.Equal(t, localSpec, app.Spec)
+		assert.True(t, manager.HasConflictCondition(app))
+
+		toApply := a.resolveIncomingAppChange(app, incoming())
+		assert.Equal(t, localSpec, toApply.Spec)
+		assert.True(t, manager.HasConflictCondition(getApp(t, a)))
+
+		// The principal's spec is changed to match the local one
+		resolved := incoming()
+		resolved.Spec = localSpec
+		a.resolveIncomingAppChange(getApp(t, a), resolved)
+		assert.False(t, manager.HasConflictCondition(getApp(t, a)))
+	})
+}
@@ -17,9 +17,11 @@ package agent
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
 	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/internal/logging/logfields"
@@ -28,7 +30,8 @@ import (
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/eventstreamapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/sirupsen/logrus"
-	"k8s.io/client-go/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
 )
@@ -38,6 +41,14 @@ func (a *Agent) maintainConnection() error {
 		var err error
 		for {
 			if !a.IsConnected() {
+				if d := a.connState.reconnectDelay(time.Now()); d > 0 {
+					log().Infof("Principal is shutting down, waiting %v before reconnecting", d.Round(time.Millisecond))
+					select {
+					case <-a.context.Done():
+						return
+					case <-time.After(d):
+					}
+				}
 				err = a.remote.Connect(a.context, false)
 				if err != nil {
 					log().Warnf("Could not connect to %s: %v", a.remote.Addr(), err)
@@ -95,15 +106,21 @@ func (a *Agent) sender(stream eventstreamapi.EventStream_SubscribeClient) error
 		"resource_id":  event.ResourceID(ev),
 		"event_id":     event.EventID(ev),
 	})
+	out := a.preSend(ev)
+	if out == nil {
+		return nil
+	}
 	logCtx.Trace("Adding an event to the event writer")
-	a.eventWriter.Add(ev)
+	a.recorder.Record(eventrecord.DirectionSend, "", out)
+	a.eventWriter.Add(out)
+	a.postSend(out)
 
 	return nil
 }
 
 // receiver receives and processes a single event from the event stream. It
 // will block until an event has been received, or an error has occurred.
-func (a *Agent) receiver(stream eventstreamapi.EventStream_SubscribeClient) error {
+func (a *Agent) receiver(stream eventstreamapi.EventStream_SubscribeClient, chunks *event.ChunkAssembler) error {
 	logCtx := log().WithFields(logrus.Fields{
 		logfields.Module:     "StreamEvent",
 		logfields.Direction:  "Recv",
@@ -118,6 +135,19 @@ func (a *Agent) receiver(stream eventstreamapi.EventStream_SubscribeClient) erro
 			return nil
 		}
 	}
+	// Events too large for a single message arrive in chunks, which are
+	// only processed once complete.
+	if event.IsChunk(rcvd.Event) {
+		pev, err := chunks.Assemble(rcvd.Event)
+		if err != nil {
+			logCtx.WithError(err).Warn("Dropping chunked event")
+			return nil
+		}
+		if pev == nil {
+			return nil
+		}
+		rcvd.Event = pev
+	}
 	ev, err := event.FromWire(rcvd.Event)
 	if err != nil {
 		logCtx.Errorf("Could not unwrap event: %v", err)
@@ -131,6 +161,7 @@ func (a *Agent) receiver(stream eventstreamapi.EventStream_SubscribeClient) erro
 	})
 
 	logCtx.Debugf("Received a new event from stream")
+	a.recorder.Record(eventrecord.DirectionRecv, "", ev.CloudEvent())
 
 	if ev.Target() == event.TargetEventAck {
 		logCtx.Trace("Received an ACK for an event")
@@ -143,7 +174,22 @@ func (a *Agent) receiver(stream eventstreamapi.EventStream_SubscribeClient) erro
 		return nil
 	}
 
-	err = a.processIncomingEvent(ev)
+	if a.eventPool == nil {
+		return a.processAndAcknowledge(ev, logCtx)
+	}
+	// Events of unrelated resources are processed concurrently, so that a
+	// slow event doesn't hold up the others.
+	return a.eventPool.submit(a.context, orderingKey(ev), func() {
+		if err := a.processAndAcknowledge(ev, logCtx); err != nil {
+			logCtx.WithError(err).Error("Unable to acknowledge incoming event")
+		}
+	})
+}
+
+// processAndAcknowledge processes an event received from the principal and
+// acknowledges it, unless processing failed with a retryable error.
+func (a *Agent) processAndAcknowledge(ev *event.Event, logCtx *logrus.Entry) error {
+	err := a.processWithPlugins(ev)
 	if err != nil {
 		logCtx.WithError(err).Errorf("Unable to process incoming event")
 		// Don't send an ACK if it is a retryable error.
@@ -164,10 +210,36 @@ func (a *Agent) receiver(stream eventstreamapi.EventStream_SubscribeClient) erro
 	return nil
 }
 
+// negotiateSchemaVersion waits for the principal's response header on the
+// event stream and configures the event writer to use the event schema
+// version agreed upon.
+func (a *Agent) negotiateSchemaVersion(stream grpc.ClientStream, ew *event.EventWriter, logCtx *logrus.Entry) bool {
+	md, err := stream.Header()
+	if err != nil {
+		return false
+	}
+	var principalSchema string
+	if v := md.Get(event.SchemaVersionMetadataKey); len(v) > 0 {
+		principalSchema = v[0]
+	}
+	v, err := event.ParseSchemaVersion(principalSchema)
+	if err != nil {
+		logCtx.WithError(err).Warn("Principal sent an invalid event schema version, using legacy schema")
+		v = event.SchemaVersionLegacy
+	}
+	v = event.NegotiateSchemaVersion(v)
+	ew.SetSchemaVersion(v)
+	logCtx.WithField("event_schema", v).Debug("Negotiated event schema version")
+	return true
+}
+
 func (a *Agent) handleStreamEvents() error {
 	conn := a.remote.Conn()
 	client := eventstreamapi.NewEventStreamClient(conn)
-	stream, err := client.Subscribe(a.context)
+	// Announce the newest event schema version we support. The principal
+	// answers with the version to use in the response header.
+	subCtx := metadata.AppendToOutgoingContext(a.context, event.SchemaVersionMetadataKey, event.CurrentSchemaVersion.String())
+	stream, err := client.Subscribe(subCtx)
 	if err != nil {
 		return err
 	}
@@ -182,27 +254,45 @@ func (a *Agent) handleStreamEvents() error {
 	} else {
 		a.eventWriter.UpdateTarget(stream)
 	}
-	go a.eventWriter.SendWaitingEvents(streamCtx)
+	a.eventWriter.SetMaxMessageSize(a.remote.MaxGRPCMessageSize)
 
 	logCtx := log().WithFields(logrus.Fields{
 		logfields.Module:     "StreamEvent",
 		logfields.ServerAddr: grpcutil.AddressFromContext(stream.Context()),
 	})
 
-	if err := a.resyncOnStart(logCtx); err != nil {
+	// Principals that don't negotiate only send a header along with their
+	// first event, so we must not block on it.
+	a.eventWriter.SetSchemaVersion(event.CurrentSchemaVersion)
+	negotiated := make(chan bool, 1)
+	go func() {
+		negotiated <- a.negotiateSchemaVersion(stream, a.eventWriter, logCtx)
+	}()
+
+	go a.eventWriter.SendWaitingEvents(streamCtx)
+
+	if err := a.resyncOnStart(logCtx, negotiated); err != nil {
 		logCtx.Errorf("failed to resync the agent on startup: %v", err)
 	}
 
+	// Let the principal know which pushed configurations we hold, so that it
+	// can push what is missing or outdated.
+	a.reportPushedConfigState()
+
+	// Let the principal know which resource statuses we leave out.
+	a.reportResourceFilter()
+
 	// Receive events from the subscription stream
 	go func() {
 		logCtx := logCtx.WithFields(logrus.Fields{
 			"direction": "recv",
 		})
 		logCtx.Info("Starting to receive events from event stream")
+		chunks := event.NewChunkAssembler()
 		var err error
 		// Continuously retrieve events from the event stream 'inbox' and process them, while the stream is connected
 		for a.IsConnected() && err == nil {
-			err = a.receiver(stream)
+			err = a.receiver(stream, chunks)
 			if err != nil {
 				if grpcutil.NeedReconnectOnError(err) {
 					a.SetConnected(false)
@@ -270,13 +360,8 @@ func (a *Agent) handleStreamEvents() error {
 		}()
 	}
 
-	for a.IsConnected() {
-		select {
-		case <-a.context.Done():
-			return nil
-		default:
-			time.Sleep(100 * time.Millisecond)
-		}
+	if err := a.WaitForConnectionState(a.context, false); err != nil {
+		return nil
 	}
 
 	log().WithField(logfields.Component, "EventHandler").Info("Stream closed")
@@ -285,7 +370,10 @@ func (a *Agent) handleStreamEvents() error {
 	return nil
 }
 
-func (a *Agent) resyncOnStart(logCtx *logrus.Entry) error {
+// resyncOnStart resyncs with the principal after the agent started. negotiated
+// receives whether the event schema version was negotiated with the principal;
+// it may be nil if the version is not being negotiated.
+func (a *Agent) resyncOnStart(logCtx *logrus.Entry, negotiated <-chan bool) error {
 	if a.resyncedOnStart {
 		return nil
 	}
@@ -310,15 +398,23 @@ func (a *Agent) resyncOnStart(logCtx *logrus.Entry) error {
 
 		// Principal is the source of truth in the managed mode. Agent should request the latest content
 		// from the Principal to detect any updates on the agent side.
-		dynClient, err := dynamic.NewForConfig(a.kubeClient.RestConfig)
+		dynClient, err := a.kubeClient.Dynamic()
 		if err != nil {
 			return err
 		}
 
 		resyncHandler := resync.NewRequestHandler(dynClient, sendQ, a.emitter, a.resources, logCtx, manager.ManagerRoleAgent, a.namespace).
 			WithDestinationBasedMapping(a.destinationBasedMapping).
-			WithIgnoreUnmanagedApps(a.ignoreUnmanagedApps)
-		go resyncHandler.SendRequestUpdates(a.context)
+			WithIgnoreUnmanagedApps(a.ignoreUnmanagedApps).
+			WithApplicationFilter(a.outgoingApplication).
+			WithPager(a.resyncPager, "principal").
+			WithBookmarks(a.resyncBookmarks, "principal")
+		if a.warmCacheLoaded {
+			a.warmCacheLoaded = false
+			go a.resyncFromWarmCache(resyncHandler, negotiated)
+		} else {
+			go resyncHandler.SendRequestUpdates(a.context)
+		}
 
 		// Agent should request SyncedResourceList from the principal to detect deleted
 		// resources on the agent side.
@@ -336,3 +432,75 @@ func (a *Agent) resyncOnStart(logCtx *logrus.Entry) error {
 	a.resyncedOnStart = true
 	return nil
 }
+
+// reportResyncProgress logs the progress of a paginated initial sync with
+// the principal and exposes it as a metric
+func (a *Agent) reportResyncProgress(p resync.Progress) {
+	logCtx := log().WithFields(logrus.Fields{
+		"kind":    p.Kind,
+		"sent":    p.Sent,
+		"total":   p.Total,
+		"resumed": p.Resumed,
+	})
+	if p.Done {
+		logCtx = logCtx.WithField("duration", p.Duration)
+		logCtx.Info("Initial sync with the principal completed")
+	} else {
+		logCtx.Info("Initial sync with the principal in progress")
+	}
+	if a.metrics != nil {
+		a.metrics.InitialSyncPending.WithLabelValues(p.Kind).Set(float64(p.Total - p.Sent))
+		if p.Done {
+			a.metrics.ResyncDuration.WithLabelValues(p.Kind).Observe(p.Duration.Seconds())
+			a.metrics.ResyncObjects.WithLabelValues(p.Kind).Set(float64(p.Total))
+		}
+	}
+}
+
+// processIncomingHeartbeat answers the principal's connection probes. Plain
+// keepalive pings without timestamps need no answer.
+func (a *Agent) processIncomingHeartbeat(ev *event.Event) error {
+	receivedAt := time.Now()
+	if ev.Type() == event.Drain {
+		return a.processDrainNotice(ev)
+	}
+	if ev.Type() != event.Ping {
+		return nil
+	}
+	probe, err := ev.ConnectionProbe()
+	if err != nil {
+		return fmt.Errorf("invalid connection probe: %w", err)
+	}
+	if probe == nil {
+		return nil
+	}
+	probe.PingReceivedAt = receivedAt
+	// Stamped again by the event writer when the pong is sent
+	probe.PongSentAt = time.Now()
+	pong, err := a.emitter.ConnectionProbeEvent(event.Pong, probe)
+	if err != nil {
+		return err
+	}
+	a.eventWriter.Add(pong)
+	return nil
+}
+
+// processDrainNotice handles the principal telling us that it shuts down. We
+// stay connected for as long as the principal lets us, so requests in flight
+// can finish, but don't reconnect before the suggested delay has passed. Up to
+// a quarter of the delay is added as jitter, so that not all agents come back
+// at the same time.
+func (a *Agent) processDrainNotice(ev *event.Event) error {
+	n, err := ev.DrainNotice()
+	if err != nil {
+		return fmt.Errorf("invalid drain notice: %w", err)
+	}
+	delay := time.Duration(n.ReconnectAfter) * time.Millisecond
+	if delay <= 0 {
+		return nil
+	}
+	delay += rand.N(delay/4 + 1)
+	log().Infof("Principal is shutting down, will reconnect in %v", delay.Round(time.Millisecond))
+	a.connState.deferReconnect(time.Now().Add(delay))
+	return nil
+}
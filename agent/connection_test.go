@@ -31,7 +31,7 @@ func TestResyncOnStart(t *testing.T) {
 
 	t.Run("should return if the agent has already been synced", func(t *testing.T) {
 		a.resyncedOnStart = true
-		err := a.resyncOnStart(logCtx)
+		err := a.resyncOnStart(logCtx, nil)
 		assert.Nil(t, err)
 
 		sendQ := a.queues.SendQ(defaultQueueName)
@@ -41,7 +41,7 @@ func TestResyncOnStart(t *testing.T) {
 	t.Run("send resource resync request in autonomous mode", func(t *testing.T) {
 		a.resyncedOnStart = false
 		a.mode = types.AgentModeAutonomous
-		err := a.resyncOnStart(logCtx)
+		err := a.resyncOnStart(logCtx, nil)
 		assert.Nil(t, err)
 
 		sendQ := a.queues.SendQ(defaultQueueName)
@@ -57,7 +57,7 @@ func TestResyncOnStart(t *testing.T) {
 	t.Run("send synced resource list request in managed mode", func(t *testing.T) {
 		a.resyncedOnStart = false
 		a.mode = types.AgentModeManaged
-		err := a.resyncOnStart(logCtx)
+		err := a.resyncOnStart(logCtx, nil)
 		assert.Nil(t, err)
 
 		sendQ := a.queues.SendQ(defaultQueueName)
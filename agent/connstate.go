@@ -0,0 +1,111 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// connectionState tracks whether the agent is connected to the principal and
+// notifies watchers when that changes.
+type connectionState struct {
+	mu        sync.Mutex
+	connected bool
+	// since is when the state last changed
+	since time.Time
+	// changed is closed and replaced whenever the state changes
+	changed chan struct{}
+	// reconnectAfter is the earliest time to reconnect, as suggested by a
+	// draining principal
+	reconnectAfter time.Time
+}
+
+func newConnectionState() *connectionState {
+	return &connectionState{changed: make(chan struct{}), since: time.Now()}
+}
+
+func (c *connectionState) get() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *connectionState) set(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connected == connected {
+		return
+	}
+	c.connected = connected
+	c.since = time.Now()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// state returns the current state and when it last changed
+func (c *connectionState) state() (bool, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected, c.since
+}
+
+// deferReconnect keeps the agent from reconnecting before t
+func (c *connectionState) deferReconnect(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnectAfter = t
+}
+
+// reconnectDelay returns how long the agent should wait before it reconnects
+func (c *connectionState) reconnectDelay(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return max(c.reconnectAfter.Sub(now), 0)
+}
+
+// watch returns the current state and a channel that is closed on the next
+// change of the state.
+func (c *connectionState) watch() (bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected, c.changed
+}
+
+// WatchConnection returns whether the agent is currently connected to the
+// principal, along with a channel that is closed as soon as the connection
+// state changes. Callers interested in further changes need to call
+// WatchConnection again.
+func (a *Agent) WatchConnection() (bool, <-chan struct{}) {
+	connected, changed := a.connState.watch()
+	return connected && a.remote != nil, changed
+}
+
+// WaitForConnectionState blocks until the agent's connection state equals
+// connected, or until ctx is done.
+func (a *Agent) WaitForConnectionState(ctx context.Context, connected bool) error {
+	for {
+		current, changed := a.WatchConnection()
+		if current == connected {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WatchConnection(t *testing.T) {
+	t.Run("Change closes the watch channel", func(t *testing.T) {
+		a, _ := newAgent(t)
+		connected, changed := a.WatchConnection()
+		assert.False(t, connected)
+		a.SetConnected(true)
+		select {
+		case <-changed:
+		default:
+			t.Fatal("watch channel not closed on change")
+		}
+		connected, changed = a.WatchConnection()
+		assert.True(t, connected)
+		// Setting the same state again is not a change
+		a.SetConnected(true)
+		select {
+		case <-changed:
+			t.Fatal("watch channel closed without change")
+		default:
+		}
+	})
+
+	t.Run("Wait returns once connected", func(t *testing.T) {
+		a, _ := newAgent(t)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			a.SetConnected(true)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, a.WaitForConnectionState(ctx, true))
+		assert.True(t, a.IsConnected())
+	})
+
+	t.Run("Wait returns when context is done", func(t *testing.T) {
+		a, _ := newAgent(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := a.WaitForConnectionState(ctx, true)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func Test_ProcessDrainNotice(t *testing.T) {
+	t.Run("Reconnect is deferred by the suggested delay", func(t *testing.T) {
+		a, _ := newAgent(t)
+		assert.Zero(t, a.connState.reconnectDelay(time.Now()))
+		ev, err := event.NewEventSource("principal").DrainEvent(4 * time.Second)
+		require.NoError(t, err)
+		require.NoError(t, a.processDrainNotice(event.New(ev, event.TargetHeartbeat)))
+		delay := a.connState.reconnectDelay(time.Now())
+		assert.Greater(t, delay, 3*time.Second)
+		assert.LessOrEqual(t, delay, 5*time.Second)
+		assert.Zero(t, a.connState.reconnectDelay(time.Now().Add(6*time.Second)))
+	})
+
+	t.Run("Notice without delay is ignored", func(t *testing.T) {
+		a, _ := newAgent(t)
+		ev, err := event.NewEventSource("principal").DrainEvent(0)
+		require.NoError(t, err)
+		require.NoError(t, a.processDrainNotice(event.New(ev, event.TargetHeartbeat)))
+		assert.Zero(t, a.connState.reconnectDelay(time.Now()))
+	})
+}
@@ -0,0 +1,187 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+)
+
+// processIncomingDebugCommand runs a diagnostic command an operator sent
+// through the principal's debug shell. The command runs on the event thread,
+// its output is sent in the background.
+func (a *Agent) processIncomingDebugCommand(ev *event.Event) error {
+	req, err := ev.DebugCommandRequest()
+	if err != nil {
+		return err
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"method":       "processIncomingDebugCommand",
+		"uuid":         req.UUID,
+		"command":      req.Command,
+		"requested_by": req.RequestedBy,
+	})
+	logCtx.Info("Running diagnostic command on request of the principal")
+
+	output, cmdErr := a.runDebugCommand(req.Command, logCtx)
+	go func() {
+		ctx, cancel := context.WithCancel(a.context)
+		defer cancel()
+		if cmdErr != nil {
+			if err := a.sendFileTransferError(ctx, req.UUID, cmdErr); err != nil {
+				logCtx.WithError(err).Warn("Could not report error to principal")
+			}
+			return
+		}
+		_, err := a.uploadFile(ctx, &fileUpload{
+			requestUUID: req.UUID,
+			contentType: "text/plain; charset=utf-8",
+			src:         bytes.NewReader(output),
+			size:        int64(len(output)),
+		}, logCtx)
+		if err != nil {
+			logCtx.WithError(err).Error("Could not send output of diagnostic command")
+		}
+	}()
+	return nil
+}
+
+// runDebugCommand runs one of the diagnostic commands in event.DebugCommands
+// and returns its output.
+func (a *Agent) runDebugCommand(command string, logCtx *logrus.Entry) ([]byte, error) {
+	switch command {
+	case event.DebugCommandStatus:
+		return a.debugStatus(), nil
+	case event.DebugCommandInflight:
+		return a.debugInflight(), nil
+	case event.DebugCommandConfig:
+		return a.debugConfig(), nil
+	case event.DebugCommandResync:
+		a.resyncedOnStart = false
+		if err := a.resyncOnStart(logCtx, nil); err != nil {
+			return nil, fmt.Errorf("could not resync: %w", err)
+		}
+		return []byte("Requested resync with the principal\n"), nil
+	}
+	return nil, fmt.Errorf("unknown command %q", command)
+}
+
+// debugStatus describes the state of the agent and its connection
+func (a *Agent) debugStatus() []byte {
+	values := map[string]string{
+		"mode":             a.mode.String(),
+		"connected":        fmt.Sprint(a.IsConnected()),
+		"configGeneration": fmt.Sprint(a.ConfigGeneration()),
+		"inflightRequests": fmt.Sprint(len(a.InflightRequests())),
+	}
+	if a.version != nil {
+		values["version"] = a.version.Version()
+	}
+	if a.queues != nil {
+		if q := a.queues.SendQ(defaultQueueName); q != nil {
+			values["sendQueueLength"] = fmt.Sprint(q.Len())
+		}
+		if q := a.queues.RecvQ(defaultQueueName); q != nil {
+			values["recvQueueLength"] = fmt.Sprint(q.Len())
+		}
+	}
+	if a.eventWriter != nil {
+		values["eventSchemaVersion"] = a.eventWriter.SchemaVersion().String()
+	}
+	return formatDebugValues(values)
+}
+
+// debugInflight lists the long-running operations in flight
+func (a *Agent) debugInflight() []byte {
+	entries := a.InflightRequests()
+	if len(entries) == 0 {
+		return []byte("No requests in flight\n")
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tID\tAGE\tATTRIBUTES")
+	for _, e := range entries {
+		attrs := make([]string, 0, len(e.Attributes))
+		for k, v := range e.Attributes {
+			attrs = append(attrs, k+"="+v)
+		}
+		sort.Strings(attrs)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Kind, e.ID, e.Age, strings.Join(attrs, ","))
+	}
+	_ = w.Flush()
+	return buf.Bytes()
+}
+
+// debugConfig describes the effective configuration of the agent. It never
+// includes credentials.
+func (a *Agent) debugConfig() []byte {
+	values := map[string]string{
+		"namespace":               a.namespace,
+		"allowedNamespaces":       strings.Join(a.allowedNamespaces, ","),
+		"resourceProxy":           fmt.Sprint(a.enableResourceProxy),
+		"destinationBasedMapping": fmt.Sprint(a.destinationBasedMapping),
+		"createNamespace":         fmt.Sprint(a.createNamespace),
+		"labelSelector":           a.labelSelector,
+		"cacheRefreshInterval":    a.cacheRefreshInterval.String(),
+		"heartbeatInterval":       a.options.heartbeatInterval.String(),
+		"namespaceMapping":        a.options.namespaceMapper.String(),
+	}
+	if a.remote != nil {
+		values["principal"] = a.remote.Addr()
+		values["auth"] = a.remote.AuthMethod()
+	}
+	if a.proxyImpersonation != nil {
+		values["proxyImpersonateUser"] = a.proxyImpersonation.UserName
+	}
+	if r := a.options.logAccessRules; r != nil {
+		values["logAccessAllow"] = strings.Join(r.allow, ",")
+		values["logAccessDeny"] = strings.Join(r.deny, ",")
+	}
+	if rules := a.options.clusterResourceRules; len(rules) > 0 {
+		resources := make([]string, 0, len(rules))
+		for _, gr := range rules {
+			if gr.Group == "" {
+				resources = append(resources, gr.Resource)
+			} else {
+				resources = append(resources, gr.Group+"/"+gr.Resource)
+			}
+		}
+		values["clusterResourceAllow"] = strings.Join(resources, ",")
+	}
+	return formatDebugValues(values)
+}
+
+// formatDebugValues prints values as sorted key: value lines
+func formatDebugValues(values map[string]string) []byte {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 1, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s:\t%s\n", k, values[k])
+	}
+	_ = w.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj-labs/argocd-agent/internal/queue"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runDebugCommand(t *testing.T) {
+	newAgent := func(t *testing.T) *Agent {
+		t.Helper()
+		a := &Agent{
+			namespace:           "argocd",
+			mode:                types.AgentModeAutonomous,
+			queues:              queue.NewSendRecvQueues(),
+			emitter:             event.NewEventSource("test-agent"),
+			inflight:            inflight.NewRegistry(),
+			enableResourceProxy: true,
+			connState:           newConnectionState(),
+		}
+		require.NoError(t, a.queues.Create(defaultQueueName))
+		return a
+	}
+	logCtx := log()
+
+	t.Run("Status", func(t *testing.T) {
+		a := newAgent(t)
+		out, err := a.runDebugCommand(event.DebugCommandStatus, logCtx)
+		require.NoError(t, err)
+		assert.Contains(t, string(out), "connected:        false\n")
+		assert.Contains(t, string(out), "mode:             autonomous\n")
+		assert.Contains(t, string(out), "sendQueueLength:  0\n")
+	})
+
+	t.Run("Inflight", func(t *testing.T) {
+		a := newAgent(t)
+		out, err := a.runDebugCommand(event.DebugCommandInflight, logCtx)
+		require.NoError(t, err)
+		assert.Equal(t, "No requests in flight\n", string(out))
+
+		_, done, err := a.inflight.Start(context.Background(), InflightLogs, "1234", map[string]string{"pod": "argocd/app-1", "container": "app"})
+		require.NoError(t, err)
+		defer done()
+		out, err = a.runDebugCommand(event.DebugCommandInflight, logCtx)
+		require.NoError(t, err)
+		assert.Contains(t, string(out), "KIND  ID    AGE")
+		assert.Contains(t, string(out), "container=app,pod=argocd/app-1")
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		a := newAgent(t)
+		require.NoError(t, WithClusterResourceAllowRules([]string{"nodes", "apiextensions.k8s.io/customresourcedefinitions"})(a))
+		out, err := a.runDebugCommand(event.DebugCommandConfig, logCtx)
+		require.NoError(t, err)
+		assert.Contains(t, string(out), "namespace:               argocd\n")
+		assert.Contains(t, string(out), "resourceProxy:           true\n")
+		assert.Contains(t, string(out), "clusterResourceAllow:    nodes,apiextensions.k8s.io/customresourcedefinitions\n")
+	})
+
+	t.Run("Resync", func(t *testing.T) {
+		a := newAgent(t)
+		a.resyncedOnStart = true
+		_, err := a.runDebugCommand(event.DebugCommandResync, logCtx)
+		require.NoError(t, err)
+		ev, _ := a.queues.SendQ(defaultQueueName).Get()
+		assert.Equal(t, event.EventRequestResourceResync.String(), ev.Type())
+		assert.True(t, a.resyncedOnStart)
+	})
+
+	t.Run("Unknown command", func(t *testing.T) {
+		_, err := newAgent(t).runDebugCommand("rm -rf /", logCtx)
+		assert.ErrorContains(t, err, `unknown command "rm -rf /"`)
+	})
+}
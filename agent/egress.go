@@ -0,0 +1,88 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/netpol"
+)
+
+const defaultRedisPort = 6379
+
+// WithEgressEndpoint adds an endpoint the agent connects to, which the agent
+// doesn't know about itself, e.g. a tracing collector, to the endpoints it
+// reports. address is a URL or a host:port pair.
+func WithEgressEndpoint(name, address string) AgentOption {
+	return func(a *Agent) error {
+		ep, err := netpol.ParseEndpoint(name, address, 0)
+		if err != nil {
+			return err
+		}
+		a.egressEndpoints = append(a.egressEndpoints, ep)
+		return nil
+	}
+}
+
+// EgressEndpoints returns the network endpoints the agent connects to
+func (a *Agent) EgressEndpoints() []netpol.Endpoint {
+	var endpoints []netpol.Endpoint
+	add := func(name, address string, defaultPort int) {
+		ep, err := netpol.ParseEndpoint(name, address, defaultPort)
+		if err != nil {
+			log().WithError(err).Debug("Not reporting egress endpoint")
+			return
+		}
+		endpoints = append(endpoints, ep)
+	}
+	if a.remote != nil && a.remote.Hostname() != "" {
+		endpoints = append(endpoints, netpol.Endpoint{Name: "principal", Host: a.remote.Hostname(), Port: a.remote.Port()})
+	}
+	if a.redisProxyMsgHandler != nil && a.redisProxyMsgHandler.redisAddress != "" {
+		add("redis", a.redisProxyMsgHandler.redisAddress, defaultRedisPort)
+	}
+	if a.kubeClient != nil && a.kubeClient.RestConfig != nil && a.kubeClient.RestConfig.Host != "" {
+		add("kubernetes-api", a.kubeClient.RestConfig.Host, 443)
+	}
+	for _, conn := range a.pluginConns {
+		// Plugins listening on unix sockets need no network access
+		if target := conn.Target(); !strings.HasPrefix(target, "unix:") {
+			add("plugin", strings.TrimPrefix(target, "dns:///"), 0)
+		}
+	}
+	return append(endpoints, a.egressEndpoints...)
+}
+
+// egressHandler reports the network endpoints the agent connects to, along
+// with the addresses they resolve to from the agent's pod
+func (a *Agent) egressHandler(w http.ResponseWriter, r *http.Request) {
+	report := &netpol.Report{Namespace: a.namespace, Endpoints: a.EgressEndpoints()}
+	netpol.Resolve(r.Context(), report.Endpoints)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// logEgressEndpoints logs the network endpoints the agent connects to
+func (a *Agent) logEgressEndpoints() {
+	var eps []string
+	for _, ep := range a.EgressEndpoints() {
+		eps = append(eps, ep.Name+"="+net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port)))
+	}
+	log().Infof("Agent connects to: %s", strings.Join(eps, ", "))
+}
@@ -0,0 +1,55 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/netpol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func Test_EgressEndpoints(t *testing.T) {
+	a, kubec := newAgent(t)
+	kubec.RestConfig = &rest.Config{Host: "https://10.96.0.1:443"}
+	require.NoError(t, WithEgressEndpoint("otlp", "otel-collector.monitoring.svc:4317")(a))
+	assert.Error(t, WithEgressEndpoint("otlp", "otel-collector")(a))
+
+	endpoints := a.EgressEndpoints()
+	names := map[string]netpol.Endpoint{}
+	for _, ep := range endpoints {
+		names[ep.Name] = ep
+	}
+	assert.Equal(t, netpol.Endpoint{Name: "principal", Host: "127.0.0.1", Port: 8080}, names["principal"])
+	assert.Equal(t, netpol.Endpoint{Name: "kubernetes-api", Host: "10.96.0.1", Port: 443}, names["kubernetes-api"])
+	assert.Equal(t, netpol.Endpoint{Name: "otlp", Host: "otel-collector.monitoring.svc", Port: 4317}, names["otlp"])
+
+	t.Run("Report resolves endpoints", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.egressHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/egress", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		report := &netpol.Report{}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(report))
+		assert.Equal(t, "argocd", report.Namespace)
+		require.NotEmpty(t, report.Endpoints)
+		assert.Equal(t, "principal", report.Endpoints[0].Name)
+		assert.Equal(t, []string{"127.0.0.1"}, report.Endpoints[0].Addresses)
+	})
+}
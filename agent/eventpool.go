@@ -0,0 +1,195 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+)
+
+// defaultEventWorkers is the default number of workers processing incoming
+// events
+const defaultEventWorkers = 1
+
+// WithEventWorkers sets the number of workers processing the events received
+// from the principal. Events of the same resource are still processed in the
+// order they were received. With a single worker, all events are processed
+// one after the other.
+func WithEventWorkers(workers int) AgentOption {
+	return func(a *Agent) error {
+		if workers < 1 {
+			return fmt.Errorf("number of event workers must be at least 1")
+		}
+		a.options.eventWorkers = workers
+		return nil
+	}
+}
+
+// eventPool runs the processing of events on a fixed number of workers.
+// Tasks with the same ordering key run one after the other, in the order they
+// were submitted, while tasks with different keys run concurrently.
+type eventPool struct {
+	work chan eventTask
+
+	mu sync.Mutex
+	// active holds the keys of the tasks being run, along with the tasks of
+	// the same key waiting for them
+	active map[string][]eventTask
+	// idle is closed once no key is active anymore. It is nil if nobody
+	// waits for it.
+	idle chan struct{}
+}
+
+type eventTask struct {
+	key string
+	run func()
+}
+
+func newEventPool(workers int) *eventPool {
+	return &eventPool{
+		work:   make(chan eventTask, workers),
+		active: make(map[string][]eventTask),
+	}
+}
+
+// start starts the workers of the pool, which run until ctx is done
+func (p *eventPool) start(ctx context.Context, workers int) {
+	for range workers {
+		go p.worker(ctx)
+	}
+}
+
+// barrierKey is the ordering key of tasks that must be ordered with the
+// tasks of all other keys.
+const barrierKey = ""
+
+// submit schedules run with the given ordering key. It blocks while all
+// workers are busy, unless a task with the same key is running, in which
+// case run is queued to run on the same worker after it.
+//
+// Tasks with barrierKey run on the caller's goroutine, once all tasks
+// submitted before them have finished, and submit returns after run.
+func (p *eventPool) submit(ctx context.Context, key string, run func()) error {
+	if key == barrierKey {
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+		run()
+		return nil
+	}
+	t := eventTask{key: key, run: run}
+	p.mu.Lock()
+	if waiting, ok := p.active[key]; ok {
+		p.active[key] = append(waiting, t)
+		p.mu.Unlock()
+		return nil
+	}
+	p.active[key] = nil
+	p.mu.Unlock()
+	select {
+	case p.work <- t:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.deactivate(key)
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// wait blocks until no task is running or waiting anymore, or until ctx is
+// done.
+func (p *eventPool) wait(ctx context.Context) error {
+	p.mu.Lock()
+	if len(p.active) == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.idle == nil {
+		p.idle = make(chan struct{})
+	}
+	idle := p.idle
+	p.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deactivate removes key from the active keys, and notifies the waiters once
+// none is left. Caller must hold p.mu.
+func (p *eventPool) deactivate(key string) {
+	delete(p.active, key)
+	if len(p.active) == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+}
+
+func (p *eventPool) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-p.work:
+			for ok := true; ok; t, ok = p.next(t.key) {
+				t.run()
+			}
+		}
+	}
+}
+
+// next returns the task waiting for the one with key to finish. If there is
+// none, key is no longer active.
+func (p *eventPool) next(key string) (eventTask, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waiting := p.active[key]
+	if len(waiting) == 0 {
+		p.deactivate(key)
+		return eventTask{}, false
+	}
+	p.active[key] = waiting[1:]
+	return waiting[0], true
+}
+
+// orderingKey returns the key of events that must be processed in the order
+// they were received. Events of the same resource, e.g. all events of an
+// Application, share a key regardless of the resource's UID, so that the
+// deletion and re-creation of a resource stay in order. Requests of clients
+// are independent of each other. Resyncs concern all resources, so they are
+// ordered with all other events. All other events are ordered by their
+// target.
+func orderingKey(ev *event.Event) string {
+	target := ev.Target()
+	switch target {
+	case event.TargetResourceResync:
+		return barrierKey
+	case event.TargetApplication, event.TargetAppProject, event.TargetRepository, event.TargetGPGKey:
+		// Resource IDs are <name>_<uid>, and names can't contain underscores
+		name, _, _ := strings.Cut(ev.ResourceID(), "_")
+		return target.String() + "/" + name
+	}
+	if event.IsInteractive(ev.CloudEvent()) && ev.ResourceID() != "" {
+		return target.String() + "/" + ev.ResourceID()
+	}
+	return target.String()
+}
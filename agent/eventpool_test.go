@@ -0,0 +1,146 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+func Test_eventPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newEventPool(2)
+	p.start(ctx, 2)
+
+	t.Run("Slow task doesn't block other keys", func(t *testing.T) {
+		blocked := make(chan struct{})
+		defer close(blocked)
+		require.NoError(t, p.submit(ctx, "slow", func() { <-blocked }))
+		done := make(chan struct{})
+		require.NoError(t, p.submit(ctx, "other", func() { close(done) }))
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("task was blocked by a task with another key")
+		}
+	})
+
+	t.Run("Tasks with the same key run in order", func(t *testing.T) {
+		var mu sync.Mutex
+		var order []int
+		var wg sync.WaitGroup
+		for i := range 50 {
+			wg.Add(1)
+			require.NoError(t, p.submit(ctx, "app", func() {
+				defer wg.Done()
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+			}))
+		}
+		wg.Wait()
+		for i := range order {
+			assert.Equal(t, i, order[i])
+		}
+	})
+
+	t.Run("Resync requests are ordered with application events", func(t *testing.T) {
+		evs := event.NewEventSource("principal")
+		appEvent := func(name string) *event.Event {
+			a := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "argocd"}}
+			return event.New(evs.ApplicationEvent(event.SpecUpdate, a), event.TargetApplication)
+		}
+		ce, err := evs.RequestSyncedResourceListEvent([]byte("checksum"))
+		require.NoError(t, err)
+		resync := event.New(ce, event.TargetResourceResync)
+
+		var mu sync.Mutex
+		var order []string
+		record := func(name string) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+		blocked := make(chan struct{})
+		require.NoError(t, p.submit(ctx, orderingKey(appEvent("app-1")), func() {
+			<-blocked
+			record("app-1")
+		}))
+		submitted := make(chan error)
+		go func() {
+			submitted <- p.submit(ctx, orderingKey(resync), func() { record("resync") })
+		}()
+		select {
+		case <-submitted:
+			t.Fatal("resync request ran before an earlier application event")
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(blocked)
+		require.NoError(t, <-submitted)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		require.NoError(t, p.submit(ctx, orderingKey(appEvent("app-2")), func() {
+			defer wg.Done()
+			record("app-2")
+		}))
+		wg.Wait()
+		assert.Equal(t, []string{"app-1", "resync", "app-2"}, order)
+	})
+
+	t.Run("Submitting to a busy pool is aborted when the context is done", func(t *testing.T) {
+		p := newEventPool(1)
+		require.NoError(t, p.submit(context.Background(), "a", func() {}))
+		stopped, stop := context.WithCancel(context.Background())
+		stop()
+		assert.ErrorIs(t, p.submit(stopped, "b", func() {}), context.Canceled)
+		// The aborted key is no longer active
+		assert.NotContains(t, p.active, "b")
+	})
+}
+
+func Test_orderingKey(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	app := func(uid string) *event.Event {
+		a := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "argocd", UID: ktypes.UID(uid)}}
+		return event.New(evs.ApplicationEvent(event.Create, a), event.TargetApplication)
+	}
+	logRequest := func() *event.Event {
+		ce, err := evs.NewLogRequestEvent("argocd", "pod", "GET", nil, 0)
+		require.NoError(t, err)
+		return event.New(ce, event.TargetContainerLog)
+	}
+
+	// Re-created resources keep their order
+	assert.Equal(t, orderingKey(app("1")), orderingKey(app("2")))
+	// Requests are independent
+	assert.NotEqual(t, orderingKey(logRequest()), orderingKey(logRequest()))
+	assert.NotEqual(t, orderingKey(app("1")), orderingKey(logRequest()))
+	resync, err := evs.RequestResourceResyncEvent()
+	require.NoError(t, err)
+	// Resyncs are ordered with all events
+	assert.Equal(t, barrierKey, orderingKey(event.New(resync, event.TargetResourceResync)))
+	assert.Equal(t, "heartbeat", orderingKey(event.New(evs.HeartbeatEvent(event.Ping), event.TargetHeartbeat)))
+}
@@ -0,0 +1,65 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSlowEventHandlerThreshold is the default time after which the
+// handler of an event is reported as slow
+const defaultSlowEventHandlerThreshold = time.Second
+
+// WithSlowEventHandlerThreshold sets the time after which the handler of an
+// incoming event is logged as slow, because it blocks the processing of the
+// events following it. A threshold of 0 disables logging slow handlers.
+func WithSlowEventHandlerThreshold(threshold time.Duration) AgentOption {
+	return func(a *Agent) error {
+		if threshold < 0 {
+			return fmt.Errorf("slow event handler threshold must not be negative")
+		}
+		a.options.slowEventHandlerThreshold = threshold
+		return nil
+	}
+}
+
+// observeEventHandler records how long the handler of ev took, and reports
+// it if it took longer than the slow event handler threshold
+func (a *Agent) observeEventHandler(ev *event.Event, took time.Duration) {
+	target, typ := ev.Target().String(), ev.Type().String()
+	if a.metrics != nil {
+		a.metrics.EventHandlerDuration.WithLabelValues(target, typ).Observe(took.Seconds())
+	}
+	threshold := a.options.slowEventHandlerThreshold
+	if threshold <= 0 || took < threshold {
+		return
+	}
+	if a.metrics != nil {
+		a.metrics.SlowEventHandlers.WithLabelValues(target, typ).Inc()
+	}
+	a.logGrpcEvent().WithFields(logrus.Fields{
+		"method":       "processIncomingEvent",
+		"event_target": target,
+		"event_type":   typ,
+		"event_id":     ev.EventID(),
+		"resource_id":  ev.ResourceID(),
+		"took":         took.String(),
+		"threshold":    threshold.String(),
+	}).Warn("Slow event handler blocked the processing of incoming events")
+}
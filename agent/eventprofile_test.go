@@ -0,0 +1,53 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_observeEventHandler(t *testing.T) {
+	a, _ := newAgent(t)
+	a.metrics = metrics.NewAgentMetricsWith(prometheus.NewRegistry())
+	require.Equal(t, defaultSlowEventHandlerThreshold, a.options.slowEventHandlerThreshold)
+	ce, err := event.NewEventSource("principal").NewLogRequestEvent("argocd", "pod", "GET", nil, 0)
+	require.NoError(t, err)
+	ev := event.New(ce, event.TargetContainerLog)
+	slow := func() float64 {
+		return testutil.ToFloat64(a.metrics.SlowEventHandlers.WithLabelValues(ev.Target().String(), ev.Type().String()))
+	}
+
+	a.observeEventHandler(ev, 10*time.Millisecond)
+	assert.Equal(t, 1, testutil.CollectAndCount(a.metrics.EventHandlerDuration))
+	assert.Zero(t, slow())
+
+	a.observeEventHandler(ev, 2*time.Second)
+	assert.Equal(t, float64(1), slow())
+
+	t.Run("Threshold of 0 disables reporting slow handlers", func(t *testing.T) {
+		require.NoError(t, WithSlowEventHandlerThreshold(0)(a))
+		a.observeEventHandler(ev, time.Minute)
+		assert.Equal(t, float64(1), slow())
+		assert.Error(t, WithSlowEventHandlerThreshold(-time.Second)(a))
+	})
+}
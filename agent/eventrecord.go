@@ -0,0 +1,37 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// WithEventRecorder makes the agent record all events it exchanges with the
+// principal on the event stream. The recorder is not closed by the agent.
+func WithEventRecorder(r *eventrecord.Recorder) AgentOption {
+	return func(a *Agent) error {
+		a.recorder = r
+		return nil
+	}
+}
+
+// ReplayEvent processes ev as if it had been received from the principal.
+// It is used to reproduce problems from recorded event streams. Unlike
+// received events, replayed events are not acknowledged.
+func (a *Agent) ReplayEvent(ev *cloudevents.Event) error {
+	return a.processWithPlugins(event.New(ev, event.Target(ev)))
+}
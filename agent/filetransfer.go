@@ -0,0 +1,191 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fileTransferChunkSize is the size of the chunks sent over the FileTransfer
+// stream
+const fileTransferChunkSize = 64 * 1024
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// fileUpload describes a file to be sent to the principal in response to a
+// request identified by requestUUID.
+type fileUpload struct {
+	requestUUID string
+	name        string
+	contentType string
+	src         io.ReadSeeker
+	size        int64
+}
+
+// uploadFile sends a file to the principal over the FileTransfer service.
+func (a *Agent) uploadFile(ctx context.Context, f *fileUpload, logCtx *logrus.Entry) (*filetransferapi.FileTransferResponse, error) {
+	resp, err := uploadFile(ctx, a.fileTransferClient, f, logCtx)
+	if code := status.Code(err); code == codes.Unauthenticated || code == codes.PermissionDenied {
+		a.SetConnected(false)
+	}
+	return resp, err
+}
+
+func (a *Agent) fileTransferClient() (filetransferapi.FileTransferServiceClient, error) {
+	conn := a.remote.Conn()
+	if conn == nil {
+		return nil, status.Error(codes.Unavailable, "gRPC connection is nil")
+	}
+	return filetransferapi.NewFileTransferServiceClient(conn), nil
+}
+
+// uploadFile sends a file using a client obtained from newClient. Every chunk
+// carries a CRC-32C checksum and the final chunk the SHA-256 digest of the
+// whole file, which the principal verifies. If the stream is interrupted, the
+// upload is resumed at the offset reported by the principal.
+func uploadFile(ctx context.Context, newClient func() (filetransferapi.FileTransferServiceClient, error), f *fileUpload, logCtx *logrus.Entry) (*filetransferapi.FileTransferResponse, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f.src); err != nil {
+		return nil, fmt.Errorf("could not checksum file: %w", err)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 200 * time.Millisecond
+	b.MaxInterval = 5 * time.Second
+	b.MaxElapsedTime = time.Minute
+	bo := backoff.WithContext(b, ctx)
+
+	resume := false
+	for {
+		var resp *filetransferapi.FileTransferResponse
+		client, err := newClient()
+		if err == nil {
+			resp, err = uploadFileAttempt(ctx, client, f, digest, resume, logCtx)
+		}
+		if err == nil {
+			return resp, nil
+		}
+		switch status.Code(err) {
+		case codes.Canceled, codes.NotFound, codes.ResourceExhausted, codes.InvalidArgument,
+			codes.Unauthenticated, codes.PermissionDenied:
+			// Retrying won't help, e.g. because the principal gave up on the transfer
+			return nil, err
+		}
+		d := bo.NextBackOff()
+		if d == backoff.Stop {
+			return nil, fmt.Errorf("giving up on file transfer: %w", err)
+		}
+		logCtx.WithError(err).Warnf("File transfer interrupted, resuming in %v", d)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d):
+		}
+		resume = true
+	}
+}
+
+// uploadFileAttempt sends the file on a new stream. When resuming, it starts
+// at the offset the principal has received so far.
+func uploadFileAttempt(ctx context.Context, client filetransferapi.FileTransferServiceClient, f *fileUpload, digest string, resume bool, logCtx *logrus.Entry) (*filetransferapi.FileTransferResponse, error) {
+	var offset int64
+	if resume {
+		st, err := client.Status(ctx, &filetransferapi.FileTransferStatusRequest{RequestUuid: f.requestUUID})
+		if err != nil {
+			return nil, err
+		}
+		offset = st.GetBytesReceived()
+		logCtx.WithField("offset", offset).Info("Resuming file transfer")
+	}
+	if _, err := f.src.Seek(offset, io.SeekStart); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not seek to offset %d: %v", offset, err)
+	}
+
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, fileTransferChunkSize)
+	first := true
+	for {
+		n, rerr := io.ReadFull(f.src, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			_, _ = stream.CloseAndRecv()
+			return nil, status.Errorf(codes.Internal, "could not read file: %v", rerr)
+		}
+		eof := rerr != nil
+		crc := crc32.Checksum(buf[:n], crc32cTable)
+		chunk := &filetransferapi.FileChunk{
+			RequestUuid: f.requestUUID,
+			Offset:      offset,
+			Data:        buf[:n],
+			Crc32C:      &crc,
+			Eof:         eof,
+		}
+		if first {
+			chunk.Name = f.name
+			chunk.ContentType = f.contentType
+			chunk.TotalSize = f.size
+			first = false
+		}
+		if eof {
+			chunk.Sha256 = digest
+		}
+		if err := stream.Send(chunk); err != nil {
+			// The actual error is returned by CloseAndRecv
+			if _, rerr := stream.CloseAndRecv(); rerr != nil {
+				err = rerr
+			}
+			return nil, err
+		}
+		offset += int64(n)
+		if eof {
+			return stream.CloseAndRecv()
+		}
+	}
+}
+
+// sendFileTransferError tells the principal that the requested file could
+// not be produced.
+func (a *Agent) sendFileTransferError(ctx context.Context, requestUUID string, cause error) error {
+	client, err := a.fileTransferClient()
+	if err != nil {
+		return err
+	}
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&filetransferapi.FileChunk{RequestUuid: requestUUID, Error: cause.Error()}); err != nil {
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	_, _ = stream.CloseAndRecv()
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeFileTransferClient keeps received data in memory. The stream breaks
+// once failAfter bytes have been received, if set.
+type fakeFileTransferClient struct {
+	received  bytes.Buffer
+	failAfter int
+	chunks    []*filetransferapi.FileChunk
+}
+
+func (c *fakeFileTransferClient) Upload(ctx context.Context, opts ...grpc.CallOption) (filetransferapi.FileTransferService_UploadClient, error) {
+	return &fakeUploadClient{c: c}, nil
+}
+
+func (c *fakeFileTransferClient) Status(ctx context.Context, in *filetransferapi.FileTransferStatusRequest, opts ...grpc.CallOption) (*filetransferapi.FileTransferStatus, error) {
+	return &filetransferapi.FileTransferStatus{RequestUuid: in.GetRequestUuid(), BytesReceived: int64(c.received.Len())}, nil
+}
+
+type fakeUploadClient struct {
+	grpc.ClientStream
+	c   *fakeFileTransferClient
+	err error
+	sum string
+}
+
+func (u *fakeUploadClient) Send(chunk *filetransferapi.FileChunk) error {
+	c := u.c
+	c.chunks = append(c.chunks, chunk)
+	if chunk.GetOffset() != int64(c.received.Len()) {
+		u.err = status.Error(codes.OutOfRange, "unexpected offset")
+		return u.err
+	}
+	if crc32.Checksum(chunk.GetData(), crc32cTable) != chunk.GetCrc32C() {
+		u.err = status.Error(codes.DataLoss, "checksum mismatch")
+		return u.err
+	}
+	if c.failAfter > 0 && c.received.Len() >= c.failAfter {
+		c.failAfter = 0
+		u.err = status.Error(codes.Unavailable, "connection reset")
+		return u.err
+	}
+	c.received.Write(chunk.GetData())
+	u.sum = chunk.GetSha256()
+	return nil
+}
+
+func (u *fakeUploadClient) CloseAndRecv() (*filetransferapi.FileTransferResponse, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	return &filetransferapi.FileTransferResponse{BytesReceived: int64(u.c.received.Len()), Sha256: u.sum}, nil
+}
+
+func Test_uploadFile(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), fileTransferChunkSize/4)
+	sum := sha256.Sum256(data)
+	newUpload := func() *fileUpload {
+		return &fileUpload{
+			requestUUID: "uuid",
+			name:        "file",
+			src:         bytes.NewReader(data),
+			size:        int64(len(data)),
+		}
+	}
+	logCtx := logrus.NewEntry(logrus.New())
+
+	t.Run("file is sent in chunks", func(t *testing.T) {
+		c := &fakeFileTransferClient{}
+		resp, err := uploadFile(context.Background(), func() (filetransferapi.FileTransferServiceClient, error) { return c, nil }, newUpload(), logCtx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), resp.GetBytesReceived())
+		assert.Equal(t, hex.EncodeToString(sum[:]), resp.GetSha256())
+		assert.Equal(t, data, c.received.Bytes())
+		require.Len(t, c.chunks, 5)
+		assert.Equal(t, "file", c.chunks[0].GetName())
+		assert.Equal(t, int64(len(data)), c.chunks[0].GetTotalSize())
+		assert.True(t, c.chunks[4].GetEof())
+	})
+
+	t.Run("interrupted upload is resumed", func(t *testing.T) {
+		c := &fakeFileTransferClient{failAfter: 2 * fileTransferChunkSize}
+		resp, err := uploadFile(context.Background(), func() (filetransferapi.FileTransferServiceClient, error) { return c, nil }, newUpload(), logCtx)
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(sum[:]), resp.GetSha256())
+		assert.Equal(t, data, c.received.Bytes())
+		// The chunk that failed is sent again at the same offset
+		assert.Equal(t, int64(2*fileTransferChunkSize), c.chunks[3].GetOffset())
+		assert.Equal(t, int64(2*fileTransferChunkSize), c.chunks[2].GetOffset())
+	})
+
+	t.Run("fatal errors are not retried", func(t *testing.T) {
+		calls := 0
+		_, err := uploadFile(context.Background(), func() (filetransferapi.FileTransferServiceClient, error) {
+			calls++
+			return nil, status.Error(codes.NotFound, "unknown request id")
+		}, newUpload(), logCtx)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, 1, calls)
+	})
+}
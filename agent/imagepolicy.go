@@ -0,0 +1,396 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// ImageVerifier verifies the signature of a container image. It is the hook
+// through which the agent's image policy is enforced.
+type ImageVerifier interface {
+	// VerifyImage returns an error if the signature of image cannot be
+	// verified.
+	VerifyImage(ctx context.Context, image string) error
+}
+
+// ImagePolicy configures the verification of container images in manifests
+// the agent applies to its cluster.
+type ImagePolicy struct {
+	// Verifier verifies the signatures of images
+	Verifier ImageVerifier
+	// Enforce refuses manifests with images that fail verification. If
+	// false, violations are logged only.
+	Enforce bool
+	// LocalManifestsOnly accepts sync operations of manifests that Argo CD
+	// renders from Git, Helm or other sources on the agent's cluster. The
+	// agent cannot verify the images of these manifests, so by default such
+	// sync operations are treated as violating the policy.
+	LocalManifestsOnly bool
+
+	// cache holds the results of verifying images
+	cache *imageVerifyCache
+}
+
+const (
+	// defaultImageVerifyTimeout is the time the verification of all images
+	// of a manifest may take.
+	defaultImageVerifyTimeout = 2 * time.Minute
+	// imageVerifyConcurrency is the number of images verified at once
+	imageVerifyConcurrency = 4
+	// imageVerifyCacheTTL is the time the result of verifying an image
+	// referenced by tag is cached. Images referenced by digest are cached
+	// until evicted, since the digest pins their content.
+	imageVerifyCacheTTL = 10 * time.Minute
+	// imageVerifyFailureTTL is the time failed verifications are cached, so
+	// that transient failures are retried soon
+	imageVerifyFailureTTL = time.Minute
+	// maxImageVerifyCacheEntries is the number of images whose results are
+	// cached
+	maxImageVerifyCacheEntries = 4096
+)
+
+// errRenderedManifests is the violation of sync operations whose manifests
+// are rendered by Argo CD, see ImagePolicy.LocalManifestsOnly.
+var errRenderedManifests = errors.New("manifests rendered by Argo CD from the application's sources cannot be verified by the agent")
+
+// imageVerifyCache caches the results of verifying images, so that the
+// images of an Application are not verified again on every event.
+type imageVerifyCache struct {
+	mu      sync.Mutex
+	entries map[string]imageVerifyResult
+}
+
+type imageVerifyResult struct {
+	err     error
+	expires time.Time
+}
+
+func newImageVerifyCache() *imageVerifyCache {
+	return &imageVerifyCache{entries: make(map[string]imageVerifyResult)}
+}
+
+// get returns the cached result of verifying image, and whether there is one
+func (c *imageVerifyCache) get(image string, now time.Time) (error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.entries[image]
+	if !ok || (!r.expires.IsZero() && now.After(r.expires)) {
+		return nil, false
+	}
+	return r.err, true
+}
+
+// put caches the result of verifying image
+func (c *imageVerifyCache) put(image string, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxImageVerifyCacheEntries {
+		for k, r := range c.entries {
+			if !r.expires.IsZero() && now.After(r.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxImageVerifyCacheEntries {
+			clear(c.entries)
+		}
+	}
+	r := imageVerifyResult{err: err}
+	switch {
+	case err != nil:
+		r.expires = now.Add(imageVerifyFailureTTL)
+	case !strings.Contains(image, "@"):
+		r.expires = now.Add(imageVerifyCacheTTL)
+	}
+	c.entries[image] = r
+}
+
+// CosignVerifier verifies image signatures with the cosign binary against a
+// public key.
+type CosignVerifier struct {
+	// Binary is the path of the cosign binary. If empty, cosign is looked up
+	// in PATH.
+	Binary string
+	// KeyPath is the path of the public key, or a key reference understood
+	// by cosign, e.g. k8s://namespace/secret.
+	KeyPath string
+}
+
+// NewCosignVerifier returns a CosignVerifier for the public key at keyPath.
+// It fails if the cosign binary, looked up in PATH if binary is empty, cannot
+// be found, so that a missing binary is detected when the agent starts rather
+// than when the first image is verified.
+func NewCosignVerifier(binary, keyPath string) (*CosignVerifier, error) {
+	if binary == "" {
+		binary = "cosign"
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("cosign binary not found: %w", err)
+	}
+	return &CosignVerifier{Binary: path, KeyPath: keyPath}, nil
+}
+
+// VerifyImage runs cosign verify for image
+func (v *CosignVerifier) VerifyImage(ctx context.Context, image string) error {
+	binary := v.Binary
+	if binary == "" {
+		binary = "cosign"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "verify", "--key", v.KeyPath, image)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndex(msg, "\n"); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// WithImagePolicy verifies the container images in manifests before the
+// agent applies them: in sync operations received from the principal, and in
+// resources created or patched through the resource proxy.
+func WithImagePolicy(policy ImagePolicy) AgentOption {
+	return func(a *Agent) error {
+		if policy.Verifier == nil {
+			return errors.New("image policy needs a verifier")
+		}
+		policy.cache = newImageVerifyCache()
+		a.options.imagePolicy = &policy
+		return nil
+	}
+}
+
+// imageViolation is an image that failed verification
+type imageViolation struct {
+	image string
+	err   error
+}
+
+func (v imageViolation) String() string {
+	return fmt.Sprintf("%s: %v", v.image, v.err)
+}
+
+// imagePolicyError is returned for manifests with images that failed
+// verification.
+type imagePolicyError struct {
+	violations []imageViolation
+}
+
+func (e *imagePolicyError) Error() string {
+	s := make([]string, len(e.violations))
+	for i, v := range e.violations {
+		s[i] = v.String()
+	}
+	return "image signature verification failed for " + strings.Join(s, "; ")
+}
+
+// verifyImages verifies images according to the agent's image policy. It
+// returns an *imagePolicyError if the policy is enforced and images failed
+// verification. Images are verified concurrently, and results are cached.
+func (a *Agent) verifyImages(ctx context.Context, images []string) error {
+	policy := a.options.imagePolicy
+	if policy == nil || len(images) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultImageVerifyTimeout)
+	defer cancel()
+	errs := make([]error, len(images))
+	sem := make(chan struct{}, imageVerifyConcurrency)
+	var wg sync.WaitGroup
+	for i, image := range images {
+		if err, ok := policy.cache.get(image, time.Now()); ok {
+			errs[i] = err
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = policy.Verifier.VerifyImage(ctx, image)
+			// Verifications cut short by the deadline say nothing about
+			// the image
+			if ctx.Err() == nil {
+				policy.cache.put(image, errs[i], time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+	violations := []imageViolation{}
+	for i, image := range images {
+		if errs[i] != nil {
+			violations = append(violations, imageViolation{image: image, err: errs[i]})
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return a.imagePolicyViolated(&imagePolicyError{violations: violations})
+}
+
+// imagePolicyViolated returns err if the image policy is enforced, and logs
+// it otherwise.
+func (a *Agent) imagePolicyViolated(err error) error {
+	if !a.options.imagePolicy.Enforce {
+		log().WithError(err).Warn("Image policy violated, not enforcing")
+		return nil
+	}
+	return err
+}
+
+// verifyResourceImages verifies the images in the body of a resource proxy
+// request that creates or patches a resource. Violations are returned as
+// Forbidden errors.
+func (a *Agent) verifyResourceImages(ctx context.Context, gvr schema.GroupVersionResource, name string, body []byte) error {
+	if a.options.imagePolicy == nil || len(body) == 0 {
+		return nil
+	}
+	var obj any
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return err
+	}
+	if err := a.verifyImages(ctx, imagesInObject(obj)); err != nil {
+		return apierrors.NewForbidden(gvr.GroupResource(), name, err)
+	}
+	return nil
+}
+
+// verifySyncOperation verifies the images of a sync operation received from
+// the principal. The images known to the agent are those of the local
+// manifests of the operation and the image overrides of the Application's
+// Kustomize sources. Manifests rendered by Argo CD on the agent's cluster
+// are not visible to the agent, so operations without local manifests
+// violate the policy unless it covers local manifests only.
+func (a *Agent) verifySyncOperation(ctx context.Context, app *v1alpha1.Application) error {
+	policy := a.options.imagePolicy
+	if policy == nil || app.Operation == nil || app.Operation.Sync == nil {
+		return nil
+	}
+	if len(app.Operation.Sync.Manifests) == 0 && !policy.LocalManifestsOnly {
+		if err := a.imagePolicyViolated(errRenderedManifests); err != nil {
+			return err
+		}
+	}
+	images, err := syncOperationImages(app)
+	if err != nil {
+		return err
+	}
+	return a.verifyImages(ctx, images)
+}
+
+// rejectSyncOperation records the failure of app's operation to pass the
+// image policy. The failure is reported to the principal with the status of
+// the Application.
+func (a *Agent) rejectSyncOperation(app *v1alpha1.Application, cause error) error {
+	log().WithField("app", app.QualifiedName()).WithError(cause).Warn("Refusing sync operation")
+	_, err := a.appManager.RejectOperation(a.context, app, cause.Error())
+	return err
+}
+
+// syncOperationImages returns the images of app's sync operation that are
+// known to the agent, sorted and without duplicates.
+func syncOperationImages(app *v1alpha1.Application) ([]string, error) {
+	images := []string{}
+	for i, m := range app.Operation.Sync.Manifests {
+		var obj any
+		if err := yaml.Unmarshal([]byte(m), &obj); err != nil {
+			return nil, fmt.Errorf("could not parse manifest %d of sync operation: %w", i, err)
+		}
+		images = append(images, imagesInObject(obj)...)
+	}
+	for _, src := range app.Spec.GetSources() {
+		if src.Kustomize == nil {
+			continue
+		}
+		for _, image := range src.Kustomize.Images {
+			images = append(images, kustomizeImage(string(image)))
+		}
+	}
+	return uniqueSorted(images), nil
+}
+
+// kustomizeImage returns the image a Kustomize image override refers to,
+// i.e. the part after the optional old_image_name=.
+func kustomizeImage(image string) string {
+	if i := strings.Index(image, "="); i >= 0 {
+		return image[i+1:]
+	}
+	return image
+}
+
+// containerListKeys are the keys of pod specs holding containers
+var containerListKeys = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// imagesInObject returns the images of all containers in obj, which is a
+// decoded JSON manifest or patch. Containers are found at any depth, so that
+// images of pods, pod templates and custom resources embedding pod specs are
+// all returned.
+func imagesInObject(obj any) []string {
+	images := []string{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch o := v.(type) {
+		case map[string]any:
+			for _, key := range containerListKeys {
+				containers, ok := o[key].([]any)
+				if !ok {
+					continue
+				}
+				for _, c := range containers {
+					if cm, ok := c.(map[string]any); ok {
+						if image, ok := cm["image"].(string); ok && image != "" {
+							images = append(images, image)
+						}
+					}
+				}
+			}
+			for _, child := range o {
+				walk(child)
+			}
+		case []any:
+			for _, child := range o {
+				walk(child)
+			}
+		}
+	}
+	walk(obj)
+	return uniqueSorted(images)
+}
+
+func uniqueSorted(s []string) []string {
+	slices.Sort(s)
+	return slices.Compact(s)
+}
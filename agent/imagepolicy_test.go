@@ -0,0 +1,298 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	backend_mocks "github.com/argoproj-labs/argocd-agent/internal/backend/mocks"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/manager/application"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeImageVerifier accepts the images in signed only
+type fakeImageVerifier struct {
+	mu       sync.Mutex
+	signed   map[string]bool
+	verified []string
+}
+
+func (v *fakeImageVerifier) VerifyImage(_ context.Context, image string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.verified = append(v.verified, image)
+	if !v.signed[image] {
+		return errors.New("no matching signatures")
+	}
+	return nil
+}
+
+const deploymentManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: guestbook-ui
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.36
+      containers:
+      - name: guestbook-ui
+        image: quay.io/argoprojlabs/guestbook-ui:v1
+      - name: sidecar
+        image: busybox:1.36
+`
+
+func Test_ImagesInObject(t *testing.T) {
+	t.Run("Pod template", func(t *testing.T) {
+		var obj any
+		require.NoError(t, json.Unmarshal([]byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"nginx:1"},{"name":"b"}],"ephemeralContainers":[{"image":"debug:1"}]}}}}`), &obj))
+		assert.Equal(t, []string{"debug:1", "nginx:1"}, imagesInObject(obj))
+	})
+	t.Run("Custom resource embedding pod specs", func(t *testing.T) {
+		var obj any
+		require.NoError(t, json.Unmarshal([]byte(`{"spec":{"jobs":[{"template":{"spec":{"containers":[{"image":"job:1"}]}}},{"template":{"spec":{"containers":[{"image":"job:2"}]}}}]}}`), &obj))
+		assert.Equal(t, []string{"job:1", "job:2"}, imagesInObject(obj))
+	})
+	t.Run("No containers", func(t *testing.T) {
+		var obj any
+		require.NoError(t, json.Unmarshal([]byte(`{"data":{"containers":"none"}}`), &obj))
+		assert.Empty(t, imagesInObject(obj))
+	})
+}
+
+func Test_SyncOperationImages(t *testing.T) {
+	app := &v1alpha1.Application{
+		Spec: v1alpha1.ApplicationSpec{
+			Sources: v1alpha1.ApplicationSources{
+				{Kustomize: &v1alpha1.ApplicationSourceKustomize{Images: v1alpha1.KustomizeImages{"guestbook=quay.io/argoprojlabs/guestbook-ui:v2", "nginx:1.27"}}},
+				{Helm: &v1alpha1.ApplicationSourceHelm{}},
+			},
+		},
+		Operation: &v1alpha1.Operation{Sync: &v1alpha1.SyncOperation{Manifests: []string{deploymentManifest}}},
+	}
+	images, err := syncOperationImages(app)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"busybox:1.36", "nginx:1.27", "quay.io/argoprojlabs/guestbook-ui:v1", "quay.io/argoprojlabs/guestbook-ui:v2"}, images)
+
+	app.Operation.Sync.Manifests = []string{"{"}
+	_, err = syncOperationImages(app)
+	assert.ErrorContains(t, err, "could not parse manifest 0")
+}
+
+func Test_VerifyImages(t *testing.T) {
+	verifier := &fakeImageVerifier{signed: map[string]bool{"signed:1": true}}
+
+	t.Run("Disabled", func(t *testing.T) {
+		a := &Agent{}
+		assert.NoError(t, a.verifyImages(context.Background(), []string{"unsigned:1"}))
+	})
+	t.Run("Enforced", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: verifier, Enforce: true})(a))
+		assert.NoError(t, a.verifyImages(context.Background(), []string{"signed:1"}))
+		err := a.verifyImages(context.Background(), []string{"signed:1", "unsigned:1"})
+		assert.EqualError(t, err, "image signature verification failed for unsigned:1: no matching signatures")
+	})
+	t.Run("Warn only", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: verifier})(a))
+		assert.NoError(t, a.verifyImages(context.Background(), []string{"unsigned:1"}))
+	})
+	t.Run("Verifier is required", func(t *testing.T) {
+		assert.Error(t, WithImagePolicy(ImagePolicy{Enforce: true})(&Agent{}))
+	})
+	t.Run("Results are cached", func(t *testing.T) {
+		verifier := &fakeImageVerifier{signed: map[string]bool{"signed:1": true, "signed@sha256:abc": true}}
+		a := &Agent{}
+		require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: verifier, Enforce: true})(a))
+		images := []string{"signed:1", "signed@sha256:abc", "unsigned:1"}
+		for range 3 {
+			assert.Error(t, a.verifyImages(context.Background(), images))
+		}
+		assert.ElementsMatch(t, images, verifier.verified)
+	})
+}
+
+func Test_ImageVerifyCache(t *testing.T) {
+	c := newImageVerifyCache()
+	now := time.Now()
+	c.put("signed:1", nil, now)
+	c.put("signed@sha256:abc", nil, now)
+	c.put("unsigned:1", errors.New("no matching signatures"), now)
+
+	_, ok := c.get("signed:1", now.Add(imageVerifyCacheTTL-time.Second))
+	assert.True(t, ok)
+	_, ok = c.get("signed:1", now.Add(imageVerifyCacheTTL+time.Second))
+	assert.False(t, ok, "results for tags expire")
+	_, ok = c.get("signed@sha256:abc", now.Add(24*time.Hour))
+	assert.True(t, ok, "results for digests don't expire")
+	err, ok := c.get("unsigned:1", now.Add(imageVerifyFailureTTL-time.Second))
+	assert.True(t, ok)
+	assert.Error(t, err)
+	_, ok = c.get("unsigned:1", now.Add(imageVerifyFailureTTL+time.Second))
+	assert.False(t, ok, "failures are retried soon")
+}
+
+func Test_VerifySyncOperation(t *testing.T) {
+	verifier := &fakeImageVerifier{signed: map[string]bool{"nginx:1.27": true}}
+	rendered := &v1alpha1.Application{
+		Spec: v1alpha1.ApplicationSpec{
+			Source: &v1alpha1.ApplicationSource{Kustomize: &v1alpha1.ApplicationSourceKustomize{Images: v1alpha1.KustomizeImages{"nginx:1.27"}}},
+		},
+		Operation: &v1alpha1.Operation{Sync: &v1alpha1.SyncOperation{}},
+	}
+
+	t.Run("Rendered manifests violate the policy", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: verifier, Enforce: true})(a))
+		assert.ErrorIs(t, a.verifySyncOperation(context.Background(), rendered), errRenderedManifests)
+	})
+	t.Run("Rendered manifests are accepted if the policy covers local manifests only", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: verifier, Enforce: true, LocalManifestsOnly: true})(a))
+		assert.NoError(t, a.verifySyncOperation(context.Background(), rendered))
+	})
+	t.Run("Rendered manifests are logged in warn mode", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: verifier})(a))
+		assert.NoError(t, a.verifySyncOperation(context.Background(), rendered))
+	})
+}
+
+func Test_VerifyResourceImages(t *testing.T) {
+	a := &Agent{}
+	require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: &fakeImageVerifier{}, Enforce: true})(a))
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	err := a.verifyResourceImages(context.Background(), gvr, "guestbook-ui", []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"guestbook-ui","image":"unsigned:1"}]}}}}`))
+	require.Error(t, err)
+	assert.True(t, kerrors.IsForbidden(err))
+	assert.Equal(t, 403, event.HTTPStatusFromError(err))
+
+	assert.NoError(t, a.verifyResourceImages(context.Background(), gvr, "guestbook-ui", []byte(`{"metadata":{"labels":{"a":"b"}}}`)))
+	assert.NoError(t, a.verifyResourceImages(context.Background(), gvr, "guestbook-ui", nil))
+}
+
+func Test_CosignVerifier(t *testing.T) {
+	// A stand-in for cosign, which accepts images tagged signed
+	cosign := filepath.Join(t.TempDir(), "cosign")
+	script := `#!/bin/sh
+[ "$1" = verify ] && [ "$2" = --key ] && [ "$3" = /keys/cosign.pub ] || exit 2
+case "$4" in
+*:signed) exit 0 ;;
+esac
+echo "Error: no matching signatures" >&2
+echo "main.go:74: error during command execution: no matching signatures" >&2
+exit 1
+`
+	require.NoError(t, os.WriteFile(cosign, []byte(script), 0o755))
+
+	v := &CosignVerifier{Binary: cosign, KeyPath: "/keys/cosign.pub"}
+	assert.NoError(t, v.VerifyImage(context.Background(), "guestbook:signed"))
+	assert.EqualError(t, v.VerifyImage(context.Background(), "guestbook:unsigned"), "main.go:74: error during command execution: no matching signatures")
+
+	v.Binary = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, v.VerifyImage(context.Background(), "guestbook:signed"))
+}
+
+func Test_NewCosignVerifier(t *testing.T) {
+	cosign := filepath.Join(t.TempDir(), "cosign")
+	require.NoError(t, os.WriteFile(cosign, []byte("#!/bin/sh\n"), 0o755))
+
+	t.Run("Binary at path", func(t *testing.T) {
+		v, err := NewCosignVerifier(cosign, "/keys/cosign.pub")
+		require.NoError(t, err)
+		assert.Equal(t, cosign, v.Binary)
+		assert.Equal(t, "/keys/cosign.pub", v.KeyPath)
+	})
+	t.Run("Binary from PATH", func(t *testing.T) {
+		t.Setenv("PATH", filepath.Dir(cosign))
+		v, err := NewCosignVerifier("", "/keys/cosign.pub")
+		require.NoError(t, err)
+		assert.Equal(t, cosign, v.Binary)
+	})
+	t.Run("Missing binary", func(t *testing.T) {
+		_, err := NewCosignVerifier(filepath.Join(t.TempDir(), "missing"), "/keys/cosign.pub")
+		assert.ErrorContains(t, err, "cosign binary not found")
+		t.Setenv("PATH", t.TempDir())
+		_, err = NewCosignVerifier("", "/keys/cosign.pub")
+		assert.ErrorContains(t, err, "cosign binary not found")
+	})
+}
+
+func Test_ProcessIncomingApplicationImagePolicy(t *testing.T) {
+	evs := event.NewEventSource("test")
+	a, _ := newAgent(t)
+	a.mode = types.AgentModeManaged
+	a.context = context.Background()
+	require.NoError(t, WithImagePolicy(ImagePolicy{Verifier: &fakeImageVerifier{}, Enforce: true})(a))
+
+	be := backend_mocks.NewApplication(t)
+	var err error
+	a.appManager, err = application.NewApplicationManager(be, "argocd",
+		application.WithRole(manager.ManagerRoleAgent),
+		application.WithMode(manager.ManagerModeManaged),
+	)
+	require.NoError(t, err)
+
+	existingApp := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "argocd",
+			Annotations: map[string]string{manager.SourceUIDAnnotation: "uid-from-principal"},
+		},
+	}
+	incomingApp := existingApp.DeepCopy()
+	incomingApp.UID = "uid-from-principal"
+	incomingApp.Annotations = nil
+	incomingApp.Operation = &v1alpha1.Operation{Sync: &v1alpha1.SyncOperation{Manifests: []string{deploymentManifest}}}
+
+	be.On("SupportsPatch").Return(false)
+	be.On("Get", mock.Anything, "test", "argocd").Return(existingApp, nil)
+	be.On("Update", mock.Anything, mock.Anything).Return(existingApp, nil)
+
+	ev := event.New(evs.ApplicationEvent(event.SetOperation, incomingApp), event.TargetApplication)
+	require.NoError(t, a.processIncomingApplication(ev))
+
+	// The operation is never set, but recorded as failed right away
+	be.AssertNumberOfCalls(t, "Update", 1)
+	updated := be.Calls[len(be.Calls)-1].Arguments[1].(*v1alpha1.Application)
+	assert.Nil(t, updated.Operation)
+	require.NotNil(t, updated.Status.OperationState)
+	assert.Equal(t, synccommon.OperationFailed, updated.Status.OperationState.Phase)
+	assert.Contains(t, updated.Status.OperationState.Message, "quay.io/argoprojlabs/guestbook-ui:v1: no matching signatures")
+	require.Len(t, updated.Status.Conditions, 1)
+	assert.Equal(t, v1alpha1.ApplicationConditionSyncError, updated.Status.Conditions[0].Type)
+}
@@ -0,0 +1,105 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// OnBehalfOfExtra is the key of the user extra info which carries the user
+// on whose behalf a proxied request was made, when the agent impersonates a
+// user for proxied requests. It shows up in the audit log of the cluster.
+const OnBehalfOfExtra = "argocd-agent.argoproj.io/on-behalf-of"
+
+// WithProxyImpersonation makes the agent impersonate the given user and
+// groups for requests it proxies on behalf of the principal, such as
+// resource requests, container logs and terminal sessions. This restricts
+// proxied requests to the permissions of the impersonated user and
+// attributes them to it in the audit log of the cluster. The agent's service
+// account needs permission to impersonate the user and groups.
+//
+// Service accounts are impersonated by their user name, e.g.
+// system:serviceaccount:argocd:argocd-agent-proxy.
+func WithProxyImpersonation(user string, groups ...string) AgentOption {
+	return func(a *Agent) error {
+		if user == "" {
+			return fmt.Errorf("user to impersonate must not be empty")
+		}
+		a.proxyImpersonation = &rest.ImpersonationConfig{UserName: user, Groups: groups}
+		return nil
+	}
+}
+
+// newProxyClient returns a copy of client which impersonates the configured
+// user
+func newProxyClient(client *kube.KubernetesClient, impersonate rest.ImpersonationConfig) (*kube.KubernetesClient, error) {
+	if client.RestConfig == nil {
+		return nil, fmt.Errorf("impersonation requires a REST config for the Kubernetes client")
+	}
+	config := rest.CopyConfig(client.RestConfig)
+	config.Impersonate = impersonate
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &onBehalfOfRoundTripper{delegate: rt}
+	})
+	return kube.NewKubernetesClientForConfig(client.Context, config, client.Namespace)
+}
+
+// proxyKubeClient returns the client to use for requests proxied on behalf
+// of the principal
+func (a *Agent) proxyKubeClient() *kube.KubernetesClient {
+	if a.proxyClient != nil {
+		return a.proxyClient
+	}
+	return a.kubeClient
+}
+
+type onBehalfOfKey struct{}
+
+// withOnBehalfOf returns a context for requests made on behalf of user. It
+// has no effect unless the agent impersonates a user for proxied requests.
+func withOnBehalfOf(ctx context.Context, user string) context.Context {
+	if user == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, onBehalfOfKey{}, user)
+}
+
+// onBehalfOfRoundTripper adds the user a request is made on behalf of to the
+// impersonated user's extra info
+type onBehalfOfRoundTripper struct {
+	delegate http.RoundTripper
+}
+
+func (rt *onBehalfOfRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	user, ok := req.Context().Value(onBehalfOfKey{}).(string)
+	if !ok {
+		return rt.delegate.RoundTrip(req)
+	}
+	req = utilnet.CloneRequest(req)
+	req.Header.Set(transport.ImpersonateUserExtraHeaderPrefix+url.PathEscape(OnBehalfOfExtra), user)
+	return rt.delegate.RoundTrip(req)
+}
+
+func (rt *onBehalfOfRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.delegate
+}
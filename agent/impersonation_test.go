@@ -0,0 +1,83 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func Test_ProxyImpersonation(t *testing.T) {
+	var mu sync.Mutex
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = r.Header.Clone()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"guestbook-ui","namespace":"guestbook"}}`))
+	}))
+	defer srv.Close()
+
+	client, err := kube.NewKubernetesClientForConfig(context.Background(), &rest.Config{Host: srv.URL}, "argocd")
+	require.NoError(t, err)
+	a := &Agent{kubeClient: client}
+	require.NoError(t, WithProxyImpersonation("system:serviceaccount:argocd:argocd-agent-proxy", "viewers")(a))
+	a.proxyClient, err = newProxyClient(client, *a.proxyImpersonation)
+	require.NoError(t, err)
+
+	get := func(ctx context.Context) http.Header {
+		t.Helper()
+		_, err := a.proxyKubeClient().Clientset.CoreV1().Pods("guestbook").Get(ctx, "guestbook-ui", v1.GetOptions{})
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		return headers
+	}
+
+	t.Run("Proxied requests impersonate the configured user", func(t *testing.T) {
+		h := get(context.Background())
+		assert.Equal(t, "system:serviceaccount:argocd:argocd-agent-proxy", h.Get("Impersonate-User"))
+		assert.Equal(t, []string{"viewers"}, h.Values("Impersonate-Group"))
+		assert.Empty(t, h.Get("Impersonate-Extra-argocd-agent.argoproj.io%2Fon-behalf-of"))
+	})
+
+	t.Run("The requesting user is recorded", func(t *testing.T) {
+		h := get(withOnBehalfOf(context.Background(), "alice"))
+		assert.Equal(t, "system:serviceaccount:argocd:argocd-agent-proxy", h.Get("Impersonate-User"))
+		assert.Equal(t, "alice", h.Get("Impersonate-Extra-argocd-agent.argoproj.io%2Fon-behalf-of"))
+	})
+
+	t.Run("The agent's own requests are not impersonated", func(t *testing.T) {
+		_, err := a.kubeClient.Clientset.CoreV1().Pods("guestbook").Get(context.Background(), "guestbook-ui", v1.GetOptions{})
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Empty(t, headers.Get("Impersonate-User"))
+	})
+
+	t.Run("User must not be empty", func(t *testing.T) {
+		assert.Error(t, WithProxyImpersonation("")(&Agent{}))
+	})
+}
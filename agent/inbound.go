@@ -37,7 +37,6 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/dynamic"
 )
 
 /*
@@ -110,6 +109,24 @@ func (a *Agent) processIncomingEvent(ev *event.Event) error {
 		}()
 	case event.TargetContainerLog:
 		err = a.processIncomingContainerLogRequest(ev)
+	case event.TargetSupportBundle:
+		err = a.processIncomingSupportBundleRequest(ev)
+	case event.TargetMetrics:
+		err = a.processIncomingMetricsRequest(ev)
+	case event.TargetDebugCommand:
+		err = a.processIncomingDebugCommand(ev)
+	case event.TargetStateChecksum:
+		err = a.processIncomingDivergedApplications(ev)
+	case event.TargetAgentConfig:
+		err = a.processIncomingAgentConfig(ev)
+	case event.TargetAgentUpdate:
+		err = a.processIncomingAgentUpdate(ev)
+	case event.TargetLogCancel:
+		err = a.processIncomingLogCancel(ev)
+	case event.TargetLogFrame:
+		err = a.processIncomingLogFrame(ev)
+	case event.TargetHeartbeat:
+		err = a.processIncomingHeartbeat(ev)
 	case event.TargetTerminal:
 		// Process terminal request in a separate goroutine to avoid blocking the event thread
 		go func() {
@@ -122,6 +139,7 @@ func (a *Agent) processIncomingEvent(ev *event.Event) error {
 	}
 
 	cp.End()
+	a.observeEventHandler(ev, cp.Duration())
 
 	if err != nil {
 		tracing.RecordError(span, err)
@@ -141,7 +159,8 @@ func (a *Agent) processIncomingEvent(ev *event.Event) error {
 		}
 
 		// store time taken by agent to process event in metrics
-		a.metrics.EventProcessingTime.WithLabelValues(string(status), string(a.mode), ev.Target().String()).Observe(cp.Duration().Seconds())
+		metrics.ObserveDuration(a.metrics.EventProcessingTime.WithLabelValues(string(status), string(a.mode), ev.Target().String()),
+			cp.Duration(), a.options.slowEventHandlerThreshold, span.SpanContext(), nil)
 	}
 
 	return err
@@ -155,10 +174,13 @@ func (a *Agent) processIncomingApplication(ev *event.Event) error {
 	if err != nil {
 		return err
 	}
+	hubNamespace := incomingApp.Namespace
+	sourceResourceVersion := incomingApp.ResourceVersion
 
 	// Determine the target namespace for the application
 	targetNamespace := a.getTargetNamespaceForApp(incomingApp)
 	incomingApp.SetNamespace(targetNamespace)
+	a.applicationToSpoke(incomingApp)
 
 	principalUID := event.PrincipalUID(ev.CloudEvent())
 
@@ -185,6 +207,38 @@ func (a *Agent) processIncomingApplication(ev *event.Event) error {
 		incomingApp.OwnerReferences = nil
 	}
 
+	// Applications violating the agent's spec policy are neither created nor
+	// updated, and the violations are reported to the principal.
+	if ev.Type() == event.Create || ev.Type() == event.SpecUpdate {
+		operation := "update"
+		if ev.Type() == event.Create {
+			operation = "create"
+		}
+		if violations := a.evaluateSpecPolicy(incomingApp, operation); len(violations) > 0 {
+			logCtx.Warnf("Refusing to %s application %s, which violates the spec policy", operation, incomingApp.QualifiedName())
+			a.reportPolicyViolations(&event.PolicyViolationReport{
+				Name:       incomingApp.Name,
+				Namespace:  hubNamespace,
+				UID:        string(incomingApp.UID),
+				Operation:  operation,
+				Violations: violations,
+			})
+			return nil
+		}
+	}
+
+	// Sync operations requested on the principal are subject to the agent's
+	// image policy. A rejected operation is not applied, but recorded as
+	// failed once the event has been processed.
+	var rejected *v1alpha1.Application
+	var policyErr error
+	if a.mode == types.AgentModeManaged && ev.Type() != event.Delete {
+		if policyErr = a.verifySyncOperation(a.context, incomingApp); policyErr != nil {
+			rejected = incomingApp.DeepCopy()
+			incomingApp.Operation = nil
+		}
+	}
+
 	switch ev.Type() {
 	case event.Create:
 		if a.mode == types.AgentModeManaged {
@@ -215,9 +269,11 @@ func (a *Agent) processIncomingApplication(ev *event.Event) error {
 			}
 		}
 
-		_, err = a.appManager.SetOperation(a.context, incomingApp)
-		if err != nil {
-			logCtx.Errorf("Error setting operation: %v", err)
+		if rejected == nil {
+			_, err = a.appManager.SetOperation(a.context, incomingApp)
+			if err != nil {
+				logCtx.Errorf("Error setting operation: %v", err)
+			}
 		}
 	case event.TerminateOperation:
 		logCtx.Trace("Received a TerminateOperation event")
@@ -234,6 +290,16 @@ func (a *Agent) processIncomingApplication(ev *event.Event) error {
 		logCtx.Warnf("Received an unknown event: %s. Protocol mismatch?", ev.Type())
 	}
 
+	// Remember which version of the principal's Application was applied, so
+	// that a later resync can skip it if neither side changed it since
+	if err == nil && a.mode == types.AgentModeManaged && (ev.Type() == event.Create || ev.Type() == event.SpecUpdate) {
+		a.resyncBookmarks.RecordApplication("principal", incomingApp, "", sourceResourceVersion)
+	}
+
+	if err == nil && rejected != nil {
+		err = a.rejectSyncOperation(rejected, policyErr)
+	}
+
 	return err
 }
 
@@ -544,7 +610,7 @@ func (a *Agent) processIncomingResourceResyncEvent(ev *event.Event) error {
 		"resource_id": ev.ResourceID(),
 	})
 
-	dynClient, err := dynamic.NewForConfig(a.kubeClient.RestConfig)
+	dynClient, err := a.kubeClient.Dynamic()
 	if err != nil {
 		return err
 	}
@@ -556,7 +622,11 @@ func (a *Agent) processIncomingResourceResyncEvent(ev *event.Event) error {
 
 	resyncHandler := resync.NewRequestHandler(dynClient, sendQ, a.emitter, a.resources, logCtx, manager.ManagerRoleAgent, a.namespace).
 		WithDestinationBasedMapping(a.destinationBasedMapping).
-		WithIgnoreUnmanagedApps(a.ignoreUnmanagedApps)
+		WithIgnoreUnmanagedApps(a.ignoreUnmanagedApps).
+		WithApplicationFilter(a.outgoingApplication).
+		WithPager(a.resyncPager, "principal").
+		WithBookmarks(a.resyncBookmarks, "principal").
+		WithIncrementalResync(a.eventWriter != nil && a.eventWriter.SchemaVersion() >= event.SchemaVersion12)
 	subject := &auth.AuthSubject{}
 	err = json.Unmarshal([]byte(a.remote.ClientID()), subject)
 	if err != nil {
@@ -602,13 +672,29 @@ func (a *Agent) processIncomingResourceResyncEvent(ev *event.Event) error {
 		// When the principal sends a RequestUpdate, it uses the prefixed name. We need to strip the prefix
 		// before looking up the resource locally.
 		if incoming.Kind == "AppProject" {
-			prefix := agentName + "-"
-			if len(incoming.Name) > len(prefix) && incoming.Name[:len(prefix)] == prefix {
-				incoming.Name = incoming.Name[len(prefix):]
-			}
+			incoming.Name = unprefixedProjectName(incoming.Name, agentName)
 		}
 
 		return resyncHandler.ProcessRequestUpdateEvent(a.context, agentName, incoming)
+	case event.EventResyncBookmarks:
+		if a.mode != types.AgentModeAutonomous {
+			return fmt.Errorf("agent can only handle ResyncBookmarks in the autonomous mode")
+		}
+
+		incoming, err := ev.ResyncBookmarks()
+		if err != nil {
+			return err
+		}
+
+		// Bookmarks of AppProjects carry the principal's prefixed names, just
+		// like RequestUpdates
+		for i := range incoming.Resources {
+			if incoming.Resources[i].Kind == "AppProject" {
+				incoming.Resources[i].Name = unprefixedProjectName(incoming.Resources[i].Name, agentName)
+			}
+		}
+
+		return resyncHandler.ProcessResyncBookmarks(a.context, agentName, incoming)
 	case event.EventRequestResourceResync:
 		if a.mode != types.AgentModeManaged {
 			return fmt.Errorf("agent can only handle ResourceResync request in the managed mode")
@@ -620,6 +706,16 @@ func (a *Agent) processIncomingResourceResyncEvent(ev *event.Event) error {
 	}
 }
 
+// unprefixedProjectName strips the prefix of the agent's name from the name
+// of an AppProject as the principal stores it for autonomous agents
+func unprefixedProjectName(name, agentName string) string {
+	prefix := agentName + "-"
+	if len(name) > len(prefix) && name[:len(prefix)] == prefix {
+		return name[len(prefix):]
+	}
+	return name
+}
+
 // createApplication creates an Application upon an event in the agent's work
 // queue. principalUID is stamped on the resource if non-empty.
 func (a *Agent) createApplication(incoming *v1alpha1.Application, principalUID string) (*v1alpha1.Application, error) {
@@ -731,11 +827,19 @@ func (a *Agent) updateApplication(incoming *v1alpha1.Application) (*v1alpha1.App
 	switch a.mode {
 	case types.AgentModeManaged:
 
+		// The spec of the application may have been modified on the agent,
+		// in which case the conflict strategy decides which spec prevails
+		toApply := incoming
+		if a.options.conflictStrategy != manager.ConflictHubWins {
+			existing, _ := a.appManager.Get(a.context, incoming.Name, incoming.Namespace)
+			toApply = a.resolveIncomingAppChange(existing, incoming)
+		}
+
 		// Update app spec in cache
 		logCtx.Tracef("Calling update spec for this event")
 		a.sourceCache.Application.Set(sourceUIDForApp(incoming), incoming.Spec)
 
-		napp, err = a.appManager.UpdateManagedApp(a.context, incoming, application.ManagedIdentity{})
+		napp, err = a.appManager.UpdateManagedApp(a.context, toApply, application.ManagedIdentity{})
 	case types.AgentModeAutonomous:
 		logCtx.Tracef("Calling update operation for this event")
 		napp, err = a.appManager.UpdateOperation(a.context, incoming)
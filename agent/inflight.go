@@ -0,0 +1,59 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+)
+
+// Kinds of long-running operations tracked by the agent
+const (
+	InflightLogs          inflight.Kind = "logs"
+	InflightTerminal      inflight.Kind = "terminal"
+	InflightSupportBundle inflight.Kind = "support-bundle"
+)
+
+// InflightRequests returns all long-running operations currently processed by
+// the agent.
+func (a *Agent) InflightRequests() []inflight.Entry {
+	return a.inflight.List()
+}
+
+// CancelInflightRequest forcefully cancels a long-running operation. It
+// returns false if no such operation is in flight.
+func (a *Agent) CancelInflightRequest(kind inflight.Kind, id string) bool {
+	return a.inflight.Cancel(kind, id)
+}
+
+// inflightListHandler lists all long-running operations as JSON
+func (a *Agent) inflightListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.InflightRequests())
+}
+
+// inflightCancelHandler cancels the long-running operation given in the path
+func (a *Agent) inflightCancelHandler(w http.ResponseWriter, r *http.Request) {
+	kind := inflight.Kind(r.PathValue("kind"))
+	id := r.PathValue("id")
+	if !a.CancelInflightRequest(kind, id) {
+		http.Error(w, "no such request in flight", http.StatusNotFound)
+		return
+	}
+	log().WithField("kind", kind).WithField("uuid", id).Info("Cancelled inflight request on request")
+	w.WriteHeader(http.StatusNoContent)
+}
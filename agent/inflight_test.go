@@ -0,0 +1,62 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InflightHandlers(t *testing.T) {
+	a := createTestAgent()
+	ctx, done, err := a.inflight.Start(a.context, InflightLogs, "abc", map[string]string{"pod": "p"})
+	require.NoError(t, err)
+	defer done()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/inflight", a.inflightListHandler)
+	mux.HandleFunc("DELETE /debug/inflight/{kind}/{id}", a.inflightCancelHandler)
+
+	t.Run("List", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/inflight", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var entries []inflight.Entry
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+		require.Len(t, entries, 1)
+		assert.Equal(t, InflightLogs, entries[0].Kind)
+		assert.Equal(t, "abc", entries[0].ID)
+	})
+
+	t.Run("Cancel unknown", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug/inflight/terminal/abc", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug/inflight/logs/abc", nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Error(t, ctx.Err())
+		assert.Empty(t, a.InflightRequests())
+	})
+}
@@ -0,0 +1,235 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElection configures leader election between replicas of the agent.
+// Only the leader connects to the principal and syncs resources; the other
+// replicas stand by to take over when the leader goes away.
+type LeaderElection struct {
+	// LeaseName is the name of the Lease in the agent's namespace
+	LeaseName string
+	// Identity identifies this replica, e.g. by its pod name
+	Identity string
+	// LeaseDuration is how long standby replicas wait before they take over
+	// a lease that was not renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader tries to renew its lease before
+	// it gives up leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval in which replicas try to acquire or renew
+	// the lease
+	RetryPeriod time.Duration
+}
+
+// DefaultLeaderElection returns the default leader election settings
+func DefaultLeaderElection() LeaderElection {
+	return LeaderElection{
+		LeaseName:     "argocd-agent-agent-leader",
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+// leaderReleaseTimeout is how long releasing the lease may take on shutdown
+const leaderReleaseTimeout = 5 * time.Second
+
+// WithLeaderElection enables leader election with the given settings, so
+// that the agent can run with more than one replica.
+func WithLeaderElection(le LeaderElection) AgentOption {
+	return func(a *Agent) error {
+		if le.LeaseName == "" {
+			return errors.New("leader election needs a lease name")
+		}
+		if le.Identity == "" {
+			return errors.New("leader election needs an identity")
+		}
+		a.options.leaderElection = &le
+		return nil
+	}
+}
+
+// leaderElector runs the leader election of the agent
+type leaderElector struct {
+	config  LeaderElection
+	elector *leaderelection.LeaderElector
+
+	leading  atomic.Bool
+	released atomic.Bool
+	// cancel stops the election, releasing the lease if held
+	cancel context.CancelFunc
+	// lost is closed when the agent lost leadership it did not release
+	lost chan struct{}
+	// done is closed when the election has ended
+	done chan struct{}
+}
+
+// newLeaderElector creates the leader elector of a. The agent is started
+// when it becomes the leader.
+func newLeaderElector(a *Agent, config LeaderElection) (*leaderElector, error) {
+	l := &leaderElector{
+		config: config,
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, a.namespace, config.LeaseName,
+		a.kubeClient.Clientset.CoreV1(), a.kubeClient.Clientset.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: config.Identity})
+	if err != nil {
+		return nil, fmt.Errorf("could not create lease lock: %w", err)
+	}
+	l.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				l.setLeading(a, true)
+				log().Infof("Acquired leadership as %s, starting agent", config.Identity)
+				if err := a.start(ctx); err != nil {
+					log().WithError(err).Error("Could not start agent, giving up leadership")
+					l.cancel()
+				}
+			},
+			OnStoppedLeading: func() {
+				if !l.setLeading(a, false) {
+					return
+				}
+				if l.released.Load() {
+					log().Infof("Released leadership")
+					return
+				}
+				log().Errorf("Lost leadership as %s", config.Identity)
+				close(l.lost)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != config.Identity {
+					log().Infof("Replica %s is the leader, standing by", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid leader election settings: %w", err)
+	}
+	return l, nil
+}
+
+// setLeading records whether the agent is the leader, and returns whether
+// it was the leader before
+func (l *leaderElector) setLeading(a *Agent, leading bool) bool {
+	was := l.leading.Swap(leading)
+	if a.metrics != nil {
+		if leading {
+			a.metrics.Leader.Set(1)
+			if !was {
+				a.metrics.LeaderTransitions.Inc()
+			}
+		} else {
+			a.metrics.Leader.Set(0)
+		}
+	}
+	return was
+}
+
+// run runs the election until ctx is done or leadership was lost
+func (l *leaderElector) run(ctx context.Context) {
+	ctx, l.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(l.done)
+		l.elector.Run(ctx)
+	}()
+}
+
+// release stops the election, and waits for the lease to be released so
+// that another replica can take over right away
+func (l *leaderElector) release(timeout time.Duration) {
+	if l.cancel == nil {
+		return
+	}
+	l.released.Store(true)
+	l.cancel()
+	select {
+	case <-l.done:
+	case <-time.After(timeout):
+		log().Warn("Timed out releasing leadership")
+	}
+}
+
+// standby returns whether the agent is waiting to become the leader
+func (a *Agent) standby() bool {
+	return a.leader != nil && !a.leader.leading.Load()
+}
+
+// LostLeadership returns a channel that is closed when the agent lost its
+// leadership to another replica. The agent stops working then, and should
+// be restarted to stand by for leadership again. The channel is nil without
+// leader election.
+func (a *Agent) LostLeadership() <-chan struct{} {
+	if a.leader == nil {
+		return nil
+	}
+	return a.leader.lost
+}
+
+// ReleaseLeadership stops the agent from being or becoming the leader, and
+// hands the lease over to another replica. It does nothing without leader
+// election.
+func (a *Agent) ReleaseLeadership() {
+	if a.leader == nil {
+		return
+	}
+	a.leader.release(leaderReleaseTimeout)
+}
+
+// leaderStatus is the leadership status reported by the agent
+type leaderStatus struct {
+	LeaseName string `json:"leaseName"`
+	Identity  string `json:"identity"`
+	Leader    string `json:"leader"`
+	IsLeader  bool   `json:"isLeader"`
+}
+
+// leaderHandler reports the leadership status of this replica
+func (a *Agent) leaderHandler(w http.ResponseWriter, r *http.Request) {
+	if a.leader == nil {
+		http.Error(w, "leader election is disabled", http.StatusNotFound)
+		return
+	}
+	status := leaderStatus{
+		LeaseName: a.leader.config.LeaseName,
+		Identity:  a.leader.config.Identity,
+		Leader:    a.leader.elector.GetLeader(),
+		IsLeader:  a.leader.leading.Load(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
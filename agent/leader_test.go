@@ -0,0 +1,130 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/argoproj-labs/argocd-agent/pkg/client"
+	fakekube "github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_LeaderElection(t *testing.T) {
+	le := LeaderElection{
+		LeaseName:     "agent-leader",
+		Identity:      "agent-1",
+		LeaseDuration: time.Minute,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+
+	t.Run("Invalid settings", func(t *testing.T) {
+		kubec := fakekube.NewKubernetesFakeClientWithApps("argocd")
+		noName := le
+		noName.LeaseName = ""
+		_, err := NewAgent(context.TODO(), kubec, "argocd", WithRemote(&client.Remote{}), WithCacheRefreshInterval(10*time.Second), WithLeaderElection(noName))
+		assert.ErrorContains(t, err, "lease name")
+		noIdentity := le
+		noIdentity.Identity = ""
+		_, err = NewAgent(context.TODO(), kubec, "argocd", WithRemote(&client.Remote{}), WithCacheRefreshInterval(10*time.Second), WithLeaderElection(noIdentity))
+		assert.ErrorContains(t, err, "identity")
+		badDurations := le
+		badDurations.RenewDeadline = 2 * time.Minute
+		_, err = NewAgent(context.TODO(), kubec, "argocd", WithRemote(&client.Remote{}), WithCacheRefreshInterval(10*time.Second), WithLeaderElection(badDurations))
+		assert.ErrorContains(t, err, "invalid leader election settings")
+	})
+
+	t.Run("Stand by while another replica leads", func(t *testing.T) {
+		kubec := fakekube.NewKubernetesFakeClientWithApps("argocd")
+		_, err := kubec.Clientset.CoordinationV1().Leases("argocd").Create(context.TODO(), &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: le.LeaseName, Namespace: "argocd"},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To("agent-2"),
+				LeaseDurationSeconds: ptr.To(int32(60)),
+				AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+				RenewTime:            &metav1.MicroTime{Time: time.Now()},
+			},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+		reg := prometheus.NewRegistry()
+		a, err := NewAgent(context.TODO(), kubec, "argocd", WithRemote(&client.Remote{}), WithCacheRefreshInterval(10*time.Second),
+			WithLeaderElection(le), WithMetricsRegistry(reg))
+		require.NoError(t, err)
+		require.NoError(t, a.Start(context.Background()))
+
+		require.Eventually(t, func() bool {
+			return a.leader.elector.GetLeader() == "agent-2"
+		}, 5*time.Second, 50*time.Millisecond)
+		assert.True(t, a.standby())
+		assert.Zero(t, testutil.ToFloat64(a.metrics.Leader))
+
+		rec := httptest.NewRecorder()
+		a.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NoError(t, a.checkPrincipal(context.TODO()))
+
+		rec = httptest.NewRecorder()
+		a.leaderHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/leader", nil))
+		var status leaderStatus
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		assert.Equal(t, leaderStatus{LeaseName: "agent-leader", Identity: "agent-1", Leader: "agent-2"}, status)
+
+		a.ReleaseLeadership()
+		select {
+		case <-a.leader.done:
+		default:
+			t.Fatal("leader election still running after release")
+		}
+		select {
+		case <-a.LostLeadership():
+			t.Fatal("standby replica reported lost leadership")
+		default:
+		}
+	})
+
+	t.Run("Leader metrics", func(t *testing.T) {
+		a := &Agent{metrics: metrics.NewAgentMetricsWith(prometheus.NewRegistry())}
+		l := &leaderElector{}
+		assert.False(t, l.setLeading(a, true))
+		assert.True(t, l.setLeading(a, true))
+		assert.Equal(t, float64(1), testutil.ToFloat64(a.metrics.Leader))
+		assert.True(t, l.setLeading(a, false))
+		assert.Zero(t, testutil.ToFloat64(a.metrics.Leader))
+		assert.Equal(t, float64(1), testutil.ToFloat64(a.metrics.LeaderTransitions))
+	})
+
+	t.Run("Without leader election", func(t *testing.T) {
+		a, _ := newAgent(t)
+		assert.False(t, a.standby())
+		assert.Nil(t, a.LostLeadership())
+		a.ReleaseLeadership()
+		rec := httptest.NewRecorder()
+		a.leaderHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/leader", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -20,17 +20,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj-labs/argocd-agent/internal/logging/logfields"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // processIncomingContainerLogRequest handles container log requests from Principal
@@ -39,6 +40,7 @@ func (a *Agent) processIncomingContainerLogRequest(ev *event.Event) error {
 	if err != nil {
 		return err
 	}
+	logReq.Namespace = a.options.namespaceMapper.ToSpoke(logReq.Namespace)
 
 	logCtx := log().WithFields(logrus.Fields{
 		"uuid":      logReq.UUID,
@@ -47,6 +49,15 @@ func (a *Agent) processIncomingContainerLogRequest(ev *event.Event) error {
 		"container": logReq.Container,
 		"follow":    logReq.Follow,
 	})
+	if logReq.RequestID != "" {
+		logCtx = logCtx.WithField(logfields.RequestID, logReq.RequestID)
+	}
+
+	if err := a.options.logAccessRules.check(logReq.Namespace, logReq.PodName); err != nil {
+		logCtx.Warn("Log request denied by log access rules")
+		a.rejectLogRequest(logReq, err, logCtx)
+		return nil
+	}
 
 	err = a.startLogStreamIfNew(logReq, logCtx)
 	if err != nil {
@@ -59,24 +70,36 @@ func (a *Agent) processIncomingContainerLogRequest(ev *event.Event) error {
 
 // startLogStreamIfNew manages log streaming with duplicate detection
 func (a *Agent) startLogStreamIfNew(logReq *event.ContainerLogRequest, logCtx *logrus.Entry) error {
-	a.inflightMu.Lock()
-	if a.inflightLogs == nil {
-		a.inflightLogs = make(map[string]struct{})
+	parent, cancel := withOnBehalfOf(a.context, logReq.RequestedBy), context.CancelFunc(func() {})
+	// The deadline is derived from the request's timeout on our own clock,
+	// which may differ from the principal's.
+	if deadline, ok := logReq.DeadlineAfter(time.Now()); ok {
+		if time.Now().After(deadline) {
+			logCtx.WithField("deadline", deadline).Warn("Log request deadline has already passed; dropping request")
+			return nil
+		}
+		parent, cancel = context.WithDeadline(parent, deadline)
 	}
-	if _, dup := a.inflightLogs[logReq.UUID]; dup {
-		a.inflightMu.Unlock()
-		logCtx.Warn("duplicate log request; already streaming")
-		return nil
+	ctx, done, err := a.inflight.Start(parent, InflightLogs, logReq.UUID, map[string]string{
+		"namespace": logReq.Namespace,
+		"pod":       logReq.PodName,
+		"container": logReq.Container,
+		"follow":    strconv.FormatBool(logReq.Follow),
+	})
+	if err != nil {
+		cancel()
+		if errors.Is(err, inflight.ErrDuplicate) {
+			logCtx.Warn("duplicate log request; already streaming")
+			return nil
+		}
+		a.rejectLogRequest(logReq, err, logCtx)
+		return err
 	}
-	ctx, cancel := context.WithCancel(a.context)
-	a.inflightLogs[logReq.UUID] = struct{}{}
-	a.inflightMu.Unlock()
 
 	cleanup := func() {
+		done()
 		cancel()
-		a.inflightMu.Lock()
-		delete(a.inflightLogs, logReq.UUID)
-		a.inflightMu.Unlock()
+		a.confirmLogCancel(logReq.UUID, logCtx)
 	}
 
 	logCtx.Info("Processing log request")
@@ -105,14 +128,19 @@ func (a *Agent) handleStaticLogs(ctx context.Context, logReq *event.ContainerLog
 	if err != nil {
 		return err
 	}
-	// Create Kubernetes log stream
-	rc, err := a.createKubernetesLogStream(ctx, logReq)
+	// Open the container logs
+	rc, err := a.logSource.GetLogStream(ctx, logReq)
 	if err != nil {
 		_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true, Error: err.Error()})
-		_, _ = stream.CloseAndRecv()
+		_, _ = a.closeLogStream(stream, logCtx)
 		return err
 	}
 	err = a.streamLogsToCompletion(ctx, stream, rc, logReq, logCtx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// The principal has stopped waiting for these logs
+		logCtx.WithError(err).Warn("Log request deadline exceeded")
+		return nil
+	}
 	if err != nil {
 		// Stop immediately on intentional server stops or auth issues
 		switch status.Code(err) {
@@ -147,41 +175,89 @@ func (a *Agent) handleLiveStreaming(ctx context.Context, logReq *event.Container
 	return nil
 }
 
-// createLogStream creates a gRPC LogStream to the principal
+// createLogStream creates a gRPC LogStream to the principal, or a log stream
+// multiplexed over the event stream if enabled. If the number of log streams
+// is limited, it waits for a free stream first.
 func (a *Agent) createLogStream(ctx context.Context) (logstreamapi.LogStreamService_StreamLogsClient, error) {
-	conn := a.remote.Conn()
-	if conn == nil {
-		return nil, fmt.Errorf("gRPC connection is nil")
+	return a.limitLogStream(ctx, func() (logstreamapi.LogStreamService_StreamLogsClient, error) {
+		if a.useLogMux() {
+			return a.openMuxLogStream(ctx)
+		}
+		if a.remote == nil {
+			return nil, fmt.Errorf("no connection to the principal configured")
+		}
+		conn := a.remote.Conn()
+		if conn == nil {
+			return nil, fmt.Errorf("gRPC connection is nil")
+		}
+		client := logstreamapi.NewLogStreamServiceClient(conn)
+		return client.StreamLogs(ctx)
+	})
+}
+
+// closeLogStream closes the log stream to the principal and records the
+// principal's response. If the stream failed, the response is a detail of the
+// returned error status.
+func (a *Agent) closeLogStream(stream logstreamapi.LogStreamService_StreamLogsClient, logCtx *logrus.Entry) (*logstreamapi.LogStreamResponse, error) {
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		resp = nil
+		for _, d := range status.Convert(err).Details() {
+			if r, ok := d.(*logstreamapi.LogStreamResponse); ok {
+				resp = r
+			}
+		}
 	}
-	client := logstreamapi.NewLogStreamServiceClient(conn)
-	return client.StreamLogs(ctx)
+	a.recordLogStreamResponse(resp, logCtx)
+	return resp, err
 }
 
-// createKubernetesLogStream creates a Kubernetes log stream
-func (a *Agent) createKubernetesLogStream(ctx context.Context, logReq *event.ContainerLogRequest) (io.ReadCloser, error) {
-	logOptions := &corev1.PodLogOptions{
-		Container:                    logReq.Container,
-		Follow:                       logReq.Follow,
-		Timestamps:                   true,
-		Previous:                     logReq.Previous,
-		InsecureSkipTLSVerifyBackend: logReq.InsecureSkipTLSVerifyBackend,
-		TailLines:                    logReq.TailLines,
-		SinceSeconds:                 logReq.SinceSeconds,
-		LimitBytes:                   logReq.LimitBytes,
+// recordLogStreamResponse logs what the principal reports about a finished
+// log stream and updates the log stream metrics.
+func (a *Agent) recordLogStreamResponse(resp *logstreamapi.LogStreamResponse, logCtx *logrus.Entry) {
+	if resp == nil {
+		return
 	}
-	// Handle SinceTime if provided
-	if logReq.SinceTime != "" {
-		if sinceTime, err := time.Parse(time.RFC3339, logReq.SinceTime); err == nil {
-			mt := v1.NewTime(sinceTime)
-			logOptions.SinceTime = &mt
-		}
+	code := resp.GetErrorCode()
+	if code == "" {
+		code = codes.OK.String()
+	}
+	writerStatus := resp.GetWriterStatus()
+	if writerStatus == "" {
+		writerStatus = "unknown"
+	}
+	duration := time.Duration(resp.GetDurationMs()) * time.Millisecond
+	logCtx.WithFields(logrus.Fields{
+		"lines_received": resp.GetLinesReceived(),
+		"bytes_received": resp.GetBytesReceived(),
+		"duration":       duration,
+		"code":           code,
+		"writer_status":  writerStatus,
+	}).Debug("Principal finished log stream")
+	if a.metrics != nil {
+		a.metrics.LogStreams.WithLabelValues(code, writerStatus).Inc()
+		a.metrics.LogStreamBytes.Add(float64(resp.GetBytesReceived()))
+		a.metrics.LogStreamDuration.Observe(duration.Seconds())
 	}
-	request := a.kubeClient.Clientset.CoreV1().Pods(logReq.Namespace).GetLogs(logReq.PodName, logOptions)
-	return request.Stream(ctx)
+}
+
+// rejectLogRequest tells the principal that the log request could not be
+// processed, so that the client does not have to wait for a timeout.
+func (a *Agent) rejectLogRequest(logReq *event.ContainerLogRequest, cause error, logCtx *logrus.Entry) {
+	stream, err := a.createLogStream(a.context)
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not report rejected log request to principal")
+		return
+	}
+	err = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true, Error: cause.Error()})
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not report rejected log request to principal")
+	}
+	_, _ = a.closeLogStream(stream, logCtx)
 }
 
 // streamLogsToCompletion streams ALL available (static) logs from k8s to the principal.
-// It flushes raw data without processing, using chunk size (64KB) or time-based flushing.
+// It flushes raw data without processing, using the configured chunk size (64KB by default) or time-based flushing.
 func (a *Agent) streamLogsToCompletion(
 	ctx context.Context,
 	stream logstreamapi.LogStreamService_StreamLogsClient,
@@ -189,9 +265,12 @@ func (a *Agent) streamLogsToCompletion(
 	logReq *event.ContainerLogRequest,
 	logCtx *logrus.Entry,
 ) error {
-	const chunkMax = 64 * 1024
 	defer rc.Close()
-	readBuf := make([]byte, chunkMax)
+	readBuf := make([]byte, a.logChunkMax())
+	redactor := a.newLogRedactor()
+	stats := &logStreamStats{}
+	budget := a.memoryBudget.logStream()
+	defer budget.release()
 
 	for {
 		// Respect cancellations before attempting a potentially blocking read
@@ -203,20 +282,33 @@ func (a *Agent) streamLogsToCompletion(
 		default:
 		}
 
+		if err := budget.acquire(ctx, len(readBuf)); err != nil {
+			return err
+		}
 		n, err := rc.Read(readBuf)
-
+		stats.count(n)
+		var data []byte
 		if n > 0 {
+			data = redactor.redact(readBuf[:n])
+		}
+		if errors.Is(err, io.EOF) {
+			data = append(data, redactor.flush()...)
+		}
+
+		if len(data) > 0 {
 			if sendErr := stream.Send(&logstreamapi.LogStreamData{
 				RequestUuid: logReq.UUID,
-				Data:        readBuf[:n],
+				Data:        data,
 			}); sendErr != nil {
 				logCtx.WithError(sendErr).Warn("Send failed")
-				if _, closedErr := stream.CloseAndRecv(); closedErr != nil {
+				if _, closedErr := a.closeLogStream(stream, logCtx); closedErr != nil {
 					return closedErr
 				}
 				return sendErr
 			}
+			stats.sent(data)
 		}
+		budget.release()
 
 		if err != nil {
 			if errors.Is(err, io.EOF) {
@@ -224,49 +316,113 @@ func (a *Agent) streamLogsToCompletion(
 				// IMPORTANT: don't ignore EOF send errors. If this fails, the principal will
 				// not signal completion (it only completes on receiving Eof=true) and the
 				// HTTP handler may hit "Static logs timeout" even though we read all logs.
-				if sendErr := stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true}); sendErr != nil {
+				if sendErr := stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true, Stats: stats.final(logReq)}); sendErr != nil {
 					logCtx.WithError(sendErr).Warn("Failed to send EOF frame")
-					if _, closedErr := stream.CloseAndRecv(); closedErr != nil {
+					if _, closedErr := a.closeLogStream(stream, logCtx); closedErr != nil {
 						return closedErr
 					}
 					return sendErr
 				}
 				// IMPORTANT: Must call CloseAndRecv to properly close the client-streaming RPC.
 				// This ensures all messages are flushed and the server receives the final response.
-				if _, closeErr := stream.CloseAndRecv(); closeErr != nil {
+				if _, closeErr := a.closeLogStream(stream, logCtx); closeErr != nil {
 					logCtx.WithError(closeErr).Warn("Failed to close stream after EOF")
 					return closeErr
 				}
 				return nil
 			}
 			logCtx.WithError(err).Error("Error reading log stream")
-			_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Error: "log stream read failed"})
-			_, _ = stream.CloseAndRecv()
+			stats.truncate()
+			_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Error: "log stream read failed", Stats: stats.final(logReq)})
+			_, _ = a.closeLogStream(stream, logCtx)
 			return err
 		}
 	}
 }
 
+// LogStreamBackoff configures how the agent retries a log stream after it
+// was interrupted by a transient error.
+type LogStreamBackoff struct {
+	// InitialInterval is the delay before the first retry
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between retries
+	MaxInterval time.Duration
+	// Multiplier is the factor by which the delay grows with each retry
+	Multiplier float64
+	// MaxElapsedTime is the time after which the agent stops retrying a
+	// stream that does not make progress. A value of 0 retries for as long as
+	// the principal still tracks the request.
+	MaxElapsedTime time.Duration
+	// ReconnectWait is how long the agent waits for the connection to the
+	// principal to be re-established after an authentication failure. A
+	// value of 0 waits for as long as the stream's context is alive.
+	ReconnectWait time.Duration
+}
+
+// DefaultLogStreamBackoff returns the default retry settings for log streams
+func DefaultLogStreamBackoff() LogStreamBackoff {
+	return LogStreamBackoff{
+		InitialInterval: 200 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2.0,
+		MaxElapsedTime:  30 * time.Second,
+		ReconnectWait:   10 * time.Second,
+	}
+}
+
+// Validate checks the backoff settings for consistency
+func (b LogStreamBackoff) Validate() error {
+	switch {
+	case b.InitialInterval <= 0:
+		return fmt.Errorf("initial interval must be positive")
+	case b.MaxInterval < b.InitialInterval:
+		return fmt.Errorf("max interval must not be smaller than initial interval")
+	case b.Multiplier < 1:
+		return fmt.Errorf("multiplier must be at least 1")
+	case b.MaxElapsedTime < 0:
+		return fmt.Errorf("max elapsed time must not be negative")
+	case b.ReconnectWait < 0:
+		return fmt.Errorf("reconnect wait must not be negative")
+	}
+	return nil
+}
+
 func (a *Agent) streamLogsWithResume(ctx context.Context, logReq *event.ContainerLogRequest, logCtx *logrus.Entry) {
-	const (
-		waitForReconnect = 10 * time.Second // how long we poll IsConnected() after Unauthenticated
-		pollEvery        = 1 * time.Second
-	)
+	cfg := a.options.logStreamBackoff
+	if cfg == (LogStreamBackoff{}) {
+		cfg = DefaultLogStreamBackoff()
+	}
 	var lastTimestamp *time.Time
+	dedup := newLogDeduplicator(a.logChunkMax())
+	stats := &logStreamStats{}
+	retry := false
 	// Configure exponential backoff with jitter
 	b := backoff.NewExponentialBackOff()
-	b.InitialInterval = 200 * time.Millisecond
-	b.Multiplier = 2.0
-	b.MaxInterval = 5 * time.Second
-	b.MaxElapsedTime = 30 * time.Second
+	b.InitialInterval = cfg.InitialInterval
+	b.Multiplier = cfg.Multiplier
+	b.MaxInterval = cfg.MaxInterval
+	b.MaxElapsedTime = cfg.MaxElapsedTime
 	bo := backoff.WithContext(b, ctx)
 
 	for {
 		// Build resume request
 		resumeReq := *logReq
+		if retry {
+			stats.resume()
+		}
+		retry = true
 		if lastTimestamp != nil {
-			t := lastTimestamp.Add(-100 * time.Millisecond)
-			resumeReq.SinceTime = t.Format(time.RFC3339)
+			// Kubernetes resumes at the beginning of the second of the last
+			// delivered line. The lines of that second the client has seen
+			// already are skipped by dedup.
+			since := dedup.resume()
+			if since.IsZero() {
+				since = lastTimestamp.Truncate(time.Second)
+			}
+			resumeReq.SinceTime = since.Format(time.RFC3339)
+			// Limits of the original request would cause gaps when resuming
+			resumeReq.TailLines = nil
+			resumeReq.SinceSeconds = nil
 		}
 
 		// One attempt to create + stream
@@ -283,17 +439,24 @@ func (a *Agent) streamLogsWithResume(ctx context.Context, logReq *event.Containe
 				Eof:         false,
 			})
 			if err != nil {
-				_, err = stream.CloseAndRecv()
+				_, err = a.closeLogStream(stream, logCtx)
 				return err
 			}
-			rc, err := a.createKubernetesLogStream(ctx, &resumeReq)
+			rc, err := a.logSource.GetLogStream(ctx, &resumeReq)
 			if err != nil {
 				_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true, Error: err.Error()})
-				_, _ = stream.CloseAndRecv()
+				_, _ = a.closeLogStream(stream, logCtx)
 				return err
 			}
-			newLastTimestamp, err := a.streamLogs(ctx, stream, rc, &resumeReq, logCtx)
+			newLastTimestamp, err := a.streamLogs(ctx, stream, rc, &resumeReq, dedup, stats, logCtx)
+			if dedup.suppressed > 0 {
+				logCtx.WithField("lines", dedup.suppressed).Debug("Suppressed duplicate lines after resume")
+			}
 			if newLastTimestamp != nil {
+				if lastTimestamp == nil || newLastTimestamp.After(*lastTimestamp) {
+					// The stream made progress, so the retry budget starts over
+					b.Reset()
+				}
 				lastTimestamp = newLastTimestamp
 			}
 			return err
@@ -313,24 +476,18 @@ func (a *Agent) streamLogsWithResume(ctx context.Context, logReq *event.Containe
 			logCtx.WithError(err).Warn("Auth/permission failure")
 			a.SetConnected(false)
 
-			waitCtx, cancel := context.WithTimeout(ctx, waitForReconnect)
-			t := time.NewTicker(pollEvery)
-
-			reconnected := false
-			for !reconnected {
-				select {
-				case <-waitCtx.Done():
-					cancel()
-					t.Stop()
-					return
-				case <-t.C:
-					if a.IsConnected() {
-						reconnected = true
-					}
-				}
+			var waitCtx context.Context
+			var cancel context.CancelFunc
+			if cfg.ReconnectWait > 0 {
+				waitCtx, cancel = context.WithTimeout(ctx, cfg.ReconnectWait)
+			} else {
+				waitCtx, cancel = context.WithCancel(ctx)
 			}
+			err := a.WaitForConnectionState(waitCtx, true)
 			cancel()
-			t.Stop()
+			if err != nil {
+				return
+			}
 			b.Reset()
 			continue
 		default:
@@ -350,14 +507,18 @@ func (a *Agent) streamLogsWithResume(ctx context.Context, logReq *event.Containe
 }
 
 // streamLogs streams logs until the context is done, returning the last seen timestamp.
-// It flushes raw data, using chunk size 64KB
+// It flushes raw data, using the configured chunk size (64KB by default)
 // Timestamps are extracted from raw lines for retry capability.
+// Lines already delivered before a resume are dropped by dedup, and the data
+// sent is counted in stats, if they are not nil.
 // If an error occurs during send, it attempts to close the stream and propagate
 // the appropriate error back to the caller for retry or termination.
-func (a *Agent) streamLogs(ctx context.Context, stream logstreamapi.LogStreamService_StreamLogsClient, rc io.ReadCloser, logReq *event.ContainerLogRequest, logCtx *logrus.Entry) (*time.Time, error) {
-	const chunkMax = 64 * 1024 // 64KB chunks
+func (a *Agent) streamLogs(ctx context.Context, stream logstreamapi.LogStreamService_StreamLogsClient, rc io.ReadCloser, logReq *event.ContainerLogRequest, dedup *logDeduplicator, stats *logStreamStats, logCtx *logrus.Entry) (*time.Time, error) {
 	var lastTimestamp *time.Time
-	readBuf := make([]byte, chunkMax)
+	readBuf := make([]byte, a.logChunkMax())
+	redactor := a.newLogRedactor()
+	budget := a.memoryBudget.logStream()
+	defer budget.release()
 	defer rc.Close()
 
 	for {
@@ -368,7 +529,11 @@ func (a *Agent) streamLogs(ctx context.Context, stream logstreamapi.LogStreamSer
 			return lastTimestamp, stream.Context().Err()
 		default:
 		}
+		if err := budget.acquire(ctx, len(readBuf)); err != nil {
+			return lastTimestamp, err
+		}
 		n, err := rc.Read(readBuf)
+		stats.count(n)
 		if n > 0 {
 			b := readBuf[:n]
 			// Extract timestamp from the last complete line in the buffer to enable resume capability.
@@ -382,27 +547,36 @@ func (a *Agent) streamLogs(ctx context.Context, stream logstreamapi.LogStreamSer
 					lastTimestamp = ts
 				}
 			}
-			if sendErr := stream.Send(&logstreamapi.LogStreamData{
-				RequestUuid: logReq.UUID,
-				Data:        readBuf[:n],
-			}); sendErr != nil {
-				// For client side streaming, the actual gRPC error may only surface
-				// after stream closure. Attempt to close and return the final error.
-				if _, closedErr := stream.CloseAndRecv(); closedErr != nil {
-					return lastTimestamp, closedErr
+			if data := redactor.redact(dedup.filter(b)); len(data) > 0 {
+				if sendErr := stream.Send(&logstreamapi.LogStreamData{
+					RequestUuid: logReq.UUID,
+					Data:        data,
+				}); sendErr != nil {
+					// For client side streaming, the actual gRPC error may only surface
+					// after stream closure. Attempt to close and return the final error.
+					if _, closedErr := a.closeLogStream(stream, logCtx); closedErr != nil {
+						return lastTimestamp, closedErr
+					}
+					return lastTimestamp, sendErr
 				}
-				return lastTimestamp, sendErr
+				stats.sent(data)
 			}
 		}
+		budget.release()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				logCtx.WithError(err).Info("Log stream ended")
-				_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true})
-				_, _ = stream.CloseAndRecv()
+				if data := append(redactor.redact(dedup.flush()), redactor.flush()...); len(data) > 0 {
+					if stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Data: data}) == nil {
+						stats.sent(data)
+					}
+				}
+				_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Eof: true, Stats: stats.final(logReq)})
+				_, _ = a.closeLogStream(stream, logCtx)
 				return lastTimestamp, nil
 			}
-			_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Error: err.Error()})
-			_, _ = stream.CloseAndRecv()
+			_ = stream.Send(&logstreamapi.LogStreamData{RequestUuid: logReq.UUID, Error: err.Error(), Stats: stats.final(logReq)})
+			_, _ = a.closeLogStream(stream, logCtx)
 			return lastTimestamp, err
 		}
 	}
@@ -16,6 +16,7 @@ package agent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"sync"
@@ -23,15 +24,22 @@ import (
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
 	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
 	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -137,10 +145,9 @@ func (m *MockReadCloser) IsClosed() bool {
 func createTestAgent() *Agent {
 	ctx, cancel := context.WithCancel(context.Background())
 	agent := &Agent{
-		context:      ctx,
-		cancelFn:     cancel,
-		inflightLogs: make(map[string]struct{}),
-		inflightMu:   sync.Mutex{},
+		context:  ctx,
+		cancelFn: cancel,
+		inflight: inflight.NewRegistry(),
 	}
 	return agent
 }
@@ -149,15 +156,30 @@ func createTestAgentWithKubeClient() *Agent {
 	ctx, cancel := context.WithCancel(context.Background())
 	kubeClient := kube.NewKubernetesFakeClientWithResources()
 	agent := &Agent{
-		context:      ctx,
-		cancelFn:     cancel,
-		kubeClient:   kubeClient,
-		inflightLogs: make(map[string]struct{}),
-		inflightMu:   sync.Mutex{},
+		context:    ctx,
+		cancelFn:   cancel,
+		kubeClient: kubeClient,
+		logSource:  NewKubernetesLogSource(kubeClient.Clientset),
+		inflight:   inflight.NewRegistry(),
 	}
 	return agent
 }
 
+// replayLogSource replays recorded logs of containers, by pod name
+type replayLogSource struct {
+	logs     map[string]string
+	requests []*event.ContainerLogRequest
+}
+
+func (s *replayLogSource) GetLogStream(_ context.Context, req *event.ContainerLogRequest) (io.ReadCloser, error) {
+	s.requests = append(s.requests, req)
+	logs, ok := s.logs[req.PodName]
+	if !ok {
+		return nil, fmt.Errorf("no logs for pod %s", req.PodName)
+	}
+	return io.NopCloser(strings.NewReader(logs)), nil
+}
+
 func createTestLogRequest(follow bool) *event.ContainerLogRequest {
 	return &event.ContainerLogRequest{
 		UUID:       uuid.New().String(),
@@ -216,12 +238,11 @@ func TestExtractTimestamp(t *testing.T) {
 	}
 }
 
-// Test createKubernetesLogStream
-func TestCreateKubernetesLogStream(t *testing.T) {
+func TestKubernetesLogSource(t *testing.T) {
 	ctx := context.Background()
 	logReq := createTestLogRequest(false)
 
-	t.Run("Test createKubernetesLogStream with fake kube client and pod", func(t *testing.T) {
+	t.Run("Logs of existing pod", func(t *testing.T) {
 		agent := createTestAgentWithKubeClient()
 		// Create a test pod
 		pod := &corev1.Pod{
@@ -241,7 +262,7 @@ func TestCreateKubernetesLogStream(t *testing.T) {
 		_, err := agent.kubeClient.Clientset.CoreV1().Pods(logReq.Namespace).Create(ctx, pod, metav1.CreateOptions{})
 		require.NoError(t, err)
 		// Now test the log stream creation
-		rc, err := agent.createKubernetesLogStream(ctx, logReq)
+		rc, err := agent.logSource.GetLogStream(ctx, logReq)
 		// The fake client actually supports streaming and returns a valid ReadCloser
 		assert.NoError(t, err)
 		assert.NotNil(t, rc)
@@ -251,12 +272,12 @@ func TestCreateKubernetesLogStream(t *testing.T) {
 			rc.Close()
 		}
 	})
-	t.Run("Test createKubernetesLogStream with non-existent pod", func(t *testing.T) {
+	t.Run("Logs of non-existent pod", func(t *testing.T) {
 		agent := createTestAgentWithKubeClient()
 		// Test with a non-existent pod
 		logReqNotFound := createTestLogRequest(false)
 		logReqNotFound.PodName = "non-existent-pod"
-		_, err := agent.createKubernetesLogStream(ctx, logReqNotFound)
+		_, err := agent.logSource.GetLogStream(ctx, logReqNotFound)
 		assert.NoError(t, err)
 	})
 }
@@ -268,19 +289,44 @@ func TestStartLogStreamIfNew(t *testing.T) {
 		logReq := createTestLogRequest(false)
 		agent := createTestAgent()
 		// Add a duplicate request
-		agent.inflightMu.Lock()
-		agent.inflightLogs[logReq.UUID] = struct{}{}
-		agent.inflightMu.Unlock()
-		err := agent.startLogStreamIfNew(logReq, logCtx)
+		_, done, err := agent.inflight.Start(agent.context, InflightLogs, logReq.UUID, nil)
+		require.NoError(t, err)
+		defer done()
+		err = agent.startLogStreamIfNew(logReq, logCtx)
 		assert.NoError(t, err) // Should return early for duplicate
 	})
+	t.Run("deadline already passed", func(t *testing.T) {
+		logReq := createTestLogRequest(false)
+		deadline := time.Now().Add(-time.Second)
+		logReq.Deadline = &deadline
+		agent := createTestAgent()
+		err := agent.startLogStreamIfNew(logReq, logCtx)
+		assert.NoError(t, err)
+		assert.Empty(t, agent.InflightRequests())
+	})
+	t.Run("timeout counts from receipt regardless of the principal's clock", func(t *testing.T) {
+		logReq := createTestLogRequest(false)
+		// The principal's clock is behind, its deadline has passed on ours
+		deadline := time.Now().Add(-time.Hour)
+		logReq.Deadline = &deadline
+		timeout := int64(60)
+		logReq.TimeoutSeconds = &timeout
+		agent := createTestAgent()
+		// The request is not dropped, but fails for the missing connection
+		err := agent.startLogStreamIfNew(logReq, logCtx)
+		assert.ErrorContains(t, err, "no connection to the principal")
+	})
 	t.Run("new request", func(t *testing.T) {
 		logReq := createTestLogRequest(false)
 		agent := createTestAgent()
-		// This will panic due to missing dependencies, but we can check if new request is processed
-		assert.Panics(t, func() {
-			agent.startLogStreamIfNew(logReq, logCtx)
-		})
+		src := &replayLogSource{logs: map[string]string{logReq.PodName: "2025-12-07T10:30:45Z line 1\n"}}
+		agent.logSource = src
+		// There is no connection to the principal to stream the logs to, so
+		// the logs are not even opened
+		err := agent.startLogStreamIfNew(logReq, logCtx)
+		assert.ErrorContains(t, err, "no connection to the principal")
+		assert.Empty(t, src.requests)
+		assert.Empty(t, agent.InflightRequests())
 	})
 }
 
@@ -304,6 +350,11 @@ func TestStreamLogsToCompletion(t *testing.T) {
 		// Check that the last message is EOF
 		lastMessage := sentData[len(sentData)-1]
 		assert.True(t, lastMessage.Eof)
+		// The EOF message carries the stream's statistics
+		require.NotNil(t, lastMessage.Stats)
+		assert.Equal(t, int64(2), lastMessage.Stats.Lines)
+		assert.Equal(t, int64(len(testData)), lastMessage.Stats.Bytes)
+		assert.False(t, lastMessage.Stats.Truncated)
 	})
 	t.Run("context cancellation", func(t *testing.T) {
 		cancelCtx, cancel := context.WithCancel(ctx)
@@ -333,7 +384,7 @@ func TestStreamLogs(t *testing.T) {
 		testData := "2025-12-07T10:30:45Z line 1\n2025-12-07T10:30:46Z line 2\n"
 		reader := &MockReadCloser{Reader: strings.NewReader(testData)}
 		logReq.Timestamps = true
-		lastTimestamp, streamErr = agent.streamLogs(testCtx, mockStream, reader, logReq, logCtx)
+		lastTimestamp, streamErr = agent.streamLogs(testCtx, mockStream, reader, logReq, nil, nil, logCtx)
 		// Check if data was sent before cancelling
 		sentData := mockStream.GetSentData()
 		// Verify data was sent due to timer flush
@@ -354,7 +405,7 @@ func TestStreamLogs(t *testing.T) {
 		mockStream.SetSendFunc(func(data *logstreamapi.LogStreamData) error {
 			return sendErr
 		})
-		lastTimestamp, streamErr := agent.streamLogs(testCtx, mockStream, reader, logReq, logCtx)
+		lastTimestamp, streamErr := agent.streamLogs(testCtx, mockStream, reader, logReq, nil, nil, logCtx)
 		require.ErrorIs(t, streamErr, sendErr)
 		require.NotNil(t, lastTimestamp, "last timestamp should be captured before send failure")
 		assert.Equal(t, 2025, lastTimestamp.Year())
@@ -364,7 +415,123 @@ func TestStreamLogs(t *testing.T) {
 	})
 }
 
+// failingLogStreamClient is a log stream whose principal ends it with err
+type failingLogStreamClient struct {
+	*MockLogStreamClient
+	err error
+}
+
+func (m *failingLogStreamClient) CloseAndRecv() (*logstreamapi.LogStreamResponse, error) {
+	return nil, m.err
+}
+
+func TestCloseLogStream(t *testing.T) {
+	agent := createTestAgentWithKubeClient()
+	agent.metrics = metrics.NewAgentMetricsWith(prometheus.NewRegistry())
+	logCtx := logrus.NewEntry(logrus.New())
+
+	t.Run("successful stream is recorded", func(t *testing.T) {
+		mockStream := NewMockLogStreamClient(context.Background(), "req")
+		resp, err := agent.closeLogStream(mockStream, logCtx)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, float64(1), testutil.ToFloat64(agent.metrics.LogStreams.WithLabelValues("OK", "unknown")))
+	})
+
+	t.Run("response is taken from the error status", func(t *testing.T) {
+		st, err := status.New(codes.Canceled, "client detached timeout").WithDetails(&logstreamapi.LogStreamResponse{
+			RequestUuid:   "req",
+			BytesReceived: 42,
+			ErrorCode:     codes.Canceled.String(),
+			WriterStatus:  "detached",
+		})
+		require.NoError(t, err)
+		mockStream := &failingLogStreamClient{MockLogStreamClient: NewMockLogStreamClient(context.Background(), "req"), err: st.Err()}
+		resp, err := agent.closeLogStream(mockStream, logCtx)
+		require.Equal(t, codes.Canceled, status.Code(err))
+		require.NotNil(t, resp)
+		assert.Equal(t, int64(42), resp.GetBytesReceived())
+		assert.Equal(t, float64(1), testutil.ToFloat64(agent.metrics.LogStreams.WithLabelValues("Canceled", "detached")))
+		assert.Equal(t, float64(42), testutil.ToFloat64(agent.metrics.LogStreamBytes))
+	})
+
+	t.Run("plain errors carry no response", func(t *testing.T) {
+		mockStream := &failingLogStreamClient{MockLogStreamClient: NewMockLogStreamClient(context.Background(), "req"), err: errors.New("boom")}
+		resp, err := agent.closeLogStream(mockStream, logCtx)
+		require.Error(t, err)
+		assert.Nil(t, resp)
+	})
+}
+
 // Helper function to create time pointer
 func timePtr(t time.Time) *time.Time {
 	return &t
 }
+
+func TestLogStreamBackoff(t *testing.T) {
+	t.Run("Default settings are valid", func(t *testing.T) {
+		assert.NoError(t, DefaultLogStreamBackoff().Validate())
+	})
+
+	t.Run("Retry forever and wait indefinitely", func(t *testing.T) {
+		b := DefaultLogStreamBackoff()
+		b.MaxElapsedTime = 0
+		b.ReconnectWait = 0
+		assert.NoError(t, b.Validate())
+	})
+
+	t.Run("Invalid settings", func(t *testing.T) {
+		for name, modify := range map[string]func(b *LogStreamBackoff){
+			"initial interval":    func(b *LogStreamBackoff) { b.InitialInterval = 0 },
+			"max interval":        func(b *LogStreamBackoff) { b.MaxInterval = b.InitialInterval / 2 },
+			"multiplier":          func(b *LogStreamBackoff) { b.Multiplier = 0.5 },
+			"max elapsed time":    func(b *LogStreamBackoff) { b.MaxElapsedTime = -time.Second },
+			"reconnect wait time": func(b *LogStreamBackoff) { b.ReconnectWait = -time.Second },
+		} {
+			t.Run(name, func(t *testing.T) {
+				b := DefaultLogStreamBackoff()
+				modify(&b)
+				assert.Error(t, b.Validate())
+				_, err := NewAgent(context.Background(), nil, "argocd", WithLogStreamBackoff(b))
+				assert.ErrorContains(t, err, "invalid log stream backoff")
+			})
+		}
+	})
+}
+
+// notFoundLogSource is the Kubernetes API after a pod was deleted
+type notFoundLogSource struct{}
+
+func (notFoundLogSource) GetLogStream(_ context.Context, req *event.ContainerLogRequest) (io.ReadCloser, error) {
+	return nil, apierrors.NewNotFound(corev1.Resource("pods"), req.PodName)
+}
+
+func TestHistoricalLogSource(t *testing.T) {
+	ctx := context.Background()
+	historical := &replayLogSource{logs: map[string]string{"deleted-pod": "2025-12-07T10:30:45Z line 1\n"}}
+	src := &fallbackLogSource{primary: notFoundLogSource{}, historical: historical}
+
+	t.Run("Logs of deleted pod are read from the historical source", func(t *testing.T) {
+		logReq := createTestLogRequest(false)
+		logReq.PodName = "deleted-pod"
+		rc, err := src.GetLogStream(ctx, logReq)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, "2025-12-07T10:30:45Z line 1\n", string(data))
+	})
+
+	t.Run("Pod unknown to both sources is not found", func(t *testing.T) {
+		_, err := src.GetLogStream(ctx, createTestLogRequest(false))
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("Logs of existing pod are read from the primary source", func(t *testing.T) {
+		historical.requests = nil
+		primary := &replayLogSource{logs: map[string]string{"test-pod": "live\n"}}
+		src := &fallbackLogSource{primary: primary, historical: historical}
+		_, err := src.GetLogStream(ctx, createTestLogRequest(false))
+		require.NoError(t, err)
+		assert.Empty(t, historical.requests)
+	})
+}
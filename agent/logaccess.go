@@ -0,0 +1,95 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v3/util/glob"
+)
+
+// logAccessRules decide which pods the principal may read the logs of,
+// regardless of the permissions the requesting user has on the principal.
+//
+// Each rule is a glob pattern matching either a namespace, e.g. "team-*", or
+// a pod within a namespace, e.g. "kube-system/etcd-*". Logs of a pod can be
+// read if it matches none of the deny rules and, unless there are no allow
+// rules, any of the allow rules.
+type logAccessRules struct {
+	allow []string
+	deny  []string
+}
+
+// WithLogAccessRules restricts the pods whose logs the principal can read,
+// see logAccessRules. Logs of all pods can be read if both allow and deny are
+// empty.
+func WithLogAccessRules(allow, deny []string) AgentOption {
+	return func(a *Agent) error {
+		if len(allow) == 0 && len(deny) == 0 {
+			return nil
+		}
+		for _, p := range append(append([]string{}, allow...), deny...) {
+			if err := validateLogAccessRule(p); err != nil {
+				return err
+			}
+		}
+		a.options.logAccessRules = &logAccessRules{allow: allow, deny: deny}
+		return nil
+	}
+}
+
+func validateLogAccessRule(pattern string) error {
+	ns, pod, _ := strings.Cut(pattern, "/")
+	if ns == "" || strings.Contains(pod, "/") {
+		return fmt.Errorf("invalid log access rule %q: must be <namespace> or <namespace>/<pod>", pattern)
+	}
+	if _, err := glob.MatchWithError(pattern, ""); err != nil {
+		return fmt.Errorf("invalid log access rule %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// allowed returns whether the logs of the given pod can be read
+func (r *logAccessRules) allowed(namespace, pod string) bool {
+	if r == nil {
+		return true
+	}
+	if matchLogAccessRules(r.deny, namespace, pod) {
+		return false
+	}
+	return len(r.allow) == 0 || matchLogAccessRules(r.allow, namespace, pod)
+}
+
+// check returns an error if the logs of the given pod cannot be read
+func (r *logAccessRules) check(namespace, pod string) error {
+	if r.allowed(namespace, pod) {
+		return nil
+	}
+	return fmt.Errorf("the agent does not allow reading the logs of pod %s/%s", namespace, pod)
+}
+
+func matchLogAccessRules(rules []string, namespace, pod string) bool {
+	for _, p := range rules {
+		if strings.Contains(p, "/") {
+			if glob.Match(p, namespace+"/"+pod, '/') {
+				return true
+			}
+		} else if glob.Match(p, namespace) {
+			return true
+		}
+	}
+	return false
+}
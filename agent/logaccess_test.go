@@ -0,0 +1,72 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_logAccessRules(t *testing.T) {
+	t.Run("No rules allow all pods", func(t *testing.T) {
+		var r *logAccessRules
+		assert.True(t, r.allowed("kube-system", "etcd-0"))
+		assert.NoError(t, r.check("kube-system", "etcd-0"))
+	})
+
+	t.Run("Allow rules restrict namespaces and pods", func(t *testing.T) {
+		r := &logAccessRules{allow: []string{"team-*", "monitoring/prometheus-*"}}
+		assert.True(t, r.allowed("team-a", "web-0"))
+		assert.True(t, r.allowed("monitoring", "prometheus-0"))
+		assert.False(t, r.allowed("monitoring", "alertmanager-0"))
+		assert.False(t, r.allowed("kube-system", "etcd-0"))
+	})
+
+	t.Run("Deny rules take precedence", func(t *testing.T) {
+		r := &logAccessRules{allow: []string{"team-*"}, deny: []string{"team-secret", "*/vault-*"}}
+		assert.True(t, r.allowed("team-a", "web-0"))
+		assert.False(t, r.allowed("team-secret", "web-0"))
+		assert.False(t, r.allowed("team-a", "vault-0"))
+		assert.EqualError(t, r.check("team-a", "vault-0"), "the agent does not allow reading the logs of pod team-a/vault-0")
+	})
+
+	t.Run("Namespace rules only match the namespace", func(t *testing.T) {
+		r := &logAccessRules{deny: []string{"kube-*"}}
+		assert.False(t, r.allowed("kube-system", "etcd-0"))
+		assert.True(t, r.allowed("default", "kube-proxy"))
+	})
+}
+
+func Test_WithLogAccessRules(t *testing.T) {
+	t.Run("Valid rules", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithLogAccessRules([]string{"team-*"}, []string{"kube-system/*"})(a))
+		require.NotNil(t, a.options.logAccessRules)
+	})
+
+	t.Run("No rules", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithLogAccessRules(nil, nil)(a))
+		assert.Nil(t, a.options.logAccessRules)
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		for _, p := range []string{"", "/pod", "a/b/c", "team-["} {
+			assert.Error(t, WithLogAccessRules([]string{p}, nil)(&Agent{}), p)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+)
+
+// logCancelRequests records when the principal asked to cancel log streams,
+// so that the agent can confirm the cancellation once the container log
+// stream is closed.
+type logCancelRequests struct {
+	mu sync.Mutex
+	// received is when the cancellation of a log stream was requested, by
+	// request UUID
+	received map[string]time.Time
+}
+
+func (r *logCancelRequests) add(requestUUID string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.received == nil {
+		r.received = make(map[string]time.Time)
+	}
+	r.received[requestUUID] = t
+}
+
+// take removes and returns when the cancellation of requestUUID was
+// requested. It returns false if it was not requested, or has been taken
+// already.
+func (r *logCancelRequests) take(requestUUID string) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.received[requestUUID]
+	delete(r.received, requestUUID)
+	return t, ok
+}
+
+// processIncomingLogCancel cancels the log stream of a request whose client
+// went away. The agent would otherwise only notice once it sends the next
+// log data, which may take long for quiet containers. The request is
+// acknowledged once the container log stream is closed.
+func (a *Agent) processIncomingLogCancel(ev *event.Event) error {
+	req, err := ev.LogStreamCancel()
+	if err != nil {
+		return err
+	}
+	a.logCancels.add(req.UUID, time.Now())
+	if a.inflight.Cancel(InflightLogs, req.UUID) {
+		log().WithField("uuid", req.UUID).Debug("Canceling log stream, its client went away")
+		return nil
+	}
+	// The stream has ended already, or has not been started
+	if _, ok := a.logCancels.take(req.UUID); !ok {
+		return nil
+	}
+	return a.sendLogCancelAck(&event.LogStreamCancelResult{UUID: req.UUID})
+}
+
+// confirmLogCancel acknowledges the cancellation of the log stream of
+// requestUUID once the stream has ended, if the principal requested it.
+func (a *Agent) confirmLogCancel(requestUUID string, logCtx *logrus.Entry) {
+	received, ok := a.logCancels.take(requestUUID)
+	if !ok {
+		return
+	}
+	res := &event.LogStreamCancelResult{
+		UUID:     requestUUID,
+		Running:  true,
+		Teardown: time.Since(received),
+	}
+	logCtx.WithField("teardown", res.Teardown).Debug("Log stream canceled")
+	if err := a.sendLogCancelAck(res); err != nil {
+		logCtx.WithError(err).Warn("Could not acknowledge log stream cancellation")
+	}
+}
+
+func (a *Agent) sendLogCancelAck(res *event.LogStreamCancelResult) error {
+	ack, err := a.emitter.LogStreamCancelAckEvent(res)
+	if err != nil {
+		return err
+	}
+	q := a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		return fmt.Errorf("no send queue available")
+	}
+	q.Add(ack)
+	return nil
+}
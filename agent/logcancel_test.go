@@ -0,0 +1,81 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/queue"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ProcessIncomingLogCancel(t *testing.T) {
+	principalEvents := event.NewEventSource("principal")
+	logCtx := logrus.NewEntry(logrus.New())
+
+	newAgent := func(t *testing.T) *Agent {
+		t.Helper()
+		a := createTestAgent()
+		a.queues = queue.NewSendRecvQueues()
+		a.emitter = event.NewEventSource("test-agent")
+		require.NoError(t, a.queues.Create(defaultQueueName))
+		return a
+	}
+	cancelEvent := func(t *testing.T, requestUUID string) *event.Event {
+		t.Helper()
+		ev, err := principalEvents.NewLogStreamCancelEvent(&event.LogStreamCancel{UUID: requestUUID})
+		require.NoError(t, err)
+		return event.New(ev, event.TargetLogCancel)
+	}
+	ack := func(t *testing.T, a *Agent) *event.LogStreamCancelResult {
+		t.Helper()
+		ev, shutdown := a.queues.SendQ(defaultQueueName).Get()
+		require.False(t, shutdown)
+		a.queues.SendQ(defaultQueueName).Done(ev)
+		res, err := event.New(ev, event.TargetLogCancel).LogStreamCancelResult()
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("Tears down a running stream before acknowledging", func(t *testing.T) {
+		a := newAgent(t)
+		ctx, done, err := a.inflight.Start(a.context, InflightLogs, "log-1", nil)
+		require.NoError(t, err)
+		go func() {
+			<-ctx.Done()
+			done()
+			a.confirmLogCancel("log-1", logCtx)
+		}()
+		require.NoError(t, a.processIncomingLogCancel(cancelEvent(t, "log-1")))
+		res := ack(t, a)
+		assert.Equal(t, "log-1", res.UUID)
+		assert.True(t, res.Running)
+		assert.Empty(t, a.InflightRequests())
+	})
+
+	t.Run("Acknowledges streams which are not running", func(t *testing.T) {
+		a := newAgent(t)
+		require.NoError(t, a.processIncomingLogCancel(cancelEvent(t, "log-1")))
+		res := ack(t, a)
+		assert.Equal(t, "log-1", res.UUID)
+		assert.False(t, res.Running)
+		// Streams ending later are not acknowledged again
+		a.confirmLogCancel("log-1", logCtx)
+		assert.Equal(t, 0, a.queues.SendQ(defaultQueueName).Len())
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"hash/fnv"
+	"time"
+)
+
+// logDedupWindow is the number of delivered lines remembered per live log
+// stream to suppress duplicates after a resume
+const logDedupWindow = 1024
+
+// logDeduplicator suppresses lines that are delivered again when a live log
+// stream is resumed.
+//
+// Kubernetes only accepts a resume point with a precision of seconds, so a
+// resumed stream starts at the beginning of the second of the last delivered
+// line, the boundary second. The deduplicator counts the lines delivered
+// within the boundary second, and skips as many lines of that second in the
+// resumed stream. As a safeguard, a line is only skipped if it matches one of
+// the last logDedupWindow delivered lines.
+type logDeduplicator struct {
+	lines  []uint64
+	next   int
+	seen   map[uint64]int
+	latest time.Time
+	// boundary is the second of the latest delivered line, boundaryLines the
+	// number of lines delivered within it
+	boundary      time.Time
+	boundaryLines int
+	// resuming is set until the first new line of a resumed stream, skip is
+	// the number of lines of the boundary second still to be skipped
+	resuming   bool
+	skip       int
+	partial    []byte
+	maxPartial int
+	// suppressed is the number of lines dropped since the last resume
+	suppressed int
+}
+
+func newLogDeduplicator(maxPartial int) *logDeduplicator {
+	return &logDeduplicator{
+		lines:      make([]uint64, 0, logDedupWindow),
+		seen:       make(map[uint64]int),
+		maxPartial: maxPartial,
+	}
+}
+
+// resume must be called before the lines of a resumed stream are filtered.
+// It returns the time to resume the stream from, which is zero if no line
+// with a timestamp was delivered yet. Any partial line held back from the
+// interrupted stream is discarded, as the resumed stream delivers it again.
+func (d *logDeduplicator) resume() time.Time {
+	if d == nil {
+		return time.Time{}
+	}
+	d.partial = nil
+	d.resuming = len(d.lines) > 0
+	d.skip = d.boundaryLines
+	d.suppressed = 0
+	return d.boundary
+}
+
+// filter returns the complete lines of data that were not delivered before,
+// including any partial line held back from the previous call. A partial line
+// longer than maxPartial is not held back any longer.
+func (d *logDeduplicator) filter(data []byte) []byte {
+	if d == nil {
+		return data
+	}
+	buf := append(d.partial, data...)
+	d.partial = nil
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	if end < len(buf) && len(buf)-end <= d.maxPartial {
+		d.partial = append([]byte{}, buf[end:]...)
+		buf = buf[:end]
+	}
+	if !d.resuming {
+		d.record(buf)
+		return buf
+	}
+	out := make([]byte, 0, len(buf))
+	for len(buf) > 0 {
+		n := bytes.IndexByte(buf, '\n') + 1
+		if n == 0 {
+			n = len(buf)
+		}
+		line := buf[:n]
+		buf = buf[n:]
+		if d.resuming && d.delivered(line) {
+			d.suppressed++
+			continue
+		}
+		d.resuming = false
+		d.remember(line)
+		out = append(out, line...)
+	}
+	return out
+}
+
+// delivered returns whether line of a resumed stream was delivered before
+// the stream was interrupted, and counts it as skipped if so.
+func (d *logDeduplicator) delivered(line []byte) bool {
+	if d.seen[hashLine(line)] == 0 {
+		return false
+	}
+	ts := extractTimestamp(string(trimEOL(line)))
+	switch {
+	case ts == nil:
+		// Without a timestamp, only the hash can tell
+		return true
+	case ts.Before(d.boundary):
+		return true
+	case ts.Before(d.boundary.Add(time.Second)) && d.skip > 0:
+		d.skip--
+		return true
+	}
+	return false
+}
+
+// flush returns the partial line held back, if any
+func (d *logDeduplicator) flush() []byte {
+	if d == nil || len(d.partial) == 0 {
+		return nil
+	}
+	buf := append(d.partial, '\n')
+	d.partial = nil
+	out := d.filter(buf)
+	if len(out) == 0 {
+		return nil
+	}
+	return out[:len(out)-1]
+}
+
+// record remembers all lines of buf as delivered
+func (d *logDeduplicator) record(buf []byte) {
+	for len(buf) > 0 {
+		n := bytes.IndexByte(buf, '\n') + 1
+		if n == 0 {
+			n = len(buf)
+		}
+		d.remember(buf[:n])
+		buf = buf[n:]
+	}
+}
+
+func (d *logDeduplicator) remember(line []byte) {
+	if ts := extractTimestamp(string(trimEOL(line))); ts != nil && !ts.Before(d.latest) {
+		d.latest = *ts
+		if second := ts.Truncate(time.Second); second.After(d.boundary) {
+			d.boundary = second
+			d.boundaryLines = 1
+		} else {
+			d.boundaryLines++
+		}
+	}
+	h := hashLine(line)
+	if len(d.lines) < logDedupWindow {
+		d.lines = append(d.lines, h)
+	} else {
+		old := d.lines[d.next]
+		if d.seen[old]--; d.seen[old] <= 0 {
+			delete(d.seen, old)
+		}
+		d.lines[d.next] = h
+		d.next = (d.next + 1) % logDedupWindow
+	}
+	d.seen[h]++
+}
+
+func hashLine(line []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(trimEOL(line))
+	return h.Sum64()
+}
+
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	return bytes.TrimSuffix(line, []byte{'\r'})
+}
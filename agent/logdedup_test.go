@@ -0,0 +1,142 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_logDeduplicator(t *testing.T) {
+	t.Run("Lines are passed through before a resume", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		assert.Equal(t, "2025-12-07T10:30:45Z a\n", string(d.filter([]byte("2025-12-07T10:30:45Z a\n"))))
+		assert.Equal(t, "2025-12-07T10:30:45Z a\n", string(d.filter([]byte("2025-12-07T10:30:45Z a\n"))))
+		assert.Zero(t, d.suppressed)
+	})
+
+	t.Run("Duplicates after resume are suppressed", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		d.filter([]byte("2025-12-07T10:30:44.900Z a\n2025-12-07T10:30:45.000Z b\n2025-12-07T10:30:45.100Z c\n"))
+		since := d.resume()
+		assert.Equal(t, time.Date(2025, 12, 7, 10, 30, 45, 0, time.UTC), since)
+		out := d.filter([]byte("2025-12-07T10:30:45.000Z b\n2025-12-07T10:30:45.100Z c\n2025-12-07T10:30:45.200Z d\n"))
+		assert.Equal(t, "2025-12-07T10:30:45.200Z d\n", string(out))
+		assert.Equal(t, 2, d.suppressed)
+
+		// Once a new line was delivered, repeated lines are delivered again
+		out = d.filter([]byte("2025-12-07T10:30:45.200Z d\n"))
+		assert.Equal(t, "2025-12-07T10:30:45.200Z d\n", string(out))
+	})
+
+	t.Run("New lines within the overlap are delivered", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		d.filter([]byte("2025-12-07T10:30:45.000Z a\n"))
+		d.resume()
+		out := d.filter([]byte("2025-12-07T10:30:45.000Z a\n2025-12-07T10:30:45.000Z other\n"))
+		assert.Equal(t, "2025-12-07T10:30:45.000Z other\n", string(out))
+	})
+
+	t.Run("Repeated lines within the boundary second are counted", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		d.filter([]byte("2025-12-07T10:30:45Z ping\n2025-12-07T10:30:45Z ping\n"))
+		d.resume()
+		out := d.filter([]byte("2025-12-07T10:30:45Z ping\n2025-12-07T10:30:45Z ping\n2025-12-07T10:30:45Z ping\n"))
+		assert.Equal(t, "2025-12-07T10:30:45Z ping\n", string(out))
+		assert.Equal(t, 2, d.suppressed)
+	})
+
+	t.Run("Lines before the boundary second are skipped", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		d.filter([]byte("2025-12-07T10:30:44.900Z a\n2025-12-07T10:30:45.100Z b\n"))
+		d.resume()
+		out := d.filter([]byte("2025-12-07T10:30:44.900Z a\n2025-12-07T10:30:45.100Z b\n2025-12-07T10:30:45.200Z c\n"))
+		assert.Equal(t, "2025-12-07T10:30:45.200Z c\n", string(out))
+	})
+
+	t.Run("Nothing to resume from", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		assert.True(t, d.resume().IsZero())
+		assert.Equal(t, "a\n", string(d.filter([]byte("a\n"))))
+	})
+
+	t.Run("Lines split across reads", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		d.filter([]byte("2025-12-07T10:30:45Z a\n"))
+		d.resume()
+		assert.Empty(t, d.filter([]byte("2025-12-07T10:")))
+		assert.Empty(t, d.filter([]byte("30:45Z a\n2025-12-07T10:30:46Z b")))
+		assert.Equal(t, "2025-12-07T10:30:46Z b", string(d.flush()))
+		assert.Nil(t, d.flush())
+	})
+
+	t.Run("Partial line of interrupted stream is discarded", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		assert.Equal(t, "2025-12-07T10:30:45Z a\n", string(d.filter([]byte("2025-12-07T10:30:45Z a\n2025-12-07T10:30:46Z b"))))
+		d.resume()
+		out := d.filter([]byte("2025-12-07T10:30:45Z a\n2025-12-07T10:30:46Z b\n"))
+		assert.Equal(t, "2025-12-07T10:30:46Z b\n", string(out))
+	})
+
+	t.Run("Only the last lines are remembered", func(t *testing.T) {
+		d := newLogDeduplicator(64)
+		for i := 0; i < logDedupWindow+10; i++ {
+			d.filter([]byte(fmt.Sprintf("2025-12-07T10:30:45Z line %d\n", i)))
+		}
+		assert.Len(t, d.lines, logDedupWindow)
+		assert.Len(t, d.seen, logDedupWindow)
+		d.resume()
+		out := d.filter([]byte("2025-12-07T10:30:45Z line 10\n2025-12-07T10:30:45Z line 0\n"))
+		assert.Equal(t, "2025-12-07T10:30:45Z line 0\n", string(out))
+	})
+
+	t.Run("Nil deduplicator passes data through", func(t *testing.T) {
+		var d *logDeduplicator
+		assert.True(t, d.resume().IsZero())
+		assert.Equal(t, "a", string(d.filter([]byte("a"))))
+		assert.Nil(t, d.flush())
+	})
+}
+
+func Test_streamLogsSuppressesDuplicatesAfterResume(t *testing.T) {
+	agent := createTestAgentWithKubeClient()
+	logReq := createTestLogRequest(true)
+	logCtx := logrus.NewEntry(logrus.New())
+	dedup := newLogDeduplicator(agent.logChunkMax())
+
+	first := "2025-12-07T10:30:45.500Z line 1\n2025-12-07T10:30:45.950Z line 2\n"
+	mockStream := NewMockLogStreamClient(context.Background(), logReq.UUID)
+	_, err := agent.streamLogs(context.Background(), mockStream, &MockReadCloser{Reader: strings.NewReader(first)}, logReq, dedup, nil, logCtx)
+	require.NoError(t, err)
+
+	// The resumed stream starts at the beginning of the second
+	dedup.resume()
+	resumed := first + "2025-12-07T10:30:46.100Z line 3\n"
+	mockStream = NewMockLogStreamClient(context.Background(), logReq.UUID)
+	_, err = agent.streamLogs(context.Background(), mockStream, &MockReadCloser{Reader: strings.NewReader(resumed)}, logReq, dedup, nil, logCtx)
+	require.NoError(t, err)
+	var sent strings.Builder
+	for _, d := range mockStream.GetSentData() {
+		sent.Write(d.Data)
+	}
+	assert.Equal(t, "2025-12-07T10:30:46.100Z line 3\n", sent.String())
+}
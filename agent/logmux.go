@@ -0,0 +1,300 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// logMuxCloseTimeout is how long the agent waits for the principal to answer
+// the end of a multiplexed log stream
+const logMuxCloseTimeout = time.Minute
+
+// logMux holds the log streams the agent multiplexes over its event stream
+type logMux struct {
+	mu sync.Mutex
+	// streams are the open multiplexed log streams, by stream ID
+	streams map[string]*muxLogStream
+}
+
+func (m *logMux) add(ms *muxLogStream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.streams == nil {
+		m.streams = make(map[string]*muxLogStream)
+	}
+	m.streams[ms.id] = ms
+}
+
+func (m *logMux) get(id string) *muxLogStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+func (m *logMux) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+}
+
+// useLogMux returns whether log streams are multiplexed over the event
+// stream
+func (a *Agent) useLogMux() bool {
+	return a.options.logStreamMultiplexing && a.eventWriter != nil && a.eventWriter.SchemaVersion() >= event.SchemaVersion14
+}
+
+// muxLogStream is a log stream multiplexed over the agent's event stream. It
+// behaves like the client of a LogStream RPC: its context is done once the
+// principal ended the stream or the connection to the principal was lost.
+type muxLogStream struct {
+	a      *Agent
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// sent is the sequence number of the last frame sent
+	sent uint64
+	// closeSent is set once the last frame was sent
+	closeSent bool
+	// consumed is the sequence number of the last frame the principal
+	// consumed
+	consumed uint64
+	// credit is signaled whenever consumed changes
+	credit chan struct{}
+	// closed is closed once the principal ended the stream, with resp and
+	// err telling how
+	closed chan struct{}
+	resp   *logstreamapi.LogStreamResponse
+	err    error
+}
+
+// openMuxLogStream opens a log stream multiplexed over the event stream
+func (a *Agent) openMuxLogStream(ctx context.Context) (*muxLogStream, error) {
+	connected, changed := a.WatchConnection()
+	if !connected {
+		return nil, status.Error(codes.Unavailable, "not connected to the principal")
+	}
+	ms := &muxLogStream{
+		a:      a,
+		id:     uuid.NewString(),
+		credit: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	ms.ctx, ms.cancel = context.WithCancel(ctx)
+	a.logMux.add(ms)
+	go func() {
+		select {
+		case <-changed:
+			ms.finish(nil, status.Error(codes.Unavailable, "connection to the principal lost"))
+		case <-ms.closed:
+		case <-ms.ctx.Done():
+			select {
+			case <-ms.closed:
+				return
+			default:
+			}
+			// Like the reset of a gRPC stream, this lets the principal know
+			// that the stream was abandoned
+			_ = ms.sendFrame(nil, true, true)
+			ms.finish(nil, status.FromContextError(ms.ctx.Err()).Err())
+		}
+	}()
+	return ms, nil
+}
+
+// finish ends the stream with the principal's response resp and err
+func (ms *muxLogStream) finish(resp *logstreamapi.LogStreamResponse, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	select {
+	case <-ms.closed:
+		return
+	default:
+	}
+	ms.resp, ms.err = resp, err
+	close(ms.closed)
+	ms.cancel()
+	ms.a.logMux.remove(ms.id)
+}
+
+// sendFrame sends the next frame of the stream, once the principal has
+// consumed enough of the earlier ones.
+func (ms *muxLogStream) sendFrame(data []byte, last, canceled bool) error {
+	for {
+		ms.mu.Lock()
+		if ms.closeSent {
+			ms.mu.Unlock()
+			return io.EOF
+		}
+		if last || ms.sent-ms.consumed < event.LogFrameWindow {
+			break
+		}
+		ms.mu.Unlock()
+		select {
+		case <-ms.credit:
+		case <-ms.closed:
+			return io.EOF
+		case <-ms.ctx.Done():
+			return ms.ctx.Err()
+		}
+	}
+	ms.sent++
+	ms.closeSent = last
+	frame := &event.LogFrame{StreamID: ms.id, Seq: ms.sent, Data: data, Close: last, Canceled: canceled}
+	ms.mu.Unlock()
+	ev, err := ms.a.emitter.LogFrameEvent(frame)
+	if err != nil {
+		return err
+	}
+	q := ms.a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		return status.Error(codes.Unavailable, "no send queue available")
+	}
+	q.Add(ev)
+	return nil
+}
+
+// Send sends a message to the principal. Like a gRPC stream, it returns
+// io.EOF once the principal ended the stream.
+func (ms *muxLogStream) Send(msg *logstreamapi.LogStreamData) error {
+	select {
+	case <-ms.closed:
+		return io.EOF
+	default:
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return ms.sendFrame(data, false, false)
+}
+
+// CloseAndRecv tells the principal that the agent has sent all messages, and
+// waits for the principal's response.
+func (ms *muxLogStream) CloseAndRecv() (*logstreamapi.LogStreamResponse, error) {
+	if err := ms.CloseSend(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	timer := time.NewTimer(logMuxCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-ms.closed:
+	case <-timer.C:
+		ms.finish(nil, status.Error(codes.DeadlineExceeded, "principal did not answer the end of the log stream"))
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.resp, ms.err
+}
+
+func (ms *muxLogStream) CloseSend() error {
+	select {
+	case <-ms.closed:
+		return io.EOF
+	default:
+	}
+	return ms.sendFrame(nil, true, false)
+}
+
+func (ms *muxLogStream) Context() context.Context {
+	return ms.ctx
+}
+
+func (ms *muxLogStream) Header() (metadata.MD, error) {
+	return metadata.MD{}, nil
+}
+
+func (ms *muxLogStream) Trailer() metadata.MD {
+	return metadata.MD{}
+}
+
+func (ms *muxLogStream) SendMsg(m any) error {
+	msg, ok := m.(*logstreamapi.LogStreamData)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message %T", m)
+	}
+	return ms.Send(msg)
+}
+
+func (ms *muxLogStream) RecvMsg(m any) error {
+	resp, ok := m.(*logstreamapi.LogStreamResponse)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message %T", m)
+	}
+	r, err := ms.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(resp, r)
+	return nil
+}
+
+// processIncomingLogFrame processes credits and the end of multiplexed log
+// streams sent by the principal.
+func (a *Agent) processIncomingLogFrame(ev *event.Event) error {
+	ctrl, err := ev.LogFrameControl()
+	if err != nil {
+		return err
+	}
+	ms := a.logMux.get(ctrl.StreamID)
+	if ms == nil {
+		return nil
+	}
+	switch ev.Type() {
+	case event.LogFrameCredit:
+		ms.mu.Lock()
+		ms.consumed = max(ms.consumed, ctrl.Consumed)
+		ms.mu.Unlock()
+		select {
+		case ms.credit <- struct{}{}:
+		default:
+		}
+	case event.LogFrameClosed:
+		var resp *logstreamapi.LogStreamResponse
+		if len(ctrl.Response) > 0 {
+			resp = &logstreamapi.LogStreamResponse{}
+			if err := proto.Unmarshal(ctrl.Response, resp); err != nil {
+				resp = nil
+			}
+		}
+		var serr error
+		if code := codes.Code(ctrl.Code); code != codes.OK {
+			st := status.New(code, ctrl.Message)
+			if resp != nil {
+				// Like a gRPC stream, the response is a detail of the error
+				if withResp, err := st.WithDetails(resp); err == nil {
+					st = withResp
+				}
+				resp = nil
+			}
+			serr = st.Err()
+		}
+		ms.finish(resp, serr)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_MuxLogStream(t *testing.T) {
+	principalEvents := event.NewEventSource("principal")
+
+	newConnectedAgent := func(t *testing.T) *Agent {
+		t.Helper()
+		a, _ := newAgent(t)
+		a.emitter = event.NewEventSource("test-agent")
+		a.SetConnected(true)
+		return a
+	}
+	// frame returns the next frame the agent sent
+	frame := func(t *testing.T, a *Agent) *event.LogFrame {
+		t.Helper()
+		q := a.queues.SendQ(defaultQueueName)
+		require.Eventually(t, func() bool { return q.Len() > 0 }, 5*time.Second, 10*time.Millisecond)
+		ev, _ := q.Get()
+		q.Done(ev)
+		f, err := event.New(ev, event.TargetLogFrame).LogFrame()
+		require.NoError(t, err)
+		return f
+	}
+	control := func(t *testing.T, evType event.EventType, ctrl *event.LogFrameControl) *event.Event {
+		t.Helper()
+		ev, err := principalEvents.LogFrameControlEvent(evType, ctrl)
+		require.NoError(t, err)
+		return event.New(ev, event.TargetLogFrame)
+	}
+
+	t.Run("Sends frames within the window and returns the response", func(t *testing.T) {
+		a := newConnectedAgent(t)
+		ms, err := a.openMuxLogStream(context.Background())
+		require.NoError(t, err)
+
+		sent := make(chan error, 1)
+		go func() {
+			for i := 0; i < event.LogFrameWindow+1; i++ {
+				if err := ms.Send(&logstreamapi.LogStreamData{RequestUuid: "req", Data: []byte("line\n")}); err != nil {
+					sent <- err
+					return
+				}
+			}
+			sent <- nil
+		}()
+		for seq := uint64(1); seq <= event.LogFrameWindow; seq++ {
+			f := frame(t, a)
+			assert.Equal(t, seq, f.Seq)
+			msg := &logstreamapi.LogStreamData{}
+			require.NoError(t, proto.Unmarshal(f.Data, msg))
+			assert.Equal(t, "req", msg.RequestUuid)
+		}
+		// The last frame waits for the principal to consume earlier ones
+		select {
+		case <-sent:
+			t.Fatal("frame sent beyond the window")
+		case <-time.After(50 * time.Millisecond):
+		}
+		require.NoError(t, a.processIncomingLogFrame(control(t, event.LogFrameCredit, &event.LogFrameControl{StreamID: ms.id, Consumed: event.LogFrameWindow / 2})))
+		require.NoError(t, <-sent)
+		assert.Equal(t, uint64(event.LogFrameWindow+1), frame(t, a).Seq)
+
+		respCh := make(chan *logstreamapi.LogStreamResponse, 1)
+		go func() {
+			resp, err := ms.CloseAndRecv()
+			assert.NoError(t, err)
+			respCh <- resp
+		}()
+		assert.True(t, frame(t, a).Close)
+		data, err := proto.Marshal(&logstreamapi.LogStreamResponse{RequestUuid: "req", LinesReceived: 33})
+		require.NoError(t, err)
+		require.NoError(t, a.processIncomingLogFrame(control(t, event.LogFrameClosed, &event.LogFrameControl{StreamID: ms.id, Response: data})))
+		assert.Equal(t, int32(33), (<-respCh).LinesReceived)
+		assert.Nil(t, a.logMux.get(ms.id))
+	})
+
+	t.Run("Ends like a gRPC stream when the principal fails it", func(t *testing.T) {
+		a := newConnectedAgent(t)
+		ms, err := a.openMuxLogStream(context.Background())
+		require.NoError(t, err)
+		data, err := proto.Marshal(&logstreamapi.LogStreamResponse{WriterStatus: "detached"})
+		require.NoError(t, err)
+		require.NoError(t, a.processIncomingLogFrame(control(t, event.LogFrameClosed, &event.LogFrameControl{StreamID: ms.id, Code: uint32(codes.Canceled), Message: "client detached", Response: data})))
+		<-ms.Context().Done()
+		assert.ErrorIs(t, ms.Send(&logstreamapi.LogStreamData{}), io.EOF)
+		resp, err := a.closeLogStream(ms, log())
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		require.NotNil(t, resp)
+		assert.Equal(t, "detached", resp.WriterStatus)
+	})
+
+	t.Run("Tells the principal when the stream is abandoned", func(t *testing.T) {
+		a := newConnectedAgent(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		ms, err := a.openMuxLogStream(ctx)
+		require.NoError(t, err)
+		cancel()
+		f := frame(t, a)
+		assert.True(t, f.Canceled)
+		_, err = ms.CloseAndRecv()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+
+	t.Run("Fails when the connection is lost", func(t *testing.T) {
+		a := newConnectedAgent(t)
+		ms, err := a.openMuxLogStream(context.Background())
+		require.NoError(t, err)
+		a.SetConnected(false)
+		_, err = ms.CloseAndRecv()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+
+		_, err = a.openMuxLogStream(context.Background())
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
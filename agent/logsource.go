@@ -0,0 +1,117 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LogSource provides the container logs requested by the principal. The
+// default source reads them from the Kubernetes API, alternative sources may
+// read them from a log aggregation system or replay them in tests.
+type LogSource interface {
+	// GetLogStream returns the requested logs. Each line should start with
+	// its RFC3339 timestamp, as returned by the Kubernetes API with
+	// timestamps enabled, so that interrupted live streams can be resumed.
+	GetLogStream(ctx context.Context, req *event.ContainerLogRequest) (io.ReadCloser, error)
+}
+
+// kubernetesLogSource reads container logs from the Kubernetes API
+type kubernetesLogSource struct {
+	clientset kubernetes.Interface
+}
+
+// NewKubernetesLogSource returns a LogSource reading container logs from the
+// Kubernetes API using clientset.
+func NewKubernetesLogSource(clientset kubernetes.Interface) LogSource {
+	return &kubernetesLogSource{clientset: clientset}
+}
+
+func (s *kubernetesLogSource) GetLogStream(ctx context.Context, logReq *event.ContainerLogRequest) (io.ReadCloser, error) {
+	logOptions := &corev1.PodLogOptions{
+		Container:                    logReq.Container,
+		Follow:                       logReq.Follow,
+		Timestamps:                   true,
+		Previous:                     logReq.Previous,
+		InsecureSkipTLSVerifyBackend: logReq.InsecureSkipTLSVerifyBackend,
+		TailLines:                    logReq.TailLines,
+		SinceSeconds:                 logReq.SinceSeconds,
+		LimitBytes:                   logReq.LimitBytes,
+	}
+	// Handle SinceTime if provided
+	if logReq.SinceTime != "" {
+		if sinceTime, err := time.Parse(time.RFC3339, logReq.SinceTime); err == nil {
+			mt := v1.NewTime(sinceTime)
+			logOptions.SinceTime = &mt
+		}
+	}
+	request := s.clientset.CoreV1().Pods(logReq.Namespace).GetLogs(logReq.PodName, logOptions)
+	return request.Stream(ctx)
+}
+
+// WithLogSource configures the source of the container logs requested by the
+// principal, instead of the Kubernetes API.
+func WithLogSource(src LogSource) AgentOption {
+	return func(a *Agent) error {
+		a.logSource = src
+		return nil
+	}
+}
+
+// WithHistoricalLogSource configures a source of historical container logs,
+// e.g. a log aggregation system. It serves the logs of pods the log source
+// does not find, because they are gone from the cluster.
+func WithHistoricalLogSource(src LogSource) AgentOption {
+	return func(a *Agent) error {
+		a.historicalLogSource = src
+		return nil
+	}
+}
+
+// fallbackLogSource reads logs from a historical source when the pod is not
+// found by the primary source
+type fallbackLogSource struct {
+	primary    LogSource
+	historical LogSource
+}
+
+func (s *fallbackLogSource) GetLogStream(ctx context.Context, req *event.ContainerLogRequest) (io.ReadCloser, error) {
+	rc, err := s.primary.GetLogStream(ctx, req)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return rc, err
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"namespace": req.Namespace,
+		"pod":       req.PodName,
+		"container": req.Container,
+	})
+	hrc, herr := s.historical.GetLogStream(ctx, req)
+	if herr != nil {
+		logCtx.WithError(herr).Debug("No historical logs of pod")
+		// The pod not being found is the more useful error
+		return nil, err
+	}
+	logCtx.Debug("Pod not found, serving historical logs")
+	return hrc, nil
+}
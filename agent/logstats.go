@@ -0,0 +1,96 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+)
+
+// logStreamStats counts the log data sent to the principal for a single log
+// request, so that the requesting client can tell whether it received the
+// complete logs. All methods may be called on a nil receiver.
+type logStreamStats struct {
+	lines int64
+	bytes int64
+	// read is the number of bytes read from the log source for the current
+	// attempt, used to detect whether the request's byte limit was hit
+	read      int64
+	truncated bool
+	resumes   int32
+	// partial is set if the data sent so far does not end with a newline
+	partial bool
+}
+
+// count records n bytes read from the log source
+func (s *logStreamStats) count(n int) {
+	if s == nil {
+		return
+	}
+	s.read += int64(n)
+}
+
+// sent records data as sent to the principal
+func (s *logStreamStats) sent(data []byte) {
+	if s == nil || len(data) == 0 {
+		return
+	}
+	s.lines += int64(bytes.Count(data, []byte{'\n'}))
+	s.bytes += int64(len(data))
+	s.partial = data[len(data)-1] != '\n'
+}
+
+// resume records that the log stream is resumed
+func (s *logStreamStats) resume() {
+	if s == nil {
+		return
+	}
+	s.resumes++
+	s.read = 0
+}
+
+// truncate records that the log stream ended before all logs were sent
+func (s *logStreamStats) truncate() {
+	if s == nil {
+		return
+	}
+	s.truncated = true
+}
+
+// final returns the statistics to send along with the final frame of the log
+// stream for logReq.
+func (s *logStreamStats) final(logReq *event.ContainerLogRequest) *logstreamapi.LogStreamStats {
+	if s == nil {
+		return nil
+	}
+	lines := s.lines
+	if s.partial {
+		lines++
+	}
+	truncated := s.truncated
+	if logReq != nil && logReq.LimitBytes != nil && s.read >= *logReq.LimitBytes {
+		// Kubernetes silently stops at the limit, so the logs were
+		// most likely cut off
+		truncated = true
+	}
+	return &logstreamapi.LogStreamStats{
+		Lines:     lines,
+		Bytes:     s.bytes,
+		Truncated: truncated,
+		Resumes:   s.resumes,
+	}
+}
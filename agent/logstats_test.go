@@ -0,0 +1,64 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_logStreamStats(t *testing.T) {
+	t.Run("Counts lines and bytes sent", func(t *testing.T) {
+		s := &logStreamStats{}
+		s.sent([]byte("line 1\nline 2\n"))
+		s.sent([]byte("line 3"))
+		stats := s.final(&event.ContainerLogRequest{})
+		assert.Equal(t, int64(3), stats.GetLines())
+		assert.Equal(t, int64(20), stats.GetBytes())
+		assert.False(t, stats.GetTruncated())
+		assert.Zero(t, stats.GetResumes())
+	})
+
+	t.Run("Hitting the byte limit truncates the logs", func(t *testing.T) {
+		limit := int64(10)
+		s := &logStreamStats{}
+		s.count(10)
+		assert.True(t, s.final(&event.ContainerLogRequest{LimitBytes: &limit}).GetTruncated())
+
+		// The limit applies to each resumed request
+		s.resume()
+		s.count(5)
+		stats := s.final(&event.ContainerLogRequest{LimitBytes: &limit})
+		assert.False(t, stats.GetTruncated())
+		assert.Equal(t, int32(1), stats.GetResumes())
+	})
+
+	t.Run("Read errors truncate the logs", func(t *testing.T) {
+		s := &logStreamStats{}
+		s.truncate()
+		assert.True(t, s.final(nil).GetTruncated())
+	})
+
+	t.Run("Nil stats", func(t *testing.T) {
+		var s *logStreamStats
+		s.count(1)
+		s.sent([]byte("a\n"))
+		s.resume()
+		s.truncate()
+		assert.Nil(t, s.final(nil))
+	})
+}
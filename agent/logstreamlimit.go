@@ -0,0 +1,116 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultLogStreamQueueTimeout is how long a log stream waits for one of the
+// limited log streams of the connection to become free by default
+const DefaultLogStreamQueueTimeout = 30 * time.Second
+
+// logStreamLimiter limits the number of log streams open on the connection to
+// the principal at the same time. Log streams exceeding the limit wait for a
+// free stream in the order they were created, until the queue timeout
+// expires. A nil logStreamLimiter does not limit log streams.
+type logStreamLimiter struct {
+	sem     *semaphore.Weighted
+	limit   int
+	timeout time.Duration
+}
+
+// newLogStreamLimiter returns a limiter for limit concurrent log streams, or
+// nil if limit is 0
+func newLogStreamLimiter(limit int, timeout time.Duration) *logStreamLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultLogStreamQueueTimeout
+	}
+	return &logStreamLimiter{sem: semaphore.NewWeighted(int64(limit)), limit: limit, timeout: timeout}
+}
+
+// acquire waits for a free log stream. The returned function frees it again
+// and may be called more than once.
+func (l *logStreamLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if !l.sem.TryAcquire(1) {
+		wctx, cancel := context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+		if err := l.sem.Acquire(wctx, 1); err != nil {
+			if ctx.Err() != nil {
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+			return nil, status.Errorf(codes.ResourceExhausted, "no log stream became free within %s, %d log streams are open", l.timeout, l.limit)
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(func() { l.sem.Release(1) }) }, nil
+}
+
+// limitedLogStream is a log stream that frees its place in the limiter once
+// it is closed or its context is done
+type limitedLogStream struct {
+	logstreamapi.LogStreamService_StreamLogsClient
+	release func()
+}
+
+func (s *limitedLogStream) CloseAndRecv() (*logstreamapi.LogStreamResponse, error) {
+	defer s.release()
+	return s.LogStreamService_StreamLogsClient.CloseAndRecv()
+}
+
+// limitLogStream waits for a free log stream, and opens the stream with open
+func (a *Agent) limitLogStream(ctx context.Context, open func() (logstreamapi.LogStreamService_StreamLogsClient, error)) (logstreamapi.LogStreamService_StreamLogsClient, error) {
+	if a.logStreamLimit == nil {
+		return open()
+	}
+	if a.metrics != nil {
+		a.metrics.LogStreamsWaiting.Inc()
+	}
+	release, err := a.logStreamLimit.acquire(ctx)
+	if a.metrics != nil {
+		a.metrics.LogStreamsWaiting.Dec()
+		if status.Code(err) == codes.ResourceExhausted {
+			a.metrics.LogStreamQueueTimeouts.Inc()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	stream, err := open()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// gRPC ends the context of a stream when the stream finishes, so that a
+	// stream that fails without being closed doesn't keep its place
+	stop := context.AfterFunc(stream.Context(), release)
+	return &limitedLogStream{LogStreamService_StreamLogsClient: stream, release: func() {
+		stop()
+		release()
+	}}, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogStreamLimiter(t *testing.T) {
+	t.Run("No limit", func(t *testing.T) {
+		l := newLogStreamLimiter(0, time.Second)
+		assert.Nil(t, l)
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		release()
+	})
+
+	t.Run("Default queue timeout", func(t *testing.T) {
+		l := newLogStreamLimiter(1, 0)
+		assert.Equal(t, DefaultLogStreamQueueTimeout, l.timeout)
+	})
+
+	t.Run("Waits for a free stream", func(t *testing.T) {
+		l := newLogStreamLimiter(1, 5*time.Second)
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		acquired := make(chan func())
+		go func() {
+			r, err := l.acquire(context.Background())
+			assert.NoError(t, err)
+			acquired <- r
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("stream acquired beyond the limit")
+		case <-time.After(50 * time.Millisecond):
+		}
+		release()
+		// Releasing twice must not free a second stream
+		release()
+		select {
+		case r := <-acquired:
+			assert.False(t, l.sem.TryAcquire(1))
+			r()
+		case <-time.After(time.Second):
+			t.Fatal("stream not acquired after release")
+		}
+	})
+
+	t.Run("Queue timeout", func(t *testing.T) {
+		l := newLogStreamLimiter(1, 20*time.Millisecond)
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+		_, err = l.acquire(context.Background())
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("Canceled while waiting", func(t *testing.T) {
+		l := newLogStreamLimiter(1, time.Minute)
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err = l.acquire(ctx)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+}
+
+func TestLimitLogStream(t *testing.T) {
+	newAgent := func(limit int, timeout time.Duration) *Agent {
+		a := createTestAgent()
+		a.logStreamLimit = newLogStreamLimiter(limit, timeout)
+		a.metrics = metrics.NewAgentMetricsWith(prometheus.NewRegistry())
+		return a
+	}
+
+	t.Run("Hundreds of simultaneous streams", func(t *testing.T) {
+		const limit, streams = 10, 300
+		a := newAgent(limit, time.Minute)
+		var open, maxOpen atomic.Int32
+		var wg sync.WaitGroup
+		errs := make(chan error, streams)
+		start := make(chan struct{})
+		for i := 0; i < streams; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				stream, err := a.limitLogStream(context.Background(), func() (logstreamapi.LogStreamService_StreamLogsClient, error) {
+					n := open.Add(1)
+					for m := maxOpen.Load(); n > m && !maxOpen.CompareAndSwap(m, n); m = maxOpen.Load() {
+					}
+					return NewMockLogStreamClient(context.Background(), "uuid"), nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+				open.Add(-1)
+				_, err = stream.CloseAndRecv()
+				errs <- err
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		assert.LessOrEqual(t, maxOpen.Load(), int32(limit))
+		assert.Equal(t, float64(0), testutil.ToFloat64(a.metrics.LogStreamsWaiting))
+		assert.Equal(t, float64(0), testutil.ToFloat64(a.metrics.LogStreamQueueTimeouts))
+		// All streams are free again
+		assert.True(t, a.logStreamLimit.sem.TryAcquire(limit))
+	})
+
+	t.Run("Overload times out the excess streams", func(t *testing.T) {
+		const limit, streams = 5, 200
+		a := newAgent(limit, 50*time.Millisecond)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var opened, timedOut atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < streams; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// The streams stay open until the end of the test
+				_, err := a.limitLogStream(ctx, func() (logstreamapi.LogStreamService_StreamLogsClient, error) {
+					return NewMockLogStreamClient(ctx, "uuid"), nil
+				})
+				if err == nil {
+					opened.Add(1)
+				} else if status.Code(err) == codes.ResourceExhausted {
+					timedOut.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(limit), opened.Load())
+		assert.Equal(t, int32(streams-limit), timedOut.Load())
+		assert.Equal(t, float64(streams-limit), testutil.ToFloat64(a.metrics.LogStreamQueueTimeouts))
+		assert.Equal(t, float64(0), testutil.ToFloat64(a.metrics.LogStreamsWaiting))
+	})
+
+	t.Run("Stream context ending frees the stream", func(t *testing.T) {
+		a := newAgent(1, time.Second)
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := a.limitLogStream(ctx, func() (logstreamapi.LogStreamService_StreamLogsClient, error) {
+			return NewMockLogStreamClient(ctx, "uuid"), nil
+		})
+		require.NoError(t, err)
+		cancel()
+		stream, err := a.limitLogStream(context.Background(), func() (logstreamapi.LogStreamService_StreamLogsClient, error) {
+			return NewMockLogStreamClient(context.Background(), "uuid"), nil
+		})
+		require.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Failing to open frees the stream", func(t *testing.T) {
+		a := newAgent(1, 20*time.Millisecond)
+		_, err := a.limitLogStream(context.Background(), func() (logstreamapi.LogStreamService_StreamLogsClient, error) {
+			return nil, status.Error(codes.Unavailable, "not connected")
+		})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.True(t, a.logStreamLimit.sem.TryAcquire(1))
+	})
+}
@@ -0,0 +1,199 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// memoryBudgetInterval is the interval in which the memory budget is
+	// enforced on queued events
+	memoryBudgetInterval = time.Second
+	// memoryBudgetPollInterval is the interval in which paused log reads
+	// check whether they may continue
+	memoryBudgetPollInterval = 100 * time.Millisecond
+)
+
+// Actions taken to stay within the memory budget, as reported in metrics
+const (
+	shedPauseLogs             = "pause_logs"
+	shedCoalesceStatusUpdates = "coalesce_status_updates"
+)
+
+// WithMemoryBudget limits the memory the agent uses for log data in transit
+// and events queued for the principal to limit bytes. When the budget is
+// exhausted, log streams pause reading, and queued status updates that are
+// superseded by later ones are dropped. A limit of 0 disables the budget.
+func WithMemoryBudget(limit int64) AgentOption {
+	return func(a *Agent) error {
+		if limit < 0 {
+			return fmt.Errorf("memory budget must not be negative")
+		}
+		a.options.memoryBudget = limit
+		return nil
+	}
+}
+
+// memoryBudget accounts for the memory used by log data in transit and
+// queued outbound events, and sheds load when it exceeds its limit. A nil
+// memoryBudget has no limit.
+type memoryBudget struct {
+	limit int64
+	// queued returns the size of the events waiting to be sent
+	queued func() int64
+	// coalesce drops superseded status updates from the send queue, and
+	// returns how many were dropped
+	coalesce func() int
+	metrics  *metrics.AgentMetrics
+
+	mu       sync.Mutex
+	logBytes int64
+	// exceeded is whether the budget was exceeded when last checked
+	exceeded bool
+}
+
+func newMemoryBudget(limit int64, queued func() int64, coalesce func() int, m *metrics.AgentMetrics) *memoryBudget {
+	if m != nil {
+		m.MemoryBudget.Set(float64(limit))
+	}
+	return &memoryBudget{limit: limit, queued: queued, coalesce: coalesce, metrics: m}
+}
+
+// logReservation is the part of the memory budget held by a log stream
+type logReservation struct {
+	b    *memoryBudget
+	held int64
+}
+
+// logStream returns a reservation for the log data of a single stream. The
+// caller must release it when the stream ends.
+func (b *memoryBudget) logStream() *logReservation {
+	return &logReservation{b: b}
+}
+
+// acquire reserves n bytes for reading log data, waiting while the budget is
+// exhausted. A stream that holds nothing may always read while the queued
+// events leave room, so that a small budget can't stall log streams forever.
+func (r *logReservation) acquire(ctx context.Context, n int) error {
+	if r.b == nil {
+		return nil
+	}
+	r.release()
+	paused := false
+	for !r.b.tryAcquire(int64(n)) {
+		if !paused {
+			paused = true
+			r.b.shed(shedPauseLogs, 1)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(memoryBudgetPollInterval):
+		}
+	}
+	r.held = int64(n)
+	return nil
+}
+
+// release returns the bytes held by r to the budget
+func (r *logReservation) release() {
+	if r.b == nil || r.held == 0 {
+		return
+	}
+	r.b.mu.Lock()
+	r.b.logBytes -= r.held
+	r.b.mu.Unlock()
+	r.held = 0
+}
+
+func (b *memoryBudget) tryAcquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queued := b.queued()
+	if b.logBytes+queued+n > b.limit && (b.logBytes > 0 || queued >= b.limit) {
+		return false
+	}
+	b.logBytes += n
+	return true
+}
+
+// usage returns the bytes used by log data and queued events
+func (b *memoryBudget) usage() (logs int64, events int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.logBytes, b.queued()
+}
+
+// shed records that the given action was taken count times to stay within
+// the budget
+func (b *memoryBudget) shed(action string, count int) {
+	if b.metrics != nil {
+		b.metrics.MemoryShedding.WithLabelValues(action).Add(float64(count))
+	}
+}
+
+// enforce checks the usage against the budget. Log reads are paused as long
+// as the budget is exceeded. If it still is, superseded status updates are
+// dropped from the send queue.
+func (b *memoryBudget) enforce() {
+	logs, events := b.usage()
+	if b.metrics != nil {
+		b.metrics.MemoryBuffered.WithLabelValues("logs").Set(float64(logs))
+		b.metrics.MemoryBuffered.WithLabelValues("events").Set(float64(events))
+	}
+	exceeded := logs+events > b.limit
+	logCtx := log().WithFields(logrus.Fields{
+		"limit":        b.limit,
+		"log_bytes":    logs,
+		"event_bytes":  events,
+		"memory_usage": logs + events,
+	})
+	if exceeded != b.exceeded {
+		b.exceeded = exceeded
+		if exceeded {
+			logCtx.Warn("Memory budget exceeded, shedding load")
+		} else {
+			logCtx.Info("Memory usage back within budget")
+		}
+	}
+	if !exceeded || events == 0 {
+		return
+	}
+	if dropped := b.coalesce(); dropped > 0 {
+		b.shed(shedCoalesceStatusUpdates, dropped)
+		logCtx.Warnf("Dropped %d superseded status updates to stay within the memory budget", dropped)
+	}
+}
+
+// run enforces the budget until ctx is done
+func (b *memoryBudget) run(ctx context.Context) {
+	ticker := time.NewTicker(memoryBudgetInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.enforce()
+		}
+	}
+}
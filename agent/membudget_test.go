@@ -0,0 +1,108 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_memoryBudget(t *testing.T) {
+	var queued atomic.Int64
+	coalesced := 0
+	newBudget := func(limit int64) *memoryBudget {
+		queued.Store(0)
+		coalesced = 0
+		return newMemoryBudget(limit, queued.Load, func() int {
+			coalesced++
+			queued.Store(0)
+			return 3
+		}, metrics.NewAgentMetricsWith(prometheus.NewRegistry()))
+	}
+
+	t.Run("No budget never blocks", func(t *testing.T) {
+		var b *memoryBudget
+		r := b.logStream()
+		require.NoError(t, r.acquire(context.Background(), 1<<30))
+		r.release()
+	})
+
+	t.Run("Log reads pause while the budget is exhausted", func(t *testing.T) {
+		b := newBudget(100)
+		r1, r2 := b.logStream(), b.logStream()
+		require.NoError(t, r1.acquire(context.Background(), 60))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, r2.acquire(ctx, 60), context.DeadlineExceeded)
+		assert.Equal(t, float64(1), testutil.ToFloat64(b.metrics.MemoryShedding.WithLabelValues(shedPauseLogs)))
+
+		done := make(chan error)
+		go func() { done <- r2.acquire(context.Background(), 60) }()
+		r1.release()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("log read was not resumed")
+		}
+		logs, _ := b.usage()
+		assert.Equal(t, int64(60), logs)
+		r2.release()
+		r2.release()
+		logs, _ = b.usage()
+		assert.Zero(t, logs)
+	})
+
+	t.Run("A single stream may read larger chunks than the budget", func(t *testing.T) {
+		b := newBudget(100)
+		r := b.logStream()
+		require.NoError(t, r.acquire(context.Background(), 200))
+		r.release()
+	})
+
+	t.Run("Queued events pause log reads", func(t *testing.T) {
+		b := newBudget(100)
+		queued.Store(100)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Error(t, b.logStream().acquire(ctx, 10))
+	})
+
+	t.Run("Status updates are coalesced while over budget", func(t *testing.T) {
+		b := newBudget(100)
+		queued.Store(50)
+		b.enforce()
+		assert.Zero(t, coalesced)
+		assert.Equal(t, float64(50), testutil.ToFloat64(b.metrics.MemoryBuffered.WithLabelValues("events")))
+
+		queued.Store(150)
+		b.enforce()
+		assert.Equal(t, 1, coalesced)
+		assert.True(t, b.exceeded)
+		assert.Equal(t, float64(3), testutil.ToFloat64(b.metrics.MemoryShedding.WithLabelValues(shedCoalesceStatusUpdates)))
+
+		b.enforce()
+		assert.False(t, b.exceeded)
+		assert.Equal(t, float64(100), testutil.ToFloat64(b.metrics.MemoryBudget))
+	})
+}
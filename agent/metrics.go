@@ -0,0 +1,101 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// processIncomingMetricsRequest handles requests from the principal for the
+// agent's metrics, which the principal relays to its own scrapers. The
+// metrics are gathered and sent in the background.
+func (a *Agent) processIncomingMetricsRequest(ev *event.Event) error {
+	req, err := ev.MetricsRequest()
+	if err != nil {
+		return err
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"method": "processIncomingMetricsRequest",
+		"uuid":   req.UUID,
+	})
+	logCtx.Debug("Processing metrics request")
+
+	go func() {
+		if err := a.sendMetrics(req, logCtx); err != nil {
+			logCtx.WithError(err).Error("Could not send metrics")
+		}
+	}()
+	return nil
+}
+
+// sendMetrics gathers the agent's metrics and sends them to the principal
+// over the FileTransfer service.
+func (a *Agent) sendMetrics(req *event.MetricsRequest, logCtx *logrus.Entry) error {
+	ctx, cancel := context.WithCancel(a.context)
+	defer cancel()
+
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if g, ok := a.options.metricsRegistry.(prometheus.Gatherer); ok {
+		gatherer = g
+	}
+	data, contentType, err := gatherMetrics(gatherer, req.Format)
+	if err != nil {
+		if serr := a.sendFileTransferError(ctx, req.UUID, err); serr != nil {
+			logCtx.WithError(serr).Warn("Could not report error to principal")
+		}
+		return err
+	}
+	_, err = a.uploadFile(ctx, &fileUpload{
+		requestUUID: req.UUID,
+		contentType: contentType,
+		src:         bytes.NewReader(data),
+		size:        int64(len(data)),
+	}, logCtx)
+	return err
+}
+
+// gatherMetrics encodes the metrics from g in the given exposition format,
+// falling back to the text format if format is empty. It returns the encoded
+// metrics and their content type.
+func gatherMetrics(g prometheus.Gatherer, format string) ([]byte, string, error) {
+	f := expfmt.Format(format)
+	if format == "" || f.FormatType() == expfmt.TypeUnknown {
+		f = expfmt.NewFormat(expfmt.TypeTextPlain)
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not gather metrics: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, f)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return nil, "", fmt.Errorf("could not encode metrics: %w", err)
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+	return buf.Bytes(), string(f), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_gatherMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "A test counter"})
+	reg.MustRegister(c)
+	c.Inc()
+
+	t.Run("Defaults to text format", func(t *testing.T) {
+		data, contentType, err := gatherMetrics(reg, "")
+		require.NoError(t, err)
+		assert.Equal(t, string(expfmt.NewFormat(expfmt.TypeTextPlain)), contentType)
+		assert.Contains(t, string(data), "test_total 1")
+	})
+
+	t.Run("Uses requested format", func(t *testing.T) {
+		f := expfmt.NewFormat(expfmt.TypeOpenMetrics)
+		data, contentType, err := gatherMetrics(reg, string(f))
+		require.NoError(t, err)
+		assert.Equal(t, string(f), contentType)
+		assert.Contains(t, string(data), "# EOF")
+	})
+
+	t.Run("Unknown format falls back to text", func(t *testing.T) {
+		_, contentType, err := gatherMetrics(reg, "application/unknown")
+		require.NoError(t, err)
+		assert.Equal(t, string(expfmt.NewFormat(expfmt.TypeTextPlain)), contentType)
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"github.com/argoproj-labs/argocd-agent/internal/nsmap"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// WithNamespaceMapping makes the agent translate namespaces between the
+// principal and its cluster according to rules, see package nsmap. The
+// mapping applies to the destination of Applications, the namespaces of their
+// resources, log and terminal requests, and the resource proxy.
+func WithNamespaceMapping(rules []string) AgentOption {
+	return func(a *Agent) error {
+		m, err := nsmap.New(rules)
+		if err != nil {
+			return err
+		}
+		a.options.namespaceMapper = m
+		return nil
+	}
+}
+
+// applicationToSpoke translates the destination namespace of app, as
+// received from the principal, to the agent's cluster.
+func (a *Agent) applicationToSpoke(app *v1alpha1.Application) {
+	m := a.options.namespaceMapper
+	if m == nil {
+		return
+	}
+	app.Spec.Destination.Namespace = m.ToSpoke(app.Spec.Destination.Namespace)
+}
+
+// applicationToHub returns app with its destination namespace and the
+// namespaces of its resources translated for the principal. app itself is
+// not modified.
+func (a *Agent) applicationToHub(app *v1alpha1.Application) *v1alpha1.Application {
+	m := a.options.namespaceMapper
+	if m == nil {
+		return app
+	}
+	mapped := app.DeepCopy()
+	mapped.Spec.Destination.Namespace = m.ToHub(mapped.Spec.Destination.Namespace)
+	for i := range mapped.Status.Resources {
+		mapped.Status.Resources[i].Namespace = m.ToHub(mapped.Status.Resources[i].Namespace)
+	}
+	if op := mapped.Status.OperationState; op != nil && op.SyncResult != nil {
+		for _, r := range op.SyncResult.Resources {
+			r.Namespace = m.ToHub(r.Namespace)
+		}
+	}
+	return mapped
+}
+
+// outgoingApplication returns app as it is sent to the principal
+func (a *Agent) outgoingApplication(app *v1alpha1.Application) *v1alpha1.Application {
+	return a.applicationToHub(a.filterResources(app))
+}
+
+// resourceToHub translates the namespace of the resource, or of the items of
+// the list, returned by the resource proxy for the principal
+func (a *Agent) resourceToHub(res *unstructured.Unstructured, list *unstructured.UnstructuredList) {
+	m := a.options.namespaceMapper
+	if m == nil {
+		return
+	}
+	if res != nil {
+		res.SetNamespace(m.ToHub(res.GetNamespace()))
+	}
+	if list != nil {
+		for i := range list.Items {
+			list.Items[i].SetNamespace(m.ToHub(list.Items[i].GetNamespace()))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_namespaceMapping(t *testing.T) {
+	a := &Agent{}
+	require.NoError(t, WithNamespaceMapping([]string{"team-a-*=*"})(a))
+
+	t.Run("Incoming application destination is mapped to the spoke", func(t *testing.T) {
+		app := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "team-a-web"}}}
+		a.applicationToSpoke(app)
+		assert.Equal(t, "web", app.Spec.Destination.Namespace)
+	})
+
+	t.Run("Outgoing application is mapped to the hub", func(t *testing.T) {
+		app := &v1alpha1.Application{
+			Spec: v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "web"}},
+			Status: v1alpha1.ApplicationStatus{
+				Resources: []v1alpha1.ResourceStatus{{Kind: "Deployment", Namespace: "web"}, {Kind: "Namespace"}},
+				OperationState: &v1alpha1.OperationState{SyncResult: &v1alpha1.SyncOperationResult{
+					Resources: v1alpha1.ResourceResults{{Kind: "Deployment", Namespace: "web"}},
+				}},
+			},
+		}
+		mapped := a.outgoingApplication(app)
+		assert.Equal(t, "team-a-web", mapped.Spec.Destination.Namespace)
+		assert.Equal(t, "team-a-web", mapped.Status.Resources[0].Namespace)
+		assert.Equal(t, "", mapped.Status.Resources[1].Namespace)
+		assert.Equal(t, "team-a-web", mapped.Status.OperationState.SyncResult.Resources[0].Namespace)
+		// The application on the agent is unchanged
+		assert.Equal(t, "web", app.Spec.Destination.Namespace)
+		assert.Equal(t, "web", app.Status.OperationState.SyncResult.Resources[0].Namespace)
+	})
+
+	t.Run("Proxied resources are mapped to the hub", func(t *testing.T) {
+		res := &unstructured.Unstructured{}
+		res.SetNamespace("web")
+		list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*res.DeepCopy()}}
+		a.resourceToHub(res, list)
+		assert.Equal(t, "team-a-web", res.GetNamespace())
+		assert.Equal(t, "team-a-web", list.Items[0].GetNamespace())
+	})
+
+	t.Run("Without mapping nothing is changed", func(t *testing.T) {
+		app := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "web"}}}
+		assert.Same(t, app, (&Agent{}).applicationToHub(app))
+	})
+
+	t.Run("Invalid mapping", func(t *testing.T) {
+		assert.Error(t, WithNamespaceMapping([]string{"team-a-*=web"})(&Agent{}))
+	})
+}
@@ -19,12 +19,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
 	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	"github.com/argoproj-labs/argocd-agent/pkg/client"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
@@ -57,6 +61,33 @@ func WithMode(mode string) AgentOption {
 	}
 }
 
+// WithMetricsRegistry makes the agent register its metrics with reg instead
+// of the default registry. Metrics are collected whenever a registry is
+// configured, even if the metrics server is not enabled, so programs
+// embedding the agent can serve them on their own. If reg is also a
+// prometheus.Gatherer, metrics requested by the principal are gathered from
+// it.
+func WithMetricsRegistry(reg prometheus.Registerer) AgentOption {
+	return func(o *Agent) error {
+		o.options.metricsRegistry = reg
+		return nil
+	}
+}
+
+// WithKubeClient configures the agent to access the Kubernetes API with the
+// given REST configuration, for programs embedding the agent. It takes
+// precedence over the client passed to NewAgent.
+func WithKubeClient(config *rest.Config) AgentOption {
+	return func(o *Agent) error {
+		kubeClient, err := kube.NewKubernetesClientForConfig(context.Background(), config, o.namespace)
+		if err != nil {
+			return fmt.Errorf("could not create Kubernetes client: %w", err)
+		}
+		o.kubeClient = kubeClient
+		return nil
+	}
+}
+
 func WithMetricsPort(port int) AgentOption {
 	return func(o *Agent) error {
 		if port > 0 && port < 32768 {
@@ -121,6 +152,70 @@ func WithHeartbeatInterval(interval time.Duration) AgentOption {
 	}
 }
 
+// WithLogStreamBackoff configures how interrupted log streams are retried.
+func WithLogStreamBackoff(b LogStreamBackoff) AgentOption {
+	return func(o *Agent) error {
+		if err := b.Validate(); err != nil {
+			return fmt.Errorf("invalid log stream backoff: %w", err)
+		}
+		o.options.logStreamBackoff = b
+		return nil
+	}
+}
+
+// WithInflightLimit sets the maximum number of long-running operations of the
+// given kind (e.g. log streams) the agent processes concurrently. A limit of 0
+// means no limit.
+func WithInflightLimit(kind inflight.Kind, limit int) AgentOption {
+	return func(o *Agent) error {
+		if limit < 0 {
+			return fmt.Errorf("limit for %s must not be negative", kind)
+		}
+		o.options.inflightOptions = append(o.options.inflightOptions, inflight.WithLimit(kind, limit))
+		return nil
+	}
+}
+
+// WithInflightMaxAge sets the maximum duration of long-running operations of
+// the given kind. Operations running longer are cancelled. A max age of 0
+// means no limit.
+func WithInflightMaxAge(kind inflight.Kind, maxAge time.Duration) AgentOption {
+	return func(o *Agent) error {
+		if maxAge < 0 {
+			return fmt.Errorf("max age for %s must not be negative", kind)
+		}
+		o.options.inflightOptions = append(o.options.inflightOptions, inflight.WithMaxAge(kind, maxAge))
+		return nil
+	}
+}
+
+// WithLogStreamChunkSize sets the size of the chunks in which container logs
+// are streamed to the principal.
+func WithLogStreamChunkSize(size int) AgentOption {
+	return func(o *Agent) error {
+		if size < minLogChunkSize || size > maxLogChunkSize {
+			return fmt.Errorf("log stream chunk size must be between %d and %d bytes", minLogChunkSize, maxLogChunkSize)
+		}
+		o.logChunkSize.Store(int64(size))
+		return nil
+	}
+}
+
+// WithConfigReload makes the agent watch the ConfigMap with the given name in
+// its namespace, and apply changes to the log level, the principal's
+// address, the log stream chunk size and the limits for log streams and
+// terminal sessions without a restart. Each applied change is reported to the
+// principal.
+func WithConfigReload(configMapName string) AgentOption {
+	return func(o *Agent) error {
+		if configMapName == "" {
+			return fmt.Errorf("config map name must not be empty")
+		}
+		o.config = &configReloader{configMap: configMapName}
+		return nil
+	}
+}
+
 func WithSubsystemLoggers(resourceProxy, redisProxy, grpcEvent *logrus.Logger) AgentOption {
 	return func(o *Agent) error {
 		if resourceProxy != nil {
@@ -216,3 +311,82 @@ func WithRedisTLSInsecure(insecure bool) AgentOption {
 		return nil
 	}
 }
+
+// WithLogStreamMultiplexing makes the agent send log streams to the principal
+// over its event stream, instead of opening a LogStream RPC for each. This
+// requires a principal supporting event schema version 14; the agent falls
+// back to LogStream RPCs otherwise.
+func WithLogStreamMultiplexing(enabled bool) AgentOption {
+	return func(o *Agent) error {
+		o.options.logStreamMultiplexing = enabled
+		return nil
+	}
+}
+
+// WithLogStreamConcurrency limits the number of log streams open on the
+// connection to the principal at the same time. Further log streams wait for
+// a free stream for up to queueTimeout, after which they fail. A limit of 0
+// means no limit, a queueTimeout of 0 DefaultLogStreamQueueTimeout.
+func WithLogStreamConcurrency(limit int, queueTimeout time.Duration) AgentOption {
+	return func(o *Agent) error {
+		if limit < 0 {
+			return fmt.Errorf("log stream concurrency must not be negative")
+		}
+		if queueTimeout < 0 {
+			return fmt.Errorf("log stream queue timeout must not be negative")
+		}
+		o.options.logStreamConcurrency = limit
+		o.options.logStreamQueueTimeout = queueTimeout
+		return nil
+	}
+}
+
+// WithServerSideApply makes the agent update Applications received from the
+// principal with server-side apply, using the argocd-agent field manager.
+// The agent then only owns the fields it synchronizes, and fields of the
+// Applications set by other controllers on the workload cluster are kept.
+func WithServerSideApply(enabled bool) AgentOption {
+	return func(o *Agent) error {
+		o.options.serverSideApply = enabled
+		return nil
+	}
+}
+
+// WithResyncBatchSize sets the number of resources sent per batch during an
+// initial sync with the principal. The agent waits for the previous batch to
+// be sent before queueing the next, so that the connection isn't blocked by
+// the whole inventory at once. A size of 0 sends all resources at once.
+func WithResyncBatchSize(size int) AgentOption {
+	return func(o *Agent) error {
+		if size < 0 {
+			return fmt.Errorf("resync batch size must not be negative")
+		}
+		o.options.resyncBatchSize = size
+		return nil
+	}
+}
+
+// WithResyncBookmarkMaxAge sets how long the agent uses the bookmarks of
+// Applications synced with the principal. While they are younger, a resync
+// requested by the principal only covers Applications that changed since
+// they were last synced. A maxAge of 0 disables incremental resyncs.
+func WithResyncBookmarkMaxAge(maxAge time.Duration) AgentOption {
+	return func(o *Agent) error {
+		if maxAge < 0 {
+			return fmt.Errorf("resync bookmark max age must not be negative")
+		}
+		o.options.resyncBookmarkMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithWarmCachePath makes the agent persist the bookmarks of Applications
+// synced with the principal to the file at path, and load them when it
+// starts. A restarted agent then only resyncs the Applications that changed
+// since, instead of all of them. An empty path disables the warm cache.
+func WithWarmCachePath(path string) AgentOption {
+	return func(o *Agent) error {
+		o.options.warmCachePath = path
+		return nil
+	}
+}
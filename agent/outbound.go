@@ -27,9 +27,11 @@ import (
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 	cacheutil "github.com/argoproj/argo-cd/v3/util/cache"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -56,6 +58,8 @@ func (a *Agent) addAppCreationToQueue(app *v1alpha1.Application) {
 		return
 	}
 
+	a.ensureAppServiceAccount(app)
+
 	// Only send the creation event when we're in autonomous mode
 	if !a.mode.IsAutonomous() {
 		return
@@ -67,7 +71,7 @@ func (a *Agent) addAppCreationToQueue(app *v1alpha1.Application) {
 		return
 	}
 
-	ev := a.emitter.ApplicationEvent(event.Create, app)
+	ev := a.emitter.ApplicationEvent(event.Create, a.outgoingApplication(app))
 	tracing.InjectTraceContext(ctx, ev)
 	q.Add(ev)
 	logCtx.WithField(logfields.SendQueueLen, q.Len()).WithField(logfields.SendQueueName, defaultQueueName).Debugf("Added app create event to send queue")
@@ -94,13 +98,16 @@ func (a *Agent) addAppUpdateToQueue(old *v1alpha1.Application, new *v1alpha1.App
 		return
 	}
 
-	// Revert any direct modifications done in application on managed-cluster
-	// because for managed-agent all changes should be done through principal
-	if reverted := a.appManager.RevertManagedAppChanges(a.context, new, a.sourceCache.Application); reverted {
+	// Revert direct modifications done in application on managed-cluster,
+	// because for managed-agent all changes should be done through principal,
+	// unless the conflict strategy lets them prevail
+	if reverted := a.resolveLocalAppChange(new); reverted {
 		logCtx.Debugf("Modifications done in application: %s are reverted", new.Name)
 		return
 	}
 
+	a.updateAppServiceAccount(old, new)
+
 	q := a.queues.SendQ(defaultQueueName)
 	if q == nil {
 		logCtx.Error("Default queue disappeared!")
@@ -117,7 +124,22 @@ func (a *Agent) addAppUpdateToQueue(old *v1alpha1.Application, new *v1alpha1.App
 		eventType = event.StatusUpdate
 	}
 
-	ev := a.emitter.ApplicationEvent(eventType, new)
+	app := a.outgoingApplication(new)
+	if eventType == event.StatusUpdate && a.options.statusReporter != nil && !a.options.statusReporter.changed(app) {
+		logCtx.Trace("Not sending status update because only timestamps changed")
+		if a.metrics != nil {
+			a.metrics.SuppressedStatusUpdates.Inc()
+		}
+		return
+	}
+
+	var ev *cloudevents.Event
+	if eventType == event.StatusUpdate && a.supportsSyncProgress() && isSyncProgress(old, new) {
+		eventType = event.SyncProgress
+		ev = a.emitter.SyncProgressEvent(app)
+	} else {
+		ev = a.emitter.ApplicationEvent(eventType, app)
+	}
 	tracing.InjectTraceContext(ctx, ev)
 	q.Add(ev)
 	logCtx.
@@ -126,6 +148,30 @@ func (a *Agent) addAppUpdateToQueue(old *v1alpha1.Application, new *v1alpha1.App
 		Debugf("Added event of type %s to send queue", eventType)
 }
 
+// supportsSyncProgress returns whether the principal understands sync
+// progress events
+func (a *Agent) supportsSyncProgress() bool {
+	return a.eventWriter != nil && a.eventWriter.SchemaVersion() >= event.SchemaVersion5
+}
+
+// isSyncProgress returns whether the only change from old to new is the
+// operation state of a running sync. Such changes are sent to the principal
+// as sync progress, which carries the operation state only.
+func isSyncProgress(old, new *v1alpha1.Application) bool {
+	op := new.Status.OperationState
+	if op == nil || op.Phase.Completed() || old.Status.OperationState == nil {
+		return false
+	}
+	if !equality.Semantic.DeepEqual(old.Operation, new.Operation) {
+		return false
+	}
+	oldStatus := old.Status.DeepCopy()
+	newStatus := new.Status.DeepCopy()
+	oldStatus.OperationState = nil
+	newStatus.OperationState = nil
+	return equality.Semantic.DeepEqual(oldStatus, newStatus)
+}
+
 // addAppDeletionToQueue processes an application delete event originating from
 // the AppInformer and puts it in the send queue.
 func (a *Agent) addAppDeletionToQueue(app *v1alpha1.Application) {
@@ -155,6 +201,10 @@ func (a *Agent) addAppDeletionToQueue(app *v1alpha1.Application) {
 	} else {
 		_ = a.appManager.Unmanage(app.QualifiedName())
 	}
+	a.removeAppServiceAccount(app)
+	if a.options.statusReporter != nil {
+		a.options.statusReporter.forget(app)
+	}
 
 	q := a.queues.SendQ(defaultQueueName)
 	if q == nil {
@@ -10,11 +10,13 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/event"
 	"github.com/argoproj-labs/argocd-agent/internal/manager"
 	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/eventstreamapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/client"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 	cacheutil "github.com/argoproj/argo-cd/v3/util/cache"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
@@ -88,6 +90,12 @@ func Test_addAppCreationToQueue(t *testing.T) {
 	})
 }
 
+// nopStream is an event stream that discards everything sent to it
+type nopStream struct{}
+
+func (nopStream) Send(*eventstreamapi.Event) error { return nil }
+func (nopStream) Context() context.Context         { return context.Background() }
+
 func Test_addAppUpdateToQueue(t *testing.T) {
 	a, _ := newAgent(t)
 	a.remote.SetClientID("agent")
@@ -126,6 +134,44 @@ func Test_addAppUpdateToQueue(t *testing.T) {
 		require.Equal(t, 0, a.queues.SendQ(defaultQueueName).Len())
 	})
 
+	t.Run("Sync progress for managed agent", func(t *testing.T) {
+		old := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "guestbook", Namespace: "agent"}}
+		old.Status.OperationState = &v1alpha1.OperationState{Phase: synccommon.OperationRunning}
+		new := old.DeepCopy()
+		new.Status.OperationState.SyncResult = &v1alpha1.SyncOperationResult{
+			Resources: v1alpha1.ResourceResults{{Kind: "Deployment", Name: "guestbook", HookPhase: synccommon.OperationRunning}},
+		}
+		_ = a.appManager.Manage("agent/guestbook")
+		defer a.appManager.Unmanage("agent/guestbook")
+		a.mode = types.AgentModeManaged
+		defer func() { a.eventWriter = nil }()
+
+		// Principals that don't know sync progress get the whole status
+		a.eventWriter = event.NewEventWriter("", &nopStream{})
+		a.eventWriter.SetSchemaVersion(event.SchemaVersion4)
+		a.addAppUpdateToQueue(old, new)
+		ev, _ := a.queues.SendQ(defaultQueueName).Get()
+		require.NotNil(t, ev)
+		assert.Equal(t, event.StatusUpdate.String(), ev.Type())
+
+		a.eventWriter.SetSchemaVersion(event.SchemaVersion5)
+		a.addAppUpdateToQueue(old, new)
+		ev, _ = a.queues.SendQ(defaultQueueName).Get()
+		require.NotNil(t, ev)
+		assert.Equal(t, event.SyncProgress.String(), ev.Type())
+		app, err := event.New(ev, event.TargetApplication).Application()
+		require.NoError(t, err)
+		require.NotNil(t, app.Status.OperationState)
+		assert.Len(t, app.Status.OperationState.SyncResult.Resources, 1)
+
+		// Other changes of the status are sent as a whole
+		new.Status.Sync.Status = v1alpha1.SyncStatusCodeSynced
+		a.addAppUpdateToQueue(old, new)
+		ev, _ = a.queues.SendQ(defaultQueueName).Get()
+		require.NotNil(t, ev)
+		assert.Equal(t, event.StatusUpdate.String(), ev.Type())
+	})
+
 }
 
 func Test_addAppDeletionToQueue(t *testing.T) {
@@ -0,0 +1,112 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/rbac"
+)
+
+// permissionCheckTimeout is the time a single permission check may take
+const permissionCheckTimeout = time.Minute
+
+// WithPermissionCheck makes the agent check its Kubernetes permissions
+// against the ones needed for its enabled features on startup, and then
+// every interval. Missing and excess permissions are logged and reported to
+// the principal. An interval of 0 checks on startup only.
+func WithPermissionCheck(interval time.Duration) AgentOption {
+	return func(a *Agent) error {
+		a.options.permissionCheck = true
+		a.options.permissionCheckInterval = interval
+		return nil
+	}
+}
+
+// rbacFeatures returns the features the agent needs permissions for. Proxied
+// requests are made with the agent's credentials unless it impersonates
+// another user for them.
+func (a *Agent) rbacFeatures() []rbac.Feature {
+	features := []rbac.Feature{rbac.FeatureCore}
+	if a.createNamespace {
+		features = append(features, rbac.FeatureCreateNamespace)
+	}
+	if a.destinationBasedMapping {
+		features = append(features, rbac.FeatureDestinationMapping)
+	}
+	if a.enableResourceProxy && a.proxyImpersonation == nil {
+		features = append(features, rbac.FeatureResourceProxy, rbac.FeatureResourceActions, rbac.FeatureLogs, rbac.FeatureTerminal)
+	}
+	return features
+}
+
+// checkPermissions checks the agent's permissions and returns the report to
+// send to the principal
+func (a *Agent) checkPermissions(ctx context.Context) *event.PermissionReport {
+	ctx, cancel := context.WithTimeout(ctx, permissionCheckTimeout)
+	defer cancel()
+	features := a.rbacFeatures()
+	report := &event.PermissionReport{CheckedAt: time.Now()}
+	for _, f := range features {
+		report.Features = append(report.Features, string(f))
+	}
+	logCtx := log().WithField("features", report.Features)
+	res, err := rbac.Check(ctx, a.kubeClient.Clientset, a.namespace, rbac.Required(features...))
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not check permissions")
+		report.Error = err.Error()
+		return report
+	}
+	report.Missing = rbac.Strings(res.Missing)
+	report.Excess = rbac.Strings(res.Excess)
+	report.Incomplete = res.Incomplete
+	if len(report.Missing) > 0 {
+		logCtx.WithField("missing", report.Missing).Warn("Agent lacks permissions needed for its enabled features")
+	}
+	if len(report.Excess) > 0 {
+		logCtx.WithField("excess", report.Excess).Info("Agent has permissions it doesn't need")
+	}
+	if res.OK() {
+		logCtx.Debug("Agent has exactly the permissions it needs")
+	}
+	return report
+}
+
+// runPermissionCheck checks the agent's permissions and reports the result
+// to the principal, on startup and then periodically.
+func (a *Agent) runPermissionCheck(ctx context.Context) {
+	var tick <-chan time.Time
+	if a.options.permissionCheckInterval > 0 {
+		ticker := time.NewTicker(a.options.permissionCheckInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		report := a.checkPermissions(ctx)
+		if q := a.queues.SendQ(defaultQueueName); q != nil && ctx.Err() == nil {
+			q.Add(a.emitter.PermissionReportEvent(report))
+		}
+		if tick == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+	}
+}
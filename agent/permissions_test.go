@@ -0,0 +1,62 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+func Test_PermissionCheck(t *testing.T) {
+	a, kubec := newAgent(t)
+	a.emitter = event.NewEventSource("test")
+	require.NoError(t, WithPermissionCheck(0)(a))
+
+	t.Run("Features follow the configuration", func(t *testing.T) {
+		assert.Equal(t, []rbac.Feature{rbac.FeatureCore, rbac.FeatureResourceProxy, rbac.FeatureResourceActions, rbac.FeatureLogs, rbac.FeatureTerminal}, a.rbacFeatures())
+		a.proxyImpersonation = &rest.ImpersonationConfig{UserName: "proxy"}
+		defer func() { a.proxyImpersonation = nil }()
+		assert.Equal(t, []rbac.Feature{rbac.FeatureCore}, a.rbacFeatures())
+	})
+
+	t.Run("Missing permissions are reported to the principal", func(t *testing.T) {
+		// Only pods/log is denied
+		clientset := kubec.Clientset.(*fake.Clientset)
+		clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+			review := action.(kubetesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = review.Spec.ResourceAttributes.Subresource != "log"
+			return true, review, nil
+		})
+		a.runPermissionCheck(context.Background())
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 1, q.Len())
+		ev, _ := q.Get()
+		report, err := event.New(ev, event.TargetPermissions).PermissionReport()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"get pods/log cluster-wide"}, report.Missing)
+		assert.Contains(t, report.Features, "logs")
+		assert.Empty(t, report.Error)
+	})
+}
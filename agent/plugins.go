@@ -0,0 +1,103 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/plugin"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// WithPlugins adds plugins whose hooks are run for the events received from
+// and sent to the principal, after those compiled into the binary.
+func WithPlugins(hooks ...plugin.Hooks) AgentOption {
+	return func(a *Agent) error {
+		a.plugins = append(a.plugins, hooks...)
+		return nil
+	}
+}
+
+// WithPluginAddresses adds the plugin processes serving the plugin gRPC
+// interface at the given addresses. Plugins run in the order given.
+func WithPluginAddresses(addresses ...string) AgentOption {
+	return func(a *Agent) error {
+		for _, address := range addresses {
+			hooks, conn, err := plugin.Dial(address)
+			if err != nil {
+				return fmt.Errorf("could not connect to plugin %s: %w", address, err)
+			}
+			a.plugins = append(a.plugins, hooks)
+			a.pluginConns = append(a.pluginConns, conn)
+		}
+		return nil
+	}
+}
+
+// processWithPlugins processes an event received from the principal, running
+// the plugins' hooks around it. Events rejected by a plugin are not
+// processed.
+func (a *Agent) processWithPlugins(ev *event.Event) error {
+	if len(a.plugins) == 0 {
+		return a.processIncomingEvent(ev)
+	}
+	ce, err := a.plugins.PreProcess(a.pluginContext(), ev.CloudEvent())
+	if err != nil {
+		return err
+	}
+	err = a.processIncomingEvent(event.New(ce, event.Target(ce)))
+	a.plugins.PostProcess(a.pluginContext(), ce, err)
+	return err
+}
+
+// preSend runs the plugins' PreSend hooks for an event about to be sent to
+// the principal. It returns nil if the event must not be sent.
+func (a *Agent) preSend(ev *cloudevents.Event) *cloudevents.Event {
+	if len(a.plugins) == 0 {
+		return ev
+	}
+	out, err := a.plugins.PreSend(a.pluginContext(), ev)
+	if err != nil {
+		log().WithField("event_id", event.EventID(ev)).WithError(err).Warn("Not sending event")
+		return nil
+	}
+	return out
+}
+
+// postSend runs the plugins' PostSend hooks for an event handed over for
+// sending to the principal.
+func (a *Agent) postSend(ev *cloudevents.Event) {
+	if len(a.plugins) > 0 {
+		a.plugins.PostSend(a.pluginContext(), ev)
+	}
+}
+
+// pluginContext returns the context for calling plugin hooks
+func (a *Agent) pluginContext() context.Context {
+	if a.context == nil {
+		return context.Background()
+	}
+	return a.context
+}
+
+// closePlugins closes the connections to plugin processes
+func (a *Agent) closePlugins() {
+	for _, conn := range a.pluginConns {
+		_ = conn.Close()
+	}
+	a.pluginConns = nil
+}
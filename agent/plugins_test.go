@@ -0,0 +1,101 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/plugin"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// policyPlugin rejects events of the given type
+type policyPlugin struct {
+	reject  string
+	results []error
+	sent    int
+}
+
+func (p *policyPlugin) Name() string {
+	return "policy"
+}
+
+func (p *policyPlugin) check(ev *cloudevents.Event) (*cloudevents.Event, error) {
+	if ev.Type() == p.reject {
+		return nil, errors.New("denied by policy")
+	}
+	return ev, nil
+}
+
+func (p *policyPlugin) PreProcess(_ context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	return p.check(ev)
+}
+
+func (p *policyPlugin) PostProcess(_ context.Context, _ *cloudevents.Event, result error) {
+	p.results = append(p.results, result)
+}
+
+func (p *policyPlugin) PreSend(_ context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	return p.check(ev)
+}
+
+func (p *policyPlugin) PostSend(_ context.Context, _ *cloudevents.Event) {
+	p.sent++
+}
+
+func Test_Plugins(t *testing.T) {
+	newEvent := func(evType string) *cloudevents.Event {
+		ev := cloudevents.NewEvent()
+		ev.SetID("1")
+		ev.SetType(evType)
+		ev.SetSource("test")
+		ev.SetDataSchema("invalid")
+		return &ev
+	}
+	p := &policyPlugin{reject: "forbidden"}
+	a, _ := newAgent(t)
+	a.context = context.Background()
+	require.NoError(t, WithPlugins(p)(a))
+
+	t.Run("Rejected incoming event is not processed", func(t *testing.T) {
+		ce := newEvent("forbidden")
+		err := a.processWithPlugins(event.New(ce, event.Target(ce)))
+		var rejected *plugin.RejectedError
+		require.ErrorAs(t, err, &rejected)
+		assert.Equal(t, "policy", rejected.Plugin)
+		assert.Empty(t, p.results)
+	})
+
+	t.Run("Plugins see the result of processing", func(t *testing.T) {
+		ce := newEvent("update")
+		err := a.processWithPlugins(event.New(ce, event.Target(ce)))
+		require.ErrorContains(t, err, "unknown event target")
+		require.Len(t, p.results, 1)
+		assert.Equal(t, err, p.results[0])
+	})
+
+	t.Run("Rejected outgoing event is not sent", func(t *testing.T) {
+		assert.Nil(t, a.preSend(newEvent("forbidden")))
+		ev := newEvent("update")
+		assert.Same(t, ev, a.preSend(ev))
+		a.postSend(ev)
+		assert.Equal(t, 1, p.sent)
+	})
+}
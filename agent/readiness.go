@@ -0,0 +1,86 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/health"
+)
+
+// defaultReadinessGracePeriod is how long the agent stays ready after it
+// lost the connection to the principal
+const defaultReadinessGracePeriod = time.Minute
+
+// WithReadinessGracePeriod sets how long the agent is still considered ready
+// after it lost the connection to the principal, so that short reconnects
+// don't flap its readiness.
+func WithReadinessGracePeriod(d time.Duration) AgentOption {
+	return func(a *Agent) error {
+		if d < 0 {
+			return fmt.Errorf("readiness grace period must not be negative")
+		}
+		a.options.readinessGracePeriod = d
+		return nil
+	}
+}
+
+// readinessChecks returns the checks that decide whether the agent is ready
+func (a *Agent) readinessChecks() []health.Check {
+	return []health.Check{
+		{Name: "kube-api", Run: a.checkKubeAPI},
+		{Name: "principal", Run: a.checkPrincipal},
+	}
+}
+
+// checkKubeAPI checks whether the Kubernetes API can be reached
+func (a *Agent) checkKubeAPI(ctx context.Context) error {
+	if a.kubeClient == nil || a.kubeClient.Clientset == nil {
+		return errors.New("no Kubernetes client configured")
+	}
+	if _, err := a.kubeClient.Clientset.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("could not reach Kubernetes API: %w", err)
+	}
+	return nil
+}
+
+// checkPrincipal checks whether the agent is connected to the principal, or
+// lost the connection less than the grace period ago.
+func (a *Agent) checkPrincipal(ctx context.Context) error {
+	if a.remote == nil {
+		return errors.New("no principal configured")
+	}
+	if a.standby() {
+		return nil
+	}
+	connected, since := a.connState.state()
+	if connected {
+		return nil
+	}
+	disconnected := time.Since(since)
+	if disconnected < a.options.readinessGracePeriod {
+		return nil
+	}
+	return fmt.Errorf("not connected to principal for %s", disconnected.Round(time.Second))
+}
+
+// readyzHandler reports whether the agent's dependencies are available
+func (a *Agent) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	health.Handler(health.DefaultTimeout, a.readinessChecks)(w, r)
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+func Test_Readyz(t *testing.T) {
+	readyz := func(a *Agent) (int, health.Report) {
+		rec := httptest.NewRecorder()
+		a.readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report health.Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		return rec.Code, report
+	}
+
+	t.Run("Ready when connected", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.SetConnected(true)
+		code, report := readyz(a)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusOK, report.Status)
+		require.Len(t, report.Checks, 2)
+	})
+
+	t.Run("Ready within grace period after disconnect", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.SetConnected(true)
+		a.SetConnected(false)
+		code, _ := readyz(a)
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("Not ready after grace period", func(t *testing.T) {
+		a, _ := newAgent(t)
+		require.NoError(t, WithReadinessGracePeriod(0)(a))
+		code, report := readyz(a)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.CheckResult{Name: "kube-api", Status: health.StatusOK}, report.Checks[0])
+		assert.Equal(t, "principal", report.Checks[1].Name)
+		assert.Equal(t, health.StatusFailed, report.Checks[1].Status)
+		assert.Contains(t, report.Checks[1].Message, "not connected to principal")
+	})
+
+	t.Run("Not ready without Kubernetes API", func(t *testing.T) {
+		a, kubec := newAgent(t)
+		a.SetConnected(true)
+		kubec.Clientset.(*kubefake.Clientset).PrependReactor("get", "version", func(action kubetesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("connection refused")
+		})
+		code, report := readyz(a)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusFailed, report.Checks[0].Status)
+		assert.Contains(t, report.Checks[0].Message, "connection refused")
+		assert.Equal(t, health.StatusOK, report.Checks[1].Status)
+	})
+
+	t.Run("Negative grace period", func(t *testing.T) {
+		a, _ := newAgent(t)
+		assert.Error(t, WithReadinessGracePeriod(-time.Second)(a))
+	})
+}
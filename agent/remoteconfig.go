@@ -0,0 +1,321 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultRedaction replaces matches of log redaction rules without a
+// replacement
+const defaultRedaction = "***"
+
+type redaction struct {
+	re          *regexp.Regexp
+	replacement []byte
+}
+
+type pushedConfig struct {
+	hash       string
+	config     *v1alpha1.AgentRuntimeConfig
+	redactions []redaction
+}
+
+// remoteConfig holds the configurations pushed to the agent by the principal
+// and the effective settings derived from them. Configurations are kept in
+// memory only; the agent reports what it holds after each connect, and the
+// principal pushes whatever is missing.
+type remoteConfig struct {
+	mu      sync.RWMutex
+	configs map[string]*pushedConfig
+	// localLimits are the agent's own limits for long-running operations,
+	// which apply unless a pushed configuration overrides them
+	localLimits map[inflight.Kind]int
+	exclusions  []v1alpha1.ResourceExclusion
+	redactions  []redaction
+}
+
+func newRemoteConfig(registry *inflight.Registry) *remoteConfig {
+	return &remoteConfig{
+		configs: make(map[string]*pushedConfig),
+		localLimits: map[inflight.Kind]int{
+			InflightLogs:     registry.Limit(InflightLogs),
+			InflightTerminal: registry.Limit(InflightTerminal),
+		},
+	}
+}
+
+// processIncomingAgentConfig applies or removes a configuration pushed by
+// the principal and acknowledges it.
+func (a *Agent) processIncomingAgentConfig(ev *event.Event) error {
+	if ev.Type() != event.AgentConfigPush {
+		return fmt.Errorf("unexpected agent config event type %s", ev.Type())
+	}
+	push, err := ev.PushedConfig()
+	if err != nil {
+		return err
+	}
+	logCtx := log().WithField("configuration", push.Name)
+	ack := &event.PushedConfigAck{Name: push.Name, Hash: push.Hash}
+	if push.Config == nil {
+		a.removePushedConfig(push.Name)
+		logCtx.Info("Removed configuration pushed by the principal")
+	} else if err := a.applyPushedConfig(push.Name, push.Hash, push.Config); err != nil {
+		logCtx.WithError(err).Warn("Could not apply configuration pushed by the principal")
+		ack.Error = err.Error()
+	} else {
+		logCtx.WithField("hash", push.Hash).Info("Applied configuration pushed by the principal")
+	}
+	q := a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		return fmt.Errorf("no send queue available")
+	}
+	q.Add(a.emitter.AgentConfigAckEvent(ack))
+	return nil
+}
+
+// reportPushedConfigState sends the names and hashes of all pushed
+// configurations held by the agent to the principal.
+func (a *Agent) reportPushedConfigState() {
+	state := &event.PushedConfigState{Configs: a.PushedConfigs()}
+	if q := a.queues.SendQ(defaultQueueName); q != nil {
+		q.Add(a.emitter.AgentConfigStateEvent(state))
+	}
+}
+
+// PushedConfigs returns the names and hashes of the configurations pushed to
+// the agent by the principal.
+func (a *Agent) PushedConfigs() map[string]string {
+	res := make(map[string]string)
+	if a.remoteConfig == nil {
+		return res
+	}
+	a.remoteConfig.mu.RLock()
+	defer a.remoteConfig.mu.RUnlock()
+	for name, c := range a.remoteConfig.configs {
+		res[name] = c.hash
+	}
+	return res
+}
+
+func (a *Agent) applyPushedConfig(name, hash string, cfg *v1alpha1.AgentRuntimeConfig) error {
+	pc := &pushedConfig{hash: hash, config: cfg}
+	for _, r := range cfg.LogRedactions {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid log redaction pattern %q: %w", r.Pattern, err)
+		}
+		replacement := r.Replacement
+		if replacement == "" {
+			replacement = defaultRedaction
+		}
+		pc.redactions = append(pc.redactions, redaction{re: re, replacement: []byte(replacement)})
+	}
+	if rl := cfg.RateLimits; rl != nil {
+		for _, l := range []*int{rl.MaxLogStreams, rl.MaxTerminalSessions} {
+			if l != nil && *l < 0 {
+				return fmt.Errorf("rate limits must not be negative")
+			}
+		}
+	}
+	rc := a.remoteConfig
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.configs[name] = pc
+	a.updateEffectiveConfig()
+	return nil
+}
+
+func (a *Agent) removePushedConfig(name string) {
+	rc := a.remoteConfig
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.configs, name)
+	a.updateEffectiveConfig()
+}
+
+// setLocalInflightLimit changes the agent's own limit for operations of the
+// given kind. It only takes effect if no pushed configuration overrides it.
+func (a *Agent) setLocalInflightLimit(kind inflight.Kind, limit int) {
+	if a.remoteConfig == nil {
+		a.inflight.SetLimit(kind, limit)
+		return
+	}
+	rc := a.remoteConfig
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.localLimits[kind] = limit
+	a.updateEffectiveConfig()
+}
+
+// updateEffectiveConfig combines all pushed configurations. Exclusions and
+// redactions of all configurations apply, and the lowest of all pushed
+// limits wins. Must be called with the lock held.
+func (a *Agent) updateEffectiveConfig() {
+	rc := a.remoteConfig
+	names := make([]string, 0, len(rc.configs))
+	for name := range rc.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rc.exclusions = nil
+	rc.redactions = nil
+	limits := make(map[inflight.Kind]int)
+	lower := func(kind inflight.Kind, limit *int) {
+		if limit == nil {
+			return
+		}
+		if cur, ok := limits[kind]; !ok || isLowerLimit(*limit, cur) {
+			limits[kind] = *limit
+		}
+	}
+	for _, name := range names {
+		c := rc.configs[name]
+		rc.exclusions = append(rc.exclusions, c.config.ResourceExclusions...)
+		rc.redactions = append(rc.redactions, c.redactions...)
+		if rl := c.config.RateLimits; rl != nil {
+			lower(InflightLogs, rl.MaxLogStreams)
+			lower(InflightTerminal, rl.MaxTerminalSessions)
+		}
+	}
+	for kind, local := range rc.localLimits {
+		if limit, ok := limits[kind]; ok {
+			a.inflight.SetLimit(kind, limit)
+		} else {
+			a.inflight.SetLimit(kind, local)
+		}
+	}
+}
+
+// isLowerLimit returns whether limit a is stricter than limit b, where 0
+// means no limit.
+func isLowerLimit(a, b int) bool {
+	if b == 0 {
+		return a != 0
+	}
+	return a != 0 && a < b
+}
+
+// isResourceExcluded returns whether requests for resources of gvr in the
+// given namespace must be refused.
+func (a *Agent) isResourceExcluded(gvr schema.GroupVersionResource, namespace string) bool {
+	if a.remoteConfig == nil {
+		return false
+	}
+	a.remoteConfig.mu.RLock()
+	defer a.remoteConfig.mu.RUnlock()
+	for _, e := range a.remoteConfig.exclusions {
+		if matchesExclusion(e.APIGroups, gvr.Group) &&
+			matchesExclusion(e.Resources, gvr.Resource) &&
+			matchesExclusion(e.Namespaces, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterExcludedResources removes resources in excluded namespaces from list
+func (a *Agent) filterExcludedResources(gvr schema.GroupVersionResource, list *unstructured.UnstructuredList) {
+	if list == nil {
+		return
+	}
+	items := list.Items[:0]
+	for _, item := range list.Items {
+		if !a.isResourceExcluded(gvr, item.GetNamespace()) {
+			items = append(items, item)
+		}
+	}
+	list.Items = items
+}
+
+func matchesExclusion(patterns []string, value string) bool {
+	return len(patterns) == 0 || slices.Contains(patterns, "*") || slices.Contains(patterns, value)
+}
+
+// errResourceExcluded returns the error reported for requests to excluded
+// resources
+func errResourceExcluded(gvr schema.GroupVersionResource, name string) error {
+	return apierrors.NewForbidden(gvr.GroupResource(), name, fmt.Errorf("resource is excluded by the agent configuration"))
+}
+
+// logRedactor applies the agent's log redaction rules to container logs.
+// Rules are applied to complete lines only, so the redactor holds back a
+// trailing partial line until it is completed by the next chunk.
+type logRedactor struct {
+	redactions []redaction
+	partial    []byte
+	maxPartial int
+}
+
+// newLogRedactor returns a redactor for the current redaction rules, or nil
+// if there are none.
+func (a *Agent) newLogRedactor() *logRedactor {
+	if a.remoteConfig == nil {
+		return nil
+	}
+	a.remoteConfig.mu.RLock()
+	defer a.remoteConfig.mu.RUnlock()
+	if len(a.remoteConfig.redactions) == 0 {
+		return nil
+	}
+	return &logRedactor{redactions: a.remoteConfig.redactions, maxPartial: a.logChunkMax()}
+}
+
+// redact returns the redacted complete lines of data, including any partial
+// line held back from the previous call. A partial line longer than the
+// chunk size is not held back any longer.
+func (r *logRedactor) redact(data []byte) []byte {
+	if r == nil {
+		return data
+	}
+	buf := append(r.partial, data...)
+	r.partial = nil
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	if end < len(buf) && len(buf)-end <= r.maxPartial {
+		r.partial = append([]byte{}, buf[end:]...)
+		buf = buf[:end]
+	}
+	return r.apply(buf)
+}
+
+// flush returns the redacted partial line held back, if any
+func (r *logRedactor) flush() []byte {
+	if r == nil || len(r.partial) == 0 {
+		return nil
+	}
+	buf := r.partial
+	r.partial = nil
+	return r.apply(buf)
+}
+
+func (r *logRedactor) apply(buf []byte) []byte {
+	for _, rd := range r.redactions {
+		buf = rd.re.ReplaceAll(buf, rd.replacement)
+	}
+	return buf
+}
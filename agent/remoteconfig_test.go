@@ -0,0 +1,144 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func Test_PushedConfig(t *testing.T) {
+	a, _ := newAgent(t)
+	a.emitter = event.NewEventSource("test")
+	principal := event.NewEventSource("principal")
+	q := a.queues.SendQ(defaultQueueName)
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+	ack := func(t *testing.T) *event.PushedConfigAck {
+		t.Helper()
+		require.Equal(t, 1, q.Len())
+		ev, _ := q.Get()
+		q.Done(ev)
+		ack, err := event.New(ev, event.TargetAgentConfig).PushedConfigAck()
+		require.NoError(t, err)
+		return ack
+	}
+	push := func(t *testing.T, name string, cfg *v1alpha1.AgentRuntimeConfig) *event.PushedConfigAck {
+		t.Helper()
+		pc := &event.PushedConfig{Name: name, Config: cfg}
+		if cfg != nil {
+			pc.Hash = cfg.Hash()
+		}
+		ev := principal.AgentConfigPushEvent(pc)
+		require.NoError(t, a.processIncomingAgentConfig(event.New(ev, event.TargetAgentConfig)))
+		return ack(t)
+	}
+
+	t.Run("Configuration is applied and acknowledged", func(t *testing.T) {
+		cfg := &v1alpha1.AgentRuntimeConfig{
+			ResourceExclusions: []v1alpha1.ResourceExclusion{{APIGroups: []string{""}, Resources: []string{"secrets"}, Namespaces: []string{"kube-system"}}},
+			RateLimits:         &v1alpha1.RateLimits{MaxLogStreams: intPtr(5)},
+		}
+		a.setLocalInflightLimit(InflightLogs, 10)
+		res := push(t, "base", cfg)
+		assert.Equal(t, "base", res.Name)
+		assert.Equal(t, cfg.Hash(), res.Hash)
+		assert.Empty(t, res.Error)
+		assert.Equal(t, map[string]string{"base": cfg.Hash()}, a.PushedConfigs())
+		assert.Equal(t, 5, a.inflight.Limit(InflightLogs))
+		assert.True(t, a.isResourceExcluded(secrets, "kube-system"))
+		assert.False(t, a.isResourceExcluded(secrets, "default"))
+	})
+
+	t.Run("Lowest pushed limit wins over the local limit", func(t *testing.T) {
+		push(t, "strict", &v1alpha1.AgentRuntimeConfig{RateLimits: &v1alpha1.RateLimits{MaxLogStreams: intPtr(2), MaxTerminalSessions: intPtr(1)}})
+		assert.Equal(t, 2, a.inflight.Limit(InflightLogs))
+		assert.Equal(t, 1, a.inflight.Limit(InflightTerminal))
+		// Local changes don't override pushed limits
+		a.setLocalInflightLimit(InflightLogs, 1)
+		assert.Equal(t, 2, a.inflight.Limit(InflightLogs))
+	})
+
+	t.Run("Invalid configuration is rejected", func(t *testing.T) {
+		res := push(t, "invalid", &v1alpha1.AgentRuntimeConfig{LogRedactions: []v1alpha1.LogRedaction{{Pattern: "(["}}})
+		assert.NotEmpty(t, res.Error)
+		assert.NotContains(t, a.PushedConfigs(), "invalid")
+	})
+
+	t.Run("Removing configuration restores local limits", func(t *testing.T) {
+		res := push(t, "strict", nil)
+		assert.Empty(t, res.Error)
+		assert.Equal(t, 5, a.inflight.Limit(InflightLogs))
+		push(t, "base", nil)
+		assert.Empty(t, a.PushedConfigs())
+		assert.Equal(t, 1, a.inflight.Limit(InflightLogs))
+		assert.False(t, a.isResourceExcluded(secrets, "kube-system"))
+	})
+
+	t.Run("State is reported to the principal", func(t *testing.T) {
+		push(t, "base", &v1alpha1.AgentRuntimeConfig{})
+		a.reportPushedConfigState()
+		require.Equal(t, 1, q.Len())
+		ev, _ := q.Get()
+		q.Done(ev)
+		state, err := event.New(ev, event.TargetAgentConfig).PushedConfigState()
+		require.NoError(t, err)
+		assert.Equal(t, a.PushedConfigs(), state.Configs)
+	})
+}
+
+func Test_LogRedactor(t *testing.T) {
+	a, _ := newAgent(t)
+	assert.Nil(t, a.newLogRedactor())
+
+	require.NoError(t, a.applyPushedConfig("redact", "1", &v1alpha1.AgentRuntimeConfig{
+		LogRedactions: []v1alpha1.LogRedaction{
+			{Pattern: `password=\S+`, Replacement: "password=<hidden>"},
+			{Pattern: `token-[a-z0-9]+`},
+		},
+	}))
+	r := a.newLogRedactor()
+	require.NotNil(t, r)
+
+	t.Run("Complete lines are redacted", func(t *testing.T) {
+		assert.Equal(t, "login password=<hidden> ok\n", string(r.redact([]byte("login password=secret ok\n"))))
+	})
+
+	t.Run("Partial lines are held back", func(t *testing.T) {
+		assert.Equal(t, "first\n", string(r.redact([]byte("first\nusing token-"))))
+		assert.Equal(t, "using *** now\n", string(r.redact([]byte("abc123 now\n"))))
+	})
+
+	t.Run("Flush redacts the remaining partial line", func(t *testing.T) {
+		assert.Empty(t, r.redact([]byte("password=x")))
+		assert.Equal(t, "password=<hidden>", string(r.flush()))
+		assert.Nil(t, r.flush())
+	})
+
+	t.Run("Nil redactor passes data through", func(t *testing.T) {
+		var nr *logRedactor
+		assert.Equal(t, "password=x", string(nr.redact([]byte("password=x"))))
+		assert.Nil(t, nr.flush())
+	})
+}
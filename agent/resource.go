@@ -44,6 +44,7 @@ func (a *Agent) processIncomingResourceRequest(ev *event.Event) error {
 	if err != nil {
 		return err
 	}
+	rreq.Namespace = a.options.namespaceMapper.ToSpoke(rreq.Namespace)
 	logCtx := a.logResourceProxy().WithFields(logrus.Fields{
 		"method":      "processIncomingResourceRequest",
 		"uuid":        rreq.UUID,
@@ -52,7 +53,7 @@ func (a *Agent) processIncomingResourceRequest(ev *event.Event) error {
 
 	logCtx.Tracef("Start processing %v", rreq)
 
-	ctx, cancel := context.WithTimeout(a.context, defaultResourceRequestTimeout)
+	ctx, cancel := context.WithTimeout(withOnBehalfOf(a.context, rreq.RequestedBy), defaultResourceRequestTimeout)
 	defer cancel()
 
 	var jsonres []byte
@@ -69,12 +70,30 @@ func (a *Agent) processIncomingResourceRequest(ev *event.Event) error {
 		logCtx.Infof("Processing resource request for resource of type %s named %s/%s", gvr.String(), namespace, name)
 	}
 
+	if gvr.Resource != "" && a.isResourceExcluded(gvr, namespace) {
+		logCtx.Infof("Refusing request for excluded resource %s", gvr.String())
+		q := a.queues.SendQ(defaultQueueName)
+		if q == nil {
+			logCtx.Error("Remote queue disappeared")
+			return nil
+		}
+		q.Add(a.emitter.NewResourceResponseEvent(rreq.UUID, event.HTTPStatusFromError(errResourceExcluded(gvr, name)), ""))
+		return nil
+	}
+
 	switch rreq.Method {
 	case http.MethodGet:
 		// If we have a request for a named resource, we fetch that particular
 		// resource. If the name is empty, we fetch either a list of resources
-		// or a list of APIs instead.
-		if name != "" {
+		// or a list of APIs instead. Cluster-scoped resources allowed by the
+		// configuration can be read whether or not they are managed.
+		if a.isAllowedClusterResource(gvr, namespace, subresource) {
+			logCtx.Debugf("Fetching allowed cluster-scoped resource %s", gvr.String())
+			unres, unlist, err = a.getClusterResource(ctx, gvr, name, rreq.Params)
+			if err == nil {
+				a.filterExcludedResources(gvr, unlist)
+			}
+		} else if name != "" {
 			if gvr.Resource != "" {
 				if subresource != "" {
 					logCtx.Debugf("Fetching subresource %s of managed resource %s/%s/%s", subresource, gvr.Group, gvr.Version, gvr.Resource)
@@ -87,24 +106,42 @@ func (a *Agent) processIncomingResourceRequest(ev *event.Event) error {
 		} else {
 			if gvr.Resource != "" {
 				unlist, err = a.getAvailableResources(ctx, gvr, namespace, rreq.Params)
+				if err == nil && namespace == "" {
+					a.filterExcludedResources(gvr, unlist)
+				}
 			} else {
 				logCtx.Debugf("Fetching APIs for group %s and version %s", gvr.Group, gvr.Version)
 				unres, err = a.getAvailableAPIs(ctx, gvr.Group, gvr.Version)
 			}
 		}
 	case http.MethodPost:
+		if err = a.verifyResourceImages(ctx, gvr, name, rreq.Body); err != nil {
+			break
+		}
+		if ctx, err = a.appServiceAccountContext(ctx, rreq, gvr); err != nil {
+			break
+		}
 		if subresource != "" {
 			unres, err = a.processIncomingPostSubresourceRequest(ctx, rreq, gvr, subresource)
 		} else {
 			unres, err = a.processIncomingPostResourceRequest(ctx, rreq, gvr)
 		}
 	case http.MethodPatch:
+		if err = a.verifyResourceImages(ctx, gvr, name, rreq.Body); err != nil {
+			break
+		}
+		if ctx, err = a.appServiceAccountContext(ctx, rreq, gvr); err != nil {
+			break
+		}
 		if subresource != "" {
 			unres, err = a.processIncomingPatchSubresourceRequest(ctx, rreq, gvr, subresource)
 		} else {
 			unres, err = a.processIncomingPatchResourceRequest(ctx, rreq, gvr)
 		}
 	case http.MethodDelete:
+		if ctx, err = a.appServiceAccountContext(ctx, rreq, gvr); err != nil {
+			break
+		}
 		err = a.processIncomingDeleteResourceRequest(ctx, rreq, gvr)
 	default:
 		err = fmt.Errorf("invalid HTTP method %s for resource request", rreq.Method)
@@ -114,6 +151,7 @@ func (a *Agent) processIncomingResourceRequest(ev *event.Event) error {
 		logCtx.Errorf("could not request resource: %v", err)
 		status = err
 	} else {
+		a.resourceToHub(unres, unlist)
 		// Marshal the unstructured resource to JSON for submission
 		if unres != nil {
 			jsonres, err = json.Marshal(unres)
@@ -157,7 +195,11 @@ func (a *Agent) processIncomingPostResourceRequest(ctx context.Context, req *eve
 		createOpts.FieldManager = fieldMgr
 	}
 
-	client := a.kubeClient.DynamicClient.Resource(gvr)
+	if resourceObj.GetNamespace() != "" {
+		resourceObj.SetNamespace(req.Namespace)
+	}
+
+	client := a.applyKubeClient().DynamicClient.Resource(gvr)
 	return client.Namespace(req.Namespace).Create(ctx, resourceObj, createOpts)
 }
 
@@ -183,7 +225,7 @@ func (a *Agent) processIncomingPatchResourceRequest(ctx context.Context, req *ev
 		patchOpts.FieldManager = fieldMgr
 	}
 
-	client := a.kubeClient.DynamicClient.Resource(gvr)
+	client := a.applyKubeClient().DynamicClient.Resource(gvr)
 	return client.Namespace(req.Namespace).Patch(ctx, req.Name, k8stypes.MergePatchType, req.Body, patchOpts)
 }
 
@@ -200,7 +242,7 @@ func (a *Agent) processIncomingDeleteResourceRequest(ctx context.Context, req *e
 		return fmt.Errorf("failed to retrieve resource: %w", err)
 	}
 
-	client := a.kubeClient.DynamicClient.Resource(gvr)
+	client := a.applyKubeClient().DynamicClient.Resource(gvr)
 	return client.Namespace(req.Namespace).Delete(ctx, req.Name, *deleteOpts)
 }
 
@@ -291,7 +333,7 @@ func (a *Agent) getManagedResourceSubresource(ctx context.Context, gvr schema.Gr
 	if err != nil {
 		return nil, fmt.Errorf("invalid subresource path: %w", err)
 	}
-	restClient := a.kubeClient.Clientset.Discovery().RESTClient()
+	restClient := a.proxyKubeClient().Clientset.Discovery().RESTClient()
 	req := restClient.Get().AbsPath(path)
 
 	result, err := a.executeSubresourceRequest(ctx, req, subresource)
@@ -309,7 +351,7 @@ func (a *Agent) getManagedResource(ctx context.Context, gvr schema.GroupVersionR
 	var err error
 	var res *unstructured.Unstructured
 
-	rif := a.kubeClient.DynamicClient.Resource(gvr)
+	rif := a.proxyKubeClient().DynamicClient.Resource(gvr)
 
 	if namespace != "" {
 		res, err = rif.Namespace(namespace).Get(ctx, name, v1.GetOptions{})
@@ -410,7 +452,7 @@ func (a *Agent) getAvailableResources(ctx context.Context, gvr schema.GroupVersi
 	}
 
 	listOpts := listOptionsFromParams(params)
-	rif := a.kubeClient.DynamicClient.Resource(gvr)
+	rif := a.proxyKubeClient().DynamicClient.Resource(gvr)
 
 	if namespace != "" {
 		res, err = rif.Namespace(namespace).List(ctx, listOpts)
@@ -434,11 +476,11 @@ func (a *Agent) getAvailableAPIs(ctx context.Context, group, version string) (*u
 	var err error
 
 	if group == "" && version == "" {
-		groupList, err = a.kubeClient.Clientset.Discovery().ServerGroups()
+		groupList, err = a.proxyKubeClient().Clientset.Discovery().ServerGroups()
 	} else if group == "" && version != "" {
-		resourceList, err = a.kubeClient.Clientset.Discovery().ServerResourcesForGroupVersion(version)
+		resourceList, err = a.proxyKubeClient().Clientset.Discovery().ServerResourcesForGroupVersion(version)
 	} else {
-		resourceList, err = a.kubeClient.Clientset.Discovery().ServerResourcesForGroupVersion(groupVersion)
+		resourceList, err = a.proxyKubeClient().Clientset.Discovery().ServerResourcesForGroupVersion(groupVersion)
 	}
 	if err != nil {
 		return nil, err
@@ -559,7 +601,7 @@ func (a *Agent) processIncomingPostSubresourceRequest(ctx context.Context, rreq
 	if err != nil {
 		return nil, fmt.Errorf("invalid subresource path: %w", err)
 	}
-	restClient := a.kubeClient.Clientset.Discovery().RESTClient()
+	restClient := a.applyKubeClient().Clientset.Discovery().RESTClient()
 	req := restClient.Post().AbsPath(path).Body(rreq.Body)
 
 	result, err := a.executeSubresourceRequest(ctx, req, subresource)
@@ -594,7 +636,7 @@ func (a *Agent) processIncomingPatchSubresourceRequest(ctx context.Context, rreq
 	if err != nil {
 		return nil, fmt.Errorf("invalid subresource path: %w", err)
 	}
-	restClient := a.kubeClient.Clientset.Discovery().RESTClient()
+	restClient := a.applyKubeClient().Clientset.Discovery().RESTClient()
 	req := restClient.Patch(patchType).AbsPath(path).Body(rreq.Body)
 
 	result, err := a.executeSubresourceRequest(ctx, req, subresource)
@@ -0,0 +1,174 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v3/util/settings"
+	"sigs.k8s.io/yaml"
+)
+
+// inClusterServer is the cluster the resources managed by the agent's
+// Argo CD live in, for matching them against the clusters of filter rules
+const inClusterServer = "https://kubernetes.default.svc"
+
+// resourceFilter decides which resource statuses of Applications are sent
+// to the principal
+type resourceFilter struct {
+	filter    settings.ResourcesFilter
+	appliedAt time.Time
+}
+
+// WithResourceFilter makes the agent leave out the status of resources that
+// match any of exclusions, or none of inclusions if it is not empty, from
+// the Applications it sends to the principal. The rules are the same as
+// Argo CD's resource.inclusions and resource.exclusions settings.
+func WithResourceFilter(inclusions, exclusions []settings.FilteredResource) AgentOption {
+	return func(a *Agent) error {
+		if len(inclusions) == 0 && len(exclusions) == 0 {
+			return nil
+		}
+		a.options.resourceFilter = &resourceFilter{
+			filter: settings.ResourcesFilter{
+				ResourceInclusions: inclusions,
+				ResourceExclusions: exclusions,
+			},
+			appliedAt: time.Now(),
+		}
+		return nil
+	}
+}
+
+// ParseResourceFilterRules parses resource filter rules in the YAML format
+// of Argo CD's resource.inclusions and resource.exclusions settings
+func ParseResourceFilterRules(s string) ([]settings.FilteredResource, error) {
+	var rules []settings.FilteredResource
+	if s == "" {
+		return rules, nil
+	}
+	if err := yaml.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("invalid resource filter rules: %w", err)
+	}
+	return rules, nil
+}
+
+// excluded returns whether the status of the resource of the given group
+// and kind is not sent to the principal. Unlike in Argo CD, events and
+// leases are not excluded by default.
+func (f *resourceFilter) excluded(group, kind string) bool {
+	if len(f.filter.ResourceInclusions) > 0 {
+		included := false
+		for _, r := range f.filter.ResourceInclusions {
+			if r.Match(group, kind, inClusterServer) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return true
+		}
+	}
+	for _, r := range f.filter.ResourceExclusions {
+		if r.Match(group, kind, inClusterServer) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterResources returns app with the status of resources excluded by the
+// agent's resource filter removed. app itself is not modified; if nothing is
+// removed, it is returned as is.
+func (a *Agent) filterResources(app *v1alpha1.Application) *v1alpha1.Application {
+	f := a.options.resourceFilter
+	if f == nil {
+		return app
+	}
+	n := 0
+	for _, r := range app.Status.Resources {
+		if f.excluded(r.Group, r.Kind) {
+			n++
+		}
+	}
+	var syncResult *v1alpha1.SyncOperationResult
+	if app.Status.OperationState != nil {
+		syncResult = app.Status.OperationState.SyncResult
+	}
+	if syncResult != nil {
+		for _, r := range syncResult.Resources {
+			if f.excluded(r.Group, r.Kind) {
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return app
+	}
+
+	filtered := app.DeepCopy()
+	resources := filtered.Status.Resources[:0]
+	for _, r := range filtered.Status.Resources {
+		if !f.excluded(r.Group, r.Kind) {
+			resources = append(resources, r)
+		}
+	}
+	filtered.Status.Resources = resources
+	if syncResult != nil {
+		results := filtered.Status.OperationState.SyncResult.Resources[:0]
+		for _, r := range filtered.Status.OperationState.SyncResult.Resources {
+			if !f.excluded(r.Group, r.Kind) {
+				results = append(results, r)
+			}
+		}
+		filtered.Status.OperationState.SyncResult.Resources = results
+	}
+	if a.metrics != nil {
+		a.metrics.FilteredResourceStatuses.Add(float64(n))
+	}
+	return filtered
+}
+
+// reportResourceFilter lets the principal know which resource filter the
+// agent applies, if any
+func (a *Agent) reportResourceFilter() {
+	f := a.options.resourceFilter
+	if f == nil {
+		return
+	}
+	report := &event.ResourceFilterReport{
+		Inclusions: filterRules(f.filter.ResourceInclusions),
+		Exclusions: filterRules(f.filter.ResourceExclusions),
+		AppliedAt:  f.appliedAt,
+	}
+	if q := a.queues.SendQ(defaultQueueName); q != nil {
+		q.Add(a.emitter.ResourceFilterReportEvent(report))
+	}
+}
+
+func filterRules(resources []settings.FilteredResource) []event.ResourceFilterRule {
+	var rules []event.ResourceFilterRule
+	for _, r := range resources {
+		rules = append(rules, event.ResourceFilterRule{
+			APIGroups: r.APIGroups,
+			Kinds:     r.Kinds,
+			Clusters:  r.Clusters,
+		})
+	}
+	return rules
+}
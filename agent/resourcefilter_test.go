@@ -0,0 +1,102 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ResourceFilter(t *testing.T) {
+	app := &v1alpha1.Application{
+		Status: v1alpha1.ApplicationStatus{
+			Resources: []v1alpha1.ResourceStatus{
+				{Group: "apps", Kind: "Deployment", Name: "guestbook"},
+				{Kind: "Service", Name: "guestbook"},
+				{Group: "s3.aws.crossplane.io", Kind: "Bucket", Name: "guestbook"},
+			},
+			OperationState: &v1alpha1.OperationState{
+				SyncResult: &v1alpha1.SyncOperationResult{
+					Resources: v1alpha1.ResourceResults{
+						{Group: "s3.aws.crossplane.io", Kind: "Bucket", Name: "guestbook"},
+						{Kind: "Service", Name: "guestbook"},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("Parse rules in Argo CD format", func(t *testing.T) {
+		rules, err := ParseResourceFilterRules("- apiGroups: [\"*.crossplane.io\"]\n  kinds: [\"*\"]\n")
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		assert.Equal(t, []string{"*.crossplane.io"}, rules[0].APIGroups)
+		_, err = ParseResourceFilterRules("apiGroups: foo")
+		assert.Error(t, err)
+	})
+
+	t.Run("Without filter, the app is sent as is", func(t *testing.T) {
+		a, _ := newAgent(t)
+		assert.Same(t, app, a.filterResources(app))
+	})
+
+	t.Run("Excluded resources are removed", func(t *testing.T) {
+		a, _ := newAgent(t)
+		rules, err := ParseResourceFilterRules("- apiGroups: [\"*.crossplane.io\"]\n  kinds: [\"*\"]\n")
+		require.NoError(t, err)
+		require.NoError(t, WithResourceFilter(nil, rules)(a))
+		filtered := a.filterResources(app)
+		require.Len(t, filtered.Status.Resources, 2)
+		assert.Equal(t, "Deployment", filtered.Status.Resources[0].Kind)
+		assert.Equal(t, "Service", filtered.Status.Resources[1].Kind)
+		require.Len(t, filtered.Status.OperationState.SyncResult.Resources, 1)
+		assert.Equal(t, "Service", filtered.Status.OperationState.SyncResult.Resources[0].Kind)
+		// The original is unchanged
+		assert.Len(t, app.Status.Resources, 3)
+		assert.Len(t, app.Status.OperationState.SyncResult.Resources, 2)
+	})
+
+	t.Run("Only included resources are kept", func(t *testing.T) {
+		a, _ := newAgent(t)
+		rules, err := ParseResourceFilterRules("- apiGroups: [\"apps\"]\n  kinds: [\"Deployment\"]\n")
+		require.NoError(t, err)
+		require.NoError(t, WithResourceFilter(rules, nil)(a))
+		filtered := a.filterResources(app)
+		require.Len(t, filtered.Status.Resources, 1)
+		assert.Equal(t, "Deployment", filtered.Status.Resources[0].Kind)
+		assert.Empty(t, filtered.Status.OperationState.SyncResult.Resources)
+	})
+
+	t.Run("Filter is reported to the principal", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.emitter = event.NewEventSource("test")
+		rules, err := ParseResourceFilterRules("- kinds: [\"Event\"]\n")
+		require.NoError(t, err)
+		require.NoError(t, WithResourceFilter(nil, rules)(a))
+		a.reportResourceFilter()
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 1, q.Len())
+		ev, _ := q.Get()
+		report, err := event.New(ev, event.TargetResourceFilter).ResourceFilterReport()
+		require.NoError(t, err)
+		require.Len(t, report.Exclusions, 1)
+		assert.Equal(t, []string{"Event"}, report.Exclusions[0].Kinds)
+		assert.Empty(t, report.Inclusions)
+	})
+}
@@ -0,0 +1,307 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+// SelfUpdate configures updates of the agent's own Deployment on request of
+// the principal.
+type SelfUpdate struct {
+	// Deployment is the name of the agent's Deployment in its namespace
+	Deployment string
+	// Container is the name of the agent's container in the Deployment. It
+	// may be empty if the Deployment has a single container.
+	Container string
+	// PublicKey verifies the signatures of the update payloads the
+	// principal forwards
+	PublicKey crypto.PublicKey
+	// Scopes are the agent and fleet names the agent accepts updates for.
+	// If empty, the agent only accepts updates scoped to its own name.
+	Scopes []string
+}
+
+// imageTagRegexp matches valid image tags
+var imageTagRegexp = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// WithSelfUpdate lets the principal update the image tag of the agent's
+// Deployment. Only updates whose payload is signed with the private key
+// belonging to su.PublicKey are accepted.
+func WithSelfUpdate(su SelfUpdate) AgentOption {
+	return func(a *Agent) error {
+		if su.Deployment == "" {
+			return errors.New("self-update needs the name of the agent's deployment")
+		}
+		switch su.PublicKey.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+		default:
+			return fmt.Errorf("unsupported self-update key type %T", su.PublicKey)
+		}
+		su.Scopes = slices.DeleteFunc(slices.Clone(su.Scopes), func(s string) bool { return s == "" })
+		a.options.selfUpdate = &su
+		return nil
+	}
+}
+
+// LoadSelfUpdateKey reads the PEM encoded public key verifying self-update
+// requests from path. Ed25519, ECDSA and RSA keys are supported.
+func LoadSelfUpdateKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read self-update key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse self-update key: %w", err)
+	}
+	return key, nil
+}
+
+// verifyUpdatePayload verifies the base64 encoded signature of the base64
+// encoded update payload, and returns the decoded payload. ECDSA and RSA
+// signatures are made over the SHA-256 digest of the payload, as created by
+// openssl dgst -sha256 -sign.
+func verifyUpdatePayload(key crypto.PublicKey, payload, signature string) (*event.AgentUpdatePayload, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("update payload is not base64 encoded: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature is not base64 encoded: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	valid := false
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, data, sig)
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !valid {
+		return nil, errors.New("invalid signature of update payload")
+	}
+	p := &event.AgentUpdatePayload{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("could not decode update payload: %w", err)
+	}
+	return p, nil
+}
+
+// checkUpdatePayload checks that the verified payload p authorizes the
+// update of image to tag on an agent with the given scopes.
+func checkUpdatePayload(p *event.AgentUpdatePayload, image, tag string, scopes []string, now time.Time) error {
+	if p.Tag != tag {
+		return fmt.Errorf("update payload is for tag %q, not %q", p.Tag, tag)
+	}
+	if repo := imageRepository(image); p.Repository != repo {
+		return fmt.Errorf("update payload is for repository %q, the agent runs %q", p.Repository, repo)
+	}
+	if p.Expires.IsZero() || !now.Before(p.Expires) {
+		return fmt.Errorf("update payload expired at %s", p.Expires.Format(time.RFC3339))
+	}
+	inScope := false
+	for _, s := range p.Scopes {
+		if s != "" && slices.Contains(scopes, s) {
+			inScope = true
+		}
+	}
+	if !inScope {
+		return fmt.Errorf("update payload is not scoped to this agent (%s)", strings.Join(scopes, ", "))
+	}
+	if !p.AllowDowngrade && isDowngrade(imageTag(image), tag) {
+		return fmt.Errorf("refusing to downgrade from %s to %s without allowDowngrade", imageTag(image), tag)
+	}
+	return nil
+}
+
+// isDowngrade returns true if the tag to is an older semantic version than
+// from. Tags that are not semantic versions cannot be ordered and are never
+// considered a downgrade.
+func isDowngrade(from, to string) bool {
+	fromVer, err := semver.NewVersion(from)
+	if err != nil {
+		return false
+	}
+	toVer, err := semver.NewVersion(to)
+	if err != nil {
+		return false
+	}
+	return toVer.LessThan(fromVer)
+}
+
+// selfUpdateScopes returns the scopes the agent accepts updates for
+func (a *Agent) selfUpdateScopes() []string {
+	if len(a.options.selfUpdate.Scopes) > 0 {
+		return a.options.selfUpdate.Scopes
+	}
+	subject := &auth.AuthSubject{}
+	if a.remote == nil || json.Unmarshal([]byte(a.remote.ClientID()), subject) != nil || subject.ClientID == "" {
+		return nil
+	}
+	return []string{subject.ClientID}
+}
+
+// imageRepository returns image without its tag and digest
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	// A colon before the last slash separates the registry's port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// imageTag returns the tag of image, or an empty string if it has none
+func imageTag(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// imageWithTag returns image with its tag and digest replaced by tag
+func imageWithTag(image, tag string) string {
+	return imageRepository(image) + ":" + tag
+}
+
+// processIncomingAgentUpdate updates the agent's Deployment as requested by
+// the principal, and acknowledges the request. Once the Deployment is
+// updated, Kubernetes replaces the agent with a pod running the new image.
+func (a *Agent) processIncomingAgentUpdate(ev *event.Event) error {
+	req, err := ev.AgentUpdateRequest()
+	if err != nil {
+		return err
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"operation": req.Operation,
+		"tag":       req.Tag,
+	})
+	res := &event.AgentUpdateResult{UUID: req.UUID}
+	if err := a.updateDeployment(a.context, req, res); err != nil {
+		logCtx.WithError(err).Warn("Could not update the agent")
+		res.Error = err.Error()
+	} else if res.Restarting {
+		logCtx.Infof("Updated the agent's deployment from %s to %s", res.PreviousImage, res.Image)
+	} else {
+		logCtx.Infof("Agent is already running %s", res.Image)
+	}
+	ack, err := a.emitter.AgentUpdateAckEvent(res)
+	if err != nil {
+		return err
+	}
+	q := a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		return fmt.Errorf("no send queue available")
+	}
+	q.Add(ack)
+	return nil
+}
+
+// updateDeployment sets the image tag of the agent's container to the one
+// requested, and records the outcome in res.
+func (a *Agent) updateDeployment(ctx context.Context, req *event.AgentUpdateRequest, res *event.AgentUpdateResult) error {
+	su := a.options.selfUpdate
+	if su == nil {
+		return errors.New("self-update is not enabled on the agent")
+	}
+	if !imageTagRegexp.MatchString(req.Tag) {
+		return fmt.Errorf("invalid image tag %q", req.Tag)
+	}
+	payload, err := verifyUpdatePayload(su.PublicKey, req.Payload, req.Signature)
+	if err != nil {
+		return err
+	}
+	deployments := a.kubeClient.Clientset.AppsV1().Deployments(a.namespace)
+	d, err := deployments.Get(ctx, su.Deployment, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get deployment %s: %w", su.Deployment, err)
+	}
+	containers := d.Spec.Template.Spec.Containers
+	idx := -1
+	for i, c := range containers {
+		if c.Name == su.Container || (su.Container == "" && len(containers) == 1) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		if su.Container == "" {
+			return fmt.Errorf("deployment %s has %d containers, the agent's container must be configured", su.Deployment, len(containers))
+		}
+		return fmt.Errorf("deployment %s has no container %s", su.Deployment, su.Container)
+	}
+	container := containers[idx]
+	if err := checkUpdatePayload(payload, container.Image, req.Tag, a.selfUpdateScopes(), time.Now()); err != nil {
+		return err
+	}
+	res.PreviousImage = container.Image
+	res.Image = imageWithTag(container.Image, req.Tag)
+	if res.Image == res.PreviousImage {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"spec": map[string]any{
+					"containers": []map[string]string{{"name": container.Name, "image": res.Image}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := deployments.Patch(ctx, su.Deployment, ktypes.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("could not update deployment %s: %w", su.Deployment, err)
+	}
+	res.Restarting = true
+	return nil
+}
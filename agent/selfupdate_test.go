@@ -0,0 +1,288 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_VerifyUpdatePayload(t *testing.T) {
+	data := []byte(`{"repository":"quay.io/argoprojlabs/argocd-agent","tag":"v0.6.0","scopes":["agent"],"expires":"2030-01-01T00:00:00Z"}`)
+	payload := base64.StdEncoding.EncodeToString(data)
+	digest := sha256.Sum256(data)
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name string
+		key  crypto.PublicKey
+		sig  []byte
+	}{
+		{"Ed25519", edPub, ed25519.Sign(edKey, data)},
+		{"ECDSA", &ecKey.PublicKey, ecSig},
+		{"RSA", &rsaKey.PublicKey, rsaSig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := verifyUpdatePayload(tt.key, payload, base64.StdEncoding.EncodeToString(tt.sig))
+			require.NoError(t, err)
+			assert.Equal(t, "quay.io/argoprojlabs/argocd-agent", p.Repository)
+			assert.Equal(t, "v0.6.0", p.Tag)
+			assert.Equal(t, []string{"agent"}, p.Scopes)
+			assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), p.Expires)
+
+			tampered := base64.StdEncoding.EncodeToString(bytes.Replace(data, []byte("v0.6.0"), []byte("v0.7.0"), 1))
+			_, err = verifyUpdatePayload(tt.key, tampered, base64.StdEncoding.EncodeToString(tt.sig))
+			assert.ErrorContains(t, err, "invalid signature")
+		})
+	}
+
+	t.Run("Not base64 encoded", func(t *testing.T) {
+		_, err := verifyUpdatePayload(edPub, payload, "!!")
+		assert.ErrorContains(t, err, "not base64")
+		_, err = verifyUpdatePayload(edPub, "!!", base64.StdEncoding.EncodeToString(ed25519.Sign(edKey, data)))
+		assert.ErrorContains(t, err, "not base64")
+	})
+}
+
+func Test_CheckUpdatePayload(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	image := "quay.io/argoprojlabs/argocd-agent:v0.5.0"
+	valid := func() *event.AgentUpdatePayload {
+		return &event.AgentUpdatePayload{
+			Repository: "quay.io/argoprojlabs/argocd-agent",
+			Tag:        "v0.6.0",
+			Scopes:     []string{"other", "staging"},
+			Expires:    now.Add(time.Hour),
+		}
+	}
+
+	t.Run("Valid payload", func(t *testing.T) {
+		assert.NoError(t, checkUpdatePayload(valid(), image, "v0.6.0", []string{"agent", "staging"}, now))
+	})
+
+	t.Run("Downgrade", func(t *testing.T) {
+		p := valid()
+		p.Tag = "v0.4.0"
+		assert.ErrorContains(t, checkUpdatePayload(p, image, "v0.4.0", []string{"staging"}, now), "refusing to downgrade")
+		p.AllowDowngrade = true
+		assert.NoError(t, checkUpdatePayload(p, image, "v0.4.0", []string{"staging"}, now))
+	})
+
+	for _, tt := range []struct {
+		name   string
+		modify func(p *event.AgentUpdatePayload)
+		error  string
+	}{
+		{"Other tag", func(p *event.AgentUpdatePayload) { p.Tag = "v0.7.0" }, "not \"v0.6.0\""},
+		{"Other repository", func(p *event.AgentUpdatePayload) { p.Repository = "evil.io/argocd-agent" }, "for repository"},
+		{"Expired", func(p *event.AgentUpdatePayload) { p.Expires = now }, "expired"},
+		{"No expiry", func(p *event.AgentUpdatePayload) { p.Expires = time.Time{} }, "expired"},
+		{"Other scope", func(p *event.AgentUpdatePayload) { p.Scopes = []string{"production"} }, "not scoped"},
+		{"No scope", func(p *event.AgentUpdatePayload) { p.Scopes = nil }, "not scoped"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.modify(p)
+			assert.ErrorContains(t, checkUpdatePayload(p, image, "v0.6.0", []string{"agent", "staging"}, now), tt.error)
+		})
+	}
+}
+
+func Test_IsDowngrade(t *testing.T) {
+	assert.True(t, isDowngrade("v0.6.0", "v0.5.9"))
+	assert.True(t, isDowngrade("0.6.0", "v0.6.0-rc1"))
+	assert.False(t, isDowngrade("v0.6.0", "v0.6.0"))
+	assert.False(t, isDowngrade("v0.6.0", "v0.7.0"))
+	assert.False(t, isDowngrade("latest", "v0.5.0"))
+	assert.False(t, isDowngrade("v0.6.0", "main"))
+	assert.False(t, isDowngrade("", "v0.5.0"))
+}
+
+func Test_LoadSelfUpdateKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	key, err := LoadSelfUpdateKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a key"), 0600))
+	_, err = LoadSelfUpdateKey(invalid)
+	assert.Error(t, err)
+	_, err = LoadSelfUpdateKey(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
+
+func Test_ImageRepositoryAndTag(t *testing.T) {
+	for image, expected := range map[string][2]string{
+		"argocd-agent": {"argocd-agent", ""},
+		"quay.io/argoprojlabs/argocd-agent:v0.5.0":  {"quay.io/argoprojlabs/argocd-agent", "v0.5.0"},
+		"registry:5000/argocd-agent":                {"registry:5000/argocd-agent", ""},
+		"quay.io/argocd-agent:v0.5.0@sha256:abcdef": {"quay.io/argocd-agent", "v0.5.0"},
+	} {
+		assert.Equal(t, expected[0], imageRepository(image), image)
+		assert.Equal(t, expected[1], imageTag(image), image)
+	}
+}
+
+func Test_ImageWithTag(t *testing.T) {
+	for image, expected := range map[string]string{
+		"argocd-agent": "argocd-agent:v0.6.0",
+		"quay.io/argoprojlabs/argocd-agent:v0.5.0":  "quay.io/argoprojlabs/argocd-agent:v0.6.0",
+		"registry:5000/argocd-agent":                "registry:5000/argocd-agent:v0.6.0",
+		"registry:5000/argocd-agent:v0.5.0":         "registry:5000/argocd-agent:v0.6.0",
+		"quay.io/argocd-agent:v0.5.0@sha256:abcdef": "quay.io/argocd-agent:v0.6.0",
+	} {
+		assert.Equal(t, expected, imageWithTag(image, "v0.6.0"), image)
+	}
+}
+
+func Test_UpdateDeployment(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	request := func(tag string) *event.AgentUpdateRequest {
+		data, err := json.Marshal(&event.AgentUpdatePayload{
+			Repository: "quay.io/argoprojlabs/argocd-agent",
+			Tag:        tag,
+			Scopes:     []string{"agent"},
+			Expires:    time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+		return &event.AgentUpdateRequest{
+			Tag:       tag,
+			Payload:   base64.StdEncoding.EncodeToString(data),
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+		}
+	}
+	withSignatureOf := func(req, other *event.AgentUpdateRequest) *event.AgentUpdateRequest {
+		req.Signature = other.Signature
+		return req
+	}
+	deployment := func(containers ...string) *appsv1.Deployment {
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "argocd-agent-agent", Namespace: "argocd"}}
+		for _, c := range containers {
+			d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, corev1.Container{Name: c, Image: "quay.io/argoprojlabs/argocd-agent:v0.5.0"})
+		}
+		return d
+	}
+	newTestAgent := func(t *testing.T, su *SelfUpdate, d *appsv1.Deployment) *Agent {
+		t.Helper()
+		a := &Agent{kubeClient: kube.NewKubernetesFakeClientWithResources(d), namespace: "argocd"}
+		if su != nil {
+			require.NoError(t, WithSelfUpdate(*su)(a))
+		}
+		return a
+	}
+	image := func(t *testing.T, a *Agent, container int) string {
+		t.Helper()
+		d, err := a.kubeClient.Clientset.AppsV1().Deployments("argocd").Get(context.Background(), "argocd-agent-agent", metav1.GetOptions{})
+		require.NoError(t, err)
+		return d.Spec.Template.Spec.Containers[container].Image
+	}
+
+	t.Run("Updates the image of the single container", func(t *testing.T) {
+		a := newTestAgent(t, &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"))
+		res := &event.AgentUpdateResult{}
+		require.NoError(t, a.updateDeployment(context.Background(), request("v0.6.0"), res))
+		assert.True(t, res.Restarting)
+		assert.Equal(t, "quay.io/argoprojlabs/argocd-agent:v0.5.0", res.PreviousImage)
+		assert.Equal(t, "quay.io/argoprojlabs/argocd-agent:v0.6.0", res.Image)
+		assert.Equal(t, "quay.io/argoprojlabs/argocd-agent:v0.6.0", image(t, a, 0))
+	})
+
+	t.Run("Updates the configured container", func(t *testing.T) {
+		a := newTestAgent(t, &SelfUpdate{Deployment: "argocd-agent-agent", Container: "agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("sidecar", "agent"))
+		res := &event.AgentUpdateResult{}
+		require.NoError(t, a.updateDeployment(context.Background(), request("v0.6.0"), res))
+		assert.Equal(t, "quay.io/argoprojlabs/argocd-agent:v0.5.0", image(t, a, 0))
+		assert.Equal(t, "quay.io/argoprojlabs/argocd-agent:v0.6.0", image(t, a, 1))
+	})
+
+	t.Run("Does not restart if the tag is current", func(t *testing.T) {
+		a := newTestAgent(t, &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"))
+		res := &event.AgentUpdateResult{}
+		require.NoError(t, a.updateDeployment(context.Background(), request("v0.5.0"), res))
+		assert.False(t, res.Restarting)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, tt := range []struct {
+			name  string
+			su    *SelfUpdate
+			d     *appsv1.Deployment
+			req   *event.AgentUpdateRequest
+			error string
+		}{
+			{"Self-update disabled", nil, deployment("agent"), request("v0.6.0"), "not enabled"},
+			{"Invalid tag", &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"), request("v0.6.0;rm"), "invalid image tag"},
+			{"Invalid signature", &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"), withSignatureOf(request("v0.6.0"), request("v0.7.0")), "invalid signature"},
+			{"Unknown deployment", &SelfUpdate{Deployment: "other", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"), request("v0.6.0"), "could not get deployment"},
+			{"Ambiguous container", &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent", "sidecar"), request("v0.6.0"), "must be configured"},
+			{"Unknown container", &SelfUpdate{Deployment: "argocd-agent-agent", Container: "other", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"), request("v0.6.0"), "has no container other"},
+			{"Not in scope", &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"production"}}, deployment("agent"), request("v0.6.0"), "not scoped"},
+			{"Downgrade", &SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: pub, Scopes: []string{"agent"}}, deployment("agent"), request("v0.4.0"), "refusing to downgrade"},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				a := newTestAgent(t, tt.su, tt.d)
+				err := a.updateDeployment(context.Background(), tt.req, &event.AgentUpdateResult{})
+				assert.ErrorContains(t, err, tt.error)
+				assert.Equal(t, "quay.io/argoprojlabs/argocd-agent:v0.5.0", image(t, a, 0))
+			})
+		}
+	})
+
+	t.Run("Unsupported key type", func(t *testing.T) {
+		assert.Error(t, WithSelfUpdate(SelfUpdate{Deployment: "argocd-agent-agent", PublicKey: "key"})(&Agent{}))
+	})
+}
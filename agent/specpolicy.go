@@ -0,0 +1,203 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+)
+
+// PolicyInput is the input of a policy evaluation
+type PolicyInput struct {
+	// Operation is create or update
+	Operation string `json:"operation"`
+	// Application is the Application as it would be created or updated
+	Application *v1alpha1.Application `json:"application"`
+}
+
+// PolicyEvaluator evaluates the specs of Applications received from the
+// principal against a set of rules. It is the hook through which the agent's
+// spec policy is enforced.
+type PolicyEvaluator interface {
+	// Evaluate returns the rules input violates. An error means that the
+	// policy could not be evaluated.
+	Evaluate(ctx context.Context, input *PolicyInput) ([]event.PolicyViolation, error)
+}
+
+// SpecPolicy configures the evaluation of Application specs received from
+// the principal.
+type SpecPolicy struct {
+	// Evaluator evaluates the rules of the policy
+	Evaluator PolicyEvaluator
+	// Enforce refuses Applications that violate the policy, or for which the
+	// policy could not be evaluated. If false, violations are logged only.
+	Enforce bool
+}
+
+// defaultPolicyEvaluationTimeout is the time the evaluation of a policy may
+// take.
+const defaultPolicyEvaluationTimeout = 10 * time.Second
+
+// policyEvaluationRule is the rule reported if a policy could not be
+// evaluated.
+const policyEvaluationRule = "policy-evaluation"
+
+// WithSpecPolicy evaluates the specs of Applications received from the
+// principal before they are created or updated on the agent's cluster.
+// Applications that violate the policy are refused and reported to the
+// principal.
+func WithSpecPolicy(policy SpecPolicy) AgentOption {
+	return func(a *Agent) error {
+		if policy.Evaluator == nil {
+			return errors.New("spec policy needs an evaluator")
+		}
+		a.options.specPolicy = &policy
+		return nil
+	}
+}
+
+// OPAEvaluator evaluates Rego policies served by an Open Policy Agent server
+// through its data API. The rule at Path receives the PolicyInput as input
+// and must evaluate to the violations: either a set of messages, as for the
+// common deny[msg] rules, or a set of objects with rule and message fields.
+// An undefined rule means no violations.
+type OPAEvaluator struct {
+	// URL is the base URL of the OPA server, e.g. http://localhost:8181
+	URL string
+	// Path is the path of the rule in the data document, e.g.
+	// argocd/agent/deny
+	Path string
+	// Client sends the requests to the OPA server. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// opaResponse is the response of OPA's data API
+type opaResponse struct {
+	Result json.RawMessage `json:"result"`
+}
+
+// Evaluate queries the rule at e.Path with input
+func (e *OPAEvaluator) Evaluate(ctx context.Context, input *PolicyInput) ([]event.PolicyViolation, error) {
+	body, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(e.URL, "/") + "/v1/data/" + strings.Trim(e.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not query OPA: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read OPA response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OPA returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	res := &opaResponse{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("invalid OPA response: %w", err)
+	}
+	return e.violations(res.Result)
+}
+
+// violations converts the result of the rule to violations
+func (e *OPAEvaluator) violations(result json.RawMessage) ([]event.PolicyViolation, error) {
+	if len(result) == 0 || string(result) == "null" {
+		return nil, nil
+	}
+	messages := []string{}
+	if err := json.Unmarshal(result, &messages); err == nil {
+		violations := make([]event.PolicyViolation, len(messages))
+		for i, msg := range messages {
+			violations[i] = event.PolicyViolation{Rule: e.Path, Message: msg}
+		}
+		return violations, nil
+	}
+	violations := []event.PolicyViolation{}
+	if err := json.Unmarshal(result, &violations); err != nil {
+		return nil, fmt.Errorf("result of %s is neither a set of messages nor of violations", e.Path)
+	}
+	for i := range violations {
+		if violations[i].Rule == "" {
+			violations[i].Rule = e.Path
+		}
+	}
+	return violations, nil
+}
+
+// evaluateSpecPolicy evaluates the agent's spec policy for app, which is
+// about to be created or updated. It returns the violations that must keep
+// the agent from applying app, which are none unless the policy is enforced.
+func (a *Agent) evaluateSpecPolicy(app *v1alpha1.Application, operation string) []event.PolicyViolation {
+	policy := a.options.specPolicy
+	if policy == nil {
+		return nil
+	}
+	logCtx := log().WithField("app", app.QualifiedName())
+	ctx, cancel := context.WithTimeout(a.pluginContext(), defaultPolicyEvaluationTimeout)
+	defer cancel()
+	violations, err := policy.Evaluator.Evaluate(ctx, &PolicyInput{Operation: operation, Application: app})
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not evaluate spec policy")
+		violations = []event.PolicyViolation{{Rule: policyEvaluationRule, Message: err.Error()}}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, v := range violations {
+		logCtx.WithField("rule", v.Rule).Warnf("Application violates spec policy: %s", v.Message)
+	}
+	if !policy.Enforce {
+		return nil
+	}
+	return violations
+}
+
+// reportPolicyViolations sends the violations of the Application identified
+// by its name, namespace and UID on the principal to the principal.
+// Principals that don't understand such reports are not sent any.
+func (a *Agent) reportPolicyViolations(report *event.PolicyViolationReport) {
+	if a.eventWriter == nil || a.eventWriter.SchemaVersion() < event.SchemaVersion10 {
+		return
+	}
+	q := a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		log().Error("Default queue disappeared!")
+		return
+	}
+	q.Add(a.emitter.PolicyViolationEvent(report))
+}
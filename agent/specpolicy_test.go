@@ -0,0 +1,183 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	backend_mocks "github.com/argoproj-labs/argocd-agent/internal/backend/mocks"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/manager/application"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakePolicyEvaluator refuses Applications deployed to kube-system
+type fakePolicyEvaluator struct {
+	err error
+}
+
+func (e *fakePolicyEvaluator) Evaluate(_ context.Context, input *PolicyInput) ([]event.PolicyViolation, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if input.Application.Spec.Destination.Namespace == "kube-system" {
+		return []event.PolicyViolation{{Rule: "namespaces", Message: "kube-system is forbidden"}}, nil
+	}
+	return nil, nil
+}
+
+func Test_OPAEvaluator(t *testing.T) {
+	result := ""
+	status := http.StatusOK
+	var input map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/argocd_agent/deny", r.URL.Path)
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(result))
+	}))
+	defer srv.Close()
+
+	e := &OPAEvaluator{URL: srv.URL + "/", Path: "/argocd_agent/deny"}
+	app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "guestbook", Namespace: "argocd"}}
+	evaluate := func() ([]event.PolicyViolation, error) {
+		return e.Evaluate(context.Background(), &PolicyInput{Operation: "create", Application: app})
+	}
+
+	t.Run("Set of messages", func(t *testing.T) {
+		result = `{"result":["no limits","no owner"]}`
+		violations, err := evaluate()
+		require.NoError(t, err)
+		assert.Equal(t, []event.PolicyViolation{{Rule: "/argocd_agent/deny", Message: "no limits"}, {Rule: "/argocd_agent/deny", Message: "no owner"}}, violations)
+		assert.Equal(t, "create", input["input"].(map[string]any)["operation"])
+		assert.Equal(t, "guestbook", input["input"].(map[string]any)["application"].(map[string]any)["metadata"].(map[string]any)["name"])
+	})
+	t.Run("Set of violations", func(t *testing.T) {
+		result = `{"result":[{"rule":"limits","message":"no limits"},{"message":"no owner"}]}`
+		violations, err := evaluate()
+		require.NoError(t, err)
+		assert.Equal(t, []event.PolicyViolation{{Rule: "limits", Message: "no limits"}, {Rule: "/argocd_agent/deny", Message: "no owner"}}, violations)
+	})
+	t.Run("Undefined rule", func(t *testing.T) {
+		result = `{}`
+		violations, err := evaluate()
+		require.NoError(t, err)
+		assert.Empty(t, violations)
+	})
+	t.Run("Unexpected result", func(t *testing.T) {
+		result = `{"result":true}`
+		_, err := evaluate()
+		assert.ErrorContains(t, err, "neither a set of messages nor of violations")
+	})
+	t.Run("Error response", func(t *testing.T) {
+		result = `{"code":"internal_error"}`
+		status = http.StatusInternalServerError
+		defer func() { status = http.StatusOK }()
+		_, err := evaluate()
+		assert.ErrorContains(t, err, "OPA returned 500")
+	})
+}
+
+func Test_EvaluateSpecPolicy(t *testing.T) {
+	forbidden := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "kube-system"}}}
+	allowed := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "guestbook"}}}
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert.Empty(t, (&Agent{}).evaluateSpecPolicy(forbidden, "create"))
+	})
+	t.Run("Enforced", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithSpecPolicy(SpecPolicy{Evaluator: &fakePolicyEvaluator{}, Enforce: true})(a))
+		assert.Empty(t, a.evaluateSpecPolicy(allowed, "create"))
+		assert.Equal(t, []event.PolicyViolation{{Rule: "namespaces", Message: "kube-system is forbidden"}}, a.evaluateSpecPolicy(forbidden, "create"))
+	})
+	t.Run("Evaluation errors are violations", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithSpecPolicy(SpecPolicy{Evaluator: &fakePolicyEvaluator{err: errors.New("connection refused")}, Enforce: true})(a))
+		assert.Equal(t, []event.PolicyViolation{{Rule: policyEvaluationRule, Message: "connection refused"}}, a.evaluateSpecPolicy(allowed, "update"))
+	})
+	t.Run("Warn only", func(t *testing.T) {
+		a := &Agent{}
+		require.NoError(t, WithSpecPolicy(SpecPolicy{Evaluator: &fakePolicyEvaluator{}})(a))
+		assert.Empty(t, a.evaluateSpecPolicy(forbidden, "create"))
+	})
+	t.Run("Evaluator is required", func(t *testing.T) {
+		assert.Error(t, WithSpecPolicy(SpecPolicy{Enforce: true})(&Agent{}))
+	})
+}
+
+func Test_ProcessIncomingApplicationSpecPolicy(t *testing.T) {
+	evs := event.NewEventSource("test")
+	a, _ := newAgent(t)
+	a.mode = types.AgentModeManaged
+	a.context = context.Background()
+	a.emitter = event.NewEventSource("agent")
+	a.eventWriter = event.NewEventWriter("", &nopStream{})
+	require.NoError(t, WithSpecPolicy(SpecPolicy{Evaluator: &fakePolicyEvaluator{}, Enforce: true})(a))
+
+	be := backend_mocks.NewApplication(t)
+	var err error
+	a.appManager, err = application.NewApplicationManager(be, "argocd",
+		application.WithRole(manager.ManagerRoleAgent),
+		application.WithMode(manager.ManagerModeManaged),
+	)
+	require.NoError(t, err)
+	be.On("Get", mock.Anything, "guestbook", "argocd").Return(nil, kerrors.NewNotFound(schema.GroupResource{Group: "argoproj.io", Resource: "applications"}, "guestbook")).Maybe()
+
+	incoming := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{Name: "guestbook", Namespace: "agent", UID: "principal-uid"},
+		Spec:       v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: "kube-system"}},
+	}
+
+	t.Run("Violations are reported to principals that understand them", func(t *testing.T) {
+		a.eventWriter.SetSchemaVersion(event.SchemaVersion10)
+		require.NoError(t, a.processIncomingApplication(event.New(evs.ApplicationEvent(event.Create, incoming.DeepCopy()), event.TargetApplication)))
+		be.AssertNotCalled(t, "Create")
+		require.Equal(t, 1, a.queues.SendQ(defaultQueueName).Len())
+		ev, _ := a.queues.SendQ(defaultQueueName).Get()
+		a.queues.SendQ(defaultQueueName).Done(ev)
+		assert.Equal(t, event.TargetPolicyViolation, event.Target(ev))
+		report, err := event.New(ev, event.TargetPolicyViolation).PolicyViolationReport()
+		require.NoError(t, err)
+		assert.Equal(t, &event.PolicyViolationReport{
+			Name:       "guestbook",
+			Namespace:  "agent",
+			UID:        "principal-uid",
+			Operation:  "create",
+			Violations: []event.PolicyViolation{{Rule: "namespaces", Message: "kube-system is forbidden"}},
+		}, report)
+	})
+
+	t.Run("Older principals are not sent reports", func(t *testing.T) {
+		a.eventWriter.SetSchemaVersion(event.SchemaVersion9)
+		require.NoError(t, a.processIncomingApplication(event.New(evs.ApplicationEvent(event.SpecUpdate, incoming.DeepCopy()), event.TargetApplication)))
+		be.AssertNotCalled(t, "Update")
+		assert.Equal(t, 0, a.queues.SendQ(defaultQueueName).Len())
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// WithStateChecksumInterval makes the agent send the checksums of the state
+// of its Applications to the principal every interval. The principal
+// compares them with its own state and resyncs the Applications that
+// diverged. An interval of 0 disables the comparison.
+func WithStateChecksumInterval(interval time.Duration) AgentOption {
+	return func(a *Agent) error {
+		if interval < 0 {
+			return fmt.Errorf("state checksum interval must not be negative")
+		}
+		a.options.stateChecksumInterval = interval
+		return nil
+	}
+}
+
+// runStateChecksum sends the state checksums to the principal every state
+// checksum interval, until ctx is done
+func (a *Agent) runStateChecksum(ctx context.Context) {
+	ticker := time.NewTicker(a.options.stateChecksumInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !a.IsConnected() || !a.supportsStateChecksum() {
+				continue
+			}
+			report, err := a.stateChecksum(ctx)
+			if err != nil {
+				log().WithError(err).Warn("Could not compute state checksum")
+				continue
+			}
+			if q := a.queues.SendQ(defaultQueueName); q != nil {
+				q.Add(a.emitter.StateChecksumEvent(report))
+			}
+		}
+	}
+}
+
+// supportsStateChecksum returns whether the principal understands state
+// checksum reports
+func (a *Agent) supportsStateChecksum() bool {
+	return a.eventWriter != nil && a.eventWriter.SchemaVersion() >= event.SchemaVersion8
+}
+
+// stateChecksum computes the checksums of the state of all Applications the
+// agent exchanges with the principal
+func (a *Agent) stateChecksum(ctx context.Context) (*event.StateChecksum, error) {
+	apps, err := a.exchangedApplications(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &event.StateChecksum{}
+	for _, app := range apps {
+		uid := resources.NewResourceKeyFromApp(app).UID
+		report.Applications = append(report.Applications, resync.NewApplicationChecksum(uid, a.outgoingApplication(app)))
+	}
+	report.Checksum = resync.AggregateChecksum(report.Applications)
+	return report, nil
+}
+
+// exchangedApplications returns the Applications whose state the agent
+// exchanges with the principal: those created by the principal for managed
+// agents, and those created locally for autonomous agents.
+func (a *Agent) exchangedApplications(ctx context.Context) ([]*v1alpha1.Application, error) {
+	list, err := a.appManager.List(ctx, backend.ApplicationSelector{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list applications: %w", err)
+	}
+	apps := []*v1alpha1.Application{}
+	for i := range list {
+		app := &list[i]
+		if !a.appManager.IsManaged(app.QualifiedName()) || app.DeletionTimestamp != nil {
+			continue
+		}
+		_, fromPrincipal := app.Annotations[manager.SourceUIDAnnotation]
+		if fromPrincipal != a.mode.IsManaged() {
+			continue
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// processIncomingDivergedApplications sends the state of the Applications
+// whose checksums didn't match the principal's: the status of managed
+// Applications, and the whole Application for autonomous agents.
+func (a *Agent) processIncomingDivergedApplications(ev *event.Event) error {
+	if ev.Type() != event.ApplicationsDiverged {
+		return fmt.Errorf("unexpected state checksum event %s", ev.Type())
+	}
+	diverged, err := ev.DivergedApplications()
+	if err != nil {
+		return err
+	}
+	uids := make(map[string]bool, len(diverged.UIDs))
+	for _, uid := range diverged.UIDs {
+		uids[uid] = true
+	}
+	apps, err := a.exchangedApplications(a.context)
+	if err != nil {
+		return err
+	}
+	q := a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		return fmt.Errorf("no send queue for %s", defaultQueueName)
+	}
+	sent := 0
+	for _, app := range apps {
+		uid := resources.NewResourceKeyFromApp(app).UID
+		if !uids[uid] {
+			continue
+		}
+		out := a.outgoingApplication(app)
+		if a.mode.IsManaged() {
+			if a.options.statusReporter != nil {
+				a.options.statusReporter.record(out)
+			}
+			q.Add(a.emitter.ApplicationEvent(event.StatusUpdate, out))
+		} else {
+			if hub, ok := diverged.Specs[uid]; ok {
+				if ev := a.driftEvent(uid, hub, out); ev != nil {
+					q.Add(ev)
+				}
+			}
+			// Create events update Applications that already exist
+			q.Add(a.emitter.ApplicationEvent(event.Create, out))
+		}
+		sent++
+	}
+	log().WithFields(logrus.Fields{
+		"requested": len(diverged.UIDs),
+		"sent":      sent,
+	}).Info("Sent the state of applications that diverged from the principal")
+	return nil
+}
+
+// driftEvent logs how the principal's copy of the autonomous Application
+// app, whose spec on the principal is hub, drifted from the agent's. It
+// returns the event reporting the drift to the principal, before the
+// principal's copy is overwritten, or nil if there is no drift or the
+// principal doesn't understand drift reports.
+func (a *Agent) driftEvent(uid string, hub v1alpha1.ApplicationSpec, app *v1alpha1.Application) *cloudevents.Event {
+	fields := resync.DiffSpecs(hub, resync.SpecForComparison(app))
+	if len(fields) == 0 {
+		return nil
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"application": app.QualifiedName(),
+		"fields":      len(fields),
+	})
+	for _, f := range fields {
+		logCtx.Warnf("Spec of application drifted on the principal: %s is %s, but %s on the agent", f.Path, valueOrUnset(f.Hub), valueOrUnset(f.Spoke))
+	}
+	if a.eventWriter == nil || a.eventWriter.SchemaVersion() < event.SchemaVersion11 {
+		return nil
+	}
+	return a.emitter.DriftEvent(&event.DriftReport{
+		Name:       app.Name,
+		Namespace:  app.Namespace,
+		UID:        uid,
+		DetectedAt: time.Now(),
+		Fields:     fields,
+	})
+}
+
+func valueOrUnset(v string) string {
+	if v == "" {
+		return "unset"
+	}
+	return v
+}
@@ -0,0 +1,122 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_StateChecksum(t *testing.T) {
+	fromPrincipal := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{
+		Name: "from-principal", Namespace: "argocd", UID: "agent-uid-1",
+		Annotations: map[string]string{manager.SourceUIDAnnotation: "principal-uid-1"},
+	}}
+	local := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{
+		Name: "local", Namespace: "argocd", UID: "agent-uid-2",
+	}}
+	newStateAgent := func(t *testing.T, mode types.AgentMode) *Agent {
+		t.Helper()
+		a, _ := newAgent(t, fromPrincipal.DeepCopy(), local.DeepCopy())
+		a.emitter = event.NewEventSource("test")
+		a.mode = mode
+		require.NoError(t, a.appManager.Manage(fromPrincipal.QualifiedName()))
+		require.NoError(t, a.appManager.Manage(local.QualifiedName()))
+		return a
+	}
+
+	t.Run("Managed agents report the applications of the principal", func(t *testing.T) {
+		a := newStateAgent(t, types.AgentModeManaged)
+		report, err := a.stateChecksum(context.Background())
+		require.NoError(t, err)
+		require.Len(t, report.Applications, 1)
+		assert.Equal(t, "principal-uid-1", report.Applications[0].UID)
+		assert.Equal(t, resync.AggregateChecksum(report.Applications), report.Checksum)
+	})
+
+	t.Run("Autonomous agents report their own applications", func(t *testing.T) {
+		a := newStateAgent(t, types.AgentModeAutonomous)
+		report, err := a.stateChecksum(context.Background())
+		require.NoError(t, err)
+		require.Len(t, report.Applications, 1)
+		assert.Equal(t, "agent-uid-2", report.Applications[0].UID)
+	})
+
+	t.Run("Managed agents send the status of diverged applications", func(t *testing.T) {
+		a := newStateAgent(t, types.AgentModeManaged)
+		ev := event.New(a.emitter.ApplicationsDivergedEvent(&event.DivergedApplications{UIDs: []string{"principal-uid-1", "unknown"}}), event.TargetStateChecksum)
+		require.NoError(t, a.processIncomingDivergedApplications(ev))
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 1, q.Len())
+		sent, _ := q.Get()
+		assert.Equal(t, event.StatusUpdate.String(), sent.Type())
+	})
+
+	t.Run("Autonomous agents send diverged applications", func(t *testing.T) {
+		a := newStateAgent(t, types.AgentModeAutonomous)
+		ev := event.New(a.emitter.ApplicationsDivergedEvent(&event.DivergedApplications{UIDs: []string{"agent-uid-2"}}), event.TargetStateChecksum)
+		require.NoError(t, a.processIncomingDivergedApplications(ev))
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 1, q.Len())
+		sent, _ := q.Get()
+		assert.Equal(t, event.Create.String(), sent.Type())
+	})
+
+	t.Run("Autonomous agents report drift of diverged specs", func(t *testing.T) {
+		a := newStateAgent(t, types.AgentModeAutonomous)
+		a.eventWriter = event.NewEventWriter("", &nopStream{})
+		a.eventWriter.SetSchemaVersion(event.SchemaVersion11)
+		ev := event.New(a.emitter.ApplicationsDivergedEvent(&event.DivergedApplications{
+			UIDs:  []string{"agent-uid-2"},
+			Specs: map[string]v1alpha1.ApplicationSpec{"agent-uid-2": {Project: "edited"}},
+		}), event.TargetStateChecksum)
+		require.NoError(t, a.processIncomingDivergedApplications(ev))
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 2, q.Len())
+		sent, _ := q.Get()
+		require.Equal(t, event.DriftReported.String(), sent.Type())
+		report, err := event.New(sent, event.TargetDrift).DriftReport()
+		require.NoError(t, err)
+		assert.Equal(t, "local", report.Name)
+		assert.Equal(t, "agent-uid-2", report.UID)
+		assert.Equal(t, []event.DriftedField{{Path: "project", Hub: `"edited"`, Spoke: `""`}}, report.Fields)
+		sent, _ = q.Get()
+		assert.Equal(t, event.Create.String(), sent.Type())
+	})
+
+	t.Run("Drift is not reported to older principals", func(t *testing.T) {
+		a := newStateAgent(t, types.AgentModeAutonomous)
+		a.eventWriter = event.NewEventWriter("", &nopStream{})
+		a.eventWriter.SetSchemaVersion(event.SchemaVersion10)
+		ev := event.New(a.emitter.ApplicationsDivergedEvent(&event.DivergedApplications{
+			UIDs:  []string{"agent-uid-2"},
+			Specs: map[string]v1alpha1.ApplicationSpec{"agent-uid-2": {Project: "edited"}},
+		}), event.TargetStateChecksum)
+		require.NoError(t, a.processIncomingDivergedApplications(ev))
+		q := a.queues.SendQ(defaultQueueName)
+		require.Equal(t, 1, q.Len())
+		sent, _ := q.Get()
+		assert.Equal(t, event.Create.String(), sent.Type())
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+)
+
+// WithDifferentialStatus makes the agent send the status of an Application
+// to the principal only when it changed in more than timestamps, such as
+// the time of the last reconciliation. Every resyncInterval, the status of
+// all Applications is sent regardless. A resyncInterval of 0 disables the
+// periodic resync.
+func WithDifferentialStatus(resyncInterval time.Duration) AgentOption {
+	return func(a *Agent) error {
+		a.options.statusReporter = newStatusReporter()
+		a.options.statusResyncInterval = resyncInterval
+		return nil
+	}
+}
+
+// statusReporter remembers the fingerprint of the status last sent for each
+// Application
+type statusReporter struct {
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+func newStatusReporter() *statusReporter {
+	return &statusReporter{sent: make(map[string]string)}
+}
+
+// changed returns whether the status of app differs from the one last sent,
+// and records it as sent
+func (r *statusReporter) changed(app *v1alpha1.Application) bool {
+	fp := statusFingerprint(app)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.sent[app.QualifiedName()]; ok && last == fp {
+		return false
+	}
+	r.sent[app.QualifiedName()] = fp
+	return true
+}
+
+// record records the status of app as sent
+func (r *statusReporter) record(app *v1alpha1.Application) {
+	fp := statusFingerprint(app)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[app.QualifiedName()] = fp
+}
+
+func (r *statusReporter) forget(app *v1alpha1.Application) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sent, app.QualifiedName())
+}
+
+// statusFingerprint returns a hash of everything the principal takes from
+// a status update of app, except for timestamps that change on every
+// reconciliation
+func statusFingerprint(app *v1alpha1.Application) string {
+	status := app.Status.DeepCopy()
+	status.ReconciledAt = nil
+	status.ObservedAt = nil
+	status.Health.LastTransitionTime = nil
+	for i := range status.Conditions {
+		status.Conditions[i].LastTransitionTime = nil
+	}
+	data, _ := json.Marshal(struct {
+		Spec      v1alpha1.ApplicationSpec    `json:"spec"`
+		Operation *v1alpha1.Operation         `json:"operation,omitempty"`
+		Status    *v1alpha1.ApplicationStatus `json:"status"`
+		Refresh   string                      `json:"refresh,omitempty"`
+		Deleting  bool                        `json:"deleting,omitempty"`
+	}{
+		Spec:      app.Spec,
+		Operation: app.Operation,
+		Status:    status,
+		Refresh:   app.Annotations["argocd.argoproj.io/refresh"],
+		Deleting:  app.DeletionTimestamp != nil,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runStatusResync sends the status of all managed Applications to the
+// principal every status resync interval, until ctx is done
+func (a *Agent) runStatusResync(ctx context.Context) {
+	ticker := time.NewTicker(a.options.statusResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if a.IsConnected() {
+				a.resyncStatus(ctx)
+			}
+		}
+	}
+}
+
+// resyncStatus sends the status of all managed Applications to the
+// principal, whether or not it changed
+func (a *Agent) resyncStatus(ctx context.Context) {
+	if a.mode != types.AgentModeManaged {
+		return
+	}
+	apps, err := a.appManager.List(ctx, backend.ApplicationSelector{})
+	if err != nil {
+		log().WithError(err).Warn("Could not list applications for status resync")
+		return
+	}
+	q := a.queues.SendQ(defaultQueueName)
+	if q == nil {
+		return
+	}
+	a.watchLock.Lock()
+	defer a.watchLock.Unlock()
+	n := 0
+	for i := range apps {
+		app := a.outgoingApplication(&apps[i])
+		if !a.appManager.IsManaged(app.QualifiedName()) {
+			continue
+		}
+		a.options.statusReporter.record(app)
+		q.Add(a.emitter.ApplicationEvent(event.StatusUpdate, app))
+		n++
+	}
+	log().Debugf("Resynced the status of %d applications", n)
+}
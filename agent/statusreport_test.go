@@ -0,0 +1,93 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_DifferentialStatus(t *testing.T) {
+	app := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "argocd"}}
+	app.Status.Sync.Status = v1alpha1.SyncStatusCodeSynced
+	app.Status.ReconciledAt = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+
+	t.Run("Fingerprint ignores timestamps", func(t *testing.T) {
+		reconciled := app.DeepCopy()
+		reconciled.Status.ReconciledAt = &metav1.Time{Time: time.Now()}
+		reconciled.Status.Health.LastTransitionTime = &metav1.Time{Time: time.Now()}
+		assert.Equal(t, statusFingerprint(app), statusFingerprint(reconciled))
+		outOfSync := app.DeepCopy()
+		outOfSync.Status.Sync.Status = v1alpha1.SyncStatusCodeOutOfSync
+		assert.NotEqual(t, statusFingerprint(app), statusFingerprint(outOfSync))
+		refresh := app.DeepCopy()
+		refresh.Annotations = map[string]string{"argocd.argoproj.io/refresh": "normal"}
+		assert.NotEqual(t, statusFingerprint(app), statusFingerprint(refresh))
+	})
+
+	t.Run("Unchanged status is not sent", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.emitter = event.NewEventSource("test")
+		a.mode = types.AgentModeManaged
+		require.NoError(t, WithDifferentialStatus(0)(a))
+		require.NoError(t, a.appManager.Manage(app.QualifiedName()))
+		q := a.queues.SendQ(defaultQueueName)
+
+		a.addAppUpdateToQueue(app, app)
+		require.Equal(t, 1, q.Len())
+
+		reconciled := app.DeepCopy()
+		reconciled.Status.ReconciledAt = &metav1.Time{Time: time.Now()}
+		a.addAppUpdateToQueue(app, reconciled)
+		assert.Equal(t, 1, q.Len())
+
+		outOfSync := reconciled.DeepCopy()
+		outOfSync.Status.Sync.Status = v1alpha1.SyncStatusCodeOutOfSync
+		a.addAppUpdateToQueue(reconciled, outOfSync)
+		assert.Equal(t, 2, q.Len())
+
+		// After the app was deleted, its status is sent again
+		a.addAppDeletionToQueue(outOfSync)
+		require.NoError(t, a.appManager.Manage(app.QualifiedName()))
+		a.addAppUpdateToQueue(outOfSync, outOfSync)
+		assert.Equal(t, 4, q.Len())
+	})
+
+	t.Run("Resync sends all statuses", func(t *testing.T) {
+		a, _ := newAgent(t, app.DeepCopy())
+		a.emitter = event.NewEventSource("test")
+		a.mode = types.AgentModeManaged
+		require.NoError(t, WithDifferentialStatus(time.Minute)(a))
+		require.NoError(t, a.appManager.Manage(app.QualifiedName()))
+		q := a.queues.SendQ(defaultQueueName)
+
+		a.resyncStatus(context.Background())
+		require.Equal(t, 1, q.Len())
+		ev, _ := q.Get()
+		assert.Equal(t, event.StatusUpdate.String(), ev.Type())
+
+		// The resynced status counts as sent
+		a.addAppUpdateToQueue(app, app)
+		assert.Equal(t, 0, q.Len())
+	})
+}
@@ -0,0 +1,276 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultSupportBundleLimitBytes is the default maximum number of log bytes
+// collected per container for a support bundle
+const defaultSupportBundleLimitBytes int64 = 1024 * 1024
+
+// processIncomingSupportBundleRequest handles support bundle requests from
+// the principal. The bundle is collected and sent in the background.
+func (a *Agent) processIncomingSupportBundleRequest(ev *event.Event) error {
+	req, err := ev.SupportBundleRequest()
+	if err != nil {
+		return err
+	}
+	if req.Namespace == "" {
+		req.Namespace = a.namespace
+	}
+	if req.LimitBytes <= 0 {
+		req.LimitBytes = defaultSupportBundleLimitBytes
+	}
+
+	logCtx := log().WithFields(logrus.Fields{
+		"method":      "processIncomingSupportBundleRequest",
+		"uuid":        req.UUID,
+		"application": req.Application,
+		"namespace":   req.Namespace,
+	})
+	logCtx.Info("Processing support bundle request")
+
+	go func() {
+		if err := a.sendSupportBundle(req, logCtx); err != nil {
+			logCtx.WithError(err).Error("Could not send support bundle")
+		}
+	}()
+	return nil
+}
+
+// sendSupportBundle collects the support bundle and sends it to the
+// principal over the FileTransfer service. The bundle is kept in memory, so
+// that the transfer can be resumed if it is interrupted.
+func (a *Agent) sendSupportBundle(req *event.SupportBundleRequest, logCtx *logrus.Entry) error {
+	ctx, done, err := a.inflight.Start(a.context, InflightSupportBundle, req.UUID, map[string]string{
+		"application": req.Application,
+		"namespace":   req.Namespace,
+	})
+	if errors.Is(err, inflight.ErrDuplicate) {
+		logCtx.Warn("Duplicate support bundle request; already processing")
+		return nil
+	} else if err != nil {
+		if serr := a.sendFileTransferError(a.context, req.UUID, err); serr != nil {
+			logCtx.WithError(serr).Warn("Could not report error to principal")
+		}
+		return err
+	}
+	defer done()
+
+	var buf bytes.Buffer
+	if err := a.writeSupportBundle(ctx, &buf, req, logCtx); err != nil {
+		if serr := a.sendFileTransferError(ctx, req.UUID, err); serr != nil {
+			logCtx.WithError(serr).Warn("Could not report error to principal")
+		}
+		return err
+	}
+
+	resp, err := a.uploadFile(ctx, &fileUpload{
+		requestUUID: req.UUID,
+		name:        fmt.Sprintf("%s-support-bundle.tar.gz", req.Application),
+		contentType: "application/gzip",
+		src:         bytes.NewReader(buf.Bytes()),
+		size:        int64(buf.Len()),
+	}, logCtx)
+	if err != nil {
+		return err
+	}
+	logCtx.WithField("bytes", resp.GetBytesReceived()).Info("Support bundle sent")
+	return nil
+}
+
+// writeSupportBundle writes a tar.gz archive with the logs of all pods of the
+// requested application to w.
+func (a *Agent) writeSupportBundle(ctx context.Context, w io.Writer, req *event.SupportBundleRequest, logCtx *logrus.Entry) error {
+	app, err := a.appManager.Get(ctx, req.Application, req.Namespace)
+	if err != nil {
+		return fmt.Errorf("could not get application %s/%s: %w", req.Namespace, req.Application, err)
+	}
+	return writeSupportBundle(ctx, w, a.kubeClient.Clientset, app, req, a.options.logAccessRules, logCtx)
+}
+
+// writeSupportBundle writes the support bundle of app to w. Logs of pods
+// denied by rules are left out, which is noted in the bundle's errors.txt.
+func writeSupportBundle(ctx context.Context, w io.Writer, clientset kubernetes.Interface, app *v1alpha1.Application, req *event.SupportBundleRequest, rules *logAccessRules, logCtx *logrus.Entry) error {
+	pods, err := applicationPods(ctx, clientset, app)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	var errs bytes.Buffer
+
+	addFile := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	for _, pod := range pods {
+		if err := rules.check(pod.Namespace, pod.Name); err != nil {
+			fmt.Fprintf(&errs, "%s: %v\n", path.Join(pod.Namespace, pod.Name), err)
+			continue
+		}
+		restarts := map[string]int32{}
+		for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			restarts[cs.Name] = cs.RestartCount
+		}
+		for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			dir := path.Join(pod.Namespace, pod.Name)
+			for _, previous := range []bool{false, true} {
+				if previous && (!req.Previous || restarts[c.Name] == 0) {
+					continue
+				}
+				name := c.Name + ".log"
+				if previous {
+					name = c.Name + ".previous.log"
+				}
+				data, err := readContainerLogs(ctx, clientset, &pod, c.Name, previous, req.LimitBytes)
+				if err != nil {
+					logCtx.WithError(err).WithField("file", path.Join(dir, name)).Warn("Could not collect logs")
+					fmt.Fprintf(&errs, "%s: %v\n", path.Join(dir, name), err)
+					continue
+				}
+				if err := addFile(path.Join(dir, name), data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if errs.Len() > 0 {
+		if err := addFile("errors.txt", errs.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// applicationPods returns the pods belonging to the application, i.e. pods
+// whose owner, or the owner of their owner, is a resource managed by the
+// application. Pods managed directly by the application are included too.
+func applicationPods(ctx context.Context, clientset kubernetes.Interface, app *v1alpha1.Application) ([]corev1.Pod, error) {
+	type ref struct{ kind, namespace, name string }
+	managed := map[ref]bool{}
+	namespaces := map[string]bool{}
+	for _, res := range app.Status.Resources {
+		if res.Namespace == "" {
+			continue
+		}
+		managed[ref{res.Kind, res.Namespace, res.Name}] = true
+		namespaces[res.Namespace] = true
+	}
+
+	var pods []corev1.Pod
+	for ns := range namespaces {
+		// Owners of intermediate resources, e.g. the Deployment of a ReplicaSet
+		parents := map[ref][]metav1.OwnerReference{}
+		rsList, err := clientset.AppsV1().ReplicaSets(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not list replicasets in %s: %w", ns, err)
+		}
+		for _, rs := range rsList.Items {
+			parents[ref{"ReplicaSet", ns, rs.Name}] = rs.OwnerReferences
+		}
+		jobList, err := clientset.BatchV1().Jobs(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not list jobs in %s: %w", ns, err)
+		}
+		for _, job := range jobList.Items {
+			parents[ref{"Job", ns, job.Name}] = job.OwnerReferences
+		}
+
+		podList, err := clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not list pods in %s: %w", ns, err)
+		}
+		for _, pod := range podList.Items {
+			belongs := managed[ref{"Pod", ns, pod.Name}]
+			for _, owner := range pod.OwnerReferences {
+				if belongs {
+					break
+				}
+				r := ref{owner.Kind, ns, owner.Name}
+				if managed[r] {
+					belongs = true
+					break
+				}
+				for _, parent := range parents[r] {
+					if managed[ref{parent.Kind, ns, parent.Name}] {
+						belongs = true
+						break
+					}
+				}
+			}
+			if belongs {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, nil
+}
+
+// readContainerLogs reads at most limitBytes of the logs of a container.
+func readContainerLogs(ctx context.Context, clientset kubernetes.Interface, pod *corev1.Pod, container string, previous bool, limitBytes int64) ([]byte, error) {
+	rc, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  container,
+		Previous:   previous,
+		Timestamps: true,
+		LimitBytes: &limitBytes,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, limitBytes))
+}
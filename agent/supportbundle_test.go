@@ -0,0 +1,109 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_writeSupportBundle(t *testing.T) {
+	owner := func(kind, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: kind, Name: name}}
+	}
+	pod := func(name string, owners []metav1.OwnerReference, restarts int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "guestbook", OwnerReferences: owners},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "main", RestartCount: restarts}}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "ui-7d9f", Namespace: "guestbook", OwnerReferences: owner("Deployment", "ui")}},
+		pod("ui-7d9f-abcde", owner("ReplicaSet", "ui-7d9f"), 1),
+		pod("db-0", owner("StatefulSet", "db"), 0),
+		pod("other", owner("ReplicaSet", "unrelated"), 0),
+	)
+	app := &v1alpha1.Application{
+		Status: v1alpha1.ApplicationStatus{Resources: []v1alpha1.ResourceStatus{
+			{Kind: "Deployment", Namespace: "guestbook", Name: "ui"},
+			{Kind: "StatefulSet", Namespace: "guestbook", Name: "db"},
+			{Kind: "Namespace", Name: "guestbook"},
+		}},
+	}
+
+	t.Run("application pods are found via owners", func(t *testing.T) {
+		pods, err := applicationPods(context.Background(), clientset, app)
+		require.NoError(t, err)
+		require.Len(t, pods, 2)
+		assert.Equal(t, "db-0", pods[0].Name)
+		assert.Equal(t, "ui-7d9f-abcde", pods[1].Name)
+	})
+
+	readBundle := func(t *testing.T, buf *bytes.Buffer) map[string]string {
+		t.Helper()
+		gz, err := gzip.NewReader(buf)
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+		files := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = string(data)
+		}
+		return files
+	}
+
+	t.Run("bundle contains current and previous logs", func(t *testing.T) {
+		var buf bytes.Buffer
+		req := &event.SupportBundleRequest{Application: "guestbook", Previous: true, LimitBytes: 1024}
+		require.NoError(t, writeSupportBundle(context.Background(), &buf, clientset, app, req, nil, logrus.NewEntry(logrus.New())))
+		assert.Equal(t, map[string]string{
+			"guestbook/db-0/main.log":                   "fake logs",
+			"guestbook/ui-7d9f-abcde/main.log":          "fake logs",
+			"guestbook/ui-7d9f-abcde/main.previous.log": "fake logs",
+		}, readBundle(t, &buf))
+	})
+
+	t.Run("bundle leaves out logs denied by log access rules", func(t *testing.T) {
+		var buf bytes.Buffer
+		req := &event.SupportBundleRequest{Application: "guestbook", LimitBytes: 1024}
+		rules := &logAccessRules{deny: []string{"guestbook/db-*"}}
+		require.NoError(t, writeSupportBundle(context.Background(), &buf, clientset, app, req, rules, logrus.NewEntry(logrus.New())))
+		assert.Equal(t, map[string]string{
+			"guestbook/ui-7d9f-abcde/main.log": "fake logs",
+			"errors.txt":                       "guestbook/db-0: the agent does not allow reading the logs of pod guestbook/db-0\n",
+		}, readBundle(t, &buf))
+	})
+}
@@ -16,6 +16,7 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -23,6 +24,7 @@ import (
 	"sync"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/terminalstreamapi"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
@@ -51,24 +53,21 @@ func (a *Agent) processIncomingTerminalRequest(ev *event.Event) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse terminal request: %w", err)
 	}
+	terminalReq.Namespace = a.options.namespaceMapper.ToSpoke(terminalReq.Namespace)
 
 	// Ensure at a time only one web terminal is opened for an application.
-	a.inflightMu.Lock()
-	if a.inflightTerminal == nil {
-		a.inflightTerminal = make(map[string]struct{})
-	}
-	if _, dup := a.inflightTerminal[terminalReq.UUID]; dup {
-		a.inflightMu.Unlock()
+	ctx, done, err := a.inflight.Start(a.context, InflightTerminal, terminalReq.UUID, map[string]string{
+		"namespace": terminalReq.Namespace,
+		"pod":       terminalReq.PodName,
+		"container": terminalReq.ContainerName,
+	})
+	if errors.Is(err, inflight.ErrDuplicate) {
 		log().WithField("session_uuid", terminalReq.UUID).Warn("duplicate terminal request; already processing")
 		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not start terminal session: %w", err)
 	}
-	a.inflightTerminal[terminalReq.UUID] = struct{}{}
-	a.inflightMu.Unlock()
-	defer func() {
-		a.inflightMu.Lock()
-		delete(a.inflightTerminal, terminalReq.UUID)
-		a.inflightMu.Unlock()
-	}()
+	defer done()
 
 	logCtx := log().WithFields(logrus.Fields{
 		"method":       "processIncomingTerminalRequest",
@@ -81,9 +80,6 @@ func (a *Agent) processIncomingTerminalRequest(ev *event.Event) error {
 
 	logCtx.Info("Processing terminal request")
 
-	ctx, cancel := context.WithCancel(a.context)
-	defer cancel()
-
 	// Connect to principal's terminal stream service
 	conn := a.remote.Conn()
 	terminalStreamClient := terminalstreamapi.NewTerminalStreamServiceClient(conn)
@@ -128,7 +124,7 @@ func (a *Agent) processIncomingTerminalRequest(ev *event.Event) error {
 // terminalInPod executes a command in a pod and streams I/O via gRPC.
 func (a *Agent) terminalInPod(ctx context.Context, stream terminalstreamapi.TerminalStreamService_StreamTerminalClient, terminalReq *event.ContainerTerminalRequest, logCtx *logrus.Entry) error {
 	// Build Kubernetes exec request
-	req := a.kubeClient.Clientset.CoreV1().RESTClient().Post().
+	req := a.proxyKubeClient().Clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(terminalReq.PodName).
 		Namespace(terminalReq.Namespace).
@@ -193,7 +189,7 @@ func (a *Agent) terminalInPod(ctx context.Context, stream terminalstreamapi.Term
 	// Try WebSocket executor first, fall back to SPDY if the cluster does not
 	// support WebSocket-based exec (e.g. TranslateStreamCloseWebsocketRequests
 	// feature gate is disabled).
-	exec, err := newWebSocketExecutor(a.kubeClient.RestConfig, "GET", req.URL().String())
+	exec, err := newWebSocketExecutor(a.proxyKubeClient().RestConfig, "GET", req.URL().String())
 	if err != nil {
 		return fmt.Errorf("failed to create WebSocket executor: %w", err)
 	}
@@ -203,7 +199,7 @@ func (a *Agent) terminalInPod(ctx context.Context, stream terminalstreamapi.Term
 	if err != nil && isWebSocketHandshakeError(err) {
 		logCtx.WithError(err).Warn("WebSocket exec failed, retrying with SPDY")
 
-		spdyExec, spdyErr := newSPDYExecutor(a.kubeClient.RestConfig, "POST", req.URL())
+		spdyExec, spdyErr := newSPDYExecutor(a.proxyKubeClient().RestConfig, "POST", req.URL())
 		if spdyErr != nil {
 			return fmt.Errorf("failed to create SPDY executor: %w", spdyErr)
 		}
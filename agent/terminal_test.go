@@ -28,6 +28,7 @@ import (
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/terminalstreamapi"
 	"github.com/argoproj-labs/argocd-agent/principal/apis/terminalstream/mock"
@@ -43,11 +44,9 @@ import (
 func createTestTerminalAgent() *Agent {
 	ctx, cancel := context.WithCancel(context.Background())
 	agent := &Agent{
-		context:          ctx,
-		cancelFn:         cancel,
-		inflightLogs:     make(map[string]struct{}),
-		inflightTerminal: make(map[string]struct{}),
-		inflightMu:       sync.Mutex{},
+		context:  ctx,
+		cancelFn: cancel,
+		inflight: inflight.NewRegistry(),
 	}
 	return agent
 }
@@ -64,7 +63,7 @@ func createTestTerminalAgentWithKube(t *testing.T, ctx context.Context, cancel c
 			RestConfig: cfg,
 			Clientset:  clientset,
 		},
-		inflightTerminal: make(map[string]struct{}),
+		inflight: inflight.NewRegistry(),
 	}
 }
 
@@ -537,9 +536,9 @@ func TestDuplicateTerminalRequest(t *testing.T) {
 	terminalReq := createTestTerminalRequest()
 
 	// Add a terminal request to inflight
-	agent.inflightMu.Lock()
-	agent.inflightTerminal[terminalReq.UUID] = struct{}{}
-	agent.inflightMu.Unlock()
+	_, done, err := agent.inflight.Start(agent.context, InflightTerminal, terminalReq.UUID, nil)
+	require.NoError(t, err)
+	defer done()
 
 	// Create an event with the same UUID
 	evs := event.NewEventSource("test")
@@ -559,34 +558,22 @@ func TestInflightTerminalTracking(t *testing.T) {
 	terminalReq := createTestTerminalRequest()
 	sessionUUID := terminalReq.UUID
 
-	t.Run("Adding and removing from inflight map", func(t *testing.T) {
+	t.Run("Adding and removing from inflight registry", func(t *testing.T) {
 		// Initially, the session should not be in inflight
-		agent.inflightMu.Lock()
-		_, exists := agent.inflightTerminal[sessionUUID]
-		agent.inflightMu.Unlock()
-		assert.False(t, exists)
+		assert.False(t, agent.inflight.IsInflight(InflightTerminal, sessionUUID))
 
 		// Add to inflight
-		agent.inflightMu.Lock()
-		agent.inflightTerminal[sessionUUID] = struct{}{}
-		agent.inflightMu.Unlock()
+		_, done, err := agent.inflight.Start(agent.context, InflightTerminal, sessionUUID, nil)
+		require.NoError(t, err)
 
 		// Verify it's in inflight
-		agent.inflightMu.Lock()
-		_, exists = agent.inflightTerminal[sessionUUID]
-		agent.inflightMu.Unlock()
-		assert.True(t, exists)
+		assert.True(t, agent.inflight.IsInflight(InflightTerminal, sessionUUID))
 
 		// Remove from inflight
-		agent.inflightMu.Lock()
-		delete(agent.inflightTerminal, sessionUUID)
-		agent.inflightMu.Unlock()
+		done()
 
 		// Verify it's removed
-		agent.inflightMu.Lock()
-		_, exists = agent.inflightTerminal[sessionUUID]
-		agent.inflightMu.Unlock()
-		assert.False(t, exists)
+		assert.False(t, agent.inflight.IsInflight(InflightTerminal, sessionUUID))
 	})
 }
 
@@ -603,19 +590,15 @@ func TestConcurrentTerminalOperations(t *testing.T) {
 			go func(id int) {
 				defer wg.Done()
 				uuid := uuid.New().String()
-				agent.inflightMu.Lock()
-				agent.inflightTerminal[uuid] = struct{}{}
-				agent.inflightMu.Unlock()
+				_, _, err := agent.inflight.Start(agent.context, InflightTerminal, uuid, nil)
+				assert.NoError(t, err)
 			}(i)
 		}
 
 		wg.Wait()
 
 		// Should have all sessions tracked without race conditions
-		agent.inflightMu.Lock()
-		count := len(agent.inflightTerminal)
-		agent.inflightMu.Unlock()
-		assert.Equal(t, numGoroutines, count)
+		assert.Equal(t, numGoroutines, agent.inflight.Len(InflightTerminal))
 	})
 }
 
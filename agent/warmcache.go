@@ -0,0 +1,92 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+)
+
+// warmCacheSaveInterval is the interval at which the agent persists its warm
+// cache
+const warmCacheSaveInterval = time.Minute
+
+// warmCacheNegotiationTimeout is how long a restarted agent waits for the
+// event schema version to be negotiated before it resyncs from its warm
+// cache. If the principal's version is not known by then, a full resync is
+// done instead.
+const warmCacheNegotiationTimeout = 10 * time.Second
+
+// loadWarmCache loads the bookmarks of Applications synced with the principal
+// that the agent persisted before it restarted
+func (a *Agent) loadWarmCache() {
+	if a.options.warmCachePath == "" || a.resyncBookmarks == nil {
+		return
+	}
+	logCtx := log().WithField("path", a.options.warmCachePath)
+	n, err := a.resyncBookmarks.Load(a.options.warmCachePath)
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not load warm cache, doing a full resync with the principal")
+		return
+	}
+	a.warmCacheLoaded = n > 0
+	logCtx.WithField("resources", n).Info("Loaded warm cache")
+}
+
+// saveWarmCache persists the bookmarks of Applications synced with the
+// principal, so that the agent can resync incrementally after a restart
+func (a *Agent) saveWarmCache() {
+	if a.options.warmCachePath == "" || a.resyncBookmarks == nil {
+		return
+	}
+	if err := a.resyncBookmarks.Save(a.options.warmCachePath); err != nil {
+		log().WithError(err).WithField("path", a.options.warmCachePath).Warn("Could not save warm cache")
+	}
+}
+
+// runWarmCachePersistence persists the warm cache periodically until ctx is
+// done
+func (a *Agent) runWarmCachePersistence(ctx context.Context) {
+	ticker := time.NewTicker(warmCacheSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.saveWarmCache()
+		}
+	}
+}
+
+// resyncFromWarmCache resyncs with the principal using the bookmarks loaded
+// from the warm cache, once the event schema version has been negotiated.
+// The principal then only sends the Applications that changed on either side
+// since they were last synced. Principals that do not understand bookmarks
+// get a request update for every Application instead.
+func (a *Agent) resyncFromWarmCache(handler *resync.RequestHandler, negotiated <-chan bool) {
+	ok := false
+	select {
+	case ok = <-negotiated:
+	case <-time.After(warmCacheNegotiationTimeout):
+	case <-a.context.Done():
+		return
+	}
+	handler.WithIncrementalResync(ok && a.eventWriter != nil && a.eventWriter.SchemaVersion() >= event.SchemaVersion12)
+	handler.SendRequestUpdates(a.context)
+}
@@ -0,0 +1,63 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WarmCache(t *testing.T) {
+	key := resources.ResourceKey{Name: "app", Namespace: "argocd", Kind: "Application", UID: "uid"}
+
+	t.Run("Bookmarks are loaded after a restart", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.options.warmCachePath = filepath.Join(t.TempDir(), "warm-cache.json")
+		a.resyncBookmarks = resync.NewBookmarks(time.Hour)
+		a.resyncBookmarks.Record("principal", key, resync.Bookmark{ResourceVersion: "1", SourceResourceVersion: "2"})
+		a.saveWarmCache()
+
+		restarted, _ := newAgent(t)
+		restarted.options.warmCachePath = a.options.warmCachePath
+		restarted.resyncBookmarks = resync.NewBookmarks(time.Hour)
+		restarted.loadWarmCache()
+		assert.True(t, restarted.warmCacheLoaded)
+		bm, ok := restarted.resyncBookmarks.Get("principal", key)
+		assert.True(t, ok)
+		assert.Equal(t, "2", bm.SourceResourceVersion)
+	})
+
+	t.Run("Missing cache is a cold start", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.options.warmCachePath = filepath.Join(t.TempDir(), "warm-cache.json")
+		a.resyncBookmarks = resync.NewBookmarks(time.Hour)
+		a.loadWarmCache()
+		assert.False(t, a.warmCacheLoaded)
+	})
+
+	t.Run("Warm cache is disabled without a path", func(t *testing.T) {
+		a, _ := newAgent(t)
+		a.resyncBookmarks = resync.NewBookmarks(time.Hour)
+		a.resyncBookmarks.Record("principal", key, resync.Bookmark{ResourceVersion: "1"})
+		a.saveWarmCache()
+		a.loadWarmCache()
+		assert.False(t, a.warmCacheLoaded)
+	})
+}
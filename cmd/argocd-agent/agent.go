@@ -22,8 +22,10 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/agent"
@@ -32,12 +34,21 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
 	"github.com/argoproj-labs/argocd-agent/internal/config"
 	"github.com/argoproj-labs/argocd-agent/internal/env"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/internal/faultinject"
 	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
+	"github.com/argoproj-labs/argocd-agent/internal/loki"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
 	"github.com/argoproj-labs/argocd-agent/internal/tracing"
+	"github.com/argoproj-labs/argocd-agent/internal/version"
 	"github.com/argoproj-labs/argocd-agent/pkg/client"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // NewAgentRunCommand returns a new agent run command.
@@ -57,14 +68,18 @@ func NewAgentRunCommand() *cobra.Command {
 		agentMode           string
 		creds               string
 		tlsSecretName       string
+		joinToken           string
 		tlsClientCrt        string
 		tlsClientKey        string
 		tlsMinVersion       string
 		tlsMaxVersion       string
 		tlsCipherSuites     []string
+		tlsCurves           []string
 		enableWebSocket     bool
+		enableQUIC          bool
 		metricsPort         int
 		healthzPort         int
+		readinessGrace      time.Duration
 		enableCompression   bool
 		pprofPort           int
 		redisAddr           string
@@ -73,9 +88,16 @@ func NewAgentRunCommand() *cobra.Command {
 		redisCredsDirPath   string
 		enableResourceProxy bool
 
+		// Cluster-scoped resources the principal may read via the resource proxy
+		clusterResourceAllow []string
+
 		// Time interval for agent to principal ping
 		// Ex: "30m", "1h" or "1h20m10s". Valid time units are "s", "m", "h".
 		keepAlivePingInterval time.Duration
+		keepAliveTimeout      time.Duration
+		addressFamily         string
+		initialWindowSize     int
+		initialConnWindowSize int
 
 		// Time interval for agent to refresh cluster cache info in principal
 		cacheRefreshInterval time.Duration
@@ -84,8 +106,27 @@ func NewAgentRunCommand() *cobra.Command {
 		// This is used to keep the connection alive through service meshes like Istio.
 		heartbeatInterval time.Duration
 
+		// Retry settings for interrupted log streams
+		logStreamRetryInitialInterval time.Duration
+		logStreamRetryMaxInterval     time.Duration
+		logStreamRetryMaxElapsedTime  time.Duration
+		logStreamReconnectWait        time.Duration
+		logStreamChunkSize            int
+		logStreamMultiplexing         bool
+		logStreamConcurrency          int
+		logStreamQueueTimeout         time.Duration
+
+		// Limits for long-running operations
+		maxLogStreams   int
+		maxTerminals    int
+		maxLogStreamAge time.Duration
+		maxTerminalAge  time.Duration
+
 		maxGRPCMessageSize int
 
+		// Name of the ConfigMap to reload configuration from at runtime
+		configReloadConfigMap string
+
 		// OpenTelemetry configuration
 		otlpAddress  string
 		otlpInsecure bool
@@ -100,6 +141,89 @@ func NewAgentRunCommand() *cobra.Command {
 
 		labelSelector string
 
+		// Addresses of plugin processes
+		pluginAddresses []string
+
+		// Fault injection into the connection to the principal, for
+		// development builds only
+		faultInjection string
+
+		// Recording of the event stream
+		recordEvents          string
+		recordEventsRedaction string
+
+		// Loki backend for logs of deleted pods
+		lokiAddress  string
+		lokiTenant   string
+		lokiLookback time.Duration
+
+		// Impersonation for requests proxied on behalf of the principal
+		proxyImpersonateUser   string
+		proxyImpersonateGroups []string
+
+		// Check of the agent's Kubernetes permissions
+		permissionCheck         bool
+		permissionCheckInterval time.Duration
+
+		// Resource statuses to send to the principal, in the format of
+		// Argo CD's resource.inclusions and resource.exclusions
+		resourceInclusions string
+		resourceExclusions string
+
+		// Namespaces and pods whose logs the principal may read
+		logAccessAllow []string
+		logAccessDeny  []string
+
+		// Translation of namespaces between principal and agent
+		namespaceMapping []string
+
+		// Differential status reporting
+		differentialStatus   bool
+		statusResyncInterval time.Duration
+
+		// Interval of state checksum reports to detect divergence
+		stateChecksumInterval time.Duration
+		slowHandlerThreshold  time.Duration
+		eventWorkers          int
+		memoryBudget          int
+
+		// Leader election between agent replicas
+		leaderElection      bool
+		leaderElectionLease string
+		leaseDuration       time.Duration
+		leaseRenewDeadline  time.Duration
+		leaseRetryPeriod    time.Duration
+
+		// Updates of the agent's Deployment requested by the principal
+		selfUpdateKeyPath    string
+		selfUpdateDeployment string
+		selfUpdateContainer  string
+		selfUpdateScopes     []string
+
+		// Verification of images in manifests the agent applies
+		imagePolicyKey    string
+		imagePolicyCosign string
+		imagePolicyMode   string
+		imagePolicyLocal  bool
+
+		// Evaluation of the specs of Applications received from the principal
+		specPolicyOPAURL  string
+		specPolicyOPAPath string
+		specPolicyMode    string
+
+		// Service accounts of Applications for resource proxy writes
+		appServiceAccountsClusterRole string
+		appServiceAccountsPrefix      string
+
+		// Resolution of conflicting changes to Applications
+		conflictStrategy string
+		serverSideApply  bool
+
+		// Pagination of the initial sync with the principal
+		resyncBatchSize      int
+		resyncBookmarkMaxAge time.Duration
+		warmCachePath        string
+
 		// Redis TLS configuration
 		redisTLSEnabled      bool
 		redisTLSCAPath       string
@@ -208,6 +332,9 @@ func NewAgentRunCommand() *cobra.Command {
 					remoteOpts = append(remoteOpts, client.WithTLSClientCertFromFile(tlsClientCrt, tlsClientKey))
 				} else if (tlsClientCrt != "" && tlsClientKey == "") || (tlsClientCrt == "" && tlsClientKey != "") {
 					cmdutil.Fatal("Both --tls-client-cert and --tls-client-key have to be given")
+				} else if joinToken != "" && !secretExists(ctx, kubeConfig.Clientset, namespace, tlsSecretName) {
+					logrus.Infof("No client TLS certificate in secret %s/%s, will join the principal using a join token", namespace, tlsSecretName)
+					remoteOpts = append(remoteOpts, client.WithJoinToken(joinToken, kubeConfig.Clientset, namespace, tlsSecretName))
 				} else {
 					logrus.Infof("Loading client TLS certificate from secret %s/%s", namespace, tlsSecretName)
 					remoteOpts = append(remoteOpts, client.WithTLSClientCertFromSecret(kubeConfig.Clientset, namespace, tlsSecretName))
@@ -227,13 +354,43 @@ func NewAgentRunCommand() *cobra.Command {
 			if len(tlsCipherSuites) > 0 && (len(tlsCipherSuites) != 1 || tlsCipherSuites[0] != "") {
 				remoteOpts = append(remoteOpts, client.WithTLSCipherSuites(tlsCipherSuites))
 			}
+			if len(tlsCurves) == 1 && tlsCurves[0] == "list" {
+				cmdutil.PrintAvailableCurves()
+				return
+			}
+			if len(tlsCurves) > 0 {
+				remoteOpts = append(remoteOpts, client.WithTLSCurvePreferences(tlsCurves))
+			}
 
 			remoteOpts = append(remoteOpts, client.WithWebSocket(enableWebSocket))
+			remoteOpts = append(remoteOpts, client.WithQUIC(enableQUIC))
 			remoteOpts = append(remoteOpts, client.WithClientMode(types.AgentModeFromString(agentMode)))
 			remoteOpts = append(remoteOpts, client.WithKeepAlivePingInterval(keepAlivePingInterval))
+			remoteOpts = append(remoteOpts, client.WithKeepAliveTimeout(keepAliveTimeout))
+			remoteOpts = append(remoteOpts, client.WithAddressFamily(grpcutil.AddressFamily(addressFamily)))
+			remoteOpts = append(remoteOpts, client.WithInitialWindowSize(initialWindowSize))
+			remoteOpts = append(remoteOpts, client.WithInitialConnWindowSize(initialConnWindowSize))
 			remoteOpts = append(remoteOpts, client.WithCompression(enableCompression))
 			remoteOpts = append(remoteOpts, client.WithMaxGRPCMessageSize(maxGRPCMessageSize))
 
+			if faultInjection != "" {
+				if !version.IsDevelopmentBuild() {
+					cmdutil.Fatal("Fault injection is only available in development builds")
+				}
+				cfg, err := faultinject.ParseConfig(faultInjection)
+				if err != nil {
+					cmdutil.Fatal("Invalid fault injection configuration: %v", err)
+				}
+				injector, err := faultinject.NewInjector(cfg)
+				if err != nil {
+					cmdutil.Fatal("Invalid fault injection configuration: %v", err)
+				}
+				logrus.Warnf("DANGER: Injecting faults into the connection to the principal (%s)", faultInjection)
+				remoteOpts = append(remoteOpts,
+					client.WithUnaryInterceptors(injector.UnaryClientInterceptor()),
+					client.WithStreamInterceptors(injector.StreamClientInterceptor()))
+			}
+
 			if serverAddress != "" && serverPort > 0 && serverPort < 65536 {
 				remote, err = client.NewRemote(serverAddress, serverPort, remoteOpts...)
 				if err != nil {
@@ -247,6 +404,7 @@ func NewAgentRunCommand() *cobra.Command {
 			agentOpts = append(agentOpts, agent.WithRemote(remote))
 			agentOpts = append(agentOpts, agent.WithMode(agentMode))
 			agentOpts = append(agentOpts, agent.WithHealthzPort(healthzPort))
+			agentOpts = append(agentOpts, agent.WithReadinessGracePeriod(readinessGrace))
 
 			agentOpts = append(agentOpts, agent.WithRedisHost(redisAddr))
 
@@ -292,17 +450,154 @@ func NewAgentRunCommand() *cobra.Command {
 			}
 
 			agentOpts = append(agentOpts, agent.WithEnableResourceProxy(enableResourceProxy))
+			if len(clusterResourceAllow) > 0 {
+				agentOpts = append(agentOpts, agent.WithClusterResourceAllowRules(clusterResourceAllow))
+			}
 			agentOpts = append(agentOpts, agent.WithCacheRefreshInterval(cacheRefreshInterval))
 			agentOpts = append(agentOpts, agent.WithHeartbeatInterval(heartbeatInterval))
+			logStreamBackoff := agent.DefaultLogStreamBackoff()
+			logStreamBackoff.InitialInterval = logStreamRetryInitialInterval
+			logStreamBackoff.MaxInterval = logStreamRetryMaxInterval
+			logStreamBackoff.MaxElapsedTime = logStreamRetryMaxElapsedTime
+			logStreamBackoff.ReconnectWait = logStreamReconnectWait
+			agentOpts = append(agentOpts, agent.WithLogStreamBackoff(logStreamBackoff))
+			agentOpts = append(agentOpts, agent.WithLogStreamChunkSize(logStreamChunkSize))
+			agentOpts = append(agentOpts, agent.WithLogStreamMultiplexing(logStreamMultiplexing))
+			agentOpts = append(agentOpts, agent.WithLogStreamConcurrency(logStreamConcurrency, logStreamQueueTimeout))
+			agentOpts = append(agentOpts, agent.WithInflightLimit(agent.InflightLogs, maxLogStreams))
+			agentOpts = append(agentOpts, agent.WithInflightLimit(agent.InflightTerminal, maxTerminals))
+			agentOpts = append(agentOpts, agent.WithInflightMaxAge(agent.InflightLogs, maxLogStreamAge))
+			agentOpts = append(agentOpts, agent.WithInflightMaxAge(agent.InflightTerminal, maxTerminalAge))
 			agentOpts = append(agentOpts, agent.WithCreateNamespace(createNamespace))
 			agentOpts = append(agentOpts, agent.WithDestinationBasedMapping(destinationBasedMapping))
 			agentOpts = append(agentOpts, agent.WithIgnoreUnmanagedApps(ignoreUnmanagedApps))
 			agentOpts = append(agentOpts, agent.WithAllowedNamespaces(allowedNamespaces...))
 			agentOpts = append(agentOpts, agent.WithLabelSelector(labelSelector))
+			if lokiAddress != "" {
+				src, err := loki.NewLogSource(lokiAddress, loki.WithTenant(lokiTenant), loki.WithLookback(lokiLookback))
+				if err != nil {
+					cmdutil.Fatal("Invalid Loki configuration: %v", err)
+				}
+				agentOpts = append(agentOpts, agent.WithHistoricalLogSource(src))
+			}
+			if otlpAddress != "" {
+				agentOpts = append(agentOpts, agent.WithEgressEndpoint("otlp", otlpAddress))
+			}
+			if lokiAddress != "" {
+				agentOpts = append(agentOpts, agent.WithEgressEndpoint("loki", lokiAddress))
+			}
+			if permissionCheck {
+				agentOpts = append(agentOpts, agent.WithPermissionCheck(permissionCheckInterval))
+			}
+			if resourceInclusions != "" || resourceExclusions != "" {
+				inclusions, err := agent.ParseResourceFilterRules(resourceInclusions)
+				if err != nil {
+					cmdutil.Fatal("Invalid --resource-inclusions: %v", err)
+				}
+				exclusions, err := agent.ParseResourceFilterRules(resourceExclusions)
+				if err != nil {
+					cmdutil.Fatal("Invalid --resource-exclusions: %v", err)
+				}
+				agentOpts = append(agentOpts, agent.WithResourceFilter(inclusions, exclusions))
+			}
+			if len(logAccessAllow) > 0 || len(logAccessDeny) > 0 {
+				agentOpts = append(agentOpts, agent.WithLogAccessRules(logAccessAllow, logAccessDeny))
+			}
+			if len(namespaceMapping) > 0 {
+				agentOpts = append(agentOpts, agent.WithNamespaceMapping(namespaceMapping))
+			}
+			if differentialStatus {
+				agentOpts = append(agentOpts, agent.WithDifferentialStatus(statusResyncInterval))
+			}
+			agentOpts = append(agentOpts, agent.WithStateChecksumInterval(stateChecksumInterval))
+			agentOpts = append(agentOpts, agent.WithSlowEventHandlerThreshold(slowHandlerThreshold))
+			agentOpts = append(agentOpts, agent.WithEventWorkers(eventWorkers))
+			agentOpts = append(agentOpts, agent.WithMemoryBudget(int64(memoryBudget)))
+			if leaderElection {
+				identity, err := os.Hostname()
+				if err != nil {
+					cmdutil.Fatal("Could not determine identity for leader election: %v", err)
+				}
+				agentOpts = append(agentOpts, agent.WithLeaderElection(agent.LeaderElection{
+					LeaseName:     leaderElectionLease,
+					Identity:      identity,
+					LeaseDuration: leaseDuration,
+					RenewDeadline: leaseRenewDeadline,
+					RetryPeriod:   leaseRetryPeriod,
+				}))
+			}
+			if selfUpdateKeyPath != "" {
+				key, err := agent.LoadSelfUpdateKey(selfUpdateKeyPath)
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				agentOpts = append(agentOpts, agent.WithSelfUpdate(agent.SelfUpdate{
+					Deployment: selfUpdateDeployment,
+					Container:  selfUpdateContainer,
+					PublicKey:  key,
+					Scopes:     selfUpdateScopes,
+				}))
+			}
+			if imagePolicyKey != "" {
+				if imagePolicyMode != "enforce" && imagePolicyMode != "warn" {
+					cmdutil.Fatal("Invalid image policy mode %q: must be enforce or warn", imagePolicyMode)
+				}
+				verifier, err := agent.NewCosignVerifier(imagePolicyCosign, imagePolicyKey)
+				if err != nil {
+					cmdutil.Fatal("Could not set up image policy: %v", err)
+				}
+				agentOpts = append(agentOpts, agent.WithImagePolicy(agent.ImagePolicy{
+					Verifier:           verifier,
+					Enforce:            imagePolicyMode == "enforce",
+					LocalManifestsOnly: imagePolicyLocal,
+				}))
+			}
+			if specPolicyOPAURL != "" {
+				if specPolicyMode != "enforce" && specPolicyMode != "warn" {
+					cmdutil.Fatal("Invalid spec policy mode %q: must be enforce or warn", specPolicyMode)
+				}
+				agentOpts = append(agentOpts, agent.WithSpecPolicy(agent.SpecPolicy{
+					Evaluator: &agent.OPAEvaluator{URL: specPolicyOPAURL, Path: specPolicyOPAPath},
+					Enforce:   specPolicyMode == "enforce",
+				}))
+			}
+			if appServiceAccountsClusterRole != "" {
+				agentOpts = append(agentOpts, agent.WithAppServiceAccounts(agent.AppServiceAccounts{
+					ClusterRole: appServiceAccountsClusterRole,
+					Prefix:      appServiceAccountsPrefix,
+				}))
+			}
+			agentOpts = append(agentOpts, agent.WithConflictStrategy(conflictStrategy))
+			agentOpts = append(agentOpts, agent.WithServerSideApply(serverSideApply))
+			agentOpts = append(agentOpts, agent.WithResyncBatchSize(resyncBatchSize))
+			agentOpts = append(agentOpts, agent.WithResyncBookmarkMaxAge(resyncBookmarkMaxAge))
+			agentOpts = append(agentOpts, agent.WithWarmCachePath(warmCachePath))
+			if proxyImpersonateUser != "" {
+				agentOpts = append(agentOpts, agent.WithProxyImpersonation(proxyImpersonateUser, proxyImpersonateGroups...))
+			}
+			if len(pluginAddresses) > 0 {
+				agentOpts = append(agentOpts, agent.WithPluginAddresses(pluginAddresses...))
+			}
+			if configReloadConfigMap != "" {
+				agentOpts = append(agentOpts, agent.WithConfigReload(configReloadConfigMap))
+			}
 
 			if metricsPort > 0 {
 				agentOpts = append(agentOpts, agent.WithMetricsPort(metricsPort))
 			}
+			if recordEvents != "" {
+				redaction, err := eventrecord.ParseRedaction(recordEventsRedaction)
+				if err != nil {
+					cmdutil.Fatal("Invalid --record-events-redaction: %v", err)
+				}
+				recorder, err := eventrecord.NewFileRecorder(recordEvents, eventrecord.WithRedaction(redaction))
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				defer recorder.Close()
+				logrus.Warnf("Recording all events exchanged with the principal to %s (redaction: %s)", recordEvents, redaction)
+				agentOpts = append(agentOpts, agent.WithEventRecorder(recorder))
+			}
 
 			ag, err := agent.NewAgent(ctx, kubeConfig, namespace, agentOpts...)
 			if err != nil {
@@ -311,13 +606,28 @@ func NewAgentRunCommand() *cobra.Command {
 			if err := ag.Start(ctx); err != nil {
 				cmdutil.Fatal("Could not start agent: %v", err)
 			}
-			<-ctx.Done()
+
+			// Hand over the lease when we are asked to terminate, so that
+			// another replica takes over without waiting for it to expire.
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+			select {
+			case sig := <-sigCh:
+				logrus.Infof("Received signal %v, shutting down", sig)
+				ag.ReleaseLeadership()
+			case <-ag.LostLeadership():
+				cmdutil.Fatal("Lost leadership to another replica, exiting")
+			case <-ctx.Done():
+			}
 		},
 	}
 
 	command.Flags().StringVar(&serverAddress, "server-address",
 		env.StringWithDefault("ARGOCD_AGENT_REMOTE_SERVER", nil, ""),
 		"Address of the server to connect to")
+	command.Flags().StringVar(&addressFamily, "address-family",
+		env.StringWithDefault("ARGOCD_AGENT_ADDRESS_FAMILY", nil, string(grpcutil.AddressFamilyDual)),
+		"IP address families to connect to the principal with, one of dual, tcp4 or tcp6. dual races IPv6 and IPv4 addresses (happy eyeballs)")
 	command.Flags().IntVar(&serverPort, "server-port",
 		env.NumWithDefault("ARGOCD_AGENT_REMOTE_PORT", nil, 443),
 		"Port on the server to connect to")
@@ -380,6 +690,9 @@ func NewAgentRunCommand() *cobra.Command {
 	command.Flags().StringVar(&tlsSecretName, "tls-secret-name",
 		env.StringWithDefault("ARGOCD_AGENT_TLS_SECRET_NAME", nil, config.SecretNameAgentClientCert),
 		"Name of the secret containing the TLS certificate")
+	command.Flags().StringVar(&joinToken, "join-token",
+		env.StringWithDefault("ARGOCD_AGENT_JOIN_TOKEN", nil, ""),
+		"One-time token to obtain a client certificate from the principal, if the TLS secret does not exist yet")
 	command.Flags().StringVar(&tlsClientCrt, "tls-client-cert",
 		env.StringWithDefault("ARGOCD_AGENT_TLS_CLIENT_CERT_PATH", nil, ""),
 		"Path to TLS client certificate")
@@ -396,19 +709,37 @@ func NewAgentRunCommand() *cobra.Command {
 	command.Flags().StringSliceVar(&tlsCipherSuites, "tls-ciphersuites",
 		env.StringSliceWithDefault("ARGOCD_AGENT_TLS_CIPHERSUITES", nil, []string{}),
 		"Comma-separated list of TLS cipher suites to use. Use 'list' to show available cipher suites and exit")
+	command.Flags().StringSliceVar(&tlsCurves, "tls-curves",
+		env.StringSliceWithDefault("ARGOCD_AGENT_TLS_CURVES", nil, []string{}),
+		"Comma-separated list of TLS key exchange curves to offer, in order of preference. Use 'list' to show available curves and exit")
 
 	command.Flags().BoolVar(&enableWebSocket, "enable-websocket",
 		env.BoolWithDefault("ARGOCD_AGENT_ENABLE_WEBSOCKET", false),
 		"Agent will rely on gRPC over WebSocket to stream events to the Principal")
+	command.Flags().BoolVar(&enableQUIC, "enable-quic",
+		env.BoolWithDefault("ARGOCD_AGENT_ENABLE_QUIC", false),
+		"(Experimental) Connect to the Principal over QUIC, falling back to TCP if QUIC is not reachable")
 	command.Flags().IntVar(&metricsPort, "metrics-port",
 		env.NumWithDefault("ARGOCD_AGENT_METRICS_PORT", cmdutil.ValidPort, 8181),
 		"Port the metrics server will listen on")
 	command.Flags().IntVar(&healthzPort, "healthz-port",
 		env.NumWithDefault("ARGOCD_AGENT_HEALTH_CHECK_PORT", cmdutil.ValidPort, 8001),
 		"Port the health check server will listen on")
+	command.Flags().DurationVar(&readinessGrace, "readiness-grace-period",
+		env.DurationWithDefault("ARGOCD_AGENT_READINESS_GRACE_PERIOD", nil, time.Minute),
+		"Time the agent is still reported as ready after it lost the connection to the principal")
 	command.Flags().DurationVar(&keepAlivePingInterval, "keep-alive-ping-interval",
 		env.DurationWithDefault("ARGOCD_AGENT_KEEP_ALIVE_PING_INTERVAL", nil, 0),
 		"Ping interval to keep connection alive with Principal")
+	command.Flags().DurationVar(&keepAliveTimeout, "keep-alive-timeout",
+		env.DurationWithDefault("ARGOCD_AGENT_KEEP_ALIVE_TIMEOUT", nil, 0),
+		"Time to wait for the principal to answer a keepalive ping before the connection is closed (0 uses gRPC's default of 20s)")
+	command.Flags().IntVar(&initialWindowSize, "grpc-initial-window-size",
+		env.NumWithDefault("ARGOCD_AGENT_GRPC_INITIAL_WINDOW_SIZE", nil, 0),
+		"Initial HTTP/2 flow control window of each gRPC stream in bytes, at least 65536 (0 uses gRPC's dynamic window)")
+	command.Flags().IntVar(&initialConnWindowSize, "grpc-initial-conn-window-size",
+		env.NumWithDefault("ARGOCD_AGENT_GRPC_INITIAL_CONN_WINDOW_SIZE", nil, 0),
+		"Initial HTTP/2 flow control window of the gRPC connection in bytes, at least 65536 (0 uses gRPC's dynamic window)")
 	command.Flags().BoolVar(&enableCompression, "enable-compression",
 		env.BoolWithDefault("ARGOCD_AGENT_ENABLE_COMPRESSION", false),
 		"Use compression while sending data between Principal and Agent using gRPC")
@@ -418,6 +749,9 @@ func NewAgentRunCommand() *cobra.Command {
 	command.Flags().BoolVar(&enableResourceProxy, "enable-resource-proxy",
 		env.BoolWithDefault("ARGOCD_AGENT_ENABLE_RESOURCE_PROXY", true),
 		"Enable resource proxy")
+	command.Flags().StringSliceVar(&clusterResourceAllow, "cluster-resource-allow",
+		env.StringSliceWithDefault("ARGOCD_AGENT_CLUSTER_RESOURCE_ALLOW", nil, []string{}),
+		"Cluster-scoped resources the principal may read through the resource proxy, as <resource> or <group>/<resource>, e.g. nodes")
 	command.Flags().DurationVar(&cacheRefreshInterval, "cache-refresh-interval",
 		env.DurationWithDefault("ARGOCD_AGENT_CACHE_REFRESH_INTERVAL", nil, 10*time.Second),
 		"Interval to refresh cluster cache info in principal")
@@ -425,6 +759,49 @@ func NewAgentRunCommand() *cobra.Command {
 		env.DurationWithDefault("ARGOCD_AGENT_HEARTBEAT_INTERVAL", nil, 0),
 		"Interval for application-level heartbeats over the Subscribe stream (e.g., 30s). "+
 			"Set to 0 to disable. Useful to keep connections alive through service meshes like Istio.")
+	command.Flags().DurationVar(&logStreamRetryInitialInterval, "log-stream-retry-initial-interval",
+		env.DurationWithDefault("ARGOCD_AGENT_LOG_STREAM_RETRY_INITIAL_INTERVAL", nil, agent.DefaultLogStreamBackoff().InitialInterval),
+		"Delay before the first retry of an interrupted log stream")
+	command.Flags().DurationVar(&logStreamRetryMaxInterval, "log-stream-retry-max-interval",
+		env.DurationWithDefault("ARGOCD_AGENT_LOG_STREAM_RETRY_MAX_INTERVAL", nil, agent.DefaultLogStreamBackoff().MaxInterval),
+		"Maximum delay between retries of an interrupted log stream")
+	command.Flags().DurationVar(&logStreamRetryMaxElapsedTime, "log-stream-retry-max-elapsed-time",
+		env.DurationWithDefault("ARGOCD_AGENT_LOG_STREAM_RETRY_MAX_ELAPSED_TIME", nil, agent.DefaultLogStreamBackoff().MaxElapsedTime),
+		"Time after which retrying an interrupted log stream is given up. "+
+			"Set to 0 to retry for as long as the client is still waiting for logs.")
+	command.Flags().DurationVar(&logStreamReconnectWait, "log-stream-reconnect-wait",
+		env.DurationWithDefault("ARGOCD_AGENT_LOG_STREAM_RECONNECT_WAIT", nil, agent.DefaultLogStreamBackoff().ReconnectWait),
+		"Time to wait for the connection to the principal to recover after an authentication failure of a log stream. "+
+			"Set to 0 to wait indefinitely.")
+	command.Flags().IntVar(&logStreamChunkSize, "log-stream-chunk-size",
+		env.NumWithDefault("ARGOCD_AGENT_LOG_STREAM_CHUNK_SIZE", nil, 64*1024),
+		"Size in bytes of the chunks in which container logs are streamed to the principal.")
+	command.Flags().BoolVar(&logStreamMultiplexing, "log-stream-multiplexing",
+		env.BoolWithDefault("ARGOCD_AGENT_LOG_STREAM_MULTIPLEXING", false),
+		"Send log streams over the event stream connection instead of opening a gRPC stream for each. Requires a principal supporting it.")
+	command.Flags().IntVar(&logStreamConcurrency, "log-stream-concurrency",
+		env.NumWithDefault("ARGOCD_AGENT_LOG_STREAM_CONCURRENCY", nil, 0),
+		"Maximum number of log streams open on the connection to the principal at the same time. Further log streams wait for a free one. Set to 0 for no limit.")
+	command.Flags().DurationVar(&logStreamQueueTimeout, "log-stream-queue-timeout",
+		env.DurationWithDefault("ARGOCD_AGENT_LOG_STREAM_QUEUE_TIMEOUT", nil, agent.DefaultLogStreamQueueTimeout),
+		"How long a log stream waits for a free log stream when --log-stream-concurrency is reached, before it fails.")
+	command.Flags().IntVar(&maxLogStreams, "max-log-streams",
+		env.NumWithDefault("ARGOCD_AGENT_MAX_LOG_STREAMS", nil, 0),
+		"Maximum number of concurrent log streams. Set to 0 for no limit.")
+	command.Flags().IntVar(&maxTerminals, "max-terminal-sessions",
+		env.NumWithDefault("ARGOCD_AGENT_MAX_TERMINAL_SESSIONS", nil, 0),
+		"Maximum number of concurrent web terminal sessions. Set to 0 for no limit.")
+	command.Flags().DurationVar(&maxLogStreamAge, "max-log-stream-age",
+		env.DurationWithDefault("ARGOCD_AGENT_MAX_LOG_STREAM_AGE", nil, 0),
+		"Maximum duration of a single log stream, after which it is terminated. Set to 0 for no limit.")
+	command.Flags().DurationVar(&maxTerminalAge, "max-terminal-session-age",
+		env.DurationWithDefault("ARGOCD_AGENT_MAX_TERMINAL_SESSION_AGE", nil, 0),
+		"Maximum duration of a single web terminal session, after which it is terminated. Set to 0 for no limit.")
+
+	command.Flags().StringVar(&configReloadConfigMap, "config-reload-configmap",
+		env.StringWithDefault("ARGOCD_AGENT_CONFIG_RELOAD_CONFIGMAP", nil, ""),
+		"Name of a ConfigMap in the agent's namespace to watch for changes to the log level, principal address, "+
+			"log stream chunk size and operation limits, which are then applied without a restart. Empty to disable.")
 
 	command.Flags().IntVar(&maxGRPCMessageSize, "grpc-max-message-size",
 		env.NumWithDefault("ARGOCD_AGENT_GRPC_MAX_MESSAGE_SIZE", nil, grpcutil.DefaultGRPCMaxMessageSize),
@@ -453,12 +830,157 @@ func NewAgentRunCommand() *cobra.Command {
 	command.Flags().StringVar(&labelSelector, "label-selector",
 		env.StringWithDefault("ARGOCD_AGENT_LABEL_SELECTOR", nil, ""),
 		"Kubernetes label selector to restrict which resources the agent watches")
+	command.Flags().StringVar(&lokiAddress, "loki-address",
+		env.StringWithDefault("ARGOCD_AGENT_LOKI_ADDRESS", nil, ""),
+		"Address of a Loki server to read the logs of deleted pods from, e.g. http://loki-gateway.monitoring. Empty to disable")
+	command.Flags().StringVar(&lokiTenant, "loki-tenant",
+		env.StringWithDefault("ARGOCD_AGENT_LOKI_TENANT", nil, ""),
+		"Tenant to read logs of from a multi-tenant Loki server")
+	command.Flags().DurationVar(&lokiLookback, "loki-lookback",
+		env.DurationWithDefault("ARGOCD_AGENT_LOKI_LOOKBACK", nil, loki.DefaultLookback),
+		"How far back to search Loki for logs of deleted pods, unless the request specifies a start time")
+	command.Flags().StringVar(&proxyImpersonateUser, "proxy-impersonate-user",
+		env.StringWithDefault("ARGOCD_AGENT_PROXY_IMPERSONATE_USER", nil, ""),
+		"User to impersonate for resource, log and terminal requests proxied from the principal, e.g. system:serviceaccount:argocd:argocd-agent-proxy. Empty to use the agent's own credentials")
+	command.Flags().StringSliceVar(&proxyImpersonateGroups, "proxy-impersonate-groups",
+		env.StringSliceWithDefault("ARGOCD_AGENT_PROXY_IMPERSONATE_GROUPS", nil, []string{}),
+		"Groups to impersonate along with --proxy-impersonate-user")
+	command.Flags().BoolVar(&permissionCheck, "permission-check",
+		env.BoolWithDefault("ARGOCD_AGENT_PERMISSION_CHECK", true),
+		"Check on startup that the agent has the Kubernetes permissions needed for its enabled features, and report missing and excess permissions to the principal")
+	command.Flags().DurationVar(&permissionCheckInterval, "permission-check-interval",
+		env.DurationWithDefault("ARGOCD_AGENT_PERMISSION_CHECK_INTERVAL", nil, time.Hour),
+		"Interval in which to repeat the permission check. 0 checks on startup only")
+	command.Flags().StringVar(&resourceInclusions, "resource-inclusions",
+		env.StringWithDefault("ARGOCD_AGENT_RESOURCE_INCLUSIONS", nil, ""),
+		"Resources whose status to send to the principal, as YAML in the format of Argo CD's resource.inclusions")
+	command.Flags().StringVar(&resourceExclusions, "resource-exclusions",
+		env.StringWithDefault("ARGOCD_AGENT_RESOURCE_EXCLUSIONS", nil, ""),
+		"Resources whose status not to send to the principal, as YAML in the format of Argo CD's resource.exclusions")
+	command.Flags().StringSliceVar(&logAccessAllow, "log-access-allow",
+		env.StringSliceWithDefault("ARGOCD_AGENT_LOG_ACCESS_ALLOW", nil, []string{}),
+		"Glob patterns of namespaces, or of pods as <namespace>/<pod>, whose logs the principal may read. Empty to allow all")
+	command.Flags().StringSliceVar(&logAccessDeny, "log-access-deny",
+		env.StringSliceWithDefault("ARGOCD_AGENT_LOG_ACCESS_DENY", nil, []string{}),
+		"Glob patterns of namespaces, or of pods as <namespace>/<pod>, whose logs the principal may not read. Takes precedence over --log-access-allow")
+	command.Flags().StringSliceVar(&namespaceMapping, "namespace-mapping",
+		env.StringSliceWithDefault("ARGOCD_AGENT_NAMESPACE_MAPPING", nil, []string{}),
+		"Rules to translate namespaces on the principal to namespaces on the agent's cluster, as <principal>=<agent>, e.g. team-a-*=*. The first matching rule wins")
+	command.Flags().BoolVar(&differentialStatus, "differential-status",
+		env.BoolWithDefault("ARGOCD_AGENT_DIFFERENTIAL_STATUS", false),
+		"Only send the status of an application to the principal when it changed in more than timestamps")
+	command.Flags().DurationVar(&statusResyncInterval, "status-resync-interval",
+		env.DurationWithDefault("ARGOCD_AGENT_STATUS_RESYNC_INTERVAL", nil, 10*time.Minute),
+		"Interval in which to send the status of all applications with --differential-status. 0 disables the resync")
+	command.Flags().DurationVar(&stateChecksumInterval, "state-checksum-interval",
+		env.DurationWithDefault("ARGOCD_AGENT_STATE_CHECKSUM_INTERVAL", nil, 10*time.Minute),
+		"Interval in which to send checksums of the state of all applications to the principal, which resyncs applications that diverged. 0 disables it")
+	command.Flags().DurationVar(&slowHandlerThreshold, "slow-event-handler-threshold",
+		env.DurationWithDefault("ARGOCD_AGENT_SLOW_EVENT_HANDLER_THRESHOLD", nil, time.Second),
+		"Time after which the handler of an event received from the principal is logged as slow (0 disables it)")
+	command.Flags().IntVar(&eventWorkers, "event-workers",
+		env.NumWithDefault("ARGOCD_AGENT_EVENT_WORKERS", nil, 1),
+		"Number of workers processing events received from the principal. Events of the same resource are processed in order; 1 processes all events one after the other")
+	command.Flags().IntVar(&memoryBudget, "memory-budget",
+		env.NumWithDefault("ARGOCD_AGENT_MEMORY_BUDGET", nil, 0),
+		"Number of bytes log data in transit and events queued for the principal may use before log reads are paused and superseded status updates are dropped (0 disables it)")
+	leDefaults := agent.DefaultLeaderElection()
+	command.Flags().BoolVar(&leaderElection, "leader-election",
+		env.BoolWithDefault("ARGOCD_AGENT_LEADER_ELECTION", false),
+		"Elect a leader between replicas of the agent. Only the leader connects to the principal, the others stand by")
+	command.Flags().StringVar(&leaderElectionLease, "leader-election-lease-name",
+		env.StringWithDefault("ARGOCD_AGENT_LEADER_ELECTION_LEASE_NAME", nil, leDefaults.LeaseName),
+		"Name of the Lease used for leader election")
+	command.Flags().DurationVar(&leaseDuration, "leader-election-lease-duration",
+		env.DurationWithDefault("ARGOCD_AGENT_LEADER_ELECTION_LEASE_DURATION", nil, leDefaults.LeaseDuration),
+		"Time standby replicas wait before taking over a lease that was not renewed")
+	command.Flags().DurationVar(&leaseRenewDeadline, "leader-election-renew-deadline",
+		env.DurationWithDefault("ARGOCD_AGENT_LEADER_ELECTION_RENEW_DEADLINE", nil, leDefaults.RenewDeadline),
+		"Time the leader tries to renew its lease before giving up leadership")
+	command.Flags().DurationVar(&leaseRetryPeriod, "leader-election-retry-period",
+		env.DurationWithDefault("ARGOCD_AGENT_LEADER_ELECTION_RETRY_PERIOD", nil, leDefaults.RetryPeriod),
+		"Interval in which replicas try to acquire or renew the lease")
+	command.Flags().StringVar(&selfUpdateKeyPath, "self-update-key-path",
+		env.StringWithDefault("ARGOCD_AGENT_SELF_UPDATE_KEY_PATH", nil, ""),
+		"Path to the PEM encoded public key verifying the update payloads the principal forwards to the agent. Self-update is disabled if empty")
+	command.Flags().StringVar(&selfUpdateDeployment, "self-update-deployment",
+		env.StringWithDefault("ARGOCD_AGENT_SELF_UPDATE_DEPLOYMENT", nil, "argocd-agent-agent"),
+		"Name of the agent's Deployment updated on request of the principal")
+	command.Flags().StringVar(&selfUpdateContainer, "self-update-container",
+		env.StringWithDefault("ARGOCD_AGENT_SELF_UPDATE_CONTAINER", nil, "argocd-agent-agent"),
+		"Name of the agent's container in its Deployment")
+	command.Flags().StringSliceVar(&selfUpdateScopes, "self-update-scopes",
+		env.StringSliceWithDefault("ARGOCD_AGENT_SELF_UPDATE_SCOPES", nil, []string{}),
+		"Agent and fleet names the agent accepts update payloads for. Defaults to the agent's name if empty")
+	command.Flags().StringVar(&imagePolicyKey, "image-policy-key",
+		env.StringWithDefault("ARGOCD_AGENT_IMAGE_POLICY_KEY", nil, ""),
+		"Public key, or cosign key reference, verifying the signatures of images in manifests the agent applies. Image verification is disabled if empty")
+	command.Flags().StringVar(&imagePolicyCosign, "image-policy-cosign",
+		env.StringWithDefault("ARGOCD_AGENT_IMAGE_POLICY_COSIGN", nil, ""),
+		"Path of the cosign binary verifying image signatures. If empty, cosign is looked up in PATH")
+	command.Flags().StringVar(&imagePolicyMode, "image-policy-mode",
+		env.StringWithDefault("ARGOCD_AGENT_IMAGE_POLICY_MODE", nil, "enforce"),
+		"What to do with manifests whose images fail verification: enforce refuses them, warn only logs the violation")
+	command.Flags().BoolVar(&imagePolicyLocal, "image-policy-local-manifests-only",
+		env.BoolWithDefault("ARGOCD_AGENT_IMAGE_POLICY_LOCAL_MANIFESTS_ONLY", false),
+		"Accept sync operations of manifests Argo CD renders from the Application's sources, whose images the agent cannot verify. If false, such sync operations violate the image policy")
+	command.Flags().StringVar(&specPolicyOPAURL, "spec-policy-opa-url",
+		env.StringWithDefault("ARGOCD_AGENT_SPEC_POLICY_OPA_URL", nil, ""),
+		"URL of the Open Policy Agent server evaluating the specs of Applications received from the principal. Spec policies are disabled if empty")
+	command.Flags().StringVar(&specPolicyOPAPath, "spec-policy-opa-path",
+		env.StringWithDefault("ARGOCD_AGENT_SPEC_POLICY_OPA_PATH", nil, "argocd_agent/deny"),
+		"Path of the rule in OPA's data document that evaluates to the violations of an Application")
+	command.Flags().StringVar(&specPolicyMode, "spec-policy-mode",
+		env.StringWithDefault("ARGOCD_AGENT_SPEC_POLICY_MODE", nil, "enforce"),
+		"What to do with Applications violating the spec policy: enforce refuses them, warn only logs the violation")
+	command.Flags().StringVar(&appServiceAccountsClusterRole, "app-service-accounts-cluster-role",
+		env.StringWithDefault("ARGOCD_AGENT_APP_SERVICE_ACCOUNTS_CLUSTER_ROLE", nil, ""),
+		"ClusterRole bound to the service account of each Application in its destination namespace. Resource proxy writes, but not syncs, are made as the Application's service account. Disabled if empty")
+	command.Flags().StringVar(&appServiceAccountsPrefix, "app-service-accounts-prefix",
+		env.StringWithDefault("ARGOCD_AGENT_APP_SERVICE_ACCOUNTS_PREFIX", nil, agent.DefaultAppServiceAccountPrefix),
+		"Prefix of the names of the service accounts of Applications")
+	command.Flags().StringVar(&conflictStrategy, "conflict-strategy",
+		env.StringWithDefault("ARGOCD_AGENT_CONFLICT_STRATEGY", nil, string(manager.ConflictHubWins)),
+		"How to resolve local changes to managed Applications that conflict with the principal's spec, one of: hub-wins, spoke-wins, newest-wins, manual")
+	command.Flags().BoolVar(&serverSideApply, "server-side-apply",
+		env.BoolWithDefault("ARGOCD_AGENT_SERVER_SIDE_APPLY", false),
+		"Update Applications received from the principal with server-side apply, so that fields set by other controllers are kept")
+	command.Flags().IntVar(&resyncBatchSize, "resync-batch-size",
+		env.NumWithDefault("ARGOCD_AGENT_RESYNC_BATCH_SIZE", nil, resync.DefaultBatchSize),
+		"Number of resources sent per batch during the initial sync with the principal (0 sends all at once)")
+	command.Flags().DurationVar(&resyncBookmarkMaxAge, "resync-bookmark-max-age",
+		env.DurationWithDefault("ARGOCD_AGENT_RESYNC_BOOKMARK_MAX_AGE", nil, resync.DefaultBookmarkMaxAge),
+		"How long bookmarks of synced Applications are used for incremental resyncs requested by the principal (0 disables incremental resyncs)")
+	command.Flags().StringVar(&warmCachePath, "warm-cache-path",
+		env.StringWithDefault("ARGOCD_AGENT_WARM_CACHE_PATH", nil, ""),
+		"Path of a file to persist bookmarks of synced Applications to, so that the agent resyncs incrementally after a restart (empty disables the warm cache)")
+	command.Flags().StringSliceVar(&pluginAddresses, "plugin-address",
+		env.StringSliceWithDefault("ARGOCD_AGENT_PLUGIN_ADDRESS", nil, []string{}),
+		"Address of a plugin process to run event hooks, e.g. unix:///run/plugin.sock. May be given multiple times; plugins run in the order given")
+	command.Flags().StringVar(&faultInjection, "fault-injection",
+		env.StringWithDefault("ARGOCD_AGENT_FAULT_INJECTION", nil, ""),
+		"Inject faults into the connection to the principal, e.g. drop=0.1,delay=0.2:500ms,corrupt=0.01,unauthenticated=0.05 (development builds only)")
+	_ = command.Flags().MarkHidden("fault-injection")
+	command.Flags().StringVar(&recordEvents, "record-events",
+		env.StringWithDefault("ARGOCD_AGENT_RECORD_EVENTS", nil, ""),
+		"Record all events exchanged with the principal to this file, for reproducing problems")
+	command.Flags().StringVar(&recordEventsRedaction, "record-events-redaction",
+		env.StringWithDefault("ARGOCD_AGENT_RECORD_EVENTS_REDACTION", nil, string(eventrecord.RedactSecrets)),
+		"What to redact from recorded events (one of: none, secrets, all)")
 
 	command.Flags().StringVar(&kubeConfig, "kubeconfig", "", "Path to a kubeconfig file to use")
 	command.Flags().StringVar(&kubeContext, "kubecontext", "", "Override the default kube context")
 	return command
 }
 
+// secretExists returns true if the secret namespace/name exists. Errors
+// other than the secret not being found are treated as the secret existing,
+// so that they are surfaced when loading the secret.
+func secretExists(ctx context.Context, kube kubernetes.Interface, namespace, name string) bool {
+	_, err := kube.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	return !apierrors.IsNotFound(err)
+}
+
 func parseCreds(credStr string) (string, auth.Credentials, error) {
 	p := strings.SplitN(credStr, ":", 2)
 	if len(p) != 2 {
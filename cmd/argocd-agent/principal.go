@@ -20,9 +20,13 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
@@ -32,13 +36,20 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
 	"github.com/argoproj-labs/argocd-agent/internal/config"
 	"github.com/argoproj-labs/argocd-agent/internal/env"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/internal/eventsink"
 	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/internal/labels"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
 	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
 	"github.com/argoproj-labs/argocd-agent/internal/tracing"
+	"github.com/argoproj-labs/argocd-agent/internal/version"
 	"github.com/argoproj-labs/argocd-agent/pkg/ha"
 	"github.com/argoproj-labs/argocd-agent/principal"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
+	logstream "github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi"
 	cacheutil "github.com/argoproj/argo-cd/v3/util/cache"
 
 	"github.com/sirupsen/logrus"
@@ -49,10 +60,13 @@ import (
 func NewPrincipalRunCommand() *cobra.Command {
 	var (
 		listenHost                string
+		addressFamily             string
 		listenPort                int
 		logLevels                 []string
 		logFormat                 string
 		metricsPort               int
+		metricsAgentLabels        []string
+		metricsAgentLabelsTop     int
 		namespace                 string
 		allowedNamespaces         []string
 		kubeConfig                string
@@ -70,12 +84,32 @@ func NewPrincipalRunCommand() *cobra.Command {
 		rootCaPath                string
 		requireClientCerts        bool
 		clientCertSubjectMatch    bool
+		clientCertCRLs            []string
+		clientCertCRLRefresh      time.Duration
+		clientCertOCSP            bool
+		clientCertOCSPFailClosed  bool
+		clientCertIdentity        string
 		autoNamespaceAllow        bool
 		autoNamespacePattern      string
 		autoNamespaceLabels       []string
 		enableWebSocket           bool
+		enableQUIC                bool
 		enableResourceProxy       bool
 		resourceProxyAddress      string
+		resourceProxyAccessLog    string
+		resourceProxyAccessFormat string
+		resourceProxySocket       string
+		resourceProxySocketMode   string
+		resourceProxyHedgeDelay   time.Duration
+		admissionAddress          string
+		admissionCertPath         string
+		admissionKeyPath          string
+		admissionMode             string
+		admissionThreshold        time.Duration
+		orphanCheckInterval       time.Duration
+		orphanPolicy              string
+		orphanDryRun              bool
+		deletionTimeout           time.Duration
 		pprofPort                 int
 		resourceProxySecretName   string
 		resourceProxyCertPath     string
@@ -86,11 +120,26 @@ func NewPrincipalRunCommand() *cobra.Command {
 		tlsMinVersion   string
 		tlsMaxVersion   string
 		tlsCipherSuites []string
+		tlsCurves       []string
 
 		// Minimum time duration for agent to wait before sending next keepalive ping to principal
 		// if agent sends ping more often than specified interval then connection will be dropped
 		// Ex: "30m", "1h" or "1h20m10s". Valid time units are "s", "m", "h".
 		keepAliveMinimumInterval time.Duration
+		keepAliveTime            time.Duration
+		keepAliveTimeout         time.Duration
+		initialWindowSize        int
+		initialConnWindowSize    int
+		maxConcurrentStreams     int
+		enableGRPCReflection     bool
+		versionSkewPolicy        string
+		versionSkewMaxMinor      int
+		conflictStrategy         string
+		serverSideApply          bool
+		resyncBatchSize          int
+		resyncBookmarkMaxAge     time.Duration
+		resyncInterval           time.Duration
+		resyncJitter             time.Duration
 
 		redisAddress         string
 		redisPassword        string
@@ -98,8 +147,37 @@ func NewPrincipalRunCommand() *cobra.Command {
 		redisCompressionType string
 		disableRedisProxy    bool
 		healthzPort          int
-
-		maxGRPCMessageSize int
+		readinessMinAgents   int
+		adminPort            int
+
+		maxGRPCMessageSize  int
+		logDownloadMaxSize  int
+		fileTransferMaxSize int
+
+		logStaticMaxSize         int
+		logStaticStreamThreshold int
+		logSpillDir              string
+		logSpillMaxSize          int
+		logSpillMaxTotalSize     int
+
+		connectionProbeInterval time.Duration
+
+		shutdownGracePeriod    time.Duration
+		shutdownReconnectDelay time.Duration
+		handoffSocket          string
+
+		eventSinkType         string
+		eventSinkURL          string
+		eventSinkHeaders      []string
+		eventSinkTopic        string
+		eventSinkTopicMap     []string
+		eventSinkRetryTimeout time.Duration
+
+		connectivityAnnotations bool
+		agentStaleAfter         time.Duration
+		agentStatusInterval     time.Duration
+		connHistoryRetention    time.Duration
+		slowHandlerThreshold    time.Duration
 
 		numEventProcessors int
 
@@ -112,6 +190,13 @@ func NewPrincipalRunCommand() *cobra.Command {
 
 		enableSelfClusterRegistration bool
 		selfRegClientCertSecretName   string
+
+		enableRegistrationController bool
+		registrationPrincipalAddress string
+		enableJoinTokens             bool
+		enableAgentConfigurations    bool
+		enableAgentOperations        bool
+		agentOperationsBundleDir     string
 		// Redis TLS configuration
 		redisTLSEnabled               bool
 		redisProxyServerTLSCertPath   string
@@ -129,6 +214,10 @@ func NewPrincipalRunCommand() *cobra.Command {
 		haAdminPort                    int
 		haAllowedReplClients           []string
 		haReplicationInitialAckTimeout time.Duration
+
+		// Recording of the event stream
+		recordEvents          string
+		recordEventsRedaction string
 	)
 	command := &cobra.Command{
 		Use:   "principal",
@@ -190,6 +279,7 @@ func NewPrincipalRunCommand() *cobra.Command {
 			}
 
 			opts = append(opts, principal.WithListenerAddress(listenHost))
+			opts = append(opts, principal.WithAddressFamily(grpcutil.AddressFamily(addressFamily)))
 			opts = append(opts, principal.WithListenerPort(listenPort))
 			opts = append(opts, principal.WithGRPC(true))
 			nsLabels := make(map[string]string)
@@ -205,6 +295,7 @@ func NewPrincipalRunCommand() *cobra.Command {
 			if metricsPort > 0 {
 				opts = append(opts, principal.WithMetricsPort(metricsPort))
 			}
+			opts = append(opts, principal.WithAgentMetricLabels(metricsAgentLabels, metricsAgentLabelsTop))
 
 			opts = append(opts, principal.WithNamespaces(allowedNamespaces...))
 
@@ -238,6 +329,14 @@ func NewPrincipalRunCommand() *cobra.Command {
 
 			opts = append(opts, principal.WithRequireClientCerts(requireClientCerts))
 			opts = append(opts, principal.WithClientCertSubjectMatch(clientCertSubjectMatch))
+			if len(clientCertCRLs) > 0 && (len(clientCertCRLs) != 1 || clientCertCRLs[0] != "") {
+				opts = append(opts, principal.WithCRLs(clientCertCRLs, clientCertCRLRefresh))
+			}
+			opts = append(opts, principal.WithOCSP(clientCertOCSP, clientCertOCSPFailClosed))
+			if clientCertIdentity != "" {
+				source, regexStr := parseClientCertIdentity(clientCertIdentity)
+				opts = append(opts, principal.WithClientCertIdentity(source, regexStr))
+			}
 
 			if tlsMinVersion != "" {
 				opts = append(opts, principal.WithMinimumTLSVersion(tlsMinVersion))
@@ -252,9 +351,21 @@ func NewPrincipalRunCommand() *cobra.Command {
 			if len(tlsCipherSuites) > 0 && (len(tlsCipherSuites) != 1 || tlsCipherSuites[0] != "") {
 				opts = append(opts, principal.WithTLSCipherSuites(tlsCipherSuites))
 			}
+			if len(tlsCurves) == 1 && tlsCurves[0] == "list" {
+				cmdutil.PrintAvailableCurves()
+				return
+			}
+			if len(tlsCurves) > 0 {
+				opts = append(opts, principal.WithTLSCurvePreferences(tlsCurves))
+			}
 
 			opts = append(opts, principal.WithResourceProxyEnabled(enableResourceProxy))
 
+			opts = append(opts, principal.WithAdmissionWebhook(admissionAddress, admissionCertPath, admissionKeyPath))
+			opts = append(opts, principal.WithAdmissionPolicy(admissionMode, admissionThreshold))
+			opts = append(opts, principal.WithOrphanDetection(orphanCheckInterval, orphanPolicy, orphanDryRun))
+			opts = append(opts, principal.WithDeletionTimeout(deletionTimeout))
+
 			if enableResourceProxy {
 				var proxyTLS *tls.Config
 				if resourceProxyCertPath != "" && resourceProxyKeyPath != "" && resourceProxyCAPath != "" {
@@ -273,8 +384,20 @@ func NewPrincipalRunCommand() *cobra.Command {
 				if err := tlsutil.SetTLSConfigFromFlags(proxyTLS, tlsMinVersion, tlsMaxVersion, tlsCipherSuites); err != nil {
 					cmdutil.Fatal("Could not set TLS configuration for resource proxy: %v", err)
 				}
+				if err := tlsutil.SetCurvePreferencesFromFlags(proxyTLS, tlsCurves); err != nil {
+					cmdutil.Fatal("Could not set TLS configuration for resource proxy: %v", err)
+				}
 				opts = append(opts, principal.WithResourceProxyTLS(proxyTLS))
 				opts = append(opts, principal.WithResourceProxyAddress(resourceProxyAddress))
+				opts = append(opts, principal.WithResourceProxyAccessLog(resourceProxyAccessLog, resourceProxyAccessFormat))
+				if resourceProxySocket != "" {
+					mode, err := strconv.ParseUint(resourceProxySocketMode, 8, 32)
+					if err != nil {
+						cmdutil.Fatal("Invalid resource proxy socket mode %s: %v", resourceProxySocketMode, err)
+					}
+					opts = append(opts, principal.WithResourceProxyUnixSocket(resourceProxySocket, os.FileMode(mode)))
+				}
+				opts = append(opts, principal.WithResourceProxyHedgeDelay(resourceProxyHedgeDelay))
 			}
 
 			if jwtKey != "" {
@@ -311,6 +434,11 @@ func NewPrincipalRunCommand() *cobra.Command {
 				}
 				mtlsauth := mtls.NewMTLSAuthentication(regex, source)
 				logrus.Infof("Using mTLS authentication (source: %s, pattern: %s)", source, regexStr)
+				// Unless configured otherwise, agent names are taken from
+				// client certificates the same way for all purposes.
+				if clientCertIdentity == "" && regexStr != "" {
+					opts = append(opts, principal.WithClientCertIdentity(source, regexStr))
+				}
 				err := authMethods.RegisterMethod("mtls", mtlsauth)
 				if err != nil {
 					cmdutil.Fatal("Could not register mtls auth method: %v", err)
@@ -359,7 +487,20 @@ func NewPrincipalRunCommand() *cobra.Command {
 			}
 
 			opts = append(opts, principal.WithWebSocket(enableWebSocket))
+			opts = append(opts, principal.WithQUIC(enableQUIC))
 			opts = append(opts, principal.WithKeepAliveMinimumInterval(keepAliveMinimumInterval))
+			opts = append(opts, principal.WithKeepAliveTime(keepAliveTime))
+			opts = append(opts, principal.WithKeepAliveTimeout(keepAliveTimeout))
+			opts = append(opts, principal.WithInitialWindowSize(initialWindowSize))
+			opts = append(opts, principal.WithInitialConnWindowSize(initialConnWindowSize))
+			opts = append(opts, principal.WithMaxConcurrentStreams(maxConcurrentStreams))
+			opts = append(opts, principal.WithGRPCReflection(enableGRPCReflection))
+			opts = append(opts, principal.WithVersionSkewPolicy(versionSkewPolicy, versionSkewMaxMinor))
+			opts = append(opts, principal.WithConflictStrategy(conflictStrategy))
+			opts = append(opts, principal.WithServerSideApply(serverSideApply))
+			opts = append(opts, principal.WithResyncBatchSize(resyncBatchSize))
+			opts = append(opts, principal.WithResyncBookmarkMaxAge(resyncBookmarkMaxAge))
+			opts = append(opts, principal.WithResyncInterval(resyncInterval, resyncJitter))
 			_, redisPassword, err := redisCreds(redisCredsDirPath, "", redisPassword)
 			if err != nil {
 				cmdutil.Fatal("Failed loading Redis credentials: %s", err.Error())
@@ -369,10 +510,53 @@ func NewPrincipalRunCommand() *cobra.Command {
 				opts = append(opts, principal.WithRedisProxyDisabled())
 			}
 			opts = append(opts, principal.WithHealthzPort(healthzPort))
+			opts = append(opts, principal.WithReadinessMinAgents(readinessMinAgents))
+			opts = append(opts, principal.WithAdminPort(adminPort))
 			opts = append(opts, principal.WithDestinationBasedMapping(destinationBasedMapping))
 			opts = append(opts, principal.WithLabelSelector(labelSelector))
 			opts = append(opts, principal.WithMaxGRPCMessageSize(maxGRPCMessageSize))
+			opts = append(opts, principal.WithLogDownloadMaxSize(logDownloadMaxSize))
+			opts = append(opts, principal.WithLogStaticMaxSize(logStaticMaxSize))
+			opts = append(opts, principal.WithLogStaticStreamThreshold(logStaticStreamThreshold))
+			opts = append(opts, principal.WithLogSpillBuffer(logSpillDir, logSpillMaxSize))
+			opts = append(opts, principal.WithLogSpillMaxTotalSize(logSpillMaxTotalSize))
+			opts = append(opts, principal.WithFileTransferMaxSize(fileTransferMaxSize))
+			opts = append(opts, principal.WithConnectionProbeInterval(connectionProbeInterval))
+			opts = append(opts, principal.WithShutDownGracePeriod(shutdownGracePeriod))
+			opts = append(opts, principal.WithShutdownReconnectDelay(shutdownReconnectDelay))
+			opts = append(opts, principal.WithHandoffSocket(handoffSocket))
 			opts = append(opts, principal.WithEventProcessors(int64(numEventProcessors)))
+			opts = append(opts, principal.WithConnectivityAnnotations(connectivityAnnotations, agentStaleAfter))
+			opts = append(opts, principal.WithAgentStatusInterval(agentStatusInterval))
+			opts = append(opts, principal.WithConnectionHistoryRetention(connHistoryRetention))
+			opts = append(opts, principal.WithSlowEventHandlerThreshold(slowHandlerThreshold))
+
+			if eventSinkURL != "" {
+				headers := http.Header{}
+				for _, h := range eventSinkHeaders {
+					k, v, ok := strings.Cut(h, "=")
+					if !ok || k == "" {
+						cmdutil.Fatal("Invalid event sink header %q, must be in the form key=value", h)
+					}
+					headers.Add(k, v)
+				}
+				topicMapping, err := eventsink.ParseTopicMapping(eventSinkTopicMap)
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				sink, err := eventsink.New(eventsink.Config{
+					Type:         eventSinkType,
+					URL:          eventSinkURL,
+					Headers:      headers,
+					Topic:        eventSinkTopic,
+					TopicMapping: topicMapping,
+				})
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				opts = append(opts, principal.WithEventSink(sink))
+				opts = append(opts, principal.WithEventSinkRetryTimeout(eventSinkRetryTimeout))
+			}
 
 			// Self agent registration validation and options
 			if enableSelfClusterRegistration {
@@ -387,6 +571,15 @@ func NewPrincipalRunCommand() *cobra.Command {
 			if selfRegClientCertSecretName != "" {
 				opts = append(opts, principal.WithClientCertSecretName(selfRegClientCertSecretName))
 			}
+			opts = append(opts, principal.WithJoinTokens(enableJoinTokens))
+			if enableRegistrationController {
+				if !enableResourceProxy {
+					cmdutil.Fatal("The agent registration controller requires --enable-resource-proxy to be enabled")
+				}
+				opts = append(opts, principal.WithAgentRegistrationController(true, registrationPrincipalAddress))
+			}
+			opts = append(opts, principal.WithAgentConfigurations(enableAgentConfigurations))
+			opts = append(opts, principal.WithAgentOperations(enableAgentOperations, agentOperationsBundleDir))
 
 			// Configure Redis TLS
 			opts = append(opts, principal.WithRedisTLSEnabled(redisTLSEnabled))
@@ -457,6 +650,20 @@ func NewPrincipalRunCommand() *cobra.Command {
 				logrus.Infof("HA enabled (preferred-role=%s, peer=%s)", haPreferredRole, haPeerAddress)
 			}
 
+			if recordEvents != "" {
+				redaction, err := eventrecord.ParseRedaction(recordEventsRedaction)
+				if err != nil {
+					cmdutil.Fatal("Invalid --record-events-redaction: %v", err)
+				}
+				recorder, err := eventrecord.NewFileRecorder(recordEvents, eventrecord.WithRedaction(redaction))
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				defer recorder.Close()
+				logrus.Warnf("Recording all events exchanged with agents to %s (redaction: %s)", recordEvents, redaction)
+				opts = append(opts, principal.WithEventRecorder(recorder))
+			}
+
 			s, err := principal.NewServer(ctx, kubeConfig, namespace, opts...)
 			if err != nil {
 				cmdutil.Fatal("Could not create new server instance: %v", err)
@@ -466,12 +673,29 @@ func NewPrincipalRunCommand() *cobra.Command {
 			if err != nil {
 				cmdutil.Fatal("Could not start server: %v", err)
 			}
-			<-ctx.Done()
+
+			// Drain connections to agents and clients when we are asked to
+			// terminate, instead of dropping them.
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+			select {
+			case sig := <-sigCh:
+				logrus.Infof("Received signal %v, shutting down", sig)
+				if err := s.Shutdown(); err != nil {
+					logrus.WithError(err).Error("Error shutting down")
+				}
+			case <-s.HandedOff():
+				logrus.Info("Sessions were handed over to a new instance, exiting")
+			case <-ctx.Done():
+			}
 		},
 	}
 	command.Flags().StringVar(&listenHost, "listen-host",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_LISTEN_HOST", nil, ""),
 		"Name of the host to listen on")
+	command.Flags().StringVar(&addressFamily, "address-family",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_ADDRESS_FAMILY", nil, string(grpcutil.AddressFamilyDual)),
+		"IP address families the gRPC listener accepts connections of, one of dual, tcp4 or tcp6")
 	command.Flags().IntVar(&listenPort, "listen-port",
 		env.NumWithDefault("ARGOCD_PRINCIPAL_LISTEN_PORT", cmdutil.ValidPort, 8443),
 		"Port the gRPC server will listen on")
@@ -486,6 +710,12 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().IntVar(&metricsPort, "metrics-port",
 		env.NumWithDefault("ARGOCD_PRINCIPAL_METRICS_PORT", cmdutil.ValidPort, 8000),
 		"Port the metrics server will listen on")
+	command.Flags().StringSliceVar(&metricsAgentLabels, "metrics-agent-labels",
+		env.StringSliceWithDefault("ARGOCD_PRINCIPAL_METRICS_AGENT_LABELS", nil, []string{}),
+		"Glob patterns of agents whose metrics are labeled with their name; metrics of other agents are aggregated (\"*\" labels all agents)")
+	command.Flags().IntVar(&metricsAgentLabelsTop, "metrics-agent-labels-top",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_METRICS_AGENT_LABELS_TOP", nil, 0),
+		"Number of most active agents whose metrics are labeled with their name, in addition to those matching --metrics-agent-labels")
 
 	command.Flags().StringVarP(&namespace, "namespace", "n",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_NAMESPACE", nil, ""),
@@ -530,6 +760,21 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().BoolVar(&clientCertSubjectMatch, "client-cert-subject-match",
 		env.BoolWithDefault("ARGOCD_PRINCIPAL_TLS_CLIENT_CERT_MATCH_SUBJECT", false),
 		"Whether a client cert's subject must match the agent name")
+	command.Flags().StringVar(&clientCertIdentity, "client-cert-identity",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_TLS_CLIENT_CERT_IDENTITY", nil, ""),
+		"How to get the agent name from client certs: cn[:<regex>], subject:<regex> or uri:<regex>. Defaults to the rule of the mtls auth method, or the common name")
+	command.Flags().StringSliceVar(&clientCertCRLs, "client-cert-crl",
+		env.StringSliceWithDefault("ARGOCD_PRINCIPAL_TLS_CLIENT_CERT_CRL", nil, []string{}),
+		"Comma-separated list of files or HTTP(S) URLs of certificate revocation lists to check agent client certs against")
+	command.Flags().DurationVar(&clientCertCRLRefresh, "client-cert-crl-refresh-interval",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_TLS_CLIENT_CERT_CRL_REFRESH_INTERVAL", nil, 1*time.Hour),
+		"Interval in which to reload the certificate revocation lists")
+	command.Flags().BoolVar(&clientCertOCSP, "client-cert-ocsp",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_TLS_CLIENT_CERT_OCSP", false),
+		"Whether to check agent client certs with the OCSP responder named in the cert")
+	command.Flags().BoolVar(&clientCertOCSPFailClosed, "client-cert-ocsp-fail-closed",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_TLS_CLIENT_CERT_OCSP_FAIL_CLOSED", false),
+		"Whether to reject agent client certs whose OCSP status cannot be determined")
 
 	command.Flags().StringVar(&tlsMinVersion, "tls-min-version",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_TLS_MIN_VERSION", nil, "tls1.3"),
@@ -540,6 +785,9 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().StringSliceVar(&tlsCipherSuites, "tls-ciphersuites",
 		env.StringSliceWithDefault("ARGOCD_PRINCIPAL_TLS_CIPHERSUITES", nil, []string{}),
 		"Comma-separated list of TLS cipher suites to use. Use 'list' to show available cipher suites and exit")
+	command.Flags().StringSliceVar(&tlsCurves, "tls-curves",
+		env.StringSliceWithDefault("ARGOCD_PRINCIPAL_TLS_CURVES", nil, []string{}),
+		"Comma-separated list of TLS key exchange curves to accept, in order of preference. Use 'list' to show available curves and exit")
 
 	command.Flags().StringVar(&resourceProxySecretName, "resource-proxy-secret-name",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_SECRET_NAME", nil, config.SecretNameProxyTLS),
@@ -574,6 +822,9 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().BoolVar(&enableWebSocket, "enable-websocket",
 		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_WEBSOCKET", false),
 		"Principal will rely on gRPC over WebSocket to stream events to the Agent")
+	command.Flags().BoolVar(&enableQUIC, "enable-quic",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_QUIC", false),
+		"(Experimental) Accept agent connections over QUIC on the UDP port with the number of the gRPC port")
 
 	command.Flags().BoolVar(&enableResourceProxy, "enable-resource-proxy",
 		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_RESOURCE_PROXY", true),
@@ -581,10 +832,96 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().StringVar(&resourceProxyAddress, "resource-proxy-address",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_ADDRESS", nil, "argocd-agent-resource-proxy:9090"),
 		"Resource proxy address on principal side")
+	command.Flags().StringVar(&resourceProxyAccessLog, "resource-proxy-access-log",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_ACCESS_LOG", nil, ""),
+		"Write an access log of resource proxy requests to stdout, stderr or the given file. Disabled if empty")
+	command.Flags().StringVar(&resourceProxyAccessFormat, "resource-proxy-access-log-format",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_ACCESS_LOG_FORMAT", nil, "json"),
+		"Format of the resource proxy access log, one of: json, combined")
+	command.Flags().StringVar(&resourceProxySocket, "resource-proxy-socket",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_SOCKET", nil, ""),
+		"Path of a unix domain socket the resource proxy listens on in addition to TCP, without TLS. Requests on the socket must use bearer tokens. Disabled if empty")
+	command.Flags().StringVar(&resourceProxySocketMode, "resource-proxy-socket-mode",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_SOCKET_MODE", nil, "0660"),
+		"Permissions of the resource proxy's unix domain socket, in octal")
+	command.Flags().DurationVar(&resourceProxyHedgeDelay, "resource-proxy-hedge-delay",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_RESOURCE_PROXY_HEDGE_DELAY", nil, 0),
+		"Send GET requests for live resources to the agent once more if it did not answer within this time, and return the first successful response (0 disables hedging)")
+
+	command.Flags().StringVar(&admissionAddress, "admission-webhook-address",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_ADMISSION_WEBHOOK_ADDRESS", nil, ""),
+		"Serve a validating webhook for Applications on this address, e.g. :9443. Disabled if empty")
+	command.Flags().StringVar(&admissionCertPath, "admission-webhook-cert-path",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_ADMISSION_WEBHOOK_CERT_PATH", nil, ""),
+		"Path to the TLS certificate of the admission webhook")
+	command.Flags().StringVar(&admissionKeyPath, "admission-webhook-key-path",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_ADMISSION_WEBHOOK_KEY_PATH", nil, ""),
+		"Path to the TLS private key of the admission webhook")
+	command.Flags().StringVar(&admissionMode, "admission-webhook-mode",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_ADMISSION_WEBHOOK_MODE", nil, principal.AdmissionModeWarn),
+		"Whether to warn about or deny Applications targeting unavailable agents, one of: warn, deny")
+	command.Flags().DurationVar(&admissionThreshold, "admission-webhook-disconnect-threshold",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_ADMISSION_WEBHOOK_DISCONNECT_THRESHOLD", nil, time.Hour),
+		"Time an agent may be disconnected before Applications targeting it are warned about or denied")
+
+	command.Flags().DurationVar(&orphanCheckInterval, "orphan-check-interval",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_ORPHAN_CHECK_INTERVAL", nil, 0),
+		"Interval to check for Applications whose agent no longer exists. Disabled if 0")
+	command.Flags().StringVar(&orphanPolicy, "orphan-policy",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_ORPHAN_POLICY", nil, principal.OrphanPolicyOrphan),
+		"What to do with Applications whose agent no longer exists, one of: orphan, delete, cascade-delete")
+	command.Flags().BoolVar(&orphanDryRun, "orphan-dry-run",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ORPHAN_DRY_RUN", false),
+		"Only report what the orphan policy would delete")
+	command.Flags().DurationVar(&deletionTimeout, "deletion-timeout",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_DELETION_TIMEOUT", nil, 0),
+		"Time to wait for an agent to confirm the deletion of an Application before removing it from the principal anyway. Waits forever if 0")
 
 	command.Flags().DurationVar(&keepAliveMinimumInterval, "keepalive-min-interval",
 		env.DurationWithDefault("ARGOCD_PRINCIPAL_KEEP_ALIVE_MIN_INTERVAL", nil, 0),
 		"Drop agent connections that send keepalive pings more often than the specified interval") // It should be less than "keep-alive-ping-interval" of agent
+	command.Flags().DurationVar(&keepAliveTime, "keepalive-time",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_KEEP_ALIVE_TIME", nil, 0),
+		"Time after which the principal pings an idle agent connection (0 uses gRPC's default of 2h)")
+	command.Flags().DurationVar(&keepAliveTimeout, "keepalive-timeout",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_KEEP_ALIVE_TIMEOUT", nil, 0),
+		"Time to wait for an agent to answer a keepalive ping before the connection is closed (0 uses gRPC's default of 20s)")
+	command.Flags().IntVar(&initialWindowSize, "grpc-initial-window-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_GRPC_INITIAL_WINDOW_SIZE", nil, 0),
+		"Initial HTTP/2 flow control window of each gRPC stream in bytes, at least 65536 (0 uses gRPC's dynamic window)")
+	command.Flags().IntVar(&initialConnWindowSize, "grpc-initial-conn-window-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_GRPC_INITIAL_CONN_WINDOW_SIZE", nil, 0),
+		"Initial HTTP/2 flow control window of each agent connection in bytes, at least 65536 (0 uses gRPC's dynamic window)")
+	command.Flags().IntVar(&maxConcurrentStreams, "grpc-max-concurrent-streams",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_GRPC_MAX_CONCURRENT_STREAMS", nil, 0),
+		"Maximum number of concurrent gRPC streams per agent connection (0 means no limit)")
+	command.Flags().BoolVar(&enableGRPCReflection, "enable-grpc-reflection",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_GRPC_REFLECTION", false),
+		"Enable gRPC server reflection, so that the principal's services can be introspected without authentication, e.g. with grpcurl")
+	command.Flags().StringVar(&versionSkewPolicy, "version-skew-policy",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_VERSION_SKEW_POLICY", nil, string(version.SkewModeExact)),
+		"How to treat agents whose version differs from the principal's, one of: exact, warn, refuse")
+	command.Flags().IntVar(&versionSkewMaxMinor, "version-skew-max-minor",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_VERSION_SKEW_MAX_MINOR", nil, version.DefaultMaxMinorSkew),
+		"Number of minor versions an agent may be behind the principal under the warn and refuse policies")
+	command.Flags().StringVar(&conflictStrategy, "conflict-strategy",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_CONFLICT_STRATEGY", nil, string(manager.ConflictSpokeWins)),
+		"How to resolve changes to autonomous agents' Applications on the principal that conflict with the agent's spec, one of: hub-wins, spoke-wins, newest-wins, manual")
+	command.Flags().BoolVar(&serverSideApply, "server-side-apply",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_SERVER_SIDE_APPLY", false),
+		"Update Applications received from autonomous agents with server-side apply, so that fields set by other controllers are kept")
+	command.Flags().IntVar(&resyncBatchSize, "resync-batch-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_RESYNC_BATCH_SIZE", nil, resync.DefaultBatchSize),
+		"Number of resources sent per batch during the initial sync with an agent (0 sends all at once)")
+	command.Flags().DurationVar(&resyncBookmarkMaxAge, "resync-bookmark-max-age",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_RESYNC_BOOKMARK_MAX_AGE", nil, resync.DefaultBookmarkMaxAge),
+		"How long bookmarks of synced Applications are used for incremental resyncs requested by autonomous agents (0 disables incremental resyncs)")
+	command.Flags().DurationVar(&resyncInterval, "resync-interval",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_RESYNC_INTERVAL", nil, 0),
+		"Interval at which the principal resyncs with each connected agent (0 disables periodic resyncs)")
+	command.Flags().DurationVar(&resyncJitter, "resync-jitter",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_RESYNC_JITTER", nil, 0),
+		"Maximum delay of the resyncs with an agent, so that the resyncs of many agents are spread over time")
 
 	command.Flags().StringVar(&redisAddress, "redis-server-address",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_REDIS_SERVER_ADDRESS", nil, "argocd-redis:6379"),
@@ -609,13 +946,85 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().IntVar(&healthzPort, "healthz-port",
 		env.NumWithDefault("ARGOCD_PRINCIPAL_HEALTH_CHECK_PORT", cmdutil.ValidPort, 8003),
 		"Port the health check server will listen on")
+	command.Flags().IntVar(&readinessMinAgents, "readiness-min-agents",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_READINESS_MIN_AGENTS", nil, 0),
+		"Number of agents that must be connected for the principal to be reported as ready (0 disables the check)")
+	command.Flags().IntVar(&adminPort, "admin-port",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_ADMIN_PORT", cmdutil.ValidPort, 0),
+		"Port the localhost-only admin server serving the debug shell will listen on (0 disables it)")
 
 	command.Flags().IntVar(&maxGRPCMessageSize, "grpc-max-message-size",
 		env.NumWithDefault("ARGOCD_PRINCIPAL_GRPC_MAX_MESSAGE_SIZE", nil, grpcutil.DefaultGRPCMaxMessageSize),
 		"Maximum gRPC message size in bytes for send and receive (default: 200MB)")
+	command.Flags().IntVar(&logDownloadMaxSize, "log-download-max-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_LOG_DOWNLOAD_MAX_SIZE", nil, logstream.DefaultMaxDownloadSize),
+		"Maximum size in bytes of pod logs downloaded as a file (0 disables the limit)")
+	command.Flags().IntVar(&logStaticMaxSize, "log-static-max-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_LOG_STATIC_MAX_SIZE", nil, 0),
+		"Maximum size in bytes of static pod logs sent to a client, other than downloads. Larger logs are refused with 413 or truncated (0 disables the limit)")
+	command.Flags().IntVar(&logStaticStreamThreshold, "log-static-stream-threshold",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_LOG_STATIC_STREAM_THRESHOLD", nil, logstream.DefaultStaticStreamThreshold),
+		"Size in bytes up to which static pod logs are buffered before they are streamed to the client (0 streams all static logs)")
+	command.Flags().StringVar(&logSpillDir, "log-spill-dir",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_LOG_SPILL_DIR", nil, ""),
+		"Directory in which follow log streams buffer data for clients reading slowly, so that agents don't wait for them. Disabled if empty")
+	command.Flags().IntVar(&logSpillMaxSize, "log-spill-max-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_LOG_SPILL_MAX_SIZE", nil, logstream.DefaultSpillSize),
+		"Maximum size in bytes of the spill buffer of a follow log stream. The oldest lines are dropped when it is full")
+	command.Flags().IntVar(&logSpillMaxTotalSize, "log-spill-max-total-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_LOG_SPILL_MAX_TOTAL_SIZE", nil, logstream.DefaultSpillTotalSize),
+		"Maximum size in bytes of the spill buffers of all follow log streams together. Further streams are written to their clients directly (0 disables the limit)")
+	command.Flags().IntVar(&fileTransferMaxSize, "file-transfer-max-size",
+		env.NumWithDefault("ARGOCD_PRINCIPAL_FILE_TRANSFER_MAX_SIZE", nil, int(filetransfer.DefaultMaxFileSize)),
+		"Maximum size in bytes of a file, such as a support bundle, transferred from an agent (0 disables the limit)")
+	command.Flags().DurationVar(&connectionProbeInterval, "connection-probe-interval",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_CONNECTION_PROBE_INTERVAL", nil, 30*time.Second),
+		"Interval at which round trip time and clock skew of connected agents are measured (0 disables measurement)")
+	command.Flags().DurationVar(&shutdownGracePeriod, "shutdown-grace-period",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_SHUTDOWN_GRACE_PERIOD", nil, 10*time.Second),
+		"Time given to requests and agent connections in flight to finish when the principal shuts down")
+	command.Flags().DurationVar(&shutdownReconnectDelay, "shutdown-reconnect-delay",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_SHUTDOWN_RECONNECT_DELAY", nil, 5*time.Second),
+		"Delay suggested to agents and clients for reconnecting when the principal shuts down")
+	command.Flags().StringVar(&handoffSocket, "handoff-socket",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_HANDOFF_SOCKET", nil, ""),
+		"Path of a Unix domain socket used to hand over live sessions to a new principal instance on upgrade (empty disables handoff)")
+	command.Flags().StringVar(&eventSinkType, "event-sink-type",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_EVENT_SINK_TYPE", nil, eventsink.TypeHTTP),
+		"Type of the event sink: one of http, nats, jetstream or kafka-rest")
+	command.Flags().StringVar(&eventSinkURL, "event-sink-url",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_EVENT_SINK_URL", nil, ""),
+		"Endpoint to which lifecycle events, such as agents connecting or finished syncs, are published as CloudEvents")
+	command.Flags().StringSliceVar(&eventSinkHeaders, "event-sink-header",
+		env.StringSliceWithDefault("ARGOCD_PRINCIPAL_EVENT_SINK_HEADERS", nil, []string{}),
+		"Additional HTTP headers in the form key=value to send to the event sink")
+	command.Flags().StringVar(&eventSinkTopic, "event-sink-topic",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_EVENT_SINK_TOPIC", nil, eventsink.DefaultTopic),
+		"Default Kafka topic or NATS subject to publish events to")
+	command.Flags().StringSliceVar(&eventSinkTopicMap, "event-sink-topic-map",
+		env.StringSliceWithDefault("ARGOCD_PRINCIPAL_EVENT_SINK_TOPIC_MAP", nil, []string{}),
+		"Kafka topic or NATS subject per event type, in the form type=topic")
+	command.Flags().DurationVar(&eventSinkRetryTimeout, "event-sink-retry-timeout",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_EVENT_SINK_RETRY_TIMEOUT", nil, 0),
+		"Time after which delivery of an event to the event sink is given up (0 retries until delivered)")
+	command.Flags().BoolVar(&connectivityAnnotations, "connectivity-annotations",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_CONNECTIVITY_ANNOTATIONS", false),
+		"Maintain annotations reflecting the connectivity of each agent on its cluster secret")
+	command.Flags().DurationVar(&agentStaleAfter, "agent-stale-after",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_AGENT_STALE_AFTER", nil, 15*time.Minute),
+		"Time after which a connected agent that has not sent any data is annotated as stale (0 disables)")
+	command.Flags().DurationVar(&agentStatusInterval, "agent-status-interval",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_AGENT_STATUS_INTERVAL", nil, 0),
+		"Interval at which AgentStatus resources are updated (0 disables them; requires the AgentStatus CRD)")
+	command.Flags().DurationVar(&connHistoryRetention, "connection-history-retention",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_CONNECTION_HISTORY_RETENTION", nil, 7*24*time.Hour),
+		"Time for which the connection history of agents is kept (0 disables it)")
 	command.Flags().IntVar(&numEventProcessors, "event-processors",
 		env.NumWithDefault("ARGOCD_PRINCIPAL_EVENT_PROCESSORS", nil, 10),
 		"Number of concurrent event processors")
+	command.Flags().DurationVar(&slowHandlerThreshold, "slow-event-handler-threshold",
+		env.DurationWithDefault("ARGOCD_PRINCIPAL_SLOW_EVENT_HANDLER_THRESHOLD", nil, time.Second),
+		"Time after which the handler of an event received from an agent is logged as slow (0 disables it)")
 
 	command.Flags().StringVar(&otlpAddress, "otlp-address",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_OTLP_ADDRESS", nil, ""),
@@ -663,6 +1072,24 @@ func NewPrincipalRunCommand() *cobra.Command {
 	command.Flags().StringVar(&selfRegClientCertSecretName, "self-registration-client-cert-secret",
 		env.StringWithDefault("ARGOCD_PRINCIPAL_SELF_REGISTRATION_CLIENT_CERT_SECRET", nil, ""),
 		"TLS secret containing shared client cert for self-registered cluster secrets (must have tls.crt, tls.key, ca.crt)")
+	command.Flags().BoolVar(&enableJoinTokens, "enable-join-tokens",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_JOIN_TOKENS", false),
+		"Allow agents to obtain a client certificate using a one-time join token")
+	command.Flags().BoolVar(&enableRegistrationController, "enable-agent-registration-controller",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_AGENT_REGISTRATION_CONTROLLER", false),
+		"Provision cluster and bootstrap secrets for AgentRegistration resources")
+	command.Flags().StringVar(&registrationPrincipalAddress, "agent-registration-principal-address",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_AGENT_REGISTRATION_PRINCIPAL_ADDRESS", nil, ""),
+		"Principal address (host:port) agents connect to, written to bootstrap secrets of AgentRegistration resources")
+	command.Flags().BoolVar(&enableAgentConfigurations, "enable-agent-configurations",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_AGENT_CONFIGURATIONS", false),
+		"Push the runtime configuration of AgentConfiguration resources to the agents they select")
+	command.Flags().BoolVar(&enableAgentOperations, "enable-agent-operations",
+		env.BoolWithDefault("ARGOCD_PRINCIPAL_ENABLE_AGENT_OPERATIONS", false),
+		"Run AgentOperation resources on the agents they select")
+	command.Flags().StringVar(&agentOperationsBundleDir, "agent-operations-bundle-dir",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_AGENT_OPERATIONS_BUNDLE_DIR", nil, ""),
+		"Directory support bundles collected by AgentOperations are written to")
 
 	command.Flags().BoolVar(&haEnabled, "ha-enabled",
 		env.BoolWithDefault("ARGOCD_PRINCIPAL_HA_ENABLED", false),
@@ -686,6 +1113,13 @@ func NewPrincipalRunCommand() *cobra.Command {
 		env.DurationWithDefault("ARGOCD_PRINCIPAL_HA_REPLICATION_INITIAL_ACK_TIMEOUT", nil, 0),
 		"How long the primary waits for the replica's initial ACK after snapshot fetch (default: 5m)")
 
+	command.Flags().StringVar(&recordEvents, "record-events",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_RECORD_EVENTS", nil, ""),
+		"Record all events exchanged with agents to this file, for reproducing problems")
+	command.Flags().StringVar(&recordEventsRedaction, "record-events-redaction",
+		env.StringWithDefault("ARGOCD_PRINCIPAL_RECORD_EVENTS_REDACTION", nil, string(eventrecord.RedactSecrets)),
+		"What to redact from recorded events (one of: none, secrets, all)")
+
 	return command
 }
 
@@ -823,6 +1257,18 @@ func parseMTLSConfig(config string) (mtls.IdentitySource, string) {
 	return mtls.IdentitySourceSubject, config
 }
 
+// parseClientCertIdentity parses the client certificate identity rule in the
+// format:
+//
+//	cn                   -> source=cn, the common name is the agent name
+//	cn:<regex>           -> source=cn
+//	subject:<regex>      -> source=subject
+//	uri:<regex>          -> source=uri
+func parseClientCertIdentity(config string) (mtls.IdentitySource, string) {
+	source, regex, _ := strings.Cut(config, ":")
+	return mtls.IdentitySource(source), regex
+}
+
 // validateAuthTLSPairing validates that the authentication method is compatible
 // with the TLS configuration. Certain combinations are invalid:
 //   - header auth requires insecure-plaintext mode (service mesh handles mTLS)
@@ -46,7 +46,8 @@ func parseTag(fieldName string, tag string) map[string]string {
 
 // StructToTabwriter takes any struct s and produces a formatted text output
 // using the tabwriter tw. The fields in struct s to render must be tagged
-// properly with a "text" tag, and they must be exported.
+// properly with a "text" tag, and they must be exported. Fields tagged with
+// omitempty are skipped if they have their type's zero value.
 //
 // This function will not flush the tabwriter's writer, so the caller is
 // expected to do that after this function returns.
@@ -71,6 +72,9 @@ func StructToTabwriter(s any, tw *tabwriter.Writer) error {
 			continue
 		}
 		tag := parseTag(t.Field(i).Name, s)
+		if tag["omitempty"] != "" && v.Field(i).IsZero() {
+			continue
+		}
 		fmt.Fprintf(tw, "%s:\t%v\n", tag["name"], v.Field(i).Interface())
 	}
 	return nil
@@ -104,6 +104,19 @@ func Test_StructToTabwriter(t *testing.T) {
 		assert.NotEmpty(t, bb.Bytes())
 	})
 
+	t.Run("Write a struct with empty fields", func(t *testing.T) {
+		test := &struct {
+			SomeField  string `text:"Some field"`
+			OtherField string `text:"Other field,omitempty"`
+		}{}
+		bb := &bytes.Buffer{}
+		tw := tabwriter.NewWriter(bb, 0, 0, 0, ' ', 0)
+		err := StructToTabwriter(test, tw)
+		assert.NoError(t, err)
+		tw.Flush()
+		assert.Equal(t, "Some field:\n", bb.String())
+	})
+
 	t.Run("Write a non-tagged struct", func(t *testing.T) {
 		test := &struct {
 			SomeField string
@@ -18,6 +18,8 @@ import (
 	"crypto/tls"
 	"fmt"
 	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
 )
 
 // PrintAvailableCipherSuites prints all available TLS cipher suites and their
@@ -43,3 +45,13 @@ func PrintAvailableCipherSuites() {
 		fmt.Printf("    Supported versions: %s\n", strings.Join(versions, ", "))
 	}
 }
+
+// PrintAvailableCurves prints all available TLS key exchange mechanisms to
+// stdout, in the order Go prefers them by default.
+func PrintAvailableCurves() {
+	fmt.Println("Available TLS curves:")
+	fmt.Println()
+	for _, name := range tlsutil.CurveNames() {
+		fmt.Printf("  %s\n", name)
+	}
+}
@@ -61,6 +61,14 @@ func NewAgentCommand() *cobra.Command {
 	command.AddCommand(NewAgentInspectCommand())
 	command.AddCommand(NewAgentPrintTLSCommand())
 	command.AddCommand(NewAgentReconfigureCommand())
+	command.AddCommand(NewAgentMaintenanceCommand())
+	command.AddCommand(NewAgentSupportBundleCommand())
+	command.AddCommand(NewAgentJoinTokenCommand())
+	command.AddCommand(NewAgentRBACCommand())
+	command.AddCommand(NewAgentEgressCommand())
+	command.AddCommand(NewAgentDebugCommand())
+	command.AddCommand(NewAgentConnectionsCommand())
+	command.AddCommand(NewAgentDriftCommand())
 	return command
 }
 
@@ -312,7 +320,11 @@ func NewAgentListCommand() *cobra.Command {
 }
 
 func NewAgentInspectCommand() *cobra.Command {
-	var outputFormat string
+	var (
+		outputFormat   string
+		connection     bool
+		metricsAddress string
+	)
 	command := &cobra.Command{
 		Short:   "Inspect agent configuration",
 		Use:     "inspect",
@@ -327,6 +339,10 @@ func NewAgentInspectCommand() *cobra.Command {
 				Name           string    `yaml:"name" json:"name" text:"Server name"`
 				NotValidBefore time.Time `yaml:"notValidBefore" json:"notValidBefore" text:"Not valid before"`
 				NotValidAfter  time.Time `yaml:"notValidAfter" json:"notValidAfter" text:"Not valid after"`
+				RTT            string    `yaml:"rtt,omitempty" json:"rtt,omitempty" text:"Round trip time,omitempty"`
+				OneWayLatency  string    `yaml:"oneWayLatency,omitempty" json:"oneWayLatency,omitempty" text:"One-way latency,omitempty"`
+				ClockSkew      string    `yaml:"clockSkew,omitempty" json:"clockSkew,omitempty" text:"Clock skew,omitempty"`
+				Maintenance    bool      `yaml:"maintenance" json:"maintenance" text:"Maintenance mode"`
 			}
 			agentName := args[0]
 			argoCluster, err := loadClusterSecret(agentName)
@@ -345,6 +361,22 @@ func NewAgentInspectCommand() *cobra.Command {
 				Name:           argoCluster.Name,
 				NotValidAfter:  cert.Leaf.NotAfter,
 				NotValidBefore: cert.Leaf.NotBefore,
+				Maintenance:    cluster.IsInMaintenance(argoCluster),
+			}
+			if connection {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				q, err := fetchConnectionQuality(ctx, metricsAddress, agentName)
+				if err != nil {
+					cmdutil.Fatal("Unable to get connection quality: %v", err)
+				}
+				if q.HasMeasurement {
+					cluster.RTT = q.RTT.String()
+					cluster.OneWayLatency = q.OneWayLatency.String()
+					cluster.ClockSkew = q.ClockSkew.String()
+				} else {
+					cmd.PrintErrf("No connection quality measured for agent %s, is it connected and does it have metrics of its own?\n", agentName)
+				}
 			}
 			var out []byte
 			switch strings.ToLower(outputFormat) {
@@ -369,6 +401,8 @@ func NewAgentInspectCommand() *cobra.Command {
 		},
 	}
 	command.Flags().StringVarP(&outputFormat, "output", "o", "json", "Output format (json, yaml or text)")
+	command.Flags().BoolVar(&connection, "connection", false, "Include round trip time and clock skew as measured by the principal")
+	command.Flags().StringVar(&metricsAddress, "metrics-address", "", "Direct address of the principal's metrics endpoint (bypasses kube port-forward)")
 	return command
 }
 
@@ -478,6 +512,58 @@ func NewAgentReconfigureCommand() *cobra.Command {
 	return command
 }
 
+func NewAgentMaintenanceCommand() *cobra.Command {
+	var disable bool
+	command := &cobra.Command{
+		Short: "Puts an agent into or takes it out of maintenance mode",
+		Long: `Puts an agent into or takes it out of maintenance mode.
+
+While an agent is in maintenance mode, the principal refuses new log streams,
+terminal sessions and support bundle requests for it. Requests in flight are
+allowed to finish, and the agent stays connected and keeps syncing.`,
+		Use: "maintenance <agent-name>",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				_ = cmd.Help()
+				cmdutil.Fatal("Not enough arguments given")
+			}
+			agentName := args[0]
+			clus, err := loadClusterSecret(agentName)
+			if err != nil {
+				cmdutil.Fatal("Unable to load agent configuration: %v", err)
+			} else if clus == nil {
+				cmd.PrintErrf("No configuration found for agent %s\n", agentName)
+				os.Exit(1)
+			}
+			if cluster.IsInMaintenance(clus) != disable {
+				cmd.Printf("Agent %s is already %s\n", agentName, maintenanceState(!disable))
+				return
+			}
+			if disable {
+				delete(clus.Annotations, cluster.AnnotationKeyMaintenance)
+			} else {
+				if clus.Annotations == nil {
+					clus.Annotations = make(map[string]string)
+				}
+				clus.Annotations[cluster.AnnotationKeyMaintenance] = "true"
+			}
+			if err := saveClusterSecret(agentName, clus); err != nil {
+				cmdutil.Fatal("Unable to save cluster secret: %v", err)
+			}
+			cmd.Printf("Agent %s is now %s\n", agentName, maintenanceState(!disable))
+		},
+	}
+	command.Flags().BoolVar(&disable, "disable", false, "Take the agent out of maintenance mode")
+	return command
+}
+
+func maintenanceState(enabled bool) string {
+	if enabled {
+		return "in maintenance mode"
+	}
+	return "not in maintenance mode"
+}
+
 func clusterSecretName(agentName string) string {
 	return "cluster-" + agentName
 }
@@ -0,0 +1,566 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	"github.com/argoproj/argo-cd/v3/util/db"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// backupFormatVersion is the version of the backup archive format
+	backupFormatVersion = 1
+	// principalParamsConfigMap is the name of the principal's configuration
+	principalParamsConfigMap = "argocd-agent-params"
+	// maxBackupEntrySize is the maximum size of a single file in a backup
+	// archive
+	maxBackupEntrySize = 256 * 1024 * 1024
+)
+
+// backupSecretNames are the secrets of the principal's PKI which are backed
+// up besides the agents' cluster secrets
+var backupSecretNames = []string{
+	config.SecretNamePrincipalCA,
+	config.SecretNamePrincipalTLS,
+	config.SecretNameProxyTLS,
+	config.SecretNameJWT,
+}
+
+// backupManifest describes the contents of a backup archive
+type backupManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Namespace string    `json:"namespace"`
+	Agents    []string  `json:"agents"`
+}
+
+// issuedCertificate holds the metadata of a certificate found in a backup
+type issuedCertificate struct {
+	Secret    string    `json:"secret"`
+	Agent     string    `json:"agent,omitempty"`
+	Subject   string    `json:"subject"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Checksum  string    `json:"sha256"`
+}
+
+// queueBacklog is the backlog of an agent's send queue, as exported by the
+// principal's admin server
+type queueBacklog struct {
+	AgentName string            `json:"agentName"`
+	Events    []json.RawMessage `json:"events"`
+}
+
+// principalBackup is the state of a principal stored in a backup archive
+type principalBackup struct {
+	Manifest     backupManifest
+	Secrets      []corev1.Secret
+	ConfigMaps   []corev1.ConfigMap
+	Certificates []issuedCertificate
+	Queues       []queueBacklog
+}
+
+// numEvents returns the number of queued events in the backup
+func (b *principalBackup) numEvents() int {
+	n := 0
+	for _, q := range b.Queues {
+		n += len(q.Events)
+	}
+	return n
+}
+
+func NewExportCommand() *cobra.Command {
+	var (
+		outputPath string
+		address    string
+		port       int
+		token      string
+		queues     bool
+	)
+	command := &cobra.Command{
+		Short: "Export the state of the principal into a backup archive",
+		Long: `Writes the agent registrations, the principal's certificates and signing
+keys, the metadata of all issued certificates, the principal's configuration
+and the events still queued for agents into a tar.gz archive, which can be
+imported into a fresh principal with the import command.
+
+The archive contains private keys and must be stored securely.
+
+The queued events are read from the principal's admin server, which must be
+enabled with --admin-port. Use --queues=false to skip them.`,
+		Use:  "export",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			clt, err := kube.NewKubernetesClientFromConfig(ctx, principalCfg.Namespace, "", principalCfg.KubeContext)
+			if err != nil {
+				cmdutil.Fatal("Could not create Kubernetes client: %v", err)
+			}
+			backup, err := collectBackup(ctx, clt.Clientset, principalCfg.Namespace)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			if queues {
+				bearer, err := adminToken(token)
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				address, stop, err := adminServerAddress(ctx, address, port)
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				defer stop()
+				backup.Queues, err = exportQueueBacklogs(ctx, address, bearer)
+				if err != nil {
+					cmdutil.Fatal("%v (use --queues=false to skip queued events)", err)
+				}
+			}
+
+			if outputPath == "" {
+				outputPath = fmt.Sprintf("argocd-agent-principal-%s.tar.gz", backup.Manifest.CreatedAt.Format("20060102-150405"))
+			}
+			f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			defer f.Close()
+			if err := writeBackupArchive(f, backup); err != nil {
+				cmdutil.Fatal("Could not write backup archive: %v", err)
+			}
+			fmt.Printf("Exported %d agents, %d certificates and %d queued events to %s\n",
+				len(backup.Manifest.Agents), len(backup.Certificates), backup.numEvents(), outputPath)
+		},
+	}
+	command.Flags().StringVarP(&outputPath, "output", "o", "", "File to write the archive to (default argocd-agent-principal-<timestamp>.tar.gz)")
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's admin server (bypasses kube port-forward)")
+	command.Flags().IntVar(&port, "port", defaultAdminPort, "Port of the principal's admin server")
+	command.Flags().StringVar(&token, "token", "", "Bearer token to authenticate to the principal's admin server (default is the token of the principal's kube context)")
+	command.Flags().BoolVar(&queues, "queues", true, "Export the events queued for agents")
+	return command
+}
+
+func NewImportCommand() *cobra.Command {
+	var (
+		address string
+		port    int
+		token   string
+		queues  bool
+		upsert  bool
+	)
+	command := &cobra.Command{
+		Short: "Import the state of a principal from a backup archive",
+		Long: `Restores the agent registrations, the principal's certificates and signing
+keys and the principal's configuration from an archive written by the export
+command into the principal's namespace. Existing resources are only
+overwritten with --upsert.
+
+The principal has to be restarted to pick up the restored configuration and
+certificates. Afterwards, the events that were queued for agents are queued
+again through the principal's admin server, which must be enabled with
+--admin-port. Use --queues=false to skip them.`,
+		Use:  "import <archive>",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			f, err := os.Open(args[0])
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			defer f.Close()
+			backup, err := readBackupArchive(f)
+			if err != nil {
+				cmdutil.Fatal("Could not read backup archive: %v", err)
+			}
+			clt, err := kube.NewKubernetesClientFromConfig(ctx, principalCfg.Namespace, "", principalCfg.KubeContext)
+			if err != nil {
+				cmdutil.Fatal("Could not create Kubernetes client: %v", err)
+			}
+			if err := restoreBackup(ctx, clt.Clientset, principalCfg.Namespace, backup, upsert); err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			fmt.Printf("Imported %d agents and %d secrets into namespace %s\n",
+				len(backup.Manifest.Agents), len(backup.Secrets), principalCfg.Namespace)
+
+			if !queues || len(backup.Queues) == 0 {
+				return
+			}
+			bearer, err := adminToken(token)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			address, stop, err := adminServerAddress(ctx, address, port)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			defer stop()
+			queued, err := importQueueBacklogs(ctx, address, bearer, backup.Queues)
+			if err != nil {
+				cmdutil.Fatal("%v (use --queues=false to skip queued events)", err)
+			}
+			fmt.Printf("Queued %d events for %d agents\n", queued, len(backup.Queues))
+		},
+	}
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's admin server (bypasses kube port-forward)")
+	command.Flags().IntVar(&port, "port", defaultAdminPort, "Port of the principal's admin server")
+	command.Flags().StringVar(&token, "token", "", "Bearer token to authenticate to the principal's admin server (default is the token of the principal's kube context)")
+	command.Flags().BoolVar(&queues, "queues", true, "Queue the events that were queued for agents again")
+	command.Flags().BoolVar(&upsert, "upsert", false, "Overwrite existing resources")
+	return command
+}
+
+// collectBackup reads the state of the principal installed in namespace
+func collectBackup(ctx context.Context, clientset kubernetes.Interface, namespace string) (*principalBackup, error) {
+	backup := &principalBackup{
+		Manifest: backupManifest{
+			Version:   backupFormatVersion,
+			CreatedAt: time.Now().UTC(),
+			Namespace: namespace,
+			Agents:    []string{},
+		},
+	}
+
+	agents, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: cluster.LabelKeyClusterAgentMapping})
+	if err != nil {
+		return nil, fmt.Errorf("could not list agents: %w", err)
+	}
+	for i := range agents.Items {
+		sec := &agents.Items[i]
+		agentName := sec.Labels[cluster.LabelKeyClusterAgentMapping]
+		backup.Manifest.Agents = append(backup.Manifest.Agents, agentName)
+		backup.Secrets = append(backup.Secrets, backupSecret(sec))
+		clus, err := db.SecretToCluster(sec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster secret %s: %w", sec.Name, err)
+		}
+		if crt, ok := certificateMetadata(sec.Name, clus.Config.CertData); ok {
+			crt.Agent = agentName
+			backup.Certificates = append(backup.Certificates, crt)
+		}
+	}
+	sort.Strings(backup.Manifest.Agents)
+
+	for _, name := range backupSecretNames {
+		sec, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not get secret %s: %w", name, err)
+		}
+		backup.Secrets = append(backup.Secrets, backupSecret(sec))
+		if crt, ok := certificateMetadata(sec.Name, sec.Data[corev1.TLSCertKey]); ok {
+			backup.Certificates = append(backup.Certificates, crt)
+		}
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, principalParamsConfigMap, metav1.GetOptions{})
+	if err == nil {
+		backup.ConfigMaps = append(backup.ConfigMaps, corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: backupObjectMeta(cm.ObjectMeta),
+			Data:       cm.Data,
+		})
+	} else if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not get config map %s: %w", principalParamsConfigMap, err)
+	}
+	return backup, nil
+}
+
+// backupSecret returns sec without its cluster-specific metadata
+func backupSecret(sec *corev1.Secret) corev1.Secret {
+	return corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: backupObjectMeta(sec.ObjectMeta),
+		Type:       sec.Type,
+		Data:       sec.Data,
+	}
+}
+
+// backupObjectMeta returns the parts of meta which are restored on import
+func backupObjectMeta(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        meta.Name,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}
+}
+
+// certificateMetadata returns the metadata of the first certificate in the
+// PEM data certPEM, if there is one
+func certificateMetadata(secretName string, certPEM []byte) (issuedCertificate, bool) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return issuedCertificate{}, false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return issuedCertificate{}, false
+	}
+	return issuedCertificate{
+		Secret:    secretName,
+		Subject:   cert.Subject.String(),
+		Serial:    cert.SerialNumber.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Checksum:  fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
+	}, true
+}
+
+// restoreBackup creates the secrets and config maps of backup in namespace.
+// Unless upsert is set, nothing is restored if any of them already exists.
+func restoreBackup(ctx context.Context, clientset kubernetes.Interface, namespace string, backup *principalBackup, upsert bool) error {
+	secrets := clientset.CoreV1().Secrets(namespace)
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	if !upsert {
+		existing := []string{}
+		for _, sec := range backup.Secrets {
+			if _, err := secrets.Get(ctx, sec.Name, metav1.GetOptions{}); err == nil {
+				existing = append(existing, "secret "+sec.Name)
+			} else if !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		for _, cm := range backup.ConfigMaps {
+			if _, err := configMaps.Get(ctx, cm.Name, metav1.GetOptions{}); err == nil {
+				existing = append(existing, "config map "+cm.Name)
+			} else if !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%s already exist in namespace %s, please use --upsert to overwrite", strings.Join(existing, ", "), namespace)
+		}
+	}
+
+	for i := range backup.Secrets {
+		sec := backup.Secrets[i].DeepCopy()
+		sec.Namespace = namespace
+		_, err := secrets.Create(ctx, sec, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			var cur *corev1.Secret
+			cur, err = secrets.Get(ctx, sec.Name, metav1.GetOptions{})
+			if err == nil {
+				sec.ResourceVersion = cur.ResourceVersion
+				_, err = secrets.Update(ctx, sec, metav1.UpdateOptions{})
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("could not restore secret %s: %w", sec.Name, err)
+		}
+	}
+	for i := range backup.ConfigMaps {
+		cm := backup.ConfigMaps[i].DeepCopy()
+		cm.Namespace = namespace
+		_, err := configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			var cur *corev1.ConfigMap
+			cur, err = configMaps.Get(ctx, cm.Name, metav1.GetOptions{})
+			if err == nil {
+				cm.ResourceVersion = cur.ResourceVersion
+				_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("could not restore config map %s: %w", cm.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeBackupArchive writes backup as a tar.gz archive to w
+func writeBackupArchive(w io.Writer, backup *principalBackup) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, v any) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: backup.Manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	if err := add("manifest.json", backup.Manifest); err != nil {
+		return err
+	}
+	for _, sec := range backup.Secrets {
+		if err := add(path.Join("secrets", sec.Name+".json"), sec); err != nil {
+			return err
+		}
+	}
+	for _, cm := range backup.ConfigMaps {
+		if err := add(path.Join("configmaps", cm.Name+".json"), cm); err != nil {
+			return err
+		}
+	}
+	if err := add("certificates.json", backup.Certificates); err != nil {
+		return err
+	}
+	if err := add("queues.json", backup.Queues); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBackupArchive reads a backup from the tar.gz archive r
+func readBackupArchive(r io.Reader) (*principalBackup, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	backup := &principalBackup{}
+	hasManifest := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxBackupEntrySize {
+			return nil, fmt.Errorf("%s exceeds the maximum size of %d bytes", hdr.Name, maxBackupEntrySize)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		switch dir, _ := path.Split(hdr.Name); {
+		case hdr.Name == "manifest.json":
+			err = json.Unmarshal(data, &backup.Manifest)
+			hasManifest = true
+		case hdr.Name == "certificates.json":
+			err = json.Unmarshal(data, &backup.Certificates)
+		case hdr.Name == "queues.json":
+			err = json.Unmarshal(data, &backup.Queues)
+		case dir == "secrets/":
+			sec := corev1.Secret{}
+			err = json.Unmarshal(data, &sec)
+			backup.Secrets = append(backup.Secrets, sec)
+		case dir == "configmaps/":
+			cm := corev1.ConfigMap{}
+			err = json.Unmarshal(data, &cm)
+			backup.ConfigMaps = append(backup.ConfigMaps, cm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", hdr.Name, err)
+		}
+	}
+	if !hasManifest {
+		return nil, fmt.Errorf("not a principal backup: manifest.json is missing")
+	}
+	if backup.Manifest.Version != backupFormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d, want %d", backup.Manifest.Version, backupFormatVersion)
+	}
+	return backup, nil
+}
+
+// exportQueueBacklogs reads the backlogs of the agents' send queues from the
+// principal's admin server at address
+func exportQueueBacklogs(ctx context.Context, address, token string) ([]queueBacklog, error) {
+	resp, err := queuesRequest(ctx, address, token, http.MethodGet, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not export queued events: %w", err)
+	}
+	defer resp.Body.Close()
+	queues := []queueBacklog{}
+	if err := json.NewDecoder(resp.Body).Decode(&queues); err != nil {
+		return nil, fmt.Errorf("invalid response from principal: %w", err)
+	}
+	return queues, nil
+}
+
+// importQueueBacklogs queues the events of the given backlogs on the
+// principal's admin server at address, and returns the number of events
+// queued
+func importQueueBacklogs(ctx context.Context, address, token string, queues []queueBacklog) (int, error) {
+	body, err := json.Marshal(queues)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := queuesRequest(ctx, address, token, http.MethodPost, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not import queued events: %w", err)
+	}
+	defer resp.Body.Close()
+	result := struct {
+		Events int `json:"events"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("invalid response from principal: %w", err)
+	}
+	return result.Events, nil
+}
+
+// queuesRequest sends a request for the queue backlogs to the principal's
+// admin server and returns the response if it was successful
+func queuesRequest(ctx context.Context, address, token, method string, body io.Reader) (*http.Response, error) {
+	reqURL := url.URL{Scheme: "http", Host: address, Path: "/queues"}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
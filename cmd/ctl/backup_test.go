@@ -0,0 +1,153 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
+	"github.com/argoproj-labs/argocd-agent/test/testutil"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_Backup(t *testing.T) {
+	certPem := testutil.MustReadFile("testdata/001_test_cert.pem")
+	keyPem := testutil.MustReadFile("testdata/001_test_key.pem")
+
+	agentSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:            "cluster-agent-1",
+		Namespace:       "argocd",
+		ResourceVersion: "42",
+	}}
+	require.NoError(t, cluster.ClusterToSecret(&v1alpha1.Cluster{
+		Server: "https://argocd-agent-resource-proxy:9090?agentName=agent-1",
+		Name:   "agent-1",
+		Config: v1alpha1.ClusterConfig{TLSClientConfig: v1alpha1.TLSClientConfig{CertData: certPem, KeyData: keyPem}},
+	}, agentSecret))
+	agentSecret.Labels[cluster.LabelKeyClusterAgentMapping] = "agent-1"
+	caSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.SecretNamePrincipalCA, Namespace: "argocd"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: certPem, corev1.TLSPrivateKeyKey: keyPem},
+	}
+	params := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: principalParamsConfigMap, Namespace: "argocd"},
+		Data:       map[string]string{"principal.namespace": "argocd"},
+	}
+	unrelated := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "argocd"}}
+
+	backup, err := collectBackup(context.TODO(), kubefake.NewSimpleClientset(agentSecret, caSecret, params, unrelated), "argocd")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent-1"}, backup.Manifest.Agents)
+	require.Len(t, backup.Secrets, 2)
+	assert.Empty(t, backup.Secrets[0].ResourceVersion)
+	require.Len(t, backup.ConfigMaps, 1)
+	require.Len(t, backup.Certificates, 2)
+	assert.Equal(t, "agent-1", backup.Certificates[0].Agent)
+	assert.Equal(t, backup.Certificates[0].Checksum, backup.Certificates[1].Checksum)
+	backup.Queues = []queueBacklog{{AgentName: "agent-1", Events: []json.RawMessage{json.RawMessage(`{"id":"1"}`)}}}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeBackupArchive(buf, backup))
+	archive := buf.Bytes()
+
+	t.Run("Archive is read back", func(t *testing.T) {
+		read, err := readBackupArchive(bytes.NewReader(archive))
+		require.NoError(t, err)
+		assert.Equal(t, backup.Manifest.Agents, read.Manifest.Agents)
+		assert.True(t, backup.Manifest.CreatedAt.Equal(read.Manifest.CreatedAt))
+		assert.Equal(t, backup.Secrets, read.Secrets)
+		assert.Equal(t, backup.ConfigMaps, read.ConfigMaps)
+		assert.Equal(t, 1, read.numEvents())
+	})
+
+	t.Run("Other archives are refused", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, writeBackupArchive(buf, &principalBackup{Manifest: backupManifest{Version: 2}}))
+		_, err := readBackupArchive(buf)
+		assert.ErrorContains(t, err, "unsupported backup format version 2")
+		_, err = readBackupArchive(strings.NewReader("foo"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Backup is restored into a fresh principal", func(t *testing.T) {
+		clientset := kubefake.NewSimpleClientset()
+		require.NoError(t, restoreBackup(context.TODO(), clientset, "dr", backup, false))
+		sec, err := clientset.CoreV1().Secrets("dr").Get(context.TODO(), "cluster-agent-1", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, agentSecret.Data, sec.Data)
+		assert.Equal(t, "agent-1", sec.Labels[cluster.LabelKeyClusterAgentMapping])
+		_, err = clientset.CoreV1().ConfigMaps("dr").Get(context.TODO(), principalParamsConfigMap, metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("Existing resources are only overwritten with upsert", func(t *testing.T) {
+		existing := caSecret.DeepCopy()
+		existing.Data = map[string][]byte{}
+		clientset := kubefake.NewSimpleClientset(existing)
+		err := restoreBackup(context.TODO(), clientset, "argocd", backup, false)
+		assert.ErrorContains(t, err, "secret argocd-agent-ca already exist")
+		_, err = clientset.CoreV1().Secrets("argocd").Get(context.TODO(), "cluster-agent-1", metav1.GetOptions{})
+		assert.Error(t, err)
+
+		require.NoError(t, restoreBackup(context.TODO(), clientset, "argocd", backup, true))
+		sec, err := clientset.CoreV1().Secrets("argocd").Get(context.TODO(), config.SecretNamePrincipalCA, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, caSecret.Data, sec.Data)
+	})
+}
+
+func Test_QueueBacklogs(t *testing.T) {
+	var imported []byte
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /queues", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer jane-token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`[{"agentName":"agent-1","events":[{"id":"1"},{"id":"2"}]}]`))
+	})
+	mux.HandleFunc("POST /queues", func(w http.ResponseWriter, r *http.Request) {
+		imported, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"events":2}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	queues, err := exportQueueBacklogs(context.TODO(), address, "jane-token")
+	require.NoError(t, err)
+	require.Len(t, queues, 1)
+	assert.Len(t, queues[0].Events, 2)
+
+	queued, err := importQueueBacklogs(context.TODO(), address, "jane-token", queues)
+	require.NoError(t, err)
+	assert.Equal(t, 2, queued)
+	assert.JSONEq(t, `[{"agentName":"agent-1","events":[{"id":"1"},{"id":"2"}]}]`, string(imported))
+
+	srv.Close()
+	_, err = exportQueueBacklogs(context.TODO(), address, "jane-token")
+	assert.ErrorContains(t, err, "could not export queued events")
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// principalMetricsPort is the port the principal's metrics server listens on
+const principalMetricsPort = 8000
+
+// connectionQuality is the connection quality of an agent as measured by the
+// principal
+type connectionQuality struct {
+	RTT            time.Duration
+	OneWayLatency  time.Duration
+	ClockSkew      time.Duration
+	HasMeasurement bool
+}
+
+// fetchConnectionQuality reads the connection quality of an agent from the
+// metrics of the principal at address. If address is empty, the principal is
+// reached through a port-forward.
+func fetchConnectionQuality(ctx context.Context, address string, agentName string) (*connectionQuality, error) {
+	if address == "" {
+		localPort, stopCh, err := portForwardToPrincipal(ctx, principalMetricsPort)
+		if err != nil {
+			return nil, err
+		}
+		defer close(stopCh)
+		address = fmt.Sprintf("localhost:%d", localPort)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch principal metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch principal metrics: %s", resp.Status)
+	}
+	return parseConnectionQuality(resp.Body, agentName)
+}
+
+func parseConnectionQuality(r io.Reader, agentName string) (*connectionQuality, error) {
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse principal metrics: %w", err)
+	}
+	q := &connectionQuality{}
+	gauge := func(name string) (time.Duration, bool) {
+		mf, ok := families[name]
+		if !ok {
+			return 0, false
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "agent_name" && l.GetValue() == agentName {
+					return time.Duration(m.GetGauge().GetValue() * float64(time.Second)), true
+				}
+			}
+		}
+		return 0, false
+	}
+	q.RTT, q.HasMeasurement = gauge("principal_agent_rtt_seconds")
+	q.OneWayLatency, _ = gauge("principal_agent_one_way_latency_seconds")
+	q.ClockSkew, _ = gauge("principal_agent_clock_skew_seconds")
+	return q, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseConnectionQuality(t *testing.T) {
+	metrics := `# HELP principal_agent_rtt_seconds RTT
+# TYPE principal_agent_rtt_seconds gauge
+principal_agent_rtt_seconds{agent_name="agent-a"} 0.1
+principal_agent_rtt_seconds{agent_name="agent-b"} 0.02
+# HELP principal_agent_one_way_latency_seconds Latency
+# TYPE principal_agent_one_way_latency_seconds gauge
+principal_agent_one_way_latency_seconds{agent_name="agent-a"} 0.05
+# HELP principal_agent_clock_skew_seconds Skew
+# TYPE principal_agent_clock_skew_seconds gauge
+principal_agent_clock_skew_seconds{agent_name="agent-a"} -1.5
+`
+	t.Run("Agent with measurement", func(t *testing.T) {
+		q, err := parseConnectionQuality(strings.NewReader(metrics), "agent-a")
+		require.NoError(t, err)
+		assert.True(t, q.HasMeasurement)
+		assert.Equal(t, 100*time.Millisecond, q.RTT)
+		assert.Equal(t, 50*time.Millisecond, q.OneWayLatency)
+		assert.Equal(t, -1500*time.Millisecond, q.ClockSkew)
+	})
+
+	t.Run("Agent without measurement", func(t *testing.T) {
+		q, err := parseConnectionQuality(strings.NewReader(metrics), "agent-c")
+		require.NoError(t, err)
+		assert.False(t, q.HasMeasurement)
+	})
+}
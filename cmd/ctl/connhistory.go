@@ -0,0 +1,133 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	agentv1alpha1 "github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/spf13/cobra"
+)
+
+// connectionHistory is the connection history of an agent as returned by
+// the principal's admin server
+type connectionHistory struct {
+	Agent     string                           `json:"agent"`
+	Connected bool                             `json:"connected"`
+	Uptime    float64                          `json:"uptime"`
+	History   []agentv1alpha1.ConnectionRecord `json:"history"`
+}
+
+func NewAgentConnectionsCommand() *cobra.Command {
+	var (
+		address      string
+		port         int
+		token        string
+		outputFormat string
+	)
+	command := &cobra.Command{
+		Short: "Show the connection history of an agent",
+		Long: `Shows when an agent connected to and disconnected from the principal, the
+address it connected from, why its connections ended, and the fraction of the
+retention window it was connected.
+
+The history is served by the principal's admin server, which must be enabled
+with --admin-port.`,
+		Use:  "connections <agent>",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			bearer, err := adminToken(token)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			address, stop, err := adminServerAddress(ctx, address, port)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			defer stop()
+			history, err := fetchConnectionHistory(ctx, address, args[0], bearer)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			switch strings.ToLower(outputFormat) {
+			case "json":
+				out, err := json.MarshalIndent(history, "", " ")
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				fmt.Println(string(out))
+			case "text":
+				printConnectionHistory(os.Stdout, history)
+			default:
+				cmdutil.Fatal("Unknown output format: %s", outputFormat)
+			}
+		},
+	}
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's admin server (bypasses kube port-forward)")
+	command.Flags().IntVar(&port, "port", defaultAdminPort, "Port of the principal's admin server")
+	command.Flags().StringVar(&token, "token", "", "Bearer token to authenticate to the principal's admin server (default is the token of the principal's kube context)")
+	command.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format (text or json)")
+	return command
+}
+
+// fetchConnectionHistory reads the connection history of agentName from the
+// principal's admin server at address.
+func fetchConnectionHistory(ctx context.Context, address, agentName, token string) (*connectionHistory, error) {
+	reqURL := url.URL{Scheme: "http", Host: address, Path: "/agents/" + url.PathEscape(agentName) + "/connections"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch connection history: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("could not fetch connection history: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	history := &connectionHistory{}
+	if err := json.NewDecoder(resp.Body).Decode(history); err != nil {
+		return nil, fmt.Errorf("invalid response from principal: %w", err)
+	}
+	return history, nil
+}
+
+func printConnectionHistory(w io.Writer, history *connectionHistory) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEVENT\tREMOTE ADDRESS\tREASON")
+	for _, rec := range history.History {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", rec.Time.UTC().Format(time.RFC3339), rec.Type, rec.RemoteAddress, rec.Reason)
+	}
+	tw.Flush()
+	state := "Disconnected"
+	if history.Connected {
+		state = "Connected"
+	}
+	fmt.Fprintf(w, "\n%s, uptime %.2f%%\n", state, history.Uptime*100)
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fetchConnectionHistory(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /agents/{name}/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") != "agent-1" {
+			http.Error(w, "invalid agent name", http.StatusBadRequest)
+			return
+		}
+		assert.Equal(t, "Bearer jane-token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"agent":"agent-1","connected":true,"uptime":0.75,"history":[
+			{"type":"Connected","time":"2025-06-01T08:00:00Z","remoteAddress":"10.0.0.1:1234"},
+			{"type":"Disconnected","time":"2025-06-01T09:00:00Z","remoteAddress":"10.0.0.1:1234","reason":"agent closed the stream"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("History is fetched and printed", func(t *testing.T) {
+		history, err := fetchConnectionHistory(context.TODO(), address, "agent-1", "jane-token")
+		require.NoError(t, err)
+		assert.True(t, history.Connected)
+		require.Len(t, history.History, 2)
+		assert.Equal(t, "agent closed the stream", history.History[1].Reason)
+
+		out := &bytes.Buffer{}
+		printConnectionHistory(out, history)
+		assert.Contains(t, out.String(), "2025-06-01T09:00:00Z   Disconnected   10.0.0.1:1234    agent closed the stream")
+		assert.Contains(t, out.String(), "Connected, uptime 75.00%")
+	})
+
+	t.Run("Errors of the principal are returned", func(t *testing.T) {
+		_, err := fetchConnectionHistory(context.TODO(), address, "Agent_2", "jane-token")
+		assert.ErrorContains(t, err, "400 Bad Request: invalid agent name")
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultAdminPort is the port the principal's admin server is expected
+	// to listen on
+	defaultAdminPort = 8406
+)
+
+func NewAgentDebugCommand() *cobra.Command {
+	var (
+		address  string
+		port     int
+		token    string
+		commands []string
+	)
+	command := &cobra.Command{
+		Short: "Run diagnostic commands on an agent",
+		Long: `Opens a shell to run diagnostic commands on an agent, such as listing the
+log streams in flight, printing the agent's configuration or resynchronizing it
+with the principal.
+
+The shell is served by the principal's admin server, which must be enabled
+with --admin-port. It only listens on localhost and is reached by
+port-forwarding to the principal's pod. Requests are authenticated with a
+Kubernetes bearer token, whose user needs the create permission on the admin
+resource of the argocd-agent.argoproj-labs.io group in the principal's
+namespace. Every command is recorded with the user name in the principal's
+audit log.`,
+		Use:  "debug <agent>",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			agentName := args[0]
+			ctx := context.Background()
+			bearer, err := adminToken(token)
+			if err != nil {
+				return err
+			}
+			address, stop, err := adminServerAddress(ctx, address, port)
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			shellURL := url.URL{Scheme: "ws", Host: address, Path: "/agents/" + url.PathEscape(agentName) + "/debug"}
+			header := http.Header{}
+			header.Set("Authorization", "Bearer "+bearer)
+			conn, resp, err := websocket.DefaultDialer.DialContext(ctx, shellURL.String(), header)
+			if err != nil {
+				if resp != nil {
+					body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+					return fmt.Errorf("could not open debug shell: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+				}
+				return fmt.Errorf("could not open debug shell: %w", err)
+			}
+			defer conn.Close()
+
+			if len(commands) > 0 {
+				return runDebugShell(conn, strings.NewReader(strings.Join(commands, "\n")+"\n"), cmd.OutOrStdout(), "")
+			}
+			return runDebugShell(conn, os.Stdin, cmd.OutOrStdout(), agentName+"> ")
+		},
+	}
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's admin server (bypasses kube port-forward)")
+	command.Flags().IntVar(&port, "port", defaultAdminPort, "Port of the principal's admin server")
+	command.Flags().StringVar(&token, "token", "", "Bearer token to authenticate to the principal's admin server (default is the token of the principal's kube context)")
+	command.Flags().StringSliceVarP(&commands, "command", "c", nil, "Run the given commands and exit instead of opening an interactive shell")
+	return command
+}
+
+// adminServerAddress returns the address of the principal's admin server. If
+// address is empty, a port-forward to port of the principal's pod is opened,
+// which is closed by calling the returned function.
+func adminServerAddress(ctx context.Context, address string, port int) (string, func(), error) {
+	if address != "" {
+		return address, func() {}, nil
+	}
+	localPort, stopCh, err := portForwardToPrincipal(ctx, port)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("localhost:%d", localPort), func() { close(stopCh) }, nil
+}
+
+// adminToken returns the bearer token used to authenticate to the principal's
+// admin server. It defaults to the token of the principal's kube context.
+func adminToken(token string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	config, err := kube.NewRestConfig("", globalOpts.principalContext)
+	if err != nil {
+		return "", fmt.Errorf("could not load kube config: %w", err)
+	}
+	if config.BearerToken != "" {
+		return config.BearerToken, nil
+	}
+	if config.BearerTokenFile != "" {
+		b, err := os.ReadFile(config.BearerTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read bearer token: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", errors.New("the principal's kube context has no bearer token, use --token, e.g. with a token from kubectl create token")
+}
+
+// runDebugShell sends each line read from in to the debug shell on conn and
+// writes the responses to out. prompt is written before reading each line.
+func runDebugShell(conn *websocket.Conn, in io.Reader, out io.Writer, prompt string) error {
+	read := func() error {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return io.EOF
+			}
+			return fmt.Errorf("debug shell closed: %w", err)
+		}
+		_, err = out.Write(msg)
+		return err
+	}
+
+	// The shell greets us first
+	if err := read(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, prompt)
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+			return err
+		}
+		if err := read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return scanner.Err()
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runDebugShell(t *testing.T) {
+	// echoShell greets the client and echoes each command until it receives
+	// exit
+	echoShell := func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("hello\n"))
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == "exit" {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				return
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("ran "+string(msg)+"\n"))
+		}
+	}
+	dial := func(t *testing.T) *websocket.Conn {
+		t.Helper()
+		srv := httptest.NewServer(http.HandlerFunc(echoShell))
+		t.Cleanup(srv.Close)
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("Commands are sent until input ends", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		err := runDebugShell(dial(t), strings.NewReader("status\n\n inflight \n"), out, "> ")
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n> ran status\n> > ran inflight\n> ", out.String())
+	})
+
+	t.Run("Shell closed by the server", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		err := runDebugShell(dial(t), strings.NewReader("exit\nstatus\n"), out, "")
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", out.String())
+	})
+}
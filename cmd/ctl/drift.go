@@ -0,0 +1,160 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+// driftSummary is the drift of the Applications of an agent as returned by
+// the principal's admin server
+type driftSummary struct {
+	Agent        string             `json:"agent"`
+	Applications []applicationDrift `json:"applications"`
+}
+
+type applicationDrift struct {
+	Name        string         `json:"name"`
+	Namespace   string         `json:"namespace"`
+	UID         string         `json:"uid"`
+	DetectedBy  string         `json:"detectedBy"`
+	DetectedAt  time.Time      `json:"detectedAt"`
+	Occurrences int            `json:"occurrences"`
+	Fields      []driftedField `json:"fields"`
+}
+
+type driftedField struct {
+	Path  string `json:"path"`
+	Hub   string `json:"hub,omitempty"`
+	Spoke string `json:"spoke,omitempty"`
+}
+
+func NewAgentDriftCommand() *cobra.Command {
+	var (
+		address      string
+		port         int
+		token        string
+		outputFormat string
+	)
+	command := &cobra.Command{
+		Short: "Show how applications of an autonomous agent drifted on the principal",
+		Long: `Shows the applications of an autonomous agent whose copies on the principal
+differed from the agent's, which fields differed, and whether the drift was
+found by the agent or by the principal. The principal's copies are always
+overwritten with the agent's, so the drift shown is historical.
+
+The summary is served by the principal's admin server, which must be enabled
+with --admin-port.`,
+		Use:  "drift <agent>",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			bearer, err := adminToken(token)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			address, stop, err := adminServerAddress(ctx, address, port)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			defer stop()
+			summary, err := fetchDriftSummary(ctx, address, args[0], bearer)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			switch strings.ToLower(outputFormat) {
+			case "json":
+				out, err := json.MarshalIndent(summary, "", " ")
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				fmt.Println(string(out))
+			case "text":
+				printDriftSummary(os.Stdout, summary)
+			default:
+				cmdutil.Fatal("Unknown output format: %s", outputFormat)
+			}
+		},
+	}
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's admin server (bypasses kube port-forward)")
+	command.Flags().IntVar(&port, "port", defaultAdminPort, "Port of the principal's admin server")
+	command.Flags().StringVar(&token, "token", "", "Bearer token to authenticate to the principal's admin server (default is the token of the principal's kube context)")
+	command.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format (text or json)")
+	return command
+}
+
+// fetchDriftSummary reads the drift summary of agentName from the
+// principal's admin server at address.
+func fetchDriftSummary(ctx context.Context, address, agentName, token string) (*driftSummary, error) {
+	reqURL := url.URL{Scheme: "http", Host: address, Path: "/agents/" + url.PathEscape(agentName) + "/drift"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch drift summary: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("could not fetch drift summary: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	summary := &driftSummary{}
+	if err := json.NewDecoder(resp.Body).Decode(summary); err != nil {
+		return nil, fmt.Errorf("invalid response from principal: %w", err)
+	}
+	return summary, nil
+}
+
+func printDriftSummary(w io.Writer, summary *driftSummary) {
+	if len(summary.Applications) == 0 {
+		fmt.Fprintf(w, "No drift of applications of agent %s\n", summary.Agent)
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "APPLICATION\tDETECTED BY\tLAST DETECTED\tCOUNT\tFIELD\tPRINCIPAL\tAGENT")
+	for _, app := range summary.Applications {
+		name := app.Namespace + "/" + app.Name
+		for i, f := range app.Fields {
+			if i == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", name, app.DetectedBy, app.DetectedAt.UTC().Format(time.RFC3339), app.Occurrences, f.Path, unsetIfEmpty(f.Hub), unsetIfEmpty(f.Spoke))
+				continue
+			}
+			fmt.Fprintf(tw, "\t\t\t\t%s\t%s\t%s\n", f.Path, unsetIfEmpty(f.Hub), unsetIfEmpty(f.Spoke))
+		}
+	}
+	tw.Flush()
+}
+
+func unsetIfEmpty(v string) string {
+	if v == "" {
+		return "<unset>"
+	}
+	return v
+}
@@ -0,0 +1,64 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fetchDriftSummary(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /agents/{name}/drift", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer jane-token", r.Header.Get("Authorization"))
+		if r.PathValue("name") != "agent-1" {
+			_, _ = w.Write([]byte(`{"agent":"agent-2","applications":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"agent":"agent-1","applications":[
+			{"name":"guestbook","namespace":"agent-1","uid":"1234","detectedBy":"agent","detectedAt":"2025-06-01T08:00:00Z","occurrences":2,
+			 "fields":[{"path":"source.targetRevision","hub":"\"main\"","spoke":"\"v1\""},{"path":"source.path","spoke":"\"guestbook\""}]}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("Drift is fetched and printed", func(t *testing.T) {
+		summary, err := fetchDriftSummary(context.TODO(), address, "agent-1", "jane-token")
+		require.NoError(t, err)
+		require.Len(t, summary.Applications, 1)
+		assert.Equal(t, 2, summary.Applications[0].Occurrences)
+
+		out := &bytes.Buffer{}
+		printDriftSummary(out, summary)
+		assert.Contains(t, out.String(), "agent-1/guestbook   agent         2025-06-01T08:00:00Z   2       source.targetRevision")
+		assert.Contains(t, out.String(), `source.path             <unset>     "guestbook"`)
+	})
+
+	t.Run("Agents without drift are reported", func(t *testing.T) {
+		summary, err := fetchDriftSummary(context.TODO(), address, "agent-2", "jane-token")
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		printDriftSummary(out, summary)
+		assert.Equal(t, "No drift of applications of agent agent-2\n", out.String())
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	"github.com/argoproj-labs/argocd-agent/internal/netpol"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// readEgressReport reads an egress report from a file, from stdin if source
+// is -, or from the agent's debug endpoint if source is a URL
+func readEgressReport(ctx context.Context, source string) (*netpol.Report, error) {
+	var r io.Reader
+	switch {
+	case source == "-":
+		r = os.Stdin
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected response from %s: %s", source, resp.Status)
+		}
+		r = resp.Body
+	default:
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	report := &netpol.Report{}
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, fmt.Errorf("invalid egress report: %w", err)
+	}
+	return report, nil
+}
+
+// NewAgentEgressCommand returns a command that renders the network policy
+// or firewall rules needed by an agent
+func NewAgentEgressCommand() *cobra.Command {
+	var (
+		report    string
+		endpoints []string
+		namespace string
+		output    string
+		name      string
+		podLabels map[string]string
+	)
+	command := &cobra.Command{
+		Short: "Render the egress rules needed by an agent",
+		Long: `Render a NetworkPolicy or a table of egress firewall rules allowing the
+connections an agent needs, and nothing else.
+
+The endpoints are read from the agent's egress report, which the agent serves
+at /debug/egress on its health check port, or given with --endpoint.`,
+		Use: "egress",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			rep := &netpol.Report{Namespace: namespace}
+			if report != "" {
+				var err error
+				rep, err = readEgressReport(ctx, report)
+				if err != nil {
+					cmdutil.Fatal("Could not read egress report: %v", err)
+				}
+				if cmd.Flags().Changed("namespace") {
+					rep.Namespace = namespace
+				}
+			}
+			var extra []netpol.Endpoint
+			for _, e := range endpoints {
+				epName, address, ok := strings.Cut(e, "=")
+				if !ok {
+					cmdutil.Fatal("Invalid endpoint %s: must be name=address", e)
+				}
+				ep, err := netpol.ParseEndpoint(epName, address, 0)
+				if err != nil {
+					cmdutil.Fatal("%v", err)
+				}
+				extra = append(extra, ep)
+			}
+			netpol.Resolve(ctx, extra)
+			rep.Endpoints = append(rep.Endpoints, extra...)
+			if len(rep.Endpoints) == 0 {
+				cmdutil.Fatal("No endpoints given. Use --report or --endpoint.")
+			}
+			switch output {
+			case "networkpolicy":
+				data, err := yaml.Marshal(netpol.NetworkPolicy(name, podLabels, rep))
+				if err != nil {
+					cmdutil.Fatal("Could not render NetworkPolicy: %v", err)
+				}
+				fmt.Print(string(data))
+			case "firewall":
+				fmt.Print(netpol.FirewallRules(rep))
+			default:
+				cmdutil.Fatal("Unknown output format %s: must be networkpolicy or firewall", output)
+			}
+		},
+	}
+	command.Flags().StringVar(&report, "report", "",
+		"Egress report of the agent: a file, - for stdin, or the URL of the agent's /debug/egress endpoint")
+	command.Flags().StringSliceVar(&endpoints, "endpoint", []string{},
+		"Endpoint the agent connects to, as name=host:port or name=URL. May be given multiple times")
+	command.Flags().StringVar(&namespace, "namespace", "argocd", "Namespace the agent runs in")
+	command.Flags().StringVarP(&output, "output", "o", "networkpolicy", "Output format: networkpolicy or firewall")
+	command.Flags().StringVar(&name, "name", "argocd-agent-agent-egress", "Name of the NetworkPolicy")
+	command.Flags().StringToStringVar(&podLabels, "pod-label",
+		map[string]string{"app.kubernetes.io/name": "argocd-agent-agent"}, "Labels selecting the agent's pods")
+	return command
+}
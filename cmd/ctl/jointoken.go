@@ -0,0 +1,128 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/jointoken"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	"github.com/spf13/cobra"
+)
+
+func NewAgentJoinTokenCommand() *cobra.Command {
+	command := &cobra.Command{
+		Short: "Manage one-time join tokens for agents",
+		Use:   "join-token",
+		Run: func(cmd *cobra.Command, args []string) {
+			_ = cmd.Help()
+			os.Exit(1)
+		},
+	}
+	command.AddCommand(NewAgentJoinTokenCreateCommand())
+	command.AddCommand(NewAgentJoinTokenListCommand())
+	command.AddCommand(NewAgentJoinTokenRevokeCommand())
+	return command
+}
+
+func NewAgentJoinTokenCreateCommand() *cobra.Command {
+	var ttl time.Duration
+	command := &cobra.Command{
+		Short: "Create a join token for an agent",
+		Long: `Create a one-time join token for an agent. The agent exchanges the token for a
+client certificate when it first connects to the principal. The principal must
+be started with --enable-join-tokens.`,
+		Use: "create <agent_name>",
+		Run: func(c *cobra.Command, args []string) {
+			if len(args) != 1 {
+				_ = c.Help()
+				os.Exit(1)
+			}
+			ctx := context.TODO()
+			clt, err := kube.NewKubernetesClientFromConfig(ctx, principalCfg.Namespace, "", principalCfg.KubeContext)
+			if err != nil {
+				cmdutil.Fatal("Could not create Kubernetes client: %v", err)
+			}
+			tok, err := jointoken.Create(ctx, clt.Clientset, principalCfg.Namespace, args[0], ttl)
+			if err != nil {
+				cmdutil.Fatal("Could not create join token: %v", err)
+			}
+			fmt.Println(tok.String())
+		},
+	}
+	command.Flags().DurationVar(&ttl, "ttl", 24*time.Hour, "Time until the token expires")
+	return command
+}
+
+func NewAgentJoinTokenListCommand() *cobra.Command {
+	command := &cobra.Command{
+		Short: "List join tokens that have not been used yet",
+		Use:   "list",
+		Run: func(c *cobra.Command, args []string) {
+			ctx := context.TODO()
+			clt, err := kube.NewKubernetesClientFromConfig(ctx, principalCfg.Namespace, "", principalCfg.KubeContext)
+			if err != nil {
+				cmdutil.Fatal("Could not create Kubernetes client: %v", err)
+			}
+			tokens, err := jointoken.List(ctx, clt.Clientset, principalCfg.Namespace)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			if len(tokens) == 0 {
+				fmt.Printf("No join tokens found.\n")
+				return
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(tw, "ID\tAGENT\tEXPIRES\n")
+			for _, t := range tokens {
+				expires := t.Expiration.Format(time.RFC3339)
+				if time.Now().After(t.Expiration) {
+					expires += " (expired)"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", t.ID, t.Agent, expires)
+			}
+			tw.Flush()
+		},
+	}
+	return command
+}
+
+func NewAgentJoinTokenRevokeCommand() *cobra.Command {
+	command := &cobra.Command{
+		Short: "Revoke a join token",
+		Use:   "revoke <token_id>",
+		Run: func(c *cobra.Command, args []string) {
+			if len(args) != 1 {
+				_ = c.Help()
+				os.Exit(1)
+			}
+			ctx := context.TODO()
+			clt, err := kube.NewKubernetesClientFromConfig(ctx, principalCfg.Namespace, "", principalCfg.KubeContext)
+			if err != nil {
+				cmdutil.Fatal("Could not create Kubernetes client: %v", err)
+			}
+			if err := jointoken.Revoke(ctx, clt.Clientset, principalCfg.Namespace, args[0]); err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			fmt.Printf("Join token %s revoked.\n", args[0])
+		},
+	}
+	return command
+}
@@ -53,11 +53,15 @@ func NewRootCommand() *cobra.Command {
 	command.AddCommand(NewPKICommand())
 	command.AddCommand(NewJWTCommand())
 	command.AddCommand(NewHACommand())
+	command.AddCommand(NewResyncCommand())
+	command.AddCommand(NewExportCommand())
+	command.AddCommand(NewImportCommand())
+	command.AddCommand(NewSoakCommand())
 	command.AddCommand(NewVersionCommand())
 	addGlobalFlags(command, globalOpts)
 
 	command.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		skip := map[string]bool{"argocd-agentctl version": true, "argocd-agentctl config create": true}
+		skip := map[string]bool{"argocd-agentctl version": true, "argocd-agentctl config create": true, "argocd-agentctl soak logs": true}
 		if skip[cmd.CommandPath()] {
 			return
 		}
@@ -0,0 +1,63 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	"github.com/argoproj-labs/argocd-agent/internal/rbac"
+	"github.com/spf13/cobra"
+)
+
+// NewAgentRBACCommand returns a command that prints minimal RBAC manifests
+// for the agent
+func NewAgentRBACCommand() *cobra.Command {
+	var (
+		features       []string
+		namespace      string
+		name           string
+		serviceAccount string
+	)
+	var known []string
+	for _, f := range rbac.Features {
+		known = append(known, string(f))
+	}
+	command := &cobra.Command{
+		Short: "Print minimal RBAC manifests for the agent",
+		Long: `Print a Role, ClusterRole and their bindings granting the agent exactly the
+permissions it needs for the given features. The core feature is always
+included.`,
+		Use: "rbac",
+		Run: func(cmd *cobra.Command, args []string) {
+			parsed, err := rbac.ParseFeatures(features)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			out, err := rbac.ManifestsYAML(name, namespace, serviceAccount, parsed...)
+			if err != nil {
+				cmdutil.Fatal("Could not generate manifests: %v", err)
+			}
+			fmt.Print(string(out))
+		},
+	}
+	command.Flags().StringSliceVar(&features, "features", []string{},
+		fmt.Sprintf("Features to grant permissions for, one or more of: %s", strings.Join(known, ", ")))
+	command.Flags().StringVar(&namespace, "namespace", "argocd", "Namespace the agent runs in")
+	command.Flags().StringVar(&name, "name", "argocd-agent-agent", "Name of the generated resources")
+	command.Flags().StringVar(&serviceAccount, "service-account", "argocd-agent-agent", "Service account of the agent")
+	return command
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/cmd/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+// resyncResult is the result of a full resync as returned by the principal's
+// admin server
+type resyncResult struct {
+	Agent        string `json:"agent"`
+	Mode         string `json:"mode"`
+	Applications int    `json:"applications"`
+}
+
+func NewResyncCommand() *cobra.Command {
+	var (
+		address string
+		port    int
+		token   string
+	)
+	command := &cobra.Command{
+		Short: "Fully resynchronize an agent with the principal",
+		Long: `Resynchronizes the state of an agent with the principal, as is done when
+the agent connects for the first time, to recover from divergence without
+restarting any component. The principal re-sends the AppProjects and
+repositories to the agent and requests a resync of the agent's resources. All
+Applications of a managed agent are re-sent as well.
+
+The request is served by the principal's admin server, which must be enabled
+with --admin-port, and is recorded in the principal's audit log.`,
+		Use:  "resync <agent>",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			bearer, err := adminToken(token)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			address, stop, err := adminServerAddress(ctx, address, port)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			defer stop()
+			result, err := requestResync(ctx, address, args[0], bearer)
+			if err != nil {
+				cmdutil.Fatal("%v", err)
+			}
+			fmt.Printf("Requested full resync of %s agent %s", result.Mode, result.Agent)
+			if result.Mode == "managed" {
+				fmt.Printf(", re-sent %d applications", result.Applications)
+			}
+			fmt.Println()
+		},
+	}
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's admin server (bypasses kube port-forward)")
+	command.Flags().IntVar(&port, "port", defaultAdminPort, "Port of the principal's admin server")
+	command.Flags().StringVar(&token, "token", "", "Bearer token to authenticate to the principal's admin server (default is the token of the principal's kube context)")
+	return command
+}
+
+// requestResync asks the principal's admin server at address to fully
+// resynchronize agentName.
+func requestResync(ctx context.Context, address, agentName, token string) (*resyncResult, error) {
+	reqURL := url.URL{Scheme: "http", Host: address, Path: "/agents/" + url.PathEscape(agentName) + "/resync"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not request resync: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("could not request resync: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	result := &resyncResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("invalid response from principal: %w", err)
+	}
+	return result, nil
+}
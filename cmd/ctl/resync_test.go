@@ -0,0 +1,52 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_requestResync(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /agents/{name}/resync", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") != "agent-1" {
+			http.Error(w, "agent is not connected", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "Bearer jane-token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"agent":"agent-1","mode":"managed","applications":3}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("Resync is requested", func(t *testing.T) {
+		result, err := requestResync(context.TODO(), address, "agent-1", "jane-token")
+		require.NoError(t, err)
+		assert.Equal(t, &resyncResult{Agent: "agent-1", Mode: "managed", Applications: 3}, result)
+	})
+
+	t.Run("Errors of the principal are returned", func(t *testing.T) {
+		_, err := requestResync(context.TODO(), address, "agent-2", "jane-token")
+		assert.ErrorContains(t, err, "503 Service Unavailable: agent is not connected")
+	})
+}
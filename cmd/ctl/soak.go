@@ -0,0 +1,123 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/env"
+	"github.com/argoproj-labs/argocd-agent/internal/logsoak"
+	"github.com/spf13/cobra"
+)
+
+func NewSoakCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "soak",
+		Short: "Run soak tests against a running installation",
+		Run: func(cmd *cobra.Command, args []string) {
+			_ = cmd.Usage()
+			os.Exit(1)
+		},
+	}
+	cmd.AddCommand(NewSoakLogsCommand())
+	return cmd
+}
+
+func NewSoakLogsCommand() *cobra.Command {
+	var (
+		cfg              logsoak.Config
+		reconnectCommand string
+		insecure         bool
+		limits           = logsoak.DefaultLimits()
+	)
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Soak-test long-lived follow log streams",
+		Long: `Keeps a number of follow log streams open through the Argo CD API for the
+given duration, and verifies that no stream is interrupted, that no line is
+delivered twice outside of the agent's resume overlap, and that the agent does
+not leak inflight log streams, goroutines or memory.
+
+The agent can be made to reconnect to the principal periodically by specifying
+a command with --reconnect-command, e.g. one that restarts a proxy between the
+agent and the principal.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.HTTPClient = &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						InsecureSkipVerify: insecure,
+					},
+				},
+			}
+			if reconnectCommand != "" {
+				cfg.Reconnect = func(ctx context.Context) error {
+					out, err := exec.CommandContext(ctx, "sh", "-c", reconnectCommand).CombinedOutput()
+					if err != nil {
+						return fmt.Errorf("reconnect command failed: %w: %s", err, out)
+					}
+					return nil
+				}
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			fmt.Printf("Running soak test with %d streams for %s\n", cfg.Streams, cfg.Duration)
+			report, err := logsoak.Run(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			report.Summary(os.Stdout)
+			if err := report.Check(limits); err != nil {
+				return fmt.Errorf("soak test failed:\n%w", err)
+			}
+			fmt.Println("Soak test passed")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&cfg.LogsURL, "logs-url", "",
+		"URL of the Argo CD API's application logs endpoint, including the query selecting the container")
+	cmd.Flags().StringVar(&cfg.Token, "token",
+		env.StringWithDefault("ARGOCD_AUTH_TOKEN", nil, ""),
+		"Argo CD API token to authenticate with")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Skip verification of the Argo CD API's TLS certificate")
+	cmd.Flags().IntVar(&cfg.Streams, "streams", 10, "Number of follow streams to keep open")
+	cmd.Flags().DurationVar(&cfg.Duration, "duration", time.Hour, "How long to keep the streams open")
+	cmd.Flags().DurationVar(&cfg.ResumeOverlap, "resume-overlap", logsoak.DefaultResumeOverlap,
+		"Time within which lines may be delivered twice after a resume")
+	cmd.Flags().StringVar(&cfg.AgentHealthzURL, "agent-healthz-url", "",
+		"Base URL of the agent's healthz server, used to detect leaked log streams")
+	cmd.Flags().StringVar(&cfg.AgentMetricsURL, "agent-metrics-url", "",
+		"URL of the agent's metrics, used to detect goroutine and memory growth")
+	cmd.Flags().DurationVar(&cfg.SampleInterval, "sample-interval", time.Minute, "Interval at which the agent is sampled")
+	cmd.Flags().StringVar(&reconnectCommand, "reconnect-command", "",
+		"Shell command making the agent reconnect to the principal")
+	cmd.Flags().DurationVar(&cfg.ReconnectInterval, "reconnect-interval", 5*time.Minute,
+		"Interval at which the reconnect command is run")
+	cmd.Flags().DurationVar(&cfg.SettleTime, "settle-time", 30*time.Second,
+		"Time to wait for the agent to clean up after closing the streams")
+	cmd.Flags().Float64Var(&limits.MaxGoroutineGrowth, "max-goroutine-growth", limits.MaxGoroutineGrowth,
+		"Number of goroutines the agent may gain over the course of the test")
+	cmd.Flags().Float64Var(&limits.MaxHeapGrowth, "max-heap-growth", limits.MaxHeapGrowth,
+		"Factor by which the agent's heap may grow over the course of the test")
+	_ = cmd.MarkFlagRequired("logs-url")
+	return cmd
+}
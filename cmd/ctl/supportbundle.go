@@ -0,0 +1,169 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/spf13/cobra"
+)
+
+// resourceProxyPort is the port the principal's resource proxy listens on
+const resourceProxyPort = 9090
+
+func NewAgentSupportBundleCommand() *cobra.Command {
+	var (
+		address    string
+		outputPath string
+		namespace  string
+		previous   bool
+		limitBytes int64
+		timeout    time.Duration
+	)
+	command := &cobra.Command{
+		Short: "Download a support bundle with the logs of an application's pods",
+		Long: `Asks the agent to collect the logs of all pods of an application and
+downloads them as a tar.gz archive. The request is sent to the principal's
+resource proxy using the agent's credentials from its cluster secret.`,
+		Use:  "support-bundle <agent> <application>",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			agentName, appName := args[0], args[1]
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			clus, err := loadClusterSecret(agentName)
+			if err != nil {
+				return fmt.Errorf("unable to load agent configuration: %w", err)
+			} else if clus == nil {
+				return fmt.Errorf("no such agent configured: %s", agentName)
+			}
+			httpClient, err := resourceProxyClient(clus)
+			if err != nil {
+				return err
+			}
+
+			if address == "" {
+				localPort, stopCh, err := portForwardToPrincipal(ctx, resourceProxyPort)
+				if err != nil {
+					return err
+				}
+				defer close(stopCh)
+				address = fmt.Sprintf("localhost:%d", localPort)
+			}
+
+			query := url.Values{}
+			if namespace != "" {
+				query.Set("namespace", namespace)
+			}
+			query.Set("previous", strconv.FormatBool(previous))
+			if limitBytes > 0 {
+				query.Set("limitBytes", strconv.FormatInt(limitBytes, 10))
+			}
+			reqURL := url.URL{
+				Scheme:   "https",
+				Host:     address,
+				Path:     "/supportbundle/applications/" + url.PathEscape(appName),
+				RawQuery: query.Encode(),
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+			if err != nil {
+				return err
+			}
+			if clus.Config.BearerToken != "" {
+				req.Header.Set("Authorization", "Bearer "+clus.Config.BearerToken)
+			}
+
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to request support bundle: %w", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+				return fmt.Errorf("failed to get support bundle: %s: %s", resp.Status, body)
+			}
+
+			if outputPath == "" {
+				outputPath = fmt.Sprintf("%s-%s-support-bundle.tar.gz", agentName, appName)
+			}
+			var out io.Writer = os.Stdout
+			if outputPath != "-" {
+				f, err := os.Create(outputPath)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			n, err := io.Copy(out, resp.Body)
+			if err != nil {
+				return fmt.Errorf("failed to download support bundle: %w", err)
+			}
+			if outputPath != "-" {
+				cmd.PrintErrf("Wrote support bundle (%d bytes) to %s\n", n, outputPath)
+			}
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&address, "address", "a", "", "Direct address of the principal's resource proxy (bypasses kube port-forward)")
+	command.Flags().StringVarP(&outputPath, "output", "o", "", "File to write the bundle to, or - for stdout (default <agent>-<application>-support-bundle.tar.gz)")
+	command.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the application on the agent (default is the agent's namespace)")
+	command.Flags().BoolVar(&previous, "previous", true, "Include logs of previous container instances")
+	command.Flags().Int64Var(&limitBytes, "limit-bytes", 0, "Maximum number of log bytes per container (default is decided by the agent)")
+	command.Flags().DurationVarP(&timeout, "timeout", "t", 5*time.Minute, "Timeout for the operation")
+	return command
+}
+
+// resourceProxyClient returns an HTTP client that authenticates to the
+// resource proxy with the credentials of the given cluster. The resource
+// proxy's certificate is verified against the cluster's server name, so that
+// it can be reached through a port-forward.
+func resourceProxyClient(clus *v1alpha1.Cluster) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: clus.Config.Insecure,
+	}
+	if len(clus.Config.CertData) > 0 {
+		cert, err := tls.X509KeyPair(clus.Config.CertData, clus.Config.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(clus.Config.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(clus.Config.CAData) {
+			return nil, fmt.Errorf("invalid CA data in cluster secret")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tlsConfig.ServerName = clus.Config.ServerName
+	if tlsConfig.ServerName == "" {
+		if u, err := url.Parse(clus.Server); err == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gen-openapi writes the OpenAPI definition of the principal's HTTP endpoints
+// to the file given as argument, or to stdout.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/argoproj-labs/argocd-agent/principal"
+)
+
+func main() {
+	def, err := principal.OpenAPIDefinition()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not generate OpenAPI definition: %v\n", err)
+		os.Exit(1)
+	}
+	if len(os.Args) < 2 {
+		_, _ = os.Stdout.Write(def)
+		return
+	}
+	if err := os.WriteFile(os.Args[1], def, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write OpenAPI definition: %v\n", err)
+		os.Exit(1)
+	}
+}
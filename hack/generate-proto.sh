@@ -27,6 +27,7 @@ GENERATE_PATHS="
 	${PROJECT_ROOT}/principal/apis/terminalstream;terminalstreamapi
 	${PROJECT_ROOT}/principal/apis/replication;replicationapi
 	${PROJECT_ROOT}/principal/apis/haadmin;haadminapi
+	${PROJECT_ROOT}/principal/apis/filetransfer;filetransferapi
 "
 
 for p in ${GENERATE_PATHS}; do
@@ -46,6 +46,8 @@ func (m *Manager) SetAgentConnectionStatus(agentName, status appv1.ConnectionSta
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	m.recordConnectionStatus(agentName, status, modifiedAt)
+
 	// Check if we have a mapping for the requested agent
 	cluster := m.mapping(agentName)
 	if cluster == nil {
@@ -53,17 +55,12 @@ func (m *Manager) SetAgentConnectionStatus(agentName, status appv1.ConnectionSta
 		return
 	}
 
-	state := "disconnected"
-	if status == appv1.ConnectionStatusSuccessful {
-		state = "connected"
-	}
-
 	// Update the cluster connection state and time in mapped cluster at principal.
 	if err := m.setClusterInfo(cluster.Server, agentName, cluster.Name,
 		&appv1.ClusterInfo{
 			ConnectionState: appv1.ConnectionState{
 				Status:     status,
-				Message:    fmt.Sprintf("Agent: '%s' is %s with principal", agentName, state),
+				Message:    connectionMessage(agentName, status, IsInMaintenance(cluster)),
 				ModifiedAt: &metav1.Time{Time: modifiedAt},
 			},
 		}); err != nil {
@@ -0,0 +1,211 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/common"
+	appv1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Annotations reflecting the connectivity of an agent on its cluster secret.
+// They allow alerting on disconnected or stale agents with standard tooling,
+// without having to scrape the principal's metrics.
+const (
+	// AnnotationKeyConnectionState is either "connected" or "disconnected"
+	AnnotationKeyConnectionState = "argocd-agent.argoproj-labs.io/connection-state"
+	// AnnotationKeyConnectionStateChangedAt is the RFC 3339 time at which
+	// the connection state last changed.
+	AnnotationKeyConnectionStateChangedAt = "argocd-agent.argoproj-labs.io/connection-state-changed-at"
+	// AnnotationKeySyncState is either "current" or "stale". An agent is
+	// stale if it's connected, but has not sent any data for longer than
+	// the configured threshold.
+	AnnotationKeySyncState = "argocd-agent.argoproj-labs.io/sync-state"
+	// AnnotationKeyLastSyncAt is the RFC 3339 time at which data was last
+	// received from the agent, as of the last change of the sync state.
+	AnnotationKeyLastSyncAt = "argocd-agent.argoproj-labs.io/last-sync-at"
+)
+
+const (
+	ConnectionStateConnected    = "connected"
+	ConnectionStateDisconnected = "disconnected"
+	SyncStateCurrent            = "current"
+	SyncStateStale              = "stale"
+)
+
+// connectivityReconcileInterval is the interval at which the connectivity
+// annotations are checked for staleness.
+const connectivityReconcileInterval = 30 * time.Second
+
+type agentConnectivity struct {
+	connected  bool
+	changedAt  time.Time
+	lastSyncAt time.Time
+}
+
+// EnableConnectivityAnnotations makes the manager maintain the connectivity
+// annotations on the cluster secrets of all agents. An agent is reported as
+// stale when it has not sent data for longer than staleAfter. A staleAfter
+// of 0 disables reporting the sync state. Must be called before Start.
+func (m *Manager) EnableConnectivityAnnotations(staleAfter time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.connectivity = make(map[string]*agentConnectivity)
+	m.reported = make(map[string]map[string]string)
+	m.staleAfter = staleAfter
+	m.connectivityChanged = make(chan struct{}, 1)
+}
+
+// RecordAgentActivity records that data has been received from the agent at
+// the given time.
+func (m *Manager) RecordAgentActivity(agentName string, at time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.connectivity == nil {
+		return
+	}
+	c, ok := m.connectivity[agentName]
+	if !ok {
+		return
+	}
+	if at.After(c.lastSyncAt) {
+		c.lastSyncAt = at
+	}
+}
+
+// recordConnectionStatus must be called with the manager's lock held
+func (m *Manager) recordConnectionStatus(agentName string, status appv1.ConnectionStatus, modifiedAt time.Time) {
+	if m.connectivity == nil {
+		return
+	}
+	connected := status == appv1.ConnectionStatusSuccessful
+	c, ok := m.connectivity[agentName]
+	if !ok {
+		c = &agentConnectivity{}
+		m.connectivity[agentName] = c
+	}
+	if ok && c.connected == connected {
+		return
+	}
+	c.connected = connected
+	c.changedAt = modifiedAt
+	if connected {
+		c.lastSyncAt = modifiedAt
+	}
+	select {
+	case m.connectivityChanged <- struct{}{}:
+	default:
+	}
+}
+
+// connectivityAnnotations returns the annotations for c at the given time
+func (m *Manager) connectivityAnnotations(c *agentConnectivity, now time.Time) map[string]string {
+	a := map[string]string{
+		AnnotationKeyConnectionState:          ConnectionStateDisconnected,
+		AnnotationKeyConnectionStateChangedAt: c.changedAt.UTC().Format(time.RFC3339),
+	}
+	if c.connected {
+		a[AnnotationKeyConnectionState] = ConnectionStateConnected
+	}
+	if m.staleAfter > 0 {
+		a[AnnotationKeySyncState] = SyncStateCurrent
+		if c.connected && now.Sub(c.lastSyncAt) > m.staleAfter {
+			a[AnnotationKeySyncState] = SyncStateStale
+		}
+		a[AnnotationKeyLastSyncAt] = c.lastSyncAt.UTC().Format(time.RFC3339)
+	}
+	return a
+}
+
+// runConnectivityReconciler updates the connectivity annotations whenever
+// the connection state of an agent changes, and periodically to detect
+// staleness.
+func (m *Manager) runConnectivityReconciler() {
+	ticker := time.NewTicker(connectivityReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.connectivityChanged:
+		}
+		m.reconcileConnectivity(m.ctx, time.Now())
+	}
+}
+
+// reconcileConnectivity updates the annotations of all cluster secrets whose
+// connectivity changed since they were last updated.
+func (m *Manager) reconcileConnectivity(ctx context.Context, now time.Time) {
+	m.mutex.RLock()
+	desired := make(map[string]map[string]string, len(m.connectivity))
+	for agent, c := range m.connectivity {
+		a := m.connectivityAnnotations(c, now)
+		if reported, ok := m.reported[agent]; ok {
+			// The time of last sync is only updated along with the sync
+			// state, to avoid writing the secret on every event.
+			if reported[AnnotationKeySyncState] == a[AnnotationKeySyncState] &&
+				reported[AnnotationKeyConnectionState] == a[AnnotationKeyConnectionState] {
+				a[AnnotationKeyLastSyncAt] = reported[AnnotationKeyLastSyncAt]
+			}
+			if maps.Equal(reported, a) {
+				continue
+			}
+		}
+		desired[agent] = a
+	}
+	m.mutex.RUnlock()
+
+	for agent, a := range desired {
+		if err := m.annotateClusterSecret(ctx, agent, a); err != nil {
+			log().WithError(err).Warnf("Could not update connectivity annotations for agent %s", agent)
+			continue
+		}
+		m.mutex.Lock()
+		m.reported[agent] = a
+		m.mutex.Unlock()
+	}
+}
+
+// annotateClusterSecret sets the given annotations on the cluster secret
+// mapped to agentName.
+func (m *Manager) annotateClusterSecret(ctx context.Context, agentName string, annotations map[string]string) error {
+	list, err := m.kubeclient.CoreV1().Secrets(m.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s",
+			common.LabelKeySecretType, common.LabelValueSecretTypeCluster,
+			LabelKeyClusterAgentMapping, agentName),
+	})
+	if err != nil {
+		return err
+	}
+	if len(list.Items) != 1 {
+		return fmt.Errorf("expected exactly one cluster secret, found %d", len(list.Items))
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.kubeclient.CoreV1().Secrets(m.namespace).Patch(ctx, list.Items[0].Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cacheutil "github.com/argoproj/argo-cd/v3/util/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ConnectivityAnnotations(t *testing.T) {
+	ctx := context.Background()
+	_, secret := newClusterSecret(t, "agent-1")
+	clt := kube.NewFakeClientsetWithResources(secret)
+	m, err := NewManager(ctx, "argocd", "", "", cacheutil.RedisCompressionGZip, clt, nil)
+	require.NoError(t, err)
+	m.EnableConnectivityAnnotations(10 * time.Minute)
+
+	annotations := func() map[string]string {
+		s, err := clt.CoreV1().Secrets("argocd").Get(ctx, secretName, metav1.GetOptions{})
+		require.NoError(t, err)
+		return s.Annotations
+	}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.SetAgentConnectionStatus("agent-1", v1alpha1.ConnectionStatusSuccessful, start)
+	m.reconcileConnectivity(ctx, start)
+	a := annotations()
+	assert.Equal(t, ConnectionStateConnected, a[AnnotationKeyConnectionState])
+	assert.Equal(t, "2025-01-01T12:00:00Z", a[AnnotationKeyConnectionStateChangedAt])
+	assert.Equal(t, SyncStateCurrent, a[AnnotationKeySyncState])
+	assert.Equal(t, "2025-01-01T12:00:00Z", a[AnnotationKeyLastSyncAt])
+
+	// Activity alone does not cause the secret to be written
+	m.RecordAgentActivity("agent-1", start.Add(5*time.Minute))
+	m.reconcileConnectivity(ctx, start.Add(6*time.Minute))
+	assert.Equal(t, "2025-01-01T12:00:00Z", annotations()[AnnotationKeyLastSyncAt])
+
+	// No activity for longer than the threshold
+	m.reconcileConnectivity(ctx, start.Add(16*time.Minute))
+	a = annotations()
+	assert.Equal(t, SyncStateStale, a[AnnotationKeySyncState])
+	assert.Equal(t, "2025-01-01T12:05:00Z", a[AnnotationKeyLastSyncAt])
+
+	// Activity resumes
+	m.RecordAgentActivity("agent-1", start.Add(17*time.Minute))
+	m.reconcileConnectivity(ctx, start.Add(17*time.Minute))
+	a = annotations()
+	assert.Equal(t, SyncStateCurrent, a[AnnotationKeySyncState])
+	assert.Equal(t, "2025-01-01T12:17:00Z", a[AnnotationKeyLastSyncAt])
+
+	// Disconnected agents are never stale
+	m.SetAgentConnectionStatus("agent-1", v1alpha1.ConnectionStatusFailed, start.Add(20*time.Minute))
+	m.reconcileConnectivity(ctx, start.Add(60*time.Minute))
+	a = annotations()
+	assert.Equal(t, ConnectionStateDisconnected, a[AnnotationKeyConnectionState])
+	assert.Equal(t, "2025-01-01T12:20:00Z", a[AnnotationKeyConnectionStateChangedAt])
+	assert.Equal(t, SyncStateCurrent, a[AnnotationKeySyncState])
+}
+
+func Test_ConnectivityAnnotationsDisabled(t *testing.T) {
+	ctx := context.Background()
+	_, secret := newClusterSecret(t, "agent-1")
+	clt := kube.NewFakeClientsetWithResources(secret)
+	m, err := NewManager(ctx, "argocd", "", "", cacheutil.RedisCompressionGZip, clt, nil)
+	require.NoError(t, err)
+	m.SetAgentConnectionStatus("agent-1", v1alpha1.ConnectionStatusSuccessful, time.Now())
+	m.RecordAgentActivity("agent-1", time.Now())
+	m.reconcileConnectivity(ctx, time.Now())
+	s, err := clt.CoreV1().Secrets("argocd").Get(ctx, secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, s.Annotations, AnnotationKeyConnectionState)
+}
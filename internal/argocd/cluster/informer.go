@@ -107,6 +107,11 @@ func (m *Manager) onClusterUpdated(old *v1.Secret, new *v1.Secret) {
 		return
 	}
 
+	if IsInMaintenance(c) != (old.Annotations[AnnotationKeyMaintenance] == "true") {
+		log().Infof("Agent %s changed maintenance mode to %v", newAgent, IsInMaintenance(c))
+		m.updateMaintenanceState(newAgent, c)
+	}
+
 	log().Infof("Updated cluster mapping for agent %s", newAgent)
 }
 
@@ -0,0 +1,79 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+
+	appv1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cacheutil "github.com/argoproj/argo-cd/v3/util/cache"
+)
+
+// AnnotationKeyMaintenance puts an agent into maintenance mode when set to
+// "true" on its cluster secret. The principal refuses new interactive
+// requests, such as log streams and terminal sessions, for agents in
+// maintenance mode, while the agent stays connected.
+const AnnotationKeyMaintenance = "argocd-agent.argoproj-labs.io/maintenance"
+
+// IsInMaintenance returns whether the cluster's agent is in maintenance mode
+func IsInMaintenance(c *appv1.Cluster) bool {
+	return c != nil && c.Annotations[AnnotationKeyMaintenance] == "true"
+}
+
+// InMaintenance returns whether the agent with the given name is in
+// maintenance mode
+func (m *Manager) InMaintenance(agentName string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return IsInMaintenance(m.mapping(agentName))
+}
+
+// connectionMessage returns the message describing the connection state of
+// an agent in the cluster info
+func connectionMessage(agentName string, status appv1.ConnectionStatus, maintenance bool) string {
+	state := "disconnected"
+	if status == appv1.ConnectionStatusSuccessful {
+		state = "connected"
+	}
+	msg := fmt.Sprintf("Agent: '%s' is %s with principal", agentName, state)
+	if maintenance {
+		msg += " and in maintenance mode"
+	}
+	return msg
+}
+
+// updateMaintenanceState reflects a change of the agent's maintenance mode in
+// the connection state of the cluster info. The caller must hold the
+// manager's mutex.
+func (m *Manager) updateMaintenanceState(agentName string, c *appv1.Cluster) {
+	if m.clusterCache == nil {
+		return
+	}
+	info := &appv1.ClusterInfo{}
+	if err := m.clusterCache.GetClusterInfo(c.Server, info); err != nil {
+		if !errors.Is(err, cacheutil.ErrCacheMiss) {
+			log().WithError(err).Errorf("Could not get cluster info for agent %s", agentName)
+		}
+		return
+	}
+	if info.ConnectionState.Status == "" {
+		return
+	}
+	info.ConnectionState.Message = connectionMessage(agentName, info.ConnectionState.Status, IsInMaintenance(c))
+	if err := m.setClusterInfo(c.Server, agentName, c.Name, info); err != nil {
+		log().WithError(err).Errorf("Could not update maintenance state of agent %s", agentName)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	appv1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Maintenance(t *testing.T) {
+	miniRedis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer miniRedis.Close()
+
+	agentName, m := setup(t, miniRedis.Addr())
+
+	connectionMessage := func(t *testing.T) string {
+		t.Helper()
+		info := &appv1.ClusterInfo{}
+		require.NoError(t, m.clusterCache.GetClusterInfo(m.mapping(agentName).Server, info))
+		return info.ConnectionState.Message
+	}
+	setMaintenance := func(enabled bool) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		c := m.mapping(agentName).DeepCopy()
+		c.Annotations = map[string]string{}
+		if enabled {
+			c.Annotations[AnnotationKeyMaintenance] = "true"
+		}
+		m.clusters[agentName] = c
+		m.updateMaintenanceState(agentName, c)
+	}
+
+	t.Run("Agent is not in maintenance by default", func(t *testing.T) {
+		assert.False(t, m.InMaintenance(agentName))
+		assert.False(t, m.InMaintenance("unknown"))
+		assert.False(t, IsInMaintenance(nil))
+	})
+
+	t.Run("Maintenance mode is reflected in the connection state", func(t *testing.T) {
+		m.SetAgentConnectionStatus(agentName, appv1.ConnectionStatusSuccessful, time.Now())
+		setMaintenance(true)
+		assert.True(t, m.InMaintenance(agentName))
+		assert.Equal(t, fmt.Sprintf("Agent: '%s' is connected with principal and in maintenance mode", agentName), connectionMessage(t))
+
+		m.SetAgentConnectionStatus(agentName, appv1.ConnectionStatusFailed, time.Now())
+		assert.Equal(t, fmt.Sprintf("Agent: '%s' is disconnected with principal and in maintenance mode", agentName), connectionMessage(t))
+	})
+
+	t.Run("Leaving maintenance mode restores the connection state", func(t *testing.T) {
+		m.SetAgentConnectionStatus(agentName, appv1.ConnectionStatusSuccessful, time.Now())
+		setMaintenance(false)
+		assert.False(t, m.InMaintenance(agentName))
+		assert.Equal(t, fmt.Sprintf("Agent: '%s' is connected with principal", agentName), connectionMessage(t))
+	})
+}
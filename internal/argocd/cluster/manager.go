@@ -66,6 +66,14 @@ type Manager struct {
 	filters *filter.Chain[*v1.Secret]
 
 	clusterCache *appstatecache.Cache
+
+	// connectivity holds the connectivity of each agent. It is only set
+	// if connectivity annotations are enabled.
+	connectivity map[string]*agentConnectivity
+	// reported holds the connectivity annotations last written per agent
+	reported            map[string]map[string]string
+	staleAfter          time.Duration
+	connectivityChanged chan struct{}
 }
 
 // NewManager instantiates and initializes a new Manager.
@@ -152,6 +160,12 @@ func (m *Manager) Start() error {
 		}
 	}()
 
+	m.mutex.RLock()
+	if m.connectivity != nil {
+		go m.runConnectivityReconciler()
+	}
+	m.mutex.RUnlock()
+
 	return m.informer.WaitForSync(ctx)
 }
 
@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 )
@@ -33,6 +34,19 @@ func (m *Manager) mapping(agent string) *v1alpha1.Cluster {
 	return c
 }
 
+// Agents returns the names of all agents that have a cluster mapped to them,
+// sorted by name.
+func (m *Manager) Agents() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	agents := make([]string, 0, len(m.clusters))
+	for agent := range m.clusters {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	return agents
+}
+
 // HasMapping returns true when the manager has a cluster mapping for an agent
 // with the given name.
 func (m *Manager) HasMapping(agent string) bool {
@@ -20,6 +20,11 @@ func Test_ClusterMappings(t *testing.T) {
 		require.True(t, m.HasMapping("agent"))
 		require.Equal(t, "cluster", m.Mapping("agent").Name)
 	})
+	t.Run("Mapped agents are listed", func(t *testing.T) {
+		require.NoError(t, m.MapCluster("another-agent", &v1alpha1.Cluster{Name: "another-cluster"}))
+		require.Equal(t, []string{"agent", "another-agent"}, m.Agents())
+		require.NoError(t, m.UnmapCluster("another-agent"))
+	})
 	t.Run("Agent cannot be mapped again", func(t *testing.T) {
 		err := m.MapCluster("agent", &v1alpha1.Cluster{})
 		require.ErrorIs(t, err, ErrAlreadyMapped)
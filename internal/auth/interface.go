@@ -19,6 +19,8 @@ import "context"
 type AuthSubject struct {
 	ClientID string `json:"clientID"`
 	Mode     string `json:"mode"`
+	// Version is the version the agent reported when it authenticated
+	Version string `json:"version,omitempty"`
 }
 
 // Credentials is a data type for passing arbitrary credentials to auth methods
@@ -0,0 +1,243 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jointoken implements one-time join tokens, which agents exchange
+// for a client certificate when they first connect to the principal.
+//
+// A join token has the form <id>.<secret>. It is stored in a secret in the
+// principal's namespace, which holds the name of the agent the token was
+// issued for, its expiry and a hash of the token's secret. The secret is
+// deleted when the token is used.
+package jointoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LabelKeyJoinToken marks secrets holding a join token
+	LabelKeyJoinToken = "argocd-agent.argoproj-labs.io/join-token"
+
+	secretNamePrefix = "argocd-agent-join-token-"
+
+	keyAgent      = "agent"
+	keyExpiration = "expiration"
+	keySecretHash = "token-secret-hash"
+)
+
+const (
+	idLength     = 6
+	secretLength = 16
+	alphabet     = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+var tokenRegex = regexp.MustCompile(`^([a-z0-9]{6})\.([a-z0-9]{16})$`)
+
+// ErrInvalidToken is returned when a token is malformed, unknown, expired or
+// was used already.
+var ErrInvalidToken = errors.New("invalid join token")
+
+// Token is a join token
+type Token struct {
+	ID     string
+	Secret string
+}
+
+// String returns the token in the form <id>.<secret>
+func (t *Token) String() string {
+	return t.ID + "." + t.Secret
+}
+
+// Info describes a join token stored on the principal
+type Info struct {
+	ID         string
+	Agent      string
+	Expiration time.Time
+}
+
+// Parse parses a token of the form <id>.<secret>
+func Parse(s string) (*Token, error) {
+	m := tokenRegex.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrInvalidToken
+	}
+	return &Token{ID: m[1], Secret: m[2]}, nil
+}
+
+// Generate returns a new random token
+func Generate() (*Token, error) {
+	id, err := randomString(idLength)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString(secretLength)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{ID: id, Secret: secret}, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("could not generate random string: %w", err)
+		}
+		b[i] = alphabet[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func secretName(id string) string {
+	return secretNamePrefix + id
+}
+
+// Create issues a new join token for agent, which is valid for ttl, and
+// stores it in namespace.
+func Create(ctx context.Context, kube kubernetes.Interface, namespace, agent string, ttl time.Duration) (*Token, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive")
+	}
+	if errs := validation.IsDNS1123Label(agent); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid agent name %q: %s", agent, strings.Join(errs, ", "))
+	}
+	tok, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName(tok.ID),
+			Namespace: namespace,
+			Labels:    map[string]string{LabelKeyJoinToken: "true"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			keyAgent:      []byte(agent),
+			keyExpiration: []byte(time.Now().Add(ttl).UTC().Format(time.RFC3339)),
+			keySecretHash: []byte(hash(tok.Secret)),
+		},
+	}
+	if _, err := kube.CoreV1().Secrets(namespace).Create(ctx, sec, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("could not store join token: %w", err)
+	}
+	return tok, nil
+}
+
+func infoFromSecret(sec *corev1.Secret) (*Info, error) {
+	exp, err := time.Parse(time.RFC3339, string(sec.Data[keyExpiration]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiration in %s: %w", sec.Name, err)
+	}
+	return &Info{
+		ID:         sec.Name[len(secretNamePrefix):],
+		Agent:      string(sec.Data[keyAgent]),
+		Expiration: exp,
+	}, nil
+}
+
+// List returns all join tokens stored in namespace, ordered by their ID
+func List(ctx context.Context, kube kubernetes.Interface, namespace string) ([]Info, error) {
+	secrets, err := kube.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelKeyJoinToken + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("could not list join tokens: %w", err)
+	}
+	infos := make([]Info, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		info, err := infoFromSecret(&secrets.Items[i])
+		if err != nil {
+			continue
+		}
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
+
+// Revoke deletes the join token with the given ID
+func Revoke(ctx context.Context, kube kubernetes.Interface, namespace, id string) error {
+	err := kube.CoreV1().Secrets(namespace).Delete(ctx, secretName(id), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("join token %s not found", id)
+	}
+	return err
+}
+
+// Consume validates token and deletes it, so it cannot be used again. It
+// returns the name of the agent the token was issued for. Expired tokens are
+// deleted as well.
+func Consume(ctx context.Context, kube kubernetes.Interface, namespace, token string) (string, error) {
+	tok, err := Parse(token)
+	if err != nil {
+		return "", err
+	}
+	secrets := kube.CoreV1().Secrets(namespace)
+	sec, err := secrets.Get(ctx, secretName(tok.ID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", ErrInvalidToken
+	} else if err != nil {
+		return "", fmt.Errorf("could not get join token: %w", err)
+	}
+	if sec.Labels[LabelKeyJoinToken] != "true" {
+		return "", ErrInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(tok.Secret)), sec.Data[keySecretHash]) != 1 {
+		return "", ErrInvalidToken
+	}
+	info, err := infoFromSecret(sec)
+	if err != nil {
+		return "", err
+	}
+
+	// Deleting with a precondition on the UID makes sure that only one of
+	// several concurrent requests using the same token succeeds.
+	uid := sec.UID
+	err = secrets.Delete(ctx, sec.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return "", ErrInvalidToken
+	} else if err != nil {
+		return "", fmt.Errorf("could not delete join token: %w", err)
+	}
+	if time.Now().After(info.Expiration) {
+		return "", ErrInvalidToken
+	}
+	if info.Agent == "" {
+		return "", ErrInvalidToken
+	}
+	return info.Agent, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jointoken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_Parse(t *testing.T) {
+	tok, err := Generate()
+	require.NoError(t, err)
+	parsed, err := Parse(tok.String())
+	require.NoError(t, err)
+	assert.Equal(t, tok, parsed)
+
+	for _, s := range []string{"", "abcdef", "abcdef.", "ABCDEF.0123456789abcdef", "abcdef.0123456789abcde", "abcdef.0123456789abcdef0"} {
+		_, err := Parse(s)
+		assert.ErrorIs(t, err, ErrInvalidToken, s)
+	}
+}
+
+func Test_CreateAndConsume(t *testing.T) {
+	ctx := context.Background()
+	ns := "argocd"
+
+	t.Run("Token can be used only once", func(t *testing.T) {
+		kubeclient := kube.NewFakeKubeClient(ns)
+		tok, err := Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+		require.NoError(t, err)
+		agent, err := Consume(ctx, kubeclient, ns, tok.String())
+		require.NoError(t, err)
+		assert.Equal(t, "agent-a", agent)
+		_, err = Consume(ctx, kubeclient, ns, tok.String())
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		kubeclient := kube.NewFakeKubeClient(ns)
+		tok, err := Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+		require.NoError(t, err)
+		_, err = Consume(ctx, kubeclient, ns, tok.ID+".0000000000000000")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		// The token must still be usable with the right secret
+		_, err = Consume(ctx, kubeclient, ns, tok.String())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Expired token is rejected and deleted", func(t *testing.T) {
+		kubeclient := kube.NewFakeKubeClient(ns)
+		tok, err := Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+		require.NoError(t, err)
+		sec, err := kubeclient.CoreV1().Secrets(ns).Get(ctx, secretName(tok.ID), metav1.GetOptions{})
+		require.NoError(t, err)
+		sec.Data[keyExpiration] = []byte(time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+		_, err = kubeclient.CoreV1().Secrets(ns).Update(ctx, sec, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		_, err = Consume(ctx, kubeclient, ns, tok.String())
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		_, err = kubeclient.CoreV1().Secrets(ns).Get(ctx, secretName(tok.ID), metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		kubeclient := kube.NewFakeKubeClient(ns)
+		_, err := Create(ctx, kubeclient, ns, "agent-a", 0)
+		assert.Error(t, err)
+		_, err = Create(ctx, kubeclient, ns, "Agent_A", time.Hour)
+		assert.Error(t, err)
+	})
+}
+
+func Test_ListAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	ns := "argocd"
+	kubeclient := kube.NewFakeKubeClient(ns)
+	tok1, err := Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+	require.NoError(t, err)
+	_, err = Create(ctx, kubeclient, ns, "agent-b", time.Hour)
+	require.NoError(t, err)
+
+	tokens, err := List(ctx, kubeclient, ns)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	agents := []string{tokens[0].Agent, tokens[1].Agent}
+	assert.ElementsMatch(t, []string{"agent-a", "agent-b"}, agents)
+
+	require.NoError(t, Revoke(ctx, kubeclient, ns, tok1.ID))
+	assert.Error(t, Revoke(ctx, kubeclient, ns, tok1.ID))
+	_, err = Consume(ctx, kubeclient, ns, tok1.String())
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	tokens, err = List(ctx, kubeclient, ns)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "agent-b", tokens[0].Agent)
+}
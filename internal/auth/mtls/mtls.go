@@ -16,6 +16,7 @@ package mtls
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"regexp"
 
@@ -34,6 +35,9 @@ const (
 	IdentitySourceSubject IdentitySource = "subject"
 	// IdentitySourceURI extracts identity from cert.URIs (e.g., SPIFFE)
 	IdentitySourceURI IdentitySource = "uri"
+	// IdentitySourceCommonName extracts identity from cert.Subject.CommonName.
+	// Without a regex, the common name is the agent ID.
+	IdentitySourceCommonName IdentitySource = "cn"
 )
 
 // MTLSAuthentication implements a mTLS authentication method
@@ -68,14 +72,34 @@ func (m *MTLSAuthentication) Authenticate(ctx context.Context, creds auth.Creden
 		return "", fmt.Errorf("no verified certificates found in TLS cred")
 	}
 	cert := tlsInfo.State.VerifiedChains[0][0]
+	agentID, identityString, err := m.AgentID(cert)
+	if err != nil {
+		return "", err
+	}
+	errs := validation.NameIsDNSLabel(agentID, false)
+	if len(errs) > 0 {
+		return "", fmt.Errorf("invalid agent ID in client certificate: %v", errs)
+	}
+	logrus.WithFields(logrus.Fields{
+		"module":          "mtls",
+		"identity_source": m.IdentitySource,
+		"identity_value":  identityString,
+		"agent_id":        agentID,
+	}).Info("Extracted agent identity from client certificate")
+	return agentID, nil
+}
 
+// AgentID extracts the agent ID from the given client certificate. It returns
+// the agent ID and the certificate field it was extracted from. The agent ID
+// is not validated.
+func (m *MTLSAuthentication) AgentID(cert *x509.Certificate) (string, string, error) {
 	var identityString string
 	var agentID string
 
 	switch m.IdentitySource {
 	case IdentitySourceURI:
 		if len(cert.URIs) == 0 {
-			return "", fmt.Errorf("no URI SANs found in client certificate")
+			return "", "", fmt.Errorf("no URI SANs found in client certificate")
 		}
 		if m.AgentIDRegex != nil {
 			for _, uri := range cert.URIs {
@@ -88,32 +112,35 @@ func (m *MTLSAuthentication) Authenticate(ctx context.Context, creds auth.Creden
 			}
 		}
 		if agentID == "" {
-			return "", fmt.Errorf("no URI SAN matched the agent ID regex pattern")
+			return "", "", fmt.Errorf("no URI SAN matched the agent ID regex pattern")
+		}
+	case IdentitySourceCommonName:
+		identityString = cert.Subject.CommonName
+		agentID = identityString
+		if m.AgentIDRegex != nil {
+			matches := m.AgentIDRegex.FindStringSubmatch(identityString)
+			if len(matches) < 2 {
+				return "", "", fmt.Errorf("certificate common name '%s' does not match the agent ID regex pattern", identityString)
+			}
+			agentID = matches[1]
+		}
+		if agentID == "" {
+			return "", "", fmt.Errorf("agent ID is empty")
 		}
 	default:
 		identityString = cert.Subject.String()
 		if m.AgentIDRegex != nil {
 			matches := m.AgentIDRegex.FindStringSubmatch(identityString)
 			if len(matches) < 2 {
-				return "", fmt.Errorf("certificate subject '%s' does not match the agent ID regex pattern", identityString)
+				return "", "", fmt.Errorf("certificate subject '%s' does not match the agent ID regex pattern", identityString)
 			}
 			agentID = matches[1]
 		}
 		if agentID == "" {
-			return "", fmt.Errorf("agent ID is empty")
+			return "", "", fmt.Errorf("agent ID is empty")
 		}
 	}
-	errs := validation.NameIsDNSLabel(agentID, false)
-	if len(errs) > 0 {
-		return "", fmt.Errorf("invalid agent ID in client certificate: %v", errs)
-	}
-	logrus.WithFields(logrus.Fields{
-		"module":          "mtls",
-		"identity_source": m.IdentitySource,
-		"identity_value":  identityString,
-		"agent_id":        agentID,
-	}).Info("Extracted agent identity from client certificate")
-	return agentID, nil
+	return agentID, identityString, nil
 }
 
 func (m *MTLSAuthentication) Init() error {
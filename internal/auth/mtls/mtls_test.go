@@ -178,6 +178,31 @@ func Test_AuthenticateWithSPIFFE(t *testing.T) {
 	})
 }
 
+func Test_AgentIDFromCommonName(t *testing.T) {
+	t.Run("Common name is the agent ID", func(t *testing.T) {
+		auth := NewMTLSAuthentication(nil, IdentitySourceCommonName)
+		agentID, identity, err := auth.AgentID(&x509.Certificate{Subject: pkix.Name{CommonName: "agent-1", Organization: []string{"org"}}})
+		assert.NoError(t, err)
+		assert.Equal(t, "agent-1", agentID)
+		assert.Equal(t, "agent-1", identity)
+	})
+
+	t.Run("Regex applied to common name", func(t *testing.T) {
+		auth := NewMTLSAuthentication(regexp.MustCompile(`^agent-(.+)$`), IdentitySourceCommonName)
+		agentID, _, err := auth.AgentID(&x509.Certificate{Subject: pkix.Name{CommonName: "agent-east"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "east", agentID)
+		_, _, err = auth.AgentID(&x509.Certificate{Subject: pkix.Name{CommonName: "east"}})
+		assert.ErrorContains(t, err, "does not match the agent ID regex pattern")
+	})
+
+	t.Run("Empty common name", func(t *testing.T) {
+		auth := NewMTLSAuthentication(nil, IdentitySourceCommonName)
+		_, _, err := auth.AgentID(&x509.Certificate{})
+		assert.ErrorContains(t, err, "agent ID is empty")
+	})
+}
+
 func generateContext(verifiedChains [][]*x509.Certificate) context.Context {
 	p := &peer.Peer{
 		AuthInfo: credentials.TLSInfo{
@@ -92,6 +92,9 @@ type Application interface {
 	Delete(ctx context.Context, name string, namespace string, deletionPropagation *DeletionPropagation) error
 	Update(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error)
 	Patch(ctx context.Context, name string, namespace string, patch []byte) (*v1alpha1.Application, error)
+	// Apply applies app with server-side apply as fieldManager, taking
+	// ownership of all fields set in app
+	Apply(ctx context.Context, app *v1alpha1.Application, fieldManager string) (*v1alpha1.Application, error)
 	SupportsPatch() bool
 	StartInformer(ctx context.Context) error
 	EnsureSynced(duration time.Duration) error
@@ -21,6 +21,7 @@ package application
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -32,6 +33,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
 )
 
 var _ backend.Application = &KubernetesBackend{}
@@ -161,6 +163,26 @@ func (be *KubernetesBackend) Patch(ctx context.Context, name string, namespace s
 	return be.appClient.ArgoprojV1alpha1().Applications(namespace).Patch(ctx, name, types.JSONPatchType, patch, v1.PatchOptions{})
 }
 
+// Apply applies app with server-side apply. Conflicts with other field
+// managers are forced, so that fieldManager takes ownership of all fields set
+// in app.
+func (be *KubernetesBackend) Apply(ctx context.Context, app *v1alpha1.Application, fieldManager string) (*v1alpha1.Application, error) {
+	cfg := app.DeepCopy()
+	cfg.APIVersion = v1alpha1.SchemeGroupVersion.String()
+	cfg.Kind = "Application"
+	cfg.ResourceVersion = ""
+	cfg.UID = ""
+	cfg.ManagedFields = nil
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal apply configuration: %w", err)
+	}
+	return be.appClient.ArgoprojV1alpha1().Applications(app.Namespace).Patch(ctx, app.Name, types.ApplyPatchType, data, v1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        ptr.To(true),
+	})
+}
+
 func (be *KubernetesBackend) SupportsPatch() bool {
 	return be.usePatch
 }
@@ -32,6 +32,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
@@ -319,3 +320,34 @@ func Test_Patch(t *testing.T) {
 		assert.Equal(t, &v1alpha1.Application{}, app)
 	})
 }
+
+func Test_Apply(t *testing.T) {
+	apps := mkApps()
+	fakeAppC := fakeappclient.NewSimpleClientset(apps...)
+	k := NewKubernetesBackend(fakeAppC, "", nil, true)
+	app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{
+		Name: "app", Namespace: "ns1", ResourceVersion: "5", UID: "uid",
+		Labels: map[string]string{"foo": "bar"},
+	}}
+	_, err := k.Apply(context.TODO(), app, "argocd-agent")
+	require.NoError(t, err)
+
+	actions := fakeAppC.Actions()
+	require.NotEmpty(t, actions)
+	patch, ok := actions[len(actions)-1].(k8stesting.PatchActionImpl)
+	require.True(t, ok)
+	assert.Equal(t, types.ApplyPatchType, patch.GetPatchType())
+	assert.Equal(t, "argocd-agent", patch.PatchOptions.FieldManager)
+	require.NotNil(t, patch.PatchOptions.Force)
+	assert.True(t, *patch.PatchOptions.Force)
+
+	cfg := &v1alpha1.Application{}
+	require.NoError(t, json.Unmarshal(patch.GetPatch(), cfg))
+	assert.Equal(t, "argoproj.io/v1alpha1", cfg.APIVersion)
+	assert.Equal(t, "Application", cfg.Kind)
+	assert.Empty(t, cfg.ResourceVersion)
+	assert.Empty(t, cfg.UID)
+	assert.Equal(t, map[string]string{"foo": "bar"}, cfg.Labels)
+	// The given app is not modified
+	assert.Equal(t, "5", app.ResourceVersion)
+}
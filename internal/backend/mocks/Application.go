@@ -27,6 +27,66 @@ func (_m *Application) EXPECT() *Application_Expecter {
 	return &Application_Expecter{mock: &_m.Mock}
 }
 
+// Apply provides a mock function with given fields: ctx, app, fieldManager
+func (_m *Application) Apply(ctx context.Context, app *v1alpha1.Application, fieldManager string) (*v1alpha1.Application, error) {
+	ret := _m.Called(ctx, app, fieldManager)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Apply")
+	}
+
+	var r0 *v1alpha1.Application
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *v1alpha1.Application, string) (*v1alpha1.Application, error)); ok {
+		return rf(ctx, app, fieldManager)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *v1alpha1.Application, string) *v1alpha1.Application); ok {
+		r0 = rf(ctx, app, fieldManager)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1alpha1.Application)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *v1alpha1.Application, string) error); ok {
+		r1 = rf(ctx, app, fieldManager)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Application_Apply_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Apply'
+type Application_Apply_Call struct {
+	*mock.Call
+}
+
+// Apply is a helper method to define mock.On call
+//   - ctx context.Context
+//   - app *v1alpha1.Application
+//   - fieldManager string
+func (_e *Application_Expecter) Apply(ctx interface{}, app interface{}, fieldManager interface{}) *Application_Apply_Call {
+	return &Application_Apply_Call{Call: _e.mock.On("Apply", ctx, app, fieldManager)}
+}
+
+func (_c *Application_Apply_Call) Run(run func(ctx context.Context, app *v1alpha1.Application, fieldManager string)) *Application_Apply_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(*v1alpha1.Application), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *Application_Apply_Call) Return(_a0 *v1alpha1.Application, _a1 error) *Application_Apply_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Application_Apply_Call) RunAndReturn(run func(context.Context, *v1alpha1.Application, string) (*v1alpha1.Application, error)) *Application_Apply_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Create provides a mock function with given fields: ctx, app
 func (_m *Application) Create(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error) {
 	ret := _m.Called(ctx, app)
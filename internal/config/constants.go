@@ -42,3 +42,11 @@ const EnvRecvQueueSize = "ARGOCD_AGENT_RECV_QUEUE_SIZE"
 
 // EnvSendQueueSize is the name of the environment variable for setting the size of the queue.
 const EnvSendQueueSize = "ARGOCD_AGENT_SEND_QUEUE_SIZE"
+
+// EnvSendQueueEvictionPolicy is the name of the environment variable for
+// setting which events are dropped when a send queue exceeds its limits.
+const EnvSendQueueEvictionPolicy = "ARGOCD_AGENT_SEND_QUEUE_EVICTION_POLICY"
+
+// EnvSendQueueMaxAge is the name of the environment variable for setting the
+// age after which events waiting in a send queue may be dropped.
+const EnvSendQueueMaxAge = "ARGOCD_AGENT_SEND_QUEUE_MAX_AGE"
@@ -0,0 +1,139 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// AgentConfigApplied is sent by the agent to the principal whenever it
+	// has applied a new generation of its runtime configuration.
+	AgentConfigApplied EventType = TypePrefix + ".agent-config-applied"
+	// AgentConfigPush is sent by the principal to push an AgentConfiguration
+	// to the agent, or to remove it.
+	AgentConfigPush EventType = TypePrefix + ".agent-config-push"
+	// AgentConfigAck is sent by the agent to acknowledge an AgentConfigPush
+	AgentConfigAck EventType = TypePrefix + ".agent-config-ack"
+	// AgentConfigState is sent by the agent after connecting, to report the
+	// pushed configurations it holds.
+	AgentConfigState EventType = TypePrefix + ".agent-config-state"
+)
+
+const TargetAgentConfig EventTarget = "agentConfig"
+
+// AgentConfigReport describes a configuration generation applied by the agent
+type AgentConfigReport struct {
+	// Generation is incremented each time the agent applies a configuration
+	Generation int64 `json:"generation"`
+	// Source is the name of the ConfigMap the configuration was read from
+	Source string `json:"source,omitempty"`
+	// ResourceVersion is the resource version of the source
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Applied holds the keys and values that were applied
+	Applied map[string]string `json:"applied,omitempty"`
+	// Rejected holds the keys that could not be applied, along with the reason
+	Rejected map[string]string `json:"rejected,omitempty"`
+}
+
+// PushedConfig is the data of AgentConfigPush events
+type PushedConfig struct {
+	// Name is the name of the AgentConfiguration
+	Name string `json:"name"`
+	// Hash identifies the configuration
+	Hash string `json:"hash,omitempty"`
+	// Config is the configuration to apply. If nil, the configuration is
+	// removed from the agent.
+	Config *v1alpha1.AgentRuntimeConfig `json:"config,omitempty"`
+}
+
+// PushedConfigAck is the data of AgentConfigAck events
+type PushedConfigAck struct {
+	Name string `json:"name"`
+	Hash string `json:"hash,omitempty"`
+	// Error is set if the agent could not apply the configuration
+	Error string `json:"error,omitempty"`
+}
+
+// PushedConfigState is the data of AgentConfigState events
+type PushedConfigState struct {
+	// Configs maps the names of the configurations held by the agent to
+	// their hashes
+	Configs map[string]string `json:"configs"`
+}
+
+func (evs EventSource) agentConfigEvent(evType EventType, data any) *cloudevents.Event {
+	reqUUID := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(evType.String())
+	cev.SetExtension(eventID, reqUUID)
+	cev.SetExtension(resourceID, reqUUID)
+	cev.SetDataSchema(TargetAgentConfig.String())
+	_ = cev.SetData(cloudevents.ApplicationJSON, data)
+	return &cev
+}
+
+// AgentConfigPushEvent creates an AgentConfigPush event
+func (evs EventSource) AgentConfigPushEvent(push *PushedConfig) *cloudevents.Event {
+	return evs.agentConfigEvent(AgentConfigPush, push)
+}
+
+// AgentConfigAckEvent creates an AgentConfigAck event
+func (evs EventSource) AgentConfigAckEvent(ack *PushedConfigAck) *cloudevents.Event {
+	return evs.agentConfigEvent(AgentConfigAck, ack)
+}
+
+// AgentConfigStateEvent creates an AgentConfigState event
+func (evs EventSource) AgentConfigStateEvent(state *PushedConfigState) *cloudevents.Event {
+	return evs.agentConfigEvent(AgentConfigState, state)
+}
+
+// AgentConfigReportEvent creates an AgentConfigApplied event from the given
+// report
+func (evs EventSource) AgentConfigReportEvent(report *AgentConfigReport) *cloudevents.Event {
+	return evs.agentConfigEvent(AgentConfigApplied, report)
+}
+
+// AgentConfigReport returns the data of an AgentConfigApplied event
+func (ev Event) AgentConfigReport() (*AgentConfigReport, error) {
+	r := &AgentConfigReport{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
+
+// PushedConfig returns the data of an AgentConfigPush event
+func (ev Event) PushedConfig() (*PushedConfig, error) {
+	p := &PushedConfig{}
+	err := ev.event.DataAs(p)
+	return p, err
+}
+
+// PushedConfigAck returns the data of an AgentConfigAck event
+func (ev Event) PushedConfigAck() (*PushedConfigAck, error) {
+	a := &PushedConfigAck{}
+	err := ev.event.DataAs(a)
+	return a, err
+}
+
+// PushedConfigState returns the data of an AgentConfigState event
+func (ev Event) PushedConfigState() (*PushedConfigState, error) {
+	s := &PushedConfigState{}
+	err := ev.event.DataAs(s)
+	return s, err
+}
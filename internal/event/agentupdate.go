@@ -0,0 +1,117 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// AgentUpdateRequested is sent by the principal to ask the agent to
+	// update the image of its own Deployment.
+	AgentUpdateRequested EventType = TypePrefix + ".agent-update-request"
+	// AgentUpdateAck is sent by the agent once it has updated its
+	// Deployment, or could not do so.
+	AgentUpdateAck EventType = TypePrefix + ".agent-update-ack"
+)
+
+const TargetAgentUpdate EventTarget = "agentUpdate"
+
+// AgentUpdateRequest asks an agent to update the image tag of its Deployment
+type AgentUpdateRequest struct {
+	// UUID for request/response correlation
+	UUID string `json:"uuid"`
+	// Tag is the image tag to update to
+	Tag string `json:"tag"`
+	// Payload is the base64 encoded AgentUpdatePayload authorizing the
+	// update
+	Payload string `json:"payload,omitempty"`
+	// Signature is the base64 encoded signature of the decoded Payload,
+	// which the agent verifies with its update key before updating.
+	Signature string `json:"signature,omitempty"`
+	// Operation is the name of the AgentOperation requesting the update
+	Operation string `json:"operation,omitempty"`
+}
+
+// AgentUpdatePayload is the signed document authorizing agents to update
+// to an image. Agents only update if the image repository, the tag and one
+// of the scopes match, and the payload has not expired.
+type AgentUpdatePayload struct {
+	// Repository is the image repository of the agents, without tag
+	Repository string `json:"repository"`
+	// Tag is the image tag to update to
+	Tag string `json:"tag"`
+	// Scopes are the names of the agents, or of the fleets, that may
+	// update to Tag
+	Scopes []string `json:"scopes"`
+	// Expires is the time after which agents no longer accept the payload
+	Expires time.Time `json:"expires"`
+	// AllowDowngrade lets agents update to a tag older than the one they
+	// are running
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+}
+
+// AgentUpdateResult is the data of AgentUpdateAck events
+type AgentUpdateResult struct {
+	// UUID of the request
+	UUID string `json:"uuid"`
+	// PreviousImage is the image the agent ran before the update
+	PreviousImage string `json:"previousImage,omitempty"`
+	// Image is the image the Deployment was updated to
+	Image string `json:"image,omitempty"`
+	// Restarting is true if the Deployment was changed, and the agent is
+	// about to be replaced by a new pod
+	Restarting bool `json:"restarting,omitempty"`
+	// Error is set if the agent could not update
+	Error string `json:"error,omitempty"`
+}
+
+func (evs EventSource) agentUpdateEvent(evType EventType, id string, data any) (*cloudevents.Event, error) {
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(evType.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetAgentUpdate.String())
+	err := cev.SetData(cloudevents.ApplicationJSON, data)
+	return &cev, err
+}
+
+// NewAgentUpdateRequestEvent creates an AgentUpdateRequested event
+func (evs EventSource) NewAgentUpdateRequestEvent(req *AgentUpdateRequest) (*cloudevents.Event, error) {
+	return evs.agentUpdateEvent(AgentUpdateRequested, req.UUID, req)
+}
+
+// AgentUpdateAckEvent creates an AgentUpdateAck event
+func (evs EventSource) AgentUpdateAckEvent(res *AgentUpdateResult) (*cloudevents.Event, error) {
+	return evs.agentUpdateEvent(AgentUpdateAck, res.UUID, res)
+}
+
+// AgentUpdateRequest returns the data of an AgentUpdateRequested event
+func (ev Event) AgentUpdateRequest() (*AgentUpdateRequest, error) {
+	req := &AgentUpdateRequest{}
+	err := ev.event.DataAs(req)
+	return req, err
+}
+
+// AgentUpdateResult returns the data of an AgentUpdateAck event
+func (ev Event) AgentUpdateResult() (*AgentUpdateResult, error) {
+	res := &AgentUpdateResult{}
+	err := ev.event.DataAs(res)
+	return res, err
+}
@@ -0,0 +1,241 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+// EventChunk events carry a part of an event too large to be sent in a
+// single gRPC message. The receiver reassembles the event once it got all of
+// its chunks.
+const EventChunk EventType = TypePrefix + ".event-chunk"
+
+const (
+	chunkTransferExt = "chunktransfer"
+	chunkIndexExt    = "chunkindex"
+	chunkCountExt    = "chunkcount"
+	chunkChecksumExt = "chunkchecksum"
+)
+
+const (
+	// chunkOverhead is the space reserved in each chunk message for the
+	// envelope around the chunk's data
+	chunkOverhead = 4 * 1024
+	// minChunkSize is the smallest amount of data sent per chunk
+	minChunkSize = 1024
+	// maxChunkedEventSize is the largest event accepted in chunks
+	maxChunkedEventSize = 1 << 30
+	// maxChunkCount is the largest number of chunks an event accepted in
+	// chunks can be split into
+	maxChunkCount = (maxChunkedEventSize + minChunkSize - 1) / minChunkSize
+	// maxPendingTransfers is the number of events a peer may be sending in
+	// chunks at the same time
+	maxPendingTransfers = 4
+	// maxPendingChunkBytes is the amount of chunk data a peer may have sent
+	// for events not received completely yet
+	maxPendingChunkBytes = maxChunkedEventSize
+	// chunkTransferTimeout is the time after which the chunks of an event
+	// that was not received completely are dropped
+	chunkTransferTimeout = 5 * time.Minute
+)
+
+// ErrInvalidChunk is returned for chunks that cannot be reassembled
+var ErrInvalidChunk = errors.New("invalid event chunk")
+
+// IsChunk returns whether pev is a chunk of a larger event
+func IsChunk(pev *pb.CloudEvent) bool {
+	return pev.GetType() == EventChunk.String()
+}
+
+// Chunk splits pev into chunk events whose messages don't exceed maxSize
+// bytes. Each chunk carries the ID of the transfer, its index, the number of
+// chunks and the SHA-256 checksum of the complete event.
+func Chunk(pev *pb.CloudEvent, maxSize int) ([]*pb.CloudEvent, error) {
+	data, err := proto.Marshal(pev)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal event: %w", err)
+	}
+	if len(data) > maxChunkedEventSize {
+		return nil, fmt.Errorf("event of %d bytes exceeds the maximum size of %d bytes", len(data), maxChunkedEventSize)
+	}
+	size := max(maxSize-chunkOverhead, minChunkSize)
+	count := (len(data) + size - 1) / size
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	transfer := uuid.NewString()
+
+	chunks := make([]*pb.CloudEvent, 0, count)
+	for i := 0; i < count; i++ {
+		cev := cloudevents.NewEvent()
+		cev.SetID(uuid.NewString())
+		cev.SetSource(pev.GetSource())
+		cev.SetSpecVersion(cloudEventSpecVersion)
+		cev.SetType(EventChunk.String())
+		cev.SetDataSchema(TargetEventChunk.String())
+		cev.SetExtension(chunkTransferExt, transfer)
+		cev.SetExtension(chunkIndexExt, i)
+		cev.SetExtension(chunkCountExt, count)
+		cev.SetExtension(chunkChecksumExt, checksum)
+		if err := cev.SetData("application/octet-stream", data[i*size:min((i+1)*size, len(data))]); err != nil {
+			return nil, err
+		}
+		cpev, err := format.ToProto(&cev)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, cpev)
+	}
+	return chunks, nil
+}
+
+// ChunkAssembler reassembles events received in chunks. Chunks of an event
+// are sent on a single stream, so one ChunkAssembler is used per stream.
+type ChunkAssembler struct {
+	mu        sync.Mutex
+	transfers map[string]*chunkTransfer
+	// pending is the size of the chunks of all transfers
+	pending int
+}
+
+type chunkTransfer struct {
+	// chunks holds the chunks received so far by their index. It is not
+	// preallocated, so that the count announced by the peer doesn't decide
+	// the memory allocated before any data arrived.
+	chunks   map[int][]byte
+	count    int
+	size     int
+	checksum string
+	started  time.Time
+}
+
+// NewChunkAssembler returns a new ChunkAssembler
+func NewChunkAssembler() *ChunkAssembler {
+	return &ChunkAssembler{transfers: make(map[string]*chunkTransfer)}
+}
+
+// Assemble returns pev as is if it is not a chunk. For chunks, it returns
+// the reassembled event once all of its chunks were received, and nil
+// before.
+func (a *ChunkAssembler) Assemble(pev *pb.CloudEvent) (*pb.CloudEvent, error) {
+	if !IsChunk(pev) {
+		return pev, nil
+	}
+	cev, err := format.FromProto(pev)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	ext := cev.Extensions()
+	transferID, _ := ext[chunkTransferExt].(string)
+	checksum, _ := ext[chunkChecksumExt].(string)
+	index, err := types.ToInteger(ext[chunkIndexExt])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	count, err := types.ToInteger(ext[chunkCountExt])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	if transferID == "" || checksum == "" || count <= 0 || count > maxChunkCount || index < 0 || index >= count {
+		return nil, fmt.Errorf("%w: transfer %q, chunk %d of %d", ErrInvalidChunk, transferID, index, count)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(time.Now())
+
+	t, ok := a.transfers[transferID]
+	if !ok {
+		if len(a.transfers) >= maxPendingTransfers {
+			return nil, fmt.Errorf("%w: more than %d events are being received in chunks", ErrInvalidChunk, maxPendingTransfers)
+		}
+		t = &chunkTransfer{chunks: make(map[int][]byte), count: int(count), checksum: checksum, started: time.Now()}
+		a.transfers[transferID] = t
+	}
+	if t.count != int(count) || t.checksum != checksum {
+		a.drop(transferID)
+		return nil, fmt.Errorf("%w: chunk %d of transfer %s does not match the previous ones", ErrInvalidChunk, index, transferID)
+	}
+	if _, ok := t.chunks[int(index)]; !ok {
+		data := cev.Data()
+		if t.size+len(data) > maxChunkedEventSize {
+			a.drop(transferID)
+			return nil, fmt.Errorf("%w: transfer %s exceeds the maximum size of %d bytes", ErrInvalidChunk, transferID, maxChunkedEventSize)
+		}
+		if a.pending+len(data) > maxPendingChunkBytes {
+			a.drop(transferID)
+			return nil, fmt.Errorf("%w: chunks of pending events exceed %d bytes", ErrInvalidChunk, maxPendingChunkBytes)
+		}
+		t.chunks[int(index)] = data
+		t.size += len(data)
+		a.pending += len(data)
+	}
+	if len(t.chunks) < t.count {
+		return nil, nil
+	}
+
+	a.drop(transferID)
+	data := make([]byte, 0, t.size)
+	for i := range t.count {
+		data = append(data, t.chunks[i]...)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != t.checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch for transfer %s", ErrInvalidChunk, transferID)
+	}
+	res := &pb.CloudEvent{}
+	if err := proto.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	return res, nil
+}
+
+// Pending returns the number of events of which chunks were received, but
+// not all of them yet
+func (a *ChunkAssembler) Pending() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.transfers)
+}
+
+// expire drops transfers that did not complete in time. The caller must
+// hold a.mu.
+func (a *ChunkAssembler) expire(now time.Time) {
+	for id, t := range a.transfers {
+		if now.Sub(t.started) > chunkTransferTimeout {
+			a.drop(id)
+		}
+	}
+}
+
+// drop forgets the chunks of a transfer. The caller must hold a.mu.
+func (a *ChunkAssembler) drop(transferID string) {
+	if t, ok := a.transfers[transferID]; ok {
+		a.pending -= t.size
+		delete(a.transfers, transferID)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/eventstreamapi"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type recordingStream struct {
+	mu   sync.Mutex
+	sent []*pb.CloudEvent
+}
+
+func (s *recordingStream) Send(ev *eventstreamapi.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, ev.Event)
+	return nil
+}
+
+func (s *recordingStream) Context() context.Context {
+	return context.Background()
+}
+
+func largeApp() *v1alpha1.Application {
+	return &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "argocd", UID: "1234"},
+		Spec: v1alpha1.ApplicationSpec{
+			Source: &v1alpha1.ApplicationSource{
+				Helm: &v1alpha1.ApplicationSourceHelm{Values: strings.Repeat("replicas: 1\n", 2000)},
+			},
+		},
+	}
+}
+
+func Test_ChunkedTransfer(t *testing.T) {
+	es := NewEventSource("test")
+
+	t.Run("Large events are sent in chunks and reassembled", func(t *testing.T) {
+		s := &recordingStream{}
+		ew := NewEventWriter("agent", s)
+		ew.SetMaxMessageSize(8 * 1024)
+		ev := es.ApplicationEvent(SpecUpdate, largeApp())
+		ew.Add(ev)
+		ew.sendUnsentEvent(ResourceID(ev))
+		require.Greater(t, len(s.sent), 1)
+
+		a := NewChunkAssembler()
+		var res *pb.CloudEvent
+		for i, c := range s.sent {
+			require.True(t, IsChunk(c))
+			assert.LessOrEqual(t, proto.Size(&eventstreamapi.Event{Event: c}), 8*1024)
+			pev, err := a.Assemble(c)
+			require.NoError(t, err)
+			if i < len(s.sent)-1 {
+				assert.Nil(t, pev)
+				assert.Equal(t, 1, a.Pending())
+			} else {
+				res = pev
+			}
+		}
+		require.NotNil(t, res)
+		assert.Equal(t, 0, a.Pending())
+		got, err := FromWire(res)
+		require.NoError(t, err)
+		assert.Equal(t, EventID(ev), got.EventID())
+		app, err := got.Application()
+		require.NoError(t, err)
+		assert.Equal(t, largeApp().Spec.Source.Helm.Values, app.Spec.Source.Helm.Values)
+	})
+
+	t.Run("Small events are sent as is", func(t *testing.T) {
+		s := &recordingStream{}
+		ew := NewEventWriter("agent", s)
+		ew.SetMaxMessageSize(1024 * 1024)
+		ev := es.ApplicationEvent(SpecUpdate, largeApp())
+		ew.Add(ev)
+		ew.sendUnsentEvent(ResourceID(ev))
+		require.Len(t, s.sent, 1)
+		assert.False(t, IsChunk(s.sent[0]))
+		pev, err := NewChunkAssembler().Assemble(s.sent[0])
+		require.NoError(t, err)
+		assert.Same(t, s.sent[0], pev)
+	})
+
+	t.Run("Peers without support get the event as is", func(t *testing.T) {
+		s := &recordingStream{}
+		ew := NewEventWriter("agent", s)
+		ew.SetMaxMessageSize(8 * 1024)
+		ew.SetSchemaVersion(SchemaVersion5)
+		ev := es.ApplicationEvent(SpecUpdate, largeApp())
+		ew.Add(ev)
+		ew.sendUnsentEvent(ResourceID(ev))
+		require.Len(t, s.sent, 1)
+		assert.False(t, IsChunk(s.sent[0]))
+	})
+
+	t.Run("Chunks may arrive out of order", func(t *testing.T) {
+		pev, err := toWire(es.ApplicationEvent(SpecUpdate, largeApp()), CurrentSchemaVersion)
+		require.NoError(t, err)
+		chunks, err := Chunk(pev, 4*1024)
+		require.NoError(t, err)
+		require.Greater(t, len(chunks), 2)
+		a := NewChunkAssembler()
+		var res *pb.CloudEvent
+		for i := len(chunks) - 1; i >= 0; i-- {
+			res, err = a.Assemble(chunks[i])
+			require.NoError(t, err)
+		}
+		require.NotNil(t, res)
+		assert.Equal(t, pev.Id, res.Id)
+	})
+
+	t.Run("Corrupted chunks are rejected", func(t *testing.T) {
+		pev, err := toWire(es.ApplicationEvent(SpecUpdate, largeApp()), CurrentSchemaVersion)
+		require.NoError(t, err)
+		chunks, err := Chunk(pev, 4*1024)
+		require.NoError(t, err)
+		data := chunks[0].GetBinaryData()
+		data[len(data)-1] ^= 0xff
+		a := NewChunkAssembler()
+		for _, c := range chunks[:len(chunks)-1] {
+			_, err := a.Assemble(c)
+			require.NoError(t, err)
+		}
+		_, err = a.Assemble(chunks[len(chunks)-1])
+		require.ErrorIs(t, err, ErrInvalidChunk)
+		assert.Equal(t, 0, a.Pending())
+	})
+	t.Run("Chunks announcing too many chunks are rejected", func(t *testing.T) {
+		pev, err := toWire(es.ApplicationEvent(SpecUpdate, largeApp()), CurrentSchemaVersion)
+		require.NoError(t, err)
+		chunks, err := Chunk(pev, 4*1024)
+		require.NoError(t, err)
+		chunks[0].Attributes[chunkCountExt] = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeInteger{CeInteger: math.MaxInt32},
+		}
+		a := NewChunkAssembler()
+		_, err = a.Assemble(chunks[0])
+		require.ErrorIs(t, err, ErrInvalidChunk)
+		assert.Equal(t, 0, a.Pending())
+	})
+
+	t.Run("Number of events received in chunks at once is limited", func(t *testing.T) {
+		a := NewChunkAssembler()
+		for i := range maxPendingTransfers + 1 {
+			pev, err := toWire(es.ApplicationEvent(SpecUpdate, largeApp()), CurrentSchemaVersion)
+			require.NoError(t, err)
+			chunks, err := Chunk(pev, 4*1024)
+			require.NoError(t, err)
+			_, err = a.Assemble(chunks[0])
+			if i < maxPendingTransfers {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrInvalidChunk)
+			}
+		}
+		assert.Equal(t, maxPendingTransfers, a.Pending())
+	})
+}
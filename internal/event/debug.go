@@ -0,0 +1,76 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// DebugCommandRequested is sent by the principal to ask an agent to run a
+// diagnostic command on behalf of an operator.
+const DebugCommandRequested EventType = TypePrefix + ".debug-command-request"
+
+const TargetDebugCommand EventTarget = "debugcommand"
+
+// The diagnostic commands agents run on request. Agents refuse to run any
+// other command.
+const (
+	// DebugCommandStatus prints the state of the agent and its connection
+	DebugCommandStatus = "status"
+	// DebugCommandInflight prints the long-running operations in flight
+	DebugCommandInflight = "inflight"
+	// DebugCommandConfig prints the effective configuration of the agent
+	DebugCommandConfig = "config"
+	// DebugCommandResync resynchronizes the agent's resources with the
+	// principal, as is done when the agent starts
+	DebugCommandResync = "resync"
+)
+
+// DebugCommands lists all diagnostic commands
+var DebugCommands = []string{DebugCommandStatus, DebugCommandInflight, DebugCommandConfig, DebugCommandResync}
+
+// DebugCommandRequest asks an agent to run a diagnostic command and to send
+// its output back over the FileTransfer stream.
+type DebugCommandRequest struct {
+	// UUID for request/response correlation
+	UUID string `json:"uuid"`
+	// Command is one of DebugCommands
+	Command string `json:"command"`
+	// RequestedBy is the operator who runs the command, as reported by the
+	// admin client
+	RequestedBy string `json:"requestedBy,omitempty"`
+}
+
+// NewDebugCommandRequestEvent creates a cloud event for running a diagnostic
+// command on an agent.
+func (evs EventSource) NewDebugCommandRequestEvent(req *DebugCommandRequest) (*cloudevents.Event, error) {
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(DebugCommandRequested.String())
+	cev.SetDataSchema(TargetDebugCommand.String())
+	cev.SetExtension(resourceID, req.UUID)
+	cev.SetExtension(eventID, req.UUID)
+	err := cev.SetData(cloudevents.ApplicationJSON, req)
+	return &cev, err
+}
+
+// DebugCommandRequest gets the diagnostic command request payload from an
+// event.
+func (ev Event) DebugCommandRequest() (*DebugCommandRequest, error) {
+	req := &DebugCommandRequest{}
+	err := ev.event.DataAs(req)
+	return req, err
+}
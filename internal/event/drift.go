@@ -0,0 +1,72 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// DriftReported is sent by autonomous agents to the principal when the
+// principal's copy of an Application differs from the agent's, before the
+// agent overwrites the principal's copy with its own.
+const DriftReported EventType = TypePrefix + ".drift"
+
+const TargetDrift EventTarget = "drift"
+
+// DriftedField is a field of an Application's spec whose value differs
+// between the principal and the agent
+type DriftedField struct {
+	// Path is the JSON path of the field, e.g. source.targetRevision
+	Path string `json:"path"`
+	// Hub and Spoke are the JSON encoded values of the field on the
+	// principal and the agent. They are empty if the field is not set.
+	Hub   string `json:"hub,omitempty"`
+	Spoke string `json:"spoke,omitempty"`
+}
+
+// DriftReport is the data of DriftReported events
+type DriftReport struct {
+	// Name, Namespace and UID are those of the Application on the agent
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	UID       string `json:"uid"`
+	// DetectedAt is the time the agent compared the specs
+	DetectedAt time.Time      `json:"detectedAt"`
+	Fields     []DriftedField `json:"fields"`
+}
+
+// DriftEvent creates a DriftReported event from report
+func (evs EventSource) DriftEvent(report *DriftReport) *cloudevents.Event {
+	id := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(DriftReported.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetDrift.String())
+	_ = cev.SetData(cloudevents.ApplicationJSON, report)
+	return &cev
+}
+
+// DriftReport returns the data of a DriftReported event
+func (ev Event) DriftReport() (*DriftReport, error) {
+	r := &DriftReport{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
@@ -15,6 +15,7 @@
 package event
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -52,6 +53,7 @@ const (
 	Delete                     EventType = TypePrefix + ".delete"
 	SpecUpdate                 EventType = TypePrefix + ".spec-update"
 	StatusUpdate               EventType = TypePrefix + ".status-update"
+	SyncProgress               EventType = TypePrefix + ".sync-progress"
 	SetOperation               EventType = TypePrefix + ".set-operation"
 	TerminateOperation         EventType = TypePrefix + ".terminate-operation"
 	EventProcessed             EventType = TypePrefix + ".processed"
@@ -63,8 +65,12 @@ const (
 	ResponseSyncedResource     EventType = TypePrefix + ".response-synced-resource"
 	EventRequestUpdate         EventType = TypePrefix + ".request-update"
 	EventRequestResourceResync EventType = TypePrefix + ".request-resource-resync"
+	EventResyncBookmarks       EventType = TypePrefix + ".resync-bookmarks"
 	ClusterCacheInfoUpdate     EventType = TypePrefix + ".cluster-cache-info-update"
 	TerminalRequest            EventType = TypePrefix + ".terminal-request"
+	EventRequestSupportBundle  EventType = TypePrefix + ".support-bundle-request"
+	EventRequestMetrics        EventType = TypePrefix + ".metrics-request"
+	Drain                      EventType = TypePrefix + ".drain"
 )
 
 const (
@@ -82,6 +88,10 @@ const (
 	TargetHeartbeat              EventTarget = "heartbeat"
 	TargetTerminal               EventTarget = "terminal"
 	TargetApplicationSet         EventTarget = "applicationset"
+	TargetSupportBundle          EventTarget = "supportbundle"
+	TargetMetrics                EventTarget = "metrics"
+	TargetEventChunk             EventTarget = "eventChunk"
+	TargetResourceFilter         EventTarget = "resourceFilter"
 )
 
 const (
@@ -89,6 +99,7 @@ const (
 	eventID      string = "eventid"
 	sentAt       string = "sentat"
 	principalUID string = "principaluid"
+	expiresAt    string = "expiresat"
 )
 
 // SetSentAt stamps the current time on an event as the send time.
@@ -109,6 +120,32 @@ func SentAt(ev *cloudevents.Event) *time.Time {
 	return &t
 }
 
+// SetTTL sets the time after which an event is stale and must not be
+// delivered anymore, e.g. because the client waiting for its response gave up.
+func SetTTL(ev *cloudevents.Event, ttl time.Duration) {
+	ev.SetExtension(expiresAt, time.Now().Add(ttl).UTC().Format(time.RFC3339Nano))
+}
+
+// ExpiresAt returns the time after which an event is stale, or nil if it
+// never expires.
+func ExpiresAt(ev *cloudevents.Event) *time.Time {
+	val, ok := ev.Extensions()[expiresAt].(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// IsExpired returns whether the TTL of an event has passed at now
+func IsExpired(ev *cloudevents.Event, now time.Time) bool {
+	exp := ExpiresAt(ev)
+	return exp != nil && now.After(*exp)
+}
+
 // SetPrincipalUID stamps the principal's persistent identity on an event.
 func SetPrincipalUID(ev *cloudevents.Event, uid string) {
 	ev.SetExtension(principalUID, uid)
@@ -195,6 +232,23 @@ func (evs EventSource) ApplicationEvent(evType EventType, app *v1alpha1.Applicat
 	return &cev
 }
 
+// SyncProgressEvent creates an event carrying only the operation state of the
+// given application, which is sent by managed agents while a sync is running
+// instead of the whole status.
+func (evs EventSource) SyncProgressEvent(app *v1alpha1.Application) *cloudevents.Event {
+	progress := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            app.Name,
+			Namespace:       app.Namespace,
+			UID:             app.UID,
+			ResourceVersion: app.ResourceVersion,
+			Annotations:     app.Annotations,
+		},
+	}
+	progress.Status.OperationState = app.Status.OperationState
+	return evs.ApplicationEvent(SyncProgress, progress)
+}
+
 func createResourceID(res v1.ObjectMeta) string {
 	return fmt.Sprintf("%s_%s", res.Name, res.UID)
 }
@@ -288,6 +342,94 @@ func (evs EventSource) HeartbeatEvent(evType EventType) *cloudevents.Event {
 	return &cev
 }
 
+// ConnectionProbe carries the timestamps of a ping/pong exchange, which is
+// used to measure the quality of the connection between principal and agent.
+// The principal sends a ping, and the agent echoes it in the pong along with
+// its own timestamps. The send times are stamped by the event writer right
+// before the ping or pong is sent.
+type ConnectionProbe struct {
+	// PingSentAt is the time the ping was sent, on the sender's clock
+	PingSentAt time.Time `json:"pingSentAt"`
+	// PingReceivedAt is the time the ping was received, on the responder's clock
+	PingReceivedAt time.Time `json:"pingReceivedAt,omitzero"`
+	// PongSentAt is the time the pong was sent, on the responder's clock
+	PongSentAt time.Time `json:"pongSentAt,omitzero"`
+}
+
+// Measure calculates the round trip time and the clock offset of the
+// responder from a pong received at pongReceivedAt. The round trip time does
+// not include the time the responder took to answer. A positive offset means
+// that the responder's clock is ahead of the sender's.
+func (p *ConnectionProbe) Measure(pongReceivedAt time.Time) (rtt, offset time.Duration) {
+	rtt = pongReceivedAt.Sub(p.PingSentAt) - p.PongSentAt.Sub(p.PingReceivedAt)
+	offset = (p.PingReceivedAt.Sub(p.PingSentAt) + p.PongSentAt.Sub(pongReceivedAt)) / 2
+	return rtt, offset
+}
+
+// ConnectionProbeEvent creates a ping or pong event carrying the timestamps
+// of a connection probe.
+func (evs EventSource) ConnectionProbeEvent(evType EventType, probe *ConnectionProbe) (*cloudevents.Event, error) {
+	cev := evs.HeartbeatEvent(evType)
+	err := cev.SetData(cloudevents.ApplicationJSON, probe)
+	return cev, err
+}
+
+// stampConnectionProbe sets the send time of a ping or pong carrying a
+// connection probe to the current time. It is called right before the event
+// is sent, so that the time the event waited to be sent counts towards
+// neither the round trip time nor the clock offset.
+func stampConnectionProbe(ev *cloudevents.Event) {
+	if Target(ev) != TargetHeartbeat || len(ev.Data()) == 0 {
+		return
+	}
+	probe := &ConnectionProbe{}
+	if err := ev.DataAs(probe); err != nil {
+		return
+	}
+	switch EventType(ev.Type()) {
+	case Ping:
+		probe.PingSentAt = time.Now()
+	case Pong:
+		probe.PongSentAt = time.Now()
+	default:
+		return
+	}
+	_ = ev.SetData(cloudevents.ApplicationJSON, probe)
+}
+
+// ConnectionProbe gets the connection probe from a heartbeat event. It
+// returns nil if the event is a plain keepalive without timestamps.
+func (ev Event) ConnectionProbe() (*ConnectionProbe, error) {
+	if len(ev.event.Data()) == 0 {
+		return nil, nil
+	}
+	probe := &ConnectionProbe{}
+	err := ev.event.DataAs(probe)
+	return probe, err
+}
+
+// DrainNotice is sent by the principal as a Drain heartbeat event when it
+// shuts down. The agent should wait ReconnectAfter before it connects again,
+// giving the principal (or its replacement) time to come up.
+type DrainNotice struct {
+	// ReconnectAfter is the suggested reconnect delay in milliseconds
+	ReconnectAfter int64 `json:"reconnectAfter"`
+}
+
+// DrainEvent creates a Drain event suggesting the given reconnect delay
+func (evs EventSource) DrainEvent(reconnectAfter time.Duration) (*cloudevents.Event, error) {
+	cev := evs.HeartbeatEvent(Drain)
+	err := cev.SetData(cloudevents.ApplicationJSON, &DrainNotice{ReconnectAfter: reconnectAfter.Milliseconds()})
+	return cev, err
+}
+
+// DrainNotice gets the drain notice from a Drain event
+func (ev Event) DrainNotice() (*DrainNotice, error) {
+	n := &DrainNotice{}
+	err := ev.event.DataAs(n)
+	return n, err
+}
+
 type RedisRequest struct {
 	UUID           string           `json:"uuid"`
 	ConnectionUUID string           `json:"connectionUuid"`
@@ -360,6 +502,8 @@ type ResourceRequest struct {
 	Body []byte `json:"body,omitempty"`
 	// Parameters from the HTTP request
 	Params map[string]string `json:"params,omitempty"`
+	// RequestedBy is the user the request is made on behalf of, if known
+	RequestedBy string `json:"requestedBy,omitempty"`
 	// The group and version of the requested resource
 	v1.GroupVersionResource
 }
@@ -487,6 +631,65 @@ func (evs EventSource) NewResourceRequestEvent(gvr v1.GroupVersionResource, name
 	return &cev, err
 }
 
+// CopyResourceRequest returns a copy of the resource or log request event ev
+// with a request ID of its own, so that both requests can be sent to the
+// agent and their responses told apart.
+func CopyResourceRequest(ev *cloudevents.Event) (*cloudevents.Event, error) {
+	data := map[string]json.RawMessage{}
+	if err := json.Unmarshal(ev.Data(), &data); err != nil {
+		return nil, fmt.Errorf("could not decode request: %w", err)
+	}
+	reqUUID := uuid.NewString()
+	raw, err := json.Marshal(reqUUID)
+	if err != nil {
+		return nil, err
+	}
+	data["uuid"] = raw
+	cev := ev.Clone()
+	cev.SetExtension(resourceID, reqUUID)
+	cev.SetExtension(eventID, reqUUID)
+	if err := cev.SetData(ev.DataContentType(), data); err != nil {
+		return nil, err
+	}
+	return &cev, nil
+}
+
+// SetRequestedBy records in a resource or log request event the user on
+// whose behalf the request is made.
+func SetRequestedBy(ev *cloudevents.Event, user string) error {
+	if user == "" {
+		return nil
+	}
+	data := map[string]json.RawMessage{}
+	if err := json.Unmarshal(ev.Data(), &data); err != nil {
+		return fmt.Errorf("could not decode request: %w", err)
+	}
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	data["requestedBy"] = raw
+	return ev.SetData(ev.DataContentType(), data)
+}
+
+// SetRequestID records in a log request event the correlation ID of the
+// client's request, so the agent can log it along with the request.
+func SetRequestID(ev *cloudevents.Event, requestID string) error {
+	if requestID == "" {
+		return nil
+	}
+	data := map[string]json.RawMessage{}
+	if err := json.Unmarshal(ev.Data(), &data); err != nil {
+		return fmt.Errorf("could not decode request: %w", err)
+	}
+	raw, err := json.Marshal(requestID)
+	if err != nil {
+		return err
+	}
+	data["requestId"] = raw
+	return ev.SetData(ev.DataContentType(), data)
+}
+
 func (evs EventSource) NewResourceResponseEvent(reqUUID string, status int, data string) *cloudevents.Event {
 	resUUID := uuid.NewString()
 	rr := &ResourceResponse{
@@ -634,6 +837,46 @@ func (evs EventSource) RequestResourceResyncEvent() (*cloudevents.Event, error)
 	return &cev, err
 }
 
+// ResourceBookmark is the state of a resource on a peer when it was last
+// synced with the source of truth.
+type ResourceBookmark struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	UID       string `json:"uid"`
+	Kind      string `json:"kind"`
+	// Checksum is the spec checksum of the peer's copy of the resource
+	Checksum []byte `json:"checksum"`
+	// ResourceVersion is the source's resourceVersion of the resource when
+	// the peer last received it. It is empty if unknown, or if the peer's
+	// copy changed since.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// ResyncBookmarks is sent by a peer to the source in response to a
+// RequestResourceResync, instead of a RequestUpdate for each resource, if
+// the peer holds recent bookmarks of its resources. The source only sends
+// updates of resources that changed on either side since they were last
+// synced.
+// Managed mode: Sent from Agent to Principal
+// Autonomous mode: Sent from Principal to Agent
+type ResyncBookmarks struct {
+	Resources []ResourceBookmark `json:"resources"`
+}
+
+func (evs EventSource) ResyncBookmarksEvent(bookmarks *ResyncBookmarks) (*cloudevents.Event, error) {
+	reqUUID := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(EventResyncBookmarks.String())
+	cev.SetDataSchema(TargetResourceResync.String())
+	cev.SetExtension(resourceID, reqUUID)
+	cev.SetExtension(eventID, reqUUID)
+
+	err := cev.SetData(cloudevents.ApplicationJSON, bookmarks)
+	return &cev, err
+}
+
 // FromWire validates an event from the wire in protobuf format, converts it
 // into an Event object and returns it. If the event on the wire is invalid,
 // or could not be converted for another reason, FromWire returns an error.
@@ -679,10 +922,55 @@ func Target(raw *cloudevents.Event) EventTarget {
 		return TargetTerminal
 	case TargetApplicationSet.String():
 		return TargetApplicationSet
+	case TargetSupportBundle.String():
+		return TargetSupportBundle
+	case TargetMetrics.String():
+		return TargetMetrics
+	case TargetLifecycle.String():
+		return TargetLifecycle
+	case TargetAgentConfig.String():
+		return TargetAgentConfig
+	case TargetPermissions.String():
+		return TargetPermissions
+	case TargetResourceFilter.String():
+		return TargetResourceFilter
+	case TargetEventChunk.String():
+		return TargetEventChunk
+	case TargetDebugCommand.String():
+		return TargetDebugCommand
+	case TargetStateChecksum.String():
+		return TargetStateChecksum
+	case TargetAgentUpdate.String():
+		return TargetAgentUpdate
+	case TargetPolicyViolation.String():
+		return TargetPolicyViolation
+	case TargetDrift.String():
+		return TargetDrift
+	case TargetLogCancel.String():
+		return TargetLogCancel
+	case TargetLogFrame.String():
+		return TargetLogFrame
 	}
 	return ""
 }
 
+// IsInteractive returns whether raw is a request a client is waiting for,
+// such as a resource, log or terminal request, or logs sent in response.
+func IsInteractive(raw *cloudevents.Event) bool {
+	switch Target(raw) {
+	case TargetResource, TargetContainerLog, TargetTerminal, TargetRedis, TargetSupportBundle, TargetMetrics, TargetDebugCommand, TargetLogFrame:
+		return true
+	}
+	return false
+}
+
+// IsUpdate returns whether raw updates the spec or status of a resource.
+// Such events are superseded by later updates of the same resource.
+func IsUpdate(raw *cloudevents.Event) bool {
+	t := EventType(raw.Type())
+	return t == SpecUpdate || t == StatusUpdate
+}
+
 func (ev Event) Target() EventTarget {
 	return ev.target
 }
@@ -796,6 +1084,12 @@ func (ev Event) RequestUpdate() (*RequestUpdate, error) {
 	return reqUpdate, err
 }
 
+func (ev Event) ResyncBookmarks() (*ResyncBookmarks, error) {
+	bookmarks := &ResyncBookmarks{}
+	err := ev.event.DataAs(bookmarks)
+	return bookmarks, err
+}
+
 type ContainerLogRequest struct {
 	// UUID for request/response correlation
 	UUID                         string `json:"uuid"`
@@ -810,10 +1104,39 @@ type ContainerLogRequest struct {
 	Previous                     bool   `json:"previous,omitempty"`
 	InsecureSkipTLSVerifyBackend bool   `json:"insecureSkipTLSVerifyBackend,omitempty"`
 	LimitBytes                   *int64 `json:"limitBytes,omitempty"`
+	// Deadline is the point in time after which the principal no longer
+	// waits for the logs, on the principal's clock. Deprecated in favor of
+	// TimeoutSeconds, which doesn't depend on the clocks of principal and
+	// agent being in sync.
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// TimeoutSeconds is the time the principal waits for the logs, counted
+	// from when it sent the request. The agent should stop working on the
+	// request once it passed since the request was received, even if it
+	// never receives a cancellation.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+	// RequestedBy is the user the request is made on behalf of, if known
+	RequestedBy string `json:"requestedBy,omitempty"`
+	// RequestID is the correlation ID of the client's request, if known
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// DeadlineAfter returns the point in time after which the agent should stop
+// working on the request, given the time it received the request. For
+// principals that don't send a timeout, it is the principal's deadline. The
+// second return value is false if the request has no deadline.
+func (r *ContainerLogRequest) DeadlineAfter(received time.Time) (time.Time, bool) {
+	if r.TimeoutSeconds != nil {
+		return received.Add(time.Duration(*r.TimeoutSeconds) * time.Second), true
+	}
+	if r.Deadline != nil {
+		return *r.Deadline, true
+	}
+	return time.Time{}, false
 }
 
-// NewLogRequestEvent creates a cloud event for requesting logs
-func (evs EventSource) NewLogRequestEvent(namespace, podName, method string, params map[string]string) (*cloudevents.Event, error) {
+// NewLogRequestEvent creates a cloud event for requesting logs. If timeout is
+// not zero, it is passed on to the agent as the request's timeout.
+func (evs EventSource) NewLogRequestEvent(namespace, podName, method string, params map[string]string, timeout time.Duration) (*cloudevents.Event, error) {
 	reqUUID := uuid.NewString()
 
 	// Parse log-specific parameters
@@ -866,6 +1189,15 @@ func (evs EventSource) NewLogRequestEvent(namespace, podName, method string, par
 			logReq.LimitBytes = &bytes
 		}
 	}
+
+	if timeout > 0 {
+		seconds := int64((timeout + time.Second - 1) / time.Second)
+		logReq.TimeoutSeconds = &seconds
+		// Agents not knowing the timeout yet get the deadline instead
+		d := time.Now().Add(timeout).UTC()
+		logReq.Deadline = &d
+	}
+
 	cev := cloudevents.NewEvent()
 	cev.SetSource(evs.source)
 	cev.SetSpecVersion(cloudEventSpecVersion)
@@ -915,3 +1247,72 @@ func (ev Event) TerminalRequest() (*ContainerTerminalRequest, error) {
 	err := ev.event.DataAs(req)
 	return req, err
 }
+
+// SupportBundleRequest asks an agent to collect the logs of all pods of an
+// application and to send them back as a tar.gz archive over the
+// FileTransfer stream.
+type SupportBundleRequest struct {
+	// UUID for request/response correlation
+	UUID string `json:"uuid"`
+	// Application is the name of the application on the agent
+	Application string `json:"application"`
+	// Namespace is the namespace of the application on the agent. If empty,
+	// the agent's namespace is used.
+	Namespace string `json:"namespace,omitempty"`
+	// Previous also collects the logs of the previous container instances
+	Previous bool `json:"previous,omitempty"`
+	// LimitBytes is the maximum number of log bytes collected per container
+	LimitBytes int64 `json:"limitBytes,omitempty"`
+}
+
+// NewSupportBundleRequestEvent creates a cloud event for requesting a support
+// bundle from an agent.
+func (evs EventSource) NewSupportBundleRequestEvent(req *SupportBundleRequest) (*cloudevents.Event, error) {
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(EventRequestSupportBundle.String())
+	cev.SetDataSchema(TargetSupportBundle.String())
+	cev.SetExtension(resourceID, req.UUID)
+	cev.SetExtension(eventID, req.UUID)
+	err := cev.SetData(cloudevents.ApplicationJSON, req)
+	return &cev, err
+}
+
+// SupportBundleRequest gets the support bundle request payload from an event.
+func (ev Event) SupportBundleRequest() (*SupportBundleRequest, error) {
+	req := &SupportBundleRequest{}
+	err := ev.event.DataAs(req)
+	return req, err
+}
+
+// MetricsRequest asks an agent to gather its Prometheus metrics and to send
+// them back over the FileTransfer stream.
+type MetricsRequest struct {
+	// UUID for request/response correlation
+	UUID string `json:"uuid"`
+	// Format is the exposition format requested by the scraper, as
+	// negotiated from its Accept header
+	Format string `json:"format,omitempty"`
+}
+
+// NewMetricsRequestEvent creates a cloud event for requesting the metrics of
+// an agent.
+func (evs EventSource) NewMetricsRequestEvent(req *MetricsRequest) (*cloudevents.Event, error) {
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(EventRequestMetrics.String())
+	cev.SetDataSchema(TargetMetrics.String())
+	cev.SetExtension(resourceID, req.UUID)
+	cev.SetExtension(eventID, req.UUID)
+	err := cev.SetData(cloudevents.ApplicationJSON, req)
+	return &cev, err
+}
+
+// MetricsRequest gets the metrics request payload from an event.
+func (ev Event) MetricsRequest() (*MetricsRequest, error) {
+	req := &MetricsRequest{}
+	err := ev.event.DataAs(req)
+	return req, err
+}
@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/stretchr/testify/require"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -420,6 +421,147 @@ func TestNewTerminalRequestEvent(t *testing.T) {
 	})
 }
 
+func TestNewLogRequestEvent(t *testing.T) {
+	es := NewEventSource("test-source")
+
+	t.Run("carries the timeout", func(t *testing.T) {
+		sent := time.Now()
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", map[string]string{"container": "c"}, 3*time.Minute)
+		require.NoError(t, err)
+		logReq, err := New(ev, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.NotNil(t, logReq.TimeoutSeconds)
+		require.Equal(t, int64(180), *logReq.TimeoutSeconds)
+		// The deadline of agents not knowing the timeout
+		require.NotNil(t, logReq.Deadline)
+		require.WithinDuration(t, sent.Add(3*time.Minute), *logReq.Deadline, time.Second)
+		require.Equal(t, "c", logReq.Container)
+	})
+
+	t.Run("deadline is derived from the timeout on the receiver's clock", func(t *testing.T) {
+		// A deadline from a principal whose clock is an hour behind
+		past := time.Now().Add(-time.Hour)
+		timeout := int64(60)
+		logReq := &ContainerLogRequest{Deadline: &past, TimeoutSeconds: &timeout}
+		received := time.Now()
+		deadline, ok := logReq.DeadlineAfter(received)
+		require.True(t, ok)
+		require.Equal(t, received.Add(time.Minute), deadline)
+
+		// Principals not sending a timeout
+		logReq.TimeoutSeconds = nil
+		deadline, ok = logReq.DeadlineAfter(received)
+		require.True(t, ok)
+		require.Equal(t, past, deadline)
+
+		_, ok = (&ContainerLogRequest{}).DeadlineAfter(received)
+		require.False(t, ok)
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", map[string]string{"follow": "true"}, 0)
+		require.NoError(t, err)
+		logReq, err := New(ev, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.Nil(t, logReq.Deadline)
+		require.True(t, logReq.Follow)
+	})
+}
+
+func TestCopyResourceRequest(t *testing.T) {
+	es := NewEventSource("test-source")
+	ev, err := es.NewResourceRequestEvent(metav1.GroupVersionResource{Version: "v1", Resource: "pods"}, "ns", "pod", "", "GET", nil, map[string]string{"pretty": "true"})
+	require.NoError(t, err)
+	require.NoError(t, SetRequestedBy(ev, "alice"))
+	SetTTL(ev, time.Minute)
+
+	cp, err := CopyResourceRequest(ev)
+	require.NoError(t, err)
+	require.NotEqual(t, EventID(ev), EventID(cp))
+	require.NotEqual(t, ResourceID(ev), ResourceID(cp))
+	rreq, err := New(cp, TargetResource).ResourceRequest()
+	require.NoError(t, err)
+	require.Equal(t, EventID(cp), rreq.UUID)
+	require.Equal(t, "pod", rreq.Name)
+	require.Equal(t, "alice", rreq.RequestedBy)
+	require.Equal(t, map[string]string{"pretty": "true"}, rreq.Params)
+	require.Equal(t, ExpiresAt(ev), ExpiresAt(cp))
+
+	// The original is left alone
+	orig, err := New(ev, TargetResource).ResourceRequest()
+	require.NoError(t, err)
+	require.Equal(t, EventID(ev), orig.UUID)
+
+	t.Run("Log request", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", map[string]string{"container": "app"}, time.Minute)
+		require.NoError(t, err)
+		cp, err := CopyResourceRequest(ev)
+		require.NoError(t, err)
+		require.NotEqual(t, EventID(ev), EventID(cp))
+		lreq, err := New(cp, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.Equal(t, EventID(cp), lreq.UUID)
+		require.Equal(t, "pod", lreq.PodName)
+		require.Equal(t, "app", lreq.Container)
+		require.NotNil(t, lreq.TimeoutSeconds)
+		require.Equal(t, int64(60), *lreq.TimeoutSeconds)
+	})
+}
+
+func TestSetRequestedBy(t *testing.T) {
+	es := NewEventSource("test-source")
+
+	t.Run("resource request", func(t *testing.T) {
+		ev, err := es.NewResourceRequestEvent(metav1.GroupVersionResource{Version: "v1", Resource: "pods"}, "ns", "pod", "", "GET", nil, nil)
+		require.NoError(t, err)
+		require.NoError(t, SetRequestedBy(ev, "system:serviceaccount:guestbook:deployer"))
+		rreq, err := New(ev, TargetResource).ResourceRequest()
+		require.NoError(t, err)
+		require.Equal(t, "system:serviceaccount:guestbook:deployer", rreq.RequestedBy)
+		require.Equal(t, "pods", rreq.Resource)
+	})
+
+	t.Run("log request", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", nil, 0)
+		require.NoError(t, err)
+		require.NoError(t, SetRequestedBy(ev, "alice"))
+		logReq, err := New(ev, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.Equal(t, "alice", logReq.RequestedBy)
+		require.Equal(t, "pod", logReq.PodName)
+	})
+
+	t.Run("older peers don't get the user", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", nil, 0)
+		require.NoError(t, err)
+		require.NoError(t, SetRequestedBy(ev, "alice"))
+		out, err := ForSchemaVersion(ev, SchemaVersion2)
+		require.NoError(t, err)
+		logReq, err := New(out, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.Empty(t, logReq.RequestedBy)
+	})
+}
+
+func TestSetRequestID(t *testing.T) {
+	es := NewEventSource("test-source")
+	ev, err := es.NewLogRequestEvent("ns", "pod", "GET", nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, SetRequestID(ev, "support-1234"))
+	logReq, err := New(ev, TargetContainerLog).ContainerLogRequest()
+	require.NoError(t, err)
+	require.Equal(t, "support-1234", logReq.RequestID)
+	require.Equal(t, "pod", logReq.PodName)
+
+	t.Run("older peers don't get the request ID", func(t *testing.T) {
+		out, err := ForSchemaVersion(ev, SchemaVersion3)
+		require.NoError(t, err)
+		logReq, err := New(out, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.Empty(t, logReq.RequestID)
+	})
+}
+
 func TestTerminalRequestFromEvent(t *testing.T) {
 	es := NewEventSource("test-source")
 
@@ -503,6 +645,28 @@ func TestTargetTerminal(t *testing.T) {
 	})
 }
 
+func TestTargetAgentUpdate(t *testing.T) {
+	es := NewEventSource("test-source")
+
+	req, err := es.NewAgentUpdateRequestEvent(&AgentUpdateRequest{UUID: "uuid", Tag: "v0.6.0"})
+	require.NoError(t, err)
+	require.Equal(t, TargetAgentUpdate, Target(req))
+
+	ack, err := es.AgentUpdateAckEvent(&AgentUpdateResult{UUID: "uuid"})
+	require.NoError(t, err)
+	require.Equal(t, TargetAgentUpdate, Target(ack))
+}
+
+func TestDriftEvent(t *testing.T) {
+	es := NewEventSource("test-source")
+	report := &DriftReport{Name: "guestbook", Namespace: "argocd", UID: "uid", Fields: []DriftedField{{Path: "source.targetRevision", Hub: `"main"`, Spoke: `"v1.0.0"`}}}
+	ev := es.DriftEvent(report)
+	require.Equal(t, TargetDrift, Target(ev))
+	got, err := New(ev, TargetDrift).DriftReport()
+	require.NoError(t, err)
+	require.Equal(t, report.Fields, got.Fields)
+}
+
 func TestSentAt(t *testing.T) {
 	t.Run("SentAt returns nil when extension not set", func(t *testing.T) {
 		ev := cloudevents.NewEvent()
@@ -528,6 +692,29 @@ func TestSentAt(t *testing.T) {
 	})
 }
 
+func TestTTL(t *testing.T) {
+	t.Run("Events without TTL never expire", func(t *testing.T) {
+		ev := cloudevents.NewEvent()
+		require.Nil(t, ExpiresAt(&ev))
+		require.False(t, IsExpired(&ev, time.Now().Add(24*time.Hour)))
+	})
+
+	t.Run("Events expire after their TTL", func(t *testing.T) {
+		ev := cloudevents.NewEvent()
+		SetTTL(&ev, time.Minute)
+		require.NotNil(t, ExpiresAt(&ev))
+		require.False(t, IsExpired(&ev, time.Now()))
+		require.True(t, IsExpired(&ev, time.Now().Add(2*time.Minute)))
+	})
+
+	t.Run("Malformed expiry is ignored", func(t *testing.T) {
+		ev := cloudevents.NewEvent()
+		ev.SetExtension(expiresAt, "not-a-timestamp")
+		require.Nil(t, ExpiresAt(&ev))
+		require.False(t, IsExpired(&ev, time.Now()))
+	})
+}
+
 func TestApplicationSetEventRoundtrip(t *testing.T) {
 	es := NewEventSource("test-source")
 	appSet := &v1alpha1.ApplicationSet{
@@ -615,3 +802,126 @@ func TestPrincipalUID(t *testing.T) {
 		require.Equal(t, "", PrincipalUID(&ev))
 	})
 }
+
+func TestConnectionProbe(t *testing.T) {
+	t.Run("Measure round trip time and clock offset", func(t *testing.T) {
+		t1 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		// The agent's clock is 2s ahead, each direction takes 50ms and the
+		// agent takes 10ms to answer
+		probe := &ConnectionProbe{
+			PingSentAt:     t1,
+			PingReceivedAt: t1.Add(2*time.Second + 50*time.Millisecond),
+			PongSentAt:     t1.Add(2*time.Second + 60*time.Millisecond),
+		}
+		rtt, offset := probe.Measure(t1.Add(110 * time.Millisecond))
+		require.Equal(t, 100*time.Millisecond, rtt)
+		require.Equal(t, 2*time.Second, offset)
+	})
+
+	t.Run("Probe event roundtrip", func(t *testing.T) {
+		es := NewEventSource("test-source")
+		sent := &ConnectionProbe{PingSentAt: time.Now().UTC()}
+		cev, err := es.ConnectionProbeEvent(Ping, sent)
+		require.NoError(t, err)
+		require.Equal(t, Ping.String(), cev.Type())
+
+		wrapped := New(cev, TargetHeartbeat)
+		require.Equal(t, TargetHeartbeat, wrapped.Target())
+		probe, err := wrapped.ConnectionProbe()
+		require.NoError(t, err)
+		require.NotNil(t, probe)
+		require.True(t, sent.PingSentAt.Equal(probe.PingSentAt))
+		require.True(t, probe.PongSentAt.IsZero())
+	})
+
+	t.Run("Plain heartbeat has no probe", func(t *testing.T) {
+		es := NewEventSource("test-source")
+		probe, err := New(es.HeartbeatEvent(Ping), TargetHeartbeat).ConnectionProbe()
+		require.NoError(t, err)
+		require.Nil(t, probe)
+	})
+}
+
+func TestDrainEvent(t *testing.T) {
+	es := NewEventSource("test-source")
+	cev, err := es.DrainEvent(5 * time.Second)
+	require.NoError(t, err)
+	require.Equal(t, Drain.String(), cev.Type())
+
+	wrapped := New(cev, Target(cev))
+	require.Equal(t, TargetHeartbeat, wrapped.Target())
+	n, err := wrapped.DrainNotice()
+	require.NoError(t, err)
+	require.Equal(t, int64(5000), n.ReconnectAfter)
+}
+
+func TestSyncProgressEvent(t *testing.T) {
+	es := NewEventSource("agent")
+	app := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "agent", UID: "1234", ResourceVersion: "5"}}
+	app.Spec.Project = "default"
+	app.Status.Sync.Status = v1alpha1.SyncStatusCodeOutOfSync
+	app.Status.OperationState = &v1alpha1.OperationState{Phase: synccommon.OperationRunning, Message: "waiting for healthy state"}
+	ev := es.SyncProgressEvent(app)
+	require.Equal(t, SyncProgress.String(), ev.Type())
+	require.Equal(t, createResourceID(app.ObjectMeta), ResourceID(ev))
+	out, err := New(ev, TargetApplication).Application()
+	require.NoError(t, err)
+	require.Equal(t, "guestbook", out.Name)
+	require.Empty(t, out.Spec.Project)
+	require.Empty(t, out.Status.Sync.Status)
+	require.NotNil(t, out.Status.OperationState)
+	require.Equal(t, "waiting for healthy state", out.Status.OperationState.Message)
+}
+
+func TestDebugCommandRequestEvent(t *testing.T) {
+	es := NewEventSource("principal")
+	ev, err := es.NewDebugCommandRequestEvent(&DebugCommandRequest{UUID: "1234", Command: DebugCommandInflight, RequestedBy: "jane"})
+	require.NoError(t, err)
+	require.Equal(t, DebugCommandRequested.String(), ev.Type())
+	require.Equal(t, TargetDebugCommand, Target(ev))
+	require.Equal(t, "1234", EventID(ev))
+	require.True(t, IsInteractive(ev))
+	req, err := New(ev, TargetDebugCommand).DebugCommandRequest()
+	require.NoError(t, err)
+	require.Equal(t, &DebugCommandRequest{UUID: "1234", Command: DebugCommandInflight, RequestedBy: "jane"}, req)
+}
+
+func TestTargetLogCancel(t *testing.T) {
+	es := NewEventSource("test-source")
+
+	req, err := es.NewLogStreamCancelEvent(&LogStreamCancel{UUID: "uuid"})
+	require.NoError(t, err)
+	require.Equal(t, TargetLogCancel, Target(req))
+	require.Equal(t, "uuid", EventID(req))
+
+	ack, err := es.LogStreamCancelAckEvent(&LogStreamCancelResult{UUID: "uuid", Running: true, Teardown: 2 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, TargetLogCancel, Target(ack))
+	res, err := New(ack, TargetLogCancel).LogStreamCancelResult()
+	require.NoError(t, err)
+	require.True(t, res.Running)
+	require.Equal(t, 2*time.Second, res.Teardown)
+}
+
+func TestLogFrameEvents(t *testing.T) {
+	es := NewEventSource("test-source")
+
+	first, err := es.LogFrameEvent(&LogFrame{StreamID: "stream", Seq: 1, Data: []byte("data")})
+	require.NoError(t, err)
+	second, err := es.LogFrameEvent(&LogFrame{StreamID: "stream", Seq: 2, Close: true})
+	require.NoError(t, err)
+	require.Equal(t, TargetLogFrame, Target(first))
+	require.True(t, IsInteractive(first))
+	// Frames must not be coalesced by the event writer
+	require.NotEqual(t, ResourceID(first), ResourceID(second))
+	frame, err := New(first, TargetLogFrame).LogFrame()
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), frame.Data)
+
+	ctrl, err := es.LogFrameControlEvent(LogFrameClosed, &LogFrameControl{StreamID: "stream", Code: 1, Message: "canceled"})
+	require.NoError(t, err)
+	require.Equal(t, "stream", ResourceID(ctrl))
+	got, err := New(ctrl, TargetLogFrame).LogFrameControl()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), got.Code)
+}
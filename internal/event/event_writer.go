@@ -2,6 +2,7 @@ package event
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -10,8 +11,10 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/logging/logfields"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/eventstreamapi"
 	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
@@ -46,6 +49,15 @@ type EventWriter struct {
 	// agentName is the name of the agent for which this EventWriter is responsible.
 	agentName string
 
+	// schemaVersion is the event schema version negotiated with the peer
+	// - acquire 'lock' before accessing
+	schemaVersion SchemaVersion
+
+	// maxMessageSize is the size of messages above which events are sent
+	// in chunks, if the peer supports it. 0 disables chunking.
+	// - acquire 'lock' before accessing
+	maxMessageSize int
+
 	log *logrus.Entry
 }
 
@@ -73,11 +85,12 @@ type eventMessage struct {
 // should be used.
 func NewEventWriter(agentName string, target streamWriter) *EventWriter {
 	return &EventWriter{
-		unsentEvents: map[string]*eventQueue{},
-		sentEvents:   map[string]*eventMessage{},
-		target:       target,
-		agentName:    agentName,
-		log:          logging.GetDefaultLogger().ModuleLogger("EventWriter").WithField(logfields.ClientAddr, grpcutil.AddressFromContext(target.Context())).WithField(logfields.Agent, agentName),
+		unsentEvents:  map[string]*eventQueue{},
+		sentEvents:    map[string]*eventMessage{},
+		target:        target,
+		agentName:     agentName,
+		schemaVersion: CurrentSchemaVersion,
+		log:           logging.GetDefaultLogger().ModuleLogger("EventWriter").WithField(logfields.ClientAddr, grpcutil.AddressFromContext(target.Context())).WithField(logfields.Agent, agentName),
 	}
 }
 
@@ -100,6 +113,73 @@ func (ew *EventWriter) UpdateTarget(target streamWriter) {
 	}
 }
 
+// SetSchemaVersion sets the event schema version negotiated with the peer.
+// Events are converted to this version before they are sent.
+func (ew *EventWriter) SetSchemaVersion(v SchemaVersion) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	ew.schemaVersion = v
+}
+
+// SchemaVersion returns the event schema version negotiated with the peer
+func (ew *EventWriter) SchemaVersion() SchemaVersion {
+	ew.mu.RLock()
+	defer ew.mu.RUnlock()
+	return ew.schemaVersion
+}
+
+// SetMaxMessageSize sets the size of gRPC messages above which events are
+// split into chunks, if the peer supports it. 0 disables chunking.
+func (ew *EventWriter) SetMaxMessageSize(n int) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	ew.maxMessageSize = n
+}
+
+// send sends pev to the target, in chunks if it exceeds maxSize and the
+// peer's schema version v supports chunked transfer
+func (ew *EventWriter) send(pev *pb.CloudEvent, v SchemaVersion, maxSize int, logCtx *logrus.Entry) error {
+	msg := &eventstreamapi.Event{Event: pev}
+	if maxSize <= 0 || proto.Size(msg) <= maxSize {
+		return ew.target.Send(msg)
+	}
+	if v < SchemaVersion6 {
+		logCtx.Warnf("Event of %d bytes exceeds the maximum message size, but the peer does not support chunked transfer", proto.Size(msg))
+		return ew.target.Send(msg)
+	}
+	chunks, err := Chunk(pev, maxSize)
+	if err != nil {
+		return err
+	}
+	logCtx.Debugf("Sending event of %d bytes in %d chunks", proto.Size(msg), len(chunks))
+	for _, c := range chunks {
+		if err := ew.target.Send(&eventstreamapi.Event{Event: c}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toWire converts ev to schema version v and its wire format
+func toWire(ev *cloudevents.Event, v SchemaVersion) (*pb.CloudEvent, error) {
+	wev, err := ForSchemaVersion(ev, v)
+	if err != nil {
+		return nil, err
+	}
+	ensureID(wev)
+	return format.ToProto(wev)
+}
+
+// dropUnsupported removes an event which cannot be sent in the peer's schema
+// version, since retrying it would never succeed.
+func (ew *EventWriter) dropUnsupported(resID string, msg *eventMessage) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ew.sentEvents[resID] == msg {
+		delete(ew.sentEvents, resID)
+	}
+}
+
 func (ew *EventWriter) Add(ev *cloudevents.Event) {
 	resID := ResourceID(ev)
 	ew.mu.Lock()
@@ -280,6 +360,8 @@ func (ew *EventWriter) retrySentEvent(resID string, sentMsg *eventMessage) {
 
 	// Re-verify the event is still in sentEvents
 	currentSent, stillExists := ew.sentEvents[resID]
+	schemaVersion := ew.schemaVersion
+	maxSize := ew.maxMessageSize
 	ew.mu.RUnlock()
 
 	// If event was ACK'd between check and use, skip retry
@@ -323,14 +405,17 @@ func (ew *EventWriter) retrySentEvent(resID string, sentMsg *eventMessage) {
 	sentMsg.retryAfter = &retryAfter
 
 	// Resend the event
-	pev, err := format.ToProto(sentMsg.event)
+	pev, err := toWire(sentMsg.event, schemaVersion)
 	if err != nil {
 		logCtx.Errorf("Could not wire event: %v\n", err)
 		sentMsg.mu.Unlock()
+		if errors.Is(err, ErrFieldNotSupported) {
+			ew.dropUnsupported(resID, sentMsg)
+		}
 		return
 	}
 
-	err = ew.target.Send(&eventstreamapi.Event{Event: pev})
+	err = ew.send(pev, schemaVersion, maxSize, logCtx)
 	sentMsg.mu.Unlock()
 
 	if err != nil {
@@ -388,6 +473,8 @@ func (ew *EventWriter) sendUnsentEvent(resID string) {
 		ew.scheduleRetry(eventMsg)
 		ew.sentEvents[resID] = eventMsg
 	}
+	schemaVersion := ew.schemaVersion
+	maxSize := ew.maxMessageSize
 	ew.mu.Unlock()
 
 	// Send the event
@@ -401,17 +488,21 @@ func (ew *EventWriter) sendUnsentEvent(resID string) {
 	if !isFireAndForget {
 		SetSentAt(eventMsg.event)
 	}
+	stampConnectionProbe(eventMsg.event)
 
-	pev, err := format.ToProto(eventMsg.event)
+	pev, err := toWire(eventMsg.event, schemaVersion)
 	eventMsg.mu.Unlock()
 
 	if err != nil {
 		logCtx.Errorf("Could not wire event: %v\n", err)
+		if errors.Is(err, ErrFieldNotSupported) {
+			ew.dropUnsupported(resID, eventMsg)
+		}
 		return
 	}
 
 	// A Send() on the stream is actually not blocking.
-	err = ew.target.Send(&eventstreamapi.Event{Event: pev})
+	err = ew.send(pev, schemaVersion, maxSize, logCtx)
 	if err != nil {
 		logCtx.Errorf("Error while sending: %v\n", err)
 		return
@@ -352,6 +352,36 @@ func TestEventWriter(t *testing.T) {
 		require.Len(t, fs.events[resID], 1)
 	})
 
+	t.Run("should stamp connection probes when sending them", func(t *testing.T) {
+		rs := &recordingStream{}
+		evSender := NewEventWriter("test", rs)
+
+		// The ping waited in the send queue for a minute
+		queuedAt := time.Now().Add(-time.Minute)
+		ping, err := es.ConnectionProbeEvent(Ping, &ConnectionProbe{PingSentAt: queuedAt})
+		require.NoError(t, err)
+		pong, err := es.ConnectionProbeEvent(Pong, &ConnectionProbe{PingSentAt: queuedAt, PingReceivedAt: queuedAt, PongSentAt: queuedAt})
+		require.NoError(t, err)
+		for _, ev := range []*cloudevents.Event{ping, pong} {
+			evSender.Add(ev)
+			evSender.sendEvent(ResourceID(ev))
+		}
+
+		require.Len(t, rs.sent, 2)
+		sent := []*ConnectionProbe{}
+		for _, pev := range rs.sent {
+			ev, err := FromWire(pev)
+			require.NoError(t, err)
+			probe, err := ev.ConnectionProbe()
+			require.NoError(t, err)
+			sent = append(sent, probe)
+		}
+		require.WithinDuration(t, time.Now(), sent[0].PingSentAt, 5*time.Second)
+		// The ping's send time is only stamped by the sender of the ping
+		require.True(t, queuedAt.Equal(sent[1].PingSentAt))
+		require.WithinDuration(t, time.Now(), sent[1].PongSentAt, 5*time.Second)
+	})
+
 	t.Run("heartbeat events should not accumulate in sentEvents over time", func(t *testing.T) {
 		fs := &fakeStream{}
 		evSender := NewEventWriter("test", fs)
@@ -0,0 +1,230 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// Lifecycle events are not exchanged between principal and agent. They are
+// published by the principal to external systems, so that fleet automation
+// can react to what is happening.
+const (
+	AgentConnected    EventType = TypePrefix + ".agent-connected"
+	AgentDisconnected EventType = TypePrefix + ".agent-disconnected"
+	SyncCompleted     EventType = TypePrefix + ".sync-completed"
+	DeletionProgress  EventType = TypePrefix + ".deletion-progress"
+	DeletionCompleted EventType = TypePrefix + ".deletion-completed"
+)
+
+// Phases of the deletion of an application on a managed agent
+const (
+	// DeletionPhaseRequested means the application was marked for deletion
+	// on the principal and the deletion was sent to the agent
+	DeletionPhaseRequested = "Requested"
+	// DeletionPhasePruning means the agent is deleting the application and
+	// pruning its resources
+	DeletionPhasePruning = "Pruning"
+	// DeletionPhaseConfirmed means the agent confirmed the deletion and the
+	// application was removed from the principal
+	DeletionPhaseConfirmed = "Confirmed"
+	// DeletionPhaseForced means the application was removed from the
+	// principal without the agent's confirmation
+	DeletionPhaseForced = "Forced"
+)
+
+const TargetLifecycle EventTarget = "lifecycle"
+
+// StructuredContentType is the media type of events in the CloudEvents JSON
+// structured content mode.
+const StructuredContentType = "application/cloudevents+json"
+
+const targetExt = "target"
+
+// AgentLifecycle is the data of AgentConnected and AgentDisconnected events
+type AgentLifecycle struct {
+	Agent string `json:"agent"`
+	Mode  string `json:"mode,omitempty"`
+}
+
+// SyncResult is the data of SyncCompleted events
+type SyncResult struct {
+	Agent       string     `json:"agent"`
+	Namespace   string     `json:"namespace"`
+	Application string     `json:"application"`
+	Phase       string     `json:"phase"`
+	Message     string     `json:"message,omitempty"`
+	Revision    string     `json:"revision,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+}
+
+// AppDeletion is the data of DeletionProgress and DeletionCompleted events
+type AppDeletion struct {
+	Agent       string `json:"agent"`
+	Namespace   string `json:"namespace"`
+	Application string `json:"application"`
+	Phase       string `json:"phase"`
+	// Resources is the number of resources the application still manages
+	Resources int    `json:"resources"`
+	Message   string `json:"message,omitempty"`
+}
+
+func (evs EventSource) lifecycleEvent(evType EventType, subject string, data any) (*cloudevents.Event, error) {
+	id := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetID(id)
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(evType.String())
+	cev.SetSubject(subject)
+	cev.SetTime(time.Now())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetLifecycle.String())
+	err := cev.SetData(cloudevents.ApplicationJSON, data)
+	return &cev, err
+}
+
+// AgentLifecycleEvent creates an AgentConnected or AgentDisconnected event
+func (evs EventSource) AgentLifecycleEvent(evType EventType, agentName, mode string) (*cloudevents.Event, error) {
+	return evs.lifecycleEvent(evType, agentName, &AgentLifecycle{Agent: agentName, Mode: mode})
+}
+
+// SyncResultEvent creates a SyncCompleted event from the operation state of
+// the given application, which is managed by agentName. It returns nil if
+// the application has no completed operation.
+func (evs EventSource) SyncResultEvent(agentName string, app *v1alpha1.Application) (*cloudevents.Event, error) {
+	op := app.Status.OperationState
+	if op == nil || !op.Phase.Completed() {
+		return nil, nil
+	}
+	res := &SyncResult{
+		Agent:       agentName,
+		Namespace:   app.Namespace,
+		Application: app.Name,
+		Phase:       string(op.Phase),
+		Message:     op.Message,
+		StartedAt:   op.StartedAt.Time,
+	}
+	if op.FinishedAt != nil {
+		t := op.FinishedAt.Time
+		res.FinishedAt = &t
+	}
+	if op.SyncResult != nil {
+		res.Revision = op.SyncResult.Revision
+	}
+	return evs.lifecycleEvent(SyncCompleted, app.QualifiedName(), res)
+}
+
+// AppDeletionEvent creates a DeletionProgress or DeletionCompleted event for
+// the given application, which is managed by agentName.
+func (evs EventSource) AppDeletionEvent(evType EventType, agentName string, app *v1alpha1.Application, phase, message string) (*cloudevents.Event, error) {
+	return evs.lifecycleEvent(evType, app.QualifiedName(), &AppDeletion{
+		Agent:       agentName,
+		Namespace:   app.Namespace,
+		Application: app.Name,
+		Phase:       phase,
+		Resources:   len(app.Status.Resources),
+		Message:     message,
+	})
+}
+
+// AgentLifecycle returns the data of an AgentConnected or AgentDisconnected
+// event.
+func (ev Event) AgentLifecycle() (*AgentLifecycle, error) {
+	l := &AgentLifecycle{}
+	err := ev.event.DataAs(l)
+	return l, err
+}
+
+// SyncResult returns the data of a SyncCompleted event
+func (ev Event) SyncResult() (*SyncResult, error) {
+	r := &SyncResult{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
+
+// AppDeletion returns the data of a DeletionProgress or DeletionCompleted
+// event
+func (ev Event) AppDeletion() (*AppDeletion, error) {
+	d := &AppDeletion{}
+	err := ev.event.DataAs(d)
+	return d, err
+}
+
+// ensureID sets the mandatory CloudEvents ID on ev, if it is missing. Events
+// exchanged between principal and agent are identified by their event ID
+// extension, which is used in that case.
+func ensureID(ev *cloudevents.Event) {
+	if ev.ID() != "" {
+		return
+	}
+	if id := EventID(ev); id != "" {
+		ev.SetID(id)
+	} else {
+		ev.SetID(uuid.NewString())
+	}
+}
+
+// ToStructured encodes ev as CloudEvents 1.0 JSON in structured content mode.
+//
+// Internally, the target of an event is kept in its dataschema attribute,
+// which CloudEvents requires to be an absolute URI. The target is therefore
+// moved into the "target" extension attribute.
+func ToStructured(ev *cloudevents.Event) ([]byte, error) {
+	out := ev.Clone()
+	ensureID(&out)
+	if u := out.DataSchema(); u != "" && !isAbsoluteURI(u) {
+		out.SetExtension(targetExt, u)
+		out.SetDataSchema("")
+	}
+	if err := out.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cloud event: %w", err)
+	}
+	return json.Marshal(out)
+}
+
+// FromStructured decodes a CloudEvents 1.0 JSON event in structured content
+// mode.
+func FromStructured(data []byte) (*cloudevents.Event, error) {
+	ev := cloudevents.NewEvent()
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, fmt.Errorf("could not decode cloud event: %w", err)
+	}
+	if ev.SpecVersion() != cloudEventSpecVersion {
+		return nil, fmt.Errorf("unsupported cloud event spec version %q", ev.SpecVersion())
+	}
+	if err := ev.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cloud event: %w", err)
+	}
+	if target, ok := ev.Extensions()[targetExt].(string); ok && ev.DataSchema() == "" {
+		ev.SetDataSchema(target)
+		ev.SetExtension(targetExt, nil)
+	}
+	return &ev, nil
+}
+
+func isAbsoluteURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs()
+}
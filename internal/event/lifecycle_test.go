@@ -0,0 +1,128 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStructured(t *testing.T) {
+	es := NewEventSource("principal")
+
+	t.Run("Lifecycle event roundtrip", func(t *testing.T) {
+		ev, err := es.AgentLifecycleEvent(AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		data, err := ToStructured(ev)
+		require.NoError(t, err)
+		raw := map[string]any{}
+		require.NoError(t, json.Unmarshal(data, &raw))
+		assert.Equal(t, "1.0", raw["specversion"])
+		assert.Equal(t, AgentConnected.String(), raw["type"])
+		assert.Equal(t, "lifecycle", raw["target"])
+		assert.NotContains(t, raw, "dataschema")
+
+		out, err := FromStructured(data)
+		require.NoError(t, err)
+		assert.Equal(t, ev.ID(), out.ID())
+		assert.Equal(t, TargetLifecycle, Target(out))
+		l, err := New(out, Target(out)).AgentLifecycle()
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", l.Agent)
+		assert.Equal(t, "managed", l.Mode)
+	})
+
+	t.Run("Internal events get an ID", func(t *testing.T) {
+		ev := es.HeartbeatEvent(Ping)
+		ev.SetID("")
+		data, err := ToStructured(ev)
+		require.NoError(t, err)
+		out, err := FromStructured(data)
+		require.NoError(t, err)
+		assert.NotEmpty(t, out.ID())
+	})
+
+	t.Run("Reject invalid input", func(t *testing.T) {
+		_, err := FromStructured([]byte(`{"specversion":"0.3","id":"1","source":"x","type":"y"}`))
+		assert.Error(t, err)
+		_, err = FromStructured([]byte(`{"specversion":"1.0","source":"x"}`))
+		assert.Error(t, err)
+		_, err = FromStructured([]byte(`not json`))
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncResultEvent(t *testing.T) {
+	es := NewEventSource("principal")
+	app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "agent-1"}}
+
+	t.Run("No event without operation", func(t *testing.T) {
+		ev, err := es.SyncResultEvent("agent-1", app)
+		require.NoError(t, err)
+		assert.Nil(t, ev)
+	})
+
+	t.Run("No event for running operation", func(t *testing.T) {
+		app.Status.OperationState = &v1alpha1.OperationState{Phase: synccommon.OperationRunning}
+		ev, err := es.SyncResultEvent("agent-1", app)
+		require.NoError(t, err)
+		assert.Nil(t, ev)
+	})
+
+	t.Run("Event for completed operation", func(t *testing.T) {
+		started := v1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+		app.Status.OperationState = &v1alpha1.OperationState{
+			Phase:      synccommon.OperationSucceeded,
+			Message:    "done",
+			StartedAt:  started,
+			FinishedAt: &started,
+			SyncResult: &v1alpha1.SyncOperationResult{Revision: "abc"},
+		}
+		ev, err := es.SyncResultEvent("agent-1", app)
+		require.NoError(t, err)
+		require.NotNil(t, ev)
+		assert.Equal(t, SyncCompleted.String(), ev.Type())
+		assert.Equal(t, "agent-1/app", ev.Subject())
+		res, err := New(ev, TargetLifecycle).SyncResult()
+		require.NoError(t, err)
+		assert.Equal(t, "Succeeded", res.Phase)
+		assert.Equal(t, "abc", res.Revision)
+		assert.True(t, started.Time.Equal(res.StartedAt))
+		require.NotNil(t, res.FinishedAt)
+	})
+}
+
+func TestAppDeletionEvent(t *testing.T) {
+	es := NewEventSource("principal")
+	app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "agent-1"}}
+	app.Status.Resources = []v1alpha1.ResourceStatus{{Kind: "Deployment", Name: "guestbook"}}
+	ev, err := es.AppDeletionEvent(DeletionProgress, "agent-1", app, DeletionPhasePruning, "")
+	require.NoError(t, err)
+	assert.Equal(t, DeletionProgress.String(), ev.Type())
+	assert.Equal(t, "agent-1/app", ev.Subject())
+	d, err := New(ev, TargetLifecycle).AppDeletion()
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", d.Agent)
+	assert.Equal(t, "app", d.Application)
+	assert.Equal(t, DeletionPhasePruning, d.Phase)
+	assert.Equal(t, 1, d.Resources)
+}
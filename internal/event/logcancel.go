@@ -0,0 +1,86 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// LogStreamCancelRequested is sent by the principal to tell the agent
+	// that the client of a follow log stream went away.
+	LogStreamCancelRequested EventType = TypePrefix + ".log-cancel-request"
+	// LogStreamCancelAck is sent by the agent once it has torn down the
+	// container log stream.
+	LogStreamCancelAck EventType = TypePrefix + ".log-cancel-ack"
+)
+
+const TargetLogCancel EventTarget = "logCancel"
+
+// LogStreamCancel asks an agent to stop streaming the logs of a request
+type LogStreamCancel struct {
+	// UUID of the log request to cancel
+	UUID string `json:"uuid"`
+}
+
+// LogStreamCancelResult is the data of LogStreamCancelAck events
+type LogStreamCancelResult struct {
+	// UUID of the canceled log request
+	UUID string `json:"uuid"`
+	// Running is true if the agent was still streaming the logs when it
+	// received the request
+	Running bool `json:"running,omitempty"`
+	// Teardown is how long the agent took to close the container log stream
+	// after it received the request
+	Teardown time.Duration `json:"teardown,omitempty"`
+}
+
+func (evs EventSource) logCancelEvent(evType EventType, id string, data any) (*cloudevents.Event, error) {
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(evType.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetLogCancel.String())
+	err := cev.SetData(cloudevents.ApplicationJSON, data)
+	return &cev, err
+}
+
+// NewLogStreamCancelEvent creates a LogStreamCancelRequested event
+func (evs EventSource) NewLogStreamCancelEvent(req *LogStreamCancel) (*cloudevents.Event, error) {
+	return evs.logCancelEvent(LogStreamCancelRequested, req.UUID, req)
+}
+
+// LogStreamCancelAckEvent creates a LogStreamCancelAck event
+func (evs EventSource) LogStreamCancelAckEvent(res *LogStreamCancelResult) (*cloudevents.Event, error) {
+	return evs.logCancelEvent(LogStreamCancelAck, res.UUID, res)
+}
+
+// LogStreamCancel returns the data of a LogStreamCancelRequested event
+func (ev Event) LogStreamCancel() (*LogStreamCancel, error) {
+	req := &LogStreamCancel{}
+	err := ev.event.DataAs(req)
+	return req, err
+}
+
+// LogStreamCancelResult returns the data of a LogStreamCancelAck event
+func (ev Event) LogStreamCancelResult() (*LogStreamCancelResult, error) {
+	res := &LogStreamCancelResult{}
+	err := ev.event.DataAs(res)
+	return res, err
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// LogFrameSent carries a message of a log stream the agent multiplexes
+	// over the event stream, instead of opening a LogStream RPC.
+	LogFrameSent EventType = TypePrefix + ".log-frame"
+	// LogFrameCredit is sent by the principal to tell the agent which frames
+	// of a multiplexed log stream it consumed, so that the agent may send
+	// more.
+	LogFrameCredit EventType = TypePrefix + ".log-frame-credit"
+	// LogFrameClosed is sent by the principal once it ended a multiplexed
+	// log stream.
+	LogFrameClosed EventType = TypePrefix + ".log-frame-closed"
+)
+
+const TargetLogFrame EventTarget = "logFrame"
+
+// LogFrameWindow is the number of frames of a multiplexed log stream the
+// agent may send ahead of the frames the principal consumed
+const LogFrameWindow = 32
+
+// LogFrame is the data of LogFrameSent events
+type LogFrame struct {
+	// StreamID identifies the virtual log stream
+	StreamID string `json:"streamId"`
+	// Seq is the sequence number of the frame within the stream, starting
+	// at 1. Frames may arrive out of order.
+	Seq uint64 `json:"seq"`
+	// Data is the wire format of a LogStreamData message
+	Data []byte `json:"data,omitempty"`
+	// Close is set on the last frame of the stream, which carries no data
+	Close bool `json:"close,omitempty"`
+	// Canceled is set on the last frame if the agent abandoned the stream
+	Canceled bool `json:"canceled,omitempty"`
+}
+
+// LogFrameControl is the data of LogFrameCredit and LogFrameClosed events
+type LogFrameControl struct {
+	// StreamID identifies the virtual log stream
+	StreamID string `json:"streamId"`
+	// Consumed is the sequence number of the last frame the principal
+	// consumed
+	Consumed uint64 `json:"consumed,omitempty"`
+	// Response is the wire format of the LogStreamResponse the stream ended
+	// with
+	Response []byte `json:"response,omitempty"`
+	// Code is the gRPC code the stream ended with, 0 on success
+	Code uint32 `json:"code,omitempty"`
+	// Message is the message of the gRPC status the stream ended with
+	Message string `json:"message,omitempty"`
+}
+
+func (evs EventSource) logFrameEvent(evType EventType, id string, data any) (*cloudevents.Event, error) {
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(evType.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetLogFrame.String())
+	err := cev.SetData(cloudevents.ApplicationJSON, data)
+	return &cev, err
+}
+
+// LogFrameEvent creates a LogFrameSent event. Each frame has a resource ID
+// of its own, so that the event writer doesn't coalesce frames.
+func (evs EventSource) LogFrameEvent(frame *LogFrame) (*cloudevents.Event, error) {
+	return evs.logFrameEvent(LogFrameSent, fmt.Sprintf("%s/%d", frame.StreamID, frame.Seq), frame)
+}
+
+// LogFrameControlEvent creates a LogFrameCredit or LogFrameClosed event.
+// Credits of a stream share a resource ID, so that a newer one replaces an
+// older one not sent yet.
+func (evs EventSource) LogFrameControlEvent(evType EventType, ctrl *LogFrameControl) (*cloudevents.Event, error) {
+	return evs.logFrameEvent(evType, ctrl.StreamID, ctrl)
+}
+
+// LogFrame returns the data of a LogFrameSent event
+func (ev Event) LogFrame() (*LogFrame, error) {
+	frame := &LogFrame{}
+	err := ev.event.DataAs(frame)
+	return frame, err
+}
+
+// LogFrameControl returns the data of a LogFrameCredit or LogFrameClosed
+// event
+func (ev Event) LogFrameControl() (*LogFrameControl, error) {
+	ctrl := &LogFrameControl{}
+	err := ev.event.DataAs(ctrl)
+	return ctrl, err
+}
@@ -0,0 +1,67 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// PermissionsChecked is sent by the agent to the principal after it has
+// checked its Kubernetes permissions.
+const PermissionsChecked EventType = TypePrefix + ".permissions-checked"
+
+const TargetPermissions EventTarget = "permissions"
+
+// PermissionReport is the data of PermissionsChecked events
+type PermissionReport struct {
+	// Features are the enabled features of the agent the permissions were
+	// checked for
+	Features []string `json:"features,omitempty"`
+	// Missing lists the permissions the agent needs but lacks
+	Missing []string `json:"missing,omitempty"`
+	// Excess lists the permissions the agent has but doesn't need
+	Excess []string `json:"excess,omitempty"`
+	// Incomplete is true if not all permissions of the agent could be
+	// determined
+	Incomplete bool `json:"incomplete,omitempty"`
+	// Error is set if the check could not be performed
+	Error string `json:"error,omitempty"`
+	// CheckedAt is the time of the check
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// PermissionReportEvent creates a PermissionsChecked event from report
+func (evs EventSource) PermissionReportEvent(report *PermissionReport) *cloudevents.Event {
+	id := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(PermissionsChecked.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetPermissions.String())
+	_ = cev.SetData(cloudevents.ApplicationJSON, report)
+	return &cev
+}
+
+// PermissionReport returns the data of a PermissionsChecked event
+func (ev Event) PermissionReport() (*PermissionReport, error) {
+	r := &PermissionReport{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
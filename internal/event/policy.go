@@ -0,0 +1,67 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// PolicyViolationReported is sent by the agent to the principal when it
+// refused an Application because its spec violates a policy of the agent.
+const PolicyViolationReported EventType = TypePrefix + ".policy-violation"
+
+const TargetPolicyViolation EventTarget = "policyViolation"
+
+// PolicyViolation is a single violation of a policy
+type PolicyViolation struct {
+	// Rule identifies the violated rule
+	Rule string `json:"rule,omitempty"`
+	// Message describes the violation
+	Message string `json:"message"`
+}
+
+// PolicyViolationReport is the data of PolicyViolationReported events
+type PolicyViolationReport struct {
+	// Name and Namespace are those of the Application on the principal
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// UID is the UID of the Application on the principal
+	UID string `json:"uid,omitempty"`
+	// Operation is the operation the agent refused, create or update
+	Operation  string            `json:"operation"`
+	Violations []PolicyViolation `json:"violations"`
+}
+
+// PolicyViolationEvent creates a PolicyViolationReported event from report
+func (evs EventSource) PolicyViolationEvent(report *PolicyViolationReport) *cloudevents.Event {
+	id := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(PolicyViolationReported.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetPolicyViolation.String())
+	_ = cev.SetData(cloudevents.ApplicationJSON, report)
+	return &cev
+}
+
+// PolicyViolationReport returns the data of a PolicyViolationReported event
+func (ev Event) PolicyViolationReport() (*PolicyViolationReport, error) {
+	r := &PolicyViolationReport{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
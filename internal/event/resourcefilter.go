@@ -0,0 +1,67 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// ResourceFilterApplied is sent by the agent to the principal on startup
+// when it filters the resource statuses it sends to the principal.
+const ResourceFilterApplied EventType = TypePrefix + ".resource-filter-applied"
+
+// ResourceFilterRule matches resources by API group, kind and cluster, in
+// the same way as an entry of Argo CD's resource.exclusions setting
+type ResourceFilterRule struct {
+	APIGroups []string `json:"apiGroups,omitempty"`
+	Kinds     []string `json:"kinds,omitempty"`
+	Clusters  []string `json:"clusters,omitempty"`
+}
+
+// ResourceFilterReport is the data of ResourceFilterApplied events
+type ResourceFilterReport struct {
+	// Inclusions are the rules a resource must match for its status to be
+	// sent. If empty, all resources are included.
+	Inclusions []ResourceFilterRule `json:"inclusions,omitempty"`
+	// Exclusions are the rules of resources whose status is not sent
+	Exclusions []ResourceFilterRule `json:"exclusions,omitempty"`
+	// AppliedAt is the time the agent started applying the filter
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// ResourceFilterReportEvent creates a ResourceFilterApplied event from
+// report
+func (evs EventSource) ResourceFilterReportEvent(report *ResourceFilterReport) *cloudevents.Event {
+	id := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(ResourceFilterApplied.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetResourceFilter.String())
+	_ = cev.SetData(cloudevents.ApplicationJSON, report)
+	return &cev
+}
+
+// ResourceFilterReport returns the data of a ResourceFilterApplied event
+func (ev Event) ResourceFilterReport() (*ResourceFilterReport, error) {
+	r := &ResourceFilterReport{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
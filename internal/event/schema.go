@@ -0,0 +1,257 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// SchemaVersion is the version of the data schema of events exchanged between
+// principal and agent. Principal and agent negotiate the highest version both
+// of them support when the agent connects, and every event is converted to
+// the negotiated version before it is sent.
+//
+// The rules for evolving the schema are:
+//
+//   - Adding a field to an event's data requires a new schema version and a
+//     FieldRule, which tells whether the field can be dropped for peers that
+//     don't know it yet, or whether the event must be rejected instead.
+//   - A field is removed by first marking it deprecated in a new schema
+//     version. Peers speaking that version or newer no longer receive the
+//     field, older peers still do. It may be deleted from the code once no
+//     supported peer speaks a version older than the deprecation.
+//   - Changing the type or meaning of a field is not allowed. Add a new field
+//     and deprecate the old one instead.
+type SchemaVersion int
+
+const (
+	// SchemaVersionLegacy is the schema spoken by peers which do not take part
+	// in the negotiation. Their events do not carry a schema version.
+	SchemaVersionLegacy SchemaVersion = 1
+
+	// SchemaVersion2 adds limitBytes and deadline to container log requests
+	SchemaVersion2 SchemaVersion = 2
+
+	// SchemaVersion3 adds requestedBy to resource and container log requests
+	SchemaVersion3 SchemaVersion = 3
+
+	// SchemaVersion4 adds requestId to container log requests
+	SchemaVersion4 SchemaVersion = 4
+
+	// SchemaVersion5 adds sync progress events of applications
+	SchemaVersion5 SchemaVersion = 5
+
+	// SchemaVersion6 adds chunked transfer of events exceeding the maximum
+	// gRPC message size
+	SchemaVersion6 SchemaVersion = 6
+
+	// SchemaVersion7 adds diagnostic command requests to agents
+	SchemaVersion7 SchemaVersion = 7
+
+	// SchemaVersion8 adds state checksum reports of agents and requests for
+	// the state of diverged applications
+	SchemaVersion8 SchemaVersion = 8
+
+	// SchemaVersion9 adds self-update requests to agents
+	SchemaVersion9 SchemaVersion = 9
+
+	// SchemaVersion10 adds policy violation reports of agents
+	SchemaVersion10 SchemaVersion = 10
+
+	// SchemaVersion11 adds the principal's specs to requests for diverged
+	// Applications, and drift reports of autonomous agents
+	SchemaVersion11 SchemaVersion = 11
+
+	// SchemaVersion12 adds bookmarks of resources for incremental resyncs
+	SchemaVersion12 SchemaVersion = 12
+
+	// SchemaVersion13 adds cancellation of container log streams whose client
+	// went away
+	SchemaVersion13 SchemaVersion = 13
+
+	// SchemaVersion14 adds log streams multiplexed over the event stream
+	SchemaVersion14 SchemaVersion = 14
+
+	// SchemaVersion15 adds timeoutSeconds to container log requests, and
+	// deprecates their deadline
+	SchemaVersion15 SchemaVersion = 15
+
+	// CurrentSchemaVersion is the newest schema version this build supports
+	CurrentSchemaVersion = SchemaVersion15
+)
+
+// SchemaVersionMetadataKey is the gRPC metadata key used to negotiate the
+// schema version on the event stream. The agent sends the newest version it
+// supports, the principal answers with the negotiated version in the
+// response header.
+const SchemaVersionMetadataKey = "x-argocd-agent-event-schema"
+
+const schemaVersionExt = "schemaversion"
+
+// ErrFieldNotSupported is returned when an event contains a field which
+// cannot be represented in the peer's schema version.
+var ErrFieldNotSupported = errors.New("field not supported by peer")
+
+// FieldPolicy decides what happens to a field which the receiving peer does
+// not know about.
+type FieldPolicy int
+
+const (
+	// FieldDrop removes the field from the event. Use it for fields that the
+	// receiver can safely ignore.
+	FieldDrop FieldPolicy = iota
+	// FieldReject refuses to send the event, because the receiver would
+	// silently behave differently than requested without the field.
+	FieldReject
+)
+
+// FieldRule describes the lifecycle of a field in the data of events for a
+// given target.
+type FieldRule struct {
+	Target EventTarget
+	// Field is the JSON name of the field
+	Field string
+	// Since is the schema version that introduced the field
+	Since SchemaVersion
+	// Deprecated is the schema version from which on the field is no longer
+	// sent. Zero means the field is not deprecated.
+	Deprecated SchemaVersion
+	// Policy applies when sending to peers older than Since
+	Policy FieldPolicy
+}
+
+// fieldRules lists all fields that were added or deprecated after the legacy
+// schema version.
+var fieldRules = []FieldRule{
+	{Target: TargetContainerLog, Field: "limitBytes", Since: SchemaVersion2, Policy: FieldReject},
+	{Target: TargetContainerLog, Field: "deadline", Since: SchemaVersion2, Deprecated: SchemaVersion15, Policy: FieldDrop},
+	{Target: TargetContainerLog, Field: "requestedBy", Since: SchemaVersion3, Policy: FieldDrop},
+	{Target: TargetResource, Field: "requestedBy", Since: SchemaVersion3, Policy: FieldDrop},
+	{Target: TargetContainerLog, Field: "requestId", Since: SchemaVersion4, Policy: FieldDrop},
+	{Target: TargetStateChecksum, Field: "specs", Since: SchemaVersion11, Policy: FieldDrop},
+	{Target: TargetContainerLog, Field: "timeoutSeconds", Since: SchemaVersion15, Policy: FieldDrop},
+}
+
+// Capabilities returns the optional event fields understood by peers of
+// schema version v, in the form <target>.<field>.
+func Capabilities(v SchemaVersion) []string {
+	caps := []string{}
+	for _, r := range fieldRules {
+		if v >= r.Since && (r.Deprecated == 0 || v < r.Deprecated) {
+			caps = append(caps, r.Target.String()+"."+r.Field)
+		}
+	}
+	return caps
+}
+
+// ParseSchemaVersion parses a schema version as sent by a peer. Peers which
+// do not send a version speak the legacy schema.
+func ParseSchemaVersion(s string) (SchemaVersion, error) {
+	if s == "" {
+		return SchemaVersionLegacy, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < int(SchemaVersionLegacy) {
+		return 0, fmt.Errorf("invalid event schema version: %q", s)
+	}
+	return SchemaVersion(v), nil
+}
+
+// NegotiateSchemaVersion returns the schema version to use with a peer that
+// supports schema versions up to peer.
+func NegotiateSchemaVersion(peer SchemaVersion) SchemaVersion {
+	if peer < SchemaVersionLegacy {
+		return SchemaVersionLegacy
+	}
+	return min(peer, CurrentSchemaVersion)
+}
+
+func (v SchemaVersion) String() string {
+	return strconv.Itoa(int(v))
+}
+
+// EventSchemaVersion returns the schema version of the given event
+func EventSchemaVersion(ev *cloudevents.Event) SchemaVersion {
+	val, ok := ev.Extensions()[schemaVersionExt]
+	if !ok {
+		return SchemaVersionLegacy
+	}
+	var v SchemaVersion
+	switch val := val.(type) {
+	case int32:
+		v = SchemaVersion(val)
+	case string:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return SchemaVersionLegacy
+		}
+		v = SchemaVersion(i)
+	}
+	if v < SchemaVersionLegacy {
+		return SchemaVersionLegacy
+	}
+	return v
+}
+
+// ForSchemaVersion returns a copy of ev that conforms to the given schema
+// version, according to the field rules. If the event contains a field which
+// must not be dropped, an error wrapping ErrFieldNotSupported is returned.
+func ForSchemaVersion(ev *cloudevents.Event, v SchemaVersion) (*cloudevents.Event, error) {
+	out := ev.Clone()
+	if v >= SchemaVersion2 {
+		out.SetExtension(schemaVersionExt, int32(v))
+	}
+
+	target := Target(ev)
+	var applicable []FieldRule
+	for _, r := range fieldRules {
+		if r.Target != target {
+			continue
+		}
+		if v < r.Since || (r.Deprecated > 0 && v >= r.Deprecated) {
+			applicable = append(applicable, r)
+		}
+	}
+	if len(applicable) == 0 || len(ev.Data()) == 0 {
+		return &out, nil
+	}
+
+	data := map[string]json.RawMessage{}
+	if err := json.Unmarshal(ev.Data(), &data); err != nil {
+		return nil, fmt.Errorf("could not convert event to schema version %d: %w", v, err)
+	}
+	changed := false
+	for _, r := range applicable {
+		if _, ok := data[r.Field]; !ok {
+			continue
+		}
+		if v < r.Since && r.Policy == FieldReject {
+			return nil, fmt.Errorf("%w: %s requires event schema version %d, peer supports %d", ErrFieldNotSupported, r.Field, r.Since, v)
+		}
+		delete(data, r.Field)
+		changed = true
+	}
+	if changed {
+		if err := out.SetData(ev.DataContentType(), data); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
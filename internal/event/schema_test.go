@@ -0,0 +1,125 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchemaVersion(t *testing.T) {
+	v, err := ParseSchemaVersion("")
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersionLegacy, v)
+	v, err = ParseSchemaVersion("2")
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersion2, v)
+	_, err = ParseSchemaVersion("0")
+	assert.Error(t, err)
+	_, err = ParseSchemaVersion("two")
+	assert.Error(t, err)
+}
+
+func TestNegotiateSchemaVersion(t *testing.T) {
+	assert.Equal(t, SchemaVersionLegacy, NegotiateSchemaVersion(0))
+	assert.Equal(t, SchemaVersionLegacy, NegotiateSchemaVersion(SchemaVersionLegacy))
+	assert.Equal(t, CurrentSchemaVersion, NegotiateSchemaVersion(CurrentSchemaVersion))
+	assert.Equal(t, CurrentSchemaVersion, NegotiateSchemaVersion(CurrentSchemaVersion+5))
+}
+
+func TestCapabilities(t *testing.T) {
+	assert.Empty(t, Capabilities(SchemaVersionLegacy))
+	assert.Equal(t, []string{"containerlog.limitBytes", "containerlog.deadline"}, Capabilities(SchemaVersion2))
+	assert.Contains(t, Capabilities(SchemaVersion4), "containerlog.requestId")
+	assert.NotContains(t, Capabilities(SchemaVersion3), "containerlog.requestId")
+}
+
+func TestForSchemaVersion(t *testing.T) {
+	es := NewEventSource("test")
+	timeout := time.Minute
+
+	t.Run("Current version keeps all fields", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", map[string]string{"limitBytes": "10"}, timeout)
+		require.NoError(t, err)
+		out, err := ForSchemaVersion(ev, CurrentSchemaVersion)
+		require.NoError(t, err)
+		assert.Equal(t, CurrentSchemaVersion, EventSchemaVersion(out))
+		logReq, err := New(out, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		require.NotNil(t, logReq.LimitBytes)
+		require.NotNil(t, logReq.TimeoutSeconds)
+		// The deadline is deprecated in favor of the timeout
+		assert.Nil(t, logReq.Deadline)
+	})
+
+	t.Run("Version before the timeout gets the deadline", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", nil, timeout)
+		require.NoError(t, err)
+		out, err := ForSchemaVersion(ev, SchemaVersion14)
+		require.NoError(t, err)
+		logReq, err := New(out, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		assert.Nil(t, logReq.TimeoutSeconds)
+		assert.NotNil(t, logReq.Deadline)
+	})
+
+	t.Run("Legacy version drops deadline", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", map[string]string{"container": "c"}, timeout)
+		require.NoError(t, err)
+		out, err := ForSchemaVersion(ev, SchemaVersionLegacy)
+		require.NoError(t, err)
+		assert.Equal(t, SchemaVersionLegacy, EventSchemaVersion(out))
+		data := map[string]any{}
+		require.NoError(t, json.Unmarshal(out.Data(), &data))
+		assert.NotContains(t, data, "deadline")
+		assert.NotContains(t, data, "timeoutSeconds")
+		assert.Equal(t, "c", data["container"])
+		// The original event is left untouched
+		logReq, err := New(ev, TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		assert.NotNil(t, logReq.Deadline)
+	})
+
+	t.Run("Legacy version rejects limitBytes", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", map[string]string{"limitBytes": "10"}, 0)
+		require.NoError(t, err)
+		_, err = ForSchemaVersion(ev, SchemaVersionLegacy)
+		assert.ErrorIs(t, err, ErrFieldNotSupported)
+	})
+
+	t.Run("Events without rules are passed through", func(t *testing.T) {
+		ev := es.HeartbeatEvent(Ping)
+		out, err := ForSchemaVersion(ev, SchemaVersionLegacy)
+		require.NoError(t, err)
+		assert.Equal(t, ev.Data(), out.Data())
+	})
+
+	t.Run("Schema version survives the wire format", func(t *testing.T) {
+		ev, err := es.NewLogRequestEvent("ns", "pod", "GET", nil, 0)
+		require.NoError(t, err)
+		out, err := ForSchemaVersion(ev, CurrentSchemaVersion)
+		require.NoError(t, err)
+		pev, err := format.ToProto(out)
+		require.NoError(t, err)
+		wev, err := format.FromProto(pev)
+		require.NoError(t, err)
+		assert.Equal(t, CurrentSchemaVersion, EventSchemaVersion(wev))
+	})
+}
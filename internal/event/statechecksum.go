@@ -0,0 +1,101 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// StateChecksumReported is sent periodically by the agent to the
+	// principal with the checksums of the state of its Applications.
+	StateChecksumReported EventType = TypePrefix + ".state-checksum"
+	// ApplicationsDiverged is sent by the principal to ask the agent to send
+	// the state of Applications whose checksums didn't match.
+	ApplicationsDiverged EventType = TypePrefix + ".applications-diverged"
+)
+
+const TargetStateChecksum EventTarget = "statechecksum"
+
+// ApplicationChecksum holds the checksums of the state of a single
+// Application
+type ApplicationChecksum struct {
+	// UID is the UID of the Application on the side that owns it, which
+	// the other side holds in the source UID annotation
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+	// Spec is the checksum of the Application's spec
+	Spec string `json:"spec"`
+	// Status is the checksum of the Application's sync and health status
+	Status string `json:"status"`
+}
+
+// StateChecksum is the data of StateChecksumReported events
+type StateChecksum struct {
+	// Checksum is the aggregate checksum of all Applications, which lets
+	// the principal skip comparing them one by one
+	Checksum     string                `json:"checksum"`
+	Applications []ApplicationChecksum `json:"applications,omitempty"`
+}
+
+// DivergedApplications is the data of ApplicationsDiverged events
+type DivergedApplications struct {
+	// UIDs of the Applications the agent should send its state of
+	UIDs []string `json:"uids"`
+	// Specs are the principal's specs of the Applications whose specs
+	// diverged, keyed by UID. Autonomous agents report how they drifted from
+	// their own.
+	Specs map[string]v1alpha1.ApplicationSpec `json:"specs,omitempty"`
+}
+
+// StateChecksumEvent creates a StateChecksumReported event from report
+func (evs EventSource) StateChecksumEvent(report *StateChecksum) *cloudevents.Event {
+	return evs.stateChecksumEvent(StateChecksumReported, report)
+}
+
+// ApplicationsDivergedEvent creates an ApplicationsDiverged event from
+// diverged
+func (evs EventSource) ApplicationsDivergedEvent(diverged *DivergedApplications) *cloudevents.Event {
+	return evs.stateChecksumEvent(ApplicationsDiverged, diverged)
+}
+
+func (evs EventSource) stateChecksumEvent(evType EventType, data any) *cloudevents.Event {
+	id := uuid.NewString()
+	cev := cloudevents.NewEvent()
+	cev.SetSource(evs.source)
+	cev.SetSpecVersion(cloudEventSpecVersion)
+	cev.SetType(evType.String())
+	cev.SetExtension(eventID, id)
+	cev.SetExtension(resourceID, id)
+	cev.SetDataSchema(TargetStateChecksum.String())
+	_ = cev.SetData(cloudevents.ApplicationJSON, data)
+	return &cev
+}
+
+// StateChecksum returns the data of a StateChecksumReported event
+func (ev Event) StateChecksum() (*StateChecksum, error) {
+	r := &StateChecksum{}
+	err := ev.event.DataAs(r)
+	return r, err
+}
+
+// DivergedApplications returns the data of an ApplicationsDiverged event
+func (ev Event) DivergedApplications() (*DivergedApplications, error) {
+	d := &DivergedApplications{}
+	err := ev.event.DataAs(d)
+	return d, err
+}
@@ -0,0 +1,289 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package eventrecord records the events exchanged between principal and agent
+on the event stream, and replays recorded events into a principal or an agent.
+Recordings are files with one JSON encoded Record per line, so that problems
+in event handling can be reproduced deterministically from captures provided
+by users. Payloads are redacted before they are written.
+*/
+package eventrecord
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Direction is the direction an event was exchanged in, as seen by the
+// component which recorded it
+type Direction string
+
+const (
+	// DirectionSend marks events sent by the recording component
+	DirectionSend Direction = "send"
+	// DirectionRecv marks events received by the recording component
+	DirectionRecv Direction = "recv"
+)
+
+// Record is a single recorded event
+type Record struct {
+	// Time is when the event was recorded
+	Time time.Time `json:"time"`
+	// Direction is the direction the event was exchanged in
+	Direction Direction `json:"direction"`
+	// Agent is the name of the agent the event was exchanged with. It is
+	// empty in recordings made by an agent.
+	Agent string `json:"agent,omitempty"`
+	// Event is the recorded event
+	Event *cloudevents.Event `json:"event"`
+}
+
+// Recorder writes records to a file. It is safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	c      io.Closer
+	redact Redaction
+	now    func() time.Time
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(r *Recorder)
+
+// WithRedaction sets how payloads are redacted. The default is RedactSecrets.
+func WithRedaction(redact Redaction) RecorderOption {
+	return func(r *Recorder) {
+		r.redact = redact
+	}
+}
+
+// NewRecorder returns a Recorder writing to w. If w is an io.Closer, it is
+// closed when the Recorder is closed.
+func NewRecorder(w io.Writer, opts ...RecorderOption) *Recorder {
+	r := &Recorder{
+		w:      bufio.NewWriter(w),
+		redact: RedactSecrets,
+		now:    time.Now,
+	}
+	if c, ok := w.(io.Closer); ok {
+		r.c = c
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// NewFileRecorder returns a Recorder writing to the file at path, which is
+// truncated if it exists.
+func NewFileRecorder(path string, opts ...RecorderOption) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create event recording: %w", err)
+	}
+	return NewRecorder(f, opts...), nil
+}
+
+// Record records the event ev exchanged in direction dir with agent. The
+// recorded event is a redacted copy, ev itself is not modified. Errors are
+// logged, as recording must not interfere with processing.
+func (r *Recorder) Record(dir Direction, agent string, ev *cloudevents.Event) {
+	if r == nil || ev == nil {
+		return
+	}
+	rec := Record{
+		Time:      r.now(),
+		Direction: dir,
+		Agent:     agent,
+		Event:     r.redact.apply(ev),
+	}
+	completeAttributes(rec.Event, agent)
+	b, err := json.Marshal(rec)
+	if err != nil {
+		logrus.WithError(err).WithField("event_id", event.EventID(ev)).Warn("Could not record event")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(b, '\n')); err != nil {
+		logrus.WithError(err).Warn("Could not record event")
+		return
+	}
+	// Flush right away, so the recording is usable even if the process
+	// does not terminate gracefully.
+	if err := r.w.Flush(); err != nil {
+		logrus.WithError(err).Warn("Could not record event")
+	}
+}
+
+// completeAttributes sets the CloudEvents attributes ev lacks, which the
+// event stream does not require but reading the recording does. Events are
+// identified by their event ID extension, and only the principal, which
+// records the agent's name, sends events without a source.
+func completeAttributes(ev *cloudevents.Event, agent string) {
+	if ev.ID() == "" {
+		id := event.EventID(ev)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		ev.SetID(id)
+	}
+	if ev.Source() == "" {
+		if agent != "" {
+			ev.SetSource("principal")
+		} else {
+			ev.SetSource("agent")
+		}
+	}
+}
+
+// Close flushes the recording and closes the underlying writer
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if r.c != nil {
+		if cerr := r.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ReadRecords reads all records from rd
+func ReadRecords(rd io.Reader) ([]Record, error) {
+	var records []Record
+	s := bufio.NewScanner(rd)
+	s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Event == nil {
+			return nil, fmt.Errorf("line %d: no event", line)
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
+
+// ReadFile reads all records from the recording at path
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecords(f)
+}
+
+// ReplayFunc is called for each replayed record
+type ReplayFunc func(rec Record) error
+
+type replayOptions struct {
+	direction Direction
+	agent     string
+	realTime  bool
+	skipAcks  bool
+}
+
+// ReplayOption configures Replay
+type ReplayOption func(o *replayOptions)
+
+// WithDirection only replays records exchanged in direction dir
+func WithDirection(dir Direction) ReplayOption {
+	return func(o *replayOptions) {
+		o.direction = dir
+	}
+}
+
+// WithAgent only replays records exchanged with agent
+func WithAgent(agent string) ReplayOption {
+	return func(o *replayOptions) {
+		o.agent = agent
+	}
+}
+
+// WithRealTime keeps the time between records as recorded, instead of
+// replaying them as fast as possible
+func WithRealTime() ReplayOption {
+	return func(o *replayOptions) {
+		o.realTime = true
+	}
+}
+
+// WithoutAcks skips acknowledgements of processed events, which only make
+// sense to the event writer that sent the original event
+func WithoutAcks() ReplayOption {
+	return func(o *replayOptions) {
+		o.skipAcks = true
+	}
+}
+
+// Replay calls fn for each record in records that matches the options, in
+// the order recorded. It stops at the first error returned by fn.
+func Replay(ctx context.Context, records []Record, fn ReplayFunc, opts ...ReplayOption) error {
+	o := &replayOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var last time.Time
+	for i, rec := range records {
+		if o.direction != "" && rec.Direction != o.direction {
+			continue
+		}
+		if o.agent != "" && rec.Agent != o.agent {
+			continue
+		}
+		if o.skipAcks && event.Target(rec.Event) == event.TargetEventAck {
+			continue
+		}
+		if o.realTime && !last.IsZero() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rec.Time.Sub(last)):
+			}
+		}
+		last = rec.Time
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return fmt.Errorf("record %d (event %s): %w", i, event.EventID(rec.Event), err)
+		}
+	}
+	return nil
+}
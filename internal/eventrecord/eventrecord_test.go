@@ -0,0 +1,180 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventrecord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_RecordAndRead(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	app := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "agent", UID: "1234", ResourceVersion: "1"}}
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	r, err := NewFileRecorder(path)
+	require.NoError(t, err)
+	r.Record(DirectionSend, "agent", evs.ApplicationEvent(event.Create, app))
+	r.Record(DirectionRecv, "agent", evs.ProcessedEvent(event.EventProcessed, event.New(evs.ApplicationEvent(event.Create, app), event.TargetApplication)))
+	require.NoError(t, r.Close())
+
+	records, err := ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, DirectionSend, records[0].Direction)
+	assert.Equal(t, "agent", records[0].Agent)
+	assert.Equal(t, event.TargetApplication, event.Target(records[0].Event))
+	got := &v1alpha1.Application{}
+	require.NoError(t, records[0].Event.DataAs(got))
+	assert.Equal(t, "app", got.Name)
+	assert.Equal(t, event.TargetEventAck, event.Target(records[1].Event))
+
+	t.Run("Events without ID and source can be read", func(t *testing.T) {
+		ev, err := event.NewEventSource("").RequestResourceResyncEvent()
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		r := NewRecorder(&buf)
+		r.Record(DirectionSend, "agent", ev)
+		require.NoError(t, r.Close())
+		records, err := ReadRecords(&buf)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, event.EventID(ev), records[0].Event.ID())
+		assert.Equal(t, "principal", records[0].Event.Source())
+		assert.Empty(t, ev.ID(), "the recorded event must not be modified")
+	})
+
+	t.Run("Nil recorder does nothing", func(t *testing.T) {
+		var r *Recorder
+		r.Record(DirectionSend, "agent", evs.ApplicationEvent(event.Create, app))
+		assert.NoError(t, r.Close())
+	})
+
+	t.Run("Invalid recording", func(t *testing.T) {
+		_, err := ReadRecords(bytes.NewBufferString("{}\n"))
+		assert.ErrorContains(t, err, "line 1")
+		_, err = ReadRecords(bytes.NewBufferString("not json\n"))
+		assert.Error(t, err)
+	})
+}
+
+func Test_Redaction(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "argocd"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}
+	repoEv := evs.RepositoryEvent(event.Create, secret)
+	b, err := json.Marshal(secret)
+	require.NoError(t, err)
+	resEv := evs.NewResourceResponseEvent("uuid", 200, string(b))
+
+	t.Run("Secrets", func(t *testing.T) {
+		red := RedactSecrets.apply(repoEv)
+		got := &corev1.Secret{}
+		require.NoError(t, red.DataAs(got))
+		assert.Equal(t, "repo", got.Name)
+		assert.NotEqual(t, []byte("hunter2"), got.Data["password"])
+		assert.NotContains(t, string(red.Data()), "aHVudGVyMg==")
+		// The original event must not be modified
+		assert.Contains(t, string(repoEv.Data()), "aHVudGVyMg==")
+
+		red = RedactSecrets.apply(resEv)
+		assert.NotContains(t, string(red.Data()), "aHVudGVyMg==")
+		resp := &event.ResourceResponse{}
+		require.NoError(t, red.DataAs(resp))
+		assert.Equal(t, "uuid", resp.UUID)
+		assert.Contains(t, resp.Resource, redactedValue)
+	})
+
+	t.Run("Other resources are not redacted", func(t *testing.T) {
+		ev := evs.NewResourceResponseEvent("uuid", 200, `{"kind":"ConfigMap","data":{"key":"value"}}`)
+		red := RedactSecrets.apply(ev)
+		assert.Equal(t, ev.Data(), red.Data())
+	})
+
+	t.Run("All", func(t *testing.T) {
+		red := RedactAll.apply(repoEv)
+		assert.Empty(t, red.Data())
+		assert.Equal(t, repoEv.ID(), red.ID())
+	})
+
+	t.Run("None", func(t *testing.T) {
+		red := RedactNone.apply(repoEv)
+		assert.Equal(t, repoEv.Data(), red.Data())
+	})
+
+	t.Run("Parse", func(t *testing.T) {
+		r, err := ParseRedaction("")
+		require.NoError(t, err)
+		assert.Equal(t, RedactSecrets, r)
+		r, err = ParseRedaction("all")
+		require.NoError(t, err)
+		assert.Equal(t, RedactAll, r)
+		_, err = ParseRedaction("some")
+		assert.Error(t, err)
+	})
+}
+
+func Test_Replay(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	app := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "agent"}}
+	now := time.Now()
+	records := []Record{
+		{Time: now, Direction: DirectionSend, Agent: "agent-1", Event: evs.ApplicationEvent(event.Create, app)},
+		{Time: now.Add(50 * time.Millisecond), Direction: DirectionRecv, Agent: "agent-1", Event: evs.ProcessedEvent(event.EventProcessed, event.New(evs.ApplicationEvent(event.Create, app), event.TargetApplication))},
+		{Time: now.Add(100 * time.Millisecond), Direction: DirectionSend, Agent: "agent-2", Event: evs.ApplicationEvent(event.SpecUpdate, app)},
+		{Time: now.Add(150 * time.Millisecond), Direction: DirectionSend, Agent: "agent-1", Event: evs.ApplicationEvent(event.Delete, app)},
+	}
+	replay := func(opts ...ReplayOption) []string {
+		var types []string
+		err := Replay(context.Background(), records, func(rec Record) error {
+			types = append(types, rec.Event.Type())
+			return nil
+		}, opts...)
+		require.NoError(t, err)
+		return types
+	}
+
+	assert.Len(t, replay(), 4)
+	assert.Equal(t, []string{event.Create.String(), event.Delete.String()}, replay(WithDirection(DirectionSend), WithAgent("agent-1")))
+	assert.Len(t, replay(WithoutAcks()), 3)
+
+	start := time.Now()
+	replay(WithRealTime())
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	t.Run("Stops on error", func(t *testing.T) {
+		n := 0
+		err := Replay(context.Background(), records, func(rec Record) error {
+			n++
+			return assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, 1, n)
+	})
+}
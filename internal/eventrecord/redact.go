@@ -0,0 +1,131 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventrecord
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Redaction determines which parts of event payloads are removed before
+// events are recorded
+type Redaction string
+
+const (
+	// RedactNone records payloads as they are
+	RedactNone Redaction = "none"
+	// RedactSecrets replaces the values of Secrets, such as repository
+	// credentials and Secrets returned by the resource proxy
+	RedactSecrets Redaction = "secrets"
+	// RedactAll removes all payloads, recording only the events' attributes
+	RedactAll Redaction = "all"
+)
+
+// redactedValue replaces redacted values
+const redactedValue = "REDACTED"
+
+// ParseRedaction parses the name of a redaction
+func ParseRedaction(s string) (Redaction, error) {
+	switch r := Redaction(s); r {
+	case RedactNone, RedactSecrets, RedactAll:
+		return r, nil
+	case "":
+		return RedactSecrets, nil
+	default:
+		return "", fmt.Errorf("unknown redaction %q: must be one of none, secrets, all", s)
+	}
+}
+
+// apply returns a copy of ev with the payload redacted
+func (r Redaction) apply(ev *cloudevents.Event) *cloudevents.Event {
+	c := ev.Clone()
+	switch r {
+	case RedactNone:
+	case RedactAll:
+		c.DataEncoded = nil
+	default:
+		redactSecrets(&c)
+	}
+	return &c
+}
+
+// redactSecrets replaces the values of Secrets carried by ev. Payloads that
+// cannot be parsed are removed, rather than risking to leak them.
+func redactSecrets(ev *cloudevents.Event) {
+	switch event.Target(ev) {
+	case event.TargetRepository:
+		var obj map[string]interface{}
+		if err := json.Unmarshal(ev.Data(), &obj); err != nil {
+			ev.DataEncoded = nil
+			return
+		}
+		redactSecretObject(obj)
+		ev.DataEncoded, _ = json.Marshal(obj)
+	case event.TargetResource:
+		resp := &event.ResourceResponse{}
+		if err := ev.DataAs(resp); err != nil || resp.Resource == "" {
+			return
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(resp.Resource), &obj); err != nil {
+			resp.Resource = redactedValue
+		} else if redactResource(obj) {
+			b, _ := json.Marshal(obj)
+			resp.Resource = string(b)
+		} else {
+			return
+		}
+		ev.DataEncoded, _ = json.Marshal(resp)
+	}
+}
+
+// redactResource redacts obj if it is a Secret or a list containing Secrets,
+// and returns whether anything was redacted.
+func redactResource(obj map[string]interface{}) bool {
+	redacted := false
+	if obj["kind"] == "Secret" {
+		redactSecretObject(obj)
+		redacted = true
+	}
+	if items, ok := obj["items"].([]interface{}); ok {
+		for _, it := range items {
+			if m, ok := it.(map[string]interface{}); ok && redactResource(m) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+func redactSecretObject(obj map[string]interface{}) {
+	for _, field := range []string{"data", "stringData"} {
+		if data, ok := obj[field].(map[string]interface{}); ok {
+			for k := range data {
+				data[k] = redactedValue
+			}
+		}
+	}
+	// The last applied configuration contains the Secret's values as well
+	if md, ok := obj["metadata"].(map[string]interface{}); ok {
+		if ann, ok := md["annotations"].(map[string]interface{}); ok {
+			if _, ok := ann["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+				ann["kubectl.kubernetes.io/last-applied-configuration"] = redactedValue
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TopicMapper(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	mapping, err := ParseTopicMapping([]string{"sync-completed=syncs", event.AgentDisconnected.String() + "=gone"})
+	require.NoError(t, err)
+	m, err := NewTopicMapper("", mapping)
+	require.NoError(t, err)
+
+	ev, _ := evs.AgentLifecycleEvent(event.AgentConnected, "agent", "")
+	assert.Equal(t, DefaultTopic, m.Topic(ev))
+	ev, _ = evs.AgentLifecycleEvent(event.AgentDisconnected, "agent", "")
+	assert.Equal(t, "gone", m.Topic(ev))
+	ev.SetType(event.SyncCompleted.String())
+	assert.Equal(t, "syncs", m.Topic(ev))
+
+	_, err = ParseTopicMapping([]string{"sync-completed"})
+	assert.Error(t, err)
+	_, err = ParseTopicMapping([]string{"=topic"})
+	assert.Error(t, err)
+}
+
+// fakeNATSServer implements just enough of the NATS server protocol to accept
+// publishes and, optionally, acknowledge them like a JetStream stream would.
+type fakeNATSServer struct {
+	l         net.Listener
+	jetStream bool
+	ackError  string
+
+	mu       sync.Mutex
+	connects []string
+	subjects []string
+	payloads [][]byte
+}
+
+func newFakeNATSServer(t *testing.T, jetStream bool) *fakeNATSServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeNATSServer{l: l, jetStream: jetStream}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = l.Close() })
+	return s
+}
+
+func (s *fakeNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	subs := fakeNATSSubs{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			s.mu.Lock()
+			s.connects = append(s.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT")))
+			s.mu.Unlock()
+		case "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			subs[fields[1]] = fields[2]
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.subjects = append(s.subjects, fields[1])
+			s.payloads = append(s.payloads, payload[:size])
+			ackError := s.ackError
+			s.mu.Unlock()
+			if len(fields) == 4 && s.jetStream {
+				ack := `{"stream":"EVENTS","seq":1}`
+				if ackError != "" {
+					ack = fmt.Sprintf(`{"error":{"code":503,"description":%q}}`, ackError)
+				}
+				_, _ = fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", fields[2], subs.match(fields[2]), len(ack), ack)
+			}
+		}
+	}
+}
+
+// fakeNATSSubs maps subjects to subscription IDs. Subjects may end with the
+// wildcard *, like the inbox the NATS client receives replies on.
+type fakeNATSSubs map[string]string
+
+func (subs fakeNATSSubs) match(subject string) string {
+	for s, sid := range subs {
+		if s == subject || (strings.HasSuffix(s, ".*") && strings.HasPrefix(subject, strings.TrimSuffix(s, "*"))) {
+			return sid
+		}
+	}
+	return ""
+}
+
+func Test_NATSSink(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	topics, err := NewTopicMapper("events", map[string]string{"sync-completed": "syncs"})
+	require.NoError(t, err)
+
+	t.Run("Core NATS", func(t *testing.T) {
+		srv := newFakeNATSServer(t, false)
+		sink, err := NewNATSSink("nats://s3cr3t@"+srv.l.Addr().String(), topics)
+		require.NoError(t, err)
+		defer sink.Close()
+		for range 2 {
+			ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+			require.NoError(t, err)
+			require.NoError(t, sink.Publish(context.Background(), ev))
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		// The connection is reused
+		require.Len(t, srv.connects, 1)
+		assert.Contains(t, srv.connects[0], `"auth_token":"s3cr3t"`)
+		assert.Equal(t, []string{"events", "events"}, srv.subjects)
+		got, err := event.FromStructured(srv.payloads[0])
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", got.Subject())
+	})
+
+	t.Run("JetStream", func(t *testing.T) {
+		srv := newFakeNATSServer(t, true)
+		sink, err := NewNATSSink("nats://user:pass@"+srv.l.Addr().String(), topics, WithJetStream())
+		require.NoError(t, err)
+		defer sink.Close()
+		ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		ev.SetType(event.SyncCompleted.String())
+		require.NoError(t, sink.Publish(context.Background(), ev))
+		srv.mu.Lock()
+		assert.Equal(t, []string{"syncs"}, srv.subjects)
+		assert.Contains(t, srv.connects[0], `"user":"user"`)
+		srv.ackError = "no stream"
+		srv.mu.Unlock()
+		assert.ErrorContains(t, sink.Publish(context.Background(), ev), "no stream")
+	})
+
+	t.Run("Server unavailable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		require.NoError(t, l.Close())
+		sink, err := NewNATSSink("nats://"+addr, topics)
+		require.NoError(t, err)
+		ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		assert.Error(t, sink.Publish(context.Background(), ev))
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		_, err := NewNATSSink("http://localhost:4222", topics)
+		assert.Error(t, err)
+	})
+}
+
+func Test_KafkaRESTSink(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	topics, err := NewTopicMapper("events", nil)
+	require.NoError(t, err)
+
+	t.Run("Produces record", func(t *testing.T) {
+		var path, contentType string
+		req := kafkaProduceRequest{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			contentType = r.Header.Get("Content-Type")
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			_, _ = io.WriteString(w, `{"offsets":[{"partition":0,"offset":42,"error_code":null,"error":null}]}`)
+		}))
+		defer srv.Close()
+		sink, err := NewKafkaRESTSink(srv.URL+"/", topics)
+		require.NoError(t, err)
+		ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		require.NoError(t, sink.Publish(context.Background(), ev))
+		assert.Equal(t, "/topics/events", path)
+		assert.Equal(t, kafkaRESTContentType, contentType)
+		require.Len(t, req.Records, 1)
+		assert.Equal(t, "agent-1", req.Records[0].Key)
+		got, err := event.FromStructured(req.Records[0].Value)
+		require.NoError(t, err)
+		assert.Equal(t, ev.ID(), got.ID())
+	})
+
+	t.Run("Record error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"not leader"}]}`)
+		}))
+		defer srv.Close()
+		sink, err := NewKafkaRESTSink(srv.URL, topics)
+		require.NoError(t, err)
+		ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		assert.ErrorContains(t, sink.Publish(context.Background(), ev), "not leader")
+	})
+}
+
+func Test_New(t *testing.T) {
+	s, err := New(Config{URL: "https://example.com"})
+	require.NoError(t, err)
+	assert.IsType(t, &HTTPSink{}, s)
+	s, err = New(Config{Type: TypeJetStream, URL: "nats://localhost"})
+	require.NoError(t, err)
+	assert.True(t, s.(*NATSSink).jetStream)
+	s, err = New(Config{Type: TypeKafkaREST, URL: "http://proxy:8082"})
+	require.NoError(t, err)
+	assert.IsType(t, &KafkaRESTSink{}, s)
+	_, err = New(Config{Type: "carrier-pigeon", URL: "http://example.com"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	TypeHTTP      = "http"
+	TypeNATS      = "nats"
+	TypeJetStream = "jetstream"
+	TypeKafkaREST = "kafka-rest"
+)
+
+// Config describes a sink in a way that can be set from the command line
+type Config struct {
+	// Type is one of TypeHTTP, TypeNATS, TypeJetStream or TypeKafkaREST
+	Type string
+	// URL is the endpoint of the webhook, the NATS server or the Kafka REST
+	// proxy, depending on the type.
+	URL string
+	// Headers are sent with every HTTP request. Only used for types based
+	// on HTTP.
+	Headers http.Header
+	// Topic is the default topic or subject for types based on a broker
+	Topic string
+	// TopicMapping maps event types to topics or subjects
+	TopicMapping map[string]string
+}
+
+// New returns the sink described by cfg
+func New(cfg Config) (Sink, error) {
+	var httpOpts []HTTPSinkOption
+	for k, values := range cfg.Headers {
+		for _, v := range values {
+			httpOpts = append(httpOpts, WithHeader(k, v))
+		}
+	}
+	switch cfg.Type {
+	case TypeHTTP, "":
+		return NewHTTPSink(cfg.URL, httpOpts...)
+	case TypeNATS, TypeJetStream, TypeKafkaREST:
+		topics, err := NewTopicMapper(cfg.Topic, cfg.TopicMapping)
+		if err != nil {
+			return nil, err
+		}
+		switch cfg.Type {
+		case TypeNATS:
+			return NewNATSSink(cfg.URL, topics)
+		case TypeJetStream:
+			return NewNATSSink(cfg.URL, topics, WithJetStream())
+		default:
+			return NewKafkaRESTSink(cfg.URL, topics, httpOpts...)
+		}
+	default:
+		return nil, fmt.Errorf("unknown event sink type %q", cfg.Type)
+	}
+}
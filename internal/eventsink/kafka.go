@@ -0,0 +1,98 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	kafkaRESTContentType = "application/vnd.kafka.json.v2+json"
+)
+
+// KafkaRESTSink produces events to Kafka topics through a Kafka REST proxy,
+// using the v2 REST API. Records are keyed by the event's subject, so that
+// all events of an agent or application end up in the same partition and
+// keep their order.
+type KafkaRESTSink struct {
+	http   *HTTPSink
+	base   string
+	topics *TopicMapper
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition *int32 `json:"partition"`
+		Offset    *int64 `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// NewKafkaRESTSink returns a sink producing events through the Kafka REST
+// proxy at proxyURL. The HTTP options are applied to the requests made to
+// the proxy.
+func NewKafkaRESTSink(proxyURL string, topics *TopicMapper, opts ...HTTPSinkOption) (*KafkaRESTSink, error) {
+	h, err := NewHTTPSink(proxyURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaRESTSink{http: h, base: strings.TrimSuffix(proxyURL, "/"), topics: topics}, nil
+}
+
+// Publish implements Sink. It only returns successfully once the proxy has
+// confirmed that the record was written to the topic.
+func (s *KafkaRESTSink) Publish(ctx context.Context, ev *cloudevents.Event) error {
+	value, err := event.ToStructured(ev)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(kafkaProduceRequest{Records: []kafkaRecord{{Key: ev.Subject(), Value: value}}})
+	if err != nil {
+		return err
+	}
+	topic := s.topics.Topic(ev)
+	respBody, err := s.http.post(ctx, s.base+"/topics/"+url.PathEscape(topic), kafkaRESTContentType, body)
+	if err != nil {
+		return fmt.Errorf("could not produce to topic %s: %w", topic, err)
+	}
+	resp := kafkaProduceResponse{}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return fmt.Errorf("could not decode response of Kafka REST proxy: %w", err)
+	}
+	if len(resp.Offsets) != 1 {
+		return fmt.Errorf("unexpected number of offsets in response of Kafka REST proxy: %d", len(resp.Offsets))
+	}
+	if o := resp.Offsets[0]; o.ErrorCode != nil || o.Error != "" {
+		return fmt.Errorf("could not produce to topic %s: %s", topic, o.Error)
+	}
+	return nil
+}
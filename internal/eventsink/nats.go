@@ -0,0 +1,181 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	defaultNATSPort    = "4222"
+	defaultNATSTimeout = 10 * time.Second
+	natsClientName     = "argocd-agent-principal"
+)
+
+// NATSSink publishes events to NATS subjects. The connection is established
+// on the first publish and kept by the NATS client, which reconnects when it
+// breaks. Once the client gives up, the next publish connects anew.
+//
+// Every publish is confirmed before Publish returns: in core NATS mode by
+// flushing the connection, i.e. a PING/PONG round trip, which guarantees the
+// server has processed the message; in JetStream mode by the stream's publish
+// acknowledgement, which guarantees the message was persisted.
+type NATSSink struct {
+	url       string
+	addr      string
+	tlsConfig *tls.Config
+	topics    *TopicMapper
+	jetStream bool
+	timeout   time.Duration
+
+	mu sync.Mutex
+	nc *nats.Conn
+	js jetstream.JetStream
+}
+
+// NATSSinkOption is a functional option for NewNATSSink
+type NATSSinkOption func(s *NATSSink)
+
+// WithNATSTLSConfig sets the TLS configuration used to connect to the NATS
+// server. TLS is used if the URL's scheme is tls, or if the server requires
+// it.
+func WithNATSTLSConfig(c *tls.Config) NATSSinkOption {
+	return func(s *NATSSink) {
+		s.tlsConfig = c
+	}
+}
+
+// WithJetStream makes the sink wait for the acknowledgement of a JetStream
+// stream for every published event. A stream must be configured to capture
+// the subjects events are published to.
+func WithJetStream() NATSSinkOption {
+	return func(s *NATSSink) {
+		s.jetStream = true
+	}
+}
+
+// WithNATSTimeout sets the timeout for connecting and for every publish
+func WithNATSTimeout(d time.Duration) NATSSinkOption {
+	return func(s *NATSSink) {
+		s.timeout = d
+	}
+}
+
+// NewNATSSink returns a sink publishing events to the NATS server at natsURL,
+// which must use the nats or tls scheme. Credentials may be given in the URL,
+// either as user and password or as token in place of the user.
+func NewNATSSink(natsURL string, topics *TopicMapper, opts ...NATSSinkOption) (*NATSSink, error) {
+	u, err := url.Parse(natsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("invalid NATS URL %s: scheme must be nats or tls", natsURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS URL %s: host is missing", natsURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultNATSPort
+	}
+	s := &NATSSink{
+		url:     natsURL,
+		addr:    net.JoinHostPort(u.Hostname(), port),
+		topics:  topics,
+		timeout: defaultNATSTimeout,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+// Publish implements Sink
+func (s *NATSSink) Publish(ctx context.Context, ev *cloudevents.Event) error {
+	payload, err := event.ToStructured(ev)
+	if err != nil {
+		return err
+	}
+	subject := s.topics.Topic(ev)
+	nc, js, err := s.connection()
+	if err != nil {
+		return fmt.Errorf("could not connect to NATS server %s: %w", s.addr, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if js != nil {
+		_, err = js.Publish(ctx, subject, payload)
+	} else if err = nc.Publish(subject, payload); err == nil {
+		err = nc.FlushWithContext(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("could not publish to subject %s: %w", subject, err)
+	}
+	return nil
+}
+
+// Close closes the connection to the NATS server, if any
+func (s *NATSSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nc != nil {
+		s.nc.Close()
+		s.nc = nil
+		s.js = nil
+	}
+}
+
+// connection returns the connection to the NATS server, and the JetStream
+// context if JetStream is used. It connects if there is no connection, or if
+// the client gave up reconnecting.
+func (s *NATSSink) connection() (*nats.Conn, jetstream.JetStream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nc != nil && !s.nc.IsClosed() {
+		return s.nc, s.js, nil
+	}
+	opts := []nats.Option{
+		nats.Name(natsClientName),
+		nats.Timeout(s.timeout),
+	}
+	if s.tlsConfig != nil {
+		opts = append(opts, nats.Secure(s.tlsConfig))
+	}
+	nc, err := nats.Connect(s.url, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var js jetstream.JetStream
+	if s.jetStream {
+		if js, err = jetstream.New(nc); err != nil {
+			nc.Close()
+			return nil, nil, err
+		}
+	}
+	s.nc, s.js = nc, js
+	return nc, js, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"context"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/cenkalti/backoff/v4"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBufferSize     = 1000
+	defaultMaxElapsedTime = time.Minute
+)
+
+// Publisher delivers events to a Sink in the background, so that callers are
+// never blocked by a slow or unavailable sink. Delivery is retried with
+// exponential backoff. If the buffer runs full, new events are dropped.
+type Publisher struct {
+	sink           Sink
+	events         chan *cloudevents.Event
+	maxElapsedTime time.Duration
+}
+
+// PublisherOption is a functional option for NewPublisher
+type PublisherOption func(p *Publisher)
+
+// WithBufferSize sets the number of events buffered for delivery
+func WithBufferSize(size int) PublisherOption {
+	return func(p *Publisher) {
+		p.events = make(chan *cloudevents.Event, size)
+	}
+}
+
+// WithMaxElapsedTime sets the time after which delivery of an event is given
+// up. A value of 0 retries delivery until it succeeds or the publisher is
+// stopped, providing at-least-once delivery for all events that made it into
+// the buffer.
+func WithMaxElapsedTime(d time.Duration) PublisherOption {
+	return func(p *Publisher) {
+		p.maxElapsedTime = d
+	}
+}
+
+// NewPublisher returns a Publisher delivering events to sink. It must be
+// started using Start.
+func NewPublisher(sink Sink, opts ...PublisherOption) *Publisher {
+	p := &Publisher{
+		sink:           sink,
+		events:         make(chan *cloudevents.Event, defaultBufferSize),
+		maxElapsedTime: defaultMaxElapsedTime,
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+// Publish queues ev for delivery. It never blocks.
+func (p *Publisher) Publish(ev *cloudevents.Event) {
+	select {
+	case p.events <- ev:
+	default:
+		log().WithField("type", ev.Type()).Warn("Event sink buffer is full, dropping event")
+	}
+}
+
+// Start delivers queued events until ctx is done
+func (p *Publisher) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-p.events:
+				p.deliver(ctx, ev)
+			}
+		}
+	}()
+}
+
+func (p *Publisher) deliver(ctx context.Context, ev *cloudevents.Event) {
+	logCtx := log().WithFields(logrus.Fields{
+		"type":    ev.Type(),
+		"subject": ev.Subject(),
+		"id":      ev.ID(),
+	})
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = p.maxElapsedTime
+	err := backoff.RetryNotify(func() error {
+		return p.sink.Publish(ctx, ev)
+	}, backoff.WithContext(b, ctx), func(err error, d time.Duration) {
+		logCtx.WithError(err).Debugf("Could not publish event, retrying in %v", d)
+	})
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not publish event to event sink")
+		return
+	}
+	logCtx.Trace("Published event")
+}
+
+func log() *logrus.Entry {
+	return logging.GetDefaultLogger().ComponentLogger("EventSink")
+}
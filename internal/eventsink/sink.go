@@ -0,0 +1,125 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package eventsink publishes events to systems outside of argocd-agent, such
+as webhooks, NATS or a Kafka REST proxy. Events are encoded as CloudEvents
+1.0 in structured content mode.
+*/
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Sink is a destination for events
+type Sink interface {
+	// Publish delivers a single event to the sink. It must be safe for
+	// concurrent use.
+	Publish(ctx context.Context, ev *cloudevents.Event) error
+}
+
+const (
+	defaultHTTPTimeout = 10 * time.Second
+	maxResponseSize    = 64 * 1024
+)
+
+// HTTPSink posts events to an HTTP endpoint
+type HTTPSink struct {
+	url     string
+	client  *http.Client
+	headers http.Header
+}
+
+// HTTPSinkOption is a functional option for NewHTTPSink
+type HTTPSinkOption func(s *HTTPSink)
+
+// WithHTTPClient sets the HTTP client used to post events
+func WithHTTPClient(c *http.Client) HTTPSinkOption {
+	return func(s *HTTPSink) {
+		s.client = c
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authentication
+func WithHeader(key, value string) HTTPSinkOption {
+	return func(s *HTTPSink) {
+		s.headers.Add(key, value)
+	}
+}
+
+// NewHTTPSink returns a sink posting events to the given http or https URL
+func NewHTTPSink(sinkURL string, opts ...HTTPSinkOption) (*HTTPSink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event sink URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid event sink URL %s: scheme must be http or https", sinkURL)
+	}
+	s := &HTTPSink{
+		url:     sinkURL,
+		client:  &http.Client{Timeout: defaultHTTPTimeout},
+		headers: http.Header{},
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+// Publish implements Sink
+func (s *HTTPSink) Publish(ctx context.Context, ev *cloudevents.Event) error {
+	body, err := event.ToStructured(ev)
+	if err != nil {
+		return err
+	}
+	_, err = s.post(ctx, s.url, event.StructuredContentType, body)
+	return err
+}
+
+// post sends body to endpoint and returns the response body. Any status
+// code other than 2xx is returned as error.
+func (s *HTTPSink) post(ctx context.Context, endpoint, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("event sink returned %s", resp.Status)
+	}
+	return respBody, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HTTPSink(t *testing.T) {
+	evs := event.NewEventSource("principal")
+
+	t.Run("Posts structured event", func(t *testing.T) {
+		var received []byte
+		var contentType, auth string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			auth = r.Header.Get("Authorization")
+			received, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+
+		sink, err := NewHTTPSink(srv.URL, WithHeader("Authorization", "Bearer abc"))
+		require.NoError(t, err)
+		ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		require.NoError(t, sink.Publish(context.Background(), ev))
+
+		assert.Equal(t, event.StructuredContentType, contentType)
+		assert.Equal(t, "Bearer abc", auth)
+		got, err := event.FromStructured(received)
+		require.NoError(t, err)
+		assert.Equal(t, ev.ID(), got.ID())
+		assert.Equal(t, event.AgentConnected.String(), got.Type())
+		assert.Equal(t, "agent-1", got.Subject())
+	})
+
+	t.Run("Error status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+		sink, err := NewHTTPSink(srv.URL)
+		require.NoError(t, err)
+		ev, err := evs.AgentLifecycleEvent(event.AgentConnected, "agent-1", "managed")
+		require.NoError(t, err)
+		assert.Error(t, sink.Publish(context.Background(), ev))
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		_, err := NewHTTPSink("ftp://example.com")
+		assert.Error(t, err)
+	})
+}
+
+type fakeSink struct {
+	mu       sync.Mutex
+	failures atomic.Int32
+	events   []string
+}
+
+func (s *fakeSink) Publish(_ context.Context, ev *cloudevents.Event) error {
+	if s.failures.Load() > 0 {
+		s.failures.Add(-1)
+		return errors.New("unavailable")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev.ID())
+	return nil
+}
+
+func (s *fakeSink) published() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.events...)
+}
+
+func Test_Publisher(t *testing.T) {
+	evs := event.NewEventSource("principal")
+	sink := &fakeSink{}
+	sink.failures.Store(2)
+	p := NewPublisher(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+
+	ev, err := evs.AgentLifecycleEvent(event.AgentDisconnected, "agent-1", "")
+	require.NoError(t, err)
+	p.Publish(ev)
+	assert.Eventually(t, func() bool {
+		return len(sink.published()) == 1
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{ev.ID()}, sink.published())
+}
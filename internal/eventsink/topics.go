@@ -0,0 +1,77 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// DefaultTopic is the topic or subject events are published to, unless
+// configured otherwise.
+const DefaultTopic = "argocd-agent.events"
+
+// TopicMapper determines the topic (Kafka) or subject (NATS) an event is
+// published to, based on the event's type.
+type TopicMapper struct {
+	defaultTopic string
+	topics       map[string]string
+}
+
+// NewTopicMapper returns a TopicMapper which publishes events to the topic
+// mapped to their type, or to defaultTopic if there is no mapping. Types may
+// be given either fully qualified or without the common type prefix, e.g.
+// "sync-completed".
+func NewTopicMapper(defaultTopic string, mapping map[string]string) (*TopicMapper, error) {
+	if defaultTopic == "" {
+		defaultTopic = DefaultTopic
+	}
+	m := &TopicMapper{defaultTopic: defaultTopic, topics: make(map[string]string)}
+	for evType, topic := range mapping {
+		if evType == "" || topic == "" {
+			return nil, fmt.Errorf("invalid topic mapping %q=%q", evType, topic)
+		}
+		if !strings.HasPrefix(evType, event.TypePrefix+".") {
+			evType = event.TypePrefix + "." + evType
+		}
+		m.topics[evType] = topic
+	}
+	return m, nil
+}
+
+// ParseTopicMapping parses a list of type=topic pairs, as given on the
+// command line.
+func ParseTopicMapping(pairs []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("invalid topic mapping %q, must be in the form type=topic", p)
+		}
+		mapping[k] = v
+	}
+	return mapping, nil
+}
+
+// Topic returns the topic ev is to be published to
+func (m *TopicMapper) Topic(ev *cloudevents.Event) string {
+	if t, ok := m.topics[ev.Type()]; ok {
+		return t
+	}
+	return m.defaultTopic
+}
@@ -0,0 +1,319 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package faultinject injects faults into the gRPC connection between agent and
+principal. It destroys streams, delays messages, corrupts payloads and fails
+calls as unauthenticated, at configurable rates, so that the retry and resume
+logic of both components can be tested under realistic failure patterns.
+
+Fault injection must never be enabled in production.
+*/
+package faultinject
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Fault is a kind of fault the Injector can inject
+type Fault string
+
+const (
+	// FaultDrop destroys the stream the message is sent or received on
+	FaultDrop Fault = "drop"
+	// FaultDelay delays a message before it is sent or returned
+	FaultDelay Fault = "delay"
+	// FaultCorrupt flips a bit in the wire representation of a message
+	FaultCorrupt Fault = "corrupt"
+	// FaultUnauthenticated fails a call as if the token was rejected
+	FaultUnauthenticated Fault = "unauthenticated"
+)
+
+// Config configures the rates at which faults are injected. Rates are
+// probabilities between 0 and 1, evaluated for each message.
+type Config struct {
+	// Methods restricts fault injection to gRPC methods starting with one of
+	// the prefixes. If empty, faults are injected into all methods.
+	Methods []string
+	// DropRate is the rate at which streams are destroyed
+	DropRate float64
+	// DelayRate is the rate at which messages are delayed by Delay
+	DelayRate float64
+	// Delay is the time by which messages are delayed
+	Delay time.Duration
+	// CorruptRate is the rate at which messages are corrupted
+	CorruptRate float64
+	// UnauthenticatedRate is the rate at which calls fail as unauthenticated
+	UnauthenticatedRate float64
+	// Seed seeds the random number generator, so that fault patterns can be
+	// reproduced. If 0, a random seed is used.
+	Seed uint64
+}
+
+// Validate checks the configuration for consistency
+func (c Config) Validate() error {
+	for f, r := range map[Fault]float64{
+		FaultDrop:            c.DropRate,
+		FaultDelay:           c.DelayRate,
+		FaultCorrupt:         c.CorruptRate,
+		FaultUnauthenticated: c.UnauthenticatedRate,
+	} {
+		if r < 0 || r > 1 {
+			return fmt.Errorf("%s rate must be between 0 and 1", f)
+		}
+	}
+	if c.Delay < 0 {
+		return fmt.Errorf("delay must not be negative")
+	}
+	if c.DelayRate > 0 && c.Delay == 0 {
+		return fmt.Errorf("delay must be set when delay rate is set")
+	}
+	return nil
+}
+
+// ParseConfig parses a fault injection specification of the form
+// "drop=0.1,delay=0.2:500ms,corrupt=0.01,unauthenticated=0.05,seed=42,method=/logstreamapi."
+// The method key may be given multiple times.
+func ParseConfig(spec string) (Config, error) {
+	var c Config
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return c, fmt.Errorf("invalid fault specification %q: expected key=value", kv)
+		}
+		var err error
+		switch k {
+		case string(FaultDrop):
+			c.DropRate, err = strconv.ParseFloat(v, 64)
+		case string(FaultDelay):
+			rate, d, ok := strings.Cut(v, ":")
+			if !ok {
+				return c, fmt.Errorf("invalid delay %q: expected rate:duration", v)
+			}
+			c.DelayRate, err = strconv.ParseFloat(rate, 64)
+			if err == nil {
+				c.Delay, err = time.ParseDuration(d)
+			}
+		case string(FaultCorrupt):
+			c.CorruptRate, err = strconv.ParseFloat(v, 64)
+		case string(FaultUnauthenticated):
+			c.UnauthenticatedRate, err = strconv.ParseFloat(v, 64)
+		case "seed":
+			c.Seed, err = strconv.ParseUint(v, 10, 64)
+		case "method":
+			c.Methods = append(c.Methods, v)
+		default:
+			return c, fmt.Errorf("unknown fault %q", k)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid value for %s: %w", k, err)
+		}
+	}
+	return c, c.Validate()
+}
+
+// Injector injects faults into gRPC calls. It is safe for concurrent use.
+type Injector struct {
+	cfg    Config
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	counts map[Fault]int
+}
+
+// NewInjector returns an Injector for the given configuration
+func NewInjector(cfg Config) (*Injector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &Injector{
+		cfg:    cfg,
+		rnd:    rand.New(rand.NewPCG(seed, seed)),
+		counts: make(map[Fault]int),
+	}, nil
+}
+
+// Count returns how often fault f has been injected
+func (i *Injector) Count(f Fault) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.counts[f]
+}
+
+// roll decides whether a fault with the given rate is injected, and records
+// it if so.
+func (i *Injector) roll(f Fault, rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.rnd.Float64() >= rate {
+		return false
+	}
+	i.counts[f]++
+	return true
+}
+
+func (i *Injector) intN(n int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rnd.IntN(n)
+}
+
+func (i *Injector) applies(method string) bool {
+	if len(i.cfg.Methods) == 0 {
+		return true
+	}
+	for _, p := range i.cfg.Methods {
+		if strings.HasPrefix(method, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// inject runs the faults that apply before a message is passed on. A non-nil
+// error means the message must not be passed on.
+func (i *Injector) inject(ctx context.Context, method string, msg interface{}, cancel context.CancelFunc) error {
+	logCtx := logrus.WithField("method", method)
+	if i.roll(FaultDelay, i.cfg.DelayRate) {
+		logCtx.Debugf("Fault injection: delaying message by %v", i.cfg.Delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(i.cfg.Delay):
+		}
+	}
+	if i.roll(FaultUnauthenticated, i.cfg.UnauthenticatedRate) {
+		logCtx.Debug("Fault injection: failing call as unauthenticated")
+		if cancel != nil {
+			cancel()
+		}
+		return status.Error(codes.Unauthenticated, "fault injection: unauthenticated")
+	}
+	if cancel != nil && i.roll(FaultDrop, i.cfg.DropRate) {
+		logCtx.Debug("Fault injection: dropping stream")
+		cancel()
+		return status.Error(codes.Unavailable, "fault injection: stream dropped")
+	}
+	if i.roll(FaultCorrupt, i.cfg.CorruptRate) {
+		logCtx.Debug("Fault injection: corrupting message")
+		return i.corrupt(msg)
+	}
+	return nil
+}
+
+// corrupt flips a random bit in the wire representation of msg. If the result
+// can no longer be decoded, msg is left untouched and a DataLoss error is
+// returned, just like a transport would report it.
+func (i *Injector) corrupt(msg interface{}) error {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil
+	}
+	b, err := proto.Marshal(m)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	bit := i.intN(len(b) * 8)
+	b[bit/8] ^= 1 << (bit % 8)
+	c := proto.Clone(m)
+	proto.Reset(c)
+	if err := proto.Unmarshal(b, c); err != nil {
+		return status.Error(codes.DataLoss, "fault injection: corrupted message")
+	}
+	proto.Reset(m)
+	proto.Merge(m, c)
+	return nil
+}
+
+// UnaryClientInterceptor returns an interceptor injecting faults into unary
+// calls. Unary calls cannot be dropped, all other faults apply.
+func (i *Injector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !i.applies(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if err := i.inject(ctx, method, req, nil); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns an interceptor injecting faults into
+// messages sent and received on streams.
+func (i *Injector) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !i.applies(method) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return cs, err
+		}
+		return &faultyClientStream{ClientStream: cs, injector: i, method: method, cancel: cancel, single: !desc.ServerStreams}, nil
+	}
+}
+
+type faultyClientStream struct {
+	grpc.ClientStream
+	injector *Injector
+	method   string
+	cancel   context.CancelFunc
+	// single is set when the server sends a single response, after which
+	// the stream is done
+	single bool
+}
+
+func (s *faultyClientStream) SendMsg(m interface{}) error {
+	if err := s.injector.inject(s.Context(), s.method, m, s.cancel); err != nil {
+		return err
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *faultyClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+		return err
+	}
+	if s.single {
+		defer s.cancel()
+	}
+	return s.injector.inject(s.Context(), s.method, m, s.cancel)
+}
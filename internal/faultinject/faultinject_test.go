@@ -0,0 +1,174 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package faultinject
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeClientStream struct {
+	ctx  context.Context
+	sent []interface{}
+}
+
+func (s *fakeClientStream) Header() (metadata.MD, error) { return nil, nil }
+func (s *fakeClientStream) Trailer() metadata.MD         { return nil }
+func (s *fakeClientStream) CloseSend() error             { return nil }
+func (s *fakeClientStream) Context() context.Context     { return s.ctx }
+func (s *fakeClientStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+func (s *fakeClientStream) RecvMsg(m interface{}) error {
+	proto.Merge(m.(proto.Message), &logstreamapi.LogStreamData{RequestUuid: "uuid", Data: []byte("line")})
+	return nil
+}
+
+func newStream(t *testing.T, cfg Config, method string) (grpc.ClientStream, *fakeClientStream) {
+	t.Helper()
+	i, err := NewInjector(cfg)
+	require.NoError(t, err)
+	fake := &fakeClientStream{}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		fake.ctx = ctx
+		return fake, nil
+	}
+	cs, err := i.StreamClientInterceptor()(context.Background(), &grpc.StreamDesc{ClientStreams: true}, nil, method, streamer)
+	require.NoError(t, err)
+	return cs, fake
+}
+
+func Test_ParseConfig(t *testing.T) {
+	t.Run("Full specification", func(t *testing.T) {
+		c, err := ParseConfig("drop=0.1, delay=0.2:500ms,corrupt=0.01,unauthenticated=0.05,seed=42,method=/logstreamapi.,method=/eventstreamapi.")
+		require.NoError(t, err)
+		assert.Equal(t, 0.1, c.DropRate)
+		assert.Equal(t, 0.2, c.DelayRate)
+		assert.Equal(t, 500*time.Millisecond, c.Delay)
+		assert.Equal(t, 0.01, c.CorruptRate)
+		assert.Equal(t, 0.05, c.UnauthenticatedRate)
+		assert.Equal(t, uint64(42), c.Seed)
+		assert.Equal(t, []string{"/logstreamapi.", "/eventstreamapi."}, c.Methods)
+	})
+	t.Run("Invalid specifications", func(t *testing.T) {
+		for _, spec := range []string{"drop", "drop=x", "drop=1.5", "delay=0.5", "delay=0.5:x", "explode=1", "corrupt=-1"} {
+			_, err := ParseConfig(spec)
+			assert.Error(t, err, spec)
+		}
+	})
+}
+
+func Test_StreamFaults(t *testing.T) {
+	msg := func() *logstreamapi.LogStreamData {
+		return &logstreamapi.LogStreamData{RequestUuid: "uuid", Data: []byte("some log line")}
+	}
+
+	t.Run("No faults", func(t *testing.T) {
+		cs, fake := newStream(t, Config{}, "/logstreamapi.LogStreamService/StreamLogs")
+		require.NoError(t, cs.SendMsg(msg()))
+		assert.Len(t, fake.sent, 1)
+	})
+
+	t.Run("Drop destroys the stream", func(t *testing.T) {
+		cs, fake := newStream(t, Config{DropRate: 1}, "/logstreamapi.LogStreamService/StreamLogs")
+		err := cs.SendMsg(msg())
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Empty(t, fake.sent)
+		assert.Error(t, fake.ctx.Err())
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		cs, _ := newStream(t, Config{UnauthenticatedRate: 1}, "/logstreamapi.LogStreamService/StreamLogs")
+		err := cs.RecvMsg(&logstreamapi.LogStreamData{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		cs, fake := newStream(t, Config{DelayRate: 1, Delay: 50 * time.Millisecond}, "/logstreamapi.LogStreamService/StreamLogs")
+		start := time.Now()
+		require.NoError(t, cs.SendMsg(msg()))
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Len(t, fake.sent, 1)
+	})
+
+	t.Run("Corrupt changes the message or fails", func(t *testing.T) {
+		cs, fake := newStream(t, Config{CorruptRate: 1, Seed: 1}, "/logstreamapi.LogStreamService/StreamLogs")
+		for n := 0; n < 20; n++ {
+			m := msg()
+			err := cs.SendMsg(m)
+			if err != nil {
+				assert.Equal(t, codes.DataLoss, status.Code(err))
+				continue
+			}
+			assert.False(t, proto.Equal(msg(), m))
+		}
+		assert.NotEmpty(t, fake.sent)
+	})
+
+	t.Run("Other methods are not affected", func(t *testing.T) {
+		cs, fake := newStream(t, Config{DropRate: 1, Methods: []string{"/eventstreamapi."}}, "/logstreamapi.LogStreamService/StreamLogs")
+		require.NoError(t, cs.SendMsg(msg()))
+		assert.Len(t, fake.sent, 1)
+	})
+}
+
+func Test_Seed(t *testing.T) {
+	pattern := func() []bool {
+		i, err := NewInjector(Config{DropRate: 0.5, Seed: 42})
+		require.NoError(t, err)
+		var p []bool
+		for n := 0; n < 50; n++ {
+			p = append(p, i.roll(FaultDrop, 0.5))
+		}
+		assert.Equal(t, i.Count(FaultDrop), countTrue(p))
+		return p
+	}
+	assert.Equal(t, pattern(), pattern())
+}
+
+func countTrue(p []bool) int {
+	n := 0
+	for _, b := range p {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_UnaryClientInterceptor(t *testing.T) {
+	i, err := NewInjector(Config{DropRate: 1, UnauthenticatedRate: 1})
+	require.NoError(t, err)
+	invoked := false
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked = true
+		return nil
+	}
+	err = i.UnaryClientInterceptor()(context.Background(), "/authapi.Authentication/Authenticate", nil, nil, nil, invoker)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.False(t, invoked)
+	assert.Equal(t, 0, i.Count(FaultDrop))
+}
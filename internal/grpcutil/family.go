@@ -0,0 +1,66 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcutil
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// AddressFamily selects the IP address families used to listen on and to
+// connect to gRPC endpoints
+type AddressFamily string
+
+const (
+	// AddressFamilyDual uses both IPv4 and IPv6. Listeners accept
+	// connections of both families, and connections race the addresses of
+	// both families against each other (happy eyeballs).
+	AddressFamilyDual AddressFamily = "dual"
+	// AddressFamilyIPv4 uses only IPv4
+	AddressFamilyIPv4 AddressFamily = "tcp4"
+	// AddressFamilyIPv6 uses only IPv6
+	AddressFamilyIPv6 AddressFamily = "tcp6"
+)
+
+// ParseAddressFamily parses the name of an address family. An empty name
+// selects AddressFamilyDual.
+func ParseAddressFamily(s string) (AddressFamily, error) {
+	switch f := AddressFamily(strings.ToLower(s)); f {
+	case "":
+		return AddressFamilyDual, nil
+	case AddressFamilyDual, AddressFamilyIPv4, AddressFamilyIPv6:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid address family %q, must be one of %s, %s or %s", s, AddressFamilyDual, AddressFamilyIPv4, AddressFamilyIPv6)
+}
+
+// Network returns the name of the network to pass to net.Listen and
+// net.Dial for the address family
+func (f AddressFamily) Network() string {
+	switch f {
+	case AddressFamilyIPv4, AddressFamilyIPv6:
+		return string(f)
+	}
+	return "tcp"
+}
+
+// JoinHostPort combines host and port into an address. Unlike
+// net.JoinHostPort, it accepts IPv6 addresses both with and without
+// brackets.
+func JoinHostPort(host string, port int) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), strconv.Itoa(port))
+}
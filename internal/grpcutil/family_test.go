@@ -0,0 +1,49 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseAddressFamily(t *testing.T) {
+	for in, want := range map[string]AddressFamily{
+		"":     AddressFamilyDual,
+		"dual": AddressFamilyDual,
+		"tcp4": AddressFamilyIPv4,
+		"TCP6": AddressFamilyIPv6,
+	} {
+		f, err := ParseAddressFamily(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, f)
+	}
+	_, err := ParseAddressFamily("ipv6")
+	assert.Error(t, err)
+
+	assert.Equal(t, "tcp", AddressFamilyDual.Network())
+	assert.Equal(t, "tcp", AddressFamily("").Network())
+	assert.Equal(t, "tcp6", AddressFamilyIPv6.Network())
+}
+
+func Test_JoinHostPort(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:443", JoinHostPort("127.0.0.1", 443))
+	assert.Equal(t, "[fd00::1]:443", JoinHostPort("fd00::1", 443))
+	assert.Equal(t, "[fd00::1]:443", JoinHostPort("[fd00::1]", 443))
+	assert.Equal(t, ":8443", JoinHostPort("", 8443))
+	assert.Equal(t, "principal.example.com:443", JoinHostPort("principal.example.com", 443))
+}
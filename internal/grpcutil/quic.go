@@ -0,0 +1,323 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcutil
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+)
+
+// gRPC runs over QUIC as HTTP/3: every RPC is a request on its own QUIC
+// stream, so that streams don't block each other when packets are lost, and
+// QUIC's TLS is the only TLS of the connection.
+//
+// The principal serves its gRPC server as an HTTP/3 handler. gRPC clients
+// only speak HTTP/2, so the agent's gRPC connection talks HTTP/2 to an
+// in-process bridge, which sends each request on as an HTTP/3 request.
+
+// quicIdleTimeout is the time after which a QUIC connection without any
+// traffic is closed. Connections are kept alive well before that.
+const quicIdleTimeout = 60 * time.Second
+
+// quicLogger discards the debug logs of the HTTP/3 client and server
+var quicLogger = slog.New(slog.DiscardHandler)
+
+// quicConfig returns the QUIC configuration used by both ends
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:  quicIdleTimeout,
+		KeepAlivePeriod: quicIdleTimeout / 4,
+	}
+}
+
+// quicTLSConfig returns a copy of tlsConfig that negotiates HTTP/3. QUIC
+// requires TLS 1.3.
+func quicTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	c := http3.ConfigureTLSConfig(tlsConfig.Clone())
+	c.MinVersion = tls.VersionTLS13
+	return c
+}
+
+// PacketNetwork returns the name of the network to pass to net.ListenUDP
+// for the address family
+func (f AddressFamily) PacketNetwork() string {
+	switch f {
+	case AddressFamilyIPv4:
+		return "udp4"
+	case AddressFamilyIPv6:
+		return "udp6"
+	}
+	return "udp"
+}
+
+// QUICServer serves gRPC over HTTP/3
+type QUICServer struct {
+	srv   *http3.Server
+	pconn net.PacketConn
+}
+
+// ServeQUIC serves handler, which is a gRPC server, over HTTP/3 on the UDP
+// address addr. The gRPC server's interceptors and services handle the
+// requests as if they were received over HTTP/2; client certificates are
+// available from the peer's TLS information as usual.
+func ServeQUIC(family AddressFamily, addr string, tlsConfig *tls.Config, handler http.Handler) (*QUICServer, error) {
+	if tlsConfig == nil {
+		return nil, errors.New("QUIC requires TLS")
+	}
+	network := family.PacketNetwork()
+	laddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &QUICServer{
+		srv: &http3.Server{
+			Handler:    grpcOverHTTP3(handler),
+			TLSConfig:  quicTLSConfig(tlsConfig),
+			QUICConfig: quicConfig(),
+			// http3 dereferences its logger when writing trailers on
+			// streams the client already canceled
+			Logger: quicLogger,
+		},
+		pconn: pconn,
+	}
+	go func() {
+		_ = s.srv.Serve(pconn)
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on
+func (s *QUICServer) Addr() net.Addr {
+	return s.pconn.LocalAddr()
+}
+
+// Close closes all QUIC connections and stops listening
+func (s *QUICServer) Close() error {
+	err := s.srv.Close()
+	_ = s.pconn.Close()
+	return err
+}
+
+// grpcOverHTTP3 passes HTTP/3 requests to the gRPC server's HTTP handler,
+// which only accepts HTTP/2 requests. HTTP/3 offers the same semantics to
+// gRPC: full duplex streams and trailers.
+func grpcOverHTTP3(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// QUICDialer connects gRPC clients to a server over HTTP/3
+type QUICDialer struct {
+	family    AddressFamily
+	tlsConfig *tls.Config
+	transport *http3.Transport
+	h2        *http2.Server
+}
+
+// NewQUICDialer returns a dialer connecting over QUIC with tlsConfig. Pass
+// its Dial method to grpc.WithContextDialer, together with its
+// TransportCredentials.
+func NewQUICDialer(family AddressFamily, tlsConfig *tls.Config) *QUICDialer {
+	return &QUICDialer{
+		family:    family,
+		tlsConfig: tlsConfig,
+		transport: &http3.Transport{QUICConfig: quicConfig(), Logger: quicLogger},
+		h2:        &http2.Server{},
+	}
+}
+
+// quicBridgeConn is the gRPC client's end of the bridge to a QUIC
+// connection. Closing it closes the QUIC connection.
+type quicBridgeConn struct {
+	net.Conn
+	conn  *quic.Conn
+	state tls.ConnectionState
+}
+
+func (c *quicBridgeConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicBridgeConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *quicBridgeConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.conn.CloseWithError(0, "")
+	return err
+}
+
+// Dial opens a QUIC connection to addr and returns the end of an in-process
+// HTTP/2 connection for the gRPC client. Each request of the gRPC client is
+// sent on a stream of its own on the QUIC connection.
+func (d *QUICDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	network := d.family.PacketNetwork()
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := quicTLSConfig(d.tlsConfig)
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tr := &quic.Transport{Conn: pconn}
+	conn, err := tr.Dial(ctx, raddr, tlsConfig, quicConfig())
+	if err != nil {
+		_ = tr.Close()
+		_ = pconn.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		_ = tr.Close()
+		_ = pconn.Close()
+	}()
+
+	cc := d.transport.NewClientConn(conn)
+	client, server := net.Pipe()
+	go d.h2.ServeConn(server, &http2.ServeConnOpts{
+		Context: conn.Context(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardHTTP3(cc, w, r)
+		}),
+	})
+	return &quicBridgeConn{Conn: client, conn: conn, state: conn.ConnectionState().TLS}, nil
+}
+
+// forwardHTTP3 sends the gRPC request r over cc and streams the response,
+// including its trailers, back to w
+func forwardHTTP3(cc *http3.ClientConn, w http.ResponseWriter, r *http.Request) {
+	out, err := http.NewRequestWithContext(r.Context(), r.Method, "https://"+r.Host+r.URL.RequestURI(), r.Body)
+	if err != nil {
+		writeGRPCError(w, codes.Internal, err)
+		return
+	}
+	out.Header = r.Header.Clone()
+	out.ContentLength = -1
+	resp, err := cc.RoundTrip(out)
+	if err != nil {
+		writeGRPCError(w, codes.Unavailable, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// The gRPC status is sent in the headers if the response has no
+	// messages. The HTTP/2 server only sends headers and trailers in one
+	// frame if they are declared as trailers.
+	for k, v := range resp.Header {
+		if isGRPCStatusHeader(k) {
+			w.Header()[http.TrailerPrefix+k] = v
+		} else {
+			w.Header()[k] = v
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", err.Error())
+			return
+		}
+	}
+	for k, v := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+k] = v
+	}
+}
+
+// isGRPCStatusHeader returns whether k is one of the headers carrying the
+// status of an RPC
+func isGRPCStatusHeader(k string) bool {
+	switch http.CanonicalHeaderKey(k) {
+	case "Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin":
+		return true
+	}
+	return false
+}
+
+// writeGRPCError answers a gRPC request with an error status
+func writeGRPCError(w http.ResponseWriter, code codes.Code, err error) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(code)))
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", fmt.Sprintf("QUIC: %v", err))
+	w.WriteHeader(http.StatusOK)
+}
+
+// TransportCredentials returns creds for connections that are not bridged to
+// QUIC. Bridged connections are already secured by QUIC's TLS, so they get
+// no second TLS handshake; their peer is authenticated by the QUIC
+// handshake with the dialer's TLS configuration.
+func (d *QUICDialer) TransportCredentials(creds credentials.TransportCredentials) credentials.TransportCredentials {
+	return &quicCredentials{TransportCredentials: creds}
+}
+
+type quicCredentials struct {
+	credentials.TransportCredentials
+}
+
+func (c *quicCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if qc, ok := conn.(*quicBridgeConn); ok {
+		return conn, credentials.TLSInfo{
+			State:          qc.state,
+			CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		}, nil
+	}
+	return c.TransportCredentials.ClientHandshake(ctx, authority, conn)
+}
+
+func (c *quicCredentials) Clone() credentials.TransportCredentials {
+	return &quicCredentials{TransportCredentials: c.TransportCredentials.Clone()}
+}
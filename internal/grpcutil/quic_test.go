@@ -0,0 +1,150 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// quicTestTLS returns a server TLS configuration for 127.0.0.1 and a client
+// TLS configuration trusting it
+func quicTestTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	caCertPEM, caKeyPEM, err := tlsutil.GenerateCaCertificate("quic-test-ca")
+	require.NoError(t, err)
+	ca, err := tls.X509KeyPair([]byte(caCertPEM), []byte(caKeyPEM))
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	require.NoError(t, err)
+	certPEM, keyPEM, err := tlsutil.GenerateServerCertificate("quic-test", caCert, ca.PrivateKey, []string{"127.0.0.1"}, nil)
+	require.NoError(t, err)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, &tls.Config{RootCAs: pool}
+}
+
+func Test_QUIC(t *testing.T) {
+	assert.Equal(t, "udp", AddressFamilyDual.PacketNetwork())
+	assert.Equal(t, "udp4", AddressFamilyIPv4.PacketNetwork())
+	assert.Equal(t, "udp6", AddressFamilyIPv6.PacketNetwork())
+	serverTLS, clientTLS := quicTestTLS(t)
+
+	// serve returns the address of a gRPC server with the health service
+	// served over QUIC. The TLS information of the last RPC is written to
+	// authInfo.
+	serve := func(t *testing.T, authInfo *credentials.TLSInfo) string {
+		t.Helper()
+		record := func(ctx context.Context) {
+			if p, ok := peer.FromContext(ctx); ok {
+				if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+					*authInfo = info
+				}
+			}
+		}
+		gs := grpc.NewServer(
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				record(ctx)
+				return handler(ctx, req)
+			}),
+		)
+		hs := health.NewServer()
+		hs.SetServingStatus("agent", healthpb.HealthCheckResponse_SERVING)
+		healthpb.RegisterHealthServer(gs, hs)
+		s, err := ServeQUIC(AddressFamilyIPv4, "127.0.0.1:0", serverTLS, gs)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s.Addr().String()
+	}
+	dial := func(t *testing.T, addr string) *grpc.ClientConn {
+		t.Helper()
+		d := NewQUICDialer(AddressFamilyIPv4, clientTLS)
+		conn, err := grpc.NewClient("passthrough:///"+addr,
+			grpc.WithContextDialer(d.Dial),
+			grpc.WithTransportCredentials(d.TransportCredentials(credentials.NewTLS(clientTLS))),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("Requires TLS", func(t *testing.T) {
+		_, err := ServeQUIC(AddressFamilyIPv4, "127.0.0.1:0", nil, grpc.NewServer())
+		assert.Error(t, err)
+	})
+
+	t.Run("RPCs over HTTP/3", func(t *testing.T) {
+		var authInfo credentials.TLSInfo
+		client := healthpb.NewHealthClient(dial(t, serve(t, &authInfo)))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "agent"})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+		// The only TLS is QUIC's, which negotiated HTTP/3
+		assert.Equal(t, "h3", authInfo.State.NegotiatedProtocol)
+		assert.Equal(t, uint16(tls.VersionTLS13), authInfo.State.Version)
+
+		// Errors are reported with their status
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Streams run concurrently", func(t *testing.T) {
+		var authInfo credentials.TLSInfo
+		client := healthpb.NewHealthClient(dial(t, serve(t, &authInfo)))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		streams := []healthpb.Health_WatchClient{}
+		for range 3 {
+			stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "agent"})
+			require.NoError(t, err)
+			streams = append(streams, stream)
+		}
+		for _, stream := range streams {
+			resp, err := stream.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+		}
+		// Unary RPCs are not blocked by the open streams
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "agent"})
+		require.NoError(t, err)
+	})
+
+	t.Run("Dial fails without a server", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_, err := NewQUICDialer(AddressFamilyIPv4, clientTLS).Dial(ctx, "127.0.0.1:1")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcutil
+
+import (
+	"fmt"
+	"math"
+)
+
+// MinWindowSize is the smallest HTTP/2 flow control window gRPC accepts.
+// Smaller windows would be ignored by gRPC.
+const MinWindowSize = 64 * 1024
+
+// ValidateWindowSize returns an error if size is not a valid initial HTTP/2
+// flow control window. A size of 0 keeps gRPC's default window, which grows
+// dynamically with the bandwidth of the connection. Setting a size disables
+// that.
+func ValidateWindowSize(size int) error {
+	if size == 0 {
+		return nil
+	}
+	if size < MinWindowSize || size > math.MaxInt32 {
+		return fmt.Errorf("window size must be 0 or between %d and %d bytes", MinWindowSize, math.MaxInt32)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package handoff implements the protocol used by a principal instance to take
+over the live sessions of the instance it replaces.
+
+The previous instance listens on a Unix domain socket. When the new instance
+starts, it connects to that socket and requests the session state. The
+previous instance drains its connections, hands over its state and shuts
+down. Afterwards, the new instance listens on the socket itself, so that it
+can hand over its sessions on the next upgrade.
+*/
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// ProtocolVersion is the version of the handoff protocol spoken by this
+// instance. Instances only hand over their state to peers speaking the same
+// version.
+const ProtocolVersion = 1
+
+// requestReadTimeout is how long we wait for a peer to send its request
+const requestReadTimeout = 10 * time.Second
+
+// State is the session state handed over to a new principal instance
+type State struct {
+	// Version is the protocol version of the instance that sent the state
+	Version int `json:"version"`
+	// LogStreams are the follow log streams which were live on the previous
+	// instance
+	LogStreams []LogStream `json:"logStreams,omitempty"`
+	// Queues are the events which were still waiting to be sent to agents
+	Queues []Queue `json:"queues,omitempty"`
+}
+
+// LogStream describes a follow log stream. The agent keeps streaming to the
+// new instance under the same request UUID, and the client re-attaches to it
+// by repeating its request.
+type LogStream struct {
+	RequestUUID string `json:"requestUUID"`
+	AgentName   string `json:"agentName"`
+	// Key identifies the client request the stream was created for
+	Key string `json:"key"`
+}
+
+// Queue holds the pending events of an agent's send queue
+type Queue struct {
+	AgentName string               `json:"agentName"`
+	Events    []*cloudevents.Event `json:"events"`
+}
+
+// ExportFunc returns the state to hand over. It is called once a peer has
+// requested the handoff, and the previous instance is expected to stop
+// serving agents and clients before it returns.
+type ExportFunc func() (*State, error)
+
+type request struct {
+	Version int `json:"version"`
+}
+
+type response struct {
+	State *State `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Listener serves the handoff protocol on a Unix domain socket
+type Listener struct {
+	path   string
+	ln     net.Listener
+	export ExportFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+func log() *logrus.Entry {
+	return logging.GetDefaultLogger().ComponentLogger("Handoff")
+}
+
+// Listen creates a Listener on the socket at path. A stale socket left over
+// by a previous instance is removed, but Listen fails if another instance is
+// still serving on it.
+func Listen(path string, export ExportFunc) (*Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("handoff socket %s is in use by another instance", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale handoff socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on handoff socket: %w", err)
+	}
+	return &Listener{path: path, ln: ln, export: export, done: make(chan struct{})}, nil
+}
+
+// Serve accepts handoff requests until the state has been handed over once,
+// or the listener was closed.
+func (l *Listener) Serve() error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		if l.handle(conn) {
+			return l.Close()
+		}
+	}
+}
+
+// Done returns a channel that is closed once the state has been handed over
+// or the listener was closed.
+func (l *Listener) Done() <-chan struct{} {
+	return l.done
+}
+
+// Close stops accepting handoff requests
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+// handle serves a single handoff request and returns whether the state was
+// handed over.
+func (l *Listener) handle(conn net.Conn) bool {
+	defer conn.Close()
+	logCtx := log().WithField("socket", l.path)
+
+	req := request{}
+	_ = conn.SetReadDeadline(time.Now().Add(requestReadTimeout))
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logCtx.WithError(err).Warn("Could not read handoff request")
+		return false
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if req.Version != ProtocolVersion {
+		logCtx.Warnf("Refusing handoff to peer with protocol version %d", req.Version)
+		_ = json.NewEncoder(conn).Encode(&response{Error: fmt.Sprintf("unsupported protocol version %d, want %d", req.Version, ProtocolVersion)})
+		return false
+	}
+
+	logCtx.Info("New instance requested a handoff")
+	state, err := l.export()
+	if err != nil {
+		logCtx.WithError(err).Error("Could not export session state")
+		_ = json.NewEncoder(conn).Encode(&response{Error: err.Error()})
+		return false
+	}
+	state.Version = ProtocolVersion
+	if err := json.NewEncoder(conn).Encode(&response{State: state}); err != nil {
+		logCtx.WithError(err).Error("Could not hand over session state")
+		return false
+	}
+	logCtx.WithFields(logrus.Fields{
+		"log_streams": len(state.LogStreams),
+		"queues":      len(state.Queues),
+	}).Info("Handed over session state")
+	return true
+}
+
+// Request requests the session state from the instance listening on the
+// socket at path. If no instance is listening, it returns a nil state and no
+// error.
+func Request(ctx context.Context, path string) (*State, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not connect to handoff socket: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(conn).Encode(&request{Version: ProtocolVersion}); err != nil {
+		return nil, fmt.Errorf("could not send handoff request: %w", err)
+	}
+	resp := response{}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("could not read session state: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("handoff refused: %s", resp.Error)
+	}
+	if resp.State == nil {
+		return nil, fmt.Errorf("handoff response contains no state")
+	}
+	return resp.State, nil
+}
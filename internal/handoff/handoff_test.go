@@ -0,0 +1,133 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func socketPath(t *testing.T) string {
+	t.Helper()
+	// Unix socket paths are limited in length, so we don't use t.TempDir()
+	dir, err := os.MkdirTemp("", "handoff")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "handoff.sock")
+}
+
+func Test_Handoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("No previous instance", func(t *testing.T) {
+		state, err := Request(ctx, socketPath(t))
+		require.NoError(t, err)
+		assert.Nil(t, state)
+	})
+
+	t.Run("State is handed over once", func(t *testing.T) {
+		path := socketPath(t)
+		ev := cloudevents.NewEvent()
+		ev.SetID("1")
+		ev.SetSource("principal")
+		ev.SetType("io.argoproj.argocd-agent.event.update")
+		exported := 0
+		l, err := Listen(path, func() (*State, error) {
+			exported++
+			return &State{
+				LogStreams: []LogStream{{RequestUUID: "uuid", AgentName: "agent", Key: "key"}},
+				Queues:     []Queue{{AgentName: "agent", Events: []*cloudevents.Event{&ev}}},
+			}, nil
+		})
+		require.NoError(t, err)
+		errch := make(chan error, 1)
+		go func() { errch <- l.Serve() }()
+
+		state, err := Request(ctx, path)
+		require.NoError(t, err)
+		require.NotNil(t, state)
+		assert.Equal(t, ProtocolVersion, state.Version)
+		assert.Equal(t, []LogStream{{RequestUUID: "uuid", AgentName: "agent", Key: "key"}}, state.LogStreams)
+		require.Len(t, state.Queues, 1)
+		require.Len(t, state.Queues[0].Events, 1)
+		assert.Equal(t, "1", state.Queues[0].Events[0].ID())
+
+		require.NoError(t, <-errch)
+		<-l.Done()
+		assert.Equal(t, 1, exported)
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Export failure is reported to the peer", func(t *testing.T) {
+		path := socketPath(t)
+		l, err := Listen(path, func() (*State, error) {
+			return nil, errors.New("not ready")
+		})
+		require.NoError(t, err)
+		defer l.Close()
+		go func() { _ = l.Serve() }()
+
+		_, err = Request(ctx, path)
+		assert.ErrorContains(t, err, "not ready")
+	})
+
+	t.Run("Peers with other protocol versions are refused", func(t *testing.T) {
+		path := socketPath(t)
+		l, err := Listen(path, func() (*State, error) {
+			t.Fatal("state must not be exported")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		defer l.Close()
+		go func() { _ = l.Serve() }()
+
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, json.NewEncoder(conn).Encode(&request{Version: ProtocolVersion + 1}))
+		resp := response{}
+		require.NoError(t, json.NewDecoder(conn).Decode(&resp))
+		assert.Contains(t, resp.Error, "unsupported protocol version")
+	})
+
+	t.Run("Socket in use is not taken over", func(t *testing.T) {
+		path := socketPath(t)
+		l, err := Listen(path, func() (*State, error) { return &State{}, nil })
+		require.NoError(t, err)
+		defer l.Close()
+		_, err = Listen(path, func() (*State, error) { return &State{}, nil })
+		assert.ErrorContains(t, err, "in use")
+	})
+
+	t.Run("Stale socket is replaced", func(t *testing.T) {
+		path := socketPath(t)
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+		l, err := Listen(path, func() (*State, error) { return &State{}, nil })
+		require.NoError(t, err)
+		assert.NoError(t, l.Close())
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health runs checks of the dependencies of a component and reports
+// their results over HTTP, e.g. for Kubernetes readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status is the result of a check, or of all checks of a report
+type Status string
+
+const (
+	StatusOK     Status = "ok"
+	StatusFailed Status = "failed"
+)
+
+// DefaultTimeout is the time checks run by Handler may take
+const DefaultTimeout = 5 * time.Second
+
+// Check is a named check of a dependency. Run returns an error describing
+// why the dependency is not healthy.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// CheckResult is the result of a single check
+type CheckResult struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Report holds the results of all checks. Its status is only StatusOK if all
+// checks succeeded.
+type Report struct {
+	Status Status        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Run runs all checks concurrently and returns their results in the order of
+// checks. Checks that don't finish before ctx is done fail.
+func Run(ctx context.Context, checks ...Check) Report {
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan error, 1)
+			go func() { done <- c.Run(ctx) }()
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			results[i] = CheckResult{Name: c.Name, Status: StatusOK}
+			if err != nil {
+				results[i].Status = StatusFailed
+				results[i].Message = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	report := Report{Status: StatusOK, Checks: results}
+	for _, r := range results {
+		if r.Status != StatusOK {
+			report.Status = StatusFailed
+		}
+	}
+	return report
+}
+
+// Handler returns an HTTP handler running checks on every request. It
+// responds with the report as JSON, and with status 200 if all checks
+// succeeded or 503 otherwise. checks is called on every request, so the set
+// of checks may change at runtime.
+func Handler(timeout time.Duration, checks func() []Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		report := Run(ctx, checks()...)
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
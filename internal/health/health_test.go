@@ -0,0 +1,62 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Handler(t *testing.T) {
+	ok := Check{Name: "ok", Run: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "failing", Run: func(ctx context.Context) error { return errors.New("unreachable") }}
+	hanging := Check{Name: "hanging", Run: func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}}
+	get := func(checks ...Check) (int, Report) {
+		rec := httptest.NewRecorder()
+		Handler(50*time.Millisecond, func() []Check { return checks })(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		return rec.Code, report
+	}
+
+	code, report := get(ok)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Report{Status: StatusOK, Checks: []CheckResult{{Name: "ok", Status: StatusOK}}}, report)
+
+	code, report = get(ok, failing, hanging)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFailed, report.Status)
+	require.Len(t, report.Checks, 3)
+	assert.Equal(t, StatusOK, report.Checks[0].Status)
+	assert.Equal(t, CheckResult{Name: "failing", Status: StatusFailed, Message: "unreachable"}, report.Checks[1])
+	assert.Equal(t, StatusFailed, report.Checks[2].Status)
+	assert.Contains(t, report.Checks[2].Message, "deadline exceeded")
+
+	code, report = get()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, report.Checks)
+}
@@ -0,0 +1,225 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package inflight keeps track of long-running operations, such as log streams
+or terminal sessions, that are currently being processed. Each operation is
+identified by its kind and the UUID of the request that started it.
+
+The Registry prevents the same request from being processed twice, enforces
+a maximum number of concurrent operations and a maximum age per kind, and
+allows operations to be listed and cancelled from the outside.
+*/
+package inflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of an inflight operation, e.g. "logs"
+type Kind string
+
+// ErrDuplicate is returned when the request is already in flight
+var ErrDuplicate = errors.New("request is already in flight")
+
+// ErrLimitExceeded is returned when the maximum number of operations of a
+// kind is already in flight
+var ErrLimitExceeded = errors.New("too many requests in flight")
+
+// Entry describes an inflight operation
+type Entry struct {
+	Kind       Kind              `json:"kind"`
+	ID         string            `json:"id"`
+	StartedAt  time.Time         `json:"startedAt"`
+	Age        string            `json:"age"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type entry struct {
+	startedAt  time.Time
+	attributes map[string]string
+	cancel     context.CancelFunc
+}
+
+type key struct {
+	kind Kind
+	id   string
+}
+
+// Registry keeps track of inflight operations. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	entries map[key]*entry
+	limits  map[Kind]int
+	maxAges map[Kind]time.Duration
+}
+
+// Option is a functional option for NewRegistry
+type Option func(r *Registry)
+
+// WithLimit sets the maximum number of concurrent operations of the given
+// kind. A limit of 0 or less means no limit.
+func WithLimit(kind Kind, limit int) Option {
+	return func(r *Registry) {
+		r.limits[kind] = limit
+	}
+}
+
+// WithMaxAge sets the maximum time an operation of the given kind may be in
+// flight. Once exceeded, the operation's context is cancelled. A max age of 0
+// or less means no limit.
+func WithMaxAge(kind Kind, maxAge time.Duration) Option {
+	return func(r *Registry) {
+		r.maxAges[kind] = maxAge
+	}
+}
+
+// NewRegistry returns a new, empty Registry
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{
+		entries: make(map[key]*entry),
+		limits:  make(map[Kind]int),
+		maxAges: make(map[Kind]time.Duration),
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// Start registers the operation identified by kind and id as inflight. It
+// returns a context derived from parent, which is cancelled when the
+// operation exceeds its max age or is cancelled via Cancel, and a function
+// that must be called once the operation is finished.
+//
+// If the operation is already in flight, ErrDuplicate is returned. If the
+// limit for kind has been reached, ErrLimitExceeded is returned.
+func (r *Registry) Start(parent context.Context, kind Kind, id string, attributes map[string]string) (context.Context, func(), error) {
+	k := key{kind: kind, id: id}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[k]; ok {
+		return nil, nil, ErrDuplicate
+	}
+	if limit := r.limits[kind]; limit > 0 && r.count(kind) >= limit {
+		return nil, nil, fmt.Errorf("%w: %d %s operations are running", ErrLimitExceeded, limit, kind)
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if maxAge := r.maxAges[kind]; maxAge > 0 {
+		ctx, cancel = context.WithTimeout(parent, maxAge)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	e := &entry{startedAt: time.Now(), attributes: attributes, cancel: cancel}
+	r.entries[k] = e
+
+	done := func() {
+		cancel()
+		r.mu.Lock()
+		// The entry may have been replaced after a forced cancel
+		if r.entries[k] == e {
+			delete(r.entries, k)
+		}
+		r.mu.Unlock()
+	}
+	return ctx, done, nil
+}
+
+// count returns the number of inflight operations of kind. Must be called
+// with the lock held.
+func (r *Registry) count(kind Kind) int {
+	n := 0
+	for k := range r.entries {
+		if k.kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of inflight operations of the given kind
+func (r *Registry) Len(kind Kind) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count(kind)
+}
+
+// SetLimit changes the maximum number of concurrent operations of the given
+// kind. Operations already in flight are not affected. A limit of 0 or less
+// means no limit.
+func (r *Registry) SetLimit(kind Kind, limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits[kind] = limit
+}
+
+// Limit returns the maximum number of concurrent operations of the given kind
+func (r *Registry) Limit(kind Kind) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limits[kind]
+}
+
+// IsInflight returns whether the operation identified by kind and id is in
+// flight.
+func (r *Registry) IsInflight(kind Kind, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.entries[key{kind: kind, id: id}]
+	return ok
+}
+
+// Cancel cancels the operation identified by kind and id and removes it from
+// the registry. It returns false if no such operation is in flight.
+func (r *Registry) Cancel(kind Kind, id string) bool {
+	k := key{kind: kind, id: id}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[k]
+	if !ok {
+		return false
+	}
+	e.cancel()
+	delete(r.entries, k)
+	return true
+}
+
+// List returns all inflight operations, oldest first
+func (r *Registry) List() []Entry {
+	now := time.Now()
+	r.mu.Lock()
+	entries := make([]Entry, 0, len(r.entries))
+	for k, e := range r.entries {
+		entries = append(entries, Entry{
+			Kind:       k.kind,
+			ID:         k.id,
+			StartedAt:  e.startedAt,
+			Age:        now.Sub(e.startedAt).Round(time.Millisecond).String(),
+			Attributes: e.attributes,
+		})
+	}
+	r.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StartedAt.Before(entries[j].StartedAt)
+	})
+	return entries
+}
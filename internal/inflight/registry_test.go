@@ -0,0 +1,145 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inflight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	kindLogs     Kind = "logs"
+	kindTerminal Kind = "terminal"
+)
+
+func Test_Registry(t *testing.T) {
+	t.Run("Start and finish", func(t *testing.T) {
+		r := NewRegistry()
+		ctx, done, err := r.Start(context.Background(), kindLogs, "a", map[string]string{"pod": "p"})
+		require.NoError(t, err)
+		assert.True(t, r.IsInflight(kindLogs, "a"))
+		assert.False(t, r.IsInflight(kindTerminal, "a"))
+		assert.Equal(t, 1, r.Len(kindLogs))
+		done()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+		assert.False(t, r.IsInflight(kindLogs, "a"))
+		assert.Equal(t, 0, r.Len(kindLogs))
+	})
+
+	t.Run("Duplicates are rejected", func(t *testing.T) {
+		r := NewRegistry()
+		_, done, err := r.Start(context.Background(), kindLogs, "a", nil)
+		require.NoError(t, err)
+		defer done()
+		_, _, err = r.Start(context.Background(), kindLogs, "a", nil)
+		assert.ErrorIs(t, err, ErrDuplicate)
+		// Same ID of another kind is fine
+		_, done2, err := r.Start(context.Background(), kindTerminal, "a", nil)
+		require.NoError(t, err)
+		done2()
+	})
+
+	t.Run("Limit per kind", func(t *testing.T) {
+		r := NewRegistry(WithLimit(kindLogs, 2))
+		_, done1, err := r.Start(context.Background(), kindLogs, "a", nil)
+		require.NoError(t, err)
+		_, done2, err := r.Start(context.Background(), kindLogs, "b", nil)
+		require.NoError(t, err)
+		defer done2()
+		_, _, err = r.Start(context.Background(), kindLogs, "c", nil)
+		assert.ErrorIs(t, err, ErrLimitExceeded)
+		// Other kinds are not limited
+		_, done3, err := r.Start(context.Background(), kindTerminal, "c", nil)
+		require.NoError(t, err)
+		defer done3()
+		// Finishing an operation frees up a slot
+		done1()
+		_, done4, err := r.Start(context.Background(), kindLogs, "c", nil)
+		require.NoError(t, err)
+		done4()
+	})
+
+	t.Run("Limit can be changed at runtime", func(t *testing.T) {
+		r := NewRegistry(WithLimit(kindLogs, 1))
+		_, done1, err := r.Start(context.Background(), kindLogs, "a", nil)
+		require.NoError(t, err)
+		defer done1()
+		_, _, err = r.Start(context.Background(), kindLogs, "b", nil)
+		assert.ErrorIs(t, err, ErrLimitExceeded)
+		r.SetLimit(kindLogs, 2)
+		assert.Equal(t, 2, r.Limit(kindLogs))
+		_, done2, err := r.Start(context.Background(), kindLogs, "b", nil)
+		require.NoError(t, err)
+		defer done2()
+		// Lowering the limit does not affect running operations
+		r.SetLimit(kindLogs, 1)
+		assert.Equal(t, 2, r.Len(kindLogs))
+		_, _, err = r.Start(context.Background(), kindLogs, "c", nil)
+		assert.ErrorIs(t, err, ErrLimitExceeded)
+	})
+
+	t.Run("Max age cancels the operation", func(t *testing.T) {
+		r := NewRegistry(WithMaxAge(kindLogs, 10*time.Millisecond))
+		ctx, done, err := r.Start(context.Background(), kindLogs, "a", nil)
+		require.NoError(t, err)
+		defer done()
+		select {
+		case <-ctx.Done():
+			assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+		case <-time.After(5 * time.Second):
+			t.Fatal("operation was not cancelled")
+		}
+	})
+
+	t.Run("Forced cancel", func(t *testing.T) {
+		r := NewRegistry()
+		ctx, done, err := r.Start(context.Background(), kindLogs, "a", nil)
+		require.NoError(t, err)
+		assert.True(t, r.Cancel(kindLogs, "a"))
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+		assert.False(t, r.IsInflight(kindLogs, "a"))
+		assert.False(t, r.Cancel(kindLogs, "a"))
+
+		// A new operation with the same ID must not be removed by the
+		// cancelled operation finishing.
+		_, done2, err := r.Start(context.Background(), kindLogs, "a", nil)
+		require.NoError(t, err)
+		defer done2()
+		done()
+		assert.True(t, r.IsInflight(kindLogs, "a"))
+	})
+
+	t.Run("List is sorted by age", func(t *testing.T) {
+		r := NewRegistry()
+		_, done1, err := r.Start(context.Background(), kindLogs, "first", map[string]string{"pod": "p"})
+		require.NoError(t, err)
+		defer done1()
+		time.Sleep(time.Millisecond)
+		_, done2, err := r.Start(context.Background(), kindTerminal, "second", nil)
+		require.NoError(t, err)
+		defer done2()
+		entries := r.List()
+		require.Len(t, entries, 2)
+		assert.Equal(t, "first", entries[0].ID)
+		assert.Equal(t, kindLogs, entries[0].Kind)
+		assert.Equal(t, "p", entries[0].Attributes["pod"])
+		assert.Equal(t, "second", entries[1].ID)
+		assert.NotEmpty(t, entries[1].Age)
+	})
+}
@@ -54,6 +54,18 @@ func NewKubernetesClient(ctx context.Context, client kubernetes.Interface, appli
 	return kc
 }
 
+// Dynamic returns the client's dynamic client, or creates one from its REST
+// configuration if none was set.
+func (c *KubernetesClient) Dynamic() (dynamic.Interface, error) {
+	if c.DynamicClient != nil {
+		return c.DynamicClient, nil
+	}
+	if c.RestConfig == nil {
+		return nil, errors.New("kubernetes client has neither a dynamic client nor a REST configuration")
+	}
+	return dynamic.NewForConfig(c.RestConfig)
+}
+
 // NewKubernetesClient creates a new Kubernetes client object from given
 // configuration file. If configuration file is the empty string, in-cluster
 // client will be created.
@@ -82,6 +94,12 @@ func NewKubernetesClientFromConfig(ctx context.Context, namespace string, kubeco
 		}
 	}
 
+	return NewKubernetesClientForConfig(ctx, config, namespace)
+}
+
+// NewKubernetesClientForConfig creates a new Kubernetes client object from
+// the given REST configuration.
+func NewKubernetesClientForConfig(ctx context.Context, config *rest.Config, namespace string) (*KubernetesClient, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
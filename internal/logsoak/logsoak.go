@@ -0,0 +1,285 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package logsoak soak-tests long-lived follow log streams. It keeps a number of
+follow streams open through the Argo CD API for a long time, optionally
+inducing reconnects of the agent, and verifies that
+
+  - no stream is interrupted,
+  - no line is delivered twice, except for those within the resume overlap,
+  - the agent does not leak inflight log streams or goroutines, and
+  - the agent's memory usage stays stable.
+
+It is used by the end-to-end tests as well as by argocd-agentctl.
+*/
+package logsoak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultResumeOverlap is the time within which lines delivered twice after a
+// resume are tolerated. Current agents suppress such duplicates, older ones
+// rewind resumed streams by 100ms.
+const DefaultResumeOverlap = 100 * time.Millisecond
+
+// Config configures a soak test
+type Config struct {
+	// LogsURL is the URL of the Argo CD API's log endpoint, including the
+	// query parameters selecting the container. follow=true is added.
+	LogsURL string
+	// Token is the Argo CD API token to authenticate with
+	Token string
+	// Streams is the number of follow streams to keep open
+	Streams int
+	// Duration is how long the streams are kept open
+	Duration time.Duration
+	// ResumeOverlap is the time within which lines may be delivered twice.
+	// Defaults to DefaultResumeOverlap.
+	ResumeOverlap time.Duration
+	// AgentHealthzURL is the base URL of the agent's healthz server, used to
+	// count inflight log streams. Leak checks are skipped if empty.
+	AgentHealthzURL string
+	// AgentMetricsURL is the URL of the agent's metrics, used to track
+	// goroutines and memory. Resource checks are skipped if empty.
+	AgentMetricsURL string
+	// SampleInterval is the interval at which the agent is sampled
+	SampleInterval time.Duration
+	// Reconnect, if set, is called every ReconnectInterval to make the agent
+	// reconnect to the principal
+	Reconnect         func(ctx context.Context) error
+	ReconnectInterval time.Duration
+	// SettleTime is how long to wait after closing the streams for the agent
+	// to clean up, before taking the final sample
+	SettleTime time.Duration
+	// HTTPClient is the client used for all requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (c *Config) setDefaults() error {
+	if c.LogsURL == "" {
+		return errors.New("logs URL is required")
+	}
+	if c.Streams <= 0 {
+		return errors.New("number of streams must be positive")
+	}
+	if c.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if c.ResumeOverlap == 0 {
+		c.ResumeOverlap = DefaultResumeOverlap
+	}
+	if c.SampleInterval == 0 {
+		c.SampleInterval = time.Minute
+	}
+	if c.SettleTime == 0 {
+		c.SettleTime = 30 * time.Second
+	}
+	if c.Reconnect != nil && c.ReconnectInterval <= 0 {
+		return errors.New("reconnect interval must be positive")
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+	return nil
+}
+
+// Report is the result of a soak test
+type Report struct {
+	// Streams are the statistics of each stream
+	Streams []*StreamStats
+	// Samples are taken from the agent every sample interval. The first one
+	// is taken before opening the streams, the last one after they were
+	// closed.
+	Samples []Sample
+	// Reconnects is the number of induced agent reconnects
+	Reconnects int
+	// ReconnectErrors are the errors of failed reconnect attempts
+	ReconnectErrors []string
+}
+
+// Run runs a soak test with the given configuration. It returns an error
+// only if the test could not be run; use Report.Check to verify the results.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+	req, err := followRequest(cfg.LogsURL, cfg.Token)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	sampler := &sampler{cfg: &cfg}
+	first, err := sampler.sample(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not sample agent: %w", err)
+	}
+	report.Samples = append(report.Samples, first)
+
+	soakCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Streams; i++ {
+		st := &StreamStats{ID: i, tracker: newLineTracker(cfg.ResumeOverlap)}
+		report.Streams = append(report.Streams, st)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.follow(soakCtx, cfg.HTTPClient, req)
+		}()
+	}
+
+	var bgWg sync.WaitGroup
+	var mu sync.Mutex
+	bgWg.Add(1)
+	go func() {
+		defer bgWg.Done()
+		ticker := time.NewTicker(cfg.SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-soakCtx.Done():
+				return
+			case <-ticker.C:
+				if s, err := sampler.sample(soakCtx); err == nil {
+					mu.Lock()
+					report.Samples = append(report.Samples, s)
+					mu.Unlock()
+				}
+			}
+		}
+	}()
+	if cfg.Reconnect != nil {
+		bgWg.Add(1)
+		go func() {
+			defer bgWg.Done()
+			ticker := time.NewTicker(cfg.ReconnectInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-soakCtx.Done():
+					return
+				case <-ticker.C:
+					err := cfg.Reconnect(soakCtx)
+					mu.Lock()
+					report.Reconnects++
+					if err != nil && soakCtx.Err() == nil {
+						report.ReconnectErrors = append(report.ReconnectErrors, err.Error())
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	bgWg.Wait()
+
+	// Give the agent time to notice that the clients went away
+	select {
+	case <-ctx.Done():
+		return report, ctx.Err()
+	case <-time.After(cfg.SettleTime):
+	}
+	last, err := sampler.sample(ctx)
+	if err != nil {
+		return report, fmt.Errorf("could not sample agent: %w", err)
+	}
+	report.Samples = append(report.Samples, last)
+	return report, nil
+}
+
+// Limits are the limits a soak test must stay within to pass
+type Limits struct {
+	// MaxGoroutineGrowth is the number of goroutines the agent may have
+	// more at the end of the test than at the start
+	MaxGoroutineGrowth float64
+	// MaxHeapGrowth is the factor by which the agent's heap may grow over
+	// the course of the test
+	MaxHeapGrowth float64
+}
+
+// DefaultLimits returns the default limits for Report.Check
+func DefaultLimits() Limits {
+	return Limits{
+		MaxGoroutineGrowth: 20,
+		MaxHeapGrowth:      1.5,
+	}
+}
+
+// Check verifies the results of the soak test against limits and returns
+// all violations found.
+func (r *Report) Check(limits Limits) error {
+	var errs []error
+	for _, st := range r.Streams {
+		if st.Interrupted != "" {
+			errs = append(errs, fmt.Errorf("stream %d was interrupted after %d lines: %s", st.ID, st.Lines, st.Interrupted))
+		}
+		if st.Lines == 0 {
+			errs = append(errs, fmt.Errorf("stream %d did not receive any lines", st.ID))
+		}
+		if st.ExcessDuplicates > 0 {
+			errs = append(errs, fmt.Errorf("stream %d received %d duplicate lines outside of the resume overlap", st.ID, st.ExcessDuplicates))
+		}
+	}
+	if len(r.Samples) >= 2 {
+		first, last := r.Samples[0], r.Samples[len(r.Samples)-1]
+		if first.HasInflight && last.InflightLogs > first.InflightLogs {
+			errs = append(errs, fmt.Errorf("agent has %d inflight log streams after the test, %d before", last.InflightLogs, first.InflightLogs))
+		}
+		if first.HasMetrics {
+			if growth := last.Goroutines - first.Goroutines; growth > limits.MaxGoroutineGrowth {
+				errs = append(errs, fmt.Errorf("agent goroutines grew by %.0f (from %.0f to %.0f)", growth, first.Goroutines, last.Goroutines))
+			}
+			if first.HeapBytes > 0 && last.HeapBytes/first.HeapBytes > limits.MaxHeapGrowth {
+				errs = append(errs, fmt.Errorf("agent heap grew by a factor of %.2f (from %.0f to %.0f bytes)", last.HeapBytes/first.HeapBytes, first.HeapBytes, last.HeapBytes))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Summary returns a human readable summary of the report
+func (r *Report) Summary(w io.Writer) {
+	lines, dups, excess := 0, 0, 0
+	for _, st := range r.Streams {
+		lines += st.Lines
+		dups += st.Duplicates
+		excess += st.ExcessDuplicates
+	}
+	fmt.Fprintf(w, "Streams:              %d\n", len(r.Streams))
+	fmt.Fprintf(w, "Lines received:       %d\n", lines)
+	fmt.Fprintf(w, "Duplicates (overlap): %d\n", dups-excess)
+	fmt.Fprintf(w, "Duplicates (excess):  %d\n", excess)
+	fmt.Fprintf(w, "Induced reconnects:   %d (%d failed)\n", r.Reconnects, len(r.ReconnectErrors))
+	if len(r.Samples) >= 2 {
+		first, last := r.Samples[0], r.Samples[len(r.Samples)-1]
+		if first.HasInflight {
+			fmt.Fprintf(w, "Inflight log streams: %d -> %d\n", first.InflightLogs, last.InflightLogs)
+		}
+		if first.HasMetrics {
+			fmt.Fprintf(w, "Goroutines:           %.0f -> %.0f\n", first.Goroutines, last.Goroutines)
+			fmt.Fprintf(w, "Heap in use (bytes):  %.0f -> %.0f\n", first.HeapBytes, last.HeapBytes)
+		}
+	}
+}
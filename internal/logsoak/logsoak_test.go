@@ -0,0 +1,177 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logsoak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func logLine(ts time.Time, content string) string {
+	return fmt.Sprintf(`{"result":{"content":%q,"timeStampStr":%q}}`+"\n", content, ts.Format(time.RFC3339Nano))
+}
+
+func Test_lineTracker(t *testing.T) {
+	now := time.Now()
+	tr := newLineTracker(100 * time.Millisecond)
+
+	dup, excess := tr.observe(now, "a")
+	assert.False(t, dup)
+	assert.False(t, excess)
+	dup, excess = tr.observe(now.Add(time.Second), "b")
+	assert.False(t, dup)
+	assert.False(t, excess)
+
+	t.Run("Duplicate within overlap", func(t *testing.T) {
+		dup, excess := tr.observe(now.Add(time.Second), "b")
+		assert.True(t, dup)
+		assert.False(t, excess)
+	})
+
+	t.Run("Duplicate outside of overlap", func(t *testing.T) {
+		dup, excess := tr.observe(now, "a")
+		assert.True(t, dup)
+		assert.True(t, excess)
+	})
+
+	t.Run("Old lines are pruned", func(t *testing.T) {
+		tr.observe(now.Add(2*time.Minute), "c")
+		assert.Len(t, tr.seen, 1)
+	})
+}
+
+func Test_read(t *testing.T) {
+	now := time.Now()
+	t.Run("Counts lines and duplicates", func(t *testing.T) {
+		st := &StreamStats{tracker: newLineTracker(DefaultResumeOverlap)}
+		body := logLine(now, "a") + logLine(now.Add(time.Second), "b") + "\n" +
+			logLine(now.Add(time.Second), "b") + `{"result":{"last":true}}` + "\n"
+		require.NoError(t, st.read(strings.NewReader(body)))
+		assert.Equal(t, 3, st.Lines)
+		assert.Equal(t, 1, st.Duplicates)
+		assert.Equal(t, 0, st.ExcessDuplicates)
+	})
+
+	t.Run("Error from server", func(t *testing.T) {
+		st := &StreamStats{tracker: newLineTracker(DefaultResumeOverlap)}
+		err := st.read(strings.NewReader(`{"error":{"message":"boom"}}`))
+		assert.ErrorContains(t, err, "boom")
+	})
+
+	t.Run("Invalid entry", func(t *testing.T) {
+		st := &StreamStats{tracker: newLineTracker(DefaultResumeOverlap)}
+		assert.Error(t, st.read(strings.NewReader("not json\n")))
+	})
+}
+
+func Test_Run(t *testing.T) {
+	var reconnects atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "true" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		flusher := w.(http.Flusher)
+		ts := time.Now()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+				ts = ts.Add(10 * time.Millisecond)
+				_, _ = w.Write([]byte(logLine(ts, "line")))
+				flusher.Flush()
+			}
+		}
+	})
+	mux.HandleFunc("/debug/inflight", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]inflight.Entry{{Kind: "logs", ID: "1"}, {Kind: "other", ID: "2"}})
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# TYPE go_goroutines gauge\ngo_goroutines 10\n# TYPE go_memstats_heap_inuse_bytes gauge\ngo_memstats_heap_inuse_bytes 1000\n")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := Config{
+		LogsURL:           srv.URL + "/logs?container=c",
+		Token:             "token",
+		Streams:           3,
+		Duration:          300 * time.Millisecond,
+		AgentHealthzURL:   srv.URL,
+		AgentMetricsURL:   srv.URL + "/metrics",
+		SampleInterval:    50 * time.Millisecond,
+		ReconnectInterval: 100 * time.Millisecond,
+		Reconnect: func(ctx context.Context) error {
+			reconnects.Add(1)
+			return nil
+		},
+		SettleTime: 10 * time.Millisecond,
+	}
+	report, err := Run(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Len(t, report.Streams, 3)
+	for _, st := range report.Streams {
+		assert.Empty(t, st.Interrupted)
+		assert.NotZero(t, st.Lines)
+	}
+	assert.GreaterOrEqual(t, len(report.Samples), 2)
+	first := report.Samples[0]
+	assert.True(t, first.HasInflight)
+	assert.Equal(t, 1, first.InflightLogs)
+	assert.True(t, first.HasMetrics)
+	assert.Equal(t, float64(10), first.Goroutines)
+	assert.Equal(t, int(reconnects.Load()), report.Reconnects)
+	assert.NoError(t, report.Check(DefaultLimits()))
+
+	var buf bytes.Buffer
+	report.Summary(&buf)
+	assert.Contains(t, buf.String(), "Streams:              3")
+
+	t.Run("Check reports violations", func(t *testing.T) {
+		r := &Report{
+			Streams: []*StreamStats{{ID: 0, Lines: 1, ExcessDuplicates: 2}, {ID: 1, Interrupted: "EOF"}},
+			Samples: []Sample{
+				{HasInflight: true, InflightLogs: 0, HasMetrics: true, Goroutines: 10, HeapBytes: 1000},
+				{HasInflight: true, InflightLogs: 2, HasMetrics: true, Goroutines: 100, HeapBytes: 3000},
+			},
+		}
+		err := r.Check(DefaultLimits())
+		require.Error(t, err)
+		for _, msg := range []string{"2 duplicate lines", "stream 1 was interrupted", "stream 1 did not receive", "2 inflight", "goroutines grew", "heap grew"} {
+			assert.ErrorContains(t, err, msg)
+		}
+	})
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		_, err := Run(context.Background(), Config{Streams: 1, Duration: time.Second})
+		assert.Error(t, err)
+		_, err = Run(context.Background(), Config{LogsURL: srv.URL, Duration: time.Second})
+		assert.Error(t, err)
+	})
+}
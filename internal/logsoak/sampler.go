@@ -0,0 +1,137 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logsoak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/inflight"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// inflightLogsKind is the kind the agent tracks log streams as
+const inflightLogsKind inflight.Kind = "logs"
+
+// Sample is the state of the agent at a point in time
+type Sample struct {
+	Time time.Time
+	// HasInflight is set if InflightLogs was sampled
+	HasInflight bool
+	// InflightLogs is the number of log streams the agent processes
+	InflightLogs int
+	// HasMetrics is set if Goroutines and HeapBytes were sampled
+	HasMetrics bool
+	// Goroutines is the number of goroutines of the agent
+	Goroutines float64
+	// HeapBytes is the agent's heap memory in use
+	HeapBytes float64
+}
+
+type sampler struct {
+	cfg *Config
+}
+
+func (s *sampler) sample(ctx context.Context) (Sample, error) {
+	sample := Sample{Time: time.Now()}
+	if s.cfg.AgentHealthzURL != "" {
+		n, err := s.inflightLogs(ctx)
+		if err != nil {
+			return sample, err
+		}
+		sample.HasInflight = true
+		sample.InflightLogs = n
+	}
+	if s.cfg.AgentMetricsURL != "" {
+		g, h, err := s.resources(ctx)
+		if err != nil {
+			return sample, err
+		}
+		sample.HasMetrics = true
+		sample.Goroutines = g
+		sample.HeapBytes = h
+	}
+	return sample, nil
+}
+
+func (s *sampler) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// inflightLogs returns the number of log streams inflight on the agent
+func (s *sampler) inflightLogs(ctx context.Context) (int, error) {
+	resp, err := s.get(ctx, strings.TrimSuffix(s.cfg.AgentHealthzURL, "/")+"/debug/inflight")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var entries []inflight.Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return 0, fmt.Errorf("could not decode inflight requests: %w", err)
+	}
+	n := 0
+	for _, e := range entries {
+		if e.Kind == inflightLogsKind {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// resources returns the number of goroutines and the heap in use of the
+// agent
+func (s *sampler) resources(ctx context.Context) (goroutines, heap float64, err error) {
+	resp, err := s.get(ctx, s.cfg.AgentMetricsURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not parse agent metrics: %w", err)
+	}
+	gauge := func(name string) (float64, error) {
+		mf, ok := families[name]
+		if !ok || len(mf.GetMetric()) == 0 {
+			return 0, fmt.Errorf("agent metrics have no %s", name)
+		}
+		return mf.GetMetric()[0].GetGauge().GetValue(), nil
+	}
+	if goroutines, err = gauge("go_goroutines"); err != nil {
+		return 0, 0, err
+	}
+	if heap, err = gauge("go_memstats_heap_inuse_bytes"); err != nil {
+		return 0, 0, err
+	}
+	return goroutines, heap, nil
+}
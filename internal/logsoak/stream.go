@@ -0,0 +1,183 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logsoak
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StreamStats are the statistics of a single follow stream
+type StreamStats struct {
+	// ID is the number of the stream
+	ID int
+	// Lines is the number of lines received
+	Lines int
+	// Duplicates is the number of lines received more than once
+	Duplicates int
+	// ExcessDuplicates is the number of lines received more than once, or
+	// out of order, outside of the resume overlap
+	ExcessDuplicates int
+	// Interrupted is set to the reason if the stream ended before the end
+	// of the test
+	Interrupted string
+
+	tracker *lineTracker
+}
+
+// logEntry is a line of the Argo CD API's log stream
+type logEntry struct {
+	Result struct {
+		Content      string `json:"content"`
+		TimeStampStr string `json:"timeStampStr"`
+		Last         bool   `json:"last"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func followRequest(logsURL, token string) (*http.Request, error) {
+	u, err := url.Parse(logsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logs URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("follow", "true")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// follow reads the stream until ctx is done
+func (st *StreamStats) follow(ctx context.Context, client *http.Client, req *http.Request) {
+	resp, err := client.Do(req.Clone(ctx))
+	if err != nil {
+		st.interrupt(ctx, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		st.interrupt(ctx, fmt.Errorf("unexpected HTTP status %s", resp.Status))
+		return
+	}
+	err = st.read(resp.Body)
+	if err == nil {
+		err = errors.New("stream ended")
+	}
+	st.interrupt(ctx, err)
+}
+
+func (st *StreamStats) interrupt(ctx context.Context, err error) {
+	// Streams end when the test is over, which is not an interruption
+	if ctx.Err() != nil {
+		return
+	}
+	st.Interrupted = err.Error()
+}
+
+// read processes the lines of the Argo CD API's log stream in r
+func (st *StreamStats) read(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		b := s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var e logEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("invalid log entry: %w", err)
+		}
+		if e.Error != nil {
+			return fmt.Errorf("error from server: %s", e.Error.Message)
+		}
+		if e.Result.Last {
+			return nil
+		}
+		ts, err := time.Parse(time.RFC3339Nano, e.Result.TimeStampStr)
+		if err != nil {
+			return fmt.Errorf("log entry without timestamp: %w", err)
+		}
+		st.Lines++
+		dup, excess := st.tracker.observe(ts, e.Result.Content)
+		if dup {
+			st.Duplicates++
+		}
+		if excess {
+			st.ExcessDuplicates++
+		}
+	}
+	return s.Err()
+}
+
+// lineTracker detects lines delivered more than once. It remembers the lines
+// of the last window, so that memory stays bounded on long-running streams.
+type lineTracker struct {
+	overlap time.Duration
+	window  time.Duration
+	latest  time.Time
+	seen    map[string]time.Time
+	pruned  time.Time
+}
+
+func newLineTracker(overlap time.Duration) *lineTracker {
+	return &lineTracker{
+		overlap: overlap,
+		window:  time.Minute,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// observe records a line and returns whether it was seen before, and whether
+// it violates the resume overlap: Lines of a container arrive in order, so a
+// line older than the newest line seen minus the overlap is either a
+// duplicate or out of order, both of which must not happen.
+func (t *lineTracker) observe(ts time.Time, content string) (dup bool, excess bool) {
+	key := ts.Format(time.RFC3339Nano) + " " + content
+	if _, ok := t.seen[key]; ok {
+		dup = true
+	}
+	if ts.Before(t.latest.Add(-t.overlap)) {
+		excess = true
+	}
+	if ts.After(t.latest) {
+		t.latest = ts
+	}
+	t.seen[key] = ts
+	if t.latest.Sub(t.pruned) > t.window {
+		for k, seenTs := range t.seen {
+			if seenTs.Before(t.latest.Add(-t.window)) {
+				delete(t.seen, k)
+			}
+		}
+		t.pruned = t.latest
+	}
+	return dup, excess
+}
@@ -0,0 +1,345 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package loki reads historical container logs from Grafana Loki, so that the
+logs of pods which are gone from the cluster can still be served.
+*/
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+)
+
+const (
+	// DefaultLookback is how far back logs are searched for, unless the
+	// request specifies a start time
+	DefaultLookback = 7 * 24 * time.Hour
+	// defaultPageSize is the number of lines requested from Loki at once,
+	// which is Loki's default maximum
+	defaultPageSize = 5000
+	defaultTimeout  = 30 * time.Second
+)
+
+// ErrNoLogs is returned when Loki has no logs for the requested container
+var ErrNoLogs = errors.New("no logs found in Loki")
+
+// Labels are the names of the Loki labels identifying the logs of a
+// container
+type Labels struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+// DefaultLabels are the labels set by the Kubernetes discovery of common
+// log collectors, such as Promtail and Grafana Alloy
+var DefaultLabels = Labels{Namespace: "namespace", Pod: "pod", Container: "container"}
+
+// LogSource reads container logs from Loki's query API
+type LogSource struct {
+	url      *url.URL
+	client   *http.Client
+	headers  http.Header
+	labels   Labels
+	lookback time.Duration
+	pageSize int
+	now      func() time.Time
+}
+
+// Option is a functional option for NewLogSource
+type Option func(s *LogSource)
+
+// WithTenant sets the tenant to query logs of, in multi-tenant setups of Loki
+func WithTenant(tenant string) Option {
+	return func(s *LogSource) {
+		if tenant != "" {
+			s.headers.Set("X-Scope-OrgID", tenant)
+		}
+	}
+}
+
+// WithHeader sets a header sent with every request, e.g. for authentication
+func WithHeader(key, value string) Option {
+	return func(s *LogSource) {
+		s.headers.Add(key, value)
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to query Loki
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *LogSource) {
+		s.client = c
+	}
+}
+
+// WithLabels sets the names of the labels identifying the logs of a container
+func WithLabels(labels Labels) Option {
+	return func(s *LogSource) {
+		s.labels = labels
+	}
+}
+
+// WithLookback sets how far back logs are searched for, unless the request
+// specifies a start time
+func WithLookback(d time.Duration) Option {
+	return func(s *LogSource) {
+		if d > 0 {
+			s.lookback = d
+		}
+	}
+}
+
+// NewLogSource returns a log source reading logs from the Loki server at
+// address, e.g. http://loki-gateway.monitoring
+func NewLogSource(address string, opts ...Option) (*LogSource, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Loki address: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Loki address %s: scheme must be http or https", address)
+	}
+	s := &LogSource{
+		url:      u.JoinPath("/loki/api/v1/query_range"),
+		client:   &http.Client{Timeout: defaultTimeout},
+		headers:  http.Header{},
+		labels:   DefaultLabels,
+		lookback: DefaultLookback,
+		pageSize: defaultPageSize,
+		now:      time.Now,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+// entry is a single log line
+type entry struct {
+	ts   int64
+	line string
+}
+
+// queryResponse is the response of Loki's range query API for log queries
+type queryResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Values [][2]string `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// GetLogStream returns the logs of the requested container found in Loki.
+// Each line is prefixed with its timestamp, like the Kubernetes API does. The
+// logs end with the last line found, even for requests to follow the logs,
+// since the container is gone. Returns ErrNoLogs if there are no logs.
+func (s *LogSource) GetLogStream(ctx context.Context, req *event.ContainerLogRequest) (io.ReadCloser, error) {
+	end := s.now()
+	start := end.Add(-s.lookback)
+	if req.SinceTime != "" {
+		if t, err := time.Parse(time.RFC3339, req.SinceTime); err == nil {
+			start = t
+		}
+	} else if req.SinceSeconds != nil {
+		start = end.Add(-time.Duration(*req.SinceSeconds) * time.Second)
+	}
+	query := s.selector(req)
+
+	var limit int64
+	if req.LimitBytes != nil {
+		limit = *req.LimitBytes
+	}
+	if req.TailLines != nil {
+		entries, err := s.tail(ctx, query, start, end, int(*req.TailLines))
+		if err != nil {
+			return nil, err
+		}
+		return newReader(entries, limit), nil
+	}
+
+	// The first page is fetched right away, so that errors and missing logs
+	// are reported to the caller. The rest is fetched while reading.
+	entries, err := s.query(ctx, query, start, end, "forward", s.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoLogs
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.copyForward(ctx, pw, query, entries, end, limit))
+	}()
+	return pr, nil
+}
+
+// selector returns the LogQL stream selector of the requested container
+func (s *LogSource) selector(req *event.ContainerLogRequest) string {
+	matchers := []string{
+		s.labels.Namespace + "=" + strconv.Quote(req.Namespace),
+		s.labels.Pod + "=" + strconv.Quote(req.PodName),
+	}
+	if req.Container != "" {
+		matchers = append(matchers, s.labels.Container+"="+strconv.Quote(req.Container))
+	}
+	return "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// copyForward writes entries and the pages following them to w, up to limit
+// bytes if limit is positive
+func (s *LogSource) copyForward(ctx context.Context, w io.Writer, query string, entries []entry, end time.Time, limit int64) error {
+	var written int64
+	for {
+		for _, e := range entries {
+			line := formatLine(e)
+			if limit > 0 && written+int64(len(line)) > limit {
+				_, err := io.WriteString(w, line[:limit-written])
+				return err
+			}
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+			written += int64(len(line))
+		}
+		if len(entries) < s.pageSize {
+			return nil
+		}
+		next := time.Unix(0, entries[len(entries)-1].ts+1)
+		var err error
+		entries, err = s.query(ctx, query, next, end, "forward", s.pageSize)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tail returns the last lines entries in the time range, oldest first
+func (s *LogSource) tail(ctx context.Context, query string, start, end time.Time, lines int) ([]entry, error) {
+	var entries []entry
+	for len(entries) < lines {
+		n := min(lines-len(entries), s.pageSize)
+		page, err := s.query(ctx, query, start, end, "backward", n)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(page) < n {
+			break
+		}
+		// The end of the range is exclusive
+		end = time.Unix(0, page[len(page)-1].ts)
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoLogs
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ts < entries[j].ts
+	})
+	return entries, nil
+}
+
+// query runs a range query and returns the entries of all matching streams,
+// ordered in the given direction
+func (s *LogSource) query(ctx context.Context, query string, start, end time.Time, direction string, limit int) ([]entry, error) {
+	u := *s.url
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	params.Set("direction", direction)
+	params.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.headers {
+		req.Header[k] = v
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not query Loki: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("could not query Loki: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	qr := &queryResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(qr); err != nil {
+		return nil, fmt.Errorf("could not decode Loki response: %w", err)
+	}
+	if qr.Status != "success" {
+		return nil, fmt.Errorf("loki query failed with status %s", qr.Status)
+	}
+
+	var entries []entry
+	for _, stream := range qr.Data.Result {
+		for _, v := range stream.Values {
+			ts, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp in Loki response: %w", err)
+			}
+			entries = append(entries, entry{ts: ts, line: v[1]})
+		}
+	}
+	// The streams of a container, e.g. for stdout and stderr, are
+	// interleaved by time
+	sort.SliceStable(entries, func(i, j int) bool {
+		if direction == "backward" {
+			return entries[i].ts > entries[j].ts
+		}
+		return entries[i].ts < entries[j].ts
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// formatLine formats an entry like the Kubernetes API formats log lines with
+// timestamps
+func formatLine(e entry) string {
+	return time.Unix(0, e.ts).UTC().Format(time.RFC3339Nano) + " " + strings.TrimSuffix(e.line, "\n") + "\n"
+}
+
+// newReader returns a reader of the formatted entries, up to limit bytes if
+// limit is positive
+func newReader(entries []entry, limit int64) io.ReadCloser {
+	var sb strings.Builder
+	for _, e := range entries {
+		sb.WriteString(formatLine(e))
+	}
+	logs := sb.String()
+	if limit > 0 && int64(len(logs)) > limit {
+		logs = logs[:limit]
+	}
+	return io.NopCloser(strings.NewReader(logs))
+}
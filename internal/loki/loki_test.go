@@ -0,0 +1,183 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLoki answers range queries from the log lines of two streams, like
+// Loki does for the stdout and stderr of a container
+type fakeLoki struct {
+	mu      sync.Mutex
+	streams [][]entry
+	queries []http.Header
+	params  []map[string]string
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/loki/api/v1/query_range" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	f.queries = append(f.queries, r.Header.Clone())
+	f.params = append(f.params, map[string]string{"query": q.Get("query"), "direction": q.Get("direction")})
+	start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	backward := q.Get("direction") == "backward"
+
+	// Loki applies the limit across all streams
+	var all []entry
+	for _, s := range f.streams {
+		for _, e := range s {
+			if e.ts >= start && e.ts < end {
+				all = append(all, e)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if backward {
+			return all[i].ts > all[j].ts
+		}
+		return all[i].ts < all[j].ts
+	})
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	result := []stream{{Stream: map[string]string{"stream": "stdout"}}, {Stream: map[string]string{"stream": "stderr"}}}
+	for _, e := range all {
+		i := 0
+		if e.ts%2 == 1 {
+			i = 1
+		}
+		result[i].Values = append(result[i].Values, [2]string{strconv.FormatInt(e.ts, 10), e.line})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": "success",
+		"data":   map[string]any{"resultType": "streams", "result": result},
+	})
+}
+
+func Test_LogSource(t *testing.T) {
+	base := time.Date(2025, 12, 7, 10, 30, 0, 0, time.UTC)
+	var lines []entry
+	for i := range 7 {
+		lines = append(lines, entry{ts: base.Add(time.Duration(i)*time.Second).UnixNano() + int64(i%2), line: "line " + strconv.Itoa(i)})
+	}
+	expected := func(from, to int) string {
+		s := ""
+		for _, e := range lines[from:to] {
+			s += time.Unix(0, e.ts).UTC().Format(time.RFC3339Nano) + " " + e.line + "\n"
+		}
+		return s
+	}
+	newSource := func(t *testing.T, opts ...Option) (*LogSource, *fakeLoki) {
+		t.Helper()
+		f := &fakeLoki{streams: [][]entry{lines}}
+		srv := httptest.NewServer(f)
+		t.Cleanup(srv.Close)
+		s, err := NewLogSource(srv.URL, opts...)
+		require.NoError(t, err)
+		s.now = func() time.Time { return base.Add(time.Hour) }
+		s.pageSize = 3
+		return s, f
+	}
+	req := func() *event.ContainerLogRequest {
+		return &event.ContainerLogRequest{Namespace: "guestbook", PodName: "guestbook-ui-1", Container: "ui"}
+	}
+	read := func(t *testing.T, rc io.ReadCloser, err error) string {
+		t.Helper()
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("All logs are read page by page", func(t *testing.T) {
+		s, f := newSource(t, WithTenant("team-a"))
+		rc, err := s.GetLogStream(context.Background(), req())
+		assert.Equal(t, expected(0, 7), read(t, rc, err))
+		assert.Len(t, f.queries, 3)
+		assert.Equal(t, "team-a", f.queries[0].Get("X-Scope-OrgID"))
+		assert.Equal(t, `{namespace="guestbook", pod="guestbook-ui-1", container="ui"}`, f.params[0]["query"])
+	})
+
+	t.Run("Tail lines", func(t *testing.T) {
+		s, f := newSource(t)
+		r := req()
+		tail := int64(4)
+		r.TailLines = &tail
+		rc, err := s.GetLogStream(context.Background(), r)
+		assert.Equal(t, expected(3, 7), read(t, rc, err))
+		assert.Equal(t, "backward", f.params[0]["direction"])
+	})
+
+	t.Run("Since time and byte limit", func(t *testing.T) {
+		s, f := newSource(t, WithLabels(Labels{Namespace: "k8s_namespace", Pod: "k8s_pod", Container: "k8s_container"}))
+		r := req()
+		r.SinceTime = base.Add(5 * time.Second).Format(time.RFC3339)
+		limit := int64(10)
+		r.LimitBytes = &limit
+		rc, err := s.GetLogStream(context.Background(), r)
+		assert.Equal(t, expected(5, 7)[:10], read(t, rc, err))
+		assert.Equal(t, `{k8s_namespace="guestbook", k8s_pod="guestbook-ui-1", k8s_container="ui"}`, f.params[0]["query"])
+	})
+
+	t.Run("No logs", func(t *testing.T) {
+		s, _ := newSource(t)
+		r := req()
+		r.SinceTime = base.Add(time.Minute).Format(time.RFC3339)
+		_, err := s.GetLogStream(context.Background(), r)
+		assert.ErrorIs(t, err, ErrNoLogs)
+	})
+
+	t.Run("Loki errors are reported", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "too many outstanding requests", http.StatusTooManyRequests)
+		}))
+		defer srv.Close()
+		s, err := NewLogSource(srv.URL)
+		require.NoError(t, err)
+		_, err = s.GetLogStream(context.Background(), req())
+		assert.ErrorContains(t, err, "too many outstanding requests")
+	})
+
+	t.Run("Invalid address", func(t *testing.T) {
+		_, err := NewLogSource("loki:3100")
+		assert.Error(t, err)
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"reflect"
 	"time"
 
@@ -44,6 +45,15 @@ type patchTransformer func(existing, incoming *v1alpha1.Application) (jsondiff.P
 // when an update was last received for this Application
 const LastUpdatedAnnotation = "argocd-agent.argoproj.io/last-updated"
 
+const (
+	// FieldManagerAgent is the field manager of server-side applies made by
+	// the agent
+	FieldManagerAgent = "argocd-agent"
+	// FieldManagerPrincipal is the field manager of server-side applies made
+	// by the principal
+	FieldManagerPrincipal = "argocd-agent-principal"
+)
+
 // ApplicationManager manages Argo CD application resources on a given backend.
 //
 // It provides primitives to create, update, upsert and delete applications.
@@ -65,6 +75,10 @@ type ApplicationManager struct {
 	// destinationBasedMapping enables destination-based mapping mode where
 	// applications are stored in their original namespace rather than the agent's namespace.
 	destinationBasedMapping bool
+
+	// fieldManager is the field manager applications are updated as with
+	// server-side apply. Server-side apply is not used if empty.
+	fieldManager string
 }
 
 // ApplicationManagerOption is a callback function to set an option to the Application
@@ -105,6 +119,16 @@ func WithDestinationBasedMapping(enabled bool) ApplicationManagerOption {
 	}
 }
 
+// WithFieldManager makes the manager update applications with server-side
+// apply as fieldManager, instead of overwriting them. The manager then only
+// owns the fields it synchronizes, and fields set by other controllers are
+// left alone.
+func WithFieldManager(fieldManager string) ApplicationManagerOption {
+	return func(m *ApplicationManager) {
+		m.fieldManager = fieldManager
+	}
+}
+
 // NewApplicationManager initializes and returns a new Manager with the given backend and
 // options.
 func NewApplicationManager(be backend.Application, namespace string, opts ...ApplicationManagerOption) (*ApplicationManager, error) {
@@ -163,8 +187,14 @@ func (m *ApplicationManager) Create(ctx context.Context, app *v1alpha1.Applicati
 	if app.Annotations[manager.SourceUIDAnnotation] == "" {
 		app.Annotations[manager.SourceUIDAnnotation] = string(app.UID)
 	}
-
-	created, err := m.applicationBackend.Create(ctx, app)
+	var created *v1alpha1.Application
+	var err error
+	if m.fieldManager != "" {
+		created, err = m.createWithApply(ctx, app)
+	} else {
+		recordLastSynced(app)
+		created, err = m.applicationBackend.Create(ctx, app)
+	}
 	if err == nil {
 		if err := m.Manage(created.QualifiedName()); err != nil {
 			log().Warnf("Could not manage app %s: %v", created.QualifiedName(), err)
@@ -308,48 +338,68 @@ func (m *ApplicationManager) UpdateManagedApp(ctx context.Context, incoming *v1a
 
 	deletionTimestampChanged := false
 
-	updated, err = m.update(ctx, m.allowUpsert, incoming, func(existing, incoming *v1alpha1.Application) {
-		applyManagedIdentity(existing, incoming, identity)
-		existing.Annotations = incoming.Annotations
-		existing.Labels = incoming.Labels
-		existing.Finalizers = incoming.Finalizers
-		existing.Spec = *incoming.Spec.DeepCopy()
-		existing.Operation = operationToUse(existing, incoming)
-
-		if incoming.DeletionTimestamp != nil && existing.DeletionTimestamp == nil {
-			deletionTimestampChanged = true
-		}
+	if m.fieldManager != "" {
+		updated, err = m.apply(ctx, m.allowUpsert, incoming, func(existing, incoming *v1alpha1.Application) *v1alpha1.Application {
+			applyManagedIdentity(existing, incoming, identity)
+			if incoming.DeletionTimestamp != nil && existing.DeletionTimestamp == nil {
+				deletionTimestampChanged = true
+			}
+			return &v1alpha1.Application{
+				ObjectMeta: v1.ObjectMeta{
+					Name:        incoming.Name,
+					Namespace:   incoming.Namespace,
+					Labels:      incoming.Labels,
+					Annotations: withoutLastSynced(incoming.Annotations),
+					Finalizers:  incoming.Finalizers,
+				},
+				Spec:      incoming.Spec,
+				Operation: operationToUse(existing, incoming),
+			}
+		})
+	} else {
+		updated, err = m.update(ctx, m.allowUpsert, incoming, func(existing, incoming *v1alpha1.Application) {
+			applyManagedIdentity(existing, incoming, identity)
+			existing.Labels, existing.Annotations = mergeMetadata(existing, incoming)
+			existing.Finalizers = incoming.Finalizers
+			existing.Spec = *incoming.Spec.DeepCopy()
+			existing.Operation = operationToUse(existing, incoming)
+
+			if incoming.DeletionTimestamp != nil && existing.DeletionTimestamp == nil {
+				deletionTimestampChanged = true
+			}
 
-	}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
-		applyManagedIdentity(existing, incoming, identity)
+		}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
+			applyManagedIdentity(existing, incoming, identity)
 
-		if incoming.DeletionTimestamp != nil && existing.DeletionTimestamp == nil {
-			deletionTimestampChanged = true
-		}
+			if incoming.DeletionTimestamp != nil && existing.DeletionTimestamp == nil {
+				deletionTimestampChanged = true
+			}
 
-		target := &v1alpha1.Application{
-			ObjectMeta: v1.ObjectMeta{
-				Annotations: incoming.Annotations,
-				Labels:      incoming.Labels,
-				Finalizers:  incoming.Finalizers,
-			},
-			Spec:      incoming.Spec,
-			Operation: operationToUse(existing, incoming),
-		}
-		source := &v1alpha1.Application{
-			ObjectMeta: v1.ObjectMeta{
-				Annotations: existing.Annotations,
-				Labels:      existing.Labels,
-			},
-			Spec:      existing.Spec,
-			Operation: existing.Operation,
-		}
-		patch, err := jsondiff.Compare(source, target)
-		if err != nil {
-			return nil, err
-		}
-		return patch, err
-	})
+			labels, annotations := mergeMetadata(existing, incoming)
+			target := &v1alpha1.Application{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: annotations,
+					Labels:      labels,
+					Finalizers:  incoming.Finalizers,
+				},
+				Spec:      incoming.Spec,
+				Operation: operationToUse(existing, incoming),
+			}
+			source := &v1alpha1.Application{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: existing.Annotations,
+					Labels:      existing.Labels,
+				},
+				Spec:      existing.Spec,
+				Operation: existing.Operation,
+			}
+			patch, err := jsondiff.Compare(source, target)
+			if err != nil {
+				return nil, err
+			}
+			return patch, err
+		})
+	}
 	if err == nil {
 		if updated.Generation == 1 {
 			logCtx.Infof("Created application")
@@ -477,65 +527,92 @@ func (m *ApplicationManager) UpdateAutonomousApp(ctx context.Context, namespace
 		return nil, fmt.Errorf("UpdateAutonomousApp should only be called from principal")
 	}
 
-	updated, err = m.update(ctx, true, incoming, func(existing, incoming *v1alpha1.Application) {
-		if v, ok := existing.Annotations[manager.SourceUIDAnnotation]; ok {
-			if incoming.Annotations == nil {
-				incoming.Annotations = make(map[string]string)
+	if m.fieldManager != "" {
+		updated, err = m.apply(ctx, true, incoming, func(existing, incoming *v1alpha1.Application) *v1alpha1.Application {
+			annotations := withoutLastSynced(incoming.Annotations)
+			if v, ok := existing.Annotations[manager.SourceUIDAnnotation]; ok {
+				annotations = maps.Clone(annotations)
+				if annotations == nil {
+					annotations = make(map[string]string)
+				}
+				annotations[manager.SourceUIDAnnotation] = v
+			}
+			return &v1alpha1.Application{
+				ObjectMeta: v1.ObjectMeta{
+					Name:        incoming.Name,
+					Namespace:   incoming.Namespace,
+					Labels:      incoming.Labels,
+					Annotations: annotations,
+					Finalizers:  incoming.Finalizers,
+				},
+				Spec:      incoming.Spec,
+				Status:    incoming.Status,
+				Operation: incoming.Operation,
+			}
+		})
+	} else {
+		updated, err = m.update(ctx, true, incoming, func(existing, incoming *v1alpha1.Application) {
+			if v, ok := existing.Annotations[manager.SourceUIDAnnotation]; ok {
+				if incoming.Annotations == nil {
+					incoming.Annotations = make(map[string]string)
+				}
+				incoming.Annotations[manager.SourceUIDAnnotation] = v
 			}
-			incoming.Annotations[manager.SourceUIDAnnotation] = v
-		}
 
-		existing.Annotations = incoming.Annotations
-		existing.Labels = incoming.Labels
-		if existing.DeletionTimestamp == nil {
-			existing.DeletionTimestamp = incoming.DeletionTimestamp
-		}
-		if existing.DeletionGracePeriodSeconds == nil {
-			existing.DeletionGracePeriodSeconds = incoming.DeletionGracePeriodSeconds
-		}
-		existing.Finalizers = incoming.Finalizers
-		existing.Spec = incoming.Spec
-		existing.Status = *incoming.Status.DeepCopy()
-		existing.Operation = incoming.Operation.DeepCopy()
-		logCtx.Infof("Updating")
-	}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
-		if v, ok := existing.Annotations[manager.SourceUIDAnnotation]; ok {
-			if incoming.Annotations == nil {
-				incoming.Annotations = make(map[string]string)
+			existing.Labels, existing.Annotations = mergeMetadata(existing, incoming)
+			if existing.DeletionTimestamp == nil {
+				existing.DeletionTimestamp = incoming.DeletionTimestamp
+			}
+			if existing.DeletionGracePeriodSeconds == nil {
+				existing.DeletionGracePeriodSeconds = incoming.DeletionGracePeriodSeconds
+			}
+			existing.Finalizers = incoming.Finalizers
+			existing.Spec = incoming.Spec
+			existing.Status = *incoming.Status.DeepCopy()
+			existing.Operation = incoming.Operation.DeepCopy()
+			logCtx.Infof("Updating")
+		}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
+			if v, ok := existing.Annotations[manager.SourceUIDAnnotation]; ok {
+				if incoming.Annotations == nil {
+					incoming.Annotations = make(map[string]string)
+				}
+				incoming.Annotations[manager.SourceUIDAnnotation] = v
 			}
-			incoming.Annotations[manager.SourceUIDAnnotation] = v
-		}
 
-		target := &v1alpha1.Application{
-			ObjectMeta: v1.ObjectMeta{
-				Labels:      incoming.Labels,
-				Annotations: incoming.Annotations,
-				Finalizers:  incoming.Finalizers,
-			},
-			Spec:      incoming.Spec,
-			Status:    incoming.Status,
-			Operation: incoming.Operation,
-		}
-		if existing.DeletionTimestamp == nil && incoming.DeletionTimestamp != nil {
-			target.DeletionTimestamp = incoming.DeletionTimestamp
-			target.DeletionGracePeriodSeconds = incoming.DeletionGracePeriodSeconds
-		}
-		source := &v1alpha1.Application{
-			ObjectMeta: v1.ObjectMeta{
-				DeletionTimestamp:          existing.DeletionTimestamp,
-				DeletionGracePeriodSeconds: existing.DeletionGracePeriodSeconds,
-				Finalizers:                 existing.Finalizers,
-			},
-			Spec:      existing.Spec,
-			Status:    existing.Status,
-			Operation: existing.Operation,
-		}
-		patch, err := jsondiff.Compare(source, target)
-		if err != nil {
-			return nil, err
-		}
-		return patch, nil
-	})
+			labels, annotations := mergeMetadata(existing, incoming)
+			target := &v1alpha1.Application{
+				ObjectMeta: v1.ObjectMeta{
+					Labels:      labels,
+					Annotations: annotations,
+					Finalizers:  incoming.Finalizers,
+				},
+				Spec:      incoming.Spec,
+				Status:    incoming.Status,
+				Operation: incoming.Operation,
+			}
+			if existing.DeletionTimestamp == nil && incoming.DeletionTimestamp != nil {
+				target.DeletionTimestamp = incoming.DeletionTimestamp
+				target.DeletionGracePeriodSeconds = incoming.DeletionGracePeriodSeconds
+			}
+			source := &v1alpha1.Application{
+				ObjectMeta: v1.ObjectMeta{
+					Labels:                     existing.Labels,
+					Annotations:                existing.Annotations,
+					DeletionTimestamp:          existing.DeletionTimestamp,
+					DeletionGracePeriodSeconds: existing.DeletionGracePeriodSeconds,
+					Finalizers:                 existing.Finalizers,
+				},
+				Spec:      existing.Spec,
+				Status:    existing.Status,
+				Operation: existing.Operation,
+			}
+			patch, err := jsondiff.Compare(source, target)
+			if err != nil {
+				return nil, err
+			}
+			return patch, nil
+		})
+	}
 	if err == nil {
 		if err := m.IgnoreChange(updated.QualifiedName(), updated.ResourceVersion); err != nil {
 			logCtx.Warnf("Could not unignore change %s for app %s: %v", updated.ResourceVersion, updated.QualifiedName(), err)
@@ -618,6 +695,69 @@ func (m *ApplicationManager) UpdateStatus(ctx context.Context, namespace string,
 	return updated, err
 }
 
+// UpdateOperationState updates the .status.operationState field of the
+// application from the sync progress reported by a managed agent. Progress
+// of an operation older than the one known is ignored, as is progress for an
+// operation that already completed. In both cases, nil is returned.
+//
+// This method is executed only by the principal.
+func (m *ApplicationManager) UpdateOperationState(ctx context.Context, namespace string, incoming *v1alpha1.Application) (*v1alpha1.Application, error) {
+	logCtx := log().WithFields(logrus.Fields{
+		"component":   "UpdateOperationState",
+		"application": incoming.QualifiedName(),
+	})
+
+	if m.role != manager.ManagerRolePrincipal {
+		return nil, fmt.Errorf("UpdateOperationState should only be called on principal")
+	}
+	if !m.destinationBasedMapping {
+		incoming.SetNamespace(namespace)
+	}
+	op := incoming.Status.OperationState
+	if op == nil {
+		return nil, nil
+	}
+
+	existing, err := m.applicationBackend.Get(ctx, incoming.Name, incoming.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if isStaleOperationState(existing.Status.OperationState, op) {
+		logCtx.Trace("Ignoring progress of stale operation")
+		return nil, nil
+	}
+
+	updated, err := m.update(ctx, false, incoming, func(existing, incoming *v1alpha1.Application) {
+		existing.Status.OperationState = op.DeepCopy()
+	}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
+		patch := jsondiff.Patch{{Type: "add", Path: "/status/operationState", Value: op}}
+		if reflect.DeepEqual(existing.Status, v1alpha1.ApplicationStatus{}) {
+			patch = append(jsondiff.Patch{{Type: "replace", Path: "/status", Value: v1alpha1.ApplicationStatus{}}}, patch...)
+		}
+		return patch, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := m.IgnoreChange(updated.QualifiedName(), updated.ResourceVersion); err != nil {
+		logCtx.Warnf("Could not ignore change %s for app %s: %v", updated.ResourceVersion, updated.QualifiedName(), err)
+	}
+	logCtx.WithField(logfields.NewResourceVersion, updated.ResourceVersion).Tracef("Updated operation state")
+	return updated, nil
+}
+
+// isStaleOperationState returns whether incoming is progress of an operation
+// older than existing, or of existing after it completed.
+func isStaleOperationState(existing, incoming *v1alpha1.OperationState) bool {
+	if existing == nil {
+		return false
+	}
+	if incoming.StartedAt.Before(&existing.StartedAt) {
+		return true
+	}
+	return incoming.StartedAt.Equal(&existing.StartedAt) && existing.Phase.Completed()
+}
+
 // UpdateOperation is used to update the .operation field of the application
 // resource to initiate a sync. Additionally, any labels and annotations that
 // are used to trigger an action (such as, refresh) will be set on the target
@@ -775,6 +915,85 @@ func (m *ApplicationManager) TerminateOperation(ctx context.Context, incoming *v
 	return updated, nil
 }
 
+// RejectOperation fails the operation of incoming without running it, e.g.
+// because it violates a policy of the agent. The operation is removed from
+// the Application and its failure is recorded in the operation state and as
+// sync error condition, from where it is reported to the principal with the
+// Application's status.
+func (m *ApplicationManager) RejectOperation(ctx context.Context, incoming *v1alpha1.Application, message string) (*v1alpha1.Application, error) {
+	logCtx := log().WithFields(logrus.Fields{
+		"component":   "RejectOperation",
+		"application": incoming.QualifiedName(),
+	})
+
+	if !m.role.IsAgent() {
+		return nil, fmt.Errorf("RejectOperation should only be called by an agent: %v", m.role)
+	}
+	if incoming.Operation == nil {
+		return nil, fmt.Errorf("application %s has no operation to reject", incoming.QualifiedName())
+	}
+
+	if !m.destinationBasedMapping {
+		incoming.SetNamespace(m.namespace)
+	}
+
+	now := v1.Now()
+	rejected := func(existing *v1alpha1.Application) *v1alpha1.Application {
+		app := existing.DeepCopy()
+		app.Operation = nil
+		app.Status.OperationState = &v1alpha1.OperationState{
+			Operation:  *incoming.Operation.DeepCopy(),
+			Phase:      synccommon.OperationFailed,
+			Message:    message,
+			StartedAt:  now,
+			FinishedAt: &now,
+		}
+		app.Status.SetConditions([]v1alpha1.ApplicationCondition{{
+			Type:               v1alpha1.ApplicationConditionSyncError,
+			Message:            message,
+			LastTransitionTime: &now,
+		}}, map[v1alpha1.ApplicationConditionType]bool{v1alpha1.ApplicationConditionSyncError: true})
+		return app
+	}
+
+	updated, err := m.update(ctx, false, incoming, func(existing, incoming *v1alpha1.Application) {
+		app := rejected(existing)
+		existing.Operation = nil
+		existing.Status = app.Status
+	}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
+		app := rejected(existing)
+		target := &v1alpha1.Application{
+			Operation: app.Operation,
+			Status:    app.Status,
+		}
+		source := &v1alpha1.Application{
+			Operation: existing.Operation,
+			Status:    existing.Status,
+		}
+		return jsondiff.Compare(source, target, jsondiff.SkipCompact())
+	})
+	if err == nil {
+		logCtx.Infof("Rejected operation on application")
+	}
+	return updated, err
+}
+
+// SetConditions replaces the conditions of the evaluated types on the
+// Application identified by name and namespace with conditions. It is used
+// to surface problems with an Application that Argo CD doesn't know about,
+// such as an agent refusing the Application or conflicting modifications of
+// its copies.
+func (m *ApplicationManager) SetConditions(ctx context.Context, name, namespace string, conditions []v1alpha1.ApplicationCondition, evaluated map[v1alpha1.ApplicationConditionType]bool) (*v1alpha1.Application, error) {
+	incoming := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace}}
+	return m.update(ctx, false, incoming, func(existing, incoming *v1alpha1.Application) {
+		existing.Status.SetConditions(conditions, evaluated)
+	}, func(existing, incoming *v1alpha1.Application) (jsondiff.Patch, error) {
+		status := existing.Status.DeepCopy()
+		status.SetConditions(conditions, evaluated)
+		return jsondiff.Compare(&v1alpha1.Application{Status: existing.Status}, &v1alpha1.Application{Status: *status}, jsondiff.SkipCompact())
+	})
+}
+
 // Delete will delete an application resource. If Delete is called by the
 // principal, any existing finalizers will be removed before deletion is
 // attempted.
@@ -857,6 +1076,40 @@ func (m *ApplicationManager) update(ctx context.Context, upsert bool, incoming *
 	return updated, err
 }
 
+// apply updates the application incoming with server-side apply, as the
+// Manager's field manager. applyFn returns the fields to apply, given the
+// existing application. If the application doesn't exist yet and upsert is
+// true, it is created.
+func (m *ApplicationManager) apply(ctx context.Context, upsert bool, incoming *v1alpha1.Application, applyFn func(existing, incoming *v1alpha1.Application) *v1alpha1.Application) (*v1alpha1.Application, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctxForUpdate := context.WithValue(ctx, backend.ForUpdateContextKey, true)
+
+	existing, err := m.applicationBackend.Get(ctxForUpdate, incoming.Name, incoming.Namespace)
+	if err != nil {
+		if errors.IsNotFound(err) && upsert {
+			return m.Create(ctx, incoming)
+		}
+		return nil, fmt.Errorf("error updating application %s: %w", incoming.QualifiedName(), err)
+	}
+	return m.applicationBackend.Apply(ctx, applyFn(existing, incoming), m.fieldManager)
+}
+
+// createWithApply creates app with server-side apply, as the Manager's field
+// manager, so that the manager owns the fields it set from the beginning.
+// Like a regular create, it fails if the application exists already.
+func (m *ApplicationManager) createWithApply(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, error) {
+	ctxForUpdate := context.WithValue(ctx, backend.ForUpdateContextKey, true)
+	if _, err := m.applicationBackend.Get(ctxForUpdate, app.Name, app.Namespace); err == nil {
+		return nil, errors.NewAlreadyExists(v1alpha1.Resource("applications"), app.Name)
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	app.Annotations = withoutLastSynced(app.Annotations)
+	return m.applicationBackend.Apply(ctx, app, m.fieldManager)
+}
+
 // RemoveFinalizers will remove finalizers on an existing application
 func (m *ApplicationManager) RemoveFinalizers(ctx context.Context, incoming *v1alpha1.Application) (*v1alpha1.Application, error) {
 	updated, err := m.update(ctx, false, incoming, func(existing, incoming *v1alpha1.Application) {
@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/backend"
 	"github.com/argoproj-labs/argocd-agent/internal/backend/kubernetes/application"
@@ -240,6 +241,9 @@ func Test_ManagerUpdateManaged(t *testing.T) {
 		// appC := fakeappclient.NewSimpleClientset(existing)
 		// ai, err := appinformer.NewAppInformer(context.Background(), appC, "argocd")
 		// require.NoError(t, err)
+		// All labels and annotations of the existing app were received from
+		// the principal
+		recordLastSynced(existing)
 		appC, ai := fakeInformer(t, "", existing)
 		be := application.NewKubernetesBackend(appC, "", ai, true)
 		mgr, err := NewApplicationManager(be, "argocd", WithMode(manager.ManagerModeManaged), WithRole(manager.ManagerRoleAgent))
@@ -411,6 +415,64 @@ func Test_ManagerUpdateStatus(t *testing.T) {
 	})
 }
 
+func Test_ManagerUpdateOperationState(t *testing.T) {
+	started := v1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+	newManager := func(t *testing.T, existing *v1alpha1.Application) *ApplicationManager {
+		t.Helper()
+		appC, ai := fakeInformer(t, "", existing)
+		be := application.NewKubernetesBackend(appC, "", ai, true)
+		mgr, err := NewApplicationManager(be, "argocd")
+		require.NoError(t, err)
+		mgr.mode = manager.ManagerModeManaged
+		mgr.role = manager.ManagerRolePrincipal
+		return mgr
+	}
+	progress := func(startedAt v1.Time, phase synccommon.OperationPhase) *v1alpha1.Application {
+		app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "foobar", Namespace: "argocd"}}
+		app.Status.OperationState = &v1alpha1.OperationState{
+			Phase:      phase,
+			StartedAt:  startedAt,
+			SyncResult: &v1alpha1.SyncOperationResult{Resources: v1alpha1.ResourceResults{{Kind: "Deployment", Name: "guestbook"}}},
+		}
+		return app
+	}
+	existing := func(op *v1alpha1.OperationState) *v1alpha1.Application {
+		app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "foobar", Namespace: "cluster-1"}}
+		app.Status.Sync.Status = v1alpha1.SyncStatusCodeOutOfSync
+		app.Status.OperationState = op
+		return app
+	}
+
+	t.Run("Progress of running operation is applied", func(t *testing.T) {
+		mgr := newManager(t, existing(&v1alpha1.OperationState{Phase: synccommon.OperationRunning, StartedAt: started}))
+		updated, err := mgr.UpdateOperationState(context.Background(), "cluster-1", progress(started, synccommon.OperationRunning))
+		require.NoError(t, err)
+		require.NotNil(t, updated)
+		require.NotNil(t, updated.Status.OperationState.SyncResult)
+		assert.Len(t, updated.Status.OperationState.SyncResult.Resources, 1)
+		assert.Equal(t, v1alpha1.SyncStatusCodeOutOfSync, updated.Status.Sync.Status)
+	})
+
+	t.Run("Progress of a new operation is applied", func(t *testing.T) {
+		mgr := newManager(t, existing(nil))
+		updated, err := mgr.UpdateOperationState(context.Background(), "cluster-1", progress(started, synccommon.OperationRunning))
+		require.NoError(t, err)
+		require.NotNil(t, updated)
+		assert.Equal(t, synccommon.OperationRunning, updated.Status.OperationState.Phase)
+	})
+
+	t.Run("Stale progress is ignored", func(t *testing.T) {
+		mgr := newManager(t, existing(&v1alpha1.OperationState{Phase: synccommon.OperationSucceeded, StartedAt: started}))
+		updated, err := mgr.UpdateOperationState(context.Background(), "cluster-1", progress(started, synccommon.OperationRunning))
+		require.NoError(t, err)
+		assert.Nil(t, updated)
+		older := v1.NewTime(started.Add(-time.Hour))
+		updated, err = mgr.UpdateOperationState(context.Background(), "cluster-1", progress(older, synccommon.OperationRunning))
+		require.NoError(t, err)
+		assert.Nil(t, updated)
+	})
+}
+
 func Test_ManagerUpdateAutonomous(t *testing.T) {
 	t.Run("Update status", func(t *testing.T) {
 		incoming := &v1alpha1.Application{
@@ -467,6 +529,9 @@ func Test_ManagerUpdateAutonomous(t *testing.T) {
 		// appC := fakeappclient.NewSimpleClientset(existing)
 		// ai, err := appinformer.NewAppInformer(context.Background(), appC, "argocd")
 		// require.NoError(t, err)
+		// All labels and annotations of the existing app were received from
+		// the agent
+		recordLastSynced(existing)
 		appC, ai := fakeInformer(t, "", existing)
 		be := application.NewKubernetesBackend(appC, "", ai, true)
 		mgr, err := NewApplicationManager(be, "argocd")
@@ -615,6 +680,42 @@ func Test_TerminateOperation(t *testing.T) {
 	})
 }
 
+func Test_RejectOperation(t *testing.T) {
+	existing := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{Name: "foobar", Namespace: "argocd"},
+		Operation:  &v1alpha1.Operation{Sync: &v1alpha1.SyncOperation{Revision: "HEAD"}},
+		Status: v1alpha1.ApplicationStatus{
+			Conditions: []v1alpha1.ApplicationCondition{{Type: v1alpha1.ApplicationConditionComparisonError, Message: "unrelated"}},
+		},
+	}
+
+	t.Run("fails the operation and sets a sync error", func(t *testing.T) {
+		appC, informer := fakeInformer(t, "", existing.DeepCopy())
+		be := application.NewKubernetesBackend(appC, "", informer, true)
+		mgr, err := NewApplicationManager(be, "argocd", WithRole(manager.ManagerRoleAgent), WithMode(manager.ManagerModeManaged))
+		require.NoError(t, err)
+
+		updated, err := mgr.RejectOperation(context.TODO(), existing.DeepCopy(), "image policy violated")
+		require.NoError(t, err)
+		assert.Nil(t, updated.Operation)
+		require.NotNil(t, updated.Status.OperationState)
+		assert.Equal(t, synccommon.OperationFailed, updated.Status.OperationState.Phase)
+		assert.Equal(t, "image policy violated", updated.Status.OperationState.Message)
+		assert.Equal(t, "HEAD", updated.Status.OperationState.Operation.Sync.Revision)
+		require.Len(t, updated.Status.Conditions, 2)
+		assert.Equal(t, v1alpha1.ApplicationConditionComparisonError, updated.Status.Conditions[0].Type)
+		assert.Equal(t, v1alpha1.ApplicationConditionSyncError, updated.Status.Conditions[1].Type)
+		assert.Equal(t, "image policy violated", updated.Status.Conditions[1].Message)
+	})
+
+	t.Run("should be called only by an agent", func(t *testing.T) {
+		mgr, err := NewApplicationManager(appmock.NewApplication(t), "argocd", WithRole(manager.ManagerRolePrincipal))
+		require.NoError(t, err)
+		_, err = mgr.RejectOperation(context.TODO(), existing.DeepCopy(), "image policy violated")
+		require.ErrorContains(t, err, "RejectOperation should only be called by an agent")
+	})
+}
+
 func Test_DeleteApp(t *testing.T) {
 	t.Run("Delete without finalizer", func(t *testing.T) {
 		existing := &v1alpha1.Application{
@@ -1115,3 +1216,86 @@ func Test_Upsert_CopiesExistingUID(t *testing.T) {
 	require.NoError(t, err)
 	mockedBackend.AssertExpectations(t)
 }
+
+func Test_ManagerServerSideApply(t *testing.T) {
+	existing := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "foobar", Namespace: "argocd",
+			Labels:      map[string]string{"team": "a", "tool": "x"},
+			Annotations: map[string]string{manager.SourceUIDAnnotation: "uid", "notified": "true"},
+		},
+		Operation: &v1alpha1.Operation{InitiatedBy: v1alpha1.OperationInitiator{Username: "admin"}},
+	}
+
+	t.Run("Managed agent applies only synchronized fields", func(t *testing.T) {
+		mockedBackend := appmock.NewApplication(t)
+		mockedBackend.On("Get", mock.Anything, "foobar", "argocd").Return(existing.DeepCopy(), nil)
+		var applied *v1alpha1.Application
+		mockedBackend.On("Apply", mock.Anything, mock.Anything, FieldManagerAgent).Run(func(args mock.Arguments) {
+			applied = args.Get(1).(*v1alpha1.Application)
+		}).Return(existing.DeepCopy(), nil)
+		mgr, err := NewApplicationManager(mockedBackend, "argocd", WithMode(manager.ManagerModeManaged), WithRole(manager.ManagerRoleAgent), WithFieldManager(FieldManagerAgent))
+		require.NoError(t, err)
+
+		incoming := &v1alpha1.Application{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "foobar", Namespace: "argocd",
+				Labels:      map[string]string{"team": "b"},
+				Annotations: map[string]string{manager.SourceUIDAnnotation: "uid", LastSyncedMetadataAnnotation: "{}"},
+			},
+			Spec: v1alpha1.ApplicationSpec{Project: "default"},
+		}
+		_, err = mgr.UpdateManagedApp(context.Background(), incoming, ManagedIdentity{})
+		require.NoError(t, err)
+		require.NotNil(t, applied)
+		assert.Equal(t, map[string]string{"team": "b"}, applied.Labels)
+		assert.Equal(t, map[string]string{manager.SourceUIDAnnotation: "uid"}, applied.Annotations)
+		assert.Equal(t, "default", applied.Spec.Project)
+		// The operation of the existing app is retained
+		assert.Equal(t, existing.Operation, applied.Operation)
+		assert.Empty(t, applied.Status)
+	})
+
+	t.Run("Principal applies only synchronized fields", func(t *testing.T) {
+		mockedBackend := appmock.NewApplication(t)
+		mockedBackend.On("Get", mock.Anything, "foobar", "agent").Return(existing.DeepCopy(), nil)
+		var applied *v1alpha1.Application
+		mockedBackend.On("Apply", mock.Anything, mock.Anything, FieldManagerPrincipal).Run(func(args mock.Arguments) {
+			applied = args.Get(1).(*v1alpha1.Application)
+		}).Return(existing.DeepCopy(), nil)
+		mgr, err := NewApplicationManager(mockedBackend, "argocd", WithRole(manager.ManagerRolePrincipal), WithFieldManager(FieldManagerPrincipal))
+		require.NoError(t, err)
+
+		incoming := &v1alpha1.Application{
+			ObjectMeta: v1.ObjectMeta{Name: "foobar", Labels: map[string]string{"team": "b"}},
+			Status:     v1alpha1.ApplicationStatus{Sync: v1alpha1.SyncStatus{Status: v1alpha1.SyncStatusCodeSynced}},
+		}
+		_, err = mgr.UpdateAutonomousApp(context.Background(), "agent", incoming)
+		require.NoError(t, err)
+		require.NotNil(t, applied)
+		assert.Equal(t, "agent", applied.Namespace)
+		assert.Equal(t, map[string]string{"team": "b"}, applied.Labels)
+		assert.Equal(t, "uid", applied.Annotations[manager.SourceUIDAnnotation])
+		assert.NotContains(t, applied.Annotations, "notified")
+		assert.Equal(t, v1alpha1.SyncStatusCodeSynced, applied.Status.Sync.Status)
+	})
+
+	t.Run("Create fails for existing apps", func(t *testing.T) {
+		mockedBackend := appmock.NewApplication(t)
+		mockedBackend.On("Get", mock.Anything, "foobar", "argocd").Return(existing.DeepCopy(), nil)
+		mgr, err := NewApplicationManager(mockedBackend, "argocd", WithRole(manager.ManagerRolePrincipal), WithFieldManager(FieldManagerPrincipal))
+		require.NoError(t, err)
+		_, err = mgr.Create(context.Background(), existing.DeepCopy())
+		assert.True(t, errors.IsAlreadyExists(err))
+	})
+
+	t.Run("Create applies new apps", func(t *testing.T) {
+		mockedBackend := appmock.NewApplication(t)
+		mockedBackend.On("Get", mock.Anything, "foobar", "argocd").Return(nil, errors.NewNotFound(v1alpha1.Resource("applications"), "foobar"))
+		mockedBackend.On("Apply", mock.Anything, mock.Anything, FieldManagerAgent).Return(existing.DeepCopy(), nil)
+		mgr, err := NewApplicationManager(mockedBackend, "argocd", WithMode(manager.ManagerModeManaged), WithRole(manager.ManagerRoleAgent), WithFieldManager(FieldManagerAgent))
+		require.NoError(t, err)
+		_, err = mgr.Create(context.Background(), existing.DeepCopy())
+		require.NoError(t, err)
+	})
+}
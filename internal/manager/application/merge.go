@@ -0,0 +1,128 @@
+// Copyright 2024 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"encoding/json"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+)
+
+// LastSyncedMetadataAnnotation is an annotation put on applications which
+// contains the labels and annotations of the Application as last received
+// from the peer. It is the common ancestor of the three-way merge of labels
+// and annotations on updates.
+const LastSyncedMetadataAnnotation = "argocd-agent.argoproj.io/last-synced-metadata"
+
+// syncedMetadata is the content of the LastSyncedMetadataAnnotation
+type syncedMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// mergeMetadata returns the labels and annotations resulting from merging
+// those of incoming, received from the peer, into those of existing.
+//
+// Keys the peer added, changed or removed since the last update are taken
+// from incoming. All other keys keep their value on existing, so that labels
+// and annotations added, changed or removed locally, e.g. by other tooling,
+// are not overwritten. If the last update is not known, keys of incoming
+// take precedence and no keys are removed. The labels and annotations of
+// incoming are recorded in the returned annotations as the new common
+// ancestor.
+func mergeMetadata(existing, incoming *v1alpha1.Application) (labels map[string]string, annotations map[string]string) {
+	base, hasBase := lastSyncedMetadata(existing)
+	theirs := syncedMetadata{Labels: incoming.Labels, Annotations: withoutLastSynced(incoming.Annotations)}
+
+	labels = mergeMap(base.Labels, existing.Labels, theirs.Labels, hasBase)
+	annotations = mergeMap(base.Annotations, withoutLastSynced(existing.Annotations), theirs.Annotations, hasBase)
+	return labels, withLastSynced(annotations, theirs)
+}
+
+// withLastSynced returns annotations with the labels and annotations in m
+// recorded as the last received from the peer.
+func withLastSynced(annotations map[string]string, m syncedMetadata) map[string]string {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log().WithError(err).Warn("Could not record last synced metadata")
+		return annotations
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[LastSyncedMetadataAnnotation] = string(data)
+	return annotations
+}
+
+// recordLastSynced records the labels and annotations of app as the last
+// received from the peer.
+func recordLastSynced(app *v1alpha1.Application) {
+	m := syncedMetadata{Labels: app.Labels, Annotations: withoutLastSynced(app.Annotations)}
+	app.Annotations = withLastSynced(app.Annotations, m)
+}
+
+// lastSyncedMetadata returns the labels and annotations last received for
+// app, and whether they are known.
+func lastSyncedMetadata(app *v1alpha1.Application) (syncedMetadata, bool) {
+	m := syncedMetadata{}
+	data, ok := app.Annotations[LastSyncedMetadataAnnotation]
+	if !ok {
+		return m, false
+	}
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		log().WithError(err).Warnf("Ignoring invalid last synced metadata of app %s", app.QualifiedName())
+		return syncedMetadata{}, false
+	}
+	return m, true
+}
+
+// mergeMap performs a three-way merge of the maps ours and theirs, whose
+// common ancestor is base. Changes in theirs take precedence over changes in
+// ours.
+func mergeMap(base, ours, theirs map[string]string, hasBase bool) map[string]string {
+	merged := make(map[string]string, len(ours)+len(theirs))
+	for k, v := range ours {
+		merged[k] = v
+	}
+	for k, v := range theirs {
+		if bv, ok := base[k]; !hasBase || !ok || bv != v {
+			merged[k] = v
+		}
+	}
+	for k := range base {
+		if _, ok := theirs[k]; !ok {
+			delete(merged, k)
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// withoutLastSynced returns a copy of annotations without the
+// LastSyncedMetadataAnnotation
+func withoutLastSynced(annotations map[string]string) map[string]string {
+	if _, ok := annotations[LastSyncedMetadataAnnotation]; !ok {
+		return annotations
+	}
+	out := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if k != LastSyncedMetadataAnnotation {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend/kubernetes/application"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_mergeMap(t *testing.T) {
+	base := map[string]string{"unchanged": "1", "changed-by-peer": "1", "removed-by-peer": "1", "changed-locally": "1", "removed-locally": "1", "changed-by-both": "1"}
+	ours := map[string]string{"unchanged": "1", "changed-by-peer": "1", "removed-by-peer": "1", "changed-locally": "2", "changed-by-both": "2", "added-locally": "1"}
+	theirs := map[string]string{"unchanged": "1", "changed-by-peer": "2", "changed-locally": "1", "removed-locally": "1", "changed-by-both": "3", "added-by-peer": "1"}
+
+	t.Run("Three-way merge", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"unchanged":       "1",
+			"changed-by-peer": "2",
+			"changed-locally": "2",
+			"changed-by-both": "3",
+			"added-locally":   "1",
+			"added-by-peer":   "1",
+		}, mergeMap(base, ours, theirs, true))
+	})
+
+	t.Run("Without common ancestor", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"unchanged":       "1",
+			"changed-by-peer": "2",
+			"removed-by-peer": "1",
+			"changed-locally": "1",
+			"removed-locally": "1",
+			"changed-by-both": "3",
+			"added-locally":   "1",
+			"added-by-peer":   "1",
+		}, mergeMap(nil, ours, theirs, false))
+	})
+
+	t.Run("Empty result", func(t *testing.T) {
+		assert.Nil(t, mergeMap(map[string]string{"a": "1"}, map[string]string{"a": "1"}, nil, true))
+	})
+}
+
+func Test_mergeMetadata(t *testing.T) {
+	fromPeer := func(labels, annotations map[string]string) *v1alpha1.Application {
+		return &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "argocd", Labels: labels, Annotations: annotations}}
+	}
+
+	t.Run("Records the peer's labels and annotations", func(t *testing.T) {
+		existing := fromPeer(map[string]string{"tool": "x"}, nil)
+		labels, annotations := mergeMetadata(existing, fromPeer(map[string]string{"team": "a"}, map[string]string{"note": "1"}))
+		assert.Equal(t, map[string]string{"tool": "x", "team": "a"}, labels)
+		assert.Equal(t, "1", annotations["note"])
+
+		existing.Labels, existing.Annotations = labels, annotations
+		m, ok := lastSyncedMetadata(existing)
+		require.True(t, ok)
+		assert.Equal(t, syncedMetadata{Labels: map[string]string{"team": "a"}, Annotations: map[string]string{"note": "1"}}, m)
+	})
+
+	t.Run("The peer's record is not taken over", func(t *testing.T) {
+		existing := fromPeer(nil, nil)
+		recordLastSynced(existing)
+		incoming := fromPeer(nil, map[string]string{"note": "1"})
+		recordLastSynced(incoming)
+		incoming.Annotations[LastSyncedMetadataAnnotation] = "{}"
+		_, annotations := mergeMetadata(existing, incoming)
+		existing.Annotations = annotations
+		m, ok := lastSyncedMetadata(existing)
+		require.True(t, ok)
+		assert.Equal(t, map[string]string{"note": "1"}, m.Annotations)
+	})
+
+	t.Run("Invalid records are ignored", func(t *testing.T) {
+		existing := fromPeer(map[string]string{"tool": "x"}, map[string]string{LastSyncedMetadataAnnotation: "invalid"})
+		labels, _ := mergeMetadata(existing, fromPeer(nil, nil))
+		assert.Equal(t, map[string]string{"tool": "x"}, labels)
+	})
+}
+
+func Test_ManagerUpdateManagedMerge(t *testing.T) {
+	app := func(labels map[string]string) *v1alpha1.Application {
+		return &v1alpha1.Application{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "foobar", Namespace: "argocd", Labels: labels,
+				Annotations: map[string]string{manager.SourceUIDAnnotation: "uid"},
+			},
+		}
+	}
+	existing := app(map[string]string{"team": "a", "version": "1"})
+	recordLastSynced(existing)
+	// Labels added and removed by tooling on the agent
+	existing.Labels["tool"] = "x"
+	delete(existing.Labels, "team")
+
+	for _, patch := range []bool{true, false} {
+		appC, ai := fakeInformer(t, "", existing.DeepCopy())
+		be := application.NewKubernetesBackend(appC, "", ai, patch)
+		mgr, err := NewApplicationManager(be, "argocd", WithMode(manager.ManagerModeManaged), WithRole(manager.ManagerRoleAgent))
+		require.NoError(t, err)
+
+		updated, err := mgr.UpdateManagedApp(context.Background(), app(map[string]string{"team": "a", "version": "2", "env": "prod"}), ManagedIdentity{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"version": "2", "env": "prod", "tool": "x"}, updated.Labels)
+
+		// A label removed by the principal is removed on the agent
+		updated, err = mgr.UpdateManagedApp(context.Background(), app(map[string]string{"team": "a", "version": "2"}), ManagedIdentity{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"version": "2", "tool": "x"}, updated.Labels)
+	}
+}
+
+func Test_ManagerUpdateAutonomousMerge(t *testing.T) {
+	existing := &v1alpha1.Application{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "foobar", Namespace: "cluster-1",
+			Labels:      map[string]string{"team": "a"},
+			Annotations: map[string]string{manager.SourceUIDAnnotation: "uid"},
+		},
+	}
+	recordLastSynced(existing)
+	// An annotation added by tooling on the principal
+	existing.Annotations["notified"] = "true"
+
+	appC, ai := fakeInformer(t, "", existing)
+	be := application.NewKubernetesBackend(appC, "", ai, true)
+	mgr, err := NewApplicationManager(be, "argocd", WithRole(manager.ManagerRolePrincipal))
+	require.NoError(t, err)
+
+	incoming := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "foobar", Labels: map[string]string{"team": "b"}}}
+	updated, err := mgr.UpdateAutonomousApp(context.TODO(), "cluster-1", incoming)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "b"}, updated.Labels)
+	assert.Equal(t, "true", updated.Annotations["notified"])
+	assert.Equal(t, "uid", updated.Annotations[manager.SourceUIDAnnotation])
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"fmt"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+)
+
+// ConflictStrategy decides whose spec prevails when both the principal's
+// copy of an Application (the hub) and the agent's copy (the spoke) were
+// modified. The side that owns the Application is the hub for managed
+// agents and the spoke for autonomous agents. Only the owner sends its
+// changes to the other side, so a modification of the other side's copy
+// stays local.
+type ConflictStrategy string
+
+const (
+	// ConflictHubWins makes the principal's spec prevail
+	ConflictHubWins ConflictStrategy = "hub-wins"
+	// ConflictSpokeWins makes the agent's spec prevail
+	ConflictSpokeWins ConflictStrategy = "spoke-wins"
+	// ConflictNewestWins makes the most recent modification prevail: local
+	// modifications of the copy are kept until the owner changes the spec
+	// again
+	ConflictNewestWins ConflictStrategy = "newest-wins"
+	// ConflictManual keeps the modified copy as it is, and marks it with a
+	// conflict condition until both specs match again
+	ConflictManual ConflictStrategy = "manual"
+)
+
+// Side is one side of the exchange of an Application
+type Side string
+
+const (
+	SideHub   Side = "hub"
+	SideSpoke Side = "spoke"
+)
+
+// ConflictConditionType is the type of the condition marking Applications
+// whose copies conflict under the manual strategy
+const ConflictConditionType v1alpha1.ApplicationConditionType = "SpecConflict"
+
+// ParseConflictStrategy parses the name of a conflict strategy
+func ParseConflictStrategy(strategy string) (ConflictStrategy, error) {
+	switch ConflictStrategy(strategy) {
+	case ConflictHubWins, ConflictSpokeWins, ConflictNewestWins, ConflictManual:
+		return ConflictStrategy(strategy), nil
+	}
+	return "", fmt.Errorf("invalid conflict strategy %q: must be one of %s, %s, %s or %s",
+		strategy, ConflictHubWins, ConflictSpokeWins, ConflictNewestWins, ConflictManual)
+}
+
+// OwnerPrevails returns whether the spec of owner, the side owning the
+// Application, prevails over a modification of the other side's copy.
+// ownerIsNewer tells whether the owner's spec changed after the copy was
+// modified.
+func (c ConflictStrategy) OwnerPrevails(owner Side, ownerIsNewer bool) bool {
+	switch c {
+	case ConflictHubWins:
+		return owner == SideHub
+	case ConflictSpokeWins:
+		return owner == SideSpoke
+	case ConflictNewestWins:
+		return ownerIsNewer
+	}
+	return false
+}
+
+// ConflictCondition returns the condition marking an Application whose copy
+// was modified on side, while the owner's spec differs
+func ConflictCondition(side Side) v1alpha1.ApplicationCondition {
+	owner := SideHub
+	if side == SideHub {
+		owner = SideSpoke
+	}
+	return v1alpha1.ApplicationCondition{
+		Type: ConflictConditionType,
+		Message: fmt.Sprintf("The spec was modified on the %s and differs from the spec of the %s, which owns the application. "+
+			"Change either spec so that both match to resolve the conflict.", side, owner),
+	}
+}
+
+// HasConflictCondition returns whether app is marked with a conflict
+// condition
+func HasConflictCondition(app *v1alpha1.Application) bool {
+	for _, c := range app.Status.Conditions {
+		if c.Type == ConflictConditionType {
+			return true
+		}
+	}
+	return false
+}
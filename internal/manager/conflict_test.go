@@ -0,0 +1,62 @@
+// Copyright 2024 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"testing"
+
+	argoapp "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseConflictStrategy(t *testing.T) {
+	for _, s := range []string{"hub-wins", "spoke-wins", "newest-wins", "manual"} {
+		strategy, err := ParseConflictStrategy(s)
+		require.NoError(t, err)
+		assert.Equal(t, ConflictStrategy(s), strategy)
+	}
+	_, err := ParseConflictStrategy("")
+	assert.ErrorContains(t, err, "invalid conflict strategy")
+}
+
+func Test_OwnerPrevails(t *testing.T) {
+	for _, tc := range []struct {
+		strategy     ConflictStrategy
+		owner        Side
+		ownerIsNewer bool
+		prevails     bool
+	}{
+		{ConflictHubWins, SideHub, false, true},
+		{ConflictHubWins, SideSpoke, true, false},
+		{ConflictSpokeWins, SideSpoke, false, true},
+		{ConflictSpokeWins, SideHub, true, false},
+		{ConflictNewestWins, SideHub, false, false},
+		{ConflictNewestWins, SideHub, true, true},
+		{ConflictNewestWins, SideSpoke, true, true},
+		{ConflictManual, SideHub, true, false},
+		{ConflictManual, SideSpoke, true, false},
+	} {
+		assert.Equal(t, tc.prevails, tc.strategy.OwnerPrevails(tc.owner, tc.ownerIsNewer), "%s %s %v", tc.strategy, tc.owner, tc.ownerIsNewer)
+	}
+}
+
+func Test_HasConflictCondition(t *testing.T) {
+	app := &argoapp.Application{}
+	assert.False(t, HasConflictCondition(app))
+	app.Status.Conditions = append(app.Status.Conditions, ConflictCondition(SideSpoke))
+	assert.True(t, HasConflictCondition(app))
+	assert.Contains(t, app.Status.Conditions[0].Message, "modified on the spoke")
+}
@@ -0,0 +1,152 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/util/glob"
+)
+
+// OtherAgents is the value of the agent_name label of metrics aggregated over
+// all agents that have no metrics of their own
+const OtherAgents = "_other"
+
+// agentRankInterval is how often the most active agents are determined
+const agentRankInterval = time.Minute
+
+// AgentLabels decides which agents get metrics labeled with their own name.
+// Labeling the metrics of every agent with its name makes the number of
+// series grow with the fleet, so by default the metrics of all agents are
+// aggregated under the OtherAgents label. Agents matching the allowlist, and
+// the topN agents with the most observations in the last minute, get metrics
+// of their own.
+type AgentLabels struct {
+	mu    sync.Mutex
+	allow []string
+	topN  int
+	// activity counts the observations of each agent since the last ranking
+	activity map[string]uint64
+	// top are the most active agents as of the last ranking
+	top      map[string]bool
+	rankedAt time.Time
+	now      func() time.Time
+	// onDemote is called with agents that dropped out of the most active
+	// agents, so that their series can be removed
+	onDemote func(agentName string)
+}
+
+// NewAgentLabels returns labels that give agents matching one of the glob
+// patterns in allow, and the topN most active agents, metrics of their own.
+// The pattern "*" gives every agent metrics of its own.
+func NewAgentLabels(allow []string, topN int) *AgentLabels {
+	return &AgentLabels{
+		allow:    allow,
+		topN:     topN,
+		activity: make(map[string]uint64),
+		top:      make(map[string]bool),
+		rankedAt: time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Label returns the value of the agent_name label for an observation of
+// agentName, and counts the observation towards the agent's activity. A nil
+// AgentLabels gives every agent metrics of its own.
+func (l *AgentLabels) Label(agentName string) string {
+	if l == nil {
+		return agentName
+	}
+	if l.allowed(agentName) {
+		return agentName
+	}
+	if l.topN <= 0 {
+		return OtherAgents
+	}
+
+	l.mu.Lock()
+	l.activity[agentName]++
+	var demoted []string
+	if now := l.now(); now.Sub(l.rankedAt) >= agentRankInterval {
+		demoted = l.rank()
+		l.rankedAt = now
+	}
+	top := l.top[agentName]
+	onDemote := l.onDemote
+	l.mu.Unlock()
+
+	if onDemote != nil {
+		for _, name := range demoted {
+			onDemote(name)
+		}
+	}
+	if top {
+		return agentName
+	}
+	return OtherAgents
+}
+
+// PerAgent returns whether agentName currently has metrics of its own. It
+// does not count towards the agent's activity.
+func (l *AgentLabels) PerAgent(agentName string) bool {
+	if l == nil || l.allowed(agentName) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.top[agentName]
+}
+
+func (l *AgentLabels) allowed(agentName string) bool {
+	for _, pattern := range l.allow {
+		if glob.Match(pattern, agentName) {
+			return true
+		}
+	}
+	return false
+}
+
+// rank determines the most active agents since the last ranking and returns
+// the agents that are no longer among them. It must be called with l.mu held.
+func (l *AgentLabels) rank() []string {
+	names := make([]string, 0, len(l.activity))
+	for name := range l.activity {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if l.activity[names[i]] != l.activity[names[j]] {
+			return l.activity[names[i]] > l.activity[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > l.topN {
+		names = names[:l.topN]
+	}
+	top := make(map[string]bool, len(names))
+	for _, name := range names {
+		top[name] = true
+	}
+	var demoted []string
+	for name := range l.top {
+		if !top[name] {
+			demoted = append(demoted, name)
+		}
+	}
+	l.top = top
+	l.activity = make(map[string]uint64)
+	return demoted
+}
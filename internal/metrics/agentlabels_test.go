@@ -0,0 +1,149 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_AgentLabels(t *testing.T) {
+	t.Run("Nil labels give every agent metrics of its own", func(t *testing.T) {
+		var l *AgentLabels
+		assert.Equal(t, "agent-1", l.Label("agent-1"))
+		assert.True(t, l.PerAgent("agent-1"))
+	})
+
+	t.Run("Agents are aggregated by default", func(t *testing.T) {
+		l := NewAgentLabels(nil, 0)
+		assert.Equal(t, OtherAgents, l.Label("agent-1"))
+		assert.False(t, l.PerAgent("agent-1"))
+	})
+
+	t.Run("Allowed agents have metrics of their own", func(t *testing.T) {
+		l := NewAgentLabels([]string{"prod-*"}, 0)
+		assert.Equal(t, "prod-1", l.Label("prod-1"))
+		assert.True(t, l.PerAgent("prod-1"))
+		assert.Equal(t, OtherAgents, l.Label("dev-1"))
+		assert.Equal(t, "dev-1", NewAgentLabels([]string{"*"}, 0).Label("dev-1"))
+	})
+
+	t.Run("Most active agents have metrics of their own", func(t *testing.T) {
+		now := time.Now()
+		l := NewAgentLabels(nil, 1)
+		l.now = func() time.Time { return now }
+		l.rankedAt = now
+		var demoted []string
+		l.onDemote = func(agentName string) { demoted = append(demoted, agentName) }
+
+		assert.Equal(t, OtherAgents, l.Label("busy"))
+		l.Label("busy")
+		l.Label("quiet")
+
+		now = now.Add(agentRankInterval)
+		assert.Equal(t, OtherAgents, l.Label("quiet"))
+		assert.Equal(t, "busy", l.Label("busy"))
+		assert.True(t, l.PerAgent("busy"))
+		assert.False(t, l.PerAgent("quiet"))
+		assert.Empty(t, demoted)
+
+		// Activity is counted anew after each ranking
+		l.Label("quiet")
+		now = now.Add(agentRankInterval)
+		assert.Equal(t, "quiet", l.Label("quiet"))
+		assert.False(t, l.PerAgent("busy"))
+		assert.Equal(t, []string{"busy"}, demoted)
+	})
+}
+
+func Test_PrincipalMetricsDeleteDemotedAgents(t *testing.T) {
+	m := NewPrincipalMetricsWith(prometheus.NewRegistry())
+	now := time.Now()
+	l := NewAgentLabels(nil, 1)
+	l.now = func() time.Time { return now }
+	l.rankedAt = now
+	m.SetAgentLabels(l)
+
+	m.AgentLabel("agent-1")
+	now = now.Add(agentRankInterval)
+	require.Equal(t, "agent-1", m.AgentLabel("agent-1"))
+	m.ApplicationDivergences.WithLabelValues("agent-1", "status").Inc()
+	m.AgentRTT.WithLabelValues("agent-1").Set(1)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.ApplicationDivergences))
+
+	m.AgentLabel("agent-2")
+	now = now.Add(agentRankInterval)
+	m.AgentLabel("agent-2")
+	assert.Equal(t, 0, testutil.CollectAndCount(m.ApplicationDivergences))
+	assert.Equal(t, 0, testutil.CollectAndCount(m.AgentRTT))
+}
+
+func Test_ObserveDuration(t *testing.T) {
+	sampled := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	exemplar := func(t *testing.T, h prometheus.Histogram) *dto.Exemplar {
+		t.Helper()
+		m := &dto.Metric{}
+		require.NoError(t, h.Write(m))
+		for _, b := range m.GetHistogram().GetBucket() {
+			if b.GetExemplar() != nil {
+				return b.GetExemplar()
+			}
+		}
+		return nil
+	}
+
+	t.Run("Slow observations carry the trace", func(t *testing.T) {
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"})
+		ObserveDuration(h, 2*time.Second, time.Second, sampled, prometheus.Labels{"agent_name": "agent-1"})
+		ex := exemplar(t, h)
+		require.NotNil(t, ex)
+		labels := map[string]string{}
+		for _, l := range ex.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		assert.Equal(t, map[string]string{"trace_id": sampled.TraceID().String(), "agent_name": "agent-1"}, labels)
+	})
+
+	t.Run("Fast or untraced observations carry no trace", func(t *testing.T) {
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"})
+		ObserveDuration(h, time.Millisecond, time.Second, sampled, nil)
+		ObserveDuration(h, 2*time.Second, time.Second, trace.SpanContext{}, nil)
+		ObserveDuration(h, 2*time.Second, 0, sampled, nil)
+		assert.Nil(t, exemplar(t, h))
+		m := &dto.Metric{}
+		require.NoError(t, h.Write(m))
+		assert.Equal(t, uint64(3), m.GetHistogram().GetSampleCount())
+	})
+
+	t.Run("Labels exceeding the exemplar length are dropped", func(t *testing.T) {
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"})
+		long := string(make([]byte, prometheus.ExemplarMaxRunes))
+		ObserveDuration(h, 2*time.Second, time.Second, sampled, prometheus.Labels{"agent_name": long})
+		ex := exemplar(t, h)
+		require.NotNil(t, ex)
+		assert.Len(t, ex.GetLabel(), 1)
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObserveDuration observes took with o, in seconds. If took is at least
+// threshold and the span of sc was sampled, the observation carries the
+// trace as an exemplar, together with labels, so that slow requests can be
+// looked up from histograms whose labels do not tell them apart. Exemplars
+// are only exposed to scrapers requesting the OpenMetrics format.
+func ObserveDuration(o prometheus.Observer, took, threshold time.Duration, sc trace.SpanContext, labels prometheus.Labels) {
+	eo, ok := o.(prometheus.ExemplarObserver)
+	if !ok || threshold <= 0 || took < threshold || !sc.IsSampled() {
+		o.Observe(took.Seconds())
+		return
+	}
+	exemplar := prometheus.Labels{"trace_id": sc.TraceID().String()}
+	runes := utf8.RuneCountInString("trace_id") + utf8.RuneCountInString(exemplar["trace_id"])
+	for k, v := range labels {
+		// Exemplars exceeding the maximum length would be rejected
+		n := utf8.RuneCountInString(k) + utf8.RuneCountInString(v)
+		if runes+n > prometheus.ExemplarMaxRunes {
+			continue
+		}
+		exemplar[k] = v
+		runes += n
+	}
+	eo.ObserveWithExemplar(took.Seconds(), exemplar)
+}
@@ -31,6 +31,19 @@ const (
 	EventProcessingNotAllowed EventProcessingStatus = "not-allowed"
 )
 
+// EventHandlerBuckets are the buckets of the event handler duration
+// histograms. They are finer than the default buckets at the low end, where
+// most handlers finish.
+var EventHandlerBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// ResourceProxyBuckets are the buckets of the resource proxy's request
+// duration histogram. Requests for logs may take minutes.
+var ResourceProxyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 180, 600}
+
+// ResyncBuckets are the buckets of the resync duration histograms. Resyncs
+// of large inventories take minutes.
+var ResyncBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
 type InformerMetrics struct {
 	ResourcesListed *prometheus.GaugeVec
 	ListDuration    *prometheus.GaugeVec
@@ -64,8 +77,114 @@ type PrincipalMetrics struct {
 	EventSent     prometheus.Counter
 
 	EventProcessingTime *prometheus.HistogramVec
+	// EventHandlerDuration observes how long the handlers of events block
+	// the event processing loop, by event target and type
+	EventHandlerDuration *prometheus.HistogramVec
+	// SlowEventHandlers counts the events whose handler took longer than
+	// the slow handler threshold, by event target and type
+	SlowEventHandlers *prometheus.CounterVec
+	// EventDispatchRunning is the number of events of an agent being
+	// processed in parallel
+	EventDispatchRunning *prometheus.GaugeVec
+	// EventDispatchWaiting is the number of events of an agent taken from
+	// its queue that wait for an earlier event to be processed
+	EventDispatchWaiting *prometheus.GaugeVec
 
 	PrincipalErrors *prometheus.CounterVec
+
+	AgentRTT            *prometheus.GaugeVec
+	AgentOneWayLatency  *prometheus.GaugeVec
+	AgentClockSkew      *prometheus.GaugeVec
+	AgentProbeRoundTrip *prometheus.HistogramVec
+
+	RevokedCertRejections *prometheus.CounterVec
+
+	// AgentVersionSkew is the number of minor versions a connected agent is
+	// behind the principal
+	AgentVersionSkew *prometheus.GaugeVec
+	// AgentVersionRefusals counts agents refused because of their version
+	AgentVersionRefusals prometheus.Counter
+
+	OrphanedApplications prometheus.Gauge
+
+	ForcedApplicationDeletions prometheus.Counter
+
+	ApplicationDivergences *prometheus.CounterVec
+
+	ApplicationDrifts *prometheus.CounterVec
+
+	// InitialSyncPending is the number of resources the principal has yet
+	// to send in the current initial sync with an agent, by event kind
+	InitialSyncPending *prometheus.GaugeVec
+	// ResyncDuration is the time it took to send all resources of a resync
+	ResyncDuration *prometheus.HistogramVec
+	// ResyncObjects is the number of resources of the last resync with an
+	// agent, by event kind
+	ResyncObjects *prometheus.GaugeVec
+
+	// ResourceProxy holds metrics of the resource proxy
+	ResourceProxy *ResourceProxyMetrics
+
+	// agentLabels decides which agents have metrics of their own. If nil,
+	// every agent has.
+	agentLabels *AgentLabels
+}
+
+// ResourceProxyMetrics holds metrics of the principal's resource proxy
+type ResourceProxyMetrics struct {
+	// RequestDuration observes how long requests took, by route, method and
+	// status code
+	RequestDuration *prometheus.HistogramVec
+	// RequestsInFlight is the number of requests being served, by route
+	RequestsInFlight *prometheus.GaugeVec
+	// HedgedRequests counts copies of requests sent to agents because they
+	// did not answer in time ("sent"), and those answering first ("won")
+	HedgedRequests *prometheus.CounterVec
+	// LogStreamTeardowns counts the follow log streams whose client went
+	// away, by whether the agent confirmed tearing them down
+	LogStreamTeardowns *prometheus.CounterVec
+	// LogStreamTeardownDuration observes the time between the client of a
+	// follow log stream going away and the agent confirming that it closed
+	// the container log stream
+	LogStreamTeardownDuration prometheus.Histogram
+	// LogStreamRejections counts the log streams of agents rejected because
+	// their request ID was unknown, finished, sent to another agent or
+	// changed, by reason
+	LogStreamRejections *prometheus.CounterVec
+}
+
+// NewResourceProxyMetricsWith returns the resource proxy metrics, registered
+// with reg
+func NewResourceProxyMetricsWith(reg prometheus.Registerer) *ResourceProxyMetrics {
+	f := promauto.With(reg)
+	return &ResourceProxyMetrics{
+		RequestDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "principal_resource_proxy_request_duration_seconds",
+			Help:    "Histogram of time taken to serve requests of the resource proxy, by route, method and status code (in seconds)",
+			Buckets: ResourceProxyBuckets,
+		}, []string{"route", "method", "code"}),
+		RequestsInFlight: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_resource_proxy_requests_in_flight",
+			Help: "The number of requests of the resource proxy being served, by route",
+		}, []string{"route"}),
+		HedgedRequests: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_resource_proxy_hedged_requests_total",
+			Help: "The total number of copies of resource requests sent to agents not answering in time, and of those whose response was returned",
+		}, []string{"result"}),
+		LogStreamTeardowns: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_resource_proxy_log_stream_teardowns_total",
+			Help: "The total number of follow log streams whose client went away, by whether the agent confirmed tearing down the container log stream",
+		}, []string{"result"}),
+		LogStreamTeardownDuration: f.NewHistogram(prometheus.HistogramOpts{
+			Name:    "principal_resource_proxy_log_stream_teardown_seconds",
+			Help:    "Histogram of time between the client of a follow log stream going away and the agent closing the container log stream (in seconds)",
+			Buckets: ResourceProxyBuckets,
+		}),
+		LogStreamRejections: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_resource_proxy_log_stream_rejections_total",
+			Help: "The total number of log streams of agents rejected because of their request ID, by reason",
+		}, []string{"reason"}),
+	}
 }
 
 // AgentMetrics holds metrics of agent
@@ -75,6 +194,54 @@ type AgentMetrics struct {
 	EventProcessingTime *prometheus.HistogramVec
 	PropagationLatency  *prometheus.HistogramVec
 	AgentErrors         *prometheus.CounterVec
+	// EventHandlerDuration observes how long the handlers of events block
+	// the event processing loop, by event target and type
+	EventHandlerDuration *prometheus.HistogramVec
+	// SlowEventHandlers counts the events whose handler took longer than
+	// the slow handler threshold, by event target and type
+	SlowEventHandlers *prometheus.CounterVec
+	// FilteredResourceStatuses counts the resource statuses not sent to
+	// the principal because of the agent's resource filter
+	FilteredResourceStatuses prometheus.Counter
+	// SuppressedStatusUpdates counts the status updates not sent to the
+	// principal because only timestamps changed
+	SuppressedStatusUpdates prometheus.Counter
+	// LogStreams counts the log streams finished by the principal, by the
+	// gRPC code they ended with and the state of the principal's writer
+	LogStreams *prometheus.CounterVec
+	// LogStreamBytes counts the bytes of log data received by the principal
+	LogStreamBytes prometheus.Counter
+	// LogStreamDuration observes how long log streams were open on the
+	// principal
+	LogStreamDuration prometheus.Histogram
+	// LogStreamsWaiting is the number of log streams waiting for a free
+	// stream, with the number of log streams limited
+	LogStreamsWaiting prometheus.Gauge
+	// LogStreamQueueTimeouts counts the log streams that failed because no
+	// stream became free in time
+	LogStreamQueueTimeouts prometheus.Counter
+	// MemoryBudget is the memory budget for buffered log data and queued
+	// events in bytes, or 0 if there is none
+	MemoryBudget prometheus.Gauge
+	// MemoryBuffered is the memory used by buffered log data and queued
+	// events in bytes, by kind
+	MemoryBuffered *prometheus.GaugeVec
+	// MemoryShedding counts the load shed to stay within the memory budget,
+	// by action
+	MemoryShedding *prometheus.CounterVec
+	// Leader is 1 if this replica is the leader and 0 if it stands by, with
+	// leader election enabled
+	Leader prometheus.Gauge
+	// LeaderTransitions counts how often this replica became the leader
+	LeaderTransitions prometheus.Counter
+	// InitialSyncPending is the number of resources the agent has yet to
+	// send in the current initial sync, by event kind
+	InitialSyncPending *prometheus.GaugeVec
+	// ResyncDuration is the time it took to send all resources of a resync
+	ResyncDuration *prometheus.HistogramVec
+	// ResyncObjects is the number of resources of the last resync, by event
+	// kind
+	ResyncObjects *prometheus.GaugeVec
 }
 
 func NewInformerMetrics(label string) *InformerMetrics {
@@ -91,116 +258,336 @@ func NewInformerMetrics(label string) *InformerMetrics {
 	return im
 }
 
+// NewPrincipalMetrics returns the principal metrics, registered with the default registry
 func NewPrincipalMetrics() *PrincipalMetrics {
+	return NewPrincipalMetricsWith(prometheus.DefaultRegisterer)
+}
+
+// NewPrincipalMetricsWith returns the principal metrics, registered with reg
+func NewPrincipalMetricsWith(reg prometheus.Registerer) *PrincipalMetrics {
+	f := promauto.With(reg)
 	return &PrincipalMetrics{
-		AgentConnected: promauto.NewGauge(prometheus.GaugeOpts{
+		AgentConnected: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_connected_with_principal",
 			Help: "The total number of agents connected with principal",
 		}),
-		AvgAgentConnectionTime: promauto.NewGauge(prometheus.GaugeOpts{
+		AvgAgentConnectionTime: f.NewGauge(prometheus.GaugeOpts{
 			Name: "principal_agent_avg_connection_time",
 			Help: "The average time all agents are connected for (in minutes)",
 		}),
 
-		ApplicationCreated: promauto.NewCounter(prometheus.CounterOpts{
+		ApplicationCreated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_applications_created",
 			Help: "The total number of applications created on the control plane",
 		}),
-		ApplicationUpdated: promauto.NewCounter(prometheus.CounterOpts{
+		ApplicationUpdated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_applications_updated",
 			Help: "The total number of applications updated on the control plane",
 		}),
-		ApplicationDeleted: promauto.NewCounter(prometheus.CounterOpts{
+		ApplicationDeleted: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_applications_deleted",
 			Help: "The total number of applications deleted on the control plane",
 		}),
 
-		AppProjectCreated: promauto.NewCounter(prometheus.CounterOpts{
+		AppProjectCreated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_app_projects_created",
 			Help: "The total number of app project created on the control plane",
 		}),
-		AppProjectUpdated: promauto.NewCounter(prometheus.CounterOpts{
+		AppProjectUpdated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_app_projects_updated",
 			Help: "The total number of app project updated on the control plane",
 		}),
-		AppProjectDeleted: promauto.NewCounter(prometheus.CounterOpts{
+		AppProjectDeleted: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_app_projects_deleted",
 			Help: "The total number of app project deleted on the control plane",
 		}),
 
-		RepositoryCreated: promauto.NewCounter(prometheus.CounterOpts{
+		RepositoryCreated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_repositories_created",
 			Help: "The total number of repositories created on the control plane",
 		}),
-		RepositoryUpdated: promauto.NewCounter(prometheus.CounterOpts{
+		RepositoryUpdated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_repositories_updated",
 			Help: "The total number of repositories updated on the control plane",
 		}),
-		RepositoryDeleted: promauto.NewCounter(prometheus.CounterOpts{
+		RepositoryDeleted: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_repositories_deleted",
 			Help: "The total number of repositories deleted on the control plane",
 		}),
 
-		GPGKeyCreated: promauto.NewCounter(prometheus.CounterOpts{
+		GPGKeyCreated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_gpg_keys_created",
 			Help: "The total number of GPG keys created on the control plane",
 		}),
-		GPGKeyUpdated: promauto.NewCounter(prometheus.CounterOpts{
+		GPGKeyUpdated: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_gpg_keys_updated",
 			Help: "The total number of GPG keys updated on the control plane",
 		}),
-		GPGKeyDeleted: promauto.NewCounter(prometheus.CounterOpts{
+		GPGKeyDeleted: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_gpg_keys_deleted",
 			Help: "The total number of GPG keys deleted on the control plane",
 		}),
 
-		EventReceived: promauto.NewCounter(prometheus.CounterOpts{
+		EventReceived: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_events_received",
 			Help: "The total number of events received by principal",
 		}),
-		EventSent: promauto.NewCounter(prometheus.CounterOpts{
+		EventSent: f.NewCounter(prometheus.CounterOpts{
 			Name: "principal_events_sent",
 			Help: "The total number of events sent by principal",
 		}),
 
-		EventProcessingTime: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		EventProcessingTime: f.NewHistogramVec(prometheus.HistogramOpts{
 			Name: "principal_event_processing_time",
 			Help: "Histogram of time taken to process events (in seconds)",
 		}, []string{"status", "agent_name", "resource_type"}),
-
-		PrincipalErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+		EventHandlerDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "principal_event_handler_duration_seconds",
+			Help:    "Histogram of time the handler of an event blocked the event processing loop (in seconds)",
+			Buckets: EventHandlerBuckets,
+		}, []string{"resource_type", "event_type"}),
+		SlowEventHandlers: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_slow_event_handlers_total",
+			Help: "The total number of events whose handler took longer than the slow handler threshold",
+		}, []string{"resource_type", "event_type"}),
+		EventDispatchRunning: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_event_dispatch_running",
+			Help: "The number of events of the agent being processed in parallel",
+		}, []string{"agent_name"}),
+		EventDispatchWaiting: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_event_dispatch_waiting",
+			Help: "The number of events of the agent waiting for an earlier event of the same resource, or for all earlier events of the agent, to be processed",
+		}, []string{"agent_name"}),
+
+		PrincipalErrors: f.NewCounterVec(prometheus.CounterOpts{
 			Name: "principal_errors",
 			Help: "The total number of errors occurred in principal",
 		}, []string{"resource_type"}),
+
+		AgentRTT: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_agent_rtt_seconds",
+			Help: "The network round trip time to the agent, excluding the agent's processing time (in seconds)",
+		}, []string{"agent_name"}),
+		AgentOneWayLatency: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_agent_one_way_latency_seconds",
+			Help: "The estimated one-way latency to the agent, i.e. half the round trip time (in seconds)",
+		}, []string{"agent_name"}),
+		AgentClockSkew: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_agent_clock_skew_seconds",
+			Help: "The estimated offset of the agent's clock from the principal's clock; positive if the agent is ahead (in seconds)",
+		}, []string{"agent_name"}),
+		AgentProbeRoundTrip: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "principal_agent_event_round_trip_seconds",
+			Help:    "Histogram of time from sending a ping event to the agent until receiving its pong, including queueing and processing (in seconds)",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"agent_name"}),
+
+		RevokedCertRejections: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_revoked_cert_rejections_total",
+			Help: "The total number of client certificates rejected because they have been revoked",
+		}, []string{"listener", "method"}),
+
+		AgentVersionSkew: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_agent_version_skew_minor",
+			Help: "The number of minor versions the agent is behind the principal; negative if the agent is ahead",
+		}, []string{"agent_name", "agent_version"}),
+		AgentVersionRefusals: f.NewCounter(prometheus.CounterOpts{
+			Name: "principal_agent_version_refusals_total",
+			Help: "The total number of agents refused because their version is not admitted by the version skew policy",
+		}),
+
+		OrphanedApplications: f.NewGauge(prometheus.GaugeOpts{
+			Name: "principal_orphaned_applications",
+			Help: "The number of applications targeting agents that no longer exist, as of the last check",
+		}),
+
+		ForcedApplicationDeletions: f.NewCounter(prometheus.CounterOpts{
+			Name: "principal_forced_application_deletions_total",
+			Help: "The total number of applications removed from the control plane without the agent confirming their deletion",
+		}),
+
+		ApplicationDivergences: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_application_divergences_total",
+			Help: "The total number of applications whose state diverged between principal and agent and was resynced",
+		}, []string{"agent_name", "kind"}),
+
+		ApplicationDrifts: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "principal_application_drifts_total",
+			Help: "The total number of times the principal's copy of an application of an autonomous agent drifted from the agent's",
+		}, []string{"agent_name", "detected_by"}),
+
+		InitialSyncPending: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_initial_sync_pending_resources",
+			Help: "The number of resources the principal has yet to send in the current initial sync with an agent",
+		}, []string{"agent_name", "kind"}),
+		ResyncDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "principal_resync_duration_seconds",
+			Help:    "Histogram of time taken to send all resources of a resync with an agent (in seconds)",
+			Buckets: ResyncBuckets,
+		}, []string{"kind"}),
+		ResyncObjects: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "principal_resync_objects",
+			Help: "The number of resources sent in the last resync with an agent",
+		}, []string{"agent_name", "kind"}),
+		ResourceProxy: NewResourceProxyMetricsWith(reg),
+	}
+}
+
+// SetAgentLabels makes l decide which agents have metrics labeled with their
+// own name. The series of agents that no longer have are removed.
+func (m *PrincipalMetrics) SetAgentLabels(l *AgentLabels) {
+	if l != nil {
+		l.mu.Lock()
+		l.onDemote = m.DeleteAgent
+		l.mu.Unlock()
 	}
+	m.agentLabels = l
+}
+
+// AgentLabel returns the value of the agent_name label for an observation of
+// agentName. Use it for counters and histograms, which can be aggregated over
+// agents.
+func (m *PrincipalMetrics) AgentLabel(agentName string) string {
+	return m.agentLabels.Label(agentName)
+}
+
+// PerAgent returns whether agentName has metrics of its own. Gauges of an
+// agent cannot be aggregated over agents, so they are only set if it has.
+func (m *PrincipalMetrics) PerAgent(agentName string) bool {
+	return m.agentLabels.PerAgent(agentName)
+}
+
+// DeleteAgent removes all series labeled with the name of an agent
+func (m *PrincipalMetrics) DeleteAgent(agentName string) {
+	labels := prometheus.Labels{"agent_name": agentName}
+	m.EventProcessingTime.DeletePartialMatch(labels)
+	m.EventDispatchRunning.DeletePartialMatch(labels)
+	m.EventDispatchWaiting.DeletePartialMatch(labels)
+	m.AgentVersionSkew.DeletePartialMatch(labels)
+	m.ApplicationDivergences.DeletePartialMatch(labels)
+	m.ApplicationDrifts.DeletePartialMatch(labels)
+	m.InitialSyncPending.DeletePartialMatch(labels)
+	m.ResyncObjects.DeletePartialMatch(labels)
+	m.DeleteAgentConnectionQuality(agentName)
 }
 
+// DeleteAgentConnectionQuality removes the connection quality metrics of an
+// agent, e.g. after it disconnected.
+func (m *PrincipalMetrics) DeleteAgentConnectionQuality(agentName string) {
+	m.AgentRTT.DeleteLabelValues(agentName)
+	m.AgentOneWayLatency.DeleteLabelValues(agentName)
+	m.AgentClockSkew.DeleteLabelValues(agentName)
+	m.AgentProbeRoundTrip.DeleteLabelValues(agentName)
+}
+
+// NewAgentMetrics returns the agent metrics, registered with the default registry
 func NewAgentMetrics() *AgentMetrics {
+	return NewAgentMetricsWith(prometheus.DefaultRegisterer)
+}
+
+// NewAgentMetricsWith returns the agent metrics, registered with reg
+func NewAgentMetricsWith(reg prometheus.Registerer) *AgentMetrics {
+	f := promauto.With(reg)
 	return &AgentMetrics{
-		EventReceived: promauto.NewCounter(prometheus.CounterOpts{
+		EventReceived: f.NewCounter(prometheus.CounterOpts{
 			Name: "agent_events_received",
 			Help: "The total number of events received by agent",
 		}),
-		EventSent: promauto.NewCounter(prometheus.CounterOpts{
+		EventSent: f.NewCounter(prometheus.CounterOpts{
 			Name: "agent_events_sent",
 			Help: "The total number of events sent by agent",
 		}),
 
-		EventProcessingTime: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		EventProcessingTime: f.NewHistogramVec(prometheus.HistogramOpts{
 			Name: "agent_event_processing_time",
 			Help: "Histogram of time taken to process events (in seconds)",
 		}, []string{"status", "agent_mode", "resource_type"}),
-
-		PropagationLatency: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		EventHandlerDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "agent_event_handler_duration_seconds",
+			Help:    "Histogram of time the handler of an event blocked the event processing loop (in seconds)",
+			Buckets: EventHandlerBuckets,
+		}, []string{"resource_type", "event_type"}),
+		SlowEventHandlers: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_slow_event_handlers_total",
+			Help: "The total number of events whose handler took longer than the slow handler threshold",
+		}, []string{"resource_type", "event_type"}),
+
+		PropagationLatency: f.NewHistogramVec(prometheus.HistogramOpts{
 			Name:    "agent_event_propagation_latency_seconds",
 			Help:    "Histogram of time from principal send to agent processing (in seconds)",
 			Buckets: prometheus.DefBuckets,
 		}, []string{"resource_type"}),
 
-		AgentErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+		AgentErrors: f.NewCounterVec(prometheus.CounterOpts{
 			Name: "agent_errors",
 			Help: "The total number of errors occurred in agent",
 		}, []string{"resource_type"}),
+
+		FilteredResourceStatuses: f.NewCounter(prometheus.CounterOpts{
+			Name: "agent_filtered_resource_statuses_total",
+			Help: "The total number of resource statuses not sent to the principal because of the resource filter",
+		}),
+
+		SuppressedStatusUpdates: f.NewCounter(prometheus.CounterOpts{
+			Name: "agent_suppressed_status_updates_total",
+			Help: "The total number of application status updates not sent to the principal because only timestamps changed",
+		}),
+
+		LogStreams: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_log_streams_total",
+			Help: "The total number of log streams finished by the principal, by result code and the state of the principal's HTTP writer",
+		}, []string{"code", "writer_status"}),
+		LogStreamBytes: f.NewCounter(prometheus.CounterOpts{
+			Name: "agent_log_stream_bytes_total",
+			Help: "The total number of bytes of log data received by the principal",
+		}),
+		LogStreamDuration: f.NewHistogram(prometheus.HistogramOpts{
+			Name:    "agent_log_stream_duration_seconds",
+			Help:    "Histogram of how long log streams were open on the principal (in seconds)",
+			Buckets: []float64{0.1, 1, 10, 60, 300, 1800, 3600},
+		}),
+		LogStreamsWaiting: f.NewGauge(prometheus.GaugeOpts{
+			Name: "agent_log_streams_waiting",
+			Help: "The number of log streams waiting for one of the limited log streams to become free",
+		}),
+		LogStreamQueueTimeouts: f.NewCounter(prometheus.CounterOpts{
+			Name: "agent_log_stream_queue_timeouts_total",
+			Help: "The total number of log streams that failed because no log stream became free in time",
+		}),
+
+		MemoryBudget: f.NewGauge(prometheus.GaugeOpts{
+			Name: "agent_memory_budget_bytes",
+			Help: "The memory budget for buffered log data and queued events in bytes, 0 if there is none",
+		}),
+		MemoryBuffered: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_memory_buffered_bytes",
+			Help: "The memory used by buffered log data and queued events in bytes",
+		}, []string{"kind"}),
+		MemoryShedding: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_memory_shedding_total",
+			Help: "The total number of times load was shed to stay within the memory budget, by action",
+		}, []string{"action"}),
+		Leader: f.NewGauge(prometheus.GaugeOpts{
+			Name: "agent_leader",
+			Help: "1 if this agent replica is the leader, 0 if it stands by",
+		}),
+		LeaderTransitions: f.NewCounter(prometheus.CounterOpts{
+			Name: "agent_leader_transitions_total",
+			Help: "The total number of times this agent replica became the leader",
+		}),
+		InitialSyncPending: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_initial_sync_pending_resources",
+			Help: "The number of resources the agent has yet to send in the current initial sync",
+		}, []string{"kind"}),
+		ResyncDuration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "agent_resync_duration_seconds",
+			Help:    "Histogram of time taken to send all resources of a resync with the principal (in seconds)",
+			Buckets: ResyncBuckets,
+		}, []string{"kind"}),
+		ResyncObjects: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_resync_objects",
+			Help: "The number of resources sent in the last resync with the principal",
+		}, []string{"kind"}),
 	}
 }
 
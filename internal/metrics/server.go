@@ -19,14 +19,16 @@ import (
 	"net/http"
 	neturl "net/url"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 type MetricsServerOptions struct {
-	host string
-	port int
-	path string
+	host     string
+	port     int
+	path     string
+	handlers map[string]http.Handler
 }
 
 type MetricsServerOption func(*MetricsServerOptions)
@@ -65,6 +67,17 @@ func WithListener(hostname string, port int) MetricsServerOption {
 	}
 }
 
+// WithHandler registers an additional handler for the given pattern on the
+// metrics server.
+func WithHandler(pattern string, handler http.Handler) MetricsServerOption {
+	return func(o *MetricsServerOptions) {
+		if o.handlers == nil {
+			o.handlers = make(map[string]http.Handler)
+		}
+		o.handlers[pattern] = handler
+	}
+}
+
 // StartMetricsServer starts the metrics server in a separate go routine and
 // returns an error channel.
 func StartMetricsServer(opts ...MetricsServerOption) chan error {
@@ -80,7 +93,13 @@ func StartMetricsServer(opts ...MetricsServerOption) chan error {
 	logrus.Infof("Starting metrics server on %s", url(config))
 	go func() {
 		sm := http.NewServeMux()
-		sm.Handle(config.path, promhttp.Handler())
+		// Exemplars linking slow observations to their traces are only
+		// exposed in the OpenMetrics format.
+		sm.Handle(config.path, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+		for pattern, handler := range config.handlers {
+			sm.Handle(pattern, handler)
+		}
 		errCh <- http.ListenAndServe(listener(config), sm)
 	}()
 	return errCh
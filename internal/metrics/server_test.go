@@ -47,4 +47,22 @@ func Test_MetricsServer(t *testing.T) {
 			fetchMetricsOutput(t)
 		}
 	})
+
+	t.Run("Additional handlers are served", func(t *testing.T) {
+		errCh := StartMetricsServer(WithListener("127.0.0.1", 31338), WithHandler("/metrics/agents/{name}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.PathValue("name")))
+		})))
+		ticker := time.NewTicker(time.Second)
+		select {
+		case err := <-errCh:
+			assert.NoError(t, err)
+		case <-ticker.C:
+			r, err := http.Get("http://127.0.0.1:31338/metrics/agents/agent-managed")
+			require.NoError(t, err)
+			defer r.Body.Close()
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "agent-managed", string(body))
+		}
+	})
 }
@@ -0,0 +1,202 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package netpol describes the network endpoints a component connects to, and
+renders NetworkPolicies and egress firewall rules allowing exactly these
+connections.
+*/
+package netpol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Endpoint is a network endpoint a component connects to
+type Endpoint struct {
+	// Name identifies the endpoint, e.g. principal
+	Name string `json:"name"`
+	// Host is the host name or IP address of the endpoint
+	Host string `json:"host"`
+	// Port is the TCP port of the endpoint
+	Port int `json:"port"`
+	// Addresses are the IP addresses Host resolved to
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// Report lists the endpoints a component connects to
+type Report struct {
+	// Namespace is the namespace the component runs in
+	Namespace string `json:"namespace"`
+	// Endpoints are the endpoints the component connects to
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// ParseEndpoint returns the endpoint of address, which may be a URL or a
+// host:port pair. defaultPort is used when address has no port.
+func ParseEndpoint(name, address string, defaultPort int) (Endpoint, error) {
+	ep := Endpoint{Name: name, Port: defaultPort}
+	host := address
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return ep, fmt.Errorf("invalid address of %s: %w", name, err)
+		}
+		host = u.Host
+		switch u.Scheme {
+		case "http":
+			ep.Port = 80
+		case "https":
+			ep.Port = 443
+		}
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return ep, fmt.Errorf("invalid port of %s: %s", name, p)
+		}
+		host, ep.Port = h, port
+	}
+	if host == "" || ep.Port <= 0 {
+		return ep, fmt.Errorf("invalid address of %s: %s", name, address)
+	}
+	ep.Host = host
+	return ep, nil
+}
+
+// Resolve sets the addresses of endpoints from DNS. Endpoints whose host
+// cannot be resolved are left without addresses.
+func Resolve(ctx context.Context, endpoints []Endpoint) {
+	for i := range endpoints {
+		ep := &endpoints[i]
+		if ip := net.ParseIP(ep.Host); ip != nil {
+			ep.Addresses = []string{ip.String()}
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, ep.Host)
+		if err != nil {
+			continue
+		}
+		sort.Strings(addrs)
+		ep.Addresses = addrs
+	}
+}
+
+// clusterService returns the namespace of the cluster service host refers to,
+// and whether it refers to one. Unqualified names refer to services in
+// namespace. Names of the form service.namespace are ambiguous and are not
+// considered cluster services.
+func clusterService(host, namespace string) (string, bool) {
+	if net.ParseIP(host) != nil {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(host, "."), ".")
+	switch {
+	case len(parts) == 1:
+		return namespace, true
+	case len(parts) >= 3 && parts[2] == "svc":
+		return parts[1], true
+	}
+	return "", false
+}
+
+func cidr(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return addr + "/128"
+	}
+	return addr + "/32"
+}
+
+// NetworkPolicy returns a NetworkPolicy named name, which allows the pods
+// matching podLabels in the report's namespace to connect to the endpoints of
+// the report and to the cluster's DNS, and nothing else.
+//
+// Endpoints that are cluster services are allowed by namespace, since their
+// pods' labels are not known. Other endpoints are allowed by the IP addresses
+// they resolved to. Note that many network plugins apply policies after
+// service addresses have been translated, so that the Kubernetes API might
+// need to be allowed by the addresses of its endpoints instead.
+func NetworkPolicy(name string, podLabels map[string]string, report *Report) *networkingv1.NetworkPolicy {
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+	dnsPort := intstr.FromInt32(53)
+	rules := []networkingv1.NetworkPolicyEgressRule{{
+		// DNS
+		To: []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"}},
+		}},
+		Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dnsPort}, {Protocol: &tcp, Port: &dnsPort}},
+	}}
+	for _, ep := range report.Endpoints {
+		port := intstr.FromInt(ep.Port)
+		rule := networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+		}
+		if ns, ok := clusterService(ep.Host, report.Namespace); ok {
+			rule.To = []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": ns}},
+			}}
+		} else {
+			if len(ep.Addresses) == 0 {
+				// Without addresses, the rule would allow any destination
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr(addr)}})
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: networkingv1.SchemeGroupVersion.String(), Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: report.Namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: podLabels},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      rules,
+		},
+	}
+}
+
+// FirewallRules returns a table of the egress connections of the report, for
+// firewalls outside of the cluster
+func FirewallRules(report *Report) string {
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tHOST\tADDRESSES\tPORT\tPROTOCOL")
+	for _, ep := range report.Endpoints {
+		addrs := strings.Join(ep.Addresses, ",")
+		if addrs == "" {
+			addrs = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\tTCP\n", ep.Name, ep.Host, addrs, ep.Port)
+	}
+	_ = tw.Flush()
+	return sb.String()
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+func Test_ParseEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		want    Endpoint
+		wantErr bool
+	}{
+		{address: "principal.example.com:443", want: Endpoint{Name: "ep", Host: "principal.example.com", Port: 443}},
+		{address: "argocd-redis", want: Endpoint{Name: "ep", Host: "argocd-redis", Port: 6379}},
+		{address: "https://10.96.0.1", want: Endpoint{Name: "ep", Host: "10.96.0.1", Port: 443}},
+		{address: "http://loki.monitoring.svc:3100", want: Endpoint{Name: "ep", Host: "loki.monitoring.svc", Port: 3100}},
+		{address: "[::1]:8443", want: Endpoint{Name: "ep", Host: "::1", Port: 8443}},
+		{address: "host:port", wantErr: true},
+	} {
+		t.Run(tt.address, func(t *testing.T) {
+			ep, err := ParseEndpoint("ep", tt.address, 6379)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ep)
+		})
+	}
+	_, err := ParseEndpoint("ep", "otel-collector", 0)
+	assert.Error(t, err)
+}
+
+func Test_NetworkPolicy(t *testing.T) {
+	report := &Report{
+		Namespace: "argocd",
+		Endpoints: []Endpoint{
+			{Name: "principal", Host: "principal.example.com", Port: 443, Addresses: []string{"192.0.2.10", "2001:db8::10"}},
+			{Name: "redis", Host: "argocd-redis", Port: 6379},
+			{Name: "loki", Host: "loki.monitoring.svc.cluster.local", Port: 3100},
+			{Name: "unresolved", Host: "nowhere.example.com", Port: 443},
+		},
+	}
+	np := NetworkPolicy("egress", map[string]string{"app": "agent"}, report)
+	assert.Equal(t, "argocd", np.Namespace)
+	assert.Equal(t, []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}, np.Spec.PolicyTypes)
+	// DNS, principal, redis and loki; the unresolved endpoint is skipped
+	require.Len(t, np.Spec.Egress, 4)
+	principal := np.Spec.Egress[1]
+	require.Len(t, principal.To, 2)
+	assert.Equal(t, "192.0.2.10/32", principal.To[0].IPBlock.CIDR)
+	assert.Equal(t, "2001:db8::10/128", principal.To[1].IPBlock.CIDR)
+	assert.Equal(t, 443, principal.Ports[0].Port.IntValue())
+	assert.Equal(t, "argocd", np.Spec.Egress[2].To[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"])
+	assert.Equal(t, "monitoring", np.Spec.Egress[3].To[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"])
+}
+
+func Test_FirewallRules(t *testing.T) {
+	report := &Report{Endpoints: []Endpoint{{Name: "principal", Host: "127.0.0.1", Port: 8443}}}
+	Resolve(context.Background(), report.Endpoints)
+	assert.Equal(t, []string{"127.0.0.1"}, report.Endpoints[0].Addresses)
+	rules := FirewallRules(report)
+	assert.Contains(t, rules, "NAME")
+	assert.Regexp(t, `principal\s+127.0.0.1\s+127.0.0.1\s+8443\s+TCP`, rules)
+}
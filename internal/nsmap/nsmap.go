@@ -0,0 +1,136 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package nsmap translates namespace names between the control plane (hub) and
+the cluster of an agent (spoke). This allows each tenant on the hub to use its
+own namespaces, e.g. with a tenant prefix, while the spoke keeps its local
+namespace conventions.
+
+A mapping consists of rules in the form <hub>=<spoke>. A rule either maps a
+single namespace, e.g. "payments=prod-payments", or, if both sides end with a
+"*", a prefix, e.g. "team-a-*=*" maps the hub namespace "team-a-web" to the
+spoke namespace "web" and vice versa. The first matching rule wins. Namespaces
+no rule matches, and the empty namespace of cluster-scoped resources, are not
+translated.
+*/
+package nsmap
+
+import (
+	"fmt"
+	"strings"
+)
+
+type rule struct {
+	hub    string
+	spoke  string
+	prefix bool
+}
+
+// Mapper translates namespace names between hub and spoke. A nil Mapper
+// does not translate any namespace.
+type Mapper struct {
+	rules []rule
+}
+
+// New returns a Mapper for the given rules, or nil if there are no rules.
+func New(rules []string) (*Mapper, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	m := &Mapper{}
+	for _, r := range rules {
+		parsed, err := parseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, parsed)
+	}
+	return m, nil
+}
+
+func parseRule(s string) (rule, error) {
+	hub, spoke, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return rule{}, fmt.Errorf("invalid namespace mapping %q: must be <hub>=<spoke>", s)
+	}
+	hubPrefix, spokePrefix := strings.HasSuffix(hub, "*"), strings.HasSuffix(spoke, "*")
+	if hubPrefix != spokePrefix {
+		return rule{}, fmt.Errorf("invalid namespace mapping %q: either both or none of the sides must end with *", s)
+	}
+	r := rule{hub: strings.TrimSuffix(hub, "*"), spoke: strings.TrimSuffix(spoke, "*"), prefix: hubPrefix}
+	if strings.Contains(r.hub, "*") || strings.Contains(r.spoke, "*") {
+		return rule{}, fmt.Errorf("invalid namespace mapping %q: * is only allowed at the end", s)
+	}
+	if !r.prefix && (r.hub == "" || r.spoke == "") {
+		return rule{}, fmt.Errorf("invalid namespace mapping %q: namespace must not be empty", s)
+	}
+	if r.prefix && r.hub == r.spoke {
+		return rule{}, fmt.Errorf("invalid namespace mapping %q: prefixes must differ", s)
+	}
+	return r, nil
+}
+
+// ToSpoke returns the name on the spoke of the hub namespace ns
+func (m *Mapper) ToSpoke(ns string) string {
+	if m == nil || ns == "" {
+		return ns
+	}
+	for _, r := range m.rules {
+		if out, ok := translate(ns, r.hub, r.spoke, r.prefix); ok {
+			return out
+		}
+	}
+	return ns
+}
+
+// ToHub returns the name on the hub of the spoke namespace ns
+func (m *Mapper) ToHub(ns string) string {
+	if m == nil || ns == "" {
+		return ns
+	}
+	for _, r := range m.rules {
+		if out, ok := translate(ns, r.spoke, r.hub, r.prefix); ok {
+			return out
+		}
+	}
+	return ns
+}
+
+// String returns the rules of the mapping, for logging
+func (m *Mapper) String() string {
+	if m == nil {
+		return ""
+	}
+	rules := make([]string, 0, len(m.rules))
+	for _, r := range m.rules {
+		if r.prefix {
+			rules = append(rules, r.hub+"*="+r.spoke+"*")
+		} else {
+			rules = append(rules, r.hub+"="+r.spoke)
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+func translate(ns, from, to string, prefix bool) (string, bool) {
+	if !prefix {
+		return to, ns == from
+	}
+	rest, ok := strings.CutPrefix(ns, from)
+	if !ok || rest == "" {
+		return "", false
+	}
+	return to + rest, true
+}
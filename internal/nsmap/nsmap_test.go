@@ -0,0 +1,67 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nsmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Mapper(t *testing.T) {
+	t.Run("No rules", func(t *testing.T) {
+		m, err := New(nil)
+		require.NoError(t, err)
+		assert.Nil(t, m)
+		assert.Equal(t, "web", m.ToSpoke("web"))
+		assert.Equal(t, "web", m.ToHub("web"))
+	})
+
+	t.Run("Exact and prefix rules", func(t *testing.T) {
+		m, err := New([]string{"payments=prod-payments", "team-a-*=*"})
+		require.NoError(t, err)
+		assert.Equal(t, "prod-payments", m.ToSpoke("payments"))
+		assert.Equal(t, "payments", m.ToHub("prod-payments"))
+		assert.Equal(t, "web", m.ToSpoke("team-a-web"))
+		assert.Equal(t, "team-a-web", m.ToHub("web"))
+		// Unmatched namespaces are not translated
+		assert.Equal(t, "other", m.ToSpoke("other"))
+		assert.Equal(t, "team-a-", m.ToSpoke("team-a-"))
+		assert.Equal(t, "payments=prod-payments,team-a-*=*", m.String())
+	})
+
+	t.Run("First matching rule wins", func(t *testing.T) {
+		m, err := New([]string{"team-a-db=shared-db", "team-a-*=a-*"})
+		require.NoError(t, err)
+		assert.Equal(t, "shared-db", m.ToSpoke("team-a-db"))
+		assert.Equal(t, "a-web", m.ToSpoke("team-a-web"))
+		assert.Equal(t, "team-a-web", m.ToHub("a-web"))
+	})
+
+	t.Run("Cluster-scoped resources have no namespace", func(t *testing.T) {
+		m, err := New([]string{"team-a-*=*"})
+		require.NoError(t, err)
+		assert.Equal(t, "", m.ToSpoke(""))
+		assert.Equal(t, "", m.ToHub(""))
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		for _, r := range []string{"web", "team-*=web", "=web", "a*b=c", "x-*=x-*"} {
+			_, err := New([]string{r})
+			assert.Error(t, err, r)
+		}
+	})
+}
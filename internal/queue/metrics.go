@@ -0,0 +1,146 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector exports the depth, backlog age and throughput of each queue
+type collector struct {
+	queues *SendRecvQueues
+	// perAgent returns whether the queues of an agent are exported on their
+	// own, or aggregated with those of other agents. If nil, all are
+	// exported on their own.
+	perAgent func(agentName string) bool
+	// aggregate is the agent_name label of aggregated queues
+	aggregate string
+
+	depth     *prometheus.Desc
+	oldestAge *prometheus.Desc
+	enqueued  *prometheus.Desc
+	dequeued  *prometheus.Desc
+	evicted   *prometheus.Desc
+	expired   *prometheus.Desc
+}
+
+// NewCollector returns a Prometheus collector for the queues in q. The names
+// of the metrics start with prefix, e.g. "principal" or "agent".
+func NewCollector(q *SendRecvQueues, prefix string) prometheus.Collector {
+	return newCollector(q, prefix)
+}
+
+// NewAggregatingCollector returns a Prometheus collector like NewCollector,
+// except that only the queues of agents for which perAgent returns true are
+// exported on their own. The queues of all other agents are exported as one,
+// labeled with aggregate.
+func NewAggregatingCollector(q *SendRecvQueues, prefix string, perAgent func(agentName string) bool, aggregate string) prometheus.Collector {
+	c := newCollector(q, prefix)
+	c.perAgent = perAgent
+	c.aggregate = aggregate
+	return c
+}
+
+func newCollector(q *SendRecvQueues, prefix string) *collector {
+	labels := []string{"agent_name", "queue"}
+	return &collector{
+		queues: q,
+		depth: prometheus.NewDesc(prefix+"_queue_depth",
+			"The number of events waiting in the queue", labels, nil),
+		oldestAge: prometheus.NewDesc(prefix+"_queue_oldest_item_age_seconds",
+			"The time the oldest event has been waiting in the queue (in seconds)", labels, nil),
+		enqueued: prometheus.NewDesc(prefix+"_queue_enqueued_total",
+			"The total number of events added to the queue", labels, nil),
+		dequeued: prometheus.NewDesc(prefix+"_queue_dequeued_total",
+			"The total number of events taken from the queue", labels, nil),
+		evicted: prometheus.NewDesc(prefix+"_queue_evicted_total",
+			"The total number of events dropped because the queue exceeded its limits", labels, nil),
+		expired: prometheus.NewDesc(prefix+"_queue_expired_total",
+			"The total number of events dropped because their TTL passed before delivery", labels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.depth
+	ch <- c.oldestAge
+	ch <- c.enqueued
+	ch <- c.dequeued
+	ch <- c.evicted
+	ch <- c.expired
+}
+
+// queueStats are the metrics of one or more queues
+type queueStats struct {
+	depth     float64
+	oldestAge float64
+	enqueued  float64
+	dequeued  float64
+	evicted   float64
+	expired   float64
+}
+
+// add adds the metrics of bq. The age of the oldest event is the maximum
+// over all queues.
+func (s *queueStats) add(now time.Time, bq *boundedQueue) {
+	if oldest := bq.oldest(); !oldest.IsZero() {
+		s.oldestAge = max(s.oldestAge, now.Sub(oldest).Seconds())
+	}
+	s.depth += float64(bq.Len())
+	s.enqueued += float64(bq.enqueued.Load())
+	s.dequeued += float64(bq.dequeued.Load())
+	s.evicted += float64(bq.evicted.Load())
+	s.expired += float64(bq.expired.Load())
+}
+
+// Collect implements prometheus.Collector
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	var aggregated map[string]*queueStats
+	c.queues.queuelock.RLock()
+	for name, qp := range c.queues.queues {
+		if c.perAgent != nil && !c.perAgent(name) {
+			if aggregated == nil {
+				aggregated = map[string]*queueStats{"send": {}, "recv": {}}
+			}
+			aggregated["send"].add(now, qp.sendq)
+			aggregated["recv"].add(now, qp.recvq)
+			continue
+		}
+		c.collectQueue(ch, now, qp.sendq, name, "send")
+		c.collectQueue(ch, now, qp.recvq, name, "recv")
+	}
+	c.queues.queuelock.RUnlock()
+	for kind, stats := range aggregated {
+		c.collectStats(ch, stats, c.aggregate, kind)
+	}
+}
+
+func (c *collector) collectQueue(ch chan<- prometheus.Metric, now time.Time, bq *boundedQueue, name, kind string) {
+	stats := &queueStats{}
+	stats.add(now, bq)
+	c.collectStats(ch, stats, name, kind)
+}
+
+func (c *collector) collectStats(ch chan<- prometheus.Metric, stats *queueStats, name, kind string) {
+	ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, stats.depth, name, kind)
+	ch <- prometheus.MustNewConstMetric(c.oldestAge, prometheus.GaugeValue, stats.oldestAge, name, kind)
+	ch <- prometheus.MustNewConstMetric(c.enqueued, prometheus.CounterValue, stats.enqueued, name, kind)
+	ch <- prometheus.MustNewConstMetric(c.dequeued, prometheus.CounterValue, stats.dequeued, name, kind)
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, stats.evicted, name, kind)
+	ch <- prometheus.MustNewConstMetric(c.expired, prometheus.CounterValue, stats.expired, name, kind)
+}
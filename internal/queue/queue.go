@@ -17,11 +17,17 @@ package queue
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/config"
 	"github.com/argoproj-labs/argocd-agent/internal/env"
+	agentevent "github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -43,6 +49,28 @@ const (
 	defaultMaxQueueSize int = 1000
 )
 
+// EvictionPolicy decides which items are dropped from a send queue that
+// exceeds its limits, e.g. while its agent is disconnected.
+type EvictionPolicy string
+
+const (
+	// EvictOldest drops the oldest items, no matter what they are
+	EvictOldest EvictionPolicy = "drop-oldest"
+	// EvictOldestUpdate drops the oldest spec or status update, and only if
+	// there is none, the oldest other item. Interactive requests, such as
+	// resource, log or terminal requests, are never dropped.
+	EvictOldestUpdate EvictionPolicy = "drop-oldest-update"
+)
+
+// ParseEvictionPolicy parses the name of an eviction policy
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(s); p {
+	case EvictOldest, EvictOldestUpdate:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q, must be one of %s, %s", s, EvictOldest, EvictOldestUpdate)
+}
+
 type queuepair struct {
 	recvq *boundedQueue
 	sendq *boundedQueue
@@ -51,27 +79,61 @@ type queuepair struct {
 type boundedQueue struct {
 	workqueue.TypedRateLimitingInterface[*event.Event]
 	maxSize int
+	// policy decides which items are dropped when the queue exceeds its
+	// limits
+	policy EvictionPolicy
+	// maxAge is the age after which items may be dropped. 0 means items
+	// never expire.
+	maxAge time.Duration
+	// dropExpired is set if items whose TTL has passed are dropped instead
+	// of handed out. Only the sender of an item knows how long it's useful,
+	// so that's done in send queues.
+	dropExpired bool
 
 	notify chan struct{}
+
+	// mu serializes adding items, which may drop others
+	mu sync.Mutex
+	// addedMu protects added
+	addedMu sync.Mutex
+	// added holds the time each queued item was added
+	added map[*event.Event]time.Time
+	// bytes is the size of the payloads of the queued items
+	bytes atomic.Int64
+
+	enqueued atomic.Uint64
+	dequeued atomic.Uint64
+	evicted  atomic.Uint64
+	expired  atomic.Uint64
 }
 
-func newBoundedQueue(maxSize int) *boundedQueue {
+func newBoundedQueue(maxSize int, policy EvictionPolicy, maxAge time.Duration) *boundedQueue {
 	rateLimiter := workqueue.DefaultTypedControllerRateLimiter[*event.Event]()
 	return &boundedQueue{
 		TypedRateLimitingInterface: workqueue.NewTypedRateLimitingQueue(rateLimiter),
 		maxSize:                    maxSize,
+		policy:                     policy,
+		maxAge:                     maxAge,
 		notify:                     make(chan struct{}, 10),
+		added:                      make(map[*event.Event]time.Time),
 	}
 }
 
 func (bq *boundedQueue) Add(item *event.Event) {
-	// We pop the oldest item if the size is going to exceed maxSize.
-	if bq.Len() == bq.maxSize {
-		old, _ := bq.Get()
-		bq.Done(old)
+	bq.mu.Lock()
+	if !bq.evict(item, time.Now()) {
+		bq.mu.Unlock()
+		return
 	}
-
+	bq.addedMu.Lock()
+	if _, ok := bq.added[item]; !ok {
+		bq.added[item] = time.Now()
+		bq.bytes.Add(int64(len(item.Data())))
+	}
+	bq.addedMu.Unlock()
 	bq.TypedRateLimitingInterface.Add(item)
+	bq.enqueued.Add(1)
+	bq.mu.Unlock()
 
 	// Notify any waiting goroutines that an item has been added to the queue.
 	select {
@@ -82,6 +144,215 @@ func (bq *boundedQueue) Add(item *event.Event) {
 	}
 }
 
+// Get returns the next item of the queue, see workqueue.Interface. Items
+// whose TTL has passed are dropped instead of being returned. If that leaves
+// the queue empty, Get returns a nil item rather than blocking.
+func (bq *boundedQueue) Get() (*event.Event, bool) {
+	for {
+		if item, shutdown, ok := bq.take(); ok {
+			return item, shutdown
+		}
+		if bq.Len() == 0 {
+			return nil, false
+		}
+	}
+}
+
+// take gets the next item of the queue. If the item has expired, it is
+// dropped and ok is false.
+func (bq *boundedQueue) take() (item *event.Event, shutdown bool, ok bool) {
+	item, shutdown = bq.TypedRateLimitingInterface.Get()
+	if item == nil {
+		return item, shutdown, true
+	}
+	bq.forget(item)
+	if bq.dropExpired && !shutdown && agentevent.IsExpired(item, time.Now()) {
+		bq.TypedRateLimitingInterface.Done(item)
+		bq.expired.Add(1)
+		log().WithFields(logrus.Fields{
+			"event_id":   agentevent.EventID(item),
+			"event_type": item.Type(),
+			"expired_at": agentevent.ExpiresAt(item),
+		}).Debug("Dropping expired event from queue")
+		return nil, false, false
+	}
+	bq.dequeued.Add(1)
+	return item, shutdown, true
+}
+
+// forget removes the add time of item
+func (bq *boundedQueue) forget(item *event.Event) {
+	bq.addedMu.Lock()
+	if _, ok := bq.added[item]; ok {
+		delete(bq.added, item)
+		bq.bytes.Add(-int64(len(item.Data())))
+	}
+	bq.addedMu.Unlock()
+}
+
+// oldest returns the add time of the oldest item in the queue, or the zero
+// time if the queue is empty.
+func (bq *boundedQueue) oldest() time.Time {
+	bq.addedMu.Lock()
+	defer bq.addedMu.Unlock()
+	var oldest time.Time
+	for _, t := range bq.added {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+// pending returns the items waiting in the queue, oldest first, without
+// taking them from the queue
+func (bq *boundedQueue) pending() []*event.Event {
+	bq.addedMu.Lock()
+	defer bq.addedMu.Unlock()
+	items := make([]*event.Event, 0, len(bq.added))
+	for it := range bq.added {
+		items = append(items, it)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return bq.added[items[i]].Before(bq.added[items[j]]) })
+	return items
+}
+
+// evict makes room for item according to the queue's limits and eviction
+// policy. It returns false if item itself has to be dropped. Caller must hold
+// bq.mu.
+func (bq *boundedQueue) evict(item *event.Event, now time.Time) bool {
+	full := bq.Len() >= bq.maxSize
+	oldest := bq.oldest()
+	expired := bq.maxAge > 0 && !oldest.IsZero() && now.Sub(oldest) > bq.maxAge
+	if !full && !expired {
+		return true
+	}
+
+	// We pop the oldest item if the size is going to exceed maxSize.
+	if bq.policy == EvictOldest && !expired {
+		old, _ := bq.TypedRateLimitingInterface.Get()
+		bq.TypedRateLimitingInterface.Done(old)
+		bq.drop(old)
+		return true
+	}
+
+	// The workqueue can only hand out items in order, so we take them all
+	// and put back those we keep.
+	items := make([]*event.Event, 0, bq.Len())
+	for bq.TypedRateLimitingInterface.Len() > 0 {
+		it, shutdown := bq.TypedRateLimitingInterface.Get()
+		if shutdown {
+			return false
+		}
+		bq.TypedRateLimitingInterface.Done(it)
+		items = append(items, it)
+	}
+	bq.addedMu.Lock()
+	keep := make([]*event.Event, 0, len(items))
+	for _, it := range items {
+		if bq.maxAge > 0 && now.Sub(bq.added[it]) > bq.maxAge && bq.mayDrop(it) {
+			bq.addedMu.Unlock()
+			bq.drop(it)
+			bq.addedMu.Lock()
+			continue
+		}
+		keep = append(keep, it)
+	}
+	bq.addedMu.Unlock()
+	if len(keep) >= bq.maxSize {
+		if victim := bq.victim(keep); victim >= 0 {
+			bq.drop(keep[victim])
+			keep = append(keep[:victim], keep[victim+1:]...)
+		} else if bq.mayDrop(item) {
+			// Nothing else may be dropped, so the new item goes
+			bq.restore(keep)
+			bq.evicted.Add(1)
+			return false
+		}
+		// Interactive requests are queued even beyond the limit
+	}
+	bq.restore(keep)
+	return true
+}
+
+// victim returns the index of the item in items to drop from a full queue,
+// or -1 if the policy doesn't allow to drop any of them.
+func (bq *boundedQueue) victim(items []*event.Event) int {
+	if bq.policy == EvictOldest {
+		return 0
+	}
+	for i, it := range items {
+		if agentevent.IsUpdate(it) {
+			return i
+		}
+	}
+	for i, it := range items {
+		if bq.mayDrop(it) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mayDrop returns whether the eviction policy allows to drop item
+func (bq *boundedQueue) mayDrop(item *event.Event) bool {
+	return bq.policy == EvictOldest || !agentevent.IsInteractive(item)
+}
+
+// drop records that item was dropped from the queue
+func (bq *boundedQueue) drop(item *event.Event) {
+	bq.forget(item)
+	bq.evicted.Add(1)
+}
+
+// coalesce drops queued status updates that are superseded by a later status
+// update of the same resource, and returns the number of events dropped. Only
+// the latest status of a resource is worth sending.
+func (bq *boundedQueue) coalesce() int {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+	items := make([]*event.Event, 0, bq.Len())
+	for bq.TypedRateLimitingInterface.Len() > 0 {
+		it, shutdown := bq.TypedRateLimitingInterface.Get()
+		if shutdown {
+			return 0
+		}
+		bq.TypedRateLimitingInterface.Done(it)
+		items = append(items, it)
+	}
+	type updateKey struct{ target, resourceID string }
+	latest := make(map[updateKey]bool)
+	superseded := make(map[*event.Event]bool)
+	for i := len(items) - 1; i >= 0; i-- {
+		it := items[i]
+		if agentevent.EventType(it.Type()) != agentevent.StatusUpdate {
+			continue
+		}
+		k := updateKey{agentevent.Target(it).String(), agentevent.ResourceID(it)}
+		superseded[it] = latest[k]
+		latest[k] = true
+	}
+	keep := make([]*event.Event, 0, len(items))
+	dropped := 0
+	for _, it := range items {
+		if superseded[it] {
+			bq.drop(it)
+			dropped++
+			continue
+		}
+		keep = append(keep, it)
+	}
+	bq.restore(keep)
+	return dropped
+}
+
+// restore puts items back into the queue in order
+func (bq *boundedQueue) restore(items []*event.Event) {
+	for _, it := range items {
+		bq.TypedRateLimitingInterface.Add(it)
+	}
+}
+
 type SendRecvQueues struct {
 	queues    map[string]*queuepair
 	queuelock sync.RWMutex
@@ -134,6 +405,51 @@ func (q *SendRecvQueues) SendQ(name string) workqueue.TypedRateLimitingInterface
 	return nil
 }
 
+// PendingSend returns copies of the events waiting in the send queue of the
+// queue pair named name, oldest first. The events stay in the queue. If no
+// such queue pair exists, returns nil
+func (q *SendRecvQueues) PendingSend(name string) []*event.Event {
+	q.queuelock.RLock()
+	qp, ok := q.queues[name]
+	q.queuelock.RUnlock()
+	if !ok {
+		return nil
+	}
+	items := qp.sendq.pending()
+	out := make([]*event.Event, 0, len(items))
+	for _, it := range items {
+		c := it.Clone()
+		out = append(out, &c)
+	}
+	return out
+}
+
+// SendBytes returns the size of the payloads of the events waiting in the
+// send queue of the queue pair named name, or 0 if no such queue pair exists
+func (q *SendRecvQueues) SendBytes(name string) int64 {
+	q.queuelock.RLock()
+	defer q.queuelock.RUnlock()
+	qp, ok := q.queues[name]
+	if !ok {
+		return 0
+	}
+	return qp.sendq.bytes.Load()
+}
+
+// CoalesceSend drops status updates from the send queue of the queue pair
+// named name that are superseded by a later status update of the same
+// resource. It
+// returns the number of events dropped.
+func (q *SendRecvQueues) CoalesceSend(name string) int {
+	q.queuelock.RLock()
+	qp, ok := q.queues[name]
+	q.queuelock.RUnlock()
+	if !ok {
+		return 0
+	}
+	return qp.sendq.coalesce()
+}
+
 // RecvQ will return the receive queue from the queue pair named name. If no
 // such queue pair exists, returns nil
 func (q *SendRecvQueues) RecvQ(name string) workqueue.TypedRateLimitingInterface[*event.Event] {
@@ -168,10 +484,21 @@ func (q *SendRecvQueues) Create(name string) error {
 		}
 		return nil
 	}, defaultMaxQueueSize)
+	policy := EvictionPolicy(env.StringWithDefault(config.EnvSendQueueEvictionPolicy, func(s string) error {
+		_, err := ParseEvictionPolicy(s)
+		return err
+	}, string(EvictOldest)))
+	maxAge := env.DurationWithDefault(config.EnvSendQueueMaxAge, func(d time.Duration) error {
+		if d < 0 {
+			return fmt.Errorf("maximum age must not be negative")
+		}
+		return nil
+	}, 0)
 	qp := &queuepair{}
 	// Send queue is non-blocking, to keep up with the informer
-	qp.sendq = newBoundedQueue(sendQueueSize)
-	qp.recvq = newBoundedQueue(recvQueueSize)
+	qp.sendq = newBoundedQueue(sendQueueSize, policy, maxAge)
+	qp.sendq.dropExpired = true
+	qp.recvq = newBoundedQueue(recvQueueSize, EvictOldest, 0)
 	q.queues[name] = qp
 
 	return nil
@@ -206,7 +533,12 @@ func GetWithContext(q workqueue.TypedRateLimitingInterface[*event.Event], ctx co
 
 	for {
 		if bq.Len() > 0 {
-			return bq.Get()
+			// Get would block if the only item has expired, so we check
+			// again.
+			if item, shutdown, ok := bq.take(); ok {
+				return item, shutdown
+			}
+			continue
 		}
 
 		// Suspend until an item is available or context is cancelled
@@ -218,3 +550,7 @@ func GetWithContext(q workqueue.TypedRateLimitingInterface[*event.Event], ctx co
 		}
 	}
 }
+
+func log() *logrus.Entry {
+	return logging.GetDefaultLogger().ComponentLogger("Queue")
+}
@@ -15,12 +15,19 @@
 package queue
 
 import (
+	"context"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/config"
+	agentevent "github.com/argoproj-labs/argocd-agent/internal/event"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_Queue(t *testing.T) {
@@ -111,4 +118,277 @@ func Test_Queue(t *testing.T) {
 		assert.Equal(t, "2", front.ID())
 	})
 
+	t.Run("Invalid eviction policy", func(t *testing.T) {
+		_, err := ParseEvictionPolicy("drop-newest")
+		assert.Error(t, err)
+		p, err := ParseEvictionPolicy("drop-oldest-update")
+		assert.NoError(t, err)
+		assert.Equal(t, EvictOldestUpdate, p)
+	})
+}
+
+func newTestEvent(id string, target agentevent.EventTarget, typ agentevent.EventType) *event.Event {
+	ev := event.New()
+	ev.SetID(id)
+	ev.SetDataSchema(target.String())
+	ev.SetType(typ.String())
+	return &ev
+}
+
+func ids(t *testing.T, q *boundedQueue) []string {
+	t.Helper()
+	var ids []string
+	for q.Len() > 0 {
+		ev, _ := q.Get()
+		q.Done(ev)
+		ids = append(ids, ev.ID())
+	}
+	return ids
+}
+
+func Test_EvictionPolicy(t *testing.T) {
+	update := func(id string) *event.Event {
+		return newTestEvent(id, agentevent.TargetApplication, agentevent.SpecUpdate)
+	}
+	create := func(id string) *event.Event {
+		return newTestEvent(id, agentevent.TargetApplication, agentevent.Create)
+	}
+	request := func(id string) *event.Event {
+		return newTestEvent(id, agentevent.TargetResource, agentevent.GetRequest)
+	}
+
+	t.Run("Oldest update is dropped first", func(t *testing.T) {
+		q := newBoundedQueue(3, EvictOldestUpdate, 0)
+		q.Add(request("1"))
+		q.Add(create("2"))
+		q.Add(update("3"))
+		q.Add(update("4"))
+		assert.Equal(t, []string{"1", "2", "4"}, ids(t, q))
+		assert.Equal(t, uint64(1), q.evicted.Load())
+	})
+
+	t.Run("Interactive requests are never dropped", func(t *testing.T) {
+		q := newBoundedQueue(2, EvictOldestUpdate, 0)
+		q.Add(request("1"))
+		q.Add(create("2"))
+		q.Add(request("3"))
+		assert.Equal(t, []string{"1", "3"}, ids(t, q))
+
+		q.Add(request("4"))
+		q.Add(request("5"))
+		// The queue is full of requests, so updates are dropped right away
+		q.Add(update("6"))
+		// ...but requests are queued beyond the limit
+		q.Add(request("7"))
+		assert.Equal(t, []string{"4", "5", "7"}, ids(t, q))
+		assert.Equal(t, uint64(2), q.evicted.Load())
+	})
+
+	t.Run("Expired items are dropped", func(t *testing.T) {
+		q := newBoundedQueue(10, EvictOldestUpdate, time.Minute)
+		q.Add(update("1"))
+		q.Add(request("2"))
+		q.Add(update("3"))
+		q.addedMu.Lock()
+		for ev := range q.added {
+			if ev.ID() != "3" {
+				q.added[ev] = time.Now().Add(-2 * time.Minute)
+			}
+		}
+		q.addedMu.Unlock()
+		q.Add(update("4"))
+		assert.Equal(t, []string{"2", "3", "4"}, ids(t, q))
+	})
+
+	t.Run("Drop oldest policy keeps previous behavior", func(t *testing.T) {
+		q := newBoundedQueue(2, EvictOldest, 0)
+		q.Add(request("1"))
+		q.Add(update("2"))
+		q.Add(request("3"))
+		assert.Equal(t, []string{"2", "3"}, ids(t, q))
+	})
+
+	t.Run("Policy is configured via environment variable", func(t *testing.T) {
+		t.Setenv(config.EnvSendQueueEvictionPolicy, string(EvictOldestUpdate))
+		t.Setenv(config.EnvSendQueueMaxAge, "5m")
+		q := NewSendRecvQueues()
+		require.NoError(t, q.Create("agent1"))
+		sendq := q.SendQ("agent1").(*boundedQueue)
+		assert.Equal(t, EvictOldestUpdate, sendq.policy)
+		assert.Equal(t, 5*time.Minute, sendq.maxAge)
+		recvq := q.RecvQ("agent1").(*boundedQueue)
+		assert.Equal(t, EvictOldest, recvq.policy)
+	})
+}
+
+func Test_TTL(t *testing.T) {
+	expired := func(id string) *event.Event {
+		ev := newTestEvent(id, agentevent.TargetResource, agentevent.GetRequest)
+		agentevent.SetTTL(ev, -time.Second)
+		return ev
+	}
+	fresh := func(id string) *event.Event {
+		ev := newTestEvent(id, agentevent.TargetResource, agentevent.GetRequest)
+		agentevent.SetTTL(ev, time.Minute)
+		return ev
+	}
+
+	t.Run("Expired events are dropped from the send queue", func(t *testing.T) {
+		q := NewSendRecvQueues()
+		require.NoError(t, q.Create("agent1"))
+		sendq := q.SendQ("agent1")
+		sendq.Add(expired("1"))
+		sendq.Add(fresh("2"))
+		sendq.Add(newTestEvent("3", agentevent.TargetApplication, agentevent.SpecUpdate))
+		bq := sendq.(*boundedQueue)
+		assert.Equal(t, []string{"2", "3"}, ids(t, bq))
+		assert.Equal(t, uint64(1), bq.expired.Load())
+		assert.Equal(t, uint64(2), bq.dequeued.Load())
+	})
+
+	t.Run("Get does not block when only expired events are left", func(t *testing.T) {
+		q := NewSendRecvQueues()
+		require.NoError(t, q.Create("agent1"))
+		sendq := q.SendQ("agent1")
+		sendq.Add(expired("1"))
+		ev, shutdown := sendq.Get()
+		assert.Nil(t, ev)
+		assert.False(t, shutdown)
+		assert.Equal(t, 0, sendq.Len())
+	})
+
+	t.Run("GetWithContext waits for the next unexpired event", func(t *testing.T) {
+		q := NewSendRecvQueues()
+		require.NoError(t, q.Create("agent1"))
+		sendq := q.SendQ("agent1")
+		sendq.Add(expired("1"))
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			sendq.Add(fresh("2"))
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ev, shutdown := GetWithContext(sendq, ctx)
+		require.NotNil(t, ev)
+		assert.False(t, shutdown)
+		assert.Equal(t, "2", ev.ID())
+	})
+
+	t.Run("Receive queue keeps expired events", func(t *testing.T) {
+		q := NewSendRecvQueues()
+		require.NoError(t, q.Create("agent1"))
+		recvq := q.RecvQ("agent1")
+		recvq.Add(expired("1"))
+		ev, _ := recvq.Get()
+		require.NotNil(t, ev)
+		assert.Equal(t, "1", ev.ID())
+	})
+}
+
+func Test_PendingSend(t *testing.T) {
+	q := NewSendRecvQueues()
+	require.NoError(t, q.Create("agent1"))
+	assert.Nil(t, q.PendingSend("agent2"))
+	sendq := q.SendQ("agent1")
+	for _, id := range []string{"1", "2", "3"} {
+		ev := event.New()
+		ev.SetID(id)
+		sendq.Add(&ev)
+		time.Sleep(time.Millisecond)
+	}
+	got, _ := sendq.Get()
+	sendq.Done(got)
+
+	pending := q.PendingSend("agent1")
+	require.Len(t, pending, 2)
+	assert.Equal(t, "2", pending[0].ID())
+	assert.Equal(t, "3", pending[1].ID())
+	assert.Equal(t, 2, sendq.Len())
+}
+
+func Test_CoalesceSend(t *testing.T) {
+	status := func(id, resource string) *event.Event {
+		ev := newTestEvent(id, agentevent.TargetApplication, agentevent.StatusUpdate)
+		ev.SetExtension("resourceid", resource)
+		require.NoError(t, ev.SetData(event.ApplicationJSON, map[string]string{"id": id}))
+		return ev
+	}
+	q := NewSendRecvQueues()
+	require.NoError(t, q.Create("agent1"))
+	assert.Equal(t, 0, q.CoalesceSend("agent2"))
+	sendq := q.SendQ("agent1")
+	sendq.Add(status("1", "app-1_1"))
+	sendq.Add(status("2", "app-2_2"))
+	sendq.Add(newTestEvent("3", agentevent.TargetApplication, agentevent.Create))
+	sendq.Add(status("4", "app-1_1"))
+	sendq.Add(status("5", "app-1_1"))
+	size := q.SendBytes("agent1")
+	assert.Positive(t, size)
+
+	assert.Equal(t, 2, q.CoalesceSend("agent1"))
+	assert.Less(t, q.SendBytes("agent1"), size)
+	bq := sendq.(*boundedQueue)
+	assert.Equal(t, uint64(2), bq.evicted.Load())
+	assert.Equal(t, []string{"2", "3", "5"}, ids(t, bq))
+	assert.Zero(t, q.SendBytes("agent1"))
+}
+
+func Test_Collector(t *testing.T) {
+	q := NewSendRecvQueues()
+	require.NoError(t, q.Create("agent1"))
+	sendq := q.SendQ("agent1")
+	ev := event.New()
+	ev.SetID("1")
+	sendq.Add(&ev)
+	ev2 := event.New()
+	ev2.SetID("2")
+	sendq.Add(&ev2)
+	got, _ := sendq.Get()
+	sendq.Done(got)
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(NewCollector(q, "principal")))
+	expected := `
+# HELP principal_queue_depth The number of events waiting in the queue
+# TYPE principal_queue_depth gauge
+principal_queue_depth{agent_name="agent1",queue="recv"} 0
+principal_queue_depth{agent_name="agent1",queue="send"} 1
+# HELP principal_queue_dequeued_total The total number of events taken from the queue
+# TYPE principal_queue_dequeued_total counter
+principal_queue_dequeued_total{agent_name="agent1",queue="recv"} 0
+principal_queue_dequeued_total{agent_name="agent1",queue="send"} 1
+# HELP principal_queue_enqueued_total The total number of events added to the queue
+# TYPE principal_queue_enqueued_total counter
+principal_queue_enqueued_total{agent_name="agent1",queue="recv"} 0
+principal_queue_enqueued_total{agent_name="agent1",queue="send"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"principal_queue_depth", "principal_queue_enqueued_total", "principal_queue_dequeued_total"))
+
+	count, err := testutil.GatherAndCount(reg, "principal_queue_oldest_item_age_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func Test_AggregatingCollector(t *testing.T) {
+	q := NewSendRecvQueues()
+	for _, name := range []string{"agent1", "agent2", "agent3"} {
+		require.NoError(t, q.Create(name))
+		ev := event.New()
+		ev.SetID(name)
+		q.SendQ(name).Add(&ev)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	perAgent := func(name string) bool { return name == "agent1" }
+	require.NoError(t, reg.Register(NewAggregatingCollector(q, "principal", perAgent, "_other")))
+	expected := `
+# HELP principal_queue_depth The number of events waiting in the queue
+# TYPE principal_queue_depth gauge
+principal_queue_depth{agent_name="_other",queue="recv"} 0
+principal_queue_depth{agent_name="_other",queue="send"} 2
+principal_queue_depth{agent_name="agent1",queue="recv"} 0
+principal_queue_depth{agent_name="agent1",queue="send"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "principal_queue_depth"))
 }
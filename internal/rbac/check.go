@@ -0,0 +1,111 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Report is the result of a permission check
+type Report struct {
+	// Missing lists the required permissions the agent lacks
+	Missing []Permission
+	// Excess lists the permissions the agent has in its namespace but
+	// doesn't need
+	Excess []Permission
+	// Incomplete is true if the cluster could not tell all permissions of
+	// the agent, e.g. because a webhook authorizer is used. Excess
+	// permissions may not be reported then.
+	Incomplete bool
+}
+
+// OK returns whether the agent has exactly the required permissions
+func (r *Report) OK() bool {
+	return len(r.Missing) == 0 && len(r.Excess) == 0
+}
+
+// selfReviews are resources every authenticated user may create
+var selfReviews = map[string]bool{
+	"authorization.k8s.io/selfsubjectaccessreviews": true,
+	"authorization.k8s.io/selfsubjectrulesreviews":  true,
+	"authentication.k8s.io/selfsubjectreviews":      true,
+}
+
+// Check checks the permissions of the client's user against required. Each
+// required permission is checked with a SelfSubjectAccessReview. Excess
+// permissions are found from a SelfSubjectRulesReview in namespace, so they
+// only include permissions in that namespace or cluster-wide.
+func Check(ctx context.Context, client kubernetes.Interface, namespace string, required []Permission) (*Report, error) {
+	report := &Report{}
+	for _, p := range required {
+		attrs := &authorizationv1.ResourceAttributes{
+			Group:       p.Group,
+			Resource:    p.Resource,
+			Subresource: p.Subresource,
+			Verb:        p.Verb,
+		}
+		if !p.ClusterWide {
+			attrs.Namespace = namespace
+		}
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attrs},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not review permission to %s: %w", p, err)
+		}
+		if !review.Status.Allowed {
+			report.Missing = append(report.Missing, p)
+		}
+	}
+
+	rules, err := client.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not review rules: %w", err)
+	}
+	report.Incomplete = rules.Status.Incomplete
+	seen := make(map[Permission]bool)
+	for _, rule := range rules.Status.ResourceRules {
+		for _, granted := range permissions("", rule.Resources, rule.Verbs, false) {
+			for _, group := range rule.APIGroups {
+				granted.Group = group
+				if seen[granted] || selfReviews[group+"/"+granted.Resource] || isRequired(granted, required) {
+					continue
+				}
+				seen[granted] = true
+				report.Excess = append(report.Excess, granted)
+			}
+		}
+	}
+	return report, nil
+}
+
+// isRequired returns whether the granted permission is needed for any of the
+// required ones. The scope of rules reviews is not known, so it is ignored.
+func isRequired(granted Permission, required []Permission) bool {
+	for _, r := range required {
+		r.ClusterWide = false
+		if r.covers(granted) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"bytes"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Rules returns the policy rules granting perms. Permissions on the same
+// resource are merged into one rule.
+func Rules(perms []Permission) []rbacv1.PolicyRule {
+	type key struct{ group, resource string }
+	var order []key
+	verbs := make(map[key][]string)
+	for _, p := range perms {
+		res := p.Resource
+		if p.Subresource != "" {
+			res += "/" + p.Subresource
+		}
+		k := key{p.Group, res}
+		if _, ok := verbs[k]; !ok {
+			order = append(order, k)
+		}
+		verbs[k] = append(verbs[k], p.Verb)
+	}
+	var rules []rbacv1.PolicyRule
+	for _, k := range order {
+		// Resources of the same group with the same verbs share a rule
+		merged := false
+		for i := range rules {
+			if rules[i].APIGroups[0] == k.group && equal(rules[i].Verbs, verbs[k]) {
+				rules[i].Resources = append(rules[i].Resources, k.resource)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			rules = append(rules, rbacv1.PolicyRule{
+				APIGroups: []string{k.group},
+				Resources: []string{k.resource},
+				Verbs:     verbs[k],
+			})
+		}
+	}
+	return rules
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Manifests returns a Role and RoleBinding in namespace, and a ClusterRole
+// and ClusterRoleBinding, granting the service account serviceAccount in
+// namespace the permissions needed for features. All resources are named
+// name.
+func Manifests(name, namespace, serviceAccount string, features ...Feature) []runtime.Object {
+	var namespaced, clusterWide []Permission
+	for _, p := range Required(features...) {
+		if p.ClusterWide {
+			clusterWide = append(clusterWide, p)
+		} else {
+			namespaced = append(namespaced, p)
+		}
+	}
+	labels := map[string]string{
+		"app.kubernetes.io/name":      name,
+		"app.kubernetes.io/part-of":   "argocd-agent",
+		"app.kubernetes.io/component": "agent",
+	}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount, Namespace: namespace}}
+	return []runtime.Object{
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Rules:      Rules(namespaced),
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			Subjects:   subjects,
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Rules:      Rules(clusterWide),
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+			Subjects:   subjects,
+		},
+	}
+}
+
+// ManifestsYAML returns the manifests of Manifests as a multi-document YAML
+func ManifestsYAML(name, namespace, serviceAccount string, features ...Feature) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range Manifests(name, namespace, serviceAccount, features...) {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package rbac knows the Kubernetes permissions the agent needs for each of its
+features. It checks the permissions the agent actually has against them, and
+generates minimal RBAC manifests for a set of features.
+*/
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Feature is a feature of the agent that requires permissions
+type Feature string
+
+const (
+	// FeatureCore is required by every agent
+	FeatureCore Feature = "core"
+	// FeatureCreateNamespace lets the agent create the namespaces of
+	// Applications
+	FeatureCreateNamespace Feature = "create-namespace"
+	// FeatureDestinationMapping lets the agent manage Applications outside
+	// of its namespace
+	FeatureDestinationMapping Feature = "destination-mapping"
+	// FeatureResourceProxy lets Argo CD read live resources
+	FeatureResourceProxy Feature = "resource-proxy"
+	// FeatureResourceActions lets Argo CD modify live resources, e.g. to run
+	// resource actions
+	FeatureResourceActions Feature = "resource-actions"
+	// FeatureLogs lets Argo CD read container logs
+	FeatureLogs Feature = "logs"
+	// FeatureTerminal lets Argo CD open web terminals
+	FeatureTerminal Feature = "terminal"
+)
+
+// Features lists all known features
+var Features = []Feature{
+	FeatureCore,
+	FeatureCreateNamespace,
+	FeatureDestinationMapping,
+	FeatureResourceProxy,
+	FeatureResourceActions,
+	FeatureLogs,
+	FeatureTerminal,
+}
+
+// ParseFeatures parses a list of feature names. The core feature is always
+// included.
+func ParseFeatures(names []string) ([]Feature, error) {
+	features := []Feature{FeatureCore}
+	for _, n := range names {
+		f := Feature(strings.TrimSpace(n))
+		if f == FeatureCore || f == "" {
+			continue
+		}
+		if _, ok := featurePermissions[f]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", n)
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+// Permission is the permission to use a verb on a resource. Permissions
+// that are not cluster-wide are needed in the agent's namespace only.
+type Permission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+	ClusterWide bool
+}
+
+func (p Permission) String() string {
+	res := p.Resource
+	if p.Subresource != "" {
+		res += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		res += "." + p.Group
+	}
+	scope := "in agent namespace"
+	if p.ClusterWide {
+		scope = "cluster-wide"
+	}
+	return fmt.Sprintf("%s %s %s", p.Verb, res, scope)
+}
+
+// covers returns whether p grants the permission q
+func (p Permission) covers(q Permission) bool {
+	if q.ClusterWide && !p.ClusterWide {
+		return false
+	}
+	return matches(p.Group, q.Group) && matches(p.Resource, q.Resource) &&
+		matches(p.Subresource, q.Subresource) && matches(p.Verb, q.Verb)
+}
+
+func matches(granted, wanted string) bool {
+	return granted == "*" || granted == wanted
+}
+
+var managementVerbs = []string{"create", "get", "list", "watch", "update", "patch", "delete"}
+
+func permissions(group string, resources []string, verbs []string, clusterWide bool) []Permission {
+	var perms []Permission
+	for _, r := range resources {
+		resource, subresource, _ := strings.Cut(r, "/")
+		for _, v := range verbs {
+			perms = append(perms, Permission{Group: group, Resource: resource, Subresource: subresource, Verb: v, ClusterWide: clusterWide})
+		}
+	}
+	return perms
+}
+
+func concat(lists ...[]Permission) []Permission {
+	var perms []Permission
+	for _, l := range lists {
+		perms = append(perms, l...)
+	}
+	return perms
+}
+
+var featurePermissions = map[Feature][]Permission{
+	FeatureCore: concat(
+		permissions("argoproj.io", []string{"applications", "appprojects", "applicationsets"}, managementVerbs, false),
+		permissions("", []string{"secrets", "configmaps"}, managementVerbs, false),
+		permissions("", []string{"events"}, []string{"create", "list"}, false),
+		permissions("", []string{"namespaces"}, []string{"get", "list", "watch"}, true),
+	),
+	FeatureCreateNamespace:    permissions("", []string{"namespaces"}, []string{"create"}, true),
+	FeatureDestinationMapping: permissions("argoproj.io", []string{"applications"}, managementVerbs, true),
+	FeatureResourceProxy:      permissions("*", []string{"*"}, []string{"get", "list"}, true),
+	FeatureResourceActions:    permissions("*", []string{"*"}, []string{"create", "update", "patch", "delete"}, true),
+	FeatureLogs:               permissions("", []string{"pods", "pods/log"}, []string{"get"}, true),
+	FeatureTerminal: concat(
+		permissions("", []string{"pods"}, []string{"get"}, true),
+		permissions("", []string{"pods/exec"}, []string{"create", "get"}, true),
+	),
+}
+
+// Required returns the permissions needed for the given features, without
+// duplicates.
+func Required(features ...Feature) []Permission {
+	seen := make(map[Permission]bool)
+	var perms []Permission
+	for _, f := range append([]Feature{FeatureCore}, features...) {
+		for _, p := range featurePermissions[f] {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// Strings returns the descriptions of perms, sorted
+func Strings(perms []Permission) []string {
+	s := make([]string, 0, len(perms))
+	for _, p := range perms {
+		s = append(s, p.String())
+	}
+	sort.Strings(s)
+	return s
+}
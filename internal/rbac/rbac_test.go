@@ -0,0 +1,129 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+// fakeAuthorizer answers access and rules reviews from a list of rules, as
+// if they were granted cluster-wide
+func fakeAuthorizer(rules []rbacv1.PolicyRule) *fake.Clientset {
+	client := fake.NewClientset()
+	var granted []Permission
+	for _, r := range rules {
+		for _, g := range r.APIGroups {
+			granted = append(granted, permissions(g, r.Resources, r.Verbs, true)...)
+		}
+	}
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		review := action.(kubetesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		want := Permission{Group: attrs.Group, Resource: attrs.Resource, Subresource: attrs.Subresource, Verb: attrs.Verb, ClusterWide: attrs.Namespace == ""}
+		for _, g := range granted {
+			if g.covers(want) {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "selfsubjectrulesreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		review := action.(kubetesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+		review.Status.ResourceRules = append(review.Status.ResourceRules, authorizationv1.ResourceRule{
+			APIGroups: []string{"authorization.k8s.io"},
+			Resources: []string{"selfsubjectaccessreviews", "selfsubjectrulesreviews"},
+			Verbs:     []string{"create"},
+		})
+		for _, r := range rules {
+			review.Status.ResourceRules = append(review.Status.ResourceRules, authorizationv1.ResourceRule{
+				APIGroups: r.APIGroups,
+				Resources: r.Resources,
+				Verbs:     r.Verbs,
+			})
+		}
+		return true, review, nil
+	})
+	return client
+}
+
+func Test_Required(t *testing.T) {
+	core := Required()
+	logs := Required(FeatureLogs, FeatureTerminal, FeatureLogs)
+	assert.Contains(t, logs, Permission{Resource: "pods", Subresource: "log", Verb: "get", ClusterWide: true})
+	assert.Contains(t, logs, Permission{Resource: "pods", Subresource: "exec", Verb: "create", ClusterWide: true})
+	// pods get is needed by both features, but listed once
+	assert.Len(t, logs, len(core)+4)
+}
+
+func Test_ParseFeatures(t *testing.T) {
+	features, err := ParseFeatures([]string{"logs", " terminal", "core"})
+	require.NoError(t, err)
+	assert.Equal(t, []Feature{FeatureCore, FeatureLogs, FeatureTerminal}, features)
+	_, err = ParseFeatures([]string{"logs", "everything"})
+	assert.ErrorContains(t, err, "everything")
+}
+
+func Test_Check(t *testing.T) {
+	required := Required(FeatureLogs)
+	rules := func(features ...Feature) []rbacv1.PolicyRule {
+		return Rules(Required(features...))
+	}
+
+	t.Run("Exact permissions", func(t *testing.T) {
+		report, err := Check(context.Background(), fakeAuthorizer(rules(FeatureLogs)), "argocd", required)
+		require.NoError(t, err)
+		assert.True(t, report.OK())
+	})
+
+	t.Run("Missing permissions", func(t *testing.T) {
+		report, err := Check(context.Background(), fakeAuthorizer(rules()), "argocd", required)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"get pods cluster-wide", "get pods/log cluster-wide"}, Strings(report.Missing))
+		assert.Empty(t, report.Excess)
+	})
+
+	t.Run("Excess permissions", func(t *testing.T) {
+		granted := append(rules(FeatureLogs), rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"delete"}})
+		report, err := Check(context.Background(), fakeAuthorizer(granted), "argocd", required)
+		require.NoError(t, err)
+		assert.Empty(t, report.Missing)
+		assert.Equal(t, []string{"delete *.* in agent namespace"}, Strings(report.Excess))
+	})
+}
+
+func Test_Manifests(t *testing.T) {
+	objs := Manifests("agent", "argocd", "agent-sa", FeatureLogs)
+	require.Len(t, objs, 4)
+	role := objs[0].(*rbacv1.Role)
+	assert.Equal(t, "argocd", role.Namespace)
+	clusterRole := objs[2].(*rbacv1.ClusterRole)
+	assert.Contains(t, clusterRole.Rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get"}})
+	binding := objs[3].(*rbacv1.ClusterRoleBinding)
+	assert.Equal(t, "agent-sa", binding.Subjects[0].Name)
+	assert.Equal(t, "agent", binding.RoleRef.Name)
+
+	data, err := ManifestsYAML("agent", "argocd", "agent-sa", FeatureLogs)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "kind: ClusterRoleBinding")
+}
@@ -0,0 +1,304 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DefaultBookmarkMaxAge is the default time after which bookmarks are no
+// longer used for incremental resyncs, and a full resync is done instead
+const DefaultBookmarkMaxAge = 24 * time.Hour
+
+// Bookmark is the state of a resource when it was last synced with the
+// source of truth
+type Bookmark struct {
+	// ResourceVersion is the local resourceVersion of the resource, empty if
+	// unknown
+	ResourceVersion string
+	// SourceResourceVersion is the resourceVersion of the resource on the
+	// source of truth, empty if unknown
+	SourceResourceVersion string
+	// Checksum is the spec checksum of the local resource
+	Checksum []byte
+}
+
+// Bookmarks records, for each peer, the state of the resources synced with
+// it. When the peer asks for a resync after a restart, the bookmarks let it
+// skip the resources that did not change on either side since.
+//
+// Bookmarks of a peer are established by a full resync. Once they are older
+// than the maximum age, they are no longer used, so that whatever they missed
+// is caught by a full resync.
+type Bookmarks struct {
+	mu     sync.Mutex
+	maxAge time.Duration
+	peers  map[string]*peerBookmarks
+	now    func() time.Time
+}
+
+type peerBookmarks struct {
+	// since is when the bookmarks were established
+	since     time.Time
+	resources map[resources.ResourceKey]Bookmark
+}
+
+// NewBookmarks returns bookmarks that are used for incremental resyncs for
+// up to maxAge.
+func NewBookmarks(maxAge time.Duration) *Bookmarks {
+	return &Bookmarks{
+		maxAge: maxAge,
+		peers:  make(map[string]*peerBookmarks),
+		now:    time.Now,
+	}
+}
+
+// Usable returns whether the bookmarks of peer may be used for an incremental
+// resync. A nil Bookmarks is never usable.
+func (b *Bookmarks) Usable(peer string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.peers[peer]
+	return ok && len(p.resources) > 0 && b.now().Sub(p.since) < b.maxAge
+}
+
+// Reset discards the bookmarks of peer, before a full resync establishes new
+// ones.
+func (b *Bookmarks) Reset(peer string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.peers[peer] = &peerBookmarks{since: b.now(), resources: make(map[resources.ResourceKey]Bookmark)}
+}
+
+// Get returns the bookmark of the resource key synced with peer
+func (b *Bookmarks) Get(peer string, key resources.ResourceKey) (Bookmark, bool) {
+	if b == nil {
+		return Bookmark{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.peers[peer]
+	if !ok {
+		return Bookmark{}, false
+	}
+	bm, ok := p.resources[key]
+	return bm, ok
+}
+
+// Record records bm as the bookmark of the resource key synced with peer
+func (b *Bookmarks) Record(peer string, key resources.ResourceKey, bm Bookmark) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.peers[peer]
+	if !ok {
+		p = &peerBookmarks{since: b.now(), resources: make(map[resources.ResourceKey]Bookmark)}
+		b.peers[peer] = p
+	}
+	p.resources[key] = bm
+}
+
+// RecordApplication records the bookmark of app after it was received from
+// peer, the source of truth, where it has sourceResourceVersion. app is the
+// Application as it was applied locally, and resourceVersion its local
+// resourceVersion, if known.
+func (b *Bookmarks) RecordApplication(peer string, app *v1alpha1.Application, resourceVersion, sourceResourceVersion string) {
+	if b == nil || app == nil {
+		return
+	}
+	key := resources.NewResourceKeyFromApp(app)
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(app)
+	if err != nil {
+		b.Forget(peer, key)
+		return
+	}
+	checksum, err := generateSpecChecksum(&unstructured.Unstructured{Object: obj})
+	if err != nil {
+		b.Forget(peer, key)
+		return
+	}
+	b.Record(peer, key, Bookmark{
+		ResourceVersion:       resourceVersion,
+		SourceResourceVersion: sourceResourceVersion,
+		Checksum:              checksum,
+	})
+}
+
+// Forget removes the bookmark of the resource key synced with peer
+func (b *Bookmarks) Forget(peer string, key resources.ResourceKey) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p, ok := b.peers[peer]; ok {
+		delete(p.resources, key)
+	}
+}
+
+// refresh records the current state of the local resource key, whose spec
+// checksum is checksum, and returns the source's resourceVersion from its
+// bookmark if the resource did not change since it was last synced.
+func (b *Bookmarks) refresh(peer string, key resources.ResourceKey, res *unstructured.Unstructured, checksum []byte) string {
+	if b == nil {
+		return ""
+	}
+	bm, ok := b.Get(peer, key)
+	sourceResourceVersion := ""
+	if ok && (bm.ResourceVersion == res.GetResourceVersion() || bytes.Equal(bm.Checksum, checksum)) {
+		sourceResourceVersion = bm.SourceResourceVersion
+	}
+	b.Record(peer, key, Bookmark{
+		ResourceVersion:       res.GetResourceVersion(),
+		SourceResourceVersion: sourceResourceVersion,
+		Checksum:              checksum,
+	})
+	return sourceResourceVersion
+}
+
+// bookmarksFileVersion is the version of the format in which bookmarks are
+// persisted. Files of other versions are not loaded.
+const bookmarksFileVersion = 1
+
+type bookmarksFile struct {
+	Version int                               `json:"version"`
+	Peers   map[string]persistedPeerBookmarks `json:"peers"`
+}
+
+type persistedPeerBookmarks struct {
+	Since     time.Time           `json:"since"`
+	Resources []persistedBookmark `json:"resources"`
+}
+
+type persistedBookmark struct {
+	Name                  string `json:"name"`
+	Namespace             string `json:"namespace,omitempty"`
+	Kind                  string `json:"kind"`
+	UID                   string `json:"uid"`
+	ResourceVersion       string `json:"resourceVersion,omitempty"`
+	SourceResourceVersion string `json:"sourceResourceVersion,omitempty"`
+	Checksum              []byte `json:"checksum,omitempty"`
+}
+
+// Save persists the bookmarks to the file at path, so that they survive a
+// restart. The file is replaced atomically.
+func (b *Bookmarks) Save(path string) error {
+	if b == nil {
+		return nil
+	}
+	f := bookmarksFile{Version: bookmarksFileVersion, Peers: map[string]persistedPeerBookmarks{}}
+	b.mu.Lock()
+	for peer, p := range b.peers {
+		pp := persistedPeerBookmarks{Since: p.since, Resources: make([]persistedBookmark, 0, len(p.resources))}
+		for key, bm := range p.resources {
+			pp.Resources = append(pp.Resources, persistedBookmark{
+				Name:                  key.Name,
+				Namespace:             key.Namespace,
+				Kind:                  key.Kind,
+				UID:                   key.UID,
+				ResourceVersion:       bm.ResourceVersion,
+				SourceResourceVersion: bm.SourceResourceVersion,
+				Checksum:              bm.Checksum,
+			})
+		}
+		f.Peers[peer] = pp
+	}
+	b.mu.Unlock()
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("could not marshal bookmarks: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not create bookmarks file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write bookmarks file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write bookmarks file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace bookmarks file: %w", err)
+	}
+	return nil
+}
+
+// Load replaces the bookmarks with those persisted to the file at path, and
+// returns the number of bookmarks loaded. A missing file is not an error, and
+// loads nothing. Loaded bookmarks keep the time they were established at, so
+// that they expire as if there had been no restart.
+func (b *Bookmarks) Load(path string) (int, error) {
+	if b == nil {
+		return 0, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("could not read bookmarks file: %w", err)
+	}
+	f := bookmarksFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return 0, fmt.Errorf("could not unmarshal bookmarks file: %w", err)
+	}
+	if f.Version != bookmarksFileVersion {
+		return 0, fmt.Errorf("unsupported bookmarks file version %d", f.Version)
+	}
+
+	n := 0
+	peers := make(map[string]*peerBookmarks, len(f.Peers))
+	for peer, pp := range f.Peers {
+		p := &peerBookmarks{since: pp.Since, resources: make(map[resources.ResourceKey]Bookmark, len(pp.Resources))}
+		for _, bm := range pp.Resources {
+			key := resources.ResourceKey{Name: bm.Name, Namespace: bm.Namespace, Kind: bm.Kind, UID: bm.UID}
+			p.resources[key] = Bookmark{
+				ResourceVersion:       bm.ResourceVersion,
+				SourceResourceVersion: bm.SourceResourceVersion,
+				Checksum:              bm.Checksum,
+			}
+		}
+		n += len(p.resources)
+		peers[peer] = p
+	}
+	b.mu.Lock()
+	b.peers = peers
+	b.mu.Unlock()
+	return n, nil
+}
@@ -0,0 +1,339 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/queue"
+	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func Test_Bookmarks(t *testing.T) {
+	key := resources.ResourceKey{Name: "test-app", Namespace: "default", Kind: "Application", UID: "source-uid"}
+
+	t.Run("Nil bookmarks are never usable", func(t *testing.T) {
+		var b *Bookmarks
+		assert.False(t, b.Usable("principal"))
+		b.Record("principal", key, Bookmark{ResourceVersion: "1"})
+		_, ok := b.Get("principal", key)
+		assert.False(t, ok)
+	})
+
+	t.Run("Bookmarks are usable until they are too old", func(t *testing.T) {
+		now := time.Now()
+		b := NewBookmarks(time.Hour)
+		b.now = func() time.Time { return now }
+		assert.False(t, b.Usable("principal"))
+
+		b.Record("principal", key, Bookmark{ResourceVersion: "1", SourceResourceVersion: "10"})
+		assert.True(t, b.Usable("principal"))
+		assert.False(t, b.Usable("other"))
+
+		now = now.Add(time.Hour)
+		assert.False(t, b.Usable("principal"))
+
+		// A full resync establishes new bookmarks
+		b.Reset("principal")
+		assert.False(t, b.Usable("principal"))
+		_, ok := b.Get("principal", key)
+		assert.False(t, ok)
+		b.Record("principal", key, Bookmark{ResourceVersion: "2"})
+		assert.True(t, b.Usable("principal"))
+	})
+
+	t.Run("Applications are recorded with their spec checksum", func(t *testing.T) {
+		b := NewBookmarks(time.Hour)
+		app := &v1alpha1.Application{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        "test-app",
+				Namespace:   "default",
+				Annotations: map[string]string{manager.SourceUIDAnnotation: "source-uid"},
+			},
+			Spec: v1alpha1.ApplicationSpec{Project: "default"},
+		}
+		b.RecordApplication("principal", app, "", "10")
+
+		bm, ok := b.Get("principal", key)
+		require.True(t, ok)
+		assert.Empty(t, bm.ResourceVersion)
+		assert.Equal(t, "10", bm.SourceResourceVersion)
+
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(app)
+		require.NoError(t, err)
+		checksum, err := generateSpecChecksum(&unstructured.Unstructured{Object: obj})
+		require.NoError(t, err)
+		assert.Equal(t, checksum, bm.Checksum)
+	})
+
+	t.Run("Source resourceVersion is kept while the resource is unchanged", func(t *testing.T) {
+		b := NewBookmarks(time.Hour)
+		b.Record("principal", key, Bookmark{ResourceVersion: "1", SourceResourceVersion: "10", Checksum: []byte("a")})
+		res := fakeUnresApp()
+
+		// Same resourceVersion
+		res.SetResourceVersion("1")
+		assert.Equal(t, "10", b.refresh("principal", key, res, []byte("a")))
+
+		// A new resourceVersion with the same spec, e.g. after a status update
+		res.SetResourceVersion("2")
+		assert.Equal(t, "10", b.refresh("principal", key, res, []byte("a")))
+		bm, _ := b.Get("principal", key)
+		assert.Equal(t, "2", bm.ResourceVersion)
+
+		// A changed spec
+		res.SetResourceVersion("3")
+		assert.Empty(t, b.refresh("principal", key, res, []byte("b")))
+		bm, _ = b.Get("principal", key)
+		assert.Empty(t, bm.SourceResourceVersion)
+	})
+}
+
+func Test_IncrementalResync(t *testing.T) {
+	ctx := context.Background()
+	key := resources.ResourceKey{Name: "test-app", Namespace: "default", Kind: "Application", UID: "source-uid"}
+
+	newReplica := func(t *testing.T) (*RequestHandler, *unstructured.Unstructured) {
+		t.Helper()
+		handler := createListingHandler(t, manager.ManagerRoleAgent)
+		handler.WithBookmarks(NewBookmarks(time.Hour), "principal").WithIncrementalResync(true)
+
+		resource := fakeUnresApp()
+		resource.SetAnnotations(map[string]string{manager.SourceUIDAnnotation: "source-uid"})
+		resource.SetResourceVersion("5")
+		_, err := handler.dynClient.Resource(appGVR(t)).Namespace("default").Create(ctx, resource, v1.CreateOptions{})
+		require.NoError(t, err)
+		handler.resources.Add(key)
+		return handler, resource
+	}
+
+	t.Run("Full resync without bookmarks establishes them", func(t *testing.T) {
+		handler, resource := newReplica(t)
+
+		require.NoError(t, handler.ProcessIncomingResourceResyncRequest(ctx, testAgentName))
+		require.Equal(t, 1, handler.sendQ.Len())
+		ev, _ := handler.sendQ.Get()
+		assert.Equal(t, event.EventRequestUpdate.String(), ev.Type())
+
+		bm, ok := handler.bookmarks.Get("principal", key)
+		require.True(t, ok)
+		assert.Equal(t, "5", bm.ResourceVersion)
+		checksum, err := generateSpecChecksum(resource)
+		require.NoError(t, err)
+		assert.Equal(t, checksum, bm.Checksum)
+		assert.True(t, handler.bookmarks.Usable("principal"))
+	})
+
+	t.Run("Unchanged resources are sent with the source's resourceVersion", func(t *testing.T) {
+		handler, resource := newReplica(t)
+		checksum, err := generateSpecChecksum(resource)
+		require.NoError(t, err)
+		handler.bookmarks.Record("principal", key, Bookmark{ResourceVersion: "5", SourceResourceVersion: "10", Checksum: checksum})
+
+		require.NoError(t, handler.ProcessIncomingResourceResyncRequest(ctx, testAgentName))
+		require.Equal(t, 1, handler.sendQ.Len())
+		ev, _ := handler.sendQ.Get()
+		require.Equal(t, event.EventResyncBookmarks.String(), ev.Type())
+		got := &event.ResyncBookmarks{}
+		require.NoError(t, ev.DataAs(got))
+		require.Len(t, got.Resources, 1)
+		assert.Equal(t, event.ResourceBookmark{
+			Name:            "test-app",
+			Namespace:       "default",
+			UID:             "source-uid",
+			Kind:            "Application",
+			Checksum:        checksum,
+			ResourceVersion: "10",
+		}, got.Resources[0])
+	})
+
+	t.Run("Changed resources are sent without resourceVersion", func(t *testing.T) {
+		handler, _ := newReplica(t)
+		handler.bookmarks.Record("principal", key, Bookmark{ResourceVersion: "4", SourceResourceVersion: "10", Checksum: []byte("old")})
+
+		require.NoError(t, handler.ProcessIncomingResourceResyncRequest(ctx, testAgentName))
+		ev, _ := handler.sendQ.Get()
+		require.Equal(t, event.EventResyncBookmarks.String(), ev.Type())
+		got := &event.ResyncBookmarks{}
+		require.NoError(t, ev.DataAs(got))
+		require.Len(t, got.Resources, 1)
+		assert.Empty(t, got.Resources[0].ResourceVersion)
+	})
+
+	t.Run("Full resync if the peer doesn't understand bookmarks", func(t *testing.T) {
+		handler, _ := newReplica(t)
+		handler.WithIncrementalResync(false)
+		handler.bookmarks.Record("principal", key, Bookmark{ResourceVersion: "5", SourceResourceVersion: "10"})
+
+		require.NoError(t, handler.ProcessIncomingResourceResyncRequest(ctx, testAgentName))
+		ev, _ := handler.sendQ.Get()
+		assert.Equal(t, event.EventRequestUpdate.String(), ev.Type())
+	})
+
+	t.Run("Resync on restart sends bookmarks", func(t *testing.T) {
+		handler, _ := newReplica(t)
+		handler.bookmarks.Record("principal", key, Bookmark{ResourceVersion: "5", SourceResourceVersion: "10"})
+
+		handler.SendRequestUpdates(ctx)
+		require.Equal(t, 1, handler.sendQ.Len())
+		ev, _ := handler.sendQ.Get()
+		assert.Equal(t, event.EventResyncBookmarks.String(), ev.Type())
+	})
+}
+
+func Test_BookmarksPersistence(t *testing.T) {
+	key := resources.ResourceKey{Name: "test-app", Namespace: "default", Kind: "Application", UID: "source-uid"}
+
+	t.Run("Bookmarks survive a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bookmarks.json")
+		since := time.Now().Add(-30 * time.Minute)
+		b := NewBookmarks(time.Hour)
+		b.now = func() time.Time { return since }
+		b.Record("principal", key, Bookmark{ResourceVersion: "5", SourceResourceVersion: "10", Checksum: []byte("checksum")})
+		require.NoError(t, b.Save(path))
+
+		loaded := NewBookmarks(time.Hour)
+		n, err := loaded.Load(path)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		bm, ok := loaded.Get("principal", key)
+		require.True(t, ok)
+		assert.Equal(t, Bookmark{ResourceVersion: "5", SourceResourceVersion: "10", Checksum: []byte("checksum")}, bm)
+		assert.True(t, loaded.Usable("principal"))
+
+		// Loaded bookmarks keep their age
+		loaded.now = func() time.Time { return since.Add(time.Hour) }
+		assert.False(t, loaded.Usable("principal"))
+	})
+
+	t.Run("Missing file loads nothing", func(t *testing.T) {
+		b := NewBookmarks(time.Hour)
+		n, err := b.Load(filepath.Join(t.TempDir(), "bookmarks.json"))
+		require.NoError(t, err)
+		assert.Zero(t, n)
+		assert.False(t, b.Usable("principal"))
+	})
+
+	t.Run("Files of other versions are not loaded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bookmarks.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"version":2,"peers":{}}`), 0o600))
+		_, err := NewBookmarks(time.Hour).Load(path)
+		assert.ErrorContains(t, err, "unsupported")
+	})
+
+	t.Run("Saving to a missing directory fails", func(t *testing.T) {
+		b := NewBookmarks(time.Hour)
+		assert.Error(t, b.Save(filepath.Join(t.TempDir(), "missing", "bookmarks.json")))
+	})
+}
+
+func Test_ProcessResyncBookmarks(t *testing.T) {
+	ctx := context.Background()
+	handler := createListingHandler(t, manager.ManagerRolePrincipal)
+
+	resource := fakeUnresApp()
+	resource.SetNamespace(testAgentName)
+	resource.SetResourceVersion("10")
+	_, err := handler.dynClient.Resource(appGVR(t)).Namespace(testAgentName).Create(ctx, resource, v1.CreateOptions{})
+	require.NoError(t, err)
+	checksum, err := generateSpecChecksum(resource)
+	require.NoError(t, err)
+
+	bookmark := func(resourceVersion string, checksum []byte) event.ResourceBookmark {
+		return event.ResourceBookmark{Name: "test-app", Namespace: "default", UID: "test-uid", Kind: "Application", Checksum: checksum, ResourceVersion: resourceVersion}
+	}
+
+	t.Run("Resources unchanged on both sides are skipped", func(t *testing.T) {
+		err := handler.ProcessResyncBookmarks(ctx, testAgentName, &event.ResyncBookmarks{
+			Resources: []event.ResourceBookmark{bookmark("10", []byte("not compared"))},
+		})
+		require.NoError(t, err)
+		assert.Zero(t, handler.sendQ.Len())
+	})
+
+	t.Run("Resources changed on the source are compared", func(t *testing.T) {
+		err := handler.ProcessResyncBookmarks(ctx, testAgentName, &event.ResyncBookmarks{
+			Resources: []event.ResourceBookmark{bookmark("9", checksum)},
+		})
+		require.NoError(t, err)
+		assert.Zero(t, handler.sendQ.Len())
+
+		err = handler.ProcessResyncBookmarks(ctx, testAgentName, &event.ResyncBookmarks{
+			Resources: []event.ResourceBookmark{bookmark("9", []byte("other"))},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, handler.sendQ.Len())
+		ev, _ := handler.sendQ.Get()
+		assert.Equal(t, event.SpecUpdate.String(), ev.Type())
+		handler.sendQ.Done(ev)
+	})
+
+	t.Run("Resources changed on the peer are updated", func(t *testing.T) {
+		err := handler.ProcessResyncBookmarks(ctx, testAgentName, &event.ResyncBookmarks{
+			Resources: []event.ResourceBookmark{bookmark("", []byte("other"))},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, handler.sendQ.Len())
+		ev, _ := handler.sendQ.Get()
+		assert.Equal(t, event.SpecUpdate.String(), ev.Type())
+		handler.sendQ.Done(ev)
+	})
+
+	t.Run("Resources deleted on the source are deleted", func(t *testing.T) {
+		missing := bookmark("10", checksum)
+		missing.Name = "deleted-app"
+		err := handler.ProcessResyncBookmarks(ctx, testAgentName, &event.ResyncBookmarks{
+			Resources: []event.ResourceBookmark{missing},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, handler.sendQ.Len())
+		ev, _ := handler.sendQ.Get()
+		assert.Equal(t, event.Delete.String(), ev.Type())
+	})
+}
+
+// createListingHandler returns a handler with the given role whose dynamic
+// client can list Applications
+func createListingHandler(t *testing.T, role manager.ManagerRole) *RequestHandler {
+	t.Helper()
+	queues := queue.NewSendRecvQueues()
+	require.NoError(t, queues.Create(testAgentName))
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		appGVR(t): "ApplicationList",
+	})
+	return NewRequestHandler(dynClient, queues.SendQ(testAgentName), event.NewEventSource("test"), resources.NewResources(), logrus.NewEntry(logrus.New()), role, "argocd")
+}
+
+func appGVR(t *testing.T) schema.GroupVersionResource {
+	t.Helper()
+	gvr, err := getGroupVersionResource("Application")
+	require.NoError(t, err)
+	return gvr
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+)
+
+const (
+	// maxDriftedFields is the maximum number of fields reported for a single
+	// Application
+	maxDriftedFields = 50
+	// maxDriftedValueLength is the maximum length of a reported value
+	maxDriftedValueLength = 256
+)
+
+// SpecForComparison returns the spec of app as it is compared between
+// principal and agent. The destination is rewritten by the receiving side,
+// just as for NewApplicationChecksum, and is not compared.
+func SpecForComparison(app *v1alpha1.Application) v1alpha1.ApplicationSpec {
+	spec := app.Spec.DeepCopy()
+	spec.Destination = v1alpha1.ApplicationDestination{}
+	return *spec
+}
+
+// DiffSpecs returns the fields whose values differ between the principal's
+// spec hub and the agent's spec spoke, ordered by path. At most
+// maxDriftedFields are returned, and long values are truncated.
+func DiffSpecs(hub, spoke v1alpha1.ApplicationSpec) []event.DriftedField {
+	hubFields := flattenSpec(hub)
+	spokeFields := flattenSpec(spoke)
+	diffs := []event.DriftedField{}
+	for path, hv := range hubFields {
+		if sv := spokeFields[path]; sv != hv {
+			diffs = append(diffs, event.DriftedField{Path: path, Hub: truncateValue(hv), Spoke: truncateValue(sv)})
+		}
+	}
+	for path, sv := range spokeFields {
+		if _, ok := hubFields[path]; !ok {
+			diffs = append(diffs, event.DriftedField{Path: path, Spoke: truncateValue(sv)})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	if len(diffs) > maxDriftedFields {
+		diffs = diffs[:maxDriftedFields]
+	}
+	return diffs
+}
+
+// flattenSpec returns the JSON encoded values of the leaves of spec, keyed
+// by their path
+func flattenSpec(spec v1alpha1.ApplicationSpec) map[string]string {
+	fields := map[string]string{}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return fields
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fields
+	}
+	flatten("", v, fields)
+	return fields
+}
+
+func flatten(path string, v any, fields map[string]string) {
+	switch val := v.(type) {
+	case map[string]any:
+		if len(val) == 0 {
+			return
+		}
+		for k, child := range val {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			flatten(p, child, fields)
+		}
+	case []any:
+		if len(val) == 0 {
+			return
+		}
+		for i, child := range val {
+			flatten(path+"["+strconv.Itoa(i)+"]", child, fields)
+		}
+	default:
+		b, _ := json.Marshal(val)
+		fields[path] = string(b)
+	}
+}
+
+func truncateValue(v string) string {
+	if len(v) <= maxDriftedValueLength {
+		return v
+	}
+	return v[:maxDriftedValueLength] + "..."
+}
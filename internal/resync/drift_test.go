@@ -0,0 +1,71 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiffSpecs(t *testing.T) {
+	spec := v1alpha1.ApplicationSpec{
+		Project: "default",
+		Source:  &v1alpha1.ApplicationSource{RepoURL: "https://example.com/repo", TargetRevision: "main"},
+	}
+
+	t.Run("Equal specs have no drift", func(t *testing.T) {
+		assert.Empty(t, DiffSpecs(spec, *spec.DeepCopy()))
+	})
+
+	t.Run("Changed, added and removed fields are reported", func(t *testing.T) {
+		spoke := spec.DeepCopy()
+		spoke.Source.TargetRevision = "v1.0.0"
+		spoke.Source.Path = "guestbook"
+		spoke.Project = ""
+		assert.Equal(t, []event.DriftedField{
+			{Path: "project", Hub: `"default"`, Spoke: `""`},
+			{Path: "source.path", Spoke: `"guestbook"`},
+			{Path: "source.targetRevision", Hub: `"main"`, Spoke: `"v1.0.0"`},
+		}, DiffSpecs(spec, *spoke))
+	})
+
+	t.Run("List elements are compared by index", func(t *testing.T) {
+		hub := spec.DeepCopy()
+		hub.SyncPolicy = &v1alpha1.SyncPolicy{SyncOptions: v1alpha1.SyncOptions{"CreateNamespace=true"}}
+		spoke := hub.DeepCopy()
+		spoke.SyncPolicy.SyncOptions = append(spoke.SyncPolicy.SyncOptions, "PruneLast=true")
+		assert.Equal(t, []event.DriftedField{
+			{Path: "syncPolicy.syncOptions[1]", Spoke: `"PruneLast=true"`},
+		}, DiffSpecs(*hub, *spoke))
+	})
+
+	t.Run("Long values are truncated", func(t *testing.T) {
+		spoke := spec.DeepCopy()
+		spoke.Source.RepoURL = strings.Repeat("x", 2*maxDriftedValueLength)
+		diffs := DiffSpecs(spec, *spoke)
+		assert.Len(t, diffs, 1)
+		assert.Len(t, diffs[0].Spoke, maxDriftedValueLength+3)
+	})
+
+	t.Run("Destination is not compared", func(t *testing.T) {
+		app := &v1alpha1.Application{Spec: spec}
+		app.Spec.Destination = v1alpha1.ApplicationDestination{Name: "in-cluster"}
+		assert.Empty(t, DiffSpecs(spec, SpecForComparison(app)))
+	})
+}
@@ -0,0 +1,222 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	cloudevent "github.com/cloudevents/sdk-go/v2/event"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultBatchSize is the default number of resources sent per batch during
+// an initial sync
+const DefaultBatchSize = 100
+
+// drainPollInterval is how often the send queue is checked while waiting for
+// it to drain between batches
+const drainPollInterval = 50 * time.Millisecond
+
+// Progress is the progress of a paginated sync
+type Progress struct {
+	// Peer is the other side of the sync, i.e. the agent's name on the
+	// principal and "principal" on the agent
+	Peer string
+	// Kind is the kind of the events sent, e.g. RequestUpdate
+	Kind string
+	// Sent is the number of resources sent so far, including those sent
+	// before the sync was interrupted and resumed
+	Sent int
+	// Total is the number of resources to send
+	Total int
+	// Resumed is true if the sync continues an interrupted one
+	Resumed bool
+	// Done is true once all resources were sent
+	Done bool
+	// Duration is the time the sync took since it was started, or resumed.
+	// It is only set once the sync is done.
+	Duration time.Duration
+}
+
+// Pager sends the events of an initial sync in batches. Before sending a
+// batch, it waits for the send queue to drain to below the batch size, so
+// that events of live changes are not stuck behind, or evicted by, the
+// events of the whole inventory.
+//
+// A Pager remembers which resources of a sync it has sent. If the sync is
+// started again before it completed, e.g. after a reconnect, resources that
+// were already sent are skipped, as their events are still queued or were
+// delivered already.
+type Pager struct {
+	ctx        context.Context
+	batchSize  int
+	onProgress func(Progress)
+
+	mu    sync.Mutex
+	syncs map[string]*pagedSync
+}
+
+// pagedSync is the state of a sync of one kind of event to one peer
+type pagedSync struct {
+	// generation is increased when the sync is started again, which stops
+	// a still running previous run
+	generation int
+	sent       map[resources.ResourceKey]bool
+}
+
+// NewPager returns a Pager sending batches of batchSize resources. A
+// batchSize of 0 or less sends all resources at once. onProgress, if not
+// nil, is called after each batch. Batches sent in the background stop when
+// ctx is done.
+func NewPager(ctx context.Context, batchSize int, onProgress func(Progress)) *Pager {
+	return &Pager{
+		ctx:        ctx,
+		batchSize:  batchSize,
+		onProgress: onProgress,
+		syncs:      make(map[string]*pagedSync),
+	}
+}
+
+// Start sends the event of each of keys by calling send, in batches. Errors
+// returned by send are passed to onError, and the resource counts as sent.
+// The first batch is sent before Start returns; any further batches are
+// sent in the background, until all were sent, the Pager's context is done,
+// q shuts down or the sync of the same peer and kind is started again.
+func (p *Pager) Start(peer, kind string, q workqueue.TypedRateLimitingInterface[*cloudevent.Event], keys []resources.ResourceKey, send func(resources.ResourceKey) error, onError func(resources.ResourceKey, error)) {
+	id := peer + "/" + kind
+	started := time.Now()
+	p.mu.Lock()
+	s, resumed := p.syncs[id]
+	if !resumed {
+		s = &pagedSync{sent: make(map[resources.ResourceKey]bool)}
+		p.syncs[id] = s
+	}
+	s.generation++
+	generation := s.generation
+	pending := make([]resources.ResourceKey, 0, len(keys))
+	for _, key := range keys {
+		if !s.sent[key] {
+			pending = append(pending, key)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(keys) == 0 {
+		p.finish(id, generation)
+		return
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		a, b := pending[i], pending[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	progress := Progress{Peer: peer, Kind: kind, Sent: len(keys) - len(pending), Total: len(keys), Resumed: resumed}
+	batchSize := p.batchSize
+	if batchSize <= 0 || batchSize > len(pending) {
+		batchSize = len(pending)
+	}
+
+	// sendBatch sends the next batch and returns false if the sync must stop
+	sendBatch := func() bool {
+		batch := pending[:batchSize]
+		pending = pending[batchSize:]
+		for _, key := range batch {
+			if !p.current(id, generation) {
+				return false
+			}
+			if err := send(key); err != nil && onError != nil {
+				onError(key, err)
+			}
+			p.mu.Lock()
+			s.sent[key] = true
+			p.mu.Unlock()
+			progress.Sent++
+		}
+		if len(pending) < batchSize {
+			batchSize = len(pending)
+		}
+		progress.Done = len(pending) == 0
+		if progress.Done {
+			progress.Duration = time.Since(started)
+			p.finish(id, generation)
+		}
+		if p.onProgress != nil {
+			p.onProgress(progress)
+		}
+		return !progress.Done
+	}
+
+	if !sendBatch() {
+		return
+	}
+	go func() {
+		threshold := p.batchSize
+		for p.waitForDrain(q, threshold, id, generation) && sendBatch() {
+		}
+	}()
+}
+
+// waitForDrain waits until q holds fewer than threshold items. It returns
+// false if the sync must stop instead.
+func (p *Pager) waitForDrain(q workqueue.TypedRateLimitingInterface[*cloudevent.Event], threshold int, id string, generation int) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		if q.ShuttingDown() {
+			// The queued events are lost, so the sync can't be resumed
+			p.finish(id, generation)
+			return false
+		}
+		if !p.current(id, generation) {
+			return false
+		}
+		if q.Len() < threshold {
+			return true
+		}
+		select {
+		case <-p.ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// current returns whether generation is the current run of the sync id
+func (p *Pager) current(id string, generation int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.syncs[id]
+	return ok && s.generation == generation
+}
+
+// finish forgets the sync id after its run of generation completed
+func (p *Pager) finish(id string, generation int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.syncs[id]; ok && s.generation == generation {
+		delete(p.syncs, id)
+	}
+}
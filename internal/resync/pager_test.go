@@ -0,0 +1,209 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/resources"
+	cloudevent "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// pagerRecorder records the resources sent and the progress reported by a
+// Pager, queueing one event per resource sent
+type pagerRecorder struct {
+	mu       sync.Mutex
+	q        workqueue.TypedRateLimitingInterface[*cloudevent.Event]
+	sent     []resources.ResourceKey
+	progress []Progress
+}
+
+func newPagerRecorder() *pagerRecorder {
+	return &pagerRecorder{
+		q: workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[*cloudevent.Event]()),
+	}
+}
+
+func (r *pagerRecorder) send(key resources.ResourceKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, key)
+	ev := cloudevent.New()
+	ev.SetID(key.Name)
+	r.q.Add(&ev)
+	return nil
+}
+
+func (r *pagerRecorder) onProgress(p Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = append(r.progress, p)
+}
+
+func (r *pagerRecorder) sentCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sent)
+}
+
+func (r *pagerRecorder) lastProgress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress[len(r.progress)-1]
+}
+
+// drain removes all events from the queue
+func (r *pagerRecorder) drain() {
+	for r.q.Len() > 0 {
+		ev, _ := r.q.Get()
+		r.q.Done(ev)
+	}
+}
+
+func pagerTestKeys(n int) []resources.ResourceKey {
+	keys := make([]resources.ResourceKey, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		keys = append(keys, resources.ResourceKey{Kind: "Application", Namespace: "argocd", Name: fmt.Sprintf("app-%02d", i)})
+	}
+	return keys
+}
+
+func Test_PagerSendsBatches(t *testing.T) {
+	t.Run("Next batch is sent once the queue drained", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := newPagerRecorder()
+		p := NewPager(ctx, 2, r.onProgress)
+
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		require.Equal(t, 2, r.sentCount())
+		assert.Equal(t, Progress{Peer: "agent", Kind: "RequestUpdate", Sent: 2, Total: 5}, r.lastProgress())
+
+		// The queue holds a full batch, so the next one must wait
+		time.Sleep(3 * drainPollInterval)
+		assert.Equal(t, 2, r.sentCount())
+
+		r.drain()
+		require.Eventually(t, func() bool { return r.sentCount() == 4 }, time.Second, 10*time.Millisecond)
+		r.drain()
+		require.Eventually(t, func() bool { return r.sentCount() == 5 }, time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool { return r.lastProgress().Done }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 5, r.lastProgress().Sent)
+		assert.Positive(t, r.lastProgress().Duration)
+
+		// Resources are sent in a stable order
+		assert.Equal(t, "app-00", r.sent[0].Name)
+		assert.Equal(t, "app-04", r.sent[4].Name)
+	})
+
+	t.Run("Batch size of 0 sends everything at once", func(t *testing.T) {
+		r := newPagerRecorder()
+		p := NewPager(context.Background(), 0, r.onProgress)
+
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		assert.Equal(t, 5, r.sentCount())
+		assert.Len(t, r.progress, 1)
+		assert.True(t, r.lastProgress().Done)
+	})
+
+	t.Run("Send errors are reported", func(t *testing.T) {
+		r := newPagerRecorder()
+		p := NewPager(context.Background(), 10, nil)
+		var failed []string
+		send := func(key resources.ResourceKey) error {
+			return errors.New("failed")
+		}
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(2), send, func(key resources.ResourceKey, err error) {
+			failed = append(failed, key.Name)
+		})
+		assert.Equal(t, []string{"app-00", "app-01"}, failed)
+	})
+
+	t.Run("Nothing to send", func(t *testing.T) {
+		r := newPagerRecorder()
+		p := NewPager(context.Background(), 10, r.onProgress)
+		p.Start("agent", "RequestUpdate", r.q, nil, r.send, nil)
+		assert.Equal(t, 0, r.sentCount())
+		assert.Empty(t, r.progress)
+	})
+}
+
+func Test_PagerResumes(t *testing.T) {
+	t.Run("Restarted sync skips resources already sent", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := newPagerRecorder()
+		p := NewPager(ctx, 2, r.onProgress)
+
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		require.Equal(t, 2, r.sentCount())
+
+		// Restarting stops the first run and continues where it stopped
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		require.Equal(t, 4, r.sentCount())
+		assert.Equal(t, Progress{Peer: "agent", Kind: "RequestUpdate", Sent: 4, Total: 5, Resumed: true}, r.lastProgress())
+
+		r.drain()
+		require.Eventually(t, func() bool { return r.lastProgress().Done }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 5, r.sentCount())
+		names := map[string]bool{}
+		for _, key := range r.sent {
+			assert.False(t, names[key.Name], "%s sent twice", key.Name)
+			names[key.Name] = true
+		}
+
+		// A completed sync starts over
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		assert.Equal(t, 7, r.sentCount())
+		assert.False(t, r.lastProgress().Resumed)
+	})
+
+	t.Run("Syncs of different peers are independent", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := newPagerRecorder()
+		p := NewPager(ctx, 2, r.onProgress)
+
+		p.Start("agent-a", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		p.Start("agent-b", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		assert.Equal(t, 4, r.sentCount())
+		assert.False(t, r.lastProgress().Resumed)
+	})
+
+	t.Run("Sync starts over after the queue shut down", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := newPagerRecorder()
+		p := NewPager(ctx, 2, r.onProgress)
+
+		p.Start("agent", "RequestUpdate", r.q, pagerTestKeys(5), r.send, nil)
+		require.Equal(t, 2, r.sentCount())
+		r.q.ShutDown()
+		require.Eventually(t, func() bool { return !p.current("agent/RequestUpdate", 1) }, time.Second, 10*time.Millisecond)
+
+		r2 := newPagerRecorder()
+		p.Start("agent", "RequestUpdate", r2.q, pagerTestKeys(5), r2.send, nil)
+		assert.Equal(t, 2, r2.sentCount())
+		assert.Equal(t, "app-00", r2.sent[0].Name)
+	})
+}
@@ -73,6 +73,21 @@ type RequestHandler struct {
 	// principalUID is stamped on outgoing events so that agents can detect
 	// principal transitions during resync after a failover.
 	principalUID string
+
+	// appFilter, if set, transforms Applications before they are sent
+	appFilter func(*v1alpha1.Application) *v1alpha1.Application
+
+	// pager, if set, sends the events of syncs of all resources in batches
+	pager *Pager
+	// peer identifies the other side of the sync for the pager and the
+	// bookmarks
+	peer string
+
+	// bookmarks, if set, records the state of resources synced with the peer
+	bookmarks *Bookmarks
+	// incremental indicates that the peer understands bookmarks, so that
+	// resyncs with it may be incremental
+	incremental bool
 }
 
 func NewRequestHandler(dynClient dynamic.Interface, queue workqueue.TypedRateLimitingInterface[*cloudevent.Event], events *event.EventSource, resources *resources.Resources, log *logrus.Entry, role manager.ManagerRole, namespace string) *RequestHandler {
@@ -95,6 +110,38 @@ func (r *RequestHandler) WithPrincipalUID(uid string) *RequestHandler {
 	return r
 }
 
+// WithApplicationFilter sets a function Applications are passed through
+// before they are sent, e.g. to leave out part of their status.
+func (r *RequestHandler) WithApplicationFilter(filter func(*v1alpha1.Application) *v1alpha1.Application) *RequestHandler {
+	r.appFilter = filter
+	return r
+}
+
+// WithPager makes the handler send the events of syncs of all resources to
+// peer in batches with pager, instead of all at once.
+func (r *RequestHandler) WithPager(pager *Pager, peer string) *RequestHandler {
+	r.pager = pager
+	r.peer = peer
+	return r
+}
+
+// WithBookmarks makes the handler record bookmarks of the resources it syncs
+// with peer.
+func (r *RequestHandler) WithBookmarks(bookmarks *Bookmarks, peer string) *RequestHandler {
+	r.bookmarks = bookmarks
+	r.peer = peer
+	return r
+}
+
+// WithIncrementalResync sets whether the peer understands bookmarks. If it
+// does, resyncs requested by the peer, as well as those started with
+// SendRequestUpdates, only cover the resources that changed since they were
+// last synced, as long as the bookmarks are recent enough.
+func (r *RequestHandler) WithIncrementalResync(enabled bool) *RequestHandler {
+	r.incremental = enabled
+	return r
+}
+
 // WithDestinationBasedMapping sets whether destination-based mapping is enabled.
 // When enabled, the handler will use the namespace from requests instead of
 // assuming the agent name equals the namespace for Applications.
@@ -130,8 +177,7 @@ func (r *RequestHandler) ProcessSyncedResourceListRequest(agentName string, req
 
 	r.log.Info("Agent and Principal checksums don't match, sending synced resource event for each resource")
 
-	resources := r.resources.GetAll()
-	for _, res := range resources {
+	send := func(res resources.ResourceKey) error {
 		ev, err := r.events.SyncedResourceEvent(res)
 		if err != nil {
 			return fmt.Errorf("failed to create synced resource event: %w", err)
@@ -139,6 +185,20 @@ func (r *RequestHandler) ProcessSyncedResourceListRequest(agentName string, req
 
 		r.log.WithField(logfields.Name, res.Name).WithField(logfields.Kind, res.Kind).Trace("Sent synced resource event")
 		r.sendQ.Add(ev)
+		return nil
+	}
+	if r.pager != nil {
+		r.pager.Start(r.peer, event.ResponseSyncedResource.String(), r.sendQ, r.resources.GetAll(), send, func(res resources.ResourceKey, err error) {
+			logCtxForResourceKey(r.log, res).WithError(err).Error("Failed to send synced resource")
+		})
+		return nil
+	}
+
+	resources := r.resources.GetAll()
+	for _, res := range resources {
+		if err := send(res); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -196,6 +256,16 @@ func (r *RequestHandler) ProcessIncomingSyncedResource(ctx context.Context, inco
 func (r *RequestHandler) ProcessIncomingResourceResyncRequest(ctx context.Context, queueID string) error {
 	r.log.Trace("Received a request for resource resync")
 
+	if r.incremental && r.bookmarks.Usable(r.peer) {
+		return r.sendBookmarks(ctx)
+	}
+	r.bookmarks.Reset(r.peer)
+
+	if r.pager != nil {
+		r.startRequestUpdates(ctx)
+		return nil
+	}
+
 	resources := r.resources.GetAll()
 	for _, resource := range resources {
 		logCtx := logCtxForResourceKey(r.log, resource)
@@ -210,6 +280,20 @@ func (r *RequestHandler) ProcessIncomingResourceResyncRequest(ctx context.Contex
 }
 
 func (r *RequestHandler) SendRequestUpdates(ctx context.Context) {
+	if r.incremental && r.bookmarks.Usable(r.peer) {
+		err := r.sendBookmarks(ctx)
+		if err == nil {
+			return
+		}
+		r.log.WithError(err).Warn("Could not send bookmarks, falling back to a full resync")
+	}
+	r.bookmarks.Reset(r.peer)
+
+	if r.pager != nil {
+		r.startRequestUpdates(ctx)
+		return
+	}
+
 	resources := r.resources.GetAll()
 	for _, resource := range resources {
 		logCtx := logCtxForResourceKey(r.log, resource)
@@ -220,6 +304,16 @@ func (r *RequestHandler) SendRequestUpdates(ctx context.Context) {
 	}
 }
 
+// startRequestUpdates sends a request update for every resource with the
+// pager
+func (r *RequestHandler) startRequestUpdates(ctx context.Context) {
+	r.pager.Start(r.peer, event.EventRequestUpdate.String(), r.sendQ, r.resources.GetAll(), func(res resources.ResourceKey) error {
+		return r.sendRequestUpdate(ctx, res)
+	}, func(res resources.ResourceKey, err error) {
+		logCtxForResourceKey(r.log, res).WithError(err).Error("Failed to send request update")
+	})
+}
+
 func (r *RequestHandler) sendRequestUpdate(ctx context.Context, resource resources.ResourceKey) error {
 	gvr, err := getGroupVersionResource(resource.Kind)
 	if err != nil {
@@ -240,6 +334,7 @@ func (r *RequestHandler) sendRequestUpdate(ctx context.Context, resource resourc
 		}
 		return fmt.Errorf("failed to construct a request update from resource %s: %w", resource.Name, err)
 	}
+	r.bookmarks.refresh(r.peer, resource, res, reqUpdate.Checksum)
 
 	ev, err := r.events.RequestUpdateEvent(reqUpdate)
 	if err != nil {
@@ -251,6 +346,119 @@ func (r *RequestHandler) sendRequestUpdate(ctx context.Context, resource resourc
 	return nil
 }
 
+// sendBookmarks answers a resync request of the peer with a single event
+// holding the bookmark of every resource, instead of a request update for
+// each of them.
+func (r *RequestHandler) sendBookmarks(ctx context.Context) error {
+	listed := map[string]map[string]*unstructured.Unstructured{}
+	bookmarks := &event.ResyncBookmarks{}
+	unchanged := 0
+	for _, resource := range r.resources.GetAll() {
+		logCtx := logCtxForResourceKey(r.log, resource)
+		objs, err := r.listResources(ctx, listed, resource.Kind, resource.Namespace)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to list resources")
+			continue
+		}
+		res, ok := objs[resource.Name]
+		if !ok {
+			logCtx.Debug("Resource not found, not sending its bookmark")
+			continue
+		}
+		reqUpdate, err := newRequestUpdateFromObject(res, resource.Kind)
+		if err != nil {
+			if errors.Is(err, ErrSourceUIDNotFound) && r.ignoreUnmanagedApps {
+				logCtx.Debug("skipping resource without source UID annotation")
+				continue
+			}
+			logCtx.WithError(err).Error("Failed to construct a bookmark")
+			continue
+		}
+
+		sourceResourceVersion := r.bookmarks.refresh(r.peer, resource, res, reqUpdate.Checksum)
+		if sourceResourceVersion != "" {
+			unchanged++
+		}
+		bookmarks.Resources = append(bookmarks.Resources, event.ResourceBookmark{
+			Name:            reqUpdate.Name,
+			Namespace:       reqUpdate.Namespace,
+			UID:             reqUpdate.UID,
+			Kind:            reqUpdate.Kind,
+			Checksum:        reqUpdate.Checksum,
+			ResourceVersion: sourceResourceVersion,
+		})
+	}
+
+	ev, err := r.events.ResyncBookmarksEvent(bookmarks)
+	if err != nil {
+		return fmt.Errorf("failed to create resync bookmarks event: %w", err)
+	}
+	r.sendQ.Add(ev)
+	r.log.WithFields(logrus.Fields{
+		"resources": len(bookmarks.Resources),
+		"unchanged": unchanged,
+	}).Info("Sent bookmarks for an incremental resync")
+	return nil
+}
+
+// ProcessResyncBookmarks handles the bookmarks the peer sent in response to
+// a resync request. Resources whose resourceVersion matches their bookmark
+// did not change on either side since they were last synced, and are
+// skipped. All others are handled like a request update.
+func (r *RequestHandler) ProcessResyncBookmarks(ctx context.Context, agentName string, bookmarks *event.ResyncBookmarks) error {
+	r.log.Trace("Received bookmarks for an incremental resync")
+
+	listed := map[string]map[string]*unstructured.Unstructured{}
+	unchanged := 0
+	for _, bm := range bookmarks.Resources {
+		reqUpdate := event.NewRequestUpdate(bm.Name, bm.Namespace, bm.Kind, bm.UID, bm.Checksum)
+		logCtx := logCtxForRequestUpdate(r.log, reqUpdate)
+		objs, err := r.listResources(ctx, listed, reqUpdate.Kind, r.requestNamespace(agentName, reqUpdate))
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to list resources")
+			continue
+		}
+		res := objs[reqUpdate.Name]
+		if res != nil && bm.ResourceVersion != "" && bm.ResourceVersion == res.GetResourceVersion() {
+			unchanged++
+			continue
+		}
+		if err := r.reconcileRequestUpdate(ctx, logCtx, agentName, reqUpdate, res); err != nil {
+			logCtx.WithError(err).Error("Failed to process bookmark")
+		}
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"resources": len(bookmarks.Resources),
+		"unchanged": unchanged,
+	}).Info("Processed bookmarks of an incremental resync")
+	return nil
+}
+
+// listResources returns the resources of kind in namespace by name. Lists
+// are kept in listed, so that each kind and namespace is listed only once.
+func (r *RequestHandler) listResources(ctx context.Context, listed map[string]map[string]*unstructured.Unstructured, kind, namespace string) (map[string]*unstructured.Unstructured, error) {
+	id := kind + "/" + namespace
+	if objs, ok := listed[id]; ok {
+		return objs, nil
+	}
+
+	gvr, err := getGroupVersionResource(kind)
+	if err != nil {
+		return nil, err
+	}
+	list, err := r.dynClient.Resource(gvr).Namespace(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	objs := make(map[string]*unstructured.Unstructured, len(list.Items))
+	for i := range list.Items {
+		objs[list.Items[i].GetName()] = &list.Items[i]
+	}
+	listed[id] = objs
+	return objs, nil
+}
+
 func (r *RequestHandler) ProcessRequestUpdateEvent(ctx context.Context, agentName string, reqUpdate *event.RequestUpdate) error {
 	logCtx := logCtxForRequestUpdate(r.log, reqUpdate)
 
@@ -261,6 +469,22 @@ func (r *RequestHandler) ProcessRequestUpdateEvent(ctx context.Context, agentNam
 		return err
 	}
 
+	// Check if the given resource exists locally
+	resClient := r.dynClient.Resource(gvr)
+	res, err := resClient.Namespace(r.requestNamespace(agentName, reqUpdate)).Get(ctx, reqUpdate.Name, v1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		res = nil
+	}
+
+	return r.reconcileRequestUpdate(ctx, logCtx, agentName, reqUpdate, res)
+}
+
+// requestNamespace returns the namespace in which the resource of reqUpdate
+// lives locally.
+func (r *RequestHandler) requestNamespace(agentName string, reqUpdate *event.RequestUpdate) string {
 	// Depending on the role, the namespace of the resource may be different.
 	namespace := r.namespace
 	switch reqUpdate.Kind {
@@ -283,15 +507,14 @@ func (r *RequestHandler) ProcessRequestUpdateEvent(ctx context.Context, agentNam
 			namespace = r.namespace
 		}
 	}
+	return namespace
+}
 
-	// Check if the given resource exists locally
-	resClient := r.dynClient.Resource(gvr)
-	res, err := resClient.Namespace(namespace).Get(ctx, reqUpdate.Name, v1.GetOptions{})
-	if err != nil {
-		if !apierrors.IsNotFound(err) {
-			return err
-		}
-
+// reconcileRequestUpdate sends the peer what it needs to bring its copy of
+// the resource in line with res, the local resource. res is nil if the
+// resource doesn't exist locally.
+func (r *RequestHandler) reconcileRequestUpdate(ctx context.Context, logCtx *logrus.Entry, agentName string, reqUpdate *event.RequestUpdate, res *unstructured.Unstructured) error {
+	if res == nil {
 		logCtx.Trace("Resource not found on the source namespace")
 
 		// The resource doesn't exist on the source. So, send a delete event to remove the orphaned resource from the peer.
@@ -342,6 +565,9 @@ func (r *RequestHandler) handleUpdatedResource(logCtx *logrus.Entry, reqUpdate *
 			return err
 		}
 
+		if r.appFilter != nil {
+			app = r.appFilter(app)
+		}
 		ev := r.events.ApplicationEvent(event.SpecUpdate, app)
 		r.stampPrincipalUID(ev)
 		logCtx.Trace("Sending a request to update the application")
@@ -0,0 +1,84 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+)
+
+// NewApplicationChecksum returns the checksums of the state of app, which is
+// identified by uid on both principal and agent. Both sides must compute it
+// from the Application as it is exchanged between them.
+func NewApplicationChecksum(uid string, app *v1alpha1.Application) event.ApplicationChecksum {
+	// The destination is rewritten by the receiving side, just as in
+	// generateSpecChecksum
+	spec := app.Spec.DeepCopy()
+	spec.Destination = v1alpha1.ApplicationDestination{}
+
+	// Only the part of the status that tells whether the Application is in
+	// sync and healthy is compared. Everything else changes with each
+	// reconciliation and would be reported as divergence while a status
+	// update is in flight.
+	status := struct {
+		Sync      v1alpha1.SyncStatusCode   `json:"sync"`
+		Revision  string                    `json:"revision,omitempty"`
+		Revisions []string                  `json:"revisions,omitempty"`
+		Health    string                    `json:"health"`
+		Operation synccommon.OperationPhase `json:"operation,omitempty"`
+		Resources int                       `json:"resources"`
+	}{
+		Sync:      app.Status.Sync.Status,
+		Revision:  app.Status.Sync.Revision,
+		Revisions: app.Status.Sync.Revisions,
+		Health:    string(app.Status.Health.Status),
+		Resources: len(app.Status.Resources),
+	}
+	if app.Status.OperationState != nil {
+		status.Operation = app.Status.OperationState.Phase
+	}
+
+	return event.ApplicationChecksum{
+		UID:    uid,
+		Name:   app.Name,
+		Spec:   checksumOf(spec),
+		Status: checksumOf(status),
+	}
+}
+
+// AggregateChecksum returns the checksum over the checksums of all
+// Applications, independent of their order
+func AggregateChecksum(apps []event.ApplicationChecksum) string {
+	sorted := make([]event.ApplicationChecksum, len(apps))
+	copy(sorted, apps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].UID < sorted[j].UID })
+	h := sha256.New()
+	for _, a := range sorted {
+		h.Write([]byte(a.UID + ":" + a.Spec + ":" + a.Status + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func checksumOf(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_NewApplicationChecksum(t *testing.T) {
+	app := &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "argocd"},
+		Spec: v1alpha1.ApplicationSpec{
+			Project:     "default",
+			Destination: v1alpha1.ApplicationDestination{Server: "https://kubernetes.default.svc"},
+		},
+		Status: v1alpha1.ApplicationStatus{
+			Sync:   v1alpha1.SyncStatus{Status: v1alpha1.SyncStatusCodeSynced, Revision: "abc"},
+			Health: v1alpha1.AppHealthStatus{Status: health.HealthStatusHealthy},
+		},
+	}
+	sum := NewApplicationChecksum("1234", app)
+	assert.Equal(t, "1234", sum.UID)
+	assert.Equal(t, "app", sum.Name)
+
+	t.Run("Destination and timestamps are ignored", func(t *testing.T) {
+		other := app.DeepCopy()
+		other.Namespace = "agent"
+		other.Spec.Destination = v1alpha1.ApplicationDestination{Name: "in-cluster"}
+		other.Status.ReconciledAt = &metav1.Time{Time: time.Now()}
+		assert.Equal(t, sum, NewApplicationChecksum("1234", other))
+	})
+
+	t.Run("Spec changes are detected", func(t *testing.T) {
+		other := app.DeepCopy()
+		other.Spec.Project = "other"
+		changed := NewApplicationChecksum("1234", other)
+		assert.NotEqual(t, sum.Spec, changed.Spec)
+		assert.Equal(t, sum.Status, changed.Status)
+	})
+
+	t.Run("Status changes are detected", func(t *testing.T) {
+		other := app.DeepCopy()
+		other.Status.Sync.Status = v1alpha1.SyncStatusCodeOutOfSync
+		changed := NewApplicationChecksum("1234", other)
+		assert.Equal(t, sum.Spec, changed.Spec)
+		assert.NotEqual(t, sum.Status, changed.Status)
+	})
+}
+
+func Test_AggregateChecksum(t *testing.T) {
+	a := event.ApplicationChecksum{UID: "a", Spec: "1", Status: "2"}
+	b := event.ApplicationChecksum{UID: "b", Spec: "3", Status: "4"}
+	assert.Equal(t, AggregateChecksum([]event.ApplicationChecksum{a, b}), AggregateChecksum([]event.ApplicationChecksum{b, a}))
+	assert.NotEqual(t, AggregateChecksum([]event.ApplicationChecksum{a, b}), AggregateChecksum([]event.ApplicationChecksum{a}))
+	b.Status = "5"
+	assert.NotEqual(t, AggregateChecksum([]event.ApplicationChecksum{a}), AggregateChecksum([]event.ApplicationChecksum{a, b}))
+}
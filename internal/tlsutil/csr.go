@@ -0,0 +1,107 @@
+// Copyright 2024 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// GenerateCertificateRequest generates a private key and a certificate
+// signing request for a client certificate with the given common name. It
+// returns both, the request and the private key in PEM format.
+func GenerateCertificateRequest(name string) (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return "", "", err
+	}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	}, key)
+	if err != nil {
+		return "", "", fmt.Errorf("error creating certificate request: %w", err)
+	}
+	csrPem := new(bytes.Buffer)
+	if err := pem.Encode(csrPem, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}); err != nil {
+		return "", "", fmt.Errorf("error encoding certificate request: %v", err)
+	}
+	keyPem := new(bytes.Buffer)
+	if err := pem.Encode(keyPem, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}); err != nil {
+		return "", "", fmt.Errorf("error encoding key: %v", err)
+	}
+	return csrPem.String(), keyPem.String(), nil
+}
+
+// ParseCertificateRequest parses the PEM encoded certificate signing request
+// csrData and verifies its signature.
+func ParseCertificateRequest(csrData []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrData)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("no PEM encoded certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid signature on certificate request: %w", err)
+	}
+	return csr, nil
+}
+
+// SignClientCertificateRequest issues a client certificate for the public key
+// of the PEM encoded certificate signing request csrData. The subject of the
+// request is ignored; the certificate is issued for the given common name.
+//
+// It will return the certificate as PEM encoded string.
+func SignClientCertificateRequest(csrData []byte, name string, signerCert *x509.Certificate, signerKey crypto.PrivateKey) (string, error) {
+	csr, err := ParseCertificateRequest(csrData)
+	if err != nil {
+		return "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", err
+	}
+	cert := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: name,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(0, 6, 0),
+		IsCA:                  false,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, signerCert, csr.PublicKey, signerKey)
+	if err != nil {
+		return "", fmt.Errorf("error creating cert: %w", err)
+	}
+	certPem := new(bytes.Buffer)
+	if err := pem.Encode(certPem, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes}); err != nil {
+		return "", fmt.Errorf("error encoding certificate: %v", err)
+	}
+	return certPem.String(), nil
+}
@@ -62,5 +62,19 @@ func Test_GenerateCaCertificate(t *testing.T) {
 		assert.Contains(t, ccert.Leaf.IPAddresses, ip[len(ip)-4:])
 		assert.Contains(t, ccert.Leaf.DNSNames, "localhost")
 	})
+	t.Run("Sign client certificate request", func(t *testing.T) {
+		csrData, keyData, err := GenerateCertificateRequest("ignored")
+		require.NoError(t, err)
+		certData, err := SignClientCertificateRequest([]byte(csrData), "agent", cert.Leaf, cert.PrivateKey)
+		require.NoError(t, err)
+		ccert, err := tls.X509KeyPair([]byte(certData), []byte(keyData))
+		require.NoError(t, err)
+		assert.Contains(t, ccert.Leaf.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		assert.Equal(t, "agent", ccert.Leaf.Subject.CommonName)
+		assert.Equal(t, "test", ccert.Leaf.Issuer.CommonName)
+
+		_, err = SignClientCertificateRequest([]byte(certData), "agent", cert.Leaf, cert.PrivateKey)
+		assert.Error(t, err)
+	})
 
 }
@@ -0,0 +1,313 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Revocation methods, as reported by RevokedError
+const (
+	RevocationMethodCRL  = "crl"
+	RevocationMethodOCSP = "ocsp"
+)
+
+// ocspTimeout is the time to wait for an OCSP responder
+const ocspTimeout = 5 * time.Second
+
+// ocspDefaultTTL is how long OCSP responses without a next update time are
+// cached
+const ocspDefaultTTL = time.Hour
+
+// RevokedError is returned when a certificate has been revoked
+type RevokedError struct {
+	// Subject is the subject of the revoked certificate
+	Subject string
+	// Serial is the serial number of the revoked certificate
+	Serial string
+	// Method is the method the revocation was found with, crl or ocsp
+	Method string
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("certificate %q with serial %s has been revoked (%s)", e.Subject, e.Serial, e.Method)
+}
+
+// crl is a loaded certificate revocation list
+type crl struct {
+	list    *x509.RevocationList
+	revoked map[string]bool
+}
+
+type ocspEntry struct {
+	status  int
+	expires time.Time
+}
+
+// RevocationChecker checks certificates against certificate revocation lists
+// and OCSP responders. CRLs are loaded from files or HTTP(S) URLs and must be
+// refreshed periodically with Run. OCSP responders are taken from the
+// certificates themselves.
+type RevocationChecker struct {
+	crlSources     []string
+	ocsp           bool
+	ocspFailClosed bool
+	client         *http.Client
+
+	mu   sync.RWMutex
+	crls map[string]*crl
+
+	ocspMu    sync.Mutex
+	ocspCache map[string]ocspEntry
+}
+
+// NewRevocationChecker returns a checker using the CRLs from crlSources, each
+// being a file path or an HTTP(S) URL. If ocspEnabled is true, certificates
+// naming an OCSP responder are checked with it. Unless ocspFailClosed is set,
+// certificates are accepted when their responder cannot be reached.
+func NewRevocationChecker(crlSources []string, ocspEnabled, ocspFailClosed bool) *RevocationChecker {
+	return &RevocationChecker{
+		crlSources:     crlSources,
+		ocsp:           ocspEnabled,
+		ocspFailClosed: ocspFailClosed,
+		client:         &http.Client{Timeout: ocspTimeout},
+		crls:           make(map[string]*crl),
+		ocspCache:      make(map[string]ocspEntry),
+	}
+}
+
+// Refresh loads all CRLs. A CRL that fails to load keeps its previous
+// version, if any. The errors of all failed sources are returned.
+func (c *RevocationChecker) Refresh(ctx context.Context) error {
+	var errs []error
+	for _, source := range c.crlSources {
+		list, err := c.loadCRL(ctx, source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not load CRL from %s: %w", source, err))
+			continue
+		}
+		revoked := make(map[string]bool, len(list.RevokedCertificateEntries))
+		for _, e := range list.RevokedCertificateEntries {
+			revoked[e.SerialNumber.String()] = true
+		}
+		c.mu.Lock()
+		c.crls[source] = &crl{list: list, revoked: revoked}
+		c.mu.Unlock()
+		if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+			log().Warnf("CRL from %s expired at %s", source, list.NextUpdate)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run refreshes the CRLs every interval until ctx is done. After each
+// refresh, refreshed is called if not nil, so that certificates accepted
+// before can be checked again. With OCSP only, refreshed is still called
+// every interval.
+func (c *RevocationChecker) Run(ctx context.Context, interval time.Duration, refreshed func()) {
+	if (len(c.crlSources) == 0 && !c.ocsp) || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				log().WithError(err).Warn("Could not refresh CRLs")
+			}
+			if refreshed != nil {
+				refreshed()
+			}
+		}
+	}
+}
+
+func (c *RevocationChecker) loadCRL(ctx context.Context, source string) (*x509.RevocationList, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = c.fetch(ctx, http.MethodGet, source, "", nil)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseRevocationList(data)
+}
+
+func (c *RevocationChecker) fetch(ctx context.Context, method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Check checks the certificates of a verified chain, leaf first, for
+// revocation. Each certificate is checked against the CRLs signed by its
+// issuer. The leaf is additionally checked with OCSP, if enabled. A
+// *RevokedError is returned if any certificate has been revoked.
+func (c *RevocationChecker) Check(chain []*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		if c.revokedByCRL(cert, issuer) {
+			return &RevokedError{Subject: cert.Subject.String(), Serial: cert.SerialNumber.String(), Method: RevocationMethodCRL}
+		}
+	}
+	if c.ocsp && len(chain) > 1 {
+		return c.checkOCSP(chain[0], chain[1])
+	}
+	return nil
+}
+
+func (c *RevocationChecker) revokedByCRL(cert, issuer *x509.Certificate) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range c.crls {
+		if !bytes.Equal(l.list.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if err := l.list.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		if l.revoked[cert.SerialNumber.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RevocationChecker) checkOCSP(cert, issuer *x509.Certificate) error {
+	if len(cert.OCSPServer) == 0 {
+		return nil
+	}
+	key := string(issuer.RawSubject) + "/" + cert.SerialNumber.String()
+	c.ocspMu.Lock()
+	entry, ok := c.ocspCache[key]
+	c.ocspMu.Unlock()
+	if !ok || time.Now().After(entry.expires) {
+		resp, err := c.queryOCSP(cert, issuer)
+		if err != nil {
+			if c.ocspFailClosed {
+				return fmt.Errorf("could not check revocation of certificate %q: %w", cert.Subject, err)
+			}
+			log().WithError(err).Warnf("Could not check revocation of certificate %q with OCSP, accepting it", cert.Subject)
+			return nil
+		}
+		entry = ocspEntry{status: resp.Status, expires: resp.NextUpdate}
+		if entry.expires.IsZero() {
+			entry.expires = time.Now().Add(ocspDefaultTTL)
+		}
+		c.ocspMu.Lock()
+		c.ocspCache[key] = entry
+		c.ocspMu.Unlock()
+	}
+	switch entry.status {
+	case ocsp.Revoked:
+		return &RevokedError{Subject: cert.Subject.String(), Serial: cert.SerialNumber.String(), Method: RevocationMethodOCSP}
+	case ocsp.Unknown:
+		if c.ocspFailClosed {
+			return fmt.Errorf("OCSP status of certificate %q is unknown", cert.Subject)
+		}
+	}
+	return nil
+}
+
+func (c *RevocationChecker) queryOCSP(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ocspTimeout)
+	defer cancel()
+	var errs []error
+	for _, server := range cert.OCSPServer {
+		data, err := c.fetch(ctx, http.MethodPost, server, "application/ocsp-request", req)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// VerifyPeerCertificate returns a function for tls.Config's
+// VerifyPeerCertificate, which rejects revoked certificates. onRevoked, if
+// not nil, is called for every rejected certificate.
+func (c *RevocationChecker) VerifyPeerCertificate(onRevoked func(*RevokedError)) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		return c.CheckChains(verifiedChains, onRevoked)
+	}
+}
+
+// CheckChains checks all verified chains of a peer, see Check. onRevoked,
+// if not nil, is called for a revoked certificate.
+func (c *RevocationChecker) CheckChains(verifiedChains [][]*x509.Certificate, onRevoked func(*RevokedError)) error {
+	for _, chain := range verifiedChains {
+		err := c.Check(chain)
+		if err == nil {
+			continue
+		}
+		var revoked *RevokedError
+		if errors.As(err, &revoked) && onRevoked != nil {
+			onRevoked(revoked)
+		}
+		return err
+	}
+	return nil
+}
+
+func log() *logrus.Entry {
+	return logging.GetDefaultLogger().ComponentLogger("RevocationChecker")
+}
@@ -0,0 +1,242 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsutil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, ocspServer string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if ocspServer != "" {
+		tmpl.OCSPServer = []string{ocspServer}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func (ca *testCA) crl(t *testing.T, revoked ...int64) []byte {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func Test_RevocationChecker_CRL(t *testing.T) {
+	ca := newTestCA(t)
+	good := ca.issue(t, 10, "")
+	revoked := ca.issue(t, 11, "")
+
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	require.NoError(t, os.WriteFile(path, ca.crl(t, 11), 0600))
+
+	c := NewRevocationChecker([]string{path}, false, false)
+	require.NoError(t, c.Refresh(context.Background()))
+
+	t.Run("Good certificate is accepted", func(t *testing.T) {
+		assert.NoError(t, c.Check([]*x509.Certificate{good, ca.cert}))
+	})
+
+	t.Run("Revoked certificate is rejected", func(t *testing.T) {
+		err := c.Check([]*x509.Certificate{revoked, ca.cert})
+		var re *RevokedError
+		require.ErrorAs(t, err, &re)
+		assert.Equal(t, RevocationMethodCRL, re.Method)
+		assert.Equal(t, "11", re.Serial)
+	})
+
+	t.Run("CRL of another issuer is ignored", func(t *testing.T) {
+		other := newTestCA(t)
+		assert.NoError(t, c.Check([]*x509.Certificate{other.issue(t, 11, ""), other.cert}))
+	})
+
+	t.Run("Refresh picks up newly revoked certificates", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, ca.crl(t, 10, 11), 0600))
+		require.NoError(t, c.Refresh(context.Background()))
+		assert.Error(t, c.Check([]*x509.Certificate{good, ca.cert}))
+	})
+
+	t.Run("Failed refresh keeps previous CRL", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("garbage"), 0600))
+		assert.Error(t, c.Refresh(context.Background()))
+		assert.Error(t, c.Check([]*x509.Certificate{revoked, ca.cert}))
+	})
+
+	t.Run("VerifyPeerCertificate reports revocations", func(t *testing.T) {
+		var reported *RevokedError
+		verify := c.VerifyPeerCertificate(func(e *RevokedError) { reported = e })
+		assert.NoError(t, verify(nil, nil))
+		assert.Error(t, verify(nil, [][]*x509.Certificate{{revoked, ca.cert}}))
+		require.NotNil(t, reported)
+		assert.Equal(t, "11", reported.Serial)
+	})
+}
+
+func Test_RevocationChecker_Run(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, 10, "")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	require.NoError(t, os.WriteFile(path, ca.crl(t), 0600))
+
+	c := NewRevocationChecker([]string{path}, false, false)
+	require.NoError(t, c.Refresh(context.Background()))
+	require.NoError(t, c.Check([]*x509.Certificate{cert, ca.cert}))
+	require.NoError(t, os.WriteFile(path, ca.crl(t, 10), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	refreshed := make(chan error, 1)
+	go c.Run(ctx, 10*time.Millisecond, func() {
+		select {
+		case refreshed <- c.Check([]*x509.Certificate{cert, ca.cert}):
+		default:
+		}
+	})
+	select {
+	case err := <-refreshed:
+		assert.Error(t, err, "certificates must be checked against the refreshed CRL")
+	case <-time.After(5 * time.Second):
+		t.Fatal("refreshed was not called")
+	}
+}
+
+func Test_RevocationChecker_CRLFromURL(t *testing.T) {
+	ca := newTestCA(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(ca.crl(t, 5))
+	}))
+	defer srv.Close()
+
+	c := NewRevocationChecker([]string{srv.URL + "/ca.crl"}, false, false)
+	require.NoError(t, c.Refresh(context.Background()))
+	assert.Error(t, c.Check([]*x509.Certificate{ca.issue(t, 5, ""), ca.cert}))
+	assert.NoError(t, c.Check([]*x509.Certificate{ca.issue(t, 6, ""), ca.cert}))
+}
+
+func Test_RevocationChecker_OCSP(t *testing.T) {
+	ca := newTestCA(t)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+		status := ocsp.Good
+		if req.SerialNumber.Int64() == 21 {
+			status = ocsp.Revoked
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now(),
+		}, ca.key)
+		require.NoError(t, err)
+		_, _ = w.Write(resp)
+	}))
+	defer srv.Close()
+
+	c := NewRevocationChecker(nil, true, false)
+	good := ca.issue(t, 20, srv.URL)
+	revoked := ca.issue(t, 21, srv.URL)
+
+	assert.NoError(t, c.Check([]*x509.Certificate{good, ca.cert}))
+	err := c.Check([]*x509.Certificate{revoked, ca.cert})
+	var re *RevokedError
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, RevocationMethodOCSP, re.Method)
+
+	t.Run("Responses are cached", func(t *testing.T) {
+		n := requests.Load()
+		assert.NoError(t, c.Check([]*x509.Certificate{good, ca.cert}))
+		assert.Equal(t, n, requests.Load())
+	})
+
+	t.Run("Unreachable responder", func(t *testing.T) {
+		unreachable := ca.issue(t, 22, "http://127.0.0.1:1")
+		assert.NoError(t, NewRevocationChecker(nil, true, false).Check([]*x509.Certificate{unreachable, ca.cert}))
+		assert.Error(t, NewRevocationChecker(nil, true, true).Check([]*x509.Certificate{unreachable, ca.cert}))
+	})
+}
@@ -26,6 +26,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -158,6 +159,68 @@ func TLSVersionFromName(name string) (uint16, error) {
 	return v, nil
 }
 
+// supportedCurves maps the names of key exchange mechanisms to their IDs, in
+// the order of Go's default preferences.
+var supportedCurves = []struct {
+	name string
+	id   tls.CurveID
+}{
+	{"X25519MLKEM768", tls.X25519MLKEM768},
+	{"X25519", tls.X25519},
+	{"P256", tls.CurveP256},
+	{"P384", tls.CurveP384},
+	{"P521", tls.CurveP521},
+}
+
+// CurveNames returns the names of the supported key exchange mechanisms
+func CurveNames() []string {
+	names := make([]string, 0, len(supportedCurves))
+	for _, c := range supportedCurves {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// ParseCurvePreferences converts a list of key exchange mechanism names, such
+// as X25519 or P256, to their IDs. Names are case-insensitive. Returns an
+// error if any name is not recognized.
+func ParseCurvePreferences(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 || (len(names) == 1 && names[0] == "") {
+		return nil, nil
+	}
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, c := range supportedCurves {
+			if strings.EqualFold(c.name, strings.TrimSpace(name)) {
+				curves = append(curves, c.id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no such curve: %s", name)
+		}
+	}
+	return curves, nil
+}
+
+// SetCurvePreferencesFromFlags sets the key exchange mechanisms of tlsConfig
+// from the command line flags. Go's defaults are kept if curves is empty.
+func SetCurvePreferencesFromFlags(tlsConfig *tls.Config, curves []string) error {
+	if tlsConfig == nil {
+		return fmt.Errorf("tlsConfig is nil")
+	}
+	ids, err := ParseCurvePreferences(curves)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		tlsConfig.CurvePreferences = ids
+	}
+	return nil
+}
+
 // ParseCipherSuites converts a list of cipher suite names to their corresponding
 // uint16 IDs. Returns an error if any cipher suite name is not recognized.
 func ParseCipherSuites(names []string) ([]uint16, error) {
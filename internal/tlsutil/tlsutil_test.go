@@ -435,3 +435,31 @@ func Test_SetTLSConfigFromFlags(t *testing.T) {
 		assert.Equal(t, []uint16{cs.ID}, cfg.CipherSuites, "cipherSuites should be preserved")
 	})
 }
+
+func Test_ParseCurvePreferences(t *testing.T) {
+	t.Run("Empty list", func(t *testing.T) {
+		curves, err := ParseCurvePreferences(nil)
+		require.NoError(t, err)
+		assert.Nil(t, curves)
+		curves, err = ParseCurvePreferences([]string{""})
+		require.NoError(t, err)
+		assert.Nil(t, curves)
+	})
+	t.Run("Known curves in order", func(t *testing.T) {
+		curves, err := ParseCurvePreferences([]string{"P384", "p256", " X25519"})
+		require.NoError(t, err)
+		assert.Equal(t, []tls.CurveID{tls.CurveP384, tls.CurveP256, tls.X25519}, curves)
+	})
+	t.Run("Unknown curve", func(t *testing.T) {
+		_, err := ParseCurvePreferences([]string{"P256", "P224"})
+		assert.ErrorContains(t, err, "no such curve: P224")
+	})
+	t.Run("Set from flags", func(t *testing.T) {
+		cfg := &tls.Config{}
+		require.NoError(t, SetCurvePreferencesFromFlags(cfg, nil))
+		assert.Nil(t, cfg.CurvePreferences)
+		require.NoError(t, SetCurvePreferencesFromFlags(cfg, []string{"P521"}))
+		assert.Equal(t, []tls.CurveID{tls.CurveP521}, cfg.CurvePreferences)
+		assert.Error(t, SetCurvePreferencesFromFlags(nil, nil))
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// SkewMode determines how the principal treats agents whose version differs
+// from its own.
+type SkewMode string
+
+const (
+	// SkewModeExact admits only agents of exactly the principal's version
+	SkewModeExact SkewMode = "exact"
+	// SkewModeWarn admits agents of any version, but warns about agents
+	// whose skew exceeds the policy
+	SkewModeWarn SkewMode = "warn"
+	// SkewModeRefuse admits only agents whose skew is within the policy
+	SkewModeRefuse SkewMode = "refuse"
+)
+
+// DefaultMaxMinorSkew is the number of minor versions an agent may be behind
+// the principal, unless configured otherwise.
+const DefaultMaxMinorSkew = 1
+
+// SkewPolicy is the policy for agents whose version differs from the
+// principal's. An agent is within the policy if it has the same major
+// version as the principal, and is not ahead of it and at most MaxMinorSkew
+// minor versions behind. Patch versions are not considered.
+type SkewPolicy struct {
+	Mode         SkewMode
+	MaxMinorSkew int
+}
+
+// DefaultSkewPolicy returns the policy that admits only agents of exactly
+// the principal's version.
+func DefaultSkewPolicy() SkewPolicy {
+	return SkewPolicy{Mode: SkewModeExact, MaxMinorSkew: DefaultMaxMinorSkew}
+}
+
+// ParseSkewMode parses the name of a skew mode
+func ParseSkewMode(mode string) (SkewMode, error) {
+	switch SkewMode(mode) {
+	case SkewModeExact, SkewModeWarn, SkewModeRefuse:
+		return SkewMode(mode), nil
+	}
+	return "", fmt.Errorf("invalid version skew mode %q: must be one of %s, %s or %s", mode, SkewModeExact, SkewModeWarn, SkewModeRefuse)
+}
+
+// Skew is the result of comparing an agent's version to the principal's
+type Skew struct {
+	// MinorBehind is the number of minor versions the agent is behind the
+	// principal. It is negative if the agent is ahead, and only meaningful
+	// if Comparable is true.
+	MinorBehind int
+	// Comparable is true if both versions are semantic versions of the same
+	// major version
+	Comparable bool
+	// WithinPolicy is true if the skew is acceptable under the policy
+	WithinPolicy bool
+	// Admitted is true if the agent may connect
+	Admitted bool
+}
+
+// Evaluate compares the agent's version to the principal's according to the
+// policy p.
+func (p SkewPolicy) Evaluate(agentVersion, principalVersion string) Skew {
+	skew := Skew{}
+	av, aerr := semver.NewVersion(agentVersion)
+	pv, perr := semver.NewVersion(principalVersion)
+	if aerr == nil && perr == nil && av.Major() == pv.Major() {
+		skew.Comparable = true
+		skew.MinorBehind = int(pv.Minor()) - int(av.Minor())
+	}
+	switch p.Mode {
+	case SkewModeWarn, SkewModeRefuse:
+		skew.WithinPolicy = agentVersion == principalVersion ||
+			(skew.Comparable && skew.MinorBehind >= 0 && skew.MinorBehind <= p.MaxMinorSkew)
+		skew.Admitted = p.Mode == SkewModeWarn || skew.WithinPolicy
+	default:
+		skew.WithinPolicy = agentVersion == principalVersion
+		skew.Admitted = skew.WithinPolicy
+	}
+	return skew
+}
+
+// Describe returns a human readable description of the skew between the
+// given versions, for log and error messages.
+func (s Skew) Describe(agentVersion, principalVersion string) string {
+	switch {
+	case !s.Comparable:
+		return fmt.Sprintf("agent version %s is incompatible with principal version %s", agentVersion, principalVersion)
+	case s.MinorBehind > 0:
+		return fmt.Sprintf("agent version %s is %d minor version(s) behind principal version %s", agentVersion, s.MinorBehind, principalVersion)
+	case s.MinorBehind < 0:
+		return fmt.Sprintf("agent version %s is %d minor version(s) ahead of principal version %s", agentVersion, -s.MinorBehind, principalVersion)
+	}
+	return fmt.Sprintf("agent version %s differs from principal version %s", agentVersion, principalVersion)
+}
@@ -0,0 +1,68 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SkewPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		mode      SkewMode
+		agent     string
+		principal string
+		behind    int
+		within    bool
+		admitted  bool
+	}{
+		{"exact: same version", SkewModeExact, "0.6.0", "0.6.0", 0, true, true},
+		{"exact: patch differs", SkewModeExact, "0.6.0", "0.6.1", 0, false, false},
+		{"refuse: patch differs", SkewModeRefuse, "0.6.0", "0.6.1", 0, true, true},
+		{"refuse: one minor behind", SkewModeRefuse, "0.5.3", "0.6.1", 1, true, true},
+		{"refuse: two minors behind", SkewModeRefuse, "0.4.0", "0.6.0", 2, false, false},
+		{"refuse: agent ahead", SkewModeRefuse, "0.7.0", "0.6.0", -1, false, false},
+		{"refuse: v prefix and pre-release", SkewModeRefuse, "v0.5.0-rc1", "0.6.0", 1, true, true},
+		{"refuse: major differs", SkewModeRefuse, "1.6.0", "0.6.0", 0, false, false},
+		{"refuse: development builds", SkewModeRefuse, "99.9.9-unreleased", "99.9.9-unreleased", 0, true, true},
+		{"warn: two minors behind", SkewModeWarn, "0.4.0", "0.6.0", 2, false, true},
+		{"warn: unparsable version", SkewModeWarn, "devel", "0.6.0", 0, false, true},
+		{"refuse: unparsable version", SkewModeRefuse, "devel", "0.6.0", 0, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			skew := SkewPolicy{Mode: tc.mode, MaxMinorSkew: 1}.Evaluate(tc.agent, tc.principal)
+			assert.Equal(t, tc.behind, skew.MinorBehind)
+			assert.Equal(t, tc.within, skew.WithinPolicy)
+			assert.Equal(t, tc.admitted, skew.Admitted)
+		})
+	}
+}
+
+func Test_ParseSkewMode(t *testing.T) {
+	m, err := ParseSkewMode("warn")
+	assert.NoError(t, err)
+	assert.Equal(t, SkewModeWarn, m)
+	_, err = ParseSkewMode("lenient")
+	assert.ErrorContains(t, err, "invalid version skew mode")
+}
+
+func Test_SkewDescribe(t *testing.T) {
+	p := SkewPolicy{Mode: SkewModeRefuse, MaxMinorSkew: 1}
+	assert.Equal(t, "agent version 0.4.0 is 2 minor version(s) behind principal version 0.6.0", p.Evaluate("0.4.0", "0.6.0").Describe("0.4.0", "0.6.0"))
+	assert.Equal(t, "agent version 0.7.0 is 1 minor version(s) ahead of principal version 0.6.0", p.Evaluate("0.7.0", "0.6.0").Describe("0.7.0", "0.6.0"))
+	assert.Equal(t, "agent version 1.0.0 is incompatible with principal version 0.6.0", p.Evaluate("1.0.0", "0.6.0").Describe("1.0.0", "0.6.0"))
+}
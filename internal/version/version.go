@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -85,6 +86,12 @@ func (v *Version) GitRevision() string {
 	return v.v.GitRevision
 }
 
+// IsDevelopmentBuild returns true if the argocd-agent was not built as a
+// release, i.e. its version was not set at build time.
+func IsDevelopmentBuild() bool {
+	return strings.HasSuffix(version, "-unreleased")
+}
+
 // GitStatus returns the git status of the argocd-agent.
 
 func (v *Version) GitStatus() string {
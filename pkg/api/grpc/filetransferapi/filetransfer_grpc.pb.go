@@ -0,0 +1,180 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v4.25.3
+// source: filetransfer.proto
+
+package filetransferapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FileTransferServiceClient is the client API for FileTransferService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileTransferServiceClient interface {
+	// Upload is a client-streaming RPC used by the agent to send a file to the principal
+	Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransferService_UploadClient, error)
+	// Status returns the progress of a transfer, so the agent knows where to resume
+	Status(ctx context.Context, in *FileTransferStatusRequest, opts ...grpc.CallOption) (*FileTransferStatus, error)
+}
+
+type fileTransferServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileTransferServiceClient(cc grpc.ClientConnInterface) FileTransferServiceClient {
+	return &fileTransferServiceClient{cc}
+}
+
+func (c *fileTransferServiceClient) Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransferService_UploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransferService_ServiceDesc.Streams[0], "/filetransferapi.FileTransferService/Upload", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferServiceUploadClient{stream}
+	return x, nil
+}
+
+type FileTransferService_UploadClient interface {
+	Send(*FileChunk) error
+	CloseAndRecv() (*FileTransferResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferServiceUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferServiceUploadClient) Send(m *FileChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileTransferServiceUploadClient) CloseAndRecv() (*FileTransferResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(FileTransferResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferServiceClient) Status(ctx context.Context, in *FileTransferStatusRequest, opts ...grpc.CallOption) (*FileTransferStatus, error) {
+	out := new(FileTransferStatus)
+	err := c.cc.Invoke(ctx, "/filetransferapi.FileTransferService/Status", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileTransferServiceServer is the server API for FileTransferService service.
+// All implementations must embed UnimplementedFileTransferServiceServer
+// for forward compatibility
+type FileTransferServiceServer interface {
+	// Upload is a client-streaming RPC used by the agent to send a file to the principal
+	Upload(FileTransferService_UploadServer) error
+	// Status returns the progress of a transfer, so the agent knows where to resume
+	Status(context.Context, *FileTransferStatusRequest) (*FileTransferStatus, error)
+	mustEmbedUnimplementedFileTransferServiceServer()
+}
+
+// UnimplementedFileTransferServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedFileTransferServiceServer struct {
+}
+
+func (UnimplementedFileTransferServiceServer) Upload(FileTransferService_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedFileTransferServiceServer) Status(context.Context, *FileTransferStatusRequest) (*FileTransferStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedFileTransferServiceServer) mustEmbedUnimplementedFileTransferServiceServer() {}
+
+// UnsafeFileTransferServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileTransferServiceServer will
+// result in compilation errors.
+type UnsafeFileTransferServiceServer interface {
+	mustEmbedUnimplementedFileTransferServiceServer()
+}
+
+func RegisterFileTransferServiceServer(s grpc.ServiceRegistrar, srv FileTransferServiceServer) {
+	s.RegisterService(&FileTransferService_ServiceDesc, srv)
+}
+
+func _FileTransferService_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileTransferServiceServer).Upload(&fileTransferServiceUploadServer{stream})
+}
+
+type FileTransferService_UploadServer interface {
+	SendAndClose(*FileTransferResponse) error
+	Recv() (*FileChunk, error)
+	grpc.ServerStream
+}
+
+type fileTransferServiceUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferServiceUploadServer) SendAndClose(m *FileTransferResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileTransferServiceUploadServer) Recv() (*FileChunk, error) {
+	m := new(FileChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FileTransferService_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileTransferStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServiceServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filetransferapi.FileTransferService/Status",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServiceServer).Status(ctx, req.(*FileTransferStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FileTransferService_ServiceDesc is the grpc.ServiceDesc for FileTransferService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileTransferService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filetransferapi.FileTransferService",
+	HandlerType: (*FileTransferServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Status",
+			Handler:    _FileTransferService_Status_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       _FileTransferService_Upload_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "filetransfer.proto",
+}
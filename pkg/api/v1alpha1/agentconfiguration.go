@@ -0,0 +1,285 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const AgentConfigurationKind = "AgentConfiguration"
+
+// AgentConfigurationResource is the resource of AgentConfiguration objects
+var AgentConfigurationResource = GroupVersion.WithResource("agentconfigurations")
+
+type AgentConfigPhase string
+
+const (
+	// AgentConfigPhasePending means the configuration was pushed to the
+	// agent, but the agent has not acknowledged it yet
+	AgentConfigPhasePending AgentConfigPhase = "Pending"
+	// AgentConfigPhaseApplied means the agent has applied the configuration
+	AgentConfigPhaseApplied AgentConfigPhase = "Applied"
+	// AgentConfigPhaseFailed means the agent could not apply the
+	// configuration
+	AgentConfigPhaseFailed AgentConfigPhase = "Failed"
+	// AgentConfigPhaseDrifted means the agent reported a configuration
+	// different from the desired one. The principal pushes the desired
+	// configuration again.
+	AgentConfigPhaseDrifted AgentConfigPhase = "Drifted"
+)
+
+// AgentConfiguration holds runtime configuration that the principal pushes
+// to a group of agents. An agent may be selected by several
+// AgentConfigurations, in which case their configuration is combined.
+type AgentConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AgentConfigurationSpec   `json:"spec,omitempty"`
+	Status AgentConfigurationStatus `json:"status,omitempty"`
+}
+
+// AgentConfigurationSpec selects agents and holds the configuration to push
+// to them
+type AgentConfigurationSpec struct {
+	// AgentSelector selects agents by the labels of their cluster secret.
+	// An empty selector selects all agents.
+	AgentSelector *metav1.LabelSelector `json:"agentSelector,omitempty"`
+	// Agents selects agents by name, in addition to AgentSelector
+	Agents []string `json:"agents,omitempty"`
+	// Config is the configuration pushed to the selected agents
+	Config AgentRuntimeConfig `json:"config"`
+}
+
+// AgentRuntimeConfig is configuration an agent applies at runtime
+type AgentRuntimeConfig struct {
+	// ResourceExclusions lists resources the agent refuses to serve through
+	// the resource proxy
+	ResourceExclusions []ResourceExclusion `json:"resourceExclusions,omitempty"`
+	// LogRedactions are applied to container logs before they are streamed
+	// to the principal
+	LogRedactions []LogRedaction `json:"logRedactions,omitempty"`
+	// RateLimits override the agent's limits for long-running operations
+	RateLimits *RateLimits `json:"rateLimits,omitempty"`
+}
+
+// ResourceExclusion matches resources by API group, resource and namespace.
+// An empty list or "*" matches everything.
+type ResourceExclusion struct {
+	APIGroups  []string `json:"apiGroups,omitempty"`
+	Resources  []string `json:"resources,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// LogRedaction replaces all matches of a regular expression in container
+// logs
+type LogRedaction struct {
+	// Pattern is a regular expression in RE2 syntax
+	Pattern string `json:"pattern"`
+	// Replacement replaces each match. Defaults to "***".
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// RateLimits limits the number of concurrent long-running operations. Unset
+// fields leave the agent's own limit in place.
+type RateLimits struct {
+	MaxLogStreams       *int `json:"maxLogStreams,omitempty"`
+	MaxTerminalSessions *int `json:"maxTerminalSessions,omitempty"`
+}
+
+// AgentConfigurationStatus is the observed state of an AgentConfiguration
+type AgentConfigurationStatus struct {
+	// Hash identifies the current configuration
+	Hash string `json:"hash,omitempty"`
+	// Agents holds the state of the configuration on each selected agent
+	Agents []AgentConfigurationAgentStatus `json:"agents,omitempty"`
+}
+
+// AgentConfigurationAgentStatus is the state of a configuration on an agent
+type AgentConfigurationAgentStatus struct {
+	Name  string           `json:"name"`
+	Phase AgentConfigPhase `json:"phase"`
+	// Hash is the hash of the configuration last acknowledged by the agent
+	Hash    string `json:"hash,omitempty"`
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the time the phase last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// Hash returns a short hash identifying c
+func (c *AgentRuntimeConfig) Hash() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// DeepCopyInto copies c into out
+func (c *AgentRuntimeConfig) DeepCopyInto(out *AgentRuntimeConfig) {
+	*out = *c
+	if c.ResourceExclusions != nil {
+		out.ResourceExclusions = make([]ResourceExclusion, len(c.ResourceExclusions))
+		for i, e := range c.ResourceExclusions {
+			out.ResourceExclusions[i] = ResourceExclusion{
+				APIGroups:  copyStrings(e.APIGroups),
+				Resources:  copyStrings(e.Resources),
+				Namespaces: copyStrings(e.Namespaces),
+			}
+		}
+	}
+	if c.LogRedactions != nil {
+		out.LogRedactions = append([]LogRedaction{}, c.LogRedactions...)
+	}
+	if c.RateLimits != nil {
+		out.RateLimits = &RateLimits{
+			MaxLogStreams:       copyInt(c.RateLimits.MaxLogStreams),
+			MaxTerminalSessions: copyInt(c.RateLimits.MaxTerminalSessions),
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of c
+func (c *AgentRuntimeConfig) DeepCopy() *AgentRuntimeConfig {
+	if c == nil {
+		return nil
+	}
+	out := &AgentRuntimeConfig{}
+	c.DeepCopyInto(out)
+	return out
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func copyInt(i *int) *int {
+	if i == nil {
+		return nil
+	}
+	v := *i
+	return &v
+}
+
+// DeepCopyInto copies c into out
+func (c *AgentConfiguration) DeepCopyInto(out *AgentConfiguration) {
+	*out = *c
+	c.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if c.Spec.AgentSelector != nil {
+		out.Spec.AgentSelector = c.Spec.AgentSelector.DeepCopy()
+	}
+	out.Spec.Agents = copyStrings(c.Spec.Agents)
+	c.Spec.Config.DeepCopyInto(&out.Spec.Config)
+	if c.Status.Agents != nil {
+		out.Status.Agents = make([]AgentConfigurationAgentStatus, len(c.Status.Agents))
+		for i := range c.Status.Agents {
+			out.Status.Agents[i] = c.Status.Agents[i]
+			c.Status.Agents[i].LastTransitionTime.DeepCopyInto(&out.Status.Agents[i].LastTransitionTime)
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of c
+func (c *AgentConfiguration) DeepCopy() *AgentConfiguration {
+	if c == nil {
+		return nil
+	}
+	out := &AgentConfiguration{}
+	c.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (c *AgentConfiguration) DeepCopyObject() runtime.Object {
+	return c.DeepCopy()
+}
+
+// AgentStatus returns the status of the configuration on the given agent
+func (c *AgentConfiguration) AgentStatus(agentName string) *AgentConfigurationAgentStatus {
+	for i := range c.Status.Agents {
+		if c.Status.Agents[i].Name == agentName {
+			return &c.Status.Agents[i]
+		}
+	}
+	return nil
+}
+
+// ToUnstructured converts c for use with the dynamic client
+func (c *AgentConfiguration) ToUnstructured() (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert AgentConfiguration: %w", err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// AgentConfigurationFromUnstructured converts an object returned by the
+// dynamic client
+func AgentConfigurationFromUnstructured(u *unstructured.Unstructured) (*AgentConfiguration, error) {
+	c := &AgentConfiguration{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, c); err != nil {
+		return nil, fmt.Errorf("could not convert AgentConfiguration: %w", err)
+	}
+	return c, nil
+}
+
+// AgentConfigurationList is a list of AgentConfiguration objects
+type AgentConfigurationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []AgentConfiguration `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object
+func (l *AgentConfigurationList) DeepCopyObject() runtime.Object {
+	if l == nil {
+		return nil
+	}
+	out := &AgentConfigurationList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	out.Items = make([]AgentConfiguration, len(l.Items))
+	for i := range l.Items {
+		l.Items[i].DeepCopyInto(&out.Items[i])
+	}
+	return out
+}
+
+// AgentConfigurationListFromUnstructured converts a list returned by the
+// dynamic client
+func AgentConfigurationListFromUnstructured(u *unstructured.UnstructuredList) (*AgentConfigurationList, error) {
+	l := &AgentConfigurationList{Items: make([]AgentConfiguration, 0, len(u.Items))}
+	l.SetResourceVersion(u.GetResourceVersion())
+	l.SetContinue(u.GetContinue())
+	for i := range u.Items {
+		c, err := AgentConfigurationFromUnstructured(&u.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		l.Items = append(l.Items, *c)
+	}
+	return l, nil
+}
@@ -0,0 +1,287 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const AgentOperationKind = "AgentOperation"
+
+// AgentOperationResource is the resource of AgentOperation objects
+var AgentOperationResource = GroupVersion.WithResource("agentoperations")
+
+// AgentOperationType is the operation run on the selected agents
+type AgentOperationType string
+
+const (
+	// AgentOperationRefresh resyncs the resources of the agent with the
+	// principal, as is done when the agent connects
+	AgentOperationRefresh AgentOperationType = "Refresh"
+	// AgentOperationConfigPush pushes the AgentConfigurations selecting the
+	// agent and waits for the agent to apply them
+	AgentOperationConfigPush AgentOperationType = "ConfigPush"
+	// AgentOperationSupportBundle collects a support bundle for an
+	// application from the agent
+	AgentOperationSupportBundle AgentOperationType = "SupportBundle"
+	// AgentOperationUpdate makes the agent update the image tag of its own
+	// Deployment, and waits for the updated agent to reconnect
+	AgentOperationUpdate AgentOperationType = "Update"
+)
+
+// AgentOperationPhase is the phase of an operation, or of an operation on a
+// single agent
+type AgentOperationPhase string
+
+const (
+	AgentOperationPending   AgentOperationPhase = "Pending"
+	AgentOperationRunning   AgentOperationPhase = "Running"
+	AgentOperationSucceeded AgentOperationPhase = "Succeeded"
+	AgentOperationFailed    AgentOperationPhase = "Failed"
+)
+
+// AgentOperation runs an operation once on all agents it selects, and
+// reports the progress of the operation in its status.
+type AgentOperation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AgentOperationSpec   `json:"spec,omitempty"`
+	Status AgentOperationStatus `json:"status,omitempty"`
+}
+
+// AgentOperationSpec selects agents and describes the operation to run on
+// them
+type AgentOperationSpec struct {
+	// AgentSelector selects agents by the labels of their cluster secret.
+	// An empty selector selects all agents.
+	AgentSelector *metav1.LabelSelector `json:"agentSelector,omitempty"`
+	// Agents selects agents by name, in addition to AgentSelector
+	Agents []string `json:"agents,omitempty"`
+	// Type is the operation to run
+	Type AgentOperationType `json:"type"`
+	// Parallelism is the number of agents the operation runs on at the same
+	// time. Defaults to 10.
+	Parallelism int `json:"parallelism,omitempty"`
+	// Timeout is the time the operation may take on a single agent
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// SupportBundle holds the parameters of SupportBundle operations
+	SupportBundle *SupportBundleOperation `json:"supportBundle,omitempty"`
+	// Update holds the parameters of Update operations
+	Update *UpdateOperation `json:"update,omitempty"`
+}
+
+// SupportBundleOperation holds the parameters of a SupportBundle operation
+type SupportBundleOperation struct {
+	// Application is the name of the application to collect logs for
+	Application string `json:"application"`
+	// Namespace is the namespace of the application on the agent
+	Namespace string `json:"namespace,omitempty"`
+	// LimitBytes is the maximum number of log bytes per container
+	LimitBytes int64 `json:"limitBytes,omitempty"`
+}
+
+// UpdateOperation holds the parameters of an Update operation
+type UpdateOperation struct {
+	// Tag is the image tag the agents are updated to. The image repository
+	// of the agents is kept.
+	Tag string `json:"tag"`
+	// Payload is the base64 encoded JSON document authorizing the update.
+	// It names the image repository, the tag, the agents or fleets that may
+	// update, and when it expires.
+	Payload string `json:"payload"`
+	// Signature is the base64 encoded signature of the decoded Payload,
+	// made with the private key whose public key the agents are configured
+	// to verify updates with
+	Signature string `json:"signature"`
+	// Version is the version the agents report after the update. If set,
+	// the update of an agent only succeeds once it reconnects with this
+	// version.
+	Version string `json:"version,omitempty"`
+	// MaintenanceWindow restricts the times agents are updated at. If not
+	// set, agents are updated right away.
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+}
+
+// MaintenanceWindow is a recurring period of time in which disruptive
+// operations may run
+type MaintenanceWindow struct {
+	// Days are the abbreviated weekdays the window opens on, e.g. Sat. The
+	// window opens every day if empty.
+	Days []string `json:"days,omitempty"`
+	// Start is the time of day the window opens, in 24h HH:MM format
+	Start string `json:"start"`
+	// Duration is how long the window stays open
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the IANA name of the time zone of Start. Defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// AgentOperationStatus reports the progress of an operation
+type AgentOperationStatus struct {
+	Phase          AgentOperationPhase `json:"phase,omitempty"`
+	Message        string              `json:"message,omitempty"`
+	StartTime      *metav1.Time        `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time        `json:"completionTime,omitempty"`
+	// Progress aggregates the phases of the operation on all agents
+	Progress AgentOperationProgress `json:"progress,omitempty"`
+	// Agents holds the status of the operation on each selected agent
+	Agents []AgentOperationAgentStatus `json:"agents,omitempty"`
+}
+
+// AgentOperationProgress counts the agents of an operation by phase
+type AgentOperationProgress struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// AgentOperationAgentStatus is the status of an operation on a single agent
+type AgentOperationAgentStatus struct {
+	Name    string              `json:"name"`
+	Phase   AgentOperationPhase `json:"phase"`
+	Message string              `json:"message,omitempty"`
+	// Result describes the outcome of a successful operation, e.g. the path
+	// of a support bundle on the principal
+	Result string `json:"result,omitempty"`
+}
+
+// UpdateProgress recounts the progress from the status of all agents
+func (s *AgentOperationStatus) UpdateProgress() {
+	p := AgentOperationProgress{Total: len(s.Agents)}
+	for _, a := range s.Agents {
+		switch a.Phase {
+		case AgentOperationPending:
+			p.Pending++
+		case AgentOperationRunning:
+			p.Running++
+		case AgentOperationSucceeded:
+			p.Succeeded++
+		case AgentOperationFailed:
+			p.Failed++
+		}
+	}
+	s.Progress = p
+}
+
+// DeepCopyInto copies o into out
+func (o *AgentOperation) DeepCopyInto(out *AgentOperation) {
+	*out = *o
+	o.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if o.Spec.AgentSelector != nil {
+		out.Spec.AgentSelector = o.Spec.AgentSelector.DeepCopy()
+	}
+	out.Spec.Agents = copyStrings(o.Spec.Agents)
+	if o.Spec.Timeout != nil {
+		t := *o.Spec.Timeout
+		out.Spec.Timeout = &t
+	}
+	if o.Spec.SupportBundle != nil {
+		sb := *o.Spec.SupportBundle
+		out.Spec.SupportBundle = &sb
+	}
+	if o.Spec.Update != nil {
+		u := *o.Spec.Update
+		if u.MaintenanceWindow != nil {
+			w := *u.MaintenanceWindow
+			w.Days = copyStrings(w.Days)
+			u.MaintenanceWindow = &w
+		}
+		out.Spec.Update = &u
+	}
+	out.Status.StartTime = o.Status.StartTime.DeepCopy()
+	out.Status.CompletionTime = o.Status.CompletionTime.DeepCopy()
+	if o.Status.Agents != nil {
+		out.Status.Agents = append([]AgentOperationAgentStatus{}, o.Status.Agents...)
+	}
+}
+
+// DeepCopy returns a deep copy of o
+func (o *AgentOperation) DeepCopy() *AgentOperation {
+	if o == nil {
+		return nil
+	}
+	out := &AgentOperation{}
+	o.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (o *AgentOperation) DeepCopyObject() runtime.Object {
+	return o.DeepCopy()
+}
+
+// ToUnstructured converts o for use with the dynamic client
+func (o *AgentOperation) ToUnstructured() (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert AgentOperation: %w", err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// AgentOperationFromUnstructured converts an object returned by the dynamic
+// client
+func AgentOperationFromUnstructured(u *unstructured.Unstructured) (*AgentOperation, error) {
+	o := &AgentOperation{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, o); err != nil {
+		return nil, fmt.Errorf("could not convert AgentOperation: %w", err)
+	}
+	return o, nil
+}
+
+// AgentOperationList is a list of AgentOperation objects
+type AgentOperationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []AgentOperation `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object
+func (l *AgentOperationList) DeepCopyObject() runtime.Object {
+	if l == nil {
+		return nil
+	}
+	out := &AgentOperationList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	out.Items = make([]AgentOperation, len(l.Items))
+	for i := range l.Items {
+		l.Items[i].DeepCopyInto(&out.Items[i])
+	}
+	return out
+}
+
+// AgentOperationListFromUnstructured converts a list returned by the dynamic
+// client
+func AgentOperationListFromUnstructured(u *unstructured.UnstructuredList) (*AgentOperationList, error) {
+	l := &AgentOperationList{Items: make([]AgentOperation, 0, len(u.Items))}
+	l.SetResourceVersion(u.GetResourceVersion())
+	l.SetContinue(u.GetContinue())
+	for i := range u.Items {
+		o, err := AgentOperationFromUnstructured(&u.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		l.Items = append(l.Items, *o)
+	}
+	return l, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const AgentRegistrationKind = "AgentRegistration"
+
+// AgentRegistrationResource is the resource of AgentRegistration objects
+var AgentRegistrationResource = GroupVersion.WithResource("agentregistrations")
+
+type RegistrationPhase string
+
+const (
+	RegistrationPhaseProvisioned RegistrationPhase = "Provisioned"
+	RegistrationPhaseFailed      RegistrationPhase = "Failed"
+)
+
+// AgentRegistration declares an agent on the principal. The principal
+// provisions the agent's cluster secret and a bootstrap secret holding the
+// credentials and configuration the agent needs to connect. The name of the
+// AgentRegistration is the name of the agent.
+type AgentRegistration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AgentRegistrationSpec   `json:"spec,omitempty"`
+	Status AgentRegistrationStatus `json:"status,omitempty"`
+}
+
+// AgentRegistrationSpec is the desired configuration of an agent
+type AgentRegistrationSpec struct {
+	// Mode is the mode the agent runs in, managed or autonomous
+	Mode string `json:"mode"`
+	// AllowedNamespaces is the list of namespaces the agent may manage
+	// applications in, written to the bootstrap secret.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// Labels are added to the agent's cluster secret
+	Labels map[string]string `json:"labels,omitempty"`
+	// BootstrapSecretName is the name of the secret the agent's credentials
+	// and configuration are written to. Defaults to <name>-bootstrap.
+	BootstrapSecretName string `json:"bootstrapSecretName,omitempty"`
+	// Maintenance puts the agent into maintenance mode when true and takes
+	// it out of maintenance mode when false. If unset, the maintenance mode
+	// of the agent is left alone, so it can be managed by other means.
+	Maintenance *bool `json:"maintenance,omitempty"`
+}
+
+// AgentRegistrationStatus is the observed state of an AgentRegistration
+type AgentRegistrationStatus struct {
+	Phase   RegistrationPhase `json:"phase,omitempty"`
+	Message string            `json:"message,omitempty"`
+	// ClusterSecret is the name of the agent's cluster secret
+	ClusterSecret string `json:"clusterSecret,omitempty"`
+	// BootstrapSecret is the name of the agent's bootstrap secret
+	BootstrapSecret string `json:"bootstrapSecret,omitempty"`
+	// ObservedGeneration is the generation of the spec last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// BootstrapSecret returns the name of the bootstrap secret
+func (r *AgentRegistration) BootstrapSecret() string {
+	if r.Spec.BootstrapSecretName != "" {
+		return r.Spec.BootstrapSecretName
+	}
+	return r.Name + "-bootstrap"
+}
+
+// OwnerReference returns a reference to r, for objects provisioned for it
+func (r *AgentRegistration) OwnerReference() metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: GroupVersion.String(),
+		Kind:       AgentRegistrationKind,
+		Name:       r.Name,
+		UID:        r.UID,
+		Controller: &controller,
+	}
+}
+
+// DeepCopyInto copies r into out
+func (r *AgentRegistration) DeepCopyInto(out *AgentRegistration) {
+	*out = *r
+	r.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if r.Spec.AllowedNamespaces != nil {
+		out.Spec.AllowedNamespaces = append([]string{}, r.Spec.AllowedNamespaces...)
+	}
+	if r.Spec.Labels != nil {
+		out.Spec.Labels = make(map[string]string, len(r.Spec.Labels))
+		for k, v := range r.Spec.Labels {
+			out.Spec.Labels[k] = v
+		}
+	}
+	if r.Spec.Maintenance != nil {
+		maintenance := *r.Spec.Maintenance
+		out.Spec.Maintenance = &maintenance
+	}
+}
+
+// DeepCopy returns a deep copy of r
+func (r *AgentRegistration) DeepCopy() *AgentRegistration {
+	if r == nil {
+		return nil
+	}
+	out := &AgentRegistration{}
+	r.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (r *AgentRegistration) DeepCopyObject() runtime.Object {
+	return r.DeepCopy()
+}
+
+// ToUnstructured converts r for use with the dynamic client
+func (r *AgentRegistration) ToUnstructured() (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert AgentRegistration: %w", err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// AgentRegistrationFromUnstructured converts an object returned by the
+// dynamic client
+func AgentRegistrationFromUnstructured(u *unstructured.Unstructured) (*AgentRegistration, error) {
+	r := &AgentRegistration{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, r); err != nil {
+		return nil, fmt.Errorf("could not convert AgentRegistration: %w", err)
+	}
+	return r, nil
+}
+
+// AgentRegistrationList is a list of AgentRegistration objects
+type AgentRegistrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []AgentRegistration `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object
+func (l *AgentRegistrationList) DeepCopyObject() runtime.Object {
+	if l == nil {
+		return nil
+	}
+	out := &AgentRegistrationList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	out.Items = make([]AgentRegistration, len(l.Items))
+	for i := range l.Items {
+		l.Items[i].DeepCopyInto(&out.Items[i])
+	}
+	return out
+}
+
+// AgentRegistrationListFromUnstructured converts a list returned by the
+// dynamic client
+func AgentRegistrationListFromUnstructured(u *unstructured.UnstructuredList) (*AgentRegistrationList, error) {
+	l := &AgentRegistrationList{Items: make([]AgentRegistration, 0, len(u.Items))}
+	l.SetResourceVersion(u.GetResourceVersion())
+	l.SetContinue(u.GetContinue())
+	for i := range u.Items {
+		r, err := AgentRegistrationFromUnstructured(&u.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		l.Items = append(l.Items, *r)
+	}
+	return l, nil
+}
@@ -0,0 +1,373 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package v1alpha1 contains the custom resources of argocd-agent. They are
+accessed using the dynamic client, so no generated clientsets are required.
+*/
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const Group = "argocd-agent.argoproj-labs.io"
+const Version = "v1alpha1"
+
+var GroupVersion = schema.GroupVersion{Group: Group, Version: Version}
+
+const AgentStatusKind = "AgentStatus"
+
+// AgentStatusResource is the resource of AgentStatus objects
+var AgentStatusResource = GroupVersion.WithResource("agentstatuses")
+
+type ConnectionState string
+
+const (
+	ConnectionStateConnected    ConnectionState = "Connected"
+	ConnectionStateDisconnected ConnectionState = "Disconnected"
+)
+
+// AgentStatus reflects the state of an agent as seen by the principal. There
+// is one AgentStatus per agent, named after the agent, in the principal's
+// namespace. It is maintained by the principal and must not be edited.
+type AgentStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status AgentStatusStatus `json:"status,omitempty"`
+}
+
+// AgentStatusStatus is the observed state of an agent
+type AgentStatusStatus struct {
+	// ConnectionState is either Connected or Disconnected
+	ConnectionState ConnectionState `json:"connectionState,omitempty"`
+	// ConnectedSince is the time the current connection was established
+	ConnectedSince *metav1.Time `json:"connectedSince,omitempty"`
+	// LastDisconnectedAt is the time the agent last disconnected
+	LastDisconnectedAt *metav1.Time `json:"lastDisconnectedAt,omitempty"`
+	// LastHeartbeat is the time the last heartbeat was received from the agent
+	LastHeartbeat *metav1.Time `json:"lastHeartbeat,omitempty"`
+	// Mode is the mode the agent runs in, managed or autonomous
+	Mode string `json:"mode,omitempty"`
+	// Maintenance is true if the agent is in maintenance mode
+	Maintenance bool `json:"maintenance,omitempty"`
+	// Version is the version of the agent
+	Version string `json:"version,omitempty"`
+	// VersionSkew describes how far the agent's version is from the
+	// principal's
+	VersionSkew *VersionSkewStatus `json:"versionSkew,omitempty"`
+	// EventSchemaVersion is the event schema version negotiated with the
+	// agent
+	EventSchemaVersion int `json:"eventSchemaVersion,omitempty"`
+	// Capabilities lists the optional features supported by the agent
+	Capabilities []string `json:"capabilities,omitempty"`
+	// RoundTripTime is the latest measured round trip time to the agent
+	RoundTripTime string `json:"roundTripTime,omitempty"`
+	// ClockSkew is the latest measured clock offset of the agent
+	ClockSkew string `json:"clockSkew,omitempty"`
+	// Queues holds the number of events waiting to be processed
+	Queues QueueStatus `json:"queues,omitempty"`
+	// Streams holds the number of active streams to the agent
+	Streams StreamStatus `json:"streams,omitempty"`
+	// ConfigGeneration is the generation of the runtime configuration last
+	// applied by the agent
+	ConfigGeneration int64 `json:"configGeneration,omitempty"`
+	// ConfigAppliedAt is the time the agent reported ConfigGeneration
+	ConfigAppliedAt *metav1.Time `json:"configAppliedAt,omitempty"`
+	// Permissions is the result of the agent's last permission check
+	Permissions *PermissionStatus `json:"permissions,omitempty"`
+	// ClientCertificate is the client certificate the agent last
+	// authenticated with
+	ClientCertificate *ClientCertificateStatus `json:"clientCertificate,omitempty"`
+	// ResourceFilter is the filter the agent applies to the resource
+	// statuses of the Applications it sends
+	ResourceFilter *ResourceFilterStatus `json:"resourceFilter,omitempty"`
+	// ConnectionHistory lists the times the agent connected and
+	// disconnected within the principal's retention window, oldest first
+	ConnectionHistory []ConnectionRecord `json:"connectionHistory,omitempty"`
+	// LastUpdated is the time this status was last updated by the principal
+	LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
+}
+
+// ConnectionEventType is the type of an entry of an agent's connection
+// history
+type ConnectionEventType string
+
+const (
+	ConnectionEventConnected    ConnectionEventType = "Connected"
+	ConnectionEventDisconnected ConnectionEventType = "Disconnected"
+)
+
+// ConnectionRecord is an entry of an agent's connection history
+type ConnectionRecord struct {
+	// Type is either Connected or Disconnected
+	Type ConnectionEventType `json:"type"`
+	// Time is the time the agent connected or disconnected
+	Time metav1.Time `json:"time"`
+	// RemoteAddress is the address the agent connected from
+	RemoteAddress string `json:"remoteAddress,omitempty"`
+	// Reason tells why the agent disconnected
+	Reason string `json:"reason,omitempty"`
+}
+
+// QueueStatus holds the depth of the queues between principal and agent
+type QueueStatus struct {
+	// Send is the number of events waiting to be sent to the agent
+	Send int `json:"send"`
+	// Receive is the number of events received from the agent waiting to be
+	// processed
+	Receive int `json:"receive"`
+}
+
+// StreamStatus holds the number of active streams per kind
+type StreamStatus struct {
+	Logs      int `json:"logs"`
+	Terminals int `json:"terminals"`
+}
+
+// VersionSkewStatus compares the version of an agent to the principal's
+type VersionSkewStatus struct {
+	// PrincipalVersion is the version of the principal
+	PrincipalVersion string `json:"principalVersion,omitempty"`
+	// MinorVersionsBehind is the number of minor versions the agent is
+	// behind the principal, negative if it is ahead
+	MinorVersionsBehind int `json:"minorVersionsBehind,omitempty"`
+	// Policy is the principal's version skew policy
+	Policy string `json:"policy,omitempty"`
+	// WithinPolicy is true if the agent's version is acceptable under the
+	// policy
+	WithinPolicy bool `json:"withinPolicy"`
+}
+
+// PermissionStatus is the result of a check of the agent's Kubernetes
+// permissions against the ones needed for its enabled features
+type PermissionStatus struct {
+	// Features are the features the permissions were checked for
+	Features []string `json:"features,omitempty"`
+	// Missing lists the permissions the agent needs but lacks
+	Missing []string `json:"missing,omitempty"`
+	// Excess lists the permissions the agent has but doesn't need
+	Excess []string `json:"excess,omitempty"`
+	// Incomplete is true if not all permissions of the agent could be
+	// determined
+	Incomplete bool `json:"incomplete,omitempty"`
+	// Error is set if the check failed
+	Error string `json:"error,omitempty"`
+	// CheckedAt is the time of the check
+	CheckedAt metav1.Time `json:"checkedAt,omitempty"`
+}
+
+// ClientCertificateStatus describes the client certificate of an agent
+type ClientCertificateStatus struct {
+	// Identity is the certificate field the agent's identity was taken from
+	Identity string `json:"identity,omitempty"`
+	// Subject is the subject of the certificate
+	Subject string `json:"subject,omitempty"`
+	// Issuer is the issuer of the certificate
+	Issuer string `json:"issuer,omitempty"`
+	// SerialNumber is the serial number of the certificate
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// Fingerprint is the SHA-256 fingerprint of the certificate
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// URIs are the URI SANs of the certificate
+	URIs []string `json:"uris,omitempty"`
+	// NotAfter is the expiry time of the certificate
+	NotAfter metav1.Time `json:"notAfter,omitempty"`
+	// Chain lists the subjects of the certificates the certificate was
+	// verified with, from its issuer up to the root
+	Chain []string `json:"chain,omitempty"`
+}
+
+// ResourceFilterRule matches resources by API group, kind and cluster
+type ResourceFilterRule struct {
+	APIGroups []string `json:"apiGroups,omitempty"`
+	Kinds     []string `json:"kinds,omitempty"`
+	Clusters  []string `json:"clusters,omitempty"`
+}
+
+// ResourceFilterStatus describes which resource statuses the agent leaves
+// out of the Applications it sends to the principal
+type ResourceFilterStatus struct {
+	// Inclusions are the rules a resource must match for its status to be
+	// sent. If empty, all resources are included.
+	Inclusions []ResourceFilterRule `json:"inclusions,omitempty"`
+	// Exclusions are the rules of resources whose status is not sent
+	Exclusions []ResourceFilterRule `json:"exclusions,omitempty"`
+	// AppliedAt is the time the agent started applying the filter
+	AppliedAt metav1.Time `json:"appliedAt,omitempty"`
+}
+
+// DeepCopyInto copies r into out
+func (r *ResourceFilterRule) DeepCopyInto(out *ResourceFilterRule) {
+	out.APIGroups = append([]string(nil), r.APIGroups...)
+	out.Kinds = append([]string(nil), r.Kinds...)
+	out.Clusters = append([]string(nil), r.Clusters...)
+}
+
+// DeepCopyInto copies f into out
+func (f *ResourceFilterStatus) DeepCopyInto(out *ResourceFilterStatus) {
+	*out = *f
+	out.Inclusions = copyResourceFilterRules(f.Inclusions)
+	out.Exclusions = copyResourceFilterRules(f.Exclusions)
+	f.AppliedAt.DeepCopyInto(&out.AppliedAt)
+}
+
+func copyResourceFilterRules(rules []ResourceFilterRule) []ResourceFilterRule {
+	if rules == nil {
+		return nil
+	}
+	out := make([]ResourceFilterRule, len(rules))
+	for i := range rules {
+		rules[i].DeepCopyInto(&out[i])
+	}
+	return out
+}
+
+// DeepCopyInto copies c into out
+func (c *ClientCertificateStatus) DeepCopyInto(out *ClientCertificateStatus) {
+	*out = *c
+	out.URIs = append([]string(nil), c.URIs...)
+	out.Chain = append([]string(nil), c.Chain...)
+	c.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy returns a deep copy of c
+func (c *ClientCertificateStatus) DeepCopy() *ClientCertificateStatus {
+	if c == nil {
+		return nil
+	}
+	out := &ClientCertificateStatus{}
+	c.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies r into out
+func (r *ConnectionRecord) DeepCopyInto(out *ConnectionRecord) {
+	*out = *r
+	r.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopyInto copies p into out
+func (p *PermissionStatus) DeepCopyInto(out *PermissionStatus) {
+	*out = *p
+	out.Features = append([]string(nil), p.Features...)
+	out.Missing = append([]string(nil), p.Missing...)
+	out.Excess = append([]string(nil), p.Excess...)
+	p.CheckedAt.DeepCopyInto(&out.CheckedAt)
+}
+
+// DeepCopyInto copies s into out
+func (s *AgentStatusStatus) DeepCopyInto(out *AgentStatusStatus) {
+	*out = *s
+	if s.ConnectedSince != nil {
+		out.ConnectedSince = s.ConnectedSince.DeepCopy()
+	}
+	if s.LastDisconnectedAt != nil {
+		out.LastDisconnectedAt = s.LastDisconnectedAt.DeepCopy()
+	}
+	if s.LastHeartbeat != nil {
+		out.LastHeartbeat = s.LastHeartbeat.DeepCopy()
+	}
+	if s.ConfigAppliedAt != nil {
+		out.ConfigAppliedAt = s.ConfigAppliedAt.DeepCopy()
+	}
+	if s.VersionSkew != nil {
+		skew := *s.VersionSkew
+		out.VersionSkew = &skew
+	}
+	if s.Capabilities != nil {
+		out.Capabilities = append([]string{}, s.Capabilities...)
+	}
+	if s.Permissions != nil {
+		out.Permissions = &PermissionStatus{}
+		s.Permissions.DeepCopyInto(out.Permissions)
+	}
+	if s.ClientCertificate != nil {
+		out.ClientCertificate = &ClientCertificateStatus{}
+		s.ClientCertificate.DeepCopyInto(out.ClientCertificate)
+	}
+	if s.ResourceFilter != nil {
+		out.ResourceFilter = &ResourceFilterStatus{}
+		s.ResourceFilter.DeepCopyInto(out.ResourceFilter)
+	}
+	if s.ConnectionHistory != nil {
+		out.ConnectionHistory = make([]ConnectionRecord, len(s.ConnectionHistory))
+		for i := range s.ConnectionHistory {
+			s.ConnectionHistory[i].DeepCopyInto(&out.ConnectionHistory[i])
+		}
+	}
+	s.LastUpdated.DeepCopyInto(&out.LastUpdated)
+}
+
+// DeepCopyInto copies a into out
+func (a *AgentStatus) DeepCopyInto(out *AgentStatus) {
+	*out = *a
+	out.TypeMeta = a.TypeMeta
+	a.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	a.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy returns a deep copy of a
+func (a *AgentStatus) DeepCopy() *AgentStatus {
+	if a == nil {
+		return nil
+	}
+	out := &AgentStatus{}
+	a.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (a *AgentStatus) DeepCopyObject() runtime.Object {
+	return a.DeepCopy()
+}
+
+// NewAgentStatus returns an empty AgentStatus for the given agent
+func NewAgentStatus(namespace, agentName string) *AgentStatus {
+	return &AgentStatus{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: GroupVersion.String(),
+			Kind:       AgentStatusKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentName,
+			Namespace: namespace,
+		},
+	}
+}
+
+// ToUnstructured converts a for use with the dynamic client
+func (a *AgentStatus) ToUnstructured() (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(a)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert AgentStatus: %w", err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// AgentStatusFromUnstructured converts an object returned by the dynamic
+// client
+func AgentStatusFromUnstructured(u *unstructured.Unstructured) (*AgentStatus, error) {
+	a := &AgentStatus{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, a); err != nil {
+		return nil, fmt.Errorf("could not convert AgentStatus: %w", err)
+	}
+	return a, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/authapi"
+	grpchttp1client "golang.stackrox.io/grpc-http1/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// joinConfig holds the configuration for joining the principal with a join
+// token.
+type joinConfig struct {
+	token      string
+	kube       kubernetes.Interface
+	namespace  string
+	secretName string
+}
+
+// WithJoinToken configures the Remote to exchange the one-time join token
+// for a client certificate before it first connects. The issued certificate,
+// its private key and the CA certificate are stored in the TLS secret
+// specified by namespace and secretName, so they can be used on subsequent
+// starts of the agent.
+func WithJoinToken(token string, kube kubernetes.Interface, namespace, secretName string) RemoteOption {
+	return func(r *Remote) error {
+		if token == "" {
+			return fmt.Errorf("join token must not be empty")
+		}
+		r.join = &joinConfig{token: token, kube: kube, namespace: namespace, secretName: secretName}
+		return nil
+	}
+}
+
+// Join exchanges the configured join token for a client certificate. The
+// private key is generated locally and only a certificate signing request is
+// sent to the principal. On success, the certificate is used for all
+// subsequent connections.
+func (r *Remote) Join(ctx context.Context) error {
+	if r.join == nil {
+		return fmt.Errorf("no join token configured")
+	}
+	logCtx := log().WithField("method", "Join")
+
+	csr, key, err := tlsutil.GenerateCertificateRequest("argocd-agent")
+	if err != nil {
+		return fmt.Errorf("could not generate certificate request: %w", err)
+	}
+
+	conn, err := r.dialJoin(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := authapi.NewAuthenticationClient(conn).Join(ctx, &authapi.JoinRequest{
+		Token:   r.join.token,
+		Csr:     csr,
+		Mode:    r.clientMode.String(),
+		Version: r.agentVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("could not join principal: %w", err)
+	}
+	cert, err := tls.X509KeyPair([]byte(resp.Certificate), []byte(key))
+	if err != nil {
+		return fmt.Errorf("principal returned an invalid certificate: %w", err)
+	}
+	r.tlsConfig.Certificates = append(r.tlsConfig.Certificates, cert)
+	r.clientID = resp.AgentName
+	logCtx.WithField("client", resp.AgentName).Info("Joined principal and received client certificate")
+
+	// The join token has been used up at this point, so we keep using the
+	// certificate even if it cannot be stored.
+	if err := r.storeJoinCertificate(ctx, resp.Certificate, key, resp.CaCertificate); err != nil {
+		logCtx.WithError(err).Error("Could not store client certificate, the agent will not be able to reconnect after a restart")
+	}
+	r.join = nil
+	return nil
+}
+
+func (r *Remote) dialJoin(ctx context.Context) (*grpc.ClientConn, error) {
+	if r.enableWebSocket {
+		var tlsCfg *tls.Config
+		if !r.insecurePlaintext {
+			tlsCfg = r.tlsConfig
+		}
+		return grpchttp1client.ConnectViaProxy(ctx, r.Addr(), tlsCfg, grpchttp1client.UseWebSocket(true))
+	}
+	creds := credentials.NewTLS(r.tlsConfig)
+	if r.insecurePlaintext {
+		creds = insecure.NewCredentials()
+	}
+	return grpc.NewClient(r.dialTarget(), grpc.WithTransportCredentials(creds), grpc.WithContextDialer(r.dialContext))
+}
+
+func (r *Remote) storeJoinCertificate(ctx context.Context, cert, key, ca string) error {
+	if r.join.kube == nil {
+		return nil
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.join.secretName,
+			Namespace: r.join.namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte(cert),
+			"tls.key": []byte(key),
+			"ca.crt":  []byte(ca),
+		},
+	}
+	secrets := r.join.kube.CoreV1().Secrets(r.join.namespace)
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"math/big"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/jointoken"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj-labs/argocd-agent/test/fake/testcerts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_Join(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	basePath := path.Join(tempDir, "certs")
+	testcerts.WriteSelfSignedCert(t, "rsa", basePath, x509.Certificate{SerialNumber: big.NewInt(1)})
+
+	principalKube := kube.NewKubernetesFakeClientWithApps("default")
+	caCert, caKey, err := tlsutil.GenerateCaCertificate(config.SecretNamePrincipalCA)
+	require.NoError(t, err)
+	_, err = principalKube.Clientset.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.SecretNamePrincipalCA, Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{"tls.crt": []byte(caCert), "tls.key": []byte(caKey)},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	s, err := principal.NewServer(ctx, principalKube, "default",
+		principal.WithGRPC(true),
+		principal.WithListenerPort(0),
+		principal.WithTLSKeyPairFromPath(basePath+".crt", basePath+".key"),
+		principal.WithGeneratedTokenSigningKey(),
+		principal.WithJoinTokens(true),
+	)
+	require.NoError(t, err)
+	am := userpass.NewUserPassAuthentication("")
+	am.UpsertUser("agent-a", "password")
+	s.AuthMethodsForE2EOnly().RegisterMethod("userpass", am)
+	require.NoError(t, s.Start(ctx, make(chan error)))
+	t.Cleanup(func() {
+		require.NoError(t, s.Shutdown())
+	})
+
+	t.Run("Join and connect", func(t *testing.T) {
+		tok, err := jointoken.Create(ctx, principalKube.Clientset, "default", "agent-a", time.Hour)
+		require.NoError(t, err)
+		agentKube := kube.NewFakeKubeClient("agent")
+		r, err := NewRemote("127.0.0.1", s.ListenerForE2EOnly().Port(),
+			WithInsecureSkipTLSVerify(),
+			WithAuth("userpass", auth.Credentials{userpass.ClientIDField: "agent-a", userpass.ClientSecretField: "password"}),
+			WithClientMode(types.AgentModeManaged),
+			WithJoinToken(tok.String(), agentKube, "agent", "client-tls"),
+		)
+		require.NoError(t, err)
+		cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		require.NoError(t, r.Connect(cctx, false))
+		assert.Nil(t, r.join)
+		require.Len(t, r.tlsConfig.Certificates, 1)
+
+		cert, err := tlsutil.TLSCertFromSecret(ctx, agentKube, "agent", "client-tls")
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		assert.Equal(t, "agent-a", leaf.Subject.CommonName)
+		sec, err := agentKube.CoreV1().Secrets("agent").Get(ctx, "client-tls", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, caCert, string(sec.Data["ca.crt"]))
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		agentKube := kube.NewFakeKubeClient("agent")
+		r, err := NewRemote("127.0.0.1", s.ListenerForE2EOnly().Port(),
+			WithInsecureSkipTLSVerify(),
+			WithClientMode(types.AgentModeManaged),
+			WithJoinToken("abcdef.0123456789abcdef", agentKube, "agent", "client-tls"),
+		)
+		require.NoError(t, err)
+		cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		assert.Error(t, r.Join(cctx))
+		assert.NotNil(t, r.join)
+		_, err = agentKube.CoreV1().Secrets("agent").Get(ctx, "client-tls", metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,346 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package proxy provides a client for the principal's resource proxy. It allows
+tooling outside of Argo CD to read live resources, events and pod logs from
+the clusters of connected agents, without dealing with the proxy's wire
+formats.
+
+The resource proxy routes every request to the agent identified by the
+credentials of the request, i.e. the subject of a resource proxy token or the
+identity of a TLS client certificate. A Client therefore always talks to a
+single agent.
+*/
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultTimeout is the timeout applied to non-streaming requests, unless
+// changed with WithTimeout.
+const DefaultTimeout = 60 * time.Second
+
+// maxErrorBodySize is the number of bytes read from the body of an error
+// response to build the error message.
+const maxErrorBodySize = 4096
+
+var (
+	// ErrUnauthorized is matched by errors returned when the principal
+	// rejected the client's credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrNotFound is matched by errors returned when the requested object
+	// does not exist on the agent's cluster.
+	ErrNotFound = errors.New("not found")
+
+	// ErrAgentNotConnected is matched by errors returned when the agent the
+	// credentials belong to is not connected to the principal.
+	ErrAgentNotConnected = errors.New("agent not connected")
+)
+
+// APIError is returned for requests the resource proxy answered with a non
+// successful status code.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Message is the error message sent by the principal or the agent
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("resource proxy returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("resource proxy returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is allows matching an APIError against ErrUnauthorized, ErrNotFound and
+// ErrAgentNotConnected using errors.Is.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAgentNotConnected:
+		return e.StatusCode == http.StatusBadGateway
+	}
+	return false
+}
+
+// Client is a client for the principal's resource proxy. A Client is safe
+// for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	timeout    time.Duration
+	userAgent  string
+
+	// token returns the bearer token to send with each request. If nil,
+	// the client authenticates with its TLS client certificate only.
+	token func() (string, error)
+
+	tlsConfig *tls.Config
+	transport http.RoundTripper
+}
+
+// Option is a function to configure a Client.
+type Option func(c *Client) error
+
+// New returns a new Client for the resource proxy at baseURL, e.g.
+// https://argocd-agent-resource-proxy:9090.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid base URL %s: scheme must be https or http", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	c := &Client{
+		baseURL:   u,
+		timeout:   DefaultTimeout,
+		userAgent: "argocd-agent-proxy-client",
+		tlsConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.httpClient == nil {
+		transport := c.transport
+		if transport == nil {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.TLSClientConfig = c.tlsConfig
+			transport = t
+		}
+		c.httpClient = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// WithBearerToken authenticates requests with the given resource proxy
+// token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) error {
+		if token == "" {
+			return fmt.Errorf("token must not be empty")
+		}
+		c.token = func() (string, error) { return token, nil }
+		return nil
+	}
+}
+
+// WithBearerTokenFile authenticates requests with the resource proxy token
+// stored in path. The file is read for every request, so that rotated tokens
+// are picked up without recreating the Client.
+func WithBearerTokenFile(path string) Option {
+	return func(c *Client) error {
+		if path == "" {
+			return fmt.Errorf("token file must not be empty")
+		}
+		c.token = func() (string, error) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("could not read token: %w", err)
+			}
+			token := strings.TrimSpace(string(b))
+			if token == "" {
+				return "", fmt.Errorf("token file %s is empty", path)
+			}
+			return token, nil
+		}
+		return nil
+	}
+}
+
+// WithClientCertificate authenticates requests with the TLS client
+// certificate and private key stored in the given PEM files.
+func WithClientCertificate(certPath, keyPath string) Option {
+	return func(c *Client) error {
+		cert, err := tlsutil.TLSCertFromFile(certPath, keyPath, false)
+		if err != nil {
+			return fmt.Errorf("could not load client certificate: %w", err)
+		}
+		c.tlsConfig.Certificates = []tls.Certificate{cert}
+		return nil
+	}
+}
+
+// WithRootCAs verifies the principal's certificate against the given pool
+// instead of the system's trust store.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) error {
+		c.tlsConfig.RootCAs = pool
+		return nil
+	}
+}
+
+// WithRootCAFile verifies the principal's certificate against the PEM
+// encoded CA certificates stored in path.
+func WithRootCAFile(path string) Option {
+	return func(c *Client) error {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificate found in %s", path)
+		}
+		c.tlsConfig.RootCAs = pool
+		return nil
+	}
+}
+
+// WithInsecureSkipVerify disables verification of the principal's
+// certificate. This should only be used for testing.
+func WithInsecureSkipVerify() Option {
+	return func(c *Client) error {
+		c.tlsConfig.InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithTransport sets the RoundTripper used for requests. TLS related options
+// have no effect when a custom transport is used.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) error {
+		c.transport = rt
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for requests. TLS related options
+// and WithTransport have no effect when a custom client is used. The client
+// must not set a timeout, or log streams will be cut off.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) error {
+		c.httpClient = hc
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout for non-streaming requests. A value of 0
+// disables the timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return fmt.Errorf("timeout must not be negative")
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with requests.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) error {
+		c.userAgent = ua
+		return nil
+	}
+}
+
+// newRequest builds a GET request for the given path and query, carrying the
+// client's credentials.
+func (c *Client) newRequest(ctx context.Context, path string, query url.Values) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path = c.baseURL.Path + path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.token != nil {
+		token, err := c.token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// do sends req and returns the response if it was successful, or an
+// *APIError otherwise.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, errorFromResponse(resp)
+	}
+	return resp, nil
+}
+
+// getJSON sends a GET request for path and decodes the JSON response into
+// out.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out any) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req, err := c.newRequest(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
+
+// errorFromResponse builds an *APIError from an unsuccessful response. If
+// the body holds a Kubernetes Status object, its message is used.
+func errorFromResponse(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	status := metav1.Status{}
+	if err := json.Unmarshal(body, &status); err == nil && status.Kind == "Status" {
+		apiErr.Message = status.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
@@ -0,0 +1,272 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, opts...)
+	require.NoError(t, err)
+	return c
+}
+
+func Test_New(t *testing.T) {
+	t.Run("Invalid scheme", func(t *testing.T) {
+		_, err := New("ftp://localhost")
+		assert.ErrorContains(t, err, "scheme")
+	})
+	t.Run("Empty token", func(t *testing.T) {
+		_, err := New("https://localhost", WithBearerToken(""))
+		assert.Error(t, err)
+	})
+	t.Run("Missing CA file", func(t *testing.T) {
+		_, err := New("https://localhost", WithRootCAFile("/nonexistent"))
+		assert.Error(t, err)
+	})
+}
+
+func Test_GetResource(t *testing.T) {
+	t.Run("Namespaced resource with bearer token", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/apis/apps/v1/namespaces/guestbook/deployments/web", r.URL.Path)
+			assert.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"guestbook"}}`)
+		}, WithBearerToken("s3cr3t"))
+		obj, err := c.GetResource(context.Background(), schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, "guestbook", "web")
+		require.NoError(t, err)
+		assert.Equal(t, "Deployment", obj.GetKind())
+		assert.Equal(t, "web", obj.GetName())
+	})
+	t.Run("Cluster scoped core resource", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/namespaces/guestbook", r.URL.Path)
+			assert.Empty(t, r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"guestbook"}}`)
+		})
+		obj, err := c.GetResource(context.Background(), schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "", "guestbook")
+		require.NoError(t, err)
+		assert.Equal(t, "guestbook", obj.GetName())
+	})
+	t.Run("Token is read from file on every request", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(path, []byte("one\n"), 0600))
+		var got []string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{}`)
+		}, WithBearerTokenFile(path))
+		gvr := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+		_, err := c.GetResource(context.Background(), gvr, "default", "p")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte("two"), 0600))
+		_, err = c.GetResource(context.Background(), gvr, "default", "p")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Bearer one", "Bearer two"}, got)
+	})
+	t.Run("Errors are mapped", func(t *testing.T) {
+		status := http.StatusBadGateway
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			if status == http.StatusNotFound {
+				fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","message":"pods \"p\" not found"}`)
+			}
+		})
+		gvr := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+		_, err := c.GetResource(context.Background(), gvr, "default", "p")
+		assert.ErrorIs(t, err, ErrAgentNotConnected)
+
+		status = http.StatusNotFound
+		_, err = c.GetResource(context.Background(), gvr, "default", "p")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorContains(t, err, `pods "p" not found`)
+
+		status = http.StatusUnauthorized
+		_, err = c.GetResource(context.Background(), gvr, "default", "p")
+		assert.ErrorIs(t, err, ErrUnauthorized)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func Test_Events(t *testing.T) {
+	pages := map[string]corev1.EventList{
+		"": {
+			ListMeta: metav1.ListMeta{Continue: "page2"},
+			Items:    []corev1.Event{{ObjectMeta: metav1.ObjectMeta{Name: "e1"}}, {ObjectMeta: metav1.ObjectMeta{Name: "e2"}}},
+		},
+		"page2": {
+			Items: []corev1.Event{{ObjectMeta: metav1.ObjectMeta{Name: "e3"}}},
+		},
+	}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/guestbook/events", r.URL.Path)
+		assert.Equal(t, "involvedObject.kind=Pod,involvedObject.name=web-0", r.URL.Query().Get("fieldSelector"))
+		assert.Equal(t, "2", r.URL.Query().Get("limit"))
+		_ = json.NewEncoder(w).Encode(pages[r.URL.Query().Get("continue")])
+	})
+	opts := ListEventsOptions{InvolvedObjectKind: "Pod", InvolvedObjectName: "web-0", Limit: 2}
+
+	t.Run("Single page", func(t *testing.T) {
+		list, err := c.ListEvents(context.Background(), "guestbook", opts)
+		require.NoError(t, err)
+		assert.Len(t, list.Items, 2)
+		assert.Equal(t, "page2", list.Continue)
+	})
+	t.Run("Iterate all pages", func(t *testing.T) {
+		names := []string{}
+		for ev, err := range c.Events(context.Background(), "guestbook", opts) {
+			require.NoError(t, err)
+			names = append(names, ev.Name)
+		}
+		assert.Equal(t, []string{"e1", "e2", "e3"}, names)
+	})
+	t.Run("Stop iteration early", func(t *testing.T) {
+		names := []string{}
+		for ev := range c.Events(context.Background(), "guestbook", opts) {
+			names = append(names, ev.Name)
+			break
+		}
+		assert.Equal(t, []string{"e1"}, names)
+	})
+}
+
+func Test_StreamLogs(t *testing.T) {
+	t.Run("Lines, stats and eof", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/namespaces/default/pods/web-0/log", r.URL.Path)
+			assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+			assert.Equal(t, "app", r.URL.Query().Get("container"))
+			assert.Equal(t, "10", r.URL.Query().Get("tailLines"))
+			assert.Equal(t, "true", r.URL.Query().Get("timestamps"))
+			assert.NotEmpty(t, r.Header.Get("X-Request-ID"))
+			fmt.Fprint(w, "retry: 3000\n\n")
+			fmt.Fprint(w, "id: 2025-01-01T00:00:00.1Z\ndata: 2025-01-01T00:00:00.1Z hello\n\n")
+			fmt.Fprint(w, ": heartbeat\n\n")
+			fmt.Fprint(w, "id: 2025-01-01T00:00:01Z\ndata: 2025-01-01T00:00:01Z world\n\n")
+			fmt.Fprint(w, "event: stats\ndata: {\"lines\":2,\"bytes\":64}\n\n")
+			fmt.Fprint(w, "event: eof\ndata: \n\n")
+		})
+		stream, err := c.StreamLogs(context.Background(), "default", "web-0", LogOptions{Container: "app", TailLines: 10})
+		require.NoError(t, err)
+		defer stream.Close()
+		lines := []LogLine{}
+		for stream.Next() {
+			lines = append(lines, stream.Line())
+		}
+		require.NoError(t, stream.Err())
+		require.Len(t, lines, 2)
+		assert.Equal(t, "hello", lines[0].Message)
+		assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 100000000, time.UTC), lines[0].Timestamp)
+		assert.Equal(t, "world", lines[1].Message)
+		stats, ok := stream.Stats()
+		require.True(t, ok)
+		assert.Equal(t, LogStats{Lines: 2, Bytes: 64}, stats)
+	})
+	t.Run("Error event", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "event: error\ndata: container not found\n\n")
+		})
+		stream, err := c.StreamLogs(context.Background(), "default", "web-0", LogOptions{})
+		require.NoError(t, err)
+		assert.False(t, stream.Next())
+		var streamErr *StreamError
+		require.ErrorAs(t, stream.Err(), &streamErr)
+		assert.Equal(t, "container not found", streamErr.Message)
+	})
+	t.Run("Request is rejected", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		})
+		_, err := c.StreamLogs(context.Background(), "default", "web-0", LogOptions{})
+		assert.ErrorIs(t, err, ErrAgentNotConnected)
+	})
+	t.Run("Follow stream resumes after connection loss", func(t *testing.T) {
+		var requests atomic.Int32
+		var requestIDs [2]string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			n := requests.Add(1)
+			assert.Equal(t, "true", r.URL.Query().Get("follow"))
+			requestIDs[n-1] = r.Header.Get("X-Request-ID")
+			fmt.Fprint(w, "retry: 10\n\n")
+			switch n {
+			case 1:
+				assert.Empty(t, r.Header.Get("Last-Event-ID"))
+				fmt.Fprint(w, "id: 2025-01-01T00:00:00Z\ndata: 2025-01-01T00:00:00Z one\n\n")
+			case 2:
+				assert.Equal(t, "2025-01-01T00:00:00Z", r.Header.Get("Last-Event-ID"))
+				fmt.Fprint(w, "id: 2025-01-01T00:00:01Z\ndata: 2025-01-01T00:00:01Z two\n\n")
+				fmt.Fprint(w, "event: eof\ndata: \n\n")
+			}
+		})
+		stream, err := c.StreamLogs(context.Background(), "default", "web-0", LogOptions{Follow: true})
+		require.NoError(t, err)
+		messages := []string{}
+		for line, err := range stream.Lines() {
+			require.NoError(t, err)
+			messages = append(messages, line.Message)
+		}
+		assert.Equal(t, []string{"one", "two"}, messages)
+		assert.EqualValues(t, 2, requests.Load())
+		assert.Equal(t, requestIDs[0], requestIDs[1])
+	})
+	t.Run("Static stream does not reconnect", func(t *testing.T) {
+		var requests atomic.Int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			fmt.Fprint(w, "id: 1\ndata: no timestamp\n\n")
+		})
+		stream, err := c.StreamLogs(context.Background(), "default", "web-0", LogOptions{})
+		require.NoError(t, err)
+		require.True(t, stream.Next())
+		assert.Equal(t, LogLine{Message: "no timestamp"}, stream.Line())
+		assert.False(t, stream.Next())
+		assert.Error(t, stream.Err())
+		assert.EqualValues(t, 1, requests.Load())
+	})
+	t.Run("Reconnects are limited", func(t *testing.T) {
+		var requests atomic.Int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) > 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, "retry: 1\n\n")
+		})
+		stream, err := c.StreamLogs(context.Background(), "default", "web-0", LogOptions{Follow: true, MaxReconnects: 2})
+		require.NoError(t, err)
+		assert.False(t, stream.Next())
+		assert.ErrorIs(t, stream.Err(), ErrAgentNotConnected)
+		assert.EqualValues(t, 3, requests.Load())
+	})
+}
@@ -0,0 +1,383 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultMaxReconnects is the number of times a follow log stream is
+// re-established after its connection was lost, unless set in LogOptions.
+const DefaultMaxReconnects = 5
+
+// defaultReconnectDelay is the delay before reconnecting a lost log stream
+// if the principal didn't send a retry hint.
+const defaultReconnectDelay = 3 * time.Second
+
+// LogOptions configures a log stream requested with StreamLogs.
+type LogOptions struct {
+	// Container is the container to return logs for. It may be empty for
+	// pods with a single container.
+	Container string
+	// Follow keeps the stream open and returns new log lines as they are
+	// written.
+	Follow bool
+	// Previous returns the logs of the previous instance of the container.
+	Previous bool
+	// SinceTime returns only lines written after the given time.
+	SinceTime time.Time
+	// SinceSeconds returns only lines written in the given number of
+	// seconds before the request. It is ignored if SinceTime is set.
+	SinceSeconds int64
+	// TailLines returns only the given number of most recent lines. Zero
+	// means all lines.
+	TailLines int64
+	// MaxReconnects is the number of times a follow stream is re-established
+	// after the connection to the principal was lost. Zero means
+	// DefaultMaxReconnects, a negative value disables reconnecting.
+	MaxReconnects int
+}
+
+// LogLine is a single line of a pod's log.
+type LogLine struct {
+	// Timestamp is the time the line was written by the container. It is
+	// zero if the line carried no timestamp.
+	Timestamp time.Time
+	// Message is the log line without the timestamp and line terminator.
+	Message string
+}
+
+// LogStats is the summary of a log stream the principal sends when the
+// stream ends.
+type LogStats struct {
+	// Lines is the number of lines sent by the agent
+	Lines int64 `json:"lines"`
+	// Bytes is the number of bytes sent by the agent
+	Bytes int64 `json:"bytes"`
+	// Truncated is true if the agent did not send the complete logs, e.g.
+	// because they exceeded its size limit.
+	Truncated bool `json:"truncated"`
+	// Resumes is the number of times the agent resumed the stream after it
+	// was interrupted.
+	Resumes int32 `json:"resumes"`
+}
+
+// StreamError is returned by LogStream.Err when the principal terminated
+// the stream with an error event.
+type StreamError struct {
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return "log stream failed: " + e.Message
+}
+
+// LogStream is an iterator over the lines of a pod's log. It must be closed
+// when no longer used. A LogStream is not safe for concurrent use.
+//
+//	stream, err := client.StreamLogs(ctx, "default", "my-pod", proxy.LogOptions{Follow: true})
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//	for stream.Next() {
+//		fmt.Println(stream.Line().Message)
+//	}
+//	return stream.Err()
+type LogStream struct {
+	ctx       context.Context
+	client    *Client
+	path      string
+	query     url.Values
+	requestID string
+	follow    bool
+
+	maxReconnects int
+	reconnects    int
+	retry         time.Duration
+
+	body   io.ReadCloser
+	reader *bufio.Reader
+
+	lastEventID string
+	line        LogLine
+	stats       *LogStats
+	err         error
+	done        bool
+}
+
+// StreamLogs requests the log of the given pod from the agent's cluster.
+// It returns once the principal accepted the request, the lines are then
+// read from the returned LogStream.
+func (c *Client) StreamLogs(ctx context.Context, namespace, pod string, opts LogOptions) (*LogStream, error) {
+	if namespace == "" || pod == "" {
+		return nil, fmt.Errorf("namespace and pod must not be empty")
+	}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	s := &LogStream{
+		ctx:           ctx,
+		client:        c,
+		path:          resourcePath(gvr, namespace, pod) + "/log",
+		query:         opts.query(),
+		requestID:     uuid.NewString(),
+		follow:        opts.Follow,
+		maxReconnects: opts.MaxReconnects,
+		retry:         defaultReconnectDelay,
+	}
+	if s.maxReconnects == 0 {
+		s.maxReconnects = DefaultMaxReconnects
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Next advances the stream to the next log line, which is then available
+// through Line. It returns false when the stream ended or failed, or the
+// context was cancelled; Err tells these cases apart.
+func (s *LogStream) Next() bool {
+	for !s.done {
+		ev, err := s.readEvent()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				s.fail(s.ctx.Err())
+			} else if err := s.reconnect(err); err != nil {
+				s.fail(err)
+			}
+			continue
+		}
+		switch ev.name {
+		case "", "message":
+			if ev.id != "" {
+				s.lastEventID = ev.id
+			}
+			s.line = parseLogLine(ev.data)
+			return true
+		case "stats":
+			stats := &LogStats{}
+			if json.Unmarshal([]byte(ev.data), stats) == nil {
+				s.stats = stats
+			}
+		case "eof":
+			s.fail(nil)
+		case "error":
+			s.fail(&StreamError{Message: ev.data})
+		}
+	}
+	return false
+}
+
+// Line returns the log line the last call to Next advanced to.
+func (s *LogStream) Line() LogLine {
+	return s.line
+}
+
+// Err returns the error that ended the stream, or nil if the stream ended
+// regularly.
+func (s *LogStream) Err() error {
+	return s.err
+}
+
+// Stats returns the summary of the stream. It is only available once the
+// stream ended and the principal reported it.
+func (s *LogStream) Stats() (LogStats, bool) {
+	if s.stats == nil {
+		return LogStats{}, false
+	}
+	return *s.stats, true
+}
+
+// Close closes the connection to the principal. It is safe to call Close
+// multiple times.
+func (s *LogStream) Close() error {
+	s.done = true
+	if s.body == nil {
+		return nil
+	}
+	err := s.body.Close()
+	s.body = nil
+	return err
+}
+
+// Lines returns an iterator over the remaining lines of the stream. The
+// stream is closed when the iteration ends. If the stream failed, the last
+// value yielded carries the error.
+func (s *LogStream) Lines() iter.Seq2[LogLine, error] {
+	return func(yield func(LogLine, error) bool) {
+		defer s.Close()
+		for s.Next() {
+			if !yield(s.Line(), nil) {
+				return
+			}
+		}
+		if s.err != nil {
+			yield(LogLine{}, s.err)
+		}
+	}
+}
+
+// connect opens a new connection to the principal, resuming after the last
+// event received if there is one.
+func (s *LogStream) connect() error {
+	req, err := s.client.newRequest(s.ctx, s.path, s.query)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-Request-ID", s.requestID)
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+	resp, err := s.client.do(req)
+	if err != nil {
+		return err
+	}
+	s.body = resp.Body
+	s.reader = bufio.NewReader(resp.Body)
+	return nil
+}
+
+// reconnect re-establishes a follow stream whose connection was lost with
+// cause. It returns nil once reconnected, or the error to end the stream
+// with if the stream cannot be resumed.
+func (s *LogStream) reconnect(cause error) error {
+	if !s.follow || s.maxReconnects < 0 {
+		return cause
+	}
+	if s.body != nil {
+		s.body.Close()
+		s.body = nil
+	}
+	err := cause
+	for s.reconnects < s.maxReconnects {
+		s.reconnects++
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(s.retry):
+		}
+		err = s.connect()
+		if err == nil || errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return err
+}
+
+// fail ends the stream with err, which is nil if the stream ended regularly.
+func (s *LogStream) fail(err error) {
+	s.err = err
+	s.Close()
+}
+
+// sseEvent is a single event read from a Server-Sent Events stream
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// readEvent reads the next event from the stream, skipping comments and
+// applying retry hints. It returns io.ErrUnexpectedEOF if the connection
+// ended before the principal terminated the stream.
+func (s *LogStream) readEvent() (sseEvent, error) {
+	ev := sseEvent{}
+	data := []string{}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return sseEvent{}, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if len(data) == 0 && ev.name == "" {
+				continue
+			}
+			ev.data = strings.Join(data, "\n")
+			return ev, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			ev.id = value
+		case "event":
+			ev.name = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// parseLogLine splits the timestamp the kubelet prefixes log lines with from
+// the message.
+func parseLogLine(data string) LogLine {
+	token, message, found := strings.Cut(data, " ")
+	ts, err := time.Parse(time.RFC3339Nano, token)
+	if err != nil {
+		return LogLine{Message: data}
+	}
+	if !found {
+		message = ""
+	}
+	return LogLine{Timestamp: ts, Message: message}
+}
+
+func (o LogOptions) query() url.Values {
+	query := url.Values{}
+	query.Set("timestamps", "true")
+	if o.Container != "" {
+		query.Set("container", o.Container)
+	}
+	if o.Follow {
+		query.Set("follow", "true")
+	}
+	if o.Previous {
+		query.Set("previous", "true")
+	}
+	if !o.SinceTime.IsZero() {
+		query.Set("sinceTime", o.SinceTime.UTC().Format(time.RFC3339))
+	} else if o.SinceSeconds > 0 {
+		query.Set("sinceSeconds", strconv.FormatInt(o.SinceSeconds, 10))
+	}
+	if o.TailLines > 0 {
+		query.Set("tailLines", strconv.FormatInt(o.TailLines, 10))
+	}
+	return query
+}
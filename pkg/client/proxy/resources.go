@@ -0,0 +1,147 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/url"
+	"path"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GetResource returns the live resource identified by gvr, namespace and
+// name from the agent's cluster. The namespace must be empty for cluster
+// scoped resources.
+func (c *Client) GetResource(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	if gvr.Version == "" || gvr.Resource == "" {
+		return nil, fmt.Errorf("version and resource must be set")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("name must not be empty")
+	}
+	obj := &unstructured.Unstructured{}
+	if err := c.getJSON(ctx, resourcePath(gvr, namespace, name), nil, &obj.Object); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ListEventsOptions restricts the events returned by ListEvents.
+type ListEventsOptions struct {
+	// InvolvedObjectKind, InvolvedObjectName and InvolvedObjectUID select
+	// only events about the given object.
+	InvolvedObjectKind string
+	InvolvedObjectName string
+	InvolvedObjectUID  string
+	// FieldSelector is an additional field selector, which is combined with
+	// the involved object selectors.
+	FieldSelector string
+	// Limit is the maximum number of events returned per page. Zero means
+	// no limit.
+	Limit int64
+	// Continue is the continue token of the page to return, as returned in
+	// the previous page's metadata.
+	Continue string
+}
+
+// ListEvents returns the events in the given namespace of the agent's
+// cluster. If namespace is empty, events from all namespaces are returned.
+func (c *Client) ListEvents(ctx context.Context, namespace string, opts ListEventsOptions) (*corev1.EventList, error) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "events"}
+	query := url.Values{}
+	if selector := opts.fieldSelector(); selector != "" {
+		query.Set("fieldSelector", selector)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.FormatInt(opts.Limit, 10))
+	}
+	if opts.Continue != "" {
+		query.Set("continue", opts.Continue)
+	}
+	list := &corev1.EventList{}
+	if err := c.getJSON(ctx, resourcePath(gvr, namespace, ""), query, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Events returns an iterator over the events in the given namespace of the
+// agent's cluster, transparently requesting further pages as needed. The
+// iteration stops after the first error.
+func (c *Client) Events(ctx context.Context, namespace string, opts ListEventsOptions) iter.Seq2[corev1.Event, error] {
+	return func(yield func(corev1.Event, error) bool) {
+		for {
+			list, err := c.ListEvents(ctx, namespace, opts)
+			if err != nil {
+				yield(corev1.Event{}, err)
+				return
+			}
+			for _, ev := range list.Items {
+				if !yield(ev, nil) {
+					return
+				}
+			}
+			if list.Continue == "" {
+				return
+			}
+			opts.Continue = list.Continue
+		}
+	}
+}
+
+func (o ListEventsOptions) fieldSelector() string {
+	selectors := []fields.Selector{}
+	if o.InvolvedObjectKind != "" {
+		selectors = append(selectors, fields.OneTermEqualSelector("involvedObject.kind", o.InvolvedObjectKind))
+	}
+	if o.InvolvedObjectName != "" {
+		selectors = append(selectors, fields.OneTermEqualSelector("involvedObject.name", o.InvolvedObjectName))
+	}
+	if o.InvolvedObjectUID != "" {
+		selectors = append(selectors, fields.OneTermEqualSelector("involvedObject.uid", o.InvolvedObjectUID))
+	}
+	if o.FieldSelector != "" {
+		if s, err := fields.ParseSelector(o.FieldSelector); err == nil {
+			selectors = append(selectors, s)
+		} else {
+			// Let the agent reject the invalid selector
+			return o.FieldSelector
+		}
+	}
+	return fields.AndSelectors(selectors...).String()
+}
+
+// resourcePath returns the Kubernetes API path for the given resource.
+func resourcePath(gvr schema.GroupVersionResource, namespace, name string) string {
+	p := "/api/" + gvr.Version
+	if gvr.Group != "" {
+		p = path.Join("/apis", gvr.Group, gvr.Version)
+	}
+	if namespace != "" {
+		p = path.Join(p, "namespaces", namespace)
+	}
+	p = path.Join(p, gvr.Resource)
+	if name != "" {
+		p = path.Join(p, name)
+	}
+	return p
+}
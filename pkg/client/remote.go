@@ -21,6 +21,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"math"
+	"net"
 	"os"
 	"sync"
 	"time"
@@ -99,12 +100,42 @@ type Remote struct {
 
 	// Time interval for agent to principal ping
 	keepAlivePingInterval time.Duration
+	// keepAliveTimeout is the time to wait for the answer to a ping before
+	// the connection is closed. 0 means gRPC's default.
+	keepAliveTimeout time.Duration
+	// initialWindowSize and initialConnWindowSize are the HTTP/2 flow
+	// control windows of streams and the connection. 0 means gRPC's
+	// dynamic default.
+	initialWindowSize     int32
+	initialConnWindowSize int32
+	// addressFamily selects the IP address families used to connect to the
+	// principal
+	addressFamily grpcutil.AddressFamily
+	// enableQUIC makes the connection run over QUIC instead of TCP when the
+	// principal is reachable over QUIC. quicFailedAt is the time QUIC last
+	// failed to connect, which makes connections use TCP for a while.
+	enableQUIC   bool
+	quicMu       sync.Mutex
+	quicFailedAt time.Time
 
 	// The largest GRPC message size supported, configurable via env/param
 	MaxGRPCMessageSize int
 
 	// agentVersion is the version of the agent, used for handshake validation
 	agentVersion string
+
+	// join is set when the agent has to join the principal with a join
+	// token before it can connect
+	join *joinConfig
+
+	// addrMu guards hostname, port and the server name in tlsConfig, which
+	// may be changed at runtime using SetAddress
+	addrMu sync.RWMutex
+
+	// unaryInterceptors and streamInterceptors are additional interceptors
+	// of the connection, run after the built-in ones
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
 }
 
 type RemoteOption func(r *Remote) error
@@ -264,6 +295,15 @@ func WithWebSocket(enableWebSocket bool) RemoteOption {
 	}
 }
 
+// WithQUIC makes the agent connect to the principal over QUIC, falling back
+// to TCP if the principal can't be reached over QUIC. This is experimental.
+func WithQUIC(enableQUIC bool) RemoteOption {
+	return func(r *Remote) error {
+		r.enableQUIC = enableQUIC
+		return nil
+	}
+}
+
 func WithAuth(method string, creds auth.Credentials) RemoteOption {
 	return func(r *Remote) error {
 		r.authMethod = method
@@ -293,6 +333,58 @@ func WithCompression(flag bool) RemoteOption {
 	}
 }
 
+// WithKeepAliveTimeout sets the time to wait for the principal to answer a
+// keepalive ping before the connection is considered dead. It only has an
+// effect if a keepalive ping interval is set.
+func WithKeepAliveTimeout(timeout time.Duration) RemoteOption {
+	return func(r *Remote) error {
+		if timeout < 0 {
+			return fmt.Errorf("keepalive timeout must not be negative")
+		}
+		r.keepAliveTimeout = timeout
+		return nil
+	}
+}
+
+// WithInitialWindowSize sets the initial HTTP/2 flow control window of each
+// stream to the principal in bytes. 0 keeps gRPC's dynamic window.
+func WithInitialWindowSize(size int) RemoteOption {
+	return func(r *Remote) error {
+		if err := grpcutil.ValidateWindowSize(size); err != nil {
+			return fmt.Errorf("invalid initial window size: %w", err)
+		}
+		r.initialWindowSize = int32(size)
+		return nil
+	}
+}
+
+// WithInitialConnWindowSize sets the initial HTTP/2 flow control window of
+// the connection to the principal in bytes. 0 keeps gRPC's dynamic window.
+func WithInitialConnWindowSize(size int) RemoteOption {
+	return func(r *Remote) error {
+		if err := grpcutil.ValidateWindowSize(size); err != nil {
+			return fmt.Errorf("invalid initial connection window size: %w", err)
+		}
+		r.initialConnWindowSize = int32(size)
+		return nil
+	}
+}
+
+// WithAddressFamily sets the IP address families used to connect to the
+// principal. With AddressFamilyDual, which is the default, the addresses of
+// both families the principal's name resolves to are raced against each
+// other (happy eyeballs), preferring IPv6.
+func WithAddressFamily(family grpcutil.AddressFamily) RemoteOption {
+	return func(r *Remote) error {
+		f, err := grpcutil.ParseAddressFamily(string(family))
+		if err != nil {
+			return err
+		}
+		r.addressFamily = f
+		return nil
+	}
+}
+
 // WithMaxGRPCMessageSize configures the maximum gRPC message size (in bytes)
 // for both sending and receiving on the agent client connection.
 func WithMaxGRPCMessageSize(size int) RemoteOption {
@@ -305,6 +397,26 @@ func WithMaxGRPCMessageSize(size int) RemoteOption {
 	}
 }
 
+// WithUnaryInterceptors adds interceptors for unary calls to the connection
+// to the principal, e.g. for custom auth, auditing or tracing. They run in the
+// order given, after the built-in interceptors.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) RemoteOption {
+	return func(r *Remote) error {
+		r.unaryInterceptors = append(r.unaryInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithStreamInterceptors adds interceptors for streams to the connection to
+// the principal. They run in the order given, after the built-in
+// interceptors.
+func WithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) RemoteOption {
+	return func(r *Remote) error {
+		r.streamInterceptors = append(r.streamInterceptors, interceptors...)
+		return nil
+	}
+}
+
 // WithMinimumTLSVersion configures the minimum TLS version the client will accept.
 func WithMinimumTLSVersion(version string) RemoteOption {
 	return func(r *Remote) error {
@@ -342,6 +454,20 @@ func WithTLSCipherSuites(cipherSuites []string) RemoteOption {
 	}
 }
 
+// WithTLSCurvePreferences configures the key exchange mechanisms the client
+// offers, in order of preference. If an unknown curve is specified, an error
+// is returned.
+func WithTLSCurvePreferences(curves []string) RemoteOption {
+	return func(r *Remote) error {
+		ids, err := tlsutil.ParseCurvePreferences(curves)
+		if err != nil {
+			return err
+		}
+		r.tlsConfig.CurvePreferences = ids
+		return nil
+	}
+}
+
 func NewRemote(hostname string, port int, opts ...RemoteOption) (*Remote, error) {
 	r := &Remote{
 		hostname: hostname,
@@ -374,17 +500,103 @@ func NewRemote(hostname string, port int, opts ...RemoteOption) (*Remote, error)
 
 // Hostname returns the name of the host this remote connects to
 func (r *Remote) Hostname() string {
+	r.addrMu.RLock()
+	defer r.addrMu.RUnlock()
 	return r.hostname
 }
 
 // Port returns the port number this remote connects to on the remote host
 func (r *Remote) Port() int {
+	r.addrMu.RLock()
+	defer r.addrMu.RUnlock()
 	return r.port
 }
 
 // Addr returns a string representation of the address this remote connects to
 func (r *Remote) Addr() string {
-	return fmt.Sprintf("%s:%d", r.hostname, r.port)
+	r.addrMu.RLock()
+	defer r.addrMu.RUnlock()
+	return grpcutil.JoinHostPort(r.hostname, r.port)
+}
+
+// dialTarget returns the gRPC target to connect to the principal. The name
+// of the principal is passed through to dialContext rather than resolved by
+// gRPC, so that the dialer can race the addresses of both address families.
+func (r *Remote) dialTarget() string {
+	return "passthrough:///" + r.Addr()
+}
+
+// dialContext connects to addr using the configured address families. With
+// both families, net.Dialer falls back to IPv4 if IPv6 doesn't connect
+// quickly (RFC 6555).
+func (r *Remote) dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, r.addressFamily.Network(), addr)
+}
+
+// quicDialTimeout is the time to wait for a QUIC connection before falling
+// back to TCP. quicRetryInterval is the time after a failed QUIC connection
+// during which TCP is used right away.
+var (
+	quicDialTimeout   = 5 * time.Second
+	quicRetryInterval = 5 * time.Minute
+)
+
+// dialQUICOrTCP connects to addr over QUIC with dialer if QUIC is enabled,
+// and over TCP if QUIC is disabled or didn't connect recently. A QUIC
+// connection carries each RPC as an HTTP/3 request on a stream of its own.
+func (r *Remote) dialQUICOrTCP(ctx context.Context, dialer *grpcutil.QUICDialer, addr string) (net.Conn, error) {
+	if dialer == nil || !r.quicUsable() {
+		return r.dialContext(ctx, addr)
+	}
+	qctx, cancel := context.WithTimeout(ctx, quicDialTimeout)
+	defer cancel()
+	conn, err := dialer.Dial(qctx, addr)
+	if err == nil {
+		log().Debugf("Connected to %s over QUIC", addr)
+		return conn, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log().WithError(err).Warnf("Could not connect to %s over QUIC, falling back to TCP for %s", addr, quicRetryInterval)
+	r.quicMu.Lock()
+	r.quicFailedAt = time.Now()
+	r.quicMu.Unlock()
+	return r.dialContext(ctx, addr)
+}
+
+// quicUsable returns whether the next connection should be attempted over
+// QUIC
+func (r *Remote) quicUsable() bool {
+	if !r.enableQUIC || r.insecurePlaintext {
+		return false
+	}
+	r.quicMu.Lock()
+	defer r.quicMu.Unlock()
+	return r.quicFailedAt.IsZero() || time.Since(r.quicFailedAt) >= quicRetryInterval
+}
+
+// SetAddress changes the address of the remote host. It does not affect an
+// existing connection; the new address will be used on the next call to
+// Connect. If the TLS server name was derived from the previous hostname, it
+// is changed to the new hostname as well.
+func (r *Remote) SetAddress(hostname string, port int) {
+	r.addrMu.Lock()
+	defer r.addrMu.Unlock()
+	if r.tlsConfig.ServerName == r.hostname {
+		r.tlsConfig.ServerName = hostname
+	}
+	r.hostname = hostname
+	r.port = port
+}
+
+// dialTLSConfig returns a copy of the TLS configuration to dial the remote
+// host with
+func (r *Remote) dialTLSConfig() *tls.Config {
+	r.addrMu.RLock()
+	defer r.addrMu.RUnlock()
+	return r.tlsConfig.Clone()
 }
 
 // Creds returns the credentials this Remote uses to connect to the remote host
@@ -529,6 +741,12 @@ func (r *Remote) Connect(ctx context.Context, forceReauth bool) error {
 	}
 	r.connMu.Unlock()
 
+	if r.join != nil {
+		if err := r.Join(ctx); err != nil {
+			return err
+		}
+	}
+
 	cparams := grpc.ConnectParams{
 		MinConnectTimeout: 365 * 24 * time.Hour,
 	}
@@ -539,25 +757,35 @@ func (r *Remote) Connect(ctx context.Context, forceReauth bool) error {
 		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		grpc.WithConnectParams(cparams),
 		grpc.WithUserAgent("argocd-agent/v0.0.1"),
-		grpc.WithChainUnaryInterceptor(
+		grpc.WithChainUnaryInterceptor(append([]grpc.UnaryClientInterceptor{
 			r.unaryAuthInterceptor,
 			grpcutil.UnaryClientMsgSizeInterceptor(r.MaxGRPCMessageSize),
-		),
-		grpc.WithChainStreamInterceptor(
+		}, r.unaryInterceptors...)...),
+		grpc.WithChainStreamInterceptor(append([]grpc.StreamClientInterceptor{
 			r.streamAuthInterceptor,
 			grpcutil.StreamClientMsgSizeInterceptor(r.MaxGRPCMessageSize),
-		),
+		}, r.streamInterceptors...)...),
 	}
 
 	if r.enableCompression {
 		log().Debug("gRPC compression is enabled.")
 		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
 	}
+	if r.initialWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(r.initialWindowSize))
+	}
+	if r.initialConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(r.initialConnWindowSize))
+	}
 
 	var (
 		conn *grpc.ClientConn
 		err  error
 	)
+	tlsConfig := r.dialTLSConfig()
+	if r.enableQUIC && (r.enableWebSocket || r.insecurePlaintext) {
+		log().Warn("QUIC is not supported with WebSocket or plaintext connections, connecting over TCP")
+	}
 	if r.enableWebSocket {
 		grpcHTTP1Opts := []grpchttp1client.ConnectOption{
 			grpchttp1client.UseWebSocket(true),
@@ -567,7 +795,7 @@ func (r *Remote) Connect(ctx context.Context, forceReauth bool) error {
 		// Use nil TLS config for plaintext mode (WebSocket over HTTP)
 		var tlsCfg *tls.Config
 		if !r.insecurePlaintext {
-			tlsCfg = r.tlsConfig
+			tlsCfg = tlsConfig
 		}
 		conn, err = grpchttp1client.ConnectViaProxy(ctx, r.Addr(), tlsCfg, grpcHTTP1Opts...)
 		if err != nil {
@@ -575,18 +803,26 @@ func (r *Remote) Connect(ctx context.Context, forceReauth bool) error {
 		}
 	} else {
 		// Use insecure credentials for plaintext mode (e.g., behind Istio)
+		var quicDialer *grpcutil.QUICDialer
 		if r.insecurePlaintext {
 			opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		} else if r.enableQUIC {
+			// Connections over QUIC are secured by QUIC's TLS only
+			quicDialer = grpcutil.NewQUICDialer(r.addressFamily, tlsConfig)
+			opts = append(opts, grpc.WithTransportCredentials(quicDialer.TransportCredentials(credentials.NewTLS(tlsConfig))))
 		} else {
-			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(r.tlsConfig)))
+			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 		}
 
 		if r.keepAlivePingInterval != 0 {
 			log().Debugf("Agent ping to principal is enabled, agent will send a ping event after every %s.", r.keepAlivePingInterval)
-			opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: r.keepAlivePingInterval}))
+			opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: r.keepAlivePingInterval, Timeout: r.keepAliveTimeout}))
 		}
 
-		conn, err = grpc.NewClient(r.Addr(), opts...)
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return r.dialQUICOrTCP(ctx, quicDialer, addr)
+		}))
+		conn, err = grpc.NewClient(r.dialTarget(), opts...)
 		if err != nil {
 			return err
 		}
@@ -640,6 +876,9 @@ func (r *Remote) Connect(ctx context.Context, forceReauth bool) error {
 			if ierr != nil {
 				return ierr
 			}
+			if resp.Version != "" && resp.Version != r.agentVersion {
+				log().Warnf("Principal runs version %s, agent runs version %s; upgrade the agent to match the principal", resp.Version, r.agentVersion)
+			}
 			authenticated = true
 			return nil
 		}
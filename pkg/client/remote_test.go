@@ -20,13 +20,17 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"math/big"
+	"net"
 	"path"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/auth"
 	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
+	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
 	"github.com/argoproj-labs/argocd-agent/internal/issuer"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/argoproj-labs/argocd-agent/principal"
@@ -34,6 +38,7 @@ import (
 	"github.com/argoproj-labs/argocd-agent/test/fake/testcerts"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 )
 
 func Test_Connect(t *testing.T) {
@@ -85,6 +90,37 @@ func Test_Connect(t *testing.T) {
 		assert.Equal(t, "default", authSub.ClientID)
 	})
 
+	t.Run("Connect to a server over IPv6", func(t *testing.T) {
+		if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+			t.Skipf("IPv6 loopback not available: %v", err)
+		} else {
+			l.Close()
+		}
+		r, err := NewRemote("::1", s.ListenerForE2EOnly().Port(),
+			WithInsecureSkipTLSVerify(),
+			WithAuth("userpass", auth.Credentials{userpass.ClientIDField: "default", userpass.ClientSecretField: "password"}),
+			WithAddressFamily(grpcutil.AddressFamilyIPv6),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("[::1]:%d", s.ListenerForE2EOnly().Port()), r.Addr())
+		ctx, cancelFn := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelFn()
+		assert.NoError(t, r.Connect(ctx, false))
+	})
+
+	t.Run("Address family excludes the server's address", func(t *testing.T) {
+		r, err := NewRemote("127.0.0.1", s.ListenerForE2EOnly().Port(),
+			WithInsecureSkipTLSVerify(),
+			WithAuth("userpass", auth.Credentials{userpass.ClientIDField: "default", userpass.ClientSecretField: "password"}),
+			WithAddressFamily(grpcutil.AddressFamilyIPv6),
+		)
+		require.NoError(t, err)
+		ctx, cancelFn := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelFn()
+		assert.Error(t, r.Connect(ctx, false))
+		assert.Nil(t, r.conn)
+	})
+
 	t.Run("Invalid auth and context deadline reached", func(t *testing.T) {
 		r, err := NewRemote("127.0.0.1", s.ListenerForE2EOnly().Port(),
 			WithInsecureSkipTLSVerify(),
@@ -101,6 +137,70 @@ func Test_Connect(t *testing.T) {
 
 }
 
+func Test_ConnectQUIC(t *testing.T) {
+	tempDir := t.TempDir()
+	basePath := path.Join(tempDir, "certs")
+	testcerts.WriteSelfSignedCert(t, "rsa", basePath, x509.Certificate{SerialNumber: big.NewInt(1)})
+
+	s, err := principal.NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("default"), "default",
+		principal.WithGRPC(true),
+		principal.WithListenerAddress("127.0.0.1"),
+		principal.WithListenerPort(0),
+		principal.WithTLSKeyPairFromPath(basePath+".crt", basePath+".key"),
+		principal.WithGeneratedTokenSigningKey(),
+		principal.WithQUIC(true),
+	)
+	require.NoError(t, err)
+	am := userpass.NewUserPassAuthentication("")
+	am.UpsertUser("default", "password")
+	s.AuthMethodsForE2EOnly().RegisterMethod("userpass", am)
+	require.NoError(t, s.Start(context.Background(), make(chan error)))
+	t.Cleanup(func() {
+		require.NoError(t, s.Shutdown())
+	})
+
+	t.Run("Connect over QUIC", func(t *testing.T) {
+		r, err := NewRemote("127.0.0.1", s.ListenerForE2EOnly().Port(),
+			WithInsecureSkipTLSVerify(),
+			WithAuth("userpass", auth.Credentials{userpass.ClientIDField: "default", userpass.ClientSecretField: "password"}),
+			WithQUIC(true),
+		)
+		require.NoError(t, err)
+		ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelFn()
+		require.NoError(t, r.Connect(ctx, false))
+		assert.True(t, r.quicFailedAt.IsZero())
+		assert.True(t, r.quicUsable())
+	})
+
+	t.Run("Fall back to TCP", func(t *testing.T) {
+		oldTimeout := quicDialTimeout
+		quicDialTimeout = 200 * time.Millisecond
+		defer func() { quicDialTimeout = oldTimeout }()
+
+		l, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		r, err := NewRemote("127.0.0.1", l.Addr().(*net.TCPAddr).Port, WithQUIC(true))
+		require.NoError(t, err)
+		conn, err := r.dialQUICOrTCP(context.Background(), grpcutil.NewQUICDialer(grpcutil.AddressFamilyIPv4, r.dialTLSConfig()), r.Addr())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, isTCP := conn.(*net.TCPConn)
+		assert.True(t, isTCP)
+		assert.False(t, r.quicUsable())
+	})
+
+	t.Run("QUIC disabled", func(t *testing.T) {
+		r, err := NewRemote("127.0.0.1", 443)
+		require.NoError(t, err)
+		assert.False(t, r.quicUsable())
+		r, err = NewRemote("127.0.0.1", 443, WithQUIC(true), WithInsecurePlaintext())
+		require.NoError(t, err)
+		assert.False(t, r.quicUsable())
+	})
+}
+
 func Test_WithMinimumTLSVersion(t *testing.T) {
 	t.Run("All valid minimum TLS versions", func(t *testing.T) {
 		versions := map[string]uint16{
@@ -124,6 +224,37 @@ func Test_WithMinimumTLSVersion(t *testing.T) {
 	})
 }
 
+func Test_WithAddressFamily(t *testing.T) {
+	r, err := NewRemote("localhost", 443)
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", r.addressFamily.Network())
+	r, err = NewRemote("localhost", 443, WithAddressFamily(grpcutil.AddressFamilyIPv4))
+	require.NoError(t, err)
+	assert.Equal(t, "tcp4", r.addressFamily.Network())
+	_, err = NewRemote("localhost", 443, WithAddressFamily("ipv4"))
+	assert.Error(t, err)
+}
+
+func Test_ConnectionTuning(t *testing.T) {
+	r, err := NewRemote("localhost", 443,
+		WithKeepAliveTimeout(5*time.Second),
+		WithInitialWindowSize(1<<20),
+		WithInitialConnWindowSize(0))
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, r.keepAliveTimeout)
+	assert.Equal(t, int32(1<<20), r.initialWindowSize)
+	assert.Zero(t, r.initialConnWindowSize)
+
+	for _, opt := range []RemoteOption{
+		WithKeepAliveTimeout(-time.Second),
+		WithInitialWindowSize(1024),
+		WithInitialConnWindowSize(-1),
+	} {
+		_, err := NewRemote("localhost", 443, opt)
+		assert.Error(t, err)
+	}
+}
+
 func Test_WithMaximumTLSVersion(t *testing.T) {
 	t.Run("All valid maximum TLS versions", func(t *testing.T) {
 		versions := map[string]uint16{
@@ -177,6 +308,20 @@ func Test_WithTLSCipherSuites(t *testing.T) {
 	})
 }
 
+func Test_WithTLSCurvePreferences(t *testing.T) {
+	t.Run("Valid curves", func(t *testing.T) {
+		r, err := NewRemote("localhost", 443, WithTLSCurvePreferences([]string{"P256", "P384"}))
+		assert.NoError(t, err)
+		assert.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, r.tlsConfig.CurvePreferences)
+	})
+
+	t.Run("Invalid curve", func(t *testing.T) {
+		r, err := NewRemote("localhost", 443, WithTLSCurvePreferences([]string{"P224"}))
+		assert.Error(t, err)
+		assert.Nil(t, r)
+	})
+}
+
 func Test_validateTLSConfig(t *testing.T) {
 	t.Run("Valid configuration with min < max", func(t *testing.T) {
 		r, err := NewRemote("localhost", 443,
@@ -366,3 +511,60 @@ func Test_TokenRefresh(t *testing.T) {
 		assert.Equal(t, originalRefreshToken, r.refreshToken.RawToken)
 	})
 }
+
+func Test_Interceptors(t *testing.T) {
+	tempDir := t.TempDir()
+	basePath := path.Join(tempDir, "certs")
+	testcerts.WriteSelfSignedCert(t, "rsa", basePath, x509.Certificate{SerialNumber: big.NewInt(1)})
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(side string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, side)
+	}
+
+	s, err := principal.NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("default"), "default",
+		principal.WithGRPC(true),
+		principal.WithListenerPort(0),
+		principal.WithTLSKeyPairFromPath(basePath+".crt", basePath+".key"),
+		principal.WithGeneratedTokenSigningKey(),
+		principal.WithUnaryInterceptors(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			record("server " + info.FullMethod)
+			return handler(ctx, req)
+		}),
+	)
+	require.NoError(t, err)
+
+	am := userpass.NewUserPassAuthentication("")
+	am.UpsertUser("default", "password")
+	s.AuthMethodsForE2EOnly().RegisterMethod("userpass", am)
+
+	errch := make(chan error)
+	err = s.Start(context.Background(), errch)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Shutdown())
+	})
+
+	r, err := NewRemote("127.0.0.1", s.ListenerForE2EOnly().Port(),
+		WithInsecureSkipTLSVerify(),
+		WithAuth("userpass", auth.Credentials{userpass.ClientIDField: "default", userpass.ClientSecretField: "password"}),
+		WithClientMode(types.AgentModeManaged),
+		WithUnaryInterceptors(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			record("client " + method)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	require.NoError(t, err)
+	ctx, cancelFn := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFn()
+	require.NoError(t, r.Connect(ctx, false))
+	defer r.Disconnect()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, calls)
+	assert.Equal(t, []string{"client /authapi.Authentication/Authenticate", "server /authapi.Authentication/Authenticate"}, calls[:2])
+}
@@ -0,0 +1,221 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+)
+
+// The gRPC interface of plugin processes. Messages are encoded as JSON, with
+// events in the CloudEvents JSON format, so plugins can be written in any
+// language without generated code.
+const (
+	// ServiceName is the name of the gRPC service served by plugin processes
+	ServiceName = "argocdagent.plugin.v1.Plugin"
+	// Codec is the gRPC content subtype of the messages
+	Codec = "json"
+)
+
+// DefaultCallTimeout is how long the agent waits for a plugin process to
+// answer a hook call
+const DefaultCallTimeout = 5 * time.Second
+
+// HookRequest is the message sent to a plugin process for each hook call
+type HookRequest struct {
+	Event *cloudevents.Event `json:"event"`
+	// Result is the error message of processing the event, for PostProcess
+	Result string `json:"result,omitempty"`
+}
+
+// HookResponse is the answer of a plugin process to a hook call
+type HookResponse struct {
+	// Event is the event to continue with. If not set, the event is left
+	// unchanged.
+	Event *cloudevents.Event `json:"event,omitempty"`
+	// Reject is set to the reason for rejecting the event
+	Reject string `json:"reject,omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return Codec
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// grpcPlugin calls the hooks of a plugin process over gRPC
+type grpcPlugin struct {
+	name    string
+	conn    grpc.ClientConnInterface
+	timeout time.Duration
+}
+
+// NewGRPCPlugin returns the hooks of the plugin process at the other end of
+// conn. Calls that fail, e.g. because the process is not reachable, reject
+// the event.
+func NewGRPCPlugin(name string, conn grpc.ClientConnInterface) Hooks {
+	return &grpcPlugin{name: name, conn: conn, timeout: DefaultCallTimeout}
+}
+
+// Dial connects to the plugin process listening on address, which may be a
+// "unix:///path/to/socket" or a "host:port" address. The connection is not
+// encrypted, so the plugin process should run next to the agent.
+func Dial(address string) (Hooks, *grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewGRPCPlugin(address, conn), conn, nil
+}
+
+func (p *grpcPlugin) Name() string {
+	return p.name
+}
+
+func (p *grpcPlugin) call(ctx context.Context, method string, req *HookRequest) (*HookResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	resp := &HookResponse{}
+	err := p.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp, grpc.CallContentSubtype(Codec))
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// pre calls a Pre hook and returns the event to continue with
+func (p *grpcPlugin) pre(ctx context.Context, method string, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	resp, err := p.call(ctx, method, &HookRequest{Event: ev})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Reject != "" {
+		return nil, errors.New(resp.Reject)
+	}
+	if resp.Event != nil {
+		return resp.Event, nil
+	}
+	return ev, nil
+}
+
+// post calls a Post hook, whose failures are only logged
+func (p *grpcPlugin) post(ctx context.Context, method string, req *HookRequest) {
+	if _, err := p.call(ctx, method, req); err != nil {
+		log().WithFields(logrus.Fields{"plugin": p.name, "hook": method}).WithError(err).Debug("Plugin hook failed")
+	}
+}
+
+func (p *grpcPlugin) PreProcess(ctx context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	return p.pre(ctx, "PreProcess", ev)
+}
+
+func (p *grpcPlugin) PostProcess(ctx context.Context, ev *cloudevents.Event, result error) {
+	req := &HookRequest{Event: ev}
+	if result != nil {
+		req.Result = result.Error()
+	}
+	p.post(ctx, "PostProcess", req)
+}
+
+func (p *grpcPlugin) PreSend(ctx context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	return p.pre(ctx, "PreSend", ev)
+}
+
+func (p *grpcPlugin) PostSend(ctx context.Context, ev *cloudevents.Event) {
+	p.post(ctx, "PostSend", &HookRequest{Event: ev})
+}
+
+// RegisterServer registers the hooks h as the plugin service on s, for
+// writing plugin processes in Go.
+func RegisterServer(s grpc.ServiceRegistrar, h Hooks) {
+	s.RegisterService(&serviceDesc, h)
+}
+
+// preHandler returns the gRPC handler of a Pre hook
+func preHandler(method string, hook func(Hooks, context.Context, *cloudevents.Event) (*cloudevents.Event, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			req := &HookRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			ev, err := hook(srv.(Hooks), ctx, req.Event)
+			if err != nil {
+				return &HookResponse{Reject: err.Error()}, nil
+			}
+			return &HookResponse{Event: ev}, nil
+		},
+	}
+}
+
+// postHandler returns the gRPC handler of a Post hook
+func postHandler(method string, hook func(Hooks, context.Context, *HookRequest)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			req := &HookRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			hook(srv.(Hooks), ctx, req)
+			return &HookResponse{}, nil
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Hooks)(nil),
+	Methods: []grpc.MethodDesc{
+		preHandler("PreProcess", Hooks.PreProcess),
+		postHandler("PostProcess", func(h Hooks, ctx context.Context, req *HookRequest) {
+			var result error
+			if req.Result != "" {
+				result = errors.New(req.Result)
+			}
+			h.PostProcess(ctx, req.Event, result)
+		}),
+		preHandler("PreSend", Hooks.PreSend),
+		postHandler("PostSend", func(h Hooks, ctx context.Context, req *HookRequest) {
+			h.PostSend(ctx, req.Event)
+		}),
+	},
+}
+
+func log() *logrus.Entry {
+	return logging.GetDefaultLogger().ComponentLogger("Plugin")
+}
@@ -0,0 +1,141 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package plugin provides the extension interface of the agent's event
+processing. Plugins get to see, and may reject or transform, the events the
+agent receives from the principal before they are processed, and the events
+the agent sends to the principal before they are sent. This allows
+integrators to inject policy checks or transformations without forking the
+agent.
+
+Plugins are either compiled into the agent, by calling Register from the init
+function of a package imported by the binary, or run as a separate process
+serving the gRPC interface implemented by NewGRPCPlugin and RegisterServer.
+*/
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Hooks is the interface implemented by plugins.
+//
+// The Pre hooks return the event to continue with, which may be the event
+// passed in or a modified copy of it. Returning an error rejects the event:
+// an incoming event is not processed, and an outgoing event is not sent.
+// The Post hooks are informational.
+type Hooks interface {
+	// Name identifies the plugin, e.g. in log messages
+	Name() string
+	// PreProcess is called for each event received from the principal,
+	// before the agent processes it.
+	PreProcess(ctx context.Context, ev *cloudevents.Event) (*cloudevents.Event, error)
+	// PostProcess is called after the agent processed an event received from
+	// the principal, with the result of processing it.
+	PostProcess(ctx context.Context, ev *cloudevents.Event, result error)
+	// PreSend is called for each event the agent is about to send to the
+	// principal.
+	PreSend(ctx context.Context, ev *cloudevents.Event) (*cloudevents.Event, error)
+	// PostSend is called after an event was handed over for sending to the
+	// principal.
+	PostSend(ctx context.Context, ev *cloudevents.Event)
+}
+
+// RejectedError is returned when a plugin rejects an event
+type RejectedError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by plugin %s: %v", e.Plugin, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// registry holds the plugins compiled into the binary
+var registry struct {
+	mu      sync.Mutex
+	plugins []Hooks
+}
+
+// Register registers a plugin compiled into the binary. It is meant to be
+// called from the init function of the plugin's package.
+func Register(h Hooks) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.plugins = append(registry.plugins, h)
+}
+
+// Registered returns the plugins compiled into the binary, in the order they
+// were registered.
+func Registered() []Hooks {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]Hooks(nil), registry.plugins...)
+}
+
+// Chain runs the hooks of several plugins in order. Each plugin gets the event
+// as returned by the previous one, and the first rejection wins. A nil or
+// empty Chain passes all events unchanged.
+type Chain []Hooks
+
+// PreProcess runs the PreProcess hooks of all plugins
+func (c Chain) PreProcess(ctx context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	for _, h := range c {
+		out, err := h.PreProcess(ctx, ev)
+		if err != nil {
+			return nil, &RejectedError{Plugin: h.Name(), Err: err}
+		}
+		if out != nil {
+			ev = out
+		}
+	}
+	return ev, nil
+}
+
+// PostProcess runs the PostProcess hooks of all plugins
+func (c Chain) PostProcess(ctx context.Context, ev *cloudevents.Event, result error) {
+	for _, h := range c {
+		h.PostProcess(ctx, ev, result)
+	}
+}
+
+// PreSend runs the PreSend hooks of all plugins
+func (c Chain) PreSend(ctx context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	for _, h := range c {
+		out, err := h.PreSend(ctx, ev)
+		if err != nil {
+			return nil, &RejectedError{Plugin: h.Name(), Err: err}
+		}
+		if out != nil {
+			ev = out
+		}
+	}
+	return ev, nil
+}
+
+// PostSend runs the PostSend hooks of all plugins
+func (c Chain) PostSend(ctx context.Context, ev *cloudevents.Event) {
+	for _, h := range c {
+		h.PostSend(ctx, ev)
+	}
+}
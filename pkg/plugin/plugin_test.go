@@ -0,0 +1,155 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// testHooks labels events with its name and rejects events of type reject
+type testHooks struct {
+	name   string
+	reject string
+
+	mu      sync.Mutex
+	results []string
+	sent    []string
+}
+
+func (h *testHooks) Name() string {
+	return h.name
+}
+
+func (h *testHooks) pre(ev *cloudevents.Event) (*cloudevents.Event, error) {
+	if ev.Type() == h.reject {
+		return nil, errors.New("not allowed")
+	}
+	out := ev.Clone()
+	out.SetSubject(ev.Subject() + "/" + h.name)
+	return &out, nil
+}
+
+func (h *testHooks) PreProcess(_ context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	return h.pre(ev)
+}
+
+func (h *testHooks) PostProcess(_ context.Context, ev *cloudevents.Event, result error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := "ok"
+	if result != nil {
+		r = result.Error()
+	}
+	h.results = append(h.results, ev.ID()+": "+r)
+}
+
+func (h *testHooks) PreSend(_ context.Context, ev *cloudevents.Event) (*cloudevents.Event, error) {
+	return h.pre(ev)
+}
+
+func (h *testHooks) PostSend(_ context.Context, ev *cloudevents.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sent = append(h.sent, ev.ID())
+}
+
+func newTestEvent(id, evType string) *cloudevents.Event {
+	ev := cloudevents.NewEvent()
+	ev.SetID(id)
+	ev.SetType(evType)
+	ev.SetSource("test")
+	return &ev
+}
+
+func Test_Chain(t *testing.T) {
+	first := &testHooks{name: "first", reject: "forbidden"}
+	second := &testHooks{name: "second", reject: "restricted"}
+	chain := Chain{first, second}
+
+	t.Run("Plugins transform events in order", func(t *testing.T) {
+		out, err := chain.PreProcess(context.Background(), newTestEvent("1", "update"))
+		require.NoError(t, err)
+		assert.Equal(t, "/first/second", out.Subject())
+		out, err = chain.PreSend(context.Background(), newTestEvent("2", "update"))
+		require.NoError(t, err)
+		assert.Equal(t, "/first/second", out.Subject())
+	})
+
+	t.Run("The first rejection wins", func(t *testing.T) {
+		_, err := chain.PreProcess(context.Background(), newTestEvent("1", "forbidden"))
+		var rejected *RejectedError
+		require.ErrorAs(t, err, &rejected)
+		assert.Equal(t, "first", rejected.Plugin)
+		_, err = chain.PreSend(context.Background(), newTestEvent("2", "restricted"))
+		require.ErrorAs(t, err, &rejected)
+		assert.Equal(t, "second", rejected.Plugin)
+	})
+
+	t.Run("Empty chain passes events unchanged", func(t *testing.T) {
+		ev := newTestEvent("1", "forbidden")
+		out, err := Chain(nil).PreProcess(context.Background(), ev)
+		require.NoError(t, err)
+		assert.Same(t, ev, out)
+	})
+}
+
+func Test_GRPCPlugin(t *testing.T) {
+	hooks := &testHooks{name: "remote", reject: "forbidden"}
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	s := grpc.NewServer()
+	RegisterServer(s, hooks)
+	go func() { _ = s.Serve(l) }()
+	defer s.Stop()
+
+	p, conn, err := Dial("unix://" + socket)
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx := context.Background()
+
+	out, err := p.PreProcess(ctx, newTestEvent("1", "update"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", out.ID())
+	assert.Equal(t, "/remote", out.Subject())
+
+	_, err = p.PreSend(ctx, newTestEvent("2", "forbidden"))
+	assert.ErrorContains(t, err, "not allowed")
+
+	p.PostProcess(ctx, newTestEvent("3", "update"), errors.New("failed"))
+	p.PostSend(ctx, newTestEvent("4", "update"))
+	hooks.mu.Lock()
+	assert.Equal(t, []string{"3: failed"}, hooks.results)
+	assert.Equal(t, []string{"4"}, hooks.sent)
+	hooks.mu.Unlock()
+
+	t.Run("Unreachable plugin rejects events", func(t *testing.T) {
+		p, conn, err := Dial("unix://" + filepath.Join(t.TempDir(), "missing.sock"))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = p.PreProcess(ctx, newTestEvent("1", "update"))
+		assert.Error(t, err)
+	})
+}
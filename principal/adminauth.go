@@ -0,0 +1,123 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// adminAPIGroup and adminResource name the virtual resource operators
+	// need the create permission on in the principal's namespace to use the
+	// admin server.
+	adminAPIGroup = "argocd-agent.argoproj-labs.io"
+	adminResource = "admin"
+)
+
+type adminOperatorKey struct{}
+
+// authenticateAdmin wraps next so that it is only served to requests carrying
+// a bearer token that the Kubernetes API authenticates, and whose user may
+// create the admin resource in the principal's namespace. The authenticated
+// user name is recorded as the operator in the audit log.
+func (s *Server) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auditCtx := auditLog().WithFields(logrus.Fields{
+			"remote_addr": r.RemoteAddr,
+			"path":        r.URL.Path,
+		})
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			auditCtx.Warn("Refused admin request without bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="argocd-agent"`)
+			http.Error(w, "bearer token required", http.StatusUnauthorized)
+			return
+		}
+		user, err := s.authenticateAdminToken(r.Context(), token)
+		if err != nil {
+			auditCtx.WithError(err).Warn("Refused admin request with invalid token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="argocd-agent"`)
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		auditCtx = auditCtx.WithField("operator", user.Username)
+		if err := s.authorizeAdminUser(r.Context(), user); err != nil {
+			auditCtx.WithError(err).Warn("Refused unauthorized admin request")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminOperatorKey{}, user.Username)))
+	})
+}
+
+// authenticateAdminToken validates token with a TokenReview and returns the
+// user it belongs to.
+func (s *Server) authenticateAdminToken(ctx context.Context, token string) (*authnv1.UserInfo, error) {
+	review, err := s.kubeClient.Clientset.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not review token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("token not authenticated: %s", review.Status.Error)
+	}
+	return &review.Status.User, nil
+}
+
+// authorizeAdminUser checks with a SubjectAccessReview that user may create
+// the admin resource in the principal's namespace.
+func (s *Server) authorizeAdminUser(ctx context.Context, user *authnv1.UserInfo) error {
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	review, err := s.kubeClient.Clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authzv1.ResourceAttributes{
+				Namespace: s.namespace,
+				Verb:      "create",
+				Group:     adminAPIGroup,
+				Resource:  adminResource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("could not review access: %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("user %s may not create %s.%s in namespace %s", user.Username, adminResource, adminAPIGroup, s.namespace)
+	}
+	return nil
+}
+
+// adminOperator returns the name of the authenticated operator of an admin
+// request.
+func adminOperator(r *http.Request) string {
+	operator, _ := r.Context().Value(adminOperatorKey{}).(string)
+	return operator
+}
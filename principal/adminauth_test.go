@@ -0,0 +1,100 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+// fakeAdminAuth makes the fake API of s authenticate the token jane-token as
+// user jane and bob-token as user bob. Only jane may use the admin server.
+func fakeAdminAuth(t *testing.T, s *Server) {
+	t.Helper()
+	clientset, ok := s.kubeClient.Clientset.(*fake.Clientset)
+	require.True(t, ok)
+	clientset.PrependReactor("create", "tokenreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		review := action.(kubetesting.CreateAction).GetObject().(*authnv1.TokenReview)
+		switch review.Spec.Token {
+		case "jane-token":
+			review.Status = authnv1.TokenReviewStatus{Authenticated: true, User: authnv1.UserInfo{Username: "jane"}}
+		case "bob-token":
+			review.Status = authnv1.TokenReviewStatus{Authenticated: true, User: authnv1.UserInfo{Username: "bob"}}
+		default:
+			review.Status = authnv1.TokenReviewStatus{Error: "invalid token"}
+		}
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		review := action.(kubetesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "jane" && attrs != nil &&
+			attrs.Verb == "create" && attrs.Group == adminAPIGroup && attrs.Resource == adminResource &&
+			attrs.Namespace == s.namespace
+		return true, review, nil
+	})
+}
+
+func Test_authenticateAdmin(t *testing.T) {
+	s := newResourceTestServer(t)
+	fakeAdminAuth(t, s)
+	var operator string
+	h := s.authenticateAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator = adminOperator(r)
+	}))
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		operator = ""
+		r := httptest.NewRequest("GET", "/agents/agent/resync", nil)
+		// The operator reported by the client is ignored
+		r.Header.Set("X-Argocd-Agent-Operator", "mallory")
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Authorized user is the operator", func(t *testing.T) {
+		w := serve("Bearer jane-token")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "jane", operator)
+	})
+	t.Run("Missing token", func(t *testing.T) {
+		w := serve("")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+		assert.Empty(t, operator)
+	})
+	t.Run("Invalid token", func(t *testing.T) {
+		w := serve("Bearer nope")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, operator)
+	})
+	t.Run("Unauthorized user", func(t *testing.T) {
+		w := serve("Bearer bob-token")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, operator)
+	})
+}
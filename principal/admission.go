@@ -0,0 +1,183 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Modes of the admission webhook
+const (
+	// AdmissionModeWarn admits Applications targeting unavailable agents
+	// with a warning
+	AdmissionModeWarn = "warn"
+	// AdmissionModeDeny rejects Applications targeting unavailable agents
+	AdmissionModeDeny = "deny"
+)
+
+const (
+	// admissionValidatePath is the path the webhook validates Applications on
+	admissionValidatePath = "/validate/applications"
+
+	// defaultAdmissionDisconnectThreshold is how long an agent may be
+	// disconnected before Applications targeting it are refused
+	defaultAdmissionDisconnectThreshold = time.Hour
+
+	// maxAdmissionReviewSize is the largest admission review we accept
+	maxAdmissionReviewSize = 3 * 1024 * 1024
+)
+
+// admissionWebhook is the validating webhook for Applications
+type admissionWebhook struct {
+	// startedAt is when the principal started. Agents are unknown until
+	// they connect, so they are not refused for having never connected
+	// until the disconnect threshold has passed since.
+	startedAt time.Time
+	server    *http.Server
+}
+
+// startAdmissionWebhook starts serving the validating webhook for
+// Applications, if configured
+func (s *Server) startAdmissionWebhook(errch chan error) error {
+	if s.options.admissionAddress == "" {
+		return nil
+	}
+	cert, err := tlsutil.TLSCertFromFile(s.options.admissionCertPath, s.options.admissionKeyPath, false)
+	if err != nil {
+		return fmt.Errorf("could not load admission webhook certificate: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(admissionValidatePath, s.validateApplicationHandler)
+	s.admission.server = &http.Server{
+		Addr:              s.options.admissionAddress,
+		Handler:           mux,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log().Infof("Starting admission webhook on %s", s.options.admissionAddress)
+	go func() {
+		if err := s.admission.server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errch <- fmt.Errorf("admission webhook: %w", err)
+		}
+	}()
+	return nil
+}
+
+// stopAdmissionWebhook stops the validating webhook, if it is running
+func (s *Server) stopAdmissionWebhook(ctx context.Context) error {
+	if s.admission == nil || s.admission.server == nil {
+		return nil
+	}
+	return s.admission.server.Shutdown(ctx)
+}
+
+// validateApplicationHandler answers admission reviews for Applications
+func (s *Server) validateApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdmissionReviewSize))
+	if err != nil {
+		http.Error(w, "could not read request", http.StatusBadRequest)
+		return
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+	review.Response = s.reviewApplication(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(review)
+}
+
+// reviewApplication decides on the admission of an Application
+func (s *Server) reviewApplication(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{Allowed: true}
+	if req.Operation != admissionv1.Create {
+		return resp
+	}
+	app := &v1alpha1.Application{}
+	if err := json.Unmarshal(req.Object.Raw, app); err != nil {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{Code: http.StatusBadRequest, Message: fmt.Sprintf("could not decode Application: %v", err)}
+		return resp
+	}
+	if app.Namespace == "" {
+		app.Namespace = req.Namespace
+	}
+	agentName := s.getAgentNameForApp(app)
+	problem := s.agentUnavailable(agentName, time.Now())
+	if problem == "" {
+		return resp
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"application": app.QualifiedName(),
+		"agent":       agentName,
+		"user":        req.UserInfo.Username,
+	})
+	msg := fmt.Sprintf("Application targets agent %s, which %s", agentName, problem)
+	if s.options.admissionMode == AdmissionModeDeny {
+		logCtx.Infof("Refusing Application: agent %s", problem)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{Code: http.StatusForbidden, Reason: metav1.StatusReasonForbidden, Message: msg}
+		return resp
+	}
+	logCtx.Infof("Admitting Application with warning: agent %s", problem)
+	resp.Warnings = []string{msg}
+	return resp
+}
+
+// agentUnavailable returns why Applications should not target agentName, or
+// an empty string if the agent is available
+func (s *Server) agentUnavailable(agentName string, now time.Time) string {
+	// A standby principal knows nothing about the agents, and Applications
+	// of autonomous agents are created on the agent's behalf.
+	if agentName == "" || !s.IsActive() || s.agentMode(agentName) == types.AgentModeAutonomous {
+		return ""
+	}
+	if s.isAgentConnected(agentName) {
+		return ""
+	}
+	threshold := s.options.admissionDisconnectThreshold
+	_, _, disconnectedAt := s.activity.get(agentName)
+	if !disconnectedAt.IsZero() {
+		if now.Sub(disconnectedAt) < threshold {
+			return ""
+		}
+		return fmt.Sprintf("has been disconnected since %s", disconnectedAt.UTC().Format(time.RFC3339))
+	}
+	if now.Sub(s.admission.startedAt) < threshold {
+		return ""
+	}
+	return "has not connected to the principal"
+}
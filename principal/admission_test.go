@@ -0,0 +1,124 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+func appAdmissionReview(t *testing.T, namespace string, op admissionv1.Operation) *admissionv1.AdmissionReview {
+	t.Helper()
+	raw, err := json.Marshal(&v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: namespace}})
+	require.NoError(t, err)
+	return &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       ktypes.UID("review-1"),
+			Operation: op,
+			Namespace: namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+func Test_ApplicationAdmission(t *testing.T) {
+	s := newResourceTestServer(t)
+	s.admission.startedAt = time.Now().Add(-2 * defaultAdmissionDisconnectThreshold)
+	review := func(namespace string, op admissionv1.Operation) *admissionv1.AdmissionResponse {
+		return s.reviewApplication(appAdmissionReview(t, namespace, op).Request)
+	}
+
+	t.Run("Connected agent", func(t *testing.T) {
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		resp := review("agent", admissionv1.Create)
+		assert.True(t, resp.Allowed)
+		assert.Empty(t, resp.Warnings)
+	})
+
+	t.Run("Agent that never connected is warned about", func(t *testing.T) {
+		resp := review("unknown", admissionv1.Create)
+		assert.True(t, resp.Allowed)
+		require.Len(t, resp.Warnings, 1)
+		assert.Contains(t, resp.Warnings[0], "has not connected")
+	})
+
+	t.Run("Agent disconnected recently", func(t *testing.T) {
+		s.activity.recordDisconnect("agent", time.Now().Add(-time.Minute))
+		resp := review("agent", admissionv1.Create)
+		assert.True(t, resp.Allowed)
+		assert.Empty(t, resp.Warnings)
+	})
+
+	t.Run("Updates are not checked", func(t *testing.T) {
+		assert.Empty(t, review("unknown", admissionv1.Update).Warnings)
+	})
+
+	t.Run("Agent disconnected beyond threshold is refused in deny mode", func(t *testing.T) {
+		require.NoError(t, WithAdmissionPolicy(AdmissionModeDeny, time.Minute)(s))
+		defer func() {
+			require.NoError(t, WithAdmissionPolicy(AdmissionModeWarn, defaultAdmissionDisconnectThreshold)(s))
+		}()
+		s.activity.recordDisconnect("agent", time.Now().Add(-time.Hour))
+		resp := review("agent", admissionv1.Create)
+		assert.False(t, resp.Allowed)
+		require.NotNil(t, resp.Result)
+		assert.Contains(t, resp.Result.Message, "has been disconnected since")
+	})
+
+	t.Run("Agents are not refused right after startup", func(t *testing.T) {
+		startedAt := s.admission.startedAt
+		defer func() { s.admission.startedAt = startedAt }()
+		s.admission.startedAt = time.Now()
+		assert.Empty(t, review("unknown", admissionv1.Create).Warnings)
+	})
+
+	t.Run("Webhook answers admission reviews", func(t *testing.T) {
+		body, err := json.Marshal(appAdmissionReview(t, "unknown", admissionv1.Create))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		s.validateApplicationHandler(w, httptest.NewRequest(http.MethodPost, admissionValidatePath, bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+		out := &admissionv1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		require.NotNil(t, out.Response)
+		assert.Equal(t, ktypes.UID("review-1"), out.Response.UID)
+		assert.True(t, out.Response.Allowed)
+		assert.Len(t, out.Response.Warnings, 1)
+
+		w = httptest.NewRecorder()
+		s.validateApplicationHandler(w, httptest.NewRequest(http.MethodPost, admissionValidatePath, bytes.NewReader([]byte("{}"))))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		assert.Error(t, WithAdmissionPolicy("reject", time.Minute)(s))
+		assert.Error(t, WithAdmissionWebhook(":8443", "", "")(s))
+		assert.NoError(t, WithAdmissionWebhook("", "", "")(s))
+	})
+}
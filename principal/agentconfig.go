@@ -0,0 +1,409 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/informer"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+// agentConfigResyncPeriod is the interval in which the configurations of
+// all agents are synced, so that changes to the labels of agents are picked
+// up eventually
+const agentConfigResyncPeriod = 5 * time.Minute
+
+// agentConfigPusher pushes the configuration of AgentConfiguration resources
+// to the agents they select, and keeps track of what each agent holds.
+type agentConfigPusher struct {
+	informer *informer.Informer[*v1alpha1.AgentConfiguration]
+	client   dynamic.ResourceInterface
+
+	mu sync.Mutex
+	// held maps agent names to the configurations the agent is known to
+	// hold, by name and hash
+	held map[string]map[string]string
+}
+
+func (s *Server) newAgentConfigPusher(ctx context.Context) error {
+	client := s.kubeClient.DynamicClient.Resource(v1alpha1.AgentConfigurationResource).Namespace(s.namespace)
+	p := &agentConfigPusher{
+		client: client,
+		held:   make(map[string]map[string]string),
+	}
+	sync := func() { s.syncAllAgentConfigs(ctx) }
+	var err error
+	p.informer, err = informer.NewInformer(ctx,
+		informer.WithListHandler[*v1alpha1.AgentConfiguration](func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			list, err := client.List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return v1alpha1.AgentConfigurationListFromUnstructured(list)
+		}),
+		informer.WithWatchHandler[*v1alpha1.AgentConfiguration](func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			w, err := client.Watch(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, toAgentConfigurationEvent), nil
+		}),
+		informer.WithAddHandler(func(*v1alpha1.AgentConfiguration) { sync() }),
+		informer.WithUpdateHandler(func(old, new *v1alpha1.AgentConfiguration) {
+			// Status updates made by ourselves don't need to be pushed
+			if !reflect.DeepEqual(old.Spec, new.Spec) {
+				sync()
+			}
+		}),
+		informer.WithDeleteHandler(func(*v1alpha1.AgentConfiguration) { sync() }),
+		informer.WithGroupResource[*v1alpha1.AgentConfiguration](v1alpha1.Group, v1alpha1.AgentConfigurationResource.Resource),
+	)
+	if err != nil {
+		return fmt.Errorf("could not create AgentConfiguration informer: %w", err)
+	}
+	s.agentConfigs = p
+	return nil
+}
+
+// startAgentConfigPusher starts watching AgentConfiguration resources and
+// returns once they are synced.
+func (s *Server) startAgentConfigPusher(ctx context.Context) error {
+	go func() {
+		if err := s.agentConfigs.informer.Start(ctx); err != nil {
+			log().WithError(err).Error("Could not start AgentConfiguration informer")
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(agentConfigResyncPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = s.agentConfigs.informer.Stop()
+				return
+			case <-ticker.C:
+				s.syncAllAgentConfigs(ctx)
+			}
+		}
+	}()
+	syncCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return s.agentConfigs.informer.WaitForSync(syncCtx)
+}
+
+// toAgentConfigurationEvent converts the objects of watch events returned by
+// the dynamic client.
+func toAgentConfigurationEvent(ev watch.Event) (watch.Event, bool) {
+	u, ok := ev.Object.(*unstructured.Unstructured)
+	if !ok {
+		return ev, true
+	}
+	c, err := v1alpha1.AgentConfigurationFromUnstructured(u)
+	if err != nil {
+		log().WithError(err).Errorf("Invalid AgentConfiguration %s", u.GetName())
+		return ev, false
+	}
+	ev.Object = c
+	return ev, true
+}
+
+// agentConfigurations returns all AgentConfiguration resources, sorted by
+// name
+func (s *Server) agentConfigurations() []*v1alpha1.AgentConfiguration {
+	objs, err := s.agentConfigs.informer.Lister().List(labels.Everything())
+	if err != nil {
+		log().WithError(err).Error("Could not list AgentConfigurations")
+		return nil
+	}
+	configs := make([]*v1alpha1.AgentConfiguration, 0, len(objs))
+	for _, obj := range objs {
+		if c, ok := obj.(*v1alpha1.AgentConfiguration); ok {
+			configs = append(configs, c)
+		}
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs
+}
+
+// desiredAgentConfigs returns the configurations the given agent should hold
+func (s *Server) desiredAgentConfigs(agentName string) map[string]*v1alpha1.AgentConfiguration {
+	desired := make(map[string]*v1alpha1.AgentConfiguration)
+	for _, cfg := range s.agentConfigurations() {
+		as, err := newAgentSelector(cfg.Spec.AgentSelector, cfg.Spec.Agents)
+		if err != nil {
+			log().WithError(err).Warnf("Ignoring AgentConfiguration %s", cfg.Name)
+			continue
+		}
+		if s.selects(as, agentName) {
+			desired[cfg.Name] = cfg
+		}
+	}
+	return desired
+}
+
+// syncAllAgentConfigs pushes configuration changes to all agents
+func (s *Server) syncAllAgentConfigs(ctx context.Context) {
+	for _, agentName := range s.queues.Names() {
+		s.syncAgentConfigs(ctx, agentName)
+	}
+}
+
+// syncAgentConfigs pushes the configurations the agent should hold but
+// doesn't, and removes the ones it shouldn't hold.
+func (s *Server) syncAgentConfigs(ctx context.Context, agentName string) {
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return
+	}
+	desired := s.desiredAgentConfigs(agentName)
+	s.agentConfigs.mu.Lock()
+	held := make(map[string]string, len(s.agentConfigs.held[agentName]))
+	for name, hash := range s.agentConfigs.held[agentName] {
+		held[name] = hash
+	}
+	s.agentConfigs.mu.Unlock()
+
+	logCtx := log().WithField("agent", agentName)
+	for name, cfg := range desired {
+		hash := cfg.Spec.Config.Hash()
+		if held[name] == hash {
+			continue
+		}
+		logCtx.WithField("configuration", name).Debug("Pushing configuration to agent")
+		q.Add(s.events.AgentConfigPushEvent(&event.PushedConfig{Name: name, Hash: hash, Config: cfg.Spec.Config.DeepCopy()}))
+		s.setAgentConfigStatus(ctx, name, agentName, v1alpha1.AgentConfigPhasePending, "", "")
+	}
+	for name := range held {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		logCtx.WithField("configuration", name).Debug("Removing configuration from agent")
+		q.Add(s.events.AgentConfigPushEvent(&event.PushedConfig{Name: name}))
+	}
+}
+
+// processPushedConfigAck records the acknowledgment of a pushed
+// configuration by the agent.
+func (s *Server) processPushedConfigAck(ctx context.Context, agentName string, ack *event.PushedConfigAck) {
+	logCtx := s.logGrpcEvent().WithFields(logrus.Fields{
+		"client":        agentName,
+		"configuration": ack.Name,
+	})
+	if ack.Error != "" {
+		logCtx.Warnf("Agent could not apply configuration: %s", ack.Error)
+		s.setAgentConfigStatus(ctx, ack.Name, agentName, v1alpha1.AgentConfigPhaseFailed, ack.Hash, ack.Error)
+		return
+	}
+	s.agentConfigs.mu.Lock()
+	held, ok := s.agentConfigs.held[agentName]
+	if !ok {
+		held = make(map[string]string)
+		s.agentConfigs.held[agentName] = held
+	}
+	if ack.Hash == "" {
+		delete(held, ack.Name)
+	} else {
+		held[ack.Name] = ack.Hash
+	}
+	s.agentConfigs.mu.Unlock()
+
+	if ack.Hash == "" {
+		logCtx.Info("Agent removed configuration")
+		s.setAgentConfigStatus(ctx, ack.Name, agentName, "", "", "")
+		return
+	}
+	logCtx.WithField("hash", ack.Hash).Info("Agent applied configuration")
+	s.setAgentConfigStatus(ctx, ack.Name, agentName, v1alpha1.AgentConfigPhaseApplied, ack.Hash, "")
+}
+
+// processPushedConfigState compares the configurations held by the agent
+// with the desired ones, pushes what is missing and reports drift of
+// configurations the agent had applied before.
+func (s *Server) processPushedConfigState(ctx context.Context, agentName string, state *event.PushedConfigState) {
+	held := make(map[string]string, len(state.Configs))
+	for name, hash := range state.Configs {
+		held[name] = hash
+	}
+	s.agentConfigs.mu.Lock()
+	previous := s.agentConfigs.held[agentName]
+	s.agentConfigs.held[agentName] = held
+	s.agentConfigs.mu.Unlock()
+
+	drifted := make(map[string]string)
+	for name, cfg := range s.desiredAgentConfigs(agentName) {
+		want := cfg.Spec.Config.Hash()
+		hash, ok := held[name]
+		if ok && hash == want {
+			continue
+		}
+		// Without a previous acknowledgment, e.g. after a restart of the
+		// principal, the recorded status tells whether it was applied.
+		st := cfg.AgentStatus(agentName)
+		if previous[name] != want && (st == nil || st.Phase != v1alpha1.AgentConfigPhaseApplied || st.Hash != want) {
+			continue
+		}
+		msg := "agent does not hold the configuration"
+		if ok {
+			msg = fmt.Sprintf("agent holds configuration %s", hash)
+		}
+		s.logGrpcEvent().WithField("client", agentName).WithField("configuration", name).Warnf("Configuration drifted: %s", msg)
+		drifted[name] = msg
+	}
+	s.syncAgentConfigs(ctx, agentName)
+	// Drifted configurations have been pushed again, and stay drifted until
+	// the agent acknowledges them.
+	for name, msg := range drifted {
+		s.setAgentConfigStatus(ctx, name, agentName, v1alpha1.AgentConfigPhaseDrifted, held[name], msg)
+	}
+}
+
+// setAgentConfigStatus sets the status of the given configuration on the
+// given agent. An empty phase removes the agent from the status.
+func (s *Server) setAgentConfigStatus(ctx context.Context, name, agentName string, phase v1alpha1.AgentConfigPhase, hash, message string) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := s.agentConfigs.client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// The configuration has been deleted in the meantime
+			return nil
+		} else if err != nil {
+			return err
+		}
+		cfg, err := v1alpha1.AgentConfigurationFromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		if !updateAgentConfigStatus(cfg, agentName, phase, hash, message, time.Now()) {
+			return nil
+		}
+		u, err = cfg.ToUnstructured()
+		if err != nil {
+			return err
+		}
+		_, err = s.agentConfigs.client.UpdateStatus(ctx, u, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log().WithError(err).WithField("configuration", name).Warn("Could not update status of AgentConfiguration")
+	}
+}
+
+// updateAgentConfigStatus updates the status of cfg on the given agent and
+// returns whether anything has changed.
+func updateAgentConfigStatus(cfg *v1alpha1.AgentConfiguration, agentName string, phase v1alpha1.AgentConfigPhase, hash, message string, now time.Time) bool {
+	changed := false
+	if h := cfg.Spec.Config.Hash(); cfg.Status.Hash != h {
+		cfg.Status.Hash = h
+		changed = true
+	}
+	st := cfg.AgentStatus(agentName)
+	if phase == "" {
+		if st == nil {
+			return changed
+		}
+		cfg.Status.Agents = slices.DeleteFunc(cfg.Status.Agents, func(st v1alpha1.AgentConfigurationAgentStatus) bool {
+			return st.Name == agentName
+		})
+		return true
+	}
+	if st == nil {
+		cfg.Status.Agents = append(cfg.Status.Agents, v1alpha1.AgentConfigurationAgentStatus{Name: agentName})
+		sort.Slice(cfg.Status.Agents, func(i, j int) bool { return cfg.Status.Agents[i].Name < cfg.Status.Agents[j].Name })
+		st = cfg.AgentStatus(agentName)
+	}
+	if st.Phase != phase {
+		st.Phase = phase
+		st.LastTransitionTime = metav1.Time{Time: now}
+		changed = true
+	}
+	if hash != "" && st.Hash != hash {
+		st.Hash = hash
+		changed = true
+	}
+	if st.Message != message {
+		st.Message = message
+		changed = true
+	}
+	return changed
+}
+
+// processAgentConfigEvent processes configuration related events from the
+// agent.
+func (s *Server) processAgentConfigEvent(agentName string, ev *cloudevents.Event) error {
+	e := event.New(ev, event.TargetAgentConfig)
+	switch e.Type() {
+	case event.AgentConfigApplied:
+		report, err := e.AgentConfigReport()
+		if err != nil {
+			return fmt.Errorf("invalid agent config report: %w", err)
+		}
+		s.processAgentConfigReport(agentName, report)
+	case event.AgentConfigAck, event.AgentConfigState:
+		if s.agentConfigs == nil {
+			// Configuration push is disabled
+			return nil
+		}
+		if e.Type() == event.AgentConfigAck {
+			ack, err := e.PushedConfigAck()
+			if err != nil {
+				return fmt.Errorf("invalid agent config ack: %w", err)
+			}
+			s.processPushedConfigAck(s.ctx, agentName, ack)
+		} else {
+			state, err := e.PushedConfigState()
+			if err != nil {
+				return fmt.Errorf("invalid agent config state: %w", err)
+			}
+			s.processPushedConfigState(s.ctx, agentName, state)
+		}
+	default:
+		return fmt.Errorf("unknown agent config event type %s", e.Type())
+	}
+	return nil
+}
+
+// processAgentConfigReport processes the report of a configuration
+// generation that was applied by the agent.
+func (s *Server) processAgentConfigReport(agentName string, report *event.AgentConfigReport) {
+	logCtx := s.logGrpcEvent().WithFields(logrus.Fields{
+		"module":     "QueueProcessor",
+		"client":     agentName,
+		"generation": report.Generation,
+		"source":     report.Source,
+	})
+	if len(report.Rejected) > 0 {
+		logCtx.WithField("rejected", report.Rejected).Warn("Agent rejected parts of its configuration")
+	}
+	logCtx.WithField("applied", report.Applied).Info("Agent applied new configuration")
+	s.activity.recordConfig(agentName, report.Generation, time.Now())
+	s.triggerAgentStatusUpdate()
+}
@@ -0,0 +1,199 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+)
+
+func Test_AgentConfigPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubec := kube.NewKubernetesFakeClientWithApps("argocd")
+	kubec.DynamicClient = dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.AgentConfigurationResource: "AgentConfigurationList"})
+	s, err := NewServer(ctx, kubec, "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithAgentConfigurations(true))
+	require.NoError(t, err)
+	require.NotNil(t, s.agentConfigs)
+	s.events = event.NewEventSource("test")
+	agentEvents := event.NewEventSource("agent")
+
+	limit := 3
+	cfg := &v1alpha1.AgentConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: v1alpha1.AgentConfigurationKind},
+		ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "argocd"},
+		Spec: v1alpha1.AgentConfigurationSpec{
+			Agents: []string{"agent-1"},
+			Config: v1alpha1.AgentRuntimeConfig{RateLimits: &v1alpha1.RateLimits{MaxLogStreams: &limit}},
+		},
+	}
+	hash := cfg.Spec.Config.Hash()
+	u, err := cfg.ToUnstructured()
+	require.NoError(t, err)
+	client := kubec.DynamicClient.Resource(v1alpha1.AgentConfigurationResource).Namespace("argocd")
+	_, err = client.Create(ctx, u, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.queues.Create("agent-1"))
+	require.NoError(t, s.queues.Create("agent-2"))
+	require.NoError(t, s.startAgentConfigPusher(ctx))
+
+	status := func(t *testing.T) *v1alpha1.AgentConfigurationAgentStatus {
+		t.Helper()
+		u, err := client.Get(ctx, "limits", metav1.GetOptions{})
+		require.NoError(t, err)
+		c, err := v1alpha1.AgentConfigurationFromUnstructured(u)
+		require.NoError(t, err)
+		assert.Equal(t, hash, c.Status.Hash)
+		return c.AgentStatus("agent-1")
+	}
+	pushed := func(t *testing.T, agentName string) *event.PushedConfig {
+		t.Helper()
+		q := s.queues.SendQ(agentName)
+		for q.Len() > 0 {
+			ev, _ := q.Get()
+			q.Done(ev)
+			if event.Target(ev) != event.TargetAgentConfig {
+				continue
+			}
+			push, err := event.New(ev, event.TargetAgentConfig).PushedConfig()
+			require.NoError(t, err)
+			return push
+		}
+		return nil
+	}
+	report := func(t *testing.T, configs map[string]string) {
+		t.Helper()
+		ev := agentEvents.AgentConfigStateEvent(&event.PushedConfigState{Configs: configs})
+		require.NoError(t, s.processAgentConfigEvent("agent-1", ev))
+	}
+	ack := func(t *testing.T, ack *event.PushedConfigAck) {
+		t.Helper()
+		require.NoError(t, s.processAgentConfigEvent("agent-1", agentEvents.AgentConfigAckEvent(ack)))
+	}
+
+	t.Run("Missing configuration is pushed on state report", func(t *testing.T) {
+		// Drain events queued by the informer
+		require.Eventually(t, func() bool {
+			return s.queues.SendQ("agent-1").Len() > 0
+		}, 5*time.Second, 50*time.Millisecond)
+		pushed(t, "agent-1")
+		report(t, map[string]string{})
+		push := pushed(t, "agent-1")
+		require.NotNil(t, push)
+		assert.Equal(t, "limits", push.Name)
+		assert.Equal(t, hash, push.Hash)
+		require.NotNil(t, push.Config)
+		assert.Equal(t, &limit, push.Config.RateLimits.MaxLogStreams)
+		assert.Equal(t, v1alpha1.AgentConfigPhasePending, status(t).Phase)
+		assert.Nil(t, pushed(t, "agent-2"))
+	})
+
+	t.Run("Acknowledgment marks configuration applied", func(t *testing.T) {
+		ack(t, &event.PushedConfigAck{Name: "limits", Hash: hash})
+		st := status(t)
+		assert.Equal(t, v1alpha1.AgentConfigPhaseApplied, st.Phase)
+		assert.Equal(t, hash, st.Hash)
+		// Nothing is pushed if the agent holds the configuration
+		report(t, map[string]string{"limits": hash})
+		assert.Nil(t, pushed(t, "agent-1"))
+	})
+
+	t.Run("Failure is reported", func(t *testing.T) {
+		ack(t, &event.PushedConfigAck{Name: "limits", Hash: hash, Error: "invalid"})
+		st := status(t)
+		assert.Equal(t, v1alpha1.AgentConfigPhaseFailed, st.Phase)
+		assert.Equal(t, "invalid", st.Message)
+		ack(t, &event.PushedConfigAck{Name: "limits", Hash: hash})
+		assert.Equal(t, v1alpha1.AgentConfigPhaseApplied, status(t).Phase)
+	})
+
+	t.Run("Drift is detected and repaired", func(t *testing.T) {
+		report(t, map[string]string{"limits": "0000", "stale": "1111"})
+		st := status(t)
+		assert.Equal(t, v1alpha1.AgentConfigPhaseDrifted, st.Phase)
+		assert.Equal(t, "0000", st.Hash)
+		assert.NotEmpty(t, st.Message)
+
+		var pushes []*event.PushedConfig
+		for p := pushed(t, "agent-1"); p != nil; p = pushed(t, "agent-1") {
+			pushes = append(pushes, p)
+		}
+		require.Len(t, pushes, 2)
+		byName := map[string]*event.PushedConfig{}
+		for _, p := range pushes {
+			byName[p.Name] = p
+		}
+		assert.Equal(t, hash, byName["limits"].Hash)
+		// Configurations not selecting the agent are removed
+		assert.Nil(t, byName["stale"].Config)
+
+		ack(t, &event.PushedConfigAck{Name: "limits", Hash: hash})
+		ack(t, &event.PushedConfigAck{Name: "stale"})
+		st = status(t)
+		assert.Equal(t, v1alpha1.AgentConfigPhaseApplied, st.Phase)
+		assert.Empty(t, st.Message)
+		assert.Equal(t, map[string]string{"limits": hash}, s.agentConfigs.held["agent-1"])
+	})
+
+	t.Run("Configuration is removed when agent is deselected", func(t *testing.T) {
+		u, err := client.Get(ctx, "limits", metav1.GetOptions{})
+		require.NoError(t, err)
+		c, err := v1alpha1.AgentConfigurationFromUnstructured(u)
+		require.NoError(t, err)
+		c.Spec.Agents = []string{"agent-2"}
+		c.Generation++
+		u, err = c.ToUnstructured()
+		require.NoError(t, err)
+		_, err = client.Update(ctx, u, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return s.queues.SendQ("agent-2").Len() > 0
+		}, 5*time.Second, 50*time.Millisecond)
+		push := pushed(t, "agent-1")
+		require.NotNil(t, push)
+		assert.Equal(t, "limits", push.Name)
+		assert.Nil(t, push.Config)
+		require.NotNil(t, pushed(t, "agent-2"))
+	})
+}
+
+func Test_UpdateAgentConfigStatus(t *testing.T) {
+	now := time.Now()
+	cfg := &v1alpha1.AgentConfiguration{}
+	assert.True(t, updateAgentConfigStatus(cfg, "b", v1alpha1.AgentConfigPhasePending, "", "", now))
+	assert.True(t, updateAgentConfigStatus(cfg, "a", v1alpha1.AgentConfigPhaseApplied, "abc", "", now))
+	require.Len(t, cfg.Status.Agents, 2)
+	assert.Equal(t, "a", cfg.Status.Agents[0].Name)
+	assert.False(t, updateAgentConfigStatus(cfg, "a", v1alpha1.AgentConfigPhaseApplied, "abc", "", now.Add(time.Minute)))
+	assert.Equal(t, now, cfg.AgentStatus("a").LastTransitionTime.Time)
+	assert.True(t, updateAgentConfigStatus(cfg, "b", "", "", "", now))
+	assert.Nil(t, cfg.AgentStatus("b"))
+	assert.False(t, updateAgentConfigStatus(cfg, "b", "", "", "", now))
+}
@@ -0,0 +1,432 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/informer"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// defaultOperationParallelism is the default number of agents an
+	// operation runs on at the same time
+	defaultOperationParallelism = 10
+	// defaultOperationTimeout is the default time an operation may take on
+	// a single agent
+	defaultOperationTimeout = 5 * time.Minute
+	// configPushPollInterval is the interval in which ConfigPush operations
+	// check whether the agent has applied its configuration
+	configPushPollInterval = 500 * time.Millisecond
+)
+
+// agentOperationRunner runs AgentOperation resources on the agents they
+// select.
+type agentOperationRunner struct {
+	informer *informer.Informer[*v1alpha1.AgentOperation]
+	client   dynamic.ResourceInterface
+	// bundleDir is the directory support bundles are written to
+	bundleDir string
+
+	mu sync.Mutex
+	// running holds the names of operations that are run by this principal
+	running map[string]bool
+}
+
+// operationRun tracks the status of an operation while it is running
+type operationRun struct {
+	mu sync.Mutex
+	op *v1alpha1.AgentOperation
+}
+
+func (s *Server) newAgentOperationRunner(ctx context.Context, bundleDir string) error {
+	client := s.kubeClient.DynamicClient.Resource(v1alpha1.AgentOperationResource).Namespace(s.namespace)
+	r := &agentOperationRunner{
+		client:    client,
+		bundleDir: bundleDir,
+		running:   make(map[string]bool),
+	}
+	var err error
+	r.informer, err = informer.NewInformer(ctx,
+		informer.WithListHandler[*v1alpha1.AgentOperation](func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			list, err := client.List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return v1alpha1.AgentOperationListFromUnstructured(list)
+		}),
+		informer.WithWatchHandler[*v1alpha1.AgentOperation](func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			w, err := client.Watch(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, toAgentOperationEvent), nil
+		}),
+		informer.WithAddHandler(func(op *v1alpha1.AgentOperation) {
+			s.handleAgentOperation(ctx, op)
+		}),
+		informer.WithGroupResource[*v1alpha1.AgentOperation](v1alpha1.Group, v1alpha1.AgentOperationResource.Resource),
+	)
+	if err != nil {
+		return fmt.Errorf("could not create AgentOperation informer: %w", err)
+	}
+	s.agentOperations = r
+	return nil
+}
+
+// startAgentOperationRunner starts watching AgentOperation resources and
+// returns once they are synced.
+func (s *Server) startAgentOperationRunner(ctx context.Context) error {
+	go func() {
+		if err := s.agentOperations.informer.Start(ctx); err != nil {
+			log().WithError(err).Error("Could not start AgentOperation informer")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = s.agentOperations.informer.Stop()
+	}()
+	syncCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return s.agentOperations.informer.WaitForSync(syncCtx)
+}
+
+// toAgentOperationEvent converts the objects of watch events returned by the
+// dynamic client.
+func toAgentOperationEvent(ev watch.Event) (watch.Event, bool) {
+	u, ok := ev.Object.(*unstructured.Unstructured)
+	if !ok {
+		return ev, true
+	}
+	op, err := v1alpha1.AgentOperationFromUnstructured(u)
+	if err != nil {
+		log().WithError(err).Errorf("Invalid AgentOperation %s", u.GetName())
+		return ev, false
+	}
+	ev.Object = op
+	return ev, true
+}
+
+// handleAgentOperation starts new operations. Operations that were running
+// when the principal was restarted cannot be resumed and are failed.
+func (s *Server) handleAgentOperation(ctx context.Context, op *v1alpha1.AgentOperation) {
+	r := s.agentOperations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[op.Name] {
+		return
+	}
+	switch op.Status.Phase {
+	case "", v1alpha1.AgentOperationPending:
+		r.running[op.Name] = true
+		go func() {
+			s.runAgentOperation(ctx, op.DeepCopy())
+			r.mu.Lock()
+			delete(r.running, op.Name)
+			r.mu.Unlock()
+		}()
+	case v1alpha1.AgentOperationRunning:
+		run := &operationRun{op: op.DeepCopy()}
+		for i := range run.op.Status.Agents {
+			st := &run.op.Status.Agents[i]
+			if st.Phase == v1alpha1.AgentOperationPending || st.Phase == v1alpha1.AgentOperationRunning {
+				st.Phase = v1alpha1.AgentOperationFailed
+				st.Message = "interrupted by a restart of the principal"
+			}
+		}
+		s.completeAgentOperation(ctx, run)
+	}
+}
+
+// runAgentOperation runs op on all agents it selects and reports the
+// progress in the status of op.
+func (s *Server) runAgentOperation(ctx context.Context, op *v1alpha1.AgentOperation) {
+	logCtx := log().WithFields(logrus.Fields{
+		"operation": op.Name,
+		"type":      op.Spec.Type,
+	})
+	run := &operationRun{op: op}
+	now := metav1.Now()
+	op.Status = v1alpha1.AgentOperationStatus{StartTime: &now}
+
+	if err := s.validateAgentOperation(op); err != nil {
+		logCtx.WithError(err).Warn("Invalid AgentOperation")
+		op.Status.Message = err.Error()
+		s.completeAgentOperation(ctx, run)
+		return
+	}
+	as, err := newAgentSelector(op.Spec.AgentSelector, op.Spec.Agents)
+	if err != nil {
+		op.Status.Message = err.Error()
+		s.completeAgentOperation(ctx, run)
+		return
+	}
+	agents := s.selectAgents(as)
+	op.Status.Phase = v1alpha1.AgentOperationRunning
+	for _, agentName := range agents {
+		op.Status.Agents = append(op.Status.Agents, v1alpha1.AgentOperationAgentStatus{
+			Name:  agentName,
+			Phase: v1alpha1.AgentOperationPending,
+		})
+	}
+	s.updateAgentOperationStatus(ctx, run)
+	logCtx.Infof("Running operation on %d agents", len(agents))
+
+	parallelism := op.Spec.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultOperationParallelism
+	}
+	timeout := defaultOperationTimeout
+	if op.Spec.Timeout != nil && op.Spec.Timeout.Duration > 0 {
+		timeout = op.Spec.Timeout.Duration
+	}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, agentName := range agents {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if op.Spec.Type == v1alpha1.AgentOperationUpdate && op.Spec.Update.MaintenanceWindow != nil {
+				if err := s.waitForMaintenanceWindow(ctx, run, i, op.Spec.Update.MaintenanceWindow); err != nil {
+					s.setAgentOperationPhase(ctx, run, i, v1alpha1.AgentOperationFailed, err.Error(), "")
+					return
+				}
+			}
+			s.setAgentOperationPhase(ctx, run, i, v1alpha1.AgentOperationRunning, "", "")
+			agentCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			result, err := s.runAgentOperationOn(agentCtx, op, agentName)
+			if err != nil {
+				logCtx.WithField("agent", agentName).WithError(err).Warn("Operation failed on agent")
+				s.setAgentOperationPhase(ctx, run, i, v1alpha1.AgentOperationFailed, err.Error(), "")
+				return
+			}
+			s.setAgentOperationPhase(ctx, run, i, v1alpha1.AgentOperationSucceeded, "", result)
+		}()
+	}
+	wg.Wait()
+	s.completeAgentOperation(ctx, run)
+	logCtx.WithField("progress", op.Status.Progress).Info("Operation completed")
+}
+
+func (s *Server) validateAgentOperation(op *v1alpha1.AgentOperation) error {
+	switch op.Spec.Type {
+	case v1alpha1.AgentOperationRefresh:
+	case v1alpha1.AgentOperationConfigPush:
+		if s.agentConfigs == nil {
+			return errors.New("pushing configuration to agents is not enabled")
+		}
+	case v1alpha1.AgentOperationSupportBundle:
+		if s.agentOperations.bundleDir == "" {
+			return errors.New("no directory for support bundles configured")
+		}
+		if op.Spec.SupportBundle == nil || op.Spec.SupportBundle.Application == "" {
+			return errors.New("support bundle operations require an application")
+		}
+	case v1alpha1.AgentOperationUpdate:
+		return validateUpdateOperation(op.Spec.Update)
+	default:
+		return fmt.Errorf("unknown operation type %q", op.Spec.Type)
+	}
+	return nil
+}
+
+// runAgentOperationOn runs op on a single agent and returns a description of
+// the result.
+func (s *Server) runAgentOperationOn(ctx context.Context, op *v1alpha1.AgentOperation, agentName string) (string, error) {
+	if !s.isAgentConnected(agentName) {
+		return "", errors.New("agent is not connected")
+	}
+	switch op.Spec.Type {
+	case v1alpha1.AgentOperationRefresh:
+		result, err := s.forceResync(ctx, agentName)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("re-sent %d applications", result.Applications), nil
+	case v1alpha1.AgentOperationConfigPush:
+		return "", s.pushAgentConfigs(ctx, agentName)
+	case v1alpha1.AgentOperationSupportBundle:
+		if s.inMaintenance(agentName) {
+			return "", errAgentInMaintenance
+		}
+		return s.collectSupportBundle(ctx, op, agentName)
+	case v1alpha1.AgentOperationUpdate:
+		return s.updateAgent(ctx, op, agentName)
+	}
+	return "", fmt.Errorf("unknown operation type %q", op.Spec.Type)
+}
+
+// refreshAgent resyncs the resources of the agent with the principal, as is
+// done when the agent connects for the first time.
+func (s *Server) refreshAgent(agentName string) error {
+	mode := s.agentMode(agentName)
+	if mode == types.AgentModeUnknown {
+		return errors.New("mode of agent is unknown")
+	}
+	s.resyncStatus.reset(agentName)
+	return s.handleResyncOnConnect(types.NewAgent(agentName, mode.String()))
+}
+
+// pushAgentConfigs pushes the agent's configuration and waits until the
+// agent holds all configurations selecting it.
+func (s *Server) pushAgentConfigs(ctx context.Context, agentName string) error {
+	s.syncAgentConfigs(ctx, agentName)
+	err := wait.PollUntilContextCancel(ctx, configPushPollInterval, true, func(context.Context) (bool, error) {
+		desired := s.desiredAgentConfigs(agentName)
+		s.agentConfigs.mu.Lock()
+		held := s.agentConfigs.held[agentName]
+		done := len(held) == len(desired)
+		for name, cfg := range desired {
+			if held[name] != cfg.Spec.Config.Hash() {
+				done = false
+			}
+		}
+		s.agentConfigs.mu.Unlock()
+		for name, cfg := range desired {
+			if st := cfg.AgentStatus(agentName); st != nil && st.Phase == v1alpha1.AgentConfigPhaseFailed {
+				return false, fmt.Errorf("agent could not apply configuration %s: %s", name, st.Message)
+			}
+		}
+		return done, nil
+	})
+	if wait.Interrupted(err) {
+		return errors.New("timeout waiting for agent to apply its configuration")
+	}
+	return err
+}
+
+// collectSupportBundle collects a support bundle from the agent and writes
+// it to the bundle directory. Returns the path of the bundle.
+func (s *Server) collectSupportBundle(ctx context.Context, op *v1alpha1.AgentOperation, agentName string) (string, error) {
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return "", errors.New("no send queue for agent")
+	}
+	spec := op.Spec.SupportBundle
+	req := &event.SupportBundleRequest{
+		UUID:        uuid.NewString(),
+		Application: spec.Application,
+		Namespace:   spec.Namespace,
+		Previous:    true,
+		LimitBytes:  spec.LimitBytes,
+	}
+	ev, err := s.events.NewSupportBundleRequestEvent(req)
+	if err != nil {
+		return "", err
+	}
+	event.SetTTL(ev, requestTimeout*6)
+	path := filepath.Join(s.agentOperations.bundleDir, fmt.Sprintf("%s-%s-%s.tar.gz", op.Name, agentName, spec.Application))
+	sink := filetransfer.NewFileSink(path)
+	transfer := s.fileTransferServer.Register(req.UUID, sink)
+	defer s.fileTransferServer.Remove(req.UUID)
+	q.Add(ev)
+
+	err = transfer.Wait(ctx, requestTimeout*6)
+	if err != nil {
+		transfer.Abort(http.StatusGatewayTimeout, "Operation did not complete")
+		return "", err
+	}
+	if err := sink.Close(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// setAgentOperationPhase sets the phase of the operation on the i-th agent
+// and updates the status of the operation.
+func (s *Server) setAgentOperationPhase(ctx context.Context, run *operationRun, i int, phase v1alpha1.AgentOperationPhase, message, result string) {
+	run.mu.Lock()
+	st := &run.op.Status.Agents[i]
+	st.Phase = phase
+	st.Message = message
+	st.Result = result
+	run.mu.Unlock()
+	s.updateAgentOperationStatus(ctx, run)
+}
+
+// completeAgentOperation sets the final phase of the operation. The
+// operation fails if it failed on any agent.
+func (s *Server) completeAgentOperation(ctx context.Context, run *operationRun) {
+	run.mu.Lock()
+	status := &run.op.Status
+	status.UpdateProgress()
+	now := metav1.Now()
+	status.CompletionTime = &now
+	switch {
+	case status.Message != "" && status.Phase != v1alpha1.AgentOperationRunning:
+		// The operation could not be started
+		status.Phase = v1alpha1.AgentOperationFailed
+	case status.Progress.Failed > 0:
+		status.Phase = v1alpha1.AgentOperationFailed
+		status.Message = fmt.Sprintf("operation failed on %d of %d agents", status.Progress.Failed, status.Progress.Total)
+	default:
+		status.Phase = v1alpha1.AgentOperationSucceeded
+		status.Message = ""
+	}
+	run.mu.Unlock()
+	s.updateAgentOperationStatus(ctx, run)
+}
+
+// updateAgentOperationStatus writes the status of the running operation
+func (s *Server) updateAgentOperationStatus(ctx context.Context, run *operationRun) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.op.Status.UpdateProgress()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := s.agentOperations.client.Get(ctx, run.op.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		op, err := v1alpha1.AgentOperationFromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		op.Status = run.op.Status
+		u, err = op.ToUnstructured()
+		if err != nil {
+			return err
+		}
+		_, err = s.agentOperations.client.UpdateStatus(ctx, u, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log().WithError(err).WithField("operation", run.op.Name).Warn("Could not update status of AgentOperation")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	appv1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+)
+
+func Test_SelectAgents(t *testing.T) {
+	s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled())
+	require.NoError(t, err)
+	require.NoError(t, s.clusterMgr.MapCluster("prod-1", &appv1.Cluster{Labels: map[string]string{"env": "prod"}}))
+	require.NoError(t, s.clusterMgr.MapCluster("dev-1", &appv1.Cluster{Labels: map[string]string{"env": "dev"}}))
+	require.NoError(t, s.queues.Create("prod-2"))
+
+	as, err := newAgentSelector(&metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, []string{"dev-1", "other"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-1", "other", "prod-1"}, s.selectAgents(as))
+
+	as, err = newAgentSelector(nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, s.selectAgents(as))
+
+	as, err = newAgentSelector(&metav1.LabelSelector{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-1", "prod-1", "prod-2"}, s.selectAgents(as))
+
+	_, err = newAgentSelector(&metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "bogus"}}}, nil)
+	assert.Error(t, err)
+}
+
+func Test_AgentOperations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubec := kube.NewKubernetesFakeClientWithApps("argocd")
+	kubec.DynamicClient = dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			v1alpha1.AgentOperationResource:     "AgentOperationList",
+			v1alpha1.AgentConfigurationResource: "AgentConfigurationList",
+		})
+	s, err := NewServer(ctx, kubec, "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(),
+		WithAgentConfigurations(true), WithAgentOperations(true, ""))
+	require.NoError(t, err)
+	s.events = event.NewEventSource("test")
+	ops := kubec.DynamicClient.Resource(v1alpha1.AgentOperationResource).Namespace("argocd")
+
+	for _, agentName := range []string{"agent-1", "agent-2"} {
+		require.NoError(t, s.queues.Create(agentName))
+		s.setAgentMode(agentName, types.AgentModeManaged)
+	}
+	s.eventStreamSrv = eventstream.NewServer(s.queues, event.NewEventWritersMap(), nil, &cluster.Manager{})
+	s.eventStreamSrv.MarkConnected("agent-1")
+	defer s.eventStreamSrv.MarkDisconnected("agent-1")
+
+	createOp := func(t *testing.T, op *v1alpha1.AgentOperation) {
+		t.Helper()
+		op.TypeMeta = metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: v1alpha1.AgentOperationKind}
+		op.Namespace = "argocd"
+		u, err := op.ToUnstructured()
+		require.NoError(t, err)
+		_, err = ops.Create(ctx, u, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	waitForOp := func(t *testing.T, name string) *v1alpha1.AgentOperation {
+		t.Helper()
+		var op *v1alpha1.AgentOperation
+		require.Eventually(t, func() bool {
+			u, err := ops.Get(ctx, name, metav1.GetOptions{})
+			require.NoError(t, err)
+			op, err = v1alpha1.AgentOperationFromUnstructured(u)
+			require.NoError(t, err)
+			return op.Status.CompletionTime != nil
+		}, 5*time.Second, 50*time.Millisecond)
+		return op
+	}
+	drain := func(agentName string) []*event.Event {
+		var evs []*event.Event
+		q := s.queues.SendQ(agentName)
+		for q.Len() > 0 {
+			ev, _ := q.Get()
+			q.Done(ev)
+			evs = append(evs, event.New(ev, event.Target(ev)))
+		}
+		return evs
+	}
+
+	// An operation that was running when the principal was restarted
+	createOp(t, &v1alpha1.AgentOperation{
+		ObjectMeta: metav1.ObjectMeta{Name: "interrupted"},
+		Spec:       v1alpha1.AgentOperationSpec{Type: v1alpha1.AgentOperationRefresh},
+		Status: v1alpha1.AgentOperationStatus{
+			Phase: v1alpha1.AgentOperationRunning,
+			Agents: []v1alpha1.AgentOperationAgentStatus{
+				{Name: "agent-1", Phase: v1alpha1.AgentOperationSucceeded},
+				{Name: "agent-2", Phase: v1alpha1.AgentOperationRunning},
+			},
+		},
+	})
+	require.NoError(t, s.startAgentConfigPusher(ctx))
+	require.NoError(t, s.startAgentOperationRunner(ctx))
+
+	t.Run("Interrupted operation fails", func(t *testing.T) {
+		op := waitForOp(t, "interrupted")
+		assert.Equal(t, v1alpha1.AgentOperationFailed, op.Status.Phase)
+		assert.Equal(t, v1alpha1.AgentOperationProgress{Total: 2, Succeeded: 1, Failed: 1}, op.Status.Progress)
+		assert.Contains(t, op.Status.Agents[1].Message, "interrupted")
+	})
+
+	t.Run("Refresh is run on all selected agents", func(t *testing.T) {
+		createOp(t, &v1alpha1.AgentOperation{
+			ObjectMeta: metav1.ObjectMeta{Name: "refresh"},
+			Spec: v1alpha1.AgentOperationSpec{
+				Type:   v1alpha1.AgentOperationRefresh,
+				Agents: []string{"agent-1", "agent-2"},
+			},
+		})
+		op := waitForOp(t, "refresh")
+		assert.Equal(t, v1alpha1.AgentOperationFailed, op.Status.Phase)
+		assert.Equal(t, v1alpha1.AgentOperationProgress{Total: 2, Succeeded: 1, Failed: 1}, op.Status.Progress)
+		assert.Equal(t, "operation failed on 1 of 2 agents", op.Status.Message)
+		require.Len(t, op.Status.Agents, 2)
+		assert.Equal(t, v1alpha1.AgentOperationSucceeded, op.Status.Agents[0].Phase)
+		assert.Equal(t, "agent is not connected", op.Status.Agents[1].Message)
+
+		evTypes := []string{}
+		for _, ev := range drain("agent-1") {
+			evTypes = append(evTypes, ev.Type().String())
+		}
+		assert.Contains(t, evTypes, event.EventRequestResourceResync.String())
+	})
+
+	t.Run("ConfigPush waits for the agent to apply its configuration", func(t *testing.T) {
+		cfgs := kubec.DynamicClient.Resource(v1alpha1.AgentConfigurationResource).Namespace("argocd")
+		cfg := &v1alpha1.AgentConfiguration{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: v1alpha1.AgentConfigurationKind},
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "argocd"},
+			Spec: v1alpha1.AgentConfigurationSpec{
+				Agents: []string{"agent-1"},
+				Config: v1alpha1.AgentRuntimeConfig{LogRedactions: []v1alpha1.LogRedaction{{Pattern: "secret"}}},
+			},
+		}
+		u, err := cfg.ToUnstructured()
+		require.NoError(t, err)
+		_, err = cfgs.Create(ctx, u, metav1.CreateOptions{})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return len(s.desiredAgentConfigs("agent-1")) == 1
+		}, 5*time.Second, 50*time.Millisecond)
+
+		createOp(t, &v1alpha1.AgentOperation{
+			ObjectMeta: metav1.ObjectMeta{Name: "push"},
+			Spec: v1alpha1.AgentOperationSpec{
+				Type:   v1alpha1.AgentOperationConfigPush,
+				Agents: []string{"agent-1"},
+			},
+		})
+		// Acknowledge the configuration as the agent would
+		require.Eventually(t, func() bool {
+			for _, ev := range drain("agent-1") {
+				if ev.Target() != event.TargetAgentConfig {
+					continue
+				}
+				push, err := ev.PushedConfig()
+				require.NoError(t, err)
+				ack := event.NewEventSource("agent").AgentConfigAckEvent(&event.PushedConfigAck{Name: push.Name, Hash: push.Hash})
+				require.NoError(t, s.processAgentConfigEvent("agent-1", ack))
+				return true
+			}
+			return false
+		}, 5*time.Second, 50*time.Millisecond)
+
+		op := waitForOp(t, "push")
+		assert.Equal(t, v1alpha1.AgentOperationSucceeded, op.Status.Phase)
+		assert.Equal(t, v1alpha1.AgentOperationProgress{Total: 1, Succeeded: 1}, op.Status.Progress)
+	})
+
+	t.Run("Invalid operation fails", func(t *testing.T) {
+		createOp(t, &v1alpha1.AgentOperation{
+			ObjectMeta: metav1.ObjectMeta{Name: "bundle"},
+			Spec: v1alpha1.AgentOperationSpec{
+				Type:          v1alpha1.AgentOperationSupportBundle,
+				Agents:        []string{"agent-1"},
+				SupportBundle: &v1alpha1.SupportBundleOperation{Application: "guestbook"},
+			},
+		})
+		op := waitForOp(t, "bundle")
+		assert.Equal(t, v1alpha1.AgentOperationFailed, op.Status.Phase)
+		assert.Equal(t, "no directory for support bundles configured", op.Status.Message)
+		assert.Empty(t, op.Status.Agents)
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// agentSelector selects agents by name or by the labels of their cluster
+// secret. A nil label selector selects no agents by label, while an empty
+// one selects all agents.
+type agentSelector struct {
+	names    []string
+	selector labels.Selector
+}
+
+func newAgentSelector(selector *metav1.LabelSelector, names []string) (*agentSelector, error) {
+	as := &agentSelector{names: names, selector: labels.Nothing()}
+	if selector != nil {
+		var err error
+		as.selector, err = metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid agent selector: %w", err)
+		}
+	}
+	return as, nil
+}
+
+// agentLabels returns the labels of the agent's cluster secret
+func (s *Server) agentLabels(agentName string) labels.Set {
+	if s.clusterMgr == nil {
+		return nil
+	}
+	if cluster := s.clusterMgr.Mapping(agentName); cluster != nil {
+		return cluster.Labels
+	}
+	return nil
+}
+
+// selects returns whether as selects the given agent
+func (s *Server) selects(as *agentSelector, agentName string) bool {
+	return slices.Contains(as.names, agentName) || as.selector.Matches(s.agentLabels(agentName))
+}
+
+// selectAgents returns the names of all agents selected by as, sorted by
+// name. Agents are known by their cluster secret or their connection to the
+// principal. Agents selected by name are always included.
+func (s *Server) selectAgents(as *agentSelector) []string {
+	known := s.queues.Names()
+	if s.clusterMgr != nil {
+		known = append(known, s.clusterMgr.Agents()...)
+	}
+	known = append(known, as.names...)
+	selected := []string{}
+	for _, agentName := range known {
+		if !slices.Contains(selected, agentName) && s.selects(as, agentName) {
+			selected = append(selected, agentName)
+		}
+	}
+	sort.Strings(selected)
+	return selected
+}
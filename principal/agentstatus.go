@@ -0,0 +1,445 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type streamKind int
+
+const (
+	streamLogs streamKind = iota
+	streamTerminal
+)
+
+// agentActivity keeps track of per-agent information that is not available
+// elsewhere in the principal, for reporting in the AgentStatus resource.
+type agentActivity struct {
+	mu             sync.Mutex
+	streams        map[string]*v1alpha1.StreamStatus
+	heartbeats     map[string]time.Time
+	disconnectedAt map[string]time.Time
+	configs        map[string]configGeneration
+	permissions    map[string]*event.PermissionReport
+	filters        map[string]*event.ResourceFilterReport
+	// certificates holds the last client certificate of each agent, keyed
+	// by listener and agent name
+	certificates map[string]*v1alpha1.ClientCertificateStatus
+}
+
+// configGeneration is the configuration generation last applied by an agent
+type configGeneration struct {
+	generation int64
+	appliedAt  time.Time
+}
+
+func newAgentActivity() *agentActivity {
+	return &agentActivity{
+		streams:        make(map[string]*v1alpha1.StreamStatus),
+		heartbeats:     make(map[string]time.Time),
+		disconnectedAt: make(map[string]time.Time),
+		configs:        make(map[string]configGeneration),
+		permissions:    make(map[string]*event.PermissionReport),
+		filters:        make(map[string]*event.ResourceFilterReport),
+		certificates:   make(map[string]*v1alpha1.ClientCertificateStatus),
+	}
+}
+
+// beginStream counts an active stream of the given kind to agentName. The
+// returned function must be called when the stream ends.
+//
+// All methods of agentActivity may be called on a nil receiver, in which
+// case nothing is tracked.
+func (a *agentActivity) beginStream(agentName string, kind streamKind) func() {
+	if a == nil {
+		return func() {}
+	}
+	a.addStream(agentName, kind, 1)
+	return func() {
+		a.addStream(agentName, kind, -1)
+	}
+}
+
+func (a *agentActivity) addStream(agentName string, kind streamKind, n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st, ok := a.streams[agentName]
+	if !ok {
+		st = &v1alpha1.StreamStatus{}
+		a.streams[agentName] = st
+	}
+	switch kind {
+	case streamLogs:
+		st.Logs += n
+	case streamTerminal:
+		st.Terminals += n
+	}
+}
+
+func (a *agentActivity) recordHeartbeat(agentName string, at time.Time) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.heartbeats[agentName] = at
+}
+
+func (a *agentActivity) recordDisconnect(agentName string, at time.Time) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.disconnectedAt[agentName] = at
+}
+
+func (a *agentActivity) recordConfig(agentName string, generation int64, at time.Time) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configs[agentName] = configGeneration{generation: generation, appliedAt: at}
+}
+
+func (a *agentActivity) recordPermissions(agentName string, report *event.PermissionReport) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.permissions[agentName] = report
+}
+
+func (a *agentActivity) recordResourceFilter(agentName string, report *event.ResourceFilterReport) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.filters[agentName] = report
+}
+
+// recordCertificate records the client certificate agentName was accepted
+// with on listener. It returns whether the certificate differs from the one
+// recorded before.
+func (a *agentActivity) recordCertificate(listener, agentName string, cert *v1alpha1.ClientCertificateStatus) bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := listener + "/" + agentName
+	if prev, ok := a.certificates[key]; ok && prev.Fingerprint == cert.Fingerprint {
+		return false
+	}
+	a.certificates[key] = cert
+	return true
+}
+
+// certificate returns the client certificate agentName was last accepted
+// with on listener, or nil
+func (a *agentActivity) certificate(listener, agentName string) *v1alpha1.ClientCertificateStatus {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cert, ok := a.certificates[listener+"/"+agentName]
+	if !ok {
+		return nil
+	}
+	return cert.DeepCopy()
+}
+
+// permissionStatus returns the result of the last permission check reported
+// by agentName, or nil if it hasn't reported one
+func (a *agentActivity) permissionStatus(agentName string) *v1alpha1.PermissionStatus {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.permissions[agentName]
+	if !ok {
+		return nil
+	}
+	return &v1alpha1.PermissionStatus{
+		Features:   r.Features,
+		Missing:    r.Missing,
+		Excess:     r.Excess,
+		Incomplete: r.Incomplete,
+		Error:      r.Error,
+		CheckedAt:  metav1.Time{Time: r.CheckedAt},
+	}
+}
+
+// resourceFilterStatus returns the resource filter last reported by
+// agentName, or nil if it hasn't reported one
+func (a *agentActivity) resourceFilterStatus(agentName string) *v1alpha1.ResourceFilterStatus {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.filters[agentName]
+	if !ok {
+		return nil
+	}
+	return &v1alpha1.ResourceFilterStatus{
+		Inclusions: resourceFilterRules(r.Inclusions),
+		Exclusions: resourceFilterRules(r.Exclusions),
+		AppliedAt:  metav1.Time{Time: r.AppliedAt},
+	}
+}
+
+func resourceFilterRules(rules []event.ResourceFilterRule) []v1alpha1.ResourceFilterRule {
+	var res []v1alpha1.ResourceFilterRule
+	for _, r := range rules {
+		res = append(res, v1alpha1.ResourceFilterRule{
+			APIGroups: r.APIGroups,
+			Kinds:     r.Kinds,
+			Clusters:  r.Clusters,
+		})
+	}
+	return res
+}
+
+// config returns the configuration generation last applied by agentName
+func (a *agentActivity) config(agentName string) configGeneration {
+	if a == nil {
+		return configGeneration{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.configs[agentName]
+}
+
+// get returns a snapshot of the activity of agentName
+func (a *agentActivity) get(agentName string) (streams v1alpha1.StreamStatus, heartbeat, disconnectedAt time.Time) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if st, ok := a.streams[agentName]; ok {
+		streams = *st
+	}
+	return streams, a.heartbeats[agentName], a.disconnectedAt[agentName]
+}
+
+func optionalTime(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &metav1.Time{Time: t}
+}
+
+// processPermissionReport records the result of a permission check of the
+// agent, for reporting in its AgentStatus.
+func (s *Server) processPermissionReport(agentName string, ev *cloudevents.Event) error {
+	report, err := event.New(ev, event.TargetPermissions).PermissionReport()
+	if err != nil {
+		return fmt.Errorf("invalid permission report: %w", err)
+	}
+	logCtx := s.logGrpcEvent().WithFields(logrus.Fields{
+		"module": "QueueProcessor",
+		"client": agentName,
+	})
+	switch {
+	case report.Error != "":
+		logCtx.Warnf("Agent could not check its permissions: %s", report.Error)
+	case len(report.Missing) > 0:
+		logCtx.WithField("missing", report.Missing).Warn("Agent lacks permissions needed for its enabled features")
+	default:
+		logCtx.Debug("Agent reported its permissions")
+	}
+	s.activity.recordPermissions(agentName, report)
+	s.triggerAgentStatusUpdate()
+	return nil
+}
+
+// processResourceFilterReport records the resource filter the agent
+// applies to the Applications it sends, for reporting in its AgentStatus.
+func (s *Server) processResourceFilterReport(agentName string, ev *cloudevents.Event) error {
+	report, err := event.New(ev, event.TargetResourceFilter).ResourceFilterReport()
+	if err != nil {
+		return fmt.Errorf("invalid resource filter report: %w", err)
+	}
+	s.logGrpcEvent().WithFields(logrus.Fields{
+		"module":     "QueueProcessor",
+		"client":     agentName,
+		"inclusions": len(report.Inclusions),
+		"exclusions": len(report.Exclusions),
+	}).Info("Agent filters the resource statuses of its applications")
+	s.activity.recordResourceFilter(agentName, report)
+	s.triggerAgentStatusUpdate()
+	return nil
+}
+
+// agentStatus returns the current status of the given agent
+func (s *Server) agentStatus(agentName string, now time.Time) v1alpha1.AgentStatusStatus {
+	streams, heartbeat, disconnectedAt := s.activity.get(agentName)
+	st := v1alpha1.AgentStatusStatus{
+		ConnectionState:    v1alpha1.ConnectionStateDisconnected,
+		LastDisconnectedAt: optionalTime(disconnectedAt),
+		LastHeartbeat:      optionalTime(heartbeat),
+		Streams:            streams,
+		LastUpdated:        metav1.Time{Time: now},
+	}
+	if mode := s.agentMode(agentName); mode != types.AgentModeUnknown {
+		st.Mode = mode.String()
+	}
+	st.Maintenance = s.inMaintenance(agentName)
+	if cfg := s.activity.config(agentName); cfg.generation > 0 {
+		st.ConfigGeneration = cfg.generation
+		st.ConfigAppliedAt = optionalTime(cfg.appliedAt)
+	}
+	st.Permissions = s.activity.permissionStatus(agentName)
+	st.ResourceFilter = s.activity.resourceFilterStatus(agentName)
+	st.ClientCertificate = s.activity.certificate(certListenerGRPC, agentName)
+	st.ConnectionHistory = s.connections.get(agentName, now)
+	if s.eventStreamSrv != nil {
+		if since, ok := s.eventStreamSrv.AgentConnectedSince(agentName); ok {
+			st.ConnectionState = v1alpha1.ConnectionStateConnected
+			st.ConnectedSince = optionalTime(since)
+			// Agents whose tokens predate version reporting were admitted
+			// only if they had the principal's version
+			st.Version = s.version.Version()
+			if v, ok := s.agentVersion(agentName); ok {
+				st.Version = v
+			}
+			st.VersionSkew = s.versionSkewStatus(st.Version)
+		}
+		if v, ok := s.eventStreamSrv.AgentSchemaVersion(agentName); ok {
+			st.EventSchemaVersion = int(v)
+			st.Capabilities = event.Capabilities(v)
+		}
+	}
+	if q, ok := s.ConnectionQuality(agentName); ok {
+		st.RoundTripTime = q.RTT.String()
+		st.ClockSkew = q.ClockSkew.String()
+	}
+	if sq := s.queues.SendQ(agentName); sq != nil {
+		st.Queues.Send = sq.Len()
+	}
+	if rq := s.queues.RecvQ(agentName); rq != nil {
+		st.Queues.Receive = rq.Len()
+	}
+	return st
+}
+
+// knownAgents returns the names of all agents that have connected since the
+// principal started.
+func (s *Server) knownAgents() []string {
+	known := make(map[string]bool)
+	for _, name := range s.queues.Names() {
+		known[name] = true
+	}
+	if s.eventStreamSrv != nil {
+		for _, name := range s.eventStreamSrv.ConnectedAgents() {
+			known[name] = true
+		}
+	}
+	agents := make([]string, 0, len(known))
+	for name := range known {
+		agents = append(agents, name)
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// runAgentStatusReconciler keeps the AgentStatus resources of all agents up
+// to date, both periodically and whenever an agent connects or disconnects.
+func (s *Server) runAgentStatusReconciler(ctx context.Context) {
+	ticker := time.NewTicker(s.options.agentStatusInterval)
+	defer ticker.Stop()
+	for {
+		s.reconcileAgentStatuses(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.agentStatusChanged:
+		}
+	}
+}
+
+// triggerAgentStatusUpdate requests an update of the AgentStatus resources
+func (s *Server) triggerAgentStatusUpdate() {
+	if s.agentStatusChanged == nil {
+		return
+	}
+	select {
+	case s.agentStatusChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) reconcileAgentStatuses(ctx context.Context, now time.Time) {
+	for _, agentName := range s.knownAgents() {
+		if err := s.updateAgentStatus(ctx, agentName, s.agentStatus(agentName, now)); err != nil {
+			log().WithError(err).WithField("agent", agentName).Warn("Could not update AgentStatus")
+		}
+	}
+}
+
+// updateAgentStatus writes the status of the given agent, creating its
+// AgentStatus resource if it doesn't exist yet.
+func (s *Server) updateAgentStatus(ctx context.Context, agentName string, status v1alpha1.AgentStatusStatus) error {
+	client := s.kubeClient.DynamicClient.Resource(v1alpha1.AgentStatusResource).Namespace(s.namespace)
+	existing, err := client.Get(ctx, agentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		obj, cerr := v1alpha1.NewAgentStatus(s.namespace, agentName).ToUnstructured()
+		if cerr != nil {
+			return cerr
+		}
+		existing, err = client.Create(ctx, obj, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	as, err := v1alpha1.AgentStatusFromUnstructured(existing)
+	if err != nil {
+		return err
+	}
+	// Carry over the connection history of a previous principal instance
+	if s.connections.restore(agentName, as.Status.ConnectionHistory, status.LastUpdated.Time) {
+		status.ConnectionHistory = s.connections.get(agentName, status.LastUpdated.Time)
+	}
+	as.Status = status
+	obj, err := as.ToUnstructured()
+	if err != nil {
+		return err
+	}
+	if _, err := client.UpdateStatus(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update status: %w", err)
+	}
+	return nil
+}
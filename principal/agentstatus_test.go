@@ -0,0 +1,124 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+)
+
+func Test_AgentActivity(t *testing.T) {
+	a := newAgentActivity()
+	endLogs := a.beginStream("agent", streamLogs)
+	endTerm := a.beginStream("agent", streamTerminal)
+	a.beginStream("agent", streamLogs)
+	streams, _, _ := a.get("agent")
+	assert.Equal(t, v1alpha1.StreamStatus{Logs: 2, Terminals: 1}, streams)
+	endLogs()
+	endTerm()
+	streams, _, _ = a.get("agent")
+	assert.Equal(t, v1alpha1.StreamStatus{Logs: 1}, streams)
+
+	// A nil activity tracks nothing
+	var nilActivity *agentActivity
+	nilActivity.beginStream("agent", streamLogs)()
+	nilActivity.recordHeartbeat("agent", time.Now())
+	streams, hb, _ := nilActivity.get("agent")
+	assert.Zero(t, streams)
+	assert.True(t, hb.IsZero())
+}
+
+func Test_UpdateAgentStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := NewServer(ctx, kube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithAgentStatusInterval(time.Minute))
+	require.NoError(t, err)
+	s.kubeClient.DynamicClient = dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.AgentStatusResource: "AgentStatusList"})
+	require.NotNil(t, s.agentStatusChanged)
+
+	require.NoError(t, s.queues.Create("agent-1"))
+	s.setAgentMode("agent-1", types.AgentModeManaged)
+	s.events = event.NewEventSource("test")
+	s.queues.SendQ("agent-1").Add(s.events.HeartbeatEvent(event.Ping))
+	heartbeat := time.Now().Add(-time.Second).Truncate(time.Second)
+	s.activity.recordHeartbeat("agent-1", heartbeat)
+	defer s.activity.beginStream("agent-1", streamTerminal)()
+	report := &event.AgentConfigReport{Generation: 3, Applied: map[string]string{"agent.log.level": "debug"}}
+	require.NoError(t, s.processAgentConfigEvent("agent-1", event.NewEventSource("agent").AgentConfigReportEvent(report)))
+	permissions := &event.PermissionReport{Features: []string{"core", "logs"}, Missing: []string{"get pods/log cluster-wide"}, CheckedAt: time.Now()}
+	require.NoError(t, s.processPermissionReport("agent-1", event.NewEventSource("agent").PermissionReportEvent(permissions)))
+	filter := &event.ResourceFilterReport{Exclusions: []event.ResourceFilterRule{{APIGroups: []string{"*.crossplane.io"}, Kinds: []string{"*"}}}, AppliedAt: time.Now()}
+	require.NoError(t, s.processResourceFilterReport("agent-1", event.NewEventSource("agent").ResourceFilterReportEvent(filter)))
+	s.auditClientCertificate(certListenerGRPC, "agent-1", []*x509.Certificate{{Raw: []byte("cert"), Subject: pkix.Name{CommonName: "agent-1"}, SerialNumber: big.NewInt(7)}})
+
+	get := func() *v1alpha1.AgentStatus {
+		u, err := s.kubeClient.DynamicClient.Resource(v1alpha1.AgentStatusResource).Namespace("argocd").Get(ctx, "agent-1", metav1.GetOptions{})
+		require.NoError(t, err)
+		as, err := v1alpha1.AgentStatusFromUnstructured(u)
+		require.NoError(t, err)
+		return as
+	}
+
+	// First reconciliation creates the resource
+	s.reconcileAgentStatuses(ctx, time.Now())
+	as := get()
+	assert.Equal(t, v1alpha1.ConnectionStateDisconnected, as.Status.ConnectionState)
+	assert.Equal(t, "managed", as.Status.Mode)
+	assert.Equal(t, 1, as.Status.Queues.Send)
+	assert.Equal(t, 1, as.Status.Streams.Terminals)
+	require.NotNil(t, as.Status.LastHeartbeat)
+	assert.True(t, heartbeat.Equal(as.Status.LastHeartbeat.Time))
+	assert.Nil(t, as.Status.LastDisconnectedAt)
+	assert.Equal(t, int64(3), as.Status.ConfigGeneration)
+	assert.NotNil(t, as.Status.ConfigAppliedAt)
+	require.NotNil(t, as.Status.Permissions)
+	assert.Equal(t, []string{"get pods/log cluster-wide"}, as.Status.Permissions.Missing)
+	assert.Equal(t, []string{"core", "logs"}, as.Status.Permissions.Features)
+	require.NotNil(t, as.Status.ResourceFilter)
+	require.Len(t, as.Status.ResourceFilter.Exclusions, 1)
+	assert.Equal(t, []string{"*.crossplane.io"}, as.Status.ResourceFilter.Exclusions[0].APIGroups)
+	assert.Empty(t, as.Status.ResourceFilter.Inclusions)
+	require.NotNil(t, as.Status.ClientCertificate)
+	assert.Equal(t, "agent-1", as.Status.ClientCertificate.Identity)
+	assert.Equal(t, "7", as.Status.ClientCertificate.SerialNumber)
+
+	// Subsequent ones update it
+	s.onAgentDisconnected("agent-1")
+	s.reconcileAgentStatuses(ctx, time.Now())
+	as = get()
+	assert.NotNil(t, as.Status.LastDisconnectedAt)
+	select {
+	case <-s.agentStatusChanged:
+	default:
+		t.Fatal("disconnect did not trigger an update")
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// agentUpdatePollInterval is the interval in which Update operations check
+// whether the updated agent has reconnected
+const agentUpdatePollInterval = time.Second
+
+// weekdays maps the abbreviated weekdays of maintenance windows
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// agentUpdates holds the Update operations waiting for the agent's
+// acknowledgement, by request UUID.
+type agentUpdates struct {
+	mu      sync.Mutex
+	pending map[string]chan *event.AgentUpdateResult
+}
+
+func (u *agentUpdates) register(id string) chan *event.AgentUpdateResult {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == nil {
+		u.pending = make(map[string]chan *event.AgentUpdateResult)
+	}
+	ch := make(chan *event.AgentUpdateResult, 1)
+	u.pending[id] = ch
+	return ch
+}
+
+func (u *agentUpdates) remove(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.pending, id)
+}
+
+// deliver passes res to the operation waiting for it. Returns false if no
+// operation is waiting.
+func (u *agentUpdates) deliver(res *event.AgentUpdateResult) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ch, ok := u.pending[res.UUID]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- res:
+	default:
+	}
+	return true
+}
+
+// validateUpdateOperation checks the parameters of an Update operation
+func validateUpdateOperation(spec *v1alpha1.UpdateOperation) error {
+	if spec == nil || spec.Tag == "" {
+		return errors.New("update operations require an image tag")
+	}
+	if spec.Payload == "" || spec.Signature == "" {
+		return errors.New("update operations require a signed update payload")
+	}
+	data, err := base64.StdEncoding.DecodeString(spec.Payload)
+	if err != nil {
+		return fmt.Errorf("update payload is not base64 encoded: %w", err)
+	}
+	payload := &event.AgentUpdatePayload{}
+	if err := json.Unmarshal(data, payload); err != nil {
+		return fmt.Errorf("could not decode update payload: %w", err)
+	}
+	if payload.Tag != spec.Tag {
+		return fmt.Errorf("update payload is for tag %q, not %q", payload.Tag, spec.Tag)
+	}
+	if !time.Now().Before(payload.Expires) {
+		return errors.New("update payload has expired")
+	}
+	if spec.MaintenanceWindow != nil {
+		if _, _, err := maintenanceWindowState(spec.MaintenanceWindow, time.Now()); err != nil {
+			return fmt.Errorf("invalid maintenance window: %w", err)
+		}
+	}
+	return nil
+}
+
+// maintenanceWindowState returns whether w is open at now and, if it isn't,
+// when it opens next.
+func maintenanceWindowState(w *v1alpha1.MaintenanceWindow, now time.Time) (bool, time.Time, error) {
+	loc := time.UTC
+	if w.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(w.TimeZone); err != nil {
+			return false, time.Time{}, fmt.Errorf("unknown time zone %q", w.TimeZone)
+		}
+	}
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("start %q is not a time of day in HH:MM format", w.Start)
+	}
+	if w.Duration.Duration <= 0 {
+		return false, time.Time{}, errors.New("duration must be positive")
+	}
+	days := map[time.Weekday]bool{}
+	for _, d := range w.Days {
+		wd, ok := weekdays[d]
+		if !ok {
+			return false, time.Time{}, fmt.Errorf("unknown weekday %q", d)
+		}
+		days[wd] = true
+	}
+
+	// Windows may span midnight, or even several days, so look at the
+	// windows that opened in the past week as well as the upcoming ones.
+	t := now.In(loc)
+	var next time.Time
+	for off := -7; off <= 7; off++ {
+		opens := time.Date(t.Year(), t.Month(), t.Day()+off, start.Hour(), start.Minute(), 0, 0, loc)
+		if len(days) > 0 && !days[opens.Weekday()] {
+			continue
+		}
+		if !opens.After(t) && t.Before(opens.Add(w.Duration.Duration)) {
+			return true, time.Time{}, nil
+		}
+		if opens.After(t) && (next.IsZero() || opens.Before(next)) {
+			next = opens
+		}
+	}
+	return false, next, nil
+}
+
+// waitForMaintenanceWindow blocks until w is open, and reports the wait in
+// the status of the operation on the i-th agent.
+func (s *Server) waitForMaintenanceWindow(ctx context.Context, run *operationRun, i int, w *v1alpha1.MaintenanceWindow) error {
+	for {
+		open, next, err := maintenanceWindowState(w, time.Now())
+		if err != nil {
+			return err
+		}
+		if open {
+			return nil
+		}
+		s.setAgentOperationPhase(ctx, run, i, v1alpha1.AgentOperationPending,
+			fmt.Sprintf("waiting for the maintenance window opening at %s", next.Format(time.RFC3339)), "")
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// updateAgent asks the agent to update the image tag of its Deployment, and
+// waits until the updated agent has reconnected.
+func (s *Server) updateAgent(ctx context.Context, op *v1alpha1.AgentOperation, agentName string) (string, error) {
+	if v, _ := s.eventStreamSrv.AgentSchemaVersion(agentName); v < event.SchemaVersion9 {
+		return "", errors.New("agent does not support self-update")
+	}
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return "", errors.New("no send queue for agent")
+	}
+	spec := op.Spec.Update
+	req := &event.AgentUpdateRequest{
+		UUID:      uuid.NewString(),
+		Tag:       spec.Tag,
+		Payload:   spec.Payload,
+		Signature: spec.Signature,
+		Operation: op.Name,
+	}
+	ev, err := s.events.NewAgentUpdateRequestEvent(req)
+	if err != nil {
+		return "", err
+	}
+	event.SetTTL(ev, requestTimeout)
+	ch := s.agentUpdates.register(req.UUID)
+	defer s.agentUpdates.remove(req.UUID)
+	sentAt := time.Now()
+	q.Add(ev)
+
+	var res *event.AgentUpdateResult
+	err = wait.PollUntilContextCancel(ctx, agentUpdatePollInterval, false, func(context.Context) (bool, error) {
+		if res == nil {
+			select {
+			case res = <-ch:
+			default:
+			}
+		}
+		if res != nil {
+			if res.Error != "" {
+				return false, fmt.Errorf("agent could not update: %s", res.Error)
+			}
+			if !res.Restarting {
+				return true, nil
+			}
+		}
+		since, connected := s.eventStreamSrv.AgentConnectedSince(agentName)
+		if !connected || !since.After(sentAt) {
+			return false, nil
+		}
+		// The old agent may be gone before its acknowledgement was
+		// delivered. A new connection without one only counts if the agent
+		// reports the expected version.
+		if res == nil {
+			v, _ := s.agentVersion(agentName)
+			return spec.Version != "" && v == spec.Version, nil
+		}
+		return true, nil
+	})
+	if wait.Interrupted(err) {
+		if res == nil {
+			return "", errors.New("timeout waiting for the agent to acknowledge the update")
+		}
+		return "", errors.New("timeout waiting for the updated agent to reconnect")
+	}
+	if err != nil {
+		return "", err
+	}
+	if spec.Version != "" {
+		if v, _ := s.agentVersion(agentName); v != spec.Version {
+			return "", fmt.Errorf("agent runs version %s after the update, expected %s", v, spec.Version)
+		}
+	}
+	switch {
+	case res == nil:
+		return fmt.Sprintf("updated to tag %s", spec.Tag), nil
+	case !res.Restarting:
+		return fmt.Sprintf("already running %s", res.Image), nil
+	}
+	return fmt.Sprintf("updated from %s to %s", res.PreviousImage, res.Image), nil
+}
+
+// processAgentUpdateEvent processes the acknowledgement of an update request
+func (s *Server) processAgentUpdateEvent(agentName string, ev *cloudevents.Event) error {
+	e := event.New(ev, event.TargetAgentUpdate)
+	if e.Type() != event.AgentUpdateAck {
+		return fmt.Errorf("unexpected agent update event type %s", e.Type())
+	}
+	res, err := e.AgentUpdateResult()
+	if err != nil {
+		return fmt.Errorf("invalid agent update result: %w", err)
+	}
+	logCtx := log().WithField("agent", agentName)
+	if !s.agentUpdates.deliver(res) {
+		logCtx.Debugf("Ignoring acknowledgement of unknown update request %s", res.UUID)
+		return nil
+	}
+	if res.Error != "" {
+		logCtx.Warnf("Agent could not update: %s", res.Error)
+	} else if res.Restarting {
+		logCtx.Infof("Agent updated its Deployment from %s to %s", res.PreviousImage, res.Image)
+	}
+	return nil
+}
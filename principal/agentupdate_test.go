@@ -0,0 +1,197 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_MaintenanceWindowState(t *testing.T) {
+	// Saturday
+	sat := time.Date(2025, time.March, 15, 3, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name   string
+		window v1alpha1.MaintenanceWindow
+		now    time.Time
+		open   bool
+		next   time.Time
+	}{
+		{
+			name:   "Open every day",
+			window: v1alpha1.MaintenanceWindow{Start: "02:00", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+			now:    sat,
+			open:   true,
+		},
+		{
+			name:   "Closed until the next day",
+			window: v1alpha1.MaintenanceWindow{Start: "02:00", Duration: metav1.Duration{Duration: time.Hour}},
+			now:    sat,
+			next:   time.Date(2025, time.March, 16, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "Spans midnight",
+			window: v1alpha1.MaintenanceWindow{Days: []string{"Fri"}, Start: "22:00", Duration: metav1.Duration{Duration: 6 * time.Hour}},
+			now:    sat,
+			open:   true,
+		},
+		{
+			name:   "Closed until the next allowed day",
+			window: v1alpha1.MaintenanceWindow{Days: []string{"Mon", "Tue"}, Start: "01:00", Duration: metav1.Duration{Duration: time.Hour}},
+			now:    sat,
+			next:   time.Date(2025, time.March, 17, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "Time zone",
+			window: v1alpha1.MaintenanceWindow{Start: "04:00", Duration: metav1.Duration{Duration: time.Hour}, TimeZone: "Europe/Berlin"},
+			now:    sat,
+			open:   true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next, err := maintenanceWindowState(&tt.window, tt.now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.open, open)
+			assert.True(t, tt.next.Equal(next), "expected %s, got %s", tt.next, next)
+		})
+	}
+
+	t.Run("Invalid windows", func(t *testing.T) {
+		for _, w := range []v1alpha1.MaintenanceWindow{
+			{Start: "2am", Duration: metav1.Duration{Duration: time.Hour}},
+			{Start: "02:00"},
+			{Start: "02:00", Duration: metav1.Duration{Duration: time.Hour}, Days: []string{"Someday"}},
+			{Start: "02:00", Duration: metav1.Duration{Duration: time.Hour}, TimeZone: "Nowhere/Nothing"},
+		} {
+			_, _, err := maintenanceWindowState(&w, sat)
+			assert.Error(t, err)
+		}
+	})
+}
+
+// updatePayload returns a base64 encoded update payload for tag expiring at
+// expires
+func updatePayload(t *testing.T, tag string, expires time.Time) string {
+	t.Helper()
+	data, err := json.Marshal(&event.AgentUpdatePayload{Repository: "agent", Tag: tag, Scopes: []string{"agent"}, Expires: expires})
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func Test_ValidateUpdateOperation(t *testing.T) {
+	payload := updatePayload(t, "v0.6.0", time.Now().Add(time.Hour))
+	assert.Error(t, validateUpdateOperation(nil))
+	assert.Error(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.6.0"}))
+	assert.Error(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.6.0", Signature: "c2ln"}))
+	assert.NoError(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.6.0", Payload: payload, Signature: "c2ln"}))
+	assert.ErrorContains(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.7.0", Payload: payload, Signature: "c2ln"}), "not \"v0.7.0\"")
+	assert.ErrorContains(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.6.0", Payload: "!!", Signature: "c2ln"}), "base64")
+	assert.ErrorContains(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.6.0", Payload: updatePayload(t, "v0.6.0", time.Now().Add(-time.Hour)), Signature: "c2ln"}), "expired")
+	assert.Error(t, validateUpdateOperation(&v1alpha1.UpdateOperation{Tag: "v0.6.0", Payload: payload, Signature: "c2ln",
+		MaintenanceWindow: &v1alpha1.MaintenanceWindow{Start: "25:00", Duration: metav1.Duration{Duration: time.Hour}}}))
+}
+
+func Test_UpdateAgent(t *testing.T) {
+	op := &v1alpha1.AgentOperation{
+		ObjectMeta: metav1.ObjectMeta{Name: "update"},
+		Spec: v1alpha1.AgentOperationSpec{
+			Type:   v1alpha1.AgentOperationUpdate,
+			Update: &v1alpha1.UpdateOperation{Tag: "v0.6.0", Payload: "cGF5bG9hZA==", Signature: "c2ln", Version: "0.6.0"},
+		},
+	}
+	agentEvents := event.NewEventSource("agent")
+
+	// request returns the update request sent to the agent
+	request := func(t *testing.T, s *Server) *event.AgentUpdateRequest {
+		t.Helper()
+		ev, shutdown := s.queues.SendQ("agent").Get()
+		require.False(t, shutdown)
+		s.queues.SendQ("agent").Done(ev)
+		req, err := event.New(ev, event.TargetAgentUpdate).AgentUpdateRequest()
+		require.NoError(t, err)
+		return req
+	}
+	run := func(s *Server) chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := s.updateAgent(ctx, op, "agent")
+			errCh <- err
+		}()
+		return errCh
+	}
+
+	t.Run("Succeeds once the updated agent reconnected", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		errCh := run(s)
+		req := request(t, s)
+		assert.Equal(t, "v0.6.0", req.Tag)
+		assert.Equal(t, "cGF5bG9hZA==", req.Payload)
+		assert.Equal(t, "c2ln", req.Signature)
+		assert.Equal(t, "update", req.Operation)
+
+		ack, err := agentEvents.AgentUpdateAckEvent(&event.AgentUpdateResult{UUID: req.UUID, PreviousImage: "agent:v0.5.0", Image: "agent:v0.6.0", Restarting: true})
+		require.NoError(t, err)
+		require.NoError(t, s.processAgentUpdateEvent("agent", ack))
+		s.recordAgentVersion("agent", "0.6.0")
+		s.eventStreamSrv.MarkConnected("agent")
+		require.NoError(t, <-errCh)
+	})
+
+	t.Run("Fails if the agent reconnects with another version", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		errCh := run(s)
+		req := request(t, s)
+		ack, err := agentEvents.AgentUpdateAckEvent(&event.AgentUpdateResult{UUID: req.UUID, Image: "agent:v0.6.0", Restarting: true})
+		require.NoError(t, err)
+		require.NoError(t, s.processAgentUpdateEvent("agent", ack))
+		s.recordAgentVersion("agent", "0.5.0")
+		s.eventStreamSrv.MarkConnected("agent")
+		assert.ErrorContains(t, <-errCh, "expected 0.6.0")
+	})
+
+	t.Run("Fails if the agent refuses the update", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		errCh := run(s)
+		req := request(t, s)
+		ack, err := agentEvents.AgentUpdateAckEvent(&event.AgentUpdateResult{UUID: req.UUID, Error: "invalid signature of image tag"})
+		require.NoError(t, err)
+		require.NoError(t, s.processAgentUpdateEvent("agent", ack))
+		assert.ErrorContains(t, <-errCh, "invalid signature")
+	})
+
+	t.Run("Acknowledgements of unknown requests are ignored", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		ack, err := agentEvents.AgentUpdateAckEvent(&event.AgentUpdateResult{UUID: "unknown"})
+		require.NoError(t, err)
+		assert.NoError(t, s.processAgentUpdateEvent("agent", ack))
+	})
+}
@@ -16,20 +16,26 @@ package auth
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/jointoken"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
 	"github.com/argoproj-labs/argocd-agent/internal/issuer"
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
 	"github.com/argoproj-labs/argocd-agent/internal/queue"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
 	"github.com/argoproj-labs/argocd-agent/internal/version"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/authapi"
 	"github.com/argoproj-labs/argocd-agent/principal/registration"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
 )
 
 type Server struct {
@@ -59,6 +65,15 @@ var errAuthenticationFailed = status.Error(codes.Unauthenticated, authFailedMess
 
 type ServerOptions struct {
 	agentRegistrationManager *registration.AgentRegistrationManager
+
+	// joinKube and joinNamespace are used to validate join tokens, if set
+	joinKube      kubernetes.Interface
+	joinNamespace string
+
+	// skewPolicy decides which agent versions are admitted
+	skewPolicy version.SkewPolicy
+	// metrics counts the agents refused because of their version, if set
+	metrics *metrics.PrincipalMetrics
 }
 
 type ServerOption func(o *ServerOptions) error
@@ -67,7 +82,7 @@ type ServerOption func(o *ServerOptions) error
 // authentication methods and options.
 func NewServer(queues *queue.SendRecvQueues, authMethods *auth.Methods, iss issuer.Issuer, opts ...ServerOption) (*Server, error) {
 	s := &Server{}
-	s.options = &ServerOptions{}
+	s.options = &ServerOptions{skewPolicy: version.DefaultSkewPolicy()}
 	if authMethods != nil {
 		s.authMethods = authMethods
 	} else {
@@ -112,8 +127,9 @@ func (s *Server) issueTokens(subject *auth.AuthSubject, refresh bool) (accessTok
 // request succeeds, a JWT will be issued to the client.
 //
 // This method also performs version handshake validation. The agent must send
-// its version number, and if it doesn't match the principal's version exactly,
-// the authentication will be rejected.
+// its version number, and if its skew from the principal's version is not
+// admitted by the configured skew policy, the authentication will be
+// rejected.
 func (s *Server) Authenticate(ctx context.Context, ar *authapi.AuthRequest) (*authapi.AuthResponse, error) {
 	logCtx := log().WithField("method", "Authenticate").WithField("authmethod", ar.Method)
 
@@ -135,15 +151,8 @@ func (s *Server) Authenticate(ctx context.Context, ar *authapi.AuthRequest) (*au
 	}
 
 	agentVersion := ar.Version
-
-	if agentVersion == "" {
-		logCtx.Warn("Agent did not provide version information")
-		return nil, status.Error(codes.InvalidArgument, "agent version is required")
-	}
-
-	if agentVersion != s.principalVersion {
-		logCtx.Warnf("Version mismatch: rejecting connection (agent: %s, principal: %s)", agentVersion, s.principalVersion)
-		return nil, status.Errorf(codes.FailedPrecondition, "version mismatch")
+	if err := s.checkVersion(logCtx.WithField("client", clientID), agentVersion); err != nil {
+		return nil, err
 	}
 
 	logCtx.WithField("client", clientID).WithField("agent_version", agentVersion).Info("client authentication successful")
@@ -156,7 +165,7 @@ func (s *Server) Authenticate(ctx context.Context, ar *authapi.AuthRequest) (*au
 		}
 	}
 
-	subject := &auth.AuthSubject{ClientID: clientID, Mode: ar.Mode}
+	subject := &auth.AuthSubject{ClientID: clientID, Mode: ar.Mode, Version: agentVersion}
 	accessToken, refreshToken, err := s.issueTokens(subject, true)
 	if err != nil {
 		logCtx.WithError(err).Warnf("Unable to generate token")
@@ -175,6 +184,68 @@ func (s *Server) Authenticate(ctx context.Context, ar *authapi.AuthRequest) (*au
 	}, nil
 }
 
+// Join issues a client certificate to an agent that presents a valid join
+// token. The certificate is issued for the public key of the request's CSR,
+// so the agent's private key never leaves the agent. Join tokens can be used
+// only once.
+func (s *Server) Join(ctx context.Context, r *authapi.JoinRequest) (*authapi.JoinResponse, error) {
+	logCtx := log().WithField("method", "Join")
+	if s.options.joinKube == nil {
+		return nil, status.Error(codes.Unimplemented, "joining is not enabled on this principal")
+	}
+
+	switch r.Mode {
+	case "managed", "autonomous":
+		break
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown or missing operation mode: '%s'", r.Mode)
+	}
+	if err := s.checkVersion(logCtx, r.Version); err != nil {
+		return nil, err
+	}
+
+	// Validate the request before using up the token
+	if _, err := tlsutil.ParseCertificateRequest([]byte(r.Csr)); err != nil {
+		logCtx.WithError(err).Info("Invalid certificate request")
+		return nil, status.Error(codes.InvalidArgument, "invalid certificate request")
+	}
+
+	agentName, err := jointoken.Consume(ctx, s.options.joinKube, s.options.joinNamespace, r.Token)
+	if err != nil {
+		logCtx.WithError(err).Info("Join token rejected")
+		return nil, errAuthenticationFailed
+	}
+	logCtx = logCtx.WithField("client", agentName)
+
+	ca, err := tlsutil.TLSCertFromSecret(ctx, s.options.joinKube, s.options.joinNamespace, config.SecretNamePrincipalCA)
+	if err != nil {
+		logCtx.WithError(err).Error("Could not read CA")
+		return nil, status.Error(codes.Internal, "unable to issue a certificate")
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		logCtx.WithError(err).Error("Could not parse CA certificate")
+		return nil, status.Error(codes.Internal, "unable to issue a certificate")
+	}
+	cert, err := tlsutil.SignClientCertificateRequest([]byte(r.Csr), agentName, caCert, ca.PrivateKey)
+	if err != nil {
+		logCtx.WithError(err).Error("Could not issue certificate")
+		return nil, status.Error(codes.Internal, "unable to issue a certificate")
+	}
+	caPEM, err := tlsutil.CertDataToPEM(ca.Certificate[0])
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to issue a certificate")
+	}
+
+	logCtx.Info("Issued client certificate to joining agent")
+	return &authapi.JoinResponse{
+		AgentName:     agentName,
+		Certificate:   cert,
+		CaCertificate: caPEM,
+		Version:       s.principalVersion,
+	}, nil
+}
+
 // RefreshToken issues a new access token when the client presents a valid
 // refresh token. If the refresh token is only valid for 10 minutes or less,
 // a new refresh token will be issued as well.
@@ -222,6 +293,29 @@ func (s *Server) RefreshToken(ctx context.Context, r *authapi.RefreshTokenReques
 	return &authapi.AuthResponse{AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
 
+// checkVersion returns an error if the skew of agentVersion from the
+// principal's version is not admitted by the skew policy. Agents that are
+// admitted despite being outside the policy are logged.
+func (s *Server) checkVersion(logCtx *logrus.Entry, agentVersion string) error {
+	if agentVersion == "" {
+		logCtx.Warn("Agent did not provide version information")
+		return status.Error(codes.InvalidArgument, "agent version is required")
+	}
+	skew := s.options.skewPolicy.Evaluate(agentVersion, s.principalVersion)
+	if !skew.Admitted {
+		desc := skew.Describe(agentVersion, s.principalVersion)
+		logCtx.WithField("policy", s.options.skewPolicy.Mode).Warnf("Version mismatch: rejecting agent (%s)", desc)
+		if s.options.metrics != nil {
+			s.options.metrics.AgentVersionRefusals.Inc()
+		}
+		return status.Errorf(codes.FailedPrecondition, "version mismatch: %s", desc)
+	}
+	if !skew.WithinPolicy {
+		logCtx.WithField("policy", s.options.skewPolicy.Mode).Warnf("Admitting agent outside of the version skew policy: %s", skew.Describe(agentVersion, s.principalVersion))
+	}
+	return nil
+}
+
 func log() *logrus.Entry {
 	return logging.GetDefaultLogger().ModuleLogger("grpc.AuthenticationServer")
 }
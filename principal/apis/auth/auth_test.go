@@ -16,29 +16,40 @@ package auth
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/jointoken"
 	authmock "github.com/argoproj-labs/argocd-agent/internal/auth/mocks"
 	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
 	issuermock "github.com/argoproj-labs/argocd-agent/internal/issuer/mocks"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
 	"github.com/argoproj-labs/argocd-agent/internal/queue"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
 	"github.com/argoproj-labs/argocd-agent/internal/version"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/authapi"
 	"github.com/argoproj-labs/argocd-agent/principal/registration"
 	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func Test_Authenticate(t *testing.T) {
-	encodedSubject := `{"clientID":"user1","mode":"managed"}`
 	queues := queue.NewSendRecvQueues()
 	testVersion := version.New("argocd-agent").Version()
+	encodedSubject := fmt.Sprintf(`{"clientID":"user1","mode":"managed","version":%q}`, testVersion)
 
 	t.Run("Authentication method unsupported", func(t *testing.T) {
 		auths, err := NewServer(queues, nil, nil)
@@ -88,6 +99,64 @@ func Test_Authenticate(t *testing.T) {
 		assert.ErrorContains(t, err, "version mismatch")
 	})
 
+	t.Run("Version skew policy", func(t *testing.T) {
+		for _, tt := range []struct {
+			name         string
+			mode         version.SkewMode
+			agentVersion string
+			admitted     bool
+		}{
+			{"Exact refuses older minor", version.SkewModeExact, "v0.4.0", false},
+			{"Refuse admits allowed skew", version.SkewModeRefuse, "v0.4.2", true},
+			{"Refuse refuses excess skew", version.SkewModeRefuse, "v0.3.0", false},
+			{"Refuse refuses newer agent", version.SkewModeRefuse, "v0.6.0", false},
+			{"Warn admits excess skew", version.SkewModeWarn, "v0.3.0", true},
+			{"Warn admits unparseable version", version.SkewModeWarn, "devel", true},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				ams := auth.NewMethods()
+				am := authmock.NewMethod(t)
+				am.On("Authenticate", mock.Anything, mock.Anything).Return("user1", nil)
+				ams.RegisterMethod("userpass", am)
+
+				iss := issuermock.NewIssuer(t)
+				m := metrics.NewPrincipalMetricsWith(prometheus.NewRegistry())
+				auths, err := NewServer(queues, ams, iss,
+					WithVersionSkewPolicy(version.SkewPolicy{Mode: tt.mode, MaxMinorSkew: 1}),
+					WithMetrics(m))
+				require.NoError(t, err)
+				auths.principalVersion = "v0.5.1"
+				if tt.admitted {
+					subject := fmt.Sprintf(`{"clientID":"user1","mode":"managed","version":%q}`, tt.agentVersion)
+					iss.On("IssueAccessToken", subject, mock.Anything).Return("access", nil)
+					iss.On("IssueRefreshToken", subject, mock.Anything).Return("refresh", nil)
+				}
+				r, err := auths.Authenticate(context.TODO(), &authapi.AuthRequest{
+					Method:      "userpass",
+					Credentials: map[string]string{userpass.ClientIDField: "user1", userpass.ClientSecretField: "password"},
+					Mode:        "managed",
+					Version:     tt.agentVersion,
+				})
+				if tt.admitted {
+					require.NoError(t, err)
+					assert.Equal(t, "v0.5.1", r.Version)
+					assert.Equal(t, float64(0), testutil.ToFloat64(m.AgentVersionRefusals))
+				} else {
+					assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+					assert.ErrorContains(t, err, "version mismatch")
+					assert.Equal(t, float64(1), testutil.ToFloat64(m.AgentVersionRefusals))
+				}
+			})
+		}
+	})
+
+	t.Run("Invalid version skew policy", func(t *testing.T) {
+		_, err := NewServer(queues, nil, nil, WithVersionSkewPolicy(version.SkewPolicy{Mode: "lenient"}))
+		assert.Error(t, err)
+		_, err = NewServer(queues, nil, nil, WithVersionSkewPolicy(version.SkewPolicy{Mode: version.SkewModeWarn, MaxMinorSkew: -1}))
+		assert.Error(t, err)
+	})
+
 	t.Run("Authentication successful", func(t *testing.T) {
 		ams := auth.NewMethods()
 		am := authmock.NewMethod(t)
@@ -363,3 +432,82 @@ func Test_RefreshToken(t *testing.T) {
 	})
 
 }
+
+func Test_Join(t *testing.T) {
+	ctx := context.Background()
+	ns := "argocd"
+	queues := queue.NewSendRecvQueues()
+	testVersion := version.New("argocd-agent").Version()
+
+	kubeclient := kube.NewFakeKubeClient(ns)
+	caCert, caKey, err := tlsutil.GenerateCaCertificate(config.SecretNamePrincipalCA)
+	require.NoError(t, err)
+	_, err = kubeclient.CoreV1().Secrets(ns).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.SecretNamePrincipalCA, Namespace: ns},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{"tls.crt": []byte(caCert), "tls.key": []byte(caKey)},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	csr, key, err := tlsutil.GenerateCertificateRequest("agent")
+	require.NoError(t, err)
+
+	t.Run("Join not enabled", func(t *testing.T) {
+		auths, err := NewServer(queues, nil, nil)
+		require.NoError(t, err)
+		_, err = auths.Join(ctx, &authapi.JoinRequest{Token: "abcdef.0123456789abcdef", Csr: csr, Mode: "managed", Version: testVersion})
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+	})
+
+	auths, err := NewServer(queues, nil, nil, WithJoinTokens(kubeclient, ns))
+	require.NoError(t, err)
+
+	t.Run("Successful join", func(t *testing.T) {
+		tok, err := jointoken.Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+		require.NoError(t, err)
+		resp, err := auths.Join(ctx, &authapi.JoinRequest{Token: tok.String(), Csr: csr, Mode: "managed", Version: testVersion})
+		require.NoError(t, err)
+		assert.Equal(t, "agent-a", resp.AgentName)
+		assert.Equal(t, testVersion, resp.Version)
+		assert.Equal(t, caCert, resp.CaCertificate)
+		cert, err := tls.X509KeyPair([]byte(resp.Certificate), []byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, "agent-a", cert.Leaf.Subject.CommonName)
+
+		// A token can only be used once
+		_, err = auths.Join(ctx, &authapi.JoinRequest{Token: tok.String(), Csr: csr, Mode: "managed", Version: testVersion})
+		assert.ErrorContains(t, err, authFailedMessage)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		_, err := auths.Join(ctx, &authapi.JoinRequest{Token: "abcdef.0123456789abcdef", Csr: csr, Mode: "managed", Version: testVersion})
+		assert.ErrorContains(t, err, authFailedMessage)
+	})
+
+	t.Run("Version mismatch", func(t *testing.T) {
+		tok, err := jointoken.Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+		require.NoError(t, err)
+		_, err = auths.Join(ctx, &authapi.JoinRequest{Token: tok.String(), Csr: csr, Mode: "managed", Version: "0.0.0"})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		// The token was not consumed
+		tokens, err := jointoken.List(ctx, kubeclient, ns)
+		require.NoError(t, err)
+		assert.Len(t, tokens, 1)
+		require.NoError(t, jointoken.Revoke(ctx, kubeclient, ns, tok.ID))
+	})
+
+	t.Run("Invalid CSR", func(t *testing.T) {
+		tok, err := jointoken.Create(ctx, kubeclient, ns, "agent-a", time.Hour)
+		require.NoError(t, err)
+		_, err = auths.Join(ctx, &authapi.JoinRequest{Token: tok.String(), Csr: "invalid", Mode: "managed", Version: testVersion})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		// The token was not consumed
+		_, err = auths.Join(ctx, &authapi.JoinRequest{Token: tok.String(), Csr: csr, Mode: "managed", Version: testVersion})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		_, err := auths.Join(ctx, &authapi.JoinRequest{Token: "abcdef.0123456789abcdef", Csr: csr, Mode: "", Version: testVersion})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -14,7 +14,14 @@
 
 package auth
 
-import "github.com/argoproj-labs/argocd-agent/principal/registration"
+import (
+	"fmt"
+
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/argoproj-labs/argocd-agent/internal/version"
+	"github.com/argoproj-labs/argocd-agent/principal/registration"
+	"k8s.io/client-go/kubernetes"
+)
 
 func WithAgentRegistrationManager(manager *registration.AgentRegistrationManager) ServerOption {
 	return func(o *ServerOptions) error {
@@ -22,3 +29,37 @@ func WithAgentRegistrationManager(manager *registration.AgentRegistrationManager
 		return nil
 	}
 }
+
+// WithJoinTokens enables the Join endpoint, which issues client certificates
+// to agents presenting a valid join token. Join tokens and the CA used to
+// issue certificates are read from namespace.
+func WithJoinTokens(kube kubernetes.Interface, namespace string) ServerOption {
+	return func(o *ServerOptions) error {
+		o.joinKube = kube
+		o.joinNamespace = namespace
+		return nil
+	}
+}
+
+// WithVersionSkewPolicy sets the policy deciding which agent versions are
+// admitted. By default, only agents of exactly the principal's version are.
+func WithVersionSkewPolicy(policy version.SkewPolicy) ServerOption {
+	return func(o *ServerOptions) error {
+		if _, err := version.ParseSkewMode(string(policy.Mode)); err != nil {
+			return err
+		}
+		if policy.MaxMinorSkew < 0 {
+			return fmt.Errorf("maximum minor version skew must not be negative")
+		}
+		o.skewPolicy = policy
+		return nil
+	}
+}
+
+// WithMetrics sets the metrics the server reports refused agents to
+func WithMetrics(m *metrics.PrincipalMetrics) ServerOption {
+	return func(o *ServerOptions) error {
+		o.metrics = m
+		return nil
+	}
+}
@@ -16,12 +16,15 @@ package eventstream
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"sync"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
 	"github.com/argoproj-labs/argocd-agent/internal/logging/logfields"
 	"github.com/argoproj-labs/argocd-agent/internal/metrics"
@@ -32,6 +35,8 @@ import (
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
 	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
@@ -72,12 +77,31 @@ type Server struct {
 // the agent connection. Return a non-nil error to reject with that status.
 type AcceptCheck func(agentName string) error
 
+// ConnectionChange describes an agent connecting to or disconnecting from
+// the subscription stream
+type ConnectionChange struct {
+	AgentName string
+	Connected bool
+	// RemoteAddr is the address the agent connected from
+	RemoteAddr string
+	// Reason tells why the agent disconnected
+	Reason string
+	At     time.Time
+}
+
 type ServerOptions struct {
-	MaxStreamDuration time.Duration
-	notifyOnConnect   chan types.Agent
-	acceptCheck       AcceptCheck
+	MaxStreamDuration  time.Duration
+	notifyOnConnect    chan types.Agent
+	notifyOnDisconnect func(agentName string)
+	notifyOnChange     func(ConnectionChange)
+	acceptCheck        AcceptCheck
+	// maxMessageSize is the size of messages above which events are sent
+	// to agents in chunks. 0 disables chunking.
+	maxMessageSize int
 
 	logger *logging.CentralizedLogger
+	// recorder records the events exchanged with agents, if not nil
+	recorder *eventrecord.Recorder
 }
 
 type ServerOption func(o *ServerOptions)
@@ -87,10 +111,19 @@ type client struct {
 	cancelFn  context.CancelFunc
 	logCtx    *logrus.Entry
 	agentName string
-	wg        *sync.WaitGroup
-	start     time.Time
-	// lock must be owned before read/writing to 'end' var
-	end            time.Time
+	// schemaVersion is the event schema version negotiated with the agent
+	schemaVersion event.SchemaVersion
+	// chunks reassembles events the agent sent in chunks
+	chunks *event.ChunkAssembler
+	wg     *sync.WaitGroup
+	start  time.Time
+	// remoteAddr is the address the agent connected from
+	remoteAddr string
+	// lock must be owned before read/writing to 'end' and 'reason' vars
+	end time.Time
+	// reason tells why the stream ended, it's set by the first goroutine
+	// to fail
+	reason         string
 	lock           sync.RWMutex
 	disconnectOnce sync.Once
 }
@@ -107,18 +140,49 @@ func WithNotifyOnConnect(notify chan types.Agent) ServerOption {
 	}
 }
 
+// WithNotifyOnDisconnect sets a function to be called whenever an agent
+// disconnects from the subscription stream.
+func WithNotifyOnDisconnect(fn func(agentName string)) ServerOption {
+	return func(o *ServerOptions) {
+		o.notifyOnDisconnect = fn
+	}
+}
+
+// WithNotifyOnConnectionChange sets a function to be called whenever an
+// agent connects to or disconnects from the subscription stream.
+func WithNotifyOnConnectionChange(fn func(ConnectionChange)) ServerOption {
+	return func(o *ServerOptions) {
+		o.notifyOnChange = fn
+	}
+}
+
 func WithAcceptCheck(fn AcceptCheck) ServerOption {
 	return func(o *ServerOptions) {
 		o.acceptCheck = fn
 	}
 }
 
+// WithMaxMessageSize sets the size of messages above which events are sent
+// to agents supporting it in chunks
+func WithMaxMessageSize(n int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxMessageSize = n
+	}
+}
+
 func WithLogger(logger *logging.CentralizedLogger) ServerOption {
 	return func(o *ServerOptions) {
 		o.logger = logger
 	}
 }
 
+// WithRecorder records all events exchanged with agents using r
+func WithRecorder(r *eventrecord.Recorder) ServerOption {
+	return func(o *ServerOptions) {
+		o.recorder = r
+	}
+}
+
 // NewServer returns a new AppStream server instance with the given options
 func NewServer(queues queue.QueuePair, eventWriters *event.EventWritersMap, metrics *metrics.PrincipalMetrics, clusterMgr clusterStatusUpdater, opts ...ServerOption) *Server {
 	options := &ServerOptions{}
@@ -145,12 +209,16 @@ func NewServer(queues queue.QueuePair, eventWriters *event.EventWritersMap, metr
 func (s *Server) newClientConnection(ctx context.Context, timeout time.Duration) (*client, error) {
 	c := &client{}
 	c.wg = &sync.WaitGroup{}
+	c.chunks = event.NewChunkAssembler()
 
 	agentName, err := session.ClientIDFromContext(ctx)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	c.agentName = agentName
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		c.remoteAddr = p.Addr.String()
+	}
 
 	c.logCtx = logrus.WithFields(logrus.Fields{
 		logfields.Method: "Subscribe",
@@ -167,13 +235,79 @@ func (s *Server) newClientConnection(ctx context.Context, timeout time.Duration)
 	}
 	c.start = time.Now()
 
+	// Agents announce the newest event schema version they support. Older
+	// agents don't, and speak the legacy schema.
+	var agentSchema string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(event.SchemaVersionMetadataKey); len(v) > 0 {
+			agentSchema = v[0]
+		}
+	}
+	peerVersion, err := event.ParseSchemaVersion(agentSchema)
+	if err != nil {
+		c.cancelFn()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	c.schemaVersion = event.NegotiateSchemaVersion(peerVersion)
+	c.logCtx = c.logCtx.WithField("event_schema", c.schemaVersion)
+
 	c.logCtx.Info("An agent connected to the subscription stream")
 	return c, nil
 }
 
+// setDisconnectReason records why the stream of c ended, unless a reason
+// was recorded already
+func (c *client) setDisconnectReason(reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.reason == "" {
+		c.reason = reason
+	}
+}
+
+// disconnectReason returns a description of the stream error err
+func disconnectReason(err error) string {
+	switch {
+	case err == io.EOF:
+		return "agent closed the stream"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "maximum stream duration reached"
+	case errors.Is(err, context.Canceled):
+		return "stream canceled"
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Canceled:
+			return "stream canceled"
+		case codes.DeadlineExceeded:
+			return "maximum stream duration reached"
+		}
+		return st.Message()
+	}
+	return err.Error()
+}
+
+// notifyConnectionChange calls the connection change handler, if any
+func (s *Server) notifyConnectionChange(c *client, connected bool, at time.Time) {
+	if s.options.notifyOnChange == nil {
+		return
+	}
+	change := ConnectionChange{AgentName: c.agentName, Connected: connected, RemoteAddr: c.remoteAddr, At: at}
+	if !connected {
+		c.lock.RLock()
+		change.Reason = c.reason
+		c.lock.RUnlock()
+	}
+	s.options.notifyOnChange(change)
+}
+
 // onDisconnect must be called whenever client c disconnects from the stream
 func (s *Server) onDisconnect(c *client) {
 	c.disconnectOnce.Do(func() {
+		if errors.Is(c.ctx.Err(), context.DeadlineExceeded) {
+			c.setDisconnectReason("maximum stream duration reached")
+		}
+		c.setDisconnectReason("stream closed")
 		c.lock.Lock()
 		c.end = time.Now()
 		c.lock.Unlock()
@@ -187,6 +321,13 @@ func (s *Server) onDisconnect(c *client) {
 			s.clusterMgr.SetAgentConnectionStatus(c.agentName, v1alpha1.ConnectionStatusFailed, c.end)
 		}
 		s.activeClientsMu.Unlock()
+
+		if current == c && s.options.notifyOnDisconnect != nil {
+			s.options.notifyOnDisconnect(c.agentName)
+		}
+		if current == c {
+			s.notifyConnectionChange(c, false, c.end)
+		}
 	})
 
 	c.wg.Done()
@@ -227,6 +368,20 @@ func (s *Server) recvFunc(c *client, subs eventstreamapi.EventStream_SubscribeSe
 		return fmt.Errorf("invalid wire transmission")
 	}
 
+	// Events too large for a single message arrive in chunks, which are
+	// only processed once complete.
+	if event.IsChunk(streamEvent.Event) {
+		pev, err := c.chunks.Assemble(streamEvent.Event)
+		if err != nil {
+			logCtx.WithError(err).Warn("Dropping chunked event")
+			return nil
+		}
+		if pev == nil {
+			return nil
+		}
+		streamEvent.Event = pev
+	}
+
 	app := &v1alpha1.Application{}
 	proj := &v1alpha1.AppProject{}
 	resResp := &event.ResourceResponse{}
@@ -267,6 +422,8 @@ func (s *Server) recvFunc(c *client, subs eventstreamapi.EventStream_SubscribeSe
 		logCtx.Infof("Received update for application '%v'", app.QualifiedName())
 	}
 
+	s.options.recorder.Record(eventrecord.DirectionRecv, c.agentName, incomingEvent)
+
 	q := s.queues.RecvQ(c.agentName)
 	if q == nil {
 		return fmt.Errorf("panic: no recvq for agent %s", c.agentName)
@@ -342,6 +499,7 @@ func (s *Server) sendFunc(c *client, subs eventstreamapi.EventStream_SubscribeSe
 		"event_id":    event.EventID(ev),
 		"type":        ev.Type(),
 	}).Trace("Adding an event to the event writer")
+	s.options.recorder.Record(eventrecord.DirectionSend, c.agentName, ev)
 	eventWriter.Add(ev)
 
 	q.Done(ev)
@@ -369,11 +527,17 @@ func (s *Server) Subscribe(subs eventstreamapi.EventStream_SubscribeServer) erro
 		}
 	}
 
+	// Tell the agent which schema version we agreed upon
+	if err := subs.SendHeader(metadata.Pairs(event.SchemaVersionMetadataKey, c.schemaVersion.String())); err != nil {
+		c.logCtx.WithError(err).Debug("Could not send event schema version to agent")
+	}
+
 	s.activeClientsMu.Lock()
 	s.activeClients[c.agentName] = c
 	s.activeClientsMu.Unlock()
 
 	s.clusterMgr.SetAgentConnectionStatus(c.agentName, v1alpha1.ConnectionStatusSuccessful, c.start)
+	s.notifyConnectionChange(c, true, c.start)
 
 	if s.metrics != nil {
 		// increase counter when an agent is connected with principal
@@ -390,6 +554,8 @@ func (s *Server) Subscribe(subs eventstreamapi.EventStream_SubscribeServer) erro
 		eventWriter = event.NewEventWriter(c.agentName, subs)
 		s.eventWriters.Add(c.agentName, eventWriter)
 	}
+	eventWriter.SetSchemaVersion(c.schemaVersion)
+	eventWriter.SetMaxMessageSize(s.options.maxMessageSize)
 
 	go eventWriter.SendWaitingEvents(c.ctx)
 
@@ -416,6 +582,7 @@ func (s *Server) Subscribe(subs eventstreamapi.EventStream_SubscribeServer) erro
 				err := s.recvFunc(c, subs)
 				if err != nil {
 					c.logCtx.Infof("Receiver disconnected: %v", err)
+					c.setDisconnectReason(disconnectReason(err))
 					c.cancelFn()
 				}
 
@@ -441,6 +608,7 @@ func (s *Server) Subscribe(subs eventstreamapi.EventStream_SubscribeServer) erro
 				err := s.sendFunc(c, subs)
 				if err != nil {
 					c.logCtx.Infof("Send: %v", err)
+					c.setDisconnectReason(disconnectReason(err))
 					c.cancelFn()
 				}
 
@@ -479,6 +647,43 @@ func (s *Server) ConnectedAgentCount() int {
 	return len(s.activeClients)
 }
 
+// ConnectedAgents returns the names of the currently connected agents.
+func (s *Server) ConnectedAgents() []string {
+	s.activeClientsMu.Lock()
+	defer s.activeClientsMu.Unlock()
+	agents := make([]string, 0, len(s.activeClients))
+	for name := range s.activeClients {
+		agents = append(agents, name)
+	}
+	return agents
+}
+
+// AgentSchemaVersion returns the event schema version negotiated with the
+// given agent. The second return value is false if the agent is not
+// connected.
+func (s *Server) AgentSchemaVersion(agentName string) (event.SchemaVersion, bool) {
+	s.activeClientsMu.Lock()
+	defer s.activeClientsMu.Unlock()
+	c, ok := s.activeClients[agentName]
+	if !ok {
+		return 0, false
+	}
+	return c.schemaVersion, true
+}
+
+// AgentConnectedSince returns the time the given agent's current connection
+// was established. The second return value is false if the agent is not
+// connected.
+func (s *Server) AgentConnectedSince(agentName string) (time.Time, bool) {
+	s.activeClientsMu.Lock()
+	defer s.activeClientsMu.Unlock()
+	c, ok := s.activeClients[agentName]
+	if !ok {
+		return time.Time{}, false
+	}
+	return c.start, true
+}
+
 // DisconnectAll cancels every active agent stream, forcing all agents to disconnect.
 func (s *Server) DisconnectAll() {
 	s.activeClientsMu.Lock()
@@ -487,8 +692,47 @@ func (s *Server) DisconnectAll() {
 	s.activeClientsMu.Unlock()
 
 	for name, c := range clients {
-		logrus.WithField("agent", name).Info("Disconnecting agent (HA demote)")
-		s.clusterMgr.SetAgentConnectionStatus(name, v1alpha1.ConnectionStatusFailed, time.Now())
+		s.disconnect(name, c, "disconnected by principal")
+	}
+}
+
+// DisconnectWhere cancels the streams of agents for which reject returns an
+// error, given the agent's name and the context of its stream. The error is
+// recorded as the reason of the disconnect.
+func (s *Server) DisconnectWhere(reject func(agentName string, ctx context.Context) error) {
+	s.activeClientsMu.Lock()
+	clients := make(map[string]*client, len(s.activeClients))
+	maps.Copy(clients, s.activeClients)
+	s.activeClientsMu.Unlock()
+
+	// reject may take a while, e.g. to query an OCSP responder, so it is
+	// not called with the lock held
+	for name, c := range clients {
+		err := reject(name, c.ctx)
+		if err == nil {
+			continue
+		}
+		s.activeClientsMu.Lock()
+		current := s.activeClients[name] == c
+		if current {
+			delete(s.activeClients, name)
+		}
+		s.activeClientsMu.Unlock()
+		if current {
+			s.disconnect(name, c, err.Error())
+		}
+	}
+}
+
+// disconnect cancels the stream of the given agent, which was removed from
+// the active clients already
+func (s *Server) disconnect(name string, c *client, reason string) {
+	logrus.WithField("agent", name).WithField("reason", reason).Info("Disconnecting agent")
+	now := time.Now()
+	s.clusterMgr.SetAgentConnectionStatus(name, v1alpha1.ConnectionStatusFailed, now)
+	c.setDisconnectReason(reason)
+	s.notifyConnectionChange(c, false, now)
+	if c.cancelFn != nil {
 		c.cancelFn()
 	}
 }
@@ -576,11 +820,12 @@ func (s *Server) IsAgentConnected(agentName string) bool {
 	return found
 }
 
-// MarkConnected registers agentName as an active client.
+// MarkConnected registers agentName as an active client speaking the
+// current event schema, connected from now on.
 func (s *Server) MarkConnected(agentName string) {
 	s.activeClientsMu.Lock()
 	defer s.activeClientsMu.Unlock()
-	s.activeClients[agentName] = &client{agentName: agentName}
+	s.activeClients[agentName] = &client{agentName: agentName, schemaVersion: event.CurrentSchemaVersion, start: time.Now()}
 }
 
 // MarkDisconnected removes agentName from active clients.
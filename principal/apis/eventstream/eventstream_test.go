@@ -15,8 +15,11 @@
 package eventstream
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -29,6 +32,10 @@ import (
 	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream/mock"
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/stretchr/testify/assert"
@@ -231,6 +238,126 @@ func TestDisconnectAll(t *testing.T) {
 	})
 }
 
+func TestDisconnectWhere(t *testing.T) {
+	qs := queue.NewSendRecvQueues()
+	qs.Create("agent-a")
+	qs.Create("agent-b")
+	var mu sync.Mutex
+	var reason string
+	notify := WithNotifyOnConnectionChange(func(c ConnectionChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !c.Connected {
+			reason = c.Reason
+		}
+	})
+	s := NewServer(qs, event.NewEventWritersMap(), nil, &cluster.Manager{}, notify)
+
+	done := make(chan string, 2)
+	gate := make(chan struct{})
+	for _, name := range []string{"agent-a", "agent-b"} {
+		name := name
+		st := &mock.MockEventServer{AgentName: name}
+		st.AddRecvHook(func(_ *mock.MockEventServer) error {
+			<-gate
+			return io.EOF
+		})
+		go func() {
+			_ = s.Subscribe(st)
+			done <- name
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return s.ConnectedAgentCount() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	s.DisconnectWhere(func(agentName string, ctx context.Context) error {
+		assert.NotNil(t, ctx)
+		if agentName == "agent-a" {
+			return errors.New("certificate revoked")
+		}
+		return nil
+	})
+	assert.Equal(t, 1, s.ConnectedAgentCount())
+	mu.Lock()
+	assert.Equal(t, "certificate revoked", reason)
+	mu.Unlock()
+
+	// Unblock recv hooks so goroutines can exit
+	close(gate)
+	<-done
+	<-done
+}
+
+func TestConnectionChange(t *testing.T) {
+	var mu sync.Mutex
+	changes := []ConnectionChange{}
+	notify := WithNotifyOnConnectionChange(func(c ConnectionChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, c)
+	})
+
+	t.Run("Connect and disconnect are reported", func(t *testing.T) {
+		changes = changes[:0]
+		qs := queue.NewSendRecvQueues()
+		require.NoError(t, qs.Create("agent-a"))
+		s := NewServer(qs, event.NewEventWritersMap(), nil, &cluster.Manager{}, notify)
+		st := &mock.MockEventServer{
+			AgentName: "agent-a",
+			Peer:      &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4711}},
+		}
+		st.AddRecvHook(func(_ *mock.MockEventServer) error {
+			return io.EOF
+		})
+		require.NoError(t, s.Subscribe(st))
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, changes, 2)
+		assert.True(t, changes[0].Connected)
+		assert.Equal(t, "192.0.2.1:4711", changes[0].RemoteAddr)
+		assert.False(t, changes[1].Connected)
+		assert.Equal(t, "agent closed the stream", changes[1].Reason)
+		assert.Equal(t, "192.0.2.1:4711", changes[1].RemoteAddr)
+	})
+
+	t.Run("Disconnect by principal is reported", func(t *testing.T) {
+		changes = changes[:0]
+		qs := queue.NewSendRecvQueues()
+		require.NoError(t, qs.Create("agent-a"))
+		s := NewServer(qs, event.NewEventWritersMap(), nil, &cluster.Manager{}, notify)
+		gate := make(chan struct{})
+		st := &mock.MockEventServer{AgentName: "agent-a"}
+		st.AddRecvHook(func(_ *mock.MockEventServer) error {
+			<-gate
+			return io.EOF
+		})
+		done := make(chan struct{})
+		go func() {
+			_ = s.Subscribe(st)
+			close(done)
+		}()
+		require.Eventually(t, func() bool { return s.ConnectedAgentCount() == 1 }, time.Second, 10*time.Millisecond)
+		s.DisconnectAll()
+		close(gate)
+		<-done
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, changes, 2)
+		assert.Equal(t, "disconnected by principal", changes[1].Reason)
+	})
+}
+
+func Test_disconnectReason(t *testing.T) {
+	assert.Equal(t, "agent closed the stream", disconnectReason(io.EOF))
+	assert.Equal(t, "maximum stream duration reached", disconnectReason(status.Error(codes.DeadlineExceeded, "deadline")))
+	assert.Equal(t, "maximum stream duration reached", disconnectReason(context.DeadlineExceeded))
+	assert.Equal(t, "stream canceled", disconnectReason(status.Error(codes.Canceled, "canceled")))
+	assert.Equal(t, "transport is closing", disconnectReason(status.Error(codes.Unavailable, "transport is closing")))
+}
+
 func TestAcceptCheck(t *testing.T) {
 	clusterMgr := &cluster.Manager{}
 
@@ -266,6 +393,45 @@ func TestAcceptCheck(t *testing.T) {
 	})
 }
 
+func TestSchemaVersionNegotiation(t *testing.T) {
+	clusterMgr := &cluster.Manager{}
+	for _, tc := range []struct {
+		name     string
+		md       metadata.MD
+		expected event.SchemaVersion
+	}{
+		{"legacy agent", nil, event.SchemaVersionLegacy},
+		{"current agent", metadata.Pairs(event.SchemaVersionMetadataKey, event.CurrentSchemaVersion.String()), event.CurrentSchemaVersion},
+		{"newer agent", metadata.Pairs(event.SchemaVersionMetadataKey, "99"), event.CurrentSchemaVersion},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			qs := queue.NewSendRecvQueues()
+			qs.Create("default")
+			ew := event.NewEventWritersMap()
+			s := NewServer(qs, ew, nil, clusterMgr)
+			st := &mock.MockEventServer{AgentName: "default", Metadata: tc.md}
+			var negotiated event.SchemaVersion
+			st.AddRecvHook(func(_ *mock.MockEventServer) error {
+				negotiated, _ = s.AgentSchemaVersion("default")
+				return io.EOF
+			})
+			require.NoError(t, s.Subscribe(st))
+			assert.Equal(t, tc.expected, negotiated)
+			assert.Equal(t, []string{tc.expected.String()}, st.Header.Get(event.SchemaVersionMetadataKey))
+			assert.Equal(t, tc.expected, ew.Get("default").SchemaVersion())
+		})
+	}
+
+	t.Run("invalid version is rejected", func(t *testing.T) {
+		qs := queue.NewSendRecvQueues()
+		qs.Create("default")
+		s := NewServer(qs, event.NewEventWritersMap(), nil, clusterMgr)
+		st := &mock.MockEventServer{AgentName: "default", Metadata: metadata.Pairs(event.SchemaVersionMetadataKey, "x")}
+		err := s.Subscribe(st)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
 func TestIsAgentConnected(t *testing.T) {
 	clusterMgr := &cluster.Manager{}
 
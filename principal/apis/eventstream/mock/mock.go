@@ -24,6 +24,8 @@ import (
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 // SendHook is a function that will be executed for the Send call in the mock
@@ -37,8 +39,13 @@ type RecvHook func(s *MockEventServer) error
 type MockEventServer struct {
 	grpc.ServerStream
 
-	AgentName   string
-	AgentMode   string
+	AgentName string
+	AgentMode string
+	// Metadata is sent as incoming metadata, Header receives the header
+	Metadata metadata.MD
+	Header   metadata.MD
+	// Peer is the remote end of the stream, if set
+	Peer        *peer.Peer
 	NumSent     atomic.Uint32
 	NumRecv     atomic.Uint32
 	Application v1alpha1.Application
@@ -69,9 +76,22 @@ func (s *MockEventServer) Context() context.Context {
 		ctx = context.WithValue(ctx, types.ContextAgentMode, s.AgentMode)
 	}
 
+	if s.Metadata != nil {
+		ctx = metadata.NewIncomingContext(ctx, s.Metadata)
+	}
+
+	if s.Peer != nil {
+		ctx = peer.NewContext(ctx, s.Peer)
+	}
+
 	return ctx
 }
 
+func (s *MockEventServer) SendHeader(md metadata.MD) error {
+	s.Header = metadata.Join(s.Header, md)
+	return nil
+}
+
 func (s *MockEventServer) Send(sub *eventstreamapi.Event) error {
 	var err error
 	for _, h := range s.SendHooks {
@@ -0,0 +1,505 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetransfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxFileSize is the default maximum size of a single file transfer
+const DefaultMaxFileSize int64 = 1024 * 1024 * 1024
+
+// ErrTimeout is returned by Transfer.Wait when the agent did not send any
+// data in time.
+var ErrTimeout = errors.New("timeout waiting for file transfer")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Server implements the FileTransferService gRPC server. Files uploaded by
+// agents are written to the Sink registered for the request UUID.
+type Server struct {
+	filetransferapi.UnimplementedFileTransferServiceServer
+
+	maxFileSize int64
+
+	mu        sync.RWMutex
+	transfers map[string]*Transfer
+}
+
+// Option is a functional option for the FileTransfer server
+type Option func(s *Server)
+
+// WithMaxFileSize sets the maximum size of a file accepted from an agent.
+// A size of 0 disables the limit.
+func WithMaxFileSize(size int64) Option {
+	return func(s *Server) {
+		s.maxFileSize = size
+	}
+}
+
+// FileInfo describes a file as announced by the agent in its first chunk
+type FileInfo struct {
+	Name        string
+	ContentType string
+	// Size is the size of the file, or 0 if unknown
+	Size int64
+}
+
+// Progress describes how far a transfer has come
+type Progress struct {
+	Received int64
+	// Total is the size of the file, or 0 if unknown
+	Total int64
+}
+
+// Sink receives the contents of a file transfer. Write is called with the
+// file's data in order, and only after Begin returned successfully.
+type Sink interface {
+	io.Writer
+	// Begin is called once, before the first data is written
+	Begin(info FileInfo) error
+	// Abort is called when the transfer fails. code is a HTTP status code
+	// describing the failure. started indicates whether Begin was called.
+	Abort(code int, msg string, started bool)
+}
+
+// Transfer is a pending or active file transfer to a Sink.
+type Transfer struct {
+	uuid       string
+	sink       Sink
+	maxSize    int64
+	onProgress func(Progress)
+
+	mu           sync.Mutex
+	started      bool
+	received     int64
+	total        int64
+	hash         hash.Hash
+	lastActivity time.Time
+
+	doneCh   chan struct{}
+	doneOnce sync.Once
+	err      error
+}
+
+// TransferOption is a functional option for a single transfer
+type TransferOption func(t *Transfer)
+
+// WithMaxSize overrides the server's maximum file size for a transfer
+func WithMaxSize(size int64) TransferOption {
+	return func(t *Transfer) {
+		t.maxSize = size
+	}
+}
+
+// WithProgress registers a function that is called whenever data has been
+// received for a transfer.
+func WithProgress(fn func(Progress)) TransferOption {
+	return func(t *Transfer) {
+		t.onProgress = fn
+	}
+}
+
+// NewServer creates a new FileTransfer gRPC server.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		maxFileSize: DefaultMaxFileSize,
+		transfers:   make(map[string]*Transfer),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Register registers the sink that receives the file for the given request
+// UUID.
+func (s *Server) Register(requestUUID string, sink Sink, opts ...TransferOption) *Transfer {
+	t := &Transfer{
+		uuid:         requestUUID,
+		sink:         sink,
+		maxSize:      s.maxFileSize,
+		hash:         sha256.New(),
+		lastActivity: time.Now(),
+		doneCh:       make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(t)
+	}
+	s.mu.Lock()
+	s.transfers[requestUUID] = t
+	s.mu.Unlock()
+	return t
+}
+
+// Remove removes the transfer for the given request UUID. Any upload still in
+// progress for it will be terminated.
+func (s *Server) Remove(requestUUID string) {
+	s.mu.Lock()
+	t := s.transfers[requestUUID]
+	delete(s.transfers, requestUUID)
+	s.mu.Unlock()
+	if t != nil {
+		// Hold the lock so that no chunk is written after Remove returns
+		t.mu.Lock()
+		t.finish(context.Canceled)
+		t.mu.Unlock()
+	}
+}
+
+func (s *Server) get(requestUUID string) *Transfer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transfers[requestUUID]
+}
+
+// Status returns the progress of a transfer. Agents use it to find the offset
+// at which to resume an interrupted upload.
+func (s *Server) Status(ctx context.Context, req *filetransferapi.FileTransferStatusRequest) (*filetransferapi.FileTransferStatus, error) {
+	t := s.get(req.GetRequestUuid())
+	if t == nil || t.isDone() {
+		return nil, status.Error(codes.NotFound, "unknown request id")
+	}
+	p := t.Progress()
+	return &filetransferapi.FileTransferStatus{
+		RequestUuid:   t.uuid,
+		BytesReceived: p.Received,
+		TotalSize:     p.Total,
+		MaxSize:       t.maxSize,
+	}, nil
+}
+
+// Upload receives a file from the agent and writes it to the registered sink.
+// If the stream breaks before the file is complete, the transfer is kept, so
+// that the agent can resume it on a new stream.
+func (s *Server) Upload(stream filetransferapi.FileTransferService_UploadServer) error {
+	logCtx := log().WithField("method", "Upload")
+
+	var t *Transfer
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = status.Error(codes.Aborted, "stream closed before end of file")
+			}
+			if t != nil {
+				logCtx.WithError(err).WithField("bytes", t.Progress().Received).Info("File transfer interrupted")
+			}
+			return err
+		}
+
+		if t == nil {
+			t = s.get(chunk.GetRequestUuid())
+			if t == nil || t.isDone() {
+				logCtx.WithField("request_uuid", chunk.GetRequestUuid()).Warn("Received file for unknown request")
+				return status.Error(codes.NotFound, "unknown request id")
+			}
+			logCtx = logCtx.WithField("request_uuid", t.uuid)
+			if chunk.GetOffset() == 0 {
+				logCtx.WithField("name", chunk.GetName()).Info("File transfer started")
+			} else {
+				logCtx.WithField("offset", chunk.GetOffset()).Info("File transfer resumed")
+			}
+		}
+
+		if chunk.GetError() != "" {
+			logCtx.WithField("error", chunk.GetError()).Warn("File transfer failed on agent")
+			t.Abort(http.StatusBadGateway, chunk.GetError())
+			return status.Error(codes.Internal, chunk.GetError())
+		}
+
+		if err := t.write(chunk); err != nil {
+			if _, ok := status.FromError(err); ok {
+				logCtx.WithError(err).Warn("Rejected file chunk")
+				return err
+			}
+			logCtx.WithError(err).Warn("Could not write file to client; canceling transfer")
+			t.finish(err)
+			return status.Error(codes.Canceled, "client disconnected")
+		}
+
+		if chunk.GetEof() {
+			sum, err := t.complete(chunk.GetSha256())
+			if err != nil {
+				logCtx.WithError(err).Warn("File transfer failed verification")
+				return err
+			}
+			logCtx.WithField("bytes", t.Progress().Received).Info("File transfer complete")
+			return stream.SendAndClose(&filetransferapi.FileTransferResponse{
+				RequestUuid:   t.uuid,
+				BytesReceived: t.Progress().Received,
+				Sha256:        sum,
+			})
+		}
+	}
+}
+
+// write validates a chunk and writes its data to the sink. Errors that carry
+// a gRPC status leave the transfer intact, so that the agent can retry from
+// the expected offset. Any other error is a failure of the sink.
+func (t *Transfer) write(chunk *filetransferapi.FileChunk) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.isDone() {
+		return status.Error(codes.NotFound, "transfer already finished")
+	}
+	if chunk.GetOffset() != t.received {
+		return status.Errorf(codes.OutOfRange, "expected offset %d, got %d", t.received, chunk.GetOffset())
+	}
+	data := chunk.GetData()
+	if chunk.Crc32C != nil && crc32.Checksum(data, crc32cTable) != chunk.GetCrc32C() {
+		return status.Errorf(codes.DataLoss, "checksum mismatch for chunk at offset %d", chunk.GetOffset())
+	}
+	if !t.started {
+		if t.maxSize > 0 && chunk.GetTotalSize() > t.maxSize {
+			t.abortLocked(http.StatusRequestEntityTooLarge, "file exceeds maximum size")
+			return status.Errorf(codes.ResourceExhausted, "file size %d exceeds maximum of %d bytes", chunk.GetTotalSize(), t.maxSize)
+		}
+		t.total = chunk.GetTotalSize()
+		if err := t.sink.Begin(FileInfo{Name: chunk.GetName(), ContentType: chunk.GetContentType(), Size: t.total}); err != nil {
+			return err
+		}
+		t.started = true
+	}
+	if t.maxSize > 0 && t.received+int64(len(data)) > t.maxSize {
+		t.abortLocked(http.StatusRequestEntityTooLarge, "file exceeds maximum size")
+		return status.Errorf(codes.ResourceExhausted, "file exceeds maximum of %d bytes", t.maxSize)
+	}
+	t.lastActivity = time.Now()
+	if len(data) == 0 {
+		return nil
+	}
+	n, err := t.sink.Write(data)
+	t.hash.Write(data[:n])
+	t.received += int64(n)
+	if err != nil {
+		return err
+	}
+	if t.onProgress != nil {
+		t.onProgress(Progress{Received: t.received, Total: t.total})
+	}
+	return nil
+}
+
+// complete verifies the received file against the expected digest, if any,
+// and finishes the transfer. It returns the digest of the received data.
+func (t *Transfer) complete(expected string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sum := hex.EncodeToString(t.hash.Sum(nil))
+	if expected != "" && expected != sum {
+		t.abortLocked(http.StatusBadGateway, "file checksum mismatch")
+		return sum, status.Errorf(codes.DataLoss, "sha256 mismatch: expected %s, got %s", expected, sum)
+	}
+	if t.total > 0 && t.received != t.total {
+		t.abortLocked(http.StatusBadGateway, "file size mismatch")
+		return sum, status.Errorf(codes.DataLoss, "size mismatch: expected %d bytes, got %d", t.total, t.received)
+	}
+	t.finish(nil)
+	return sum, nil
+}
+
+// Abort ends the transfer and reports an error to the sink.
+func (t *Transfer) Abort(code int, msg string) {
+	t.mu.Lock()
+	t.abortLocked(code, msg)
+	t.mu.Unlock()
+}
+
+// abortLocked aborts the transfer. Caller must hold t.mu.
+func (t *Transfer) abortLocked(code int, msg string) {
+	if !t.isDone() {
+		t.sink.Abort(code, msg, t.started)
+	}
+	t.finish(errors.New(msg))
+}
+
+func (t *Transfer) finish(err error) {
+	t.doneOnce.Do(func() {
+		t.err = err
+		close(t.doneCh)
+	})
+}
+
+func (t *Transfer) isDone() bool {
+	select {
+	case <-t.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Progress returns the number of bytes received so far and the total size of
+// the file, if known.
+func (t *Transfer) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Progress{Received: t.received, Total: t.total}
+}
+
+// Started returns true if the agent has begun sending the file.
+func (t *Transfer) Started() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.started
+}
+
+func (t *Transfer) idle() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.lastActivity)
+}
+
+// Wait waits for the transfer to complete. If the agent doesn't send any
+// data for idleTimeout, either before starting or while resuming an
+// interrupted transfer, ErrTimeout is returned. It returns early if ctx is
+// done, e.g. because the HTTP client went away.
+func (t *Transfer) Wait(ctx context.Context, idleTimeout time.Duration) error {
+	timer := time.NewTimer(idleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.doneCh:
+			return t.err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			idle := t.idle()
+			if idle >= idleTimeout {
+				return ErrTimeout
+			}
+			timer.Reset(idleTimeout - idle)
+		}
+	}
+}
+
+// httpSink writes a file to an HTTP response
+type httpSink struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewHTTPSink returns a Sink that sends the file as the body of an HTTP
+// response. Headers are written once the agent begins the transfer.
+func NewHTTPSink(w http.ResponseWriter) Sink {
+	s := &httpSink{w: w}
+	s.flusher, _ = w.(http.Flusher)
+	return s
+}
+
+func (s *httpSink) Begin(info FileInfo) error {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	s.w.Header().Set("Content-Type", contentType)
+	if info.Name != "" {
+		s.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name))
+	}
+	if info.Size > 0 {
+		// Lets the client detect a truncated file if the transfer fails
+		s.w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	s.w.Header().Set("Cache-Control", "no-cache, no-transform")
+	s.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *httpSink) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if err == nil && s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return n, err
+}
+
+// Abort reports the error to the client if the response hasn't started yet.
+// Otherwise, the body is truncated.
+func (s *httpSink) Abort(code int, msg string, started bool) {
+	if !started {
+		http.Error(s.w, msg, code)
+	}
+}
+
+// FileSink writes a file to the local file system
+type FileSink struct {
+	path string
+	f    *os.File
+}
+
+// NewFileSink returns a Sink that writes the file to path. The file is
+// created once the agent begins the transfer, and removed if the transfer
+// fails. Close must be called once the transfer has finished.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Begin(info FileInfo) error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+func (s *FileSink) Write(p []byte) (int, error) {
+	if s.f == nil {
+		return 0, fmt.Errorf("file transfer has not begun")
+	}
+	return s.f.Write(p)
+}
+
+// Abort removes the partially written file
+func (s *FileSink) Abort(code int, msg string, started bool) {
+	if s.f == nil {
+		return
+	}
+	_ = s.f.Close()
+	_ = os.Remove(s.path)
+	s.f = nil
+}
+
+// Close closes the file, if it has been created
+func (s *FileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func log() *logrus.Entry {
+	return logrus.WithField("module", "FileTransfer")
+}
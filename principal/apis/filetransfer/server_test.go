@@ -0,0 +1,249 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetransfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type mockUploadServer struct {
+	grpc.ServerStream
+	chunks []*filetransferapi.FileChunk
+	resp   *filetransferapi.FileTransferResponse
+}
+
+func (m *mockUploadServer) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockUploadServer) Recv() (*filetransferapi.FileChunk, error) {
+	if len(m.chunks) == 0 {
+		return nil, io.EOF
+	}
+	c := m.chunks[0]
+	m.chunks = m.chunks[1:]
+	return c, nil
+}
+
+func (m *mockUploadServer) SendAndClose(resp *filetransferapi.FileTransferResponse) error {
+	m.resp = resp
+	return nil
+}
+
+func Test_Upload(t *testing.T) {
+	t.Run("file is written to registered client", func(t *testing.T) {
+		s := NewServer()
+		w := httptest.NewRecorder()
+		transfer := s.Register("uuid", NewHTTPSink(w))
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Name: "bundle.tar.gz", ContentType: "application/gzip"},
+			{RequestUuid: "uuid", Data: []byte("hello ")},
+			{RequestUuid: "uuid", Offset: 6, Data: []byte("world"), Eof: true},
+		}}
+		require.NoError(t, s.Upload(stream))
+		require.NoError(t, transfer.Wait(context.Background(), time.Second))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="bundle.tar.gz"`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "hello world", w.Body.String())
+		assert.Equal(t, int64(11), stream.resp.GetBytesReceived())
+	})
+
+	t.Run("unknown request", func(t *testing.T) {
+		s := NewServer()
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{{RequestUuid: "unknown"}}}
+		assert.Equal(t, codes.NotFound, status.Code(s.Upload(stream)))
+	})
+
+	t.Run("error before first data", func(t *testing.T) {
+		s := NewServer()
+		w := httptest.NewRecorder()
+		transfer := s.Register("uuid", NewHTTPSink(w))
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{{RequestUuid: "uuid", Error: "no such application"}}}
+		assert.Equal(t, codes.Internal, status.Code(s.Upload(stream)))
+		assert.EqualError(t, transfer.Wait(context.Background(), time.Second), "no such application")
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, w.Body.String(), "no such application")
+	})
+
+	t.Run("interrupted transfer can be resumed", func(t *testing.T) {
+		s := NewServer()
+		w := httptest.NewRecorder()
+		transfer := s.Register("uuid", NewHTTPSink(w))
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Data: []byte("hello "), TotalSize: 11},
+		}}
+		assert.Equal(t, codes.Aborted, status.Code(s.Upload(stream)))
+		assert.ErrorIs(t, transfer.Wait(context.Background(), 10*time.Millisecond), ErrTimeout)
+
+		st, err := s.Status(context.Background(), &filetransferapi.FileTransferStatusRequest{RequestUuid: "uuid"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), st.GetBytesReceived())
+		assert.Equal(t, int64(11), st.GetTotalSize())
+
+		// Resuming at the wrong offset is rejected
+		stream = &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Offset: 0, Data: []byte("hello ")},
+		}}
+		assert.Equal(t, codes.OutOfRange, status.Code(s.Upload(stream)))
+
+		sum := sha256.Sum256([]byte("hello world"))
+		stream = &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Offset: 6, Data: []byte("world")},
+			{RequestUuid: "uuid", Offset: 11, Eof: true, Sha256: hex.EncodeToString(sum[:])},
+		}}
+		require.NoError(t, s.Upload(stream))
+		require.NoError(t, transfer.Wait(context.Background(), time.Second))
+		assert.Equal(t, "hello world", w.Body.String())
+		assert.Equal(t, "11", w.Header().Get("Content-Length"))
+		assert.Equal(t, hex.EncodeToString(sum[:]), stream.resp.GetSha256())
+	})
+
+	t.Run("corrupt chunk is rejected", func(t *testing.T) {
+		s := NewServer()
+		w := httptest.NewRecorder()
+		transfer := s.Register("uuid", NewHTTPSink(w))
+		defer s.Remove("uuid")
+
+		crc := crc32.Checksum([]byte("hello"), crc32cTable)
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Data: []byte("hellO"), Crc32C: &crc},
+		}}
+		assert.Equal(t, codes.DataLoss, status.Code(s.Upload(stream)))
+		assert.Equal(t, int64(0), transfer.Progress().Received)
+		assert.False(t, transfer.Started())
+	})
+
+	t.Run("checksum mismatch aborts transfer", func(t *testing.T) {
+		s := NewServer()
+		w := httptest.NewRecorder()
+		transfer := s.Register("uuid", NewHTTPSink(w))
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Data: []byte("hello"), Eof: true, Sha256: "0000"},
+		}}
+		assert.Equal(t, codes.DataLoss, status.Code(s.Upload(stream)))
+		assert.EqualError(t, transfer.Wait(context.Background(), time.Second), "file checksum mismatch")
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		s := NewServer(WithMaxFileSize(8))
+		w := httptest.NewRecorder()
+		transfer := s.Register("uuid", NewHTTPSink(w))
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", TotalSize: 16},
+		}}
+		assert.Equal(t, codes.ResourceExhausted, status.Code(s.Upload(stream)))
+		assert.Error(t, transfer.Wait(context.Background(), time.Second))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		w = httptest.NewRecorder()
+		transfer = s.Register("other", NewHTTPSink(w), WithMaxSize(0))
+		defer s.Remove("other")
+		stream = &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "other", Data: make([]byte, 16), Eof: true},
+		}}
+		require.NoError(t, s.Upload(stream))
+		require.NoError(t, transfer.Wait(context.Background(), time.Second))
+	})
+
+	t.Run("progress is reported", func(t *testing.T) {
+		s := NewServer()
+		var progress []Progress
+		transfer := s.Register("uuid", NewHTTPSink(httptest.NewRecorder()), WithProgress(func(p Progress) {
+			progress = append(progress, p)
+		}))
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Data: []byte("abc"), TotalSize: 5},
+			{RequestUuid: "uuid", Offset: 3, Data: []byte("de"), Eof: true},
+		}}
+		require.NoError(t, s.Upload(stream))
+		require.NoError(t, transfer.Wait(context.Background(), time.Second))
+		assert.Equal(t, []Progress{{Received: 3, Total: 5}, {Received: 5, Total: 5}}, progress)
+	})
+}
+
+func Test_Wait(t *testing.T) {
+	s := NewServer()
+	w := httptest.NewRecorder()
+	transfer := s.Register("uuid", NewHTTPSink(w))
+	defer s.Remove("uuid")
+
+	assert.ErrorIs(t, transfer.Wait(context.Background(), 10*time.Millisecond), ErrTimeout)
+	transfer.Abort(http.StatusGatewayTimeout, "timeout")
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.False(t, transfer.Started())
+}
+
+func Test_FileSink(t *testing.T) {
+	t.Run("file is written to disk", func(t *testing.T) {
+		s := NewServer()
+		path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+		sink := NewFileSink(path)
+		transfer := s.Register("uuid", sink)
+		defer s.Remove("uuid")
+
+		stream := &mockUploadServer{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: "uuid", Name: "bundle.tar.gz"},
+			{RequestUuid: "uuid", Data: []byte("hello"), Eof: true},
+		}}
+		require.NoError(t, s.Upload(stream))
+		require.NoError(t, transfer.Wait(context.Background(), time.Second))
+		require.NoError(t, sink.Close())
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("file is removed on failure", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+		sink := NewFileSink(path)
+		require.NoError(t, sink.Begin(FileInfo{}))
+		_, err := sink.Write([]byte("partial"))
+		require.NoError(t, err)
+		sink.Abort(http.StatusBadGateway, "failed", true)
+		assert.NoFileExists(t, path)
+		assert.NoError(t, sink.Close())
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxDownloadSize is the maximum number of uncompressed bytes sent in
+// response to a log download.
+const DefaultMaxDownloadSize = 100 * 1024 * 1024
+
+// errDownloadLimitReached is returned when a log download hit its size limit.
+var errDownloadLimitReached = errors.New("log download size limit reached")
+
+// isDownloadRequest returns true if the client asked to save static logs as
+// a file, using the download=true query parameter.
+func isDownloadRequest(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("download"), "true") && isStaticRequest(r) && !IsEventStreamRequest(r)
+}
+
+// acceptsGzip returns true if the client accepts gzip content encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc, _, _ = strings.Cut(strings.TrimSpace(enc), ";")
+			if strings.EqualFold(enc, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// downloadFilename returns the file name suggested to the client for a log
+// download, derived from the pod log request path
+// /api/v1/namespaces/<namespace>/pods/<pod>/log and the container parameter.
+func downloadFilename(r *http.Request) string {
+	parts := []string{}
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "namespaces" || segments[i] == "pods" {
+			parts = append(parts, segments[i+1])
+		}
+	}
+	if container := r.URL.Query().Get("container"); container != "" {
+		parts = append(parts, container)
+	}
+	if len(parts) == 0 {
+		return "logs.log"
+	}
+	name := strings.Join(parts, "_")
+	// Only keep characters that are valid in both DNS names and file names.
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return name + ".log"
+}
+
+// downloadWriter writes the body of a log download, optionally compressing it,
+// and cuts it off once the size limit is reached.
+type downloadWriter struct {
+	w  io.Writer
+	gz *gzip.Writer
+	// limit is the maximum number of uncompressed bytes to write
+	limit   int64
+	written int64
+	// done is set once the download has been completed or cut off
+	done bool
+}
+
+func newDownloadWriter(w io.Writer, compress bool, limit int64) *downloadWriter {
+	dw := &downloadWriter{w: w, limit: limit}
+	if compress {
+		dw.gz = gzip.NewWriter(w)
+		dw.w = dw.gz
+	}
+	return dw
+}
+
+// write writes data to the download. When the size limit is exceeded, the
+// data is truncated, a note is appended and errDownloadLimitReached is
+// returned.
+func (dw *downloadWriter) write(data []byte) (int, error) {
+	if dw.done {
+		return 0, errDownloadLimitReached
+	}
+	if dw.limit > 0 && dw.written+int64(len(data)) > dw.limit {
+		n, err := dw.w.Write(data[:dw.limit-dw.written])
+		dw.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+		_, _ = fmt.Fprintf(dw.w, "\n[log truncated after %d bytes]\n", dw.limit)
+		_ = dw.close()
+		return n, errDownloadLimitReached
+	}
+	n, err := dw.w.Write(data)
+	dw.written += int64(n)
+	return n, err
+}
+
+// fail appends an error message to the download and completes it.
+func (dw *downloadWriter) fail(msg string) {
+	if dw.done {
+		return
+	}
+	_, _ = fmt.Fprintf(dw.w, "%s\n", msg)
+	_ = dw.close()
+}
+
+// close completes the download, writing the gzip footer if needed.
+func (dw *downloadWriter) close() error {
+	if dw.done {
+		return nil
+	}
+	dw.done = true
+	if dw.gz != nil {
+		return dw.gz.Close()
+	}
+	return nil
+}
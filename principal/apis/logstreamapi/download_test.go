@@ -0,0 +1,102 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDownloadFilename(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/namespaces/guestbook/pods/ui-7d9f/log?container=main&download=true", nil)
+	assert.Equal(t, "guestbook_ui-7d9f_main.log", downloadFilename(r))
+	r = httptest.NewRequest("GET", "/logs", nil)
+	assert.Equal(t, "logs.log", downloadFilename(r))
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	r := httptest.NewRequest("GET", "/logs", nil)
+	assert.False(t, acceptsGzip(r))
+	r.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0, br")
+	assert.True(t, acceptsGzip(r))
+}
+
+func TestRegisterHTTP_Download(t *testing.T) {
+	t.Run("plain download", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/namespaces/ns/pods/pod/log?download=true", nil)
+		require.NoError(t, server.RegisterHTTP("dl", w, r))
+		defer server.RemoveSession("dl")
+
+		sendLogs(t, server, "dl", "line1\n", "line2\n")
+		assert.Equal(t, `attachment; filename="ns_pod.log"`, w.Header().Get("Content-Disposition"))
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "line1\nline2\n", w.Body.String())
+	})
+
+	t.Run("gzip download", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/namespaces/ns/pods/pod/log?download=true", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		require.NoError(t, server.RegisterHTTP("dl", w, r))
+		defer server.RemoveSession("dl")
+
+		sendLogs(t, server, "dl", "line1\n", "line2\n")
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		gz, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, "line1\nline2\n", string(body))
+	})
+
+	t.Run("download is cut off at size limit", func(t *testing.T) {
+		server := NewServer(WithMaxDownloadSize(8))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/namespaces/ns/pods/pod/log?download=true", nil)
+		require.NoError(t, server.RegisterHTTP("dl", w, r))
+		defer server.RemoveSession("dl")
+
+		client := server.newLogClient(t.Context())
+		client.requestID = "dl"
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "dl", Data: []byte("line1\n")}))
+		err := server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "dl", Data: []byte("line2\n")})
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.True(t, strings.HasPrefix(w.Body.String(), "line1\nli\n[log truncated after 8 bytes]\n"))
+	})
+
+	t.Run("timeout is appended to download", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/namespaces/ns/pods/pod/log?download=true", nil)
+		require.NoError(t, server.RegisterHTTP("dl", w, r))
+		defer server.RemoveSession("dl")
+
+		assert.True(t, server.WriteError("dl", http.StatusGatewayTimeout, "timeout"))
+		assert.Equal(t, "timeout\n", w.Body.String())
+	})
+}
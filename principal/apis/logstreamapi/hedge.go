@@ -0,0 +1,62 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+// Hedge registers hedgeUUID as a copy of the static log request requestUUID,
+// sent to the agent because it did not start streaming in time. The session
+// of requestUUID is registered under both request IDs, and whichever of both
+// streams starts first answers the client. The stream starting later is
+// canceled. Returns false if there is no such session, or if its stream has
+// started already.
+func (s *Server) Hedge(requestUUID, hedgeUUID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[requestUUID]
+	if sess == nil || sess.hedgeUUID != "" || sess.streamUUID != "" || sess.eof {
+		return false
+	}
+	if _, ok := s.sessions[hedgeUUID]; ok {
+		return false
+	}
+	sess.hedgeUUID = hedgeUUID
+	s.sessions[hedgeUUID] = sess
+	return true
+}
+
+// HedgeWon returns whether the client of the given request was answered by
+// the stream of its hedged copy, see Hedge.
+func (s *Server) HedgeWon(requestUUID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess := s.sessions[requestUUID]
+	return sess != nil && sess.hedgeUUID != "" && sess.streamUUID == sess.hedgeUUID
+}
+
+// claim records that the stream of requestUUID started for the session, and
+// returns false if the stream of another request ID, i.e. the other copy of
+// a hedged request, started first. Caller must hold the server mutex.
+func (sess *session) claim(requestUUID string) bool {
+	if sess.streamUUID == "" {
+		sess.streamUUID = requestUUID
+	}
+	return sess.streamUUID == requestUUID
+}
+
+// isIdleHedge returns whether requestUUID is the copy of a hedged request
+// whose stream does not answer the client of the session. Caller must hold
+// the server mutex.
+func (sess *session) isIdleHedge(requestUUID string) bool {
+	return sess.hedgeUUID == requestUUID && sess.streamUUID != requestUUID
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHedge(t *testing.T) {
+	stream := func(requestUUID string, data ...string) *mock.MockLogStreamServer {
+		ms := mock.NewMockLogStreamServer(context.Background())
+		for _, d := range data {
+			ms.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Data: []byte(d)})
+		}
+		ms.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true})
+		return ms
+	}
+
+	t.Run("Hedged copy answers the client when it starts first", func(t *testing.T) {
+		s := NewServer()
+		w := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, s.RegisterHTTP("orig", w, httptest.NewRequest("GET", "/logs", nil)))
+		require.True(t, s.Hedge("orig", "hedge"))
+
+		require.NoError(t, s.StreamLogs(stream("hedge", "line 1\n")))
+		assert.Equal(t, "line 1\n", w.GetBody())
+		assert.True(t, s.HedgeWon("orig"))
+
+		// The original request's stream is canceled once it starts
+		err := s.StreamLogs(stream("orig", "line 1\n"))
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.Equal(t, "line 1\n", w.GetBody())
+		s.RemoveSession("orig")
+	})
+
+	t.Run("Original request answers the client when it starts first", func(t *testing.T) {
+		s := NewServer()
+		w := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, s.RegisterHTTP("orig", w, httptest.NewRequest("GET", "/logs", nil)))
+		require.True(t, s.Hedge("orig", "hedge"))
+
+		require.NoError(t, s.StreamLogs(stream("orig", "line 1\n")))
+		err := s.StreamLogs(stream("hedge", "line 1\n"))
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.Equal(t, "line 1\n", w.GetBody())
+		s.RemoveSession("hedge")
+		s.RemoveSession("orig")
+	})
+
+	t.Run("Removing an idle copy leaves the session alone", func(t *testing.T) {
+		s := NewServer()
+		w := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, s.RegisterHTTP("orig", w, httptest.NewRequest("GET", "/logs", nil)))
+		require.True(t, s.Hedge("orig", "hedge"))
+		s.RemoveSession("hedge")
+
+		require.NoError(t, s.StreamLogs(stream("orig", "line 1\n")))
+		assert.Equal(t, "line 1\n", w.GetBody())
+		// The copy may not stream anymore
+		err := s.StreamLogs(stream("hedge", "line 1\n"))
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		s.RemoveSession("orig")
+	})
+
+	t.Run("Started streams are not hedged", func(t *testing.T) {
+		s := NewServer()
+		require.NoError(t, s.RegisterHTTP("orig", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil)))
+		c := s.newLogClient(context.Background())
+		dataCh := make(chan *logstreamapi.LogStreamData, 1)
+		dataCh <- &logstreamapi.LogStreamData{RequestUuid: "orig"}
+		close(dataCh)
+		s.processLogStreamLoop(c, dataCh, make(chan error))
+
+		assert.False(t, s.Hedge("orig", "hedge"))
+		assert.False(t, s.Hedge("unknown", "hedge"))
+		s.RemoveSession("orig")
+	})
+}
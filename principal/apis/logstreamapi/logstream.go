@@ -15,11 +15,15 @@
 package logstream
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
@@ -33,13 +37,115 @@ type Server struct {
 	logstreamapi.UnimplementedLogStreamServiceServer
 	mu       sync.RWMutex
 	sessions map[string]*session
+	// finished holds the request IDs of recently finished log streams
+	finished map[string]finishedRequest
+
+	// sseHeartbeatInterval is the interval of keep-alive comments on SSE responses
+	sseHeartbeatInterval time.Duration
+	// sseRetry is the reconnection delay hint sent to SSE clients
+	sseRetry time.Duration
+	// maxRangeBufferSize limits how much log data is buffered to answer a
+	// Range request for static logs
+	maxRangeBufferSize int
+	// maxDownloadSize limits how many bytes are sent for a log download
+	maxDownloadSize int64
+	// maxStaticSize limits how many bytes are sent for other static logs
+	maxStaticSize int64
+	// staticStreamThreshold is how much of static logs is buffered before
+	// the response is streamed
+	staticStreamThreshold int
+	// spillDir is the directory of the spill buffers of follow streams, and
+	// spillSize their capacity. Follow streams write to their clients
+	// directly if spillDir is empty. spillTotal limits the capacity of all
+	// spill buffers together, spillUsed is the capacity in use.
+	spillDir   string
+	spillSize  int64
+	spillTotal int64
+	spillUsed  atomic.Int64
+	// resumeTimeout is how long an interrupted follow stream waits for the
+	// agent to resume it
+	resumeTimeout time.Duration
+	// onInterrupt is called when the agent's stream of a resumable follow
+	// stream is interrupted, if not nil
+	onInterrupt func(requestUUID string)
+	// onReject is called when a log stream is rejected, if not nil
+	onReject func(agentName, reason string)
+}
+
+// Option is a functional option for the LogStream server
+type Option func(s *Server)
+
+// WithMaxDownloadSize sets the maximum number of uncompressed bytes sent in
+// response to a log download (download=true). A value of 0 disables the limit.
+func WithMaxDownloadSize(size int64) Option {
+	return func(s *Server) {
+		s.maxDownloadSize = size
+	}
+}
+
+// WithMaxStaticSize sets the maximum number of bytes sent in response to a
+// request for static logs, other than downloads. Larger logs are refused with
+// 413 Content Too Large if the response has not been started yet, and are
+// truncated otherwise. A value of 0 disables the limit.
+func WithMaxStaticSize(size int64) Option {
+	return func(s *Server) {
+		s.maxStaticSize = size
+	}
+}
+
+// WithStaticStreamThreshold sets how many bytes of static logs are buffered
+// before the response is started. Logs up to this size are sent with a
+// Content-Length, larger logs are streamed as they arrive. A value of 0, the
+// default, streams all static logs.
+func WithStaticStreamThreshold(size int) Option {
+	return func(s *Server) {
+		s.staticStreamThreshold = size
+	}
 }
 
 type session struct {
+	// agent is the name of the agent the log request was sent to, if known
+	agent      string
 	hw         *httpWriter
 	completeCh chan bool // signaled on EOF (static logs)
 	cancelFn   context.CancelFunc
 	doneCh     chan struct{} // closed on finalization to stop watchdog goroutine
+	// handedOff is set when the stream was handed over to another principal
+	// instance, and the agent should continue streaming there.
+	handedOff bool
+	// adopted is set when the stream was handed over to us and its client
+	// has not re-attached yet. Log data received meanwhile is kept in pending.
+	adopted bool
+	pending []byte
+	// shared is set for static log streams which other clients may join,
+	// see Share. history holds the data received so far, for joining clients,
+	// until it exceeds the buffer size.
+	shared   bool
+	history  []byte
+	overflow bool
+	// eof is set once the agent has sent all logs
+	eof bool
+	// followers are the sessions of the clients which joined this stream,
+	// and leader is the session of the stream a follower joined.
+	followers []*session
+	leader    *session
+	// resumable is set for follow streams which survive the interruption of
+	// the agent's stream, see MarkResumable. interrupted is set while such a
+	// stream waits for the agent to resume it, and interruptions counts how
+	// often that happened.
+	resumable     bool
+	interrupted   bool
+	interruptions int
+	// lastTimestamp is the timestamp of the last complete line received for
+	// a resumable stream. Lines up to resumeAfter are dropped after a resume,
+	// because the agent sends them again.
+	lastTimestamp time.Time
+	resumeAfter   time.Time
+	// hedgeUUID is the request ID of a copy of this static log request, see
+	// Hedge. streamUUID is the request ID of the stream which started first
+	// and answers the client.
+	hedgeUUID  string
+	streamUUID string
 }
 
 // closeChannels safely closes doneCh and completeCh if open.
@@ -56,10 +162,214 @@ func (sess *session) closeChannels() {
 }
 
 type httpWriter struct {
+	// mu serializes writes from the log stream and the SSE heartbeat
+	mu      sync.Mutex
 	w       http.ResponseWriter
+	r       *http.Request
 	flusher http.Flusher
+	// sse is set when the client requested text/event-stream framing
+	sse *sseEncoder
+	// buf holds the logs of a Range request or of small static logs until
+	// the agent signals EOF. It is nil once the response has been started.
+	buf    *bytes.Buffer
+	maxBuf int
+	// dl is set when the client requested to download the logs as a file
+	dl *downloadWriter
+	// limit is the maximum number of bytes of static logs to send, if not
+	// zero. written counts the bytes sent so far.
+	limit   int64
+	written int64
+	// spill buffers the data of a follow stream until it is written to the
+	// client in the background. spillDone is closed once that has ended.
+	spill     *spillBuffer
+	spillDone chan struct{}
+}
+
+// write writes log data to the client, applying SSE framing if requested.
+// Follow streams with a spill buffer write the data to the buffer instead.
+func (hw *httpWriter) write(data []byte) (int, error) {
+	if hw.spill != nil {
+		if err := hw.spill.write(data); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return hw.writeLocked(data)
+}
+
+// writeLocked writes log data to the client. Caller must hold hw.mu.
+func (hw *httpWriter) writeLocked(data []byte) (int, error) {
+	if hw.limit > 0 {
+		if hw.written+int64(len(data)) > hw.limit {
+			return hw.cutOffLocked(data)
+		}
+		hw.written += int64(len(data))
+	}
+	if hw.buf != nil {
+		if hw.buf.Len()+len(data) <= hw.maxBuf {
+			return hw.buf.Write(data)
+		}
+		// Too large to buffer, so send the full logs instead of the range.
+		hw.w.WriteHeader(http.StatusOK)
+		buffered := hw.buf.Bytes()
+		hw.buf = nil
+		if _, err := hw.w.Write(buffered); err != nil {
+			return 0, err
+		}
+	}
+	if hw.dl != nil {
+		return hw.dl.write(data)
+	}
+	if hw.sse != nil {
+		data = hw.sse.encode(data)
+		if len(data) == 0 {
+			return 0, nil
+		}
+	}
+	return hw.w.Write(data)
+}
+
+// cutOffLocked ends a response whose logs exceed the size limit. A response
+// not started yet is refused with 413 Content Too Large, otherwise the logs
+// are truncated. Either way, the client is told how to get larger logs.
+// Caller must hold hw.mu.
+func (hw *httpWriter) cutOffLocked(data []byte) (int, error) {
+	if hw.written > hw.limit {
+		return 0, errStaticLimitReached
+	}
+	hint := "request them with follow=true or download=true"
+	if hw.buf != nil {
+		hw.buf = nil
+		hw.written = hw.limit + 1
+		http.Error(hw.w, fmt.Sprintf("Logs exceed %d bytes, %s", hw.limit, hint), http.StatusRequestEntityTooLarge)
+		return 0, errStaticLimitReached
+	}
+	n, err := hw.w.Write(data[:hw.limit-hw.written])
+	hw.written = hw.limit + 1
+	if err != nil {
+		return n, err
+	}
+	_, _ = fmt.Fprintf(hw.w, "\n[log truncated after %d bytes, %s]\n", hw.limit, hint)
+	return n, errStaticLimitReached
+}
+
+// flush flushes buffered data to the client.
+func (hw *httpWriter) flush() error {
+	if hw.spill != nil {
+		// Spilled data is flushed when it is written to the client
+		return nil
+	}
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if hw.buf != nil {
+		return nil
+	}
+	return safeFlush(hw.flusher)
+}
+
+// finish completes the response once the agent has sent all logs, reporting
+// the stream's stats if the agent sent them. SSE clients receive an "eof"
+// event, buffered Range requests are served and downloads are completed.
+func (hw *httpWriter) finish(stats *logstreamapi.LogStreamStats) {
+	hw.stopSpill()
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.writeStatsLocked(stats)
+	if hw.dl != nil {
+		_ = hw.dl.close()
+		_ = safeFlush(hw.flusher)
+		return
+	}
+	if hw.buf != nil {
+		http.ServeContent(hw.w, hw.r, "", time.Time{}, bytes.NewReader(hw.buf.Bytes()))
+		hw.buf = nil
+		return
+	}
+	if hw.sse != nil {
+		// Tell SSE clients that the stream is complete, so they don't reconnect
+		hw.writeEventLocked("eof", "")
+	}
+}
+
+// fail reports an error to the client if the response format allows it: SSE
+// clients receive an "error" event, buffered Range requests get an HTTP error
+// status and downloads end with the error message. It returns false for plain
+// text responses, whose status has already been sent.
+func (hw *httpWriter) fail(code int, msg string) bool {
+	hw.stopSpill()
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return hw.failLocked(code, msg)
+}
+
+// failRetryable is like fail, but also tells the client when to retry. SSE
+// clients receive a new retry hint and buffered Range requests a Retry-After
+// header.
+func (hw *httpWriter) failRetryable(code int, msg string, retryAfter time.Duration) bool {
+	hw.stopSpill()
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if hw.buf != nil {
+		hw.w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+	}
+	if hw.sse != nil {
+		_, _ = hw.w.Write(hw.sse.start(retryAfter))
+	}
+	return hw.failLocked(code, msg)
+}
+
+// failLocked reports an error to the client. Caller must hold hw.mu.
+func (hw *httpWriter) failLocked(code int, msg string) bool {
+	if hw.dl != nil {
+		hw.dl.fail(msg)
+		_ = safeFlush(hw.flusher)
+		return true
+	}
+	if hw.buf != nil {
+		http.Error(hw.w, msg, code)
+		hw.buf = nil
+		return true
+	}
+	if hw.sse != nil {
+		hw.writeEventLocked("error", msg)
+		return true
+	}
+	return false
+}
+
+// writeEventLocked writes a named SSE event, preceded by any pending partial
+// line. Caller must hold hw.mu.
+func (hw *httpWriter) writeEventLocked(name, data string) {
+	out := append(hw.sse.flush(), hw.sse.named(name, data)...)
+	if _, err := hw.w.Write(out); err == nil {
+		_ = safeFlush(hw.flusher)
+	}
 }
 
+// heartbeat writes an SSE comment to keep idle connections open.
+func (hw *httpWriter) heartbeat() error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if hw.sse == nil {
+		return nil
+	}
+	if _, err := hw.w.Write(hw.sse.comment("heartbeat")); err != nil {
+		return err
+	}
+	return safeFlush(hw.flusher)
+}
+
+// States of the HTTP writer reported to the agent when a log stream ends
+const (
+	writerStatusAttached     = "attached"
+	writerStatusDetached     = "detached"
+	writerStatusWriteFailed  = "write_failed"
+	writerStatusLimitReached = "limit_reached"
+	writerStatusHandedOff    = "handed_off"
+)
+
 type logClient struct {
 	mu           sync.Mutex
 	ctx          context.Context
@@ -67,6 +377,16 @@ type logClient struct {
 	logCtx       *logrus.Entry
 	requestID    string
 	terminateErr error // returned as stream status when set
+	// started, lines and bytes describe the stream for the agent
+	started time.Time
+	lines   int64
+	bytes   int64
+	// writerStatus is the state of the HTTP writer when the stream ended
+	writerStatus string
+	// detached is set when we ended the stream because its HTTP client went
+	// away. agentGone is set when the agent's side of the stream broke off.
+	detached  bool
+	agentGone bool
 }
 
 func (c *logClient) setTerminateErr(err error) {
@@ -77,42 +397,100 @@ func (c *logClient) setTerminateErr(err error) {
 	}
 }
 
+// detach ends the stream because its HTTP client went away
+func (c *logClient) detach() {
+	c.mu.Lock()
+	c.detached = true
+	c.mu.Unlock()
+	c.setTerminateErr(status.Error(codes.Canceled, "client detached timeout"))
+	c.cancelFn()
+}
+
+// setAgentGone records that the agent's side of the stream broke off,
+// unless we ended the stream
+func (c *logClient) setAgentGone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agentGone = !c.detached
+}
+
+func (c *logClient) setWriterStatus(writerStatus string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writerStatus == "" {
+		c.writerStatus = writerStatus
+	}
+}
+
+// response returns the response to send to the agent when the stream ends
+// with err, which may be nil.
+func (c *logClient) response(err error) *logstreamapi.LogStreamResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := &logstreamapi.LogStreamResponse{
+		RequestUuid:   c.requestID,
+		LinesReceived: int32(min(c.lines, math.MaxInt32)),
+		BytesReceived: c.bytes,
+		DurationMs:    time.Since(c.started).Milliseconds(),
+		WriterStatus:  c.writerStatus,
+	}
+	if err != nil {
+		st := status.Convert(err)
+		resp.ErrorCode = st.Code().String()
+		resp.Error = st.Message()
+	} else {
+		resp.Status = http.StatusOK
+	}
+	return resp
+}
+
 func (s *Server) newLogClient(ctx context.Context) *logClient {
 	cctx, cancel := context.WithCancel(ctx)
 	return &logClient{
 		ctx:      cctx,
 		cancelFn: cancel,
 		logCtx:   logrus.WithField("module", "LogStream"),
+		started:  time.Now(),
 	}
 }
 
-func NewServer() *Server {
+func NewServer(opts ...Option) *Server {
 	logrus.Info("Starting LogStream gRPC service")
-	return &Server{
-		sessions: make(map[string]*session),
+	s := &Server{
+		sessions:             make(map[string]*session),
+		finished:             make(map[string]finishedRequest),
+		sseHeartbeatInterval: defaultSSEHeartbeatInterval,
+		sseRetry:             defaultSSERetry,
+		maxRangeBufferSize:   defaultMaxRangeBufferSize,
+		maxDownloadSize:      DefaultMaxDownloadSize,
+		resumeTimeout:        DefaultResumeTimeout,
+	}
+	for _, o := range opts {
+		o(s)
 	}
+	return s
 }
 
-// RegisterHTTP registers an HTTP writer for a given request UUID
+// RegisterHTTP registers an HTTP writer for a given request UUID. If the
+// client accepts text/event-stream, log lines are framed as Server-Sent Events
+// and a Last-Event-ID header skips lines the client has already seen. Range
+// requests for static logs are buffered and answered once the logs are
+// complete. With download=true, static logs are sent as a file attachment,
+// gzip-compressed if the client accepts it and cut off at the size limit.
 func (s *Server) RegisterHTTP(requestUUID string, w http.ResponseWriter, r *http.Request) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		return status.Error(codes.FailedPrecondition, "writer does not support flushing")
+	hw, err := s.newHTTPWriter(w, r)
+	if err != nil {
+		return err
 	}
-	// streaming headers
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache, no-transform")
-	w.Header().Set("Connection", "keep-alive")
-	w.WriteHeader(http.StatusOK)
 
 	// upsert session
 	sess := s.sessions[requestUUID]
 	if sess == nil {
 		sess = &session{
-			hw:         &httpWriter{w: w, flusher: flusher},
+			hw:         hw,
 			completeCh: make(chan bool, 1),
 			doneCh:     make(chan struct{}),
 		}
@@ -125,35 +503,139 @@ func (s *Server) RegisterHTTP(requestUUID string, w http.ResponseWriter, r *http
 		}
 		sess.doneCh = make(chan struct{})
 
-		sess.hw = &httpWriter{w: w, flusher: flusher}
+		sess.hw = hw
+		// The client of an adopted stream re-attached, pass on what the agent
+		// sent in the meantime. We hold the lock, so this is written before
+		// any new data.
+		if sess.adopted {
+			if len(sess.pending) > 0 {
+				if _, err := hw.write(sess.pending); err == nil {
+					_ = hw.flush()
+				}
+			}
+			sess.adopted = false
+			sess.pending = nil
+		}
+	}
+
+	s.watch(requestUUID, r, sess.doneCh, hw)
+	return nil
+}
+
+// newHTTPWriter creates the writer for the response to r and sends the
+// response headers, unless the response is buffered.
+func (s *Server) newHTTPWriter(w http.ResponseWriter, r *http.Request) (*httpWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "writer does not support flushing")
+	}
+	hw := &httpWriter{w: w, r: r, flusher: flusher}
+	if IsEventStreamRequest(r) {
+		hw.sse = &sseEncoder{}
+		if ts, ok := LastEventTime(r); ok {
+			hw.sse.resumeAfter = ts
+		}
+	} else if isDownloadRequest(r) {
+		compress := acceptsGzip(r)
+		hw.dl = newDownloadWriter(w, compress, s.maxDownloadSize)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", downloadFilename(r)))
+		if compress {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+	} else if isRangeRequest(r) {
+		hw.buf = &bytes.Buffer{}
+		hw.maxBuf = s.maxRangeBufferSize
+		hw.limit = s.maxStaticSize
+	} else if isStaticRequest(r) {
+		// Small static logs are buffered, so they can be sent with a
+		// Content-Length, or refused when they exceed the size limit.
+		if s.staticStreamThreshold > 0 {
+			hw.buf = &bytes.Buffer{}
+			hw.maxBuf = s.staticStreamThreshold
+		}
+		hw.limit = s.maxStaticSize
+	}
+
+	// streaming headers
+	if hw.sse != nil {
+		w.Header().Set("Content-Type", eventStreamContentType)
+		// Prevent reverse proxies such as nginx from buffering the events
+		w.Header().Set("X-Accel-Buffering", "no")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if isStaticRequest(r) && hw.dl == nil {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+	}
+	w.Header().Set("Cache-Control", "no-cache, no-transform")
+	w.Header().Set("Connection", "keep-alive")
+	if hw.buf == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	if hw.sse != nil {
+		_, _ = w.Write(hw.sse.start(s.sseRetry))
+		_ = safeFlush(flusher)
+	}
+	// Follow streams don't wait for slow clients if they can spill
+	if s.spillDir != "" && !isStaticRequest(r) {
+		if b, err := s.newSpillBuffer(); err != nil {
+			logrus.WithError(err).Warn("Could not create spill buffer; writing logs to the client directly")
+		} else {
+			hw.startSpill(r.Context(), b)
+		}
+	}
+	return hw, nil
+}
+
+// watch starts the watchdog for the HTTP client of a session, which detaches
+// the writer when the client disconnects. It also sends heartbeats to SSE
+// clients. The watchdog ends once doneCh is closed.
+func (s *Server) watch(requestUUID string, r *http.Request, doneCh <-chan struct{}, hw *httpWriter) {
+	// SSE responses get periodic heartbeat comments from the watchdog.
+	var heartbeat *time.Ticker
+	if hw.sse != nil && s.sseHeartbeatInterval > 0 {
+		heartbeat = time.NewTicker(s.sseHeartbeatInterval)
 	}
 
 	// watchdog for client disconnection. When client disconnects, immediately cancel the stream.
 	// doneCh is passed as a parameter to avoid a data race with closeChannels setting it to nil.
-	go func(reqID string, done <-chan struct{}, doneCh <-chan struct{}) {
+	go func(reqID string, done <-chan struct{}, doneCh <-chan struct{}, heartbeat *time.Ticker) {
+		var tick <-chan time.Time
+		if heartbeat != nil {
+			defer heartbeat.Stop()
+			tick = heartbeat.C
+		}
 		// Wait for either client disconnection or session finalization
-		select {
-		case <-done:
-			logrus.WithFields(logrus.Fields{
-				"request_id": reqID,
-				"reason":     "client_disconnected",
-			}).Debug("HTTP client disconnected; canceling stream")
-		case <-doneCh:
-			// Session was finalized, watchdog is no longer needed
-			return
+	wait:
+		for {
+			select {
+			case <-done:
+				logrus.WithFields(logrus.Fields{
+					"request_id": reqID,
+					"reason":     "client_disconnected",
+				}).Debug("HTTP client disconnected; canceling stream")
+				break wait
+			case <-doneCh:
+				// Session was finalized, watchdog is no longer needed
+				return
+			case <-tick:
+				if err := hw.heartbeat(); err != nil {
+					logrus.WithField("request_id", reqID).WithError(err).Debug("SSE heartbeat failed")
+				}
+			}
 		}
 		s.mu.Lock()
 		if sess, ok := s.sessions[reqID]; ok {
 			sess.hw = nil
-			if sess.cancelFn != nil {
+			// Shared streams continue for the clients which joined them
+			if sess.cancelFn != nil && !sess.shared {
 				// Tag stream as canceled due to client detach.
 				sess.cancelFn()
 			}
 		}
 		s.mu.Unlock()
-	}(requestUUID, r.Context().Done(), sess.doneCh)
-
-	return nil
+	}(requestUUID, r.Context().Done(), doneCh, heartbeat)
 }
 
 // StreamLogs receives log data from agent
@@ -186,20 +668,46 @@ func (s *Server) StreamLogs(stream logstreamapi.LogStreamService_StreamLogsServe
 
 	s.processLogStreamLoop(c, dataCh, errCh)
 
-	// Cleanup session
+	// Cleanup session, unless it waits for the agent to resume it
+	c.mu.Lock()
+	agentGone := c.agentGone
+	c.mu.Unlock()
 	if c.requestID != "" {
-		s.finalizeSession(c.requestID)
+		c.setWriterStatus(s.writerStatus(c.requestID))
+		if !agentGone || !s.interrupt(c.requestID, c.logCtx) {
+			s.finalizeSession(c.requestID)
+		}
 	}
 	c.mu.Lock()
 	terr := c.terminateErr
 	c.mu.Unlock()
+	resp := c.response(terr)
 	if terr != nil {
+		// The response travels to the agent as a detail of the error status
+		if st, err := status.Convert(terr).WithDetails(resp); err == nil {
+			return st.Err()
+		}
 		return terr
 	}
-	resp := &logstreamapi.LogStreamResponse{RequestUuid: c.requestID, Status: http.StatusOK}
 	return stream.SendAndClose(resp)
 }
 
+// writerStatus returns the state of the HTTP writer of the given request
+func (s *Server) writerStatus(requestUUID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess := s.sessions[requestUUID]
+	switch {
+	case sess == nil:
+		return ""
+	case sess.handedOff:
+		return writerStatusHandedOff
+	case sess.hw == nil:
+		return writerStatusDetached
+	}
+	return writerStatusAttached
+}
+
 // processLogStreamLoop processes log data from agent and forwards to HTTP writer
 // It also handles client detachment and timeout.
 func (s *Server) processLogStreamLoop(c *logClient, dataCh <-chan *logstreamapi.LogStreamData, errCh <-chan error) {
@@ -208,12 +716,16 @@ func (s *Server) processLogStreamLoop(c *logClient, dataCh <-chan *logstreamapi.
 	for {
 		select {
 		case <-c.ctx.Done():
-			// Ensure we terminate promptly when HTTP client detaches.
+			// Ensure we terminate promptly when HTTP client detaches. The
+			// context is also done when the agent's stream broke off.
+			c.setAgentGone()
 			c.setTerminateErr(status.Error(codes.Canceled, "client detached timeout"))
+			c.setWriterStatus(writerStatusDetached)
 			return
 		case err := <-errCh:
 			// io.EOF means the client finished sending (normal close on the agent side).
 			if err != nil && err != io.EOF {
+				c.setAgentGone()
 				// Prefer a consistent reason for the agent on cancellations.
 				if status.Code(err) == codes.Canceled {
 					c.setTerminateErr(status.Error(codes.Canceled, "client detached timeout"))
@@ -225,6 +737,7 @@ func (s *Server) processLogStreamLoop(c *logClient, dataCh <-chan *logstreamapi.
 		case msg, ok := <-dataCh:
 			if !ok {
 				// Pump exited without delivering an error (likely due to ctx cancellation).
+				c.setAgentGone()
 				return
 			}
 			if msg == nil {
@@ -234,21 +747,39 @@ func (s *Server) processLogStreamLoop(c *logClient, dataCh <-chan *logstreamapi.
 
 			// First message, capture request UUID and expose cancelFn for detach handler
 			if c.requestID == "" {
+				c.logCtx = c.logCtx.WithField("request_id", msg.GetRequestUuid())
+				if err := s.authorize(c, msg.GetRequestUuid()); err != nil {
+					c.setTerminateErr(err)
+					return
+				}
+				s.mu.Lock()
+				sess, ok := s.sessions[msg.GetRequestUuid()]
+				if ok && !sess.claim(msg.GetRequestUuid()) {
+					s.mu.Unlock()
+					// The other copy of a hedged request answers the client
+					c.logCtx.Info("Log request is answered by another stream; terminating")
+					c.setTerminateErr(status.Error(codes.Canceled, "log request is answered by another stream"))
+					return
+				}
 				c.requestID = msg.GetRequestUuid()
-				c.logCtx = c.logCtx.WithField("request_id", c.requestID)
 				c.logCtx.Info("LogStream started")
-
-				s.mu.Lock()
-				if sess, ok := s.sessions[c.requestID]; ok {
-					sess.cancelFn = func() {
-						// tag this stream as terminated due to client detach
-						c.setTerminateErr(status.Error(codes.Canceled, "client detached timeout"))
-						c.cancelFn()
+				if ok {
+					// tag this stream as terminated due to client detach
+					sess.cancelFn = c.detach
+					if sess.interrupted {
+						sess.interrupted = false
+						c.logCtx.Info("LogStream resumed")
 					}
 				}
 				s.mu.Unlock()
 			}
 
+			if msg.GetRequestUuid() != c.requestID {
+				c.setTerminateErr(s.reject(c, streamAgent(c), RejectRequestIDChanged, "",
+					status.Errorf(codes.InvalidArgument, "request id changed to %s during the stream", msg.GetRequestUuid())))
+				return
+			}
+
 			if err := s.processLogMessage(c, msg); err != nil {
 				// processLogMessage can return io.EOF or a terminal status
 				if err == io.EOF {
@@ -273,19 +804,25 @@ func safeFlush(f http.Flusher) (err error) {
 }
 
 // clearWriterAndCancel clears the HTTP writer and invokes the cancel function.
-// Used when write/flush fails or client disconnects.
-func (s *Server) clearWriterAndCancel(reqID string) {
+// Used when write/flush fails or client disconnects. Shared streams continue
+// for the clients which joined them, in which case it returns false.
+func (s *Server) clearWriterAndCancel(reqID string) bool {
 	s.mu.Lock()
 	sess := s.sessions[reqID]
 	var cancel context.CancelFunc
 	if sess != nil {
 		sess.hw = nil
+		if sess.shared {
+			s.mu.Unlock()
+			return false
+		}
 		cancel = sess.cancelFn
 	}
 	s.mu.Unlock()
 	if cancel != nil {
 		cancel()
 	}
+	return true
 }
 
 func (s *Server) processLogMessage(c *logClient, msg *logstreamapi.LogStreamData) error {
@@ -300,15 +837,42 @@ func (s *Server) processLogMessage(c *logClient, msg *logstreamapi.LogStreamData
 		logCtx.Warn("received data for unknown request; terminating")
 		return status.Error(codes.NotFound, "unknown request id")
 	}
+	s.mu.RLock()
+	handedOff := sess.handedOff
+	s.mu.RUnlock()
+	if handedOff {
+		// The agent retries on this error, and reaches the instance that
+		// took over the stream.
+		logCtx.Info("Log stream was handed over; terminating")
+		return status.Error(codes.Unavailable, "log stream was handed over to another principal instance")
+	}
 
 	// Agent forwarded error
 	if msg.GetError() != "" {
 		logCtx.WithField("error", msg.GetError()).Warn("log stream error from agent")
+		s.mu.RLock()
+		hw := sess.hw
+		s.mu.RUnlock()
+		if hw != nil {
+			hw.writeStats(msg.GetStats())
+			hw.fail(http.StatusBadGateway, msg.GetError())
+		}
+		for _, f := range s.followerWriters(sess) {
+			f.writeStats(msg.GetStats())
+			f.fail(http.StatusBadGateway, msg.GetError())
+		}
 		return status.Error(codes.Internal, msg.GetError())
 	}
 	// EOF
 	if msg.GetEof() {
 		logCtx.Info("LogStream EOF")
+		s.mu.RLock()
+		hw := sess.hw
+		s.mu.RUnlock()
+		if hw != nil {
+			hw.finish(msg.GetStats())
+		}
+		s.finishFollowers(sess, msg.GetStats())
 		s.mu.Lock()
 		if sess, ok := s.sessions[reqID]; ok {
 			// Close doneCh FIRST to stop watchdog before HTTP handler returns.
@@ -333,7 +897,28 @@ func (s *Server) processLogMessage(c *logClient, msg *logstreamapi.LogStreamData
 	if len(data) == 0 {
 		return nil
 	}
+	// Drop the lines a resumed stream sends again
+	if data = s.trackResume(sess, data); len(data) == 0 {
+		return nil
+	}
 	logCtx.WithField("data_length", len(data)).Trace("data received")
+	c.mu.Lock()
+	c.lines += int64(bytes.Count(data, []byte{'\n'}))
+	c.bytes += int64(len(data))
+	c.mu.Unlock()
+
+	// Pass the data on to the clients which joined a shared stream
+	shared := s.shareData(sess, data)
+	for _, f := range shared.followers {
+		if _, err := f.hw.write(data); err != nil {
+			_ = f.hw.flush()
+			s.detachFollower(f.sess)
+			continue
+		}
+		if err := f.hw.flush(); err != nil {
+			s.detachFollower(f.sess)
+		}
+	}
 
 	// Get current writer
 	s.mu.RLock()
@@ -342,20 +927,42 @@ func (s *Server) processLogMessage(c *logClient, msg *logstreamapi.LogStreamData
 
 	// If writer is gone, end the stream (vanilla semantics: new request will be created)
 	if hw == nil {
+		if s.keepPending(reqID, data) {
+			logCtx.WithField("data_length", len(data)).Trace("Keeping data until client re-attaches")
+			return nil
+		}
+		if shared.keep {
+			logCtx.WithField("data_length", len(data)).Trace("Keeping data for clients joining the stream")
+			return nil
+		}
 		logCtx.Info("HTTP writer missing; terminating stream")
 		return status.Error(codes.Canceled, "client disconnected")
 	}
 
 	// Write data and flush; on failure, clear writer and cancel stream
-	if _, err := hw.w.Write(data); err != nil {
+	if _, err := hw.write(data); err == errDownloadLimitReached || err == errStaticLimitReached {
+		logCtx.Infof("%v; canceling stream", err)
+		c.setWriterStatus(writerStatusLimitReached)
+		_ = hw.flush()
+		if s.clearWriterAndCancel(reqID) {
+			return status.Error(codes.Canceled, err.Error())
+		}
+		return nil
+	} else if err != nil {
 		logCtx.WithError(err).Warn("HTTP write failed; canceling stream")
-		s.clearWriterAndCancel(reqID)
-		return status.Error(codes.Canceled, "HTTP write failed")
+		c.setWriterStatus(writerStatusWriteFailed)
+		if s.clearWriterAndCancel(reqID) {
+			return status.Error(codes.Canceled, "HTTP write failed")
+		}
+		return nil
 	}
-	if err := safeFlush(hw.flusher); err != nil {
+	if err := hw.flush(); err != nil {
 		logCtx.WithError(err).Warn("HTTP flush failed; canceling stream")
-		s.clearWriterAndCancel(reqID)
-		return status.Error(codes.Canceled, "HTTP flush failed")
+		c.setWriterStatus(writerStatusWriteFailed)
+		if s.clearWriterAndCancel(reqID) {
+			return status.Error(codes.Canceled, "HTTP flush failed")
+		}
+		return nil
 	}
 	logCtx.WithField("data_length", len(data)).Trace("HTTP write and flush successful")
 	return nil
@@ -381,18 +988,134 @@ func (s *Server) WaitForCompletion(requestUUID string, timeout time.Duration) bo
 	}
 }
 
-// RemoveSession removes a session if it exists.
+// WriteError reports an error, such as a timeout, to the HTTP client of the
+// given request if its response format allows it. SSE clients receive an
+// "error" event and buffered Range requests get the given HTTP status code.
+// It returns false if the error could not be reported, e.g. because the
+// client receives plain text logs and the status has already been sent.
+func (s *Server) WriteError(requestUUID string, code int, msg string) bool {
+	s.mu.RLock()
+	var hw *httpWriter
+	if sess := s.sessions[requestUUID]; sess != nil {
+		hw = sess.hw
+	}
+	s.mu.RUnlock()
+	if hw == nil {
+		return false
+	}
+	return hw.fail(code, msg)
+}
+
+// WriteUnavailable reports to the HTTP client of the given request that the
+// principal is going away, like WriteError with 503 Service Unavailable. The
+// client is told to retry after the given delay.
+func (s *Server) WriteUnavailable(requestUUID string, retryAfter time.Duration, msg string) bool {
+	s.mu.RLock()
+	var hw *httpWriter
+	if sess := s.sessions[requestUUID]; sess != nil {
+		hw = sess.hw
+	}
+	s.mu.RUnlock()
+	if hw == nil {
+		return false
+	}
+	return hw.failRetryable(http.StatusServiceUnavailable, msg, retryAfter)
+}
+
+// keepPending keeps data for an adopted session until its client re-attaches.
+// It returns false if the session is not adopted, or if the data exceeds the
+// buffer size, in which case the stream is given up.
+func (s *Server) keepPending(requestUUID string, data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[requestUUID]
+	if sess == nil || !sess.adopted {
+		return false
+	}
+	if len(sess.pending)+len(data) > s.maxRangeBufferSize {
+		return false
+	}
+	sess.pending = append(sess.pending, data...)
+	return true
+}
+
+// HandOff hands the session of a follow log stream over to another principal
+// instance. The client is told to reconnect after retryAfter, and the agent
+// is told to continue streaming elsewhere when it sends more data. Returns
+// false if there is no such session.
+func (s *Server) HandOff(requestUUID string, retryAfter time.Duration, msg string) bool {
+	s.mu.Lock()
+	sess := s.sessions[requestUUID]
+	if sess == nil {
+		s.mu.Unlock()
+		return false
+	}
+	hw := sess.hw
+	sess.hw = nil
+	sess.handedOff = true
+	// Stop the watchdog, so the client disconnecting doesn't cancel the
+	// agent's stream.
+	if sess.doneCh != nil {
+		close(sess.doneCh)
+		sess.doneCh = nil
+	}
+	s.mu.Unlock()
+	if hw != nil {
+		hw.failRetryable(http.StatusServiceUnavailable, msg, retryAfter)
+	}
+	return true
+}
+
+// Adopt creates the session for a follow log stream handed over by another
+// principal instance. The agent agentName continues streaming under
+// requestUUID, and the client re-attaches with RegisterHTTP.
+func (s *Server) Adopt(requestUUID, agentName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[requestUUID]; ok {
+		return
+	}
+	s.sessions[requestUUID] = &session{
+		agent:      agentName,
+		completeCh: make(chan bool, 1),
+		adopted:    true,
+	}
+}
+
+// IsAdopted returns whether the session was handed over to us and is still
+// waiting for its client to re-attach.
+func (s *Server) IsAdopted(requestUUID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess := s.sessions[requestUUID]
+	return sess != nil && sess.adopted
+}
+
+// RemoveSession removes a session if it exists. Sessions which were handed
+// over to another principal instance are kept until the agent's stream ends.
 func (s *Server) RemoveSession(requestUUID string) {
+	s.mu.RLock()
+	sess := s.sessions[requestUUID]
+	handedOff := sess != nil && sess.handedOff
+	s.mu.RUnlock()
+	if handedOff {
+		return
+	}
 	s.finalizeSession(requestUUID)
 }
 
 func (s *Server) finalizeSession(requestUUID string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if sess := s.sessions[requestUUID]; sess != nil {
+	if sess := s.sessions[requestUUID]; sess != nil && sess.isIdleHedge(requestUUID) {
+		// The other copy of the hedged request answers the client
+		s.rememberFinishedLocked(requestUUID, sess.agent)
+	} else if sess != nil {
 		// closeChannels unblocks WaitForCompletion and stops watchdog.
 		// Channels may already be closed from EOF handling.
 		sess.closeChannels()
+		s.unlinkLocked(sess)
+		s.rememberFinishedLocked(requestUUID, sess.agent)
 	}
 	delete(s.sessions, requestUUID)
 }
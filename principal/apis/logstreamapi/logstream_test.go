@@ -137,6 +137,39 @@ func TestStreamLogs(t *testing.T) {
 		assert.Contains(t, body, "test log line 2")
 	})
 
+	t.Run("response reports what the principal received", func(t *testing.T) {
+		w := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, server.RegisterHTTP(requestUUID, w, httptest.NewRequest("GET", "/logs", nil)))
+
+		mockStream := mock.NewMockLogStreamServer(context.Background())
+		mockStream.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Data: []byte("line 1\nline 2\n")})
+		mockStream.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true})
+		require.NoError(t, server.StreamLogs(mockStream))
+
+		resp := mockStream.GetResponse()
+		require.NotNil(t, resp)
+		assert.Equal(t, int32(http.StatusOK), resp.GetStatus())
+		assert.Equal(t, int32(2), resp.GetLinesReceived())
+		assert.Equal(t, int64(14), resp.GetBytesReceived())
+		assert.Empty(t, resp.GetErrorCode())
+		assert.Equal(t, writerStatusAttached, resp.GetWriterStatus())
+	})
+
+	t.Run("error status carries the response", func(t *testing.T) {
+		mockStream := mock.NewMockLogStreamServer(context.Background())
+		mockStream.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: "unknown-request", Data: []byte("line\n")})
+		err := server.StreamLogs(mockStream)
+		require.Error(t, err)
+
+		details := status.Convert(err).Details()
+		require.Len(t, details, 1)
+		resp, ok := details[0].(*logstreamapi.LogStreamResponse)
+		require.True(t, ok)
+		assert.Equal(t, codes.NotFound.String(), resp.GetErrorCode())
+		assert.Equal(t, "unknown request id", resp.GetError())
+		assert.Zero(t, resp.GetStatus())
+	})
+
 	t.Run("stream with error from agent", func(t *testing.T) {
 		// Register HTTP session first
 		w := mock.NewMockHTTPResponseWriter()
@@ -489,6 +522,65 @@ func TestFinalizeSession(t *testing.T) {
 	assert.False(t, exists)
 }
 
+func TestHandOff(t *testing.T) {
+	t.Run("Handed over session tells the agent to continue elsewhere", func(t *testing.T) {
+		server := NewServer()
+		w := mock.NewMockHTTPResponseWriter()
+		r := httptest.NewRequest("GET", "/logs?follow=true", nil)
+		r.Header.Set("Accept", "text/event-stream")
+		require.NoError(t, server.RegisterHTTP("stream", w, r))
+
+		assert.True(t, server.HandOff("stream", 2*time.Second, "shutting down"))
+		assert.False(t, server.HandOff("unknown", 2*time.Second, "shutting down"))
+		assert.Contains(t, w.GetBody(), "retry: 2000\n\nevent: error\ndata: shutting down\n\n")
+
+		// The session outlives the HTTP handler
+		server.RemoveSession("stream")
+		client := server.newLogClient(context.Background())
+		err := server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "stream", Data: []byte("line\n")})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+
+		server.finalizeSession("stream")
+		err = server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "stream", Data: []byte("line\n")})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Adopted session keeps data until the client re-attaches", func(t *testing.T) {
+		server := NewServer()
+		server.sseHeartbeatInterval = 0
+		server.Adopt("stream", "agent")
+		assert.True(t, server.IsAdopted("stream"))
+
+		client := server.newLogClient(context.Background())
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "stream", Data: []byte("2025-12-07T10:30:45Z line 1\n")}))
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "stream", Data: []byte("2025-12-07T10:30:46Z line 2\n")}))
+
+		w := mock.NewMockHTTPResponseWriter()
+		r := httptest.NewRequest("GET", "/logs?follow=true", nil)
+		r.Header.Set("Accept", "text/event-stream")
+		r.Header.Set("Last-Event-ID", "2025-12-07T10:30:45Z")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, server.RegisterHTTP("stream", w, r.WithContext(ctx)))
+		assert.False(t, server.IsAdopted("stream"))
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "stream", Data: []byte("2025-12-07T10:30:47Z line 3\n")}))
+
+		body := w.GetBody()
+		assert.NotContains(t, body, "line 1")
+		assert.Contains(t, body, "data: 2025-12-07T10:30:46Z line 2\n\nid: 2025-12-07T10:30:47Z\ndata: 2025-12-07T10:30:47Z line 3\n\n")
+		server.RemoveSession("stream")
+	})
+
+	t.Run("Adopted session is given up when its buffer is exceeded", func(t *testing.T) {
+		server := NewServer()
+		server.maxRangeBufferSize = 8
+		server.Adopt("stream", "agent")
+		client := server.newLogClient(context.Background())
+		err := server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "stream", Data: []byte("a long line\n")})
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+}
+
 func TestSafeFlush(t *testing.T) {
 	t.Run("successful flush", func(t *testing.T) {
 		w := mock.NewMockHTTPResponseWriter()
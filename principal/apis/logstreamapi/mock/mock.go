@@ -36,6 +36,7 @@ type MockLogStreamServer struct {
 	sendError error
 	mu        sync.Mutex
 	closed    bool
+	resp      *logstreamapi.LogStreamResponse
 }
 
 func NewMockLogStreamServer(ctx context.Context) *MockLogStreamServer {
@@ -81,9 +82,17 @@ func (m *MockLogStreamServer) SendAndClose(resp *logstreamapi.LogStreamResponse)
 	}
 
 	m.closed = true
+	m.resp = resp
 	return m.sendError
 }
 
+// GetResponse returns the response sent with SendAndClose, if any
+func (m *MockLogStreamServer) GetResponse() *logstreamapi.LogStreamResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resp
+}
+
 func (m *MockLogStreamServer) AddRecvData(data *logstreamapi.LogStreamData) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -0,0 +1,51 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxRangeBufferSize is the maximum amount of log data buffered to
+// answer a Range request. Larger logs are sent in full, ignoring the Range
+// header, which is permitted by RFC 9110.
+const defaultMaxRangeBufferSize = 16 * 1024 * 1024
+
+// DefaultStaticStreamThreshold is the suggested number of bytes of static logs
+// buffered before the response is started, see WithStaticStreamThreshold.
+const DefaultStaticStreamThreshold = 64 * 1024
+
+// errStaticLimitReached is returned when static logs exceeded their size
+// limit.
+var errStaticLimitReached = errors.New("static log size limit reached")
+
+// isStaticRequest returns true if the request is for static logs, i.e. the
+// response ends once the agent has sent all existing log lines.
+func isStaticRequest(r *http.Request) bool {
+	return !strings.EqualFold(r.URL.Query().Get("follow"), "true")
+}
+
+// isRangeRequest returns true if the client asked for a byte range of static
+// logs, e.g. to resume an interrupted download. Since the total size of the
+// logs isn't known upfront, such responses are buffered and served once the
+// agent signals EOF.
+func isRangeRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || !isStaticRequest(r) || IsEventStreamRequest(r) {
+		return false
+	}
+	return strings.HasPrefix(r.Header.Get("Range"), "bytes=")
+}
@@ -0,0 +1,209 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRangeRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/logs", nil)
+	assert.False(t, isRangeRequest(r))
+	r.Header.Set("Range", "bytes=10-")
+	assert.True(t, isRangeRequest(r))
+
+	r = httptest.NewRequest("GET", "/logs?follow=true", nil)
+	r.Header.Set("Range", "bytes=10-")
+	assert.False(t, isRangeRequest(r))
+
+	r = httptest.NewRequest("GET", "/logs", nil)
+	r.Header.Set("Range", "bytes=10-")
+	r.Header.Set("Accept", "text/event-stream")
+	assert.False(t, isRangeRequest(r))
+}
+
+func sendLogs(t *testing.T, server *Server, requestUUID string, chunks ...string) {
+	t.Helper()
+	client := server.newLogClient(t.Context())
+	client.requestID = requestUUID
+	for _, c := range chunks {
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: requestUUID, Data: []byte(c)}))
+	}
+	require.Equal(t, io.EOF, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true}))
+}
+
+func TestRegisterHTTP_Range(t *testing.T) {
+	t.Run("serves requested range on EOF", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Range", "bytes=6-")
+		require.NoError(t, server.RegisterHTTP("range", w, r))
+		defer server.RemoveSession("range")
+
+		sendLogs(t, server, "range", "line1\n", "line2\n")
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes 6-11/12", w.Header().Get("Content-Range"))
+		assert.Equal(t, "line2\n", w.Body.String())
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Range", "bytes=100-")
+		require.NoError(t, server.RegisterHTTP("range", w, r))
+		defer server.RemoveSession("range")
+
+		sendLogs(t, server, "range", "line1\n")
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	})
+
+	t.Run("falls back to full response when buffer is exceeded", func(t *testing.T) {
+		server := NewServer()
+		server.maxRangeBufferSize = 8
+		w := mock.NewMockHTTPResponseWriter()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Range", "bytes=6-")
+		require.NoError(t, server.RegisterHTTP("range", w, r))
+		defer server.RemoveSession("range")
+
+		sendLogs(t, server, "range", "line1\n", "line2\n")
+		assert.Equal(t, http.StatusOK, w.GetStatusCode())
+		assert.Equal(t, "line1\nline2\n", w.GetBody())
+	})
+
+	t.Run("timeout is reported as error status", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Range", "bytes=6-")
+		require.NoError(t, server.RegisterHTTP("range", w, r))
+		defer server.RemoveSession("range")
+
+		assert.True(t, server.WriteError("range", http.StatusGatewayTimeout, "timeout"))
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("shutdown is reported as retryable error status", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Range", "bytes=6-")
+		require.NoError(t, server.RegisterHTTP("range", w, r))
+		defer server.RemoveSession("range")
+
+		assert.True(t, server.WriteUnavailable("range", 1500*time.Millisecond, "shutting down"))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("static responses advertise range support", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		require.NoError(t, server.RegisterHTTP("plain", w, httptest.NewRequest("GET", "/logs", nil)))
+		defer server.RemoveSession("plain")
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	})
+}
+
+func TestRegisterHTTP_Static(t *testing.T) {
+	t.Run("small logs are sent at once", func(t *testing.T) {
+		server := NewServer(WithStaticStreamThreshold(64))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		require.NoError(t, server.RegisterHTTP("static", w, r))
+		defer server.RemoveSession("static")
+
+		sendLogs(t, server, "static", "line1\n", "line2\n")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "12", w.Header().Get("Content-Length"))
+		assert.Equal(t, "line1\nline2\n", w.Body.String())
+	})
+
+	t.Run("larger logs are streamed", func(t *testing.T) {
+		server := NewServer(WithStaticStreamThreshold(8))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		require.NoError(t, server.RegisterHTTP("static", w, r))
+		defer server.RemoveSession("static")
+
+		client := server.newLogClient(t.Context())
+		client.requestID = "static"
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "static", Data: []byte("line1\n")}))
+		assert.Empty(t, w.Body.String())
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "static", Data: []byte("line2\n")}))
+		assert.Equal(t, "line1\nline2\n", w.Body.String())
+		assert.Empty(t, w.Header().Get("Content-Length"))
+	})
+
+	t.Run("logs exceeding the limit before the response started are refused", func(t *testing.T) {
+		server := NewServer(WithStaticStreamThreshold(64), WithMaxStaticSize(8))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		require.NoError(t, server.RegisterHTTP("static", w, r))
+		defer server.RemoveSession("static")
+
+		client := server.newLogClient(t.Context())
+		client.requestID = "static"
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "static", Data: []byte("line1\n")}))
+		err := server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "static", Data: []byte("line2\n")})
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Contains(t, w.Body.String(), "follow=true or download=true")
+		assert.NotContains(t, w.Body.String(), "line1")
+	})
+
+	t.Run("streamed logs are cut off at the limit", func(t *testing.T) {
+		server := NewServer(WithMaxStaticSize(8))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		require.NoError(t, server.RegisterHTTP("static", w, r))
+		defer server.RemoveSession("static")
+
+		client := server.newLogClient(t.Context())
+		client.requestID = "static"
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "static", Data: []byte("line1\n")}))
+		err := server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "static", Data: []byte("line2\n")})
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Body.String(), "line1\nli\n[log truncated after 8 bytes"))
+	})
+
+	t.Run("follow streams are not limited", func(t *testing.T) {
+		server := NewServer(WithStaticStreamThreshold(64), WithMaxStaticSize(8))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs?follow=true", nil)
+		require.NoError(t, server.RegisterHTTP("follow", w, r))
+		defer server.RemoveSession("follow")
+
+		client := server.newLogClient(t.Context())
+		client.requestID = "follow"
+		require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: "follow", Data: []byte("line1\nline2\n")}))
+		assert.Equal(t, "line1\nline2\n", w.Body.String())
+	})
+}
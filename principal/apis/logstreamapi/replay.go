@@ -0,0 +1,127 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// finishedRetention is how long the request IDs of finished log streams are
+// remembered, to tell replays of them from unknown request IDs
+const finishedRetention = 10 * time.Minute
+
+// Reasons for rejecting a log stream, as passed to the reject callback
+const (
+	// RejectUnknown is a request ID that was never registered
+	RejectUnknown = "unknown"
+	// RejectReplayed is the request ID of a log stream that has finished
+	RejectReplayed = "replayed"
+	// RejectAgentMismatch is a request ID that was sent to another agent
+	RejectAgentMismatch = "agent_mismatch"
+	// RejectRequestIDChanged is a stream changing its request ID
+	RejectRequestIDChanged = "request_id_changed"
+)
+
+// finishedRequest records the end of a log stream
+type finishedRequest struct {
+	agent string
+	at    time.Time
+}
+
+// WithOnReject sets a function called with the name of the agent and the
+// reason when a log stream is rejected
+func WithOnReject(fn func(agentName, reason string)) Option {
+	return func(s *Server) {
+		s.onReject = fn
+	}
+}
+
+// BindAgent records that the log request requestUUID was sent to the agent
+// agentName. Only that agent may then stream the logs of the request.
+// Returns false if there is no session for the request.
+func (s *Server) BindAgent(requestUUID, agentName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[requestUUID]
+	if ok {
+		sess.agent = agentName
+	}
+	return ok
+}
+
+// streamAgent returns the name of the agent a log stream comes from, which
+// the authentication of the stream stored in its context
+func streamAgent(c *logClient) string {
+	agentName, _ := c.ctx.Value(types.ContextAgentIdentifier).(string)
+	return agentName
+}
+
+// authorize checks that the log stream c may send the logs of requestUUID,
+// which must be registered and bound to the agent of the stream, if bound
+// at all
+func (s *Server) authorize(c *logClient, requestUUID string) error {
+	agentName := streamAgent(c)
+	s.mu.RLock()
+	sess, ok := s.sessions[requestUUID]
+	var expected string
+	if ok {
+		expected = sess.agent
+	}
+	finished, wasFinished := s.finished[requestUUID]
+	s.mu.RUnlock()
+
+	switch {
+	case !ok && wasFinished:
+		return s.reject(c, agentName, RejectReplayed, finished.agent,
+			status.Errorf(codes.NotFound, "log request %s finished %s ago", requestUUID, time.Since(finished.at).Round(time.Second)))
+	case !ok:
+		return s.reject(c, agentName, RejectUnknown, "", status.Error(codes.NotFound, "unknown request id"))
+	case expected != "" && agentName != expected:
+		return s.reject(c, agentName, RejectAgentMismatch, expected,
+			status.Errorf(codes.PermissionDenied, "log request %s was not sent to this agent", requestUUID))
+	}
+	return nil
+}
+
+// reject logs the rejection of log stream c for auditing, and returns err
+func (s *Server) reject(c *logClient, agentName, reason, expectedAgent string, err error) error {
+	c.logCtx.WithFields(logrus.Fields{
+		"agent":          agentName,
+		"expected_agent": expectedAgent,
+		"reason":         reason,
+	}).Warnf("Rejected log stream: %v", status.Convert(err).Message())
+	if s.onReject != nil {
+		s.onReject(agentName, reason)
+	}
+	return err
+}
+
+// rememberFinishedLocked records that the log stream of requestUUID has
+// finished, and forgets the streams finished before the retention period.
+// Must be called with the lock held.
+func (s *Server) rememberFinishedLocked(requestUUID, agentName string) {
+	now := time.Now()
+	for id, f := range s.finished {
+		if now.Sub(f.at) > finishedRetention {
+			delete(s.finished, id)
+		}
+	}
+	s.finished[requestUUID] = finishedRequest{agent: agentName, at: now}
+}
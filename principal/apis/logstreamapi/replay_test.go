@@ -0,0 +1,132 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// agentLogStream returns a log stream of the agent agentName sending data
+func agentLogStream(agentName string, data ...*logstreamapi.LogStreamData) *mock.MockLogStreamServer {
+	stream := mock.NewMockLogStreamServer(context.WithValue(context.Background(), types.ContextAgentIdentifier, agentName))
+	for _, d := range data {
+		stream.AddRecvData(d)
+	}
+	return stream
+}
+
+func Test_StreamRequestIDValidation(t *testing.T) {
+	type rejection struct{ agent, reason string }
+	newServer := func(t *testing.T, requestUUID string) (*Server, *mock.MockHTTPResponseWriter, *[]rejection) {
+		t.Helper()
+		var rejected []rejection
+		server := NewServer(WithOnReject(func(agentName, reason string) {
+			rejected = append(rejected, rejection{agentName, reason})
+		}))
+		w := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, server.RegisterHTTP(requestUUID, w, httptest.NewRequest("GET", "/logs", nil)))
+		require.True(t, server.BindAgent(requestUUID, "agent-a"))
+		return server, w, &rejected
+	}
+	eof := func(requestUUID string) *logstreamapi.LogStreamData {
+		return &logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true}
+	}
+
+	t.Run("Agent the request was sent to", func(t *testing.T) {
+		server, w, rejected := newServer(t, "req-1")
+		require.NoError(t, server.StreamLogs(agentLogStream("agent-a", logData("req-1", "line\n"), eof("req-1"))))
+		assert.Equal(t, "line\n", w.GetBody())
+		assert.Empty(t, *rejected)
+	})
+
+	t.Run("Another agent", func(t *testing.T) {
+		server, w, rejected := newServer(t, "req-1")
+		err := server.StreamLogs(agentLogStream("agent-b", logData("req-1", "line\n"), eof("req-1")))
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Empty(t, w.GetBody())
+		assert.Equal(t, []rejection{{"agent-b", RejectAgentMismatch}}, *rejected)
+		// The session remains for the right agent
+		require.NoError(t, server.StreamLogs(agentLogStream("agent-a", logData("req-1", "line\n"), eof("req-1"))))
+		assert.Equal(t, "line\n", w.GetBody())
+	})
+
+	t.Run("Stream without an agent", func(t *testing.T) {
+		server, _, rejected := newServer(t, "req-1")
+		stream := mock.NewMockLogStreamServer(context.Background())
+		stream.AddRecvData(logData("req-1", "line\n"))
+		assert.Equal(t, codes.PermissionDenied, status.Code(server.StreamLogs(stream)))
+		assert.Equal(t, []rejection{{"", RejectAgentMismatch}}, *rejected)
+	})
+
+	t.Run("Replay of a finished request", func(t *testing.T) {
+		server, _, rejected := newServer(t, "req-1")
+		require.NoError(t, server.StreamLogs(agentLogStream("agent-a", logData("req-1", "line\n"), eof("req-1"))))
+		server.RemoveSession("req-1")
+		err := server.StreamLogs(agentLogStream("agent-a", logData("req-1", "line\n")))
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "finished")
+		assert.Equal(t, []rejection{{"agent-a", RejectReplayed}}, *rejected)
+	})
+
+	t.Run("Unknown request", func(t *testing.T) {
+		server, _, rejected := newServer(t, "req-1")
+		err := server.StreamLogs(agentLogStream("agent-a", logData("req-2", "line\n")))
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, []rejection{{"agent-a", RejectUnknown}}, *rejected)
+	})
+
+	t.Run("Request ID changing during the stream", func(t *testing.T) {
+		server, w, rejected := newServer(t, "req-1")
+		require.NoError(t, server.RegisterHTTP("req-2", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil)))
+		err := server.StreamLogs(agentLogStream("agent-a", logData("req-1", "one\n"), logData("req-2", "two\n")))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, "one\n", w.GetBody())
+		assert.Equal(t, []rejection{{"agent-a", RejectRequestIDChanged}}, *rejected)
+	})
+
+	t.Run("Adopted stream is bound to its agent", func(t *testing.T) {
+		server := NewServer()
+		server.Adopt("req-1", "agent-a")
+		err := server.StreamLogs(agentLogStream("agent-b", logData("req-1", "line\n")))
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("Finished requests are forgotten after the retention period", func(t *testing.T) {
+		server := NewServer()
+		server.mu.Lock()
+		server.finished["old"] = finishedRequest{agent: "agent-a", at: time.Now().Add(-finishedRetention - time.Second)}
+		server.rememberFinishedLocked("new", "agent-a")
+		_, old := server.finished["old"]
+		_, recent := server.finished["new"]
+		server.mu.Unlock()
+		assert.False(t, old)
+		assert.True(t, recent)
+	})
+
+	t.Run("Binding requires a session", func(t *testing.T) {
+		assert.False(t, NewServer().BindAgent("req-1", "agent-a"))
+	})
+}
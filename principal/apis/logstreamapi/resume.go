@@ -0,0 +1,172 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultResumeTimeout is how long an interrupted follow stream waits for
+// the agent to resume it by default
+const DefaultResumeTimeout = 2 * time.Minute
+
+// WithResumeTimeout sets how long a follow stream whose agent went away,
+// e.g. during a failover to another agent replica, waits for the agent to
+// resume it. Afterwards, the client is told to retry.
+func WithResumeTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.resumeTimeout = d
+	}
+}
+
+// WithOnInterrupt sets a function to be called with the request UUID when
+// the agent's stream of a resumable follow stream is interrupted. The
+// function must not block.
+func WithOnInterrupt(fn func(requestUUID string)) Option {
+	return func(s *Server) {
+		s.onInterrupt = fn
+	}
+}
+
+// MarkResumable lets the follow stream of the given request survive the
+// interruption of the agent's stream, so that the agent, or another replica
+// of it, can resume the stream under the same request UUID. The client stays
+// attached meanwhile. Returns false if there is no such session.
+func (s *Server) MarkResumable(requestUUID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[requestUUID]
+	if sess == nil {
+		return false
+	}
+	sess.resumable = true
+	return true
+}
+
+// Interrupted returns whether the follow stream of the given request waits
+// for the agent to resume it, along with the timestamp of the last line its
+// client received. The timestamp is zero if no line was received yet.
+func (s *Server) Interrupted(requestUUID string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess := s.sessions[requestUUID]
+	if sess == nil || !sess.interrupted {
+		return time.Time{}, false
+	}
+	return sess.lastTimestamp, true
+}
+
+// interrupt keeps the session of a resumable follow stream whose agent went
+// away, until the agent resumes it or the resume timeout expires. Returns
+// false if the session is not kept.
+func (s *Server) interrupt(requestUUID string, logCtx *logrus.Entry) bool {
+	s.mu.Lock()
+	sess := s.sessions[requestUUID]
+	if sess == nil || !sess.resumable || sess.handedOff || sess.hw == nil {
+		s.mu.Unlock()
+		return false
+	}
+	sess.interrupted = true
+	sess.interruptions++
+	sess.cancelFn = nil
+	sess.resumeAfter = sess.lastTimestamp
+	interruption := sess.interruptions
+	lastTimestamp := sess.lastTimestamp
+	s.mu.Unlock()
+
+	logCtx.WithField("last_timestamp", lastTimestamp).Info("LogStream interrupted; waiting for the agent to resume it")
+	time.AfterFunc(s.resumeTimeout, func() { s.expireInterrupted(requestUUID, interruption, logCtx) })
+	if s.onInterrupt != nil {
+		s.onInterrupt(requestUUID)
+	}
+	return true
+}
+
+// expireInterrupted gives up on a follow stream that was not resumed since
+// the given interruption, and tells its client to retry.
+func (s *Server) expireInterrupted(requestUUID string, interruption int, logCtx *logrus.Entry) {
+	s.mu.RLock()
+	sess := s.sessions[requestUUID]
+	expired := sess != nil && sess.interrupted && sess.interruptions == interruption
+	var hw *httpWriter
+	if expired {
+		hw = sess.hw
+	}
+	s.mu.RUnlock()
+	if !expired {
+		return
+	}
+	logCtx.Warn("LogStream was not resumed in time; ending it")
+	if hw != nil {
+		hw.failRetryable(http.StatusServiceUnavailable, "agent did not resume the log stream", s.sseRetry)
+	}
+	s.finalizeSession(requestUUID)
+}
+
+// trackResume records the timestamp of the last complete line of a
+// resumable stream, and drops the lines of a resumed stream the client has
+// received already.
+func (s *Server) trackResume(sess *session, data []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !sess.resumable {
+		return data
+	}
+	if !sess.resumeAfter.IsZero() {
+		var done bool
+		data, done = dropLinesUntil(data, sess.resumeAfter)
+		if done {
+			sess.resumeAfter = time.Time{}
+		}
+	}
+	if ts := lastLineTimestamp(data); !ts.IsZero() {
+		sess.lastTimestamp = ts
+	}
+	return data
+}
+
+// dropLinesUntil drops the complete lines from data with a timestamp up to
+// and including after. It returns true once it reached a later line, or a
+// line it can't tell, after which no more lines need to be dropped.
+func dropLinesUntil(data []byte, after time.Time) ([]byte, bool) {
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			return data, true
+		}
+		_, ts := lineTimestamp(data[:idx])
+		if ts.IsZero() || ts.After(after) {
+			return data, true
+		}
+		data = data[idx+1:]
+	}
+	return data, false
+}
+
+// lastLineTimestamp returns the timestamp of the last complete line in data,
+// or the zero time if there is none.
+func lastLineTimestamp(data []byte) time.Time {
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return time.Time{}
+	}
+	start := bytes.LastIndexByte(data[:end], '\n') + 1
+	_, ts := lineTimestamp(data[start:end])
+	return ts
+}
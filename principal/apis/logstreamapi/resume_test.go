@@ -0,0 +1,136 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// brokenLogStream delivers its data and then fails like a stream whose agent
+// went away
+type brokenLogStream struct {
+	*mock.MockLogStreamServer
+	data []*logstreamapi.LogStreamData
+}
+
+func (b *brokenLogStream) Recv() (*logstreamapi.LogStreamData, error) {
+	if len(b.data) == 0 {
+		return nil, status.Error(codes.Unavailable, "transport is closing")
+	}
+	d := b.data[0]
+	b.data = b.data[1:]
+	return d, nil
+}
+
+func logData(requestUUID, data string) *logstreamapi.LogStreamData {
+	return &logstreamapi.LogStreamData{RequestUuid: requestUUID, Data: []byte(data)}
+}
+
+func Test_ResumeInterruptedStream(t *testing.T) {
+	const requestUUID = "follow-1"
+	interrupted := make(chan string, 1)
+	server := NewServer(WithOnInterrupt(func(id string) { interrupted <- id }))
+	w := mock.NewMockHTTPResponseWriter()
+	require.NoError(t, server.RegisterHTTP(requestUUID, w, httptest.NewRequest("GET", "/logs?follow=true", nil)))
+	require.True(t, server.MarkResumable(requestUUID))
+
+	_, ok := server.Interrupted(requestUUID)
+	assert.False(t, ok)
+
+	// The agent's stream breaks off after two lines
+	_ = server.StreamLogs(&brokenLogStream{
+		MockLogStreamServer: mock.NewMockLogStreamServer(context.Background()),
+		data: []*logstreamapi.LogStreamData{
+			logData(requestUUID, "2025-01-01T10:00:00.100Z line 1\n"),
+			logData(requestUUID, "2025-01-01T10:00:00.200Z line 2\n2025-01-01T10:00:00.3"),
+		},
+	})
+	assert.Equal(t, requestUUID, <-interrupted)
+	since, ok := server.Interrupted(requestUUID)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2025, 1, 1, 10, 0, 0, 200000000, time.UTC), since.UTC())
+
+	// Another agent replica resumes the stream at the beginning of the
+	// second, the lines already delivered are dropped
+	resumed := mock.NewMockLogStreamServer(context.Background())
+	resumed.AddRecvData(logData(requestUUID, ""))
+	resumed.AddRecvData(logData(requestUUID, "2025-01-01T10:00:00.100Z line 1\n2025-01-01T10:00:00.200Z line 2\n"))
+	resumed.AddRecvData(logData(requestUUID, "2025-01-01T10:00:00.300Z line 3\n"))
+	resumed.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true})
+	require.NoError(t, server.StreamLogs(resumed))
+	_, ok = server.Interrupted(requestUUID)
+	assert.False(t, ok)
+
+	assert.Equal(t, "2025-01-01T10:00:00.100Z line 1\n"+
+		"2025-01-01T10:00:00.200Z line 2\n2025-01-01T10:00:00.3"+
+		"2025-01-01T10:00:00.300Z line 3\n", w.GetBody())
+}
+
+func Test_InterruptedStreamExpires(t *testing.T) {
+	const requestUUID = "follow-2"
+	server := NewServer(WithResumeTimeout(50 * time.Millisecond))
+	w := mock.NewMockHTTPResponseWriter()
+	require.NoError(t, server.RegisterHTTP(requestUUID, w, httptest.NewRequest("GET", "/logs?follow=true", nil)))
+	server.MarkResumable(requestUUID)
+
+	_ = server.StreamLogs(&brokenLogStream{MockLogStreamServer: mock.NewMockLogStreamServer(context.Background()),
+		data: []*logstreamapi.LogStreamData{logData(requestUUID, "2025-01-01T10:00:00Z line 1\n")}})
+	_, ok := server.Interrupted(requestUUID)
+	require.True(t, ok)
+
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		return server.sessions[requestUUID] == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_InterruptOnlyResumableStreams(t *testing.T) {
+	const requestUUID = "static-1"
+	server := NewServer()
+	w := mock.NewMockHTTPResponseWriter()
+	require.NoError(t, server.RegisterHTTP(requestUUID, w, httptest.NewRequest("GET", "/logs", nil)))
+
+	_ = server.StreamLogs(&brokenLogStream{MockLogStreamServer: mock.NewMockLogStreamServer(context.Background()),
+		data: []*logstreamapi.LogStreamData{logData(requestUUID, "2025-01-01T10:00:00Z line 1\n")}})
+	_, ok := server.Interrupted(requestUUID)
+	assert.False(t, ok)
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	assert.Nil(t, server.sessions[requestUUID])
+}
+
+func Test_DropLinesUntil(t *testing.T) {
+	after := time.Date(2025, 1, 1, 10, 0, 1, 0, time.UTC)
+	data, done := dropLinesUntil([]byte("2025-01-01T10:00:00Z a\n2025-01-01T10:00:01Z b\n"), after)
+	assert.Empty(t, data)
+	assert.False(t, done)
+	data, done = dropLinesUntil([]byte("2025-01-01T10:00:01Z b\n2025-01-01T10:00:02Z c\n2025-01-01T10:00:00Z d\n"), after)
+	assert.Equal(t, "2025-01-01T10:00:02Z c\n2025-01-01T10:00:00Z d\n", string(data))
+	assert.True(t, done)
+	data, done = dropLinesUntil([]byte("no timestamp\n"), after)
+	assert.Equal(t, "no timestamp\n", string(data))
+	assert.True(t, done)
+}
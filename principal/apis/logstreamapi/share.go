@@ -0,0 +1,199 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"net/http"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Share allows other clients to join the static log stream of the given
+// request while it is in progress, see Join. The data received from the agent
+// is kept up to the buffer size, so joining clients receive the full logs.
+// A shared stream continues when its own client disconnects, so that a client
+// retrying its request can join it. Returns false if there is no such session.
+func (s *Server) Share(requestUUID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[requestUUID]
+	if sess == nil {
+		return false
+	}
+	sess.shared = true
+	return true
+}
+
+// Join registers the HTTP writer of a request identical to the shared log
+// stream of leaderUUID, so both are answered from a single agent-side stream.
+// The data received so far is written right away. The joining request has a
+// session of its own under requestUUID, which completes with the shared
+// stream. Join fails if the stream is not shared, already complete, or its
+// data exceeded the buffer size.
+func (s *Server) Join(leaderUUID, requestUUID string, w http.ResponseWriter, r *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leader := s.sessions[leaderUUID]
+	if leader == nil || !leader.shared || leader.eof {
+		return status.Error(codes.NotFound, "no shared log stream in progress")
+	}
+	if leader.overflow {
+		return status.Error(codes.FailedPrecondition, "log stream is too large to be shared")
+	}
+	if _, ok := s.sessions[requestUUID]; ok {
+		return status.Error(codes.AlreadyExists, "request is already registered")
+	}
+	hw, err := s.newHTTPWriter(w, r)
+	if err != nil {
+		return err
+	}
+	sess := &session{
+		hw:         hw,
+		completeCh: make(chan bool, 1),
+		doneCh:     make(chan struct{}),
+		leader:     leader,
+	}
+	// We hold the lock, so the data received so far is written before any
+	// new data.
+	if len(leader.history) > 0 {
+		if _, err := hw.write(leader.history); err == nil {
+			_ = hw.flush()
+		}
+	}
+	leader.followers = append(leader.followers, sess)
+	s.sessions[requestUUID] = sess
+	s.watch(requestUUID, r, sess.doneCh, hw)
+	return nil
+}
+
+// follower is a client which joined a shared stream
+type follower struct {
+	sess *session
+	hw   *httpWriter
+}
+
+// sharedData is the result of recording data of a shared stream
+type sharedData struct {
+	// followers are the joined clients to write the data to
+	followers []follower
+	// keep is set if the stream must continue without its own client
+	keep bool
+}
+
+// shareData records data received for a shared stream and returns the
+// joined clients to pass it on to.
+func (s *Server) shareData(sess *session, data []byte) sharedData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !sess.shared {
+		return sharedData{}
+	}
+	if !sess.overflow {
+		if len(sess.history)+len(data) > s.maxRangeBufferSize {
+			// No one can join anymore, but the joined clients get the rest
+			sess.overflow = true
+			sess.history = nil
+		} else {
+			sess.history = append(sess.history, data...)
+		}
+	}
+	res := sharedData{keep: !sess.overflow}
+	for _, f := range sess.followers {
+		if f.hw != nil {
+			res.followers = append(res.followers, follower{sess: f, hw: f.hw})
+			res.keep = true
+		}
+	}
+	return res
+}
+
+// followerWriters returns the HTTP writers of the clients which joined a
+// shared stream.
+func (s *Server) followerWriters(sess *session) []*httpWriter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var hws []*httpWriter
+	for _, f := range sess.followers {
+		if f.hw != nil {
+			hws = append(hws, f.hw)
+		}
+	}
+	return hws
+}
+
+// finishFollowers completes the responses of the clients which joined a
+// shared stream once the agent has sent all logs.
+func (s *Server) finishFollowers(sess *session, stats *logstreamapi.LogStreamStats) {
+	s.mu.Lock()
+	sess.eof = true
+	s.mu.Unlock()
+	for _, hw := range s.followerWriters(sess) {
+		hw.finish(stats)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range sess.followers {
+		f.complete()
+	}
+}
+
+// complete stops the watchdog and signals WaitForCompletion that the
+// response is complete. Caller must hold the server mutex.
+func (sess *session) complete() {
+	if sess.doneCh != nil {
+		close(sess.doneCh)
+		sess.doneCh = nil
+	}
+	select {
+	case sess.completeCh <- true:
+	default:
+	}
+}
+
+// detachFollower stops writing to a client which joined a shared stream,
+// e.g. because writing to it failed.
+func (s *Server) detachFollower(f *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.hw = nil
+}
+
+// unlinkLocked removes the links between a finalized session and the shared
+// stream it joined or the clients which joined it. Joined clients of a stream
+// that ends early are told so. Caller must hold s.mu.
+func (s *Server) unlinkLocked(sess *session) {
+	if leader := sess.leader; leader != nil {
+		for i, f := range leader.followers {
+			if f == sess {
+				leader.followers = append(leader.followers[:i], leader.followers[i+1:]...)
+				break
+			}
+		}
+		sess.leader = nil
+	}
+	for _, f := range sess.followers {
+		f.leader = nil
+		if !sess.eof {
+			if f.hw != nil {
+				f.hw.fail(http.StatusBadGateway, "log stream ended before all logs were received")
+			}
+			// The client was told already, so its handler may return
+			f.complete()
+		}
+	}
+	sess.followers = nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShare(t *testing.T) {
+	send := func(t *testing.T, s *Server, c *logClient, data string) {
+		t.Helper()
+		require.NoError(t, s.processLogMessage(c, &logstreamapi.LogStreamData{RequestUuid: "leader", Data: []byte(data)}))
+	}
+	eof := func(t *testing.T, s *Server, c *logClient) {
+		t.Helper()
+		err := s.processLogMessage(c, &logstreamapi.LogStreamData{RequestUuid: "leader", Eof: true})
+		require.Equal(t, io.EOF, err)
+	}
+
+	t.Run("Joined client receives the full logs", func(t *testing.T) {
+		s := NewServer()
+		lw := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, s.RegisterHTTP("leader", lw, httptest.NewRequest("GET", "/logs", nil)))
+		require.True(t, s.Share("leader"))
+		c := s.newLogClient(context.Background())
+		send(t, s, c, "line 1\n")
+
+		fw := mock.NewMockHTTPResponseWriter()
+		require.NoError(t, s.Join("leader", "follower", fw, httptest.NewRequest("GET", "/logs", nil)))
+		send(t, s, c, "line 2\n")
+		eof(t, s, c)
+
+		assert.True(t, s.WaitForCompletion("follower", time.Second))
+		assert.Equal(t, "line 1\nline 2\n", lw.GetBody())
+		assert.Equal(t, "line 1\nline 2\n", fw.GetBody())
+
+		// The stream is complete, so no one may join anymore
+		err := s.Join("leader", "late", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil))
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		s.RemoveSession("follower")
+		s.RemoveSession("leader")
+	})
+
+	t.Run("Shared stream continues without its own client", func(t *testing.T) {
+		s := NewServer()
+		ctx, cancel := context.WithCancel(context.Background())
+		require.NoError(t, s.RegisterHTTP("leader", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil).WithContext(ctx)))
+		require.True(t, s.Share("leader"))
+		c := s.newLogClient(context.Background())
+		send(t, s, c, "line 1\n")
+
+		// The client refreshes, i.e. disconnects and repeats its request
+		cancel()
+		require.Eventually(t, func() bool {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.sessions["leader"].hw == nil
+		}, time.Second, 10*time.Millisecond)
+		send(t, s, c, "line 2\n")
+		fw := mock.NewMockHTTPResponseWriter()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Accept", "text/event-stream")
+		require.NoError(t, s.Join("leader", "follower", fw, r))
+		send(t, s, c, "line 3\n")
+		eof(t, s, c)
+
+		assert.Contains(t, fw.GetBody(), "data: line 1\n\nid: 2\ndata: line 2\n\nid: 3\ndata: line 3\n\nevent: eof\n")
+		s.RemoveSession("follower")
+		s.RemoveSession("leader")
+	})
+
+	t.Run("Only shared streams within the buffer size may be joined", func(t *testing.T) {
+		s := NewServer()
+		s.maxRangeBufferSize = 8
+		require.NoError(t, s.RegisterHTTP("leader", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil)))
+		err := s.Join("leader", "follower", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil))
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		require.True(t, s.Share("leader"))
+		c := s.newLogClient(context.Background())
+		send(t, s, c, "a long line\n")
+		err = s.Join("leader", "follower", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil))
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.False(t, s.Share("unknown"))
+		s.RemoveSession("leader")
+	})
+
+	t.Run("Joined clients are told when the stream ends early", func(t *testing.T) {
+		s := NewServer()
+		require.NoError(t, s.RegisterHTTP("leader", mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs", nil)))
+		require.True(t, s.Share("leader"))
+		fw := mock.NewMockHTTPResponseWriter()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Accept", "text/event-stream")
+		require.NoError(t, s.Join("leader", "follower", fw, r))
+
+		s.RemoveSession("leader")
+		assert.Contains(t, fw.GetBody(), "event: error\ndata: log stream ended before all logs were received\n\n")
+		// The follower's handler doesn't wait for its timeout
+		assert.True(t, s.WaitForCompletion("follower", time.Second))
+		s.RemoveSession("follower")
+		assert.Equal(t, http.StatusOK, fw.GetStatusCode())
+	})
+}
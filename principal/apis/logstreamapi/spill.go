@@ -0,0 +1,300 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSpillSize is the default capacity of the spill buffer of a follow
+// stream in bytes.
+const DefaultSpillSize = 64 * 1024 * 1024
+
+// DefaultSpillTotalSize is the default capacity of the spill buffers of all
+// follow streams together in bytes.
+const DefaultSpillTotalSize = 1024 * 1024 * 1024
+
+// spillChunkSize is the maximum amount of spilled data written to the client
+// at once.
+const spillChunkSize = 32 * 1024
+
+// errSpillClosed is returned when data is written to a closed spill buffer.
+var errSpillClosed = errors.New("spill buffer closed")
+
+// errSpillExhausted is returned when the spill buffers of all follow streams
+// together would exceed their total size.
+var errSpillExhausted = errors.New("total size of spill buffers exhausted")
+
+// WithSpillBuffer makes follow streams buffer log data for slow clients in a
+// file in dir, holding up to size bytes per stream. The agent's stream then
+// continues at its own pace, and once a client falls behind by more than
+// size bytes, the oldest buffered lines are dropped. Without a spill buffer,
+// the agent's stream waits for slow clients.
+func WithSpillBuffer(dir string, size int64) Option {
+	return func(s *Server) {
+		s.spillDir = dir
+		s.spillSize = size
+	}
+}
+
+// WithSpillTotalSize limits the spill buffers of all follow streams together
+// to total bytes. Follow streams that would exceed it write to their clients
+// directly. A value of 0 disables the limit.
+func WithSpillTotalSize(total int64) Option {
+	return func(s *Server) {
+		s.spillTotal = total
+	}
+}
+
+// newSpillBuffer creates a spill buffer for a follow stream, unless the spill
+// buffers of all streams would exceed their total size then
+func (s *Server) newSpillBuffer() (*spillBuffer, error) {
+	if s.spillTotal > 0 {
+		if s.spillUsed.Add(s.spillSize) > s.spillTotal {
+			s.spillUsed.Add(-s.spillSize)
+			return nil, errSpillExhausted
+		}
+	}
+	b, err := newSpillBuffer(s.spillDir, s.spillSize)
+	if err != nil {
+		if s.spillTotal > 0 {
+			s.spillUsed.Add(-s.spillSize)
+		}
+		return nil, err
+	}
+	if s.spillTotal > 0 {
+		b.release = func() { s.spillUsed.Add(-s.spillSize) }
+	}
+	return b, nil
+}
+
+// spillBuffer is a bounded FIFO of log data in a file, used as a ring buffer.
+// The file is read and written through the page cache, so recently spilled
+// data usually doesn't touch the disk.
+type spillBuffer struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// start is the file offset of the oldest buffered byte, length the
+	// number of buffered bytes
+	start  int64
+	length int64
+	// dropped counts the bytes evicted since the client was last told.
+	// partial is set when eviction cut a line, whose rest is dropped, too.
+	dropped int64
+	partial bool
+	// err is set once the buffer was closed, see close and fail
+	err error
+	// ready is signaled when data was written or the buffer was closed
+	ready chan struct{}
+	// release, if not nil, returns the buffer's size to the total size of
+	// all spill buffers once it has been removed
+	release func()
+}
+
+// newSpillBuffer creates a spill buffer of the given size in a new file in dir
+func newSpillBuffer(dir string, size int64) (*spillBuffer, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("spill buffer size must be positive")
+	}
+	f, err := os.CreateTemp(dir, "logstream-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("could not create spill file: %w", err)
+	}
+	return &spillBuffer{f: f, size: size, ready: make(chan struct{}, 1)}, nil
+}
+
+// signal wakes up the reader, if it waits
+func (b *spillBuffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// write appends data to the buffer, evicting the oldest data if the buffer is
+// full. It returns the buffer's error once it has been closed.
+func (b *spillBuffer) write(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	// A line is only cut if the evicted data doesn't end with a newline
+	if n := int64(len(data)); n > b.size {
+		b.dropped += b.length + n - b.size
+		b.start, b.length = 0, 0
+		b.partial = data[n-b.size-1] != '\n'
+		data = data[n-b.size:]
+	} else if excess := b.length + n - b.size; excess > 0 {
+		last := make([]byte, 1)
+		if _, err := b.f.ReadAt(last, (b.start+excess-1)%b.size); err != nil {
+			b.err = fmt.Errorf("could not read spill file: %w", err)
+			return b.err
+		}
+		b.start = (b.start + excess) % b.size
+		b.length -= excess
+		b.dropped += excess
+		b.partial = last[0] != '\n'
+	}
+	for len(data) > 0 {
+		off := (b.start + b.length) % b.size
+		chunk := data[:min(int64(len(data)), b.size-off)]
+		if _, err := b.f.WriteAt(chunk, off); err != nil {
+			b.err = fmt.Errorf("could not write spill file: %w", err)
+			return b.err
+		}
+		b.length += int64(len(chunk))
+		data = data[len(chunk):]
+	}
+	b.signal()
+	return nil
+}
+
+// next returns up to max bytes of the oldest buffered data, and the number of
+// bytes evicted before it. It waits for data until ctx is done, and returns
+// false once the buffer has been closed and drained.
+func (b *spillBuffer) next(ctx context.Context, max int) ([]byte, int64, bool) {
+	for {
+		b.mu.Lock()
+		if b.length > 0 {
+			n := min(b.length, int64(max), b.size-b.start)
+			data := make([]byte, n)
+			if _, err := b.f.ReadAt(data, b.start); err != nil {
+				b.err = fmt.Errorf("could not read spill file: %w", err)
+				b.mu.Unlock()
+				return nil, 0, false
+			}
+			b.start = (b.start + n) % b.size
+			b.length -= n
+			// Drop the rest of a line cut by eviction
+			if b.partial {
+				i := bytes.IndexByte(data, '\n')
+				if i < 0 {
+					b.dropped += n
+					b.mu.Unlock()
+					continue
+				}
+				b.dropped += int64(i + 1)
+				b.partial = false
+				data = data[i+1:]
+			}
+			dropped := b.dropped
+			b.dropped = 0
+			b.mu.Unlock()
+			return data, dropped, true
+		}
+		if b.err != nil {
+			b.mu.Unlock()
+			return nil, 0, false
+		}
+		b.mu.Unlock()
+		select {
+		case <-b.ready:
+		case <-ctx.Done():
+			b.fail(ctx.Err())
+			return nil, 0, false
+		}
+	}
+}
+
+// close stops accepting data. Buffered data can still be read.
+func (b *spillBuffer) close() {
+	b.fail(errSpillClosed)
+}
+
+// fail closes the buffer and discards buffered data, if err is not
+// errSpillClosed. Writes return err from now on.
+func (b *spillBuffer) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	if err != errSpillClosed {
+		b.length = 0
+	}
+	b.signal()
+}
+
+// remove deletes the buffer's file
+func (b *spillBuffer) remove() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = b.f.Close()
+	_ = os.Remove(b.f.Name())
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+}
+
+// startSpill makes hw buffer log data in a spill buffer, which is written to
+// the client in the background until the client goes away.
+func (hw *httpWriter) startSpill(ctx context.Context, b *spillBuffer) {
+	hw.spill = b
+	hw.spillDone = make(chan struct{})
+	go func() {
+		defer close(hw.spillDone)
+		defer b.remove()
+		for {
+			data, dropped, ok := b.next(ctx, spillChunkSize)
+			if !ok {
+				return
+			}
+			hw.mu.Lock()
+			if dropped > 0 {
+				_, _ = hw.writeLocked([]byte(hw.droppedNotice(dropped)))
+			}
+			_, err := hw.writeLocked(data)
+			if err == nil {
+				err = safeFlush(hw.flusher)
+			}
+			hw.mu.Unlock()
+			if err != nil {
+				b.fail(err)
+				return
+			}
+		}
+	}()
+}
+
+// stopSpill waits until the spilled data has been written to the client, so
+// that the response can be completed.
+func (hw *httpWriter) stopSpill() {
+	if hw.spill == nil {
+		return
+	}
+	hw.spill.close()
+	<-hw.spillDone
+}
+
+// droppedNotice tells the client that log data was dropped because it reads
+// too slowly. It is prefixed with a timestamp if the client requested them,
+// since clients such as Argo CD parse the timestamp of every line.
+func (hw *httpWriter) droppedNotice(dropped int64) string {
+	msg := fmt.Sprintf("[%d bytes of logs dropped because the client reads too slowly]\n", dropped)
+	if strings.EqualFold(hw.r.URL.Query().Get("timestamps"), "true") {
+		msg = time.Now().UTC().Format(time.RFC3339Nano) + " " + msg
+	}
+	return msg
+}
@@ -0,0 +1,179 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readSpill(t *testing.T, b *spillBuffer) (string, int64) {
+	t.Helper()
+	var out []byte
+	var dropped int64
+	for {
+		data, d, ok := b.next(context.Background(), 4)
+		if !ok {
+			return string(out), dropped
+		}
+		out = append(out, data...)
+		dropped += d
+	}
+}
+
+func TestSpillBuffer(t *testing.T) {
+	t.Run("data is read in order across the end of the file", func(t *testing.T) {
+		b, err := newSpillBuffer(t.TempDir(), 16)
+		require.NoError(t, err)
+		defer b.remove()
+		require.NoError(t, b.write([]byte("line1\nline2\n")))
+		data, dropped, ok := b.next(context.Background(), 12)
+		require.True(t, ok)
+		assert.Equal(t, "line1\nline2\n", string(data))
+		assert.Zero(t, dropped)
+		require.NoError(t, b.write([]byte("line3\nline4\n")))
+		b.close()
+		out, dropped := readSpill(t, b)
+		assert.Equal(t, "line3\nline4\n", out)
+		assert.Zero(t, dropped)
+	})
+
+	t.Run("the oldest lines are evicted when full", func(t *testing.T) {
+		b, err := newSpillBuffer(t.TempDir(), 16)
+		require.NoError(t, err)
+		defer b.remove()
+		require.NoError(t, b.write([]byte("line1\nline2\n")))
+		require.NoError(t, b.write([]byte("line3\nline4\n")))
+		b.close()
+		out, dropped := readSpill(t, b)
+		// line2 was cut by eviction, so it's dropped in full
+		assert.Equal(t, "line3\nline4\n", out)
+		assert.Equal(t, int64(12), dropped)
+	})
+
+	t.Run("lines evicted in full don't cut the next line", func(t *testing.T) {
+		b, err := newSpillBuffer(t.TempDir(), 12)
+		require.NoError(t, err)
+		defer b.remove()
+		require.NoError(t, b.write([]byte("line1\nline2\n")))
+		require.NoError(t, b.write([]byte("line3\n")))
+		b.close()
+		out, dropped := readSpill(t, b)
+		assert.Equal(t, "line2\nline3\n", out)
+		assert.Equal(t, int64(6), dropped)
+	})
+
+	t.Run("data larger than the buffer keeps its end", func(t *testing.T) {
+		b, err := newSpillBuffer(t.TempDir(), 8)
+		require.NoError(t, err)
+		defer b.remove()
+		require.NoError(t, b.write([]byte("line1\nline2\nl3\n")))
+		b.close()
+		out, dropped := readSpill(t, b)
+		assert.Equal(t, "l3\n", out)
+		assert.Equal(t, int64(12), dropped)
+	})
+
+	t.Run("data larger than the buffer starting with a line keeps it", func(t *testing.T) {
+		b, err := newSpillBuffer(t.TempDir(), 9)
+		require.NoError(t, err)
+		defer b.remove()
+		require.NoError(t, b.write([]byte("line1\nl2\nl3\n")))
+		b.close()
+		out, dropped := readSpill(t, b)
+		assert.Equal(t, "l2\nl3\n", out)
+		assert.Equal(t, int64(6), dropped)
+	})
+
+	t.Run("writes fail once the buffer is closed", func(t *testing.T) {
+		b, err := newSpillBuffer(t.TempDir(), 8)
+		require.NoError(t, err)
+		b.close()
+		assert.ErrorIs(t, b.write([]byte("line1\n")), errSpillClosed)
+		name := b.f.Name()
+		b.remove()
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestRegisterHTTP_Spill(t *testing.T) {
+	t.Run("follow streams are written through the spill buffer", func(t *testing.T) {
+		dir := t.TempDir()
+		server := NewServer(WithSpillBuffer(dir, 1024))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs?follow=true", nil)
+		require.NoError(t, server.RegisterHTTP("follow", w, r))
+		defer server.RemoveSession("follow")
+		server.mu.RLock()
+		hw := server.sessions["follow"].hw
+		server.mu.RUnlock()
+		require.NotNil(t, hw.spill)
+
+		sendLogs(t, server, "follow", "line1\n", "line2\n")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "line1\nline2\n", w.Body.String())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("spill buffers are limited in total", func(t *testing.T) {
+		dir := t.TempDir()
+		server := NewServer(WithSpillBuffer(dir, 1024), WithSpillTotalSize(1024))
+		first, err := server.newSpillBuffer()
+		require.NoError(t, err)
+		_, err = server.newSpillBuffer()
+		assert.ErrorIs(t, err, errSpillExhausted)
+
+		// Further follow streams write to their clients directly
+		w := httptest.NewRecorder()
+		require.NoError(t, server.RegisterHTTP("direct", w, httptest.NewRequest("GET", "/logs?follow=true", nil)))
+		defer server.RemoveSession("direct")
+		server.mu.RLock()
+		hw := server.sessions["direct"].hw
+		server.mu.RUnlock()
+		assert.Nil(t, hw.spill)
+
+		first.remove()
+		assert.Zero(t, server.spillUsed.Load())
+		second, err := server.newSpillBuffer()
+		require.NoError(t, err)
+		second.remove()
+	})
+
+	t.Run("static logs are not spilled", func(t *testing.T) {
+		server := NewServer(WithSpillBuffer(t.TempDir(), 1024))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		require.NoError(t, server.RegisterHTTP("static", w, r))
+		defer server.RemoveSession("static")
+		server.mu.RLock()
+		hw := server.sessions["static"].hw
+		server.mu.RUnlock()
+		assert.Nil(t, hw.spill)
+	})
+
+	t.Run("dropped data is reported to the client", func(t *testing.T) {
+		hw := &httpWriter{w: httptest.NewRecorder(), r: httptest.NewRequest("GET", "/logs?follow=true&timestamps=true", nil)}
+		assert.Regexp(t, `^\d{4}-\d\d-\d\dT\S+ \[12 bytes of logs dropped because the client reads too slowly\]\n$`, hw.droppedNotice(12))
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventStreamContentType is the media type of Server-Sent Events.
+	eventStreamContentType = "text/event-stream"
+
+	// defaultSSEHeartbeatInterval is how often a comment line is written to
+	// idle SSE responses, so that intermediaries don't drop the connection.
+	defaultSSEHeartbeatInterval = 15 * time.Second
+
+	// defaultSSERetry is the reconnection delay hint sent to SSE clients.
+	defaultSSERetry = 3 * time.Second
+)
+
+// IsEventStreamRequest returns true if the client asked for the response to
+// be framed as Server-Sent Events.
+func IsEventStreamRequest(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(strings.ToLower(accept), eventStreamContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// sseEncoder frames raw log data as Server-Sent Events. Every complete log
+// line is emitted as a single event. Since the agent always requests
+// timestamps from the kubelet, the line's timestamp is used as the event id,
+// which allows a reconnecting client to resume via the Last-Event-ID header.
+// Lines without a parseable timestamp get a sequence number as id instead.
+//
+// sseEncoder is not safe for concurrent use; callers must serialize access.
+type sseEncoder struct {
+	// pending holds an incomplete line carried over from the previous chunk
+	pending []byte
+	// seq is the fallback event id for lines without a timestamp
+	seq uint64
+	// resumeAfter is the timestamp of the last event the client has seen.
+	// Lines up to and including it are dropped, because the kubelet only
+	// honors sinceTime with second precision and will send them again.
+	resumeAfter time.Time
+}
+
+// start returns the preamble for a new SSE response, containing the retry hint.
+func (e *sseEncoder) start(retry time.Duration) []byte {
+	return fmt.Appendf(nil, "retry: %d\n\n", retry.Milliseconds())
+}
+
+// encode splits data into lines and returns the SSE framing for every
+// complete line. Incomplete trailing data is kept until the next call.
+func (e *sseEncoder) encode(data []byte) []byte {
+	var out bytes.Buffer
+	buf := append(e.pending, data...)
+	for {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx < 0 {
+			break
+		}
+		e.writeEvent(&out, buf[:idx])
+		buf = buf[idx+1:]
+	}
+	e.pending = append([]byte(nil), buf...)
+	return out.Bytes()
+}
+
+// flush returns the SSE framing for any incomplete line still pending.
+func (e *sseEncoder) flush() []byte {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	e.writeEvent(&out, e.pending)
+	e.pending = nil
+	return out.Bytes()
+}
+
+// named returns a named SSE event with a single data line, such as the
+// terminal "eof" or "error" events.
+func (e *sseEncoder) named(name, data string) []byte {
+	data = strings.ReplaceAll(data, "\n", " ")
+	return fmt.Appendf(nil, "event: %s\ndata: %s\n\n", name, data)
+}
+
+// comment returns an SSE comment line, which clients ignore.
+func (e *sseEncoder) comment(text string) []byte {
+	return fmt.Appendf(nil, ": %s\n\n", text)
+}
+
+func (e *sseEncoder) writeEvent(out *bytes.Buffer, line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	id, ts := lineTimestamp(line)
+	if !e.resumeAfter.IsZero() && !ts.IsZero() && !ts.After(e.resumeAfter) {
+		return
+	}
+	e.seq++
+	if id == "" {
+		id = strconv.FormatUint(e.seq, 10)
+	}
+	out.WriteString("id: ")
+	out.WriteString(id)
+	out.WriteString("\ndata: ")
+	out.Write(line)
+	out.WriteString("\n\n")
+}
+
+// lineTimestamp returns the leading RFC3339 timestamp of a log line as
+// written by the kubelet, both verbatim and parsed. If there is none, it
+// returns an empty string and the zero time.
+func lineTimestamp(line []byte) (string, time.Time) {
+	end := bytes.IndexByte(line, ' ')
+	if end < 0 {
+		end = len(line)
+	}
+	// RFC3339Nano with offset is at most 35 characters
+	if end < 20 || end > 40 {
+		return "", time.Time{}
+	}
+	token := string(line[:end])
+	ts, err := time.Parse(time.RFC3339Nano, token)
+	if err != nil {
+		return "", time.Time{}
+	}
+	return token, ts
+}
+
+// LastEventTime returns the time encoded in the Last-Event-ID header sent by
+// a reconnecting SSE client. The second return value is false if the header
+// is missing or doesn't hold a timestamp, e.g. because the last event was a
+// line without timestamp.
+func LastEventTime(r *http.Request) (time.Time, bool) {
+	id := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if id == "" {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, id)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
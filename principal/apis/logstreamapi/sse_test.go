@@ -0,0 +1,168 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsEventStreamRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/logs", nil)
+	assert.False(t, IsEventStreamRequest(r))
+	r.Header.Set("Accept", "text/plain, text/event-stream")
+	assert.True(t, IsEventStreamRequest(r))
+}
+
+func TestSSEEncoder(t *testing.T) {
+	t.Run("timestamped lines use timestamp as id", func(t *testing.T) {
+		e := &sseEncoder{}
+		out := e.encode([]byte("2025-12-07T10:30:45.123456789Z line 1\r\n"))
+		assert.Equal(t, "id: 2025-12-07T10:30:45.123456789Z\ndata: 2025-12-07T10:30:45.123456789Z line 1\n\n", string(out))
+	})
+
+	t.Run("lines without timestamp use sequence id", func(t *testing.T) {
+		e := &sseEncoder{}
+		out := e.encode([]byte("foo\nbar\n"))
+		assert.Equal(t, "id: 1\ndata: foo\n\nid: 2\ndata: bar\n\n", string(out))
+	})
+
+	t.Run("partial lines are carried over", func(t *testing.T) {
+		e := &sseEncoder{}
+		assert.Empty(t, e.encode([]byte("hel")))
+		assert.Equal(t, "id: 1\ndata: hello\n\n", string(e.encode([]byte("lo\nwor"))))
+		assert.Equal(t, "id: 2\ndata: wor\n\n", string(e.flush()))
+		assert.Empty(t, e.flush())
+	})
+
+	t.Run("named events and comments", func(t *testing.T) {
+		e := &sseEncoder{}
+		assert.Equal(t, "retry: 3000\n\n", string(e.start(3*time.Second)))
+		assert.Equal(t, "event: error\ndata: a b\n\n", string(e.named("error", "a\nb")))
+		assert.Equal(t, ": heartbeat\n\n", string(e.comment("heartbeat")))
+	})
+}
+
+func TestRegisterHTTP_EventStream(t *testing.T) {
+	server := NewServer()
+	server.sseHeartbeatInterval = 0
+	requestUUID := "sse-request"
+
+	w := mock.NewMockHTTPResponseWriter()
+	r := httptest.NewRequest("GET", "/logs", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	require.NoError(t, server.RegisterHTTP(requestUUID, w, r))
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no", w.Header().Get("X-Accel-Buffering"))
+
+	client := server.newLogClient(context.Background())
+	client.requestID = requestUUID
+	err := server.processLogMessage(client, &logstreamapi.LogStreamData{
+		RequestUuid: requestUUID,
+		Data:        []byte("2025-12-07T10:30:45Z line 1\n2025-12-07T10:30:46Z partial"),
+	})
+	require.NoError(t, err)
+	err = server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true})
+	require.Equal(t, io.EOF, err)
+
+	body := w.GetBody()
+	assert.True(t, strings.HasPrefix(body, "retry: 3000\n\n"))
+	assert.Contains(t, body, "id: 2025-12-07T10:30:45Z\ndata: 2025-12-07T10:30:45Z line 1\n\n")
+	assert.Contains(t, body, "id: 2025-12-07T10:30:46Z\ndata: 2025-12-07T10:30:46Z partial\n\n")
+	assert.True(t, strings.HasSuffix(body, "event: eof\ndata: \n\n"))
+	server.RemoveSession(requestUUID)
+}
+
+func TestRegisterHTTP_EventStreamHeartbeat(t *testing.T) {
+	server := NewServer()
+	server.sseHeartbeatInterval = 5 * time.Millisecond
+	requestUUID := "sse-heartbeat"
+
+	w := mock.NewMockHTTPResponseWriter()
+	r := httptest.NewRequest("GET", "/logs", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	require.NoError(t, server.RegisterHTTP(requestUUID, w, r))
+	defer server.RemoveSession(requestUUID)
+
+	server.mu.RLock()
+	hw := server.sessions[requestUUID].hw
+	server.mu.RUnlock()
+	assert.Eventually(t, func() bool {
+		hw.mu.Lock()
+		defer hw.mu.Unlock()
+		return strings.Contains(w.GetBody(), ": heartbeat\n\n")
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSSEEncoderResume(t *testing.T) {
+	e := &sseEncoder{resumeAfter: time.Date(2025, 12, 7, 10, 30, 45, 500000000, time.UTC)}
+	out := e.encode([]byte("2025-12-07T10:30:45.100Z seen\n2025-12-07T10:30:45.500Z seen\n2025-12-07T10:30:45.600Z new\nno timestamp\n"))
+	assert.Equal(t, "id: 2025-12-07T10:30:45.600Z\ndata: 2025-12-07T10:30:45.600Z new\n\nid: 2\ndata: no timestamp\n\n", string(out))
+}
+
+func TestLastEventTime(t *testing.T) {
+	r := httptest.NewRequest("GET", "/logs", nil)
+	_, ok := LastEventTime(r)
+	assert.False(t, ok)
+	r.Header.Set("Last-Event-ID", "42")
+	_, ok = LastEventTime(r)
+	assert.False(t, ok)
+	r.Header.Set("Last-Event-ID", "2025-12-07T10:30:45.123456789Z")
+	ts, ok := LastEventTime(r)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 12, 7, 10, 30, 45, 123456789, time.UTC), ts)
+}
+
+func TestWriteError(t *testing.T) {
+	server := NewServer()
+
+	plain := mock.NewMockHTTPResponseWriter()
+	require.NoError(t, server.RegisterHTTP("plain", plain, httptest.NewRequest("GET", "/logs", nil)))
+	assert.False(t, server.WriteError("plain", http.StatusGatewayTimeout, "timeout"))
+	assert.False(t, server.WriteError("unknown", http.StatusGatewayTimeout, "timeout"))
+
+	sse := mock.NewMockHTTPResponseWriter()
+	r := httptest.NewRequest("GET", "/logs", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	require.NoError(t, server.RegisterHTTP("sse", sse, r))
+	assert.True(t, server.WriteError("sse", http.StatusGatewayTimeout, "timeout"))
+	assert.Contains(t, sse.GetBody(), "event: error\ndata: timeout\n\n")
+}
+
+func TestWriteUnavailable(t *testing.T) {
+	server := NewServer()
+	assert.False(t, server.WriteUnavailable("unknown", 5*time.Second, "shutting down"))
+
+	sse := mock.NewMockHTTPResponseWriter()
+	r := httptest.NewRequest("GET", "/logs", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	require.NoError(t, server.RegisterHTTP("sse", sse, r))
+	assert.True(t, server.WriteUnavailable("sse", 5*time.Second, "shutting down"))
+	assert.Contains(t, sse.GetBody(), "retry: 5000\n\nevent: error\ndata: shutting down\n\n")
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+)
+
+// Headers carrying the statistics of a log stream. They are sent as
+// trailers, unless the response is buffered.
+const (
+	LogLinesHeader     = "X-Log-Lines"
+	LogBytesHeader     = "X-Log-Bytes"
+	LogTruncatedHeader = "X-Log-Truncated"
+	LogResumesHeader   = "X-Log-Resumes"
+)
+
+// streamStats is the payload of the "stats" event sent to SSE clients
+type streamStats struct {
+	Lines     int64 `json:"lines"`
+	Bytes     int64 `json:"bytes"`
+	Truncated bool  `json:"truncated"`
+	Resumes   int32 `json:"resumes"`
+}
+
+// writeStats reports the statistics of the stream sent by the agent to the
+// client, so that it can tell whether it received the complete logs.
+func (hw *httpWriter) writeStats(stats *logstreamapi.LogStreamStats) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.writeStatsLocked(stats)
+}
+
+// writeStatsLocked reports stats to the client: SSE clients receive a
+// "stats" event, buffered Range requests get response headers, and all other
+// responses get trailers. Caller must hold hw.mu.
+func (hw *httpWriter) writeStatsLocked(stats *logstreamapi.LogStreamStats) {
+	if stats == nil {
+		return
+	}
+	if hw.sse != nil {
+		b, err := json.Marshal(streamStats{
+			Lines:     stats.GetLines(),
+			Bytes:     stats.GetBytes(),
+			Truncated: stats.GetTruncated(),
+			Resumes:   stats.GetResumes(),
+		})
+		if err == nil {
+			hw.writeEventLocked("stats", string(b))
+		}
+		return
+	}
+	prefix := http.TrailerPrefix
+	if hw.buf != nil {
+		prefix = ""
+	}
+	h := hw.w.Header()
+	h.Set(prefix+LogLinesHeader, strconv.FormatInt(stats.GetLines(), 10))
+	h.Set(prefix+LogBytesHeader, strconv.FormatInt(stats.GetBytes(), 10))
+	h.Set(prefix+LogTruncatedHeader, strconv.FormatBool(stats.GetTruncated()))
+	h.Set(prefix+LogResumesHeader, strconv.FormatInt(int64(stats.GetResumes()), 10))
+}
@@ -0,0 +1,93 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendLogsWithStats(t *testing.T, server *Server, requestUUID string, data string, stats *logstreamapi.LogStreamStats) {
+	t.Helper()
+	client := server.newLogClient(t.Context())
+	client.requestID = requestUUID
+	require.NoError(t, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: requestUUID, Data: []byte(data)}))
+	require.Equal(t, io.EOF, server.processLogMessage(client, &logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true, Stats: stats}))
+}
+
+func TestWriteStats(t *testing.T) {
+	stats := &logstreamapi.LogStreamStats{Lines: 2, Bytes: 12, Truncated: true, Resumes: 1}
+
+	t.Run("plain responses carry trailers", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		require.NoError(t, server.RegisterHTTP("plain", w, httptest.NewRequest("GET", "/logs", nil)))
+		defer server.RemoveSession("plain")
+
+		sendLogsWithStats(t, server, "plain", "line1\nline2\n", stats)
+		res := w.Result()
+		assert.Equal(t, "2", res.Trailer.Get(LogLinesHeader))
+		assert.Equal(t, "12", res.Trailer.Get(LogBytesHeader))
+		assert.Equal(t, "true", res.Trailer.Get(LogTruncatedHeader))
+		assert.Equal(t, "1", res.Trailer.Get(LogResumesHeader))
+		assert.Empty(t, res.Header.Get(LogLinesHeader))
+	})
+
+	t.Run("event streams receive a stats event before eof", func(t *testing.T) {
+		server := NewServer()
+		server.sseHeartbeatInterval = 0
+		w := mock.NewMockHTTPResponseWriter()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Accept", "text/event-stream")
+		require.NoError(t, server.RegisterHTTP("sse", w, r))
+		defer server.RemoveSession("sse")
+
+		sendLogsWithStats(t, server, "sse", "line1\nline2\n", stats)
+		assert.True(t, strings.HasSuffix(w.GetBody(),
+			"event: stats\ndata: {\"lines\":2,\"bytes\":12,\"truncated\":true,\"resumes\":1}\n\nevent: eof\ndata: \n\n"))
+	})
+
+	t.Run("range responses carry headers", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/logs", nil)
+		r.Header.Set("Range", "bytes=6-")
+		require.NoError(t, server.RegisterHTTP("range", w, r))
+		defer server.RemoveSession("range")
+
+		sendLogsWithStats(t, server, "range", "line1\nline2\n", stats)
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "2", w.Header().Get(LogLinesHeader))
+		assert.Equal(t, "true", w.Header().Get(LogTruncatedHeader))
+	})
+
+	t.Run("no stats from agent", func(t *testing.T) {
+		server := NewServer()
+		w := httptest.NewRecorder()
+		require.NoError(t, server.RegisterHTTP("plain", w, httptest.NewRequest("GET", "/logs", nil)))
+		defer server.RemoveSession("plain")
+
+		sendLogsWithStats(t, server, "plain", "line1\n", nil)
+		assert.Empty(t, w.Result().Trailer)
+	})
+}
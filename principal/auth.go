@@ -57,17 +57,42 @@ func (s *Server) clientCertificateMatches(ctx context.Context, match string) err
 	if len(tls.State.VerifiedChains) < 1 {
 		return fmt.Errorf("no verified certificates found in TLS cred")
 	}
-	cn := tls.State.VerifiedChains[0][0].Subject.CommonName
-	if match != cn {
-		return fmt.Errorf("the TLS subject '%s' does not match agent name '%s'", cn, match)
+	name, identity, err := s.clientCertIdentity().AgentID(tls.State.VerifiedChains[0][0])
+	if err != nil {
+		return fmt.Errorf("could not get agent name from TLS certificate: %w", err)
+	}
+	if match != name {
+		return fmt.Errorf("the TLS subject '%s' does not match agent name '%s'", identity, match)
 	}
 
-	logCtx.WithField("client_name", cn).Infof("Successful match of client cert subject '%s'", cn)
+	logCtx.WithField("client_name", name).Infof("Successful match of client cert identity '%s'", identity)
 
 	// Subject has been matched
 	return nil
 }
 
+// hasVerifiedClientCert returns an error if the client of the request did not
+// present a verified TLS client certificate. This is only required when the
+// TLS handshake does not enforce client certificates, i.e. when agents may
+// join without a certificate.
+func (s *Server) hasVerifiedClientCert(ctx context.Context) error {
+	if s.options.insecurePlaintext {
+		return nil
+	}
+	c, ok := peer.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("could not get peer from context")
+	}
+	tls, ok := c.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return fmt.Errorf("connection requires TLS credentials but has none")
+	}
+	if len(tls.State.VerifiedChains) < 1 {
+		return fmt.Errorf("no verified client certificate presented")
+	}
+	return nil
+}
+
 // unauthenticated is a wrapper function to return a gRPC unauthenticated
 // response to the caller.
 func unauthenticated() (context.Context, error) {
@@ -122,11 +147,18 @@ func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
 	// If we require client certificates, we enforce any potential rules for
 	// the certificate here, instead of at time the connection is made.
 	if s.options.requireClientCerts {
+		if s.options.joinTokensEnabled {
+			if err := s.hasVerifiedClientCert(ctx); err != nil {
+				logCtx.Errorf("could not verify TLS certificate: %v", err)
+				return unauthenticated()
+			}
+		}
 		if err := s.clientCertificateMatches(ctx, agentInfo.ClientID); err != nil {
 			logCtx.Errorf("could not match TLS certificate: %v", err)
 			return unauthenticated()
 		}
 	}
+	s.auditClientCertificate(certListenerGRPC, agentInfo.ClientID, verifiedClientChain(ctx))
 
 	// claims at this point is validated and we can propagate values to the
 	// context.
@@ -147,6 +179,7 @@ func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
 		return unauthenticated()
 	}
 	s.setAgentMode(agentInfo.ClientID, mode)
+	s.recordAgentVersion(agentInfo.ClientID, agentInfo.Version)
 	logCtx.WithField("client", agentInfo.ClientID).WithField("mode", agentInfo.Mode).Tracef("Client passed authentication")
 	return authCtx, nil
 }
@@ -189,8 +222,12 @@ func (s *Server) authenticateReplication(ctx context.Context) error {
 //
 // It enforces authentication on incoming gRPC calls according to settings of
 // Server s. If the called method is in the list of unauthenticated endpoints,
-// authentication is skipped.
+// authentication is skipped. Calls on connections with a revoked client
+// certificate are always refused.
 func (s *Server) unaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkClientCertRevocation(ctx); err != nil {
+		return nil, err
+	}
 	if _, ok := s.noauth[info.FullMethod]; ok {
 		return handler(ctx, req)
 	}
@@ -214,8 +251,12 @@ func (s *Server) unaryAuthInterceptor(ctx context.Context, req any, info *grpc.U
 //
 // It enforces authentication on incoming gRPC calls according to settings of
 // Server s. If the called method is in the list of unauthenticated endpoints,
-// authentication is skipped.
+// authentication is skipped. Calls on connections with a revoked client
+// certificate are always refused.
 func (s *Server) streamAuthInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkClientCertRevocation(stream.Context()); err != nil {
+		return err
+	}
 	if _, ok := s.noauth[info.FullMethod]; ok {
 		return handler(srv, stream)
 	}
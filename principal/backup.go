@@ -0,0 +1,103 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/argoproj-labs/argocd-agent/internal/handoff"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// exportQueuesPattern is the pattern on the admin server under which
+	// the backlogs of the agents' send queues are exported
+	exportQueuesPattern = "GET /queues"
+	// importQueuesPattern is the pattern on the admin server under which
+	// exported backlogs are queued again
+	importQueuesPattern = "POST /queues"
+	// maxQueuesImportSize is the maximum size of an imported backlog
+	maxQueuesImportSize = 256 * 1024 * 1024
+)
+
+// pendingQueues returns the events waiting to be sent to each agent, without
+// taking them from the queues
+func (s *Server) pendingQueues() []handoff.Queue {
+	names := s.queues.Names()
+	sort.Strings(names)
+	queues := []handoff.Queue{}
+	for _, agentName := range names {
+		if events := s.queues.PendingSend(agentName); len(events) > 0 {
+			queues = append(queues, handoff.Queue{AgentName: agentName, Events: events})
+		}
+	}
+	return queues
+}
+
+// restoreQueues adds the given events to the send queues of their agents,
+// creating the queues if necessary. It returns the number of events queued.
+func (s *Server) restoreQueues(queues []handoff.Queue, logCtx *logrus.Entry) int {
+	queued := 0
+	for _, q := range queues {
+		if !s.queues.HasQueuePair(q.AgentName) {
+			if err := s.queues.Create(q.AgentName); err != nil {
+				logCtx.WithError(err).Warnf("Could not create queue for agent %s", q.AgentName)
+				continue
+			}
+		}
+		sendQ := s.queues.SendQ(q.AgentName)
+		for _, ev := range q.Events {
+			sendQ.Add(ev)
+			queued++
+		}
+	}
+	return queued
+}
+
+// processExportQueues serves the backlogs of the agents' send queues as JSON,
+// so that they can be backed up and imported into another principal.
+func (s *Server) processExportQueues(w http.ResponseWriter, r *http.Request) {
+	queues := s.pendingQueues()
+	auditLog().WithFields(logrus.Fields{
+		"operator":    adminOperator(r),
+		"remote_addr": r.RemoteAddr,
+		"queues":      len(queues),
+	}).Info("Exported queue backlogs")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(queues)
+}
+
+// processImportQueues queues the events of exported backlogs again. They are
+// sent once their agents connect.
+func (s *Server) processImportQueues(w http.ResponseWriter, r *http.Request) {
+	queues := []handoff.Queue{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueuesImportSize)).Decode(&queues); err != nil {
+		http.Error(w, "invalid queue backlogs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	auditCtx := auditLog().WithFields(logrus.Fields{
+		"operator":    adminOperator(r),
+		"remote_addr": r.RemoteAddr,
+	})
+	queued := s.restoreQueues(queues, auditCtx)
+	auditCtx.WithFields(logrus.Fields{
+		"queues": len(queues),
+		"events": queued,
+	}).Info("Imported queue backlogs")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"events": queued})
+}
@@ -0,0 +1,65 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_QueueBacklogs(t *testing.T) {
+	old := newDrainTestServer(t)
+	require.NoError(t, old.queues.Create("agent-1"))
+	require.NoError(t, old.queues.Create("agent-2"))
+	ev, err := old.events.DrainEvent(time.Second)
+	require.NoError(t, err)
+	old.queues.SendQ("agent-1").Add(ev)
+
+	w := httptest.NewRecorder()
+	old.processExportQueues(w, httptest.NewRequest(http.MethodGet, "/queues", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	exported := w.Body.String()
+	assert.Contains(t, exported, `"agentName":"agent-1"`)
+	assert.NotContains(t, exported, "agent-2")
+	// Exporting doesn't take the events from the queue
+	assert.Equal(t, 1, old.queues.SendQ("agent-1").Len())
+
+	t.Run("Backlogs are queued again", func(t *testing.T) {
+		s := newDrainTestServer(t)
+		w := httptest.NewRecorder()
+		s.processImportQueues(w, httptest.NewRequest(http.MethodPost, "/queues", strings.NewReader(exported)))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"events":1}`, w.Body.String())
+		q := s.queues.SendQ("agent-1")
+		require.NotNil(t, q)
+		require.Equal(t, 1, q.Len())
+		got, _ := q.Get()
+		q.Done(got)
+		assert.Equal(t, ev.ID(), got.ID())
+	})
+
+	t.Run("Invalid backlogs are refused", func(t *testing.T) {
+		s := newDrainTestServer(t)
+		w := httptest.NewRecorder()
+		s.processImportQueues(w, httptest.NewRequest(http.MethodPost, "/queues", strings.NewReader("{")))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
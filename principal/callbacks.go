@@ -152,13 +152,20 @@ func (s *Server) updateAppCallback(old *v1alpha1.Application, new *v1alpha1.Appl
 			}
 		}
 
-		// Revert modifications on autonomous agent applications
-		if s.appManager.RevertAutonomousAppChanges(s.ctx, new, s.sourceCache.Application) {
+		// Revert modifications on autonomous agent applications, unless
+		// the conflict strategy lets them prevail
+		if s.resolveHubAppChange(s.ctx, agentName, new) {
 			logCtx.Trace("Modifications to the application are reverted")
 			return
 		}
 	}
 
+	if new.DeletionTimestamp != nil && !s.isResourceFromAutonomousAgent(new) {
+		if s.handleAppDeletion(ctx, old, new, agentName, logCtx) {
+			return
+		}
+	}
+
 	if s.appManager.IsChangeIgnored(new.QualifiedName(), new.ResourceVersion) {
 		logCtx.WithField("resource_version", new.ResourceVersion).Debugf("Resource version has already been seen")
 		return
@@ -253,6 +260,12 @@ func (s *Server) deleteAppCallback(outbound *v1alpha1.Application) {
 		}
 	}
 
+	// Orphaned applications may be deleted on the principal only
+	if s.orphans.unmarkPrincipalOnly(outbound) {
+		logCtx.Debug("Not sending deletion of orphaned application to agent")
+		return
+	}
+
 	if !s.queues.HasQueuePair(agentName) {
 		if err := s.queues.Create(agentName); err != nil {
 			logCtx.WithError(err).Error("failed to create a queue pair for agent")
@@ -0,0 +1,149 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+
+	"github.com/argoproj-labs/argocd-agent/internal/auth/mtls"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Listeners on which client certificates are audited
+const (
+	certListenerGRPC          = "grpc"
+	certListenerResourceProxy = "resource-proxy"
+)
+
+// defaultClientCertIdentity takes the agent name from the common name of the
+// client certificate
+var defaultClientCertIdentity = mtls.NewMTLSAuthentication(nil, mtls.IdentitySourceCommonName)
+
+// clientCertIdentity returns the rule to extract agent names from client
+// certificates with
+func (s *Server) clientCertIdentity() *mtls.MTLSAuthentication {
+	if s.options != nil && s.options.clientCertIdentity != nil {
+		return s.options.clientCertIdentity
+	}
+	return defaultClientCertIdentity
+}
+
+// verifiedClientChain returns the verified certificate chain of the gRPC
+// client in ctx, leaf first, or nil if there is none
+func verifiedClientChain(ctx context.Context) []*x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) < 1 {
+		return nil
+	}
+	return info.State.VerifiedChains[0]
+}
+
+// auditClientCertificate records the client certificate chain an agent was
+// accepted with on the given listener. The full chain is logged whenever the
+// agent presents a different certificate than before, for forensic purposes.
+func (s *Server) auditClientCertificate(listener, agentName string, chain []*x509.Certificate) {
+	if len(chain) == 0 {
+		return
+	}
+	_, identity, err := s.clientCertIdentity().AgentID(chain[0])
+	if err != nil {
+		identity = ""
+	}
+	st := clientCertificateStatus(identity, chain)
+	if !s.activity.recordCertificate(listener, agentName, st) {
+		return
+	}
+	log().WithFields(logrus.Fields{
+		"listener": listener,
+		"agent":    agentName,
+		"identity": identity,
+		"chain":    tlsutil.DescribeChain(chain),
+	}).Info("Accepted client certificate")
+}
+
+func clientCertificateStatus(identity string, chain []*x509.Certificate) *v1alpha1.ClientCertificateStatus {
+	leaf := tlsutil.DescribeCertificate(chain[0])
+	st := &v1alpha1.ClientCertificateStatus{
+		Identity:     identity,
+		Subject:      leaf.Subject,
+		Issuer:       leaf.Issuer,
+		SerialNumber: leaf.SerialNumber,
+		Fingerprint:  leaf.Fingerprint,
+		URIs:         leaf.URIs,
+		NotAfter:     metav1.Time{Time: leaf.NotAfter},
+	}
+	for _, c := range chain[1:] {
+		st.Chain = append(st.Chain, c.Subject.String())
+	}
+	return st
+}
+
+// verifiedClientChains returns all verified chains of the client certificate
+// of the gRPC peer in ctx, if any
+func verifiedClientChains(ctx context.Context) [][]*x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return info.State.VerifiedChains
+}
+
+// checkClientCertRevocation rejects requests on connections whose client
+// certificate was revoked after the TLS handshake. Connections are long
+// lived, so revocation is checked again on every request, including token
+// refreshes.
+func (s *Server) checkClientCertRevocation(ctx context.Context) error {
+	if s.revocation == nil {
+		return nil
+	}
+	var revoked *tlsutil.RevokedError
+	if err := s.revocation.CheckChains(verifiedClientChains(ctx), s.onRevokedCert(certListenerGRPC)); errors.As(err, &revoked) {
+		return status.Error(codes.Unauthenticated, revoked.Error())
+	}
+	return nil
+}
+
+// disconnectRevokedAgents closes the event streams of agents whose client
+// certificate was revoked since they connected. It is called after every
+// refresh of the revocation lists.
+func (s *Server) disconnectRevokedAgents() {
+	if s.eventStreamSrv == nil {
+		return
+	}
+	s.eventStreamSrv.DisconnectWhere(func(_ string, ctx context.Context) error {
+		var revoked *tlsutil.RevokedError
+		if err := s.revocation.CheckChains(verifiedClientChains(ctx), s.onRevokedCert(certListenerGRPC)); errors.As(err, &revoked) {
+			return revoked
+		}
+		return nil
+	})
+}
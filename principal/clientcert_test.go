@@ -0,0 +1,182 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/auth/mtls"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func spiffeCert(t *testing.T, raw string, agent string) *x509.Certificate {
+	t.Helper()
+	u, err := url.Parse("spiffe://example.com/agent/" + agent)
+	require.NoError(t, err)
+	return &x509.Certificate{
+		Raw:          []byte(raw),
+		Subject:      pkix.Name{CommonName: "argocd-agent"},
+		Issuer:       pkix.Name{CommonName: "ca"},
+		SerialNumber: big.NewInt(42),
+		URIs:         []*url.URL{u},
+		NotAfter:     time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+}
+
+func Test_WithClientCertIdentity(t *testing.T) {
+	s := &Server{options: &ServerOptions{}}
+	assert.Equal(t, defaultClientCertIdentity, s.clientCertIdentity())
+	require.NoError(t, WithClientCertIdentity(mtls.IdentitySourceURI, `^spiffe://example\.com/agent/([^/]+)$`)(s))
+	assert.Equal(t, mtls.IdentitySourceURI, s.clientCertIdentity().IdentitySource)
+	require.NoError(t, WithClientCertIdentity(mtls.IdentitySourceCommonName, "")(s))
+	assert.Error(t, WithClientCertIdentity(mtls.IdentitySourceURI, "")(s))
+	assert.Error(t, WithClientCertIdentity(mtls.IdentitySourceSubject, "(")(s))
+	assert.Error(t, WithClientCertIdentity("dns", ".*")(s))
+}
+
+func Test_ClientCertIdentityFromURI(t *testing.T) {
+	s := &Server{options: &ServerOptions{clientCertSubjectMatch: true}, activity: newAgentActivity()}
+	require.NoError(t, WithClientCertIdentity(mtls.IdentitySourceURI, `^spiffe://example\.com/agent/([^/]+)$`)(s))
+	cert := spiffeCert(t, "cert-1", "agent-1")
+	ca := &x509.Certificate{Raw: []byte("ca"), Subject: pkix.Name{CommonName: "ca"}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &mockAddr{},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca}}}},
+	})
+
+	t.Run("Agent name is matched against the URI SAN", func(t *testing.T) {
+		assert.NoError(t, s.clientCertificateMatches(ctx, "agent-1"))
+		assert.ErrorContains(t, s.clientCertificateMatches(ctx, "argocd-agent"), "does not match agent name")
+	})
+
+	t.Run("Resource proxy authenticates by URI SAN", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert, ca}}}
+		agent, err := s.extractAgentFromAuth(r)
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", agent)
+		// Resource proxy certificates are not shown in the agent's status
+		assert.Nil(t, s.activity.certificate(certListenerGRPC, "agent-1"))
+		assert.NotNil(t, s.activity.certificate(certListenerResourceProxy, "agent-1"))
+	})
+
+	t.Run("Accepted certificate is recorded", func(t *testing.T) {
+		s.auditClientCertificate(certListenerGRPC, "agent-1", verifiedClientChain(ctx))
+		st := s.activity.certificate(certListenerGRPC, "agent-1")
+		require.NotNil(t, st)
+		assert.Equal(t, "spiffe://example.com/agent/agent-1", st.Identity)
+		assert.Equal(t, "CN=argocd-agent", st.Subject)
+		assert.Equal(t, "CN=ca", st.Issuer)
+		assert.Equal(t, "42", st.SerialNumber)
+		assert.Equal(t, []string{"spiffe://example.com/agent/agent-1"}, st.URIs)
+		assert.Equal(t, []string{"CN=ca"}, st.Chain)
+		assert.Len(t, st.Fingerprint, 64)
+		assert.True(t, cert.NotAfter.Equal(st.NotAfter.Time))
+	})
+
+	t.Run("Only changed certificates are recorded again", func(t *testing.T) {
+		st := s.activity.certificate(certListenerGRPC, "agent-1")
+		assert.False(t, s.activity.recordCertificate(certListenerGRPC, "agent-1", st))
+		renewed := clientCertificateStatus("", []*x509.Certificate{spiffeCert(t, "cert-2", "agent-1")})
+		assert.True(t, s.activity.recordCertificate(certListenerGRPC, "agent-1", renewed))
+	})
+}
+
+func Test_CheckClientCertRevocation(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, key.Public(), key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "agent-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, ca, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	writeCRL := func(path string, revoked ...int64) {
+		tmpl := &x509.RevocationList{Number: big.NewInt(time.Now().UnixNano()), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}
+		for _, serial := range revoked {
+			tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca, key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600))
+	}
+
+	crl := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(crl)
+	s := &Server{
+		options:    &ServerOptions{},
+		revocation: tlsutil.NewRevocationChecker([]string{crl}, false, false),
+		noauth:     map[string]bool{"/authapi.Authentication/RefreshToken": true},
+	}
+	require.NoError(t, s.revocation.Refresh(context.Background()))
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &mockAddr{},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca}}}},
+	})
+	require.NoError(t, s.checkClientCertRevocation(ctx))
+
+	t.Run("Certificate revoked after the handshake is refused", func(t *testing.T) {
+		writeCRL(crl, 7)
+		require.NoError(t, s.revocation.Refresh(context.Background()))
+		err := s.checkClientCertRevocation(ctx)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		// Unauthenticated methods such as token refreshes are refused, too
+		_, err = s.unaryAuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/authapi.Authentication/RefreshToken"}, func(context.Context, any) (any, error) {
+			return nil, nil
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Connections without client certificate are not affected", func(t *testing.T) {
+		assert.NoError(t, s.checkClientCertRevocation(context.Background()))
+	})
+}
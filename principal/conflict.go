@@ -0,0 +1,97 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+// resolveHubAppChange handles a modification of app, an Application of the
+// autonomous agent agentName, on the principal. It returns true if the
+// modification was reverted to the agent's spec.
+func (s *Server) resolveHubAppChange(ctx context.Context, agentName string, app *v1alpha1.Application) bool {
+	strategy := s.conflictStrategy()
+	spoke, ok := s.sourceCache.Application.Get(ktypes.UID(app.Annotations[manager.SourceUIDAnnotation]))
+	if !ok || strategy.OwnerPrevails(manager.SideSpoke, false) {
+		s.recordHubDrift(agentName, app)
+		return s.appManager.RevertAutonomousAppChanges(ctx, app, s.sourceCache.Application)
+	}
+	if reflect.DeepEqual(spoke, app.Spec) {
+		s.setConflictCondition(ctx, app, false)
+		return false
+	}
+	log().WithField("application", app.QualifiedName()).Infof("Keeping modification of application according to conflict strategy %s", strategy)
+	s.setConflictCondition(ctx, app, strategy == manager.ConflictManual)
+	return false
+}
+
+// resolveAgentAppChange returns the Application to write for incoming, an
+// update of an autonomous agent's Application, whose copy on the principal
+// is existing and whose spec was last received as previous. If the copy was
+// modified on the principal, and the modification prevails according to the
+// conflict strategy, the copy's spec is kept. The second return value tells
+// whether the copy must be marked as conflicting after it was written.
+func (s *Server) resolveAgentAppChange(existing *v1alpha1.Application, previous *v1alpha1.ApplicationSpec, incoming *v1alpha1.Application) (*v1alpha1.Application, bool) {
+	strategy := s.conflictStrategy()
+	if existing == nil || previous == nil || reflect.DeepEqual(existing.Spec, *previous) || reflect.DeepEqual(existing.Spec, incoming.Spec) {
+		// The copy was not modified, or matches the agent's spec again
+		return incoming, false
+	}
+	if strategy.OwnerPrevails(manager.SideSpoke, true) {
+		return incoming, false
+	}
+	log().WithField("application", existing.QualifiedName()).Infof("Keeping modified spec of application instead of the agent's according to conflict strategy %s", strategy)
+	out := incoming.DeepCopy()
+	out.Spec = *existing.Spec.DeepCopy()
+	return out, strategy == manager.ConflictManual
+}
+
+// conflictStrategy returns the configured conflict strategy, defaulting to
+// spoke-wins
+func (s *Server) conflictStrategy() manager.ConflictStrategy {
+	if s.options != nil && s.options.conflictStrategy != "" {
+		return s.options.conflictStrategy
+	}
+	return manager.ConflictSpokeWins
+}
+
+// setConflictCondition adds or removes the conflict condition of app, if it
+// doesn't match conflicted.
+func (s *Server) setConflictCondition(ctx context.Context, app *v1alpha1.Application, conflicted bool) {
+	if app == nil || manager.HasConflictCondition(app) == conflicted {
+		return
+	}
+	conditions := []v1alpha1.ApplicationCondition{}
+	if conflicted {
+		conditions = append(conditions, manager.ConflictCondition(manager.SideHub))
+	}
+	evaluated := map[v1alpha1.ApplicationConditionType]bool{manager.ConflictConditionType: true}
+	if _, err := s.appManager.SetConditions(ctx, app.Name, app.Namespace, conditions, evaluated); err != nil {
+		log().WithError(err).WithField("application", app.QualifiedName()).Warn("Could not update conflict condition of application")
+	}
+}
+
+// specOrNil returns a pointer to spec if ok is true, or nil otherwise.
+func specOrNil(spec v1alpha1.ApplicationSpec, ok bool) *v1alpha1.ApplicationSpec {
+	if !ok {
+		return nil
+	}
+	return &spec
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConflictStrategy(t *testing.T) {
+	spokeSpec := v1alpha1.ApplicationSpec{Project: "default", Source: &v1alpha1.ApplicationSource{RepoURL: "https://spoke", TargetRevision: "v1"}}
+	hubSpec := v1alpha1.ApplicationSpec{Project: "default", Source: &v1alpha1.ApplicationSource{RepoURL: "https://spoke", TargetRevision: "hub"}}
+	newSpokeSpec := v1alpha1.ApplicationSpec{Project: "default", Source: &v1alpha1.ApplicationSource{RepoURL: "https://spoke", TargetRevision: "v2"}}
+
+	// newConflictServer returns a server whose copy of the autonomous agent's
+	// application was modified to hubSpec
+	newConflictServer := func(t *testing.T, strategy manager.ConflictStrategy) (*Server, *v1alpha1.Application) {
+		t.Helper()
+		s := newResyncTestServer(t)
+		s.options.conflictStrategy = strategy
+		s.sourceCache.Application.Set("1234", spokeSpec)
+		app := getApp(t, s)
+		app.Spec = hubSpec
+		app, err := s.appManager.UpdateAutonomousApp(context.TODO(), "agent-1", app)
+		require.NoError(t, err)
+		return s, app
+	}
+	incoming := func(app *v1alpha1.Application) *v1alpha1.Application {
+		in := app.DeepCopy()
+		in.Spec = newSpokeSpec
+		return in
+	}
+
+	t.Run("spoke-wins reverts modifications", func(t *testing.T) {
+		s, app := newConflictServer(t, manager.ConflictSpokeWins)
+		assert.True(t, s.resolveHubAppChange(context.TODO(), "agent-1", app))
+		assert.Equal(t, spokeSpec, getApp(t, s).Spec)
+	})
+
+	for _, strategy := range []manager.ConflictStrategy{manager.ConflictHubWins, manager.ConflictNewestWins} {
+		t.Run(string(strategy)+" keeps modifications", func(t *testing.T) {
+			s, app := newConflictServer(t, strategy)
+			assert.False(t, s.resolveHubAppChange(context.TODO(), "agent-1", app))
+			app = getApp(t, s)
+			assert.Equal(t, hubSpec, app.Spec)
+			assert.False(t, manager.HasConflictCondition(app))
+		})
+	}
+
+	t.Run("hub-wins keeps the modified spec on updates from the agent", func(t *testing.T) {
+		s, app := newConflictServer(t, manager.ConflictHubWins)
+		toApply, conflicted := s.resolveAgentAppChange(app, &spokeSpec, incoming(app))
+		assert.Equal(t, hubSpec, toApply.Spec)
+		assert.False(t, conflicted)
+	})
+
+	t.Run("newest-wins applies updates from the agent", func(t *testing.T) {
+		s, app := newConflictServer(t, manager.ConflictNewestWins)
+		toApply, conflicted := s.resolveAgentAppChange(app, &spokeSpec, incoming(app))
+		assert.Equal(t, newSpokeSpec, toApply.Spec)
+		assert.False(t, conflicted)
+	})
+
+	t.Run("Unmodified applications receive updates from the agent", func(t *testing.T) {
+		s, app := newConflictServer(t, manager.ConflictHubWins)
+		toApply, _ := s.resolveAgentAppChange(app, &hubSpec, incoming(app))
+		assert.Equal(t, newSpokeSpec, toApply.Spec)
+		toApply, _ = s.resolveAgentAppChange(app, nil, incoming(app))
+		assert.Equal(t, newSpokeSpec, toApply.Spec)
+	})
+
+	t.Run("manual marks conflicts until both specs match", func(t *testing.T) {
+		s, app := newConflictServer(t, manager.ConflictManual)
+		assert.False(t, s.resolveHubAppChange(context.TODO(), "agent-1", app))
+		app = getApp(t, s)
+		assert.Equal(t, hubSpec, app.Spec)
+		assert.True(t, manager.HasConflictCondition(app))
+
+		toApply, conflicted := s.resolveAgentAppChange(app, &spokeSpec, incoming(app))
+		assert.Equal(t, hubSpec, toApply.Spec)
+		assert.True(t, conflicted)
+
+		// The spec on the principal is changed back to the agent's
+		app.Spec = spokeSpec
+		app, err := s.appManager.UpdateAutonomousApp(context.TODO(), "agent-1", app)
+		require.NoError(t, err)
+		assert.False(t, s.resolveHubAppChange(context.TODO(), "agent-1", app))
+		assert.False(t, manager.HasConflictCondition(getApp(t, s)))
+	})
+}
+
+func getApp(t *testing.T, s *Server) *v1alpha1.Application {
+	t.Helper()
+	app, err := s.appManager.Get(context.TODO(), "autonomous", "agent-1")
+	require.NoError(t, err)
+	return app
+}
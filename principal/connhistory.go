@@ -0,0 +1,293 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultConnectionHistoryRetention is how long entries of the
+	// connection history of agents are kept by default
+	defaultConnectionHistoryRetention = 7 * 24 * time.Hour
+	// maxConnectionHistoryRecords is the maximum number of entries kept in
+	// the connection history of an agent, so that agents flapping within
+	// the retention window don't bloat their AgentStatus
+	maxConnectionHistoryRecords = 100
+	// connectionHistoryPattern is the pattern on the admin server under
+	// which the connection history of an agent is served
+	connectionHistoryPattern = "GET /agents/{name}/connections"
+)
+
+// connectionHistory records when and why agents connected and disconnected,
+// within a retention window.
+//
+// All methods of connectionHistory may be called on a nil receiver, in
+// which case nothing is recorded.
+type connectionHistory struct {
+	mu        sync.Mutex
+	retention time.Duration
+	// since is the time from which on the history is known
+	since   time.Time
+	records map[string][]v1alpha1.ConnectionRecord
+	// restored holds the agents whose persisted history has been restored
+	restored map[string]bool
+}
+
+func newConnectionHistory(retention time.Duration, now time.Time) *connectionHistory {
+	return &connectionHistory{
+		retention: retention,
+		since:     now,
+		records:   make(map[string][]v1alpha1.ConnectionRecord),
+		restored:  make(map[string]bool),
+	}
+}
+
+// record adds rec to the history of agentName
+func (h *connectionHistory) record(agentName string, rec v1alpha1.ConnectionRecord) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records[agentName] = h.prune(append(h.records[agentName], rec), rec.Time.Time)
+}
+
+// restore merges the history of agentName persisted by a previous principal
+// instance into the recorded one. It only does so once per agent, and
+// returns whether it did.
+func (h *connectionHistory) restore(agentName string, persisted []v1alpha1.ConnectionRecord, now time.Time) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.restored[agentName] {
+		return false
+	}
+	h.restored[agentName] = true
+	if len(persisted) == 0 {
+		return false
+	}
+	current := h.records[agentName]
+	merged := make([]v1alpha1.ConnectionRecord, 0, len(persisted)+len(current))
+	for _, rec := range persisted {
+		// Anything recorded by us is newer than what was persisted before
+		if len(current) > 0 && !rec.Time.Before(&current[0].Time) {
+			continue
+		}
+		var out v1alpha1.ConnectionRecord
+		rec.DeepCopyInto(&out)
+		merged = append(merged, out)
+	}
+	merged = append(merged, current...)
+	h.records[agentName] = h.prune(merged, now)
+	if len(merged) > 0 && merged[0].Time.Time.Before(h.since) {
+		h.since = merged[0].Time.Time
+	}
+	return true
+}
+
+// prune drops the records older than the retention window, and the oldest
+// records beyond the maximum number of records. Caller must hold h.mu.
+func (h *connectionHistory) prune(records []v1alpha1.ConnectionRecord, now time.Time) []v1alpha1.ConnectionRecord {
+	cutoff := now.Add(-h.retention)
+	i := 0
+	for i < len(records) && records[i].Time.Time.Before(cutoff) {
+		i++
+	}
+	if len(records)-i > maxConnectionHistoryRecords {
+		i = len(records) - maxConnectionHistoryRecords
+	}
+	return records[i:]
+}
+
+// get returns the history of agentName, oldest first
+func (h *connectionHistory) get(agentName string, now time.Time) []v1alpha1.ConnectionRecord {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := h.prune(h.records[agentName], now)
+	if len(records) == 0 {
+		delete(h.records, agentName)
+		return nil
+	}
+	h.records[agentName] = records
+	out := make([]v1alpha1.ConnectionRecord, len(records))
+	for i := range records {
+		records[i].DeepCopyInto(&out[i])
+	}
+	return out
+}
+
+// agents returns the names of all agents with a history
+func (h *connectionHistory) agents() []string {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	agents := make([]string, 0, len(h.records))
+	for name := range h.records {
+		agents = append(agents, name)
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// uptime returns the fraction of the retention window, or of the time since
+// the history is known if that's shorter, in which agentName was connected.
+// connected is whether the agent is connected now.
+func (h *connectionHistory) uptime(agentName string, connected bool, now time.Time) float64 {
+	if h == nil {
+		return 0
+	}
+	records := h.get(agentName, now)
+	h.mu.Lock()
+	start := now.Add(-h.retention)
+	if h.since.After(start) {
+		start = h.since
+	}
+	h.mu.Unlock()
+	return uptimeRatio(records, connected, start, now)
+}
+
+// uptimeRatio returns the fraction of the time between start and end in
+// which the agent was connected according to records. The agent is assumed
+// to have been in the opposite state of the first record before it, and in
+// the given state after the last record.
+func uptimeRatio(records []v1alpha1.ConnectionRecord, connected bool, start, end time.Time) float64 {
+	window := end.Sub(start)
+	if window <= 0 {
+		if connected {
+			return 1
+		}
+		return 0
+	}
+	var up time.Duration
+	cursor := start
+	state := connected
+	if len(records) > 0 {
+		state = records[0].Type != v1alpha1.ConnectionEventConnected
+	}
+	for _, rec := range records {
+		t := rec.Time.Time
+		if t.After(cursor) {
+			if state {
+				up += t.Sub(cursor)
+			}
+			cursor = t
+		}
+		state = rec.Type == v1alpha1.ConnectionEventConnected
+	}
+	if connected && end.After(cursor) {
+		up += end.Sub(cursor)
+	}
+	return up.Seconds() / window.Seconds()
+}
+
+// onConnectionChange is called by the event stream server whenever an agent
+// connects or disconnects
+func (s *Server) onConnectionChange(change eventstream.ConnectionChange) {
+	rec := v1alpha1.ConnectionRecord{
+		Type:          v1alpha1.ConnectionEventConnected,
+		Time:          metav1.Time{Time: change.At},
+		RemoteAddress: change.RemoteAddr,
+	}
+	if !change.Connected {
+		rec.Type = v1alpha1.ConnectionEventDisconnected
+		rec.Reason = change.Reason
+	}
+	s.connections.record(change.AgentName, rec)
+}
+
+// processConnectionHistory serves the connection history of an agent as
+// JSON
+func (s *Server) processConnectionHistory(w http.ResponseWriter, r *http.Request) {
+	agentName := r.PathValue("name")
+	if errs := validation.NameIsDNSLabel(agentName, false); len(errs) > 0 {
+		http.Error(w, "invalid agent name", http.StatusBadRequest)
+		return
+	}
+	auditLog().WithFields(logrus.Fields{
+		"agent":       agentName,
+		"operator":    adminOperator(r),
+		"remote_addr": r.RemoteAddr,
+	}).Debug("Connection history of agent requested")
+
+	now := time.Now()
+	connected := s.isAgentConnected(agentName)
+	history := s.connections.get(agentName, now)
+	if history == nil {
+		history = []v1alpha1.ConnectionRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Agent     string                      `json:"agent"`
+		Connected bool                        `json:"connected"`
+		Uptime    float64                     `json:"uptime"`
+		History   []v1alpha1.ConnectionRecord `json:"history"`
+	}{
+		Agent:     agentName,
+		Connected: connected,
+		Uptime:    s.connections.uptime(agentName, connected, now),
+		History:   history,
+	})
+}
+
+// uptimeCollector exports the uptime of each agent with a connection
+// history
+type uptimeCollector struct {
+	s      *Server
+	uptime *prometheus.Desc
+}
+
+func newUptimeCollector(s *Server) prometheus.Collector {
+	return &uptimeCollector{
+		s: s,
+		uptime: prometheus.NewDesc("principal_agent_uptime_ratio",
+			"The fraction of the connection history retention window the agent was connected", []string{"agent_name"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *uptimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.uptime
+}
+
+// Collect implements prometheus.Collector
+func (c *uptimeCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, agentName := range c.s.connections.agents() {
+		if c.s.metrics != nil && !c.s.metrics.PerAgent(agentName) {
+			continue
+		}
+		ratio := c.s.connections.uptime(agentName, c.s.isAgentConnected(agentName), now)
+		ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue, ratio, agentName)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_connectionHistory(t *testing.T) {
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	rec := func(typ v1alpha1.ConnectionEventType, after time.Duration) v1alpha1.ConnectionRecord {
+		return v1alpha1.ConnectionRecord{Type: typ, Time: metav1.Time{Time: start.Add(after)}}
+	}
+
+	t.Run("Records outside the retention window are dropped", func(t *testing.T) {
+		h := newConnectionHistory(time.Hour, start)
+		h.record("agent-1", rec(v1alpha1.ConnectionEventConnected, 0))
+		h.record("agent-1", rec(v1alpha1.ConnectionEventDisconnected, 30*time.Minute))
+		h.record("agent-1", rec(v1alpha1.ConnectionEventConnected, 90*time.Minute))
+		history := h.get("agent-1", start.Add(100*time.Minute))
+		require.Len(t, history, 1)
+		assert.Equal(t, v1alpha1.ConnectionEventConnected, history[0].Type)
+		assert.Empty(t, h.get("agent-2", start))
+		assert.Equal(t, []string{"agent-1"}, h.agents())
+	})
+
+	t.Run("Number of records is capped", func(t *testing.T) {
+		h := newConnectionHistory(time.Hour, start)
+		for i := range maxConnectionHistoryRecords + 10 {
+			h.record("agent-1", rec(v1alpha1.ConnectionEventConnected, time.Duration(i)*time.Second))
+		}
+		history := h.get("agent-1", start.Add(time.Minute))
+		require.Len(t, history, maxConnectionHistoryRecords)
+		assert.Equal(t, start.Add(10*time.Second), history[0].Time.Time)
+	})
+
+	t.Run("Persisted history is restored once", func(t *testing.T) {
+		h := newConnectionHistory(24*time.Hour, start.Add(2*time.Hour))
+		h.record("agent-1", rec(v1alpha1.ConnectionEventConnected, 2*time.Hour))
+		persisted := []v1alpha1.ConnectionRecord{
+			rec(v1alpha1.ConnectionEventConnected, 0),
+			rec(v1alpha1.ConnectionEventDisconnected, time.Hour),
+			// Recorded by this instance already
+			rec(v1alpha1.ConnectionEventConnected, 2*time.Hour),
+		}
+		now := start.Add(3 * time.Hour)
+		assert.True(t, h.restore("agent-1", persisted, now))
+		assert.False(t, h.restore("agent-1", persisted, now))
+		history := h.get("agent-1", now)
+		require.Len(t, history, 3)
+		assert.Equal(t, start, history[0].Time.Time)
+		assert.Equal(t, start.Add(2*time.Hour), history[2].Time.Time)
+
+		// Restoring moved the start of the known history
+		assert.InDelta(t, 2.0/3.0, h.uptime("agent-1", true, now), 0.0001)
+	})
+
+	t.Run("Nil history records nothing", func(t *testing.T) {
+		var h *connectionHistory
+		h.record("agent-1", rec(v1alpha1.ConnectionEventConnected, 0))
+		assert.False(t, h.restore("agent-1", []v1alpha1.ConnectionRecord{rec(v1alpha1.ConnectionEventConnected, 0)}, start))
+		assert.Nil(t, h.get("agent-1", start))
+		assert.Nil(t, h.agents())
+		assert.Zero(t, h.uptime("agent-1", true, start))
+	})
+}
+
+func Test_uptimeRatio(t *testing.T) {
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Hour)
+	rec := func(typ v1alpha1.ConnectionEventType, after time.Duration) v1alpha1.ConnectionRecord {
+		return v1alpha1.ConnectionRecord{Type: typ, Time: metav1.Time{Time: start.Add(after)}}
+	}
+
+	t.Run("Without history the current state counts", func(t *testing.T) {
+		assert.Equal(t, 1.0, uptimeRatio(nil, true, start, end))
+		assert.Equal(t, 0.0, uptimeRatio(nil, false, start, end))
+	})
+
+	t.Run("Connected and disconnected periods are summed up", func(t *testing.T) {
+		records := []v1alpha1.ConnectionRecord{
+			rec(v1alpha1.ConnectionEventConnected, 2*time.Hour),
+			rec(v1alpha1.ConnectionEventDisconnected, 5*time.Hour),
+			rec(v1alpha1.ConnectionEventConnected, 6*time.Hour),
+		}
+		// Disconnected before 2h, connected 2h-5h and 6h-10h
+		assert.InDelta(t, 0.7, uptimeRatio(records, true, start, end), 0.0001)
+		// Disconnected again since the last record, e.g. not yet recorded
+		assert.InDelta(t, 0.3, uptimeRatio(records, false, start, end), 0.0001)
+	})
+
+	t.Run("Agent is assumed connected before a first disconnect", func(t *testing.T) {
+		records := []v1alpha1.ConnectionRecord{rec(v1alpha1.ConnectionEventDisconnected, 4*time.Hour)}
+		assert.InDelta(t, 0.4, uptimeRatio(records, false, start, end), 0.0001)
+	})
+
+	t.Run("Empty window", func(t *testing.T) {
+		assert.Equal(t, 1.0, uptimeRatio(nil, true, start, start))
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/validation"
+)
+
+const (
+	// debugShellPattern is the pattern on the admin server under which the
+	// debug shell to an agent is served
+	debugShellPattern = "GET /agents/{name}/debug"
+	// debugShellIdleTimeout is the time after which a debug shell without
+	// input is closed
+	debugShellIdleTimeout = 15 * time.Minute
+	// maxDebugOutputSize is the maximum size of the output of a single
+	// diagnostic command
+	maxDebugOutputSize = 4 * 1024 * 1024
+)
+
+// debugShellUpgrader upgrades debug shell requests to WebSockets. Its default
+// origin check refuses requests from browsers on other sites.
+var debugShellUpgrader = websocket.Upgrader{}
+
+// startAdminServer starts the localhost-only admin server. It is only
+// reachable from within the principal's pod, e.g. by port-forwarding, and
+// every request must be authenticated with a Kubernetes bearer token whose
+// user may create the admin resource in the principal's namespace.
+func (s *Server) startAdminServer(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugShellPattern, s.processDebugShell)
+	mux.HandleFunc(resyncPattern, s.processResync)
+	mux.HandleFunc(exportQueuesPattern, s.processExportQueues)
+	mux.HandleFunc(importQueuesPattern, s.processImportQueues)
+	mux.HandleFunc(connectionHistoryPattern, s.processConnectionHistory)
+	mux.HandleFunc(driftPattern, s.processDrift)
+	addr := fmt.Sprintf("127.0.0.1:%d", s.options.adminPort)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on admin port %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: s.authenticateAdmin(mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log().WithError(err).Error("Admin server error")
+		}
+	}()
+	log().WithField("addr", addr).Info("Started admin server")
+	return nil
+}
+
+// processDebugShell serves an interactive shell over a WebSocket, in which
+// an operator runs diagnostic commands on an agent. Each text message from
+// the client is a command line, which is answered by a text message holding
+// the command's output. Every command is recorded in the audit log.
+func (s *Server) processDebugShell(w http.ResponseWriter, r *http.Request) {
+	agentName := r.PathValue("name")
+	if errs := validation.NameIsDNSLabel(agentName, false); len(errs) > 0 {
+		http.Error(w, "invalid agent name", http.StatusBadRequest)
+		return
+	}
+	if s.eventStreamSrv == nil || !s.isAgentConnected(agentName) {
+		http.Error(w, "agent is not connected", http.StatusServiceUnavailable)
+		return
+	}
+	if v, _ := s.eventStreamSrv.AgentSchemaVersion(agentName); v < event.SchemaVersion7 {
+		http.Error(w, "agent does not support diagnostic commands", http.StatusNotImplemented)
+		return
+	}
+
+	conn, err := debugShellUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log().WithError(err).Warn("Could not upgrade debug shell to WebSocket")
+		return
+	}
+	defer conn.Close()
+
+	operator := adminOperator(r)
+	auditCtx := auditLog().WithFields(logrus.Fields{
+		"agent":       agentName,
+		"session":     uuid.NewString(),
+		"operator":    operator,
+		"remote_addr": r.RemoteAddr,
+	})
+	auditCtx.Info("Debug shell session started")
+	defer auditCtx.Info("Debug shell session ended")
+
+	// Unblock reading from the WebSocket when the principal shuts down
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.drain.expired:
+		}
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "debug shell closed"), time.Now().Add(time.Second))
+		_ = conn.Close()
+	}()
+
+	write := func(text string) bool {
+		return conn.WriteMessage(websocket.TextMessage, []byte(text)) == nil
+	}
+	if !write(fmt.Sprintf("Connected to agent %s. Type help for a list of commands.\n", agentName)) {
+		return
+	}
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(debugShellIdleTimeout))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		args := strings.Fields(string(msg))
+		if len(args) == 0 {
+			continue
+		}
+		command := args[0]
+		switch {
+		case command == "help":
+			write(debugShellHelp())
+			continue
+		case command == "exit" || command == "quit":
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		case !slices.Contains(event.DebugCommands, command):
+			write(fmt.Sprintf("error: unknown command %q. Type help for a list of commands.\n", command))
+			continue
+		case len(args) > 1:
+			write(fmt.Sprintf("error: command %s takes no arguments\n", command))
+			continue
+		}
+
+		auditCtx.WithField("command", command).Info("Running diagnostic command on agent")
+		output, err := s.runDebugCommand(ctx, agentName, command, operator)
+		if err != nil {
+			auditCtx.WithField("command", command).WithError(err).Warn("Diagnostic command failed")
+			output = fmt.Sprintf("error: %v\n", err)
+		}
+		if !write(output) {
+			return
+		}
+	}
+}
+
+// runDebugCommand runs a diagnostic command on the agent and returns its
+// output.
+func (s *Server) runDebugCommand(ctx context.Context, agentName, command, operator string) (string, error) {
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return "", errors.New("agent is not connected")
+	}
+	req := &event.DebugCommandRequest{
+		UUID:        uuid.NewString(),
+		Command:     command,
+		RequestedBy: operator,
+	}
+	ev, err := s.events.NewDebugCommandRequestEvent(req)
+	if err != nil {
+		return "", err
+	}
+	event.SetTTL(ev, requestTimeout)
+
+	sink := &debugOutputSink{}
+	transfer := s.fileTransferServer.Register(req.UUID, sink, filetransfer.WithMaxSize(maxDebugOutputSize))
+	defer s.fileTransferServer.Remove(req.UUID)
+	q.Add(ev)
+
+	err = transfer.Wait(ctx, requestTimeout)
+	if errors.Is(err, filetransfer.ErrTimeout) {
+		transfer.Abort(http.StatusGatewayTimeout, "Timeout waiting for output from agent")
+		return "", errors.New("timeout waiting for output from agent")
+	} else if err != nil {
+		return "", err
+	}
+	return sink.buf.String(), nil
+}
+
+func debugShellHelp() string {
+	return `Commands:
+  status    show the state of the agent and its connection
+  inflight  list long-running operations, such as log streams, in flight
+  config    show the effective configuration of the agent
+  resync    resynchronize the agent's resources with the principal
+  help      show this help
+  exit      close the shell
+`
+}
+
+// debugOutputSink collects the output of a diagnostic command in memory
+type debugOutputSink struct {
+	buf bytes.Buffer
+}
+
+func (s *debugOutputSink) Begin(filetransfer.FileInfo) error { return nil }
+
+func (s *debugOutputSink) Write(p []byte) (int, error) { return s.buf.Write(p) }
+
+func (s *debugOutputSink) Abort(int, string, bool) {}
+
+// auditLog returns the logger for the audit trail of the admin server
+func auditLog() *logrus.Entry {
+	return logging.GetDefaultLogger().ModuleLogger("AdminAudit")
+}
@@ -0,0 +1,136 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_processDebugShell(t *testing.T) {
+	newShellServer := func(t *testing.T, s *Server) *httptest.Server {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.HandleFunc(debugShellPattern, s.processDebugShell)
+		fakeAdminAuth(t, s)
+		srv := httptest.NewServer(s.authenticateAdmin(mux))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	dial := func(t *testing.T, srv *httptest.Server, agentName string) *websocket.Conn {
+		t.Helper()
+		header := http.Header{"Authorization": []string{"Bearer jane-token"}}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/agents/"+agentName+"/debug", header)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		_, greeting, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Contains(t, string(greeting), "Connected to agent "+agentName)
+		return conn
+	}
+	run := func(t *testing.T, conn *websocket.Conn, line string) string {
+		t.Helper()
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(line)))
+		_, out, err := conn.ReadMessage()
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	t.Run("Commands are run on the agent", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		conn := dial(t, newShellServer(t, s), "agent")
+
+		go func() {
+			ev, shutdown := s.queues.SendQ("agent").Get()
+			if shutdown {
+				return
+			}
+			req, err := event.New(ev, event.TargetDebugCommand).DebugCommandRequest()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, event.DebugCommandInflight, req.Command)
+			assert.Equal(t, "jane", req.RequestedBy)
+			err = s.fileTransferServer.Upload(&fakeUploadStream{chunks: []*filetransferapi.FileChunk{
+				{RequestUuid: req.UUID, Data: []byte("No requests in flight\n"), Eof: true},
+			}})
+			assert.NoError(t, err)
+		}()
+		assert.Equal(t, "No requests in flight\n", run(t, conn, "inflight"))
+	})
+
+	t.Run("Errors of the agent are shown", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		conn := dial(t, newShellServer(t, s), "agent")
+
+		go func() {
+			ev, _ := s.queues.SendQ("agent").Get()
+			err := s.fileTransferServer.Upload(&fakeUploadStream{chunks: []*filetransferapi.FileChunk{
+				{RequestUuid: event.EventID(ev), Error: "could not resync"},
+			}})
+			assert.ErrorContains(t, err, "could not resync")
+		}()
+		assert.Contains(t, run(t, conn, "resync"), "error: could not resync")
+	})
+
+	t.Run("Only known commands are sent to the agent", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		conn := dial(t, newShellServer(t, s), "agent")
+
+		assert.Contains(t, run(t, conn, "rm -rf /"), `error: unknown command "rm"`)
+		assert.Contains(t, run(t, conn, "status now"), "error: command status takes no arguments")
+		assert.Contains(t, run(t, conn, "help"), "inflight")
+		assert.Zero(t, s.queues.SendQ("agent").Len())
+
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("exit")))
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	})
+
+	t.Run("Agent not connected", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		r := httptest.NewRequest("GET", "/agents/agent/debug", nil)
+		w := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		mux.HandleFunc(debugShellPattern, s.processDebugShell)
+		mux.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("Event stream not started", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv = nil
+		r := httptest.NewRequest("GET", "/agents/agent/debug", nil)
+		w := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		mux.HandleFunc(debugShellPattern, s.processDebugShell)
+		mux.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
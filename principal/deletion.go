@@ -0,0 +1,180 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// The deletion of an Application of a managed agent happens in two phases.
+// When an Application with finalizers is deleted on the principal, it is
+// only marked for deletion, and the deletion is sent to the agent. The agent
+// deletes its Application, letting Argo CD prune the resources according to
+// the finalizers. Once the Application is gone on the agent, the agent
+// confirms the deletion and the principal removes the finalizers, so that
+// the Application is removed from the principal, too.
+
+// ForceDeletionAnnotation on an Application marked for deletion on the
+// principal removes it without waiting for its agent to confirm the deletion
+const ForceDeletionAnnotation = "argocd-agent.argoproj-labs.io/force-deletion"
+
+// deletionTracker remembers the number of resources last reported for each
+// Application being deleted, so that progress is only published on change
+type deletionTracker struct {
+	mu        sync.Mutex
+	remaining map[string]int
+}
+
+func newDeletionTracker() *deletionTracker {
+	return &deletionTracker{remaining: make(map[string]int)}
+}
+
+// progressed records the number of resources app still manages, and returns
+// whether it changed since the last call
+func (t *deletionTracker) progressed(app *v1alpha1.Application) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(app.Status.Resources)
+	if last, ok := t.remaining[app.QualifiedName()]; ok && last == n {
+		return false
+	}
+	t.remaining[app.QualifiedName()] = n
+	return true
+}
+
+func (t *deletionTracker) forget(app *v1alpha1.Application) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.remaining, app.QualifiedName())
+}
+
+// handleAppDeletion is called for updates to an Application of a managed
+// agent that is marked for deletion. It returns true if the Application was
+// removed from the principal right away, in which case the deletion is sent
+// to the agent once the Application is gone.
+func (s *Server) handleAppDeletion(ctx context.Context, old, new *v1alpha1.Application, agentName string, logCtx *logrus.Entry) bool {
+	if old.DeletionTimestamp == nil {
+		logCtx.Info("Application is marked for deletion, waiting for the agent to confirm")
+		s.publishAppDeletion(event.DeletionProgress, agentName, new, event.DeletionPhaseRequested, "")
+	}
+	if new.Annotations[ForceDeletionAnnotation] != "true" {
+		return false
+	}
+	if err := s.forceAppDeletion(ctx, new, agentName, "forced by annotation"); err != nil {
+		logCtx.WithError(err).Error("Could not force deletion of application")
+		return false
+	}
+	return true
+}
+
+// forceAppDeletion removes the finalizers of app, which is marked for
+// deletion, without waiting for its agent to confirm the deletion
+func (s *Server) forceAppDeletion(ctx context.Context, app *v1alpha1.Application, agentName, reason string) error {
+	if _, err := s.appManager.RemoveFinalizers(ctx, app); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	log().WithFields(logrus.Fields{
+		"application": app.QualifiedName(),
+		"agent":       agentName,
+	}).Warnf("Removed application from the principal without confirmation of the agent: %s", reason)
+	if s.metrics != nil {
+		s.metrics.ForcedApplicationDeletions.Inc()
+	}
+	s.publishAppDeletion(event.DeletionCompleted, agentName, app, event.DeletionPhaseForced, reason)
+	s.appDeletions.forget(app)
+	return nil
+}
+
+// recordDeletionProgress publishes the progress of the deletion of app, as
+// reported by its agent
+func (s *Server) recordDeletionProgress(agentName string, app *v1alpha1.Application) {
+	if !s.appDeletions.progressed(app) {
+		return
+	}
+	s.publishAppDeletion(event.DeletionProgress, agentName, app, event.DeletionPhasePruning, "")
+}
+
+// confirmAppDeletion is called when the agent confirmed the deletion of app
+func (s *Server) confirmAppDeletion(agentName string, app *v1alpha1.Application) {
+	s.publishAppDeletion(event.DeletionCompleted, agentName, app, event.DeletionPhaseConfirmed, "")
+	s.appDeletions.forget(app)
+}
+
+func (s *Server) publishAppDeletion(evType event.EventType, agentName string, app *v1alpha1.Application, phase, message string) {
+	if s.lifecycle == nil {
+		return
+	}
+	s.publishLifecycleEvent(s.events.AppDeletionEvent(evType, agentName, app, phase, message))
+}
+
+// runDeletionTimeouts forces the deletion of Applications whose agent did
+// not confirm their deletion within the deletion timeout, until ctx is done
+func (s *Server) runDeletionTimeouts(ctx context.Context) {
+	interval := min(s.options.deletionTimeout, time.Minute)
+	log().Infof("Forcing deletion of applications not confirmed by their agent within %v", s.options.deletionTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.IsActive() {
+				continue
+			}
+			if err := s.forceExpiredDeletions(ctx, time.Now()); err != nil {
+				log().WithError(err).Warn("Could not check for expired deletions")
+			}
+		}
+	}
+}
+
+// forceExpiredDeletions forces the deletion of all Applications of managed
+// agents that have been marked for deletion for longer than the timeout
+func (s *Server) forceExpiredDeletions(ctx context.Context, now time.Time) error {
+	apps, err := s.appManager.List(ctx, backend.ApplicationSelector{})
+	if err != nil {
+		return fmt.Errorf("could not list applications: %w", err)
+	}
+	filters := s.defaultAppFilterChain()
+	timeout := s.options.deletionTimeout
+	for i := range apps {
+		app := &apps[i]
+		if app.DeletionTimestamp == nil || len(app.Finalizers) == 0 || !filters.Admit(app) {
+			continue
+		}
+		if _, ok := app.Annotations[manager.SourceUIDAnnotation]; ok {
+			continue
+		}
+		if now.Sub(app.DeletionTimestamp.Time) < timeout {
+			continue
+		}
+		agentName := s.getAgentNameForApp(app)
+		if err := s.forceAppDeletion(ctx, app, agentName, fmt.Sprintf("agent did not confirm the deletion within %v", timeout)); err != nil {
+			log().WithError(err).WithField("application", app.QualifiedName()).Error("Could not force deletion of application")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_AppDeletion(t *testing.T) {
+	deleting := func(name string, since time.Duration, annotations map[string]string) *v1alpha1.Application {
+		deletedAt := metav1.NewTime(time.Now().Add(-since))
+		return &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "agent",
+			DeletionTimestamp: &deletedAt,
+			Finalizers:        []string{"resources-finalizer.argocd.argoproj.io"},
+			Annotations:       annotations,
+		}}
+	}
+	newServer := func(t *testing.T, apps ...*v1alpha1.Application) *Server {
+		t.Helper()
+		objs := []runtime.Object{}
+		for _, app := range apps {
+			objs = append(objs, app)
+		}
+		s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd", objs...), "argocd",
+			WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithNamespaces("agent"),
+			WithDeletionTimeout(time.Hour))
+		require.NoError(t, err)
+		return s
+	}
+	finalizers := func(t *testing.T, s *Server, name string) []string {
+		t.Helper()
+		app, err := s.appManager.Get(context.TODO(), name, "agent")
+		require.NoError(t, err)
+		return app.Finalizers
+	}
+
+	t.Run("Deletions not confirmed within the timeout are forced", func(t *testing.T) {
+		s := newServer(t,
+			deleting("expired", 2*time.Hour, nil),
+			deleting("pending", time.Minute, nil),
+			deleting("autonomous", 2*time.Hour, map[string]string{manager.SourceUIDAnnotation: "1234"}),
+		)
+		require.NoError(t, s.forceExpiredDeletions(context.TODO(), time.Now()))
+		assert.Empty(t, finalizers(t, s, "expired"))
+		assert.NotEmpty(t, finalizers(t, s, "pending"))
+		assert.NotEmpty(t, finalizers(t, s, "autonomous"))
+	})
+
+	t.Run("Deletion waits for the agent without annotation", func(t *testing.T) {
+		app := deleting("guestbook", time.Minute, nil)
+		s := newServer(t, app)
+		old := app.DeepCopy()
+		old.DeletionTimestamp = nil
+		assert.False(t, s.handleAppDeletion(context.TODO(), old, app, "agent", log()))
+		assert.NotEmpty(t, finalizers(t, s, "guestbook"))
+	})
+
+	t.Run("Deletion is forced by annotation", func(t *testing.T) {
+		app := deleting("guestbook", time.Minute, map[string]string{ForceDeletionAnnotation: "true"})
+		s := newServer(t, app)
+		assert.True(t, s.handleAppDeletion(context.TODO(), app, app, "agent", log()))
+		assert.Empty(t, finalizers(t, s, "guestbook"))
+	})
+
+	t.Run("Progress is only recorded on change", func(t *testing.T) {
+		tr := newDeletionTracker()
+		app := deleting("guestbook", time.Minute, nil)
+		app.Status.Resources = []v1alpha1.ResourceStatus{{Name: "a"}, {Name: "b"}}
+		assert.True(t, tr.progressed(app))
+		assert.False(t, tr.progressed(app))
+		app.Status.Resources = app.Status.Resources[:1]
+		assert.True(t, tr.progressed(app))
+		tr.forget(app)
+		assert.True(t, tr.progressed(app))
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		s := &Server{options: &ServerOptions{}}
+		assert.Error(t, WithDeletionTimeout(-time.Minute)(s))
+		assert.NoError(t, WithDeletionTimeout(0)(s))
+	})
+}
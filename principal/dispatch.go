@@ -0,0 +1,178 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// eventDispatcher decides which events received from agents may be processed
+// in parallel. Events of an agent with the same ordering key are processed
+// one after the other, in the order they were received. Barrier events, which
+// affect all resources of an agent, are only processed once all earlier
+// events of the agent have been processed, and before any later ones.
+type eventDispatcher struct {
+	metrics *metrics.PrincipalMetrics
+
+	mu sync.Mutex
+	// active holds the keys of the events being processed, along with the
+	// events of the same key waiting for them
+	active map[dispatchKey][]*cloudevents.Event
+	agents map[string]*agentDispatch
+}
+
+type dispatchKey struct {
+	agent string
+	key   string
+}
+
+// agentDispatch is the dispatch state of an agent's events
+type agentDispatch struct {
+	running int
+	waiting int
+	// held is a barrier event waiting for the running events to finish
+	held *cloudevents.Event
+	// barrier is whether a barrier event is being processed
+	barrier bool
+}
+
+func newEventDispatcher(m *metrics.PrincipalMetrics) *eventDispatcher {
+	return &eventDispatcher{
+		metrics: m,
+		active:  make(map[dispatchKey][]*cloudevents.Event),
+		agents:  make(map[string]*agentDispatch),
+	}
+}
+
+// dispatchOrderingKey returns the key of events of an agent that must be
+// processed in the order they were received, and whether ev is a barrier.
+// Events of the same resource share a key regardless of the resource's UID,
+// so that the deletion and re-creation of a resource stay in order.
+// Responses to requests are independent of each other, and all other events
+// are ordered by their target.
+func dispatchOrderingKey(ev *cloudevents.Event) (string, bool) {
+	target := event.Target(ev)
+	switch target {
+	case event.TargetApplication, event.TargetAppProject:
+		// Resource IDs are <name>_<uid>, and names can't contain underscores
+		name, _, _ := strings.Cut(event.ResourceID(ev), "_")
+		return target.String() + "/" + name, false
+	case event.TargetResource, event.TargetRedis, event.TargetLogFrame:
+		return target.String() + "/" + event.ResourceID(ev), false
+	case event.TargetResourceResync, event.TargetStateChecksum:
+		// Resyncs and state comparisons need the effects of all earlier
+		// events of the agent
+		return target.String(), true
+	}
+	return target.String(), false
+}
+
+func (d *eventDispatcher) agent(agentName string) *agentDispatch {
+	a, ok := d.agents[agentName]
+	if !ok {
+		a = &agentDispatch{}
+		d.agents[agentName] = a
+	}
+	return a
+}
+
+// blocked returns whether no further events of agentName may be dispatched,
+// because a barrier event is waiting or being processed
+func (d *eventDispatcher) blocked(agentName string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.agents[agentName]
+	return ok && (a.held != nil || a.barrier)
+}
+
+// release returns the barrier event of agentName held back by dispatch, once
+// all earlier events of the agent have been processed
+func (d *eventDispatcher) release(agentName string) *cloudevents.Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.agents[agentName]
+	if !ok || a.held == nil || a.running > 0 || a.waiting > 1 {
+		return nil
+	}
+	ev := a.held
+	a.held = nil
+	a.waiting--
+	d.updateMetrics(agentName, a)
+	return ev
+}
+
+// dispatch registers ev of agentName for processing, and returns its
+// ordering key. It returns false if the event must not be processed yet, in
+// which case it is returned by done or release once it may.
+func (d *eventDispatcher) dispatch(agentName string, ev *cloudevents.Event) (dispatchKey, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	k, barrier := dispatchOrderingKey(ev)
+	key := dispatchKey{agent: agentName, key: k}
+	a := d.agent(agentName)
+	defer d.updateMetrics(agentName, a)
+	if barrier && (a.running > 0 || a.waiting > 0) {
+		a.held = ev
+		a.waiting++
+		return key, false
+	}
+	if waiting, ok := d.active[key]; ok {
+		d.active[key] = append(waiting, ev)
+		a.waiting++
+		return key, false
+	}
+	d.active[key] = nil
+	a.running++
+	a.barrier = a.barrier || barrier
+	return key, true
+}
+
+// done marks the event with key as processed. It returns the next event
+// waiting for it, which must be processed next, or nil.
+func (d *eventDispatcher) done(agentName string, key dispatchKey) *cloudevents.Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a := d.agent(agentName)
+	defer d.updateMetrics(agentName, a)
+	if waiting := d.active[key]; len(waiting) > 0 {
+		d.active[key] = waiting[1:]
+		a.waiting--
+		return waiting[0]
+	}
+	delete(d.active, key)
+	a.running--
+	if a.barrier && a.running == 0 {
+		a.barrier = false
+	}
+	if a.running == 0 && a.waiting == 0 {
+		delete(d.agents, agentName)
+	}
+	return nil
+}
+
+// updateMetrics reports the dispatch state of agentName. Caller must hold
+// d.mu.
+func (d *eventDispatcher) updateMetrics(agentName string, a *agentDispatch) {
+	if d.metrics == nil || !d.metrics.PerAgent(agentName) {
+		return
+	}
+	d.metrics.EventDispatchRunning.WithLabelValues(agentName).Set(float64(a.running))
+	d.metrics.EventDispatchWaiting.WithLabelValues(agentName).Set(float64(a.waiting))
+}
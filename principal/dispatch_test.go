@@ -0,0 +1,108 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+func Test_eventDispatcher(t *testing.T) {
+	evs := event.NewEventSource("agent-1")
+	app := func(name, uid string) *cloudevents.Event {
+		return evs.ApplicationEvent(event.StatusUpdate, &v1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "argocd", UID: ktypes.UID(uid)},
+		})
+	}
+	resync := func() *cloudevents.Event {
+		ev, err := evs.RequestUpdateEvent(&event.RequestUpdate{Name: "app-1"})
+		require.NoError(t, err)
+		return ev
+	}
+
+	t.Run("Events of different resources run in parallel", func(t *testing.T) {
+		d := newEventDispatcher(nil)
+		k1, run1 := d.dispatch("agent-1", app("app-1", "1"))
+		k2, run2 := d.dispatch("agent-1", app("app-2", "2"))
+		assert.True(t, run1)
+		assert.True(t, run2)
+		assert.NotEqual(t, k1, k2)
+		assert.Nil(t, d.done("agent-1", k1))
+		assert.Nil(t, d.done("agent-1", k2))
+		assert.Empty(t, d.agents)
+	})
+
+	t.Run("Events of the same resource run in order", func(t *testing.T) {
+		d := newEventDispatcher(metrics.NewPrincipalMetricsWith(prometheus.NewRegistry()))
+		first, second, recreated := app("app-1", "1"), app("app-1", "1"), app("app-1", "2")
+		key, run := d.dispatch("agent-1", first)
+		require.True(t, run)
+		_, run = d.dispatch("agent-1", second)
+		assert.False(t, run)
+		_, run = d.dispatch("agent-1", recreated)
+		assert.False(t, run)
+		// The same resource of another agent is independent
+		_, run = d.dispatch("agent-2", app("app-1", "1"))
+		assert.True(t, run)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(d.metrics.EventDispatchRunning.WithLabelValues("agent-1")))
+		assert.Equal(t, float64(2), testutil.ToFloat64(d.metrics.EventDispatchWaiting.WithLabelValues("agent-1")))
+		assert.Same(t, second, d.done("agent-1", key))
+		assert.Same(t, recreated, d.done("agent-1", key))
+		assert.Nil(t, d.done("agent-1", key))
+		assert.Equal(t, float64(0), testutil.ToFloat64(d.metrics.EventDispatchRunning.WithLabelValues("agent-1")))
+		assert.Equal(t, float64(0), testutil.ToFloat64(d.metrics.EventDispatchWaiting.WithLabelValues("agent-1")))
+	})
+
+	t.Run("Barriers wait for earlier events and block later ones", func(t *testing.T) {
+		d := newEventDispatcher(nil)
+		key, run := d.dispatch("agent-1", app("app-1", "1"))
+		require.True(t, run)
+
+		barrier := resync()
+		_, run = d.dispatch("agent-1", barrier)
+		assert.False(t, run)
+		assert.True(t, d.blocked("agent-1"))
+		assert.False(t, d.blocked("agent-2"))
+		assert.Nil(t, d.release("agent-1"))
+
+		assert.Nil(t, d.done("agent-1", key))
+		assert.Same(t, barrier, d.release("agent-1"))
+		barrierKey, run := d.dispatch("agent-1", barrier)
+		require.True(t, run)
+		assert.True(t, d.blocked("agent-1"))
+		assert.Nil(t, d.done("agent-1", barrierKey))
+		assert.False(t, d.blocked("agent-1"))
+	})
+
+	t.Run("Barriers of an idle agent run immediately", func(t *testing.T) {
+		d := newEventDispatcher(nil)
+		key, run := d.dispatch("agent-1", resync())
+		require.True(t, run)
+		assert.True(t, d.blocked("agent-1"))
+		assert.Nil(t, d.done("agent-1", key))
+		assert.False(t, d.blocked("agent-1"))
+	})
+}
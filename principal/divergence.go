@@ -0,0 +1,278 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+// divergenceKind tells how the state of an Application on the principal
+// differs from the state on the agent
+type divergenceKind string
+
+const (
+	// divergedSpec means the specs differ
+	divergedSpec divergenceKind = "spec"
+	// divergedStatus means the sync or health status differ
+	divergedStatus divergenceKind = "status"
+	// divergedMissingOnAgent means only the principal has the Application
+	divergedMissingOnAgent divergenceKind = "missing_on_agent"
+	// divergedMissingOnPrincipal means only the agent has the Application
+	divergedMissingOnPrincipal divergenceKind = "missing_on_principal"
+)
+
+// divergedApplication is an Application whose state differs between
+// principal and agent
+type divergedApplication struct {
+	uid  string
+	name string
+	kind divergenceKind
+	// app is the principal's copy, if it has one
+	app *v1alpha1.Application
+}
+
+// divergenceState remembers the Applications that diverged in the last state
+// checksum report of each agent. A divergence is only acted upon if it is
+// reported twice in a row, because the state of an Application may differ
+// while an update is in flight.
+type divergenceState struct {
+	mu      sync.Mutex
+	pending map[string]map[string]divergenceKind
+}
+
+func newDivergenceState() *divergenceState {
+	return &divergenceState{pending: make(map[string]map[string]divergenceKind)}
+}
+
+// confirm records the divergences found for agentName and returns the ones
+// that were already found in the previous report. Confirmed divergences are
+// forgotten, so that they are only acted upon again after two more reports.
+func (d *divergenceState) confirm(agentName string, found []divergedApplication) []divergedApplication {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	previous := d.pending[agentName]
+	pending := make(map[string]divergenceKind)
+	confirmed := []divergedApplication{}
+	for _, da := range found {
+		if kind, ok := previous[da.uid]; ok && kind == da.kind {
+			confirmed = append(confirmed, da)
+			continue
+		}
+		pending[da.uid] = da.kind
+	}
+	if len(pending) == 0 {
+		delete(d.pending, agentName)
+	} else {
+		d.pending[agentName] = pending
+	}
+	return confirmed
+}
+
+// forget drops the pending divergences of agentName
+func (d *divergenceState) forget(agentName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, agentName)
+}
+
+// processStateChecksum compares the state checksums reported by the agent
+// with the principal's state, and resyncs the Applications whose state
+// diverged.
+func (s *Server) processStateChecksum(ctx context.Context, agentName string, ev *cloudevents.Event) error {
+	report, err := event.New(ev, event.TargetStateChecksum).StateChecksum()
+	if err != nil {
+		return fmt.Errorf("invalid state checksum report: %w", err)
+	}
+	mode := s.agentMode(agentName)
+	if mode == types.AgentModeUnknown {
+		return fmt.Errorf("mode of agent %s is unknown", agentName)
+	}
+	logCtx := s.logGrpcEvent().WithFields(logrus.Fields{
+		"module": "QueueProcessor",
+		"client": agentName,
+		"mode":   mode.String(),
+	})
+
+	apps, err := s.exchangedApplications(ctx, agentName, mode)
+	if err != nil {
+		return err
+	}
+	checksums := make([]event.ApplicationChecksum, 0, len(apps))
+	for uid, app := range apps {
+		checksums = append(checksums, resync.NewApplicationChecksum(uid, s.applicationForChecksum(agentName, mode, app)))
+	}
+	if resync.AggregateChecksum(checksums) == report.Checksum {
+		logCtx.Trace("State of agent matches the principal")
+		s.divergence.forget(agentName)
+		return nil
+	}
+
+	found := diffStateChecksums(checksums, report.Applications, apps)
+	confirmed := s.divergence.confirm(agentName, found)
+	logCtx.WithFields(logrus.Fields{
+		"diverged":  len(found),
+		"confirmed": len(confirmed),
+	}).Debug("State of agent differs from the principal")
+	if len(confirmed) == 0 {
+		return nil
+	}
+	return s.resyncDivergedApplications(ctx, agentName, mode, confirmed, logCtx)
+}
+
+// exchangedApplications returns the principal's copies of the Applications
+// it exchanges with the agent, keyed by the UID they are known by on both
+// sides.
+func (s *Server) exchangedApplications(ctx context.Context, agentName string, mode types.AgentMode) (map[string]*v1alpha1.Application, error) {
+	list, err := s.appManager.List(ctx, backend.ApplicationSelector{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list applications: %w", err)
+	}
+	filters := s.defaultAppFilterChain()
+	apps := make(map[string]*v1alpha1.Application)
+	for i := range list {
+		app := &list[i]
+		if !filters.Admit(app) || app.DeletionTimestamp != nil {
+			continue
+		}
+		sourceUID, fromAgent := app.Annotations[manager.SourceUIDAnnotation]
+		switch {
+		case mode.IsAutonomous() && fromAgent && app.Namespace == agentName:
+			apps[sourceUID] = app
+		case mode.IsManaged() && !fromAgent && s.getAgentNameForApp(app) == agentName:
+			apps[string(app.UID)] = app
+		}
+	}
+	return apps, nil
+}
+
+// applicationForChecksum returns app as the agent knows it. The projects of
+// Applications of autonomous agents are prefixed with the agent's name on
+// the principal.
+func (s *Server) applicationForChecksum(agentName string, mode types.AgentMode, app *v1alpha1.Application) *v1alpha1.Application {
+	if !mode.IsAutonomous() {
+		return app
+	}
+	out := app.DeepCopy()
+	out.Spec.Project = strings.TrimPrefix(out.Spec.Project, agentName+"-")
+	return out
+}
+
+// diffStateChecksums returns the Applications whose checksums differ between
+// the principal's and the agent's, ordered by UID
+func diffStateChecksums(principal, agent []event.ApplicationChecksum, apps map[string]*v1alpha1.Application) []divergedApplication {
+	onAgent := make(map[string]event.ApplicationChecksum, len(agent))
+	for _, c := range agent {
+		onAgent[c.UID] = c
+	}
+	diverged := []divergedApplication{}
+	for _, p := range principal {
+		a, ok := onAgent[p.UID]
+		delete(onAgent, p.UID)
+		da := divergedApplication{uid: p.UID, name: p.Name, app: apps[p.UID]}
+		switch {
+		case !ok:
+			da.kind = divergedMissingOnAgent
+		case a.Spec != p.Spec:
+			da.kind = divergedSpec
+		case a.Status != p.Status:
+			da.kind = divergedStatus
+		default:
+			continue
+		}
+		diverged = append(diverged, da)
+	}
+	for _, a := range onAgent {
+		diverged = append(diverged, divergedApplication{uid: a.UID, name: a.Name, kind: divergedMissingOnPrincipal})
+	}
+	sort.Slice(diverged, func(i, j int) bool { return diverged[i].uid < diverged[j].uid })
+	return diverged
+}
+
+// resyncDivergedApplications resyncs the given Applications with the agent.
+// The side that owns the diverged state sends it to the other side:
+//
+//   - The principal owns the spec of Applications of managed agents, and
+//     re-sends them or asks the agent to delete Applications it doesn't know.
+//   - The agent owns the status of Applications of managed agents, and
+//     everything of Applications of autonomous agents. It is asked to send
+//     them, or asked whether it still has Applications the principal has.
+func (s *Server) resyncDivergedApplications(ctx context.Context, agentName string, mode types.AgentMode, diverged []divergedApplication, logCtx *logrus.Entry) error {
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return fmt.Errorf("no send queue found for agent: %s", agentName)
+	}
+	request := &event.DivergedApplications{}
+	for _, da := range diverged {
+		logCtx.WithFields(logrus.Fields{
+			"application": da.name,
+			"uid":         da.uid,
+			"divergence":  da.kind,
+		}).Warn("Application diverged between principal and agent, resyncing it")
+		if s.metrics != nil {
+			s.metrics.ApplicationDivergences.WithLabelValues(s.metrics.AgentLabel(agentName), string(da.kind)).Inc()
+		}
+
+		switch {
+		case mode.IsManaged() && (da.kind == divergedSpec || da.kind == divergedMissingOnAgent):
+			out := da.app.DeepCopy()
+			out.Operation = nil
+			ev := s.events.ApplicationEvent(event.SpecUpdate, out)
+			s.stampEvent(ctx, ev)
+			q.Add(ev)
+		case mode.IsManaged() && da.kind == divergedMissingOnPrincipal:
+			ev := s.events.ApplicationEvent(event.Delete, &v1alpha1.Application{
+				ObjectMeta: metav1.ObjectMeta{Name: da.name, Namespace: agentName, UID: ktypes.UID(da.uid)},
+			})
+			s.stampEvent(ctx, ev)
+			q.Add(ev)
+		case mode.IsAutonomous() && da.kind == divergedMissingOnAgent:
+			// The agent answers with the Application, or with its deletion
+			ev, err := s.events.RequestUpdateEvent(event.NewRequestUpdate(da.app.Name, da.app.Namespace, "Application", da.uid, nil))
+			if err != nil {
+				return err
+			}
+			q.Add(ev)
+		default:
+			request.UIDs = append(request.UIDs, da.uid)
+			// Autonomous agents report how the principal's spec drifted
+			// from theirs
+			if mode.IsAutonomous() && da.kind == divergedSpec && da.app != nil {
+				if request.Specs == nil {
+					request.Specs = make(map[string]v1alpha1.ApplicationSpec)
+				}
+				request.Specs[da.uid] = resync.SpecForComparison(s.applicationForChecksum(agentName, mode, da.app))
+			}
+		}
+	}
+	if len(request.UIDs) > 0 {
+		q.Add(s.events.ApplicationsDivergedEvent(request))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_processStateChecksum(t *testing.T) {
+	agentEvents := event.NewEventSource("agent-1")
+	report := func(apps ...event.ApplicationChecksum) *event.Event {
+		r := &event.StateChecksum{Applications: apps, Checksum: resync.AggregateChecksum(apps)}
+		return event.New(agentEvents.StateChecksumEvent(r), event.TargetStateChecksum)
+	}
+	checksum := func(uid, name string, spec v1alpha1.ApplicationSpec, status v1alpha1.ApplicationStatus) event.ApplicationChecksum {
+		return resync.NewApplicationChecksum(uid, &v1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       spec,
+			Status:     status,
+		})
+	}
+	drain := func(s *Server) []*event.Event {
+		var evs []*event.Event
+		q := s.queues.SendQ("agent-1")
+		for q.Len() > 0 {
+			ev, _ := q.Get()
+			q.Done(ev)
+			evs = append(evs, event.New(ev, event.Target(ev)))
+		}
+		return evs
+	}
+
+	t.Run("Matching state is not resynced", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		ev := report(
+			checksum("app-1", "app-1", v1alpha1.ApplicationSpec{}, v1alpha1.ApplicationStatus{}),
+			checksum("app-2", "app-2", v1alpha1.ApplicationSpec{}, v1alpha1.ApplicationStatus{}),
+		)
+		for range 2 {
+			require.NoError(t, s.processStateChecksum(context.TODO(), "agent-1", ev.CloudEvent()))
+		}
+		assert.Empty(t, drain(s))
+	})
+
+	t.Run("Divergence of managed agents is resynced when confirmed", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		ev := report(
+			// app-1 differs in status, app-2 in spec
+			checksum("app-1", "app-1", v1alpha1.ApplicationSpec{}, v1alpha1.ApplicationStatus{
+				Sync: v1alpha1.SyncStatus{Status: v1alpha1.SyncStatusCodeOutOfSync},
+			}),
+			checksum("app-2", "app-2", v1alpha1.ApplicationSpec{Project: "other"}, v1alpha1.ApplicationStatus{}),
+			// deleted only exists on the agent
+			checksum("deleted", "deleted", v1alpha1.ApplicationSpec{}, v1alpha1.ApplicationStatus{}),
+		)
+
+		// The first report may have raced with an update
+		require.NoError(t, s.processStateChecksum(context.TODO(), "agent-1", ev.CloudEvent()))
+		assert.Empty(t, drain(s))
+
+		require.NoError(t, s.processStateChecksum(context.TODO(), "agent-1", ev.CloudEvent()))
+		byType := map[string][]string{}
+		for _, ev := range drain(s) {
+			switch ev.Target() {
+			case event.TargetApplication:
+				app, err := ev.Application()
+				require.NoError(t, err)
+				byType[ev.Type().String()] = append(byType[ev.Type().String()], app.Name)
+			case event.TargetStateChecksum:
+				diverged, err := ev.DivergedApplications()
+				require.NoError(t, err)
+				byType[ev.Type().String()] = diverged.UIDs
+			}
+		}
+		assert.Equal(t, map[string][]string{
+			event.SpecUpdate.String():           {"app-2"},
+			event.Delete.String():               {"deleted"},
+			event.ApplicationsDiverged.String(): {"app-1"},
+		}, byType)
+
+		// Confirmed divergences need to be confirmed again
+		require.NoError(t, s.processStateChecksum(context.TODO(), "agent-1", ev.CloudEvent()))
+		assert.Empty(t, drain(s))
+	})
+
+	t.Run("Applications missing on the agent are re-sent", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		ev := report(checksum("app-1", "app-1", v1alpha1.ApplicationSpec{}, v1alpha1.ApplicationStatus{}))
+		for range 2 {
+			require.NoError(t, s.processStateChecksum(context.TODO(), "agent-1", ev.CloudEvent()))
+		}
+		evs := drain(s)
+		require.Len(t, evs, 1)
+		assert.Equal(t, event.SpecUpdate, evs[0].Type())
+		app, err := evs[0].Application()
+		require.NoError(t, err)
+		assert.Equal(t, "app-2", app.Name)
+		assert.Nil(t, app.Operation)
+	})
+
+	t.Run("Projects of autonomous agents are compared without prefix", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		s.setAgentMode("agent-1", types.AgentModeAutonomous)
+		app := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{Project: "agent-1-default"}}
+		out := s.applicationForChecksum("agent-1", types.AgentModeAutonomous, app)
+		assert.Equal(t, "default", out.Spec.Project)
+		assert.Equal(t, "agent-1-default", app.Spec.Project)
+
+		apps, err := s.exchangedApplications(context.TODO(), "agent-1", types.AgentModeAutonomous)
+		require.NoError(t, err)
+		require.Len(t, apps, 1)
+		assert.Equal(t, "autonomous", apps["1234"].Name)
+	})
+}
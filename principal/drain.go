@@ -0,0 +1,139 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
+)
+
+const (
+	// defaultShutdownReconnectDelay is the reconnect delay suggested to
+	// agents and clients when the principal shuts down
+	defaultShutdownReconnectDelay = 5 * time.Second
+
+	// drainPollInterval is how often we check whether draining is complete
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// drainState tracks the draining of connections while the principal shuts
+// down. Draining starts by refusing new requests and telling agents when to
+// reconnect. Requests in flight get until the end of the shutdown grace
+// period to finish, after which they are ended with a retryable error.
+type drainState struct {
+	once sync.Once
+	// started is closed when draining starts
+	started chan struct{}
+	// expired is closed when the grace period for requests in flight is over
+	expired chan struct{}
+	// staticLogs is the number of static log requests in flight
+	staticLogs atomic.Int64
+}
+
+func newDrainState() *drainState {
+	return &drainState{
+		started: make(chan struct{}),
+		expired: make(chan struct{}),
+	}
+}
+
+// isDraining returns whether the principal is shutting down
+func (s *Server) isDraining() bool {
+	select {
+	case <-s.drain.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeUnavailable tells an HTTP client that the principal is shutting down
+// and when to retry.
+func (s *Server) writeUnavailable(w http.ResponseWriter) {
+	seconds := int(math.Ceil(s.options.shutdownReconnectDelay.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	w.Header().Set("Connection", "close")
+	http.Error(w, "principal is shutting down", http.StatusServiceUnavailable)
+}
+
+// refuseWhileDraining wraps a resource proxy handler, so that it refuses new
+// requests once the principal is shutting down.
+func (s *Server) refuseWhileDraining(fn resourceproxy.HandlerFunc) resourceproxy.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params resourceproxy.Params) {
+		if s.isDraining() {
+			log().WithField("method", r.Method).Debugf("Refusing request for %s: principal is shutting down", r.URL.Path)
+			s.writeUnavailable(w)
+			return
+		}
+		fn(w, r, params)
+	}
+}
+
+// drainConnections drains the connections of agents and clients before the
+// principal shuts down. Connected agents are sent a drain notice with the
+// suggested reconnect delay, and static log requests in flight may finish
+// until the grace period is over. Afterwards, all agents are disconnected.
+func (s *Server) drainConnections() {
+	s.drain.once.Do(func() {
+		logCtx := log().WithField("grace_period", s.options.gracePeriod)
+		logCtx.Info("Draining connections")
+		close(s.drain.started)
+		defer close(s.drain.expired)
+		if s.eventStreamSrv == nil {
+			return
+		}
+
+		agents := s.eventStreamSrv.ConnectedAgents()
+		for _, agentName := range agents {
+			ev, err := s.events.DrainEvent(s.options.shutdownReconnectDelay)
+			if err != nil {
+				logCtx.WithError(err).Error("Could not create drain event")
+				break
+			}
+			if q := s.queues.SendQ(agentName); q != nil {
+				q.Add(ev)
+			}
+		}
+
+		deadline := time.Now().Add(s.options.gracePeriod)
+		for time.Now().Before(deadline) && !s.drained(agents) {
+			time.Sleep(drainPollInterval)
+		}
+		if n := s.drain.staticLogs.Load(); n > 0 {
+			logCtx.Warnf("Grace period is over, ending %d log requests in flight", n)
+		}
+		s.eventStreamSrv.DisconnectAll()
+	})
+}
+
+// drained returns whether the drain notice was sent to all given agents and
+// no static log requests are in flight anymore.
+func (s *Server) drained(agents []string) bool {
+	if s.drain.staticLogs.Load() > 0 {
+		return false
+	}
+	for _, agentName := range agents {
+		if q := s.queues.SendQ(agentName); q != nil && q.Len() > 0 && s.isAgentConnected(agentName) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDrainTestServer(t *testing.T, opts ...ServerOption) *Server {
+	t.Helper()
+	opts = append([]ServerOption{WithGeneratedTokenSigningKey(), WithRedisProxyDisabled()}, opts...)
+	s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd"), "argocd", opts...)
+	require.NoError(t, err)
+	s.events = event.NewEventSource("test")
+	s.eventStreamSrv = eventstream.NewServer(s.queues, event.NewEventWritersMap(), nil, &cluster.Manager{})
+	return s
+}
+
+func Test_RefuseWhileDraining(t *testing.T) {
+	s := newDrainTestServer(t, WithShutdownReconnectDelay(1500*time.Millisecond))
+	called := false
+	handler := s.refuseWhileDraining(func(w http.ResponseWriter, r *http.Request, params resourceproxy.Params) {
+		called = true
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/pods", nil), nil)
+	assert.True(t, called)
+
+	called = false
+	close(s.drain.started)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/pods", nil), nil)
+	assert.False(t, called)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func Test_DrainConnections(t *testing.T) {
+	t.Run("Connected agents receive a drain notice", func(t *testing.T) {
+		s := newDrainTestServer(t, WithShutDownGracePeriod(time.Second), WithShutdownReconnectDelay(3*time.Second))
+		require.NoError(t, s.queues.Create("agent-1"))
+		s.eventStreamSrv.MarkConnected("agent-1")
+		defer s.eventStreamSrv.MarkDisconnected("agent-1")
+
+		go s.drainConnections()
+		var ev *event.Event
+		require.Eventually(t, func() bool {
+			q := s.queues.SendQ("agent-1")
+			if q.Len() == 0 {
+				return false
+			}
+			cev, _ := q.Get()
+			q.Done(cev)
+			ev = event.New(cev, event.Target(cev))
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, event.Drain, ev.Type())
+		n, err := ev.DrainNotice()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3000), n.ReconnectAfter)
+
+		select {
+		case <-s.drain.expired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("draining did not finish")
+		}
+		assert.True(t, s.isDraining())
+	})
+
+	t.Run("Static log requests get until the end of the grace period", func(t *testing.T) {
+		s := newDrainTestServer(t, WithShutDownGracePeriod(300*time.Millisecond))
+		s.drain.staticLogs.Add(1)
+		start := time.Now()
+		s.drainConnections()
+		assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+		select {
+		case <-s.drain.expired:
+		default:
+			t.Fatal("grace period did not expire")
+		}
+	})
+
+	t.Run("Draining ends early when nothing is in flight", func(t *testing.T) {
+		s := newDrainTestServer(t, WithShutDownGracePeriod(time.Minute))
+		start := time.Now()
+		s.drainConnections()
+		assert.Less(t, time.Since(start), 10*time.Second)
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/validation"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// driftPattern is the pattern on the admin server under which the drift
+	// summary of an agent is served
+	driftPattern = "GET /agents/{name}/drift"
+	// driftRetention is the time after which drift that wasn't observed
+	// again is dropped from the summary
+	driftRetention = 24 * time.Hour
+	// maxDriftedApplications is the maximum number of Applications whose
+	// drift is kept per agent
+	maxDriftedApplications = 500
+)
+
+const (
+	// DriftDetectedByAgent means the agent found that the principal's copy
+	// of an Application differs from its own
+	DriftDetectedByAgent = "agent"
+	// DriftDetectedByPrincipal means the principal's copy of an Application
+	// was modified on the principal, and the modification reverted
+	DriftDetectedByPrincipal = "principal"
+)
+
+// ApplicationDrift is the latest drift of an Application of an autonomous
+// agent, i.e. how the principal's copy differed from the agent's before it
+// was overwritten.
+type ApplicationDrift struct {
+	// Name and Namespace are those of the Application on the principal
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// UID is the UID of the Application on the agent
+	UID         string               `json:"uid"`
+	DetectedBy  string               `json:"detectedBy"`
+	DetectedAt  time.Time            `json:"detectedAt"`
+	Occurrences int                  `json:"occurrences"`
+	Fields      []event.DriftedField `json:"fields"`
+}
+
+// DriftSummary is the drift of the Applications of an agent, as served by
+// the admin server
+type DriftSummary struct {
+	Agent        string             `json:"agent"`
+	Applications []ApplicationDrift `json:"applications"`
+}
+
+// driftState keeps the latest drift of the Applications of each autonomous
+// agent, keyed by the UID of the Application on the agent. All methods of
+// driftState may be called on a nil receiver, in which case no drift is
+// kept.
+type driftState struct {
+	mu     sync.Mutex
+	agents map[string]map[string]*ApplicationDrift
+}
+
+func newDriftState() *driftState {
+	return &driftState{agents: make(map[string]map[string]*ApplicationDrift)}
+}
+
+// record records drift of an Application of agentName, counting how often
+// the Application drifted.
+func (d *driftState) record(agentName string, drift ApplicationDrift) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	apps, ok := d.agents[agentName]
+	if !ok {
+		apps = make(map[string]*ApplicationDrift)
+		d.agents[agentName] = apps
+	}
+	drift.Occurrences = 1
+	if prev, ok := apps[drift.UID]; ok {
+		drift.Occurrences = prev.Occurrences + 1
+	} else if len(apps) >= maxDriftedApplications {
+		d.evictOldest(apps)
+	}
+	apps[drift.UID] = &drift
+}
+
+func (d *driftState) evictOldest(apps map[string]*ApplicationDrift) {
+	oldest := ""
+	for uid, drift := range apps {
+		if oldest == "" || drift.DetectedAt.Before(apps[oldest].DetectedAt) {
+			oldest = uid
+		}
+	}
+	delete(apps, oldest)
+}
+
+// summary returns the drift of the Applications of agentName observed within
+// the retention, ordered by the Applications' names.
+func (d *driftState) summary(agentName string, now time.Time) *DriftSummary {
+	summary := &DriftSummary{Agent: agentName, Applications: []ApplicationDrift{}}
+	if d == nil {
+		return summary
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	apps := d.agents[agentName]
+	for uid, drift := range apps {
+		if now.Sub(drift.DetectedAt) > driftRetention {
+			delete(apps, uid)
+			continue
+		}
+		summary.Applications = append(summary.Applications, *drift)
+	}
+	if len(apps) == 0 {
+		delete(d.agents, agentName)
+	}
+	sort.Slice(summary.Applications, func(i, j int) bool {
+		a, b := summary.Applications[i], summary.Applications[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.UID < b.UID
+	})
+	return summary
+}
+
+// processDriftReport records the drift an autonomous agent found between
+// the principal's copy of an Application and its own. The principal's copy
+// is overwritten by the Application the agent sends after the report.
+func (s *Server) processDriftReport(_ context.Context, agentName string, ev *cloudevents.Event) error {
+	report, err := event.New(ev, event.TargetDrift).DriftReport()
+	if err != nil {
+		return fmt.Errorf("invalid drift report: %w", err)
+	}
+	if !s.agentMode(agentName).IsAutonomous() {
+		return fmt.Errorf("drift report from agent %s, which is not autonomous", agentName)
+	}
+	// Applications of autonomous agents live in the agent's namespace on the
+	// principal
+	s.recordDrift(agentName, ApplicationDrift{
+		Name:       report.Name,
+		Namespace:  agentName,
+		UID:        report.UID,
+		DetectedBy: DriftDetectedByAgent,
+		DetectedAt: report.DetectedAt,
+		Fields:     report.Fields,
+	})
+	return nil
+}
+
+// recordHubDrift records how the principal's copy of app, an Application of
+// an autonomous agent, was modified on the principal. It is called before
+// the modification is reverted to the spec last received from the agent.
+func (s *Server) recordHubDrift(agentName string, app *v1alpha1.Application) {
+	sourceUID, ok := app.Annotations[manager.SourceUIDAnnotation]
+	if !ok {
+		return
+	}
+	spoke, ok := s.sourceCache.Application.Get(ktypes.UID(sourceUID))
+	if !ok {
+		return
+	}
+	fields := resync.DiffSpecs(app.Spec, spoke)
+	if len(fields) == 0 {
+		return
+	}
+	s.recordDrift(agentName, ApplicationDrift{
+		Name:       app.Name,
+		Namespace:  app.Namespace,
+		UID:        sourceUID,
+		DetectedBy: DriftDetectedByPrincipal,
+		DetectedAt: time.Now(),
+		Fields:     fields,
+	})
+}
+
+func (s *Server) recordDrift(agentName string, drift ApplicationDrift) {
+	if drift.DetectedAt.IsZero() {
+		drift.DetectedAt = time.Now()
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"agent":       agentName,
+		"application": drift.Namespace + "/" + drift.Name,
+		"detected_by": drift.DetectedBy,
+	})
+	for _, f := range drift.Fields {
+		logCtx.Warnf("Spec of application drifted from the agent: %s is %s on the principal, but %s on the agent", f.Path, valueOrUnset(f.Hub), valueOrUnset(f.Spoke))
+	}
+	s.drift.record(agentName, drift)
+	if s.metrics != nil {
+		s.metrics.ApplicationDrifts.WithLabelValues(s.metrics.AgentLabel(agentName), drift.DetectedBy).Inc()
+	}
+}
+
+func valueOrUnset(v string) string {
+	if v == "" {
+		return "unset"
+	}
+	return v
+}
+
+// processDrift serves the drift summary of an agent as JSON
+func (s *Server) processDrift(w http.ResponseWriter, r *http.Request) {
+	agentName := r.PathValue("name")
+	if errs := validation.NameIsDNSLabel(agentName, false); len(errs) > 0 {
+		http.Error(w, "invalid agent name", http.StatusBadRequest)
+		return
+	}
+	auditLog().WithFields(logrus.Fields{
+		"agent":       agentName,
+		"operator":    adminOperator(r),
+		"remote_addr": r.RemoteAddr,
+	}).Debug("Drift summary of agent requested")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.drift.summary(agentName, time.Now()))
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_driftState(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Drift is counted per application", func(t *testing.T) {
+		d := newDriftState()
+		d.record("agent-1", ApplicationDrift{Name: "b", UID: "uid-b", DetectedAt: now})
+		d.record("agent-1", ApplicationDrift{Name: "a", UID: "uid-a", DetectedAt: now.Add(-time.Minute)})
+		d.record("agent-1", ApplicationDrift{Name: "a", UID: "uid-a", DetectedAt: now})
+		d.record("agent-2", ApplicationDrift{Name: "c", UID: "uid-c", DetectedAt: now})
+
+		summary := d.summary("agent-1", now)
+		assert.Equal(t, "agent-1", summary.Agent)
+		require.Len(t, summary.Applications, 2)
+		assert.Equal(t, "a", summary.Applications[0].Name)
+		assert.Equal(t, 2, summary.Applications[0].Occurrences)
+		assert.Equal(t, now, summary.Applications[0].DetectedAt)
+		assert.Equal(t, 1, summary.Applications[1].Occurrences)
+	})
+
+	t.Run("Old drift is dropped", func(t *testing.T) {
+		d := newDriftState()
+		d.record("agent-1", ApplicationDrift{Name: "a", UID: "uid-a", DetectedAt: now.Add(-2 * driftRetention)})
+		assert.Empty(t, d.summary("agent-1", now).Applications)
+		assert.Empty(t, d.agents)
+	})
+
+	t.Run("Number of applications is bounded", func(t *testing.T) {
+		d := newDriftState()
+		for i := range maxDriftedApplications + 1 {
+			d.record("agent-1", ApplicationDrift{UID: string(rune('a' + i)), DetectedAt: now.Add(time.Duration(i) * time.Second)})
+		}
+		assert.Len(t, d.agents["agent-1"], maxDriftedApplications)
+		assert.NotContains(t, d.agents["agent-1"], "a")
+	})
+}
+
+func Test_processDriftReport(t *testing.T) {
+	report := &event.DriftReport{
+		Name:       "autonomous",
+		Namespace:  "argocd",
+		UID:        "1234",
+		DetectedAt: time.Now(),
+		Fields:     []event.DriftedField{{Path: "source.targetRevision", Hub: `"main"`, Spoke: `"v1"`}},
+	}
+	ev := event.NewEventSource("agent-1").DriftEvent(report)
+
+	t.Run("Drift of autonomous agents is recorded", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		s.setAgentMode("agent-1", types.AgentModeAutonomous)
+		require.NoError(t, s.processDriftReport(context.TODO(), "agent-1", ev))
+		summary := s.drift.summary("agent-1", time.Now())
+		require.Len(t, summary.Applications, 1)
+		drift := summary.Applications[0]
+		assert.Equal(t, "agent-1", drift.Namespace)
+		assert.Equal(t, DriftDetectedByAgent, drift.DetectedBy)
+		assert.Equal(t, report.Fields, drift.Fields)
+	})
+
+	t.Run("Reports of managed agents are refused", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		assert.Error(t, s.processDriftReport(context.TODO(), "agent-1", ev))
+		assert.Empty(t, s.drift.summary("agent-1", time.Now()).Applications)
+	})
+}
+
+func Test_recordHubDrift(t *testing.T) {
+	s := newResyncTestServer(t)
+	s.setAgentMode("agent-1", types.AgentModeAutonomous)
+	app := &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "autonomous", Namespace: "agent-1", Annotations: map[string]string{manager.SourceUIDAnnotation: "1234"}},
+		Spec:       v1alpha1.ApplicationSpec{Project: "agent-1-default"},
+	}
+
+	// Nothing is known about the agent's spec yet
+	s.recordHubDrift("agent-1", app)
+	assert.Empty(t, s.drift.summary("agent-1", time.Now()).Applications)
+
+	s.sourceCache.Application.Set("1234", v1alpha1.ApplicationSpec{Project: "agent-1-default"})
+	s.recordHubDrift("agent-1", app)
+	assert.Empty(t, s.drift.summary("agent-1", time.Now()).Applications)
+
+	app.Spec.Project = "agent-1-other"
+	s.recordHubDrift("agent-1", app)
+	summary := s.drift.summary("agent-1", time.Now())
+	require.Len(t, summary.Applications, 1)
+	assert.Equal(t, DriftDetectedByPrincipal, summary.Applications[0].DetectedBy)
+	assert.Equal(t, []event.DriftedField{{Path: "project", Hub: `"agent-1-other"`, Spoke: `"agent-1-default"`}}, summary.Applications[0].Fields)
+}
+
+func Test_resyncDivergedSpecsOfAutonomousAgents(t *testing.T) {
+	s := newResyncTestServer(t)
+	s.setAgentMode("agent-1", types.AgentModeAutonomous)
+	app := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{
+		Project:     "agent-1-default",
+		Destination: v1alpha1.ApplicationDestination{Name: "agent-1"},
+	}}
+	diverged := []divergedApplication{
+		{uid: "1234", name: "autonomous", kind: divergedSpec, app: app},
+		{uid: "5678", name: "other", kind: divergedStatus, app: app},
+	}
+	require.NoError(t, s.resyncDivergedApplications(context.TODO(), "agent-1", types.AgentModeAutonomous, diverged, log()))
+
+	q := s.queues.SendQ("agent-1")
+	require.Equal(t, 1, q.Len())
+	ev, _ := q.Get()
+	request, err := event.New(ev, event.TargetStateChecksum).DivergedApplications()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1234", "5678"}, request.UIDs)
+	// Only specs that diverged are sent, as the agent knows them
+	assert.Equal(t, map[string]v1alpha1.ApplicationSpec{"1234": {Project: "default"}}, request.Specs)
+}
+
+func Test_processDrift(t *testing.T) {
+	s := newResyncTestServer(t)
+	s.drift.record("agent-1", ApplicationDrift{Name: "autonomous", UID: "1234", DetectedBy: DriftDetectedByAgent, DetectedAt: time.Now()})
+	mux := http.NewServeMux()
+	mux.HandleFunc(driftPattern, s.processDrift)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agents/agent-1/drift", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	summary := &DriftSummary{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), summary))
+	require.Len(t, summary.Applications, 1)
+	assert.Equal(t, "autonomous", summary.Applications[0].Name)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agents/Agent_1/drift", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
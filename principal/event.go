@@ -25,13 +25,13 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/internal/manager"
 	"github.com/argoproj-labs/argocd-agent/internal/metrics"
-	"github.com/argoproj-labs/argocd-agent/internal/namedlock"
 	"github.com/argoproj-labs/argocd-agent/internal/resync"
 	"github.com/argoproj-labs/argocd-agent/internal/tracing"
 	"github.com/argoproj-labs/argocd-agent/pkg/replication"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/semaphore"
@@ -39,7 +39,6 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation"
-	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -48,7 +47,7 @@ import (
 // on promotion.
 func skipReplication(target event.EventTarget) bool {
 	switch target {
-	case event.TargetHeartbeat, event.TargetClusterCacheInfoUpdate:
+	case event.TargetHeartbeat, event.TargetClusterCacheInfoUpdate, event.TargetAgentConfig, event.TargetPermissions, event.TargetResourceFilter, event.TargetStateChecksum, event.TargetAgentUpdate, event.TargetDrift, event.TargetLogCancel, event.TargetLogFrame:
 		return true
 	default:
 		return false
@@ -59,8 +58,17 @@ func skipReplication(target event.EventTarget) bool {
 // events received by agents. It will trigger updates of resources in the
 // server's backend.
 func (s *Server) processRecvQueue(ctx context.Context, agentName string, q workqueue.TypedRateLimitingInterface[*cloudevents.Event]) (*cloudevents.Event, error) {
-	status := metrics.EventProcessingSuccess
 	ev, _ := q.Get()
+	err := s.processEvent(ctx, agentName, ev)
+	// Mark event as processed
+	q.Done(ev)
+	return ev, err
+}
+
+// processEvent processes an event received from agentName, which has been
+// taken from the agent's receiver queue.
+func (s *Server) processEvent(ctx context.Context, agentName string, ev *cloudevents.Event) error {
+	status := metrics.EventProcessingSuccess
 
 	logCtx := s.logGrpcEvent().WithFields(logrus.Fields{
 		"module":       "QueueProcessor",
@@ -130,12 +138,33 @@ func (s *Server) processRecvQueue(ctx context.Context, agentName string, q workq
 		err = s.processClusterCacheInfoUpdateEvent(agentName, ev)
 	case event.TargetHeartbeat:
 		err = s.processHeartbeatEvent(agentName, ev)
+	case event.TargetAgentConfig:
+		err = s.processAgentConfigEvent(agentName, ev)
+	case event.TargetPermissions:
+		err = s.processPermissionReport(agentName, ev)
+	case event.TargetResourceFilter:
+		err = s.processResourceFilterReport(agentName, ev)
+	case event.TargetStateChecksum:
+		err = s.processStateChecksum(ctx, agentName, ev)
+	case event.TargetAgentUpdate:
+		err = s.processAgentUpdateEvent(agentName, ev)
+	case event.TargetLogCancel:
+		err = s.processLogCancelEvent(agentName, ev)
+	case event.TargetLogFrame:
+		err = s.processLogFrameEvent(agentName, ev)
+	case event.TargetPolicyViolation:
+		err = s.processPolicyViolationReport(ctx, agentName, ev)
+	case event.TargetDrift:
+		err = s.processDriftReport(ctx, agentName, ev)
 	default:
 		err = fmt.Errorf("unknown target: '%s'", target)
 	}
 
-	// Mark event as processed
-	q.Done(ev)
+	// Anything but heartbeats counts as data received from the agent when
+	// determining whether it is stale.
+	if err == nil && target != event.TargetHeartbeat && s.clusterMgr != nil {
+		s.clusterMgr.RecordAgentActivity(agentName, time.Now())
+	}
 
 	// Forward successfully processed events to replicas, skipping operational
 	// noise that replicas don't need. Replicas get fresh data from agents on promotion.
@@ -146,6 +175,7 @@ func (s *Server) processRecvQueue(ctx context.Context, agentName string, q workq
 	// Stop and log checkpoint information
 	cp.End()
 	logCtx.Debug(cp.String())
+	s.observeEventHandler(logCtx, agentName, ev, cp.Duration())
 
 	if s.metrics != nil {
 		// ignore EventNotAllowed errors for metrics
@@ -159,7 +189,8 @@ func (s *Server) processRecvQueue(ctx context.Context, agentName string, q workq
 		}
 
 		// store time taken by principal to process event in metrics
-		s.metrics.EventProcessingTime.WithLabelValues(string(status), agentName, target.String()).Observe(cp.Duration().Seconds())
+		metrics.ObserveDuration(s.metrics.EventProcessingTime.WithLabelValues(string(status), s.metrics.AgentLabel(agentName), target.String()),
+			cp.Duration(), s.options.slowEventHandlerThreshold, span.SpanContext(), prometheus.Labels{"agent_name": agentName})
 	}
 
 	if err != nil {
@@ -168,7 +199,7 @@ func (s *Server) processRecvQueue(ctx context.Context, agentName string, q workq
 		tracing.SetSpanOK(span)
 	}
 
-	return ev, err
+	return err
 }
 
 // processApplicationEvent processes an incoming event that has an application
@@ -228,11 +259,15 @@ func (s *Server) processApplicationEvent(ctx context.Context, agentName string,
 			return event.ErrEventDiscarded
 		}
 
+		previous, hasPrevious := s.sourceCache.Application.Get(incoming.UID)
 		s.sourceCache.Application.Set(incoming.UID, incoming.Spec)
 
 		incoming.SetNamespace(agentName)
-		_, err := s.appManager.Create(ctx, incoming)
-		if err != nil {
+		sourceResourceVersion := incoming.ResourceVersion
+		created, err := s.appManager.Create(ctx, incoming)
+		if err == nil {
+			s.resyncBookmarks.RecordApplication(agentName, created, created.ResourceVersion, sourceResourceVersion)
+		} else {
 			if !kerrors.IsAlreadyExists(err) {
 				return fmt.Errorf("could not create application %s: %w", incoming.QualifiedName(), err)
 			}
@@ -249,10 +284,13 @@ func (s *Server) processApplicationEvent(ctx context.Context, agentName string,
 			}
 
 			// Update the application if it already exists
-			_, err = s.appManager.UpdateAutonomousApp(ctx, agentName, incoming)
+			toApply, conflicted := s.resolveAgentAppChange(existing, specOrNil(previous, hasPrevious), incoming)
+			updated, err := s.appManager.UpdateAutonomousApp(ctx, agentName, toApply)
 			if err != nil {
 				return fmt.Errorf("could not update application spec for %s: %w", incoming.QualifiedName(), err)
 			}
+			s.resyncBookmarks.RecordApplication(agentName, updated, updated.ResourceVersion, sourceResourceVersion)
+			s.setConflictCondition(ctx, updated, conflicted)
 		}
 	// Spec updates are only allowed in autonomous mode
 	case event.SpecUpdate.String():
@@ -272,12 +310,18 @@ func (s *Server) processApplicationEvent(ctx context.Context, agentName string,
 			return nil
 		}
 
+		previous, hasPrevious := s.sourceCache.Application.Get(incoming.UID)
 		s.sourceCache.Application.Set(incoming.UID, incoming.Spec)
 
-		_, err = s.appManager.UpdateAutonomousApp(ctx, agentName, incoming)
+		toApply, conflicted := s.resolveAgentAppChange(existing, specOrNil(previous, hasPrevious), incoming)
+		sourceResourceVersion := incoming.ResourceVersion
+		updated, err := s.appManager.UpdateAutonomousApp(ctx, agentName, toApply)
 		if err != nil {
 			return fmt.Errorf("could not update application spec for %s: %w", incoming.QualifiedName(), err)
 		}
+		s.resyncBookmarks.RecordApplication(agentName, updated, updated.ResourceVersion, sourceResourceVersion)
+		s.setConflictCondition(ctx, updated, conflicted)
+		s.publishSyncResult(agentName, incoming)
 		logCtx.Infof("Updated application spec %s", incoming.QualifiedName())
 	// Status updates are only allowed in managed mode
 	case event.StatusUpdate.String():
@@ -288,10 +332,25 @@ func (s *Server) processApplicationEvent(ctx context.Context, agentName string,
 
 		s.sourceCache.Application.Set(incoming.UID, incoming.Spec)
 
-		_, err := s.appManager.UpdateStatus(ctx, agentName, incoming)
+		updated, err := s.appManager.UpdateStatus(ctx, agentName, incoming)
 		if err != nil {
 			return fmt.Errorf("could not update application status for %s: %w", incoming.QualifiedName(), err)
 		}
+		s.publishSyncResult(agentName, incoming)
+		if updated.DeletionTimestamp != nil {
+			s.recordDeletionProgress(agentName, updated)
+		}
+	// Sync progress is only sent by agents in managed mode
+	case event.SyncProgress.String():
+		if !agentMode.IsManaged() {
+			logCtx.Debug("Discarding event, because agent is not in managed mode")
+			return event.NewEventNotAllowedErr("event type not allowed when mode is not managed")
+		}
+		_, err := s.appManager.UpdateOperationState(ctx, agentName, incoming)
+		if err != nil {
+			return fmt.Errorf("could not update operation state for %s: %w", incoming.QualifiedName(), err)
+		}
+		logCtx.Tracef("Updated operation state of %s", incoming.QualifiedName())
 		logCtx.Infof("Updated application spec %s", incoming.QualifiedName())
 	// App deletion
 	case event.Delete.String():
@@ -353,6 +412,7 @@ func (s *Server) processApplicationEvent(ctx context.Context, agentName string,
 				}
 				return fmt.Errorf("could not delete application %s: %w", incoming.QualifiedName(), err)
 			}
+			s.confirmAppDeletion(agentName, app)
 			logCtx.Infof("Deleted application %s", incoming.QualifiedName())
 
 		} else {
@@ -491,12 +551,26 @@ func (s *Server) processClusterCacheInfoUpdateEvent(agentName string, ev *cloude
 // processHeartbeatEvent processes heartbeat ping events from agents.
 // The ping keeps the gRPC stream active and prevents service mesh idle timeouts.
 // No response is needed - ping itself should reset the service mesh idle timer.
+// Pongs answer the principal's connection probes and carry the timestamps to
+// measure the connection quality.
 func (s *Server) processHeartbeatEvent(agentName string, ev *cloudevents.Event) error {
+	receivedAt := time.Now()
 	s.logGrpcEvent().WithFields(logrus.Fields{
 		"module": "QueueProcessor",
 		"client": agentName,
 		"event":  ev.Type(),
 	}).Debug("Received heartbeat")
+	s.activity.recordHeartbeat(agentName, receivedAt)
+	if ev.Type() != event.Pong.String() {
+		return nil
+	}
+	probe, err := event.New(ev, event.TargetHeartbeat).ConnectionProbe()
+	if err != nil {
+		return fmt.Errorf("invalid connection probe: %w", err)
+	}
+	if probe != nil {
+		s.recordConnectionProbe(agentName, probe, receivedAt)
+	}
 	return nil
 }
 
@@ -648,7 +722,7 @@ func (s *Server) processIncomingResourceResyncEvent(ctx context.Context, agentNa
 		"event_id":    event.EventID(ev),
 	})
 
-	dynClient, err := dynamic.NewForConfig(s.kubeClient.RestConfig)
+	dynClient, err := s.kubeClient.Dynamic()
 	if err != nil {
 		return err
 	}
@@ -660,7 +734,10 @@ func (s *Server) processIncomingResourceResyncEvent(ctx context.Context, agentNa
 
 	resyncHandler := resync.NewRequestHandler(dynClient, sendQ, s.events, s.resources.Get(agentName), logCtx, manager.ManagerRolePrincipal, s.namespace).
 		WithDestinationBasedMapping(s.destinationBasedMapping).
-		WithPrincipalUID(s.principalUID)
+		WithPrincipalUID(s.principalUID).
+		WithPager(s.resyncPager, agentName).
+		WithBookmarks(s.resyncBookmarks, agentName).
+		WithIncrementalResync(s.agentSupportsBookmarks(agentName))
 
 	switch ev.Type() {
 	case event.SyncedResourceList.String():
@@ -710,6 +787,17 @@ func (s *Server) processIncomingResourceResyncEvent(ctx context.Context, agentNa
 		}
 
 		return resyncHandler.ProcessRequestUpdateEvent(ctx, agentName, incoming)
+	case event.EventResyncBookmarks.String():
+		if agentMode != types.AgentModeManaged {
+			return fmt.Errorf("principal can only handle resync bookmarks in the managed mode")
+		}
+
+		incoming := &event.ResyncBookmarks{}
+		if err := ev.DataAs(incoming); err != nil {
+			return err
+		}
+
+		return resyncHandler.ProcessResyncBookmarks(ctx, agentName, incoming)
 	case event.EventRequestResourceResync.String():
 		if agentMode != types.AgentModeAutonomous {
 			return fmt.Errorf("principal can only handle ResourceResync request in autonomous mode")
@@ -727,102 +815,109 @@ func (s *Server) processIncomingResourceResyncEvent(ctx context.Context, agentNa
 }
 
 // eventProcessor is the main loop to process event from the receiver queue,
-// i.e. events coming from the connect agents. It will process events from
-// different agents in parallel, but it will not parallelize processing of
-// events from the same queue. These latter events need to be processed in
-// sequential order, in any case.
+// i.e. events coming from the connect agents. It processes events in
+// parallel, both those of different agents and those of different resources
+// of the same agent. Events of the same resource, e.g. of an Application,
+// are processed in the order they were received, as are events that affect
+// all resources of an agent, such as resyncs.
 func (s *Server) eventProcessor(ctx context.Context) error {
 	sem := semaphore.NewWeighted(s.options.eventProcessors)
-	queueLock := namedlock.NewNamedLock()
+	d := newEventDispatcher(s.metrics)
 	baseLogCtx := s.logGrpcEvent().WithField("module", "EventProcessor")
 	for {
-		queuesProcessed := 0
+		eventsDispatched := 0
 		for _, queueName := range s.queues.Names() {
 			select {
 			case <-ctx.Done():
 				baseLogCtx.Infof("Shutting down event processor")
 				return nil
 			default:
-				// Though unlikely, the agent might have disconnected, and
-				// the queue will be gone. In this case, we'll just skip.
-				q := s.queues.RecvQ(queueName)
-				if q == nil {
-					baseLogCtx.WithField("queueName", queueName).Debugf("Queue disappeared -- client probably has disconnected")
-					break
-				}
+			}
+			// Though unlikely, the agent might have disconnected, and
+			// the queue will be gone. In this case, we'll just skip.
+			q := s.queues.RecvQ(queueName)
+			if q == nil {
+				baseLogCtx.WithField("queueName", queueName).Debugf("Queue disappeared -- client probably has disconnected")
+				continue
+			}
 
-				// Since q.Get() is blocking, we want to make sure something is actually
-				// in the queue before we try to grab it.
-				if q.Len() == 0 {
-					break
+			ev := d.release(queueName)
+			if ev == nil {
+				// Since q.Get() is blocking, we want to make sure something
+				// is actually in the queue before we try to grab it. Events
+				// behind one waiting for all earlier events of the agent stay
+				// in the queue until it has been processed.
+				if d.blocked(queueName) || q.Len() == 0 {
+					continue
 				}
-
-				// We lock this specific queue, so that we won't process two
-				// items of the same queue at the same time. Queues must be
-				// processed in FIFO order, always.
-				//
-				// If it's not possible to get a lock (i.e. a lock is already
-				// being held elsewhere), we continue with the next queue.
-				if !queueLock.TryLock(queueName) {
-					// logCtx.Tracef("Could not acquire queue lock, skipping queue")
-					break
+				var shutdown bool
+				ev, shutdown = q.Get()
+				if shutdown || ev == nil {
+					continue
 				}
+			}
+			eventsDispatched += 1
 
-				queueLogCtx := baseLogCtx.WithField("queueName", queueName)
-
-				queuesProcessed += 1
-
-				queueLogCtx.Trace("Acquired queue lock")
-
-				err := sem.Acquire(ctx, 1)
-				if err != nil {
-					queueLogCtx.Tracef("Error acquiring semaphore: %v", err)
-					queueLock.Unlock(queueName)
-					break
-				}
+			key, run := d.dispatch(queueName, ev)
+			if !run {
+				// The event waits for an earlier one with the same key, and
+				// is processed after it by the same goroutine.
+				continue
+			}
 
-				queueLogCtx.Trace("Acquired semaphore")
-
-				go func(agentName string, q workqueue.TypedRateLimitingInterface[*cloudevents.Event], logCtx *logrus.Entry) {
-					defer func() {
-						sem.Release(1)
-						queueLock.Unlock(agentName)
-					}()
-
-					ev, err := s.processRecvQueue(ctx, agentName, q)
-					if err != nil {
-						logCtx.WithField("client", agentName).WithError(err).Errorf("Could not process agent receiver queue")
-						// Don't send an ACK if it is a retryable error.
-						if kube.IsRetryableError(err) {
-							logCtx.Trace("Skipping ACK for retryable errors")
-							return
-						}
-					}
-
-					// Send an ACK if the event is processed successfully.
-					sendQ := s.queues.SendQ(agentName)
-					if sendQ == nil {
-						logCtx.Debugf("Queue disappeared -- client probably has disconnected")
-						return
-					}
-					logCtx = logCtx.WithFields(logrus.Fields{
-						"resource_id": event.ResourceID(ev),
-						"event_id":    event.EventID(ev),
-						"type":        ev.Type(),
-					})
-
-					logCtx.Trace("sending an ACK for an event")
-					sendQ.Add(s.events.ProcessedEvent(event.EventProcessed, event.New(ev, event.TargetEventAck)))
-				}(queueName, q, queueLogCtx)
+			queueLogCtx := baseLogCtx.WithField("queueName", queueName)
+			if err := sem.Acquire(ctx, 1); err != nil {
+				queueLogCtx.Tracef("Error acquiring semaphore: %v", err)
+				return nil
 			}
+			queueLogCtx.Trace("Acquired semaphore")
+
+			go func(agentName string, ev *cloudevents.Event, logCtx *logrus.Entry) {
+				defer sem.Release(1)
+				for ev != nil {
+					err := s.processEvent(ctx, agentName, ev)
+					q.Done(ev)
+					s.acknowledgeEvent(agentName, ev, err, logCtx)
+					ev = d.done(agentName, key)
+				}
+			}(queueName, ev, queueLogCtx)
 		}
-		// Give the CPU a little rest when no agents are connected
-		if queuesProcessed == 0 {
+		// Give the CPU a little rest when no events are waiting
+		if eventsDispatched == 0 {
 			time.Sleep(10 * time.Millisecond)
 		}
 	}
 }
 
+// acknowledgeEvent sends an ACK for an event received from agentName that
+// has been processed with the given result, unless processing failed with a
+// retryable error.
+func (s *Server) acknowledgeEvent(agentName string, ev *cloudevents.Event, err error, logCtx *logrus.Entry) {
+	if err != nil {
+		logCtx.WithField("client", agentName).WithError(err).Errorf("Could not process agent receiver queue")
+		// Don't send an ACK if it is a retryable error.
+		if kube.IsRetryableError(err) {
+			logCtx.Trace("Skipping ACK for retryable errors")
+			return
+		}
+	}
+
+	// Send an ACK if the event is processed successfully.
+	sendQ := s.queues.SendQ(agentName)
+	if sendQ == nil {
+		logCtx.Debugf("Queue disappeared -- client probably has disconnected")
+		return
+	}
+	logCtx = logCtx.WithFields(logrus.Fields{
+		"resource_id": event.ResourceID(ev),
+		"event_id":    event.EventID(ev),
+		"type":        ev.Type(),
+	})
+
+	logCtx.Trace("sending an ACK for an event")
+	sendQ.Add(s.events.ProcessedEvent(event.EventProcessed, event.New(ev, event.TargetEventAck)))
+}
+
 // StartEventProcessor will start the event processor, which processes items
 // from all queues as the items appear in the queues. Processing will be
 // performed in parallel, and in the background, until the context ctx is done.
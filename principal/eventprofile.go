@@ -0,0 +1,51 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSlowEventHandlerThreshold is the default time after which the
+// handler of an event is reported as slow
+const defaultSlowEventHandlerThreshold = time.Second
+
+// observeEventHandler records how long the handler of an event received from
+// agentName took, and reports it if it took longer than the slow event
+// handler threshold. A slow handler delays the events of the same resource
+// queued behind it.
+func (s *Server) observeEventHandler(logCtx *logrus.Entry, agentName string, ev *cloudevents.Event, took time.Duration) {
+	target, typ := event.Target(ev).String(), ev.Type()
+	if s.metrics != nil {
+		s.metrics.EventHandlerDuration.WithLabelValues(target, typ).Observe(took.Seconds())
+	}
+	threshold := s.options.slowEventHandlerThreshold
+	if threshold <= 0 || took < threshold {
+		return
+	}
+	if s.metrics != nil {
+		s.metrics.SlowEventHandlers.WithLabelValues(target, typ).Inc()
+	}
+	logCtx.WithFields(logrus.Fields{
+		"event_id":    event.EventID(ev),
+		"resource_id": event.ResourceID(ev),
+		"took":        took.String(),
+		"threshold":   threshold.String(),
+	}).Warnf("Slow event handler blocked the processing of events from agent %s", agentName)
+}
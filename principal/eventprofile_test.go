@@ -0,0 +1,47 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_observeEventHandler(t *testing.T) {
+	s := &Server{options: defaultOptions(), metrics: metrics.NewPrincipalMetricsWith(prometheus.NewRegistry())}
+	ev := event.NewEventSource("agent-1").HeartbeatEvent(event.Ping)
+	logCtx := logrus.NewEntry(logrus.New())
+	slow := func() float64 {
+		return testutil.ToFloat64(s.metrics.SlowEventHandlers.WithLabelValues(event.TargetHeartbeat.String(), ev.Type()))
+	}
+
+	s.observeEventHandler(logCtx, "agent-1", ev, time.Millisecond)
+	assert.Equal(t, 1, testutil.CollectAndCount(s.metrics.EventHandlerDuration))
+	assert.Zero(t, slow())
+
+	s.observeEventHandler(logCtx, "agent-1", ev, defaultSlowEventHandlerThreshold)
+	assert.Equal(t, float64(1), slow())
+
+	s.options.slowEventHandlerThreshold = 0
+	s.observeEventHandler(logCtx, "agent-1", ev, time.Minute)
+	assert.Equal(t, float64(1), slow())
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"fmt"
+
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// WithEventRecorder makes the principal record all events it exchanges with
+// agents on the event stream. The recorder is not closed by the principal.
+func WithEventRecorder(r *eventrecord.Recorder) ServerOption {
+	return func(o *Server) error {
+		o.options.eventRecorder = r
+		return nil
+	}
+}
+
+// ReplayEvent queues ev for processing as if it had been received from the
+// agent agentName, running in mode. It is used to reproduce problems from
+// recorded event streams. The agent does not need to be connected.
+func (s *Server) ReplayEvent(agentName string, mode types.AgentMode, ev *cloudevents.Event) error {
+	if !s.queues.HasQueuePair(agentName) {
+		if err := s.queues.Create(agentName); err != nil {
+			return fmt.Errorf("could not create queues for agent %s: %w", agentName, err)
+		}
+	}
+	if s.agentMode(agentName) == types.AgentModeUnknown {
+		s.setAgentMode(agentName, mode)
+	}
+	q := s.queues.RecvQ(agentName)
+	if q == nil {
+		return fmt.Errorf("no receive queue for agent %s", agentName)
+	}
+	q.Add(ev)
+	return nil
+}
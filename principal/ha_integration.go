@@ -299,11 +299,12 @@ func NewHAComponents(ctx context.Context, server *Server, haOpts ...ha.Option) (
 				return nil
 			}
 			return &tls.Config{
-				Certificates: server.tlsConfig.Certificates,
-				RootCAs:      server.tlsConfig.ClientCAs,
-				MinVersion:   server.tlsConfig.MinVersion,
-				MaxVersion:   server.tlsConfig.MaxVersion,
-				CipherSuites: server.tlsConfig.CipherSuites,
+				Certificates:     server.tlsConfig.Certificates,
+				RootCAs:          server.tlsConfig.ClientCAs,
+				MinVersion:       server.tlsConfig.MinVersion,
+				MaxVersion:       server.tlsConfig.MaxVersion,
+				CipherSuites:     server.tlsConfig.CipherSuites,
+				CurvePreferences: server.tlsConfig.CurvePreferences,
 			}
 		}))
 
@@ -574,6 +575,11 @@ func (h *HAComponents) handleReplicatedEvent(ev *replication.ReplicatedEvent) er
 				log().WithField("app", app.QualifiedName()).WithError(err).Warn("HA: failed to update application status from replicated event")
 			}
 			server.resources.Add(ev.AgentName, key)
+		case event.SyncProgress:
+			if _, err := server.appManager.UpdateOperationState(ctx, ev.AgentName, app); err != nil {
+				h.recordResourceError("Application", "sync_progress")
+				log().WithField("app", app.QualifiedName()).WithError(err).Warn("HA: failed to update application operation state from replicated event")
+			}
 		case event.Delete:
 			if err := server.appManager.Delete(ctx, app.Namespace, app, nil); err != nil && !k8serrors.IsNotFound(err) {
 				h.recordResourceError("Application", "delete")
@@ -0,0 +1,258 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/handoff"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// handoffAdoptTimeout is how long a log stream handed over by the
+	// previous instance waits for its client to re-attach
+	handoffAdoptTimeout = 30 * time.Second
+
+	// handoffRequestMargin is added to the shutdown grace period when
+	// waiting for the previous instance to hand over its sessions
+	handoffRequestMargin = 30 * time.Second
+)
+
+// handoffState tracks the follow log streams which can be handed over to the
+// next principal instance, and those handed over to us by the previous one.
+type handoffState struct {
+	mu sync.Mutex
+	// streams are the follow log streams served by this instance, by
+	// request UUID
+	streams map[string]handoff.LogStream
+	// handedOff is set once the streams were handed over
+	handedOff bool
+	// adopted are the request UUIDs of follow log streams handed over by the
+	// previous instance, by the key of their client request
+	adopted map[string]string
+	// done is closed once this instance handed its sessions over
+	done chan struct{}
+}
+
+func newHandoffState() *handoffState {
+	return &handoffState{
+		streams: make(map[string]handoff.LogStream),
+		adopted: make(map[string]string),
+		done:    make(chan struct{}),
+	}
+}
+
+// HandedOff returns a channel that is closed once the server handed its
+// sessions over to a new principal instance and has shut down.
+func (s *Server) HandedOff() <-chan struct{} {
+	return s.handoff.done
+}
+
+// logStreamKey identifies the client request of a follow log stream, so that
+// a client repeating its request can re-attach to a handed over stream.
+func logStreamKey(agentName string, r *http.Request) string {
+	return agentName + " " + r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// isFollowRequest returns whether r requests to follow a log stream
+func isFollowRequest(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("follow"), "true")
+}
+
+// trackLogStream records a follow log stream, so it can be handed over to
+// the next instance. The returned function stops tracking it, unless it has
+// been handed over already.
+func (s *Server) trackLogStream(requestUUID, agentName string, r *http.Request) func() {
+	s.handoff.mu.Lock()
+	defer s.handoff.mu.Unlock()
+	s.handoff.streams[requestUUID] = handoff.LogStream{
+		RequestUUID: requestUUID,
+		AgentName:   agentName,
+		Key:         logStreamKey(agentName, r),
+	}
+	return func() {
+		s.handoff.mu.Lock()
+		defer s.handoff.mu.Unlock()
+		if !s.handoff.handedOff {
+			delete(s.handoff.streams, requestUUID)
+		}
+	}
+}
+
+// isHandedOff returns whether the log streams of this instance were handed
+// over to the next instance
+func (s *Server) isHandedOff() bool {
+	s.handoff.mu.Lock()
+	defer s.handoff.mu.Unlock()
+	return s.handoff.handedOff
+}
+
+// adoptLogStream returns the request UUID of a log stream handed over by the
+// previous instance for the client request r, if there is one.
+func (s *Server) adoptLogStream(agentName string, r *http.Request) (string, bool) {
+	key := logStreamKey(agentName, r)
+	s.handoff.mu.Lock()
+	defer s.handoff.mu.Unlock()
+	requestUUID, ok := s.handoff.adopted[key]
+	if ok {
+		delete(s.handoff.adopted, key)
+	}
+	return requestUUID, ok
+}
+
+// resumeLogStream re-attaches a client to a follow log stream handed over by
+// the previous instance.
+func (s *Server) resumeLogStream(w http.ResponseWriter, r *http.Request, requestUUID, agentName string, logCtx *logrus.Entry) {
+	logCtx.WithField("uuid", requestUUID).Info("Re-attaching client to handed over log stream")
+	if err := s.logStream.RegisterHTTP(requestUUID, w, r); err != nil {
+		logCtx.Errorf("Could not register HTTP writer for log streaming: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.logStream.BindAgent(requestUUID, agentName)
+	defer s.logStream.RemoveSession(requestUUID)
+	defer s.activity.beginStream(agentName, streamLogs)()
+	defer s.trackLogStream(requestUUID, agentName, r)()
+	s.waitForLogStreamClient(r, requestUUID, agentName, logCtx)
+}
+
+// exportHandoffState hands the live sessions of this instance over to the
+// next instance. Follow log streams are detached from their clients, which
+// are told to reconnect, and agents are drained. Afterwards, the server is
+// shut down and the events still queued for agents are exported.
+func (s *Server) exportHandoffState() (*handoff.State, error) {
+	state := &handoff.State{}
+
+	s.handoff.mu.Lock()
+	s.handoff.handedOff = true
+	streams := make([]handoff.LogStream, 0, len(s.handoff.streams))
+	for _, ls := range s.handoff.streams {
+		streams = append(streams, ls)
+	}
+	s.handoff.mu.Unlock()
+	for _, ls := range streams {
+		if s.logStream.HandOff(ls.RequestUUID, s.options.shutdownReconnectDelay, "principal is shutting down") {
+			state.LogStreams = append(state.LogStreams, ls)
+		}
+	}
+
+	if err := s.Shutdown(); err != nil {
+		log().WithError(err).Warn("Error shutting down for handoff")
+	}
+
+	for _, agentName := range s.queues.Names() {
+		q := s.queues.SendQ(agentName)
+		if q == nil {
+			continue
+		}
+		var events []*cloudevents.Event
+		for q.Len() > 0 {
+			ev, shutdown := q.Get()
+			if shutdown {
+				break
+			}
+			if ev == nil {
+				continue
+			}
+			q.Done(ev)
+			events = append(events, ev)
+		}
+		if len(events) > 0 {
+			state.Queues = append(state.Queues, handoff.Queue{AgentName: agentName, Events: events})
+		}
+	}
+	return state, nil
+}
+
+// importHandoffState takes over the sessions of the previous instance
+// listening on the handoff socket, if there is one.
+func (s *Server) importHandoffState(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.options.gracePeriod+handoffRequestMargin)
+	defer cancel()
+	logCtx := log().WithField("socket", s.options.handoffSocket)
+	state, err := handoff.Request(ctx, s.options.handoffSocket)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		logCtx.Info("No previous instance to take over sessions from")
+		return nil
+	}
+
+	s.restoreQueues(state.Queues, logCtx)
+
+	s.handoff.mu.Lock()
+	for _, ls := range state.LogStreams {
+		s.logStream.Adopt(ls.RequestUUID, ls.AgentName)
+		s.handoff.adopted[ls.Key] = ls.RequestUUID
+		time.AfterFunc(handoffAdoptTimeout, func() { s.expireAdoptedLogStream(ls) })
+	}
+	s.handoff.mu.Unlock()
+
+	logCtx.Infof("Took over %d log streams and the queues of %d agents from previous instance", len(state.LogStreams), len(state.Queues))
+	return nil
+}
+
+// expireAdoptedLogStream gives up a handed over log stream whose client did
+// not re-attach in time. The agent stops streaming once it sends more data.
+func (s *Server) expireAdoptedLogStream(ls handoff.LogStream) {
+	s.handoff.mu.Lock()
+	if s.handoff.adopted[ls.Key] == ls.RequestUUID {
+		delete(s.handoff.adopted, ls.Key)
+	}
+	s.handoff.mu.Unlock()
+	if s.logStream.IsAdopted(ls.RequestUUID) {
+		log().WithField("uuid", ls.RequestUUID).Info("Client of handed over log stream did not re-attach")
+		s.logStream.RemoveSession(ls.RequestUUID)
+	}
+}
+
+// startHandoff takes over the sessions of the previous instance, and then
+// listens for the next instance to hand our sessions over to.
+func (s *Server) startHandoff(ctx context.Context) error {
+	if err := s.importHandoffState(ctx); err != nil {
+		// Failing the handoff only costs us the live sessions, clients and
+		// agents will reconnect anyway.
+		log().WithError(err).Warn("Could not take over sessions from previous instance")
+	}
+	l, err := handoff.Listen(s.options.handoffSocket, s.exportHandoffState)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := l.Serve(); err != nil {
+			log().WithError(err).Error("Handoff listener has exited non-successfully")
+		}
+		s.handoff.mu.Lock()
+		handedOff := s.handoff.handedOff
+		s.handoff.mu.Unlock()
+		if handedOff {
+			close(s.handoff.done)
+		}
+	}()
+	go func() {
+		// Shutting down, we don't hand over anything anymore. A handoff in
+		// progress still completes, the connection stays open.
+		<-s.ctx.Done()
+		_ = l.Close()
+	}()
+	return nil
+}
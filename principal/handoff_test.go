@@ -0,0 +1,93 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SessionHandoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Unix socket paths are limited in length, so we don't use t.TempDir()
+	dir, err := os.MkdirTemp("", "handoff")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "handoff.sock")
+
+	logURL := "/api/v1/namespaces/default/pods/guestbook/log?container=ui&follow=true"
+
+	// The previous instance has a live follow log stream and an event queued
+	// for a disconnected agent
+	old := newDrainTestServer(t, WithHandoffSocket(socket), WithShutDownGracePeriod(100*time.Millisecond))
+	require.NoError(t, old.queues.Create("agent-1"))
+	ev, err := old.events.DrainEvent(time.Second)
+	require.NoError(t, err)
+	old.queues.SendQ("agent-1").Add(ev)
+	oldClient := mock.NewMockHTTPResponseWriter()
+	oldReq := httptest.NewRequest("GET", logURL, nil)
+	oldReq.Header.Set("Accept", "text/event-stream")
+	require.NoError(t, old.logStream.RegisterHTTP("stream-1", oldClient, oldReq))
+	untrack := old.trackLogStream("stream-1", "agent-1", oldReq)
+	require.NoError(t, old.startHandoff(ctx))
+
+	// The new instance takes over
+	s := newDrainTestServer(t, WithHandoffSocket(socket), WithShutDownGracePeriod(100*time.Millisecond))
+	require.NoError(t, s.startHandoff(ctx))
+	defer s.ctxCancel()
+
+	select {
+	case <-old.HandedOff():
+	case <-time.After(5 * time.Second):
+		t.Fatal("previous instance did not hand over its sessions")
+	}
+	untrack()
+	assert.Contains(t, oldClient.GetBody(), "event: error\ndata: principal is shutting down\n\n")
+
+	t.Run("Queued events are taken over", func(t *testing.T) {
+		q := s.queues.SendQ("agent-1")
+		require.NotNil(t, q)
+		require.Equal(t, 1, q.Len())
+		got, _ := q.Get()
+		q.Done(got)
+		assert.Equal(t, ev.ID(), got.ID())
+	})
+
+	t.Run("Client re-attaches to the log stream", func(t *testing.T) {
+		assert.True(t, s.logStream.IsAdopted("stream-1"))
+		_, ok := s.adoptLogStream("agent-2", httptest.NewRequest("GET", logURL, nil))
+		assert.False(t, ok)
+		requestUUID, ok := s.adoptLogStream("agent-1", httptest.NewRequest("GET", logURL, nil))
+		require.True(t, ok)
+		assert.Equal(t, "stream-1", requestUUID)
+		// A stream is only adopted once
+		_, ok = s.adoptLogStream("agent-1", httptest.NewRequest("GET", logURL, nil))
+		assert.False(t, ok)
+	})
+
+	t.Run("New instance listens for the next handoff", func(t *testing.T) {
+		_, err := os.Stat(socket)
+		assert.NoError(t, err)
+	})
+}
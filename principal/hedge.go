@@ -0,0 +1,133 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// hedgeResourceRequest sends a copy of the resource request ev to the agent,
+// e.g. because the agent did not answer ev in time. The copy is sent with
+// sendHedged, and is answered by whichever agent replica leads when it
+// arrives.
+//
+// It returns the copy's ID and the channel its response is read from, or an
+// empty ID if the copy could not be sent. The caller must stop tracking the
+// copy.
+func (s *Server) hedgeResourceRequest(ev *cloudevents.Event, agentName string, logCtx *logrus.Entry) (string, <-chan *cloudevents.Event) {
+	if !s.isAgentConnected(agentName) {
+		return "", nil
+	}
+	hedgeEv, err := event.CopyResourceRequest(ev)
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not copy resource request for hedging")
+		return "", nil
+	}
+	hedgeUUID := event.EventID(hedgeEv)
+	ch, err := s.resourceProxy.Track(hedgeUUID, agentName)
+	if err != nil {
+		logCtx.WithError(err).Warnf("Could not track hedged request %s", hedgeUUID)
+		return "", nil
+	}
+	logCtx.WithField("uuid", hedgeUUID).Debug("No response from agent yet, sending hedged request")
+	if !s.sendHedged(agentName, hedgeEv) {
+		if err := s.resourceProxy.StopTracking(hedgeUUID); err != nil {
+			logCtx.Warnf("Could not untrack %s: %v", hedgeUUID, err)
+		}
+		return "", nil
+	}
+	if s.metrics != nil {
+		s.metrics.ResourceProxy.HedgedRequests.WithLabelValues("sent").Inc()
+	}
+	return hedgeUUID, ch
+}
+
+// hedgeStaticLogs sends a copy of the static log request ev to the agent if
+// the agent has not started to stream the logs within the hedge delay.
+// Whichever stream starts first answers the client, see logstream's Hedge.
+// The returned function must be called once the request is finished.
+func (s *Server) hedgeStaticLogs(ev *cloudevents.Event, agentName string, logCtx *logrus.Entry) func() {
+	if s.options.resourceProxyHedgeDelay <= 0 {
+		return func() {}
+	}
+	requestUUID := event.EventID(ev)
+	hedged := make(chan string, 1)
+	t := time.AfterFunc(s.options.resourceProxyHedgeDelay, func() {
+		hedged <- s.hedgeLogRequest(ev, agentName, logCtx)
+	})
+	return func() {
+		if t.Stop() {
+			return
+		}
+		hedgeUUID := <-hedged
+		if hedgeUUID == "" {
+			return
+		}
+		if s.logStream.HedgeWon(requestUUID) && s.metrics != nil {
+			s.metrics.ResourceProxy.HedgedRequests.WithLabelValues("won").Inc()
+		}
+		s.logStream.RemoveSession(hedgeUUID)
+	}
+}
+
+// hedgeLogRequest sends a copy of the static log request ev to the agent,
+// unless the agent has started to stream its logs already. It returns the
+// copy's ID, or an empty ID if no copy was sent.
+func (s *Server) hedgeLogRequest(ev *cloudevents.Event, agentName string, logCtx *logrus.Entry) string {
+	if !s.isAgentConnected(agentName) {
+		return ""
+	}
+	hedgeEv, err := event.CopyResourceRequest(ev)
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not copy log request for hedging")
+		return ""
+	}
+	hedgeUUID := event.EventID(hedgeEv)
+	if !s.logStream.Hedge(event.EventID(ev), hedgeUUID) {
+		// The agent is streaming the logs already
+		return ""
+	}
+	logCtx.WithField("uuid", hedgeUUID).Debug("Agent has not started streaming logs yet, sending hedged request")
+	if !s.sendHedged(agentName, hedgeEv) {
+		s.logStream.RemoveSession(hedgeUUID)
+		return ""
+	}
+	if s.metrics != nil {
+		s.metrics.ResourceProxy.HedgedRequests.WithLabelValues("sent").Inc()
+	}
+	return hedgeUUID
+}
+
+// sendHedged sends the hedged copy ev of a request to the agent. The copy
+// skips the agent's send queue, where the original request may wait behind a
+// backlog of events or be held back by the rate limit, and is handed to the
+// writer of the agent's event stream directly. It cannot take a path
+// independent of that stream: the agent's standby replicas do not connect to
+// the principal, so the leading replica's stream is the only way to reach
+// the agent. Returns false if the agent has no event stream.
+func (s *Server) sendHedged(agentName string, ev *cloudevents.Event) bool {
+	ew := s.eventWriters.Get(agentName)
+	if ew == nil {
+		return false
+	}
+	s.options.eventRecorder.Record(eventrecord.DirectionSend, agentName, ev)
+	ew.Add(ev)
+	return true
+}
@@ -0,0 +1,103 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+// syncResultTracker remembers the last sync result published for each
+// application, because agents send the same operation state with every
+// status update.
+type syncResultTracker struct {
+	mu      sync.Mutex
+	started map[ktypes.UID]time.Time
+}
+
+func newSyncResultTracker() *syncResultTracker {
+	return &syncResultTracker{started: make(map[ktypes.UID]time.Time)}
+}
+
+// isNew records the operation of the given application which started at
+// startedAt, and returns whether it wasn't seen before.
+func (t *syncResultTracker) isNew(uid ktypes.UID, startedAt time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.started[uid]; ok && last.Equal(startedAt) {
+		return false
+	}
+	t.started[uid] = startedAt
+	return true
+}
+
+// publishLifecycleEvent hands ev over to the configured event sink, if any
+func (s *Server) publishLifecycleEvent(ev *cloudevents.Event, err error) {
+	if s.lifecycle == nil {
+		return
+	}
+	if err != nil {
+		log().WithError(err).Error("Could not create lifecycle event")
+		return
+	}
+	if ev != nil {
+		s.lifecycle.Publish(ev)
+	}
+}
+
+// publishAgentConnected is run as a handler whenever an agent connects
+func (s *Server) publishAgentConnected(agent types.Agent) error {
+	if s.lifecycle == nil {
+		return nil
+	}
+	s.publishLifecycleEvent(s.events.AgentLifecycleEvent(event.AgentConnected, agent.Name(), agent.Mode()))
+	return nil
+}
+
+// onAgentDisconnected is called by the event stream server whenever an agent
+// disconnects.
+func (s *Server) onAgentDisconnected(agentName string) {
+	s.activity.recordDisconnect(agentName, time.Now())
+	s.logMux.closeAgent(agentName)
+	s.triggerAgentStatusUpdate()
+	s.publishAgentDisconnected(agentName)
+}
+
+// publishAgentDisconnected publishes an AgentDisconnected lifecycle event
+func (s *Server) publishAgentDisconnected(agentName string) {
+	if s.lifecycle == nil {
+		return
+	}
+	s.publishLifecycleEvent(s.events.AgentLifecycleEvent(event.AgentDisconnected, agentName, ""))
+}
+
+// publishSyncResult publishes the result of the application's last operation,
+// unless it has been published before.
+func (s *Server) publishSyncResult(agentName string, app *v1alpha1.Application) {
+	if s.lifecycle == nil {
+		return
+	}
+	op := app.Status.OperationState
+	if op == nil || !op.Phase.Completed() || !s.syncResults.isNew(app.UID, op.StartedAt.Time) {
+		return
+	}
+	s.publishLifecycleEvent(s.events.SyncResultEvent(agentName, app))
+}
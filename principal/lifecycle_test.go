@@ -0,0 +1,98 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []*cloudevents.Event
+}
+
+func (s *recordingSink) Publish(_ context.Context, ev *cloudevents.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *recordingSink) types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := []string{}
+	for _, ev := range s.events {
+		types = append(types, ev.Type())
+	}
+	return types
+}
+
+func Test_LifecycleEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := &recordingSink{}
+	s, err := NewServer(ctx, kube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithEventSink(sink))
+	require.NoError(t, err)
+	s.events = event.NewEventSource("test")
+	s.lifecycle.Start(ctx)
+
+	s.publishAgentDisconnected("agent-1")
+	app := &v1alpha1.Application{ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "agent-1", UID: "1234"}}
+	// No operation yet
+	s.publishSyncResult("agent-1", app)
+	app.Status.OperationState = &v1alpha1.OperationState{
+		Phase:     synccommon.OperationSucceeded,
+		StartedAt: v1.NewTime(time.Now()),
+	}
+	// The same operation state is sent with every status update, but must
+	// only be published once.
+	s.publishSyncResult("agent-1", app)
+	s.publishSyncResult("agent-1", app)
+	app.Status.OperationState = &v1alpha1.OperationState{
+		Phase:     synccommon.OperationFailed,
+		StartedAt: v1.NewTime(time.Now().Add(time.Second)),
+	}
+	s.publishSyncResult("agent-1", app)
+
+	expected := []string{event.AgentDisconnected.String(), event.SyncCompleted.String(), event.SyncCompleted.String()}
+	require.Eventually(t, func() bool {
+		return len(sink.types()) == len(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, expected, sink.types())
+}
+
+func Test_LifecycleEventsDisabled(t *testing.T) {
+	s, err := NewServer(context.Background(), kube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled())
+	require.NoError(t, err)
+	assert.Nil(t, s.lifecycle)
+	// Must not panic
+	s.publishAgentDisconnected("agent-1")
+	s.publishSyncResult("agent-1", &v1alpha1.Application{})
+}
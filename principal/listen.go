@@ -29,12 +29,16 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
 	"github.com/argoproj-labs/argocd-agent/internal/metrics"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/authapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/eventstreamapi"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/replicationapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/terminalstreamapi"
@@ -98,14 +102,15 @@ func (s *Server) Listen(ctx context.Context, backoff wait.Backoff) error {
 	var c net.Listener
 	var err error
 	try := 1
-	bind := fmt.Sprintf("%s:%d", s.options.address, s.options.port)
+	bind := grpcutil.JoinHostPort(s.options.address, s.options.port)
+	network := s.options.addressFamily.Network()
 	// It should not be a fatal failure if the listener could not be started.
 	// Instead, retry with backoff until the context has expired or the
 	// number of maximum retries has been exceeded.
 	err = wait.ExponentialBackoff(backoff, func() (done bool, err error) {
 		var lerr error
 		if try == 1 {
-			s.logGrpcEvent().Debugf("Starting TCP listener on %s", bind)
+			s.logGrpcEvent().Debugf("Starting TCP listener on %s (%s)", bind, network)
 		}
 		// Even though we load TLS configuration here, we will not yet create
 		// a TLS listener. TLS will be setup using the appropriate grpc-go API
@@ -115,7 +120,7 @@ func (s *Server) Listen(ctx context.Context, backoff wait.Backoff) error {
 			return false, lerr
 		}
 		// Start the TCP listener and bail out on errors.
-		c, lerr = net.Listen("tcp", bind)
+		c, lerr = net.Listen(network, bind)
 		if lerr != nil {
 			s.logGrpcEvent().WithError(lerr).Debugf("Retrying to start TCP listener on %s (retry %d/%d)", bind, try, listenerRetries)
 			try += 1
@@ -151,18 +156,18 @@ func (s *Server) serveGRPC(ctx context.Context, metrics *metrics.PrincipalMetric
 		grpc.MaxSendMsgSize(s.options.maxGRPCMessageSize),
 		// Global stats handler for tracing
 		grpc.StatsHandler(otelgrpc.NewServerHandler()),
-		// Global interceptors for gRPC streams
-		grpc.ChainStreamInterceptor(
+		// Global interceptors for gRPC streams, followed by the user-supplied ones
+		grpc.ChainStreamInterceptor(append([]grpc.StreamServerInterceptor{
 			s.streamRequestLogger(), // logging
 			s.streamAuthInterceptor, // auth
 			grpcutil.StreamServerMsgSizeInterceptor(s.options.maxGRPCMessageSize), // message size warning
-		),
-		// Global interceptors for gRPC unary calls
-		grpc.ChainUnaryInterceptor(
+		}, s.options.streamInterceptors...)...),
+		// Global interceptors for gRPC unary calls, followed by the user-supplied ones
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{
 			s.unaryRequestLogger(), // logging
 			s.unaryAuthInterceptor, // auth
 			grpcutil.UnaryServerMsgSizeInterceptor(s.options.maxGRPCMessageSize), // message size warning
-		),
+		}, s.options.unaryInterceptors...)...),
 	}
 
 	// Add TLS credentials unless running in plaintext mode (e.g., behind Istio)
@@ -176,6 +181,7 @@ func (s *Server) serveGRPC(ctx context.Context, metrics *metrics.PrincipalMetric
 		s.logGrpcEvent().Debugf("Agent ping to principal is enabled, agent should wait at least %s before sending next ping event to principal", s.keepAliveMinimumInterval)
 		grpcOpts = append(grpcOpts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: s.keepAliveMinimumInterval}))
 	}
+	grpcOpts = append(grpcOpts, s.connectionTuningOptions()...)
 
 	// Instantiate server with given opts
 	s.grpcServer = grpc.NewServer(grpcOpts...)
@@ -213,6 +219,29 @@ func (s *Server) serveGRPC(ctx context.Context, metrics *metrics.PrincipalMetric
 		}()
 	}
 
+	if s.options.enableQUIC {
+		if err := s.serveQUIC(); err != nil {
+			return fmt.Errorf("could not start QUIC listener: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// serveQUIC serves the gRPC server over HTTP/3 on the UDP port with the
+// number of the TCP listener's port. Every RPC runs on a QUIC stream of its
+// own. Agents that can't reach it keep connecting over TCP.
+func (s *Server) serveQUIC() error {
+	if s.tlsConfig == nil {
+		return fmt.Errorf("QUIC requires TLS")
+	}
+	bind := grpcutil.JoinHostPort(s.options.address, s.listener.port)
+	srv, err := grpcutil.ServeQUIC(s.options.addressFamily, bind, s.tlsConfig, s.grpcServer)
+	if err != nil {
+		return err
+	}
+	s.quicServer = srv
+	s.logGrpcEvent().Infof("Now listening for QUIC connections on %s", srv.Addr().String())
 	return nil
 }
 
@@ -232,8 +261,15 @@ func (l *Listener) Address() string {
 // This method should be called after the server is configured, and has all
 // required configuration properties set.
 func (s *Server) registerGrpcServices(metrics *metrics.PrincipalMetrics) error {
-	authSrv, err := auth.NewServer(s.queues, s.authMethods, s.issuer,
-		auth.WithAgentRegistrationManager(s.agentRegistrationManager))
+	authOpts := []auth.ServerOption{
+		auth.WithAgentRegistrationManager(s.agentRegistrationManager),
+		auth.WithVersionSkewPolicy(s.options.versionSkewPolicy),
+		auth.WithMetrics(metrics),
+	}
+	if s.options.joinTokensEnabled {
+		authOpts = append(authOpts, auth.WithJoinTokens(s.kubeClient.Clientset, s.namespace))
+	}
+	authSrv, err := auth.NewServer(s.queues, s.authMethods, s.issuer, authOpts...)
 	if err != nil {
 		return fmt.Errorf("could not create new auth server: %w", err)
 	}
@@ -243,6 +279,14 @@ func (s *Server) registerGrpcServices(metrics *metrics.PrincipalMetrics) error {
 	opts := []eventstream.ServerOption{}
 	opts = append(opts, eventstream.WithNotifyOnConnect(s.notifyOnConnect))
 	opts = append(opts, eventstream.WithLogger(s.options.grpcEventLogger))
+	opts = append(opts, eventstream.WithNotifyOnDisconnect(s.onAgentDisconnected))
+	if s.connections != nil {
+		opts = append(opts, eventstream.WithNotifyOnConnectionChange(s.onConnectionChange))
+	}
+	opts = append(opts, eventstream.WithMaxMessageSize(s.options.maxGRPCMessageSize))
+	if s.options.eventRecorder != nil {
+		opts = append(opts, eventstream.WithRecorder(s.options.eventRecorder))
+	}
 	if s.ha != nil {
 		opts = append(opts, eventstream.WithAcceptCheck(func(agentName string) error {
 			return s.ha.Controller.OnAgentConnect(agentName)
@@ -255,11 +299,63 @@ func (s *Server) registerGrpcServices(metrics *metrics.PrincipalMetrics) error {
 	logstreamapi.RegisterLogStreamServiceServer(s.grpcServer, s.logStream)
 	// Register TerminalStream gRPC service for web terminal sessions
 	terminalstreamapi.RegisterTerminalStreamServiceServer(s.grpcServer, s.terminalStreamServer)
+	// Register FileTransfer gRPC service for files requested from agents
+	filetransferapi.RegisterFileTransferServiceServer(s.grpcServer, s.fileTransferServer)
 
 	// Register replication service when HA is enabled
 	if s.ha != nil && s.ha.ReplicationServer != nil {
 		replicationapi.RegisterReplicationServer(s.grpcServer, s.ha.ReplicationServer)
 	}
 
+	if s.options.grpcReflection {
+		s.enableReflection()
+	}
+
 	return nil
 }
+
+// reflectionMethods are the methods of the gRPC server reflection service,
+// which are available without authentication when reflection is enabled.
+var reflectionMethods = []string{
+	grpc_reflection_v1.ServerReflection_ServerReflectionInfo_FullMethodName,
+	grpc_reflection_v1alpha.ServerReflection_ServerReflectionInfo_FullMethodName,
+}
+
+// enableReflection registers the gRPC server reflection service, which
+// describes all services registered so far. It must be called after all
+// other services have been registered.
+func (s *Server) enableReflection() {
+	noauth := make(map[string]bool, len(s.noauth)+len(reflectionMethods))
+	for method := range s.noauth {
+		noauth[method] = true
+	}
+	for _, method := range reflectionMethods {
+		noauth[method] = true
+	}
+	s.noauth = noauth
+	reflection.Register(s.grpcServer)
+	log().Warn("gRPC server reflection is enabled, the principal's API can be introspected without authentication")
+}
+
+// connectionTuningOptions returns the gRPC server options for keepalive and
+// flow control configured for the principal. Settings left at 0 keep gRPC's
+// defaults.
+func (s *Server) connectionTuningOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if s.options.keepAliveTime > 0 || s.options.keepAliveTimeout > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    s.options.keepAliveTime,
+			Timeout: s.options.keepAliveTimeout,
+		}))
+	}
+	if s.options.initialWindowSize > 0 {
+		opts = append(opts, grpc.InitialWindowSize(s.options.initialWindowSize))
+	}
+	if s.options.initialConnWindowSize > 0 {
+		opts = append(opts, grpc.InitialConnWindowSize(s.options.initialConnWindowSize))
+	}
+	if s.options.maxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(s.options.maxConcurrentStreams))
+	}
+	return opts
+}
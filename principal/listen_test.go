@@ -24,6 +24,7 @@ import (
 
 	"github.com/argoproj-labs/argocd-agent/internal/auth"
 	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
+	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/authapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/versionapi"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
@@ -32,7 +33,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
@@ -93,6 +97,38 @@ func Test_Listen(t *testing.T) {
 		assert.NotZero(t, s.listener.port)
 	})
 
+	t.Run("Listen on IPv6 only", func(t *testing.T) {
+		s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps(testNamespace), testNamespace,
+			WithTLSKeyPairFromPath(path.Join(tempDir, "test-cert.crt"), path.Join(tempDir, "test-cert.key")),
+			WithListenerPort(0),
+			WithGeneratedTokenSigningKey(),
+			WithListenerAddress("::1"),
+			WithAddressFamily(grpcutil.AddressFamilyIPv6),
+		)
+		require.NoError(t, err)
+		err = s.Listen(context.Background(), wait.Backoff{Duration: 100 * time.Millisecond, Steps: 2})
+		if err != nil {
+			t.Skipf("IPv6 loopback not available: %v", err)
+		}
+		defer s.listener.l.Close()
+		assert.Equal(t, "[::1]", s.listener.host)
+		assert.NotZero(t, s.listener.port)
+	})
+
+	t.Run("Address family must match the listen address", func(t *testing.T) {
+		s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps(testNamespace), testNamespace,
+			WithTLSKeyPairFromPath(path.Join(tempDir, "test-cert.crt"), path.Join(tempDir, "test-cert.key")),
+			WithListenerPort(0),
+			WithGeneratedTokenSigningKey(),
+			WithListenerAddress("127.0.0.1"),
+			WithAddressFamily(grpcutil.AddressFamilyIPv6),
+		)
+		require.NoError(t, err)
+		err = s.Listen(context.Background(), wait.Backoff{Duration: 100 * time.Millisecond, Steps: 2})
+		require.Error(t, err)
+		assert.Nil(t, s.listener)
+	})
+
 	t.Run("Listen on privileged port", func(t *testing.T) {
 		s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps(testNamespace), testNamespace,
 			WithTLSKeyPairFromPath(path.Join(tempDir, "test-cert.crt"), path.Join(tempDir, "test-cert.key")),
@@ -190,3 +226,63 @@ func Test_Serve(t *testing.T) {
 	err = s.Shutdown()
 	assert.NoError(t, err)
 }
+
+func Test_ServeWithReflection(t *testing.T) {
+	listServices := func(t *testing.T, reflection bool) ([]string, error) {
+		t.Helper()
+		tempDir := t.TempDir()
+		fakecerts.WriteSelfSignedCert(t, "rsa", path.Join(tempDir, "test-cert"), certTempl)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		s, err := NewServer(ctx, kube.NewKubernetesFakeClientWithApps(testNamespace), testNamespace,
+			WithTLSKeyPairFromPath(path.Join(tempDir, "test-cert.crt"), path.Join(tempDir, "test-cert.key")),
+			WithGeneratedTokenSigningKey(),
+			WithListenerPort(0),
+			WithListenerAddress("127.0.0.1"),
+			WithShutDownGracePeriod(2*time.Second),
+			WithGRPC(true),
+			WithGRPCReflection(reflection),
+			WithRedisProxyDisabled(),
+			WithInformerSyncTimeout(5*time.Second),
+		)
+		require.NoError(t, err)
+		require.NoError(t, s.Start(ctx, make(chan error)))
+		defer func() { _ = s.Shutdown() }()
+
+		conn := grpcDialer(t, s)
+		defer conn.Close()
+		stream, err := grpc_reflection_v1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		require.NoError(t, err)
+		err = stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+			MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_ListServices{},
+		})
+		require.NoError(t, err)
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		services := []string{}
+		for _, svc := range resp.GetListServicesResponse().GetService() {
+			services = append(services, svc.GetName())
+		}
+		return services, nil
+	}
+
+	t.Run("Reflection lists services without authentication", func(t *testing.T) {
+		services, err := listServices(t, true)
+		require.NoError(t, err)
+		assert.Contains(t, services, "eventstreamapi.EventStream")
+		assert.Contains(t, services, "principal.apis.logstreamapi.LogStreamService")
+		assert.Contains(t, services, "grpc.reflection.v1.ServerReflection")
+		// The shared list of unauthenticated endpoints must not be modified
+		assert.NotContains(t, noAuthEndpoints, grpc_reflection_v1.ServerReflection_ServerReflectionInfo_FullMethodName)
+	})
+
+	t.Run("Reflection is disabled by default", func(t *testing.T) {
+		_, err := listServices(t, false)
+		require.Error(t, err)
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// logCancelTimeout is how long we wait for an agent to confirm that it tore
+// down the log stream of a request whose client went away.
+const logCancelTimeout = 2 * time.Minute
+
+// logCancels tracks the follow log streams agents were asked to tear down,
+// until they confirm it.
+type logCancels struct {
+	mu sync.Mutex
+	// pending are the canceled log streams, by request UUID
+	pending map[string]*pendingLogCancel
+}
+
+type pendingLogCancel struct {
+	agentName string
+	// detached is when the client went away
+	detached time.Time
+	timer    *time.Timer
+}
+
+func (c *logCancels) add(requestUUID string, p *pendingLogCancel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[string]*pendingLogCancel)
+	}
+	c.pending[requestUUID] = p
+}
+
+// take removes and returns the pending cancellation of the log stream
+// requestUUID of agentName
+func (c *logCancels) take(requestUUID, agentName string) (*pendingLogCancel, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[requestUUID]
+	if !ok || p.agentName != agentName {
+		return nil, false
+	}
+	delete(c.pending, requestUUID)
+	return p, true
+}
+
+// cancelAgentLogStream asks the agent to tear down the log stream of a
+// follow log request whose client went away at detached. Without it, the
+// agent only notices once it has new log data to send.
+func (s *Server) cancelAgentLogStream(requestUUID, agentName string, detached time.Time, logCtx *logrus.Entry) {
+	if s.eventStreamSrv == nil {
+		return
+	}
+	if v, _ := s.eventStreamSrv.AgentSchemaVersion(agentName); v < event.SchemaVersion13 {
+		s.observeLogStreamTeardown("unsupported", 0)
+		return
+	}
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return
+	}
+	ev, err := s.events.NewLogStreamCancelEvent(&event.LogStreamCancel{UUID: requestUUID})
+	if err != nil {
+		logCtx.WithError(err).Warn("Could not create log stream cancel event")
+		return
+	}
+	event.SetTTL(ev, logCancelTimeout)
+	p := &pendingLogCancel{agentName: agentName, detached: detached}
+	p.timer = time.AfterFunc(logCancelTimeout, func() {
+		if _, ok := s.logCancels.take(requestUUID, agentName); ok {
+			logCtx.Warnf("Agent did not confirm tearing down the log stream within %v", logCancelTimeout)
+			s.observeLogStreamTeardown("unconfirmed", 0)
+		}
+	})
+	s.logCancels.add(requestUUID, p)
+	q.Add(ev)
+}
+
+// processLogCancelEvent processes the acknowledgement of a log stream
+// cancellation, and records how long it took to tear down the stream since
+// its client went away.
+func (s *Server) processLogCancelEvent(agentName string, ev *cloudevents.Event) error {
+	e := event.New(ev, event.TargetLogCancel)
+	if e.Type() != event.LogStreamCancelAck {
+		return fmt.Errorf("unexpected log cancel event type %s", e.Type())
+	}
+	res, err := e.LogStreamCancelResult()
+	if err != nil {
+		return fmt.Errorf("invalid log cancel result: %w", err)
+	}
+	logCtx := log().WithFields(logrus.Fields{"agent": agentName, "uuid": res.UUID})
+	p, ok := s.logCancels.take(res.UUID, agentName)
+	if !ok {
+		logCtx.Debug("Ignoring acknowledgement of unknown log stream cancellation")
+		return nil
+	}
+	p.timer.Stop()
+	elapsed := time.Since(p.detached)
+	logCtx.WithFields(logrus.Fields{
+		"running":        res.Running,
+		"agent_teardown": res.Teardown,
+		"teardown":       elapsed,
+	}).Debug("Agent tore down log stream")
+	if res.Running {
+		s.observeLogStreamTeardown("torn_down", elapsed)
+	} else {
+		s.observeLogStreamTeardown("not_running", elapsed)
+	}
+	return nil
+}
+
+func (s *Server) observeLogStreamTeardown(result string, elapsed time.Duration) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.ResourceProxy.LogStreamTeardowns.WithLabelValues(result).Inc()
+	if result == "torn_down" {
+		s.metrics.ResourceProxy.LogStreamTeardownDuration.Observe(elapsed.Seconds())
+	}
+}
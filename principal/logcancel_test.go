@@ -0,0 +1,84 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cancelAgentLogStream(t *testing.T) {
+	agentEvents := event.NewEventSource("agent")
+	logCtx := logrus.NewEntry(logrus.StandardLogger())
+
+	newServer := func(t *testing.T) *Server {
+		t.Helper()
+		s := newResourceTestServer(t)
+		s.metrics = metrics.NewPrincipalMetricsWith(prometheus.NewRegistry())
+		return s
+	}
+
+	t.Run("Records the teardown once the agent confirms it", func(t *testing.T) {
+		s := newServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		s.cancelAgentLogStream("log-1", "agent", time.Now().Add(-time.Second), logCtx)
+
+		ev, shutdown := s.queues.SendQ("agent").Get()
+		require.False(t, shutdown)
+		s.queues.SendQ("agent").Done(ev)
+		req, err := event.New(ev, event.TargetLogCancel).LogStreamCancel()
+		require.NoError(t, err)
+		assert.Equal(t, "log-1", req.UUID)
+
+		ack, err := agentEvents.LogStreamCancelAckEvent(&event.LogStreamCancelResult{UUID: "log-1", Running: true, Teardown: 10 * time.Millisecond})
+		require.NoError(t, err)
+		// Acknowledgements of other agents are ignored
+		require.NoError(t, s.processLogCancelEvent("other", ack))
+		require.NoError(t, s.processLogCancelEvent("agent", ack))
+		assert.Equal(t, float64(1), testutil.ToFloat64(s.metrics.ResourceProxy.LogStreamTeardowns.WithLabelValues("torn_down")))
+		assert.Equal(t, 1, testutil.CollectAndCount(s.metrics.ResourceProxy.LogStreamTeardownDuration))
+
+		// A second acknowledgement is ignored
+		require.NoError(t, s.processLogCancelEvent("agent", ack))
+		assert.Equal(t, float64(1), testutil.ToFloat64(s.metrics.ResourceProxy.LogStreamTeardowns.WithLabelValues("torn_down")))
+	})
+
+	t.Run("Counts streams which were not running anymore", func(t *testing.T) {
+		s := newServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		s.cancelAgentLogStream("log-1", "agent", time.Now(), logCtx)
+		ack, err := agentEvents.LogStreamCancelAckEvent(&event.LogStreamCancelResult{UUID: "log-1"})
+		require.NoError(t, err)
+		require.NoError(t, s.processLogCancelEvent("agent", ack))
+		assert.Equal(t, float64(1), testutil.ToFloat64(s.metrics.ResourceProxy.LogStreamTeardowns.WithLabelValues("not_running")))
+	})
+
+	t.Run("Does not cancel streams of agents which do not support it", func(t *testing.T) {
+		s := newServer(t)
+		s.cancelAgentLogStream("log-1", "agent", time.Now(), logCtx)
+		assert.Equal(t, 0, s.queues.SendQ("agent").Len())
+		assert.Equal(t, float64(1), testutil.ToFloat64(s.metrics.ResourceProxy.LogStreamTeardowns.WithLabelValues("unsupported")))
+	})
+}
@@ -0,0 +1,317 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// logMuxTombstone is how long we remember a multiplexed log stream after it
+// ended, so that frames resent by the agent don't open it again.
+const logMuxTombstone = time.Minute
+
+// logMux holds the log streams agents multiplex over their event stream
+type logMux struct {
+	mu sync.Mutex
+	// streams are the multiplexed log streams, by agent name and stream ID
+	streams map[string]*muxLogStream
+}
+
+func logMuxKey(agentName, streamID string) string {
+	return agentName + "/" + streamID
+}
+
+// stream returns the log stream with the given ID of agentName, opening it
+// if frame is its first frame. It returns nil if the frame belongs to a
+// stream that ended or was never opened.
+func (m *logMux) stream(agentName string, frame *event.LogFrame, open func() *muxLogStream) (*muxLogStream, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := logMuxKey(agentName, frame.StreamID)
+	if ms, ok := m.streams[key]; ok {
+		return ms, false
+	}
+	if frame.Seq != 1 {
+		return nil, false
+	}
+	if m.streams == nil {
+		m.streams = make(map[string]*muxLogStream)
+	}
+	ms := open()
+	m.streams[key] = ms
+	return ms, true
+}
+
+// forget removes the log stream with the given ID of agentName after the
+// tombstone period
+func (m *logMux) forget(agentName, streamID string, ms *muxLogStream) {
+	time.AfterFunc(logMuxTombstone, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		key := logMuxKey(agentName, streamID)
+		if m.streams[key] == ms {
+			delete(m.streams, key)
+		}
+	})
+}
+
+// closeAgent breaks off the log streams of agentName, e.g. because it
+// disconnected
+func (m *logMux) closeAgent(agentName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ms := range m.streams {
+		if ms.agentName == agentName {
+			ms.cancel()
+		}
+	}
+}
+
+// muxLogStream is the principal's side of a log stream an agent multiplexes
+// over its event stream. It is served by the LogStream service like any
+// other log stream.
+type muxLogStream struct {
+	agentName string
+	streamID  string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	// credit sends the number of the last frame consumed to the agent
+	credit func(consumed uint64)
+
+	mu sync.Mutex
+	// frames are the frames received but not consumed yet, by sequence
+	// number
+	frames map[uint64]*event.LogFrame
+	// next is the sequence number of the next frame to consume
+	next uint64
+	// eof is set once the agent's last frame was consumed
+	eof bool
+	// abandoned is set once the agent abandoned the stream, which ends it
+	// regardless of the frames still missing
+	abandoned bool
+	resp      *logstreamapi.LogStreamResponse
+	// overrun is set once the agent sent a frame beyond its window, which
+	// fails the stream, so that frames kept out of order stay bounded
+	overrun bool
+	// ready is signaled whenever a frame is received
+	ready chan struct{}
+}
+
+func newMuxLogStream(ctx context.Context, agentName, streamID string, credit func(uint64)) *muxLogStream {
+	ms := &muxLogStream{
+		agentName: agentName,
+		streamID:  streamID,
+		credit:    credit,
+		frames:    make(map[uint64]*event.LogFrame),
+		next:      1,
+		ready:     make(chan struct{}, 1),
+	}
+	// The LogStream service tells the agent of a stream by its context, like
+	// that of an authenticated gRPC stream
+	ms.ctx, ms.cancel = context.WithCancel(context.WithValue(ctx, types.ContextAgentIdentifier, agentName))
+	return ms
+}
+
+// deliver records a frame received from the agent. Frames received twice
+// are ignored. The agent may send up to LogFrameWindow frames ahead of the
+// frames consumed, plus its closing frame; a frame beyond that fails the
+// stream.
+func (ms *muxLogStream) deliver(frame *event.LogFrame) {
+	ms.mu.Lock()
+	if frame.Canceled {
+		ms.abandoned = true
+	} else if frame.Seq > ms.next+event.LogFrameWindow {
+		ms.overrun = true
+		ms.frames = make(map[uint64]*event.LogFrame)
+	} else if _, ok := ms.frames[frame.Seq]; !ok && frame.Seq >= ms.next && !ms.overrun {
+		ms.frames[frame.Seq] = frame
+	}
+	ms.mu.Unlock()
+	select {
+	case ms.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Recv returns the next message of the stream, in the order the agent sent
+// them
+func (ms *muxLogStream) Recv() (*logstreamapi.LogStreamData, error) {
+	for {
+		ms.mu.Lock()
+		if ms.abandoned {
+			ms.mu.Unlock()
+			return nil, status.Error(codes.Canceled, "agent abandoned the log stream")
+		}
+		if ms.overrun {
+			ms.mu.Unlock()
+			return nil, status.Errorf(codes.ResourceExhausted, "agent sent more than %d log frames ahead", event.LogFrameWindow)
+		}
+		if ms.eof {
+			ms.mu.Unlock()
+			return nil, io.EOF
+		}
+		frame, ok := ms.frames[ms.next]
+		if ok {
+			delete(ms.frames, ms.next)
+			ms.next++
+			ms.eof = frame.Close
+		}
+		ms.mu.Unlock()
+		if ok {
+			// Credit the agent every half window, so that it can keep
+			// sending while the credit is underway
+			if frame.Seq%(event.LogFrameWindow/2) == 0 {
+				ms.credit(frame.Seq)
+			}
+			if frame.Close {
+				return nil, io.EOF
+			}
+			msg := &logstreamapi.LogStreamData{}
+			if err := proto.Unmarshal(frame.Data, msg); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid log frame %d: %v", frame.Seq, err)
+			}
+			return msg, nil
+		}
+		select {
+		case <-ms.ready:
+		case <-ms.ctx.Done():
+			return nil, status.Error(codes.Unavailable, "agent disconnected")
+		}
+	}
+}
+
+// SendAndClose records the response the stream ends with
+func (ms *muxLogStream) SendAndClose(resp *logstreamapi.LogStreamResponse) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.resp = resp
+	return nil
+}
+
+func (ms *muxLogStream) Context() context.Context {
+	return ms.ctx
+}
+
+func (ms *muxLogStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (ms *muxLogStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (ms *muxLogStream) SetTrailer(metadata.MD) {}
+
+func (ms *muxLogStream) SendMsg(m any) error {
+	resp, ok := m.(*logstreamapi.LogStreamResponse)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message %T", m)
+	}
+	return ms.SendAndClose(resp)
+}
+
+func (ms *muxLogStream) RecvMsg(m any) error {
+	msg, ok := m.(*logstreamapi.LogStreamData)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message %T", m)
+	}
+	data, err := ms.Recv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(msg, data)
+	return nil
+}
+
+// processLogFrameEvent passes a frame of a multiplexed log stream to the
+// stream, and starts serving the stream on its first frame.
+func (s *Server) processLogFrameEvent(agentName string, ev *cloudevents.Event) error {
+	e := event.New(ev, event.TargetLogFrame)
+	if e.Type() != event.LogFrameSent {
+		return fmt.Errorf("unexpected log frame event type %s", e.Type())
+	}
+	frame, err := e.LogFrame()
+	if err != nil {
+		return fmt.Errorf("invalid log frame: %w", err)
+	}
+	ms, opened := s.logMux.stream(agentName, frame, func() *muxLogStream {
+		return newMuxLogStream(s.ctx, agentName, frame.StreamID, func(consumed uint64) {
+			s.sendLogFrameControl(agentName, event.LogFrameCredit, &event.LogFrameControl{StreamID: frame.StreamID, Consumed: consumed})
+		})
+	})
+	if ms == nil {
+		log().WithFields(logrus.Fields{"agent": agentName, "stream_id": frame.StreamID}).Debugf("Ignoring frame %d of unknown log stream", frame.Seq)
+		return nil
+	}
+	ms.deliver(frame)
+	if opened {
+		go s.serveMuxLogStream(ms)
+	}
+	return nil
+}
+
+// serveMuxLogStream serves a multiplexed log stream until it ends, and tells
+// the agent how it ended.
+func (s *Server) serveMuxLogStream(ms *muxLogStream) {
+	defer s.logMux.forget(ms.agentName, ms.streamID, ms)
+	defer ms.cancel()
+	err := s.logStream.StreamLogs(ms)
+	ctrl := &event.LogFrameControl{StreamID: ms.streamID}
+	st := status.Convert(err)
+	ctrl.Code = uint32(st.Code())
+	ctrl.Message = st.Message()
+	ms.mu.Lock()
+	resp := ms.resp
+	ms.mu.Unlock()
+	for _, d := range st.Details() {
+		if r, ok := d.(*logstreamapi.LogStreamResponse); ok {
+			resp = r
+		}
+	}
+	if resp != nil {
+		ctrl.Response, err = proto.Marshal(resp)
+		if err != nil {
+			log().WithError(err).Warn("Could not marshal log stream response")
+		}
+	}
+	s.sendLogFrameControl(ms.agentName, event.LogFrameClosed, ctrl)
+}
+
+func (s *Server) sendLogFrameControl(agentName string, evType event.EventType, ctrl *event.LogFrameControl) {
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return
+	}
+	ev, err := s.events.LogFrameControlEvent(evType, ctrl)
+	if err != nil {
+		log().WithError(err).Warn("Could not create log frame control event")
+		return
+	}
+	q.Add(ev)
+}
@@ -0,0 +1,137 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func logFrame(t *testing.T, streamID string, seq uint64, msg *logstreamapi.LogStreamData) *event.LogFrame {
+	t.Helper()
+	f := &event.LogFrame{StreamID: streamID, Seq: seq, Close: msg == nil}
+	if msg != nil {
+		data, err := proto.Marshal(msg)
+		require.NoError(t, err)
+		f.Data = data
+	}
+	return f
+}
+
+func Test_muxLogStream(t *testing.T) {
+	t.Run("Receives frames in order and credits the agent", func(t *testing.T) {
+		var credits []uint64
+		ms := newMuxLogStream(context.Background(), "agent", "stream", func(consumed uint64) {
+			credits = append(credits, consumed)
+		})
+		last := uint64(event.LogFrameWindow/2 + 1)
+		// Frames arrive in reverse order, and some twice
+		for seq := last; seq >= 1; seq-- {
+			ms.deliver(logFrame(t, "stream", seq, &logstreamapi.LogStreamData{RequestUuid: "req", Data: []byte{byte(seq)}}))
+		}
+		ms.deliver(logFrame(t, "stream", 2, &logstreamapi.LogStreamData{RequestUuid: "req"}))
+		for seq := uint64(1); seq <= last; seq++ {
+			msg, err := ms.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, []byte{byte(seq)}, msg.Data)
+		}
+		assert.Equal(t, []uint64{event.LogFrameWindow / 2}, credits)
+
+		// A frame received again after it was consumed is ignored
+		ms.deliver(logFrame(t, "stream", 1, &logstreamapi.LogStreamData{RequestUuid: "req"}))
+		ms.deliver(logFrame(t, "stream", last+1, nil))
+		_, err := ms.Recv()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Ends when the agent abandons the stream", func(t *testing.T) {
+		ms := newMuxLogStream(context.Background(), "agent", "stream", func(uint64) {})
+		f := logFrame(t, "stream", 5, nil)
+		f.Canceled = true
+		ms.deliver(f)
+		_, err := ms.Recv()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+
+	t.Run("Fails when the agent sends beyond its window", func(t *testing.T) {
+		ms := newMuxLogStream(context.Background(), "agent", "stream", func(uint64) {})
+		// Frame 1 is missing, so none of the others can be consumed
+		for seq := uint64(2); seq <= event.LogFrameWindow+1; seq++ {
+			ms.deliver(logFrame(t, "stream", seq, &logstreamapi.LogStreamData{RequestUuid: "req"}))
+		}
+		assert.Len(t, ms.frames, event.LogFrameWindow)
+		ms.deliver(logFrame(t, "stream", event.LogFrameWindow+2, &logstreamapi.LogStreamData{RequestUuid: "req"}))
+		assert.Empty(t, ms.frames)
+		ms.deliver(logFrame(t, "stream", 1, &logstreamapi.LogStreamData{RequestUuid: "req"}))
+		assert.Empty(t, ms.frames)
+		_, err := ms.Recv()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("Ends when the agent disconnects", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		ms, _ := s.logMux.stream("agent", logFrame(t, "stream", 1, nil), func() *muxLogStream {
+			return newMuxLogStream(context.Background(), "agent", "stream", func(uint64) {})
+		})
+		s.logMux.closeAgent("agent")
+		_, err := ms.Recv()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func Test_processLogFrameEvent(t *testing.T) {
+	agentEvents := event.NewEventSource("agent")
+	s := newResourceTestServer(t)
+	send := func(f *event.LogFrame) {
+		t.Helper()
+		ev, err := agentEvents.LogFrameEvent(f)
+		require.NoError(t, err)
+		require.NoError(t, s.processLogFrameEvent("agent", ev))
+	}
+
+	// Frames of streams which were never opened are ignored
+	send(logFrame(t, "unknown", 2, nil))
+
+	// The principal knows no request with this UUID, and ends the stream
+	send(logFrame(t, "stream", 1, &logstreamapi.LogStreamData{RequestUuid: "unknown"}))
+	q := s.queues.SendQ("agent")
+	require.Eventually(t, func() bool { return q.Len() > 0 }, 5*time.Second, 10*time.Millisecond)
+	ev, _ := q.Get()
+	q.Done(ev)
+	e := event.New(ev, event.TargetLogFrame)
+	assert.Equal(t, event.LogFrameClosed, e.Type())
+	ctrl, err := e.LogFrameControl()
+	require.NoError(t, err)
+	assert.Equal(t, "stream", ctrl.StreamID)
+	assert.Equal(t, codes.NotFound, codes.Code(ctrl.Code))
+	resp := &logstreamapi.LogStreamResponse{}
+	require.NoError(t, proto.Unmarshal(ctrl.Response, resp))
+	assert.Equal(t, codes.NotFound.String(), resp.ErrorCode)
+
+	// Frames resent by the agent don't open the stream again
+	send(logFrame(t, "stream", 1, &logstreamapi.LogStreamData{RequestUuid: "unknown"}))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, q.Len())
+}
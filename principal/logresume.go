@@ -0,0 +1,151 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// logResumeDelay is how long we wait for an agent that is still connected to
+// resume an interrupted follow log stream by itself, before we request the
+// logs again.
+const logResumeDelay = 5 * time.Second
+
+// logResumeState tracks the requests of follow log streams, so that they can
+// be sent again when the agent's stream was interrupted, e.g. because the
+// leading agent replica went away.
+type logResumeState struct {
+	mu sync.Mutex
+	// requests are the log request events of follow log streams, by request
+	// UUID
+	requests map[string]resumableLogRequest
+}
+
+type resumableLogRequest struct {
+	agentName string
+	ev        *cloudevents.Event
+}
+
+func newLogResumeState() *logResumeState {
+	return &logResumeState{requests: make(map[string]resumableLogRequest)}
+}
+
+// trackResumableLogStream records the request of a follow log stream, so it
+// can be resumed by the agent. The returned function stops tracking it.
+func (s *Server) trackResumableLogStream(requestUUID, agentName string, ev *cloudevents.Event) func() {
+	s.logResume.mu.Lock()
+	s.logResume.requests[requestUUID] = resumableLogRequest{agentName: agentName, ev: ev}
+	s.logResume.mu.Unlock()
+	s.logStream.MarkResumable(requestUUID)
+	return func() {
+		s.logResume.mu.Lock()
+		defer s.logResume.mu.Unlock()
+		delete(s.logResume.requests, requestUUID)
+	}
+}
+
+// onLogStreamInterrupted requests the logs of an interrupted follow log
+// stream again, unless the agent resumed it by itself in the meantime.
+func (s *Server) onLogStreamInterrupted(requestUUID string) {
+	time.AfterFunc(logResumeDelay, func() {
+		s.logResume.mu.Lock()
+		req, ok := s.logResume.requests[requestUUID]
+		s.logResume.mu.Unlock()
+		if ok && s.eventStreamSrv != nil && s.eventStreamSrv.IsAgentConnected(req.agentName) {
+			s.resendLogRequest(requestUUID, req)
+		}
+	})
+}
+
+// onLogStreamRejected counts the log streams the LogStream service rejected
+// because of their request ID. The service logs the details.
+func (s *Server) onLogStreamRejected(agentName, reason string) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.ResourceProxy.LogStreamRejections.WithLabelValues(reason).Inc()
+}
+
+// resumeLogStreamsOnConnect requests the logs of the interrupted follow log
+// streams of a (re)connecting agent again. A new replica of the agent knows
+// nothing about the streams of its predecessor.
+func (s *Server) resumeLogStreamsOnConnect(agent types.Agent) error {
+	s.logResume.mu.Lock()
+	requests := make(map[string]resumableLogRequest)
+	for requestUUID, req := range s.logResume.requests {
+		if req.agentName == agent.Name() {
+			requests[requestUUID] = req
+		}
+	}
+	s.logResume.mu.Unlock()
+	for requestUUID, req := range requests {
+		s.resendLogRequest(requestUUID, req)
+	}
+	return nil
+}
+
+// resendLogRequest sends the request of an interrupted follow log stream to
+// the agent again, asking for the logs since the last line the client
+// received. Agents already streaming the request ignore it.
+func (s *Server) resendLogRequest(requestUUID string, req resumableLogRequest) {
+	since, ok := s.logStream.Interrupted(requestUUID)
+	if !ok {
+		return
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"agent": req.agentName,
+		"uuid":  requestUUID,
+	})
+	ev, err := resumeLogRequestEvent(req.ev, since)
+	if err != nil {
+		logCtx.WithError(err).Error("Could not create log request to resume log stream")
+		return
+	}
+	q := s.queues.SendQ(req.agentName)
+	if q == nil {
+		logCtx.Warn("No send queue for agent; cannot resume log stream")
+		return
+	}
+	logCtx.WithField("since", since).Info("Requesting logs again to resume interrupted log stream")
+	q.Add(ev)
+}
+
+// resumeLogRequestEvent returns a copy of the log request event ev, which
+// requests the logs since the given time. Limits of the original request
+// would cause gaps, so they are dropped. Lines of the same second the client
+// received already are dropped by the log stream server.
+func resumeLogRequestEvent(ev *cloudevents.Event, since time.Time) (*cloudevents.Event, error) {
+	resumed := ev.Clone()
+	if !since.IsZero() {
+		logReq := &event.ContainerLogRequest{}
+		if err := ev.DataAs(logReq); err != nil {
+			return nil, err
+		}
+		logReq.SinceTime = since.UTC().Truncate(time.Second).Format(time.RFC3339)
+		logReq.SinceSeconds = nil
+		logReq.TailLines = nil
+		if err := resumed.SetData(cloudevents.ApplicationJSON, logReq); err != nil {
+			return nil, err
+		}
+	}
+	event.SetTTL(&resumed, logRequestTimeout)
+	return &resumed, nil
+}
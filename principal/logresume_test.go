@@ -0,0 +1,86 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// interruptedLogStream delivers its data and then fails like the stream of
+// an agent replica that went away
+type interruptedLogStream struct {
+	*mock.MockLogStreamServer
+	data []*logstreamapi.LogStreamData
+}
+
+func (s *interruptedLogStream) Recv() (*logstreamapi.LogStreamData, error) {
+	if len(s.data) == 0 {
+		return nil, status.Error(codes.Unavailable, "transport is closing")
+	}
+	d := s.data[0]
+	s.data = s.data[1:]
+	return d, nil
+}
+
+func Test_ResumeLogStreamsOnConnect(t *testing.T) {
+	s := newDrainTestServer(t)
+	require.NoError(t, s.queues.Create("agent-1"))
+	ev, err := s.events.NewLogRequestEvent("argocd", "app-pod", "GET",
+		map[string]string{"follow": "true", "tailLines": "100", "container": "app"}, 0)
+	require.NoError(t, err)
+	requestUUID := event.EventID(ev)
+
+	require.NoError(t, s.logStream.RegisterHTTP(requestUUID, mock.NewMockHTTPResponseWriter(), httptest.NewRequest("GET", "/logs?follow=true", nil)))
+	defer s.trackResumableLogStream(requestUUID, "agent-1", ev)()
+
+	// Nothing to resume while the agent's stream is intact
+	require.NoError(t, s.resumeLogStreamsOnConnect(types.NewAgent("agent-1", "managed")))
+	assert.Zero(t, s.queues.SendQ("agent-1").Len())
+
+	_ = s.logStream.StreamLogs(&interruptedLogStream{
+		MockLogStreamServer: mock.NewMockLogStreamServer(context.Background()),
+		data: []*logstreamapi.LogStreamData{{
+			RequestUuid: requestUUID,
+			Data:        []byte("2025-01-01T10:00:05.5Z line\n"),
+		}},
+	})
+
+	// A new agent replica connecting gets the request again
+	require.NoError(t, s.resumeLogStreamsOnConnect(types.NewAgent("agent-2", "managed")))
+	assert.Zero(t, s.queues.SendQ("agent-1").Len())
+	require.NoError(t, s.resumeLogStreamsOnConnect(types.NewAgent("agent-1", "managed")))
+	q := s.queues.SendQ("agent-1")
+	require.Equal(t, 1, q.Len())
+	resent, _ := q.Get()
+	assert.Equal(t, requestUUID, event.EventID(resent))
+	logReq, err := event.New(resent, event.TargetContainerLog).ContainerLogRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "2025-01-01T10:00:05Z", logReq.SinceTime)
+	assert.Nil(t, logReq.TailLines)
+	assert.Equal(t, "app", logReq.Container)
+	assert.True(t, logReq.Follow)
+	assert.NotNil(t, event.ExpiresAt(resent))
+}
@@ -0,0 +1,97 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// sharedLogWindow is how long after it was sent an identical static log
+// request, e.g. one retried by a browser refresh, joins a request in progress
+// instead of being sent to the agent again.
+const sharedLogWindow = 10 * time.Second
+
+// sharedLogRequests tracks the static log requests in progress by their
+// fingerprint.
+type sharedLogRequests struct {
+	mu       sync.Mutex
+	requests map[string]sharedLogRequest
+}
+
+type sharedLogRequest struct {
+	requestUUID string
+	sent        time.Time
+}
+
+func newSharedLogRequests() *sharedLogRequests {
+	return &sharedLogRequests{requests: make(map[string]sharedLogRequest)}
+}
+
+// staticLogKey returns the fingerprint of a static log request, made up of
+// everything that decides what the agent sends.
+func staticLogKey(agentName, namespace, pod string, params map[string]string) string {
+	q := url.Values{}
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	return agentName + "/" + namespace + "/" + pod + "?" + q.Encode()
+}
+
+// shareStaticLogs lets identical requests join the static log request
+// requestUUID for a while. The returned function stops sharing it.
+func (s *Server) shareStaticLogs(key, requestUUID string) func() {
+	if !s.logStream.Share(requestUUID) {
+		return func() {}
+	}
+	s.sharedLogs.mu.Lock()
+	s.sharedLogs.requests[key] = sharedLogRequest{requestUUID: requestUUID, sent: time.Now()}
+	s.sharedLogs.mu.Unlock()
+	return func() {
+		s.sharedLogs.mu.Lock()
+		defer s.sharedLogs.mu.Unlock()
+		if s.sharedLogs.requests[key].requestUUID == requestUUID {
+			delete(s.sharedLogs.requests, key)
+		}
+	}
+}
+
+// joinStaticLogs answers a static log request from an identical request in
+// progress, if there is one that was sent recently. It returns false if the
+// request has to be sent to the agent.
+func (s *Server) joinStaticLogs(w http.ResponseWriter, r *http.Request, key, agentName string, reqParams map[string]string, logCtx *logrus.Entry) bool {
+	s.sharedLogs.mu.Lock()
+	shared, ok := s.sharedLogs.requests[key]
+	s.sharedLogs.mu.Unlock()
+	if !ok || time.Since(shared.sent) > sharedLogWindow {
+		return false
+	}
+	requestUUID := uuid.NewString()
+	logCtx = logCtx.WithFields(logrus.Fields{"uuid": requestUUID, "shared_uuid": shared.requestUUID})
+	if err := s.logStream.Join(shared.requestUUID, requestUUID, w, r); err != nil {
+		logCtx.WithError(err).Debug("Could not join identical log request in progress")
+		return false
+	}
+	logCtx.Info("Joined identical log request in progress")
+	defer s.logStream.RemoveSession(requestUUID)
+	defer s.activity.beginStream(agentName, streamLogs)()
+	s.waitForStaticLogs(w, requestUUID, reqParams, logCtx)
+	return true
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_StaticLogKey(t *testing.T) {
+	key := staticLogKey("agent", "default", "guestbook", map[string]string{"container": "ui", "tailLines": "100"})
+	assert.Equal(t, key, staticLogKey("agent", "default", "guestbook", map[string]string{"tailLines": "100", "container": "ui"}))
+	assert.NotEqual(t, key, staticLogKey("agent", "default", "guestbook", map[string]string{"container": "ui", "tailLines": "10"}))
+	assert.NotEqual(t, key, staticLogKey("other", "default", "guestbook", map[string]string{"container": "ui", "tailLines": "100"}))
+}
+
+func Test_SharedStaticLogs(t *testing.T) {
+	s := newDrainTestServer(t)
+	params := map[string]string{"container": "ui"}
+	key := staticLogKey("agent-1", "default", "guestbook", params)
+	newRequest := func() *httptest.ResponseRecorder { return httptest.NewRecorder() }
+	r := httptest.NewRequest("GET", "/api/v1/namespaces/default/pods/guestbook/log?container=ui", nil)
+
+	assert.False(t, s.joinStaticLogs(newRequest(), r, key, "agent-1", params, log()), "nothing to join")
+
+	require.NoError(t, s.logStream.RegisterHTTP("leader", mock.NewMockHTTPResponseWriter(), r))
+	stop := s.shareStaticLogs(key, "leader")
+
+	t.Run("Identical request joins the request in progress", func(t *testing.T) {
+		joined := make(chan bool)
+		go func() { joined <- s.joinStaticLogs(newRequest(), r, key, "agent-1", params, log()) }()
+		require.Eventually(t, func() bool {
+			streams, _, _ := s.activity.get("agent-1")
+			return streams.Logs > 0
+		}, time.Second, 10*time.Millisecond)
+		// The leader ends, so the joined request does, too
+		s.logStream.RemoveSession("leader")
+		select {
+		case ok := <-joined:
+			assert.True(t, ok)
+		case <-time.After(5 * time.Second):
+			t.Fatal("joined request did not complete")
+		}
+	})
+
+	t.Run("Requests outside the window are sent to the agent", func(t *testing.T) {
+		require.NoError(t, s.logStream.RegisterHTTP("leader-2", mock.NewMockHTTPResponseWriter(), r))
+		defer s.logStream.RemoveSession("leader-2")
+		stop := s.shareStaticLogs(key, "leader-2")
+		defer stop()
+		s.sharedLogs.mu.Lock()
+		shared := s.sharedLogs.requests[key]
+		shared.sent = time.Now().Add(-2 * sharedLogWindow)
+		s.sharedLogs.requests[key] = shared
+		s.sharedLogs.mu.Unlock()
+		assert.False(t, s.joinStaticLogs(newRequest(), r, key, "agent-1", params, log()))
+	})
+
+	stop()
+	s.sharedLogs.mu.Lock()
+	assert.Empty(t, s.sharedLogs.requests)
+	s.sharedLogs.mu.Unlock()
+}
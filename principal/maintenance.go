@@ -0,0 +1,45 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errAgentInMaintenance is returned for requests that are refused because
+// the agent is in maintenance mode.
+var errAgentInMaintenance = errors.New("agent is in maintenance mode")
+
+// inMaintenance returns whether the agent is in maintenance mode. Agents in
+// maintenance mode stay connected and keep syncing, but we don't start any
+// new interactive requests, such as log streams or terminal sessions, for
+// them. Requests already in flight are allowed to finish.
+func (s *Server) inMaintenance(agentName string) bool {
+	return s.clusterMgr != nil && s.clusterMgr.InMaintenance(agentName)
+}
+
+// refuseInMaintenance responds with 503 Service Unavailable and returns true
+// if the agent is in maintenance mode.
+func (s *Server) refuseInMaintenance(w http.ResponseWriter, agentName string, logCtx *logrus.Entry) bool {
+	if !s.inMaintenance(agentName) {
+		return false
+	}
+	logCtx.WithField("agent", agentName).Info("Refusing request: agent is in maintenance mode")
+	http.Error(w, errAgentInMaintenance.Error(), http.StatusServiceUnavailable)
+	return true
+}
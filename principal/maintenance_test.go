@@ -0,0 +1,58 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	appv1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Maintenance(t *testing.T) {
+	s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled())
+	require.NoError(t, err)
+	require.NoError(t, s.clusterMgr.MapCluster("agent-1", &appv1.Cluster{
+		Annotations: map[string]string{cluster.AnnotationKeyMaintenance: "true"},
+	}))
+	require.NoError(t, s.clusterMgr.MapCluster("agent-2", &appv1.Cluster{}))
+
+	t.Run("Requests to agents in maintenance mode are refused", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		assert.True(t, s.refuseInMaintenance(w, "agent-1", log()))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "maintenance mode")
+	})
+
+	t.Run("Requests to other agents are passed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		assert.False(t, s.refuseInMaintenance(w, "agent-2", log()))
+		assert.False(t, s.refuseInMaintenance(w, "unknown", log()))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Maintenance mode is reported in the agent status", func(t *testing.T) {
+		assert.True(t, s.agentStatus("agent-1", time.Now()).Maintenance)
+		assert.False(t, s.agentStatus("agent-2", time.Now()).Maintenance)
+	})
+}
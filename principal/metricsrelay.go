@@ -0,0 +1,88 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
+	"github.com/google/uuid"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/validation"
+)
+
+// agentMetricsPattern is the pattern on the principal's metrics server under
+// which the metrics of connected agents are relayed.
+const agentMetricsPattern = "GET /metrics/agents/{name}"
+
+// maxAgentMetricsSize is the maximum size of the metrics relayed from a
+// single agent
+const maxAgentMetricsSize = 32 * 1024 * 1024
+
+// processAgentMetricsRequest fetches the metrics of an agent over its
+// existing connection, so that they can be scraped from the principal without
+// network access to the agent's cluster.
+func (s *Server) processAgentMetricsRequest(w http.ResponseWriter, r *http.Request) {
+	agentName := r.PathValue("name")
+	logCtx := log().WithFields(logrus.Fields{
+		"function": "processAgentMetricsRequest",
+		"agent":    agentName,
+	})
+	if errs := validation.NameIsDNSLabel(agentName, false); len(errs) > 0 {
+		http.Error(w, "invalid agent name", http.StatusBadRequest)
+		return
+	}
+	if !s.isAgentConnected(agentName) {
+		http.Error(w, "agent is not connected", http.StatusServiceUnavailable)
+		return
+	}
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		logCtx.Errorf("Help! Queue disappeared")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	req := &event.MetricsRequest{
+		UUID:   uuid.NewString(),
+		Format: string(expfmt.Negotiate(r.Header)),
+	}
+	ev, err := s.events.NewMetricsRequestEvent(req)
+	if err != nil {
+		logCtx.WithError(err).Error("Could not create metrics request event")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	event.SetTTL(ev, requestTimeout)
+
+	transfer := s.fileTransferServer.Register(req.UUID, filetransfer.NewHTTPSink(w), filetransfer.WithMaxSize(maxAgentMetricsSize))
+	defer s.fileTransferServer.Remove(req.UUID)
+	q.Add(ev)
+
+	err = transfer.Wait(r.Context(), requestTimeout)
+	switch {
+	case err == nil:
+		logCtx.Debug("Relayed agent metrics")
+	case errors.Is(err, filetransfer.ErrTimeout):
+		logCtx.Warn("Timeout waiting for agent metrics")
+		transfer.Abort(http.StatusGatewayTimeout, "Timeout waiting for metrics from agent")
+	default:
+		logCtx.WithError(err).Warn("Could not relay agent metrics")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/filetransferapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type fakeUploadStream struct {
+	grpc.ServerStream
+	chunks []*filetransferapi.FileChunk
+}
+
+func (f *fakeUploadStream) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeUploadStream) Recv() (*filetransferapi.FileChunk, error) {
+	if len(f.chunks) == 0 {
+		return nil, io.EOF
+	}
+	c := f.chunks[0]
+	f.chunks = f.chunks[1:]
+	return c, nil
+}
+
+func (f *fakeUploadStream) SendAndClose(*filetransferapi.FileTransferResponse) error {
+	return nil
+}
+
+func Test_processAgentMetricsRequest(t *testing.T) {
+	newMux := func(s *Server) *http.ServeMux {
+		mux := http.NewServeMux()
+		mux.HandleFunc(agentMetricsPattern, s.processAgentMetricsRequest)
+		return mux
+	}
+
+	t.Run("Metrics are relayed from the agent", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+
+		r := httptest.NewRequest("GET", "/metrics/agents/agent", nil)
+		w := httptest.NewRecorder()
+		ch := make(chan interface{})
+		go func() {
+			newMux(s).ServeHTTP(w, r)
+			ch <- 1
+		}()
+
+		sendq := s.queues.SendQ("agent")
+		require.NotNil(t, sendq)
+		ev, shutdown := sendq.Get()
+		require.False(t, shutdown)
+		req, err := event.New(ev, event.TargetMetrics).MetricsRequest()
+		require.NoError(t, err)
+		assert.NotEmpty(t, req.Format)
+
+		err = s.fileTransferServer.Upload(&fakeUploadStream{chunks: []*filetransferapi.FileChunk{
+			{RequestUuid: req.UUID, ContentType: "text/plain; version=0.0.4", Data: []byte("agent_events_sent 1\n"), Eof: true},
+		}})
+		require.NoError(t, err)
+		<-ch
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+		assert.Equal(t, "agent_events_sent 1\n", w.Body.String())
+	})
+
+	t.Run("Agent not connected", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		r := httptest.NewRequest("GET", "/metrics/agents/agent", nil)
+		w := httptest.NewRecorder()
+		newMux(s).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("Invalid agent name", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		r := httptest.NewRequest("GET", "/metrics/agents/Agent_1", nil)
+		w := httptest.NewRecorder()
+		newMux(s).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
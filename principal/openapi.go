@@ -0,0 +1,237 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
+)
+
+// openAPIPath is the path the OpenAPI definition is served at on the healthz
+// port.
+const openAPIPath = "/openapi.json"
+
+// openAPIInfo is the metadata of the principal's OpenAPI definition. The
+// version is that of the HTTP surface, not of the principal.
+var openAPIInfo = resourceproxy.OpenAPIInfo{
+	Title: "argocd-agent principal",
+	Description: "HTTP endpoints of the argocd-agent principal. Operations tagged resource-proxy " +
+		"are served by the resource proxy and routed to the agent identified by the client's " +
+		"credentials. Operations tagged health are served on the healthz port.",
+	Version: "v1",
+}
+
+// healthTag is the tag of the operations served on the healthz port
+const healthTag = "health"
+
+// logQueryDocs are the query parameters of pod log requests
+var logQueryDocs = []resourceproxy.ParamDoc{
+	{Name: "container", Description: "Container to return logs for"},
+	{Name: "follow", Type: "boolean", Description: "Stream new log lines as they are written"},
+	{Name: "previous", Type: "boolean", Description: "Return logs of the previous instance of the container"},
+	{Name: "sinceSeconds", Type: "integer", Description: "Return only lines written in the given number of seconds"},
+	{Name: "sinceTime", Description: "Return only lines written after the given RFC3339 time"},
+	{Name: "tailLines", Type: "integer", Description: "Return only the given number of most recent lines"},
+	{Name: "timestamps", Type: "boolean", Description: "Prefix every line with its RFC3339 timestamp"},
+	{Name: "limitBytes", Type: "integer", Description: "Maximum number of bytes to return"},
+	{Name: "download", Type: "boolean", Description: "Return the static logs as file attachment"},
+}
+
+// resourceRouteDocs documents the operations of the resource route
+var resourceRouteDocs = []resourceproxy.OperationDoc{
+	{
+		Path:        "/api/{version}/namespaces/{namespace}/{resource}/{name}",
+		OperationID: "coreNamespacedResource",
+		Summary:     "Live namespaced resource of the core API group",
+		Responses:   []resourceproxy.ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/json"}}},
+	},
+	{
+		Path:        "/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}",
+		OperationID: "namespacedResource",
+		Summary:     "Live namespaced resource",
+		Responses:   []resourceproxy.ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/json"}}},
+	},
+	{
+		Path:        "/api/{version}/{resource}/{name}",
+		OperationID: "coreClusterResource",
+		Summary:     "Live cluster scoped resource of the core API group",
+		Responses:   []resourceproxy.ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/json"}}},
+	},
+	{
+		Path:        "/apis/{group}/{version}/{resource}/{name}",
+		OperationID: "clusterResource",
+		Summary:     "Live cluster scoped resource",
+		Responses:   []resourceproxy.ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/json"}}},
+	},
+	{
+		Path:        "/api/v1/namespaces/{namespace}/events",
+		Methods:     []string{http.MethodGet},
+		OperationID: "listEvents",
+		Summary:     "List the events in a namespace",
+		Query: []resourceproxy.ParamDoc{
+			{Name: "fieldSelector", Description: "Select events by field, e.g. involvedObject.name"},
+			{Name: "labelSelector", Description: "Select events by label"},
+			{Name: "limit", Type: "integer", Description: "Maximum number of events per page"},
+			{Name: "continue", Description: "Continue token of the page to return"},
+		},
+		Responses: []resourceproxy.ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/json"}}},
+	},
+	{
+		Path:        "/api/v1/namespaces/{namespace}/pods/{name}/log",
+		Methods:     []string{http.MethodGet},
+		OperationID: "podLogs",
+		Summary:     "Logs of a pod's container",
+		Description: "Logs are returned as plain text, or as Server-Sent Events if requested in the Accept " +
+			"header. Every event carries a log line and has the line's timestamp as id; the named events " +
+			"stats, eof and error end the stream. Static logs support byte ranges.",
+		Query: logQueryDocs,
+		Headers: []resourceproxy.ParamDoc{
+			{Name: "Accept", Description: "text/event-stream to receive Server-Sent Events"},
+			{Name: "Last-Event-ID", Description: "Resume an event stream after the line with the given timestamp"},
+			{Name: "Range", Description: "Byte range of static logs to return"},
+			{Name: "X-Request-ID", Description: "Correlation ID of the request, generated if missing"},
+		},
+		Responses: []resourceproxy.ResponseDoc{
+			{Status: http.StatusOK, ContentTypes: []string{"text/plain", "text/event-stream"}},
+			{Status: http.StatusPartialContent, Description: "Requested byte range of static logs", ContentTypes: []string{"text/plain"}},
+			{Status: http.StatusRequestedRangeNotSatisfiable, Description: "The requested byte range is invalid"},
+		},
+	},
+	{
+		Path:        "/api/v1/namespaces/{namespace}/pods/{name}/exec",
+		Methods:     []string{http.MethodGet},
+		OperationID: "podExec",
+		Summary:     "Interactive shell in a pod's container",
+		Description: "Upgrades the connection to a WebSocket, over which the terminal's input and output are exchanged. Only supported for managed agents.",
+		Query: []resourceproxy.ParamDoc{
+			{Name: "container", Description: "Container to execute the command in"},
+			{Name: "command", Description: "Command to execute, may be repeated for arguments"},
+		},
+		Responses: []resourceproxy.ResponseDoc{
+			{Status: http.StatusSwitchingProtocols, Description: "Connection upgraded to a WebSocket"},
+			{Status: http.StatusBadRequest, Description: "The agent does not support the web terminal"},
+		},
+	},
+}
+
+// versionRouteDocs documents the operations of the version route
+var versionRouteDocs = []resourceproxy.OperationDoc{
+	{
+		OperationID: "version",
+		Summary:     "Kubernetes version reported to Argo CD for the agent's cluster",
+		Responses:   []resourceproxy.ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/json"}}},
+	},
+}
+
+// supportBundleRouteDocs documents the operations of the support bundle route
+var supportBundleRouteDocs = []resourceproxy.OperationDoc{
+	{
+		OperationID: "supportBundle",
+		Summary:     "Logs of all pods of an application as tar.gz archive",
+		Query: []resourceproxy.ParamDoc{
+			{Name: "namespace", Description: "Namespace of the application on the agent"},
+			{Name: "previous", Type: "boolean", Description: "Include logs of previous containers, true by default"},
+			{Name: "limitBytes", Type: "integer", Description: "Maximum number of log bytes per container"},
+		},
+		Responses: []resourceproxy.ResponseDoc{
+			{Status: http.StatusOK, ContentTypes: []string{"application/gzip"}},
+			{Status: http.StatusBadRequest, Description: "Invalid query parameters"},
+		},
+	},
+}
+
+// proxyRoutes returns the routes of the features served through the resource
+// proxy.
+func (s *Server) proxyRoutes() []resourceproxy.Route {
+	return []resourceproxy.Route{
+		s.resourceRoute(),
+		s.versionRoute(),
+		s.supportBundleRoute(),
+	}
+}
+
+// openAPIDocument returns the OpenAPI definition of the principal's HTTP
+// endpoints. If the resource proxy is running, its registry is used, so that
+// routes added by plugins are included.
+func (s *Server) openAPIDocument() *resourceproxy.OpenAPI {
+	doc := resourceproxy.NewOpenAPI(openAPIInfo)
+	doc.Tags = []resourceproxy.OpenAPITag{
+		{Name: resourceproxy.ProxyTag, Description: "Requests routed to an agent by the resource proxy"},
+		{Name: healthTag, Description: "Health and readiness of the principal"},
+	}
+	routes := s.proxyRoutes()
+	if s.resourceProxy != nil {
+		routes = s.resourceProxy.Routes().Routes()
+	}
+	for _, route := range doc.AddRoutes(routes...) {
+		log().Debugf("Route %s cannot be expressed in the OpenAPI definition", route.Name)
+	}
+
+	unauthenticated := &[]map[string][]string{}
+	doc.AddOperation("/healthz", http.MethodGet, &resourceproxy.Operation{
+		OperationID: "healthz",
+		Summary:     "Liveness of the principal",
+		Tags:        []string{healthTag},
+		Security:    unauthenticated,
+		Responses: map[string]resourceproxy.Response{
+			"200": {Description: "The principal is healthy"},
+			"503": {Description: "The principal is unhealthy"},
+		},
+	})
+	doc.AddOperation("/readyz", http.MethodGet, &resourceproxy.Operation{
+		OperationID: "readyz",
+		Summary:     "Readiness of the principal and its dependencies",
+		Tags:        []string{healthTag},
+		Security:    unauthenticated,
+		Responses: map[string]resourceproxy.Response{
+			"200": {Description: "All checks passed", Content: map[string]resourceproxy.MediaType{"application/json": {}}},
+			"503": {Description: "At least one check failed", Content: map[string]resourceproxy.MediaType{"application/json": {}}},
+		},
+	})
+	doc.AddOperation(openAPIPath, http.MethodGet, &resourceproxy.Operation{
+		OperationID: "openapi",
+		Summary:     "This OpenAPI definition",
+		Tags:        []string{healthTag},
+		Security:    unauthenticated,
+		Responses: map[string]resourceproxy.Response{
+			"200": {Description: "OpenAPI definition", Content: map[string]resourceproxy.MediaType{"application/json": {}}},
+		},
+	})
+	return doc
+}
+
+// OpenAPIDefinition returns the OpenAPI definition of the principal's
+// built-in HTTP endpoints as indented JSON. It is used to generate the
+// definition shipped with the documentation.
+func OpenAPIDefinition() ([]byte, error) {
+	b, err := json.MarshalIndent((&Server{}).openAPIDocument(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// openAPIHandler serves the OpenAPI definition of the principal's HTTP
+// endpoints.
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.openAPIDocument()); err != nil {
+		log().WithError(err).Error("Could not write OpenAPI definition")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OpenAPIDefinition(t *testing.T) {
+	t.Run("Shipped definition is up to date", func(t *testing.T) {
+		def, err := OpenAPIDefinition()
+		require.NoError(t, err)
+		shipped, err := os.ReadFile("../docs/assets/principal-openapi.json")
+		require.NoError(t, err)
+		assert.Equal(t, string(shipped), string(def), "run 'make openapi' to update the shipped OpenAPI definition")
+	})
+
+	t.Run("Documented paths are matched by their routes", func(t *testing.T) {
+		param := regexp.MustCompile(`\{[^}]+\}`)
+		for _, route := range (&Server{}).proxyRoutes() {
+			re := regexp.MustCompile(route.Pattern)
+			for _, doc := range route.Docs {
+				if doc.Path == "" {
+					continue
+				}
+				path := param.ReplaceAllStringFunc(doc.Path, func(p string) string {
+					// versions must start with a v to be matched
+					if p == "{version}" {
+						return "v1"
+					}
+					return strings.Trim(p, "{}")
+				})
+				assert.Truef(t, re.MatchString(path), "path %s of route %s is not matched by %s", doc.Path, route.Name, route.Pattern)
+			}
+		}
+	})
+
+	t.Run("Served definition includes routes of the proxy", func(t *testing.T) {
+		s := &Server{}
+		rp, err := resourceproxy.New("127.0.0.1:0", resourceproxy.WithRoutes(append(s.proxyRoutes(), resourceproxy.Route{
+			Name:    "plugin",
+			Pattern: `^/plugin/(?P<id>[^\/]+)$`,
+			Methods: []string{"get"},
+			Handler: func(w http.ResponseWriter, r *http.Request, params resourceproxy.Params) {},
+		})...))
+		require.NoError(t, err)
+		s.resourceProxy = rp
+
+		w := httptest.NewRecorder()
+		s.openAPIHandler(w, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		doc := resourceproxy.OpenAPI{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, "3.1.0", doc.OpenAPI)
+		assert.Contains(t, doc.Paths, "/plugin/{id}")
+		assert.Contains(t, doc.Paths, "/api/v1/namespaces/{namespace}/pods/{name}/log")
+		assert.Contains(t, doc.Paths, "/version")
+		require.Contains(t, doc.Paths, "/healthz")
+		require.NotNil(t, doc.Paths["/healthz"]["get"].Security)
+		assert.Empty(t, *doc.Paths["/healthz"]["get"].Security)
+	})
+}
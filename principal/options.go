@@ -25,6 +25,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"net"
 	"os"
@@ -32,13 +33,26 @@ import (
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/mtls"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/internal/eventsink"
 	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
 	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/internal/version"
 	"github.com/argoproj-labs/argocd-agent/pkg/ha"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
+	logstream "github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
 	cacheutil "github.com/argoproj/argo-cd/v3/util/cache"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type ServerOptions struct {
@@ -52,6 +66,7 @@ type ServerOptions struct {
 	tlsCiphers    []uint16
 	tlsMinVersion uint16
 	tlsMaxVersion uint16
+	tlsCurves     []tls.CurveID
 	gracePeriod   time.Duration
 	namespaces    []string
 	signingKey    crypto.PrivateKey
@@ -65,15 +80,101 @@ type ServerOptions struct {
 	metricsEnabled         bool
 	metricsPort            int
 	requireClientCerts     bool
+	crlSources             []string
+	crlRefreshInterval     time.Duration
+	ocspEnabled            bool
+	ocspFailClosed         bool
+	clientCertIdentity     *mtls.MTLSAuthentication
 	rootCa                 *x509.CertPool
 	clientCertSubjectMatch bool
 	redisAddress           string
 	redisPassword          string
 	redisCompressionType   cacheutil.RedisCompressionType
 	healthzPort            int
-	redisProxyDisabled     bool
-	informerSyncTimeout    time.Duration
-	maxGRPCMessageSize     int
+	// readinessMinAgents is the number of agents that must be connected
+	// for the principal to be ready
+	readinessMinAgents  int
+	redisProxyDisabled  bool
+	informerSyncTimeout time.Duration
+	maxGRPCMessageSize  int
+	logDownloadMaxSize  int
+	fileTransferMaxSize int
+	// logStaticMaxSize limits static log responses other than downloads,
+	// and logStaticStreamThreshold is how much of them is buffered before
+	// the response is streamed
+	logStaticMaxSize         int
+	logStaticStreamThreshold int
+	// logSpillDir is the directory of the spill buffers of follow log
+	// streams, disabled if empty. logSpillMaxSize is their capacity, and
+	// logSpillMaxTotalSize the capacity of all of them together.
+	logSpillDir          string
+	logSpillMaxSize      int
+	logSpillMaxTotalSize int
+	// addressFamily selects the IP address families the gRPC listener
+	// accepts connections of
+	addressFamily grpcutil.AddressFamily
+	// enableQUIC makes the gRPC server accept agent connections over QUIC
+	// on the UDP port with the number of its TCP port
+	enableQUIC bool
+	// keepAliveTime and keepAliveTimeout are the interval in which the
+	// principal pings idle agent connections, and the time it waits for the
+	// answer. 0 means gRPC's default.
+	keepAliveTime    time.Duration
+	keepAliveTimeout time.Duration
+	// initialWindowSize and initialConnWindowSize are the HTTP/2 flow
+	// control windows of streams and connections. 0 means gRPC's dynamic
+	// default.
+	initialWindowSize     int32
+	initialConnWindowSize int32
+	// maxConcurrentStreams limits the number of concurrent streams per agent
+	// connection. 0 means no limit.
+	maxConcurrentStreams uint32
+	// grpcReflection enables the gRPC server reflection service
+	grpcReflection bool
+	// versionSkewPolicy determines which agent versions are admitted
+	versionSkewPolicy version.SkewPolicy
+	// conflictStrategy decides whose spec prevails when an Application of an
+	// autonomous agent was also modified on the principal
+	conflictStrategy manager.ConflictStrategy
+	// serverSideApply makes the principal update Applications of autonomous
+	// agents with server-side apply
+	serverSideApply bool
+	// resyncBatchSize is the number of resources sent per batch during an
+	// initial sync with an agent
+	resyncBatchSize int
+	// resyncBookmarkMaxAge is how long bookmarks are used for incremental
+	// resyncs requested by autonomous agents. Zero disables incremental
+	// resyncs.
+	resyncBookmarkMaxAge time.Duration
+	// resyncInterval is the interval of periodic resyncs with each connected
+	// agent. A value of 0 disables periodic resyncs.
+	resyncInterval time.Duration
+	// resyncJitter spreads resyncs of different agents over this duration
+	resyncJitter time.Duration
+	// connectionProbeInterval is the interval at which the connection
+	// quality of agents is measured. A value of 0 disables probing.
+	connectionProbeInterval time.Duration
+	// eventSink receives lifecycle events, such as agents connecting
+	eventSink eventsink.Sink
+	// eventSinkRetryTimeout is the time after which delivery of an event to
+	// the sink is given up. A value of 0 retries until the event has been
+	// delivered.
+	eventSinkRetryTimeout time.Duration
+	// connectivityAnnotations enables maintaining the connectivity of agents
+	// as annotations on their cluster secrets.
+	connectivityAnnotations bool
+	// agentStaleAfter is the time after which a connected agent that did
+	// not send any data is reported as stale.
+	agentStaleAfter time.Duration
+	// agentStatusInterval is the interval at which AgentStatus resources
+	// are updated. A value of 0 disables AgentStatus reporting.
+	agentStatusInterval time.Duration
+	// connectionHistoryRetention is how long the connection history of
+	// agents is kept. A value of 0 disables connection history.
+	connectionHistoryRetention time.Duration
+	// slowEventHandlerThreshold is the time after which the handler of an
+	// event received from an agent is logged as slow. 0 disables it.
+	slowEventHandlerThreshold time.Duration
 
 	// insecurePlaintext disables TLS on the gRPC server. Use when Istio sidecar
 	// handles mTLS termination.
@@ -94,8 +195,77 @@ type ServerOptions struct {
 	labelSelector string
 
 	selfAgentRegistrationEnabled bool
+	// registrationControllerEnabled enables provisioning agents from
+	// AgentRegistration resources
+	registrationControllerEnabled bool
+	// registrationPrincipalAddress is the principal address written to
+	// bootstrap secrets of AgentRegistration resources
+	registrationPrincipalAddress string
 	resourceProxyAddress         string
 	clientCertSecretName         string
+
+	// admissionAddress is the address the validating webhook for
+	// Applications listens on. Empty disables the webhook.
+	admissionAddress  string
+	admissionCertPath string
+	admissionKeyPath  string
+	// admissionMode is warn or deny
+	admissionMode string
+	// admissionDisconnectThreshold is how long an agent may be disconnected
+	// before Applications targeting it are warned about or refused
+	admissionDisconnectThreshold time.Duration
+
+	// orphanCheckInterval is how often to look for Applications whose agent
+	// no longer exists. Zero disables the check.
+	orphanCheckInterval time.Duration
+	// orphanPolicy is what to do with orphaned Applications
+	orphanPolicy string
+	// orphanDryRun only reports what would be done to orphaned Applications
+	orphanDryRun bool
+
+	// deletionTimeout is how long to wait for an agent to confirm the
+	// deletion of an Application, before removing it from the principal
+	// anyway. Zero waits forever.
+	deletionTimeout time.Duration
+
+	// resourceProxyAccessLog receives the access log of the resource proxy,
+	// if set
+	resourceProxyAccessLog       io.Writer
+	resourceProxyAccessLogFormat resourceproxy.AccessLogFormat
+	// resourceProxySocketPath is the path of a unix domain socket the
+	// resource proxy listens on in addition to TCP, if not empty
+	resourceProxySocketPath string
+	resourceProxySocketMode os.FileMode
+	// resourceProxyHedgeDelay is the time after which a GET request for a
+	// resource still unanswered by the agent is sent once more, if not zero
+	resourceProxyHedgeDelay time.Duration
+
+	// agentConfigurationsEnabled enables pushing AgentConfiguration
+	// resources to agents
+	agentConfigurationsEnabled bool
+	// agentOperationsEnabled enables running AgentOperation resources on
+	// agents
+	agentOperationsEnabled bool
+	// agentOperationsBundleDir is the directory support bundles collected
+	// by AgentOperations are written to
+	agentOperationsBundleDir string
+
+	// joinTokensEnabled allows agents to exchange a join token for a client
+	// certificate
+	joinTokensEnabled bool
+
+	// shutdownReconnectDelay is the delay suggested to agents and clients
+	// for reconnecting when the principal shuts down
+	shutdownReconnectDelay time.Duration
+
+	// handoffSocket is the path of the Unix domain socket used to hand over
+	// live sessions between principal instances
+	handoffSocket string
+
+	// adminPort is the port of the localhost-only admin server, which
+	// serves the debug shell to agents. Zero disables the admin server.
+	adminPort int
+
 	// Redis TLS configuration
 	redisTLSEnabled             bool
 	redisProxyServerTLSCert     *x509.Certificate
@@ -108,6 +278,28 @@ type ServerOptions struct {
 
 	// haOptions contains HA configuration options
 	haOptions []ha.Option
+
+	// unaryInterceptors and streamInterceptors are additional interceptors
+	// of the gRPC server, run after the built-in ones
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+
+	// metricsRegistry is the registry to register metrics with, instead of
+	// the default registry
+	metricsRegistry prometheus.Registerer
+	// metricsAgentLabels are glob patterns of the agents that have metrics
+	// labeled with their own name
+	metricsAgentLabels []string
+	// metricsAgentLabelsTop is the number of most active agents that have
+	// metrics labeled with their own name
+	metricsAgentLabelsTop int
+	// logStreamOptions are additional options of the log stream server
+	logStreamOptions []logstream.Option
+	// authProviders are auth methods registered in addition to those passed
+	// with WithAuthMethods, by name
+	authProviders map[string]AuthProvider
+	// eventRecorder records the events exchanged with agents, if set
+	eventRecorder *eventrecord.Recorder
 }
 
 type ServerOption func(o *Server) error
@@ -115,15 +307,32 @@ type ServerOption func(o *Server) error
 // defaultOptions returns a set of default options for the server
 func defaultOptions() *ServerOptions {
 	return &ServerOptions{
-		port:                 443,
-		address:              "",
-		tlsMinVersion:        tls.VersionTLS13,
-		unauthMethods:        make(map[string]bool),
-		eventProcessors:      10,
-		rootCa:               x509.NewCertPool(),
-		informerSyncTimeout:  60 * time.Second,
-		maxGRPCMessageSize:   grpcutil.DefaultGRPCMaxMessageSize,
-		resourceProxyAddress: "argocd-agent-resource-proxy:9090",
+		port:                     443,
+		address:                  "",
+		tlsMinVersion:            tls.VersionTLS13,
+		unauthMethods:            make(map[string]bool),
+		eventProcessors:          10,
+		rootCa:                   x509.NewCertPool(),
+		informerSyncTimeout:      60 * time.Second,
+		maxGRPCMessageSize:       grpcutil.DefaultGRPCMaxMessageSize,
+		logDownloadMaxSize:       logstream.DefaultMaxDownloadSize,
+		logStaticStreamThreshold: logstream.DefaultStaticStreamThreshold,
+		logSpillMaxSize:          logstream.DefaultSpillSize,
+		logSpillMaxTotalSize:     logstream.DefaultSpillTotalSize,
+		fileTransferMaxSize:      int(filetransfer.DefaultMaxFileSize),
+		connectionProbeInterval:  defaultConnectionProbeInterval,
+		resourceProxyAddress:     "argocd-agent-resource-proxy:9090",
+		shutdownReconnectDelay:   defaultShutdownReconnectDelay,
+
+		admissionMode:                AdmissionModeWarn,
+		admissionDisconnectThreshold: defaultAdmissionDisconnectThreshold,
+		orphanPolicy:                 OrphanPolicyOrphan,
+		connectionHistoryRetention:   defaultConnectionHistoryRetention,
+		slowEventHandlerThreshold:    defaultSlowEventHandlerThreshold,
+		versionSkewPolicy:            version.DefaultSkewPolicy(),
+		conflictStrategy:             manager.ConflictSpokeWins,
+		resyncBatchSize:              resync.DefaultBatchSize,
+		resyncBookmarkMaxAge:         resync.DefaultBookmarkMaxAge,
 	}
 }
 
@@ -223,6 +432,30 @@ func WithListenerAddress(host string) ServerOption {
 	}
 }
 
+// WithAddressFamily sets the IP address families the gRPC listener accepts
+// connections of. With AddressFamilyDual, which is the default, a listener on
+// an unspecified address accepts both IPv4 and IPv6 connections.
+func WithAddressFamily(family grpcutil.AddressFamily) ServerOption {
+	return func(o *Server) error {
+		f, err := grpcutil.ParseAddressFamily(string(family))
+		if err != nil {
+			return err
+		}
+		o.options.addressFamily = f
+		return nil
+	}
+}
+
+// WithQUIC makes the gRPC server accept agent connections over QUIC in
+// addition to TCP. QUIC connections are received on the UDP port with the same
+// number as the TCP port. This is experimental and requires TLS.
+func WithQUIC(enableQUIC bool) ServerOption {
+	return func(o *Server) error {
+		o.options.enableQUIC = enableQUIC
+		return nil
+	}
+}
+
 // WithClientCertSubjectMatch sets whether the subject of a client certificate
 // presented by the agent must match the agent's name. Has no effect if client
 // certificates are not required.
@@ -274,6 +507,71 @@ func WithRequireClientCerts(require bool) ServerOption {
 	}
 }
 
+// WithClientCertIdentity configures how the agent name is taken from client
+// certificates, for matching client certificates against agent names and for
+// authenticating resource proxy requests by client certificate. The source
+// is one of cn, subject or uri. If regex is not empty, its first capture
+// group is the agent name; it is required for the subject and uri sources.
+// By default, the common name is the agent name.
+func WithClientCertIdentity(source mtls.IdentitySource, regex string) ServerOption {
+	return func(o *Server) error {
+		var re *regexp.Regexp
+		if regex != "" {
+			var err error
+			re, err = regexp.Compile(regex)
+			if err != nil {
+				return fmt.Errorf("invalid client certificate identity regex: %w", err)
+			}
+		}
+		switch source {
+		case mtls.IdentitySourceCommonName:
+		case mtls.IdentitySourceSubject, mtls.IdentitySourceURI:
+			if re == nil {
+				return fmt.Errorf("client certificate identity source %s requires a regex", source)
+			}
+		default:
+			return fmt.Errorf("unknown client certificate identity source: %s", source)
+		}
+		o.options.clientCertIdentity = mtls.NewMTLSAuthentication(re, source)
+		return nil
+	}
+}
+
+// WithCRLs makes the server reject agent client certificates listed in any of
+// the given certificate revocation lists, on both the gRPC listener and the
+// resource proxy. Each source is a file path or an HTTP(S) URL, and is
+// reloaded every refreshInterval.
+func WithCRLs(sources []string, refreshInterval time.Duration) ServerOption {
+	return func(o *Server) error {
+		o.options.crlSources = sources
+		o.options.crlRefreshInterval = refreshInterval
+		return nil
+	}
+}
+
+// WithOCSP makes the server check agent client certificates with the OCSP
+// responder named in the certificate. If failClosed is true, certificates
+// whose status cannot be determined are rejected; otherwise they are
+// accepted.
+func WithOCSP(enabled bool, failClosed bool) ServerOption {
+	return func(o *Server) error {
+		o.options.ocspEnabled = enabled
+		o.options.ocspFailClosed = failClosed
+		return nil
+	}
+}
+
+// WithJoinTokens allows agents to exchange a one-time join token for a client
+// certificate issued by the principal's CA. When client certificates are
+// required, the TLS handshake will accept clients without a certificate, so
+// they can join, but all other requests still require one.
+func WithJoinTokens(enabled bool) ServerOption {
+	return func(o *Server) error {
+		o.options.joinTokensEnabled = enabled
+		return nil
+	}
+}
+
 // WithTLSKeyPair configures the TLS certificate and private key to be used by
 // the server. The key must not be passphrase protected.
 func WithTLSKeyPair(cert *x509.Certificate, key *rsa.PrivateKey) ServerOption {
@@ -367,6 +665,20 @@ func WithTLSCipherSuites(cipherSuites []string) ServerOption {
 	}
 }
 
+// WithTLSCurvePreferences configures the key exchange mechanisms the server
+// accepts, in order of preference. If an unknown curve is specified, an error
+// is returned.
+func WithTLSCurvePreferences(curves []string) ServerOption {
+	return func(o *Server) error {
+		ids, err := tlsutil.ParseCurvePreferences(curves)
+		if err != nil {
+			return err
+		}
+		o.options.tlsCurves = ids
+		return nil
+	}
+}
+
 // WithMinimumTLSVersion configures the minimum TLS version to be accepted by
 // the server.
 func WithMinimumTLSVersion(version string) ServerOption {
@@ -394,8 +706,9 @@ func WithMaximumTLSVersion(version string) ServerOption {
 }
 
 // WithShutDownGracePeriod configures how long the server should wait for
-// client connections to close during shutdown. If d is 0, the server will
-// not use a grace period for shutdown but instead close immediately.
+// requests in flight and client connections to finish during shutdown. If d
+// is 0, the server will not use a grace period for shutdown but instead close
+// immediately.
 func WithShutDownGracePeriod(d time.Duration) ServerOption {
 	return func(o *Server) error {
 		o.options.gracePeriod = d
@@ -403,6 +716,29 @@ func WithShutDownGracePeriod(d time.Duration) ServerOption {
 	}
 }
 
+// WithShutdownReconnectDelay configures the delay the server suggests to
+// agents and HTTP clients for reconnecting when it shuts down.
+func WithShutdownReconnectDelay(d time.Duration) ServerOption {
+	return func(o *Server) error {
+		if d < 0 {
+			return fmt.Errorf("shutdown reconnect delay must not be negative")
+		}
+		o.options.shutdownReconnectDelay = d
+		return nil
+	}
+}
+
+// WithHandoffSocket enables the handoff of live sessions between principal
+// instances over the Unix domain socket at path. On startup, the server takes
+// over the sessions of the instance listening on path, if any, and then
+// listens on path to hand its own sessions over to the next instance.
+func WithHandoffSocket(path string) ServerOption {
+	return func(o *Server) error {
+		o.options.handoffSocket = path
+		return nil
+	}
+}
+
 // WithNamespaces sets an
 func WithNamespaces(namespaces ...string) ServerOption {
 	return func(o *Server) error {
@@ -444,6 +780,78 @@ func WithMetricsPort(port int) ServerOption {
 	}
 }
 
+// AuthProvider is an auth method of agents, for programs embedding the
+// principal which implement their own.
+type AuthProvider interface {
+	// Init initializes the auth method when it is registered
+	Init() error
+	// Authenticate authenticates an agent with the credentials it sent, and
+	// returns the agent's name
+	Authenticate(ctx context.Context, credentials map[string]string) (string, error)
+}
+
+// authProvider adapts an AuthProvider to auth.Method
+type authProvider struct {
+	AuthProvider
+}
+
+func (p authProvider) Authenticate(ctx context.Context, credentials auth.Credentials) (string, error) {
+	return p.AuthProvider.Authenticate(ctx, credentials)
+}
+
+// WithAuthProvider registers an auth method for agents under the given name.
+// Agents select it with the same name, e.g. using client.WithAuth.
+func WithAuthProvider(name string, provider AuthProvider) ServerOption {
+	return func(o *Server) error {
+		if name == "" {
+			return fmt.Errorf("auth provider needs a name")
+		}
+		if o.options.authProviders == nil {
+			o.options.authProviders = make(map[string]AuthProvider)
+		}
+		if _, ok := o.options.authProviders[name]; ok {
+			return fmt.Errorf("auth provider %s already registered", name)
+		}
+		o.options.authProviders[name] = provider
+		return nil
+	}
+}
+
+// WithKubeClient configures the principal to access the Kubernetes API with
+// the given REST configuration, for programs embedding the principal. It
+// takes precedence over the client passed to NewServer.
+func WithKubeClient(config *rest.Config) ServerOption {
+	return func(o *Server) error {
+		kubeClient, err := kube.NewKubernetesClientForConfig(o.ctx, config, o.namespace)
+		if err != nil {
+			return fmt.Errorf("could not create Kubernetes client: %w", err)
+		}
+		o.kubeClient = kubeClient
+		return nil
+	}
+}
+
+// WithMetricsRegistry makes the principal register its metrics with reg
+// instead of the default registry. Metrics are collected whenever a registry
+// is configured, even if the metrics server is not enabled, so programs
+// embedding the principal can serve them on their own.
+func WithMetricsRegistry(reg prometheus.Registerer) ServerOption {
+	return func(o *Server) error {
+		o.options.metricsRegistry = reg
+		return nil
+	}
+}
+
+// WithLogStreamOptions passes options to the server streaming container logs
+// from agents. They are applied after the options derived from other
+// settings, e.g. WithLogDownloadMaxSize.
+func WithLogStreamOptions(opts ...logstream.Option) ServerOption {
+	return func(o *Server) error {
+		o.options.logStreamOptions = append(o.options.logStreamOptions, opts...)
+		return nil
+	}
+}
+
 func WithAuthMethods(am *auth.Methods) ServerOption {
 	return func(o *Server) error {
 		o.authMethods = am
@@ -503,6 +911,116 @@ func WithResourceProxyTLS(tlsConfig *tls.Config) ServerOption {
 	}
 }
 
+// WithResourceProxyUnixSocket makes the resource proxy listen on the unix
+// domain socket at path in addition to its TCP listener, with mode as the
+// permissions of the socket file. Requests on the socket are served without
+// TLS and must authenticate with a bearer token. An empty path disables the
+// socket.
+func WithResourceProxyUnixSocket(path string, mode os.FileMode) ServerOption {
+	return func(o *Server) error {
+		if mode&^os.ModePerm != 0 {
+			return fmt.Errorf("invalid resource proxy socket mode %o", mode)
+		}
+		o.options.resourceProxySocketPath = path
+		o.options.resourceProxySocketMode = mode
+		return nil
+	}
+}
+
+// WithResourceProxyAccessLog writes an access log of all requests to the
+// resource proxy to dest, which is either stdout, stderr or the path of a file
+// to append to. format is json or combined. An empty dest disables the access
+// log.
+func WithResourceProxyAccessLog(dest string, format string) ServerOption {
+	return func(o *Server) error {
+		if dest == "" {
+			return nil
+		}
+		f, err := resourceproxy.ParseAccessLogFormat(format)
+		if err != nil {
+			return err
+		}
+		switch dest {
+		case "stdout":
+			o.options.resourceProxyAccessLog = os.Stdout
+		case "stderr":
+			o.options.resourceProxyAccessLog = os.Stderr
+		default:
+			out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				return fmt.Errorf("could not open resource proxy access log: %w", err)
+			}
+			o.options.resourceProxyAccessLog = out
+		}
+		o.options.resourceProxyAccessLogFormat = f
+		return nil
+	}
+}
+
+// WithAdmissionWebhook serves a validating webhook for Applications on the
+// given address, using the TLS certificate and key from the given files.
+func WithAdmissionWebhook(address, certPath, keyPath string) ServerOption {
+	return func(o *Server) error {
+		if address != "" && (certPath == "" || keyPath == "") {
+			return fmt.Errorf("admission webhook requires a TLS certificate and key")
+		}
+		o.options.admissionAddress = address
+		o.options.admissionCertPath = certPath
+		o.options.admissionKeyPath = keyPath
+		return nil
+	}
+}
+
+// WithAdmissionPolicy sets whether the admission webhook warns about or
+// denies Applications targeting agents that have never connected or have
+// been disconnected for longer than threshold.
+func WithAdmissionPolicy(mode string, threshold time.Duration) ServerOption {
+	return func(o *Server) error {
+		if mode != AdmissionModeWarn && mode != AdmissionModeDeny {
+			return fmt.Errorf("invalid admission webhook mode %q, must be one of: %s, %s", mode, AdmissionModeWarn, AdmissionModeDeny)
+		}
+		if threshold < 0 {
+			return fmt.Errorf("admission disconnect threshold must not be negative")
+		}
+		o.options.admissionMode = mode
+		o.options.admissionDisconnectThreshold = threshold
+		return nil
+	}
+}
+
+// WithOrphanDetection looks for Applications whose agent no longer exists
+// every interval, and applies policy to them. With dryRun, the actions are
+// only logged. An interval of zero disables the detection.
+func WithOrphanDetection(interval time.Duration, policy string, dryRun bool) ServerOption {
+	return func(o *Server) error {
+		switch policy {
+		case OrphanPolicyOrphan, OrphanPolicyDelete, OrphanPolicyCascadeDelete:
+		default:
+			return fmt.Errorf("invalid orphan policy %q, must be one of: %s, %s, %s", policy, OrphanPolicyOrphan, OrphanPolicyDelete, OrphanPolicyCascadeDelete)
+		}
+		if interval < 0 {
+			return fmt.Errorf("orphan check interval must not be negative")
+		}
+		o.options.orphanCheckInterval = interval
+		o.options.orphanPolicy = policy
+		o.options.orphanDryRun = dryRun
+		return nil
+	}
+}
+
+// WithDeletionTimeout removes Applications of managed agents from the
+// principal if their agent did not confirm the deletion within timeout. A
+// timeout of zero waits for the agent forever.
+func WithDeletionTimeout(timeout time.Duration) ServerOption {
+	return func(o *Server) error {
+		if timeout < 0 {
+			return fmt.Errorf("deletion timeout must not be negative")
+		}
+		o.options.deletionTimeout = timeout
+		return nil
+	}
+}
+
 func WithKeepAliveMinimumInterval(interval time.Duration) ServerOption {
 	return func(o *Server) error {
 		o.keepAliveMinimumInterval = interval
@@ -547,6 +1065,308 @@ func WithMaxGRPCMessageSize(size int) ServerOption {
 	}
 }
 
+// WithKeepAliveTime sets the time after which the principal pings an agent
+// connection that has seen no activity, so that NAT gateways and firewalls
+// don't drop idle connections. 0 keeps gRPC's default of two hours.
+func WithKeepAliveTime(interval time.Duration) ServerOption {
+	return func(o *Server) error {
+		if interval < 0 {
+			return fmt.Errorf("keepalive time must not be negative")
+		}
+		o.options.keepAliveTime = interval
+		return nil
+	}
+}
+
+// WithKeepAliveTimeout sets the time the principal waits for an agent to
+// answer a keepalive ping before it closes the connection. 0 keeps gRPC's
+// default.
+func WithKeepAliveTimeout(timeout time.Duration) ServerOption {
+	return func(o *Server) error {
+		if timeout < 0 {
+			return fmt.Errorf("keepalive timeout must not be negative")
+		}
+		o.options.keepAliveTimeout = timeout
+		return nil
+	}
+}
+
+// WithInitialWindowSize sets the initial HTTP/2 flow control window of each
+// stream in bytes. 0 keeps gRPC's dynamic window.
+func WithInitialWindowSize(size int) ServerOption {
+	return func(o *Server) error {
+		if err := grpcutil.ValidateWindowSize(size); err != nil {
+			return fmt.Errorf("invalid initial window size: %w", err)
+		}
+		o.options.initialWindowSize = int32(size)
+		return nil
+	}
+}
+
+// WithInitialConnWindowSize sets the initial HTTP/2 flow control window of
+// each agent connection in bytes. 0 keeps gRPC's dynamic window.
+func WithInitialConnWindowSize(size int) ServerOption {
+	return func(o *Server) error {
+		if err := grpcutil.ValidateWindowSize(size); err != nil {
+			return fmt.Errorf("invalid initial connection window size: %w", err)
+		}
+		o.options.initialConnWindowSize = int32(size)
+		return nil
+	}
+}
+
+// WithMaxConcurrentStreams limits the number of concurrent streams an agent
+// may open on its connection. 0 means no limit.
+func WithMaxConcurrentStreams(streams int) ServerOption {
+	return func(o *Server) error {
+		if streams < 0 || streams > math.MaxUint32 {
+			return fmt.Errorf("max concurrent streams must be between 0 and %d", uint32(math.MaxUint32))
+		}
+		o.options.maxConcurrentStreams = uint32(streams)
+		return nil
+	}
+}
+
+// WithGRPCReflection enables the gRPC server reflection service, so that
+// tools like grpcurl can list and describe the principal's services. The
+// reflection service does not require authentication.
+func WithGRPCReflection(enabled bool) ServerOption {
+	return func(o *Server) error {
+		o.options.grpcReflection = enabled
+		return nil
+	}
+}
+
+// WithVersionSkewPolicy sets the policy for agents whose version differs
+// from the principal's. By default, only agents of exactly the principal's
+// version are admitted.
+func WithVersionSkewPolicy(mode string, maxMinorSkew int) ServerOption {
+	return func(o *Server) error {
+		skewMode, err := version.ParseSkewMode(mode)
+		if err != nil {
+			return err
+		}
+		if maxMinorSkew < 0 {
+			return fmt.Errorf("maximum minor version skew must not be negative")
+		}
+		o.options.versionSkewPolicy = version.SkewPolicy{Mode: skewMode, MaxMinorSkew: maxMinorSkew}
+		return nil
+	}
+}
+
+// WithUnaryInterceptors adds interceptors for unary calls to the gRPC
+// server, e.g. for custom auth, auditing or tracing. They run in the order
+// given, after the built-in interceptors, so the agent the request is from is
+// known by then.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(o *Server) error {
+		o.options.unaryInterceptors = append(o.options.unaryInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithStreamInterceptors adds interceptors for streams to the gRPC server.
+// They run in the order given, after the built-in interceptors.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) ServerOption {
+	return func(o *Server) error {
+		o.options.streamInterceptors = append(o.options.streamInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithLogDownloadMaxSize configures the maximum number of bytes sent to the
+// client when pod logs are downloaded as a file. A size of 0 disables the
+// limit.
+func WithLogDownloadMaxSize(size int) ServerOption {
+	return func(o *Server) error {
+		if size < 0 {
+			return fmt.Errorf("log download max size must not be negative")
+		}
+		o.options.logDownloadMaxSize = size
+		return nil
+	}
+}
+
+// WithConnectionProbeInterval configures the interval at which the principal
+// measures round trip time and clock skew of connected agents. An interval of
+// 0 disables the measurement.
+func WithConnectionProbeInterval(interval time.Duration) ServerOption {
+	return func(o *Server) error {
+		if interval < 0 {
+			return fmt.Errorf("connection probe interval must not be negative")
+		}
+		o.options.connectionProbeInterval = interval
+		return nil
+	}
+}
+
+// WithAdminPort enables the admin server on the given port. It only listens
+// on localhost and is reached by port-forwarding to the principal's pod.
+func WithAdminPort(port int) ServerOption {
+	return func(o *Server) error {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port: %d", port)
+		}
+		o.options.adminPort = port
+		return nil
+	}
+}
+
+// WithLogStaticMaxSize configures the maximum number of bytes sent to the
+// client in response to a request for static logs, other than a download.
+// Larger logs are refused with 413 Content Too Large if they exceed the limit
+// before the response is started, and truncated otherwise. A size of 0
+// disables the limit.
+func WithLogStaticMaxSize(size int) ServerOption {
+	return func(o *Server) error {
+		if size < 0 {
+			return fmt.Errorf("static log max size must not be negative")
+		}
+		o.options.logStaticMaxSize = size
+		return nil
+	}
+}
+
+// WithLogStaticStreamThreshold configures how many bytes of static logs are
+// buffered before the response to the client is started. Smaller logs are
+// sent at once, larger logs are streamed as they arrive, which bounds the
+// memory used per request. A size of 0 streams all static logs.
+func WithLogStaticStreamThreshold(size int) ServerOption {
+	return func(o *Server) error {
+		if size < 0 {
+			return fmt.Errorf("static log stream threshold must not be negative")
+		}
+		o.options.logStaticStreamThreshold = size
+		return nil
+	}
+}
+
+// WithLogSpillBuffer makes follow log streams buffer log data for clients
+// reading slowly in files in dir, up to size bytes per stream, so that the
+// agent's stream does not wait for them. Once a client falls behind by more
+// than size bytes, the oldest buffered lines are dropped. An empty dir
+// disables spilling.
+func WithLogSpillBuffer(dir string, size int) ServerOption {
+	return func(o *Server) error {
+		if size <= 0 {
+			return fmt.Errorf("log spill buffer size must be positive")
+		}
+		if dir != "" {
+			if fi, err := os.Stat(dir); err != nil {
+				return fmt.Errorf("invalid log spill directory: %w", err)
+			} else if !fi.IsDir() {
+				return fmt.Errorf("invalid log spill directory: %s is not a directory", dir)
+			}
+		}
+		o.options.logSpillDir = dir
+		o.options.logSpillMaxSize = size
+		return nil
+	}
+}
+
+// WithLogSpillMaxTotalSize limits the spill buffers of all follow log streams
+// together to size bytes. Follow streams that would exceed it are written to
+// their clients directly. A size of 0 disables the limit.
+func WithLogSpillMaxTotalSize(size int) ServerOption {
+	return func(o *Server) error {
+		if size < 0 {
+			return fmt.Errorf("log spill total size must not be negative")
+		}
+		o.options.logSpillMaxTotalSize = size
+		return nil
+	}
+}
+
+// WithFileTransferMaxSize configures the maximum size of a single file, such
+// as a support bundle, that agents may transfer to the principal. A size of 0
+// disables the limit.
+func WithFileTransferMaxSize(size int) ServerOption {
+	return func(o *Server) error {
+		if size < 0 {
+			return fmt.Errorf("file transfer max size must not be negative")
+		}
+		o.options.fileTransferMaxSize = size
+		return nil
+	}
+}
+
+// WithEventSink configures a sink to which the principal publishes lifecycle
+// events, such as agents connecting and disconnecting or finished syncs.
+func WithEventSink(sink eventsink.Sink) ServerOption {
+	return func(o *Server) error {
+		o.options.eventSink = sink
+		return nil
+	}
+}
+
+// WithEventSinkRetryTimeout sets the time after which delivery of an event to
+// the event sink is given up. A value of 0, the default, retries delivery
+// until it succeeds or the principal shuts down.
+func WithEventSinkRetryTimeout(d time.Duration) ServerOption {
+	return func(o *Server) error {
+		if d < 0 {
+			return fmt.Errorf("event sink retry timeout must not be negative")
+		}
+		o.options.eventSinkRetryTimeout = d
+		return nil
+	}
+}
+
+// WithConnectivityAnnotations makes the principal maintain annotations on the
+// cluster secret of each agent, which reflect whether the agent is connected
+// and whether it is stale, i.e. has not sent any data for longer than
+// staleAfter. A staleAfter of 0 disables staleness detection.
+func WithConnectivityAnnotations(enabled bool, staleAfter time.Duration) ServerOption {
+	return func(o *Server) error {
+		if staleAfter < 0 {
+			return fmt.Errorf("agent stale threshold must not be negative")
+		}
+		o.options.connectivityAnnotations = enabled
+		o.options.agentStaleAfter = staleAfter
+		return nil
+	}
+}
+
+// WithAgentStatusInterval enables maintaining an AgentStatus resource for
+// every agent, which is updated at the given interval and whenever an agent
+// connects or disconnects. A value of 0 disables AgentStatus resources.
+func WithAgentStatusInterval(d time.Duration) ServerOption {
+	return func(o *Server) error {
+		if d < 0 {
+			return fmt.Errorf("agent status interval must not be negative")
+		}
+		o.options.agentStatusInterval = d
+		return nil
+	}
+}
+
+// WithConnectionHistoryRetention sets how long the history of agents'
+// connects and disconnects is kept. A value of 0 disables connection
+// history.
+func WithConnectionHistoryRetention(d time.Duration) ServerOption {
+	return func(o *Server) error {
+		if d < 0 {
+			return fmt.Errorf("connection history retention must not be negative")
+		}
+		o.options.connectionHistoryRetention = d
+		return nil
+	}
+}
+
+// WithSlowEventHandlerThreshold sets the time after which the handler of an
+// event received from an agent is logged as slow, because it blocks the
+// processing of the agent's events following it. A threshold of 0 disables
+// logging slow handlers.
+func WithSlowEventHandlerThreshold(threshold time.Duration) ServerOption {
+	return func(o *Server) error {
+		if threshold < 0 {
+			return fmt.Errorf("slow event handler threshold must not be negative")
+		}
+		o.options.slowEventHandlerThreshold = threshold
+		return nil
+	}
+}
+
 // WithInsecurePlaintext disables TLS on the gRPC server. This should only be
 // used when running behind a service mesh (e.g., Istio) that handles mTLS
 // termination at the sidecar level.
@@ -613,6 +1433,38 @@ func WithAgentRegistration(enabled bool) ServerOption {
 	}
 }
 
+// WithAgentRegistrationController enables the controller that provisions
+// cluster and bootstrap secrets for AgentRegistration resources. The
+// principalAddress (host:port) is written to bootstrap secrets, so agents
+// know where to connect to.
+func WithAgentRegistrationController(enabled bool, principalAddress string) ServerOption {
+	return func(o *Server) error {
+		o.options.registrationControllerEnabled = enabled
+		o.options.registrationPrincipalAddress = principalAddress
+		return nil
+	}
+}
+
+// WithAgentConfigurations enables pushing the runtime configuration of
+// AgentConfiguration resources to the agents they select.
+func WithAgentConfigurations(enabled bool) ServerOption {
+	return func(o *Server) error {
+		o.options.agentConfigurationsEnabled = enabled
+		return nil
+	}
+}
+
+// WithAgentOperations enables running AgentOperation resources on the agents
+// they select. Support bundles collected by operations are written to
+// bundleDir; if it is empty, SupportBundle operations are refused.
+func WithAgentOperations(enabled bool, bundleDir string) ServerOption {
+	return func(o *Server) error {
+		o.options.agentOperationsEnabled = enabled
+		o.options.agentOperationsBundleDir = bundleDir
+		return nil
+	}
+}
+
 func WithResourceProxyAddress(address string) ServerOption {
 	return func(o *Server) error {
 		o.options.resourceProxyAddress = address
@@ -700,3 +1552,109 @@ func WithHA(opts ...ha.Option) ServerOption {
 		return nil
 	}
 }
+
+// WithConflictStrategy sets whose spec prevails when an Application of an
+// autonomous agent was modified on the principal. It only applies to
+// autonomous agents, as the Applications of managed agents are owned by the
+// principal. The default is spoke-wins, which reverts modifications made on
+// the principal.
+func WithConflictStrategy(strategy string) ServerOption {
+	return func(o *Server) error {
+		s, err := manager.ParseConflictStrategy(strategy)
+		if err != nil {
+			return err
+		}
+		o.options.conflictStrategy = s
+		return nil
+	}
+}
+
+// WithServerSideApply makes the principal update Applications received from
+// autonomous agents with server-side apply, using the argocd-agent-principal
+// field manager. The principal then only owns the fields it synchronizes,
+// and fields of the Applications set by other controllers on the control
+// plane are kept.
+func WithServerSideApply(enabled bool) ServerOption {
+	return func(o *Server) error {
+		o.options.serverSideApply = enabled
+		return nil
+	}
+}
+
+// WithResyncBatchSize sets the number of resources sent per batch during an
+// initial sync with an agent. The principal waits for the previous batch to
+// be sent before queueing the next, so that the agent's connection isn't
+// blocked by the whole inventory at once. A size of 0 sends all resources at
+// once.
+func WithResyncBatchSize(size int) ServerOption {
+	return func(o *Server) error {
+		if size < 0 {
+			return fmt.Errorf("resync batch size must not be negative")
+		}
+		o.options.resyncBatchSize = size
+		return nil
+	}
+}
+
+// WithResyncBookmarkMaxAge sets how long the principal uses the bookmarks of
+// Applications synced with autonomous agents. While they are younger, a
+// resync requested by an agent only covers Applications that changed since
+// they were last synced. A maxAge of 0 disables incremental resyncs.
+func WithResyncBookmarkMaxAge(maxAge time.Duration) ServerOption {
+	return func(o *Server) error {
+		if maxAge < 0 {
+			return fmt.Errorf("resync bookmark max age must not be negative")
+		}
+		o.options.resyncBookmarkMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithResyncInterval makes the principal resync with each connected agent
+// every interval, in addition to the resync when an agent first connects. An
+// interval of 0 disables periodic resyncs. The resyncs of each agent, both
+// periodic ones and those after the principal restarted, are delayed by up
+// to jitter, so that the agents of a fleet do not resync all at once.
+func WithResyncInterval(interval, jitter time.Duration) ServerOption {
+	return func(o *Server) error {
+		if interval < 0 {
+			return fmt.Errorf("resync interval must not be negative")
+		}
+		if jitter < 0 {
+			return fmt.Errorf("resync jitter must not be negative")
+		}
+		o.options.resyncInterval = interval
+		o.options.resyncJitter = jitter
+		return nil
+	}
+}
+
+// WithAgentMetricLabels sets which agents have metrics labeled with their own
+// name: those matching one of the glob patterns in allow, and the topN most
+// active agents. The metrics of all other agents are aggregated, so that the
+// number of series does not grow with the number of agents. The pattern "*"
+// gives every agent metrics of its own.
+func WithAgentMetricLabels(allow []string, topN int) ServerOption {
+	return func(o *Server) error {
+		if topN < 0 {
+			return fmt.Errorf("number of agents with metrics of their own must not be negative")
+		}
+		o.options.metricsAgentLabels = allow
+		o.options.metricsAgentLabelsTop = topN
+		return nil
+	}
+}
+
+// WithResourceProxyHedgeDelay makes the principal send a GET request for a
+// live resource to the agent once more, if the agent has not answered it
+// within delay. The first successful response of both is returned to the
+// client. A delay of zero disables hedging.
+func WithResourceProxyHedgeDelay(delay time.Duration) ServerOption {
+	return func(o *Server) error {
+		if delay < 0 {
+			return fmt.Errorf("resource proxy hedge delay must not be negative")
+		}
+		o.options.resourceProxyHedgeDelay = delay
+		return nil
+	}
+}
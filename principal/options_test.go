@@ -15,11 +15,22 @@
 package principal
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	logstream "github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
 )
 
 func Test_WithInformerSyncTimeout(t *testing.T) {
@@ -102,6 +113,21 @@ func Test_WithTLSCipherSuites(t *testing.T) {
 	})
 }
 
+func Test_WithTLSCurvePreferences(t *testing.T) {
+	t.Run("Valid curves", func(t *testing.T) {
+		s := &Server{options: &ServerOptions{}}
+		err := WithTLSCurvePreferences([]string{"X25519", "P256"})(s)
+		assert.NoError(t, err)
+		assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, s.options.tlsCurves)
+	})
+
+	t.Run("Invalid curve", func(t *testing.T) {
+		s := &Server{options: &ServerOptions{}}
+		err := WithTLSCurvePreferences([]string{"cowabunga"})(s)
+		assert.Error(t, err)
+	})
+}
+
 func Test_WithMinimumTLSVersion(t *testing.T) {
 	t.Run("All valid minimum TLS versions", func(t *testing.T) {
 		versions := map[string]uint16{
@@ -158,3 +184,104 @@ func Test_WithRedisProxyDisabled(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, s.options.redisProxyDisabled)
 }
+
+func Test_ConnectionTuningOptions(t *testing.T) {
+	s := &Server{options: &ServerOptions{}}
+	assert.Empty(t, s.connectionTuningOptions())
+
+	require.NoError(t, WithKeepAliveTime(time.Minute)(s))
+	require.NoError(t, WithKeepAliveTimeout(10*time.Second)(s))
+	require.NoError(t, WithInitialWindowSize(1<<20)(s))
+	require.NoError(t, WithInitialConnWindowSize(1<<21)(s))
+	require.NoError(t, WithMaxConcurrentStreams(100)(s))
+	assert.Equal(t, time.Minute, s.options.keepAliveTime)
+	assert.Equal(t, int32(1<<20), s.options.initialWindowSize)
+	assert.Equal(t, int32(1<<21), s.options.initialConnWindowSize)
+	assert.Equal(t, uint32(100), s.options.maxConcurrentStreams)
+	assert.Len(t, s.connectionTuningOptions(), 4)
+
+	assert.Error(t, WithKeepAliveTime(-time.Second)(s))
+	assert.Error(t, WithKeepAliveTimeout(-time.Second)(s))
+	assert.Error(t, WithInitialWindowSize(1024)(s))
+	assert.Error(t, WithInitialConnWindowSize(1<<32)(s))
+	assert.Error(t, WithMaxConcurrentStreams(-1)(s))
+}
+
+func Test_WithResourceProxyAccessLog(t *testing.T) {
+	s := &Server{options: &ServerOptions{}}
+	require.NoError(t, WithResourceProxyAccessLog("", "json")(s))
+	assert.Nil(t, s.options.resourceProxyAccessLog)
+	require.NoError(t, WithResourceProxyAccessLog("stdout", "combined")(s))
+	assert.Equal(t, os.Stdout, s.options.resourceProxyAccessLog)
+	assert.Equal(t, resourceproxy.AccessLogFormatCombined, s.options.resourceProxyAccessLogFormat)
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, WithResourceProxyAccessLog(path, "json")(s))
+	assert.FileExists(t, path)
+	assert.Error(t, WithResourceProxyAccessLog("stdout", "apache")(s))
+	assert.Error(t, WithResourceProxyAccessLog(filepath.Join(path, "nested"), "json")(s))
+}
+
+type testAuthProvider struct {
+	initialized bool
+}
+
+func (p *testAuthProvider) Init() error {
+	p.initialized = true
+	return nil
+}
+
+func (p *testAuthProvider) Authenticate(_ context.Context, credentials map[string]string) (string, error) {
+	if credentials["token"] != "secret" {
+		return "", errors.New("invalid token")
+	}
+	return "agent", nil
+}
+
+func Test_EmbeddingOptions(t *testing.T) {
+	t.Run("Auth provider is registered", func(t *testing.T) {
+		p := &testAuthProvider{}
+		s := newDrainTestServer(t, WithAuthProvider("custom", p))
+		assert.True(t, p.initialized)
+		m := s.AuthMethodsForE2EOnly().Method("custom")
+		require.NotNil(t, m)
+		name, err := m.Authenticate(context.Background(), auth.Credentials{"token": "secret"})
+		require.NoError(t, err)
+		assert.Equal(t, "agent", name)
+
+		_, err = NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd"), "argocd",
+			WithGeneratedTokenSigningKey(), WithAuthProvider("custom", p), WithAuthProvider("custom", p))
+		assert.ErrorContains(t, err, "already registered")
+	})
+
+	t.Run("Metrics are registered with the given registry", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		s := newDrainTestServer(t, WithMetricsRegistry(reg))
+		require.NotNil(t, s.metrics)
+		s.metrics.AgentConnected.Set(1)
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		names := []string{}
+		for _, f := range families {
+			names = append(names, f.GetName())
+		}
+		assert.Contains(t, names, "agent_connected_with_principal")
+	})
+
+	t.Run("Kube client from REST configuration", func(t *testing.T) {
+		s, err := NewServer(context.TODO(), nil, "argocd",
+			WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(),
+			WithKubeClient(&rest.Config{Host: "https://127.0.0.1:6443"}))
+		require.NoError(t, err)
+		require.NotNil(t, s.kubeClient.DynamicClient)
+		assert.Equal(t, "https://127.0.0.1:6443", s.kubeClient.RestConfig.Host)
+
+		_, err = NewServer(context.TODO(), nil, "argocd", WithGeneratedTokenSigningKey())
+		assert.ErrorContains(t, err, "no Kubernetes client")
+	})
+
+	t.Run("Log stream options are applied", func(t *testing.T) {
+		applied := false
+		newDrainTestServer(t, WithLogStreamOptions(func(*logstream.Server) { applied = true }))
+		assert.True(t, applied)
+	})
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Policies for Applications whose agent no longer exists
+const (
+	// OrphanPolicyOrphan only reports orphaned Applications
+	OrphanPolicyOrphan = "orphan"
+	// OrphanPolicyDelete deletes orphaned Applications on the principal only
+	OrphanPolicyDelete = "delete"
+	// OrphanPolicyCascadeDelete deletes orphaned Applications on the
+	// principal and tells the agent to delete them, including their
+	// resources, which requires the agent to still be connected
+	OrphanPolicyCascadeDelete = "cascade-delete"
+)
+
+// orphanedApplication is an Application whose agent no longer exists
+type orphanedApplication struct {
+	app   *v1alpha1.Application
+	agent string
+}
+
+// orphanState tracks the Applications deleted as orphans
+type orphanState struct {
+	mu sync.Mutex
+	// principalOnly holds the Applications deleted on the principal only,
+	// whose deletion must not be sent to their agent
+	principalOnly map[string]bool
+}
+
+func newOrphanState() *orphanState {
+	return &orphanState{principalOnly: make(map[string]bool)}
+}
+
+// markPrincipalOnly marks the deletion of app to not be sent to its agent
+func (o *orphanState) markPrincipalOnly(app *v1alpha1.Application) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.principalOnly[app.QualifiedName()] = true
+}
+
+// unmarkPrincipalOnly returns whether the deletion of app is not to be sent
+// to its agent, and forgets about app
+func (o *orphanState) unmarkPrincipalOnly(app *v1alpha1.Application) bool {
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ok := o.principalOnly[app.QualifiedName()]
+	delete(o.principalOnly, app.QualifiedName())
+	return ok
+}
+
+// runOrphanDetection looks for orphaned Applications every interval until
+// ctx is done
+func (s *Server) runOrphanDetection(ctx context.Context) {
+	interval := s.options.orphanCheckInterval
+	log().Infof("Checking for orphaned applications every %v with policy %s (dry run: %v)", interval, s.options.orphanPolicy, s.options.orphanDryRun)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.IsActive() {
+				continue
+			}
+			if err := s.reconcileOrphans(ctx, time.Now()); err != nil {
+				log().WithError(err).Warn("Could not check for orphaned applications")
+			}
+		}
+	}
+}
+
+// findOrphanedApplications returns the Applications whose agent no longer
+// exists, i.e. has no cluster secret. Applications of autonomous agents are
+// owned by the agent and never considered orphaned. So aren't Applications
+// younger than the check interval, whose agent may not be registered yet.
+func (s *Server) findOrphanedApplications(ctx context.Context, now time.Time) ([]orphanedApplication, error) {
+	// Without any cluster secret, we can't tell deregistered agents from an
+	// installation that doesn't use cluster secrets.
+	if len(s.clusterMgr.Agents()) == 0 {
+		return nil, nil
+	}
+	apps, err := s.appManager.List(ctx, backend.ApplicationSelector{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list applications: %w", err)
+	}
+	filters := s.defaultAppFilterChain()
+	orphans := []orphanedApplication{}
+	for i := range apps {
+		app := &apps[i]
+		if !filters.Admit(app) || app.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := app.Annotations[manager.SourceUIDAnnotation]; ok {
+			continue
+		}
+		if now.Sub(app.CreationTimestamp.Time) < s.options.orphanCheckInterval {
+			continue
+		}
+		agentName := s.getAgentNameForApp(app)
+		if agentName == "" || s.clusterMgr.HasMapping(agentName) {
+			continue
+		}
+		orphans = append(orphans, orphanedApplication{app: app, agent: agentName})
+	}
+	return orphans, nil
+}
+
+// reconcileOrphans applies the orphan policy to all orphaned Applications
+func (s *Server) reconcileOrphans(ctx context.Context, now time.Time) error {
+	orphans, err := s.findOrphanedApplications(ctx, now)
+	if err != nil {
+		return err
+	}
+	if s.metrics != nil {
+		s.metrics.OrphanedApplications.Set(float64(len(orphans)))
+	}
+	policy := s.options.orphanPolicy
+	for _, o := range orphans {
+		logCtx := log().WithFields(logrus.Fields{
+			"application": o.app.QualifiedName(),
+			"agent":       o.agent,
+			"policy":      policy,
+			"dryRun":      s.options.orphanDryRun,
+		})
+		switch policy {
+		case OrphanPolicyDelete:
+			if s.options.orphanDryRun {
+				logCtx.Info("Would delete orphaned application on the principal")
+				continue
+			}
+			s.orphans.markPrincipalOnly(o.app)
+			if err := s.deleteOrphan(ctx, o.app); err != nil {
+				s.orphans.unmarkPrincipalOnly(o.app)
+				logCtx.WithError(err).Error("Could not delete orphaned application")
+				continue
+			}
+			logCtx.Info("Deleted orphaned application on the principal")
+		case OrphanPolicyCascadeDelete:
+			if !s.isAgentConnected(o.agent) {
+				logCtx.Warn("Orphaned application's agent is not connected, deferring its deletion")
+				continue
+			}
+			if s.options.orphanDryRun {
+				logCtx.Info("Would delete orphaned application on the principal and its agent")
+				continue
+			}
+			if err := s.deleteOrphan(ctx, o.app); err != nil {
+				logCtx.WithError(err).Error("Could not delete orphaned application")
+				continue
+			}
+			logCtx.Info("Deleted orphaned application on the principal and its agent")
+		default:
+			logCtx.Warn("Application targets an agent that no longer exists")
+		}
+	}
+	if len(orphans) > 0 {
+		log().Infof("Found %d orphaned applications", len(orphans))
+	}
+	return nil
+}
+
+func (s *Server) deleteOrphan(ctx context.Context, app *v1alpha1.Application) error {
+	err := s.appManager.Delete(ctx, app.Namespace, app, nil)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
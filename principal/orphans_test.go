@@ -0,0 +1,130 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newOrphanTestServer(t *testing.T, policy string, dryRun bool) *Server {
+	t.Helper()
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	app := func(name, namespace string, annotations map[string]string) runtime.Object {
+		return &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: namespace, CreationTimestamp: created, Annotations: annotations,
+		}}
+	}
+	s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd",
+		app("registered", "agent-1", nil),
+		app("orphaned", "agent-2", nil),
+		app("autonomous", "agent-3", map[string]string{manager.SourceUIDAnnotation: "1234"}),
+	), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithNamespaces("agent-*"),
+		WithOrphanDetection(time.Minute, policy, dryRun))
+	require.NoError(t, err)
+	require.NoError(t, s.clusterMgr.MapCluster("agent-1", &v1alpha1.Cluster{}))
+	s.eventStreamSrv = eventstream.NewServer(s.queues, event.NewEventWritersMap(), nil, &cluster.Manager{})
+	return s
+}
+
+func orphanTestAppNames(t *testing.T, s *Server) []string {
+	t.Helper()
+	apps, err := s.appManager.List(context.TODO(), backend.ApplicationSelector{})
+	require.NoError(t, err)
+	names := []string{}
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	return names
+}
+
+func Test_OrphanDetection(t *testing.T) {
+	t.Run("Only applications of deregistered managed agents are orphaned", func(t *testing.T) {
+		s := newOrphanTestServer(t, OrphanPolicyOrphan, false)
+		orphans, err := s.findOrphanedApplications(context.TODO(), time.Now())
+		require.NoError(t, err)
+		require.Len(t, orphans, 1)
+		assert.Equal(t, "orphaned", orphans[0].app.Name)
+		assert.Equal(t, "agent-2", orphans[0].agent)
+
+		// Applications younger than the check interval are left alone
+		orphans, err = s.findOrphanedApplications(context.TODO(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, orphans)
+	})
+
+	t.Run("Nothing is orphaned without cluster secrets", func(t *testing.T) {
+		s := newOrphanTestServer(t, OrphanPolicyOrphan, false)
+		require.NoError(t, s.clusterMgr.UnmapCluster("agent-1"))
+		orphans, err := s.findOrphanedApplications(context.TODO(), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, orphans)
+	})
+
+	t.Run("Orphan policy keeps applications", func(t *testing.T) {
+		s := newOrphanTestServer(t, OrphanPolicyOrphan, false)
+		require.NoError(t, s.reconcileOrphans(context.TODO(), time.Now()))
+		assert.Len(t, orphanTestAppNames(t, s), 3)
+	})
+
+	t.Run("Delete policy in dry run keeps applications", func(t *testing.T) {
+		s := newOrphanTestServer(t, OrphanPolicyDelete, true)
+		require.NoError(t, s.reconcileOrphans(context.TODO(), time.Now()))
+		assert.Len(t, orphanTestAppNames(t, s), 3)
+	})
+
+	t.Run("Delete policy deletes on the principal only", func(t *testing.T) {
+		s := newOrphanTestServer(t, OrphanPolicyDelete, false)
+		require.NoError(t, s.reconcileOrphans(context.TODO(), time.Now()))
+		assert.ElementsMatch(t, []string{"registered", "autonomous"}, orphanTestAppNames(t, s))
+		orphan := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "orphaned", Namespace: "agent-2"}}
+		assert.True(t, s.orphans.unmarkPrincipalOnly(orphan))
+		assert.False(t, s.orphans.unmarkPrincipalOnly(orphan))
+	})
+
+	t.Run("Cascade delete waits for the agent to connect", func(t *testing.T) {
+		s := newOrphanTestServer(t, OrphanPolicyCascadeDelete, false)
+		require.NoError(t, s.reconcileOrphans(context.TODO(), time.Now()))
+		assert.Len(t, orphanTestAppNames(t, s), 3)
+
+		s.eventStreamSrv.MarkConnected("agent-2")
+		defer s.eventStreamSrv.MarkDisconnected("agent-2")
+		require.NoError(t, s.reconcileOrphans(context.TODO(), time.Now()))
+		assert.ElementsMatch(t, []string{"registered", "autonomous"}, orphanTestAppNames(t, s))
+		orphan := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "orphaned", Namespace: "agent-2"}}
+		assert.False(t, s.orphans.unmarkPrincipalOnly(orphan))
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		s := &Server{options: &ServerOptions{}}
+		assert.Error(t, WithOrphanDetection(time.Minute, "purge", false)(s))
+		assert.Error(t, WithOrphanDetection(-time.Minute, OrphanPolicyOrphan, false)(s))
+		assert.NoError(t, WithOrphanDetection(0, OrphanPolicyCascadeDelete, true)(s))
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// processPolicyViolationReport records that the agent refused to create or
+// update an Application, because its spec violates the agent's policy. The
+// violations are set as InvalidSpecError condition on the Application, where
+// users of Argo CD see them, until the agent reports the Application's
+// status again.
+func (s *Server) processPolicyViolationReport(ctx context.Context, agentName string, ev *cloudevents.Event) error {
+	report, err := event.New(ev, event.TargetPolicyViolation).PolicyViolationReport()
+	if err != nil {
+		return fmt.Errorf("invalid policy violation report: %w", err)
+	}
+	logCtx := s.logGrpcEvent().WithFields(logrus.Fields{
+		"module":    "QueueProcessor",
+		"client":    agentName,
+		"app":       report.Namespace + "/" + report.Name,
+		"operation": report.Operation,
+	})
+
+	app, err := s.appManager.Get(ctx, report.Name, report.Namespace)
+	if err != nil {
+		return fmt.Errorf("could not get application %s/%s: %w", report.Namespace, report.Name, err)
+	}
+	if s.getAgentNameForApp(app) != agentName {
+		return fmt.Errorf("application %s is not managed by agent %s", app.QualifiedName(), agentName)
+	}
+	if report.UID != "" && report.UID != string(app.UID) {
+		logCtx.Debug("Ignoring policy violations of a previous instance of the application")
+		return nil
+	}
+
+	messages := make([]string, len(report.Violations))
+	for i, v := range report.Violations {
+		logCtx.WithField("rule", v.Rule).Warnf("Agent refused application: %s", v.Message)
+		messages[i] = v.Message
+		if v.Rule != "" {
+			messages[i] = v.Rule + ": " + v.Message
+		}
+	}
+	now := metav1.Now()
+	cond := v1alpha1.ApplicationCondition{
+		Type:               v1alpha1.ApplicationConditionInvalidSpecError,
+		Message:            fmt.Sprintf("Agent %s refused to %s the application, which violates its policy: %s", agentName, report.Operation, strings.Join(messages, "; ")),
+		LastTransitionTime: &now,
+	}
+	_, err = s.appManager.SetConditions(ctx, app.Name, app.Namespace, []v1alpha1.ApplicationCondition{cond},
+		map[v1alpha1.ApplicationConditionType]bool{v1alpha1.ApplicationConditionInvalidSpecError: true})
+	if err != nil {
+		return fmt.Errorf("could not set conditions of application %s: %w", app.QualifiedName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ProcessPolicyViolationReport(t *testing.T) {
+	app := &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "agent", UID: "app-uid"},
+		Status: v1alpha1.ApplicationStatus{
+			Conditions: []v1alpha1.ApplicationCondition{{Type: v1alpha1.ApplicationConditionSyncError, Message: "unrelated"}},
+		},
+	}
+	newServer := func(t *testing.T) *Server {
+		t.Helper()
+		s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd", app.DeepCopy()), "argocd",
+			WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithNamespaces("agent"))
+		require.NoError(t, err)
+		return s
+	}
+	agentEvents := event.NewEventSource("agent")
+	report := func(uid string) *event.PolicyViolationReport {
+		return &event.PolicyViolationReport{
+			Name:      "guestbook",
+			Namespace: "agent",
+			UID:       uid,
+			Operation: "update",
+			Violations: []event.PolicyViolation{
+				{Rule: "namespaces", Message: "kube-system is forbidden"},
+				{Message: "no owner"},
+			},
+		}
+	}
+	conditions := func(t *testing.T, s *Server) []v1alpha1.ApplicationCondition {
+		t.Helper()
+		app, err := s.appManager.Get(context.TODO(), "guestbook", "agent")
+		require.NoError(t, err)
+		return app.Status.Conditions
+	}
+
+	t.Run("Violations are set as condition", func(t *testing.T) {
+		s := newServer(t)
+		require.NoError(t, s.processPolicyViolationReport(context.TODO(), "agent", agentEvents.PolicyViolationEvent(report("app-uid"))))
+		messages := map[v1alpha1.ApplicationConditionType]string{}
+		for _, c := range conditions(t, s) {
+			messages[c.Type] = c.Message
+		}
+		assert.Equal(t, map[v1alpha1.ApplicationConditionType]string{
+			v1alpha1.ApplicationConditionSyncError:        "unrelated",
+			v1alpha1.ApplicationConditionInvalidSpecError: "Agent agent refused to update the application, which violates its policy: namespaces: kube-system is forbidden; no owner",
+		}, messages)
+	})
+
+	t.Run("Reports of previous instances are ignored", func(t *testing.T) {
+		s := newServer(t)
+		require.NoError(t, s.processPolicyViolationReport(context.TODO(), "agent", agentEvents.PolicyViolationEvent(report("old-uid"))))
+		assert.Len(t, conditions(t, s), 1)
+	})
+
+	t.Run("Agents can only report their own applications", func(t *testing.T) {
+		s := newServer(t)
+		err := s.processPolicyViolationReport(context.TODO(), "other", agentEvents.PolicyViolationEvent(report("app-uid")))
+		assert.ErrorContains(t, err, "not managed by agent other")
+		assert.Len(t, conditions(t, s), 1)
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultConnectionProbeInterval is the default interval at which the
+	// principal measures the connection quality of each agent
+	defaultConnectionProbeInterval = 30 * time.Second
+
+	// clockSkewWarningThreshold is the clock offset above which a warning is
+	// logged. Log streams resume from the timestamp of the last line seen,
+	// which breaks if the clocks of principal and agent drift apart.
+	clockSkewWarningThreshold = time.Second
+)
+
+// ConnectionQuality is the result of the latest connection probe of an agent
+type ConnectionQuality struct {
+	// RTT is the network round trip time, excluding the agent's processing time
+	RTT time.Duration
+	// ClockSkew is the offset of the agent's clock from the principal's clock.
+	// It is positive if the agent's clock is ahead.
+	ClockSkew time.Duration
+	// MeasuredAt is the time the measurement was taken
+	MeasuredAt time.Time
+}
+
+// ConnectionQuality returns the latest connection quality measured for the
+// given agent. The second return value is false if no measurement exists.
+func (s *Server) ConnectionQuality(agentName string) (ConnectionQuality, bool) {
+	s.connQualityMu.RLock()
+	defer s.connQualityMu.RUnlock()
+	q, ok := s.connQuality[agentName]
+	return q, ok
+}
+
+// runConnectionProbes periodically sends a ping carrying a timestamp to every
+// connected agent. The agents answer with a pong, which is evaluated by
+// recordConnectionProbe.
+func (s *Server) runConnectionProbes(ctx context.Context) {
+	ticker := time.NewTicker(s.options.connectionProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendConnectionProbes()
+		}
+	}
+}
+
+func (s *Server) sendConnectionProbes() {
+	if s.eventStreamSrv == nil {
+		return
+	}
+	connected := map[string]bool{}
+	for _, agentName := range s.eventStreamSrv.ConnectedAgents() {
+		connected[agentName] = true
+		q := s.queues.SendQ(agentName)
+		if q == nil {
+			continue
+		}
+		// PingSentAt is stamped by the event writer when the ping is sent
+		ev, err := s.events.ConnectionProbeEvent(event.Ping, &event.ConnectionProbe{PingSentAt: time.Now()})
+		if err != nil {
+			log().WithError(err).Error("Could not create connection probe")
+			return
+		}
+		q.Add(ev)
+	}
+
+	// Forget about agents that went away
+	s.connQualityMu.Lock()
+	for agentName := range s.connQuality {
+		if !connected[agentName] {
+			delete(s.connQuality, agentName)
+			if s.metrics != nil {
+				s.metrics.DeleteAgentConnectionQuality(agentName)
+			}
+			s.forgetAgentVersionSkew(agentName)
+		}
+	}
+	s.connQualityMu.Unlock()
+}
+
+// recordConnectionProbe evaluates the pong of a connection probe received
+// from an agent at receivedAt.
+func (s *Server) recordConnectionProbe(agentName string, probe *event.ConnectionProbe, receivedAt time.Time) {
+	rtt, skew := probe.Measure(receivedAt)
+	if rtt < 0 {
+		rtt = 0
+	}
+	logCtx := log().WithFields(logrus.Fields{
+		"agent":      agentName,
+		"rtt":        rtt,
+		"clock_skew": skew,
+	})
+	logCtx.Trace("Measured connection quality")
+
+	s.connQualityMu.Lock()
+	prev, known := s.connQuality[agentName]
+	s.connQuality[agentName] = ConnectionQuality{RTT: rtt, ClockSkew: skew, MeasuredAt: receivedAt}
+	s.connQualityMu.Unlock()
+
+	// Only warn when the skew first exceeds the threshold
+	if skew.Abs() > clockSkewWarningThreshold && (!known || prev.ClockSkew.Abs() <= clockSkewWarningThreshold) {
+		logCtx.Warn("Clock of agent is out of sync with principal; resuming log streams may skip or repeat lines")
+	}
+
+	if s.metrics != nil {
+		if s.metrics.PerAgent(agentName) {
+			s.metrics.AgentRTT.WithLabelValues(agentName).Set(rtt.Seconds())
+			s.metrics.AgentOneWayLatency.WithLabelValues(agentName).Set((rtt / 2).Seconds())
+			s.metrics.AgentClockSkew.WithLabelValues(agentName).Set(skew.Seconds())
+		}
+		s.metrics.AgentProbeRoundTrip.WithLabelValues(s.metrics.AgentLabel(agentName)).Observe(receivedAt.Sub(probe.PingSentAt).Seconds())
+	}
+}
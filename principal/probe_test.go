@@ -0,0 +1,76 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConnectionProbes(t *testing.T) {
+	t.Run("Probes are sent to connected agents", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+
+		s.sendConnectionProbes()
+		ev, shutdown := s.queues.SendQ("agent").Get()
+		require.False(t, shutdown)
+		assert.Equal(t, event.Ping.String(), ev.Type())
+		probe, err := event.New(ev, event.TargetHeartbeat).ConnectionProbe()
+		require.NoError(t, err)
+		require.NotNil(t, probe)
+		assert.False(t, probe.PingSentAt.IsZero())
+	})
+
+	t.Run("Pong is recorded", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		now := time.Now()
+		probe := &event.ConnectionProbe{
+			PingSentAt:     now.Add(-100 * time.Millisecond),
+			PingReceivedAt: now.Add(-50*time.Millisecond + 3*time.Second),
+			PongSentAt:     now.Add(-50*time.Millisecond + 3*time.Second),
+		}
+		pong, err := s.events.ConnectionProbeEvent(event.Pong, probe)
+		require.NoError(t, err)
+		require.NoError(t, s.processHeartbeatEvent("agent", pong))
+
+		q, ok := s.ConnectionQuality("agent")
+		require.True(t, ok)
+		assert.InDelta(t, float64(100*time.Millisecond), float64(q.RTT), float64(20*time.Millisecond))
+		assert.InDelta(t, float64(3*time.Second), float64(q.ClockSkew), float64(20*time.Millisecond))
+	})
+
+	t.Run("Agents that disconnected are forgotten", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.recordConnectionProbe("agent", &event.ConnectionProbe{PingSentAt: time.Now()}, time.Now())
+		_, ok := s.ConnectionQuality("agent")
+		require.True(t, ok)
+		s.sendConnectionProbes()
+		_, ok = s.ConnectionQuality("agent")
+		assert.False(t, ok)
+	})
+
+	t.Run("Plain heartbeats are ignored", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		require.NoError(t, s.processHeartbeatEvent("agent", s.events.HeartbeatEvent(event.Ping)))
+		_, ok := s.ConnectionQuality("agent")
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/argoproj-labs/argocd-agent/internal/health"
+)
+
+// WithReadinessMinAgents makes the principal report as not ready while fewer
+// than n agents are connected. 0 disables the check.
+func WithReadinessMinAgents(n int) ServerOption {
+	return func(o *Server) error {
+		if n < 0 {
+			return fmt.Errorf("minimum number of connected agents must not be negative")
+		}
+		o.options.readinessMinAgents = n
+		return nil
+	}
+}
+
+// readinessChecks returns the checks that decide whether the principal is
+// ready
+func (s *Server) readinessChecks() []health.Check {
+	checks := []health.Check{
+		{Name: "listener", Run: s.checkListener},
+	}
+	if s.options.readinessMinAgents > 0 {
+		checks = append(checks, health.Check{Name: "agents", Run: s.checkConnectedAgents})
+	}
+	if s.ha != nil && s.ha.Controller != nil {
+		checks = append(checks, health.Check{Name: "ha", Run: s.checkHA})
+	}
+	return checks
+}
+
+// checkListener checks whether the gRPC listener accepts connections
+func (s *Server) checkListener(ctx context.Context) error {
+	if s.listener == nil || s.listener.l == nil {
+		return errors.New("not listening")
+	}
+	if err := s.listener.ctx.Err(); err != nil {
+		return fmt.Errorf("listener stopped: %w", err)
+	}
+	addr := s.listener.l.Addr()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, addr.Network(), addr.String())
+	if err != nil {
+		return fmt.Errorf("listener on %s does not accept connections: %w", addr, err)
+	}
+	_ = conn.Close()
+	return nil
+}
+
+// checkConnectedAgents checks whether the minimum number of agents is
+// connected
+func (s *Server) checkConnectedAgents(ctx context.Context) error {
+	if s.eventStreamSrv == nil {
+		return errors.New("event stream server not started")
+	}
+	connected := s.eventStreamSrv.ConnectedAgentCount()
+	if connected < s.options.readinessMinAgents {
+		return fmt.Errorf("%d of at least %d agents connected", connected, s.options.readinessMinAgents)
+	}
+	return nil
+}
+
+// checkHA checks whether this principal should receive traffic in its HA
+// state
+func (s *Server) checkHA(ctx context.Context) error {
+	if state := s.ha.Controller.State(); !state.IsHealthy() {
+		return fmt.Errorf("HA state %s does not receive traffic", state)
+	}
+	return nil
+}
+
+// readyzHandler reports whether the principal's dependencies are available
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	health.Handler(health.DefaultTimeout, s.readinessChecks)(w, r)
+}
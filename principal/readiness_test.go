@@ -0,0 +1,87 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Readyz(t *testing.T) {
+	readyz := func(s *Server) (int, health.Report) {
+		rec := httptest.NewRecorder()
+		s.readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report health.Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		return rec.Code, report
+	}
+	listen := func(t *testing.T, s *Server) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.listener = &Listener{l: l, ctx: ctx, cancel: cancel}
+		t.Cleanup(func() {
+			cancel()
+			l.Close()
+		})
+	}
+
+	t.Run("Ready when listening", func(t *testing.T) {
+		s := newDrainTestServer(t)
+		listen(t, s)
+		code, report := readyz(s)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []health.CheckResult{{Name: "listener", Status: health.StatusOK}}, report.Checks)
+	})
+
+	t.Run("Not ready without listener", func(t *testing.T) {
+		s := newDrainTestServer(t)
+		code, report := readyz(s)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.CheckResult{Name: "listener", Status: health.StatusFailed, Message: "not listening"}, report.Checks[0])
+	})
+
+	t.Run("Not ready when listener is closed", func(t *testing.T) {
+		s := newDrainTestServer(t)
+		listen(t, s)
+		s.listener.l.Close()
+		code, report := readyz(s)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, report.Checks[0].Message, "does not accept connections")
+	})
+
+	t.Run("Not ready with too few agents", func(t *testing.T) {
+		s := newDrainTestServer(t, WithReadinessMinAgents(1))
+		listen(t, s)
+		code, report := readyz(s)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		require.Len(t, report.Checks, 2)
+		assert.Equal(t, health.StatusOK, report.Checks[0].Status)
+		assert.Equal(t, health.CheckResult{Name: "agents", Status: health.StatusFailed, Message: "0 of at least 1 agents connected"}, report.Checks[1])
+	})
+
+	t.Run("Negative minimum agents", func(t *testing.T) {
+		s := &Server{options: &ServerOptions{}}
+		assert.Error(t, WithReadinessMinAgents(-1)(s))
+	})
+}
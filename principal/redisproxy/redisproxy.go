@@ -81,6 +81,7 @@ type RedisProxy struct {
 	tlsMinVersion     uint16
 	tlsMaxVersion     uint16
 	tlsCipherSuites   []uint16
+	tlsCurves         []tls.CurveID
 
 	// TLS configuration for Redis (connections to principal's argocd-redis)
 	redisTLSCA       *x509.CertPool
@@ -145,11 +146,13 @@ func (rp *RedisProxy) SetServerTLSFromPath(certPath, keyPath string) {
 	rp.tlsServerKeyPath = keyPath
 }
 
-// SetServerTLSConfig sets the TLS protocol configuration for the Redis proxy server
-func (rp *RedisProxy) SetServerTLSConfig(minVersion, maxVersion uint16, cipherSuites []uint16) {
+// SetServerTLSConfig sets the TLS protocol configuration for the Redis proxy
+// server. Go's default curves are used if none are given.
+func (rp *RedisProxy) SetServerTLSConfig(minVersion, maxVersion uint16, cipherSuites []uint16, curves ...tls.CurveID) {
 	rp.tlsMinVersion = minVersion
 	rp.tlsMaxVersion = maxVersion
 	rp.tlsCipherSuites = cipherSuites
+	rp.tlsCurves = curves
 }
 
 // SetUpstreamTLSCA sets the CA certificate pool for verifying upstream Redis TLS
@@ -188,10 +191,11 @@ func (rp *RedisProxy) createServerTLSConfig() (*tls.Config, error) {
 	}
 
 	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   rp.tlsMinVersion,
-		MaxVersion:   rp.tlsMaxVersion,
-		CipherSuites: rp.tlsCipherSuites,
+		Certificates:     []tls.Certificate{cert},
+		MinVersion:       rp.tlsMinVersion,
+		MaxVersion:       rp.tlsMaxVersion,
+		CipherSuites:     rp.tlsCipherSuites,
+		CurvePreferences: rp.tlsCurves,
 	}, nil
 }
 
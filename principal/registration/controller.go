@@ -0,0 +1,442 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registration
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
+	"github.com/argoproj-labs/argocd-agent/internal/informer"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj/argo-cd/v3/common"
+	appv1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LabelKeyAgentRegistration marks secrets provisioned for an AgentRegistration
+const LabelKeyAgentRegistration = "argocd-agent.argoproj-labs.io/agent-registration"
+
+// Keys of the bootstrap secret. The configuration keys match the entries of
+// the agent's parameter ConfigMap.
+const (
+	BootstrapKeyCert              = "tls.crt"
+	BootstrapKeyKey               = "tls.key"
+	BootstrapKeyCA                = "ca.crt"
+	BootstrapKeyMode              = "agent.mode"
+	BootstrapKeyAllowedNamespaces = "agent.allowed-namespaces"
+	BootstrapKeyServerAddress     = "agent.server.address"
+	BootstrapKeyServerPort        = "agent.server.port"
+)
+
+// registrationResyncPeriod makes sure secrets that were deleted by someone
+// are provisioned again.
+const registrationResyncPeriod = 5 * time.Minute
+
+// Controller provisions cluster and bootstrap secrets for AgentRegistration
+// resources, so agents can be onboarded declaratively.
+type Controller struct {
+	namespace            string
+	resourceProxyAddress string
+	principalAddress     string
+	kubeclient           kubernetes.Interface
+	dynclient            dynamic.Interface
+	informer             *informer.Informer[*v1alpha1.AgentRegistration]
+}
+
+// ControllerOption is a functional option for NewController
+type ControllerOption func(c *Controller)
+
+// WithResourceProxyAddress sets the address of the resource proxy written to
+// cluster secrets.
+func WithResourceProxyAddress(address string) ControllerOption {
+	return func(c *Controller) {
+		c.resourceProxyAddress = address
+	}
+}
+
+// WithPrincipalAddress sets the address (host:port) agents use to connect to
+// the principal, written to bootstrap secrets.
+func WithPrincipalAddress(address string) ControllerOption {
+	return func(c *Controller) {
+		c.principalAddress = address
+	}
+}
+
+// NewController returns a controller for the AgentRegistration resources in
+// namespace.
+func NewController(ctx context.Context, namespace string, kubeclient kubernetes.Interface, dynclient dynamic.Interface, opts ...ControllerOption) (*Controller, error) {
+	c := &Controller{
+		namespace:            namespace,
+		resourceProxyAddress: "argocd-agent-resource-proxy:9090",
+		kubeclient:           kubeclient,
+		dynclient:            dynclient,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	client := dynclient.Resource(v1alpha1.AgentRegistrationResource).Namespace(namespace)
+	var err error
+	c.informer, err = informer.NewInformer(ctx,
+		informer.WithListHandler[*v1alpha1.AgentRegistration](func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			list, err := client.List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return v1alpha1.AgentRegistrationListFromUnstructured(list)
+		}),
+		informer.WithWatchHandler[*v1alpha1.AgentRegistration](func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			w, err := client.Watch(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, toAgentRegistrationEvent), nil
+		}),
+		informer.WithAddHandler(func(reg *v1alpha1.AgentRegistration) {
+			c.reconcile(ctx, reg)
+		}),
+		informer.WithUpdateHandler(func(_, reg *v1alpha1.AgentRegistration) {
+			c.reconcile(ctx, reg)
+		}),
+		informer.WithResyncPeriod[*v1alpha1.AgentRegistration](registrationResyncPeriod),
+		informer.WithGroupResource[*v1alpha1.AgentRegistration](v1alpha1.Group, v1alpha1.AgentRegistrationResource.Resource),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Start runs the controller until ctx is done. It returns once the
+// informer has synced.
+func (c *Controller) Start(ctx context.Context) error {
+	log().Info("Starting agent registration controller")
+	go func() {
+		if err := c.informer.Start(ctx); err != nil {
+			log().WithError(err).Error("Could not start informer")
+		}
+	}()
+	syncCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return c.informer.WaitForSync(syncCtx)
+}
+
+// Stop stops the controller
+func (c *Controller) Stop() error {
+	return c.informer.Stop()
+}
+
+// toAgentRegistrationEvent converts the objects of watch events returned by
+// the dynamic client.
+func toAgentRegistrationEvent(ev watch.Event) (watch.Event, bool) {
+	u, ok := ev.Object.(*unstructured.Unstructured)
+	if !ok {
+		return ev, true
+	}
+	reg, err := v1alpha1.AgentRegistrationFromUnstructured(u)
+	if err != nil {
+		log().WithError(err).Errorf("Invalid AgentRegistration %s", u.GetName())
+		return ev, false
+	}
+	ev.Object = reg
+	return ev, true
+}
+
+func (c *Controller) reconcile(ctx context.Context, reg *v1alpha1.AgentRegistration) {
+	reg = reg.DeepCopy()
+	if reg.DeletionTimestamp != nil {
+		return
+	}
+	logCtx := log().WithField("agent", reg.Name)
+
+	status := v1alpha1.AgentRegistrationStatus{
+		Phase:              v1alpha1.RegistrationPhaseProvisioned,
+		ClusterSecret:      cluster.GetClusterSecretName(reg.Name),
+		BootstrapSecret:    reg.BootstrapSecret(),
+		ObservedGeneration: reg.Generation,
+	}
+	if err := c.provision(ctx, reg); err != nil {
+		logCtx.WithError(err).Warn("Could not provision agent")
+		status.Phase = v1alpha1.RegistrationPhaseFailed
+		status.Message = err.Error()
+	}
+	if status == reg.Status {
+		return
+	}
+	reg.Status = status
+	obj, err := reg.ToUnstructured()
+	if err != nil {
+		logCtx.WithError(err).Error("Could not update status of AgentRegistration")
+		return
+	}
+	if _, err := c.dynclient.Resource(v1alpha1.AgentRegistrationResource).Namespace(c.namespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		logCtx.WithError(err).Warn("Could not update status of AgentRegistration")
+		return
+	}
+	if status.Phase == v1alpha1.RegistrationPhaseProvisioned {
+		logCtx.Info("Agent provisioned")
+	}
+}
+
+// validate checks the spec of reg
+func validate(reg *v1alpha1.AgentRegistration) error {
+	if errs := validation.IsDNS1123Label(reg.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid agent name: %s", strings.Join(errs, ", "))
+	}
+	if mode := types.AgentModeFromString(reg.Spec.Mode); mode == types.AgentModeUnknown {
+		return fmt.Errorf("invalid mode %q: must be managed or autonomous", reg.Spec.Mode)
+	}
+	return nil
+}
+
+// provision creates the cluster secret and the bootstrap secret of reg,
+// unless they exist already.
+func (c *Controller) provision(ctx context.Context, reg *v1alpha1.AgentRegistration) error {
+	if err := validate(reg); err != nil {
+		return err
+	}
+
+	secrets := c.kubeclient.CoreV1().Secrets(c.namespace)
+	clusterSecret, err := secrets.Get(ctx, cluster.GetClusterSecretName(reg.Name), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not get cluster secret: %w", err)
+	}
+	bootstrapSecret, err := secrets.Get(ctx, reg.BootstrapSecret(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not get bootstrap secret: %w", err)
+	}
+	if clusterSecret != nil && clusterSecret.Name != "" && !isOwnedBy(clusterSecret, reg) {
+		return fmt.Errorf("cluster secret %s exists and is not managed by this registration", clusterSecret.Name)
+	}
+	if bootstrapSecret != nil && bootstrapSecret.Name != "" && !isOwnedBy(bootstrapSecret, reg) {
+		return fmt.Errorf("bootstrap secret %s exists and is not managed by this registration", bootstrapSecret.Name)
+	}
+
+	ca, caPEM, err := c.loadCA(ctx)
+	if err != nil {
+		return err
+	}
+
+	if clusterSecret == nil || clusterSecret.Name == "" {
+		sec, err := c.clusterSecret(reg, ca, caPEM)
+		if err != nil {
+			return err
+		}
+		if _, err := secrets.Create(ctx, sec, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create cluster secret: %w", err)
+		}
+	} else if err := c.updateClusterSecret(ctx, clusterSecret, reg); err != nil {
+		return err
+	}
+
+	if bootstrapSecret == nil || bootstrapSecret.Name == "" {
+		sec, err := c.bootstrapSecret(reg, ca, caPEM)
+		if err != nil {
+			return err
+		}
+		if _, err := secrets.Create(ctx, sec, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create bootstrap secret: %w", err)
+		}
+	} else if err := c.updateBootstrapConfig(ctx, bootstrapSecret, reg); err != nil {
+		return err
+	}
+	return nil
+}
+
+type signer struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+// loadCA reads the principal's CA used to issue client certificates
+func (c *Controller) loadCA(ctx context.Context) (*signer, string, error) {
+	tlsCert, err := tlsutil.TLSCertFromSecret(ctx, c.kubeclient, c.namespace, config.SecretNamePrincipalCA)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read CA secret: %w", err)
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("could not parse CA certificate: %w", err)
+	}
+	caPEM, err := tlsutil.CertDataToPEM(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("could not encode CA certificate: %w", err)
+	}
+	return &signer{cert: cert, key: tlsCert.PrivateKey}, caPEM, nil
+}
+
+func (c *Controller) objectMeta(reg *v1alpha1.AgentRegistration, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       c.namespace,
+		Labels:          map[string]string{LabelKeyAgentRegistration: reg.Name},
+		OwnerReferences: []metav1.OwnerReference{reg.OwnerReference()},
+	}
+}
+
+func (c *Controller) clusterLabels(reg *v1alpha1.AgentRegistration) map[string]string {
+	labels := maps.Clone(reg.Spec.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[cluster.LabelKeyClusterAgentMapping] = reg.Name
+	labels[LabelKeyAgentRegistration] = reg.Name
+	return labels
+}
+
+// clusterSecret returns the Argo CD cluster secret for reg, with a client
+// certificate for the resource proxy.
+func (c *Controller) clusterSecret(reg *v1alpha1.AgentRegistration, ca *signer, caPEM string) (*corev1.Secret, error) {
+	cert, key, err := tlsutil.GenerateClientCertificate(reg.Name, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("could not issue client certificate: %w", err)
+	}
+	clus := &appv1.Cluster{
+		Server: fmt.Sprintf("https://%s?agentName=%s", c.resourceProxyAddress, reg.Name),
+		Name:   reg.Name,
+		Labels: c.clusterLabels(reg),
+		Annotations: map[string]string{
+			common.AnnotationKeyAppSkipReconcile: "true",
+		},
+		Config: appv1.ClusterConfig{
+			TLSClientConfig: appv1.TLSClientConfig{
+				CertData: []byte(cert),
+				KeyData:  []byte(key),
+				CAData:   []byte(caPEM),
+			},
+		},
+	}
+	if reg.Spec.Maintenance != nil && *reg.Spec.Maintenance {
+		clus.Annotations[cluster.AnnotationKeyMaintenance] = "true"
+	}
+	sec := &corev1.Secret{ObjectMeta: c.objectMeta(reg, cluster.GetClusterSecretName(reg.Name))}
+	if err := cluster.ClusterToSecret(clus, sec); err != nil {
+		return nil, fmt.Errorf("could not convert cluster to secret: %w", err)
+	}
+	return sec, nil
+}
+
+// bootstrapConfig returns the configuration entries of the bootstrap secret
+func (c *Controller) bootstrapConfig(reg *v1alpha1.AgentRegistration) map[string][]byte {
+	data := map[string][]byte{
+		BootstrapKeyMode:              []byte(reg.Spec.Mode),
+		BootstrapKeyAllowedNamespaces: []byte(strings.Join(reg.Spec.AllowedNamespaces, ",")),
+	}
+	if c.principalAddress != "" {
+		host, port, err := net.SplitHostPort(c.principalAddress)
+		if err != nil {
+			host, port = c.principalAddress, "443"
+		}
+		data[BootstrapKeyServerAddress] = []byte(host)
+		data[BootstrapKeyServerPort] = []byte(port)
+	}
+	return data
+}
+
+// bootstrapSecret returns the secret holding everything the agent needs to
+// connect to the principal: a client certificate, the CA and its
+// configuration.
+func (c *Controller) bootstrapSecret(reg *v1alpha1.AgentRegistration, ca *signer, caPEM string) (*corev1.Secret, error) {
+	cert, key, err := tlsutil.GenerateClientCertificate(reg.Name, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("could not issue client certificate: %w", err)
+	}
+	data := c.bootstrapConfig(reg)
+	data[BootstrapKeyCert] = []byte(cert)
+	data[BootstrapKeyKey] = []byte(key)
+	data[BootstrapKeyCA] = []byte(caPEM)
+	return &corev1.Secret{
+		ObjectMeta: c.objectMeta(reg, reg.BootstrapSecret()),
+		Type:       corev1.SecretTypeTLS,
+		Data:       data,
+	}, nil
+}
+
+// updateClusterSecret applies changes of the spec's labels and maintenance
+// mode to an existing cluster secret.
+func (c *Controller) updateClusterSecret(ctx context.Context, sec *corev1.Secret, reg *v1alpha1.AgentRegistration) error {
+	want := c.clusterLabels(reg)
+	want[common.LabelKeySecretType] = common.LabelValueSecretTypeCluster
+	changed := !maps.Equal(sec.Labels, want)
+	sec = sec.DeepCopy()
+	sec.Labels = want
+	if m := reg.Spec.Maintenance; m != nil && *m != (sec.Annotations[cluster.AnnotationKeyMaintenance] == "true") {
+		changed = true
+		if *m {
+			if sec.Annotations == nil {
+				sec.Annotations = make(map[string]string)
+			}
+			sec.Annotations[cluster.AnnotationKeyMaintenance] = "true"
+		} else {
+			delete(sec.Annotations, cluster.AnnotationKeyMaintenance)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := c.kubeclient.CoreV1().Secrets(c.namespace).Update(ctx, sec, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update cluster secret: %w", err)
+	}
+	return nil
+}
+
+// updateBootstrapConfig applies changes of the spec to an existing bootstrap
+// secret, keeping its credentials.
+func (c *Controller) updateBootstrapConfig(ctx context.Context, sec *corev1.Secret, reg *v1alpha1.AgentRegistration) error {
+	changed := false
+	sec = sec.DeepCopy()
+	if sec.Data == nil {
+		sec.Data = make(map[string][]byte)
+	}
+	for k, v := range c.bootstrapConfig(reg) {
+		if string(sec.Data[k]) != string(v) {
+			sec.Data[k] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := c.kubeclient.CoreV1().Secrets(c.namespace).Update(ctx, sec, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update bootstrap secret: %w", err)
+	}
+	return nil
+}
+
+func isOwnedBy(sec *corev1.Secret, reg *v1alpha1.AgentRegistration) bool {
+	for _, ref := range sec.OwnerReferences {
+		if ref.Kind == v1alpha1.AgentRegistrationKind && ref.Name == reg.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/config"
+	"github.com/argoproj-labs/argocd-agent/internal/tlsutil"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+)
+
+func createTestCASecret(t *testing.T, kubeclient kubernetes.Interface) {
+	t.Helper()
+	caCertPEM, caKeyPEM, err := tlsutil.GenerateCaCertificate(config.SecretNamePrincipalCA)
+	require.NoError(t, err)
+	_, err = kubeclient.CoreV1().Secrets(testNamespace).Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.SecretNamePrincipalCA, Namespace: testNamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte(caCertPEM),
+			"tls.key": []byte(caKeyPEM),
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func newTestController(t *testing.T, regs ...*v1alpha1.AgentRegistration) (*Controller, kubernetes.Interface) {
+	t.Helper()
+	kubeclient := kube.NewFakeClientsetWithResources()
+	createTestCASecret(t, kubeclient)
+	objs := []runtime.Object{}
+	for _, r := range regs {
+		u, err := r.ToUnstructured()
+		require.NoError(t, err)
+		objs = append(objs, u)
+	}
+	dynclient := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.AgentRegistrationResource: "AgentRegistrationList"}, objs...)
+	c, err := NewController(context.Background(), testNamespace, kubeclient, dynclient,
+		WithResourceProxyAddress(testResourceProxyAddr),
+		WithPrincipalAddress("principal.example.com:8443"))
+	require.NoError(t, err)
+	return c, kubeclient
+}
+
+func testRegistration(name, mode string) *v1alpha1.AgentRegistration {
+	return &v1alpha1.AgentRegistration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: v1alpha1.AgentRegistrationKind},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: "1234", Generation: 1},
+		Spec: v1alpha1.AgentRegistrationSpec{
+			Mode:              mode,
+			AllowedNamespaces: []string{"ns1", "ns2"},
+			Labels:            map[string]string{"env": "prod"},
+		},
+	}
+}
+
+func getRegistration(t *testing.T, c *Controller, name string) *v1alpha1.AgentRegistration {
+	t.Helper()
+	u, err := c.dynclient.Resource(v1alpha1.AgentRegistrationResource).Namespace(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	reg, err := v1alpha1.AgentRegistrationFromUnstructured(u)
+	require.NoError(t, err)
+	return reg
+}
+
+func reconcileRegistration(t *testing.T, c *Controller, reg *v1alpha1.AgentRegistration) {
+	t.Helper()
+	c.reconcile(context.Background(), reg)
+}
+
+func Test_ControllerReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Provisions cluster and bootstrap secret", func(t *testing.T) {
+		reg := testRegistration(testAgentName, "managed")
+		c, kubeclient := newTestController(t, reg)
+		reconcileRegistration(t, c, reg)
+
+		status := getRegistration(t, c, testAgentName).Status
+		assert.Equal(t, v1alpha1.RegistrationPhaseProvisioned, status.Phase, status.Message)
+		assert.Equal(t, "cluster-"+testAgentName, status.ClusterSecret)
+		assert.Equal(t, testAgentName+"-bootstrap", status.BootstrapSecret)
+		assert.Equal(t, int64(1), status.ObservedGeneration)
+
+		clusterSecret, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, status.ClusterSecret, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, testAgentName, clusterSecret.Labels[cluster.LabelKeyClusterAgentMapping])
+		assert.Equal(t, "prod", clusterSecret.Labels["env"])
+		assert.Equal(t, "https://"+testResourceProxyAddr+"?agentName="+testAgentName, string(clusterSecret.Data["server"]))
+		require.Len(t, clusterSecret.OwnerReferences, 1)
+		assert.Equal(t, v1alpha1.AgentRegistrationKind, clusterSecret.OwnerReferences[0].Kind)
+
+		bootstrap, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, status.BootstrapSecret, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "managed", string(bootstrap.Data[BootstrapKeyMode]))
+		assert.Equal(t, "ns1,ns2", string(bootstrap.Data[BootstrapKeyAllowedNamespaces]))
+		assert.Equal(t, "principal.example.com", string(bootstrap.Data[BootstrapKeyServerAddress]))
+		assert.Equal(t, "8443", string(bootstrap.Data[BootstrapKeyServerPort]))
+		assert.NotEmpty(t, bootstrap.Data[BootstrapKeyCert])
+		assert.NotEmpty(t, bootstrap.Data[BootstrapKeyKey])
+		assert.NotEmpty(t, bootstrap.Data[BootstrapKeyCA])
+
+		cert, err := tlsutil.TLSCertFromSecret(ctx, kubeclient, testNamespace, status.BootstrapSecret)
+		require.NoError(t, err)
+		assert.NotEmpty(t, cert.Certificate)
+	})
+
+	t.Run("Applies spec changes and keeps credentials", func(t *testing.T) {
+		reg := testRegistration(testAgentName, "managed")
+		c, kubeclient := newTestController(t, reg)
+		reconcileRegistration(t, c, reg)
+		before, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, reg.BootstrapSecret(), metav1.GetOptions{})
+		require.NoError(t, err)
+
+		reg = getRegistration(t, c, testAgentName)
+		reg.Spec.Mode = "autonomous"
+		reg.Spec.Labels = map[string]string{"env": "staging"}
+		reg.Generation = 2
+		reconcileRegistration(t, c, reg)
+
+		after, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, reg.BootstrapSecret(), metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "autonomous", string(after.Data[BootstrapKeyMode]))
+		assert.Equal(t, before.Data[BootstrapKeyCert], after.Data[BootstrapKeyCert])
+		clusterSecret, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, "cluster-"+testAgentName, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "staging", clusterSecret.Labels["env"])
+		assert.Equal(t, int64(2), getRegistration(t, c, testAgentName).Status.ObservedGeneration)
+	})
+
+	t.Run("Manages maintenance mode of the agent", func(t *testing.T) {
+		reg := testRegistration(testAgentName, "managed")
+		enabled := true
+		reg.Spec.Maintenance = &enabled
+		c, kubeclient := newTestController(t, reg)
+		reconcileRegistration(t, c, reg)
+		clusterSecret := func() *corev1.Secret {
+			sec, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, "cluster-"+testAgentName, metav1.GetOptions{})
+			require.NoError(t, err)
+			return sec
+		}
+		assert.Equal(t, "true", clusterSecret().Annotations[cluster.AnnotationKeyMaintenance])
+
+		reg = getRegistration(t, c, testAgentName)
+		disabled := false
+		reg.Spec.Maintenance = &disabled
+		reg.Generation = 2
+		reconcileRegistration(t, c, reg)
+		assert.NotContains(t, clusterSecret().Annotations, cluster.AnnotationKeyMaintenance)
+
+		// Maintenance mode set by other means is kept if the spec doesn't
+		// specify it
+		sec := clusterSecret()
+		sec.Annotations[cluster.AnnotationKeyMaintenance] = "true"
+		_, err := kubeclient.CoreV1().Secrets(testNamespace).Update(ctx, sec, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		reg = getRegistration(t, c, testAgentName)
+		reg.Spec.Maintenance = nil
+		reg.Generation = 3
+		reconcileRegistration(t, c, reg)
+		assert.Equal(t, "true", clusterSecret().Annotations[cluster.AnnotationKeyMaintenance])
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		reg := testRegistration(testAgentName, "invalid")
+		c, kubeclient := newTestController(t, reg)
+		reconcileRegistration(t, c, reg)
+
+		status := getRegistration(t, c, testAgentName).Status
+		assert.Equal(t, v1alpha1.RegistrationPhaseFailed, status.Phase)
+		assert.Contains(t, status.Message, "invalid mode")
+		_, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, "cluster-"+testAgentName, metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Does not touch unmanaged secrets", func(t *testing.T) {
+		reg := testRegistration(testAgentName, "managed")
+		c, kubeclient := newTestController(t, reg)
+		_, err := kubeclient.CoreV1().Secrets(testNamespace).Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-" + testAgentName, Namespace: testNamespace},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+		reconcileRegistration(t, c, reg)
+
+		status := getRegistration(t, c, testAgentName).Status
+		assert.Equal(t, v1alpha1.RegistrationPhaseFailed, status.Phase)
+		assert.Contains(t, status.Message, "not managed")
+		_, err = kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, reg.BootstrapSecret(), metav1.GetOptions{})
+		assert.Error(t, err)
+	})
+}
+
+func Test_ControllerStart(t *testing.T) {
+	reg := testRegistration(testAgentName, "autonomous")
+	c, kubeclient := newTestController(t, reg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, c.Start(ctx))
+	assert.Eventually(t, func() bool {
+		_, err := kubeclient.CoreV1().Secrets(testNamespace).Get(ctx, reg.BootstrapSecret(), metav1.GetOptions{})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
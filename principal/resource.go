@@ -16,6 +16,7 @@ package principal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -23,11 +24,15 @@ import (
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/logging/logfields"
+	logstream "github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi"
 	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/validation"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/transport"
 )
 
 // resourceRequestRegexp is the regexp used to match requests for retrieving a
@@ -35,16 +40,136 @@ import (
 // capture groups. It also supports Kubernetes subresources.
 const resourceRequestRegexp = `^/(?:api|apis|(?:api|apis/(?P<group>[^\/]+))/(?P<version>v[^\/]+)(?:/(?:namespaces/(?P<namespace>[^\/]+)/)?)?(?:(?P<resource>[^\/]+)(?:/(?P<name>[^\/]+)(?:/(?P<subresource>[^\/]+))?)?)?)$`
 
+// resourceRoute returns the resource proxy route for requests for live
+// resources from the Argo CD API.
+func (s *Server) resourceRoute() resourceproxy.Route {
+	return resourceproxy.Route{
+		Name:    "resource",
+		Pattern: resourceRequestRegexp,
+		Methods: []string{"get", "patch", "post", "delete"},
+		Handler: s.refuseWhileDraining(s.processResourceRequest),
+		Docs:    resourceRouteDocs,
+		// Container logs and exec sessions take much longer than other
+		// requests, so they are told apart in the proxy's metrics.
+		MetricLabel: resourceMetricLabel,
+	}
+}
+
+// resourceMetricLabel returns the route label of the proxy's metrics for
+// a request for a live resource
+func resourceMetricLabel(r *http.Request, params resourceproxy.Params) string {
+	switch params.Get("subresource") {
+	case "log":
+		if isFollowRequest(r) {
+			return "resource-logs-follow"
+		}
+		return "resource-logs"
+	case "exec":
+		return "resource-exec"
+	default:
+		return "resource"
+	}
+}
+
 // requestTimeout is the timeout that's being applied to requests for any live
 // resource.
 //
 // TODO(jannfis): Make the timeout configurable
 const requestTimeout = 30 * time.Second
 
+// logRequestTimeout is the timeout applied to requests for static logs, which
+// may take considerably longer than other requests.
+const logRequestTimeout = 6 * requestTimeout
+
+// redisRequestTimeout is the timeout applied to requests proxied to the
+// agent's redis.
+const redisRequestTimeout = 60 * time.Second
+
+// requestIDHeader is the header holding the correlation ID of log requests
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest correlation ID accepted from clients
+const maxRequestIDLength = 128
+
+// logRequestID returns the correlation ID of a log request. The client may
+// send its own in the X-Request-ID header, otherwise a new one is generated.
+func logRequestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
 // processResourceRequest is being executed by the resource proxy once it
 // received a request for a specific resource. It will encapsulate this request
 // into an event and add this event to the target agent's event queue. It will
 // then wait for a response from the agent, which comes in asynchronously.
+// waitForLogStreamClient keeps the handler of a follow log stream alive until
+// the client disconnects or the principal shuts down. When the client
+// disconnects, the agent is asked to tear down the stream.
+func (s *Server) waitForLogStreamClient(r *http.Request, requestUUID, agentName string, logCtx *logrus.Entry) {
+	logCtx = logCtx.WithField("uuid", requestUUID)
+	logCtx.Info("Streaming logs: waiting for client disconnect")
+	select {
+	case <-r.Context().Done():
+		logCtx.Info("Client disconnected; end streaming handler")
+		if !s.isHandedOff() {
+			s.cancelAgentLogStream(requestUUID, agentName, time.Now(), logCtx)
+		}
+	case <-s.drain.started:
+		// Streaming clients resume from where they left off, so we don't
+		// keep them around while shutting down. Streams handed over to the
+		// next instance have been ended already.
+		logCtx.Info("Principal is shutting down; end streaming handler")
+		s.logStream.WriteUnavailable(requestUUID, s.options.shutdownReconnectDelay, "principal is shutting down")
+	}
+}
+
+// waitForStaticLogs waits until the agent has sent the static logs of the
+// given request, and reports a timeout to the client.
+func (s *Server) waitForStaticLogs(w http.ResponseWriter, requestUUID string, reqParams map[string]string, logCtx *logrus.Entry) {
+	// When shutting down, static logs may finish until the grace period is
+	// over.
+	s.drain.staticLogs.Add(1)
+	defer s.drain.staticLogs.Add(-1)
+	logCtx.Info("Static logs: waiting for completion")
+	// for logs, we use a longer timeout
+	completed := make(chan bool, 1)
+	go func() {
+		completed <- s.logStream.WaitForCompletion(requestUUID, logRequestTimeout)
+	}()
+	var ok bool
+	select {
+	case ok = <-completed:
+	case <-s.drain.expired:
+		logCtx.Warn("Principal is shutting down; end static logs")
+		s.logStream.WriteUnavailable(requestUUID, s.options.shutdownReconnectDelay, "principal is shutting down")
+		return
+	}
+	if !ok {
+		logCtx.Warn("Static logs timeout")
+		// Best-effort: RegisterHTTP has usually written HTTP 200 headers for streaming.
+		// SSE clients get the timeout as an error event and buffered Range requests
+		// a gateway timeout. Otherwise, if the client
+		// requested timestamps, make sure our timeout message is timestamp-prefixed,
+		// otherwise Argo CD's PodLogs parser can choke when it tries to parse
+		// "Timeout" as a timestamp.
+		if s.logStream.WriteError(requestUUID, http.StatusGatewayTimeout, "Timeout fetching logs from agent") {
+			logCtx.Debug("Reported timeout to client")
+		} else if strings.EqualFold(reqParams["timestamps"], "true") {
+			_, _ = w.Write([]byte(time.Now().UTC().Format(time.RFC3339Nano) + " Timeout fetching logs from agent\n"))
+		} else {
+			_, _ = w.Write([]byte("Timeout fetching logs from agent\n"))
+		}
+	}
+}
+
 func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request, params resourceproxy.Params) {
 	logCtx := log().WithField("function", "resourceRequester")
 
@@ -119,7 +244,17 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 
 	// Create the event
 	var sentEv *cloudevents.Event
+	// sharedKey is the fingerprint of a static log request
+	var sharedKey string
 	if requestedSubresource == "log" {
+		// The request ID is returned to the client and ends up in the logs
+		// of both principal and agent, so they can be correlated.
+		requestID := logRequestID(r)
+		w.Header().Set(requestIDHeader, requestID)
+		logCtx = logCtx.WithField(logfields.RequestID, requestID)
+		if s.refuseInMaintenance(w, agentName, logCtx) {
+			return
+		}
 		if requestedNamespace == "" || requestedName == "" {
 			logCtx.WithFields(logrus.Fields{
 				"namespace": requestedNamespace,
@@ -130,7 +265,39 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 			http.Error(w, "Missing required parameters: namespace and pod", http.StatusBadRequest)
 			return
 		}
-		sentEv, err = s.events.NewLogRequestEvent(requestedNamespace, requestedName, r.Method, reqParams)
+		// A client reconnecting after a principal upgrade re-attaches to the
+		// log stream the agent continues to send.
+		if isFollowRequest(r) {
+			if requestUUID, ok := s.adoptLogStream(agentName, r); ok {
+				s.resumeLogStream(w, r, requestUUID, agentName, logCtx)
+				return
+			}
+		}
+		// A reconnecting SSE client tells us the timestamp of the last line it
+		// received, so we only request logs from that point on. The kubelet
+		// honors sinceTime with second precision, the remaining duplicates
+		// are dropped by the log stream writer.
+		if logstream.IsEventStreamRequest(r) {
+			if since, ok := logstream.LastEventTime(r); ok {
+				reqParams["sinceTime"] = since.UTC().Format(time.RFC3339)
+				delete(reqParams, "sinceSeconds")
+				delete(reqParams, "tailLines")
+			}
+		}
+		// Static log requests carry our timeout, so the agent gives up on
+		// them when we do. Streaming requests last as long as the client is
+		// connected.
+		var timeout time.Duration
+		if !strings.EqualFold(reqParams["follow"], "true") {
+			timeout = logRequestTimeout
+			// A retried request, e.g. after a browser refresh, shares the
+			// stream of the identical request still in progress.
+			sharedKey = staticLogKey(agentName, requestedNamespace, requestedName, reqParams)
+			if s.joinStaticLogs(w, r, sharedKey, agentName, reqParams, logCtx) {
+				return
+			}
+		}
+		sentEv, err = s.events.NewLogRequestEvent(requestedNamespace, requestedName, r.Method, reqParams, timeout)
 		if err != nil {
 			logCtx.WithFields(logrus.Fields{
 				"namespace": requestedNamespace,
@@ -141,6 +308,22 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		if err := event.SetRequestID(sentEv, requestID); err != nil {
+			logCtx.Errorf("Could not create container log event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// Older agents would silently ignore parameters they don't know
+		// about, so we refuse such requests instead.
+		if s.eventStreamSrv != nil {
+			if v, ok := s.eventStreamSrv.AgentSchemaVersion(agentName); ok {
+				if _, err := event.ForSchemaVersion(sentEv, v); errors.Is(err, event.ErrFieldNotSupported) {
+					logCtx.WithField("agent", agentName).Infof("Rejecting log request: %v", err)
+					http.Error(w, fmt.Sprintf("Agent does not support this request: %v", err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
 	} else {
 		sentEv, err = s.events.NewResourceRequestEvent(gvr, requestedNamespace, requestedName, requestedSubresource, r.Method, reqBody, reqParams)
 		if err != nil {
@@ -150,6 +333,23 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	// Clients acting for a user, e.g. Argo CD syncing with impersonation,
+	// send the user along. The agent may record it in the cluster's audit
+	// log.
+	if err := event.SetRequestedBy(sentEv, r.Header.Get(transport.ImpersonateUserHeader)); err != nil {
+		logCtx.Errorf("Could not create event: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The client gives up on the request after a while, so there's no point
+	// in delivering it later, e.g. once a disconnected agent is back.
+	if requestedSubresource == "log" {
+		event.SetTTL(sentEv, logRequestTimeout)
+	} else {
+		event.SetTTL(sentEv, requestTimeout)
+	}
+
 	// Remember the resource ID of the sent event
 	sentUUID := event.EventID(sentEv)
 
@@ -174,9 +374,11 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		s.logStream.BindAgent(sentUUID, agentName)
 		// Ensure session is cleaned up when handler exits (covers timeout/disconnect cases
 		// where StreamLogs never ran or didn't finalize the session)
 		defer s.logStream.RemoveSession(sentUUID)
+		defer s.activity.beginStream(agentName, streamLogs)()
 
 		// Submit the event to the queue
 		logCtx.Tracef("Submitting event: %v", sentEv)
@@ -186,26 +388,16 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 		isStreaming := strings.EqualFold(reqParams["follow"], "true")
 
 		if isStreaming {
-			// Keep handler alive until client disconnects
-			logCtx.WithField("uuid", string(sentUUID)).Info("Streaming logs: waiting for client disconnect")
-			<-r.Context().Done()
-			logCtx.WithField("uuid", string(sentUUID)).Info("Client disconnected; end streaming handler")
+			defer s.trackLogStream(sentUUID, agentName, r)()
+			defer s.trackResumableLogStream(sentUUID, agentName, sentEv)()
+			s.waitForLogStreamClient(r, sentUUID, agentName, logCtx)
 		} else {
-			// Static logs: wait for completion signal from logStream
-			logCtx.WithField("uuid", string(sentUUID)).Info("Static logs: waiting for completion")
-			// for logs, we use a longer timeout
-			if ok := s.logStream.WaitForCompletion(sentUUID, requestTimeout*6); !ok {
-				logCtx.WithField("uuid", string(sentUUID)).Warn("Static logs timeout")
-				// Best-effort: RegisterHTTP has already written HTTP 200 headers for streaming.
-				// If the client requested timestamps, make sure our timeout message is
-				// timestamp-prefixed, otherwise Argo CD's PodLogs parser can choke when it
-				// tries to parse "Timeout" as a timestamp.
-				if strings.EqualFold(reqParams["timestamps"], "true") {
-					_, _ = w.Write([]byte(time.Now().UTC().Format(time.RFC3339Nano) + " Timeout fetching logs from agent\n"))
-				} else {
-					_, _ = w.Write([]byte("Timeout fetching logs from agent\n"))
-				}
-			}
+			defer s.shareStaticLogs(sharedKey, sentUUID)()
+			// Static logs are requested once more if the agent does not
+			// start streaming them within the hedge delay. Follow streams
+			// last long, so they are never sent twice.
+			defer s.hedgeStaticLogs(sentEv, agentName, logCtx)()
+			s.waitForStaticLogs(w, sentUUID, reqParams, logCtx.WithField("uuid", sentUUID))
 		}
 		// IMPORTANT: do not enter the standard eventCh loop for log requests.
 		return
@@ -232,58 +424,111 @@ func (s *Server) processResourceRequest(w http.ResponseWriter, r *http.Request,
 	ctx, cancel := context.WithTimeout(s.ctx, requestTimeout)
 	defer cancel()
 
+	// A GET request the agent did not answer within the hedge delay is sent
+	// once more, and the first successful response is returned. Only GET
+	// requests are idempotent, so other requests are never sent twice.
+	var hedgeTimer <-chan time.Time
+	if s.options.resourceProxyHedgeDelay > 0 && strings.EqualFold(r.Method, http.MethodGet) {
+		t := time.NewTimer(s.options.resourceProxyHedgeDelay)
+		defer t.Stop()
+		hedgeTimer = t.C
+	}
+	var hedgeUUID string
+	var hedgeCh <-chan *cloudevents.Event
+
 	// The response is being read through a channel that is kept open and
 	// written to by the resource proxy.
 	for {
+		var rcvdEv *cloudevents.Event
+		var ok bool
+		var expUUID string
 		select {
 		case <-ctx.Done():
+			if s.isDraining() {
+				log().Infof("Principal is shutting down, closing proxy connection.")
+				s.writeUnavailable(w)
+				return
+			}
 			log().Infof("Timeout communicating to the agent, closing proxy connection.")
 			w.WriteHeader(http.StatusGatewayTimeout)
 			return
-		case rcvdEv, ok := <-eventCh:
-			// Channel was closed. Bail out.
-			if !ok {
-				log().Info("EventQueue has closed the channel")
-				w.WriteHeader(http.StatusGatewayTimeout)
-				return
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			hedgeUUID, hedgeCh = s.hedgeResourceRequest(sentEv, agentName, logCtx)
+			if hedgeUUID != "" {
+				defer func() {
+					if err := s.resourceProxy.StopTracking(hedgeUUID); err != nil {
+						logCtx.Warnf("Could not untrack %s: %v", hedgeUUID, err)
+					}
+				}()
 			}
+			continue
+		case rcvdEv, ok = <-eventCh:
+			expUUID = sentUUID
+		case rcvdEv, ok = <-hedgeCh:
+			expUUID = hedgeUUID
+		default:
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
 
-			// Make sure that we have the right response event. This should
-			// usually not happen, because the resource proxy has a mapping
-			// of request to response, but we'll be vigilant.
-			rcvdUUID := event.EventID(rcvdEv)
-			if rcvdUUID != sentUUID {
-				log().Error("Received mismatching UUID in response")
-				w.WriteHeader(http.StatusForbidden)
-				return
-			}
+		// Channel was closed. Bail out.
+		if !ok {
+			log().Info("EventQueue has closed the channel")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
 
-			// Get the resource out of the event
-			resp := &event.ResourceResponse{}
-			err = rcvdEv.DataAs(resp)
-			if err != nil {
-				logCtx.WithError(err).Error("Could not get data from event")
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		// Make sure that we have the right response event. This should
+		// usually not happen, because the resource proxy has a mapping
+		// of request to response, but we'll be vigilant.
+		rcvdUUID := event.EventID(rcvdEv)
+		if rcvdUUID != expUUID {
+			log().Error("Received mismatching UUID in response")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 
-			log().Infof("Status: %d", resp.Status)
-			if resp.Status == http.StatusOK {
-				// We are good to send the response to the caller
-				log().Info("Writing resource to caller")
-				w.Header().Set("Content-type", "application/json")
-				w.WriteHeader(http.StatusOK)
-				_, err := w.Write([]byte(resp.Resource))
-				if err != nil {
-					log().Errorf("Could not write response to client: %v", err)
-				}
+		// Get the resource out of the event
+		resp := &event.ResourceResponse{}
+		err = rcvdEv.DataAs(resp)
+		if err != nil {
+			logCtx.WithError(err).Error("Could not get data from event")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// A request failing on the agent's side may still succeed as the
+		// other copy of a hedged request.
+		if resp.Status >= http.StatusInternalServerError && hedgeCh != nil {
+			logCtx.WithField("uuid", rcvdUUID).Infof("Hedged request failed with status %d, waiting for the other copy", resp.Status)
+			if rcvdUUID == sentUUID {
+				eventCh = nil
 			} else {
-				w.WriteHeader(resp.Status)
+				hedgeCh = nil
 			}
-			return
-		default:
-			time.Sleep(100 * time.Millisecond)
+			if eventCh != nil || hedgeCh != nil {
+				continue
+			}
+		}
+		if rcvdUUID == hedgeUUID && resp.Status < http.StatusInternalServerError && s.metrics != nil {
+			s.metrics.ResourceProxy.HedgedRequests.WithLabelValues("won").Inc()
+		}
+
+		log().Infof("Status: %d", resp.Status)
+		if resp.Status == http.StatusOK {
+			// We are good to send the response to the caller
+			log().Info("Writing resource to caller")
+			w.Header().Set("Content-type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(resp.Resource))
+			if err != nil {
+				log().Errorf("Could not write response to client: %v", err)
+			}
+		} else {
+			w.WriteHeader(resp.Status)
 		}
+		return
 	}
 }
 
@@ -312,6 +557,8 @@ func (s *Server) sendSynchronousRedisMessageToAgent(agentName string, connection
 		return nil
 	}
 
+	event.SetTTL(sentEv, redisRequestTimeout)
+
 	// Remember the resource ID of the sent event
 	sentEventUUID := event.EventID(sentEv)
 
@@ -333,8 +580,8 @@ func (s *Server) sendSynchronousRedisMessageToAgent(agentName string, connection
 	logCtx.Tracef("Submitting event: %v", sentEv)
 	q.Add(sentEv)
 
-	// Wait for the event from the agent (timeout after 60 seconds)
-	ctx, cancel := context.WithTimeout(s.ctx, 60*time.Second)
+	// Wait for the event from the agent
+	ctx, cancel := context.WithTimeout(s.ctx, redisRequestTimeout)
 	defer cancel()
 
 	// The response is being read through a channel that is kept open and
@@ -411,19 +658,26 @@ func (s *Server) extractAgentFromAuth(r *http.Request) (string, error) {
 			return "", fmt.Errorf("could not get subject from token: %v", err)
 		}
 		logCtx.WithField("agent", subject).Info("Successfully authenticated via bearer token")
+		resourceproxy.SetAccessLogAgent(r, subject)
 		return subject, nil
 	}
 
-	// Method 2: Extract agent name from TLS client certificate CN
-	// Used by manually created cluster secrets
+	// Method 2: Extract agent name from TLS client certificate, by default
+	// its CN. Used by manually created cluster secrets
 	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
 		cert := r.TLS.PeerCertificates[0]
-		agentName := cert.Subject.CommonName
-		if agentName != "" {
-			logCtx.WithField("agent", agentName).Info("Successfully authenticated via TLS client certificate CN")
+		agentName, identity, err := s.clientCertIdentity().AgentID(cert)
+		if err == nil {
+			logCtx.WithField("agent", agentName).Infof("Successfully authenticated via TLS client certificate identity '%s'", identity)
+			chain := r.TLS.PeerCertificates
+			if len(r.TLS.VerifiedChains) > 0 {
+				chain = r.TLS.VerifiedChains[0]
+			}
+			s.auditClientCertificate(certListenerResourceProxy, agentName, chain)
+			resourceproxy.SetAccessLogAgent(r, agentName)
 			return agentName, nil
 		}
-		logCtx.Warn("TLS client certificate has empty Common Name")
+		logCtx.WithError(err).Warn("Could not get agent name from TLS client certificate")
 	}
 
 	return "", fmt.Errorf("no authorization found")
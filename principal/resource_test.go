@@ -10,14 +10,22 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/eventstreamapi"
+	"github.com/argoproj-labs/argocd-agent/pkg/api/grpc/logstreamapi"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi/mock"
 	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
 	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -39,6 +47,42 @@ func newResourceTestServer(t *testing.T) *Server {
 	return s
 }
 
+// hedgeStream receives the events written to an agent's event stream
+type hedgeStream struct {
+	ch chan *eventstreamapi.Event
+}
+
+func (s *hedgeStream) Send(ev *eventstreamapi.Event) error {
+	s.ch <- ev
+	return nil
+}
+
+func (s *hedgeStream) Context() context.Context {
+	return context.Background()
+}
+
+// hedgedEvents returns the events the principal writes to the agent's event
+// stream directly, i.e. the hedged copies of requests.
+func hedgedEvents(t *testing.T, s *Server) func() *cloudevents.Event {
+	t.Helper()
+	stream := &hedgeStream{ch: make(chan *eventstreamapi.Event, 10)}
+	ew := event.NewEventWriter("agent", stream)
+	s.eventWriters.Add("agent", ew)
+	go ew.SendWaitingEvents(t.Context())
+	return func() *cloudevents.Event {
+		t.Helper()
+		select {
+		case pev := <-stream.ch:
+			ev, err := format.FromProto(pev.Event)
+			require.NoError(t, err)
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no hedged event was sent")
+			return nil
+		}
+	}
+}
+
 func Test_resourceRequester(t *testing.T) {
 	t.Run("Successfully request a resource", func(t *testing.T) {
 		s := newResourceTestServer(t)
@@ -82,6 +126,152 @@ func Test_resourceRequester(t *testing.T) {
 		assert.Equal(t, "foo", string(body))
 	})
 
+	t.Run("Hedged request answers first", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.options.resourceProxyHedgeDelay = 10 * time.Millisecond
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		nextHedged := hedgedEvents(t, s)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent"}}},
+		}
+		w := httptest.NewRecorder()
+		ch := make(chan interface{})
+		go func() {
+			s.processResourceRequest(w, r, resourceproxy.NewParams())
+			ch <- 1
+		}()
+
+		// The agent does not answer the first request, so the principal
+		// sends a copy of it.
+		sendq := s.queues.SendQ("agent")
+		ev, _ := sendq.Get()
+		hedged := nextHedged()
+		require.NotEqual(t, event.EventID(ev), event.EventID(hedged))
+		// The copy skips the send queue
+		assert.Equal(t, 0, sendq.Len())
+		_, sendCh := s.resourceProxy.Tracked(event.EventID(hedged))
+		require.NotNil(t, sendCh)
+		sendCh <- s.events.NewResourceResponseEvent(event.EventID(hedged), 200, "foo")
+		<-ch
+		assert.Equal(t, 200, w.Result().StatusCode)
+		body, err := io.ReadAll(w.Result().Body)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", string(body))
+
+		// Neither request is tracked anymore
+		_, origCh := s.resourceProxy.Tracked(event.EventID(ev))
+		assert.Nil(t, origCh)
+		_, sendCh = s.resourceProxy.Tracked(event.EventID(hedged))
+		assert.Nil(t, sendCh)
+	})
+
+	t.Run("Hedged request waits for the other copy on failure", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.options.resourceProxyHedgeDelay = 10 * time.Millisecond
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		nextHedged := hedgedEvents(t, s)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent"}}},
+		}
+		w := httptest.NewRecorder()
+		ch := make(chan interface{})
+		go func() {
+			s.processResourceRequest(w, r, resourceproxy.NewParams())
+			ch <- 1
+		}()
+
+		ev, _ := s.queues.SendQ("agent").Get()
+		hedged := nextHedged()
+		_, hedgedCh := s.resourceProxy.Tracked(event.EventID(hedged))
+		require.NotNil(t, hedgedCh)
+		hedgedCh <- s.events.NewResourceResponseEvent(event.EventID(hedged), http.StatusServiceUnavailable, "")
+		_, origCh := s.resourceProxy.Tracked(event.EventID(ev))
+		require.NotNil(t, origCh)
+		origCh <- s.events.NewResourceResponseEvent(event.EventID(ev), 200, "foo")
+		<-ch
+		assert.Equal(t, 200, w.Result().StatusCode)
+	})
+
+	t.Run("Only GET requests are hedged", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.options.resourceProxyHedgeDelay = 10 * time.Millisecond
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		r := httptest.NewRequest("DELETE", "/", nil)
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent"}}},
+		}
+		w := httptest.NewRecorder()
+		ch := make(chan interface{})
+		go func() {
+			s.processResourceRequest(w, r, resourceproxy.NewParams())
+			ch <- 1
+		}()
+
+		sendq := s.queues.SendQ("agent")
+		ev, _ := sendq.Get()
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, 0, sendq.Len())
+		_, sendCh := s.resourceProxy.Tracked(event.EventID(ev))
+		require.NotNil(t, sendCh)
+		sendCh <- s.events.NewResourceResponseEvent(event.EventID(ev), 200, "")
+		<-ch
+		assert.Equal(t, 200, w.Result().StatusCode)
+	})
+
+	t.Run("Static log request is hedged", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.options.resourceProxyHedgeDelay = 10 * time.Millisecond
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		nextHedged := hedgedEvents(t, s)
+		r := httptest.NewRequest("GET", "/api/v1/namespaces/ns/pods/pod/log", nil)
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent"}}},
+		}
+		params := resourceproxy.NewParams()
+		params.Set("version", "v1")
+		params.Set("resource", "pods")
+		params.Set("namespace", "ns")
+		params.Set("name", "pod")
+		params.Set("subresource", "log")
+		w := httptest.NewRecorder()
+		ch := make(chan interface{})
+		go func() {
+			s.processResourceRequest(w, r, params)
+			ch <- 1
+		}()
+
+		// The agent does not start streaming the logs, so the principal
+		// sends a copy of the request, and the copy's stream answers.
+		ev, _ := s.queues.SendQ("agent").Get()
+		hedged := nextHedged()
+		require.NotEqual(t, event.EventID(ev), event.EventID(hedged))
+		logReq, err := event.New(hedged, event.TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		assert.Equal(t, event.EventID(hedged), logReq.UUID)
+
+		agentCtx := context.WithValue(context.Background(), types.ContextAgentIdentifier, "agent")
+		stream := func(requestUUID string) *mock.MockLogStreamServer {
+			ms := mock.NewMockLogStreamServer(agentCtx)
+			ms.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Data: []byte("line 1\n")})
+			ms.AddRecvData(&logstreamapi.LogStreamData{RequestUuid: requestUUID, Eof: true})
+			return ms
+		}
+		require.NoError(t, s.logStream.StreamLogs(stream(event.EventID(hedged))))
+		<-ch
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assert.Equal(t, "line 1\n", w.Body.String())
+
+		// The original request's stream comes too late
+		err = s.logStream.StreamLogs(stream(event.EventID(ev)))
+		assert.Error(t, err)
+	})
+
 	t.Run("No TLS data in request", func(t *testing.T) {
 		s := newResourceTestServer(t)
 		r := httptest.NewRequest("GET", "/", nil)
@@ -255,6 +445,58 @@ func Test_resourceRequester(t *testing.T) {
 
 }
 
+func Test_logRequestID(t *testing.T) {
+	newLogRequest := func(ctx context.Context, requestID string) *http.Request {
+		r := httptest.NewRequest("GET", "/api/v1/namespaces/ns/pods/pod/log?follow=true", nil).WithContext(ctx)
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent"}}},
+		}
+		if requestID != "" {
+			r.Header.Set(requestIDHeader, requestID)
+		}
+		return r
+	}
+	logParams := func() resourceproxy.Params {
+		params := resourceproxy.NewParams()
+		params.Set("version", "v1")
+		params.Set("resource", "pods")
+		params.Set("namespace", "ns")
+		params.Set("name", "pod")
+		params.Set("subresource", "log")
+		return params
+	}
+
+	t.Run("Client's request ID is passed to the agent and returned", func(t *testing.T) {
+		s := newResourceTestServer(t)
+		s.eventStreamSrv.MarkConnected("agent")
+		defer s.eventStreamSrv.MarkDisconnected("agent")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := httptest.NewRecorder()
+		ch := make(chan interface{})
+		go func() {
+			s.processResourceRequest(w, newLogRequest(ctx, "support-1234"), logParams())
+			ch <- 1
+		}()
+		ev, shutdown := s.queues.SendQ("agent").Get()
+		require.False(t, shutdown)
+		logReq, err := event.New(ev, event.TargetContainerLog).ContainerLogRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "support-1234", logReq.RequestID)
+		cancel()
+		<-ch
+		assert.Equal(t, "support-1234", w.Header().Get(requestIDHeader))
+	})
+
+	t.Run("Request ID is generated", func(t *testing.T) {
+		for _, id := range []string{"", "with space", strings.Repeat("a", maxRequestIDLength+1)} {
+			generated := logRequestID(newLogRequest(context.Background(), id))
+			assert.NotEqual(t, id, generated)
+			assert.NoError(t, uuid.Validate(generated))
+		}
+	})
+}
+
 func Test_resourceRegexp(t *testing.T) {
 	tc := []struct {
 		url       string
@@ -303,6 +545,29 @@ func Test_resourceRegexp(t *testing.T) {
 	}
 }
 
+func Test_resourceMetricLabel(t *testing.T) {
+	for _, tt := range []struct {
+		url   string
+		label string
+	}{
+		{"/api/v1/namespaces/foo/pods/bar", "resource"},
+		{"/api/v1/namespaces/foo/pods", "resource"},
+		{"/api/v1/namespaces/foo/pods/bar/log", "resource-logs"},
+		{"/api/v1/namespaces/foo/pods/bar/log?follow=true", "resource-logs-follow"},
+		{"/api/v1/namespaces/foo/pods/bar/exec?command=sh", "resource-exec"},
+	} {
+		t.Run(tt.url, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			re := regexp.MustCompile(resourceRequestRegexp)
+			matches := re.FindStringSubmatch(r.URL.Path)
+			require.NotEmpty(t, matches)
+			params := resourceproxy.NewParams()
+			params.Set("subresource", matches[re.SubexpIndex("subresource")])
+			assert.Equal(t, tt.label, resourceMetricLabel(r, params))
+		})
+	}
+}
+
 func Test_extractAgentFromAuth(t *testing.T) {
 	t.Run("Extracts agent from valid bearer token", func(t *testing.T) {
 		s := newResourceTestServer(t)
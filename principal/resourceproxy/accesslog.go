@@ -0,0 +1,173 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourceproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of the proxy's access log
+type AccessLogFormat string
+
+const (
+	// AccessLogFormatJSON writes one JSON object per request
+	AccessLogFormatJSON AccessLogFormat = "json"
+	// AccessLogFormatCombined writes the Apache combined log format, with
+	// the agent's name as the remote user
+	AccessLogFormatCombined AccessLogFormat = "combined"
+)
+
+// ParseAccessLogFormat parses the name of an access log format
+func ParseAccessLogFormat(format string) (AccessLogFormat, error) {
+	switch f := AccessLogFormat(format); f {
+	case AccessLogFormatJSON, AccessLogFormatCombined:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown access log format %q, must be one of: json, combined", format)
+	}
+}
+
+// AccessLogEntry is a single request in the access log
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Agent      string    `json:"agent,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"durationMs"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+}
+
+// accessLog writes the access log of the proxy, separate from its
+// application log
+type accessLog struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format AccessLogFormat
+}
+
+func (l *accessLog) write(e *AccessLogEntry) error {
+	var line []byte
+	switch l.format {
+	case AccessLogFormatCombined:
+		line = []byte(e.combined())
+	default:
+		var err error
+		line, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.out.Write(line)
+	return err
+}
+
+// combined returns the entry in the Apache combined log format
+func (e *AccessLogEntry) combined() string {
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s %q %q\n",
+		dash(host), dash(e.Agent), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.URI, e.Proto, e.Status, size, dash(e.Referer), dash(e.UserAgent))
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type accessLogKey struct{}
+
+// SetAccessLogAgent records in the access log the name of the agent a
+// request was authenticated for
+func SetAccessLogAgent(r *http.Request, agent string) {
+	if e, ok := r.Context().Value(accessLogKey{}).(*AccessLogEntry); ok {
+		e.Agent = agent
+	}
+}
+
+// withAccessLog returns the request carrying an access log entry, which
+// handlers may complete
+func withAccessLog(r *http.Request, e *AccessLogEntry) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), accessLogKey{}, e))
+}
+
+// accessLogWriter records status and size of a response. Log streams need
+// the Flusher, terminal sessions hijack the connection.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
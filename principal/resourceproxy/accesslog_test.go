@@ -0,0 +1,87 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourceproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AccessLog(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request, params Params) {
+		SetAccessLogAgent(r, "agent-1")
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "log streams need to flush")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	}
+	newProxy := func(t *testing.T, out *bytes.Buffer, format AccessLogFormat) *ResourceProxy {
+		p, err := New("127.0.0.1:8080",
+			WithRoutes(Route{Name: "test", Pattern: "^/test$", Methods: []string{"get"}, Handler: handler}),
+			WithAccessLog(out, format),
+		)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("JSON format", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		p := newProxy(t, out, AccessLogFormatJSON)
+		r := httptest.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set("User-Agent", "argocd-server")
+		p.proxyHandler(httptest.NewRecorder(), r)
+
+		e := AccessLogEntry{}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &e))
+		assert.Equal(t, "agent-1", e.Agent)
+		assert.Equal(t, "test", e.Route)
+		assert.Equal(t, http.MethodGet, e.Method)
+		assert.Equal(t, "/test", e.URI)
+		assert.Equal(t, http.StatusCreated, e.Status)
+		assert.Equal(t, int64(5), e.Bytes)
+		assert.Equal(t, "argocd-server", e.UserAgent)
+		assert.Equal(t, r.RemoteAddr, e.RemoteAddr)
+	})
+
+	t.Run("Combined format", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		p := newProxy(t, out, AccessLogFormatCombined)
+		p.proxyHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+		assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - agent-1 \[[^\]]+\] "GET /test HTTP/1\.1" 201 5 "-" "-"\n$`), out.String())
+	})
+
+	t.Run("Unmatched requests are logged", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		p := newProxy(t, out, AccessLogFormatJSON)
+		p.proxyHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/other", nil))
+		e := AccessLogEntry{}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &e))
+		assert.Empty(t, e.Agent)
+		assert.Empty(t, e.Route)
+		assert.Equal(t, http.StatusBadRequest, e.Status)
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		_, err := New("127.0.0.1:8080", WithAccessLog(&bytes.Buffer{}, "apache"))
+		assert.Error(t, err)
+	})
+}
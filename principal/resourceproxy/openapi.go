@@ -0,0 +1,329 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourceproxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the generated
+// documents conform to.
+const openAPIVersion = "3.1.0"
+
+// OperationDoc documents an operation of a route for the OpenAPI definition
+// of the proxy. A route may have several operations, e.g. for subresources
+// matched by the same pattern.
+type OperationDoc struct {
+	// Path is the templated path of the operation, e.g.
+	// /api/v1/namespaces/{namespace}/pods/{name}/log. If empty, the path is
+	// derived from the route's pattern.
+	Path string
+	// Methods are the HTTP methods of the operation. If empty, the methods
+	// of the route are used.
+	Methods []string
+	// OperationID identifies the operation. If empty, it is derived from the
+	// method and the route's name.
+	OperationID string
+	Summary     string
+	Description string
+	// Query and Headers are the request's parameters. Path parameters are
+	// derived from the path.
+	Query   []ParamDoc
+	Headers []ParamDoc
+	// Responses are the operation's responses. Responses common to all
+	// proxied requests are added unless documented here.
+	Responses []ResponseDoc
+}
+
+// ParamDoc documents a query or header parameter of an operation
+type ParamDoc struct {
+	Name        string
+	Description string
+	// Type is the JSON schema type of the parameter, string if empty
+	Type     string
+	Required bool
+}
+
+// ResponseDoc documents a response of an operation
+type ResponseDoc struct {
+	Status      int
+	Description string
+	// ContentTypes are the media types the response may have
+	ContentTypes []string
+}
+
+// OpenAPI is an OpenAPI document. Only the parts of the specification needed
+// to describe the proxy's HTTP surface are modelled.
+type OpenAPI struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       OpenAPIInfo           `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components OpenAPIComponents     `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+	Tags       []OpenAPITag          `json:"tags,omitempty"`
+}
+
+// OpenAPIInfo holds the metadata of an OpenAPI document
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPITag groups operations of an OpenAPI document
+type OpenAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIComponents holds the reusable objects of an OpenAPI document
+type OpenAPIComponents struct {
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is an authentication method accepted by the API
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// PathItem maps lower case HTTP methods to the operations of a path
+type PathItem map[string]*Operation
+
+// Operation is a single API operation on a path
+type Operation struct {
+	OperationID string                 `json:"operationId"`
+	Summary     string                 `json:"summary,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	Parameters  []Parameter            `json:"parameters,omitempty"`
+	Responses   map[string]Response    `json:"responses"`
+	Security    *[]map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a parameter of an operation
+type Parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      Schema `json:"schema"`
+}
+
+// Schema is a JSON schema of a parameter or response
+type Schema struct {
+	Type   string `json:"type,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the content of a response in a specific media type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// ProxyTag is the tag of all operations generated from the proxy's routes
+const ProxyTag = "resource-proxy"
+
+// commonResponses are the responses every proxied request may receive,
+// regardless of the route handling it.
+var commonResponses = []ResponseDoc{
+	{Status: http.StatusUnauthorized, Description: "The client's credentials were rejected", ContentTypes: []string{"text/plain"}},
+	{Status: http.StatusMethodNotAllowed, Description: "The method is not allowed for the route", ContentTypes: []string{"text/plain"}},
+	{Status: http.StatusBadGateway, Description: "The agent is not connected to the principal", ContentTypes: []string{"text/plain"}},
+	{Status: http.StatusServiceUnavailable, Description: "The principal is shutting down", ContentTypes: []string{"text/plain"}},
+	{Status: http.StatusGatewayTimeout, Description: "The agent did not respond in time", ContentTypes: []string{"text/plain"}},
+}
+
+// NewOpenAPI returns an empty OpenAPI document, in which clients can
+// authenticate with a resource proxy token or a TLS client certificate.
+func NewOpenAPI(info OpenAPIInfo) *OpenAPI {
+	return &OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+		Components: OpenAPIComponents{
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearer": {
+					Type:         "http",
+					Scheme:       "bearer",
+					BearerFormat: "JWT",
+					Description:  "Resource proxy token, whose subject is the name of the agent",
+				},
+				"clientCertificate": {
+					Type:        "mutualTLS",
+					Description: "TLS client certificate, whose identity is the name of the agent",
+				},
+			},
+		},
+		Security: []map[string][]string{{"bearer": {}}, {"clientCertificate": {}}},
+	}
+}
+
+// AddOperation adds op for method on path to the document. If op has no
+// responses, a plain 200 response is added.
+func (d *OpenAPI) AddOperation(path, method string, op *Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = PathItem{}
+		d.Paths[path] = item
+	}
+	if len(op.Responses) == 0 {
+		op.Responses = map[string]Response{"200": {Description: http.StatusText(http.StatusOK)}}
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// AddRoutes adds the operations of routes to the document. Routes without
+// documentation get a generic operation on the path derived from their
+// pattern. Routes whose pattern cannot be expressed as a templated path are
+// skipped and returned, so that the caller may report them.
+func (d *OpenAPI) AddRoutes(routes ...Route) []Route {
+	skipped := []Route{}
+	for _, route := range routes {
+		docs := route.Docs
+		if len(docs) == 0 {
+			docs = []OperationDoc{{Summary: fmt.Sprintf("Requests matching route %s", route.Name)}}
+		}
+		for i, doc := range docs {
+			path := doc.Path
+			if path == "" {
+				var ok bool
+				if path, ok = PathTemplate(route.Pattern); !ok {
+					skipped = append(skipped, route)
+					break
+				}
+			}
+			methods := doc.Methods
+			if len(methods) == 0 {
+				methods = route.Methods
+			}
+			if len(methods) == 0 {
+				methods = []string{http.MethodGet}
+			}
+			for _, method := range methods {
+				id := doc.OperationID
+				if id == "" {
+					id = strings.ToLower(method) + "_" + route.Name
+					if len(docs) > 1 {
+						id += "_" + strconv.Itoa(i)
+					}
+				} else if len(methods) > 1 {
+					id = strings.ToLower(method) + "_" + id
+				}
+				d.AddOperation(path, method, doc.operation(id, path))
+			}
+		}
+	}
+	return skipped
+}
+
+// operation builds the OpenAPI operation for doc on path
+func (doc OperationDoc) operation(id, path string) *Operation {
+	op := &Operation{
+		OperationID: id,
+		Summary:     doc.Summary,
+		Description: doc.Description,
+		Tags:        []string{ProxyTag},
+		Responses:   map[string]Response{},
+	}
+	for _, name := range pathParams(path) {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: Schema{Type: "string"}})
+	}
+	for _, p := range doc.Query {
+		op.Parameters = append(op.Parameters, p.parameter("query"))
+	}
+	for _, p := range doc.Headers {
+		op.Parameters = append(op.Parameters, p.parameter("header"))
+	}
+	responses := doc.Responses
+	if len(responses) == 0 {
+		responses = []ResponseDoc{{Status: http.StatusOK, Description: http.StatusText(http.StatusOK)}}
+	}
+	for _, r := range append(responses, commonResponses...) {
+		code := strconv.Itoa(r.Status)
+		if _, ok := op.Responses[code]; ok {
+			continue
+		}
+		op.Responses[code] = r.response()
+	}
+	return op
+}
+
+func (p ParamDoc) parameter(in string) Parameter {
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+	return Parameter{Name: p.Name, In: in, Description: p.Description, Required: p.Required, Schema: Schema{Type: typ}}
+}
+
+func (r ResponseDoc) response() Response {
+	resp := Response{Description: r.Description}
+	if resp.Description == "" {
+		resp.Description = http.StatusText(r.Status)
+	}
+	if len(r.ContentTypes) > 0 {
+		resp.Content = map[string]MediaType{}
+		for _, ct := range r.ContentTypes {
+			resp.Content[ct] = MediaType{}
+		}
+	}
+	return resp
+}
+
+// namedSegmentRegexp matches a named submatch capturing a single path
+// segment, as used in route patterns.
+var namedSegmentRegexp = regexp.MustCompile(`\(\?P<([a-zA-Z_][a-zA-Z0-9_]*)>\[\^\\?/\]\+\)`)
+
+// pathParamRegexp matches the parameters of a templated path
+var pathParamRegexp = regexp.MustCompile(`\{([^}]+)\}`)
+
+// PathTemplate converts a route pattern to a templated path, e.g.
+// ^/supportbundle/applications/(?P<name>[^\/]+)$ to
+// /supportbundle/applications/{name}. It returns false if the pattern
+// contains constructs other than literal path segments and named segment
+// submatches.
+func PathTemplate(pattern string) (string, bool) {
+	p := strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+	p = namedSegmentRegexp.ReplaceAllString(p, "{$1}")
+	p = strings.ReplaceAll(p, `\/`, "/")
+	p = strings.ReplaceAll(p, `\.`, ".")
+	p = strings.ReplaceAll(p, `\-`, "-")
+	if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, `\()[]?*+|^$`) {
+		return "", false
+	}
+	return p, true
+}
+
+// pathParams returns the names of the parameters of a templated path
+func pathParams(path string) []string {
+	names := []string{}
+	for _, m := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
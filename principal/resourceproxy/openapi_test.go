@@ -0,0 +1,90 @@
+package resourceproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PathTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		path    string
+		ok      bool
+	}{
+		{`^/version$`, "/version", true},
+		{`^/supportbundle/applications/(?P<name>[^\/]+)$`, "/supportbundle/applications/{name}", true},
+		{`^/a/(?P<ns>[^/]+)/b/(?P<name>[^/]+)$`, "/a/{ns}/b/{name}", true},
+		{`^/(?:api|apis)/(?P<version>v[^\/]+)$`, "", false},
+		{`^/logs/.*$`, "", false},
+		{`version`, "", false},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			path, ok := PathTemplate(tc.pattern)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.path, path)
+		})
+	}
+}
+
+func Test_OpenAPI(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request, params Params) {}
+
+	t.Run("Documented route", func(t *testing.T) {
+		doc := NewOpenAPI(OpenAPIInfo{Title: "test", Version: "v1"})
+		skipped := doc.AddRoutes(Route{
+			Name:    "bundle",
+			Pattern: `^/bundle/(?P<name>[^\/]+)$`,
+			Methods: []string{"get"},
+			Handler: noop,
+			Docs: []OperationDoc{{
+				OperationID: "bundle",
+				Query:       []ParamDoc{{Name: "limit", Type: "integer"}},
+				Headers:     []ParamDoc{{Name: "X-Request-ID"}},
+				Responses:   []ResponseDoc{{Status: http.StatusOK, ContentTypes: []string{"application/gzip"}}, {Status: http.StatusBadGateway, Description: "custom"}},
+			}},
+		})
+		assert.Empty(t, skipped)
+		op := doc.Paths["/bundle/{name}"]["get"]
+		require.NotNil(t, op)
+		assert.Equal(t, "bundle", op.OperationID)
+		assert.Equal(t, []string{ProxyTag}, op.Tags)
+		require.Len(t, op.Parameters, 3)
+		assert.Equal(t, Parameter{Name: "name", In: "path", Required: true, Schema: Schema{Type: "string"}}, op.Parameters[0])
+		assert.Equal(t, Parameter{Name: "limit", In: "query", Schema: Schema{Type: "integer"}}, op.Parameters[1])
+		assert.Equal(t, "header", op.Parameters[2].In)
+		assert.Contains(t, op.Responses["200"].Content, "application/gzip")
+		assert.Equal(t, "custom", op.Responses["502"].Description)
+		assert.Contains(t, op.Responses, "401")
+		assert.Contains(t, op.Responses, "504")
+	})
+
+	t.Run("Undocumented route", func(t *testing.T) {
+		doc := NewOpenAPI(OpenAPIInfo{Title: "test", Version: "v1"})
+		skipped := doc.AddRoutes(Route{Name: "plugin", Pattern: `^/plugin/(?P<id>[^/]+)$`, Methods: []string{"get", "post"}, Handler: noop})
+		assert.Empty(t, skipped)
+		item := doc.Paths["/plugin/{id}"]
+		require.Len(t, item, 2)
+		assert.Equal(t, "get_plugin", item["get"].OperationID)
+		assert.Equal(t, "post_plugin", item["post"].OperationID)
+		assert.Contains(t, item["get"].Responses, "200")
+	})
+
+	t.Run("Route with complex pattern is skipped", func(t *testing.T) {
+		doc := NewOpenAPI(OpenAPIInfo{Title: "test", Version: "v1"})
+		skipped := doc.AddRoutes(Route{Name: "complex", Pattern: `^/(?:a|b)$`, Handler: noop})
+		require.Len(t, skipped, 1)
+		assert.Equal(t, "complex", skipped[0].Name)
+		assert.Empty(t, doc.Paths)
+	})
+
+	t.Run("Docs are kept by the registry", func(t *testing.T) {
+		r := NewRegistry()
+		require.NoError(t, r.Register(Route{Name: "foo", Pattern: "^/foo$", Handler: noop, Docs: []OperationDoc{{Summary: "foo"}}}))
+		routes := r.Routes()
+		require.Len(t, routes, 1)
+		assert.Equal(t, "foo", routes[0].Docs[0].Summary)
+	})
+}
@@ -16,9 +16,13 @@ package resourceproxy
 
 import (
 	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
 	"regexp"
 
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
 )
 
 // ResourceProxyOption is an option setting callback function
@@ -32,19 +36,35 @@ func WithTLSConfig(t *tls.Config) ResourceProxyOption {
 	}
 }
 
-// WithRequestMatcher adds a request matcher to the proxy. The handler fn will
-// be executed when pattern matches on the request URI's path.
-func WithRequestMatcher(pattern string, methods []string, fn HandlerFunc) ResourceProxyOption {
+// WithUnixSocket makes the proxy listen on the unix domain socket at path in
+// addition to its TCP address, e.g. for an Argo CD API server in the same pod.
+// Connections to the socket don't use TLS, so clients must authenticate with
+// a bearer token. mode sets the permissions of the socket file.
+func WithUnixSocket(path string, mode os.FileMode) ResourceProxyOption {
 	return func(p *ResourceProxy) error {
-		rm, err := matcher(pattern, methods, fn)
-		if err != nil {
-			return err
+		if mode&^os.ModePerm != 0 {
+			return fmt.Errorf("invalid socket mode %o", mode)
 		}
-		p.interceptors = append(p.interceptors, rm)
+		p.socketPath = path
+		p.socketMode = mode
 		return nil
 	}
 }
 
+// WithRequestMatcher adds a request matcher to the proxy. The handler fn will
+// be executed when pattern matches on the request URI's path. The route is
+// named after its pattern.
+func WithRequestMatcher(pattern string, methods []string, fn HandlerFunc) ResourceProxyOption {
+	return WithRoutes(Route{Name: pattern, Pattern: pattern, Methods: methods, Handler: fn})
+}
+
+// WithRoutes adds routes to the proxy, which are matched in the given order
+func WithRoutes(routes ...Route) ResourceProxyOption {
+	return func(p *ResourceProxy) error {
+		return p.routes.Register(routes...)
+	}
+}
+
 // WithLogger sets the logger for the proxy to what is passed in. If this option is not provided
 // the New function will set it to the default logger
 func WithLogger(logger *logging.CentralizedLogger) ResourceProxyOption {
@@ -57,6 +77,27 @@ func WithLogger(logger *logging.CentralizedLogger) ResourceProxyOption {
 	}
 }
 
+// WithAccessLog writes an access log entry in the given format to out for
+// every request the proxy receives
+func WithAccessLog(out io.Writer, format AccessLogFormat) ResourceProxyOption {
+	return func(p *ResourceProxy) error {
+		if _, err := ParseAccessLogFormat(string(format)); err != nil {
+			return err
+		}
+		p.accessLog = &accessLog{out: out, format: format}
+		return nil
+	}
+}
+
+// WithMetrics makes the proxy record the latency, status and number of
+// in-flight requests of each route in m
+func WithMetrics(m *metrics.ResourceProxyMetrics) ResourceProxyOption {
+	return func(p *ResourceProxy) error {
+		p.metrics = m
+		return nil
+	}
+}
+
 // matcher creates and returns a new request matcher for the given pattern.
 // If the pattern contains submatches, mapping
 func matcher(pattern string, methods []string, fn HandlerFunc) (requestMatcher, error) {
@@ -0,0 +1,135 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourceproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Route is a request path pattern of the proxy, along with the handler for
+// the requests matching it.
+type Route struct {
+	// Name identifies the route, e.g. in log messages. Names must be unique
+	// within a registry.
+	Name string
+	// Pattern is a regexp matched against the request URI's path. Named
+	// submatches are passed to the handler as params.
+	Pattern string
+	// Methods are the HTTP methods allowed for the route. Matching requests
+	// with other methods are refused.
+	Methods []string
+	// Handler is executed for the requests matching the route
+	Handler HandlerFunc
+	// Docs document the route's operations in the proxy's OpenAPI
+	// definition. Routes without docs are listed with a generic operation.
+	Docs []OperationDoc
+	// MetricLabel, if set, returns the value of the route label of a
+	// request's metrics, e.g. to tell different kinds of requests of the
+	// route apart. It must only return a small, fixed set of values.
+	// Requests are labeled with the route's name otherwise.
+	MetricLabel func(r *http.Request, params Params) string
+}
+
+// Registry holds the routes of a proxy. Routes are matched in the order they
+// were registered, and the first match wins. It is safe for concurrent use,
+// so routes may be registered while the proxy is serving requests.
+type Registry struct {
+	mu     sync.RWMutex
+	routes []requestMatcher
+}
+
+// NewRegistry returns a new, empty route registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds routes to the registry. It fails if a pattern does not
+// compile, or if a route with the same name has been registered before, in
+// which case none of the routes are added.
+func (r *Registry) Register(routes ...Route) error {
+	matchers := make([]requestMatcher, 0, len(routes))
+	for _, route := range routes {
+		if route.Name == "" {
+			return fmt.Errorf("route for pattern %s has no name", route.Pattern)
+		}
+		rm, err := matcher(route.Pattern, route.Methods, route.Handler)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for route %s: %w", route.Name, err)
+		}
+		rm.name = route.Name
+		rm.docs = route.Docs
+		rm.metricLabel = route.MetricLabel
+		matchers = append(matchers, rm)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rm := range matchers {
+		for _, existing := range append(r.routes, matchers[:i]...) {
+			if existing.name == rm.name {
+				return fmt.Errorf("route %s is already registered", rm.name)
+			}
+		}
+	}
+	r.routes = append(r.routes, matchers...)
+	return nil
+}
+
+// Routes returns the registered routes in the order they are matched
+func (r *Registry) Routes() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	routes := make([]Route, 0, len(r.routes))
+	for _, rm := range r.routes {
+		routes = append(routes, Route{Name: rm.name, Pattern: rm.pattern, Methods: rm.methods, Handler: rm.fn, Docs: rm.docs, MetricLabel: rm.metricLabel})
+	}
+	return routes
+}
+
+// match returns the first route matching path, along with the submatches
+func (r *Registry) match(path string) (requestMatcher, []string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rm := range r.routes {
+		if matches := rm.matcher.FindStringSubmatch(path); matches != nil {
+			return rm, matches, true
+		}
+	}
+	return requestMatcher{}, nil, false
+}
+
+// plugins holds the routes registered with RegisterPlugin
+var plugins struct {
+	mu     sync.Mutex
+	routes []Route
+}
+
+// RegisterPlugin registers a route which is added to every proxy created
+// afterwards, after the routes passed as options. It is meant to be called
+// from the init function of a package compiled into a distribution of the
+// principal, e.g. guarded by a build tag, to add proxied endpoints.
+func RegisterPlugin(route Route) {
+	plugins.mu.Lock()
+	defer plugins.mu.Unlock()
+	plugins.routes = append(plugins.routes, route)
+}
+
+// pluginRoutes returns the routes registered with RegisterPlugin
+func pluginRoutes() []Route {
+	plugins.mu.Lock()
+	defer plugins.mu.Unlock()
+	return append([]Route(nil), plugins.routes...)
+}
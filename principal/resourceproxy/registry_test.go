@@ -0,0 +1,86 @@
+package resourceproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Registry(t *testing.T) {
+	handler := func(status int) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params Params) {
+			w.WriteHeader(status)
+		}
+	}
+
+	t.Run("Routes are matched in order", func(t *testing.T) {
+		r := NewRegistry()
+		require.NoError(t, r.Register(
+			Route{Name: "foo", Pattern: "^/foo$", Methods: []string{"get"}, Handler: handler(http.StatusOK)},
+			Route{Name: "any", Pattern: "^/(?P<name>[^/]+)$", Methods: []string{"get"}, Handler: handler(http.StatusAccepted)},
+		))
+		m, _, ok := r.match("/foo")
+		require.True(t, ok)
+		assert.Equal(t, "foo", m.name)
+		m, matches, ok := r.match("/bar")
+		require.True(t, ok)
+		assert.Equal(t, "any", m.name)
+		assert.Equal(t, []string{"/bar", "bar"}, matches)
+		_, _, ok = r.match("/foo/bar")
+		assert.False(t, ok)
+
+		routes := r.Routes()
+		require.Len(t, routes, 2)
+		assert.Equal(t, "foo", routes[0].Name)
+		assert.Equal(t, "^/(?P<name>[^/]+)$", routes[1].Pattern)
+	})
+
+	t.Run("Invalid routes are refused", func(t *testing.T) {
+		r := NewRegistry()
+		require.NoError(t, r.Register(Route{Name: "foo", Pattern: "^/foo$"}))
+		assert.ErrorContains(t, r.Register(Route{Name: "foo", Pattern: "^/bar$"}), "already registered")
+		assert.ErrorContains(t, r.Register(Route{Pattern: "^/bar$"}), "no name")
+		assert.ErrorContains(t, r.Register(Route{Name: "bar", Pattern: "^/(bar$"}), "invalid pattern")
+		// Either all routes are added or none
+		assert.Error(t, r.Register(
+			Route{Name: "baz", Pattern: "^/baz$"},
+			Route{Name: "baz", Pattern: "^/qux$"},
+		))
+		assert.Len(t, r.Routes(), 1)
+	})
+
+	t.Run("Routes can be added to a running proxy", func(t *testing.T) {
+		p, err := New("127.0.0.1:8080")
+		require.NoError(t, err)
+		require.NoError(t, p.Routes().Register(Route{Name: "foo", Pattern: "^/foo$", Methods: []string{"get"}, Handler: handler(http.StatusOK)}))
+		rec := httptest.NewRecorder()
+		p.proxyHandler(rec, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+		rec = httptest.NewRecorder()
+		p.proxyHandler(rec, httptest.NewRequest(http.MethodPost, "/foo", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Result().StatusCode)
+	})
+
+	t.Run("Plugin routes are added to new proxies", func(t *testing.T) {
+		defer func() { plugins.routes = nil }()
+		RegisterPlugin(Route{Name: "plugin", Pattern: "^/plugin$", Methods: []string{"get"}, Handler: handler(http.StatusTeapot)})
+		p, err := New("127.0.0.1:8080",
+			WithRoutes(Route{Name: "builtin", Pattern: "^/builtin$", Methods: []string{"get"}, Handler: handler(http.StatusOK)}))
+		require.NoError(t, err)
+		routes := p.Routes().Routes()
+		require.Len(t, routes, 2)
+		assert.Equal(t, "builtin", routes[0].Name)
+		assert.Equal(t, "plugin", routes[1].Name)
+		rec := httptest.NewRecorder()
+		p.proxyHandler(rec, httptest.NewRequest(http.MethodGet, "/plugin", nil))
+		assert.Equal(t, http.StatusTeapot, rec.Result().StatusCode)
+
+		// A plugin must not take over the name of a builtin route
+		_, err = New("127.0.0.1:8080",
+			WithRoutes(Route{Name: "plugin", Pattern: "^/builtin$"}))
+		assert.ErrorContains(t, err, "plugin routes")
+	})
+}
@@ -19,7 +19,9 @@ API.
 
 If a requests matches any of the configured patterns, named fields in the
 regexp will be mapped to a params structure and passed to a handler for
-further processing.
+further processing. The patterns and their handlers are kept as routes in a
+registry, to which feature modules add their routes. Distributions may add
+routes of their own with RegisterPlugin.
 */
 package resourceproxy
 
@@ -31,12 +33,15 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
+	"os"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -63,14 +68,26 @@ type ResourceProxy struct {
 	// server is the proxy's HTTP server
 	server *http.Server
 
-	// interceptors is a list of interceptors for intercepting requests
-	interceptors []requestMatcher
+	// routes holds the routes for intercepting requests
+	routes *Registry
 
 	// state holds state information about requests
 	statemap requestState
 
 	// logger is a separate logger from the default one to allow control to the log level of this subsystem
 	logger *logging.CentralizedLogger
+
+	// accessLog, if set, receives an entry for every request
+	accessLog *accessLog
+
+	// metrics, if set, record the latency and status of requests by route
+	metrics *metrics.ResourceProxyMetrics
+
+	// socketPath is the path of a unix domain socket the proxy listens on in
+	// addition to addr, if not empty. socketMode are the permissions of the
+	// socket file.
+	socketPath string
+	socketMode os.FileMode
 }
 
 // HandlerFunc is a parameterized HTTP handler function
@@ -78,10 +95,14 @@ type HandlerFunc func(w http.ResponseWriter, r *http.Request, params Params)
 
 // requestMatcher holds information for matching requests against
 type requestMatcher struct {
+	name    string
 	pattern string
 	matcher *regexp.Regexp
 	methods []string
 	fn      HandlerFunc
+	docs    []OperationDoc
+	// metricLabel returns the route label of a request's metrics, if set
+	metricLabel func(r *http.Request, params Params) string
 }
 
 // New returns a new instance of ResourceProxy for connecting to the given upstream
@@ -100,6 +121,7 @@ func New(addr string, options ...ResourceProxyOption) (*ResourceProxy, error) {
 		addr:        addr,
 		idleTimeout: defaultIdleTimeout,
 		readTimeout: defaultReadTimeout,
+		routes:      NewRegistry(),
 	}
 
 	// Options may return error, which aborts instantiation
@@ -109,6 +131,10 @@ func New(addr string, options ...ResourceProxyOption) (*ResourceProxy, error) {
 		}
 	}
 
+	if err := p.routes.Register(pluginRoutes()...); err != nil {
+		return nil, fmt.Errorf("could not register plugin routes: %w", err)
+	}
+
 	if p.logger == nil {
 		p.logger = logging.GetDefaultLogger()
 	}
@@ -170,14 +196,53 @@ func (rp *ResourceProxy) Start(ctx context.Context) (<-chan error, error) {
 		return nil, err
 	}
 
+	var ul net.Listener
+	if rp.socketPath != "" {
+		ul, err = rp.listenUnix()
+		if err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+
 	// Start the HTTP server in the background
 	go func() {
 		errCh <- rp.server.Serve(l)
 	}()
+	if ul != nil {
+		go func() {
+			errCh <- rp.server.Serve(ul)
+		}()
+	}
 
 	return errCh, nil
 }
 
+// listenUnix starts listening on the proxy's unix domain socket. Requests on
+// the socket are served without TLS, the permissions of the socket file
+// control who may connect. A socket file left behind by a previous run is
+// replaced.
+func (rp *ResourceProxy) listenUnix() (net.Listener, error) {
+	rp.log().Infof("Starting ResourceProxy on unix socket %s", rp.socketPath)
+	if fi, err := os.Lstat(rp.socketPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", rp.socketPath)
+		}
+		if err := os.Remove(rp.socketPath); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", rp.socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(rp.socketPath, rp.socketMode); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("could not set permissions of socket: %w", err)
+	}
+	return l, nil
+}
+
 // Stop can be used to gracefully shut down the proxy server.
 func (rp *ResourceProxy) Stop(ctx context.Context) error {
 	return rp.server.Shutdown(ctx)
@@ -188,44 +253,93 @@ func (rp *ResourceProxy) Stop(ctx context.Context) error {
 func (rp *ResourceProxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	rp.log().Debugf("Processing URI %s %s (goroutines:%d)", r.Method, r.RequestURI, runtime.NumGoroutine())
 
-	// Loop through all registered matchers and match them against the request
-	// URI's path. First match wins. This is obviously not the most efficient
-	// nor performant way to do it, but we need regexp matching with submatch
-	// extraction.
-	for _, m := range rp.interceptors {
-		matches := m.matcher.FindStringSubmatch(r.URL.Path)
-		if matches == nil {
-			rp.log().Debugf("Request did not match %s %s", r.Method, r.RequestURI)
-			continue
-		} else {
-			validMethod := false
-			for _, method := range m.methods {
-				if strings.EqualFold(r.Method, method) {
-					validMethod = true
-				}
+	// Match the request URI's path against all registered routes. First
+	// match wins. This is obviously not the most efficient nor performant way
+	// to do it, but we need regexp matching with submatch extraction.
+	m, matches, matched := rp.routes.match(r.URL.Path)
+
+	// uriParams will hold the named matches from the regexp
+	uriParams := NewParams()
+	if matched {
+		for i, name := range m.matcher.SubexpNames() {
+			if i != 0 && name != "" {
+				uriParams.Set(name, matches[i])
 			}
-			// We must have a callback function defined. Also, method must be
-			// allowed.
-			if !validMethod || m.fn == nil {
-				rp.log().Debugf("Method %s not allowed for URI %s", r.Method, r.RequestURI)
-				w.WriteHeader(http.StatusForbidden)
-				return
+		}
+	}
+
+	if rp.metrics != nil {
+		route := unmatchedRoute
+		if matched {
+			route = m.name
+			if m.metricLabel != nil {
+				route = m.metricLabel(r, uriParams)
+			}
+		}
+		start := time.Now()
+		inFlight := rp.metrics.RequestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		sw := &accessLogWriter{ResponseWriter: w}
+		w = sw
+		defer func() {
+			inFlight.Dec()
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
 			}
+			rp.metrics.RequestDuration.WithLabelValues(route, metricMethod(r.Method), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+		}()
+	}
 
-			// uriParams will hold the named matches from the regexp
-			uriParams := NewParams()
-			for i, name := range m.matcher.SubexpNames() {
-				if i != 0 && name != "" {
-					uriParams.Set(name, matches[i])
-				}
+	var entry *AccessLogEntry
+	if rp.accessLog != nil {
+		entry = &AccessLogEntry{
+			Time:       time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+		aw := &accessLogWriter{ResponseWriter: w}
+		w, r = aw, withAccessLog(r, entry)
+		defer func() {
+			entry.Status = aw.status
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
 			}
+			entry.Bytes = aw.bytes
+			entry.DurationMs = time.Since(entry.Time).Milliseconds()
+			if err := rp.accessLog.write(entry); err != nil {
+				rp.log().WithError(err).Warn("Could not write access log")
+			}
+		}()
+	}
 
-			// Call the handler with our params. The connection will stay open
-			// until the handler returns.
-			rp.log().Tracef("Executing callback for %v", uriParams)
-			m.fn(w, r, uriParams)
+	if matched {
+		if entry != nil {
+			entry.Route = m.name
+		}
+		validMethod := false
+		for _, method := range m.methods {
+			if strings.EqualFold(r.Method, method) {
+				validMethod = true
+			}
+		}
+		// We must have a callback function defined. Also, method must be
+		// allowed.
+		if !validMethod || m.fn == nil {
+			rp.log().Debugf("Method %s not allowed for URI %s", r.Method, r.RequestURI)
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+
+		// Call the handler with our params. The connection will stay open
+		// until the handler returns.
+		rp.log().WithField("route", m.name).Tracef("Executing callback for %v", uriParams)
+		m.fn(w, r, uriParams)
+		return
 	}
 
 	// Finally, if we had no handler match, we don't handle it
@@ -233,6 +347,27 @@ func (rp *ResourceProxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 }
 
+// unmatchedRoute is the route label of requests not matching any route
+const unmatchedRoute = "unmatched"
+
+// metricMethod returns the method label of a request's metrics. Methods
+// other than the standard ones are labeled as "OTHER", so that clients
+// cannot create arbitrary series.
+func metricMethod(method string) string {
+	switch m := strings.ToUpper(method); m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	default:
+		return "OTHER"
+	}
+}
+
 func (rp *ResourceProxy) log() *logrus.Entry {
 	return logging.SelectLogger(rp.logger).ModuleLogger("proxy")
 }
+
+// Routes returns the registry holding the routes of the proxy
+func (rp *ResourceProxy) Routes() *Registry {
+	return rp.routes
+}
@@ -2,11 +2,18 @@ package resourceproxy
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/argoproj-labs/argocd-agent/internal/logging"
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,8 +25,8 @@ func Test_NewProxy(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, p)
 
-		// Interceptors should be empty
-		assert.Len(t, p.interceptors, 0)
+		// Routes should be empty
+		assert.Empty(t, p.Routes().Routes())
 	})
 
 	t.Run("It requires a valid listener address", func(t *testing.T) {
@@ -68,6 +75,56 @@ func Test_proxyHandler(t *testing.T) {
 	})
 }
 
+func Test_proxyHandlerMetrics(t *testing.T) {
+	m := metrics.NewResourceProxyMetricsWith(prometheus.NewRegistry())
+	p, err := New("127.0.0.1:8080",
+		WithMetrics(m),
+		WithRoutes(Route{
+			Name:    "test",
+			Pattern: "^/test/(?P<kind>[^/]+)$",
+			Methods: []string{"get"},
+			Handler: func(w http.ResponseWriter, r *http.Request, params Params) {
+				assert.Equal(t, float64(1), testutil.ToFloat64(m.RequestsInFlight.WithLabelValues("test-"+params.Get("kind"))))
+				if params.Get("kind") == "fail" {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				_, _ = w.Write([]byte("ok"))
+			},
+			MetricLabel: func(r *http.Request, params Params) string {
+				return "test-" + params.Get("kind")
+			},
+		}),
+	)
+	require.NoError(t, err)
+
+	observed := func(t *testing.T, labels ...string) uint64 {
+		t.Helper()
+		h, err := m.RequestDuration.GetMetricWithLabelValues(labels...)
+		require.NoError(t, err)
+		dm := &dto.Metric{}
+		require.NoError(t, h.(prometheus.Histogram).Write(dm))
+		return dm.GetHistogram().GetSampleCount()
+	}
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/test/ok"},
+		{http.MethodGet, "/test/ok"},
+		{http.MethodGet, "/test/fail"},
+		{http.MethodPost, "/test/ok"},
+		{"BREW", "/nothing"},
+	} {
+		p.proxyHandler(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	assert.Equal(t, uint64(2), observed(t, "test-ok", "GET", "200"))
+	assert.Equal(t, uint64(1), observed(t, "test-fail", "GET", "502"))
+	assert.Equal(t, uint64(1), observed(t, "test-ok", "POST", "403"))
+	assert.Equal(t, uint64(1), observed(t, "unmatched", "OTHER", "400"))
+	assert.Equal(t, 4, testutil.CollectAndCount(m.RequestDuration))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.RequestsInFlight.WithLabelValues("test-ok")))
+}
+
 func Test_Start(t *testing.T) {
 	t.Run("Start IPv4", func(t *testing.T) {
 		r, err := New("127.0.0.1:0")
@@ -85,6 +142,55 @@ func Test_Start(t *testing.T) {
 		r.Stop(context.TODO())
 		assert.ErrorIs(t, <-errch, http.ErrServerClosed)
 	})
+	t.Run("Start with unix socket", func(t *testing.T) {
+		// Socket paths are limited in length, so don't use t.TempDir
+		dir, err := os.MkdirTemp("", "rp")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		sock := filepath.Join(dir, "proxy.sock")
+		// A socket left behind by a previous run is replaced
+		stale, err := net.Listen("unix", sock)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		r, err := New("127.0.0.1:0",
+			WithUnixSocket(sock, 0600),
+			WithRequestMatcher("^/version$", []string{"get"}, func(w http.ResponseWriter, r *http.Request, params Params) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		require.NoError(t, err)
+		errch, err := r.Start(context.TODO())
+		require.NoError(t, err)
+		fi, err := os.Stat(sock)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		}}
+		resp, err := client.Get("http://resource-proxy/version")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		r.Stop(context.TODO())
+		assert.ErrorIs(t, <-errch, http.ErrServerClosed)
+		assert.ErrorIs(t, <-errch, http.ErrServerClosed)
+		assert.NoFileExists(t, sock)
+	})
+	t.Run("Unix socket path must not be a regular file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+		r, err := New("127.0.0.1:0", WithUnixSocket(path, 0660))
+		require.NoError(t, err)
+		_, err = r.Start(context.TODO())
+		assert.ErrorContains(t, err, "not a socket")
+		assert.FileExists(t, path)
+	})
 }
 
 func init() {
@@ -0,0 +1,250 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/backend"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/internal/resync"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/validation"
+)
+
+// reportResyncProgress logs the progress of a paginated initial sync with an
+// agent and exposes it as a metric
+func (s *Server) reportResyncProgress(p resync.Progress) {
+	logCtx := log().WithFields(logrus.Fields{
+		"agent":   p.Peer,
+		"kind":    p.Kind,
+		"sent":    p.Sent,
+		"total":   p.Total,
+		"resumed": p.Resumed,
+	})
+	if p.Done {
+		logCtx = logCtx.WithField("duration", p.Duration)
+		logCtx.Info("Initial sync with agent completed")
+	} else {
+		logCtx.Info("Initial sync with agent in progress")
+	}
+	if s.metrics != nil {
+		perAgent := s.metrics.PerAgent(p.Peer)
+		if perAgent {
+			s.metrics.InitialSyncPending.WithLabelValues(p.Peer, p.Kind).Set(float64(p.Total - p.Sent))
+		}
+		if p.Done {
+			s.metrics.ResyncDuration.WithLabelValues(p.Kind).Observe(p.Duration.Seconds())
+			if perAgent {
+				s.metrics.ResyncObjects.WithLabelValues(p.Peer, p.Kind).Set(float64(p.Total))
+			}
+		}
+	}
+}
+
+// maxPeriodicResyncCheck is the maximum interval at which the principal
+// checks whether periodic resyncs with agents are due
+const maxPeriodicResyncCheck = 10 * time.Second
+
+// resyncDelay returns by how much the resyncs of the given agent are delayed.
+// The delay is derived from the agent's name, so that it is stable across
+// restarts of the principal and different for each agent, spreading the
+// resyncs of all agents over the configured jitter.
+func (s *Server) resyncDelay(agentName string) time.Duration {
+	if s.options.resyncJitter <= 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(agentName))
+	return time.Duration(uint64(h.Sum32()) * uint64(s.options.resyncJitter) >> 32)
+}
+
+// scheduleResyncOnConnect resyncs with an agent that connected, delayed by
+// the agent's share of the resync jitter if the principal has not resynced
+// with the agent before. This prevents a fleet of agents reconnecting after
+// a restart of the principal from resyncing all at once.
+func (s *Server) scheduleResyncOnConnect(agent types.Agent) error {
+	delay := s.resyncDelay(agent.Name())
+	if delay == 0 || s.resyncStatus.isResynced(agent.Name()) {
+		return s.handleResyncOnConnect(agent)
+	}
+	log().WithField("agent", agent.Name()).Debugf("Delaying resync with agent by %v", delay)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if !s.isAgentConnected(agent.Name()) {
+			return
+		}
+		if err := s.handleResyncOnConnect(agent); err != nil {
+			log().WithError(err).WithField("agent", agent.Name()).Error("Could not resync with agent")
+		}
+	}()
+	return nil
+}
+
+// runPeriodicResync resyncs with each connected agent at the configured
+// interval until ctx is done
+func (s *Server) runPeriodicResync(ctx context.Context) {
+	interval := min(s.options.resyncInterval, maxPeriodicResyncCheck)
+	log().Infof("Resyncing with agents every %v", s.options.resyncInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.IsActive() {
+				continue
+			}
+			for _, agentName := range s.resyncDue(time.Now()) {
+				if err := s.refreshAgent(agentName); err != nil {
+					log().WithError(err).WithField("agent", agentName).Warn("Could not resync with agent")
+				}
+			}
+		}
+	}
+}
+
+// resyncDue returns the names of the connected agents whose periodic resync
+// is due at the given time
+func (s *Server) resyncDue(now time.Time) []string {
+	var due []string
+	for agentName, last := range s.resyncStatus.resyncedAt() {
+		if !s.isAgentConnected(agentName) {
+			continue
+		}
+		if now.Before(last.Add(s.options.resyncInterval + s.resyncDelay(agentName))) {
+			continue
+		}
+		due = append(due, agentName)
+	}
+	return due
+}
+
+// resyncPattern is the pattern on the admin server under which the full
+// resynchronization of an agent is requested
+const resyncPattern = "POST /agents/{name}/resync"
+
+var errAgentNotConnected = errors.New("agent is not connected")
+
+// resyncResult describes a full resynchronization requested with an agent
+type resyncResult struct {
+	Agent string `json:"agent"`
+	Mode  string `json:"mode"`
+	// Applications is the number of Applications re-sent to the agent. It
+	// is always zero for autonomous agents, which own their Applications.
+	Applications int `json:"applications"`
+}
+
+// forceResync resynchronizes the state of an agent with the principal, as is
+// done when the agent connects for the first time. In addition, all the
+// Applications of a managed agent are re-sent, so that Applications the agent
+// lost are recreated.
+func (s *Server) forceResync(ctx context.Context, agentName string) (*resyncResult, error) {
+	if !s.isAgentConnected(agentName) {
+		return nil, errAgentNotConnected
+	}
+	if err := s.refreshAgent(agentName); err != nil {
+		return nil, err
+	}
+	mode := s.agentMode(agentName)
+	result := &resyncResult{Agent: agentName, Mode: mode.String()}
+	if mode != types.AgentModeManaged {
+		return result, nil
+	}
+	n, err := s.resendApplications(ctx, agentName)
+	if err != nil {
+		return nil, err
+	}
+	result.Applications = n
+	return result, nil
+}
+
+// resendApplications sends the current spec of all Applications of the
+// managed agent to the agent and returns their number.
+func (s *Server) resendApplications(ctx context.Context, agentName string) (int, error) {
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		return 0, fmt.Errorf("no send queue found for agent: %s", agentName)
+	}
+	apps, err := s.appManager.List(ctx, backend.ApplicationSelector{})
+	if err != nil {
+		return 0, fmt.Errorf("could not list applications: %w", err)
+	}
+	filters := s.defaultAppFilterChain()
+	sent := 0
+	for i := range apps {
+		app := &apps[i]
+		if !filters.Admit(app) || app.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := app.Annotations[manager.SourceUIDAnnotation]; ok {
+			continue
+		}
+		if s.getAgentNameForApp(app) != agentName {
+			continue
+		}
+		// Operations are only delivered with SetOperation events, as in
+		// updateAppCallback.
+		out := app.DeepCopy()
+		out.Operation = nil
+		ev := s.events.ApplicationEvent(event.SpecUpdate, out)
+		s.stampEvent(ctx, ev)
+		q.Add(ev)
+		sent++
+	}
+	return sent, nil
+}
+
+// processResync serves requests of operators to fully resynchronize an agent
+// with the principal. The result is returned as JSON.
+func (s *Server) processResync(w http.ResponseWriter, r *http.Request) {
+	agentName := r.PathValue("name")
+	if errs := validation.NameIsDNSLabel(agentName, false); len(errs) > 0 {
+		http.Error(w, "invalid agent name", http.StatusBadRequest)
+		return
+	}
+	auditCtx := auditLog().WithFields(logrus.Fields{
+		"agent":       agentName,
+		"operator":    adminOperator(r),
+		"remote_addr": r.RemoteAddr,
+	})
+	auditCtx.Info("Full resync of agent requested")
+
+	result, err := s.forceResync(r.Context(), agentName)
+	if errors.Is(err, errAgentNotConnected) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		auditCtx.WithError(err).Warn("Full resync of agent failed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditCtx.WithField("applications", result.Applications).Info("Full resync of agent started")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,184 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/argocd/cluster"
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/manager"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+func newResyncTestServer(t *testing.T) *Server {
+	t.Helper()
+	app := func(name, namespace string, annotations map[string]string) runtime.Object {
+		return &v1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: ktypes.UID(name), Annotations: annotations},
+			Operation:  &v1alpha1.Operation{Sync: &v1alpha1.SyncOperation{}},
+		}
+	}
+	s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps("argocd",
+		app("app-1", "agent-1", nil),
+		app("app-2", "agent-1", nil),
+		app("app-3", "agent-2", nil),
+		app("autonomous", "agent-1", map[string]string{manager.SourceUIDAnnotation: "1234"}),
+	), "argocd",
+		WithGeneratedTokenSigningKey(), WithRedisProxyDisabled(), WithNamespaces("agent-*"))
+	require.NoError(t, err)
+	s.events = event.NewEventSource("principal")
+	s.eventStreamSrv = eventstream.NewServer(s.queues, event.NewEventWritersMap(), nil, &cluster.Manager{})
+	for _, agentName := range []string{"agent-1", "agent-2"} {
+		require.NoError(t, s.queues.Create(agentName))
+		s.eventStreamSrv.MarkConnected(agentName)
+		t.Cleanup(func() { s.eventStreamSrv.MarkDisconnected(agentName) })
+	}
+	s.setAgentMode("agent-1", types.AgentModeManaged)
+	return s
+}
+
+func Test_forceResync(t *testing.T) {
+	drain := func(s *Server, agentName string) []*event.Event {
+		var evs []*event.Event
+		q := s.queues.SendQ(agentName)
+		for q.Len() > 0 {
+			ev, _ := q.Get()
+			q.Done(ev)
+			evs = append(evs, event.New(ev, event.Target(ev)))
+		}
+		return evs
+	}
+
+	t.Run("Applications of managed agents are re-sent", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		result, err := s.forceResync(context.TODO(), "agent-1")
+		require.NoError(t, err)
+		assert.Equal(t, &resyncResult{Agent: "agent-1", Mode: "managed", Applications: 2}, result)
+
+		apps := []string{}
+		resyncRequested := false
+		for _, ev := range drain(s, "agent-1") {
+			switch ev.Target() {
+			case event.TargetApplication:
+				assert.Equal(t, event.SpecUpdate, ev.Type())
+				app, err := ev.Application()
+				require.NoError(t, err)
+				assert.Nil(t, app.Operation)
+				apps = append(apps, app.Name)
+			case event.TargetResourceResync:
+				resyncRequested = resyncRequested || ev.Type() == event.EventRequestResourceResync
+			}
+		}
+		assert.ElementsMatch(t, []string{"app-1", "app-2"}, apps)
+		assert.True(t, resyncRequested)
+	})
+
+	t.Run("Mode of agent is unknown", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		_, err := s.forceResync(context.TODO(), "agent-2")
+		assert.ErrorContains(t, err, "mode of agent is unknown")
+		assert.Zero(t, s.queues.SendQ("agent-2").Len())
+	})
+
+	t.Run("Agent not connected", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		_, err := s.forceResync(context.TODO(), "agent-3")
+		assert.ErrorIs(t, err, errAgentNotConnected)
+	})
+}
+
+func Test_processResync(t *testing.T) {
+	s := newResyncTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc(resyncPattern, s.processResync)
+
+	t.Run("Resync is requested", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agents/agent-1/resync", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		result := &resyncResult{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+		assert.Equal(t, 2, result.Applications)
+	})
+
+	t.Run("Agent not connected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agents/agent-3/resync", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("Only POST is allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agents/agent-1/resync", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func Test_resyncDelay(t *testing.T) {
+	t.Run("No delay without jitter", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		assert.Zero(t, s.resyncDelay("agent-1"))
+	})
+
+	t.Run("Delay is stable and within jitter", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		require.NoError(t, WithResyncInterval(time.Hour, 10*time.Minute)(s))
+		delays := map[time.Duration]bool{}
+		for i := range 20 {
+			agentName := "agent-" + string(rune('a'+i))
+			delay := s.resyncDelay(agentName)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.Less(t, delay, 10*time.Minute)
+			assert.Equal(t, delay, s.resyncDelay(agentName))
+			delays[delay] = true
+		}
+		assert.Greater(t, len(delays), 1, "agents should be spread over the jitter")
+	})
+
+	t.Run("Negative values are rejected", func(t *testing.T) {
+		s := newResyncTestServer(t)
+		assert.Error(t, WithResyncInterval(-time.Second, 0)(s))
+		assert.Error(t, WithResyncInterval(time.Second, -time.Second)(s))
+	})
+}
+
+func Test_resyncDue(t *testing.T) {
+	s := newResyncTestServer(t)
+	require.NoError(t, WithResyncInterval(time.Hour, time.Minute)(s))
+	s.resyncStatus.resynced("agent-1")
+	s.resyncStatus.resynced("agent-2")
+	s.resyncStatus.resynced("agent-3")
+	now := time.Now()
+
+	assert.Empty(t, s.resyncDue(now))
+	assert.Empty(t, s.resyncDue(now.Add(time.Hour+s.resyncDelay("agent-1")-time.Second)))
+	assert.Contains(t, s.resyncDue(now.Add(time.Hour+s.resyncDelay("agent-1"))), "agent-1")
+	due := s.resyncDue(now.Add(2 * time.Hour))
+	assert.ElementsMatch(t, []string{"agent-1", "agent-2"}, due, "agent-3 is not connected")
+}
@@ -19,6 +19,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
 	"regexp"
@@ -38,7 +39,9 @@ import (
 	"github.com/argoproj-labs/argocd-agent/internal/cache"
 	"github.com/argoproj-labs/argocd-agent/internal/config"
 	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/internal/eventsink"
 	"github.com/argoproj-labs/argocd-agent/internal/filter"
+	"github.com/argoproj-labs/argocd-agent/internal/grpcutil"
 	"github.com/argoproj-labs/argocd-agent/internal/informer"
 	"github.com/argoproj-labs/argocd-agent/internal/issuer"
 	"github.com/argoproj-labs/argocd-agent/internal/kube"
@@ -59,6 +62,7 @@ import (
 	principalIdentity "github.com/argoproj-labs/argocd-agent/pkg/principal"
 	"github.com/argoproj-labs/argocd-agent/pkg/types"
 	"github.com/argoproj-labs/argocd-agent/principal/apis/eventstream"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
 	logstream "github.com/argoproj-labs/argocd-agent/principal/apis/logstreamapi"
 	"github.com/argoproj-labs/argocd-agent/principal/apis/terminalstream"
 	"github.com/argoproj-labs/argocd-agent/principal/redisproxy"
@@ -77,7 +81,6 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/dynamic"
 )
 
 type Server struct {
@@ -85,6 +88,8 @@ type Server struct {
 	tlsConfig *tls.Config
 	// listener contains GRPC server listener
 	listener *Listener
+	// quicServer serves grpcServer over HTTP/3 if QUIC is enabled
+	quicServer *grpcutil.QUICServer
 	// server is not currently used
 	server      *http.Server
 	grpcServer  *grpc.Server
@@ -147,12 +152,25 @@ type Server struct {
 	resourceProxyListenAddr string
 	// resourceProxyTLSConfig is the TLS configuration for the resource proxy
 	resourceProxyTLSConfig *tls.Config
+	// revocation checks agent client certificates for revocation, if
+	// configured
+	revocation *tlsutil.RevocationChecker
 
 	// clusterManager manages Argo CD cluster secrets and their mappings to agents
 	clusterMgr *cluster.Manager
 
 	// metrics holds principal side metrics
 	metrics *metrics.PrincipalMetrics
+	// agentVersions holds the versions reported by agents
+	agentVersions agentVersions
+	// agentUpdates holds the update requests waiting for the agent's
+	// acknowledgement
+	agentUpdates agentUpdates
+	// logCancels holds the log stream cancellations waiting for the agent's
+	// acknowledgement
+	logCancels logCancels
+	// logMux holds the log streams agents multiplex over their event stream
+	logMux logMux
 
 	// Minimum time duration for agent to wait before sending next keepalive ping to principal
 	// if agent sends ping more often than specified interval then connection will be dropped
@@ -179,6 +197,54 @@ type Server struct {
 
 	// terminalStreamServer handles bidirectional streaming for web terminal sessions
 	terminalStreamServer *terminalstream.Server
+	// fileTransferServer receives files, such as support bundles, from agents
+	fileTransferServer *filetransfer.Server
+
+	// connQuality holds the latest connection probe results, keyed by agent name
+	connQuality   map[string]ConnectionQuality
+	connQualityMu sync.RWMutex
+
+	// activity tracks streams and heartbeats per agent
+	activity *agentActivity
+	// drain tracks the draining of connections on shutdown
+	drain *drainState
+	// admission is the validating webhook for Applications
+	admission *admissionWebhook
+	// orphans tracks the deletion of Applications whose agent no longer
+	// exists
+	orphans *orphanState
+	// divergence tracks the Applications whose state diverged between the
+	// principal and their agent
+	divergence *divergenceState
+	// drift keeps the drift of the Applications of autonomous agents from
+	// the principal's copies
+	drift *driftState
+	// resyncPager sends the events of initial syncs with agents in batches
+	resyncPager *resync.Pager
+	// resyncBookmarks records the state of Applications synced with
+	// autonomous agents, for incremental resyncs. It is nil if those are
+	// disabled.
+	resyncBookmarks *resync.Bookmarks
+	// appDeletions tracks the progress of Applications being deleted on
+	// managed agents
+	appDeletions *deletionTracker
+	// handoff tracks the sessions handed over between principal instances
+	handoff *handoffState
+	// logResume tracks the requests of follow log streams, to resume them
+	// when the agent's stream is interrupted
+	logResume *logResumeState
+	// sharedLogs tracks the static log requests identical requests may join
+	sharedLogs *sharedLogRequests
+	// connections records the connection history of agents. It is nil if
+	// connection history is disabled.
+	connections *connectionHistory
+	// agentStatusChanged triggers an update of the AgentStatus resources. It
+	// is nil unless AgentStatus reporting is enabled.
+	agentStatusChanged chan struct{}
+
+	// lifecycle publishes lifecycle events to an external sink, if configured
+	lifecycle   *eventsink.Publisher
+	syncResults *syncResultTracker
 
 	// appToAgent maps application qualified names (namespace/name) to agent names.
 	// This is used for destination-based mapping to determine which agent
@@ -194,6 +260,15 @@ type Server struct {
 	// agentRegistrationManager handles automatic registration of agents
 	agentRegistrationManager *registration.AgentRegistrationManager
 
+	// registrationController provisions agents from AgentRegistration
+	// resources, if enabled
+	registrationController *registration.Controller
+
+	// agentConfigs pushes AgentConfiguration resources to agents, if enabled
+	agentConfigs *agentConfigPusher
+	// agentOperations runs AgentOperation resources on agents, if enabled
+	agentOperations *agentOperationRunner
+
 	// ha holds HA components for high availability support
 	ha *HAComponents
 
@@ -212,6 +287,7 @@ var noAuthEndpoints = map[string]bool{
 	"/versionapi.Version/Version":          true,
 	"/authapi.Authentication/Authenticate": true,
 	"/authapi.Authentication/RefreshToken": true,
+	"/authapi.Authentication/Join":         true,
 }
 
 const waitForSyncedDuration = 60 * time.Second
@@ -239,6 +315,18 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 		sourceCache:     cache.NewSourceCache(),
 		deletions:       manager.NewDeletionTracker(),
 		appToAgent:      newConcurrentStringMap(),
+		connQuality:     make(map[string]ConnectionQuality),
+		activity:        newAgentActivity(),
+		drain:           newDrainState(),
+		admission:       &admissionWebhook{startedAt: time.Now()},
+		orphans:         newOrphanState(),
+		divergence:      newDivergenceState(),
+		drift:           newDriftState(),
+		appDeletions:    newDeletionTracker(),
+		handoff:         newHandoffState(),
+		logResume:       newLogResumeState(),
+		sharedLogs:      newSharedLogRequests(),
+		syncResults:     newSyncResultTracker(),
 	}
 
 	s.ctx, s.ctxCancel = context.WithCancel(ctx)
@@ -267,8 +355,32 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 		return nil, err
 	}
 
+	s.resyncPager = resync.NewPager(s.ctx, s.options.resyncBatchSize, s.reportResyncProgress)
+	if s.options.resyncBookmarkMaxAge > 0 {
+		s.resyncBookmarks = resync.NewBookmarks(s.options.resyncBookmarkMaxAge)
+	}
+
 	s.handlersOnConnect = []handlersOnConnect{
-		s.handleResyncOnConnect,
+		s.scheduleResyncOnConnect,
+		s.resumeLogStreamsOnConnect,
+	}
+
+	if s.options.connectionHistoryRetention > 0 {
+		s.connections = newConnectionHistory(s.options.connectionHistoryRetention, time.Now())
+	}
+
+	if s.options.agentStatusInterval > 0 {
+		s.agentStatusChanged = make(chan struct{}, 1)
+		s.handlersOnConnect = append(s.handlersOnConnect, func(types.Agent) error {
+			s.triggerAgentStatusUpdate()
+			return nil
+		})
+	}
+
+	if s.options.eventSink != nil {
+		s.lifecycle = eventsink.NewPublisher(s.options.eventSink,
+			eventsink.WithMaxElapsedTime(s.options.eventSinkRetryTimeout))
+		s.handlersOnConnect = append(s.handlersOnConnect, s.publishAgentConnected)
 	}
 
 	s.destinationBasedMapping = s.options.destinationBasedMapping
@@ -276,6 +388,20 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 	if s.authMethods == nil {
 		s.authMethods = auth.NewMethods()
 	}
+	for name, provider := range s.options.authProviders {
+		if err := provider.Init(); err != nil {
+			return nil, fmt.Errorf("could not initialize auth provider %s: %w", name, err)
+		}
+		if err := s.authMethods.RegisterMethod(name, authProvider{provider}); err != nil {
+			return nil, err
+		}
+	}
+
+	// A client configured with WithKubeClient takes precedence
+	kubeClient = s.kubeClient
+	if kubeClient == nil {
+		return nil, fmt.Errorf("no Kubernetes client configured")
+	}
 
 	var err error
 
@@ -308,6 +434,9 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 		application.WithRole(manager.ManagerRolePrincipal),
 		application.WithDestinationBasedMapping(s.destinationBasedMapping),
 	}
+	if s.options.serverSideApply {
+		appManagerOpts = append(appManagerOpts, application.WithFieldManager(application.FieldManagerPrincipal))
+	}
 
 	projInformerOpts := []informer.InformerOption[*v1alpha1.AppProject]{
 		informer.WithListHandler[*v1alpha1.AppProject](func(ctx context.Context, opts v1.ListOptions) (runtime.Object, error) {
@@ -328,9 +457,22 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 		appproject.WithRole(manager.ManagerRolePrincipal),
 	}
 
-	if s.options.metricsPort > 0 {
-		s.metrics = metrics.NewPrincipalMetrics()
-		metrics.RegisterK8sClientMetrics()
+	if s.options.metricsPort > 0 || s.options.metricsRegistry != nil {
+		reg := s.options.metricsRegistry
+		if reg == nil {
+			reg = prometheus.DefaultRegisterer
+			metrics.RegisterK8sClientMetrics()
+		}
+		s.metrics = metrics.NewPrincipalMetricsWith(reg)
+		s.metrics.SetAgentLabels(metrics.NewAgentLabels(s.options.metricsAgentLabels, s.options.metricsAgentLabelsTop))
+		if err := reg.Register(queue.NewAggregatingCollector(s.queues, "principal", s.metrics.PerAgent, metrics.OtherAgents)); err != nil {
+			log().WithError(err).Warn("Could not register queue metrics")
+		}
+		if s.connections != nil {
+			if err := reg.Register(newUptimeCollector(s)); err != nil {
+				log().WithError(err).Warn("Could not register uptime metrics")
+			}
+		}
 
 		appInformerOpts = append(appInformerOpts, informer.WithMetrics[*v1alpha1.Application](prometheus.NewRegistry(), metrics.NewInformerMetrics("applications")))
 		projInformerOpts = append(projInformerOpts, informer.WithMetrics[*v1alpha1.AppProject](prometheus.NewRegistry(), metrics.NewInformerMetrics("appprojects")))
@@ -491,7 +633,7 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 			}
 
 			// Apply global TLS configuration to Redis proxy server
-			s.redisProxy.SetServerTLSConfig(s.options.tlsMinVersion, s.options.tlsMaxVersion, s.options.tlsCiphers)
+			s.redisProxy.SetServerTLSConfig(s.options.tlsMinVersion, s.options.tlsMaxVersion, s.options.tlsCiphers, s.options.tlsCurves...)
 
 			// Redis TLS (for connections to principal's argocd-redis)
 			if s.options.redisTLSInsecure {
@@ -507,28 +649,40 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 		}
 	}
 
+	if len(s.options.crlSources) > 0 || s.options.ocspEnabled {
+		s.revocation = tlsutil.NewRevocationChecker(s.options.crlSources, s.options.ocspEnabled, s.options.ocspFailClosed)
+		if err := s.revocation.Refresh(ctx); err != nil {
+			return nil, fmt.Errorf("could not load certificate revocation lists: %w", err)
+		}
+		if s.resourceProxyTLSConfig != nil {
+			s.resourceProxyTLSConfig = s.resourceProxyTLSConfig.Clone()
+			s.resourceProxyTLSConfig.VerifyPeerCertificate = s.revocation.VerifyPeerCertificate(s.onRevokedCert("resource-proxy"))
+		}
+	}
+
 	// Instantiate our ResourceProxy to intercept Kubernetes requests from Argo
 	// CD's API server.
 	if s.resourceProxyEnabled {
 		// TODO(jannfis): Enable fetching APIs and resource counts
-		s.resourceProxy, err = resourceproxy.New(s.resourceProxyListenAddr,
-			// For matching resource requests from the Argo CD API
-			resourceproxy.WithRequestMatcher(
-				resourceRequestRegexp,
-				[]string{"get", "patch", "post", "delete"},
-				s.processResourceRequest,
-			),
-			// Fake version output
-			resourceproxy.WithRequestMatcher(
-				`^/version$`,
-				[]string{"get"},
-				s.proxyVersion,
-			),
+		proxyOpts := []resourceproxy.ResourceProxyOption{
+			// Routes of the features served through the proxy. Routes of
+			// plugins compiled into the binary are added after these.
+			resourceproxy.WithRoutes(s.proxyRoutes()...),
 
 			resourceproxy.WithLogger(s.options.resourceProxyLogger),
 
 			resourceproxy.WithTLSConfig(s.resourceProxyTLSConfig),
-		)
+		}
+		if s.options.resourceProxyAccessLog != nil {
+			proxyOpts = append(proxyOpts, resourceproxy.WithAccessLog(s.options.resourceProxyAccessLog, s.options.resourceProxyAccessLogFormat))
+		}
+		if s.metrics != nil {
+			proxyOpts = append(proxyOpts, resourceproxy.WithMetrics(s.metrics.ResourceProxy))
+		}
+		if s.options.resourceProxySocketPath != "" {
+			proxyOpts = append(proxyOpts, resourceproxy.WithUnixSocket(s.options.resourceProxySocketPath, s.options.resourceProxySocketMode))
+		}
+		s.resourceProxy, err = resourceproxy.New(s.resourceProxyListenAddr, proxyOpts...)
 		if err != nil {
 			return nil, err
 		}
@@ -566,10 +720,22 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 	if err != nil {
 		return nil, err
 	}
+	if s.options.connectivityAnnotations {
+		s.clusterMgr.EnableConnectivityAnnotations(s.options.agentStaleAfter)
+	}
 
 	s.resources = resources.NewAgentResources()
-	s.logStream = logstream.NewServer()
+	s.logStream = logstream.NewServer(append([]logstream.Option{
+		logstream.WithMaxDownloadSize(int64(s.options.logDownloadMaxSize)),
+		logstream.WithMaxStaticSize(int64(s.options.logStaticMaxSize)),
+		logstream.WithStaticStreamThreshold(s.options.logStaticStreamThreshold),
+		logstream.WithSpillBuffer(s.options.logSpillDir, int64(s.options.logSpillMaxSize)),
+		logstream.WithSpillTotalSize(int64(s.options.logSpillMaxTotalSize)),
+		logstream.WithOnInterrupt(s.onLogStreamInterrupted),
+		logstream.WithOnReject(s.onLogStreamRejected),
+	}, s.options.logStreamOptions...)...)
 	s.terminalStreamServer = terminalstream.NewServer()
+	s.fileTransferServer = filetransfer.NewServer(filetransfer.WithMaxFileSize(int64(s.options.fileTransferMaxSize)))
 
 	// Initialize agent registration manager to handle self registration of agents
 	s.agentRegistrationManager = registration.NewAgentRegistrationManager(
@@ -581,6 +747,28 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 		s.issuer,
 	)
 
+	if s.options.registrationControllerEnabled {
+		s.registrationController, err = registration.NewController(ctx, namespace, kubeClient.Clientset, kubeClient.DynamicClient,
+			registration.WithResourceProxyAddress(s.options.resourceProxyAddress),
+			registration.WithPrincipalAddress(s.options.registrationPrincipalAddress),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not create agent registration controller: %w", err)
+		}
+	}
+
+	if s.options.agentConfigurationsEnabled {
+		if err := s.newAgentConfigPusher(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.options.agentOperationsEnabled {
+		if err := s.newAgentOperationRunner(ctx, s.options.agentOperationsBundleDir); err != nil {
+			return nil, err
+		}
+	}
+
 	// Initialize HA components if HA options are configured
 	if len(s.options.haOptions) > 0 {
 		s.ha, err = NewHAComponents(ctx, s, s.options.haOptions...)
@@ -597,6 +785,17 @@ func NewServer(ctx context.Context, kubeClient *kube.KubernetesClient, namespace
 	return s, nil
 }
 
+// versionRoute returns the resource proxy route for the fake version output
+func (s *Server) versionRoute() resourceproxy.Route {
+	return resourceproxy.Route{
+		Name:    "version",
+		Pattern: `^/version$`,
+		Methods: []string{"get"},
+		Handler: s.proxyVersion,
+		Docs:    versionRouteDocs,
+	}
+}
+
 func (s *Server) proxyVersion(w http.ResponseWriter, r *http.Request, params resourceproxy.Params) {
 	kubeVersion := struct {
 		Major        string
@@ -659,6 +858,10 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 	s.principalUID = uid
 	log().Infof("Principal identity: %s", uid)
 
+	if s.revocation != nil {
+		go s.revocation.Run(ctx, s.options.crlRefreshInterval, s.disconnectRevokedAgents)
+	}
+
 	// We need to maintain a cache to keep resources in sync with last known state of
 	// autonomous-agent in case it is disconnected with agent or resources on the control-plane are modified.
 	if err := s.populateSourceCache(ctx); err != nil {
@@ -675,7 +878,10 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 	}
 
 	if s.options.metricsPort > 0 {
-		metrics.StartMetricsServer(metrics.WithListener("", s.options.metricsPort))
+		metrics.StartMetricsServer(
+			metrics.WithListener("", s.options.metricsPort),
+			metrics.WithHandler(agentMetricsPattern, http.HandlerFunc(s.processAgentMetricsRequest)),
+		)
 
 		// A goroutine is started which calculates average connection time of all agents
 		// to export in metrics after every 3 minutes
@@ -688,6 +894,15 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 	}
 
 	go s.RunHandlersOnConnect(s.ctx)
+	if s.lifecycle != nil {
+		s.lifecycle.Start(s.ctx)
+	}
+	if s.options.connectionProbeInterval > 0 {
+		go s.runConnectionProbes(s.ctx)
+	}
+	if s.agentStatusChanged != nil {
+		go s.runAgentStatusReconciler(s.ctx)
+	}
 
 	if err = s.StartEventProcessor(s.ctx); err != nil {
 		return err
@@ -793,6 +1008,14 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 	}
 	log().Infof("GPG key informer synced and ready")
 
+	// Take over the sessions of the instance we replace, now that we are
+	// ready to serve them.
+	if s.options.handoffSocket != "" {
+		if err := s.startHandoff(ctx); err != nil {
+			return fmt.Errorf("unable to start session handoff: %w", err)
+		}
+	}
+
 	// Start resource proxy if it is enabled
 	if s.resourceProxy != nil {
 		_, err = s.resourceProxy.Start(s.ctx)
@@ -812,6 +1035,24 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 	}
 	log().Infof("Namespace informer synced and ready")
 
+	if s.registrationController != nil {
+		if err := s.registrationController.Start(s.ctx); err != nil {
+			return fmt.Errorf("unable to start agent registration controller: %w", err)
+		}
+	}
+
+	if s.agentConfigs != nil {
+		if err := s.startAgentConfigPusher(s.ctx); err != nil {
+			return fmt.Errorf("unable to sync AgentConfiguration informer: %w", err)
+		}
+	}
+
+	if s.agentOperations != nil {
+		if err := s.startAgentOperationRunner(s.ctx); err != nil {
+			return fmt.Errorf("unable to sync AgentOperation informer: %w", err)
+		}
+	}
+
 	if s.options.healthzPort > 0 {
 		// Endpoint to check if the principal is up and running
 		// Wrap with HA handler if HA is configured
@@ -820,6 +1061,10 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 			healthzHandler = s.ha.HAHealthzHandler(s.healthzHandler)
 		}
 		http.HandleFunc("/healthz", healthzHandler)
+		// Endpoint to check if the principal's dependencies are available
+		http.HandleFunc("/readyz", s.readyzHandler)
+		// OpenAPI definition of the principal's HTTP endpoints
+		http.HandleFunc(openAPIPath, s.openAPIHandler)
 		healthzAddr := fmt.Sprintf(":%d", s.options.healthzPort)
 
 		log().Infof("Starting healthz server on %s", healthzAddr)
@@ -827,6 +1072,28 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 		go http.ListenAndServe(healthzAddr, nil)
 	}
 
+	if err := s.startAdmissionWebhook(errch); err != nil {
+		return err
+	}
+
+	if s.options.adminPort > 0 {
+		if err := s.startAdminServer(s.ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.options.orphanCheckInterval > 0 {
+		go s.runOrphanDetection(s.ctx)
+	}
+
+	if s.options.deletionTimeout > 0 {
+		go s.runDeletionTimeouts(s.ctx)
+	}
+
+	if s.options.resyncInterval > 0 {
+		go s.runPeriodicResync(s.ctx)
+	}
+
 	// Finally, start accepting connections from agents
 	if s.options.serveGRPC {
 		if err := s.serveGRPC(ctx, s.metrics, errch); err != nil {
@@ -841,13 +1108,13 @@ func (s *Server) Start(ctx context.Context, errch chan error) error {
 // resyncStatus indicates whether we need to inform the agent that the principal has been restarted.
 type resyncStatus struct {
 	mu sync.RWMutex
-	// key: agent name
-	resync map[string]bool
+	// key: agent name, value: time of the last resync
+	resync map[string]time.Time
 }
 
 func newResyncStatus() *resyncStatus {
 	return &resyncStatus{
-		resync: map[string]bool{},
+		resync: map[string]time.Time{},
 	}
 }
 
@@ -863,7 +1130,24 @@ func (rs *resyncStatus) resynced(agentName string) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
-	rs.resync[agentName] = true
+	rs.resync[agentName] = time.Now()
+}
+
+// resyncedAt returns when the principal last resynced with each agent
+func (rs *resyncStatus) resyncedAt() map[string]time.Time {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	return maps.Clone(rs.resync)
+}
+
+// reset makes the principal resync with the agent the next time it runs the
+// resync handshake
+func (rs *resyncStatus) reset(agentName string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	delete(rs.resync, agentName)
 }
 
 // RunHandlersOnConnect runs the registered handlers when an agent connects to the principal
@@ -930,14 +1214,16 @@ func (s *Server) handleResyncOnConnect(agent types.Agent) error {
 	// In autonomous mode, principal acts as peer and it should resync with the agent.
 	if agent.Mode() == types.AgentModeAutonomous.String() {
 		// Principal should request updates from the Agent to revert any changes on the Principal side.
-		dynClient, err := dynamic.NewForConfig(s.kubeClient.RestConfig)
+		dynClient, err := s.kubeClient.Dynamic()
 		if err != nil {
 			return err
 		}
 
 		resyncHandler := resync.NewRequestHandler(dynClient, sendQ, s.events, s.resources.Get(agent.Name()), logCtx, manager.ManagerRolePrincipal, s.namespace).
 			WithDestinationBasedMapping(s.destinationBasedMapping).
-			WithPrincipalUID(s.principalUID)
+			WithPrincipalUID(s.principalUID).
+			WithPager(s.resyncPager, agent.Name()).
+			WithBookmarks(s.resyncBookmarks, agent.Name())
 		go resyncHandler.SendRequestUpdates(s.ctx)
 
 		// Principal should request SyncedResourceList to revert any deletions on the Principal side.
@@ -1093,6 +1379,10 @@ func hasSkipSyncLabel(labels map[string]string) bool {
 func (s *Server) Shutdown() error {
 	var err error
 
+	// Tell agents and clients that we are going away, and give requests in
+	// flight a chance to finish
+	s.drainConnections()
+
 	// Shutdown HA components first
 	if s.ha != nil {
 		if err = s.ha.ShutdownHA(s.ctx); err != nil {
@@ -1107,6 +1397,10 @@ func (s *Server) Shutdown() error {
 		}
 	}
 
+	if err = s.stopAdmissionWebhook(s.ctx); err != nil {
+		return err
+	}
+
 	if s.redisProxy != nil {
 		if err = s.redisProxy.Stop(); err != nil {
 			return err
@@ -1121,6 +1415,11 @@ func (s *Server) Shutdown() error {
 	// Cancel server-wide context
 	s.ctxCancel()
 
+	if s.quicServer != nil {
+		_ = s.quicServer.Close()
+		s.quicServer = nil
+	}
+
 	if s.server != nil {
 		if s.options.gracePeriod > 0 {
 			ctx, cancel := context.WithTimeout(context.Background(), s.options.gracePeriod)
@@ -1134,7 +1433,7 @@ func (s *Server) Shutdown() error {
 		s.server = nil
 	} else if s.grpcServer != nil {
 		log().Infof("Shutting down server")
-		s.grpcServer.Stop()
+		s.stopGRPCServer()
 		s.grpcServer = nil
 	} else {
 		return fmt.Errorf("no server running")
@@ -1142,6 +1441,27 @@ func (s *Server) Shutdown() error {
 	return err
 }
 
+// stopGRPCServer stops the gRPC server gracefully, so that agents receive a
+// GOAWAY and streams still open can finish. Streams which are still open
+// when the grace period is over are closed forcefully.
+func (s *Server) stopGRPCServer() {
+	if s.options.gracePeriod <= 0 {
+		s.grpcServer.Stop()
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(s.options.gracePeriod):
+		log().Warn("Grace period is over, closing remaining gRPC streams")
+		s.grpcServer.Stop()
+	}
+}
+
 // loadTLSConfig will configure and return a tls.Config object that can be
 // used by the server's listener. It will use options set in the server for
 // configuring the returned object. Returns nil if insecurePlaintext mode is
@@ -1166,10 +1486,11 @@ func (s *Server) loadTLSConfig() (*tls.Config, error) {
 	}
 
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   s.options.tlsMinVersion,
-		MaxVersion:   s.options.tlsMaxVersion,
-		CipherSuites: s.options.tlsCiphers,
+		Certificates:     []tls.Certificate{cert},
+		MinVersion:       s.options.tlsMinVersion,
+		MaxVersion:       s.options.tlsMaxVersion,
+		CipherSuites:     s.options.tlsCiphers,
+		CurvePreferences: s.options.tlsCurves,
 	}
 
 	// If the server is configured to require client certificates, set up the
@@ -1180,11 +1501,30 @@ func (s *Server) loadTLSConfig() (*tls.Config, error) {
 		log().Infof("This server will require TLS client certs as part of authentication")
 		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 		tlsConfig.ClientCAs = s.options.rootCa
+		if s.options.joinTokensEnabled {
+			// Agents without a certificate must be able to join. The
+			// certificate is enforced on authentication instead.
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if s.revocation != nil {
+		tlsConfig.VerifyPeerCertificate = s.revocation.VerifyPeerCertificate(s.onRevokedCert("grpc"))
 	}
 
 	return tlsConfig, nil
 }
 
+// onRevokedCert returns the function to call when a revoked client
+// certificate is rejected on the given listener
+func (s *Server) onRevokedCert(listener string) func(*tlsutil.RevokedError) {
+	return func(e *tlsutil.RevokedError) {
+		log().WithField("listener", listener).Warnf("Rejected client certificate: %v", e)
+		if s.metrics != nil {
+			s.metrics.RevokedCertRejections.WithLabelValues(listener, e.Method).Inc()
+		}
+	}
+}
+
 // defaultAppFilterChain returns the default filter chain for server s to use
 func (s *Server) defaultAppFilterChain() *filter.Chain[*v1alpha1.Application] {
 	c := filter.NewFilterChain[*v1alpha1.Application]()
@@ -1246,6 +1586,12 @@ func (s *Server) AuthMethodsForE2EOnly() *auth.Methods {
 	return s.authMethods
 }
 
+// AgentConnectedForE2EOnly returns whether agentName is connected to the
+// event stream of Server s
+func (s *Server) AgentConnectedForE2EOnly(agentName string) bool {
+	return s.isAgentConnected(agentName)
+}
+
 func (s *Server) QueuesForE2EOnly() *queue.SendRecvQueues {
 	return s.queues
 }
@@ -1350,6 +1696,16 @@ func (s *Server) GetHAStatus() *HAStatus {
 	return s.ha.GetHAStatus()
 }
 
+// agentSupportsBookmarks returns whether the agent understands bookmarks of
+// incremental resyncs
+func (s *Server) agentSupportsBookmarks(agentName string) bool {
+	if s.eventStreamSrv == nil {
+		return false
+	}
+	v, _ := s.eventStreamSrv.AgentSchemaVersion(agentName)
+	return v >= event.SchemaVersion12
+}
+
 func (s *Server) isAgentConnected(agentName string) bool {
 	if s.eventStreamSrv == nil {
 		return false
@@ -98,6 +98,30 @@ func Test_ServerWithTLSConfig(t *testing.T) {
 		assert.ErrorContains(t, err, "failed to find any PEM data")
 		assert.Nil(t, tlsConfig)
 	})
+
+	t.Run("Revocation checking", func(t *testing.T) {
+		templ := certTempl
+		fakecerts.WriteSelfSignedCert(t, "rsa", path.Join(tempDir, "revocation-cert"), templ)
+		s, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps(testNamespace), testNamespace,
+			WithTLSKeyPairFromPath(path.Join(tempDir, "revocation-cert.crt"), path.Join(tempDir, "revocation-cert.key")),
+			WithOCSP(true, false),
+			WithGeneratedTokenSigningKey(),
+			WithRedisProxyDisabled(),
+		)
+		require.NoError(t, err)
+		tlsConfig, err := s.loadTLSConfig()
+		require.NoError(t, err)
+		assert.NotNil(t, tlsConfig.VerifyPeerCertificate)
+	})
+
+	t.Run("Unloadable CRL", func(t *testing.T) {
+		_, err := NewServer(context.TODO(), kube.NewKubernetesFakeClientWithApps(testNamespace), testNamespace,
+			WithCRLs([]string{path.Join(tempDir, "missing.crl")}, time.Hour),
+			WithGeneratedTokenSigningKey(),
+			WithRedisProxyDisabled(),
+		)
+		assert.ErrorContains(t, err, "could not load certificate revocation lists")
+	})
 }
 
 func Test_NewServer(t *testing.T) {
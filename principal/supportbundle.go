@@ -0,0 +1,136 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/argoproj-labs/argocd-agent/internal/event"
+	"github.com/argoproj-labs/argocd-agent/principal/apis/filetransfer"
+	"github.com/argoproj-labs/argocd-agent/principal/resourceproxy"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/validation"
+)
+
+// supportBundleRequestRegexp matches requests for the support bundle of an
+// application on the agent identified by the client's credentials.
+const supportBundleRequestRegexp = `^/supportbundle/applications/(?P<name>[^\/]+)$`
+
+// supportBundleRoute returns the resource proxy route for support bundles of
+// applications on the agent.
+func (s *Server) supportBundleRoute() resourceproxy.Route {
+	return resourceproxy.Route{
+		Name:    "supportbundle",
+		Pattern: supportBundleRequestRegexp,
+		Methods: []string{"get"},
+		Handler: s.refuseWhileDraining(s.processSupportBundleRequest),
+		Docs:    supportBundleRouteDocs,
+	}
+}
+
+// processSupportBundleRequest asks the agent to collect the logs of all pods
+// of an application and streams the resulting tar.gz archive back to the
+// client. The following query parameters are supported:
+//
+//   - namespace: namespace of the application on the agent
+//   - previous: whether to include logs of previous containers (default true)
+//   - limitBytes: maximum number of log bytes per container
+func (s *Server) processSupportBundleRequest(w http.ResponseWriter, r *http.Request, params resourceproxy.Params) {
+	logCtx := log().WithField("function", "processSupportBundleRequest")
+
+	agentName, err := s.extractAgentFromAuth(r)
+	if err != nil {
+		logCtx.WithError(err).Errorf("Authentication failed for client %s", r.RemoteAddr)
+		http.Error(w, "authentication failed", http.StatusUnauthorized)
+		return
+	}
+	if errs := validation.NameIsDNSLabel(agentName, false); len(errs) > 0 {
+		logCtx.Errorf("CRITICAL: Invalid agent name in token: %v", errs)
+		http.Error(w, "invalid agent name", http.StatusBadRequest)
+		return
+	}
+	logCtx = logCtx.WithField("agent", agentName)
+
+	if !s.isAgentConnected(agentName) {
+		logCtx.Debug("Agent is not connected, cannot collect support bundle")
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if s.refuseInMaintenance(w, agentName, logCtx) {
+		return
+	}
+	q := s.queues.SendQ(agentName)
+	if q == nil {
+		logCtx.Errorf("Help! Queue disappeared")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	req := &event.SupportBundleRequest{
+		UUID:        uuid.NewString(),
+		Application: params.Get("name"),
+		Namespace:   query.Get("namespace"),
+		Previous:    !strings.EqualFold(query.Get("previous"), "false"),
+	}
+	if errs := validation.NameIsDNSSubdomain(req.Application, false); len(errs) > 0 {
+		http.Error(w, "invalid application name", http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limitBytes"); limit != "" {
+		req.LimitBytes, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil || req.LimitBytes <= 0 {
+			http.Error(w, "invalid limitBytes", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ev, err := s.events.NewSupportBundleRequestEvent(req)
+	if err != nil {
+		logCtx.WithError(err).Error("Could not create support bundle event")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	event.SetTTL(ev, requestTimeout*6)
+
+	logCtx = logCtx.WithFields(logrus.Fields{
+		"application": req.Application,
+		"uuid":        req.UUID,
+	})
+	logCtx.Info("Requesting support bundle from agent")
+
+	transfer := s.fileTransferServer.Register(req.UUID, filetransfer.NewHTTPSink(w))
+	defer s.fileTransferServer.Remove(req.UUID)
+	q.Add(ev)
+
+	// Collecting the logs may take a while, so we only time out if the agent
+	// stops sending the bundle, e.g. because it never started or it did not
+	// resume an interrupted transfer in time.
+	err = transfer.Wait(r.Context(), requestTimeout*6)
+	switch {
+	case err == nil:
+		logCtx.Info("Support bundle sent to client")
+	case errors.Is(err, filetransfer.ErrTimeout):
+		logCtx.Warn("Timeout waiting for support bundle")
+		transfer.Abort(http.StatusGatewayTimeout, "Timeout waiting for support bundle from agent")
+	default:
+		logCtx.WithError(err).Warn("Support bundle transfer did not complete")
+	}
+}
@@ -72,6 +72,10 @@ func (s *Server) processTerminalRequest(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	if s.refuseInMaintenance(w, agentName, logCtx) {
+		return
+	}
+
 	namespace := params.Get("namespace")
 	podName := params.Get("name")
 	containerName := r.URL.Query().Get("container")
@@ -128,6 +132,7 @@ func (s *Server) processTerminalRequest(w http.ResponseWriter, r *http.Request,
 	// Register the session
 	s.terminalStreamServer.RegisterSession(session)
 	defer s.terminalStreamServer.UnregisterSession(sessionUUID)
+	defer s.activity.beginStream(agentName, streamTerminal)()
 
 	logCtx.Info("Web terminal session registered")
 
@@ -161,6 +166,10 @@ func (s *Server) processTerminalRequest(w http.ResponseWriter, r *http.Request,
 		logCtx.Info("Web terminal session completed, cleaning up")
 	case <-ctx.Done():
 		logCtx.Warn("Web terminal session timeout after 30 minutes")
+	case <-s.drain.expired:
+		logCtx.Info("Principal is shutting down, closing web terminal session")
+		_ = wsConn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "principal is shutting down"), time.Now().Add(time.Second))
 	}
 
 	// Ensure channels are closed to terminate the agent web terminal stream
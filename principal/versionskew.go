@@ -0,0 +1,97 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"sync"
+
+	"github.com/argoproj-labs/argocd-agent/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// agentVersions keeps track of the versions agents reported when they
+// authenticated. The version is carried in the subject of the agent's
+// tokens, so it is known for every authenticated request.
+type agentVersions struct {
+	mu       sync.RWMutex
+	versions map[string]string
+}
+
+// recordAgentVersion records the version of agentName, as taken from its
+// token, and updates the version skew metric if it changed. Tokens issued
+// before agents reported their version carry none, in which case nothing is
+// recorded.
+func (s *Server) recordAgentVersion(agentName, agentVersion string) {
+	if agentVersion == "" {
+		return
+	}
+	s.agentVersions.mu.RLock()
+	known := s.agentVersions.versions[agentName]
+	s.agentVersions.mu.RUnlock()
+	if known == agentVersion {
+		return
+	}
+
+	s.agentVersions.mu.Lock()
+	if s.agentVersions.versions == nil {
+		s.agentVersions.versions = make(map[string]string)
+	}
+	s.agentVersions.versions[agentName] = agentVersion
+	s.agentVersions.mu.Unlock()
+
+	skew := s.options.versionSkewPolicy.Evaluate(agentVersion, s.version.Version())
+	if s.metrics != nil {
+		s.metrics.AgentVersionSkew.DeletePartialMatch(prometheus.Labels{"agent_name": agentName})
+		if skew.Comparable && s.metrics.PerAgent(agentName) {
+			s.metrics.AgentVersionSkew.WithLabelValues(agentName, agentVersion).Set(float64(skew.MinorBehind))
+		}
+	}
+	if !skew.WithinPolicy {
+		log().WithField("agent", agentName).Warnf("Agent is outside of the version skew policy: %s", skew.Describe(agentVersion, s.version.Version()))
+	}
+}
+
+// agentVersion returns the version agentName reported when it last
+// authenticated.
+func (s *Server) agentVersion(agentName string) (string, bool) {
+	s.agentVersions.mu.RLock()
+	defer s.agentVersions.mu.RUnlock()
+	v, ok := s.agentVersions.versions[agentName]
+	return v, ok
+}
+
+// forgetAgentVersionSkew removes the version skew metric of agentName, e.g.
+// after it disconnected.
+func (s *Server) forgetAgentVersionSkew(agentName string) {
+	if s.metrics != nil {
+		s.metrics.AgentVersionSkew.DeletePartialMatch(prometheus.Labels{"agent_name": agentName})
+	}
+}
+
+// versionSkewStatus returns the skew of agentVersion from the principal's
+// version for reporting in the AgentStatus resource.
+func (s *Server) versionSkewStatus(agentVersion string) *v1alpha1.VersionSkewStatus {
+	principalVersion := s.version.Version()
+	skew := s.options.versionSkewPolicy.Evaluate(agentVersion, principalVersion)
+	st := &v1alpha1.VersionSkewStatus{
+		PrincipalVersion: principalVersion,
+		Policy:           string(s.options.versionSkewPolicy.Mode),
+		WithinPolicy:     skew.WithinPolicy,
+	}
+	if skew.Comparable {
+		st.MinorVersionsBehind = skew.MinorBehind
+	}
+	return st
+}
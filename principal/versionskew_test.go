@@ -0,0 +1,70 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package principal
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/argocd-agent/internal/metrics"
+	"github.com/argoproj-labs/argocd-agent/internal/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RecordAgentVersion(t *testing.T) {
+	s := newResourceTestServer(t)
+	require.NoError(t, WithVersionSkewPolicy(string(version.SkewModeWarn), 1)(s))
+	s.metrics = metrics.NewPrincipalMetricsWith(prometheus.NewRegistry())
+	principalVersion := s.version.Version()
+
+	t.Run("Tokens without version are ignored", func(t *testing.T) {
+		s.recordAgentVersion("agent", "")
+		_, ok := s.agentVersion("agent")
+		assert.False(t, ok)
+	})
+
+	t.Run("Version and skew are recorded", func(t *testing.T) {
+		s.recordAgentVersion("agent", "99.8.0")
+		v, ok := s.agentVersion("agent")
+		require.True(t, ok)
+		assert.Equal(t, "99.8.0", v)
+		assert.Equal(t, float64(1), testutil.ToFloat64(s.metrics.AgentVersionSkew.WithLabelValues("agent", "99.8.0")))
+
+		st := s.versionSkewStatus(v)
+		assert.Equal(t, principalVersion, st.PrincipalVersion)
+		assert.Equal(t, 1, st.MinorVersionsBehind)
+		assert.Equal(t, "warn", st.Policy)
+		assert.True(t, st.WithinPolicy)
+	})
+
+	t.Run("Upgraded agent replaces the old version", func(t *testing.T) {
+		s.recordAgentVersion("agent", principalVersion)
+		assert.Equal(t, 1, testutil.CollectAndCount(s.metrics.AgentVersionSkew))
+		assert.Equal(t, float64(0), testutil.ToFloat64(s.metrics.AgentVersionSkew.WithLabelValues("agent", principalVersion)))
+	})
+
+	t.Run("Excess skew is outside of the policy", func(t *testing.T) {
+		st := s.versionSkewStatus("99.5.0")
+		assert.Equal(t, 4, st.MinorVersionsBehind)
+		assert.False(t, st.WithinPolicy)
+	})
+
+	t.Run("Metric is removed when the agent goes away", func(t *testing.T) {
+		s.forgetAgentVersionSkew("agent")
+		assert.Equal(t, 0, testutil.CollectAndCount(s.metrics.AgentVersionSkew))
+	})
+}
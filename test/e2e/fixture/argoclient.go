@@ -410,6 +410,36 @@ func (c *ArgoRestClient) ListResourceActions(app *v1alpha1.Application, group, v
 	return names, nil
 }
 
+// ApplicationLogsURL returns the URL of Argo CD's application logs endpoint for
+// the given container, logging in first if required. The URL must be requested
+// with the token returned by Token and the client returned by HTTPClient.
+func (c *ArgoRestClient) ApplicationLogsURL(app *v1alpha1.Application, namespace, podName, container string) (string, error) {
+	if c.token == "" {
+		if err := c.Login(); err != nil {
+			return "", err
+		}
+	}
+	u := c.url(
+		"appNamespace", app.Namespace,
+		"project", app.Spec.Project,
+		"namespace", namespace,
+		"podName", podName,
+		"container", container,
+	)
+	u.Path = fmt.Sprintf("/api/v1/applications/%s/logs", app.Name)
+	return u.String(), nil
+}
+
+// Token returns the client's current authentication token
+func (c *ArgoRestClient) Token() string {
+	return c.token
+}
+
+// HTTPClient returns the HTTP client used to talk to the Argo CD API
+func (c *ArgoRestClient) HTTPClient() *http.Client {
+	return c.client
+}
+
 // url constructs a URL for hitting an Argo CD API endpoint.
 func (c *ArgoRestClient) url(params ...string) *url.URL {
 	u := &url.URL{Scheme: "https", Host: c.endpoint}
@@ -0,0 +1,167 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/logsoak"
+	"github.com/argoproj-labs/argocd-agent/test/e2e/fixture"
+	v1alpha1 "github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/suite"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The soak test runs for a long time and is therefore only run when
+// E2E_SOAK_DURATION is set, e.g. to "2h".
+const (
+	soakDurationEnv          = "E2E_SOAK_DURATION"
+	soakStreamsEnv           = "E2E_SOAK_STREAMS"
+	soakReconnectIntervalEnv = "E2E_SOAK_RECONNECT_INTERVAL"
+)
+
+type LogsSoakTestSuite struct {
+	fixture.BaseSuite
+}
+
+func (suite *LogsSoakTestSuite) Test_logs_follow_soak_managed() {
+	requires := suite.Require()
+
+	duration, err := time.ParseDuration(os.Getenv(soakDurationEnv))
+	if err != nil {
+		suite.T().Skipf("%s not set, skipping soak test", soakDurationEnv)
+	}
+	streams := 10
+	if s := os.Getenv(soakStreamsEnv); s != "" {
+		streams, err = strconv.Atoi(s)
+		requires.NoError(err)
+	}
+	reconnectInterval := 5 * time.Minute
+	if s := os.Getenv(soakReconnectIntervalEnv); s != "" {
+		reconnectInterval, err = time.ParseDuration(s)
+		requires.NoError(err)
+	}
+
+	// Route the agent's connection through Toxiproxy, so that we can induce
+	// reconnects
+	proxy, cleanup, err := fixture.SetupToxiproxy(suite.T(), "agent-managed", "127.0.0.1:8475")
+	requires.NoError(err)
+	defer cleanup()
+	fixture.RestartAgent(suite.T(), "agent-managed")
+	fixture.CheckReadiness(suite.T(), "agent-managed")
+
+	appName := "guestbook-logs-soak"
+	app := &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appName,
+			Namespace: "agent-managed",
+		},
+		Spec: v1alpha1.ApplicationSpec{
+			Project: "default",
+			Source: &v1alpha1.ApplicationSource{
+				RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+				Path:           "kustomize-guestbook",
+				TargetRevision: "HEAD",
+			},
+			Destination: v1alpha1.ApplicationDestination{
+				Name:      "agent-managed",
+				Namespace: "guestbook",
+			},
+			SyncPolicy: &v1alpha1.SyncPolicy{
+				Automated: &v1alpha1.SyncPolicyAutomated{},
+				SyncOptions: v1alpha1.SyncOptions{
+					"CreateNamespace=true",
+				},
+			},
+		},
+	}
+	err = suite.PrincipalClient.Create(suite.Ctx, app, metav1.CreateOptions{})
+	requires.NoError(err)
+	suite.T().Cleanup(func() {
+		_ = suite.PrincipalClient.Delete(suite.Ctx, app, metav1.DeleteOptions{})
+	})
+
+	argoEndpoint, err := fixture.GetArgoCDServerEndpoint(suite.PrincipalClient)
+	requires.NoError(err)
+	password, err := fixture.GetInitialAdminSecret(suite.PrincipalClient)
+	requires.NoError(err)
+	argoClient := fixture.NewArgoClient(argoEndpoint, "admin", password)
+	requires.NoError(argoClient.Login())
+
+	// Wait until the app is synced and healthy
+	requires.Eventually(func() bool {
+		a := &v1alpha1.Application{}
+		if err := suite.PrincipalClient.Get(suite.Ctx, types.NamespacedName{Namespace: "agent-managed", Name: appName}, a, metav1.GetOptions{}); err != nil {
+			return false
+		}
+		return a.Status.Sync.Status == v1alpha1.SyncStatusCodeSynced &&
+			a.Status.Health.Status == health.HealthStatusHealthy
+	}, 120*time.Second, 1*time.Second)
+
+	var podName, containerName string
+	pods := &corev1.PodList{}
+	err = suite.ManagedAgentClient.List(suite.Ctx, "guestbook", pods, metav1.ListOptions{})
+	requires.NoError(err)
+	for _, p := range pods.Items {
+		if strings.Contains(p.Name, "kustomize-guestbook-ui") && len(p.Spec.Containers) > 0 {
+			podName = p.Name
+			containerName = p.Spec.Containers[0].Name
+			break
+		}
+	}
+	requires.NotEmpty(podName, "could not find guestbook pod")
+
+	logsURL, err := argoClient.ApplicationLogsURL(app, "guestbook", podName, containerName)
+	requires.NoError(err)
+
+	report, err := logsoak.Run(suite.Ctx, logsoak.Config{
+		LogsURL:           logsURL,
+		Token:             argoClient.Token(),
+		Streams:           streams,
+		Duration:          duration,
+		AgentHealthzURL:   "http://localhost:8001",
+		AgentMetricsURL:   "http://localhost:8181/metrics",
+		ReconnectInterval: reconnectInterval,
+		Reconnect: func(ctx context.Context) error {
+			if err := proxy.Disable(); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+			return proxy.Enable()
+		},
+		HTTPClient: argoClient.HTTPClient(),
+	})
+	requires.NoError(err)
+
+	var summary strings.Builder
+	report.Summary(&summary)
+	suite.T().Logf("Soak test results:\n%s", summary.String())
+	requires.NoError(report.Check(logsoak.DefaultLimits()))
+}
+
+func TestLogsSoakTestSuite(t *testing.T) {
+	suite.Run(t, new(LogsSoakTestSuite))
+}
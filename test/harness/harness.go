@@ -0,0 +1,380 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package harness runs a principal and an agent in the same process,
+// connected over gRPC on the loopback interface and backed by fake
+// Kubernetes clients on both sides. It allows testing features that span
+// both components, such as log streaming, resumption and cancellation,
+// without the VM-based end-to-end suite.
+package harness
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/agent"
+	"github.com/argoproj-labs/argocd-agent/internal/auth"
+	"github.com/argoproj-labs/argocd-agent/internal/auth/userpass"
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/internal/faultinject"
+	"github.com/argoproj-labs/argocd-agent/internal/kube"
+	"github.com/argoproj-labs/argocd-agent/pkg/client"
+	"github.com/argoproj-labs/argocd-agent/pkg/types"
+	"github.com/argoproj-labs/argocd-agent/principal"
+	fakekube "github.com/argoproj-labs/argocd-agent/test/fake/kube"
+	"github.com/argoproj-labs/argocd-agent/test/fake/testcerts"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	fakeappclient "github.com/argoproj/argo-cd/v3/pkg/client/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+const (
+	// Namespace is the namespace Argo CD runs in on both sides
+	Namespace = "argocd"
+	// DefaultAgentName is the name of the agent unless configured otherwise
+	DefaultAgentName = "agent-test"
+	// DefaultTimeout is the time to wait for the agent to connect
+	DefaultTimeout = 30 * time.Second
+
+	agentPassword = "harness"
+)
+
+// Harness is a principal and an agent connected to each other
+type Harness struct {
+	// Principal is the running principal
+	Principal *principal.Server
+	// PrincipalKube is the fake Kubernetes client of the principal
+	PrincipalKube *kube.KubernetesClient
+	// Agent is the running agent
+	Agent *agent.Agent
+	// AgentKube is the fake Kubernetes client of the agent
+	AgentKube *kube.KubernetesClient
+	// AgentName is the name the agent authenticates with
+	AgentName string
+	// Mode is the mode the agent runs in
+	Mode types.AgentMode
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type config struct {
+	agentName        string
+	mode             types.AgentMode
+	principalOpts    []principal.ServerOption
+	agentOpts        []agent.AgentOption
+	remoteOpts       []client.RemoteOption
+	principalObjects []runtime.Object
+	agentObjects     []runtime.Object
+	timeout          time.Duration
+}
+
+// Option configures a Harness
+type Option func(*config)
+
+// WithAgentName sets the name of the agent
+func WithAgentName(name string) Option {
+	return func(c *config) {
+		c.agentName = name
+	}
+}
+
+// WithMode sets the mode of the agent. The default is managed.
+func WithMode(mode types.AgentMode) Option {
+	return func(c *config) {
+		c.mode = mode
+	}
+}
+
+// WithPrincipalOptions adds options to the principal, after the ones set
+// by the harness
+func WithPrincipalOptions(opts ...principal.ServerOption) Option {
+	return func(c *config) {
+		c.principalOpts = append(c.principalOpts, opts...)
+	}
+}
+
+// WithAgentOptions adds options to the agent, after the ones set by the
+// harness
+func WithAgentOptions(opts ...agent.AgentOption) Option {
+	return func(c *config) {
+		c.agentOpts = append(c.agentOpts, opts...)
+	}
+}
+
+// WithRemoteOptions adds options to the agent's connection to the principal
+func WithRemoteOptions(opts ...client.RemoteOption) Option {
+	return func(c *config) {
+		c.remoteOpts = append(c.remoteOpts, opts...)
+	}
+}
+
+// WithFaultInjection injects faults into the agent's connection to the
+// principal. The injector can be used to check which faults were injected.
+func WithFaultInjection(injector *faultinject.Injector) Option {
+	return func(c *config) {
+		c.remoteOpts = append(c.remoteOpts,
+			client.WithUnaryInterceptors(injector.UnaryClientInterceptor()),
+			client.WithStreamInterceptors(injector.StreamClientInterceptor()))
+	}
+}
+
+// WithPrincipalObjects adds objects to the principal's fake Kubernetes
+// client
+func WithPrincipalObjects(objs ...runtime.Object) Option {
+	return func(c *config) {
+		c.principalObjects = append(c.principalObjects, objs...)
+	}
+}
+
+// WithAgentObjects adds objects to the agent's fake Kubernetes client
+func WithAgentObjects(objs ...runtime.Object) Option {
+	return func(c *config) {
+		c.agentObjects = append(c.agentObjects, objs...)
+	}
+}
+
+// WithTimeout sets the time to wait for the agent to connect
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// New starts a principal and an agent, and waits for the agent to connect.
+// Both are shut down when the test finishes.
+func New(t *testing.T, opts ...Option) *Harness {
+	t.Helper()
+	cfg := &config{
+		agentName: DefaultAgentName,
+		mode:      types.AgentModeManaged,
+		timeout:   DefaultTimeout,
+	}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Harness{
+		AgentName: cfg.agentName,
+		Mode:      cfg.mode,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	t.Cleanup(h.stop)
+
+	h.startPrincipal(t, cfg)
+	h.startAgent(t, cfg)
+	h.WaitForConnection(t, cfg.timeout)
+	return h
+}
+
+// Context returns a context that is cancelled when the harness stops
+func (h *Harness) Context() context.Context {
+	return h.ctx
+}
+
+func (h *Harness) startPrincipal(t *testing.T, cfg *config) {
+	t.Helper()
+	certPath := path.Join(t.TempDir(), "principal")
+	testcerts.WriteSelfSignedCert(t, "rsa", certPath, testcerts.DefaultCertTempl)
+
+	h.PrincipalKube = fakekube.NewKubernetesFakeClientWithApps(Namespace, cfg.principalObjects...)
+	h.PrincipalKube.DynamicClient = newFakeDynamicClient(h.PrincipalKube)
+	emulateResourceVersions(h.PrincipalKube)
+	popts := []principal.ServerOption{
+		principal.WithGRPC(true),
+		principal.WithListenerAddress("127.0.0.1"),
+		principal.WithListenerPort(0),
+		principal.WithTLSKeyPairFromPath(certPath+".crt", certPath+".key"),
+		principal.WithGeneratedTokenSigningKey(),
+		principal.WithRedisProxyDisabled(),
+		principal.WithNamespaces(cfg.agentName),
+		principal.WithInformerSyncTimeout(10 * time.Second),
+		principal.WithShutDownGracePeriod(time.Second),
+	}
+	popts = append(popts, cfg.principalOpts...)
+	s, err := principal.NewServer(h.ctx, h.PrincipalKube, Namespace, popts...)
+	require.NoError(t, err)
+
+	am := userpass.NewUserPassAuthentication("")
+	am.UpsertUser(cfg.agentName, agentPassword)
+	s.AuthMethodsForE2EOnly().RegisterMethod("userpass", am)
+
+	require.NoError(t, s.Start(h.ctx, make(chan error, 10)))
+	h.Principal = s
+}
+
+func (h *Harness) startAgent(t *testing.T, cfg *config) {
+	t.Helper()
+	ropts := []client.RemoteOption{
+		client.WithInsecureSkipTLSVerify(),
+		client.WithAuth("userpass", auth.Credentials{
+			userpass.ClientIDField:     cfg.agentName,
+			userpass.ClientSecretField: agentPassword,
+		}),
+		client.WithClientMode(cfg.mode),
+	}
+	ropts = append(ropts, cfg.remoteOpts...)
+	remote, err := client.NewRemote("127.0.0.1", h.Principal.ListenerForE2EOnly().Port(), ropts...)
+	require.NoError(t, err)
+
+	h.AgentKube = fakekube.NewKubernetesFakeClientWithApps(Namespace, cfg.agentObjects...)
+	h.AgentKube.DynamicClient = newFakeDynamicClient(h.AgentKube)
+	emulateResourceVersions(h.AgentKube)
+	aopts := []agent.AgentOption{
+		agent.WithRemote(remote),
+		agent.WithMode(cfg.mode.String()),
+		agent.WithCacheRefreshInterval(time.Minute),
+	}
+	aopts = append(aopts, cfg.agentOpts...)
+	a, err := agent.NewAgent(h.ctx, h.AgentKube, Namespace, aopts...)
+	require.NoError(t, err)
+	require.NoError(t, a.Start(h.ctx))
+	h.Agent = a
+}
+
+// newFakeDynamicClient returns a dynamic client reading the resources
+// principal and agent resync with each other from the typed fake clients of
+// kc, so that both views of the cluster agree.
+func newFakeDynamicClient(kc *kube.KubernetesClient) dynamic.Interface {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	dyn := dynfake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}: "ApplicationList",
+		{Group: "argoproj.io", Version: "v1alpha1", Resource: "appprojects"}:  "AppProjectList",
+		{Group: "", Version: "v1", Resource: "secrets"}:                       "SecretList",
+		{Group: "", Version: "v1", Resource: "configmaps"}:                    "ConfigMapList",
+	})
+	apps := kubetesting.ObjectReaction(kc.ApplicationsClientset.(*fakeappclient.Clientset).Tracker())
+	core := kubetesting.ObjectReaction(kc.Clientset.(*kubefake.Clientset).Tracker())
+	for _, verb := range []string{"get", "list"} {
+		dyn.PrependReactor(verb, "*", func(action kubetesting.Action) (bool, runtime.Object, error) {
+			if action.GetResource().Group == "argoproj.io" {
+				return apps(action)
+			}
+			return core(action)
+		})
+	}
+	return dyn
+}
+
+// emulateResourceVersions makes the fake Argo CD client of kc assign resource
+// versions like the API server does: every write, including the deletion
+// reported to watches, gets a new resource version. Principal and agent
+// identify events by resource version.
+func emulateResourceVersions(kc *kube.KubernetesClient) {
+	c := kc.ApplicationsClientset.(*fakeappclient.Clientset)
+	var version atomic.Int64
+	next := func() string {
+		return strconv.FormatInt(version.Add(1), 10)
+	}
+	for _, verb := range []string{"create", "update"} {
+		c.PrependReactor(verb, "*", func(action kubetesting.Action) (bool, runtime.Object, error) {
+			if obj, err := meta.Accessor(action.(interface{ GetObject() runtime.Object }).GetObject()); err == nil {
+				obj.SetResourceVersion(next())
+			}
+			return false, nil, nil
+		})
+	}
+	c.PrependWatchReactor("*", func(action kubetesting.Action) (bool, watch.Interface, error) {
+		var opts metav1.ListOptions
+		if wa, ok := action.(kubetesting.WatchActionImpl); ok {
+			opts = wa.ListOptions
+		}
+		w, err := c.Tracker().Watch(action.GetResource(), action.GetNamespace(), opts)
+		if err != nil {
+			return false, nil, err
+		}
+		return true, watch.Filter(w, func(ev watch.Event) (watch.Event, bool) {
+			if ev.Type == watch.Deleted {
+				ev.Object = ev.Object.DeepCopyObject()
+				if obj, err := meta.Accessor(ev.Object); err == nil {
+					obj.SetResourceVersion(next())
+				}
+			}
+			return ev, true
+		}), nil
+	})
+}
+
+// WaitForConnection waits until the agent is connected to the principal
+func (h *Harness) WaitForConnection(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return h.Agent.IsConnected() && h.Principal.AgentConnectedForE2EOnly(h.AgentName)
+	}, timeout, 50*time.Millisecond, "agent %s did not connect to the principal", h.AgentName)
+}
+
+// ReplayToAgent feeds the events the principal sent in records into the
+// agent, as if they had been received on the event stream. Records made on
+// either side can be replayed.
+func (h *Harness) ReplayToAgent(t *testing.T, records []eventrecord.Record) {
+	t.Helper()
+	err := eventrecord.Replay(h.ctx, records, func(rec eventrecord.Record) error {
+		if !sentByPrincipal(rec) {
+			return nil
+		}
+		return h.Agent.ReplayEvent(rec.Event)
+	}, eventrecord.WithoutAcks())
+	require.NoError(t, err)
+}
+
+// ReplayToPrincipal feeds the events the agent sent in records into the
+// principal, as if they had been received from the harness' agent. Records
+// made on either side can be replayed.
+func (h *Harness) ReplayToPrincipal(t *testing.T, records []eventrecord.Record) {
+	t.Helper()
+	err := eventrecord.Replay(h.ctx, records, func(rec eventrecord.Record) error {
+		if sentByPrincipal(rec) {
+			return nil
+		}
+		return h.Principal.ReplayEvent(h.AgentName, h.Mode, rec.Event)
+	}, eventrecord.WithoutAcks())
+	require.NoError(t, err)
+}
+
+// sentByPrincipal returns whether the event in rec was sent by the principal.
+// Only the principal records the agent's name.
+func sentByPrincipal(rec eventrecord.Record) bool {
+	if rec.Agent != "" {
+		return rec.Direction == eventrecord.DirectionSend
+	}
+	return rec.Direction == eventrecord.DirectionRecv
+}
+
+func (h *Harness) stop() {
+	if h.Agent != nil {
+		_ = h.Agent.Stop()
+	}
+	if h.Principal != nil {
+		_ = h.Principal.Shutdown()
+	}
+	h.cancel()
+}
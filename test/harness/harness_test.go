@@ -0,0 +1,139 @@
+// Copyright 2025 The argocd-agent Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harness
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/argocd-agent/internal/eventrecord"
+	"github.com/argoproj-labs/argocd-agent/internal/faultinject"
+	"github.com/argoproj-labs/argocd-agent/principal"
+	"github.com/argoproj/argo-cd/v3/pkg/apis/application/v1alpha1"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+)
+
+func newApp(h *Harness, name string) *v1alpha1.Application {
+	return &v1alpha1.Application{
+		// The fake clients do not assign UIDs, which the agent uses to tell
+		// applications apart
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: h.AgentName, UID: ktypes.UID(uuid.NewString())},
+		Spec: v1alpha1.ApplicationSpec{
+			Project: "default",
+			Source:  &v1alpha1.ApplicationSource{RepoURL: "https://github.com/argoproj/argocd-example-apps", Path: "guestbook"},
+		},
+	}
+}
+
+// agentAppPath returns the source path of the application on the agent, or
+// an empty string if it does not exist there
+func agentAppPath(h *Harness, name string) string {
+	app, err := h.AgentKube.ApplicationsClientset.ArgoprojV1alpha1().Applications(Namespace).Get(h.Context(), name, metav1.GetOptions{})
+	if err != nil || app.Spec.Source == nil {
+		return ""
+	}
+	return app.Spec.Source.Path
+}
+
+func Test_Harness(t *testing.T) {
+	h := New(t)
+	apps := h.PrincipalKube.ApplicationsClientset.ArgoprojV1alpha1().Applications(h.AgentName)
+
+	t.Run("Agent is connected", func(t *testing.T) {
+		assert.True(t, h.Agent.IsConnected())
+		assert.True(t, h.Principal.AgentConnectedForE2EOnly(h.AgentName))
+	})
+
+	t.Run("Applications created on the principal reach the agent", func(t *testing.T) {
+		_, err := apps.Create(h.Context(), newApp(h, "guestbook"), metav1.CreateOptions{})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return agentAppPath(h, "guestbook") == "guestbook"
+		}, 10*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Updates on the principal reach the agent", func(t *testing.T) {
+		app, err := apps.Get(h.Context(), "guestbook", metav1.GetOptions{})
+		require.NoError(t, err)
+		app.Spec.Source.Path = "helm-guestbook"
+		_, err = apps.Update(h.Context(), app, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return agentAppPath(h, "guestbook") == "helm-guestbook"
+		}, 10*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Deletions on the principal reach the agent", func(t *testing.T) {
+		require.NoError(t, apps.Delete(h.Context(), "guestbook", metav1.DeleteOptions{}))
+		require.Eventually(t, func() bool {
+			_, err := h.AgentKube.ApplicationsClientset.ArgoprojV1alpha1().Applications(Namespace).Get(h.Context(), "guestbook", metav1.GetOptions{})
+			return apierrors.IsNotFound(err)
+		}, 10*time.Second, 50*time.Millisecond)
+	})
+}
+
+func Test_HarnessFaultInjection(t *testing.T) {
+	injector, err := faultinject.NewInjector(faultinject.Config{
+		Methods:   []string{"/eventstreamapi."},
+		DelayRate: 1,
+		Delay:     10 * time.Millisecond,
+		Seed:      42,
+	})
+	require.NoError(t, err)
+	h := New(t, WithFaultInjection(injector))
+
+	_, err = h.PrincipalKube.ApplicationsClientset.ArgoprojV1alpha1().Applications(h.AgentName).Create(h.Context(), newApp(h, "guestbook"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return agentAppPath(h, "guestbook") == "guestbook"
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Positive(t, injector.Count(faultinject.FaultDelay))
+	assert.Zero(t, injector.Count(faultinject.FaultDrop))
+}
+
+func Test_HarnessRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	recorder, err := eventrecord.NewFileRecorder(path)
+	require.NoError(t, err)
+	h := New(t, WithPrincipalOptions(principal.WithEventRecorder(recorder)))
+
+	_, err = h.PrincipalKube.ApplicationsClientset.ArgoprojV1alpha1().Applications(h.AgentName).Create(h.Context(), newApp(h, "guestbook"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return agentAppPath(h, "guestbook") == "guestbook"
+	}, 10*time.Second, 50*time.Millisecond)
+	require.NoError(t, recorder.Close())
+
+	records, err := eventrecord.ReadFile(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	for _, rec := range records {
+		assert.Equal(t, h.AgentName, rec.Agent)
+	}
+
+	// Replaying the recording into a fresh agent recreates the application
+	replayed := New(t)
+	assert.Empty(t, agentAppPath(replayed, "guestbook"))
+	replayed.ReplayToAgent(t, records)
+	require.Eventually(t, func() bool {
+		return agentAppPath(replayed, "guestbook") == "guestbook"
+	}, 10*time.Second, 50*time.Millisecond)
+}